@@ -17,16 +17,14 @@ limitations under the License.
 package main
 
 import (
-	"context"
 	"flag"
 	"os"
 
-	"knative.dev/pkg/configmap"
-	kncontroller "knative.dev/pkg/controller"
 	"knative.dev/pkg/injection"
 	"knative.dev/pkg/injection/sharedmain"
 	"knative.dev/pkg/signals"
 
+	"knative.dev/eventing-natss/pkg/loglevel"
 	"knative.dev/eventing-natss/pkg/reconciler/controller"
 )
 
@@ -39,8 +37,7 @@ func main() {
 	if ns != "" {
 		ctx = injection.WithNamespaceScope(ctx, ns)
 	}
+	ctx = loglevel.WithComponent(ctx, component)
 
-	sharedmain.MainWithContext(ctx, component, func(ctx context.Context, watcher configmap.Watcher) *kncontroller.Impl {
-		return controller.NewController(ctx)
-	})
+	sharedmain.MainWithContext(ctx, component, controller.NewController)
 }
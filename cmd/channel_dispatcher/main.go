@@ -19,8 +19,10 @@ package main
 import (
 	"os"
 
+	"knative.dev/eventing-natss/pkg/loglevel"
 	controller "knative.dev/eventing-natss/pkg/reconciler/dispatcher"
 
+	pkgcontroller "knative.dev/pkg/controller"
 	"knative.dev/pkg/injection"
 	"knative.dev/pkg/injection/sharedmain"
 
@@ -29,12 +31,28 @@ import (
 
 const component = "natsschannel-dispatcher"
 
+// threadsPerController is the number of workers of the dispatcher. The channels of a namespace
+// being deleted are finalized in batches by one of them, the others removing the finalizers of
+// the channels already finalized.
+const threadsPerController = 8
+
 func main() {
 	ctx := signals.NewContext()
 	ns := os.Getenv("NAMESPACE")
 	if ns != "" {
+		// The dispatchers of the namespaces, created by the controller for the channels annotated
+		// with eventing.knative.dev/scope: namespace, run a single replica which cannot hold leases
+		// in the system namespace.
 		ctx = injection.WithNamespaceScope(ctx, ns)
+		ctx = sharedmain.WithHADisabled(ctx)
 	}
+	ctx = loglevel.WithComponent(ctx, component)
+	ctx, waitStopped := controller.WithShutdownWait(ctx)
+	pkgcontroller.DefaultThreadsPerController = threadsPerController
 
+	// sharedmain watches the config-observability ConfigMap named by CONFIG_OBSERVABILITY_NAME,
+	// switching the exporter of the metrics without restart.
 	sharedmain.MainWithContext(ctx, component, controller.NewController)
+	// On SIGTERM, the dispatcher drains its subscriptions before the process exits.
+	waitStopped()
 }
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-natss is the kubectl plugin inspecting and operating the NatssChannels, run as
+// kubectl natss once on the PATH.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	eventingclientset "knative.dev/eventing/pkg/client/clientset/versioned"
+	"knative.dev/pkg/signals"
+
+	"knative.dev/eventing-natss/pkg/cli"
+	clientset "knative.dev/eventing-natss/pkg/client/clientset/versioned"
+)
+
+func main() {
+	// The kubeconfig is loaded the way kubectl does, from $KUBECONFIG or ~/.kube/config.
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	namespace, _, err := loader.Namespace()
+	if err != nil {
+		fail(err)
+	}
+	config, err := loader.ClientConfig()
+	if err != nil {
+		fail(err)
+	}
+
+	cmd := &cli.Command{
+		Client:         clientset.NewForConfigOrDie(config),
+		EventingClient: eventingclientset.NewForConfigOrDie(config),
+		HTTPClient:     &http.Client{Timeout: 30 * time.Second},
+		Namespace:      namespace,
+		Out:            os.Stdout,
+		Err:            os.Stderr,
+	}
+	if err := cmd.Run(signals.NewContext(), os.Args[1:]); err != nil {
+		if errors.Is(err, cli.ErrUsage) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"knative.dev/pkg/injection/sharedmain"
+	"knative.dev/pkg/signals"
+	pkgwebhook "knative.dev/pkg/webhook"
+	"knative.dev/pkg/webhook/certificates"
+
+	"knative.dev/eventing-natss/pkg/loglevel"
+	"knative.dev/eventing-natss/pkg/webhook"
+)
+
+const component = "natss-webhook"
+
+func main() {
+	ctx := pkgwebhook.WithOptions(signals.NewContext(), pkgwebhook.Options{
+		ServiceName: "natss-webhook",
+		Port:        8443,
+		SecretName:  "natss-webhook-certs",
+	})
+	ctx = loglevel.WithComponent(ctx, component)
+
+	sharedmain.MainWithContext(ctx, component,
+		certificates.NewController,
+		webhook.NewDefaultingAdmissionController,
+		webhook.NewValidationAdmissionController,
+	)
+}
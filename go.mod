@@ -5,14 +5,21 @@ go 1.14
 require (
 	github.com/cloudevents/sdk-go/protocol/stan/v2 v2.2.0
 	github.com/cloudevents/sdk-go/v2 v2.2.0
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/google/go-cmp v0.5.2
 	github.com/google/uuid v1.1.2
 	github.com/hashicorp/go-uuid v1.0.2 // indirect
 	github.com/influxdata/tdigest v0.0.1 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/nats-io/jwt v0.3.2
+	github.com/nats-io/nats-server/v2 v2.7.4
+	github.com/nats-io/nats.go v1.14.0
+	github.com/nats-io/nkeys v0.3.0
 	github.com/nats-io/stan.go v0.6.0
 	github.com/pkg/errors v0.9.1
+	github.com/rickb777/date v1.13.0
 	github.com/stretchr/testify v1.6.0 // indirect
+	go.opencensus.io v0.22.5
 	go.uber.org/zap v1.16.0
 	k8s.io/api v0.18.8
 	k8s.io/apimachinery v0.18.8
@@ -20,6 +27,7 @@ require (
 	knative.dev/eventing v0.19.0
 	knative.dev/hack v0.0.0-20201103151104-3d5abc3a0075
 	knative.dev/pkg v0.0.0-20201103163404-5514ab0c1fdf
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
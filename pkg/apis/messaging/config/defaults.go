@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config holds the cluster-wide defaults applied to the NatssChannels by the defaulting
+// webhook.
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultsConfigMapName is the name of the ConfigMap holding the defaults of the NatssChannels,
+	// in the system namespace.
+	DefaultsConfigMapName = "config-natss-defaults"
+
+	// DefaultDeliveryKey is the key of the ConfigMap holding, as YAML, the spec.delivery of the
+	// NatssChannels created without one.
+	DefaultDeliveryKey = "default-delivery"
+)
+
+// Defaults holds the defaults of the NatssChannels.
+type Defaults struct {
+	// Delivery is the spec.delivery of the channels which set none, nil leaving it unset.
+	Delivery *eventingduckv1.DeliverySpec
+}
+
+// NewDefaultsFromConfigMap creates Defaults from the supplied ConfigMap. A nil ConfigMap, or one
+// without the keys, yields no defaults.
+func NewDefaultsFromConfigMap(cm *corev1.ConfigMap) (*Defaults, error) {
+	d := &Defaults{}
+	if cm == nil {
+		return d, nil
+	}
+	if value := strings.TrimSpace(cm.Data[DefaultDeliveryKey]); value != "" {
+		delivery, err := parseDelivery(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q: %w", DefaultDeliveryKey, err)
+		}
+		d.Delivery = delivery
+	}
+	return d, nil
+}
+
+func parseDelivery(value string) (*eventingduckv1.DeliverySpec, error) {
+	j, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+	delivery := &eventingduckv1.DeliverySpec{}
+	dec := json.NewDecoder(strings.NewReader(string(j)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(delivery); err != nil {
+		return nil, err
+	}
+	if err := delivery.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/ptr"
+)
+
+func TestNewDefaultsFromConfigMap(t *testing.T) {
+	linear := eventingduckv1.BackoffPolicyLinear
+
+	testCases := map[string]struct {
+		data    map[string]string
+		want    *Defaults
+		wantErr bool
+	}{
+		"no ConfigMap": {
+			want: &Defaults{},
+		},
+		"empty": {
+			data: map[string]string{DefaultDeliveryKey: "  "},
+			want: &Defaults{},
+		},
+		"delivery": {
+			data: map[string]string{DefaultDeliveryKey: `
+retry: 3
+backoffPolicy: linear
+backoffDelay: PT1S
+deadLetterSink:
+  uri: http://dls.ns.svc.cluster.local
+`},
+			want: &Defaults{Delivery: &eventingduckv1.DeliverySpec{
+				Retry:          ptr.Int32(3),
+				BackoffPolicy:  &linear,
+				BackoffDelay:   ptr.String("PT1S"),
+				DeadLetterSink: &duckv1.Destination{URI: apis.HTTP("dls.ns.svc.cluster.local")},
+			}},
+		},
+		"not YAML": {
+			data:    map[string]string{DefaultDeliveryKey: "retry: [3"},
+			wantErr: true,
+		},
+		"unknown field": {
+			data:    map[string]string{DefaultDeliveryKey: "retries: 3"},
+			wantErr: true,
+		},
+		"invalid delivery": {
+			data:    map[string]string{DefaultDeliveryKey: "backoffPolicy: random"},
+			wantErr: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var cm *corev1.ConfigMap
+			if tc.data != nil {
+				cm = &corev1.ConfigMap{Data: tc.data}
+			}
+			got, err := NewDefaultsFromConfigMap(cm)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewDefaultsFromConfigMap() = %v, wantErr %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected defaults (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/system"
+)
+
+type defaultsKey struct{}
+
+// FromContext returns the Defaults attached to ctx, nil when it has none.
+func FromContext(ctx context.Context) *Defaults {
+	d, _ := ctx.Value(defaultsKey{}).(*Defaults)
+	return d
+}
+
+// FromContextOrDefaults is like FromContext, returning empty Defaults when ctx has none.
+func FromContextOrDefaults(ctx context.Context) *Defaults {
+	if d := FromContext(ctx); d != nil {
+		return d
+	}
+	return &Defaults{}
+}
+
+// ToContext attaches d to ctx.
+func ToContext(ctx context.Context, d *Defaults) context.Context {
+	return context.WithValue(ctx, defaultsKey{}, d)
+}
+
+// Store holds the Defaults of the DefaultsConfigMapName ConfigMap, which it follows once
+// watching.
+type Store struct {
+	logger   *zap.SugaredLogger
+	defaults atomic.Value
+}
+
+// NewStore creates a Store holding no defaults until it watches the ConfigMap.
+func NewStore(logger *zap.SugaredLogger) *Store {
+	s := &Store{logger: logger}
+	s.defaults.Store(&Defaults{})
+	return s
+}
+
+// WatchConfigs makes s follow the ConfigMap, which holds no defaults while it does not exist when
+// cmw supports it. Invalid changes are ignored.
+func (s *Store) WatchConfigs(cmw configmap.Watcher) {
+	observer := func(cm *corev1.ConfigMap) {
+		d, err := NewDefaultsFromConfigMap(cm)
+		if err != nil {
+			s.logger.Errorw("Ignoring the invalid NatssChannel defaults", zap.Error(err))
+			return
+		}
+		s.defaults.Store(d)
+	}
+	if iw, ok := cmw.(*configmap.InformedWatcher); ok {
+		iw.WatchWithDefault(corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultsConfigMapName, Namespace: system.Namespace()},
+		}, observer)
+		return
+	}
+	cmw.Watch(DefaultsConfigMapName, observer)
+}
+
+// Load returns the current Defaults, which must not be modified.
+func (s *Store) Load() *Defaults {
+	return s.defaults.Load().(*Defaults)
+}
+
+// ToContext attaches the current Defaults to ctx.
+func (s *Store) ToContext(ctx context.Context) context.Context {
+	return ToContext(ctx, s.Load())
+}
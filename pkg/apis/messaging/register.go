@@ -18,4 +18,97 @@ package messaging
 
 const (
 	GroupName = "messaging.knative.dev"
+
+	// ReplayFromAnnotationKey is the annotation of a Subscription to a NatssChannel requesting the
+	// replay of the events published since an RFC3339 time.
+	ReplayFromAnnotationKey = "natss.messaging.knative.dev/replay-from"
+
+	// ReplayedFromAnnotationKey is the annotation recording the value of ReplayFromAnnotationKey
+	// of the last replay handled by the dispatcher.
+	ReplayedFromAnnotationKey = "natss.messaging.knative.dev/replayed-from"
+
+	// PausedUnhealthyAnnotationKey is the annotation set by the dispatcher on a Subscription to a
+	// NatssChannel whose subscription it paused because the subscriber kept failing, holding the
+	// RFC3339 time of the pause. Removing it resumes the subscription.
+	PausedUnhealthyAnnotationKey = "natss.messaging.knative.dev/paused-unhealthy"
+
+	// DurableAnnotationKey is the annotation of a Subscription to a NatssChannel which, set to
+	// "false", makes the dispatcher subscribe without a durable.
+	DurableAnnotationKey = "natss.messaging.knative.dev/durable"
+
+	// ConsumersAnnotationKey is the annotation of a Subscription to a NatssChannel setting how many
+	// consumers of a queue group process its events in parallel, one by default.
+	ConsumersAnnotationKey = "natss.messaging.knative.dev/consumers"
+
+	// OrderedAnnotationKey is the annotation of a Subscription to a NatssChannel which, set to
+	// "ordered", delivers the events to its subscriber in order, one at a time, a failed delivery
+	// being retried until it succeeds.
+	OrderedAnnotationKey = "natss.messaging.knative.dev/delivery-order"
+
+	// ColdStartRetriesAnnotationKey is the annotation of a Subscription to a NatssChannel which,
+	// set to "false", retries the deliveries refused while its Knative Service subscriber starts
+	// from zero as genuine failures, and set to "true", retries them with the cold start backoff
+	// whatever its subscriber.
+	ColdStartRetriesAnnotationKey = "natss.messaging.knative.dev/cold-start-retries"
+
+	// DeliveryTimeoutAnnotationKey is the annotation of a Subscription to a NatssChannel setting
+	// the ISO 8601 duration after which each request of the deliveries to its subscriber times out,
+	// standing for the delivery.timeout the DeliverySpec of this version of Knative Eventing lacks.
+	DeliveryTimeoutAnnotationKey = "natss.messaging.knative.dev/delivery-timeout"
+
+	// AckWaitAnnotationKey, MaxInflightAnnotationKey and StartAtAnnotationKey are the annotations
+	// of a NatssChannel overriding the ack wait, the max inflight and the start position of its
+	// subscriptions.
+	AckWaitAnnotationKey     = "natss.messaging.knative.dev/ack-wait"
+	MaxInflightAnnotationKey = "natss.messaging.knative.dev/max-inflight"
+	StartAtAnnotationKey     = "natss.messaging.knative.dev/start-at"
+
+	// DurableNamingAnnotationKey is the annotation of a NatssChannel migrating the durables of its
+	// subscriptions to a naming scheme, "v1" or "v2": the events stored until then are delivered
+	// from the old durables, removed once drained, and the next ones from the new durables.
+	DurableNamingAnnotationKey = "natss.messaging.knative.dev/durable-naming-scheme"
+
+	// DeliveryOrderAnnotationKey is the annotation of a NatssChannel setting whether the events
+	// are delivered to its subscribers in order, the NATSS equivalent of the delivery.order
+	// annotation of the Kafka channels, so that the tooling stamping it works with both.
+	DeliveryOrderAnnotationKey = "natss.eventing.knative.dev/delivery.order"
+
+	// MultiplexTargetAnnotationKey is the annotation of a NatssChannel which, set to "true",
+	// allows the events received on the multiplex endpoint of the dispatcher to be published to it.
+	MultiplexTargetAnnotationKey = "natss.messaging.knative.dev/multiplex-target"
+
+	// ShedOnPressureLabelKey is the label of a NatssChannel which, set to "true", makes the
+	// receiver refuse its events while the store of NATSS is critically full, keeping the room
+	// left to the channels without it.
+	ShedOnPressureLabelKey = "natss.knative.dev/shed-on-pressure"
+
+	// LogLevelAnnotationKey and TraceSamplingAnnotationKey are the annotations of a NatssChannel
+	// forcing the level of the logs and the sampling probability of the traces of its events,
+	// whatever config-logging and config-tracing set, to debug the channel alone.
+	LogLevelAnnotationKey      = "natss.knative.dev/log-level"
+	TraceSamplingAnnotationKey = "natss.knative.dev/trace-sampling"
+
+	// AddressSchemeAnnotationKey and AddressPortAnnotationKey are the annotations of a NatssChannel
+	// overriding the scheme and the port of the address advertised in its status.
+	AddressSchemeAnnotationKey = "natss.messaging.knative.dev/address-scheme"
+	AddressPortAnnotationKey   = "natss.messaging.knative.dev/address-port"
+
+	// FanoutSoftLimitAnnotationKey and FanoutHardLimitAnnotationKey are the annotations of a
+	// NatssChannel overriding the fan-out limits of config-natss, zero lifting the limit.
+	FanoutSoftLimitAnnotationKey = "natss.messaging.knative.dev/fanout-soft-limit"
+	FanoutHardLimitAnnotationKey = "natss.messaging.knative.dev/fanout-hard-limit"
+
+	// QuotaMaxChannelsAnnotationKey and QuotaMaxSubscriptionsAnnotationKey are the annotations of a
+	// Namespace overriding the quotas of config-natss, zero lifting the quota.
+	QuotaMaxChannelsAnnotationKey      = "natss.messaging.knative.dev/quota-max-channels"
+	QuotaMaxSubscriptionsAnnotationKey = "natss.messaging.knative.dev/quota-max-subscriptions"
+
+	// AllowZeroReplicasAnnotationKey is the annotation of the dispatcher Deployment of a namespace
+	// which, set to "true", lets it be scaled to zero, the controller otherwise restoring its
+	// replica.
+	AllowZeroReplicasAnnotationKey = "natss.knative.dev/allow-zero-replicas"
+
+	// EgressMinShareAnnotationKey is the annotation of a NatssChannel setting the fraction of the
+	// egress limits of the dispatcher, between 0 and 1, the channel is guaranteed.
+	EgressMinShareAnnotationKey = "natss.messaging.knative.dev/egress-min-share"
 )
@@ -0,0 +1,44 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"knative.dev/pkg/apis"
+)
+
+// NatssChannelAudit configures the mirroring of the events of a channel to an audit sink.
+type NatssChannelAudit struct {
+	// Sink is the URI receiving a copy of every event sent to the channel, carrying the
+	// knauditchannel extension set to the namespace and name of the channel.
+	Sink *apis.URL `json:"sink"`
+}
+
+// Validate checks the sink is an absolute URI.
+func (a *NatssChannelAudit) Validate(context.Context) *apis.FieldError {
+	if a == nil {
+		return nil
+	}
+	if a.Sink.IsEmpty() {
+		return apis.ErrMissingField("sink")
+	}
+	if !a.Sink.URL().IsAbs() {
+		return apis.ErrInvalidValue(a.Sink.String(), "sink")
+	}
+	return nil
+}
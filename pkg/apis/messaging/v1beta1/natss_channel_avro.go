@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+)
+
+// NatssChannelAvroTranscode configures the transcoding to JSON of the Avro events of a channel,
+// before their delivery to the subscribers.
+type NatssChannelAvroTranscode struct {
+	// RegistryURL is the URL of the Confluent compatible schema registry the schemas of the
+	// events are fetched from.
+	RegistryURL *apis.URL `json:"registryURL"`
+
+	// SecretRef references the Secret, in the namespace of the channel, holding the credentials
+	// of the registry: either a username and a password, or a token.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+func (a *NatssChannelAvroTranscode) Validate(context.Context) *apis.FieldError {
+	if a == nil {
+		return nil
+	}
+	var errs *apis.FieldError
+	if a.RegistryURL.IsEmpty() {
+		errs = errs.Also(apis.ErrMissingField("registryURL"))
+	} else if !a.RegistryURL.URL().IsAbs() {
+		errs = errs.Also(apis.ErrInvalidValue(a.RegistryURL.String(), "registryURL"))
+	}
+	if a.SecretRef != nil && a.SecretRef.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("secretRef.name"))
+	}
+	return errs
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"knative.dev/pkg/apis"
+)
+
+// clusterIDPattern matches the IDs NATS Streaming accepts for a cluster.
+var clusterIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// NatssChannelCluster is a NATS Streaming cluster storing the events of channels.
+type NatssChannelCluster struct {
+	// NatsURL is the comma separated URLs of the NATS servers of the cluster.
+	NatsURL string `json:"natsURL,omitempty"`
+
+	// ClusterID is the ID of the NATS Streaming cluster.
+	ClusterID string `json:"clusterID,omitempty"`
+}
+
+// Cluster returns the cluster the spec stores the events of the channel in, whose empty fields
+// default to the cluster of the dispatcher.
+func (cs *NatssChannelSpec) Cluster() NatssChannelCluster {
+	return NatssChannelCluster{NatsURL: cs.NatsURL, ClusterID: cs.ClusterID}
+}
+
+// Validate checks the URLs are NATS ones and the cluster ID is valid.
+func (c NatssChannelCluster) Validate(context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+	if c.NatsURL != "" {
+		for _, server := range strings.Split(c.NatsURL, ",") {
+			u, err := url.Parse(strings.TrimSpace(server))
+			if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+				fe := apis.ErrInvalidValue(c.NatsURL, "natsURL")
+				fe.Details = "expected comma separated nats:// or tls:// URLs"
+				errs = errs.Also(fe)
+				break
+			}
+		}
+	}
+	if c.ClusterID != "" && !clusterIDPattern.MatchString(c.ClusterID) {
+		fe := apis.ErrInvalidValue(c.ClusterID, "clusterID")
+		fe.Details = "expected only alphanumeric characters, '-' and '_'"
+		errs = errs.Also(fe)
+	}
+	return errs
+}
+
+// checkClusterImmutable refuses to move a channel to another cluster, whose subscriptions would
+// not find the events stored in the original one.
+func (cs *NatssChannelSpec) checkClusterImmutable(original *NatssChannelSpec) *apis.FieldError {
+	var errs *apis.FieldError
+	if cs.NatsURL != original.NatsURL {
+		fe := apis.ErrGeneric("immutable field changed", "natsURL")
+		fe.Details = "the events stored in the original cluster would be orphaned"
+		errs = errs.Also(fe)
+	}
+	if cs.ClusterID != original.ClusterID {
+		fe := apis.ErrGeneric("immutable field changed", "clusterID")
+		fe.Details = "the events stored in the original cluster would be orphaned"
+		errs = errs.Also(fe)
+	}
+	return errs
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 
 	"knative.dev/eventing/pkg/apis/messaging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/config"
 )
 
 func (c *NatssChannel) SetDefaults(ctx context.Context) {
@@ -38,6 +40,11 @@ func (c *NatssChannel) SetDefaults(ctx context.Context) {
 	c.Spec.SetDefaults(ctx)
 }
 
+// SetDefaults sets the delivery of the cluster-wide defaults of ctx when the channel sets none.
 func (cs *NatssChannelSpec) SetDefaults(ctx context.Context) {
-	// Noop
+	if cs.Delivery == nil {
+		if delivery := config.FromContextOrDefaults(ctx).Delivery; delivery != nil {
+			cs.Delivery = delivery.DeepCopy()
+		}
+	}
 }
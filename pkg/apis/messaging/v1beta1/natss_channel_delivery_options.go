@@ -0,0 +1,188 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rickb777/date/period"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+)
+
+// StartPosition is where the subscriptions of a channel start when they have no durable to resume
+// from.
+type StartPosition string
+
+const (
+	// StartPositionNewOnly delivers the events published from when the subscription is made. It
+	// is the default.
+	StartPositionNewOnly StartPosition = "new-only"
+	// StartPositionAllAvailable delivers all the events NATSS still stores for the channel.
+	StartPositionAllAvailable StartPosition = "all-available"
+)
+
+// DeliveryOrder is whether the events of a channel are delivered to each subscriber in order.
+type DeliveryOrder string
+
+const (
+	// DeliveryOrdered delivers the events to each subscriber one at a time, in the order they
+	// were published, a failed event holding back the next ones until it is delivered.
+	DeliveryOrdered DeliveryOrder = "ordered"
+	// DeliveryUnordered lets NATSS send several events to a subscriber before they are
+	// acknowledged, the events redelivered coming after the next ones. It is the default.
+	DeliveryUnordered DeliveryOrder = "unordered"
+)
+
+const (
+	// MinAckWait and MaxAckWait bound how long NATSS waits for the ack of an event before
+	// redelivering it, NATSS counting in whole seconds.
+	MinAckWait = 1 * time.Second
+	MaxAckWait = 1 * time.Hour
+	// MaxInflightLimit bounds how many events of a subscription NATSS sends before they are
+	// acknowledged.
+	MaxInflightLimit = 10000
+)
+
+// OrDefault returns the start position, StartPositionNewOnly when it is not set.
+func (p StartPosition) OrDefault() StartPosition {
+	if p == "" {
+		return StartPositionNewOnly
+	}
+	return p
+}
+
+// Validate checks the start position is known.
+func (p StartPosition) Validate(context.Context) *apis.FieldError {
+	switch p {
+	case "", StartPositionNewOnly, StartPositionAllAvailable:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(p, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", StartPositionNewOnly, StartPositionAllAvailable)
+		return fe
+	}
+}
+
+// Validate checks the delivery order is known.
+func (o DeliveryOrder) Validate(context.Context) *apis.FieldError {
+	switch o {
+	case "", DeliveryOrdered, DeliveryUnordered:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(o, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", DeliveryOrdered, DeliveryUnordered)
+		return fe
+	}
+}
+
+// ValidateAckWait checks the ack wait d is between MinAckWait and MaxAckWait.
+func ValidateAckWait(d time.Duration) error {
+	if d < MinAckWait || d > MaxAckWait {
+		return fmt.Errorf("the ack wait %v is not between %v and %v", d, MinAckWait, MaxAckWait)
+	}
+	return nil
+}
+
+// ParseDeliveryTimeout parses the ISO 8601 duration raw, such as PT30S, as the timeout of the
+// requests of a delivery, which must be positive.
+func ParseDeliveryTimeout(raw string) (time.Duration, error) {
+	p, err := period.Parse(raw)
+	if err != nil {
+		return 0, fmt.Errorf("expected an ISO 8601 duration such as PT30S: %w", err)
+	}
+	d, _ := p.Duration()
+	if d <= 0 {
+		return 0, fmt.Errorf("the timeout %s is not positive", raw)
+	}
+	return d, nil
+}
+
+// ValidateMaxInflight checks n is between 1 and MaxInflightLimit.
+func ValidateMaxInflight(n int) error {
+	if n < 1 || n > MaxInflightLimit {
+		return fmt.Errorf("the max inflight %d is not between 1 and %d", n, MaxInflightLimit)
+	}
+	return nil
+}
+
+// DeliveryOptions are the options of the subscriptions of a NatssChannel set by its annotations,
+// the zero values leaving those of the dispatcher.
+type DeliveryOptions struct {
+	// AckWait is how long NATSS waits for the ack of an event before redelivering it.
+	AckWait time.Duration
+	// MaxInflight is how many events of a subscription NATSS sends before they are acknowledged.
+	MaxInflight int
+	// StartAt is where the subscriptions without a durable to resume from start.
+	StartAt StartPosition
+	// Order is whether the events are delivered to each subscriber in order, DeliveryOrdered
+	// taking precedence over MaxInflight.
+	Order DeliveryOrder
+}
+
+// DeliveryOptionsFromAnnotations returns the delivery options set by the annotations of a
+// NatssChannel.
+func DeliveryOptionsFromAnnotations(annotations map[string]string) (DeliveryOptions, *apis.FieldError) {
+	var options DeliveryOptions
+	var errs *apis.FieldError
+	invalid := func(key, value string, err error) {
+		fe := apis.ErrInvalidValue(value, apis.CurrentField)
+		fe.Details = err.Error()
+		errs = errs.Also(fe.ViaFieldKey("annotations", key))
+	}
+	if raw, ok := annotations[messaging.AckWaitAnnotationKey]; ok {
+		d, err := time.ParseDuration(raw)
+		if err == nil {
+			err = ValidateAckWait(d)
+		}
+		if err != nil {
+			invalid(messaging.AckWaitAnnotationKey, raw, err)
+		} else {
+			options.AckWait = d
+		}
+	}
+	if raw, ok := annotations[messaging.MaxInflightAnnotationKey]; ok {
+		n, err := strconv.Atoi(raw)
+		if err == nil {
+			err = ValidateMaxInflight(n)
+		}
+		if err != nil {
+			invalid(messaging.MaxInflightAnnotationKey, raw, err)
+		} else {
+			options.MaxInflight = n
+		}
+	}
+	if raw, ok := annotations[messaging.StartAtAnnotationKey]; ok {
+		if fe := StartPosition(raw).Validate(context.Background()); fe != nil {
+			errs = errs.Also(fe.ViaFieldKey("annotations", messaging.StartAtAnnotationKey))
+		} else {
+			options.StartAt = StartPosition(raw)
+		}
+	}
+	if raw, ok := annotations[messaging.DeliveryOrderAnnotationKey]; ok {
+		if fe := DeliveryOrder(raw).Validate(context.Background()); fe != nil {
+			errs = errs.Also(fe.ViaFieldKey("annotations", messaging.DeliveryOrderAnnotationKey))
+		} else {
+			options.Order = DeliveryOrder(raw)
+		}
+	}
+	return options, errs
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+// Distribution is how the events of a channel are distributed among its subscribers.
+type Distribution string
+
+const (
+	// DistributionFanout delivers a copy of every event to each subscriber. It is the default.
+	DistributionFanout Distribution = "fanout"
+	// DistributionWorkQueue delivers every event to a single subscriber, the subscribers competing
+	// for the events like the consumers of a work queue. An event whose delivery keeps failing is
+	// redelivered to any of the subscribers, and the events a removed subscriber did not
+	// acknowledge are redelivered to the remaining ones.
+	DistributionWorkQueue Distribution = "workqueue"
+)
+
+// OrDefault returns the distribution, DistributionFanout when it is not set.
+func (d Distribution) OrDefault() Distribution {
+	if d == "" {
+		return DistributionFanout
+	}
+	return d
+}
+
+// Validate checks the distribution is known.
+func (d Distribution) Validate(context.Context) *apis.FieldError {
+	switch d {
+	case "", DistributionFanout, DistributionWorkQueue:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(d, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", DistributionFanout, DistributionWorkQueue)
+		return fe
+	}
+}
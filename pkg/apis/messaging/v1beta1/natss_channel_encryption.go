@@ -0,0 +1,50 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+)
+
+// NatssChannelEncryption configures the encryption of the events stored by NATSS.
+type NatssChannelEncryption struct {
+	// SecretRef references the Secret, in the namespace of the channel, holding the keys. Each
+	// data entry is a 16, 24 or 32 bytes AES key named after its ID.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// KeyID is the ID of the key encrypting the new events. The other keys of the Secret only
+	// decrypt the events stored before the key was rotated.
+	KeyID string `json:"keyID"`
+}
+
+// Validate checks the Secret and the key are set.
+func (e *NatssChannelEncryption) Validate(context.Context) *apis.FieldError {
+	if e == nil {
+		return nil
+	}
+	var errs *apis.FieldError
+	if e.SecretRef.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("secretRef.name"))
+	}
+	if e.KeyID == "" {
+		errs = errs.Also(apis.ErrMissingField("keyID"))
+	}
+	return errs
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+// DispatcherFinalizerName is the finalizer the dispatcher sets on the NatssChannels, removed once
+// their subscriptions are finalized. Its version changes with the finalization, the previous
+// names being added to LegacyDispatcherFinalizerNames.
+const DispatcherFinalizerName = "natsschannels.messaging.knative.dev/dispatcher-v1"
+
+// LegacyDispatcherFinalizerNames are the finalizers the previous releases of the dispatcher set,
+// which it replaces with DispatcherFinalizerName.
+var LegacyDispatcherFinalizerNames = []string{
+	// The default finalizer of the generated reconciler.
+	"natsschannels.messaging.knative.dev",
+	"natss-ch-dispatcher",
+}
+
+// IsLegacyDispatcherFinalizer tells whether finalizer is one of LegacyDispatcherFinalizerNames.
+func IsLegacyDispatcherFinalizer(finalizer string) bool {
+	for _, legacy := range LegacyDispatcherFinalizerNames {
+		if finalizer == legacy {
+			return true
+		}
+	}
+	return false
+}
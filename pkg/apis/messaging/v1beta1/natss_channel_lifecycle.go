@@ -17,6 +17,10 @@ limitations under the License.
 package v1beta1
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/apis"
@@ -28,7 +32,8 @@ var conditionSet = apis.NewLivingConditionSet(
 	NatssChannelConditionServiceReady,
 	NatssChannelConditionEndpointsReady,
 	NatssChannelConditionAddressable,
-	NatssChannelConditionChannelServiceReady)
+	NatssChannelConditionChannelServiceReady,
+	NatssChannelConditionNatssConnectionReady)
 
 const (
 	// NatssChannelConditionReady has status True when all subconditions below have been set to True.
@@ -55,6 +60,43 @@ const (
 	// NatssChannelConditionChannelServiceReady has status True when a k8s Service representing the channel is ready.
 	// Because this uses ExternalName, there are no endpoints to check.
 	NatssChannelConditionChannelServiceReady apis.ConditionType = "ChannelServiceReady"
+
+	// NatssChannelConditionAuditSinkReachable has status True when the last copy of an event was
+	// delivered to the audit sink of the channel. It does not affect the readiness of the channel.
+	NatssChannelConditionAuditSinkReachable apis.ConditionType = "AuditSinkReachable"
+
+	// NatssChannelConditionHibernated has status True when the dispatcher closed the subscriptions
+	// of the channel after it went without events for the hibernation threshold. It is informational
+	// and does not affect the readiness of the channel, which wakes up on the next event.
+	NatssChannelConditionHibernated apis.ConditionType = "Hibernated"
+
+	// NatssChannelConditionInsecureDelivery has status True when subscriptions of the channel
+	// delivered over plain HTTP to a subscriber in another namespace. It is informational and does
+	// not affect the readiness of the channel.
+	NatssChannelConditionInsecureDelivery apis.ConditionType = "InsecureDelivery"
+
+	// NatssChannelConditionChannelNotProvisionedOnServer has status True when the NATSS server
+	// refused the NATSS channel backing the channel, as the servers partitioned or limited to a
+	// fixed list of channels do. It names the NATSS channel to provision, and does not affect the
+	// readiness of the channel, whose subscribers are reported as not ready.
+	NatssChannelConditionChannelNotProvisionedOnServer apis.ConditionType = "ChannelNotProvisionedOnServer"
+
+	// NatssChannelConditionNatssConnectionReady has status True when the dispatcher is connected to
+	// the NATSS cluster of the channel. It is False while the connection cannot be made, or was lost
+	// until the subscriptions of the channel are made again on a new connection.
+	NatssChannelConditionNatssConnectionReady apis.ConditionType = "NatssConnectionReady"
+
+	// NatssChannelConditionFanoutAboveLimit has status True when the channel has more subscribers
+	// than its fan-out soft limit, each of its events being delivered once per subscriber, or than
+	// its hard limit, the subscribers beyond which are refused. It is informational and does not
+	// affect the readiness of the channel.
+	NatssChannelConditionFanoutAboveLimit apis.ConditionType = "FanoutAboveLimit"
+
+	// NatssChannelConditionBufferingOffline has status True while the receiver holds events of the
+	// channel in memory, accepted while the connection to NATSS is lost, which are lost if the
+	// dispatcher stops before they are published. It is informational and does not affect the
+	// readiness of the channel.
+	NatssChannelConditionBufferingOffline apis.ConditionType = "BufferingOffline"
 )
 
 // GetConditionSet retrieves the condition set for this resource. Implements the KRShaped interface.
@@ -87,6 +129,14 @@ func (cs *NatssChannelStatus) SetAddress(url *apis.URL) {
 	}
 }
 
+// MarkAddressUnavailable removes the addresses of the channel, which cannot be served, and marks
+// the Addressable condition false.
+func (cs *NatssChannelStatus) MarkAddressUnavailable(reason, messageFormat string, messageA ...interface{}) {
+	cs.Address = nil
+	cs.Addresses = nil
+	conditionSet.Manage(cs).MarkFalse(NatssChannelConditionAddressable, reason, messageFormat, messageA...)
+}
+
 func (cs *NatssChannelStatus) MarkDispatcherFailed(reason, messageFormat string, messageA ...interface{}) {
 	conditionSet.Manage(cs).MarkFalse(NatssChannelConditionDispatcherReady, reason, messageFormat, messageA...)
 }
@@ -127,3 +177,158 @@ func (cs *NatssChannelStatus) MarkEndpointsFailed(reason, messageFormat string,
 func (cs *NatssChannelStatus) MarkEndpointsTrue() {
 	conditionSet.Manage(cs).MarkTrue(NatssChannelConditionEndpointsReady)
 }
+
+func (cs *NatssChannelStatus) MarkAuditSinkReachable() {
+	conditionSet.Manage(cs).MarkTrue(NatssChannelConditionAuditSinkReachable)
+}
+
+func (cs *NatssChannelStatus) MarkAuditSinkUnreachable(reason, messageFormat string, messageA ...interface{}) {
+	conditionSet.Manage(cs).MarkFalse(NatssChannelConditionAuditSinkReachable, reason, messageFormat, messageA...)
+}
+
+func (cs *NatssChannelStatus) MarkAuditSinkUnknown(reason, messageFormat string, messageA ...interface{}) {
+	conditionSet.Manage(cs).MarkUnknown(NatssChannelConditionAuditSinkReachable, reason, messageFormat, messageA...)
+}
+
+// ClearAuditSinkCondition removes the AuditSinkReachable condition of the channels without audit sink.
+func (cs *NatssChannelStatus) ClearAuditSinkCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionAuditSinkReachable)
+}
+
+// MarkHibernated reports the subscriptions of the channel closed since the given time.
+func (cs *NatssChannelStatus) MarkHibernated(since time.Time) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionHibernated,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityInfo,
+		Reason:   "Idle",
+		Message:  fmt.Sprintf("The subscriptions are closed since %s, until the next event", since.UTC().Format(time.RFC3339)),
+	})
+}
+
+// ClearHibernatedCondition removes the Hibernated condition of the channels which are awake.
+func (cs *NatssChannelStatus) ClearHibernatedCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionHibernated)
+}
+
+// MarkInsecureDelivery reports the subscribers delivered to over plain HTTP in another namespace.
+func (cs *NatssChannelStatus) MarkInsecureDelivery(subscribers []string) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionInsecureDelivery,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityInfo,
+		Reason:   "PlainHTTPCrossNamespace",
+		Message:  fmt.Sprintf("The events are delivered over plain HTTP to another namespace to: %s", strings.Join(subscribers, ", ")),
+	})
+}
+
+// ClearInsecureDeliveryCondition removes the InsecureDelivery condition of the channels whose
+// deliveries are secure, or not reported.
+func (cs *NatssChannelStatus) ClearInsecureDeliveryCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionInsecureDelivery)
+}
+
+// MarkChannelNotProvisionedOnServer reports the NATSS channel subject refused by the server.
+func (cs *NatssChannelStatus) MarkChannelNotProvisionedOnServer(subject string) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionChannelNotProvisionedOnServer,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "NatssChannelNotProvisioned",
+		Message:  fmt.Sprintf("The NATSS server does not serve the channel %q, which must be provisioned on it", subject),
+	})
+}
+
+// ClearChannelNotProvisionedOnServerCondition removes the ChannelNotProvisionedOnServer condition
+// of the channels served by the NATSS server.
+func (cs *NatssChannelStatus) ClearChannelNotProvisionedOnServerCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionChannelNotProvisionedOnServer)
+}
+
+// MarkFanoutAboveSoftLimit reports the subscribers of the channel above its fan-out soft limit.
+func (cs *NatssChannelStatus) MarkFanoutAboveSoftLimit(subscribers, limit int) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionFanoutAboveLimit,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "FanoutSoftLimitExceeded",
+		Message:  fmt.Sprintf("The channel has %d subscribers, above its fan-out soft limit of %d: each event is delivered %d times", subscribers, limit, subscribers),
+	})
+}
+
+// MarkFanoutLimitExceeded reports the subscribers of the channel above its fan-out hard limit,
+// those beyond it being refused.
+func (cs *NatssChannelStatus) MarkFanoutLimitExceeded(subscribers, limit int) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionFanoutAboveLimit,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "FanoutLimitExceeded",
+		Message:  fmt.Sprintf("The channel has %d subscribers, above its fan-out limit of %d: the last %d are refused", subscribers, limit, subscribers-limit),
+	})
+}
+
+// ClearFanoutAboveLimitCondition removes the FanoutAboveLimit condition of the channels within
+// their fan-out limits, or whose dispatcher does not limit them.
+func (cs *NatssChannelStatus) ClearFanoutAboveLimitCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionFanoutAboveLimit)
+}
+
+// MarkBufferingOffline reports the events of the channel the receiver holds in memory since since,
+// until connected to NATSS again.
+func (cs *NatssChannelStatus) MarkBufferingOffline(events int, since time.Time) {
+	conditionSet.Manage(cs).SetCondition(apis.Condition{
+		Type:     NatssChannelConditionBufferingOffline,
+		Status:   corev1.ConditionTrue,
+		Severity: apis.ConditionSeverityWarning,
+		Reason:   "EventsBufferedInMemory",
+		Message: fmt.Sprintf("%d events accepted since %s are held in memory until NATSS is reachable again, and are lost if the dispatcher stops",
+			events, since.UTC().Format(time.RFC3339)),
+	})
+}
+
+// ClearBufferingOfflineCondition removes the BufferingOffline condition of the channels without
+// buffered events.
+func (cs *NatssChannelStatus) ClearBufferingOfflineCondition() {
+	_ = conditionSet.Manage(cs).ClearCondition(NatssChannelConditionBufferingOffline)
+}
+
+// MarkNatssConnectionReady reports the dispatcher connected to the NATSS cluster of the channel.
+func (cs *NatssChannelStatus) MarkNatssConnectionReady() {
+	conditionSet.Manage(cs).MarkTrue(NatssChannelConditionNatssConnectionReady)
+}
+
+// PropagateNatssConnectionStatus sets the NatssConnectionReady condition of from, reported by the
+// dispatcher, updating the readiness of the channel accordingly.
+func (cs *NatssChannelStatus) PropagateNatssConnectionStatus(from *NatssChannelStatus) {
+	c := from.GetCondition(NatssChannelConditionNatssConnectionReady)
+	switch {
+	case c == nil:
+	case c.IsTrue():
+		conditionSet.Manage(cs).MarkTrue(NatssChannelConditionNatssConnectionReady)
+	case c.IsFalse():
+		conditionSet.Manage(cs).MarkFalse(NatssChannelConditionNatssConnectionReady, c.Reason, "%s", c.Message)
+	default:
+		conditionSet.Manage(cs).MarkUnknown(NatssChannelConditionNatssConnectionReady, c.Reason, "%s", c.Message)
+	}
+}
+
+// MarkNatssConnectionFailed reports the dispatcher unable to connect to the NATSS cluster at url
+// of the channel, the last attempt failing with err, nil when none was made yet.
+func (cs *NatssChannelStatus) MarkNatssConnectionFailed(url string, err error) {
+	if err == nil {
+		conditionSet.Manage(cs).MarkFalse(NatssChannelConditionNatssConnectionReady, "NatssNotConnected",
+			"The dispatcher is not connected to NATSS at %s yet", url)
+		return
+	}
+	conditionSet.Manage(cs).MarkFalse(NatssChannelConditionNatssConnectionReady, "NatssConnectionFailed",
+		"The dispatcher cannot connect to NATSS at %s: %v", url, err)
+}
+
+// MarkNatssConnectionLost reports the connection of the dispatcher to the NATSS cluster at url of
+// the channel lost since the given time, with err, until its subscriptions are made again.
+func (cs *NatssChannelStatus) MarkNatssConnectionLost(url string, since time.Time, err error) {
+	conditionSet.Manage(cs).MarkFalse(NatssChannelConditionNatssConnectionReady, "NatssConnectionLost",
+		"The connection to NATSS at %s is lost since %s, the subscriptions are made again once reconnected: %v",
+		url, since.UTC().Format(time.RFC3339), err)
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package v1beta1
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
 
@@ -132,6 +134,9 @@ func TestChannelInitializeConditions(t *testing.T) {
 					}, {
 						Type:   NatssChannelConditionEndpointsReady,
 						Status: corev1.ConditionUnknown,
+					}, {
+						Type:   NatssChannelConditionNatssConnectionReady,
+						Status: corev1.ConditionUnknown,
 					}, {
 						Type:   NatssChannelConditionReady,
 						Status: corev1.ConditionUnknown,
@@ -169,6 +174,9 @@ func TestChannelInitializeConditions(t *testing.T) {
 					}, {
 						Type:   NatssChannelConditionEndpointsReady,
 						Status: corev1.ConditionUnknown,
+					}, {
+						Type:   NatssChannelConditionNatssConnectionReady,
+						Status: corev1.ConditionUnknown,
 					}, {
 						Type:   NatssChannelConditionReady,
 						Status: corev1.ConditionUnknown,
@@ -206,6 +214,9 @@ func TestChannelInitializeConditions(t *testing.T) {
 					}, {
 						Type:   NatssChannelConditionEndpointsReady,
 						Status: corev1.ConditionUnknown,
+					}, {
+						Type:   NatssChannelConditionNatssConnectionReady,
+						Status: corev1.ConditionUnknown,
 					}, {
 						Type:   NatssChannelConditionReady,
 						Status: corev1.ConditionUnknown,
@@ -235,6 +246,8 @@ func TestChannelIsReady(t *testing.T) {
 		markChannelServiceReady bool
 		setAddress              bool
 		markEndpointsReady      bool
+		auditSinkUnreachable    bool
+		natssConnectionLost     bool
 		wantReady               bool
 		dispatcherStatus        *appsv1.DeploymentStatus
 	}{{
@@ -285,6 +298,24 @@ func TestChannelIsReady(t *testing.T) {
 		dispatcherStatus:        deploymentStatusReady,
 		setAddress:              true,
 		wantReady:               false,
+	}, {
+		name:                    "audit sink unreachable",
+		markServiceReady:        true,
+		markChannelServiceReady: true,
+		markEndpointsReady:      true,
+		auditSinkUnreachable:    true,
+		dispatcherStatus:        deploymentStatusReady,
+		setAddress:              true,
+		wantReady:               true,
+	}, {
+		name:                    "NATSS connection lost",
+		markServiceReady:        true,
+		markChannelServiceReady: true,
+		markEndpointsReady:      true,
+		natssConnectionLost:     true,
+		dispatcherStatus:        deploymentStatusReady,
+		setAddress:              true,
+		wantReady:               false,
 	}}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -313,6 +344,14 @@ func TestChannelIsReady(t *testing.T) {
 			} else {
 				cs.MarkDispatcherFailed("NotReadyDispatcher", "testing")
 			}
+			if test.auditSinkUnreachable {
+				cs.MarkAuditSinkUnreachable("AuditSinkUnreachable", "testing")
+			}
+			if test.natssConnectionLost {
+				cs.MarkNatssConnectionLost("nats://natss:4222", time.Now(), errors.New("connection refused"))
+			} else {
+				cs.MarkNatssConnectionReady()
+			}
 			got := cs.IsReady()
 			if test.wantReady != got {
 				t.Errorf("unexpected readiness: want %v, got %v", test.wantReady, got)
@@ -383,3 +422,18 @@ func TestNatssChannelStatus_SetAddressable(t *testing.T) {
 		})
 	}
 }
+
+func TestNatssChannelStatus_MarkAddressUnavailable(t *testing.T) {
+	https := "https"
+	cs := &NatssChannelStatus{}
+	cs.SetAddress(&apis.URL{Scheme: "https", Host: "test-domain"})
+	cs.Addresses = []NatssChannelAddress{{Name: &https, URL: cs.Address.URL}}
+
+	cs.MarkAddressUnavailable("TransportEncryptionUnavailable", "no certificate")
+	if cs.Address != nil || cs.Addresses != nil {
+		t.Errorf("addresses = %v, %v, want none", cs.Address, cs.Addresses)
+	}
+	if c := cs.GetCondition(NatssChannelConditionAddressable); c == nil || !c.IsFalse() || c.Reason != "TransportEncryptionUnavailable" {
+		t.Errorf("Addressable condition = %+v, want false", c)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap/zapcore"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+)
+
+// Observability overrides how the events of a channel are logged and traced, the nil fields
+// keeping the settings of config-logging and config-tracing.
+type Observability struct {
+	// LogLevel is the level of the logs of the channel.
+	LogLevel *zapcore.Level
+	// TraceSampling is the probability, between 0 and 1, of tracing an event of the channel
+	// received without a sampled trace.
+	TraceSampling *float64
+}
+
+// IsZero returns whether o keeps the settings of config-logging and config-tracing.
+func (o Observability) IsZero() bool {
+	return o.LogLevel == nil && o.TraceSampling == nil
+}
+
+// ObservabilityFromAnnotations returns the observability set by the annotations of a NatssChannel.
+func ObservabilityFromAnnotations(annotations map[string]string) (Observability, *apis.FieldError) {
+	var o Observability
+	var errs *apis.FieldError
+	invalid := func(key, value string, err error) {
+		fe := apis.ErrInvalidValue(value, apis.CurrentField)
+		fe.Details = err.Error()
+		errs = errs.Also(fe.ViaFieldKey("annotations", key))
+	}
+	if raw, ok := annotations[messaging.LogLevelAnnotationKey]; ok {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(raw)); err != nil {
+			invalid(messaging.LogLevelAnnotationKey, raw, err)
+		} else {
+			o.LogLevel = &level
+		}
+	}
+	if raw, ok := annotations[messaging.TraceSamplingAnnotationKey]; ok {
+		p, err := strconv.ParseFloat(raw, 64)
+		if err == nil && (p < 0 || p > 1) {
+			err = fmt.Errorf("the sampling probability %v is not between 0 and 1", p)
+		}
+		if err != nil {
+			invalid(messaging.TraceSamplingAnnotationKey, raw, err)
+		} else {
+			o.TraceSampling = &p
+		}
+	}
+	return o, errs
+}
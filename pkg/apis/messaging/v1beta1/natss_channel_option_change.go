@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+)
+
+// OptionChangePolicy is what the dispatcher does when the options of the subscriptions of a
+// channel change in a way NATSS does not apply to their existing durables, such as their start
+// position.
+type OptionChangePolicy string
+
+const (
+	// OptionChangeIgnore applies the changes NATSS applies to the existing durables and leaves the
+	// others to the durables made from then on, reporting them in the status of the subscribers.
+	// It is the default.
+	OptionChangeIgnore OptionChangePolicy = "ignore"
+	// OptionChangeRecreate removes the durables and makes them again with the new options: the
+	// events they did not acknowledge are lost, and the events they start from delivered again.
+	OptionChangeRecreate OptionChangePolicy = "recreate"
+	// OptionChangeReject refuses the updates of the channel changing the options NATSS does not
+	// apply to the existing durables.
+	OptionChangeReject OptionChangePolicy = "reject"
+)
+
+// OrDefault returns the option change policy, OptionChangeIgnore when it is not set.
+func (p OptionChangePolicy) OrDefault() OptionChangePolicy {
+	if p == "" {
+		return OptionChangeIgnore
+	}
+	return p
+}
+
+// Validate checks the option change policy is known.
+func (p OptionChangePolicy) Validate(context.Context) *apis.FieldError {
+	switch p {
+	case "", OptionChangeIgnore, OptionChangeRecreate, OptionChangeReject:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(p, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected one of %q, %q or %q", OptionChangeIgnore, OptionChangeRecreate, OptionChangeReject)
+		return fe
+	}
+}
+
+// checkOptionChange refuses to change the start position of a channel rejecting the option
+// changes, which NATSS would not apply to the durables of its subscribers.
+func (c *NatssChannel) checkOptionChange(original *NatssChannel) *apis.FieldError {
+	if c.Spec.OptionChangePolicy.OrDefault() != OptionChangeReject || len(original.Spec.Subscribers) == 0 {
+		return nil
+	}
+	// The invalid annotations are reported on their own.
+	from, _ := DeliveryOptionsFromAnnotations(original.Annotations)
+	to, _ := DeliveryOptionsFromAnnotations(c.Annotations)
+	if from.StartAt == to.StartAt {
+		return nil
+	}
+	fe := apis.ErrGeneric("start position of existing durables changed")
+	fe.Details = fmt.Sprintf("NATSS does not apply it to the durables of the subscribers, and spec.optionChangePolicy is %q", OptionChangeReject)
+	return fe.ViaFieldKey("annotations", messaging.StartAtAnnotationKey)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+// RedirectPolicy is whether the deliveries of a channel follow the redirects of its subscribers.
+type RedirectPolicy string
+
+const (
+	// RedirectPolicyFollow follows the redirects, up to the maximum configured for the dispatcher.
+	// It is the default.
+	RedirectPolicyFollow RedirectPolicy = "follow"
+	// RedirectPolicyDeny fails the deliveries answered with a redirect, for the channels whose
+	// subscribers must be reached at their exact address.
+	RedirectPolicyDeny RedirectPolicy = "deny"
+)
+
+// OrDefault returns the redirect policy, RedirectPolicyFollow when it is not set.
+func (p RedirectPolicy) OrDefault() RedirectPolicy {
+	if p == "" {
+		return RedirectPolicyFollow
+	}
+	return p
+}
+
+// Validate checks the redirect policy is known.
+func (p RedirectPolicy) Validate(context.Context) *apis.FieldError {
+	switch p {
+	case "", RedirectPolicyFollow, RedirectPolicyDeny:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(p, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", RedirectPolicyFollow, RedirectPolicyDeny)
+		return fe
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"knative.dev/pkg/apis"
+)
+
+// ResponseAction is what the dispatcher does with an event whose delivery failed.
+type ResponseAction string
+
+const (
+	// ResponseActionRetry leaves the event unacknowledged so that it is redelivered.
+	ResponseActionRetry ResponseAction = "retry"
+	// ResponseActionDrop acknowledges the event without delivering it anywhere else.
+	ResponseActionDrop ResponseAction = "drop"
+	// ResponseActionDeadLetter sends the event to the dead letter sink of the subscription,
+	// falling back to retry when there is none.
+	ResponseActionDeadLetter ResponseAction = "deadletter"
+)
+
+// ResponseCodePolicy maps subscriber response codes to the action taken by the dispatcher.
+// Keys are either a status code ("404") or a status code class ("4xx"), a status code taking
+// precedence over its class. Success codes (2xx) cannot be configured.
+type ResponseCodePolicy map[string]ResponseAction
+
+// Action returns the action configured for the response code, if any.
+func (p ResponseCodePolicy) Action(code int) (ResponseAction, bool) {
+	if action, ok := p[strconv.Itoa(code)]; ok {
+		return action, true
+	}
+	action, ok := p[fmt.Sprintf("%dxx", code/100)]
+	return action, ok
+}
+
+// Validate checks the keys are status codes or classes of error responses and the actions are
+// known, rejecting keys that only differ by their case.
+func (p ResponseCodePolicy) Validate(context.Context) *apis.FieldError {
+	var errs *apis.FieldError
+	seen := make(map[string]string, len(p))
+	for _, key := range p.sortedKeys() {
+		normalized := strings.ToLower(key)
+		if other, ok := seen[normalized]; ok {
+			fe := apis.ErrMultipleOneOf(other, key)
+			errs = errs.Also(fe)
+			continue
+		}
+		seen[normalized] = key
+
+		if err := validateResponseCodeKey(normalized); err != nil {
+			fe := apis.ErrInvalidKeyName(key, apis.CurrentField, err.Error())
+			errs = errs.Also(fe)
+		}
+		switch p[key] {
+		case ResponseActionRetry, ResponseActionDrop, ResponseActionDeadLetter:
+		default:
+			fe := apis.ErrInvalidValue(p[key], key)
+			fe.Details = fmt.Sprintf("expected one of %q, %q or %q", ResponseActionRetry, ResponseActionDrop, ResponseActionDeadLetter)
+			errs = errs.Also(fe)
+		}
+	}
+	return errs
+}
+
+// String formats the policy as parsed by ParseResponseCodePolicy.
+func (p ResponseCodePolicy) String() string {
+	entries := make([]string, 0, len(p))
+	for _, key := range p.sortedKeys() {
+		entries = append(entries, key+"="+string(p[key]))
+	}
+	return strings.Join(entries, ",")
+}
+
+func (p ResponseCodePolicy) sortedKeys() []string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseResponseCodePolicy parses a policy formatted as comma separated <code or class>=<action>
+// entries, for example "404=deadletter,429=retry,5xx=retry".
+func ParseResponseCodePolicy(s string) (ResponseCodePolicy, error) {
+	p := make(ResponseCodePolicy)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid response code policy entry %q, expected <code>=<action>", entry)
+		}
+		key := strings.TrimSpace(parts[0])
+		if _, ok := p[key]; ok {
+			return nil, fmt.Errorf("duplicate response code policy entry for %q", key)
+		}
+		p[key] = ResponseAction(strings.TrimSpace(parts[1]))
+	}
+	if err := p.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func validateResponseCodeKey(key string) error {
+	if len(key) != 3 {
+		return fmt.Errorf("expected a status code or a status code class like 4xx")
+	}
+	if strings.HasSuffix(key, "xx") {
+		if key[0] < '1' || key[0] > '5' {
+			return fmt.Errorf("unknown status code class")
+		}
+		if key[0] == '2' {
+			return fmt.Errorf("success responses cannot be configured")
+		}
+		return nil
+	}
+	code, err := strconv.Atoi(key)
+	if err != nil || code < 100 || code > 599 {
+		return fmt.Errorf("expected a status code between 100 and 599")
+	}
+	if code/100 == 2 {
+		return fmt.Errorf("success responses cannot be configured")
+	}
+	return nil
+}
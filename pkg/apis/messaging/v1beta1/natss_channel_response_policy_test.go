@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResponseCodePolicyAction(t *testing.T) {
+	p := ResponseCodePolicy{
+		"404": ResponseActionDeadLetter,
+		"4xx": ResponseActionRetry,
+		"5xx": ResponseActionDrop,
+	}
+
+	testCases := map[int]struct {
+		want   ResponseAction
+		wantOk bool
+	}{
+		404: {want: ResponseActionDeadLetter, wantOk: true},
+		429: {want: ResponseActionRetry, wantOk: true},
+		503: {want: ResponseActionDrop, wantOk: true},
+		302: {},
+		-1:  {},
+	}
+
+	for code, tc := range testCases {
+		got, ok := p.Action(code)
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("Action(%d) = %q, %v, want %q, %v", code, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
+
+func TestResponseCodePolicyValidate(t *testing.T) {
+	testCases := map[string]struct {
+		policy  ResponseCodePolicy
+		wantErr bool
+	}{
+		"empty": {},
+		"codes and classes": {
+			policy: ResponseCodePolicy{"404": ResponseActionDeadLetter, "4xx": ResponseActionRetry, "5xx": ResponseActionDrop},
+		},
+		"unknown action": {
+			policy:  ResponseCodePolicy{"404": "ignore"},
+			wantErr: true,
+		},
+		"success code": {
+			policy:  ResponseCodePolicy{"202": ResponseActionRetry},
+			wantErr: true,
+		},
+		"success class": {
+			policy:  ResponseCodePolicy{"2xx": ResponseActionDrop},
+			wantErr: true,
+		},
+		"out of range code": {
+			policy:  ResponseCodePolicy{"600": ResponseActionRetry},
+			wantErr: true,
+		},
+		"unknown class": {
+			policy:  ResponseCodePolicy{"9xx": ResponseActionRetry},
+			wantErr: true,
+		},
+		"not a code": {
+			policy:  ResponseCodePolicy{"notfound": ResponseActionRetry},
+			wantErr: true,
+		},
+		"overlapping keys": {
+			policy:  ResponseCodePolicy{"4xx": ResponseActionRetry, "4XX": ResponseActionDrop},
+			wantErr: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			err := tc.policy.Validate(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseResponseCodePolicy(t *testing.T) {
+	got, err := ParseResponseCodePolicy(" 404=deadletter, 429=retry,,5xx=drop")
+	if err != nil {
+		t.Fatalf("ParseResponseCodePolicy() = %v", err)
+	}
+	want := ResponseCodePolicy{"404": ResponseActionDeadLetter, "429": ResponseActionRetry, "5xx": ResponseActionDrop}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected policy (-want, +got): %s", diff)
+	}
+	if got, want := got.String(), "404=deadletter,429=retry,5xx=drop"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, invalid := range []string{"404", "404=retry,404=drop", "200=retry", "404=maybe"} {
+		if _, err := ParseResponseCodePolicy(invalid); err == nil {
+			t.Errorf("ParseResponseCodePolicy(%q) succeeded, want an error", invalid)
+		}
+	}
+}
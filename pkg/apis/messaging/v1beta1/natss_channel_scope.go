@@ -0,0 +1,40 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"knative.dev/eventing/pkg/apis/eventing"
+	"knative.dev/pkg/apis"
+)
+
+// IsNamespaceScoped returns whether the channel is served by a dispatcher of its namespace, as
+// requested by the eventing.knative.dev/scope: namespace annotation, rather than by the dispatcher
+// of the cluster.
+func (c *NatssChannel) IsNamespaceScoped() bool {
+	return c.Annotations[eventing.ScopeAnnotationKey] == eventing.ScopeNamespace
+}
+
+// checkScopeImmutable refuses to move a channel to another dispatcher, whose subscriptions would
+// not resume from the durables of the original one.
+func (c *NatssChannel) checkScopeImmutable(original *NatssChannel) *apis.FieldError {
+	if c.IsNamespaceScoped() == original.IsNamespaceScoped() {
+		return nil
+	}
+	fe := apis.ErrGeneric("immutable field changed")
+	fe.Details = "the channel cannot move between the dispatcher of the cluster and the one of its namespace"
+	return fe.ViaFieldKey("annotations", eventing.ScopeAnnotationKey)
+}
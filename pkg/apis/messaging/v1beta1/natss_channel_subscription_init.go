@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+// SubscriptionInit is when the dispatcher makes the subscriptions of a channel to NATSS.
+type SubscriptionInit string
+
+const (
+	// SubscriptionInitEager makes the subscriptions as soon as the channel is reconciled. It is
+	// the default.
+	SubscriptionInitEager SubscriptionInit = "eager"
+	// SubscriptionInitLazy defers the subscriptions until the first event sent to the channel, or
+	// the next probe of the subscribers, the channel receiving the events meanwhile. The durables
+	// then start from the position of the channel when it was reconciled, so that the events
+	// sent in between are delivered.
+	SubscriptionInitLazy SubscriptionInit = "lazy"
+)
+
+// OrDefault returns the subscription init, SubscriptionInitEager when it is not set.
+func (i SubscriptionInit) OrDefault() SubscriptionInit {
+	if i == "" {
+		return SubscriptionInitEager
+	}
+	return i
+}
+
+// Validate checks the subscription init is known.
+func (i SubscriptionInit) Validate(context.Context) *apis.FieldError {
+	switch i {
+	case "", SubscriptionInitEager, SubscriptionInitLazy:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(i, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", SubscriptionInitEager, SubscriptionInitLazy)
+		return fe
+	}
+}
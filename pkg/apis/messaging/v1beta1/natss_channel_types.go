@@ -56,6 +56,60 @@ type NatssChannelSpec struct {
 	// * SubscribableSpec - List of subscribers
 	// * DeliverySpec - contains options controlling the event delivery
 	eventingduckv1.ChannelableSpec `json:",inline"`
+
+	// ResponseCodePolicy configures what the dispatcher does when a subscriber responds
+	// with an error, overriding the cluster default set in the config-natss ConfigMap.
+	// +optional
+	ResponseCodePolicy ResponseCodePolicy `json:"responseCodePolicy,omitempty"`
+
+	// WireFormat is the format of the events published on the subject of the channel, either
+	// envelope (the default) or nats-binding for consumers reading the subject directly.
+	// +optional
+	WireFormat WireFormat `json:"wireFormat,omitempty"`
+
+	// Distribution is how the events are distributed among the subscribers, either fanout (the
+	// default) delivering every event to each subscriber, or workqueue delivering every event to
+	// a single subscriber. The delivery guarantees differ, see DistributionWorkQueue.
+	// +optional
+	Distribution Distribution `json:"distribution,omitempty"`
+
+	// SubscriptionInit is when the subscriptions are made, either eager (the default) as soon as
+	// the channel is reconciled, or lazy on the first event sent to the channel.
+	// +optional
+	SubscriptionInit SubscriptionInit `json:"subscriptionInit,omitempty"`
+
+	// Encryption enables the encryption of the events stored by NATSS with the keys of a Secret.
+	// +optional
+	Encryption *NatssChannelEncryption `json:"encryption,omitempty"`
+
+	// Audit enables the mirroring of the events sent to the channel to an audit sink.
+	// +optional
+	Audit *NatssChannelAudit `json:"audit,omitempty"`
+
+	// RedirectPolicy is whether the deliveries follow the redirects of the subscribers, either
+	// follow (the default) or deny.
+	// +optional
+	RedirectPolicy RedirectPolicy `json:"redirectPolicy,omitempty"`
+
+	// OptionChangePolicy is what the dispatcher does when the start position of the subscriptions
+	// changes, which NATSS does not apply to their existing durables: ignore (the default),
+	// recreate or reject.
+	// +optional
+	OptionChangePolicy OptionChangePolicy `json:"optionChangePolicy,omitempty"`
+
+	// AvroTranscode enables the transcoding to JSON of the Avro events before their delivery.
+	// +optional
+	AvroTranscode *NatssChannelAvroTranscode `json:"avroTranscode,omitempty"`
+
+	// NatsURL is the comma separated URLs of the NATS servers of the cluster storing the events
+	// of the channel, defaulting to the one of the dispatcher. It cannot be changed.
+	// +optional
+	NatsURL string `json:"natsURL,omitempty"`
+
+	// ClusterID is the ID of the NATS Streaming cluster storing the events of the channel,
+	// defaulting to the one of the dispatcher. It cannot be changed.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
 }
 
 // NatssChannelStatus represents the current state of a NatssChannel.
@@ -68,6 +122,36 @@ type NatssChannelStatus struct {
 	// * DeadLetterChannel is a KReference and is set by the channel when it supports native error handling via a channel
 	//   Failed messages are delivered here.
 	eventingduckv1.ChannelableStatus `json:",inline"`
+
+	// DeadLetterSinkURI is the default dead letter sink of the namespace, set when the dispatcher
+	// applies it to subscribers without a dead letter sink of their own.
+	// +optional
+	DeadLetterSinkURI *apis.URL `json:"deadLetterSinkURI,omitempty"`
+
+	// Addresses are the addresses of the channel when the transport encryption of the cluster is
+	// permissive or strict, the HTTPS one first.
+	// +optional
+	Addresses []NatssChannelAddress `json:"addresses,omitempty"`
+
+	// Cluster is the NATS Streaming cluster the dispatcher bound the channel to.
+	// +optional
+	Cluster *NatssChannelCluster `json:"cluster,omitempty"`
+}
+
+// NatssChannelAddress is an address of a NatssChannel, shaped as the Addressable of the versions
+// of Knative Eventing which advertise the HTTPS addresses along with their certificate authorities.
+type NatssChannelAddress struct {
+	// Name tells the addresses apart, either "http" or "https".
+	// +optional
+	Name *string `json:"name,omitempty"`
+
+	// URL is the address.
+	URL *apis.URL `json:"url,omitempty"`
+
+	// CACerts is the PEM bundle of the certificate authorities of the HTTPS address, when they
+	// are not trusted by default.
+	// +optional
+	CACerts *string `json:"CACerts,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
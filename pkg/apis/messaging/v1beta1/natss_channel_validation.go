@@ -38,6 +38,17 @@ func (c *NatssChannel) Validate(ctx context.Context) *apis.FieldError {
 			}
 		}
 	}
+	_, fe := DeliveryOptionsFromAnnotations(c.Annotations)
+	errs = errs.Also(fe.ViaField("metadata"))
+	_, fe = ObservabilityFromAnnotations(c.Annotations)
+	errs = errs.Also(fe.ViaField("metadata"))
+	if apis.IsInUpdate(ctx) {
+		if original, ok := apis.GetBaseline(ctx).(*NatssChannel); ok && original != nil {
+			errs = errs.Also(c.Spec.checkClusterImmutable(&original.Spec).ViaField("spec"))
+			errs = errs.Also(c.checkScopeImmutable(original).ViaField("metadata"))
+			errs = errs.Also(c.checkOptionChange(original).ViaField("metadata"))
+		}
+	}
 	return errs
 }
 
@@ -50,5 +61,15 @@ func (cs *NatssChannelSpec) Validate(ctx context.Context) *apis.FieldError {
 			errs = errs.Also(fe.ViaField(fmt.Sprintf("subscriber[%d]", i)).ViaField("subscribable"))
 		}
 	}
+	errs = errs.Also(cs.ResponseCodePolicy.Validate(ctx).ViaField("responseCodePolicy"))
+	errs = errs.Also(cs.WireFormat.Validate(ctx).ViaField("wireFormat"))
+	errs = errs.Also(cs.Distribution.Validate(ctx).ViaField("distribution"))
+	errs = errs.Also(cs.SubscriptionInit.Validate(ctx).ViaField("subscriptionInit"))
+	errs = errs.Also(cs.RedirectPolicy.Validate(ctx).ViaField("redirectPolicy"))
+	errs = errs.Also(cs.OptionChangePolicy.Validate(ctx).ViaField("optionChangePolicy"))
+	errs = errs.Also(cs.Encryption.Validate(ctx).ViaField("encryption"))
+	errs = errs.Also(cs.Audit.Validate(ctx).ViaField("audit"))
+	errs = errs.Also(cs.AvroTranscode.Validate(ctx).ViaField("avroTranscode"))
+	errs = errs.Also(cs.Cluster().Validate(ctx))
 	return errs
 }
@@ -21,11 +21,16 @@ import (
 	"testing"
 
 	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/apis/eventing"
 
 	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"knative.dev/pkg/webhook/resourcesemantics"
 
 	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
 )
 
 func TestNatssChannelValidation(t *testing.T) {
@@ -96,6 +101,289 @@ func TestNatssChannelValidation(t *testing.T) {
 				return errs
 			}(),
 		},
+		"valid response code policy": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					ResponseCodePolicy: ResponseCodePolicy{"404": ResponseActionDeadLetter, "5xx": ResponseActionRetry},
+				},
+			},
+			want: nil,
+		},
+		"invalid response code policy": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					ResponseCodePolicy: ResponseCodePolicy{"2xx": ResponseActionDrop},
+				},
+			},
+			want: apis.ErrInvalidKeyName("2xx", "spec.responseCodePolicy", "success responses cannot be configured"),
+		},
+		"nats binding wire format": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					WireFormat: WireFormatNATSBinding,
+				},
+			},
+			want: nil,
+		},
+		"invalid wire format": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					WireFormat: "binary",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("binary", "spec.wireFormat")
+				fe.Details = `expected either "envelope" or "nats-binding"`
+				return fe
+			}(),
+		},
+		"work queue distribution": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Distribution: DistributionWorkQueue,
+				},
+			},
+			want: nil,
+		},
+		"lazy subscription init": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					SubscriptionInit: SubscriptionInitLazy,
+				},
+			},
+			want: nil,
+		},
+		"encryption": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Encryption: &NatssChannelEncryption{
+						SecretRef: corev1.LocalObjectReference{Name: "keys"},
+						KeyID:     "2020-11",
+					},
+				},
+			},
+			want: nil,
+		},
+		"encryption without key": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Encryption: &NatssChannelEncryption{},
+				},
+			},
+			want: apis.ErrMissingField("spec.encryption.secretRef.name", "spec.encryption.keyID"),
+		},
+		"audit sink": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Audit: &NatssChannelAudit{Sink: apis.HTTP("audit.example.com")},
+				},
+			},
+			want: nil,
+		},
+		"relative audit sink": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Audit: &NatssChannelAudit{Sink: &apis.URL{Path: "/audit"}},
+				},
+			},
+			want: apis.ErrInvalidValue("/audit", "spec.audit.sink"),
+		},
+		"avro transcode": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					AvroTranscode: &NatssChannelAvroTranscode{
+						RegistryURL: apis.HTTP("registry.example.com"),
+						SecretRef:   &corev1.LocalObjectReference{Name: "registry-credentials"},
+					},
+				},
+			},
+			want: nil,
+		},
+		"avro transcode without registry": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					AvroTranscode: &NatssChannelAvroTranscode{SecretRef: &corev1.LocalObjectReference{}},
+				},
+			},
+			want: apis.ErrMissingField("spec.avroTranscode.registryURL", "spec.avroTranscode.secretRef.name"),
+		},
+		"invalid redirect policy": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					RedirectPolicy: "loop",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("loop", "spec.redirectPolicy")
+				fe.Details = `expected either "follow" or "deny"`
+				return fe
+			}(),
+		},
+		"invalid option change policy": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					OptionChangePolicy: "restart",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("restart", "spec.optionChangePolicy")
+				fe.Details = `expected one of "ignore", "recreate" or "reject"`
+				return fe
+			}(),
+		},
+		"invalid distribution": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					Distribution: "roundrobin",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("roundrobin", "spec.distribution")
+				fe.Details = `expected either "fanout" or "workqueue"`
+				return fe
+			}(),
+		},
+		"invalid subscription init": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					SubscriptionInit: "deferred",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("deferred", "spec.subscriptionInit")
+				fe.Details = `expected either "eager" or "lazy"`
+				return fe
+			}(),
+		},
+		"cluster": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					NatsURL:   "nats://natss-a:4222, tls://natss-b:4222",
+					ClusterID: "knative-nats-streaming",
+				},
+			},
+			want: nil,
+		},
+		"invalid nats url": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					NatsURL: "http://natss:4222",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("http://natss:4222", "spec.natsURL")
+				fe.Details = "expected comma separated nats:// or tls:// URLs"
+				return fe
+			}(),
+		},
+		"invalid cluster id": {
+			cr: &NatssChannel{
+				Spec: NatssChannelSpec{
+					ClusterID: "knative.nats",
+				},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("knative.nats", "spec.clusterID")
+				fe.Details = "expected only alphanumeric characters, '-' and '_'"
+				return fe
+			}(),
+		},
+		"valid delivery options": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.AckWaitAnnotationKey:     "5m",
+					messaging.MaxInflightAnnotationKey: "16",
+					messaging.StartAtAnnotationKey:     "all-available",
+				}},
+			},
+			want: nil,
+		},
+		"invalid delivery options": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.AckWaitAnnotationKey:     "500ms",
+					messaging.MaxInflightAnnotationKey: "0",
+					messaging.StartAtAnnotationKey:     "last-received",
+				}},
+			},
+			want: func() *apis.FieldError {
+				var errs *apis.FieldError
+				fe := apis.ErrInvalidValue("500ms", apis.CurrentField)
+				fe.Details = "the ack wait 500ms is not between 1s and 1h0m0s"
+				errs = errs.Also(fe.ViaFieldKey("annotations", messaging.AckWaitAnnotationKey))
+				fe = apis.ErrInvalidValue("0", apis.CurrentField)
+				fe.Details = "the max inflight 0 is not between 1 and 10000"
+				errs = errs.Also(fe.ViaFieldKey("annotations", messaging.MaxInflightAnnotationKey))
+				fe = apis.ErrInvalidValue("last-received", apis.CurrentField)
+				fe.Details = `expected either "new-only" or "all-available"`
+				errs = errs.Also(fe.ViaFieldKey("annotations", messaging.StartAtAnnotationKey))
+				return errs.ViaField("metadata")
+			}(),
+		},
+		"valid observability": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.LogLevelAnnotationKey:      "debug",
+					messaging.TraceSamplingAnnotationKey: "1.0",
+				}},
+			},
+			want: nil,
+		},
+		"invalid observability": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.LogLevelAnnotationKey:      "verbose",
+					messaging.TraceSamplingAnnotationKey: "1.5",
+				}},
+			},
+			want: func() *apis.FieldError {
+				var errs *apis.FieldError
+				fe := apis.ErrInvalidValue("verbose", apis.CurrentField)
+				fe.Details = `unrecognized level: "verbose"`
+				errs = errs.Also(fe.ViaFieldKey("annotations", messaging.LogLevelAnnotationKey))
+				fe = apis.ErrInvalidValue("1.5", apis.CurrentField)
+				fe.Details = "the sampling probability 1.5 is not between 0 and 1"
+				errs = errs.Also(fe.ViaFieldKey("annotations", messaging.TraceSamplingAnnotationKey))
+				return errs.ViaField("metadata")
+			}(),
+		},
+		"ordered delivery": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.DeliveryOrderAnnotationKey: "ordered",
+				}},
+			},
+			want: nil,
+		},
+		"unordered delivery": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.DeliveryOrderAnnotationKey: "unordered",
+				}},
+			},
+			want: nil,
+		},
+		"ordered work queue": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.DeliveryOrderAnnotationKey: "ordered",
+				}},
+				Spec: NatssChannelSpec{Distribution: DistributionWorkQueue},
+			},
+			want: nil,
+		},
+		"unknown delivery order": {
+			cr: &NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					messaging.DeliveryOrderAnnotationKey: "partitioned",
+				}},
+			},
+			want: func() *apis.FieldError {
+				fe := apis.ErrInvalidValue("partitioned", apis.CurrentField)
+				fe.Details = `expected either "ordered" or "unordered"`
+				return fe.ViaFieldKey("annotations", messaging.DeliveryOrderAnnotationKey).ViaField("metadata")
+			}(),
+		},
 	}
 
 	for n, test := range testCases {
@@ -107,3 +395,142 @@ func TestNatssChannelValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestNatssChannelClusterImmutable(t *testing.T) {
+	original := &NatssChannel{Spec: NatssChannelSpec{NatsURL: "nats://natss-a:4222"}}
+
+	testCases := map[string]struct {
+		spec NatssChannelSpec
+		want *apis.FieldError
+	}{
+		"unchanged": {
+			spec: NatssChannelSpec{NatsURL: "nats://natss-a:4222", RedirectPolicy: RedirectPolicyDeny},
+		},
+		"url changed": {
+			spec: NatssChannelSpec{NatsURL: "nats://natss-b:4222"},
+			want: func() *apis.FieldError {
+				fe := apis.ErrGeneric("immutable field changed", "spec.natsURL")
+				fe.Details = "the events stored in the original cluster would be orphaned"
+				return fe
+			}(),
+		},
+		"cluster id set": {
+			spec: NatssChannelSpec{NatsURL: "nats://natss-a:4222", ClusterID: "other"},
+			want: func() *apis.FieldError {
+				fe := apis.ErrGeneric("immutable field changed", "spec.clusterID")
+				fe.Details = "the events stored in the original cluster would be orphaned"
+				return fe
+			}(),
+		},
+	}
+	for n, test := range testCases {
+		t.Run(n, func(t *testing.T) {
+			ctx := apis.WithinUpdate(context.Background(), original)
+			got := (&NatssChannel{Spec: test.spec}).Validate(ctx)
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Errorf("validate (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestNatssChannelScopeImmutable(t *testing.T) {
+	namespaced := map[string]string{eventing.ScopeAnnotationKey: eventing.ScopeNamespace}
+	scopeChanged := func() *apis.FieldError {
+		fe := apis.ErrGeneric("immutable field changed")
+		fe.Details = "the channel cannot move between the dispatcher of the cluster and the one of its namespace"
+		return fe.ViaFieldKey("annotations", eventing.ScopeAnnotationKey).ViaField("metadata")
+	}()
+
+	testCases := map[string]struct {
+		original map[string]string
+		updated  map[string]string
+		want     *apis.FieldError
+	}{
+		"cluster unchanged": {
+			updated: map[string]string{eventing.ScopeAnnotationKey: eventing.ScopeCluster},
+		},
+		"namespace unchanged": {
+			original: namespaced,
+			updated:  namespaced,
+		},
+		"namespace set": {
+			updated: namespaced,
+			want:    scopeChanged,
+		},
+		"namespace removed": {
+			original: namespaced,
+			want:     scopeChanged,
+		},
+	}
+	for n, test := range testCases {
+		t.Run(n, func(t *testing.T) {
+			original := &NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: test.original}}
+			ctx := apis.WithinUpdate(context.Background(), original)
+			got := (&NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: test.updated}}).Validate(ctx)
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Errorf("validate (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestNatssChannelOptionChangeRejected(t *testing.T) {
+	startAt := func(position StartPosition) map[string]string {
+		return map[string]string{messaging.StartAtAnnotationKey: string(position)}
+	}
+	subscribed := NatssChannelSpec{ChannelableSpec: eventingduckv1.ChannelableSpec{SubscribableSpec: eventingduckv1.SubscribableSpec{
+		Subscribers: []eventingduckv1.SubscriberSpec{{UID: "a", SubscriberURI: apis.HTTP("a.default.svc.cluster.local")}},
+	}}}
+	rejecting := subscribed
+	rejecting.OptionChangePolicy = OptionChangeReject
+	rejected := func() *apis.FieldError {
+		fe := apis.ErrGeneric("start position of existing durables changed")
+		fe.Details = `NATSS does not apply it to the durables of the subscribers, and spec.optionChangePolicy is "reject"`
+		return fe.ViaFieldKey("annotations", messaging.StartAtAnnotationKey).ViaField("metadata")
+	}()
+
+	testCases := map[string]struct {
+		original, updated NatssChannel
+		want              *apis.FieldError
+	}{
+		"start position set": {
+			original: NatssChannel{Spec: rejecting},
+			updated:  NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: rejecting},
+			want:     rejected,
+		},
+		"start position removed": {
+			original: NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: rejecting},
+			updated:  NatssChannel{Spec: rejecting},
+			want:     rejected,
+		},
+		"start position unchanged": {
+			original: NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: subscribed},
+			updated:  NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: rejecting},
+		},
+		"ignored": {
+			original: NatssChannel{Spec: subscribed},
+			updated:  NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: subscribed},
+		},
+		"recreated": {
+			original: NatssChannel{Spec: subscribed},
+			updated: NatssChannel{
+				ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)},
+				Spec:       NatssChannelSpec{ChannelableSpec: subscribed.ChannelableSpec, OptionChangePolicy: OptionChangeRecreate},
+			},
+		},
+		"no durable": {
+			original: NatssChannel{Spec: NatssChannelSpec{OptionChangePolicy: OptionChangeReject}},
+			updated:  NatssChannel{ObjectMeta: metav1.ObjectMeta{Annotations: startAt(StartPositionAllAvailable)}, Spec: rejecting},
+		},
+	}
+	for n, test := range testCases {
+		t.Run(n, func(t *testing.T) {
+			ctx := apis.WithinUpdate(context.Background(), &test.original)
+			got := test.updated.Validate(ctx)
+			if diff := cmp.Diff(test.want.Error(), got.Error()); diff != "" {
+				t.Errorf("validate (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
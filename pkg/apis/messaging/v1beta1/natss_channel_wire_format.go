@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+// WireFormat is the format of the events published on the subject of a channel.
+type WireFormat string
+
+const (
+	// WireFormatEnvelope publishes the events as structured CloudEvents, the attributes and the
+	// data being enclosed in a JSON envelope. It is the default.
+	WireFormatEnvelope WireFormat = "envelope"
+	// WireFormatNATSBinding publishes the events following the CloudEvents NATS protocol binding,
+	// the attributes being carried by NATS message headers and the data left untouched.
+	// It requires a transport supporting NATS headers.
+	WireFormatNATSBinding WireFormat = "nats-binding"
+)
+
+// OrDefault returns the wire format, WireFormatEnvelope when it is not set.
+func (f WireFormat) OrDefault() WireFormat {
+	if f == "" {
+		return WireFormatEnvelope
+	}
+	return f
+}
+
+// Validate checks the wire format is known.
+func (f WireFormat) Validate(context.Context) *apis.FieldError {
+	switch f {
+	case "", WireFormatEnvelope, WireFormatNATSBinding:
+		return nil
+	default:
+		fe := apis.ErrInvalidValue(f, apis.CurrentField)
+		fe.Details = fmt.Sprintf("expected either %q or %q", WireFormatEnvelope, WireFormatNATSBinding)
+		return fe
+	}
+}
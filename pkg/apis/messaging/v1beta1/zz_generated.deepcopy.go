@@ -21,7 +21,9 @@ limitations under the License.
 package v1beta1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	apis "knative.dev/pkg/apis"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -52,6 +54,117 @@ func (in *NatssChannel) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NatssChannelAddress) DeepCopyInto(out *NatssChannelAddress) {
+	*out = *in
+	if in.Name != nil {
+		in, out := &in.Name, &out.Name
+		*out = new(string)
+		**out = **in
+	}
+	if in.URL != nil {
+		in, out := &in.URL, &out.URL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CACerts != nil {
+		in, out := &in.CACerts, &out.CACerts
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NatssChannelAddress.
+func (in *NatssChannelAddress) DeepCopy() *NatssChannelAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(NatssChannelAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NatssChannelAudit) DeepCopyInto(out *NatssChannelAudit) {
+	*out = *in
+	if in.Sink != nil {
+		in, out := &in.Sink, &out.Sink
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NatssChannelAudit.
+func (in *NatssChannelAudit) DeepCopy() *NatssChannelAudit {
+	if in == nil {
+		return nil
+	}
+	out := new(NatssChannelAudit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NatssChannelAvroTranscode) DeepCopyInto(out *NatssChannelAvroTranscode) {
+	*out = *in
+	if in.RegistryURL != nil {
+		in, out := &in.RegistryURL, &out.RegistryURL
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NatssChannelAvroTranscode.
+func (in *NatssChannelAvroTranscode) DeepCopy() *NatssChannelAvroTranscode {
+	if in == nil {
+		return nil
+	}
+	out := new(NatssChannelAvroTranscode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NatssChannelCluster) DeepCopyInto(out *NatssChannelCluster) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NatssChannelCluster.
+func (in *NatssChannelCluster) DeepCopy() *NatssChannelCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(NatssChannelCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NatssChannelEncryption) DeepCopyInto(out *NatssChannelEncryption) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NatssChannelEncryption.
+func (in *NatssChannelEncryption) DeepCopy() *NatssChannelEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(NatssChannelEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NatssChannelList) DeepCopyInto(out *NatssChannelList) {
 	*out = *in
@@ -89,6 +202,28 @@ func (in *NatssChannelList) DeepCopyObject() runtime.Object {
 func (in *NatssChannelSpec) DeepCopyInto(out *NatssChannelSpec) {
 	*out = *in
 	in.ChannelableSpec.DeepCopyInto(&out.ChannelableSpec)
+	if in.ResponseCodePolicy != nil {
+		in, out := &in.ResponseCodePolicy, &out.ResponseCodePolicy
+		*out = make(ResponseCodePolicy, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(NatssChannelEncryption)
+		**out = **in
+	}
+	if in.Audit != nil {
+		in, out := &in.Audit, &out.Audit
+		*out = new(NatssChannelAudit)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AvroTranscode != nil {
+		in, out := &in.AvroTranscode, &out.AvroTranscode
+		*out = new(NatssChannelAvroTranscode)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -106,6 +241,23 @@ func (in *NatssChannelSpec) DeepCopy() *NatssChannelSpec {
 func (in *NatssChannelStatus) DeepCopyInto(out *NatssChannelStatus) {
 	*out = *in
 	in.ChannelableStatus.DeepCopyInto(&out.ChannelableStatus)
+	if in.DeadLetterSinkURI != nil {
+		in, out := &in.DeadLetterSinkURI, &out.DeadLetterSinkURI
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]NatssChannelAddress, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Cluster != nil {
+		in, out := &in.Cluster, &out.Cluster
+		*out = new(NatssChannelCluster)
+		**out = **in
+	}
 	return
 }
 
@@ -118,3 +270,25 @@ func (in *NatssChannelStatus) DeepCopy() *NatssChannelStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in ResponseCodePolicy) DeepCopyInto(out *ResponseCodePolicy) {
+	{
+		in := &in
+		*out = make(ResponseCodePolicy, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+		return
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResponseCodePolicy.
+func (in ResponseCodePolicy) DeepCopy() ResponseCodePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ResponseCodePolicy)
+	in.DeepCopyInto(out)
+	return *out
+}
@@ -0,0 +1,398 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package avro transcodes data in the Avro binary encoding to JSON.
+package avro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Schema is a parsed Avro schema.
+type Schema struct {
+	root *node
+}
+
+// node is a type of a schema.
+type node struct {
+	kind string
+	// name is the full name of the records, enums and fixed.
+	name string
+	// fields are the fields of a record.
+	fields []field
+	// symbols are the symbols of an enum.
+	symbols []string
+	// items is the type of the items of an array, values the type of the values of a map.
+	items, values *node
+	// branches are the types of a union.
+	branches []*node
+	// size is the size of a fixed.
+	size int
+}
+
+type field struct {
+	name string
+	typ  *node
+}
+
+// parser resolves the references to the named types while parsing a schema.
+type parser struct {
+	names map[string]*node
+}
+
+// ParseSchema parses the JSON text of an Avro schema. The logical types are decoded as their
+// underlying type.
+func ParseSchema(text string) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	p := parser{names: make(map[string]*node)}
+	root, err := p.parse(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+func (p *parser) parse(raw interface{}, namespace string) (*node, error) {
+	switch v := raw.(type) {
+	case string:
+		return p.parseName(v, namespace)
+	case []interface{}:
+		n := &node{kind: "union"}
+		for _, branch := range v {
+			b, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			n.branches = append(n.branches, b)
+		}
+		return n, nil
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	default:
+		return nil, fmt.Errorf("invalid schema type %v", raw)
+	}
+}
+
+func (p *parser) parseName(name, namespace string) (*node, error) {
+	switch name {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return &node{kind: name}, nil
+	}
+	if n, ok := p.names[fullName(name, namespace)]; ok {
+		return n, nil
+	}
+	if n, ok := p.names[name]; ok {
+		return n, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+func (p *parser) parseComplex(v map[string]interface{}, namespace string) (*node, error) {
+	kind, _ := v["type"].(string)
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s without name", kind)
+		}
+		if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		n := &node{kind: kind, name: fullName(name, namespace)}
+		if i := strings.LastIndex(n.name, "."); i >= 0 {
+			namespace = n.name[:i]
+		}
+		// Registered first, the records may reference themselves.
+		p.names[n.name] = n
+		return n, p.parseNamed(n, v, namespace)
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: kind, items: items}, nil
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: kind, values: values}, nil
+	default:
+		// A primitive type, possibly annotated with a logical type, or a nested definition.
+		return p.parse(v["type"], namespace)
+	}
+}
+
+func (p *parser) parseNamed(n *node, v map[string]interface{}, namespace string) error {
+	switch n.kind {
+	case "record", "error":
+		n.kind = "record"
+		fields, ok := v["fields"].([]interface{})
+		if !ok {
+			return fmt.Errorf("record %s without fields", n.name)
+		}
+		for _, f := range fields {
+			def, ok := f.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid field of record %s", n.name)
+			}
+			name, _ := def["name"].(string)
+			typ, err := p.parse(def["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("field %s of record %s: %w", name, n.name, err)
+			}
+			n.fields = append(n.fields, field{name: name, typ: typ})
+		}
+	case "enum":
+		symbols, ok := v["symbols"].([]interface{})
+		if !ok {
+			return fmt.Errorf("enum %s without symbols", n.name)
+		}
+		for _, s := range symbols {
+			symbol, _ := s.(string)
+			n.symbols = append(n.symbols, symbol)
+		}
+	case "fixed":
+		size, ok := v["size"].(float64)
+		if !ok || size < 0 {
+			return fmt.Errorf("fixed %s without size", n.name)
+		}
+		n.size = int(size)
+	}
+	return nil
+}
+
+func fullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// ToJSON transcodes data, encoded with the Avro binary encoding, to JSON. The records and maps
+// become objects, the unions their value without wrapping, and the bytes and fixed base64 strings.
+func (s *Schema) ToJSON(data []byte) ([]byte, error) {
+	d := decoder{in: data}
+	if err := d.decode(s.root); err != nil {
+		return nil, err
+	}
+	if len(d.in) != 0 {
+		return nil, fmt.Errorf("%d bytes left after the decoded value", len(d.in))
+	}
+	return d.out.Bytes(), nil
+}
+
+var errTruncated = errors.New("truncated data")
+
+// maxNullItems bounds the number of items of a block beyond the bytes left to decode.
+const maxNullItems = 1 << 16
+
+// decoder writes the JSON of the Avro data it reads.
+type decoder struct {
+	in  []byte
+	out bytes.Buffer
+}
+
+func (d *decoder) decode(n *node) error {
+	switch n.kind {
+	case "null":
+		d.out.WriteString("null")
+	case "boolean":
+		if len(d.in) < 1 {
+			return errTruncated
+		}
+		d.out.WriteString(strconv.FormatBool(d.in[0] != 0))
+		d.in = d.in[1:]
+	case "int", "long":
+		v, err := d.long()
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(strconv.FormatInt(v, 10))
+	case "float":
+		if len(d.in) < 4 {
+			return errTruncated
+		}
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(d.in)))
+		d.in = d.in[4:]
+		return d.float(v, 32)
+	case "double":
+		if len(d.in) < 8 {
+			return errTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.in))
+		d.in = d.in[8:]
+		return d.float(v, 64)
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		d.writeJSON(base64.StdEncoding.EncodeToString(b))
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		d.writeJSON(string(b))
+	case "fixed":
+		if len(d.in) < n.size {
+			return errTruncated
+		}
+		d.writeJSON(base64.StdEncoding.EncodeToString(d.in[:n.size]))
+		d.in = d.in[n.size:]
+	case "enum":
+		i, err := d.long()
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= int64(len(n.symbols)) {
+			return fmt.Errorf("invalid symbol %d of enum %s", i, n.name)
+		}
+		d.writeJSON(n.symbols[i])
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= int64(len(n.branches)) {
+			return fmt.Errorf("invalid union branch %d", i)
+		}
+		return d.decode(n.branches[i])
+	case "record":
+		d.out.WriteByte('{')
+		for i, f := range n.fields {
+			if i > 0 {
+				d.out.WriteByte(',')
+			}
+			d.writeJSON(f.name)
+			d.out.WriteByte(':')
+			if err := d.decode(f.typ); err != nil {
+				return err
+			}
+		}
+		d.out.WriteByte('}')
+	case "array":
+		d.out.WriteByte('[')
+		if err := d.blocks(func(first bool) error {
+			if !first {
+				d.out.WriteByte(',')
+			}
+			return d.decode(n.items)
+		}); err != nil {
+			return err
+		}
+		d.out.WriteByte(']')
+	case "map":
+		d.out.WriteByte('{')
+		if err := d.blocks(func(first bool) error {
+			if !first {
+				d.out.WriteByte(',')
+			}
+			key, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			d.writeJSON(string(key))
+			d.out.WriteByte(':')
+			return d.decode(n.values)
+		}); err != nil {
+			return err
+		}
+		d.out.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported type %q", n.kind)
+	}
+	return nil
+}
+
+// long reads a zigzag encoded variable-length integer, the encoding of the ints and longs.
+func (d *decoder) long() (int64, error) {
+	v, n := binary.Varint(d.in)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.in = d.in[n:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	size, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || size > int64(len(d.in)) {
+		return nil, errTruncated
+	}
+	b := d.in[:size]
+	d.in = d.in[size:]
+	return b, nil
+}
+
+// blocks reads the blocks of the items of an array or a map, calling item for each of them.
+func (d *decoder) blocks(item func(first bool) error) error {
+	first := true
+	for {
+		count, err := d.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// A negative count is followed by the size of the block in bytes.
+			count = -count
+			if _, err := d.long(); err != nil {
+				return err
+			}
+		}
+		// Every item but the nulls takes a byte at least, bounding the items of corrupted data.
+		if count > int64(len(d.in))+maxNullItems {
+			return errTruncated
+		}
+		for ; count > 0; count-- {
+			if err := item(first); err != nil {
+				return err
+			}
+			first = false
+		}
+	}
+}
+
+func (d *decoder) float(v float64, bitSize int) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%v cannot be represented in JSON", v)
+	}
+	d.out.WriteString(strconv.FormatFloat(v, 'g', -1, bitSize))
+	return nil
+}
+
+func (d *decoder) writeJSON(s string) {
+	b, _ := json.Marshal(s)
+	d.out.Write(b)
+}
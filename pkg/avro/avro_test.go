@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// encoder builds Avro binary data.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) long(v int64) *encoder {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.Write(b[:binary.PutVarint(b, v)])
+	return e
+}
+
+func (e *encoder) str(s string) *encoder {
+	e.long(int64(len(s)))
+	e.WriteString(s)
+	return e
+}
+
+func (e *encoder) double(v float64) *encoder {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	e.Write(b)
+	return e
+}
+
+func (e *encoder) raw(b ...byte) *encoder {
+	e.Write(b)
+	return e
+}
+
+const orderSchema = `{
+	"type": "record",
+	"name": "Order",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "id", "type": "string"},
+		{"name": "quantity", "type": "int"},
+		{"name": "price", "type": "double"},
+		{"name": "paid", "type": "boolean"},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "SHIPPED"]}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attributes", "type": {"type": "map", "values": "long"}},
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "checksum", "type": {"type": "fixed", "name": "MD5", "size": 2}},
+		{"name": "createdAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "parent", "type": ["null", "Order"]}
+	]
+}`
+
+func TestToJSON(t *testing.T) {
+	schema, err := ParseSchema(orderSchema)
+	if err != nil {
+		t.Fatalf("ParseSchema() = %v", err)
+	}
+
+	order := func(e *encoder) *encoder {
+		e.str("o-1").long(3).double(9.5).raw(1).long(1)
+		// Two blocks of tags, the second one with its size.
+		e.long(1).str("a").long(-1).long(2).str("b").long(0)
+		e.long(1).str("weight").long(-12).long(0)
+		e.long(1).str("fragile")
+		e.raw(0xca, 0xfe)
+		return e.long(1604224800000)
+	}
+	var data encoder
+	order(&data).long(1)
+	// The parent, without note nor parent.
+	data.str("o-0").long(1).double(1).raw(0).long(0).long(0).long(0).long(0).raw(0, 0).long(0).long(0)
+
+	got, err := schema.ToJSON(data.Bytes())
+	if err != nil {
+		t.Fatalf("ToJSON() = %v", err)
+	}
+	want := `{"id":"o-1","quantity":3,"price":9.5,"paid":true,"status":"SHIPPED","tags":["a","b"],` +
+		`"attributes":{"weight":-12},"note":"fragile","checksum":"yv4=","createdAt":1604224800000,` +
+		`"parent":{"id":"o-0","quantity":1,"price":1,"paid":false,"status":"NEW","tags":[],` +
+		`"attributes":{},"note":null,"checksum":"AAA=","createdAt":0,"parent":null}}`
+	if string(got) != want {
+		t.Errorf("ToJSON() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestToJSONInvalidData(t *testing.T) {
+	schema, err := ParseSchema(`{"type": "record", "name": "R", "fields": [
+		{"name": "s", "type": "string"},
+		{"name": "u", "type": ["null", "double"]},
+		{"name": "n", "type": {"type": "array", "items": "null"}}
+	]}`)
+	if err != nil {
+		t.Fatalf("ParseSchema() = %v", err)
+	}
+	testCases := map[string][]byte{
+		"truncated string": new(encoder).long(10).raw('a').Bytes(),
+		"invalid branch":   new(encoder).str("a").long(5).Bytes(),
+		"NaN":              new(encoder).str("a").long(1).double(math.NaN()).Bytes(),
+		"trailing bytes":   new(encoder).str("a").long(0).long(0).raw(1).Bytes(),
+		"too many items":   new(encoder).str("a").long(0).long(math.MaxInt32).long(0).Bytes(),
+	}
+	for n, data := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got, err := schema.ToJSON(data); err == nil {
+				t.Errorf("ToJSON() = %s, want an error", got)
+			}
+		})
+	}
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	testCases := map[string]string{
+		"not JSON":      `{`,
+		"unknown type":  `"Missing"`,
+		"record fields": `{"type": "record", "name": "R"}`,
+		"unnamed enum":  `{"type": "enum", "symbols": ["A"]}`,
+	}
+	for n, text := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if _, err := ParseSchema(text); err == nil {
+				t.Error("ParseSchema() succeeded, want an error")
+			}
+		})
+	}
+}
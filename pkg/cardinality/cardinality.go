@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cardinality builds the tags telling apart the resources a metric of the dispatcher is
+// recorded for, as finely as the cardinality mode set by the metrics.cardinality key of the
+// config-natss ConfigMap allows.
+package cardinality
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"go.opencensus.io/tag"
+	"knative.dev/pkg/metrics/metricskey"
+)
+
+// Mode is how finely the metrics tell the resources apart.
+type Mode string
+
+const (
+	// Full tags the metrics with the namespace, the channel and the subscription.
+	Full Mode = "full"
+	// Channel aggregates the metrics of the subscriptions of a channel, dropping their tag.
+	Channel Mode = "channel"
+	// Low only tags the metrics with the namespace.
+	Low Mode = "low"
+)
+
+// Default is the mode when none is configured.
+const Default = Full
+
+var (
+	// NamespaceKey is the namespace of the resource, kept by every mode.
+	NamespaceKey = tag.MustNewKey(metricskey.LabelNamespaceName)
+	// ChannelKey is the namespace/name of the channel, dropped by Low.
+	ChannelKey = tag.MustNewKey("channel")
+	// SubscriptionKey is the UID of the subscription, dropped by Channel and Low.
+	SubscriptionKey = tag.MustNewKey("subscription")
+)
+
+// current holds the Mode the tags are built with.
+var current atomic.Value
+
+func init() {
+	current.Store(Default)
+}
+
+// Parse returns the Mode named raw, Default when raw is empty.
+func Parse(raw string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "":
+		return Default, nil
+	case Full, Channel, Low:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown cardinality %q, expected one of %q, %q or %q", raw, Full, Channel, Low)
+	}
+}
+
+// Set replaces the mode the tags are built with from now on, an empty one restoring Default.
+func Set(mode Mode) {
+	if mode == "" {
+		mode = Default
+	}
+	current.Store(mode)
+}
+
+// Load returns the mode the tags are built with.
+func Load() Mode {
+	return current.Load().(Mode)
+}
+
+// Resource identifies what a metric is recorded for. The empty fields are not tagged.
+type Resource struct {
+	Namespace string
+	// Channel is the name of the channel, tagged with its namespace.
+	Channel      string
+	Subscription string
+}
+
+// Tags returns the mutators tagging r as finely as the current mode allows. The tags the mode
+// drops are deleted, so that the tags of a context are never recorded.
+func Tags(r Resource) []tag.Mutator {
+	mode := Load()
+	mutators := make([]tag.Mutator, 0, 3)
+	if r.Namespace != "" {
+		mutators = append(mutators, tag.Upsert(NamespaceKey, r.Namespace))
+	}
+	if r.Channel != "" && mode != Low {
+		mutators = append(mutators, tag.Upsert(ChannelKey, r.Namespace+"/"+r.Channel))
+	} else {
+		mutators = append(mutators, tag.Delete(ChannelKey))
+	}
+	if r.Subscription != "" && mode == Full {
+		mutators = append(mutators, tag.Upsert(SubscriptionKey, r.Subscription))
+	} else {
+		mutators = append(mutators, tag.Delete(SubscriptionKey))
+	}
+	return mutators
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cardinality
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/tag"
+)
+
+// tagsOf returns the tags of the context built with mutators.
+func tagsOf(t *testing.T, mutators ...tag.Mutator) map[string]string {
+	t.Helper()
+	ctx, err := tag.New(context.Background(), mutators...)
+	if err != nil {
+		t.Fatalf("tag.New() = %v", err)
+	}
+	tags := make(map[string]string)
+	for _, key := range []tag.Key{NamespaceKey, ChannelKey, SubscriptionKey} {
+		if value, ok := tag.FromContext(ctx).Value(key); ok {
+			tags[key.Name()] = value
+		}
+	}
+	return tags
+}
+
+func TestTags(t *testing.T) {
+	defer Set(Default)
+	subscription := Resource{Namespace: "ns", Channel: "orders", Subscription: "5a7e"}
+
+	testCases := map[Mode]map[string]string{
+		Full:    {"namespace_name": "ns", "channel": "ns/orders", "subscription": "5a7e"},
+		Channel: {"namespace_name": "ns", "channel": "ns/orders"},
+		Low:     {"namespace_name": "ns"},
+	}
+	for mode, want := range testCases {
+		t.Run(string(mode), func(t *testing.T) {
+			Set(mode)
+			if diff := cmp.Diff(want, tagsOf(t, Tags(subscription)...)); diff != "" {
+				t.Errorf("tags (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestTagsDropInherited(t *testing.T) {
+	defer Set(Default)
+	Set(Low)
+	// The tags dropped by the mode are removed from the context they are added to.
+	inherited := []tag.Mutator{tag.Insert(ChannelKey, "ns/orders"), tag.Insert(SubscriptionKey, "5a7e")}
+	got := tagsOf(t, append(inherited, Tags(Resource{Namespace: "ns", Channel: "orders"})...)...)
+	if diff := cmp.Diff(map[string]string{"namespace_name": "ns"}, got); diff != "" {
+		t.Errorf("tags (-want, +got) = %s", diff)
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := map[string]struct {
+		raw     string
+		want    Mode
+		wantErr bool
+	}{
+		"empty":   {raw: "", want: Full},
+		"channel": {raw: "channel", want: Channel},
+		"case":    {raw: " Low ", want: Low},
+		"unknown": {raw: "none", wantErr: true},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := Parse(tc.raw)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Parse() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("Parse() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
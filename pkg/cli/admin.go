@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+)
+
+// resyncPath is the path of the endpoint of the controller resyncing all the channels.
+const resyncPath = "/resync"
+
+// replay requests the replay of the events published since --from to a Subscription, through
+// the annotation the dispatcher watches.
+func (c *Command) replay(ctx context.Context, o *options, args []string) error {
+	if o.from == "" {
+		return fmt.Errorf("%w: --from is required", ErrUsage)
+	}
+	if _, err := time.Parse(time.RFC3339, o.from); err != nil {
+		return fmt.Errorf("%w: --from must be an RFC3339 time: %v", ErrUsage, err)
+	}
+	subscriptions := c.EventingClient.MessagingV1().Subscriptions(o.namespace)
+	sub, err := subscriptions.Get(ctx, args[0], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if sub.Spec.Channel.Kind != "NatssChannel" {
+		return fmt.Errorf("subscription %s/%s is not to a NatssChannel but to a %s", sub.Namespace, sub.Name, sub.Spec.Channel.Kind)
+	}
+	if sub.Annotations[messaging.ReplayedFromAnnotationKey] == o.from {
+		return fmt.Errorf("the events of subscription %s/%s were already replayed from %s", sub.Namespace, sub.Name, o.from)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{messaging.ReplayFromAnnotationKey: o.from},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := subscriptions.Patch(ctx, sub.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Replay of subscription %s/%s from %s requested, its events report the progress\n", sub.Namespace, sub.Name, o.from)
+	return nil
+}
+
+// resync makes the controller reconcile all the channels through its admin endpoint.
+func (c *Command) resync(ctx context.Context, o *options, _ []string) error {
+	controllerURL, err := requireURL(o.controllerURL, "controller-url")
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPost, controllerURL+resyncPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(c.Out, resp.Body)
+	return err
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+)
+
+func newTestSubscription(kind string, annotations map[string]string) *messagingv1.Subscription {
+	return &messagingv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "orders-sub", Annotations: annotations},
+		Spec: messagingv1.SubscriptionSpec{
+			Channel: corev1.ObjectReference{Kind: kind, Name: "orders", APIVersion: "messaging.knative.dev/v1beta1"},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	const from = "2020-11-01T09:00:00Z"
+	cmd, out := newTestCommand(newTestSubscription("NatssChannel", map[string]string{"team": "orders"}))
+
+	if err := cmd.Run(context.Background(), []string{"replay", "orders-sub", "--from", from}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	sub, err := cmd.EventingClient.MessagingV1().Subscriptions("default").Get(context.Background(), "orders-sub", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sub.Annotations[messaging.ReplayFromAnnotationKey]; got != from {
+		t.Errorf("replay-from annotation = %q, want %q", got, from)
+	}
+	if sub.Annotations["team"] != "orders" {
+		t.Error("the other annotations were not kept")
+	}
+	if !strings.Contains(out.String(), "requested") {
+		t.Errorf("output = %q, want the replay reported", out)
+	}
+}
+
+func TestReplayRefused(t *testing.T) {
+	const from = "2020-11-01T09:00:00Z"
+	testCases := map[string]*messagingv1.Subscription{
+		"other channel kind": newTestSubscription("InMemoryChannel", nil),
+		"already replayed":   newTestSubscription("NatssChannel", map[string]string{messaging.ReplayedFromAnnotationKey: from}),
+	}
+	for n, sub := range testCases {
+		t.Run(n, func(t *testing.T) {
+			cmd, _ := newTestCommand(sub)
+			if err := cmd.Run(context.Background(), []string{"replay", "orders-sub", "--from", from}); err == nil {
+				t.Error("Run() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	controllerAdmin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != resyncPath {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("3 channels enqueued\n"))
+	}))
+	defer controllerAdmin.Close()
+	cmd, out := newTestCommand()
+
+	if err := cmd.Run(context.Background(), []string{"resync", "--controller-url", controllerAdmin.URL}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if got := out.String(); got != "3 channels enqueued\n" {
+		t.Errorf("output = %q, want the answer of the controller", got)
+	}
+}
+
+func TestResyncUnavailable(t *testing.T) {
+	controllerAdmin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer controllerAdmin.Close()
+	cmd, _ := newTestCommand()
+
+	err := cmd.Run(context.Background(), []string{"resync", "--controller-url", controllerAdmin.URL})
+	if err == nil || !strings.Contains(err.Error(), "503 Service Unavailable: overloaded") {
+		t.Errorf("Run() = %v, want the error of the controller", err)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// orphansPath is the path of the endpoint of the dispatcher listing the orphaned durables.
+const orphansPath = "/debug/orphans"
+
+// channelSummary is a NatssChannel as listed.
+type channelSummary struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	Ready            string `json:"ready"`
+	Reason           string `json:"reason,omitempty"`
+	Subscribers      int    `json:"subscribers"`
+	ReadySubscribers int    `json:"readySubscribers"`
+	Address          string `json:"address,omitempty"`
+}
+
+// channelDetails is a NatssChannel as described.
+type channelDetails struct {
+	channelSummary
+	Distribution     v1beta1.Distribution `json:"distribution"`
+	Subject          string               `json:"subject"`
+	Conditions       []condition          `json:"conditions"`
+	Subscribers      []subscriberDetails  `json:"subscriberDetails"`
+	OrphanedDurables []orphanedDurable    `json:"orphanedDurables,omitempty"`
+}
+
+type condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type subscriberDetails struct {
+	UID            string `json:"uid"`
+	SubscriberURI  string `json:"subscriberURI,omitempty"`
+	ReplyURI       string `json:"replyURI,omitempty"`
+	DeadLetterSink string `json:"deadLetterSink,omitempty"`
+	Durable        string `json:"durable"`
+	Ready          string `json:"ready"`
+	Message        string `json:"message,omitempty"`
+}
+
+// orphanedDurable is a durable reported by the orphan audit of the dispatcher.
+type orphanedDurable struct {
+	Durable      string    `json:"durable"`
+	Channel      string    `json:"channel"`
+	MissingSince time.Time `json:"missingSince"`
+}
+
+// list lists the channels of the namespace, or of all the namespaces.
+func (c *Command) list(ctx context.Context, o *options, _ []string) error {
+	namespace := o.namespace
+	if o.allNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	channels, err := c.Client.MessagingV1beta1().NatssChannels(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	summaries := make([]channelSummary, 0, len(channels.Items))
+	for i := range channels.Items {
+		summaries = append(summaries, summarize(&channels.Items[i]))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Namespace != summaries[j].Namespace {
+			return summaries[i].Namespace < summaries[j].Namespace
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	rows := make([][]string, 0, len(summaries))
+	for _, s := range summaries {
+		rows = append(rows, []string{s.Namespace, s.Name, s.Ready, s.Reason,
+			fmt.Sprintf("%d/%d", s.ReadySubscribers, s.Subscribers), s.Address})
+	}
+	return c.print(o, summaries, []string{"NAMESPACE", "NAME", "READY", "REASON", "SUBSCRIBERS", "ADDRESS"}, rows)
+}
+
+func summarize(nc *v1beta1.NatssChannel) channelSummary {
+	s := channelSummary{
+		Namespace:   nc.Namespace,
+		Name:        nc.Name,
+		Ready:       string(corev1.ConditionUnknown),
+		Subscribers: len(nc.Spec.Subscribers),
+	}
+	if ready := nc.Status.GetCondition(apis.ConditionReady); ready != nil {
+		s.Ready, s.Reason = string(ready.Status), ready.Reason
+	}
+	for _, sub := range nc.Status.Subscribers {
+		if sub.Ready == corev1.ConditionTrue {
+			s.ReadySubscribers++
+		}
+	}
+	if nc.Status.Address != nil && nc.Status.Address.URL != nil {
+		s.Address = nc.Status.Address.URL.String()
+	}
+	return s
+}
+
+// describe shows a channel, its subscribers and, from the dispatcher, its orphaned durables.
+func (c *Command) describe(ctx context.Context, o *options, args []string) error {
+	nc, err := c.Client.MessagingV1beta1().NatssChannels(o.namespace).Get(ctx, args[0], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	d := channelDetails{
+		channelSummary: summarize(nc),
+		Distribution:   nc.Spec.Distribution,
+		Subject:        subject(nc),
+	}
+	if d.Distribution == "" {
+		d.Distribution = v1beta1.DistributionFanout
+	}
+	for _, cond := range nc.Status.Conditions {
+		d.Conditions = append(d.Conditions, condition{Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message})
+	}
+	statuses := make(map[string]int, len(nc.Status.Subscribers))
+	for i, status := range nc.Status.Subscribers {
+		statuses[string(status.UID)] = i
+	}
+	for _, sub := range nc.Spec.Subscribers {
+		details := subscriberDetails{
+			UID:           string(sub.UID),
+			SubscriberURI: sub.SubscriberURI.String(),
+			ReplyURI:      sub.ReplyURI.String(),
+			Durable:       dispatcher.ChannelDurableName(nc.Spec.Distribution, sub),
+			Ready:         string(corev1.ConditionUnknown),
+		}
+		if sub.Delivery != nil && sub.Delivery.DeadLetterSink != nil && sub.Delivery.DeadLetterSink.URI != nil {
+			details.DeadLetterSink = sub.Delivery.DeadLetterSink.URI.String()
+		}
+		if i, ok := statuses[string(sub.UID)]; ok {
+			details.Ready, details.Message = string(nc.Status.Subscribers[i].Ready), nc.Status.Subscribers[i].Message
+		}
+		d.Subscribers = append(d.Subscribers, details)
+	}
+
+	if o.dispatcherURL != "" {
+		var orphans []orphanedDurable
+		if err := c.getJSON(ctx, strings.TrimSuffix(o.dispatcherURL, "/")+orphansPath, &orphans); err != nil {
+			return err
+		}
+		for _, orphan := range orphans {
+			if orphan.Channel == nc.Namespace+"/"+nc.Name {
+				d.OrphanedDurables = append(d.OrphanedDurables, orphan)
+			}
+		}
+	}
+
+	if o.output == outputJSON {
+		return c.print(o, d, nil, nil)
+	}
+	return c.printDetails(d)
+}
+
+func (c *Command) printDetails(d channelDetails) error {
+	w := tabwriter.NewWriter(c.Out, 0, 4, 3, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", d.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", d.Namespace)
+	fmt.Fprintf(w, "Address:\t%s\n", d.Address)
+	fmt.Fprintf(w, "Distribution:\t%s\n", d.Distribution)
+	fmt.Fprintf(w, "Subject:\t%s\n", d.Subject)
+	fmt.Fprintln(w, "Conditions:")
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+	for _, cond := range d.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", cond.Type, cond.Status, cond.Reason, cond.Message)
+	}
+	fmt.Fprintf(w, "Subscribers:\t%d/%d ready\n", d.ReadySubscribers, d.channelSummary.Subscribers)
+	fmt.Fprintln(w, "  UID\tSUBSCRIBER\tDURABLE\tREADY\tMESSAGE")
+	for _, sub := range d.Subscribers {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", sub.UID, sub.SubscriberURI, sub.Durable, sub.Ready, sub.Message)
+	}
+	if len(d.OrphanedDurables) > 0 {
+		fmt.Fprintln(w, "Orphaned durables:")
+		fmt.Fprintln(w, "  DURABLE\tMISSING SINCE")
+		for _, orphan := range d.OrphanedDurables {
+			fmt.Fprintf(w, "  %s\t%s\n", orphan.Durable, orphan.MissingSince.Format(time.RFC3339))
+		}
+	}
+	return w.Flush()
+}
+
+// subject returns the NATSS subject of the events of nc.
+func subject(nc *v1beta1.NatssChannel) string {
+	return dispatcher.Subject(eventingchannels.ChannelReference{Namespace: nc.Namespace, Name: nc.Name})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestList(t *testing.T) {
+	notReady := newTestChannel("other", "failing")
+	notReady.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionFalse, Reason: "DispatcherNotReady"}}
+	cmd, out := newTestCommand(newTestChannel("default", "orders"), newTestChannel("default", "audit"), notReady)
+
+	if err := cmd.Run(context.Background(), []string{"list"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := `NAMESPACE   NAME     READY   REASON   SUBSCRIBERS   ADDRESS
+default     audit    True             1/2           http://audit-kn-channel.default.svc.cluster.local
+default     orders   True             1/2           http://orders-kn-channel.default.svc.cluster.local
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("list (-want, +got) = %s", diff)
+	}
+
+	// The flags may follow the arguments, kubectl style.
+	out.Reset()
+	if err := cmd.Run(context.Background(), []string{"list", "-A", "--output", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got []channelSummary
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	if len(got) != 3 || got[2].Namespace != "other" || got[2].Ready != "False" || got[2].Reason != "DispatcherNotReady" {
+		t.Errorf("list -A = %+v, want the channels of all the namespaces", got)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	nc := newTestChannel("default", "orders")
+	dispatcherAdmin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != orphansPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"durable":"orphaned","channel":"default/orders","missingSince":"2020-11-01T09:00:00Z"},` +
+			`{"durable":"other","channel":"default/other","missingSince":"2020-11-01T09:00:00Z"}]`))
+	}))
+	defer dispatcherAdmin.Close()
+	cmd, out := newTestCommand(nc)
+
+	if err := cmd.Run(context.Background(), []string{"describe", "orders", "--dispatcher-url", dispatcherAdmin.URL, "-o", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got channelDetails
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	if got.Subject != "orders.default" || got.Distribution != v1beta1.DistributionFanout {
+		t.Errorf("subject, distribution = %s, %s, want orders.default, fanout", got.Subject, got.Distribution)
+	}
+	wantSubscribers := []subscriberDetails{
+		{UID: readyUID, SubscriberURI: "http://ready.example.com", Durable: readyUID, Ready: "True"},
+		{UID: notReadyUID, SubscriberURI: "http://failing.example.com", Durable: notReadyUID, Ready: "False", Message: "subscription failed"},
+	}
+	if diff := cmp.Diff(wantSubscribers, got.Subscribers); diff != "" {
+		t.Errorf("subscribers (-want, +got) = %s", diff)
+	}
+	if len(got.OrphanedDurables) != 1 || got.OrphanedDurables[0].Durable != "orphaned" {
+		t.Errorf("orphaned durables = %+v, want those of the channel", got.OrphanedDurables)
+	}
+
+	// The members of a work queue share the durable of the queue group.
+	nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+	cmd, out = newTestCommand(nc)
+	if err := cmd.Run(context.Background(), []string{"describe", "-n", "default", "orders"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	for _, want := range []string{"Distribution:   workqueue", notReadyUID + "   http://failing.example.com   workqueue   False   subscription failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("describe = %s, want it to contain %q", out, want)
+		}
+	}
+
+	if err := cmd.Run(context.Background(), []string{"describe", "missing"}); err == nil {
+		t.Error("describe succeeded for a missing channel")
+	}
+}
+
+func TestDescribeDispatcherUnavailable(t *testing.T) {
+	dispatcherAdmin := httptest.NewServer(http.NotFoundHandler())
+	defer dispatcherAdmin.Close()
+	cmd, _ := newTestCommand(newTestChannel("default", "orders"))
+	err := cmd.Run(context.Background(), []string{"describe", "orders", "--dispatcher-url", dispatcherAdmin.URL})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Run() = %v, want the error of the dispatcher", err)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cli implements kubectl-natss, the kubectl plugin inspecting and operating the
+// NatssChannels through the Kubernetes API, the admin endpoints of the controller and the
+// dispatcher, and the monitoring endpoint of NATSS.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+
+	eventingclientset "knative.dev/eventing/pkg/client/clientset/versioned"
+
+	clientset "knative.dev/eventing-natss/pkg/client/clientset/versioned"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// ErrUsage is returned, wrapped, for the invalid command lines, after the usage is printed.
+var ErrUsage = errors.New("invalid usage")
+
+// Command runs the subcommands of kubectl-natss.
+type Command struct {
+	// Client and EventingClient talk to the Kubernetes API.
+	Client         clientset.Interface
+	EventingClient eventingclientset.Interface
+	// HTTPClient talks to the admin endpoints and to the monitoring endpoint of NATSS.
+	HTTPClient *http.Client
+	// Namespace is the namespace of the commands without --namespace.
+	Namespace string
+	// Out receives the output of the commands, and Err their usage.
+	Out io.Writer
+	Err io.Writer
+}
+
+// options are the flags of the subcommands, each subcommand declaring those it reads.
+type options struct {
+	namespace     string
+	allNamespaces bool
+	output        string
+	controllerURL string
+	dispatcherURL string
+	monitoringURL string
+	from          string
+}
+
+// subcommand is a subcommand of kubectl-natss.
+type subcommand struct {
+	name string
+	// args describes the positional arguments of the subcommand, for the usage.
+	args    string
+	summary string
+	// flags declares the flags of the subcommand on fs.
+	flags func(fs *flag.FlagSet, o *options)
+	// nargs is the number of positional arguments of the subcommand.
+	nargs int
+	run   func(c *Command, ctx context.Context, o *options, args []string) error
+}
+
+var subcommands = []subcommand{{
+	name:    "list",
+	summary: "List the NatssChannels with their readiness and subscribers",
+	flags:   withFlags(namespaceFlags, outputFlags),
+	run:     (*Command).list,
+}, {
+	name:    "describe",
+	args:    "CHANNEL",
+	summary: "Show a NatssChannel, its subscribers and their durables, and its orphaned durables with --dispatcher-url",
+	flags:   withFlags(namespaceFlags, outputFlags, dispatcherFlags),
+	nargs:   1,
+	run:     (*Command).describe,
+}, {
+	name:    "lag",
+	args:    "CHANNEL",
+	summary: "Show how far behind the subscribers of a NatssChannel are, from the monitoring endpoint of NATSS",
+	flags:   withFlags(namespaceFlags, outputFlags, monitoringFlags),
+	nargs:   1,
+	run:     (*Command).lag,
+}, {
+	name:    "replay",
+	args:    "SUBSCRIPTION",
+	summary: "Replay the events published since --from to a subscription",
+	flags: withFlags(namespaceFlags, func(fs *flag.FlagSet, o *options) {
+		fs.StringVar(&o.from, "from", "", "RFC3339 time to replay the events from")
+	}),
+	nargs: 1,
+	run:   (*Command).replay,
+}, {
+	name:    "plan",
+	args:    "CHANNEL FILE",
+	summary: "Show what the dispatcher does to the subscriptions of a NatssChannel updated to the one of FILE",
+	flags:   withFlags(namespaceFlags, outputFlags),
+	nargs:   2,
+	run:     (*Command).plan,
+}, {
+	name:    "resync",
+	summary: "Make the controller reconcile all the NatssChannels",
+	flags:   withFlags(controllerFlags),
+	run:     (*Command).resync,
+}}
+
+func withFlags(declare ...func(*flag.FlagSet, *options)) func(*flag.FlagSet, *options) {
+	return func(fs *flag.FlagSet, o *options) {
+		for _, d := range declare {
+			d(fs, o)
+		}
+	}
+}
+
+func namespaceFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.namespace, "namespace", "", "namespace of the resources")
+	fs.StringVar(&o.namespace, "n", "", "shorthand for --namespace")
+}
+
+func outputFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.output, "output", outputTable, "output format: table or json")
+	fs.StringVar(&o.output, "o", outputTable, "shorthand for --output")
+	if fs.Name() == "list" {
+		fs.BoolVar(&o.allNamespaces, "all-namespaces", false, "list the channels of all the namespaces")
+		fs.BoolVar(&o.allNamespaces, "A", false, "shorthand for --all-namespaces")
+	}
+}
+
+func controllerFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.controllerURL, "controller-url", "", "URL of the admin endpoint of the controller, for example http://localhost:8081 through kubectl port-forward")
+}
+
+func dispatcherFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.dispatcherURL, "dispatcher-url", "", "URL of the admin endpoint of the dispatcher, for example http://localhost:8081 through kubectl port-forward")
+}
+
+func monitoringFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.monitoringURL, "monitoring-url", "", "URL of the monitoring endpoint of NATSS, for example http://localhost:8222 through kubectl port-forward")
+}
+
+// Run runs the subcommand of args, the command line without the name of the program.
+func (c *Command) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage()
+		if len(args) == 0 {
+			return fmt.Errorf("%w: no command", ErrUsage)
+		}
+		return nil
+	}
+	for _, sub := range subcommands {
+		if sub.name != args[0] {
+			continue
+		}
+		o := &options{output: outputTable}
+		fs := flag.NewFlagSet(sub.name, flag.ContinueOnError)
+		fs.SetOutput(c.Err)
+		sub.flags(fs, o)
+		positional, err := parseInterspersed(fs, args[1:])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUsage, err)
+		}
+		if len(positional) != sub.nargs {
+			fmt.Fprintf(c.Err, "Usage: kubectl natss %s %s [flags]\n", sub.name, sub.args)
+			fs.PrintDefaults()
+			return fmt.Errorf("%w: %s expects %d arguments, got %d", ErrUsage, sub.name, sub.nargs, len(positional))
+		}
+		if o.output != outputTable && o.output != outputJSON {
+			return fmt.Errorf("%w: unknown output format %q", ErrUsage, o.output)
+		}
+		if o.namespace == "" {
+			o.namespace = c.Namespace
+		}
+		return sub.run(c, ctx, o, positional)
+	}
+	c.usage()
+	return fmt.Errorf("%w: unknown command %q", ErrUsage, args[0])
+}
+
+func (c *Command) usage() {
+	fmt.Fprintln(c.Err, "Usage: kubectl natss COMMAND [flags]")
+	fmt.Fprintln(c.Err, "\nCommands:")
+	w := tabwriter.NewWriter(c.Err, 0, 4, 2, ' ', 0)
+	for _, sub := range subcommands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", sub.name, sub.args, sub.summary)
+	}
+	w.Flush()
+}
+
+// parseInterspersed parses the flags of args wherever they are, kubectl style, returning the
+// positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// print writes v as indented JSON, or the rows under header as a table.
+func (c *Command) print(o *options, v interface{}, header []string, rows [][]string) error {
+	if o.output == outputJSON {
+		enc := json.NewEncoder(c.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.Out, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// getJSON decodes into v the JSON answered by url to a GET.
+func (c *Command) getJSON(ctx context.Context, url string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode the response of %s: %w", url, err)
+	}
+	return nil
+}
+
+// do sends a request without body to url, failing unless it answers 2xx.
+func (c *Command) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// requireURL fails when the URL of the endpoint named by flag is not set.
+func requireURL(url, flag string) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("%w: --%s is required", ErrUsage, flag)
+	}
+	return strings.TrimSuffix(url, "/"), nil
+}
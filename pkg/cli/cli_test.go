@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingfake "knative.dev/eventing/pkg/client/clientset/versioned/fake"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/client/clientset/versioned/fake"
+)
+
+const (
+	readyUID    = "11111111-1111-1111-1111-111111111111"
+	notReadyUID = "22222222-2222-2222-2222-222222222222"
+)
+
+// newTestCommand returns a Command over fake clients holding objs, and its output.
+func newTestCommand(objs ...runtime.Object) (*Command, *bytes.Buffer) {
+	var natssObjs, eventingObjs []runtime.Object
+	for _, obj := range objs {
+		if _, ok := obj.(*v1beta1.NatssChannel); ok {
+			natssObjs = append(natssObjs, obj)
+		} else {
+			eventingObjs = append(eventingObjs, obj)
+		}
+	}
+	out := &bytes.Buffer{}
+	return &Command{
+		Client:         fake.NewSimpleClientset(natssObjs...),
+		EventingClient: eventingfake.NewSimpleClientset(eventingObjs...),
+		Namespace:      "default",
+		Out:            out,
+		Err:            &bytes.Buffer{},
+	}, out
+}
+
+// newTestChannel returns a ready NatssChannel with a ready subscriber and one which is not.
+func newTestChannel(namespace, name string) *v1beta1.NatssChannel {
+	nc := &v1beta1.NatssChannel{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	nc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{
+		{UID: readyUID, SubscriberURI: apis.HTTP("ready.example.com")},
+		{UID: notReadyUID, SubscriberURI: apis.HTTP("failing.example.com")},
+	}
+	nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{
+		{UID: readyUID, Ready: corev1.ConditionTrue},
+		{UID: notReadyUID, Ready: corev1.ConditionFalse, Message: "subscription failed"},
+	}
+	nc.Status.Conditions = duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}}
+	nc.Status.Address = &duckv1.Addressable{URL: apis.HTTP(name + "-kn-channel." + namespace + ".svc.cluster.local")}
+	return nc
+}
+
+func TestRunUsage(t *testing.T) {
+	testCases := map[string][]string{
+		"no command":          nil,
+		"unknown command":     {"purge"},
+		"missing argument":    {"describe"},
+		"extra argument":      {"list", "extra"},
+		"unknown flag":        {"list", "--unknown"},
+		"unknown output":      {"list", "-o", "yaml"},
+		"missing url":         {"resync"},
+		"missing replay time": {"replay", "sub"},
+		"invalid replay time": {"replay", "sub", "--from", "yesterday"},
+	}
+	for n, args := range testCases {
+		t.Run(n, func(t *testing.T) {
+			cmd, _ := newTestCommand()
+			if err := cmd.Run(context.Background(), args); !errors.Is(err, ErrUsage) {
+				t.Errorf("Run(%q) = %v, want a usage error", args, err)
+			}
+		})
+	}
+
+	cmd, _ := newTestCommand()
+	if err := cmd.Run(context.Background(), []string{"help"}); err != nil {
+		t.Errorf("Run(help) = %v", err)
+	}
+	if usage := cmd.Err.(*bytes.Buffer).String(); !strings.Contains(usage, "describe CHANNEL") {
+		t.Errorf("usage = %q, want the commands listed", usage)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// channelszPath is the path of the monitoring endpoint of NATSS describing its channels.
+const channelszPath = "/streaming/channelsz"
+
+const (
+	lagStateOnline  = "online"
+	lagStateOffline = "offline"
+	lagStateStalled = "stalled"
+	lagStateMissing = "missing"
+)
+
+// channelz is the description of a channel by the monitoring endpoint of NATSS.
+type channelz struct {
+	Name          string          `json:"name"`
+	Msgs          int             `json:"msgs"`
+	FirstSeq      uint64          `json:"first_seq"`
+	LastSeq       uint64          `json:"last_seq"`
+	Subscriptions []subscriptionz `json:"subscriptions"`
+}
+
+// subscriptionz is the description of a subscription by the monitoring endpoint of NATSS.
+type subscriptionz struct {
+	ClientID     string `json:"client_id"`
+	DurableName  string `json:"durable_name"`
+	QueueName    string `json:"queue_name"`
+	IsDurable    bool   `json:"is_durable"`
+	IsOffline    bool   `json:"is_offline"`
+	IsStalled    bool   `json:"is_stalled"`
+	LastSent     uint64 `json:"last_sent"`
+	PendingCount int    `json:"pending_count"`
+}
+
+// channelLag is how far behind the subscribers of a channel are.
+type channelLag struct {
+	Namespace   string          `json:"namespace"`
+	Name        string          `json:"name"`
+	Subject     string          `json:"subject"`
+	LastSeq     uint64          `json:"lastSequence"`
+	Subscribers []subscriberLag `json:"subscribers"`
+}
+
+// subscriberLag is how far behind a subscriber is: the events published after the last one
+// sent to its durable, and those sent but not acknowledged yet.
+type subscriberLag struct {
+	UID      string `json:"uid"`
+	Durable  string `json:"durable"`
+	State    string `json:"state"`
+	LastSent uint64 `json:"lastSent"`
+	Pending  int    `json:"pending"`
+	Lag      uint64 `json:"lag"`
+}
+
+// lag shows how far behind the subscribers of a channel are, from the monitoring endpoint of
+// NATSS. The members of a work queue share the figures of their queue group.
+func (c *Command) lag(ctx context.Context, o *options, args []string) error {
+	monitoringURL, err := requireURL(o.monitoringURL, "monitoring-url")
+	if err != nil {
+		return err
+	}
+	nc, err := c.Client.MessagingV1beta1().NatssChannels(o.namespace).Get(ctx, args[0], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var z channelz
+	if err := c.getJSON(ctx, fmt.Sprintf("%s%s?channel=%s&subs=1", monitoringURL, channelszPath, url.QueryEscape(subject(nc))), &z); err != nil {
+		return err
+	}
+
+	l := channelLag{Namespace: nc.Namespace, Name: nc.Name, Subject: subject(nc), LastSeq: z.LastSeq}
+	rows := make([][]string, 0, len(nc.Spec.Subscribers))
+	for _, sub := range nc.Spec.Subscribers {
+		// The durable of the latest naming scheme found takes precedence, the one a durable being
+		// migrated moves to.
+		durable := dispatcher.ChannelDurableName(nc.Spec.Distribution, sub)
+		var members []subscriptionz
+		for _, name := range dispatcher.ChannelDurableNames(nc.Spec.Distribution, sub) {
+			if found := durableSubscriptions(z.Subscriptions, nc.Spec.Distribution, name); len(found) > 0 {
+				durable, members = name, found
+			}
+		}
+		s := subscriberLag{UID: string(sub.UID), Durable: durable, State: lagStateMissing}
+		if len(members) > 0 {
+			s.State = lagStateOffline
+			for _, m := range members {
+				if m.LastSent > s.LastSent {
+					s.LastSent = m.LastSent
+				}
+				s.Pending += m.PendingCount
+				switch {
+				case m.IsStalled:
+					s.State = lagStateStalled
+				case !m.IsOffline && s.State == lagStateOffline:
+					s.State = lagStateOnline
+				}
+			}
+			if z.LastSeq > s.LastSent {
+				s.Lag = z.LastSeq - s.LastSent
+			}
+		}
+		l.Subscribers = append(l.Subscribers, s)
+		rows = append(rows, []string{s.UID, s.Durable, s.State, strconv.FormatUint(s.LastSent, 10),
+			strconv.Itoa(s.Pending), strconv.FormatUint(s.Lag, 10)})
+	}
+	return c.print(o, l, []string{"SUBSCRIBER", "DURABLE", "STATE", "LAST SENT", "PENDING", "LAG"}, rows)
+}
+
+// durableSubscriptions returns the subscriptions of the durable, the members of the queue group
+// of a work queue, whose queue name is prefixed by the durable name.
+func durableSubscriptions(subs []subscriptionz, distribution v1beta1.Distribution, durable string) []subscriptionz {
+	var found []subscriptionz
+	for _, sub := range subs {
+		if distribution == v1beta1.DistributionWorkQueue {
+			if strings.HasPrefix(sub.QueueName, durable+":") {
+				found = append(found, sub)
+			}
+		} else if sub.QueueName == "" && sub.DurableName == durable {
+			found = append(found, sub)
+		}
+	}
+	return found
+}
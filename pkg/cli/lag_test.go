@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// newMonitoringServer serves z on the monitoring endpoint of NATSS for its channel.
+func newMonitoringServer(t *testing.T, z channelz) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != channelszPath || r.URL.Query().Get("channel") != z.Name || r.URL.Query().Get("subs") != "1" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(z)
+	}))
+}
+
+func TestLag(t *testing.T) {
+	monitoring := newMonitoringServer(t, channelz{
+		Name:    "orders.default",
+		LastSeq: 100,
+		Subscriptions: []subscriptionz{
+			{DurableName: readyUID, IsDurable: true, LastSent: 100},
+			{DurableName: notReadyUID, IsDurable: true, LastSent: 40, PendingCount: 10, IsStalled: true},
+			// Another client using the same durable name in a queue group is not counted.
+			{DurableName: readyUID, QueueName: readyUID + ":other", LastSent: 1},
+		},
+	})
+	defer monitoring.Close()
+	cmd, out := newTestCommand(newTestChannel("default", "orders"))
+
+	if err := cmd.Run(context.Background(), []string{"lag", "orders", "--monitoring-url", monitoring.URL + "/"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := `SUBSCRIBER                             DURABLE                                STATE     LAST SENT   PENDING   LAG
+11111111-1111-1111-1111-111111111111   11111111-1111-1111-1111-111111111111   online    100         0         0
+22222222-2222-2222-2222-222222222222   22222222-2222-2222-2222-222222222222   stalled   40          10        60
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("lag (-want, +got) = %s", diff)
+	}
+}
+
+func TestLagWorkQueue(t *testing.T) {
+	nc := newTestChannel("default", "orders")
+	nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+	monitoring := newMonitoringServer(t, channelz{
+		Name:    "orders.default",
+		LastSeq: 100,
+		Subscriptions: []subscriptionz{
+			{QueueName: "workqueue:orders.default", IsDurable: true, IsOffline: true, LastSent: 80, PendingCount: 2},
+			{QueueName: "workqueue:orders.default", IsDurable: true, LastSent: 90, PendingCount: 3},
+		},
+	})
+	defer monitoring.Close()
+	cmd, out := newTestCommand(nc)
+
+	if err := cmd.Run(context.Background(), []string{"lag", "orders", "--monitoring-url", monitoring.URL, "-o", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got channelLag
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	// The members of the queue group share its figures.
+	member := subscriberLag{Durable: "workqueue", State: lagStateOnline, LastSent: 90, Pending: 5, Lag: 10}
+	want := []subscriberLag{member, member}
+	want[0].UID, want[1].UID = readyUID, notReadyUID
+	if diff := cmp.Diff(want, got.Subscribers); diff != "" {
+		t.Errorf("lag (-want, +got) = %s", diff)
+	}
+}
+
+func TestLagNamingSchemes(t *testing.T) {
+	monitoring := newMonitoringServer(t, channelz{
+		Name:    "orders.default",
+		LastSeq: 100,
+		Subscriptions: []subscriptionz{
+			{DurableName: "v2-" + readyUID, IsDurable: true, LastSent: 100},
+			// The durable being migrated from is drained while the new one delivers the next events.
+			{DurableName: notReadyUID, IsDurable: true, LastSent: 40, PendingCount: 10},
+			{DurableName: "v2-" + notReadyUID, IsDurable: true, LastSent: 90, PendingCount: 5},
+		},
+	})
+	defer monitoring.Close()
+	cmd, out := newTestCommand(newTestChannel("default", "orders"))
+
+	if err := cmd.Run(context.Background(), []string{"lag", "orders", "--monitoring-url", monitoring.URL, "-o", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got channelLag
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	want := []subscriberLag{
+		{UID: readyUID, Durable: "v2-" + readyUID, State: lagStateOnline, LastSent: 100},
+		{UID: notReadyUID, Durable: "v2-" + notReadyUID, State: lagStateOnline, LastSent: 90, Pending: 5, Lag: 10},
+	}
+	if diff := cmp.Diff(want, got.Subscribers); diff != "" {
+		t.Errorf("lag (-want, +got) = %s", diff)
+	}
+}
+
+func TestLagMissingDurable(t *testing.T) {
+	monitoring := newMonitoringServer(t, channelz{Name: "orders.default", LastSeq: 100})
+	defer monitoring.Close()
+	cmd, out := newTestCommand(newTestChannel("default", "orders"))
+
+	if err := cmd.Run(context.Background(), []string{"lag", "orders", "--monitoring-url", monitoring.URL, "-o", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got channelLag
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	for _, sub := range got.Subscribers {
+		if sub.State != lagStateMissing {
+			t.Errorf("state of %s = %s, want %s", sub.UID, sub.State, lagStateMissing)
+		}
+	}
+}
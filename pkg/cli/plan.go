@@ -0,0 +1,61 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+// plan shows what the dispatcher does to the subscriptions of a channel when it is updated to
+// the channel of a YAML or JSON file, without updating it.
+func (c *Command) plan(ctx context.Context, o *options, args []string) error {
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	changed := &v1beta1.NatssChannel{}
+	if err := utilyaml.NewYAMLOrJSONDecoder(f, 4096).Decode(changed); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", args[1], err)
+	}
+	if changed.Name != "" && changed.Name != args[0] {
+		return fmt.Errorf("%s holds the channel %q, not %q", args[1], changed.Name, args[0])
+	}
+
+	current, err := c.Client.MessagingV1beta1().NatssChannels(o.namespace).Get(ctx, args[0], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	plan := planner.ForUpdate(current, changed)
+	if plan == nil {
+		// Printed as an empty list.
+		plan = planner.Plan{}
+	}
+	rows := make([][]string, 0, len(plan))
+	for _, step := range plan {
+		rows = append(rows, []string{string(step.Action), string(step.UID), step.Reason})
+	}
+	return c.print(o, plan, []string{"ACTION", "SUBSCRIBER", "REASON"}, rows)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "orders.yaml")
+	changed := `apiVersion: messaging.knative.dev/v1beta1
+kind: NatssChannel
+metadata:
+  name: orders
+spec:
+  subscribers:
+  - uid: ` + readyUID + `
+    subscriberUri: http://ready.example.com
+    generation: 2
+  - uid: 33333333-3333-3333-3333-333333333333
+    subscriberUri: http://new.example.com
+`
+	if err := ioutil.WriteFile(file, []byte(changed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd, out := newTestCommand(newTestChannel("default", "orders"))
+
+	if err := cmd.Run(context.Background(), []string{"plan", "orders", file}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := `ACTION        SUBSCRIBER                             REASON
+keep          11111111-1111-1111-1111-111111111111   ` + planner.ReasonNotApplied + `
+subscribe     33333333-3333-3333-3333-333333333333   ` + planner.ReasonAdded + `
+unsubscribe   22222222-2222-2222-2222-222222222222   ` + planner.ReasonRemoved + `
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("plan (-want, +got) = %s", diff)
+	}
+
+	out.Reset()
+	if err := cmd.Run(context.Background(), []string{"plan", "orders", file, "-o", "json"}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	var got []planner.Step
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", out, err)
+	}
+	if len(got) != 3 || got[2].Action != planner.Unsubscribe || got[2].UID != notReadyUID {
+		t.Errorf("plan -o json = %+v", got)
+	}
+
+	// The file must hold the channel planned.
+	if err := cmd.Run(context.Background(), []string{"plan", "audit", file}); err == nil {
+		t.Error("Run() succeeded with the file of another channel")
+	}
+}
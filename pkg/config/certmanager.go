@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// CertManagerEnabledKey is the ConfigMap key making the controller issue the certificates of
+	// the webhook and of the receiver of the dispatcher with cert-manager.
+	CertManagerEnabledKey = "cert-manager.enabled"
+
+	// CertManagerIssuerNameKey and CertManagerIssuerKindKey are the ConfigMap keys referencing
+	// the cert-manager issuer of the certificates, an Issuer of the system namespace or a
+	// ClusterIssuer.
+	CertManagerIssuerNameKey = "cert-manager.issuer-name"
+	CertManagerIssuerKindKey = "cert-manager.issuer-kind"
+
+	// CertManagerIssuer and CertManagerClusterIssuer are the kinds of the cert-manager issuers.
+	CertManagerIssuer        = "Issuer"
+	CertManagerClusterIssuer = "ClusterIssuer"
+
+	// CertManagerReceiverDir is the directory the controller mounts the receiver certificate
+	// issued by cert-manager into the dispatcher at.
+	CertManagerReceiverDir = "/etc/natss/receiver-tls"
+
+	// CACertKey is the key of the certificate authority in the Secrets issued by cert-manager.
+	CACertKey = "ca.crt"
+)
+
+// CertManager configures the certificates issued by cert-manager.
+type CertManager struct {
+	// Enabled makes the controller issue the certificates with cert-manager, instead of the
+	// webhook generating its own and the receiver serving the files of the Security settings.
+	Enabled bool
+
+	// IssuerName and IssuerKind reference the issuer of the certificates.
+	IssuerName string
+	IssuerKind string
+}
+
+func (c CertManager) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.IssuerName == "" {
+		return fmt.Errorf("%q is required by %q", CertManagerIssuerNameKey, CertManagerEnabledKey)
+	}
+	if c.IssuerKind != CertManagerIssuer && c.IssuerKind != CertManagerClusterIssuer {
+		return fmt.Errorf("%q must be %s or %s, got %q", CertManagerIssuerKindKey, CertManagerIssuer, CertManagerClusterIssuer, c.IssuerKind)
+	}
+	return nil
+}
+
+// WithReceiverCertificate returns s serving the receiver certificate issued by cert-manager in
+// dir, and trusting its certificate authority, when cert-manager is enabled, s has no receiver
+// certificate of its own and the certificate is mounted. The receiver serves plain HTTP until
+// the controller mounts the certificate, once issued, restarting the dispatcher.
+func (c CertManager) WithReceiverCertificate(s security.Config, dir string) (security.Config, error) {
+	if !c.Enabled || s.ReceiverCertFile != "" {
+		return s, nil
+	}
+	certFile := filepath.Join(dir, corev1.TLSCertKey)
+	if _, err := os.Stat(certFile); os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, fmt.Errorf("failed to read the receiver certificate: %w", err)
+	}
+	s.ReceiverCertFile = certFile
+	s.ReceiverKeyFile = filepath.Join(dir, corev1.TLSPrivateKeyKey)
+	if s.ReceiverCACerts == "" {
+		ca, err := ioutil.ReadFile(filepath.Join(dir, CACertKey))
+		if err != nil && !os.IsNotExist(err) {
+			return s, fmt.Errorf("failed to read the receiver certificate authority: %w", err)
+		}
+		s.ReceiverCACerts = string(ca)
+	}
+	return s, nil
+}
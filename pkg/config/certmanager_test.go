@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+func TestCertManagerWithReceiverCertificate(t *testing.T) {
+	issued, err := ioutil.TempDir("", "receiver-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(issued) })
+	for key, value := range map[string]string{corev1.TLSCertKey: "cert", corev1.TLSPrivateKeyKey: "key", CACertKey: "ca"} {
+		if err := ioutil.WriteFile(filepath.Join(issued, key), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enabled := CertManager{Enabled: true, IssuerName: "natss-ca", IssuerKind: CertManagerIssuer}
+	own := security.Config{ReceiverCertFile: "tls.crt", ReceiverKeyFile: "tls.key"}
+
+	testCases := map[string]struct {
+		certManager CertManager
+		security    security.Config
+		dir         string
+		want        security.Config
+	}{
+		"disabled": {
+			dir: issued,
+		},
+		"issued": {
+			certManager: enabled,
+			dir:         issued,
+			want: security.Config{
+				ReceiverCertFile: filepath.Join(issued, corev1.TLSCertKey),
+				ReceiverKeyFile:  filepath.Join(issued, corev1.TLSPrivateKeyKey),
+				ReceiverCACerts:  "ca",
+			},
+		},
+		"issued with certificate authorities": {
+			certManager: enabled,
+			security:    security.Config{ReceiverCACerts: "bundle"},
+			dir:         issued,
+			want: security.Config{
+				ReceiverCertFile: filepath.Join(issued, corev1.TLSCertKey),
+				ReceiverKeyFile:  filepath.Join(issued, corev1.TLSPrivateKeyKey),
+				ReceiverCACerts:  "bundle",
+			},
+		},
+		"not mounted yet": {
+			certManager: enabled,
+			dir:         filepath.Join(issued, "missing"),
+		},
+		"own receiver certificate": {
+			certManager: enabled,
+			security:    own,
+			dir:         issued,
+			want:        own,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := tc.certManager.WithReceiverCertificate(tc.security, tc.dir)
+			if err != nil {
+				t.Fatalf("WithReceiverCertificate() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected security configuration (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,1412 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/cardinality"
+	"knative.dev/eventing-natss/pkg/features"
+	"knative.dev/eventing-natss/pkg/security"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap holding the NATSS channel configuration.
+	ConfigMapName = "config-natss"
+
+	// TransportKey is the ConfigMap key selecting the transport used by the dispatcher.
+	TransportKey = "transport"
+
+	// DefaultTransport is the transport used when none is configured.
+	DefaultTransport = "stan"
+
+	// PersistHostMapKey is the ConfigMap key enabling the persistence of the dispatcher host to
+	// channel map, so that a restarted dispatcher serves traffic before its informers are synced.
+	PersistHostMapKey = "persist-host-map"
+
+	// ResponseCodePolicyKey is the ConfigMap key holding the cluster default response code policy,
+	// formatted as comma separated <code or class>=<action> entries.
+	ResponseCodePolicyKey = "response-code-policy"
+
+	// OrphanAuditIntervalKey is the ConfigMap key setting how often the dispatcher audits the
+	// durables of the deleted channels and subscribers, zero disables the audit.
+	OrphanAuditIntervalKey = "orphan-audit-interval"
+
+	// OrphanAuditGracePeriodKey is the ConfigMap key setting how long a durable must be absent
+	// from the cluster before it may be deleted.
+	OrphanAuditGracePeriodKey = "orphan-audit-grace-period"
+
+	// DefaultOrphanAuditGracePeriod is the grace period used when none is configured.
+	DefaultOrphanAuditGracePeriod = 7 * 24 * time.Hour
+
+	// DurableNamingSchemeKey is the ConfigMap key setting the scheme the durables of the new
+	// subscriptions are named with, "v1" naming them after their UID and "v2" prefixing it with
+	// the scheme.
+	DurableNamingSchemeKey = "durable-naming-scheme"
+
+	// DurableNamingV1 and DurableNamingV2 are the durable naming schemes, DurableNamingV1 being
+	// used when none is configured.
+	DurableNamingV1 = "v1"
+	DurableNamingV2 = "v2"
+
+	// ControllerResyncPeriodKey is the ConfigMap key setting how often the controller reconciles
+	// all the channels, zero leaving it to the informer resync.
+	ControllerResyncPeriodKey = "controller-resync-period"
+
+	// ControllerNotReadyResyncPeriodKey is the ConfigMap key setting how often the controller
+	// reconciles the channels which are not ready, zero disabling it.
+	ControllerNotReadyResyncPeriodKey = "controller-not-ready-resync-period"
+
+	// DispatcherResyncPeriodKey is the ConfigMap key setting how often the dispatcher reconciles
+	// all the channels, zero leaving it to the informer resync.
+	DispatcherResyncPeriodKey = "dispatcher-resync-period"
+
+	// DispatcherNotReadyResyncPeriodKey is the ConfigMap key setting how often the dispatcher
+	// reconciles the channels which are not ready, zero disabling it.
+	DispatcherNotReadyResyncPeriodKey = "dispatcher-not-ready-resync-period"
+
+	// DispatcherRequireReplicasKey is the ConfigMap key making the channels not ready while the
+	// dispatcher Deployment has no replica desired or available.
+	DispatcherRequireReplicasKey = "dispatcher.require-replicas"
+
+	// DispatcherDrainTimeoutKey is the ConfigMap key setting how long the dispatcher waits, when
+	// it stops, for the events being dispatched, zero using the default of the dispatcher.
+	DispatcherDrainTimeoutKey = "dispatcher.drain-timeout"
+
+	// DispatcherLivenessThresholdKey is the ConfigMap key setting how long the connection of the
+	// dispatcher to NATSS may be down before its liveness probe fails, zero never failing it.
+	DispatcherLivenessThresholdKey = "dispatcher.liveness-threshold"
+
+	// DefaultDispatcherLivenessThreshold is the liveness threshold used when none is configured.
+	DefaultDispatcherLivenessThreshold = 5 * time.Minute
+
+	// DeliveryUserAgentKey is the ConfigMap key setting the User-Agent of the requests sent by the
+	// dispatcher, in which {version}, {namespace} and {name} are replaced by the version of the
+	// dispatcher and the namespace and name of the channel. An empty value suppresses the header.
+	DeliveryUserAgentKey = "delivery-user-agent"
+
+	// DefaultDeliveryUserAgent is the User-Agent used when none is configured.
+	DefaultDeliveryUserAgent = "knative-eventing-natss-dispatcher/{version} channel/{namespace}/{name}"
+
+	// DeliveryOriginKey is the ConfigMap key setting the Knative-Origin header of the requests sent
+	// by the dispatcher. An empty value suppresses the header.
+	DeliveryOriginKey = "delivery-origin"
+
+	// DefaultDeliveryOrigin is the Knative-Origin header used when none is configured.
+	DefaultDeliveryOrigin = "natsschannel"
+
+	// DeliveryMaxRedirectsKey is the ConfigMap key setting how many redirects a delivery follows
+	// before failing, zero disallowing the redirects.
+	DeliveryMaxRedirectsKey = "delivery-max-redirects"
+
+	// DefaultDeliveryMaxRedirects is the maximum number of redirects used when none is configured.
+	DefaultDeliveryMaxRedirects = 3
+
+	// DeliveryMaxInflightKey is the ConfigMap key setting how many events of a subscription NATSS
+	// sends before they are acknowledged, zero using the default of NATSS.
+	DeliveryMaxInflightKey = "delivery-max-inflight"
+
+	// DeliveryConcurrencyKey is the ConfigMap key setting how many events of a channel the
+	// dispatcher delivers at once across its subscribers, zero using the default of the dispatcher.
+	DeliveryConcurrencyKey = "delivery-concurrency"
+
+	// DeliveryAckWaitKey is the ConfigMap key setting how long NATSS waits for the ack of an event
+	// before redelivering it, extended by the backoff of the retries of the subscription, zero
+	// using one minute.
+	DeliveryAckWaitKey = "delivery-ack-wait"
+
+	// DeliveryStartAtKey is the ConfigMap key setting where the subscriptions start when they have
+	// no durable to resume from, either "new-only" or "all-available".
+	DeliveryStartAtKey = "delivery-start-at"
+
+	// DeliveryErrorBodyLimitKey is the ConfigMap key setting how many bytes of the bodies of the
+	// error responses of the subscribers the dispatcher keeps for its diagnostics, zero disabling
+	// them.
+	DeliveryErrorBodyLimitKey = "delivery-error-body-limit"
+
+	// DefaultDeliveryErrorBodyLimit is the error body limit used when none is configured.
+	DefaultDeliveryErrorBodyLimit = 1024
+
+	// DeliveryRetryKey is the ConfigMap key setting how many times the dispatcher retries the
+	// failed deliveries of the subscriptions whose delivery spec sets no retry, before leaving
+	// them to NATSS to redeliver.
+	DeliveryRetryKey = "delivery-retry"
+
+	// DeliveryBackoffPolicyKey is the ConfigMap key setting the backoff policy of the retries of
+	// DeliveryRetryKey, exponential or linear.
+	DeliveryBackoffPolicyKey = "delivery-backoff-policy"
+
+	// DeliveryBackoffDelayKey is the ConfigMap key setting the ISO 8601 duration the retries of
+	// DeliveryRetryKey back off from.
+	DeliveryBackoffDelayKey = "delivery-backoff-delay"
+
+	// DeliveryTimeoutKey is the ConfigMap key setting the ISO 8601 duration after which the
+	// requests of the deliveries of the subscriptions without a timeout of their own time out.
+	DeliveryTimeoutKey = "delivery-timeout"
+
+	// HibernationThresholdKey is the ConfigMap key setting how long a channel must go without
+	// events before the dispatcher closes its subscriptions, zero disabling the hibernation.
+	HibernationThresholdKey = "hibernation-idle-threshold"
+
+	// SubscriberPauseAfterKey is the ConfigMap key setting how long the deliveries to a subscriber
+	// must all fail before the dispatcher pauses its subscription, zero disabling the pauses.
+	SubscriberPauseAfterKey = "subscriber-pause-after"
+
+	// SubscriberProbeIntervalKey is the ConfigMap key setting how often the dispatcher probes the
+	// subscribers of the paused subscriptions, zero using the default of the dispatcher.
+	SubscriberProbeIntervalKey = "subscriber-probe-interval"
+
+	// SubscriberConnectCheckKey is the ConfigMap key making the dispatcher connect to the
+	// subscribers of the new subscriptions to check that they are reachable, beyond resolving
+	// their host.
+	SubscriberConnectCheckKey = "subscriber-connect-check"
+
+	// FanoutSoftLimitKey is the ConfigMap key setting how many subscribers a channel may have
+	// before it warns of the amplification of its events, zero disabling the warning.
+	FanoutSoftLimitKey = "fanout.soft-limit"
+
+	// FanoutHardLimitKey is the ConfigMap key setting how many subscribers of a channel the
+	// dispatcher subscribes, the others being refused, zero disabling the limit.
+	FanoutHardLimitKey = "fanout.hard-limit"
+
+	// QuotaMaxChannelsKey and QuotaMaxSubscriptionsKey are the ConfigMap keys setting how many
+	// channels, and subscriptions of their channels, a namespace may have, zero disabling the
+	// quota. The validation webhook enforces them.
+	QuotaMaxChannelsKey      = "quota.max-channels"
+	QuotaMaxSubscriptionsKey = "quota.max-subscriptions"
+
+	// EgressEventsPerSecondKey and EgressBytesPerSecondKey are the ConfigMap keys setting how many
+	// events, and bytes of events, the dispatcher delivers per second across all its channels,
+	// zero disabling the limit.
+	EgressEventsPerSecondKey = "egress.events-per-second"
+	EgressBytesPerSecondKey  = "egress.bytes-per-second"
+
+	// AvroSchemaCacheTTLKey is the ConfigMap key setting how long the dispatcher caches the
+	// schemas fetched from the schema registries, zero disabling the cache.
+	AvroSchemaCacheTTLKey = "avro-schema-cache-ttl"
+
+	// DefaultAvroSchemaCacheTTL is the schema cache TTL used when none is configured.
+	DefaultAvroSchemaCacheTTL = 10 * time.Minute
+
+	// SecurityStrictKey is the ConfigMap key enabling the strict security mode, restricting the
+	// TLS connections of the dispatcher to TLS 1.2 or later and to the FIPS approved ciphers.
+	SecurityStrictKey = "security.strict"
+
+	// SecurityCAFileKey is the ConfigMap key holding the path of the PEM bundle of the certificate
+	// authorities trusted by the dispatcher for its connections to NATSS and to the subscribers.
+	SecurityCAFileKey = "security.ca-file"
+
+	// SecurityClientCertFileKey and SecurityClientKeyFileKey are the ConfigMap keys holding the
+	// paths of the certificate and key the dispatcher authenticates to NATSS with.
+	SecurityClientCertFileKey = "security.client-cert-file"
+	SecurityClientKeyFileKey  = "security.client-key-file"
+
+	// SecurityInsecureSkipVerifyKey is the ConfigMap key disabling the verification of the
+	// certificate of NATSS.
+	SecurityInsecureSkipVerifyKey = "security.insecure-skip-verify"
+
+	// SecurityReceiverCertFileKey and SecurityReceiverKeyFileKey are the ConfigMap keys holding
+	// the paths of the certificate and key the receiver of the dispatcher serves HTTPS with.
+	SecurityReceiverCertFileKey = "security.receiver-cert-file"
+	SecurityReceiverKeyFileKey  = "security.receiver-key-file"
+
+	// SecurityReceiverCACertsKey is the ConfigMap key holding the PEM bundle of the certificate
+	// authorities of the receiver certificate, advertised with the HTTPS addresses of the channels.
+	SecurityReceiverCACertsKey = "security.receiver-ca-certs"
+
+	// AuthSecretNameKey is the ConfigMap key holding the name of the Secret of the system
+	// namespace with the credentials the dispatcher authenticates to NATS with, see
+	// stanutil.AuthFromSecret.
+	AuthSecretNameKey = "auth.secret-name"
+
+	// MetricsCardinalityKey is the ConfigMap key setting how finely the metrics of the dispatcher
+	// tell the channels and subscriptions apart, see cardinality.Mode.
+	MetricsCardinalityKey = "metrics.cardinality"
+
+	// DeliveryReportsSinkKey is the ConfigMap key holding the URL the dispatcher POSTs the
+	// reports of its deliveries to, empty disabling the reports.
+	DeliveryReportsSinkKey = "delivery-reports.sink"
+
+	// DeliveryReportsBatchSizeKey is the ConfigMap key setting the maximum number of delivery
+	// reports POSTed at once.
+	DeliveryReportsBatchSizeKey = "delivery-reports.batch-size"
+
+	// DeliveryReportsFlushIntervalKey is the ConfigMap key setting how long a delivery report waits
+	// for its batch to fill before it is sent anyway.
+	DeliveryReportsFlushIntervalKey = "delivery-reports.flush-interval"
+
+	// DeliveryReportsQueueSizeKey is the ConfigMap key setting how many delivery reports may await
+	// to be sent, the oldest being dropped beyond.
+	DeliveryReportsQueueSizeKey = "delivery-reports.queue-size"
+
+	// DefaultDeliveryReportsBatchSize, DefaultDeliveryReportsFlushInterval and
+	// DefaultDeliveryReportsQueueSize are used when the keys are not configured.
+	DefaultDeliveryReportsBatchSize     = 100
+	DefaultDeliveryReportsFlushInterval = 5 * time.Second
+	DefaultDeliveryReportsQueueSize     = 10000
+
+	// OfflineBufferMaxEventsKey is the ConfigMap key setting how many events the receiver buffers
+	// in memory while the connection to NATSS is lost, zero disabling the buffer.
+	OfflineBufferMaxEventsKey = "offline-buffer.max-events"
+
+	// OfflineBufferMaxBytesKey is the ConfigMap key setting how many bytes of event data the receiver
+	// buffers in memory while the connection to NATSS is lost.
+	OfflineBufferMaxBytesKey = "offline-buffer.max-bytes"
+
+	// OfflineBufferMaxOutageKey is the ConfigMap key setting how long after the first event it
+	// buffers the receiver keeps buffering, the events being refused beyond.
+	OfflineBufferMaxOutageKey = "offline-buffer.max-outage"
+
+	// OfflineBufferOverflowPolicyKey is the ConfigMap key telling what becomes of the buffered
+	// events once the buffer overflows or the outage lasts too long, see OfflineBufferFlush and
+	// OfflineBufferDrop.
+	OfflineBufferOverflowPolicyKey = "offline-buffer.overflow-policy"
+
+	// OfflineBufferFlush keeps the buffered events to publish them once connected again, and
+	// OfflineBufferDrop drops them.
+	OfflineBufferFlush = "flush"
+	OfflineBufferDrop  = "drop"
+
+	// DefaultOfflineBufferMaxBytes and DefaultOfflineBufferMaxOutage are used when the keys are
+	// not configured.
+	DefaultOfflineBufferMaxBytes  = 10 << 20
+	DefaultOfflineBufferMaxOutage = 30 * time.Second
+
+	// ReceiverTrustedProxiesKey is the ConfigMap key holding the comma separated CIDRs of the
+	// proxies whose Forwarded and X-Forwarded-For headers the receiver honors.
+	ReceiverTrustedProxiesKey = "receiver.trusted-proxies"
+
+	// ReceiverReservedExtensionsKey is the ConfigMap key telling whether the receiver strips or
+	// rejects the extension attributes of the events reserved to the dispatcher.
+	ReceiverReservedExtensionsKey = "receiver.reserved-extensions"
+
+	// ReservedExtensionsStrip and ReservedExtensionsReject are the values of
+	// ReceiverReservedExtensionsKey, the events being stripped by default.
+	ReservedExtensionsStrip  = "strip"
+	ReservedExtensionsReject = "reject"
+
+	// ReceiverPublishModeKey is the ConfigMap key telling whether the receiver publishes the
+	// events to NATSS synchronously, one ack awaited at a time on the round-trip of each
+	// publication, or asynchronously, the publications waiting for their acks concurrently.
+	ReceiverPublishModeKey = "receiver.publish-mode"
+
+	// PublishSync and PublishAsync are the values of ReceiverPublishModeKey, the events being
+	// published synchronously by default.
+	PublishSync  = "sync"
+	PublishAsync = "async"
+
+	// ReceiverPublishMaxInflightKey is the ConfigMap key setting how many publications of the
+	// receiver wait for their ack on a connection to NATSS before the next ones block.
+	ReceiverPublishMaxInflightKey = "receiver.publish-max-inflight"
+
+	// ReceiverPublishAckTimeoutKey is the ConfigMap key setting how long a publication of the
+	// receiver waits for its ack before the event is answered with an error.
+	ReceiverPublishAckTimeoutKey = "receiver.publish-ack-timeout"
+
+	// SupportBundleDirKey is the ConfigMap key holding the directory, usually on a volume, the
+	// support bundles are written to, empty sending them in the responses of their requests.
+	SupportBundleDirKey = "support-bundle.dir"
+
+	// SupportBundleMaxBytesKey is the ConfigMap key setting how many bytes of JSON a support
+	// bundle holds before it is compressed, the sections beyond being left out.
+	SupportBundleMaxBytesKey = "support-bundle.max-bytes"
+
+	// DefaultSupportBundleMaxBytes is used when SupportBundleMaxBytesKey is not configured.
+	DefaultSupportBundleMaxBytes = 16 << 20
+
+	// ServerPartitionedKey is the ConfigMap key telling that NATSS runs with partitioning, whose
+	// servers ignore the requests of the channels they do not own.
+	ServerPartitionedKey = "server.partitioned"
+
+	// ServerURLKey and ServerClusterIDKey are the ConfigMap keys holding the comma separated NATS
+	// URLs and the cluster ID of the NATSS cluster of the dispatcher, overriding the
+	// DEFAULT_NATSS_URL and DEFAULT_CLUSTER_ID environment variables.
+	ServerURLKey       = "server.url"
+	ServerClusterIDKey = "server.cluster-id"
+
+	// ServerReconnectBackoffKey is the ConfigMap key setting the delay before the second attempt
+	// to connect to NATSS, doubled after each failed one up to ServerReconnectMaxBackoffKey.
+	ServerReconnectBackoffKey    = "server.reconnect-backoff"
+	ServerReconnectMaxBackoffKey = "server.reconnect-max-backoff"
+
+	// ServerChannelProvisioningURLKey is the ConfigMap key holding the URL the dispatcher POSTs the
+	// NATSS channels refused by the server to, empty disabling the requests.
+	ServerChannelProvisioningURLKey = "server.channel-provisioning-url"
+
+	// ProbeNamespaceKey is the ConfigMap key holding the namespace of the channel of the end to
+	// end probe, empty disabling the probe.
+	ProbeNamespaceKey = "probe.namespace"
+
+	// ProbeIntervalKey is the ConfigMap key setting how often the dispatcher sends an event
+	// through the probe channel.
+	ProbeIntervalKey = "probe.interval"
+
+	// ProbeTimeoutKey is the ConfigMap key setting how long the event of a probe may take to
+	// reach the probe subscriber before the probe fails.
+	ProbeTimeoutKey = "probe.timeout"
+
+	// DefaultProbeInterval and DefaultProbeTimeout are used when the keys are not configured.
+	DefaultProbeInterval = 30 * time.Second
+	DefaultProbeTimeout  = 10 * time.Second
+
+	// StorageMonitoringURLKey is the ConfigMap key holding the URL of the store page of the
+	// monitoring endpoint of NATSS, /streaming/storez, empty disabling the storage monitor.
+	StorageMonitoringURLKey = "storage.monitoring-url"
+
+	// StorageCapacityKey is the ConfigMap key holding the size of the store of NATSS, which the
+	// monitoring endpoint does not report, required with StorageMonitoringURLKey.
+	StorageCapacityKey = "storage.capacity"
+
+	// StorageWarningPercentKey is the ConfigMap key setting the percentage of the store in use
+	// above which the receiver warns the producers.
+	StorageWarningPercentKey = "storage.warning-percent"
+
+	// StorageCriticalPercentKey is the ConfigMap key setting the percentage of the store in use
+	// above which the receiver refuses the events of the channels shedding on pressure.
+	StorageCriticalPercentKey = "storage.critical-percent"
+
+	// StoragePollIntervalKey is the ConfigMap key setting how often the storage monitor reads
+	// the monitoring endpoint.
+	StoragePollIntervalKey = "storage.poll-interval"
+
+	// DefaultStorageWarningPercent, DefaultStorageCriticalPercent and DefaultStoragePollInterval
+	// are used when the keys are not configured.
+	DefaultStorageWarningPercent  = 80
+	DefaultStorageCriticalPercent = 95
+	DefaultStoragePollInterval    = 30 * time.Second
+
+	// AddressSchemeKey is the ConfigMap key setting the scheme of the addresses of the channels,
+	// http or https, whatever the receiver serves, for example behind a mesh terminating TLS.
+	AddressSchemeKey = "address.scheme"
+
+	// AddressPortKey is the ConfigMap key setting the port of the addresses of the channels.
+	AddressPortKey = "address.port"
+
+	// DefaultDeadLetterSinkKeyPrefix prefixes the ConfigMap keys holding the URL of the dead
+	// letter sink of the subscribers of a namespace without one, the namespace ending the key.
+	DefaultDeadLetterSinkKeyPrefix = "default-dead-letter-sink."
+
+	// MaintenanceModeKey is the ConfigMap key which, set to "true", makes the controller and the
+	// dispatcher stop reconciling the channels, and the dispatcher stop subscribing them again on
+	// reconnection, while the NATSS cluster is under maintenance.
+	MaintenanceModeKey = "maintenance-mode"
+
+	// ResyncAnnotation is the annotation of the ConfigMap whose changes make the controller and
+	// the dispatcher reconcile all the channels, its value being typically a timestamp.
+	ResyncAnnotation = "natss.knative.dev/resync"
+)
+
+// Resync holds the periods of the resyncs of a controller, a zero period disabling the resync.
+type Resync struct {
+	// Period is the interval between two reconciliations of all the channels.
+	Period time.Duration
+
+	// NotReadyPeriod is the interval between two reconciliations of the channels which are not ready.
+	NotReadyPeriod time.Duration
+}
+
+// NamespaceQuota bounds the channels of a namespace, and the subscriptions of its channels. A
+// zero limit is disabled.
+type NamespaceQuota struct {
+	// Channels is the number of channels of the namespace.
+	Channels int
+
+	// Subscriptions is the number of subscribers of all the channels of the namespace.
+	Subscriptions int
+}
+
+// WithAnnotations returns q overridden by the quota annotations of a Namespace.
+func (q NamespaceQuota) WithAnnotations(annotations map[string]string) (NamespaceQuota, error) {
+	parse := func(key string, target *int) error {
+		raw, ok := annotations[key]
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse the %q annotation: %w", key, err)
+		}
+		*target = n
+		return nil
+	}
+	if err := parse(messaging.QuotaMaxChannelsAnnotationKey, &q.Channels); err != nil {
+		return q, err
+	}
+	if err := parse(messaging.QuotaMaxSubscriptionsAnnotationKey, &q.Subscriptions); err != nil {
+		return q, err
+	}
+	if err := q.validate(); err != nil {
+		return q, fmt.Errorf("invalid quota annotations: %w", err)
+	}
+	return q, nil
+}
+
+func (q NamespaceQuota) validate() error {
+	if q.Channels < 0 || q.Subscriptions < 0 {
+		return fmt.Errorf("the quotas %d and %d must not be negative", q.Channels, q.Subscriptions)
+	}
+	return nil
+}
+
+// DeliveryReports configures the reports of the deliveries POSTed by the dispatcher.
+type DeliveryReports struct {
+	// Sink receives the reports, nil disabling them.
+	Sink *apis.URL
+
+	// BatchSize is the maximum number of reports POSTed at once.
+	BatchSize int
+
+	// FlushInterval is how long a report waits for its batch to fill.
+	FlushInterval time.Duration
+
+	// QueueSize is the maximum number of reports awaiting to be sent.
+	QueueSize int
+}
+
+// OfflineBuffer configures the buffer of the events received while the connection to NATSS is
+// lost.
+type OfflineBuffer struct {
+	// MaxEvents is how many events are buffered, zero disabling the buffer.
+	MaxEvents int
+
+	// MaxBytes is how many bytes of event data are buffered.
+	MaxBytes int64
+
+	// MaxOutage is how long after the first buffered event the events are buffered.
+	MaxOutage time.Duration
+
+	// OverflowPolicy is OfflineBufferFlush or OfflineBufferDrop.
+	OverflowPolicy string
+}
+
+func (b OfflineBuffer) validate() error {
+	if b.MaxEvents < 0 || b.MaxBytes < 0 || b.MaxOutage < 0 {
+		return fmt.Errorf("%q, %q and %q must not be negative", OfflineBufferMaxEventsKey, OfflineBufferMaxBytesKey, OfflineBufferMaxOutageKey)
+	}
+	switch b.OverflowPolicy {
+	case OfflineBufferFlush, OfflineBufferDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid %q %q, must be %q or %q", OfflineBufferOverflowPolicyKey, b.OverflowPolicy, OfflineBufferFlush, OfflineBufferDrop)
+	}
+}
+
+// SupportBundle configures the support bundles of the dispatcher.
+type SupportBundle struct {
+	// Dir is the directory the bundles are written to, empty sending them in the responses.
+	Dir string
+
+	// MaxBytes is how many bytes of JSON a bundle holds.
+	MaxBytes int64
+}
+
+func (b SupportBundle) validate() error {
+	if b.MaxBytes <= 0 {
+		return fmt.Errorf("%q must be positive", SupportBundleMaxBytesKey)
+	}
+	return nil
+}
+
+// Publish configures the publications of the events the receiver accepts to NATSS.
+type Publish struct {
+	// Mode is PublishSync or PublishAsync.
+	Mode string
+
+	// MaxInflight is how many publications wait for their ack on a connection.
+	MaxInflight int
+
+	// AckTimeout is how long a publication waits for its ack.
+	AckTimeout time.Duration
+}
+
+func (p Publish) validate() error {
+	if p.Mode != PublishSync && p.Mode != PublishAsync {
+		return fmt.Errorf("invalid %q %q, must be %q or %q", ReceiverPublishModeKey, p.Mode, PublishSync, PublishAsync)
+	}
+	if p.MaxInflight <= 0 || p.AckTimeout <= 0 {
+		return fmt.Errorf("%q and %q must be positive", ReceiverPublishMaxInflightKey, ReceiverPublishAckTimeoutKey)
+	}
+	return nil
+}
+
+// Probe configures the end to end probe.
+type Probe struct {
+	// Namespace is the namespace of the probe channel, empty disabling the probe.
+	Namespace string
+
+	// Interval is the interval between two probes.
+	Interval time.Duration
+
+	// Timeout is how long the event of a probe may take to reach the probe subscriber.
+	Timeout time.Duration
+}
+
+// Storage configures the monitor of the store of NATSS.
+type Storage struct {
+	// MonitoringURL is the URL of the store page of the monitoring endpoint of NATSS, nil
+	// disabling the monitor.
+	MonitoringURL *apis.URL
+
+	// Capacity is the size of the store in bytes.
+	Capacity int64
+
+	// WarningPercent and CriticalPercent are the percentages of the store in use above which
+	// the store is under warning and critical pressure.
+	WarningPercent  float64
+	CriticalPercent float64
+
+	// PollInterval is the interval between two reads of the monitoring endpoint.
+	PollInterval time.Duration
+}
+
+// Address configures the addresses advertised by the channels instead of those of the receiver.
+type Address struct {
+	// Scheme is http or https, empty when not overridden.
+	Scheme string
+
+	// Port is the port of the addresses, zero when not overridden.
+	Port int
+}
+
+// IsZero returns whether a overrides nothing, the channels advertising the addresses of the
+// receiver.
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
+// URL returns the address of a channel served at host, whose scheme defaults to http.
+func (a Address) URL(host string) *apis.URL {
+	u := &apis.URL{Scheme: a.Scheme, Host: host}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	if a.Port != 0 {
+		u.Host = net.JoinHostPort(host, strconv.Itoa(a.Port))
+	}
+	return u
+}
+
+// WithAnnotations returns a overridden by the address annotations of a NatssChannel.
+func (a Address) WithAnnotations(annotations map[string]string) (Address, error) {
+	if scheme, ok := annotations[messaging.AddressSchemeAnnotationKey]; ok {
+		a.Scheme = scheme
+	}
+	if raw, ok := annotations[messaging.AddressPortAnnotationKey]; ok {
+		port, err := strconv.Atoi(raw)
+		if err != nil {
+			return a, fmt.Errorf("failed to parse the %q annotation: %w", messaging.AddressPortAnnotationKey, err)
+		}
+		a.Port = port
+	}
+	if err := a.validate(); err != nil {
+		return a, fmt.Errorf("invalid address annotations: %w", err)
+	}
+	return a, nil
+}
+
+func (a Address) validate() error {
+	switch a.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("the scheme %q is neither http nor https", a.Scheme)
+	}
+	if a.Port < 0 || a.Port > 65535 {
+		return fmt.Errorf("the port %d is not between 1 and 65535", a.Port)
+	}
+	return nil
+}
+
+// FanoutLimits bounds the subscribers of a channel, each event of which is delivered once per
+// subscriber. A zero limit is disabled.
+type FanoutLimits struct {
+	// Soft is the number of subscribers above which the channel warns of its fan-out.
+	Soft int
+
+	// Hard is the number of subscribers the dispatcher subscribes, the others being refused.
+	Hard int
+}
+
+// WithAnnotations returns l overridden by the fan-out annotations of a NatssChannel.
+func (l FanoutLimits) WithAnnotations(annotations map[string]string) (FanoutLimits, error) {
+	parse := func(key string, target *int) error {
+		raw, ok := annotations[key]
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse the %q annotation: %w", key, err)
+		}
+		*target = n
+		return nil
+	}
+	if err := parse(messaging.FanoutSoftLimitAnnotationKey, &l.Soft); err != nil {
+		return l, err
+	}
+	if err := parse(messaging.FanoutHardLimitAnnotationKey, &l.Hard); err != nil {
+		return l, err
+	}
+	if err := l.validate(); err != nil {
+		return l, fmt.Errorf("invalid fan-out annotations: %w", err)
+	}
+	return l, nil
+}
+
+func (l FanoutLimits) validate() error {
+	if l.Soft < 0 || l.Hard < 0 {
+		return fmt.Errorf("the limits %d and %d must not be negative", l.Soft, l.Hard)
+	}
+	if l.Soft > 0 && l.Hard > 0 && l.Soft > l.Hard {
+		return fmt.Errorf("the soft limit %d is above the hard limit %d", l.Soft, l.Hard)
+	}
+	return nil
+}
+
+// Config holds the NATSS channel configuration.
+type Config struct {
+	// Transport is the name of the transport the dispatcher uses to talk to NATS.
+	Transport string
+
+	// CertManager configures the certificates issued by cert-manager.
+	CertManager CertManager
+
+	// PersistHostMap enables the persistence of the dispatcher host to channel map.
+	PersistHostMap bool
+
+	// ResponseCodePolicy is the response code policy of the channels not overriding it.
+	ResponseCodePolicy v1beta1.ResponseCodePolicy
+
+	// OrphanAuditInterval is the interval between two audits of the orphaned durables.
+	OrphanAuditInterval time.Duration
+
+	// OrphanAuditGracePeriod is how long a durable must be orphaned before it may be deleted.
+	OrphanAuditGracePeriod time.Duration
+
+	// DurableNamingScheme is the scheme the durables of the new subscriptions are named with.
+	DurableNamingScheme string
+
+	// ControllerResync holds the resync periods of the controller.
+	ControllerResync Resync
+
+	// DispatcherResync holds the resync periods of the dispatcher.
+	DispatcherResync Resync
+
+	// DispatcherRequireReplicas makes the channels not ready while the dispatcher is scaled to zero.
+	DispatcherRequireReplicas bool
+
+	// DispatcherDrainTimeout is how long the dispatcher waits for the events being dispatched
+	// when it stops.
+	DispatcherDrainTimeout time.Duration
+
+	// DispatcherLivenessThreshold is how long the connection of the dispatcher to NATSS may be
+	// down before its liveness probe fails, zero never failing it.
+	DispatcherLivenessThreshold time.Duration
+
+	// DeliveryUserAgent is the User-Agent template of the requests sent by the dispatcher.
+	DeliveryUserAgent string
+
+	// DeliveryOrigin is the Knative-Origin header of the requests sent by the dispatcher.
+	DeliveryOrigin string
+
+	// DeliveryMaxRedirects is how many redirects a delivery follows before failing.
+	DeliveryMaxRedirects int
+
+	// DeliveryMaxInflight is how many events of a subscription are sent before they are acknowledged.
+	DeliveryMaxInflight int
+
+	// DeliveryConcurrency is how many events of a channel are delivered at once, zero when none
+	// is configured.
+	DeliveryConcurrency int
+
+	// DeliveryAckWait is how long NATSS waits for the ack of an event before redelivering it, zero
+	// when none is configured.
+	DeliveryAckWait time.Duration
+
+	// DeliveryStartAt is where the subscriptions without a durable to resume from start.
+	DeliveryStartAt v1beta1.StartPosition
+
+	// DeliveryErrorBodyLimit is how many bytes of the error responses of the subscribers are kept.
+	DeliveryErrorBodyLimit int64
+
+	// DefaultDelivery is the retry and backoff of the subscriptions whose delivery spec sets none,
+	// nil when none is configured.
+	DefaultDelivery *eventingduckv1.DeliverySpec
+
+	// DeliveryTimeout bounds the requests of the deliveries of the subscriptions without a timeout
+	// of their own, zero when not configured.
+	DeliveryTimeout time.Duration
+
+	// HibernationThreshold is how long a channel must be idle before it hibernates.
+	HibernationThreshold time.Duration
+
+	// SubscriberPauseAfter is how long a subscriber must fail before its subscription is paused.
+	SubscriberPauseAfter time.Duration
+
+	// SubscriberProbeInterval is how often the subscribers of the paused subscriptions are probed.
+	SubscriberProbeInterval time.Duration
+
+	// SubscriberConnectCheck makes the checks of the subscribers of the new subscriptions connect
+	// to them.
+	SubscriberConnectCheck bool
+
+	// Fanout bounds the subscribers of the channels not overriding it.
+	Fanout FanoutLimits
+
+	// Quota bounds the channels of the namespaces not overriding it.
+	Quota NamespaceQuota
+
+	// EgressEventsPerSecond and EgressBytesPerSecond bound the deliveries of the dispatcher
+	// across all its channels, zero when not configured.
+	EgressEventsPerSecond int
+	EgressBytesPerSecond  int64
+
+	// AvroSchemaCacheTTL is how long the schemas fetched from the schema registries are cached.
+	AvroSchemaCacheTTL time.Duration
+
+	// Security holds the TLS settings of the dispatcher.
+	Security security.Config
+
+	// AuthSecretName is the name of the Secret holding the NATS credentials of the dispatcher,
+	// empty when it does not authenticate.
+	AuthSecretName string
+
+	// MetricsCardinality is how finely the metrics of the dispatcher tell the resources apart,
+	// empty for the default.
+	MetricsCardinality cardinality.Mode
+
+	// DeliveryReports configures the reports of the deliveries.
+	DeliveryReports DeliveryReports
+
+	// OfflineBuffer configures the buffer of the events received while NATSS is unreachable.
+	OfflineBuffer OfflineBuffer
+
+	// ReceiverTrustedProxies are the networks of the proxies in front of the receiver.
+	ReceiverTrustedProxies []*net.IPNet
+
+	// ReceiverRejectReservedExtensions makes the receiver reject the events carrying extension
+	// attributes reserved to the dispatcher instead of stripping them.
+	ReceiverRejectReservedExtensions bool
+
+	// ReceiverPublish configures the publications of the events the receiver accepts.
+	ReceiverPublish Publish
+
+	// SupportBundle configures the support bundles of the dispatcher.
+	SupportBundle SupportBundle
+
+	// ServerPartitioned tells that NATSS runs with partitioning.
+	ServerPartitioned bool
+
+	// ServerURL and ServerClusterID are those of the NATSS cluster of the dispatcher, empty when
+	// not configured.
+	ServerURL       string
+	ServerClusterID string
+
+	// ServerReconnectBackoff and ServerReconnectMaxBackoff back off the attempts to connect to
+	// NATSS, zero when not configured.
+	ServerReconnectBackoff    time.Duration
+	ServerReconnectMaxBackoff time.Duration
+
+	// ServerChannelProvisioningURL receives the NATSS channels refused by the server, nil when
+	// not configured.
+	ServerChannelProvisioningURL *apis.URL
+
+	// Probe configures the end to end probe.
+	Probe Probe
+
+	// Storage configures the monitor of the store of NATSS.
+	Storage Storage
+
+	// Address configures the addresses advertised by the channels.
+	Address Address
+
+	// DefaultDeadLetterSinks are the dead letter sinks of the subscribers without one, by namespace.
+	DefaultDeadLetterSinks map[string]*apis.URL
+
+	// ResyncRequest is the value of the ResyncAnnotation of the ConfigMap.
+	ResyncRequest string
+
+	// MaintenanceMode tells the NATSS cluster is under maintenance.
+	MaintenanceMode bool
+
+	// Features holds the feature flags of the features section.
+	Features *features.Flags
+}
+
+// NewConfigFromConfigMap creates a Config from the supplied ConfigMap, using
+// the defaults for the missing keys. A nil ConfigMap yields the default Config.
+func NewConfigFromConfigMap(cm *corev1.ConfigMap) (*Config, error) {
+	c := &Config{
+		Transport:                   DefaultTransport,
+		OrphanAuditGracePeriod:      DefaultOrphanAuditGracePeriod,
+		CertManager:                 CertManager{IssuerKind: CertManagerIssuer},
+		DurableNamingScheme:         DurableNamingV1,
+		DispatcherLivenessThreshold: DefaultDispatcherLivenessThreshold,
+		DeliveryUserAgent:           DefaultDeliveryUserAgent,
+		DeliveryOrigin:              DefaultDeliveryOrigin,
+		DeliveryMaxRedirects:        DefaultDeliveryMaxRedirects,
+		DeliveryErrorBodyLimit:      DefaultDeliveryErrorBodyLimit,
+		AvroSchemaCacheTTL:          DefaultAvroSchemaCacheTTL,
+		Features:                    features.Defaults(),
+		Probe:                       Probe{Interval: DefaultProbeInterval, Timeout: DefaultProbeTimeout},
+		Storage: Storage{
+			WarningPercent:  DefaultStorageWarningPercent,
+			CriticalPercent: DefaultStorageCriticalPercent,
+			PollInterval:    DefaultStoragePollInterval,
+		},
+		DeliveryReports: DeliveryReports{
+			BatchSize:     DefaultDeliveryReportsBatchSize,
+			FlushInterval: DefaultDeliveryReportsFlushInterval,
+			QueueSize:     DefaultDeliveryReportsQueueSize,
+		},
+		OfflineBuffer: OfflineBuffer{
+			MaxBytes:       DefaultOfflineBufferMaxBytes,
+			MaxOutage:      DefaultOfflineBufferMaxOutage,
+			OverflowPolicy: OfflineBufferFlush,
+		},
+		ReceiverPublish: Publish{
+			Mode:        PublishSync,
+			MaxInflight: stan.DefaultMaxPubAcksInflight,
+			AckTimeout:  stan.DefaultAckWait,
+		},
+		SupportBundle: SupportBundle{MaxBytes: DefaultSupportBundleMaxBytes},
+	}
+	if cm == nil {
+		return c, nil
+	}
+	c.ResyncRequest = cm.Annotations[ResyncAnnotation]
+
+	if err := c.parseOverridable(cm.Data); err != nil {
+		return nil, err
+	}
+	var retry int
+	var backoffPolicy, backoffDelay, deliveryTimeout string
+	if err := configmap.Parse(cm.Data,
+		configmap.AsString(TransportKey, &c.Transport),
+		configmap.AsBool(CertManagerEnabledKey, &c.CertManager.Enabled),
+		configmap.AsString(CertManagerIssuerNameKey, &c.CertManager.IssuerName),
+		configmap.AsString(CertManagerIssuerKindKey, &c.CertManager.IssuerKind),
+		configmap.AsBool(PersistHostMapKey, &c.PersistHostMap),
+		configmap.AsBool(DispatcherRequireReplicasKey, &c.DispatcherRequireReplicas),
+		configmap.AsDuration(DispatcherDrainTimeoutKey, &c.DispatcherDrainTimeout),
+		configmap.AsDuration(DispatcherLivenessThresholdKey, &c.DispatcherLivenessThreshold),
+		configmap.AsDuration(OrphanAuditIntervalKey, &c.OrphanAuditInterval),
+		configmap.AsDuration(OrphanAuditGracePeriodKey, &c.OrphanAuditGracePeriod),
+		configmap.AsString(DurableNamingSchemeKey, &c.DurableNamingScheme),
+		configmap.AsDuration(ControllerResyncPeriodKey, &c.ControllerResync.Period),
+		configmap.AsDuration(ControllerNotReadyResyncPeriodKey, &c.ControllerResync.NotReadyPeriod),
+		configmap.AsDuration(DispatcherResyncPeriodKey, &c.DispatcherResync.Period),
+		configmap.AsDuration(DispatcherNotReadyResyncPeriodKey, &c.DispatcherResync.NotReadyPeriod),
+		configmap.AsString(DeliveryUserAgentKey, &c.DeliveryUserAgent),
+		configmap.AsString(DeliveryOriginKey, &c.DeliveryOrigin),
+		asBytes(DeliveryErrorBodyLimitKey, &c.DeliveryErrorBodyLimit),
+		configmap.AsInt(DeliveryConcurrencyKey, &c.DeliveryConcurrency),
+		configmap.AsInt(DeliveryRetryKey, &retry),
+		configmap.AsString(DeliveryBackoffPolicyKey, &backoffPolicy),
+		configmap.AsString(DeliveryBackoffDelayKey, &backoffDelay),
+		configmap.AsString(DeliveryTimeoutKey, &deliveryTimeout),
+		configmap.AsDuration(HibernationThresholdKey, &c.HibernationThreshold),
+		configmap.AsDuration(SubscriberPauseAfterKey, &c.SubscriberPauseAfter),
+		configmap.AsDuration(SubscriberProbeIntervalKey, &c.SubscriberProbeInterval),
+		configmap.AsBool(SubscriberConnectCheckKey, &c.SubscriberConnectCheck),
+		configmap.AsInt(FanoutSoftLimitKey, &c.Fanout.Soft),
+		configmap.AsInt(FanoutHardLimitKey, &c.Fanout.Hard),
+		configmap.AsInt(QuotaMaxChannelsKey, &c.Quota.Channels),
+		configmap.AsInt(QuotaMaxSubscriptionsKey, &c.Quota.Subscriptions),
+		configmap.AsInt(EgressEventsPerSecondKey, &c.EgressEventsPerSecond),
+		asBytes(EgressBytesPerSecondKey, &c.EgressBytesPerSecond),
+		configmap.AsDuration(AvroSchemaCacheTTLKey, &c.AvroSchemaCacheTTL),
+		configmap.AsBool(SecurityStrictKey, &c.Security.Strict),
+		configmap.AsString(SecurityCAFileKey, &c.Security.CAFile),
+		configmap.AsString(SecurityClientCertFileKey, &c.Security.ClientCertFile),
+		configmap.AsString(SecurityClientKeyFileKey, &c.Security.ClientKeyFile),
+		configmap.AsBool(SecurityInsecureSkipVerifyKey, &c.Security.InsecureSkipVerify),
+		configmap.AsString(SecurityReceiverCertFileKey, &c.Security.ReceiverCertFile),
+		configmap.AsString(SecurityReceiverKeyFileKey, &c.Security.ReceiverKeyFile),
+		configmap.AsString(SecurityReceiverCACertsKey, &c.Security.ReceiverCACerts),
+		configmap.AsString(AuthSecretNameKey, &c.AuthSecretName),
+		asCardinality(MetricsCardinalityKey, &c.MetricsCardinality),
+		asURL(DeliveryReportsSinkKey, &c.DeliveryReports.Sink),
+		configmap.AsInt(DeliveryReportsBatchSizeKey, &c.DeliveryReports.BatchSize),
+		configmap.AsDuration(DeliveryReportsFlushIntervalKey, &c.DeliveryReports.FlushInterval),
+		configmap.AsInt(DeliveryReportsQueueSizeKey, &c.DeliveryReports.QueueSize),
+		configmap.AsInt(OfflineBufferMaxEventsKey, &c.OfflineBuffer.MaxEvents),
+		asBytes(OfflineBufferMaxBytesKey, &c.OfflineBuffer.MaxBytes),
+		configmap.AsDuration(OfflineBufferMaxOutageKey, &c.OfflineBuffer.MaxOutage),
+		configmap.AsString(OfflineBufferOverflowPolicyKey, &c.OfflineBuffer.OverflowPolicy),
+		asCIDRs(ReceiverTrustedProxiesKey, &c.ReceiverTrustedProxies),
+		asReservedExtensions(ReceiverReservedExtensionsKey, &c.ReceiverRejectReservedExtensions),
+		configmap.AsString(ReceiverPublishModeKey, &c.ReceiverPublish.Mode),
+		configmap.AsInt(ReceiverPublishMaxInflightKey, &c.ReceiverPublish.MaxInflight),
+		configmap.AsDuration(ReceiverPublishAckTimeoutKey, &c.ReceiverPublish.AckTimeout),
+		configmap.AsString(SupportBundleDirKey, &c.SupportBundle.Dir),
+		asBytes(SupportBundleMaxBytesKey, &c.SupportBundle.MaxBytes),
+		configmap.AsBool(ServerPartitionedKey, &c.ServerPartitioned),
+		configmap.AsBool(MaintenanceModeKey, &c.MaintenanceMode),
+		configmap.AsString(ServerURLKey, &c.ServerURL),
+		configmap.AsString(ServerClusterIDKey, &c.ServerClusterID),
+		configmap.AsDuration(ServerReconnectBackoffKey, &c.ServerReconnectBackoff),
+		configmap.AsDuration(ServerReconnectMaxBackoffKey, &c.ServerReconnectMaxBackoff),
+		asURL(ServerChannelProvisioningURLKey, &c.ServerChannelProvisioningURL),
+		configmap.AsString(ProbeNamespaceKey, &c.Probe.Namespace),
+		configmap.AsDuration(ProbeIntervalKey, &c.Probe.Interval),
+		configmap.AsDuration(ProbeTimeoutKey, &c.Probe.Timeout),
+		asURL(StorageMonitoringURLKey, &c.Storage.MonitoringURL),
+		asBytes(StorageCapacityKey, &c.Storage.Capacity),
+		configmap.AsFloat64(StorageWarningPercentKey, &c.Storage.WarningPercent),
+		configmap.AsFloat64(StorageCriticalPercentKey, &c.Storage.CriticalPercent),
+		configmap.AsDuration(StoragePollIntervalKey, &c.Storage.PollInterval),
+		configmap.AsString(AddressSchemeKey, &c.Address.Scheme),
+		configmap.AsInt(AddressPortKey, &c.Address.Port),
+		asNamespacedURLs(DefaultDeadLetterSinkKeyPrefix, &c.DefaultDeadLetterSinks),
+		asFeatures(&c.Features),
+	); err != nil {
+		return nil, err
+	}
+	if err := c.CertManager.validate(); err != nil {
+		return nil, err
+	}
+	if c.OrphanAuditInterval < 0 || c.OrphanAuditGracePeriod < 0 {
+		return nil, fmt.Errorf("%q and %q must not be negative", OrphanAuditIntervalKey, OrphanAuditGracePeriodKey)
+	}
+	if c.DurableNamingScheme != DurableNamingV1 && c.DurableNamingScheme != DurableNamingV2 {
+		return nil, fmt.Errorf("invalid %q %q, expected %q or %q", DurableNamingSchemeKey, c.DurableNamingScheme, DurableNamingV1, DurableNamingV2)
+	}
+	if c.DeliveryErrorBodyLimit < 0 {
+		return nil, fmt.Errorf("%q must not be negative", DeliveryErrorBodyLimitKey)
+	}
+	if c.DeliveryConcurrency < 0 {
+		return nil, fmt.Errorf("%q must not be negative", DeliveryConcurrencyKey)
+	}
+	if retry != 0 || backoffPolicy != "" || backoffDelay != "" {
+		c.DefaultDelivery = newDeliverySpec(retry, backoffPolicy, backoffDelay)
+		if err := c.DefaultDelivery.Validate(context.Background()); err != nil {
+			return nil, fmt.Errorf("invalid %q, %q or %q: %w", DeliveryRetryKey, DeliveryBackoffPolicyKey, DeliveryBackoffDelayKey, err)
+		}
+	}
+	if deliveryTimeout != "" {
+		timeout, err := v1beta1.ParseDeliveryTimeout(deliveryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q: %w", DeliveryTimeoutKey, err)
+		}
+		c.DeliveryTimeout = timeout
+	}
+	if c.DispatcherDrainTimeout < 0 {
+		return nil, fmt.Errorf("%q must not be negative", DispatcherDrainTimeoutKey)
+	}
+	if c.DispatcherLivenessThreshold < 0 {
+		return nil, fmt.Errorf("%q must not be negative", DispatcherLivenessThresholdKey)
+	}
+	if c.HibernationThreshold < 0 {
+		return nil, fmt.Errorf("%q must not be negative", HibernationThresholdKey)
+	}
+	if c.SubscriberPauseAfter < 0 || c.SubscriberProbeInterval < 0 {
+		return nil, fmt.Errorf("%q and %q must not be negative", SubscriberPauseAfterKey, SubscriberProbeIntervalKey)
+	}
+	if err := c.Fanout.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %q or %q: %w", FanoutSoftLimitKey, FanoutHardLimitKey, err)
+	}
+	if err := c.Quota.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %q or %q: %w", QuotaMaxChannelsKey, QuotaMaxSubscriptionsKey, err)
+	}
+	if c.EgressEventsPerSecond < 0 || c.EgressBytesPerSecond < 0 {
+		return nil, fmt.Errorf("%q and %q must not be negative", EgressEventsPerSecondKey, EgressBytesPerSecondKey)
+	}
+	if c.AvroSchemaCacheTTL < 0 {
+		return nil, fmt.Errorf("%q must not be negative", AvroSchemaCacheTTLKey)
+	}
+	if err := (v1beta1.NatssChannelCluster{NatsURL: c.ServerURL, ClusterID: c.ServerClusterID}).Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid %q or %q: %w", ServerURLKey, ServerClusterIDKey, err)
+	}
+	if c.ServerReconnectBackoff < 0 || c.ServerReconnectMaxBackoff < 0 {
+		return nil, fmt.Errorf("%q and %q must not be negative", ServerReconnectBackoffKey, ServerReconnectMaxBackoffKey)
+	}
+	if c.ServerReconnectMaxBackoff > 0 && c.ServerReconnectBackoff > c.ServerReconnectMaxBackoff {
+		return nil, fmt.Errorf("%q must not be above %q", ServerReconnectBackoffKey, ServerReconnectMaxBackoffKey)
+	}
+	if c.Probe.Interval <= 0 || c.Probe.Timeout <= 0 {
+		return nil, fmt.Errorf("%q and %q must be positive", ProbeIntervalKey, ProbeTimeoutKey)
+	}
+	if ns := c.Probe.Namespace; ns != "" {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %q %q: %s", ProbeNamespaceKey, ns, strings.Join(errs, ", "))
+		}
+	}
+	if err := c.Storage.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.Address.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %q or %q: %w", AddressSchemeKey, AddressPortKey, err)
+	}
+	if err := c.Security.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid security configuration: %w", err)
+	}
+	if c.AuthSecretName != "" {
+		if errs := validation.IsDNS1123Subdomain(c.AuthSecretName); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %q: %s", AuthSecretNameKey, strings.Join(errs, ", "))
+		}
+	}
+	if c.DeliveryReports.BatchSize <= 0 || c.DeliveryReports.FlushInterval <= 0 {
+		return nil, fmt.Errorf("%q and %q must be positive", DeliveryReportsBatchSizeKey, DeliveryReportsFlushIntervalKey)
+	}
+	if c.DeliveryReports.QueueSize < c.DeliveryReports.BatchSize {
+		return nil, fmt.Errorf("%q must not be less than %q", DeliveryReportsQueueSizeKey, DeliveryReportsBatchSizeKey)
+	}
+	if err := c.OfflineBuffer.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.ReceiverPublish.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.SupportBundle.validate(); err != nil {
+		return nil, err
+	}
+	for key, period := range map[string]time.Duration{
+		ControllerResyncPeriodKey:         c.ControllerResync.Period,
+		ControllerNotReadyResyncPeriodKey: c.ControllerResync.NotReadyPeriod,
+		DispatcherResyncPeriodKey:         c.DispatcherResync.Period,
+		DispatcherNotReadyResyncPeriodKey: c.DispatcherResync.NotReadyPeriod,
+	} {
+		if period < 0 {
+			return nil, fmt.Errorf("%q must not be negative", key)
+		}
+	}
+	return c, nil
+}
+
+// newDeliverySpec returns the delivery spec of the retries, leaving unset the backoff not
+// configured.
+func newDeliverySpec(retry int, backoffPolicy, backoffDelay string) *eventingduckv1.DeliverySpec {
+	retries := int32(retry)
+	spec := &eventingduckv1.DeliverySpec{Retry: &retries}
+	if backoffPolicy != "" {
+		policy := eventingduckv1.BackoffPolicyType(backoffPolicy)
+		spec.BackoffPolicy = &policy
+	}
+	if backoffDelay != "" {
+		spec.BackoffDelay = &backoffDelay
+	}
+	return spec
+}
+
+func (s *Storage) validate() error {
+	if s.MonitoringURL != nil && s.Capacity <= 0 {
+		return fmt.Errorf("%q must be positive with %q", StorageCapacityKey, StorageMonitoringURLKey)
+	}
+	if s.WarningPercent <= 0 || s.CriticalPercent < s.WarningPercent || s.CriticalPercent > 100 {
+		return fmt.Errorf("%q and %q must be percentages, the first one positive and not above the second one", StorageWarningPercentKey, StorageCriticalPercentKey)
+	}
+	if s.PollInterval <= 0 {
+		return fmt.Errorf("%q must be positive", StoragePollIntervalKey)
+	}
+	return nil
+}
+
+// NamespaceOverridableKeys are the keys a config-natss ConfigMap in the namespace of channels may
+// set for them. They tune the deliveries of the channels only: the credentials, the URLs and the
+// settings of the dispatcher as a whole are left to the ConfigMap of the system namespace.
+var NamespaceOverridableKeys = sets.NewString(
+	ResponseCodePolicyKey,
+	DeliveryMaxRedirectsKey,
+	DeliveryMaxInflightKey,
+	DeliveryAckWaitKey,
+	DeliveryStartAtKey,
+)
+
+// WithNamespaceOverrides returns the Config of the channels of a namespace: c overridden by the
+// keys of NamespaceOverridableKeys set by cm, the config-natss ConfigMap of the namespace, c itself
+// when cm is nil. The other keys of cm are ignored and returned, sorted.
+func (c *Config) WithNamespaceOverrides(cm *corev1.ConfigMap) (*Config, []string, error) {
+	if cm == nil {
+		return c, nil, nil
+	}
+	var ignored []string
+	for key := range cm.Data {
+		if !NamespaceOverridableKeys.Has(key) {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+
+	overridden := *c
+	if err := overridden.parseOverridable(cm.Data); err != nil {
+		return nil, ignored, err
+	}
+	return &overridden, ignored, nil
+}
+
+// parseOverridable parses the keys of NamespaceOverridableKeys set in data into c.
+func (c *Config) parseOverridable(data map[string]string) error {
+	if err := configmap.Parse(data,
+		asResponseCodePolicy(ResponseCodePolicyKey, &c.ResponseCodePolicy),
+		configmap.AsInt(DeliveryMaxRedirectsKey, &c.DeliveryMaxRedirects),
+		configmap.AsInt(DeliveryMaxInflightKey, &c.DeliveryMaxInflight),
+		configmap.AsDuration(DeliveryAckWaitKey, &c.DeliveryAckWait),
+		asStartPosition(DeliveryStartAtKey, &c.DeliveryStartAt),
+	); err != nil {
+		return err
+	}
+	if c.DeliveryMaxRedirects < 0 {
+		return fmt.Errorf("%q must not be negative", DeliveryMaxRedirectsKey)
+	}
+	if c.DeliveryMaxInflight < 0 || c.DeliveryMaxInflight > v1beta1.MaxInflightLimit {
+		return fmt.Errorf("%q must be between 0 and %d", DeliveryMaxInflightKey, v1beta1.MaxInflightLimit)
+	}
+	if c.DeliveryAckWait != 0 {
+		if err := v1beta1.ValidateAckWait(c.DeliveryAckWait); err != nil {
+			return fmt.Errorf("invalid %q: %w", DeliveryAckWaitKey, err)
+		}
+	}
+	return nil
+}
+
+func asResponseCodePolicy(key string, target *v1beta1.ResponseCodePolicy) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		if raw, ok := data[key]; ok {
+			p, err := v1beta1.ParseResponseCodePolicy(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			*target = p
+		}
+		return nil
+	}
+}
+
+func asStartPosition(key string, target *v1beta1.StartPosition) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		if raw, ok := data[key]; ok {
+			p := v1beta1.StartPosition(raw)
+			if err := p.Validate(context.Background()); err != nil {
+				return fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			*target = p
+		}
+		return nil
+	}
+}
+
+// asFeatures parses the feature flags of the features section.
+func asFeatures(target **features.Flags) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		flags, err := features.NewFlagsFromMap(data)
+		if err != nil {
+			return err
+		}
+		*target = flags
+		return nil
+	}
+}
+
+// asURL parses the absolute URL of key, an empty value leaving target nil.
+func asURL(key string, target **apis.URL) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		raw := data[key]
+		if raw == "" {
+			return nil
+		}
+		u, err := apis.ParseURL(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%q must be an absolute URL, got %q", key, raw)
+		}
+		*target = u
+		return nil
+	}
+}
+
+// asBytes parses the quantity of bytes of key, like 10Gi.
+func asBytes(key string, target *int64) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		var q *resource.Quantity
+		if err := configmap.AsQuantity(key, &q)(data); err != nil {
+			return err
+		}
+		if q != nil {
+			*target = q.Value()
+		}
+		return nil
+	}
+}
+
+// asNamespacedURLs parses the absolute URLs of the keys made of prefix and a namespace, by
+// namespace, target staying nil without such keys.
+func asNamespacedURLs(prefix string, target *map[string]*apis.URL) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		for key := range data {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			namespace := strings.TrimPrefix(key, prefix)
+			if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+				return fmt.Errorf("%q does not end with a namespace: %s", key, strings.Join(errs, ", "))
+			}
+			var u *apis.URL
+			if err := asURL(key, &u)(data); err != nil {
+				return err
+			}
+			if u == nil {
+				continue
+			}
+			if *target == nil {
+				*target = make(map[string]*apis.URL)
+			}
+			(*target)[namespace] = u
+		}
+		return nil
+	}
+}
+
+// asCIDRs parses the comma separated CIDRs of key, the IP addresses without prefix length
+// standing for themselves.
+func asCIDRs(key string, target *[]*net.IPNet) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		for _, raw := range strings.Split(data[key], ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			if ip := net.ParseIP(raw); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				raw = fmt.Sprintf("%s/%d", ip, bits)
+			}
+			_, n, err := net.ParseCIDR(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse %q: %w", key, err)
+			}
+			*target = append(*target, n)
+		}
+		return nil
+	}
+}
+
+// asReservedExtensions parses whether key rejects the reserved extensions, an empty value
+// stripping them.
+func asReservedExtensions(key string, reject *bool) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		switch raw := strings.ToLower(strings.TrimSpace(data[key])); raw {
+		case "", ReservedExtensionsStrip:
+			*reject = false
+		case ReservedExtensionsReject:
+			*reject = true
+		default:
+			return fmt.Errorf("failed to parse %q: unknown value %q, expected %q or %q", key, raw, ReservedExtensionsStrip, ReservedExtensionsReject)
+		}
+		return nil
+	}
+}
+
+// asCardinality parses the cardinality.Mode of key, leaving mode empty when it is not set.
+func asCardinality(key string, mode *cardinality.Mode) configmap.ParseFunc {
+	return func(data map[string]string) error {
+		raw := strings.TrimSpace(data[key])
+		if raw == "" {
+			return nil
+		}
+		parsed, err := cardinality.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+		*mode = parsed
+		return nil
+	}
+}
+
+// Get reads the NATSS channel configuration from the system namespace, falling
+// back to the default Config when the ConfigMap does not exist.
+func Get(ctx context.Context) (*Config, error) {
+	cm, err := kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace()).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return NewConfigFromConfigMap(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigFromConfigMap(cm)
+}
+
+// GetAuth reads the NATS credentials of the dispatcher from the Secret secretName of the system
+// namespace, returning the zero Auth when secretName is empty. The Secret must set exactly one
+// authentication mode.
+func GetAuth(ctx context.Context, secretName string) (stanutil.Auth, error) {
+	if secretName == "" {
+		return stanutil.Auth{}, nil
+	}
+	secret, err := kubeclient.Get(ctx).CoreV1().Secrets(system.Namespace()).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return stanutil.Auth{}, fmt.Errorf("failed to get the NATS credentials: %w", err)
+	}
+	auth := stanutil.AuthFromSecret(secret.Data)
+	if err := auth.Validate(); err != nil {
+		return stanutil.Auth{}, fmt.Errorf("invalid NATS credentials in secret %q: %w", secretName, err)
+	}
+	return auth, nil
+}
+
+// Watch calls observer with the NATSS channel configuration every time the ConfigMap changes. The
+// default Config is observed when the ConfigMap does not exist, and invalid changes are ignored.
+func Watch(ctx context.Context, cmw configmap.Watcher, observer func(*Config)) {
+	logger := logging.FromContext(ctx)
+	watchWithDefault(cmw, ConfigMapName, func(cm *corev1.ConfigMap) {
+		c, err := NewConfigFromConfigMap(cm)
+		if err != nil {
+			logger.Errorw("Ignoring the invalid NATSS channel configuration", zap.Error(err))
+			return
+		}
+		observer(c)
+	})
+}
+
+// watchWithDefault watches the ConfigMap name of the system namespace, observing an empty
+// ConfigMap while it does not exist when cmw supports it.
+func watchWithDefault(cmw configmap.Watcher, name string, o configmap.Observer) {
+	if iw, ok := cmw.(*configmap.InformedWatcher); ok {
+		iw.WatchWithDefault(corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: system.Namespace()},
+		}, o)
+		return
+	}
+	cmw.Watch(name, o)
+}
@@ -0,0 +1,1572 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/system"
+
+	_ "knative.dev/pkg/system/testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/cardinality"
+	"knative.dev/eventing-natss/pkg/features"
+	"knative.dev/eventing-natss/pkg/security"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+var defaultCertManager = CertManager{IssuerKind: CertManagerIssuer}
+
+var defaultDeliveryReports = DeliveryReports{
+	BatchSize:     DefaultDeliveryReportsBatchSize,
+	FlushInterval: DefaultDeliveryReportsFlushInterval,
+	QueueSize:     DefaultDeliveryReportsQueueSize,
+}
+
+var defaultProbe = Probe{Interval: DefaultProbeInterval, Timeout: DefaultProbeTimeout}
+
+var defaultStorage = Storage{
+	WarningPercent:  DefaultStorageWarningPercent,
+	CriticalPercent: DefaultStorageCriticalPercent,
+	PollInterval:    DefaultStoragePollInterval,
+}
+
+var defaultOfflineBuffer = OfflineBuffer{
+	MaxBytes:       DefaultOfflineBufferMaxBytes,
+	MaxOutage:      DefaultOfflineBufferMaxOutage,
+	OverflowPolicy: OfflineBufferFlush,
+}
+
+var defaultReceiverPublish = Publish{
+	Mode:        PublishSync,
+	MaxInflight: stan.DefaultMaxPubAcksInflight,
+	AckTimeout:  stan.DefaultAckWait,
+}
+
+var defaultSupportBundle = SupportBundle{MaxBytes: DefaultSupportBundleMaxBytes}
+
+func TestNewConfigFromConfigMap(t *testing.T) {
+	testCases := map[string]struct {
+		cm      *corev1.ConfigMap
+		want    *Config
+		wantErr bool
+	}{
+		"nil configmap": {
+			want: &Config{Transport: DefaultTransport, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"empty configmap": {
+			cm:   &corev1.ConfigMap{},
+			want: &Config{Transport: DefaultTransport, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"transport": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{TransportKey: "jetstream"},
+			},
+			want: &Config{Transport: "jetstream", OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"persist host map": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{PersistHostMapKey: "true"},
+			},
+			want: &Config{Transport: DefaultTransport, PersistHostMap: true, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"dispatcher require replicas": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherRequireReplicasKey: "true"},
+			},
+			want: &Config{Transport: DefaultTransport, DispatcherRequireReplicas: true, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"response code policy": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ResponseCodePolicyKey: "404=deadletter,429=retry"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				ResponseCodePolicy: v1beta1.ResponseCodePolicy{
+					"404": v1beta1.ResponseActionDeadLetter,
+					"429": v1beta1.ResponseActionRetry,
+				},
+				DeliveryReports: defaultDeliveryReports,
+				Probe:           defaultProbe,
+			},
+		},
+		"warm up subscribers": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{"features.warm-up-subscribers": "enabled"},
+			},
+			want: &Config{Transport: DefaultTransport, Features: &features.Flags{WarmUpSubscribers: features.Enabled, OrphanAuditDelete: features.Disabled, DeliveryCursors: features.Disabled, InsecureDeliveryCondition: features.Disabled, OrphanedSubscriberPause: features.Disabled}, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"durable naming scheme": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DurableNamingSchemeKey: "v2"},
+			},
+			want: &Config{Transport: DefaultTransport, DurableNamingScheme: DurableNamingV2, OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod, AvroSchemaCacheTTL: DefaultAvroSchemaCacheTTL, DeliveryMaxRedirects: DefaultDeliveryMaxRedirects, DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit, DeliveryUserAgent: DefaultDeliveryUserAgent, DeliveryOrigin: DefaultDeliveryOrigin, DeliveryReports: defaultDeliveryReports, Probe: defaultProbe},
+		},
+		"invalid durable naming scheme": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DurableNamingSchemeKey: "v3"},
+			},
+			wantErr: true,
+		},
+		"cert-manager": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					CertManagerEnabledKey:    "true",
+					CertManagerIssuerNameKey: "natss-ca",
+					CertManagerIssuerKindKey: CertManagerClusterIssuer,
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				CertManager:            CertManager{Enabled: true, IssuerName: "natss-ca", IssuerKind: CertManagerClusterIssuer},
+			},
+		},
+		"cert-manager without issuer": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{CertManagerEnabledKey: "true"},
+			},
+			wantErr: true,
+		},
+		"invalid cert-manager issuer kind": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					CertManagerEnabledKey:    "true",
+					CertManagerIssuerNameKey: "natss-ca",
+					CertManagerIssuerKindKey: "Vault",
+				},
+			},
+			wantErr: true,
+		},
+		"orphan audit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					OrphanAuditIntervalKey:    "1h",
+					OrphanAuditGracePeriodKey: "48h",
+					// The key of the flag before the features section still applies.
+					"orphan-audit-delete": "true",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditInterval:    time.Hour,
+				OrphanAuditGracePeriod: 48 * time.Hour,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				Features:               &features.Flags{WarmUpSubscribers: features.Disabled, OrphanAuditDelete: features.Enabled, DeliveryCursors: features.Disabled, InsecureDeliveryCondition: features.Disabled, OrphanedSubscriberPause: features.Disabled},
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"delivery headers": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					DeliveryUserAgentKey: "natss/{version}",
+					DeliveryOriginKey:    "",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      "natss/{version}",
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"resync": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					ControllerResyncPeriodKey:         "1h",
+					ControllerNotReadyResyncPeriodKey: "1m",
+					DispatcherNotReadyResyncPeriodKey: "30s",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				ControllerResync:       Resync{Period: time.Hour, NotReadyPeriod: time.Minute},
+				DispatcherResync:       Resync{NotReadyPeriod: 30 * time.Second},
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"hibernation": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{HibernationThresholdKey: "168h"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				HibernationThreshold:   7 * 24 * time.Hour,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"dispatcher drain timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherDrainTimeoutKey: "1m"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DispatcherDrainTimeout: time.Minute,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"dispatcher liveness threshold": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherLivenessThresholdKey: "10m"},
+			},
+			want: &Config{
+				Transport:                   DefaultTransport,
+				OrphanAuditGracePeriod:      DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:          DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:        DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit:      DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:           DefaultDeliveryUserAgent,
+				DeliveryOrigin:              DefaultDeliveryOrigin,
+				DispatcherLivenessThreshold: 10 * time.Minute,
+				DeliveryReports:             defaultDeliveryReports,
+				Probe:                       defaultProbe,
+			},
+		},
+		"server": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					ServerURLKey:                 "nats://a:4222,nats://b:4222",
+					ServerClusterIDKey:           "cluster-b",
+					ServerReconnectBackoffKey:    "2s",
+					ServerReconnectMaxBackoffKey: "1m",
+				},
+			},
+			want: &Config{
+				Transport:                 DefaultTransport,
+				OrphanAuditGracePeriod:    DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:        DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:      DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit:    DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:         DefaultDeliveryUserAgent,
+				DeliveryOrigin:            DefaultDeliveryOrigin,
+				ServerURL:                 "nats://a:4222,nats://b:4222",
+				ServerClusterID:           "cluster-b",
+				ServerReconnectBackoff:    2 * time.Second,
+				ServerReconnectMaxBackoff: time.Minute,
+				DeliveryReports:           defaultDeliveryReports,
+				Probe:                     defaultProbe,
+			},
+		},
+		"subscriber pause": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SubscriberPauseAfterKey: "10m", SubscriberProbeIntervalKey: "1m"},
+			},
+			want: &Config{
+				Transport:               DefaultTransport,
+				OrphanAuditGracePeriod:  DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:      DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:    DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit:  DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:       DefaultDeliveryUserAgent,
+				DeliveryOrigin:          DefaultDeliveryOrigin,
+				SubscriberPauseAfter:    10 * time.Minute,
+				SubscriberProbeInterval: time.Minute,
+				DeliveryReports:         defaultDeliveryReports,
+				Probe:                   defaultProbe,
+			},
+		},
+		"subscriber connect check": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SubscriberConnectCheckKey: "true"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				SubscriberConnectCheck: true,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"negative subscriber pause": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SubscriberPauseAfterKey: "-1m"},
+			},
+			wantErr: true,
+		},
+		"namespace quota": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{QuotaMaxChannelsKey: "20", QuotaMaxSubscriptionsKey: "100"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				Quota:                  NamespaceQuota{Channels: 20, Subscriptions: 100},
+			},
+		},
+		"negative namespace quota": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{QuotaMaxSubscriptionsKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"avro schema cache": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{AvroSchemaCacheTTLKey: "0s"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"redirects disallowed": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxRedirectsKey: "0"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"error body limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryErrorBodyLimitKey: "4Ki"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: 4096,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"error bodies disabled": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryErrorBodyLimitKey: "0"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"default delivery": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryRetryKey: "3", DeliveryBackoffPolicyKey: "linear", DeliveryBackoffDelayKey: "PT0.5S"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				DefaultDelivery:        newDeliverySpec(3, "linear", "PT0.5S"),
+			},
+		},
+		"default delivery without backoff": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryRetryKey: "2"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				DefaultDelivery:        newDeliverySpec(2, "", ""),
+			},
+		},
+		"invalid backoff delay": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryRetryKey: "2", DeliveryBackoffDelayKey: "500ms"},
+			},
+			wantErr: true,
+		},
+		"delivery timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryTimeoutKey: "PT30S"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				DeliveryTimeout:        30 * time.Second,
+			},
+		},
+		"egress limits": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{EgressEventsPerSecondKey: "500", EgressBytesPerSecondKey: "10Mi"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				EgressEventsPerSecond:  500,
+				EgressBytesPerSecond:   10 << 20,
+			},
+		},
+		"maintenance mode": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{MaintenanceModeKey: "true"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				MaintenanceMode:        true,
+			},
+		},
+		"negative egress limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{EgressEventsPerSecondKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"invalid delivery timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryTimeoutKey: "30s"},
+			},
+			wantErr: true,
+		},
+		"non positive delivery timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryTimeoutKey: "PT0S"},
+			},
+			wantErr: true,
+		},
+		"invalid backoff policy": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryRetryKey: "2", DeliveryBackoffPolicyKey: "random"},
+			},
+			wantErr: true,
+		},
+		"negative retry": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryRetryKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"advertised address": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{AddressSchemeKey: "https", AddressPortKey: "443"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				Address:                Address{Scheme: "https", Port: 443},
+			},
+		},
+		"invalid address scheme": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{AddressSchemeKey: "tcp"},
+			},
+			wantErr: true,
+		},
+		"invalid address port": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{AddressPortKey: "70000"},
+			},
+			wantErr: true,
+		},
+		"fan-out limits": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{FanoutSoftLimitKey: "10", FanoutHardLimitKey: "50"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				Fanout:                 FanoutLimits{Soft: 10, Hard: 50},
+			},
+		},
+		"fan-out soft limit above the hard limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{FanoutSoftLimitKey: "50", FanoutHardLimitKey: "10"},
+			},
+			wantErr: true,
+		},
+		"negative fan-out limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{FanoutHardLimitKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"negative error body limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryErrorBodyLimitKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"max inflight": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxInflightKey: "16"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryMaxInflight:    16,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"delivery concurrency": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryConcurrencyKey: "32"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryConcurrency:    32,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"support bundle": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SupportBundleDirKey: "/var/run/support", SupportBundleMaxBytesKey: "1Mi"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				SupportBundle:          SupportBundle{Dir: "/var/run/support", MaxBytes: 1 << 20},
+			},
+		},
+		"zero support bundle size": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SupportBundleMaxBytesKey: "0"},
+			},
+			wantErr: true,
+		},
+		"negative delivery concurrency": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryConcurrencyKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"negative max inflight": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxInflightKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"max inflight above the limit": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxInflightKey: "10001"},
+			},
+			wantErr: true,
+		},
+		"ack wait and start position": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryAckWaitKey: "5m", DeliveryStartAtKey: "all-available"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryAckWait:        5 * time.Minute,
+				DeliveryStartAt:        v1beta1.StartPositionAllAvailable,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+			},
+		},
+		"invalid ack wait": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryAckWaitKey: "five minutes"},
+			},
+			wantErr: true,
+		},
+		"ack wait below a second": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryAckWaitKey: "500ms"},
+			},
+			wantErr: true,
+		},
+		"ack wait above an hour": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryAckWaitKey: "2h"},
+			},
+			wantErr: true,
+		},
+		"unknown start position": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryStartAtKey: "last-received"},
+			},
+			wantErr: true,
+		},
+		"negative max redirects": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxRedirectsKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"negative dispatcher drain timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherDrainTimeoutKey: "-1s"},
+			},
+			wantErr: true,
+		},
+		"negative dispatcher liveness threshold": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherLivenessThresholdKey: "-1m"},
+			},
+			wantErr: true,
+		},
+		"invalid server url": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ServerURLKey: "http://natss:4222"},
+			},
+			wantErr: true,
+		},
+		"invalid server cluster id": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ServerClusterIDKey: "my cluster"},
+			},
+			wantErr: true,
+		},
+		"server reconnect backoff above its max": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ServerReconnectBackoffKey: "2m", ServerReconnectMaxBackoffKey: "1m"},
+			},
+			wantErr: true,
+		},
+		"negative hibernation threshold": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{HibernationThresholdKey: "-1h"},
+			},
+			wantErr: true,
+		},
+		"security": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					SecurityStrictKey:           "true",
+					SecurityCAFileKey:           "/etc/natss/ca.pem",
+					SecurityReceiverCertFileKey: "/etc/receiver/tls.crt",
+					SecurityReceiverKeyFileKey:  "/etc/receiver/tls.key",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				Security: security.Config{
+					Strict:           true,
+					CAFile:           "/etc/natss/ca.pem",
+					ReceiverCertFile: "/etc/receiver/tls.crt",
+					ReceiverKeyFile:  "/etc/receiver/tls.key",
+				},
+				DeliveryReports: defaultDeliveryReports,
+				Probe:           defaultProbe,
+			},
+		},
+		"client credentials": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					SecurityClientCertFileKey:     "/etc/natss-client/tls.crt",
+					SecurityClientKeyFileKey:      "/etc/natss-client/tls.key",
+					SecurityInsecureSkipVerifyKey: "true",
+					AuthSecretNameKey:             "nats-credentials",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				Security: security.Config{
+					ClientCertFile:     "/etc/natss-client/tls.crt",
+					ClientKeyFile:      "/etc/natss-client/tls.key",
+					InsecureSkipVerify: true,
+				},
+				AuthSecretName:  "nats-credentials",
+				DeliveryReports: defaultDeliveryReports,
+				Probe:           defaultProbe,
+			},
+		},
+		"delivery reports": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					DeliveryReportsSinkKey:          "https://lineage.example.com/reports",
+					DeliveryReportsBatchSizeKey:     "50",
+					DeliveryReportsFlushIntervalKey: "1s",
+					DeliveryReportsQueueSizeKey:     "500",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports: DeliveryReports{
+					Sink:          apis.HTTPS("lineage.example.com").ResolveReference(&apis.URL{Path: "/reports"}),
+					BatchSize:     50,
+					FlushInterval: time.Second,
+					QueueSize:     500,
+				},
+				Probe: defaultProbe,
+			},
+		},
+		"relative delivery reports sink": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryReportsSinkKey: "/reports"},
+			},
+			wantErr: true,
+		},
+		"delivery reports queue smaller than a batch": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryReportsQueueSizeKey: "10"},
+			},
+			wantErr: true,
+		},
+		"offline buffer": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					OfflineBufferMaxEventsKey:      "1000",
+					OfflineBufferMaxBytesKey:       "1Mi",
+					OfflineBufferMaxOutageKey:      "10s",
+					OfflineBufferOverflowPolicyKey: "drop",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				OfflineBuffer: OfflineBuffer{
+					MaxEvents:      1000,
+					MaxBytes:       1 << 20,
+					MaxOutage:      10 * time.Second,
+					OverflowPolicy: OfflineBufferDrop,
+				},
+				Probe: defaultProbe,
+			},
+		},
+		"negative offline buffer": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{OfflineBufferMaxEventsKey: "-1"},
+			},
+			wantErr: true,
+		},
+		"unknown offline buffer overflow policy": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{OfflineBufferOverflowPolicyKey: "block"},
+			},
+			wantErr: true,
+		},
+		"trusted proxies": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverTrustedProxiesKey: "10.0.0.0/8, 192.168.1.7,fd00::/8"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				ReceiverTrustedProxies: []*net.IPNet{
+					mustParseCIDR(t, "10.0.0.0/8"),
+					mustParseCIDR(t, "192.168.1.7/32"),
+					mustParseCIDR(t, "fd00::/8"),
+				},
+			},
+		},
+		"reject reserved extensions": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverReservedExtensionsKey: "Reject"},
+			},
+			want: &Config{
+				Transport:                        DefaultTransport,
+				OrphanAuditGracePeriod:           DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:               DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:             DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit:           DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:                DefaultDeliveryUserAgent,
+				DeliveryOrigin:                   DefaultDeliveryOrigin,
+				DeliveryReports:                  defaultDeliveryReports,
+				Probe:                            defaultProbe,
+				ReceiverRejectReservedExtensions: true,
+			},
+		},
+		"async publish": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					ReceiverPublishModeKey:        "async",
+					ReceiverPublishMaxInflightKey: "1024",
+					ReceiverPublishAckTimeoutKey:  "5s",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				ReceiverPublish: Publish{
+					Mode:        PublishAsync,
+					MaxInflight: 1024,
+					AckTimeout:  5 * time.Second,
+				},
+			},
+		},
+		"unknown publish mode": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverPublishModeKey: "batch"},
+			},
+			wantErr: true,
+		},
+		"zero publish ack timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverPublishAckTimeoutKey: "0s"},
+			},
+			wantErr: true,
+		},
+		"metrics cardinality": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{MetricsCardinalityKey: "Channel"},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				MetricsCardinality:     cardinality.Channel,
+			},
+		},
+		"invalid metrics cardinality": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{MetricsCardinalityKey: "subscription"},
+			},
+			wantErr: true,
+		},
+		"invalid reserved extensions": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverReservedExtensionsKey: "drop"},
+			},
+			wantErr: true,
+		},
+		"partitioned server": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					ServerPartitionedKey:            "true",
+					ServerChannelProvisioningURLKey: "http://natss-admin.natss.svc.cluster.local/channels",
+				},
+			},
+			want: &Config{
+				Transport:                    DefaultTransport,
+				OrphanAuditGracePeriod:       DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:           DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:         DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit:       DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:            DefaultDeliveryUserAgent,
+				DeliveryOrigin:               DefaultDeliveryOrigin,
+				DeliveryReports:              defaultDeliveryReports,
+				Probe:                        defaultProbe,
+				ServerPartitioned:            true,
+				ServerChannelProvisioningURL: apis.HTTP("natss-admin.natss.svc.cluster.local").ResolveReference(&apis.URL{Path: "/channels"}),
+			},
+		},
+		"probe": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					ProbeNamespaceKey: "natss-probe",
+					ProbeIntervalKey:  "1m",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  Probe{Namespace: "natss-probe", Interval: time.Minute, Timeout: DefaultProbeTimeout},
+			},
+		},
+		"storage monitor": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					StorageMonitoringURLKey:   "http://nats-streaming.natss.svc.cluster.local:8222/streaming/storez",
+					StorageCapacityKey:        "10Gi",
+					StorageWarningPercentKey:  "70",
+					StorageCriticalPercentKey: "90.5",
+					StoragePollIntervalKey:    "10s",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				Storage: Storage{
+					MonitoringURL:   apis.HTTP("nats-streaming.natss.svc.cluster.local:8222").ResolveReference(&apis.URL{Path: "/streaming/storez"}),
+					Capacity:        10 << 30,
+					WarningPercent:  70,
+					CriticalPercent: 90.5,
+					PollInterval:    10 * time.Second,
+				},
+			},
+		},
+		"storage monitor without capacity": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{StorageMonitoringURLKey: "http://nats-streaming.natss.svc.cluster.local:8222/streaming/storez"},
+			},
+			wantErr: true,
+		},
+		"storage warning above critical": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{StorageWarningPercentKey: "96"},
+			},
+			wantErr: true,
+		},
+		"invalid storage capacity": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{StorageCapacityKey: "ten gigs"},
+			},
+			wantErr: true,
+		},
+		"invalid probe namespace": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ProbeNamespaceKey: "Natss_Probe"},
+			},
+			wantErr: true,
+		},
+		"zero probe timeout": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ProbeTimeoutKey: "0s"},
+			},
+			wantErr: true,
+		},
+		"relative channel provisioning URL": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ServerChannelProvisioningURLKey: "/channels"},
+			},
+			wantErr: true,
+		},
+		"resync request": {
+			cm: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{ResyncAnnotation: "2020-10-01T12:00:00Z"},
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				ResyncRequest:          "2020-10-01T12:00:00Z",
+			},
+		},
+		"default dead letter sinks": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					DefaultDeadLetterSinkKeyPrefix + "team-a": "http://dls.team-a.svc.cluster.local",
+					DefaultDeadLetterSinkKeyPrefix + "team-b": "",
+				},
+			},
+			want: &Config{
+				Transport:              DefaultTransport,
+				OrphanAuditGracePeriod: DefaultOrphanAuditGracePeriod,
+				AvroSchemaCacheTTL:     DefaultAvroSchemaCacheTTL,
+				DeliveryMaxRedirects:   DefaultDeliveryMaxRedirects,
+				DeliveryErrorBodyLimit: DefaultDeliveryErrorBodyLimit,
+				DeliveryUserAgent:      DefaultDeliveryUserAgent,
+				DeliveryOrigin:         DefaultDeliveryOrigin,
+				DeliveryReports:        defaultDeliveryReports,
+				Probe:                  defaultProbe,
+				DefaultDeadLetterSinks: map[string]*apis.URL{
+					"team-a": apis.HTTP("dls.team-a.svc.cluster.local"),
+				},
+			},
+		},
+		"default dead letter sink without namespace": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DefaultDeadLetterSinkKeyPrefix: "http://dls.svc.cluster.local"},
+			},
+			wantErr: true,
+		},
+		"relative default dead letter sink": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DefaultDeadLetterSinkKeyPrefix + "team-a": "/dls"},
+			},
+			wantErr: true,
+		},
+		"invalid trusted proxy": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ReceiverTrustedProxiesKey: "10.0.0.0/33"},
+			},
+			wantErr: true,
+		},
+		"receiver certificate without key": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SecurityReceiverCertFileKey: "/etc/receiver/tls.crt"},
+			},
+			wantErr: true,
+		},
+		"invalid auth secret name": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{AuthSecretNameKey: "NATS_Credentials"},
+			},
+			wantErr: true,
+		},
+		"client key without certificate": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SecurityClientKeyFileKey: "/etc/natss-client/tls.key"},
+			},
+			wantErr: true,
+		},
+		"strict without verification": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{
+					SecurityStrictKey:             "true",
+					SecurityInsecureSkipVerifyKey: "true",
+				},
+			},
+			wantErr: true,
+		},
+		"invalid receiver certificate authorities": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{SecurityReceiverCACertsKey: "not a certificate"},
+			},
+			wantErr: true,
+		},
+		"invalid feature flag": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{"features.warm-up-subscribers": "on"},
+			},
+			wantErr: true,
+		},
+		"negative resync period": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{DispatcherResyncPeriodKey: "-1h"},
+			},
+			wantErr: true,
+		},
+		"negative orphan audit interval": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{OrphanAuditIntervalKey: "-1h"},
+			},
+			wantErr: true,
+		},
+		"invalid response code policy": {
+			cm: &corev1.ConfigMap{
+				Data: map[string]string{ResponseCodePolicyKey: "2xx=drop"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			// The feature flags are tested by their package.
+			if tc.want != nil && tc.want.Features == nil {
+				tc.want.Features = features.Defaults()
+			}
+			// The cases leave the storage monitor alone unless they configure it.
+			if tc.want != nil && tc.want.Storage == (Storage{}) {
+				tc.want.Storage = defaultStorage
+			}
+			if tc.want != nil && tc.want.OfflineBuffer == (OfflineBuffer{}) {
+				tc.want.OfflineBuffer = defaultOfflineBuffer
+			}
+			if tc.want != nil && tc.want.ReceiverPublish == (Publish{}) {
+				tc.want.ReceiverPublish = defaultReceiverPublish
+			}
+			if tc.want != nil && tc.want.SupportBundle == (SupportBundle{}) {
+				tc.want.SupportBundle = defaultSupportBundle
+			}
+			if tc.want != nil && tc.want.CertManager == (CertManager{}) {
+				tc.want.CertManager = defaultCertManager
+			}
+			if tc.want != nil && tc.want.DurableNamingScheme == "" {
+				tc.want.DurableNamingScheme = DurableNamingV1
+			}
+			if tc.want != nil && tc.want.DispatcherLivenessThreshold == 0 {
+				tc.want.DispatcherLivenessThreshold = DefaultDispatcherLivenessThreshold
+			}
+			got, err := NewConfigFromConfigMap(tc.cm)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewConfigFromConfigMap() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected config (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFanoutLimitsWithAnnotations(t *testing.T) {
+	global := FanoutLimits{Soft: 10, Hard: 50}
+	tests := map[string]struct {
+		annotations map[string]string
+		want        FanoutLimits
+		wantErr     bool
+	}{
+		"no annotation": {
+			want: global,
+		},
+		"hard limit raised": {
+			annotations: map[string]string{messaging.FanoutHardLimitAnnotationKey: "200"},
+			want:        FanoutLimits{Soft: 10, Hard: 200},
+		},
+		"limits lifted": {
+			annotations: map[string]string{messaging.FanoutSoftLimitAnnotationKey: "0", messaging.FanoutHardLimitAnnotationKey: "0"},
+			want:        FanoutLimits{},
+		},
+		"soft limit above the hard limit": {
+			annotations: map[string]string{messaging.FanoutSoftLimitAnnotationKey: "60"},
+			wantErr:     true,
+		},
+		"not a number": {
+			annotations: map[string]string{messaging.FanoutHardLimitAnnotationKey: "many"},
+			wantErr:     true,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := global.WithAnnotations(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WithAnnotations() = %v, want error: %t", err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("WithAnnotations() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceQuotaWithAnnotations(t *testing.T) {
+	global := NamespaceQuota{Channels: 20, Subscriptions: 100}
+	tests := map[string]struct {
+		annotations map[string]string
+		want        NamespaceQuota
+		wantErr     bool
+	}{
+		"no annotation": {
+			want: global,
+		},
+		"channels raised": {
+			annotations: map[string]string{messaging.QuotaMaxChannelsAnnotationKey: "50"},
+			want:        NamespaceQuota{Channels: 50, Subscriptions: 100},
+		},
+		"quotas lifted": {
+			annotations: map[string]string{messaging.QuotaMaxChannelsAnnotationKey: "0", messaging.QuotaMaxSubscriptionsAnnotationKey: "0"},
+			want:        NamespaceQuota{},
+		},
+		"negative": {
+			annotations: map[string]string{messaging.QuotaMaxSubscriptionsAnnotationKey: "-5"},
+			wantErr:     true,
+		},
+		"not a number": {
+			annotations: map[string]string{messaging.QuotaMaxChannelsAnnotationKey: "many"},
+			wantErr:     true,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := global.WithAnnotations(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WithAnnotations() = %v, want error: %t", err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("WithAnnotations() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithNamespaceOverrides(t *testing.T) {
+	global := &corev1.ConfigMap{
+		Data: map[string]string{
+			ResponseCodePolicyKey:   "404=drop",
+			DeliveryMaxRedirectsKey: "5",
+			DeliveryReportsSinkKey:  "http://reports.svc.cluster.local",
+		},
+	}
+	testCases := map[string]struct {
+		global      *corev1.ConfigMap
+		namespace   *corev1.ConfigMap
+		want        func(*Config)
+		wantIgnored []string
+		wantErr     bool
+	}{
+		"built-in defaults": {},
+		"global": {
+			global: global,
+			want: func(c *Config) {
+				c.ResponseCodePolicy = v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDrop}
+				c.DeliveryMaxRedirects = 5
+			},
+		},
+		"namespace over built-in defaults": {
+			namespace: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxInflightKey: "8"},
+			},
+			want: func(c *Config) {
+				c.DeliveryMaxInflight = 8
+			},
+		},
+		"namespace over global": {
+			global: global,
+			namespace: &corev1.ConfigMap{
+				Data: map[string]string{
+					ResponseCodePolicyKey:   "5xx=deadletter",
+					DeliveryMaxRedirectsKey: "0",
+				},
+			},
+			want: func(c *Config) {
+				c.ResponseCodePolicy = v1beta1.ResponseCodePolicy{"5xx": v1beta1.ResponseActionDeadLetter}
+				c.DeliveryMaxRedirects = 0
+			},
+		},
+		"namespace keys not overridable": {
+			global: global,
+			namespace: &corev1.ConfigMap{
+				Data: map[string]string{
+					DeliveryMaxInflightKey: "8",
+					DeliveryReportsSinkKey: "http://elsewhere.svc.cluster.local",
+					SecurityCAFileKey:      "/etc/ca.pem",
+				},
+			},
+			want: func(c *Config) {
+				c.ResponseCodePolicy = v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDrop}
+				c.DeliveryMaxRedirects = 5
+				c.DeliveryMaxInflight = 8
+			},
+			wantIgnored: []string{DeliveryReportsSinkKey, SecurityCAFileKey},
+		},
+		"invalid namespace value": {
+			global: global,
+			namespace: &corev1.ConfigMap{
+				Data: map[string]string{DeliveryMaxRedirectsKey: "-1"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			c, err := NewConfigFromConfigMap(tc.global)
+			if err != nil {
+				t.Fatalf("NewConfigFromConfigMap() = %v", err)
+			}
+			want, err := NewConfigFromConfigMap(tc.global)
+			if err != nil {
+				t.Fatalf("NewConfigFromConfigMap() = %v", err)
+			}
+			if tc.want != nil {
+				tc.want(want)
+			}
+
+			got, ignored, err := c.WithNamespaceOverrides(tc.namespace)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WithNamespaceOverrides() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.wantIgnored, ignored); diff != "" {
+				t.Errorf("unexpected ignored keys (-want, +got): %s", diff)
+			}
+			if tc.wantErr {
+				return
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected config (-want, +got): %s", diff)
+			}
+		})
+	}
+
+	// The global Config is left unchanged.
+	c, _ := NewConfigFromConfigMap(global)
+	if _, _, err := c.WithNamespaceOverrides(&corev1.ConfigMap{Data: map[string]string{DeliveryMaxRedirectsKey: "1"}}); err != nil {
+		t.Fatalf("WithNamespaceOverrides() = %v", err)
+	}
+	if c.DeliveryMaxRedirects != 5 {
+		t.Errorf("global DeliveryMaxRedirects = %d, want 5", c.DeliveryMaxRedirects)
+	}
+}
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestGet(t *testing.T) {
+	ctx, _ := fakekubeclient.With(context.Background())
+	got, err := Get(ctx)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Transport != DefaultTransport {
+		t.Errorf("Transport without configmap: got %q, want %q", got.Transport, DefaultTransport)
+	}
+
+	ctx, _ = fakekubeclient.With(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{TransportKey: "memory"},
+	})
+	got, err = Get(ctx)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if got.Transport != "memory" {
+		t.Errorf("Transport: got %q, want %q", got.Transport, "memory")
+	}
+}
+
+func TestGetAuth(t *testing.T) {
+	ctx, _ := fakekubeclient.With(context.Background(),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nats-user", Namespace: system.Namespace()},
+			Data: map[string][]byte{
+				stanutil.AuthUserKey:     []byte("dispatcher"),
+				stanutil.AuthPasswordKey: []byte("s3cret\n"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nats-ambiguous", Namespace: system.Namespace()},
+			Data: map[string][]byte{
+				stanutil.AuthUserKey:     []byte("dispatcher"),
+				stanutil.AuthPasswordKey: []byte("s3cret"),
+				stanutil.AuthTokenKey:    []byte("t0ken"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nats-empty", Namespace: system.Namespace()},
+		},
+	)
+
+	if got, err := GetAuth(ctx, ""); err != nil || !got.IsZero() {
+		t.Errorf("GetAuth() = %+v, %v, want no credentials", got, err)
+	}
+	got, err := GetAuth(ctx, "nats-user")
+	if err != nil {
+		t.Fatalf("GetAuth() = %v", err)
+	}
+	if want := (stanutil.Auth{User: "dispatcher", Password: "s3cret"}); !cmp.Equal(got, want) {
+		t.Errorf("GetAuth() = %+v, want %+v", got, want)
+	}
+	for _, name := range []string{"nats-ambiguous", "nats-empty", "nats-missing"} {
+		if _, err := GetAuth(ctx, name); err == nil {
+			t.Errorf("GetAuth(%q) succeeded", name)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{ControllerResyncPeriodKey: "1h"},
+	}
+	cmw := &configmap.ManualWatcher{Namespace: system.Namespace()}
+
+	var got *Config
+	Watch(context.Background(), cmw, func(c *Config) { got = c })
+	cmw.OnChange(cm)
+	if got == nil || got.ControllerResync.Period != time.Hour {
+		t.Fatalf("observed %+v, want a controller resync period of 1h", got)
+	}
+
+	// The feature flags are updated live.
+	cm.Data["features.warm-up-subscribers"] = "enabled"
+	cmw.OnChange(cm)
+	if !got.Features.WarmUpSubscribers.Enabled() {
+		t.Errorf("observed %+v, want warm-up-subscribers enabled", got.Features)
+	}
+
+	// Invalid changes are ignored.
+	cm.Data[ControllerResyncPeriodKey] = "-1h"
+	cmw.OnChange(cm)
+	if got.ControllerResync.Period != time.Hour {
+		t.Errorf("observed the invalid resync period %v", got.ControllerResync.Period)
+	}
+}
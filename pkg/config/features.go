@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// FeaturesConfigMapName is the name of the ConfigMap holding the feature flags of Knative
+	// Eventing, in the system namespace.
+	FeaturesConfigMapName = "config-features"
+
+	// TransportEncryptionKey is the feature flag setting the transport encryption mode, one of
+	// disabled, permissive and strict.
+	TransportEncryptionKey = "transport-encryption"
+)
+
+// Features holds the feature flags of Knative Eventing followed by the NATSS channels.
+type Features struct {
+	// TransportEncryption is the transport encryption mode of the cluster.
+	TransportEncryption security.TransportEncryption
+}
+
+// NewFeaturesFromConfigMap creates Features from the supplied ConfigMap, the flags which are not
+// set keeping their defaults. A nil ConfigMap yields the default Features.
+func NewFeaturesFromConfigMap(cm *corev1.ConfigMap) (*Features, error) {
+	f := &Features{TransportEncryption: security.TransportEncryptionDisabled}
+	if cm == nil {
+		return f, nil
+	}
+	mode, err := security.ParseTransportEncryption(cm.Data[TransportEncryptionKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", TransportEncryptionKey, err)
+	}
+	f.TransportEncryption = mode
+	return f, nil
+}
+
+// GetFeatures reads the feature flags from the system namespace, falling back to the default
+// Features when the ConfigMap does not exist.
+func GetFeatures(ctx context.Context) (*Features, error) {
+	cm, err := kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace()).Get(ctx, FeaturesConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return NewFeaturesFromConfigMap(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewFeaturesFromConfigMap(cm)
+}
+
+// WatchFeatures calls observer with the feature flags every time the ConfigMap changes, as Watch
+// does with the NATSS channel configuration.
+func WatchFeatures(ctx context.Context, cmw configmap.Watcher, observer func(*Features)) {
+	logger := logging.FromContext(ctx)
+	watchWithDefault(cmw, FeaturesConfigMapName, func(cm *corev1.ConfigMap) {
+		f, err := NewFeaturesFromConfigMap(cm)
+		if err != nil {
+			logger.Errorw("Ignoring the invalid feature flags", zap.Error(err))
+			return
+		}
+		observer(f)
+	})
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+func TestNewFeaturesFromConfigMap(t *testing.T) {
+	testCases := map[string]struct {
+		cm      *corev1.ConfigMap
+		want    security.TransportEncryption
+		wantErr bool
+	}{
+		"nil configmap": {
+			want: security.TransportEncryptionDisabled,
+		},
+		"other flags only": {
+			cm:   &corev1.ConfigMap{Data: map[string]string{"kreference-group": "enabled"}},
+			want: security.TransportEncryptionDisabled,
+		},
+		"permissive": {
+			cm:   &corev1.ConfigMap{Data: map[string]string{TransportEncryptionKey: "permissive"}},
+			want: security.TransportEncryptionPermissive,
+		},
+		"strict": {
+			cm:   &corev1.ConfigMap{Data: map[string]string{TransportEncryptionKey: "strict"}},
+			want: security.TransportEncryptionStrict,
+		},
+		"unknown mode": {
+			cm:      &corev1.ConfigMap{Data: map[string]string{TransportEncryptionKey: "enabled"}},
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := NewFeaturesFromConfigMap(tc.cm)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewFeaturesFromConfigMap() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err == nil && got.TransportEncryption != tc.want {
+				t.Errorf("TransportEncryption = %q, want %q", got.TransportEncryption, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetFeatures(t *testing.T) {
+	ctx, _ := fakekubeclient.With(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: FeaturesConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{TransportEncryptionKey: "strict"},
+	})
+	got, err := GetFeatures(ctx)
+	if err != nil || got.TransportEncryption != security.TransportEncryptionStrict {
+		t.Errorf("GetFeatures() = %+v, %v, want the strict mode", got, err)
+	}
+}
+
+func TestWatchFeatures(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: FeaturesConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{TransportEncryptionKey: "permissive"},
+	}
+	cmw := &configmap.ManualWatcher{Namespace: system.Namespace()}
+
+	var got *Features
+	WatchFeatures(context.Background(), cmw, func(f *Features) { got = f })
+	cmw.OnChange(cm)
+	if got == nil || got.TransportEncryption != security.TransportEncryptionPermissive {
+		t.Fatalf("observed %+v, want the permissive mode", got)
+	}
+
+	// Invalid changes are ignored.
+	cm.Data[TransportEncryptionKey] = "enabled"
+	cmw.OnChange(cm)
+	if got.TransportEncryption != security.TransportEncryptionPermissive {
+		t.Errorf("observed the invalid mode %q", got.TransportEncryption)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/logging"
+	tracingconfig "knative.dev/pkg/tracing/config"
+)
+
+// WatchTracing calls observer with the tracing configuration every time the config-tracing
+// ConfigMap of the system namespace changes, the traces not being exported while it does not
+// exist.
+func WatchTracing(ctx context.Context, cmw configmap.Watcher, observer func(*tracingconfig.Config)) {
+	logger := logging.FromContext(ctx)
+	watchWithDefault(cmw, tracingconfig.ConfigName, func(cm *corev1.ConfigMap) {
+		c, err := tracingconfig.NewTracingConfigFromConfigMap(cm)
+		if err != nil {
+			logger.Errorw("Ignoring the invalid tracing configuration", zap.Error(err))
+			return
+		}
+		observer(c)
+	})
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/system"
+	tracingconfig "knative.dev/pkg/tracing/config"
+)
+
+func TestWatchTracing(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: tracingconfig.ConfigName, Namespace: system.Namespace()},
+		Data: map[string]string{
+			"backend":         "zipkin",
+			"zipkin-endpoint": "http://zipkin.istio-system.svc.cluster.local:9411/api/v2/spans",
+			"sample-rate":     "0.5",
+		},
+	}
+	cmw := &configmap.ManualWatcher{Namespace: system.Namespace()}
+
+	var got *tracingconfig.Config
+	WatchTracing(context.Background(), cmw, func(c *tracingconfig.Config) { got = c })
+	cmw.OnChange(cm)
+	if got == nil || got.Backend != tracingconfig.Zipkin || got.SampleRate != 0.5 {
+		t.Fatalf("observed %+v, want zipkin sampling half of the traces", got)
+	}
+
+	// Invalid changes are ignored.
+	cm.Data["sample-rate"] = "2"
+	cmw.OnChange(cm)
+	if got.SampleRate != 0.5 {
+		t.Errorf("observed the invalid sample rate %v", got.SampleRate)
+	}
+}
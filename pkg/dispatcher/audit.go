@@ -0,0 +1,262 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/metrics"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/retry"
+)
+
+const (
+	// AuditChannelExtension is the CloudEvents extension attribute set to the namespace and name
+	// of the channel on the copies of the events sent to its audit sink.
+	AuditChannelExtension = "knauditchannel"
+	// AuditClientExtension is the CloudEvents extension attribute set to the address of the
+	// client which sent the event on the copies sent to the audit sinks.
+	AuditClientExtension = "knauditclient"
+)
+
+const (
+	// auditQueueSize is the number of copies awaiting delivery to the audit sinks, the copies
+	// being dropped once it is reached.
+	auditQueueSize = 1000
+	// auditWorkers is the number of copies delivered concurrently.
+	auditWorkers = 4
+	// auditRetries is the number of times the delivery of a copy is retried.
+	auditRetries = 2
+)
+
+var (
+	// auditBackoff is the delay before the first retry of a copy, doubled for each retry.
+	auditBackoff = 100 * time.Millisecond
+	// auditTimeout is the timeout of each delivery of a copy.
+	auditTimeout = 5 * time.Second
+)
+
+var (
+	// auditEventCountM records the copies of the events delivered to or dropped for the audit sinks.
+	auditEventCountM = stats.Int64(
+		"audit_event_count",
+		"Number of copies of the events delivered to or dropped for the audit sinks",
+		stats.UnitDimensionless,
+	)
+
+	// auditResultKey tags the copies with either auditResultAudited or auditResultDropped.
+	auditResultKey = tag.MustNewKey("result")
+)
+
+const (
+	auditResultAudited = "audited"
+	auditResultDropped = "dropped"
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: auditEventCountM.Description(),
+			Measure:     auditEventCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{auditResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// Auditor is implemented by the dispatchers able to mirror the events of a channel to an audit sink.
+type Auditor interface {
+	// SetAuditSink sets the sink receiving a copy of the events sent to channel, a nil sink
+	// disabling the audit. notify is called when the sink becomes reachable or unreachable.
+	SetAuditSink(channel eventingchannels.ChannelReference, sink *apis.URL, notify func())
+	// AuditSinkStatus returns whether a copy was delivered or dropped since the sink was set,
+	// and the error of the last one, nil if it was delivered.
+	AuditSinkStatus(channel eventingchannels.ChannelReference) (bool, error)
+}
+
+var _ Auditor = (*SubscriptionsSupervisor)(nil)
+
+// auditSink is the audit sink of a channel.
+type auditSink struct {
+	url    *apis.URL
+	notify func()
+
+	mu   sync.Mutex
+	sent bool
+	err  error
+}
+
+// setResult records the outcome of the delivery of a copy, notifying the changes of reachability.
+func (a *auditSink) setResult(err error) {
+	a.mu.Lock()
+	changed := !a.sent || (a.err == nil) != (err == nil)
+	a.sent = true
+	a.err = err
+	a.mu.Unlock()
+	if changed && a.notify != nil {
+		a.notify()
+	}
+}
+
+// auditCopy is a copy of an event awaiting delivery to an audit sink.
+type auditCopy struct {
+	channel eventingchannels.ChannelReference
+	sink    *auditSink
+	event   *event.Event
+}
+
+// SetAuditSink implements Auditor.
+func (s *SubscriptionsSupervisor) SetAuditSink(channel eventingchannels.ChannelReference, sink *apis.URL, notify func()) {
+	if sink.IsEmpty() {
+		s.auditSinks.Delete(channel)
+		return
+	}
+	if current, ok := s.auditSinks.Load(channel); ok && current.(*auditSink).url.String() == sink.String() {
+		return
+	}
+	s.auditSinks.Store(channel, &auditSink{url: sink, notify: notify})
+}
+
+// AuditSinkStatus implements Auditor.
+func (s *SubscriptionsSupervisor) AuditSinkStatus(channel eventingchannels.ChannelReference) (bool, error) {
+	sink, ok := s.auditSinks.Load(channel)
+	if !ok {
+		return false, nil
+	}
+	a := sink.(*auditSink)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sent, a.err
+}
+
+// prepareAudit returns the message to publish on channel and, when the channel has an audit
+// sink, the copy of its event to queue once it is published.
+func (s *SubscriptionsSupervisor) prepareAudit(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message) (binding.Message, *auditCopy, error) {
+	sink, ok := s.auditSinks.Load(channel)
+	if !ok {
+		return message, nil, nil
+	}
+	e, err := binding.ToEvent(ctx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = message.Finish(nil)
+
+	audited := e.Clone()
+	audited.SetExtension(AuditChannelExtension, channel.String())
+	if client, ok := ClientAddress(ctx); ok {
+		audited.SetExtension(AuditClientExtension, client)
+	}
+	return binding.ToMessage(e), &auditCopy{channel: channel, sink: sink.(*auditSink), event: &audited}, nil
+}
+
+// queueAudit queues the copy of an event without waiting, the copy being dropped when the
+// queue is full.
+func (s *SubscriptionsSupervisor) queueAudit(audited *auditCopy) {
+	select {
+	case s.auditQueue <- audited:
+	default:
+		s.logger.Warn("Audit queue full, dropping the copy of an event", zap.String("id", audited.event.ID()))
+		recordAudit(auditResultDropped)
+	}
+}
+
+// runAuditWorkers delivers the queued copies until ctx is done.
+func (s *SubscriptionsSupervisor) runAuditWorkers(ctx context.Context) {
+	for i := 0; i < auditWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case audited := <-s.auditQueue:
+					s.deliverAudit(ctx, audited)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// deliverAudit delivers a copy to its audit sink, retrying auditRetries times.
+func (s *SubscriptionsSupervisor) deliverAudit(ctx context.Context, audited *auditCopy) {
+	policy := retry.Policy{
+		Initial:     auditBackoff,
+		Multiplier:  2,
+		MaxAttempts: auditRetries + 1,
+	}
+	err := retry.Do(ctx, policy, func(ctx context.Context) error {
+		return s.sendAudit(ctx, audited)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	audited.sink.setResult(err)
+	if err != nil {
+		s.logger.Warn("Failed to deliver the copy of an event to the audit sink", zap.String("sink", audited.sink.url.String()), zap.Error(err))
+		recordAudit(auditResultDropped)
+		return
+	}
+	recordAudit(auditResultAudited)
+}
+
+func (s *SubscriptionsSupervisor) sendAudit(ctx context.Context, audited *auditCopy) error {
+	ctx, cancel := context.WithTimeout(withOutboundChannel(ctx, audited.channel), auditTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, audited.sink.url.String(), nil)
+	if err != nil {
+		return err
+	}
+	if err := cehttp.WriteRequest(ctx, binding.ToMessage(audited.event), req); err != nil {
+		return err
+	}
+	resp, err := s.auditClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func recordAudit(result string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(auditResultKey, result))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, auditEventCountM.M(1))
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+func TestAudit(t *testing.T) {
+	audited := make(chan event.Event, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		audited <- *e
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _ := newTestSupervisor(t)
+	s.runAuditWorkers(ctx)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.SetAuditSink(ref, apis.HTTP(sink.Listener.Addr().String()), func() { atomic.AddInt32(&notified, 1) })
+	if _, err := s.UpdateSubscriptions(ctx, newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	publishTestEvent(t, s, ref, "audited")
+
+	select {
+	case e := <-audited:
+		if e.ID() != "audited" || e.Extensions()[AuditChannelExtension] != ref.String() {
+			t.Errorf("audited %v, want the event with %s=%s", e, AuditChannelExtension, ref.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not audited")
+	}
+	if got := subscriber.received(); len(got) != 1 || got[0] != "audited" {
+		t.Errorf("received %v, want [audited]", got)
+	}
+	// The status is recorded right after the sink answered.
+	waitForAuditStatus(t, s, ref)
+	if sent, err := s.AuditSinkStatus(ref); !sent || err != nil {
+		t.Errorf("AuditSinkStatus() = %v, %v, want true, nil", sent, err)
+	}
+	if atomic.LoadInt32(&notified) != 1 {
+		t.Errorf("notified %d times, want 1", notified)
+	}
+}
+
+func TestAuditSinkUnreachable(t *testing.T) {
+	defer func(backoff time.Duration) { auditBackoff = backoff }(auditBackoff)
+	auditBackoff = time.Millisecond
+
+	var attempts int32
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _ := newTestSupervisor(t)
+	s.runAuditWorkers(ctx)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetAuditSink(ref, apis.HTTP(sink.Listener.Addr().String()), func() {})
+
+	publishTestEvent(t, s, ref, "dropped")
+	waitForAuditStatus(t, s, ref)
+
+	if sent, err := s.AuditSinkStatus(ref); !sent || err == nil {
+		t.Errorf("AuditSinkStatus() = %v, %v, want true and an error", sent, err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != auditRetries+1 {
+		t.Errorf("attempted %d deliveries, want %d", got, auditRetries+1)
+	}
+
+	// Setting the same sink again keeps its status, removing it forgets it.
+	s.SetAuditSink(ref, apis.HTTP(sink.Listener.Addr().String()), func() {})
+	if sent, _ := s.AuditSinkStatus(ref); !sent {
+		t.Error("the status of the sink was reset")
+	}
+	s.SetAuditSink(ref, nil, nil)
+	if sent, err := s.AuditSinkStatus(ref); sent || err != nil {
+		t.Errorf("AuditSinkStatus() = %v, %v after the sink was removed", sent, err)
+	}
+}
+
+func TestAuditDoesNotBlockPublishing(t *testing.T) {
+	release := make(chan struct{})
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _ := newTestSupervisor(t)
+	s.runAuditWorkers(ctx)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetAuditSink(ref, apis.HTTP(sink.Listener.Addr().String()), func() {})
+
+	// The workers hang on the sink and the queue fills up, the copies are then dropped.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < auditQueueSize+2*auditWorkers; i++ {
+			publishTestEvent(t, s, ref, "blocked")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("publishing blocked on the audit sink")
+	}
+}
+
+func publishTestEvent(t *testing.T, s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference, id string) {
+	e := event.New()
+	e.SetID(id)
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	if err := messageReceiverFunc(s)(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{}); err != nil {
+		t.Errorf("failed to publish the event: %v", err)
+	}
+}
+
+func waitForAuditStatus(t *testing.T, s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent, _ := s.AuditSinkStatus(ref); sent {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no copy was sent to the audit sink")
+}
+
+func TestAuditClientAddress(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetAuditSink(ref, apis.HTTP("audit.example.com"), nil)
+
+	e := event.New()
+	e.SetID("forwarded")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	ctx := context.WithValue(context.Background(), clientAddressKey{}, "198.51.100.1")
+	_, audited, err := s.prepareAudit(ctx, ref, binding.ToMessage(&e))
+	if err != nil {
+		t.Fatalf("prepareAudit() = %v", err)
+	}
+	if got := audited.event.Extensions()[AuditClientExtension]; got != "198.51.100.1" {
+		t.Errorf("%s = %v, want the address of the client", AuditClientExtension, got)
+	}
+}
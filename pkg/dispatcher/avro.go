@@ -0,0 +1,294 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/metrics"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/avro"
+)
+
+const (
+	// AvroContentType is the datacontenttype of the Avro events, transcoded to JSON.
+	AvroContentType = "avro/binary"
+	// AvroSchemaIDExtension is the CloudEvents extension attribute holding the ID, in the schema
+	// registry, of the schema of an Avro event.
+	AvroSchemaIDExtension = "avroschemaid"
+)
+
+// registryTimeout is the timeout of the requests to the schema registries.
+var registryTimeout = 5 * time.Second
+
+var (
+	// avroTranscodeCountM records the Avro events transcoded to JSON, or delivered unchanged
+	// when their transcoding failed.
+	avroTranscodeCountM = stats.Int64(
+		"avro_transcode_count",
+		"Number of Avro events transcoded to JSON or passed through",
+		stats.UnitDimensionless,
+	)
+
+	// transcodeResultKey tags the events with either transcodeResultTranscoded or
+	// transcodeResultPassthrough.
+	transcodeResultKey = tag.MustNewKey("result")
+)
+
+const (
+	transcodeResultTranscoded  = "transcoded"
+	transcodeResultPassthrough = "passthrough"
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: avroTranscodeCountM.Description(),
+			Measure:     avroTranscodeCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{transcodeResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// AvroRegistry is the schema registry the Avro events of a channel are transcoded with.
+type AvroRegistry struct {
+	url *apis.URL
+	// username and password, or token, authenticate the requests to the registry.
+	username, password string
+	token              string
+	// err is why the credentials are unavailable.
+	err error
+}
+
+// NewAvroRegistry returns the registry at url, authenticated with the credentials of a Secret:
+// either username and password for basic authentication, or token for bearer authentication.
+func NewAvroRegistry(url *apis.URL, credentials map[string][]byte) (*AvroRegistry, error) {
+	r := &AvroRegistry{
+		url:      url,
+		username: string(credentials["username"]),
+		password: string(credentials["password"]),
+		token:    string(credentials["token"]),
+	}
+	if r.password != "" && r.username == "" {
+		return nil, errors.New("password without username")
+	}
+	if r.username != "" && r.token != "" {
+		return nil, errors.New("both username and token are set")
+	}
+	return r, nil
+}
+
+// NewUnavailableAvroRegistry returns the registry at url whose credentials could not be loaded,
+// the events being delivered unchanged until they are.
+func NewUnavailableAvroRegistry(url *apis.URL, err error) *AvroRegistry {
+	return &AvroRegistry{url: url, err: err}
+}
+
+// Err returns why the credentials of the registry are unavailable, nil when they are.
+func (r *AvroRegistry) Err() error {
+	return r.err
+}
+
+func (r *AvroRegistry) equal(other *AvroRegistry) bool {
+	return r.url.String() == other.url.String() && r.username == other.username &&
+		r.password == other.password && r.token == other.token && (r.err == nil) == (other.err == nil)
+}
+
+// AvroTranscoder is implemented by the dispatchers able to transcode the Avro events to JSON.
+type AvroTranscoder interface {
+	// SetAvroRegistry sets the registry the Avro events of channel are transcoded with, nil
+	// disabling the transcoding.
+	SetAvroRegistry(channel eventingchannels.ChannelReference, registry *AvroRegistry)
+}
+
+var _ AvroTranscoder = (*SubscriptionsSupervisor)(nil)
+
+// schemaRegistry fetches and caches the schemas of a registry.
+type schemaRegistry struct {
+	*AvroRegistry
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	schemas map[string]cachedSchema
+}
+
+type cachedSchema struct {
+	schema  *avro.Schema
+	expires time.Time
+}
+
+// SetAvroRegistry implements AvroTranscoder. The cache of the schemas is kept while the
+// registry does not change.
+func (s *SubscriptionsSupervisor) SetAvroRegistry(channel eventingchannels.ChannelReference, registry *AvroRegistry) {
+	if registry == nil {
+		s.avroRegistries.Delete(channel)
+		return
+	}
+	if current, ok := s.avroRegistries.Load(channel); ok && current.(*schemaRegistry).equal(registry) {
+		return
+	}
+	s.avroRegistries.Store(channel, &schemaRegistry{
+		AvroRegistry: registry,
+		client:       s.registryClient,
+		ttl:          s.avroSchemaCacheTTL,
+		schemas:      make(map[string]cachedSchema),
+	})
+}
+
+// schema returns the schema id, from the cache unless it expired.
+func (r *schemaRegistry) schema(ctx context.Context, id string) (*avro.Schema, error) {
+	r.mu.Lock()
+	cached, ok := r.schemas[id]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.schema, nil
+	}
+
+	schema, err := r.fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.schemas[id] = cachedSchema{schema: schema, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return schema, nil
+}
+
+// fetch gets the schema id with the schema registry API of Confluent.
+func (r *schemaRegistry) fetch(ctx context.Context, id string) (*avro.Schema, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+	endpoint := strings.TrimSuffix(r.url.String(), "/") + "/schemas/ids/" + url.PathEscape(id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.username != "":
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d fetching schema %s", resp.StatusCode, id)
+	}
+	var body struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response fetching schema %s: %w", id, err)
+	}
+	// The registry omits the type of the Avro schemas.
+	if body.SchemaType != "" && body.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema %s is a %s schema", id, body.SchemaType)
+	}
+	return avro.ParseSchema(body.Schema)
+}
+
+// transcodeAvro returns message with its data transcoded to JSON when it is an Avro event of a
+// channel with a schema registry. The message is returned unchanged when the transcoding fails.
+func (s *SubscriptionsSupervisor) transcodeAvro(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message) binding.Message {
+	registry, ok := s.avroRegistries.Load(channel)
+	if !ok {
+		return message
+	}
+	e, err := binding.ToEvent(ctx, message)
+	if err != nil || e.DataContentType() != AvroContentType {
+		return message
+	}
+
+	transcoded, err := transcodeEvent(ctx, registry.(*schemaRegistry), e)
+	if err != nil {
+		s.subscriptionsLogger.Warn("Failed to transcode an Avro event, delivering it unchanged",
+			zap.String("channel", channel.String()), zap.String("id", e.ID()), zap.Error(err))
+		recordTranscode(transcodeResultPassthrough)
+		return message
+	}
+	recordTranscode(transcodeResultTranscoded)
+	return binding.ToMessage(transcoded)
+}
+
+func transcodeEvent(ctx context.Context, registry *schemaRegistry, e *event.Event) (*event.Event, error) {
+	rawID, ok := e.Extensions()[AvroSchemaIDExtension]
+	if !ok {
+		return nil, fmt.Errorf("no %s extension", AvroSchemaIDExtension)
+	}
+	id, err := types.Format(rawID)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := registry.schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := schema.ToJSON(e.Data())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the data with schema %s: %w", id, err)
+	}
+	transcoded := e.Clone()
+	if err := transcoded.SetData(event.ApplicationJSON, data); err != nil {
+		return nil, err
+	}
+	return &transcoded, nil
+}
+
+func recordTranscode(result string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(transcodeResultKey, result))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, avroTranscodeCountM.M(1))
+}
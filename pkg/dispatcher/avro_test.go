@@ -0,0 +1,223 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+const userSchema = `{"type": "record", "name": "User", "fields": [
+	{"name": "name", "type": "string"},
+	{"name": "age", "type": "int"}
+]}`
+
+// userData is the Avro encoding of {"name": "ada", "age": 36}.
+var userData = []byte{6, 'a', 'd', 'a', 72}
+
+// stubRegistry serves the schema 42 to the requests authenticated as user, counting them.
+type stubRegistry struct {
+	*httptest.Server
+	requests int32
+}
+
+func newStubRegistry(t *testing.T) *stubRegistry {
+	r := &stubRegistry{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&r.requests, 1)
+		if user, password, ok := req.BasicAuth(); !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path != "/schemas/ids/42" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": userSchema})
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newAvroEvent(schemaID string, data []byte) *event.Event {
+	e := event.New()
+	e.SetID("avro")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	_ = e.SetData(AvroContentType, data)
+	if schemaID != "" {
+		e.SetExtension(AvroSchemaIDExtension, schemaID)
+	}
+	return &e
+}
+
+func TestTranscodeAvro(t *testing.T) {
+	registry := newStubRegistry(t)
+	credentials := map[string][]byte{"username": []byte("user"), "password": []byte("secret")}
+
+	testCases := map[string]struct {
+		event       *event.Event
+		credentials map[string][]byte
+		wantData    string
+	}{
+		"avro event": {
+			event:       newAvroEvent("42", userData),
+			credentials: credentials,
+			wantData:    `{"name":"ada","age":36}`,
+		},
+		"unknown schema": {
+			event:       newAvroEvent("7", userData),
+			credentials: credentials,
+		},
+		"no schema ID": {
+			event:       newAvroEvent("", userData),
+			credentials: credentials,
+		},
+		"corrupted data": {
+			event:       newAvroEvent("42", userData[:3]),
+			credentials: credentials,
+		},
+		"unauthorized": {
+			event: newAvroEvent("42", userData),
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, _ := newTestSupervisor(t)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			config, err := NewAvroRegistry(apis.HTTP(registry.Listener.Addr().String()), tc.credentials)
+			if err != nil {
+				t.Fatalf("NewAvroRegistry() = %v", err)
+			}
+			s.SetAvroRegistry(ref, config)
+
+			message := s.transcodeAvro(context.Background(), ref, binding.ToMessage(tc.event))
+			got, err := binding.ToEvent(context.Background(), message)
+			if err != nil {
+				t.Fatalf("ToEvent() = %v", err)
+			}
+			if tc.wantData == "" {
+				// The events failing to transcode are delivered unchanged.
+				if got.DataContentType() != AvroContentType || string(got.Data()) != string(tc.event.Data()) {
+					t.Errorf("transcodeAvro() = %s %q, want the event unchanged", got.DataContentType(), got.Data())
+				}
+				return
+			}
+			if got.DataContentType() != event.ApplicationJSON || string(got.Data()) != tc.wantData {
+				t.Errorf("transcodeAvro() = %s %s, want %s %s", got.DataContentType(), got.Data(), event.ApplicationJSON, tc.wantData)
+			}
+		})
+	}
+}
+
+func TestTranscodeAvroDisabled(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	message := binding.ToMessage(newAvroEvent("42", userData))
+	if got := s.transcodeAvro(context.Background(), ref, message); got != message {
+		t.Error("transcodeAvro() changed the event of a channel without schema registry")
+	}
+}
+
+func TestAvroSchemaCache(t *testing.T) {
+	registry := newStubRegistry(t)
+	s, _ := newTestSupervisor(t)
+	s.avroSchemaCacheTTL = time.Hour
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	credentials := map[string][]byte{"username": []byte("user"), "password": []byte("secret")}
+	setRegistry := func() {
+		config, err := NewAvroRegistry(apis.HTTP(registry.Listener.Addr().String()), credentials)
+		if err != nil {
+			t.Fatalf("NewAvroRegistry() = %v", err)
+		}
+		s.SetAvroRegistry(ref, config)
+	}
+
+	setRegistry()
+	for i := 0; i < 3; i++ {
+		s.transcodeAvro(context.Background(), ref, binding.ToMessage(newAvroEvent("42", userData)))
+		// Reconciling the channel again keeps the cache.
+		setRegistry()
+	}
+	if got := atomic.LoadInt32(&registry.requests); got != 1 {
+		t.Errorf("%d requests to the registry, want the schema to be fetched once", got)
+	}
+
+	// Changing the credentials clears the cache.
+	credentials["password"] = []byte("rotated")
+	setRegistry()
+	s.transcodeAvro(context.Background(), ref, binding.ToMessage(newAvroEvent("42", userData)))
+	if got := atomic.LoadInt32(&registry.requests); got != 2 {
+		t.Errorf("%d requests to the registry, want the schema to be fetched again", got)
+	}
+}
+
+func TestDispatchMessageTranscodesAvro(t *testing.T) {
+	registry := newStubRegistry(t)
+	received := make(chan *event.Event, 1)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- e
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	config, err := NewAvroRegistry(apis.HTTP(registry.Listener.Addr().String()),
+		map[string][]byte{"username": []byte("user"), "password": []byte("secret")})
+	if err != nil {
+		t.Fatalf("NewAvroRegistry() = %v", err)
+	}
+	s.SetAvroRegistry(ref, config)
+
+	if !s.dispatchMessage(context.Background(), ref, binding.ToMessage(newAvroEvent("42", userData)), mustParseURL(t, subscriber.URL), nil, nil) {
+		t.Fatal("dispatchMessage() = false, want the event to be delivered")
+	}
+	got := <-received
+	if got.DataContentType() != event.ApplicationJSON || string(got.Data()) != `{"name":"ada","age":36}` {
+		t.Errorf("received %s %s, want the event transcoded to JSON", got.DataContentType(), got.Data())
+	}
+}
+
+func TestNewAvroRegistry(t *testing.T) {
+	url := apis.HTTP("registry.example.com")
+	if _, err := NewAvroRegistry(url, map[string][]byte{"password": []byte("secret")}); err == nil {
+		t.Error("NewAvroRegistry() succeeded with a password without username")
+	}
+	if _, err := NewAvroRegistry(url, map[string][]byte{"username": []byte("user"), "token": []byte("t")}); err == nil {
+		t.Error("NewAvroRegistry() succeeded with both a username and a token")
+	}
+	if _, err := NewAvroRegistry(url, map[string][]byte{"token": []byte("t")}); err != nil {
+		t.Errorf("NewAvroRegistry() = %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"knative.dev/pkg/metrics"
+)
+
+const (
+	// lowWaterPercent is the percentage of the cap under which a paused
+	// dispatcher resumes pulling messages.
+	lowWaterPercent = 80
+)
+
+var (
+	// bufferedBytesM records the total size of the event payloads received
+	// from NATSS and not yet dispatched.
+	bufferedBytesM = stats.Int64(
+		"buffered_event_bytes",
+		"Total size of the events awaiting dispatch by the NATSS dispatcher",
+		stats.UnitBytes,
+	)
+
+	// pauseCountM records the number of times the dispatcher stopped pulling
+	// messages from NATSS because the buffered bytes cap was reached.
+	pauseCountM = stats.Int64(
+		"buffer_pause_count",
+		"Number of times the NATSS dispatcher paused because of the buffered bytes cap",
+		stats.UnitDimensionless,
+	)
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: bufferedBytesM.Description(),
+			Measure:     bufferedBytesM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: pauseCountM.Description(),
+			Measure:     pauseCountM,
+			Aggregation: view.Count(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// bufferLimiter tracks the bytes of the messages being dispatched across all
+// subscriptions. Once the cap is exceeded, acquire blocks the STAN callbacks,
+// and therefore delays the acks and the delivery of new messages, until the
+// buffered bytes drop below the low-water mark.
+type bufferLimiter struct {
+	max      int64
+	lowWater int64
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buffered int64
+	paused   bool
+	pauses   int64
+}
+
+// newBufferLimiter creates a bufferLimiter capping the buffered bytes to max.
+// A max lower or equal to zero disables the cap.
+func newBufferLimiter(max int64) *bufferLimiter {
+	b := &bufferLimiter{
+		max:      max,
+		lowWater: max * lowWaterPercent / 100,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire reserves size bytes, waiting while the dispatcher is paused or the
+// reservation would exceed the cap. A message larger than the cap is let
+// through once nothing else is buffered.
+func (b *bufferLimiter) acquire(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.max > 0 {
+		for b.paused || (b.buffered > 0 && b.buffered+size > b.max) {
+			if !b.paused {
+				b.paused = true
+				b.pauses++
+				metrics.Record(context.Background(), pauseCountM.M(1))
+			}
+			b.cond.Wait()
+		}
+	}
+	b.buffered += size
+	metrics.Record(context.Background(), bufferedBytesM.M(b.buffered))
+}
+
+// release frees size bytes previously reserved with acquire.
+func (b *bufferLimiter) release(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buffered -= size
+	metrics.Record(context.Background(), bufferedBytesM.M(b.buffered))
+	if b.paused && (b.buffered < b.lowWater || b.buffered == 0) {
+		b.paused = false
+		b.cond.Broadcast()
+	}
+}
+
+// bufferedBytes returns the bytes currently reserved.
+func (b *bufferLimiter) bufferedBytes() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffered
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferLimiterRespectsCap(t *testing.T) {
+	const (
+		payloadSize = 1024 * 1024
+		maxBytes    = 4 * payloadSize
+		messages    = 64
+	)
+	payload := make([]byte, payloadSize)
+	b := newBufferLimiter(maxBytes)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		highest int64
+	)
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			size := int64(len(payload))
+			b.acquire(size)
+			mu.Lock()
+			if current := b.bufferedBytes(); current > highest {
+				highest = current
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			b.release(size)
+		}()
+	}
+	wg.Wait()
+
+	if highest > maxBytes {
+		t.Errorf("buffered bytes exceeded the cap: got %d, max %d", highest, maxBytes)
+	}
+	if got := b.bufferedBytes(); got != 0 {
+		t.Errorf("buffered bytes after dispatch: got %d, want 0", got)
+	}
+	if b.pauses == 0 {
+		t.Error("expected the limiter to pause at least once")
+	}
+}
+
+func TestBufferLimiterOversizedMessage(t *testing.T) {
+	b := newBufferLimiter(10)
+	done := make(chan struct{})
+	go func() {
+		b.acquire(100)
+		b.release(100)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a message larger than the cap must be dispatched when nothing else is buffered")
+	}
+}
+
+func TestBufferLimiterDisabled(t *testing.T) {
+	b := newBufferLimiter(0)
+	for i := 0; i < 10; i++ {
+		b.acquire(1024)
+	}
+	if got := b.bufferedBytes(); got != 10*1024 {
+		t.Errorf("buffered bytes: got %d, want %d", got, 10*1024)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// ClusterBinder is implemented by the dispatchers able to store the events of the channels in
+// other NATSS clusters than their own.
+type ClusterBinder interface {
+	// BindCluster binds channel to cluster, whose empty fields default to the cluster of the
+	// dispatcher, and returns the cluster the channel is bound to. The zero cluster binds the
+	// channel to the cluster of the dispatcher.
+	BindCluster(channel eventingchannels.ChannelReference, cluster v1beta1.NatssChannelCluster) v1beta1.NatssChannelCluster
+}
+
+var _ ClusterBinder = (*SubscriptionsSupervisor)(nil)
+
+// connPool is the pool of the connections to the NATSS clusters, a *stanutil.ConnManager but in
+// the tests.
+type connPool interface {
+	Get(ctx context.Context, key stanutil.ConnKey) (stan.Conn, error)
+	Release(key stanutil.ConnKey, conn stan.Conn) error
+	Healthy(key stanutil.ConnKey) bool
+}
+
+// BindCluster implements ClusterBinder.
+func (s *SubscriptionsSupervisor) BindCluster(channel eventingchannels.ChannelReference, cluster v1beta1.NatssChannelCluster) v1beta1.NatssChannelCluster {
+	dispatcherKey := s.dispatcherKey()
+	key := dispatcherKey
+	if cluster.NatsURL != "" {
+		key.URL = cluster.NatsURL
+	}
+	if cluster.ClusterID != "" {
+		key.ClusterID = cluster.ClusterID
+	}
+	if key == dispatcherKey {
+		s.clusters.Delete(channel)
+	} else {
+		s.clusters.Store(channel, key)
+	}
+	return v1beta1.NatssChannelCluster{NatsURL: key.URL, ClusterID: key.ClusterID}
+}
+
+// clusterOf returns the key of the connection to the cluster of channel.
+func (s *SubscriptionsSupervisor) clusterOf(channel eventingchannels.ChannelReference) stanutil.ConnKey {
+	if key, ok := s.clusters.Load(channel); ok {
+		return key.(stanutil.ConnKey)
+	}
+	return s.dispatcherKey()
+}
+
+// connection returns the connection to the cluster of channel. The connections to the clusters
+// other than the one of the dispatcher are made on their first use, and made again once lost.
+func (s *SubscriptionsSupervisor) connection(ctx context.Context, channel eventingchannels.ChannelReference) (*stan.Conn, error) {
+	key := s.clusterOf(channel)
+	if key == s.dispatcherKey() {
+		s.natssConnMux.Lock()
+		currentNatssConn := s.natssConn
+		s.natssConnMux.Unlock()
+		if currentNatssConn == nil {
+			return nil, s.noConnection()
+		}
+		return currentNatssConn, nil
+	}
+
+	s.clusterConnsMux.Lock()
+	conn, ok := s.clusterConns[key]
+	if ok && s.conns.Healthy(key) {
+		s.clusterConnsMux.Unlock()
+		return &conn, nil
+	}
+	s.clusterConnsMux.Unlock()
+
+	nConn, err := s.conns.Get(ctx, key)
+	s.recordClusterConnection(key, err)
+	if err != nil {
+		return nil, fmt.Errorf("no Connection to NATSS cluster %q at %s: %w", key.ClusterID, key.URL, err)
+	}
+	s.clusterConnsMux.Lock()
+	defer s.clusterConnsMux.Unlock()
+	if s.clusterConnsClosed {
+		// The connection hook stopped while connecting.
+		_ = s.conns.Release(key, nConn)
+		return nil, fmt.Errorf("no Connection to NATSS cluster %q at %s", key.ClusterID, key.URL)
+	}
+	if current, ok := s.clusterConns[key]; ok {
+		if current == nConn {
+			// Made by a concurrent call, which holds the reference of the supervisor.
+			_ = s.conns.Release(key, nConn)
+			return &current, nil
+		}
+		// The connection was lost, its replacement is kept.
+		_ = s.conns.Release(key, current)
+	}
+	s.connectionLogger.Info("Connected to NATSS cluster", zap.String("clusterID", key.ClusterID), zap.String("url", key.URL))
+	s.clusterConns[key] = nConn
+	return &nConn, nil
+}
+
+// recordClusterConnection records the result err of the attempt to connect to the cluster of key,
+// notifying the channels of the cluster when it changed.
+func (s *SubscriptionsSupervisor) recordClusterConnection(key stanutil.ConnKey, err error) {
+	s.clusterConnsMux.Lock()
+	previous, failed := s.clusterConnErrs[key]
+	if err != nil {
+		s.clusterConnErrs[key] = err
+	} else {
+		delete(s.clusterConnErrs, key)
+	}
+	s.clusterConnsMux.Unlock()
+	if failed != (err != nil) || (failed && previous.Error() != err.Error()) {
+		s.notifyConnection(key)
+	}
+}
+
+// connectionLost handles the loss of the connection to the cluster of channel, the connections
+// to the other clusters than the one of the dispatcher being made again on their next use.
+func (s *SubscriptionsSupervisor) connectionLost(channel eventingchannels.ChannelReference) {
+	if s.clusterOf(channel) == s.dispatcherKey() {
+		s.signalReconnect()
+	}
+}
+
+// closeClusterConnections closes the connections to the clusters other than the one of the
+// dispatcher.
+func (s *SubscriptionsSupervisor) closeClusterConnections() error {
+	s.clusterConnsMux.Lock()
+	defer s.clusterConnsMux.Unlock()
+	s.clusterConnsClosed = true
+	var firstErr error
+	for key, conn := range s.clusterConns {
+		if err := s.conns.Release(key, conn); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.clusterConns, key)
+	}
+	return firstErr
+}
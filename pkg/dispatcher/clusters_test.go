@@ -0,0 +1,194 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// fakeConnPool makes a fakeStanConn per cluster, forgetting the lost ones.
+type fakeConnPool struct {
+	mu    sync.Mutex
+	conns map[stanutil.ConnKey]*fakeStanConn
+	refs  map[*fakeStanConn]int
+	dials int
+	// err fails the connections while set, as when the server is down.
+	err error
+}
+
+func newFakeConnPool() *fakeConnPool {
+	return &fakeConnPool{
+		conns: make(map[stanutil.ConnKey]*fakeStanConn),
+		refs:  make(map[*fakeStanConn]int),
+	}
+}
+
+func (p *fakeConnPool) Get(_ context.Context, key stanutil.ConnKey) (stan.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	conn, ok := p.conns[key]
+	if !ok {
+		conn = newFakeStanConn()
+		p.conns[key] = conn
+		p.dials++
+	}
+	p.refs[conn]++
+	return conn, nil
+}
+
+func (p *fakeConnPool) Release(_ stanutil.ConnKey, conn stan.Conn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refs[conn.(*fakeStanConn)]--
+	return nil
+}
+
+func (p *fakeConnPool) Healthy(key stanutil.ConnKey) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.conns[key]
+	return ok
+}
+
+// lose loses the connection of key.
+func (p *fakeConnPool) lose(key stanutil.ConnKey) *fakeStanConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn := p.conns[key]
+	delete(p.conns, key)
+	return conn
+}
+
+// stored returns the events stored through conn.
+func stored(conn *fakeStanConn) *[]string {
+	var ids []string
+	conn.mu.Lock()
+	conn.subs = append(conn.subs, &fakeStanSubscription{conn: conn, cb: func(msg *stan.Msg) {
+		ids = append(ids, string(msg.Data))
+	}})
+	conn.mu.Unlock()
+	return &ids
+}
+
+func publishTo(t *testing.T, s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference) {
+	t.Helper()
+	e := event.New()
+	e.SetID("id")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	if err := messageReceiverFunc(s)(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{}); err != nil {
+		t.Fatalf("failed to publish the event: %v", err)
+	}
+}
+
+func TestBindCluster(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	testCases := map[string]struct {
+		cluster     v1beta1.NatssChannelCluster
+		want        v1beta1.NatssChannelCluster
+		wantDefault bool
+	}{
+		"defaults": {
+			want:        v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "default"},
+			wantDefault: true,
+		},
+		"cluster id": {
+			cluster: v1beta1.NatssChannelCluster{ClusterID: "other"},
+			want:    v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "other"},
+		},
+		"url": {
+			cluster: v1beta1.NatssChannelCluster{NatsURL: "nats://other:4222"},
+			want:    v1beta1.NatssChannelCluster{NatsURL: "nats://other:4222", ClusterID: "default"},
+		},
+		"cluster of the dispatcher": {
+			cluster:     v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "default"},
+			want:        v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "default"},
+			wantDefault: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got := s.BindCluster(ref, tc.cluster); got != tc.want {
+				t.Errorf("BindCluster() = %+v, want %+v", got, tc.want)
+			}
+			if got := s.clusterOf(ref) == s.connKey; got != tc.wantDefault {
+				t.Errorf("bound to the cluster of the dispatcher: %t, want %t", got, tc.wantDefault)
+			}
+		})
+	}
+}
+
+func TestClusterConnections(t *testing.T) {
+	s, defaultConn := newTestSupervisor(t)
+	pool := newFakeConnPool()
+	s.conns = pool
+	onDefault := eventingchannels.ChannelReference{Namespace: "ns", Name: "default"}
+	onOther := eventingchannels.ChannelReference{Namespace: "ns", Name: "other"}
+	alsoOnOther := eventingchannels.ChannelReference{Namespace: "ns2", Name: "other"}
+	s.BindCluster(onDefault, v1beta1.NatssChannelCluster{})
+	cluster := s.BindCluster(onOther, v1beta1.NatssChannelCluster{NatsURL: "nats://other:4222", ClusterID: "other"})
+	s.BindCluster(alsoOnOther, cluster)
+	otherKey := s.clusterOf(onOther)
+
+	defaultEvents := stored(defaultConn)
+	publishTo(t, s, onDefault)
+	publishTo(t, s, onOther)
+	publishTo(t, s, alsoOnOther)
+	if len(*defaultEvents) != 1 {
+		t.Errorf("%d events stored in the cluster of the dispatcher, want 1", len(*defaultEvents))
+	}
+	if pool.dials != 1 {
+		t.Errorf("%d connections made, want a single one shared by the channels of the other cluster", pool.dials)
+	}
+
+	// The lost connection is replaced on its next use.
+	lost := pool.lose(otherKey)
+	publishTo(t, s, onOther)
+	if pool.dials != 2 {
+		t.Errorf("%d connections made, want the lost one replaced", pool.dials)
+	}
+	if got := pool.refs[lost]; got != 0 {
+		t.Errorf("the lost connection has %d users, want it released", got)
+	}
+
+	if err := s.closeClusterConnections(); err != nil {
+		t.Fatalf("closeClusterConnections() = %v", err)
+	}
+	if got := pool.refs[pool.conns[otherKey]]; got != 0 {
+		t.Errorf("the connection has %d users once closed, want 0", got)
+	}
+	if _, err := s.connection(context.Background(), onOther); err == nil {
+		t.Error("connection() succeeded once the connections are closed")
+	}
+}
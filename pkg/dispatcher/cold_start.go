@@ -0,0 +1,168 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+const (
+	// retryReasonColdStart and retryReasonFailure are the reasons of the retries of the deliveries:
+	// a subscriber starting from zero, or any other failure.
+	retryReasonColdStart = "cold_start"
+	retryReasonFailure   = "failure"
+)
+
+var (
+	// coldStartRetries is how many times the deliveries refused while the subscriber starts are
+	// retried, on top of the retries of the delivery spec of the subscription.
+	coldStartRetries = 6
+	// coldStartBackoffDelay is the delay before the first cold start retry, doubled for each of the
+	// next ones up to coldStartMaxBackoff.
+	coldStartBackoffDelay = 500 * time.Millisecond
+	coldStartMaxBackoff   = 8 * time.Second
+)
+
+// ColdStartSetter is implemented by the dispatchers retrying the deliveries to the subscribers
+// scaled to zero, such as Knative Services, with a backoff of their own while they start. The
+// activator of Knative Serving answers 503 while no replica of the subscriber is ready, which the
+// retries of the delivery spec, tuned for genuine failures, are usually too short to outlast.
+type ColdStartSetter interface {
+	// SetColdStart sets the subscriptions of channel whose subscriber may be scaled to zero, by UID.
+	// It must be called before updating the subscriptions of the channel for the ack wait of the
+	// subscriptions made to cover the cold start retries.
+	SetColdStart(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool)
+}
+
+var _ ColdStartSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetColdStart implements ColdStartSetter.
+func (s *SubscriptionsSupervisor) SetColdStart(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool) {
+	if len(subscriptions) == 0 {
+		s.coldStart.Delete(channel)
+		return
+	}
+	s.coldStart.Store(channel, subscriptions)
+}
+
+// coldStarting tells whether the subscriber of subscription of channel may be scaled to zero.
+func (s *SubscriptionsSupervisor) coldStarting(channel eventingchannels.ChannelReference, subscription types.UID) bool {
+	subscriptions, ok := s.coldStart.Load(channel)
+	return ok && subscriptions.(map[types.UID]bool)[subscription]
+}
+
+// coldStartAckWait returns how much the ack wait of subscription of channel is extended for NATSS
+// not to redeliver the events while their cold start retries are made.
+func (s *SubscriptionsSupervisor) coldStartAckWait(channel eventingchannels.ChannelReference, subscription types.UID) time.Duration {
+	if !s.coldStarting(channel, subscription) {
+		return 0
+	}
+	var wait time.Duration
+	for i := 0; i < coldStartRetries; i++ {
+		wait += coldStartBackoff(i)
+	}
+	return wait
+}
+
+// coldStartBackoff returns the delay before the cold start retry attempt, starting from zero.
+func coldStartBackoff(attempt int) time.Duration {
+	delay := coldStartBackoffDelay
+	for i := 0; i < attempt && delay < coldStartMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > coldStartMaxBackoff {
+		return coldStartMaxBackoff
+	}
+	return delay
+}
+
+// isColdStartResponse tells whether resp is the one of the activator while the subscriber starts.
+func isColdStartResponse(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// deliveryRetries counts the retries of a delivery to the subscriber of subscription of channel,
+// by reason.
+type deliveryRetries struct {
+	s            *SubscriptionsSupervisor
+	channel      eventingchannels.ChannelReference
+	subscription types.UID
+	// retry is the retry config of the subscription, nil when its failed deliveries are not
+	// retried.
+	retry     *kncloudevents.RetryConfig
+	coldStart bool
+
+	coldStarts, failures int
+	// reason is the reason of the last retry.
+	reason string
+}
+
+// retryOfDelivery returns how a delivery to the subscriber of subscription of channel is retried,
+// following retry and, when its subscriber may be scaled to zero, retrying the responses of the
+// activator with the cold start backoff, nil when it is not retried. The retries are counted by
+// reason.
+func (s *SubscriptionsSupervisor) retryOfDelivery(channel eventingchannels.ChannelReference, subscription types.UID, retry *kncloudevents.RetryConfig) *kncloudevents.RetryConfig {
+	r := &deliveryRetries{s: s, channel: channel, subscription: subscription, retry: retry, coldStart: s.coldStarting(channel, subscription)}
+	if retry == nil && !r.coldStart {
+		return nil
+	}
+	config := &kncloudevents.RetryConfig{CheckRetry: r.checkRetry, Backoff: r.backoff}
+	if retry != nil {
+		config.RetryMax, config.BackoffDelay, config.BackoffPolicy = retry.RetryMax, retry.BackoffDelay, retry.BackoffPolicy
+	}
+	if r.coldStart {
+		// The budgets of both reasons are enforced by checkRetry.
+		config.RetryMax += coldStartRetries
+	}
+	return config
+}
+
+// checkRetry retries the responses of the activator until the cold start retries are exhausted,
+// and the other failures following the retry config of the subscription.
+func (r *deliveryRetries) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if r.coldStart && isColdStartResponse(resp) && r.coldStarts < coldStartRetries {
+		r.coldStarts++
+		r.reason = retryReasonColdStart
+		r.s.recordRetry(r.channel, r.subscription, retryReasonColdStart)
+		return true, nil
+	}
+	if r.retry == nil || r.failures >= r.retry.RetryMax {
+		return false, nil
+	}
+	retry, checkErr := r.retry.CheckRetry(ctx, resp, err)
+	if retry {
+		r.failures++
+		r.reason = retryReasonFailure
+		r.s.recordRetry(r.channel, r.subscription, retryReasonFailure)
+	}
+	return retry, checkErr
+}
+
+// backoff returns the delay before the retry checkRetry just decided, following the attempts of
+// its reason.
+func (r *deliveryRetries) backoff(_ int, resp *http.Response) time.Duration {
+	if r.reason == retryReasonColdStart {
+		return coldStartBackoff(r.coldStarts - 1)
+	}
+	return r.retry.Backoff(r.failures-1, resp)
+}
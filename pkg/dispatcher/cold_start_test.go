@@ -0,0 +1,190 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+func withColdStartTiming(t *testing.T) {
+	retries, delay, max := coldStartRetries, coldStartBackoffDelay, coldStartMaxBackoff
+	coldStartRetries, coldStartBackoffDelay, coldStartMaxBackoff = 4, time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { coldStartRetries, coldStartBackoffDelay, coldStartMaxBackoff = retries, delay, max })
+}
+
+// newColdStartSubscriber returns a subscriber answering like the activator, 503, to its first
+// refused requests, then code, counting them all in attempts.
+func newColdStartSubscriber(refused int32, code int, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(attempts, 1) <= refused {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(code)
+	}))
+}
+
+func TestColdStartRetries(t *testing.T) {
+	withColdStartTiming(t)
+	testCases := map[string]struct {
+		coldStart bool
+		// refused is how many requests the activator refuses before the subscriber answers code.
+		refused  int32
+		code     int
+		delivery *eventingduckv1.DeliverySpec
+
+		wantAttempts int32
+	}{
+		"cold start": {
+			coldStart:    true,
+			refused:      3,
+			code:         http.StatusAccepted,
+			wantAttempts: 4,
+		},
+		"cold start on top of the retries": {
+			coldStart:    true,
+			refused:      3,
+			code:         http.StatusInternalServerError,
+			delivery:     newRetryDelivery(2, eventingduckv1.BackoffPolicyLinear, "PT0.001S"),
+			wantAttempts: 6,
+		},
+		"cold start exhausted": {
+			coldStart:    true,
+			refused:      100,
+			code:         http.StatusAccepted,
+			delivery:     newRetryDelivery(1, eventingduckv1.BackoffPolicyLinear, "PT0.001S"),
+			wantAttempts: 6,
+		},
+		"genuine failure": {
+			coldStart:    true,
+			code:         http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+		"not scaled to zero": {
+			refused:      3,
+			code:         http.StatusAccepted,
+			delivery:     newRetryDelivery(1, eventingduckv1.BackoffPolicyLinear, "PT0.001S"),
+			wantAttempts: 2,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var attempts int32
+			subscriber := newColdStartSubscriber(tc.refused, tc.code, &attempts)
+			defer subscriber.Close()
+
+			s, conn := newTestSupervisor(t)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			uid := types.UID("uid-" + n)
+			if tc.coldStart {
+				s.SetColdStart(ref, map[types.UID]bool{uid: true})
+			}
+			channel := newTestChannel(ref)
+			channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+				UID:           uid,
+				SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+				Delivery:      tc.delivery,
+			}}
+			if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+
+			conn.publish(newTestEventMsg(t, "cold"))
+
+			if got := atomic.LoadInt32(&attempts); got != tc.wantAttempts {
+				t.Errorf("the subscriber was sent the event %d times, want %d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestColdStartRetryReasons(t *testing.T) {
+	withColdStartTiming(t)
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	retry, err := s.retryConfig(context.Background(), subscriptionReference{
+		Delivery: newRetryDelivery(2, eventingduckv1.BackoffPolicyLinear, "PT1S"),
+	})
+	if err != nil {
+		t.Fatalf("retryConfig() = %v", err)
+	}
+	r := &deliveryRetries{s: s, channel: ref, subscription: "uid-0", retry: retry, coldStart: true}
+	refused := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	failed := &http.Response{StatusCode: http.StatusInternalServerError}
+
+	// The failures and the refusals of the activator are retried with the backoff of their reason,
+	// each within its own budget.
+	steps := []struct {
+		resp        *http.Response
+		wantRetry   bool
+		wantBackoff time.Duration
+	}{
+		{resp: refused, wantRetry: true, wantBackoff: time.Millisecond},
+		{resp: failed, wantRetry: true},
+		{resp: refused, wantRetry: true, wantBackoff: 2 * time.Millisecond},
+		{resp: refused, wantRetry: true, wantBackoff: 4 * time.Millisecond},
+		{resp: refused, wantRetry: true, wantBackoff: 4 * time.Millisecond},
+		// The cold start retries exhausted, the refusals are failures.
+		{resp: refused, wantRetry: true, wantBackoff: time.Second},
+		{resp: failed},
+	}
+	for i, step := range steps {
+		retried, err := r.checkRetry(context.Background(), step.resp, nil)
+		if err != nil || retried != step.wantRetry {
+			t.Fatalf("step %d: checkRetry() = %t, %v, want %t", i, retried, err, step.wantRetry)
+		}
+		if !retried {
+			continue
+		}
+		if got := r.backoff(i, step.resp); got != step.wantBackoff {
+			t.Errorf("step %d: backoff() = %v, want %v", i, got, step.wantBackoff)
+		}
+	}
+	if r.coldStarts != 4 || r.failures != 2 {
+		t.Errorf("retried %d cold starts and %d failures, want 4 and 2", r.coldStarts, r.failures)
+	}
+}
+
+func TestColdStartAckWait(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetColdStart(ref, map[types.UID]bool{"uid-0": true})
+
+	// 0.5s, 1s, 2s, 4s and twice the 8s cap.
+	if got, want := s.coldStartAckWait(ref, "uid-0"), 23500*time.Millisecond; got != want {
+		t.Errorf("coldStartAckWait() = %v, want %v", got, want)
+	}
+	if got := s.coldStartAckWait(ref, "uid-1"); got != 0 {
+		t.Errorf("coldStartAckWait() of another subscription = %v, want 0", got)
+	}
+
+	// The channel forgotten, its subscriptions are no longer cold started.
+	s.SetColdStart(ref, nil)
+	if got := s.coldStartAckWait(ref, "uid-0"); got != 0 {
+		t.Errorf("coldStartAckWait() once removed = %v, want 0", got)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// ConfigUpdater is implemented by the dispatchers applying the changes of their configuration
+// without restarting.
+type ConfigUpdater interface {
+	// UpdateConfig applies update. The delivery limits apply to the subscriptions made from then
+	// on, the delivery concurrency to the deliveries starting from then on, and the egress limits
+	// at once. A change of the cluster of the dispatcher or of its publish options makes its
+	// connection to NATSS again, the subscriptions of the channels bound to no other cluster
+	// being made again on it.
+	UpdateConfig(update ConfigUpdate)
+}
+
+var _ ConfigUpdater = (*SubscriptionsSupervisor)(nil)
+
+// ConfigUpdate is the configuration of a dispatcher which can change while it runs.
+type ConfigUpdate struct {
+	// NatssURL and ClusterID are those of the cluster of the dispatcher.
+	NatssURL  string
+	ClusterID string
+	// ReconnectBackoff and ReconnectMaxBackoff are as in Args.
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	// DeliveryLimits are those of the channels without delivery limits of their own.
+	DeliveryLimits DeliveryLimits
+	// DeliveryConcurrency is as in Args.
+	DeliveryConcurrency int
+	// Egress is as in Args.
+	Egress EgressLimits
+	// Maintenance tells NATSS is under maintenance: the subscriptions lost with the connection
+	// are made again once it ends rather than on reconnection.
+	Maintenance bool
+	// Publish are the options of the publications of the receiver, its Mode applying once the
+	// dispatcher restarts.
+	Publish PublishOptions
+}
+
+// UpdateConfig implements ConfigUpdater.
+func (s *SubscriptionsSupervisor) UpdateConfig(update ConfigUpdate) {
+	if update.DeliveryConcurrency <= 0 {
+		update.DeliveryConcurrency = DefaultDeliveryConcurrency
+	}
+	update.Publish.Mode = s.publishMode
+
+	s.configMux.Lock()
+	key := stanutil.ConnKey{ClusterID: update.ClusterID, ClientID: s.connKey.ClientID, URL: update.NatssURL}
+	reconnect := key != s.connKey || update.Publish != s.publishOptions
+	s.connKey = key
+	s.publishOptions = update.Publish
+	s.reconnectBackoff, s.reconnectMaxBackoff = update.ReconnectBackoff, update.ReconnectMaxBackoff
+	limits := update.DeliveryLimits
+	s.maxRedirects, s.maxInflight, s.ackWait, s.startAt = limits.MaxRedirects, limits.MaxInflight, limits.AckWait, limits.StartAt
+	if update.DeliveryConcurrency != s.deliveryConcurrency {
+		s.deliveryConcurrency = update.DeliveryConcurrency
+		// The deliveries in flight give their worker back to the pool they took it from.
+		s.workerPools.Range(func(channel, _ interface{}) bool {
+			s.workerPools.Delete(channel)
+			return true
+		})
+	}
+	s.egress.setLimits(update.Egress)
+	if reconnect && s.setStanOptions != nil {
+		s.setStanOptions(update.Publish.stanOptions()...)
+	}
+	s.configMux.Unlock()
+
+	s.setMaintenance(update.Maintenance)
+	if reconnect {
+		s.connectionLogger.Info("The connection to NATSS changed, reconnecting", zap.String("clusterID", key.ClusterID),
+			zap.String("url", key.URL))
+		s.signalReconnect()
+	}
+}
+
+// dispatcherKey returns the key of the connection of the dispatcher.
+func (s *SubscriptionsSupervisor) dispatcherKey() stanutil.ConnKey {
+	s.configMux.RLock()
+	defer s.configMux.RUnlock()
+	return s.connKey
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+func TestUpdateConfigReconnects(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	s.natssConn = nil
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	pool := newFakeConnPool()
+	s.conns = pool
+	s.maxPayloadOf = func(stan.Conn) int64 { return 0 }
+	var stanOptions int
+	s.setStanOptions = func(opts ...stan.Option) { stanOptions = len(opts) }
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Connect(ctx)
+	s.signalReconnect()
+	waitConnected(t, s)
+	if _, err := s.UpdateSubscriptions(ctx, newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	old := s.connKey
+
+	// The settings applied live leave the connection.
+	s.UpdateConfig(ConfigUpdate{NatssURL: old.URL, ClusterID: old.ClusterID, DeliveryConcurrency: 5})
+	select {
+	case <-s.Connected():
+		t.Fatal("reconnected without a change of the connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The subscriptions follow the connection to the new cluster.
+	s.UpdateConfig(ConfigUpdate{NatssURL: "nats://other:4222", ClusterID: "other", Publish: PublishOptions{AckTimeout: time.Second}})
+	waitConnected(t, s)
+	key := stanutil.ConnKey{ClusterID: "other", ClientID: "test", URL: "nats://other:4222"}
+	if status := s.ConnectionStatus(ref); !status.Ready || status.URL != key.URL {
+		t.Errorf("ConnectionStatus() = %+v, want ready at %s", status, key.URL)
+	}
+	if stanOptions != 1 {
+		t.Errorf("the connections are made with %d options, want the publish ack timeout", stanOptions)
+	}
+	pool.mu.Lock()
+	oldRefs, conn := pool.refs[pool.conns[old]], pool.conns[key]
+	pool.mu.Unlock()
+	if oldRefs != 0 {
+		t.Errorf("the connection to the previous cluster has %d users, want released", oldRefs)
+	}
+	if conn == nil {
+		t.Fatal("not connected to the new cluster")
+	}
+	conn.publish(newTestEventMsg(t, "moved"))
+	if got := subscriber.received(); len(got) != 1 || got[0] != "moved" {
+		t.Errorf("the subscriber received %v, want the event of the new cluster", got)
+	}
+}
+
+func TestUpdateConfigLive(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	before := s.workerPool(ref)
+
+	limits := DeliveryLimits{MaxRedirects: 2, MaxInflight: 16, AckWait: time.Minute}
+	s.UpdateConfig(ConfigUpdate{
+		DeliveryLimits:      limits,
+		DeliveryConcurrency: 3,
+		ReconnectBackoff:    2 * time.Second,
+		ReconnectMaxBackoff: time.Minute,
+		Egress:              EgressLimits{EventsPerSecond: 100},
+	})
+	if got := s.deliveryLimitsOf(ref); got != limits {
+		t.Errorf("deliveryLimitsOf() = %+v, want %+v", got, limits)
+	}
+	if p := s.workerPool(ref); cap(p) != 3 || cap(before) != DefaultDeliveryConcurrency {
+		t.Errorf("the pool has %d workers, and had %d, want 3 and %d", cap(p), cap(before), DefaultDeliveryConcurrency)
+	}
+	if policy := s.connectPolicy(); policy.Initial != 2*time.Second || policy.Max != time.Minute {
+		t.Errorf("connectPolicy() = %+v, want the reconnect backoff of the configuration", policy)
+	}
+	if atomic.LoadInt32(&s.egress.enabled) == 0 {
+		t.Error("the egress limits are disabled, want them applied")
+	}
+
+	// Unset, the settings are the defaults.
+	s.UpdateConfig(ConfigUpdate{})
+	if policy := s.connectPolicy(); policy.Initial != retryInterval || policy.Max != maxRetryInterval {
+		t.Errorf("connectPolicy() = %+v, want the default backoff", policy)
+	}
+	if p := s.workerPool(ref); cap(p) != DefaultDeliveryConcurrency {
+		t.Errorf("the pool has %d workers, want %d", cap(p), DefaultDeliveryConcurrency)
+	}
+	if atomic.LoadInt32(&s.egress.enabled) != 0 {
+		t.Error("the egress limits are enabled, want them lifted")
+	}
+}
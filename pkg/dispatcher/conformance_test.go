@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher_test
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+)
+
+func TestConformanceSubscriptionsSupervisor(t *testing.T) {
+	dispatchertesting.RunConformance(t, dispatchertesting.Conformance{
+		New: func(t *testing.T) dispatcher.NatssDispatcher { return dispatcher.NewTestSupervisor(t) },
+		Subscribed: func(d dispatcher.NatssDispatcher, channel eventingchannels.ChannelReference) []types.UID {
+			return dispatcher.Subscribed(d.(*dispatcher.SubscriptionsSupervisor), channel)
+		},
+	})
+}
+
+func TestConformanceSubscriptionsSupervisorDisconnected(t *testing.T) {
+	dispatchertesting.RunConformance(t, dispatchertesting.Conformance{
+		New: func(t *testing.T) dispatcher.NatssDispatcher {
+			d, err := dispatcher.NewDispatcher(dispatcher.Args{ClientID: "test"})
+			if err != nil {
+				t.Fatalf("NewDispatcher() = %v", err)
+			}
+			return d
+		},
+		Subscribed: func(d dispatcher.NatssDispatcher, channel eventingchannels.ChannelReference) []types.UID {
+			return dispatcher.Subscribed(d.(*dispatcher.SubscriptionsSupervisor), channel)
+		},
+		FailsSubscriptions: true,
+		FailsFinalizations: true,
+	})
+}
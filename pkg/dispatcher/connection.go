@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// retryJitter is the fraction of the delay between the attempts to reconnect to NATSS by which it
+// is randomly shortened or lengthened, so that the dispatchers do not reconnect all at once once
+// the NATS Streaming server restarts.
+var retryJitter = 0.2
+
+// ConnectionReporter is implemented by the dispatchers reporting the health of the connections to
+// NATSS the channels are subscribed through. Their connection lost, the subscriptions are made
+// again once reconnected.
+type ConnectionReporter interface {
+	// WatchConnection sets the function called when the connection of channel is made, lost, or
+	// fails, nil removing it.
+	WatchConnection(channel eventingchannels.ChannelReference, notify func())
+	// ConnectionStatus returns the status of the connection of channel.
+	ConnectionStatus(channel eventingchannels.ChannelReference) ConnectionStatus
+}
+
+var _ ConnectionReporter = (*SubscriptionsSupervisor)(nil)
+
+// ConnectionStatus is the status of the connection to NATSS of a channel.
+type ConnectionStatus struct {
+	// URL is the NATS URL of the cluster of the channel.
+	URL string
+	// Ready tells whether the connection is made and the subscriptions of the channel are made on
+	// it.
+	Ready bool
+	// LostSince is when the connection was lost, zero when it was not.
+	LostSince time.Time
+	// Err is why the connection was lost, or why the last attempt to make it failed.
+	Err error
+}
+
+// WatchConnection implements ConnectionReporter.
+func (s *SubscriptionsSupervisor) WatchConnection(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.connectionNotifiers.Delete(channel)
+		return
+	}
+	s.connectionNotifiers.Store(channel, notify)
+}
+
+// ConnectionStatus implements ConnectionReporter. The connections to the clusters other than the
+// one of the dispatcher are made on their first use, and made again on the next use once lost:
+// they are ready until they fail.
+func (s *SubscriptionsSupervisor) ConnectionStatus(channel eventingchannels.ChannelReference) ConnectionStatus {
+	key := s.clusterOf(channel)
+	status := ConnectionStatus{URL: key.URL}
+	if key == s.dispatcherKey() {
+		s.natssConnMux.Lock()
+		defer s.natssConnMux.Unlock()
+		status.Ready = s.natssConn != nil && s.connectionLostAt.IsZero()
+		status.LostSince = s.connectionLostAt
+		if !status.Ready {
+			status.Err = s.natssConnErr
+		}
+		return status
+	}
+
+	s.clusterConnsMux.Lock()
+	defer s.clusterConnsMux.Unlock()
+	status.Err = s.clusterConnErrs[key]
+	if _, ok := s.clusterConns[key]; ok && status.Err == nil && !s.conns.Healthy(key) {
+		status.Err = errors.New("connection lost, made again on its next use")
+	}
+	status.Ready = status.Err == nil
+	return status
+}
+
+// natssConnectionLost is the lost handler of the connection manager, which reconnects to NATSS
+// when the connection of the dispatcher is lost.
+func (s *SubscriptionsSupervisor) natssConnectionLost(key stanutil.ConnKey, err error) {
+	if key != s.dispatcherKey() {
+		// Made again on its next use.
+		s.notifyConnection(key)
+		return
+	}
+	s.connectionLogger.Warn("Connection to NATSS lost, reconnecting", zap.Error(err))
+	s.natssConnMux.Lock()
+	if s.connectionLostAt.IsZero() {
+		s.connectionLostAt = time.Now()
+	}
+	s.natssConnErr = err
+	s.natssConnMux.Unlock()
+	s.notifyConnection(key)
+	s.signalReconnect()
+}
+
+// connectionRestored records that the connection is made, and the subscriptions made again on it
+// when the previous one was lost.
+func (s *SubscriptionsSupervisor) connectionRestored() {
+	s.natssConnMux.Lock()
+	lostAt := s.connectionLostAt
+	s.connectionLostAt = time.Time{}
+	s.natssConnMux.Unlock()
+	if !lostAt.IsZero() {
+		s.connectionLogger.Info("Subscriptions restored after the connection to NATSS was lost", zap.Duration("outage", time.Since(lostAt)))
+	}
+	s.notifyConnection(s.dispatcherKey())
+}
+
+// notifyConnection calls the notifiers of the channels of the cluster of key.
+func (s *SubscriptionsSupervisor) notifyConnection(key stanutil.ConnKey) {
+	s.connectionNotifiers.Range(func(channel, notify interface{}) bool {
+		if s.clusterOf(channel.(eventingchannels.ChannelReference)) == key {
+			notify.(func())()
+		}
+		return true
+	})
+}
+
+// resubscribe makes the subscriptions of the channels of the cluster of the dispatcher again on
+// its new connection, the ones of the lost connection having stopped. The durables resume where
+// they stopped, from the last version of the channels the subscriptions were updated with,
+// without waiting for the channels to be reconciled. The subscriptions failing are made again by
+// the next update of their channel.
+func (s *SubscriptionsSupervisor) resubscribe() {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+
+	key := s.dispatcherKey()
+	for channel, subscribed := range s.subscribedChannels {
+		subs, ok := s.subscriptions[channel]
+		if !ok || s.clusterOf(channel) != key {
+			// Hibernated, or connected to another cluster.
+			continue
+		}
+		for uid, sub := range subs {
+			// The subscriptions of the lost connection are gone, closing them only frees them.
+			s.stopMigration(uid, false)
+			_ = (*sub).Close()
+			s.cursors.close(channel, uid, false)
+		}
+		delete(s.subscriptions, channel)
+		delete(s.subscribedDistributions, channel)
+		delete(s.subscribedEphemeral, channel)
+		delete(s.subscribedOptions, channel)
+
+		failed, _ := s.updateSubscriptions(subscribed.ctx, channel, subscribed.channel, false)
+		for sub, err := range failed {
+			s.subscriptionsLogger.Error("Failed to subscribe again after the connection was lost", zap.String("channel", channel.String()),
+				zap.String("subscription", string(sub.UID)), zap.Error(err))
+		}
+		s.subscriptionsLogger.Info("Subscribed again after the connection was lost", zap.String("channel", channel.String()),
+			zap.Int("subscriptions", len(s.subscriptions[channel])))
+	}
+}
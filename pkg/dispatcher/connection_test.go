@@ -0,0 +1,166 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+func TestResubscribeAfterConnectionLost(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	s.natssConn = nil
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	pool := newFakeConnPool()
+	s.conns = pool
+	s.maxPayloadOf = func(stan.Conn) int64 { return 0 }
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchConnection(ref, func() { atomic.AddInt32(&notified, 1) })
+	if status := s.ConnectionStatus(ref); status.Ready || status.URL != s.connKey.URL {
+		t.Errorf("ConnectionStatus() = %+v before connecting, want not ready at %s", status, s.connKey.URL)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Connect(ctx)
+	s.signalReconnect()
+	waitConnected(t, s)
+	if status := s.ConnectionStatus(ref); !status.Ready {
+		t.Errorf("ConnectionStatus() = %+v once connected, want ready", status)
+	}
+	if _, err := s.UpdateSubscriptions(ctx, newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	pool.conns[s.connKey].publish(newTestEventMsg(t, "before"))
+
+	// The server stops: the connection is lost and cannot be made again until it restarts.
+	connected := atomic.LoadInt32(&notified)
+	pool.mu.Lock()
+	pool.err = errors.New("connection refused")
+	pool.mu.Unlock()
+	pool.lose(s.connKey)
+	s.natssConnectionLost(s.connKey, errors.New("server stopped"))
+	if status := s.ConnectionStatus(ref); status.Ready || status.LostSince.IsZero() || status.Err == nil {
+		t.Errorf("ConnectionStatus() = %+v while the server is down, want lost with an error", status)
+	}
+	if atomic.LoadInt32(&notified) == connected {
+		t.Error("the channel was not notified of the loss")
+	}
+
+	// The server restarts.
+	time.Sleep(3 * retryInterval)
+	lost := atomic.LoadInt32(&notified)
+	pool.mu.Lock()
+	pool.err = nil
+	pool.mu.Unlock()
+	waitConnected(t, s)
+	if status := s.ConnectionStatus(ref); !status.Ready || !status.LostSince.IsZero() || status.Err != nil {
+		t.Errorf("ConnectionStatus() = %+v once the subscriptions are made again, want ready", status)
+	}
+	if atomic.LoadInt32(&notified) == lost {
+		t.Error("the channel was not notified of the restoration")
+	}
+
+	// The delivery resumes without the channel being updated again.
+	pool.mu.Lock()
+	conn := pool.conns[s.connKey]
+	pool.mu.Unlock()
+	conn.publish(newTestEventMsg(t, "after"))
+	if got := subscriber.received(); len(got) != 2 || got[1] != "after" {
+		t.Errorf("the subscriber received %v, want the events before and after the restart", got)
+	}
+}
+
+func TestConnectionStatusOfAnotherCluster(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	pool := newFakeConnPool()
+	s.conns = pool
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.BindCluster(ref, v1beta1.NatssChannelCluster{NatsURL: "nats://other:4222", ClusterID: "other"})
+	other := s.clusterOf(ref)
+	var notified int32
+	s.WatchConnection(ref, func() { atomic.AddInt32(&notified, 1) })
+
+	// Not connected until its first use, the connection is ready until it fails.
+	if status := s.ConnectionStatus(ref); !status.Ready || status.URL != "nats://other:4222" {
+		t.Errorf("ConnectionStatus() = %+v, want ready at nats://other:4222", status)
+	}
+
+	pool.err = errors.New("connection refused")
+	if _, err := s.connection(context.Background(), ref); err == nil {
+		t.Fatal("connection() succeeded while the cluster is down")
+	}
+	if status := s.ConnectionStatus(ref); status.Ready || status.Err == nil {
+		t.Errorf("ConnectionStatus() = %+v, want the error of the cluster", status)
+	}
+	pool.err = nil
+	if _, err := s.connection(context.Background(), ref); err != nil {
+		t.Fatalf("connection() = %v", err)
+	}
+	if status := s.ConnectionStatus(ref); !status.Ready {
+		t.Errorf("ConnectionStatus() = %+v, want ready", status)
+	}
+	if atomic.LoadInt32(&notified) != 2 {
+		t.Errorf("the channel was notified %d times, want of the failure and of the connection", atomic.LoadInt32(&notified))
+	}
+
+	// The loss of the connection of the other cluster leaves the connection of the dispatcher.
+	pool.lose(other)
+	s.natssConnectionLost(other, errors.New("server stopped"))
+	if status := s.ConnectionStatus(ref); status.Ready {
+		t.Errorf("ConnectionStatus() = %+v once lost, want not ready", status)
+	}
+	s.natssConnMux.Lock()
+	lostAt := s.connectionLostAt
+	s.natssConnMux.Unlock()
+	if !lostAt.IsZero() {
+		t.Error("the loss of the connection to another cluster was taken for the one of the dispatcher")
+	}
+}
+
+func TestConnectPolicyJitter(t *testing.T) {
+	if got := connectPolicy().Jitter; got != retryJitter {
+		t.Errorf("connectPolicy().Jitter = %v, want %v", got, retryJitter)
+	}
+}
+
+// waitConnected waits for the connection of s to be established.
+func waitConnected(t *testing.T, s *SubscriptionsSupervisor) {
+	t.Helper()
+	select {
+	case <-s.Connected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected to NATSS")
+	}
+}
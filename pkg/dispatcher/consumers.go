@@ -0,0 +1,223 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	natsscloudevents "github.com/cloudevents/sdk-go/protocol/stan/v2"
+	"github.com/nats-io/stan.go"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// MaxConsumers is the maximum number of consumers of a subscription.
+const MaxConsumers = 32
+
+// ConsumersSetter is implemented by the dispatchers able to process the events of a subscription
+// with several consumers in parallel. The consumers are the members of a queue group named after
+// the subscription, sharing a durable of their own, and each event is delivered to one of them
+// only, the order of the events not being kept.
+type ConsumersSetter interface {
+	// SetConsumers sets the number of consumers of the subscriptions of channel having more than
+	// one, by UID. It must be called before updating the subscriptions of the channel to take
+	// effect, which makes again the subscriptions whose number of consumers changed. A
+	// subscription switching between a single consumer and several ones loses the events its
+	// previous durable held. The members of a work queue and the subscriptions delivered in
+	// order have a single consumer.
+	SetConsumers(channel eventingchannels.ChannelReference, consumers map[types.UID]int)
+	// Consumers returns the number of consumers the dispatcher holds the subscription of channel
+	// with, zero when it does not hold it.
+	Consumers(channel eventingchannels.ChannelReference, subscription types.UID) int
+}
+
+var _ ConsumersSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetConsumers implements ConsumersSetter.
+func (s *SubscriptionsSupervisor) SetConsumers(channel eventingchannels.ChannelReference, consumers map[types.UID]int) {
+	if len(consumers) == 0 {
+		s.consumers.Delete(channel)
+		return
+	}
+	s.consumers.Store(channel, consumers)
+}
+
+// Consumers implements ConsumersSetter.
+func (s *SubscriptionsSupervisor) Consumers(channel eventingchannels.ChannelReference, subscription types.UID) int {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	sub, ok := s.subscriptions[channel][subscription]
+	if !ok {
+		return 0
+	}
+	return consumersOf(*sub)
+}
+
+// consumerSubscriptions returns the number of consumers of the subscriptions of channel having
+// more than one when its events are distributed with distribution.
+func (s *SubscriptionsSupervisor) consumerSubscriptions(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution) map[types.UID]int {
+	if distribution == v1beta1.DistributionWorkQueue || s.deliveredInOrder(channel) {
+		return nil
+	}
+	c, ok := s.consumers.Load(channel)
+	if !ok {
+		return nil
+	}
+	consumers := c.(map[types.UID]int)
+	ordered := s.orderedSubscriptions(channel, distribution)
+	if len(ordered) == 0 {
+		return consumers
+	}
+	// The subscriptions delivered in order have a single consumer.
+	unordered := make(map[types.UID]int, len(consumers))
+	for uid, n := range consumers {
+		if !ordered[uid] {
+			unordered[uid] = n
+		}
+	}
+	return unordered
+}
+
+// consumersOfSubscription returns the number of consumers to subscribe subscription of channel
+// with.
+func (s *SubscriptionsSupervisor) consumersOfSubscription(channel eventingchannels.ChannelReference, subscription types.UID) int {
+	if n := s.consumerSubscriptions(channel, s.distribution(channel))[subscription]; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// subscribeConsumers makes the subscription of consumers with subscriber, a consumerGroup when
+// there are several. The members made are closed when one of them fails.
+func (s *SubscriptionsSupervisor) subscribeConsumers(conn stan.Conn, subscriber natsscloudevents.Subscriber, consumers int, subject string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	if consumers <= 1 {
+		return subscriber.Subscribe(conn, subject, cb, opts...)
+	}
+	group := make(consumerGroup, 0, consumers)
+	for i := 0; i < consumers; i++ {
+		member, err := subscriber.Subscribe(conn, subject, cb, opts...)
+		if err != nil {
+			_ = group.Close()
+			return nil, err
+		}
+		group = append(group, member)
+	}
+	return group, nil
+}
+
+// consumersOf returns the number of consumers of sub.
+func consumersOf(sub stan.Subscription) int {
+	if group, ok := sub.(consumerGroup); ok {
+		return len(group)
+	}
+	return 1
+}
+
+// consumerGroup is the subscription of the consumers of a subscription, the members of its queue
+// group.
+type consumerGroup []stan.Subscription
+
+var _ stan.Subscription = consumerGroup(nil)
+
+// Unsubscribe unsubscribes every member, the durable of the group being removed with the last one.
+func (g consumerGroup) Unsubscribe() error {
+	return g.each(stan.Subscription.Unsubscribe)
+}
+
+// Close closes every member, keeping the durable of the group.
+func (g consumerGroup) Close() error {
+	return g.each(stan.Subscription.Close)
+}
+
+func (g consumerGroup) ClearMaxPending() error {
+	return g.each(stan.Subscription.ClearMaxPending)
+}
+
+func (g consumerGroup) Delivered() (int64, error) {
+	var total int64
+	for _, member := range g {
+		n, err := member.Delivered()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (g consumerGroup) Dropped() (int, error) {
+	total := 0
+	for _, member := range g {
+		n, err := member.Dropped()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// IsValid tells whether every member is valid.
+func (g consumerGroup) IsValid() bool {
+	for _, member := range g {
+		if !member.IsValid() {
+			return false
+		}
+	}
+	return len(g) > 0
+}
+
+func (g consumerGroup) MaxPending() (int, int, error) {
+	return g.sum(stan.Subscription.MaxPending)
+}
+
+func (g consumerGroup) Pending() (int, int, error) {
+	return g.sum(stan.Subscription.Pending)
+}
+
+// PendingLimits returns the limits of the first member, the members sharing them.
+func (g consumerGroup) PendingLimits() (int, int, error) {
+	return g[0].PendingLimits()
+}
+
+func (g consumerGroup) SetPendingLimits(msgLimit, bytesLimit int) error {
+	return g.each(func(member stan.Subscription) error { return member.SetPendingLimits(msgLimit, bytesLimit) })
+}
+
+// each calls f with every member, returning the first error.
+func (g consumerGroup) each(f func(stan.Subscription) error) error {
+	var firstErr error
+	for _, member := range g {
+		if err := f(member); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sum returns the sums of the messages and bytes of the members returned by f.
+func (g consumerGroup) sum(f func(stan.Subscription) (int, int, error)) (int, int, error) {
+	msgs, bytes := 0, 0
+	for _, member := range g {
+		m, b, err := f(member)
+		if err != nil {
+			return 0, 0, err
+		}
+		msgs, bytes = msgs+m, bytes+b
+	}
+	return msgs, bytes, nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestConsumers(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+	members := func() []*fakeStanSubscription {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return append([]*fakeStanSubscription(nil), conn.groups[string(uid)]...)
+	}
+
+	s.SetConsumers(ref, map[types.UID]int{uid: 2})
+	update()
+	got := members()
+	if len(got) != 2 || len(conn.subs) != 0 {
+		t.Fatalf("got %d queue group members and %d subscriptions, want 2 members", len(got), len(conn.subs))
+	}
+	for _, member := range got {
+		if member.durable != string(uid) {
+			t.Errorf("member of the durable %q, want %q", member.durable, uid)
+		}
+	}
+	if n := s.Consumers(ref, uid); n != 2 {
+		t.Errorf("Consumers() = %d, want 2", n)
+	}
+
+	// Each event is delivered to a single consumer.
+	conn.publish(newTestEventMsg(t, "1"))
+	conn.publish(newTestEventMsg(t, "2"))
+	if ids := subscriber.received(); len(ids) != 2 {
+		t.Errorf("received %q, want each event once", ids)
+	}
+
+	// The consumers are made again, sharing the durable of the group.
+	s.SetConsumers(ref, map[types.UID]int{uid: 3})
+	update()
+	if got := members(); len(got) != 3 {
+		t.Errorf("got %d queue group members, want 3", len(got))
+	}
+	if n := s.Consumers(ref, uid); n != 3 {
+		t.Errorf("Consumers() = %d, want 3", n)
+	}
+
+	// Removing the subscriber unsubscribes every consumer.
+	channel.Spec.Subscribers = nil
+	update()
+	if got := members(); len(got) != 0 {
+		t.Errorf("got %d queue group members, want none", len(got))
+	}
+	if n := s.Consumers(ref, uid); n != 0 {
+		t.Errorf("Consumers() = %d, want 0", n)
+	}
+}
+
+func TestConsumersSwitch(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+
+	update()
+	if len(conn.subs) != 1 {
+		t.Fatalf("got %d subscriptions, want 1", len(conn.subs))
+	}
+
+	// The durable of the single consumer is removed, the consumers making their own.
+	s.SetConsumers(ref, map[types.UID]int{uid: 2})
+	update()
+	if len(conn.subs) != 0 || len(conn.groups[string(uid)]) != 2 {
+		t.Errorf("got %d subscriptions and %d queue group members, want 2 members", len(conn.subs), len(conn.groups[string(uid)]))
+	}
+	if len(conn.closed) != 0 {
+		t.Errorf("durables kept: %v", conn.closed)
+	}
+
+	s.SetConsumers(ref, nil)
+	update()
+	if len(conn.subs) != 1 || len(conn.groups[string(uid)]) != 0 {
+		t.Errorf("got %d subscriptions and %d queue group members, want 1 subscription", len(conn.subs), len(conn.groups[string(uid)]))
+	}
+	if len(conn.closed) != 0 {
+		t.Errorf("durables kept: %v", conn.closed)
+	}
+}
+
+func TestConsumersWorkQueue(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	s.SetDistribution(ref, v1beta1.DistributionWorkQueue)
+	s.SetConsumers(ref, map[types.UID]int{channel.Spec.Subscribers[0].UID: 4})
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	// The members of a work queue have a single consumer.
+	if members := conn.groups[getSubject(ref)]; len(members) != 1 {
+		t.Errorf("got %d queue group members, want 1", len(members))
+	}
+	if n := s.Consumers(ref, channel.Spec.Subscribers[0].UID); n != 1 {
+		t.Errorf("Consumers() = %d, want 1", n)
+	}
+}
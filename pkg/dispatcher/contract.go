@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/binding/format"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"knative.dev/pkg/metrics"
+)
+
+const (
+	// webhookRequestOriginHeader names the sender asking for the permission to deliver events in
+	// the abuse protection handshake of the CloudEvents HTTP Webhook specification.
+	webhookRequestOriginHeader = "WebHook-Request-Origin"
+	// webhookAllowedOriginHeader grants the permission to the sender.
+	webhookAllowedOriginHeader = "WebHook-Allowed-Origin"
+
+	// receiverAllowedMethods are the methods the receiver serves on the path of the channels.
+	receiverAllowedMethods = "POST, OPTIONS"
+
+	// specVersionHeader holds the version of the specification of the events in binary mode.
+	specVersionHeader = "Ce-Specversion"
+
+	rejectedMethodNotAllowed     = "method_not_allowed"
+	rejectedUnsupportedMediaType = "unsupported_media_type"
+	rejectedMalformedEvent       = "malformed_event"
+)
+
+var (
+	// rejectedRequestCountM records the requests to the channels the receiver refuses because
+	// they are not CloudEvents it accepts.
+	rejectedRequestCountM = stats.Int64(
+		"natss_receiver_rejected_requests_total",
+		"Number of requests to the channels refused by the receiver as not acceptable CloudEvents, by reason",
+		stats.UnitDimensionless,
+	)
+
+	// rejectionReasonKey tags the requests with rejectedMethodNotAllowed,
+	// rejectedUnsupportedMediaType or rejectedMalformedEvent.
+	rejectionReasonKey = tag.MustNewKey("reason")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: rejectedRequestCountM.Description(),
+			Measure:     rejectedRequestCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{rejectionReasonKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// withChannelContract returns a handler answering the requests to the channels the way the Knative
+// channel specification requires before calling next. It grants every sender the permission to
+// deliver events to the channels, and refuses with 405 Method Not Allowed the other methods than
+// POST, and with 415 Unsupported Media Type the requests which are neither structured nor binary
+// CloudEvents. The events which are not valid CloudEvents, which the receiver of the eventing
+// library fails to publish with 500, are refused with 400 Bad Request here when their headers are
+// malformed, and by validateEvent otherwise. The body of the refusals tells the sender why.
+func withChannelContract(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodOptions:
+			serveWebhookValidation(w, r)
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", receiverAllowedMethods)
+			rejectRequest(w, http.StatusMethodNotAllowed, rejectedMethodNotAllowed,
+				"the method %s is not allowed, the events are posted", r.Method)
+			return
+		}
+		if status, reason, err := checkEncoding(r.Header); err != nil {
+			rejectRequest(w, status, reason, "%v", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkEncoding returns the status and the reason refusing the request with header when its
+// event is in neither of the structured and binary modes of the HTTP binding of CloudEvents.
+func checkEncoding(header http.Header) (int, string, error) {
+	contentType := header.Get("Content-Type")
+	if format.Lookup(contentType) != nil {
+		return 0, "", nil
+	}
+	if strings.HasPrefix(strings.ToLower(contentType), "application/cloudevents") {
+		// The batches and the other formats of the structured mode.
+		return http.StatusUnsupportedMediaType, rejectedUnsupportedMediaType,
+			fmt.Errorf("the content type %q is not supported, the structured events are %s", contentType, structuredContentType)
+	}
+	if version := header.Get(specVersionHeader); version != "" {
+		if spec.VS.Version(version) == nil {
+			return http.StatusBadRequest, rejectedMalformedEvent, fmt.Errorf("the spec version %q is not supported", version)
+		}
+		return 0, "", nil
+	}
+	for name := range header {
+		if strings.HasPrefix(strings.ToLower(name), cloudEventsHeaderPrefix) {
+			return http.StatusBadRequest, rejectedMalformedEvent,
+				fmt.Errorf("the binary event has no %s header", strings.ToLower(specVersionHeader))
+		}
+	}
+	return http.StatusUnsupportedMediaType, rejectedUnsupportedMediaType,
+		fmt.Errorf("the request with the content type %q is not a CloudEvent: the structured events are %s, the binary ones carry %s headers",
+			contentType, structuredContentType, cloudEventsHeaderPrefix)
+}
+
+// rejectRequest refuses the request with status and the message of format and args, recording the
+// rejection with reason.
+func rejectRequest(w http.ResponseWriter, status int, reason string, format string, args ...interface{}) {
+	recordRejectedRequest(reason)
+	http.Error(w, fmt.Sprintf(format, args...), status)
+}
+
+// serveWebhookValidation answers the abuse protection handshake of the CloudEvents HTTP Webhook
+// specification, allowing the origin of r.
+func serveWebhookValidation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", receiverAllowedMethods)
+	origin := r.Header.Get(webhookRequestOriginHeader)
+	if origin == "" {
+		origin = "*"
+	}
+	w.Header().Set(webhookAllowedOriginHeader, origin)
+	w.WriteHeader(http.StatusOK)
+}
+
+// validateEvent is the IngressInterceptor refusing with 400 Bad Request the events which are not
+// valid CloudEvents.
+func validateEvent(_ context.Context, e *event.Event, _ http.Header) (*event.Event, error) {
+	if err := e.Validate(); err != nil {
+		recordRejectedRequest(rejectedMalformedEvent)
+		return nil, NewIngressError(http.StatusBadRequest, "invalid event: %w", err)
+	}
+	return e, nil
+}
+
+func recordRejectedRequest(reason string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(rejectionReasonKey, reason))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, rejectedRequestCountM.M(1))
+}
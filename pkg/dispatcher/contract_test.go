@@ -0,0 +1,405 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/nats-io/stan.go"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// The tests of this file check the data plane of the channel against the requirements of the
+// Knative channel specification, with the receiver and the dispatcher running on the in-memory
+// NATSS of fakeStanConn.
+
+const contractChannelHost = "channel-kn-channel.ns.svc.cluster.local"
+
+// contractRequest is a request received by a subscriber or a reply sink.
+type contractRequest struct {
+	method      string
+	specVersion string
+	id          string
+	extension   interface{}
+	data        string
+}
+
+// contractSink records the requests it receives, and answers the ones to subscribers with reply.
+type contractSink struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []contractRequest
+}
+
+func newContractSink(t *testing.T, reply func(http.ResponseWriter)) *contractSink {
+	s := &contractSink{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := contractRequest{method: req.Method}
+		if e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req)); err == nil {
+			r.specVersion, r.id, r.data = e.SpecVersion(), e.ID(), string(e.Data())
+			r.extension = e.Extensions()["contract"]
+		} else {
+			t.Logf("the sink received an invalid event: %v", err)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.mu.Unlock()
+		if reply != nil {
+			reply(w)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return s
+}
+
+func (s *contractSink) received() []contractRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]contractRequest(nil), s.requests...)
+}
+
+// newContractChannel serves the receiver of a channel with subscriber, which replies to replySink
+// when it is not nil.
+func newContractChannel(t *testing.T, subscriber, replySink *contractSink) *httptest.Server {
+	d, err := NewDispatcher(Args{ClientID: "test", Reporter: eventingchannels.NewStatsReporter("dispatcher", "test")})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	var natssConn stan.Conn = newFakeStanConn()
+	s.natssConn = &natssConn
+
+	channel := messagingv1.Channel{}
+	channel.Namespace, channel.Name = "ns", "channel"
+	channel.Status.Address = &duckv1.Addressable{URL: apis.HTTP(contractChannelHost)}
+	if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{channel}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	spec := eventingduckv1.SubscriberSpec{UID: types.UID("uid"), SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String())}
+	if replySink != nil {
+		spec.ReplyURI = apis.HTTP(replySink.Listener.Addr().String())
+	}
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{spec}
+	failed, err := s.UpdateSubscriptions(context.Background(), &channel, false)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	return httptest.NewServer(s.receiverHandler())
+}
+
+func binaryHeaders(specVersion string) map[string]string {
+	return map[string]string{
+		"Content-Type":   "application/json",
+		"Ce-Specversion": specVersion,
+		"Ce-Id":          "id-" + specVersion,
+		"Ce-Type":        "dev.knative.contract",
+		"Ce-Source":      "contract",
+		"Ce-Contract":    "kept",
+	}
+}
+
+func structuredBody(specVersion string) string {
+	return `{"specversion": "` + specVersion + `", "id": "id-` + specVersion + `", "type": "dev.knative.contract",` +
+		` "source": "contract", "contract": "kept", "datacontenttype": "application/json", "data": {"contract": true}}`
+}
+
+func TestChannelContractIngress(t *testing.T) {
+	testCases := map[string]struct {
+		method     string
+		host       string
+		path       string
+		headers    map[string]string
+		body       string
+		wantStatus int
+		// wantBody is a part of the body of the refusals.
+		wantBody string
+		// wantSpecVersion is the version of the event delivered to the subscriber, if any.
+		wantSpecVersion string
+	}{
+		"binary 1.0": {
+			headers:         binaryHeaders("1.0"),
+			body:            `{"contract": true}`,
+			wantStatus:      http.StatusAccepted,
+			wantSpecVersion: "1.0",
+		},
+		"binary 0.3": {
+			headers:         binaryHeaders("0.3"),
+			body:            `{"contract": true}`,
+			wantStatus:      http.StatusAccepted,
+			wantSpecVersion: "0.3",
+		},
+		"structured 1.0": {
+			headers:         map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:            structuredBody("1.0"),
+			wantStatus:      http.StatusAccepted,
+			wantSpecVersion: "1.0",
+		},
+		"structured 0.3": {
+			headers:         map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:            structuredBody("0.3"),
+			wantStatus:      http.StatusAccepted,
+			wantSpecVersion: "0.3",
+		},
+		"GET": {
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "the method GET is not allowed",
+		},
+		"PUT": {
+			method:     http.MethodPut,
+			headers:    binaryHeaders("1.0"),
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "the method PUT is not allowed",
+		},
+		"DELETE": {
+			method:     http.MethodDelete,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "the method DELETE is not allowed",
+		},
+		"not a CloudEvent": {
+			headers:    map[string]string{"Content-Type": "application/json"},
+			body:       `{"contract": true}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   `the request with the content type "application/json" is not a CloudEvent`,
+		},
+		"no content type": {
+			body:       `{"contract": true}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   `the request with the content type "" is not a CloudEvent`,
+		},
+		"batch": {
+			headers:    map[string]string{"Content-Type": "application/cloudevents-batch+json"},
+			body:       "[" + structuredBody("1.0") + "]",
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   `the content type "application/cloudevents-batch+json" is not supported`,
+		},
+		"unsupported structured format": {
+			headers:    map[string]string{"Content-Type": "application/cloudevents+avro"},
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   `the content type "application/cloudevents+avro" is not supported`,
+		},
+		"unsupported spec version": {
+			headers:    binaryHeaders("0.2"),
+			body:       `{"contract": true}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `the spec version "0.2" is not supported`,
+		},
+		"missing spec version": {
+			headers: map[string]string{
+				"Content-Type": "application/json",
+				"Ce-Id":        "id",
+				"Ce-Type":      "dev.knative.contract",
+				"Ce-Source":    "contract",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "the binary event has no ce-specversion header",
+		},
+		"missing id": {
+			headers: map[string]string{
+				"Content-Type":   "application/json",
+				"Ce-Specversion": "1.0",
+				"Ce-Type":        "dev.knative.contract",
+				"Ce-Source":      "contract",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid event: id: MUST be a non-empty string",
+		},
+		"malformed structured event": {
+			headers:    map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:       `{"specversion": "1.0"`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid event:",
+		},
+		"unknown channel": {
+			host:       "unknown-kn-channel.ns.svc.cluster.local",
+			headers:    binaryHeaders("1.0"),
+			wantStatus: http.StatusNotFound,
+		},
+		"unknown path": {
+			path:       "/path",
+			headers:    binaryHeaders("1.0"),
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			subscriber := newContractSink(t, nil)
+			defer subscriber.Close()
+			channel := newContractChannel(t, subscriber, nil)
+			defer channel.Close()
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, channel.URL+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = contractChannelHost
+			if tc.host != "" {
+				req.Host = tc.host
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() = %v", err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if !strings.Contains(string(body), tc.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tc.wantBody)
+			}
+			if tc.wantStatus == http.StatusMethodNotAllowed && resp.Header.Get("Allow") != "POST, OPTIONS" {
+				t.Errorf("Allow = %q, want POST, OPTIONS", resp.Header.Get("Allow"))
+			}
+
+			got := subscriber.received()
+			if tc.wantSpecVersion == "" {
+				if len(got) != 0 {
+					t.Errorf("the subscriber received %v, want nothing", got)
+				}
+				return
+			}
+			want := contractRequest{
+				method:      http.MethodPost,
+				specVersion: tc.wantSpecVersion,
+				id:          "id-" + tc.wantSpecVersion,
+				extension:   "kept",
+				data:        `{"contract":true}`,
+			}
+			if len(got) != 1 {
+				t.Fatalf("the subscriber received %v, want %v", got, want)
+			}
+			got[0].data = strings.Replace(got[0].data, " ", "", -1)
+			if got[0] != want {
+				t.Errorf("the subscriber received %+v, want %+v", got[0], want)
+			}
+		})
+	}
+}
+
+func TestChannelContractWebhookValidation(t *testing.T) {
+	subscriber := newContractSink(t, nil)
+	defer subscriber.Close()
+	channel := newContractChannel(t, subscriber, nil)
+	defer channel.Close()
+
+	req, err := http.NewRequest(http.MethodOptions, channel.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = contractChannelHost
+	req.Header.Set("WebHook-Request-Origin", "eventemitter.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("WebHook-Allowed-Origin"); got != "eventemitter.example.com" {
+		t.Errorf("WebHook-Allowed-Origin = %q, want the origin of the request", got)
+	}
+	if got := resp.Header.Get("Allow"); got != "POST, OPTIONS" {
+		t.Errorf("Allow = %q, want POST, OPTIONS", got)
+	}
+}
+
+func TestChannelContractReply(t *testing.T) {
+	testCases := map[string]struct {
+		reply     func(http.ResponseWriter)
+		wantReply bool
+	}{
+		"event in the response": {
+			reply: func(w http.ResponseWriter) {
+				for k, v := range binaryHeaders("1.0") {
+					w.Header().Set(k, v)
+				}
+				w.Header().Set("Ce-Id", "reply")
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"contract": true}`))
+			},
+			wantReply: true,
+		},
+		"empty response": {
+			reply: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			replySink := newContractSink(t, nil)
+			defer replySink.Close()
+			subscriber := newContractSink(t, tc.reply)
+			defer subscriber.Close()
+			channel := newContractChannel(t, subscriber, replySink)
+			defer channel.Close()
+
+			req, err := http.NewRequest(http.MethodPost, channel.URL, strings.NewReader(`{"contract": true}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = contractChannelHost
+			for k, v := range binaryHeaders("1.0") {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() = %v", err)
+			}
+			_, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+			}
+
+			got := replySink.received()
+			if !tc.wantReply {
+				if len(got) != 0 {
+					t.Errorf("the reply sink received %v, want nothing", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].method != http.MethodPost || got[0].id != "reply" {
+				t.Errorf("the reply sink received %+v, want the reply of the subscriber", got)
+			}
+		})
+	}
+}
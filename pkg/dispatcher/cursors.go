@@ -0,0 +1,259 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// DeliveryCursor is the position of a durable: every message of its channel up to Sequence was
+// acknowledged by the dispatcher. The messages after it may have been delivered too, a consumer
+// resuming after Sequence receives them again but never misses one.
+type DeliveryCursor struct {
+	Channel eventingchannels.ChannelReference
+	Durable string
+	// Sequence is the highest NATSS sequence acknowledged with all the ones before it.
+	Sequence uint64
+}
+
+// DeliveryCursorTracker is implemented by the dispatchers tracking the position of their durables
+// when the delivery-cursors feature flag is enabled.
+type DeliveryCursorTracker interface {
+	// DeliveryCursors returns the cursors of the durables whose position is known, sorted by
+	// channel and durable, and the number of messages acknowledged since the dispatcher started.
+	DeliveryCursors() ([]DeliveryCursor, uint64)
+	// LoadDeliveryCursors sets the cursors persisted by a previous run, reported until the
+	// durables receive their first message.
+	LoadDeliveryCursors(cursors []DeliveryCursor)
+}
+
+var _ DeliveryCursorTracker = (*SubscriptionsSupervisor)(nil)
+
+type cursorKey struct {
+	channel eventingchannels.ChannelReference
+	durable string
+}
+
+type cursorMember struct {
+	channel      eventingchannels.ChannelReference
+	subscription types.UID
+}
+
+// cursor follows the messages of a durable, shared by the members of a work queue. NATSS sends
+// the messages of a durable in order, but for the redeliveries of the ones not acknowledged, which
+// come first when the durable resumes: the durable delivered all the messages before the first
+// one still outstanding.
+type cursor struct {
+	// sequence is the highest sequence acknowledged, once known.
+	sequence uint64
+	known    bool
+	// resuming is set until the first message after the durable resumed, which sets sequence.
+	resuming bool
+	// outstanding are the sequences received and not acknowledged yet, at most the messages in
+	// flight of the members.
+	outstanding map[uint64]struct{}
+	// members is the number of open subscriptions of the durable.
+	members int
+}
+
+// position returns the highest sequence delivered with all the ones before it.
+func (c *cursor) position() uint64 {
+	if len(c.outstanding) == 0 {
+		return c.sequence
+	}
+	first := uint64(0)
+	for seq := range c.outstanding {
+		if first == 0 || seq < first {
+			first = seq
+		}
+	}
+	return first - 1
+}
+
+// cursorTracker holds the cursors of the durables of the dispatcher.
+type cursorTracker struct {
+	mu      sync.Mutex
+	cursors map[cursorKey]*cursor
+	members map[cursorMember]cursorKey
+	// acks counts the acknowledgements tracked.
+	acks uint64
+}
+
+func newCursorTracker() *cursorTracker {
+	return &cursorTracker{
+		cursors: make(map[cursorKey]*cursor),
+		members: make(map[cursorMember]cursorKey),
+	}
+}
+
+// open starts tracking the subscription of channel to durable, returning the cursor its messages
+// are tracked on.
+func (t *cursorTracker) open(channel eventingchannels.ChannelReference, subscription types.UID, durable string) *trackedCursor {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := cursorKey{channel: channel, durable: durable}
+	c, ok := t.cursors[key]
+	if !ok {
+		c = &cursor{}
+		t.cursors[key] = c
+	}
+	if c.members == 0 {
+		c.resuming = true
+		c.outstanding = nil
+	}
+	c.members++
+	t.members[cursorMember{channel: channel, subscription: subscription}] = key
+	return &trackedCursor{tracker: t, cursor: c}
+}
+
+// close stops tracking the subscription of channel. The cursor of its durable is dropped with
+// the last member when the durable is removed, and kept while it is only closed.
+func (t *cursorTracker) close(channel eventingchannels.ChannelReference, subscription types.UID, removed bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	member := cursorMember{channel: channel, subscription: subscription}
+	key, ok := t.members[member]
+	if !ok {
+		return
+	}
+	delete(t.members, member)
+	c := t.cursors[key]
+	c.members--
+	if c.members > 0 {
+		return
+	}
+	if removed {
+		delete(t.cursors, key)
+		return
+	}
+	// The outstanding messages are redelivered when the durable resumes.
+	c.outstanding = nil
+}
+
+func (t *cursorTracker) snapshot() ([]DeliveryCursor, uint64) {
+	if t == nil {
+		return nil, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cursors := make([]DeliveryCursor, 0, len(t.cursors))
+	for key, c := range t.cursors {
+		if !c.known {
+			continue
+		}
+		cursors = append(cursors, DeliveryCursor{Channel: key.channel, Durable: key.durable, Sequence: c.position()})
+	}
+	sort.Slice(cursors, func(i, j int) bool {
+		if cursors[i].Channel != cursors[j].Channel {
+			return cursors[i].Channel.String() < cursors[j].Channel.String()
+		}
+		return cursors[i].Durable < cursors[j].Durable
+	})
+	return cursors, t.acks
+}
+
+func (t *cursorTracker) load(cursors []DeliveryCursor) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, loaded := range cursors {
+		key := cursorKey{channel: loaded.Channel, durable: loaded.Durable}
+		if _, ok := t.cursors[key]; !ok {
+			t.cursors[key] = &cursor{sequence: loaded.Sequence, known: true, resuming: true}
+		}
+	}
+}
+
+// forget drops the cursor of durable once it is removed from NATSS.
+func (t *cursorTracker) forget(channel eventingchannels.ChannelReference, durable string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := cursorKey{channel: channel, durable: durable}
+	if c, ok := t.cursors[key]; ok && c.members == 0 {
+		delete(t.cursors, key)
+	}
+}
+
+// trackedCursor tracks the messages of a subscription, nil when its durable is not tracked.
+type trackedCursor struct {
+	tracker *cursorTracker
+	cursor  *cursor
+}
+
+// received records that the message with sequence was received. The first message after the
+// durable resumed sets its position, even backwards when an acknowledgement was lost: a cursor
+// behind means duplicates, never missed messages.
+func (tc *trackedCursor) received(sequence uint64) {
+	if tc == nil || sequence == 0 {
+		return
+	}
+	tc.tracker.mu.Lock()
+	defer tc.tracker.mu.Unlock()
+	c := tc.cursor
+	if c.resuming {
+		c.resuming = false
+		c.sequence, c.known = sequence-1, true
+	}
+	if c.outstanding == nil {
+		c.outstanding = make(map[uint64]struct{})
+	}
+	c.outstanding[sequence] = struct{}{}
+}
+
+// acked records that the message with sequence was acknowledged.
+func (tc *trackedCursor) acked(sequence uint64) {
+	if tc == nil || sequence == 0 {
+		return
+	}
+	tc.tracker.mu.Lock()
+	defer tc.tracker.mu.Unlock()
+	tc.tracker.acks++
+	c := tc.cursor
+	if _, ok := c.outstanding[sequence]; !ok {
+		// Received before the durable resumed, the message is redelivered.
+		return
+	}
+	delete(c.outstanding, sequence)
+	if sequence > c.sequence {
+		c.sequence = sequence
+	}
+}
+
+// DeliveryCursors implements DeliveryCursorTracker.
+func (s *SubscriptionsSupervisor) DeliveryCursors() ([]DeliveryCursor, uint64) {
+	return s.cursors.snapshot()
+}
+
+// LoadDeliveryCursors implements DeliveryCursorTracker.
+func (s *SubscriptionsSupervisor) LoadDeliveryCursors(cursors []DeliveryCursor) {
+	s.cursors.load(cursors)
+}
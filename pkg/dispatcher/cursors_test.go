@@ -0,0 +1,224 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+var cursorChannel = eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+func cursorSequences(t *cursorTracker) map[string]uint64 {
+	cursors, _ := t.snapshot()
+	sequences := make(map[string]uint64, len(cursors))
+	for _, c := range cursors {
+		sequences[c.Durable] = c.Sequence
+	}
+	return sequences
+}
+
+func TestCursorTrackerGaps(t *testing.T) {
+	tracker := newCursorTracker()
+	tc := tracker.open(cursorChannel, "uid", "uid")
+	if got := cursorSequences(tracker); len(got) != 0 {
+		t.Errorf("cursors before the first message = %v, want none", got)
+	}
+
+	// The durable resumes at 5, everything before was acknowledged.
+	for _, seq := range []uint64{5, 6, 7} {
+		tc.received(seq)
+	}
+	tc.acked(6)
+	tc.acked(7)
+	if diff := cmp.Diff(map[string]uint64{"uid": 4}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors with a gap (-want, +got) = %s", diff)
+	}
+	tc.acked(5)
+	if diff := cmp.Diff(map[string]uint64{"uid": 7}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors once the gap is filled (-want, +got) = %s", diff)
+	}
+	// A redelivery after a lost acknowledgement does not move the cursor.
+	tc.received(6)
+	tc.acked(6)
+	if diff := cmp.Diff(map[string]uint64{"uid": 7}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors after a redelivery (-want, +got) = %s", diff)
+	}
+	if _, acks := tracker.snapshot(); acks != 4 {
+		t.Errorf("acks = %d, want 4", acks)
+	}
+}
+
+func TestCursorTrackerMembers(t *testing.T) {
+	tracker := newCursorTracker()
+	first := tracker.open(cursorChannel, "uid-0", workQueueDurableName)
+	second := tracker.open(cursorChannel, "uid-1", workQueueDurableName)
+	first.received(1)
+	second.received(2)
+	first.acked(1)
+	second.acked(2)
+	if diff := cmp.Diff(map[string]uint64{workQueueDurableName: 2}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors of the work queue (-want, +got) = %s", diff)
+	}
+
+	// The cursor outlives the durable closed by the hibernation.
+	tracker.close(cursorChannel, "uid-0", false)
+	tracker.close(cursorChannel, "uid-1", false)
+	if diff := cmp.Diff(map[string]uint64{workQueueDurableName: 2}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors of the closed work queue (-want, +got) = %s", diff)
+	}
+
+	// The resumed durable starts again from its first message.
+	third := tracker.open(cursorChannel, "uid-0", workQueueDurableName)
+	third.received(3)
+	third.acked(3)
+	if diff := cmp.Diff(map[string]uint64{workQueueDurableName: 3}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors of the resumed work queue (-want, +got) = %s", diff)
+	}
+
+	// The cursor goes away with the durable.
+	tracker.close(cursorChannel, "uid-0", true)
+	if got := cursorSequences(tracker); len(got) != 0 {
+		t.Errorf("cursors of the removed durable = %v, want none", got)
+	}
+}
+
+func TestCursorTrackerUntracked(t *testing.T) {
+	var tc *trackedCursor
+	tc.received(1)
+	tc.acked(1)
+
+	var tracker *cursorTracker
+	if tracker.open(cursorChannel, "uid", "uid") != nil {
+		t.Error("open() on a nil tracker returned a cursor")
+	}
+	tracker.close(cursorChannel, "uid", true)
+	if cursors, _ := tracker.snapshot(); cursors != nil {
+		t.Errorf("snapshot() on a nil tracker = %v, want nil", cursors)
+	}
+}
+
+// fakeDurable tracks the messages a NATSS durable sent and got acknowledged.
+type fakeDurable struct {
+	sent  uint64
+	acked map[uint64]bool
+}
+
+// delivered returns the highest sequence delivered with all the ones before it.
+func (d *fakeDurable) delivered() uint64 {
+	var seq uint64
+	for d.acked[seq+1] {
+		seq++
+	}
+	return seq
+}
+
+// resume returns the messages sent to a resumed subscription, the ones not acknowledged first
+// and then count new ones.
+func (d *fakeDurable) resume(count uint64) []uint64 {
+	var messages []uint64
+	for seq := uint64(1); seq <= d.sent; seq++ {
+		if !d.acked[seq] {
+			messages = append(messages, seq)
+		}
+	}
+	for i := uint64(0); i < count; i++ {
+		d.sent++
+		messages = append(messages, d.sent)
+	}
+	return messages
+}
+
+func TestCursorCrashRecovery(t *testing.T) {
+	durable := &fakeDurable{acked: make(map[uint64]bool)}
+	attempts := make(map[uint64]int)
+	// fails makes every fifth message fail on its first delivery.
+	fails := func(seq uint64) bool {
+		attempts[seq]++
+		return seq%5 == 0 && attempts[seq] == 1
+	}
+
+	var persisted []DeliveryCursor
+	check := func(when string, cursors []DeliveryCursor) {
+		t.Helper()
+		for _, c := range cursors {
+			if delivered := durable.delivered(); c.Sequence > delivered {
+				t.Fatalf("%s: cursor %d ahead of the messages delivered up to %d", when, c.Sequence, delivered)
+			}
+		}
+	}
+
+	// Every round is a run of the dispatcher crashing at its end, the cursors changed since the
+	// last flush being lost.
+	for round := 0; round < 10; round++ {
+		tracker := newCursorTracker()
+		tracker.load(persisted)
+		check("loaded", persisted)
+		tc := tracker.open(cursorChannel, "uid", "uid")
+
+		for i, seq := range durable.resume(23) {
+			tc.received(seq)
+			if !fails(seq) {
+				durable.acked[seq] = true
+				tc.acked(seq)
+			}
+			cursors, _ := tracker.snapshot()
+			check("delivering", cursors)
+			if i%7 == 6 {
+				persisted = cursors
+			}
+		}
+	}
+
+	// A last run without failure catches up with every message.
+	tracker := newCursorTracker()
+	tracker.load(persisted)
+	tc := tracker.open(cursorChannel, "uid", "uid")
+	for _, seq := range durable.resume(0) {
+		tc.received(seq)
+		durable.acked[seq] = true
+		tc.acked(seq)
+	}
+	if diff := cmp.Diff(map[string]uint64{"uid": durable.sent}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors after the recovery (-want, +got) = %s", diff)
+	}
+}
+
+func TestCursorLoadedUntilResumed(t *testing.T) {
+	tracker := newCursorTracker()
+	tracker.load([]DeliveryCursor{{Channel: cursorChannel, Durable: "uid", Sequence: 10}})
+	tc := tracker.open(cursorChannel, "uid", "uid")
+	if diff := cmp.Diff(map[string]uint64{"uid": 10}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors before the first message (-want, +got) = %s", diff)
+	}
+
+	// The acknowledgements lost by the crash are redelivered: the cursor goes back.
+	tc.received(8)
+	if diff := cmp.Diff(map[string]uint64{"uid": 7}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors after a redelivery (-want, +got) = %s", diff)
+	}
+
+	// The cursor of the durables removed while the dispatcher was down is forgotten.
+	tracker.load([]DeliveryCursor{{Channel: cursorChannel, Durable: "gone", Sequence: 3}})
+	tracker.forget(cursorChannel, "gone")
+	tracker.forget(cursorChannel, "uid")
+	if diff := cmp.Diff(map[string]uint64{"uid": 7}, cursorSequences(tracker)); diff != "" {
+		t.Errorf("cursors after forgetting (-want, +got) = %s", diff)
+	}
+}
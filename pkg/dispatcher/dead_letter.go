@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+)
+
+const (
+	// ErrorDestExtension is set on the events sent to the dead letter sink to the destination
+	// which failed to receive them, following the error extensions of Knative eventing.
+	ErrorDestExtension = "knativeerrordest"
+	// ErrorCodeExtension is set on the events sent to the dead letter sink to the status code of
+	// the response to the failed delivery, when there was one.
+	ErrorCodeExtension = "knativeerrorcode"
+	// ErrorDataExtension is set on the events sent to the dead letter sink to the beginning of
+	// the body of the response to the failed delivery, encoded in base64, when it was kept.
+	ErrorDataExtension = "knativeerrordata"
+)
+
+// deadLetterSink returns the URI of the dead letter sink of subscription, nil when it has none.
+func deadLetterSink(subscription subscriptionReference) *url.URL {
+	if subscription.Delivery == nil || subscription.Delivery.DeadLetterSink == nil || subscription.Delivery.DeadLetterSink.URI.IsEmpty() {
+		return nil
+	}
+	return subscription.Delivery.DeadLetterSink.URI.URL()
+}
+
+// withErrorExtensions returns message with the error extensions of its failed delivery to
+// destination, answered with code and data. The messages which are not valid events are returned as is,
+// for the dead letter sink to receive them anyway.
+func withErrorExtensions(ctx context.Context, message binding.Message, destination *url.URL, code int, data []byte) binding.Message {
+	e, err := binding.ToEvent(ctx, message)
+	if err != nil {
+		return message
+	}
+	if destination != nil {
+		e.SetExtension(ErrorDestExtension, destination.String())
+	}
+	if code > 0 {
+		e.SetExtension(ErrorCodeExtension, code)
+	}
+	if len(data) > 0 {
+		e.SetExtension(ErrorDataExtension, base64.StdEncoding.EncodeToString(data))
+	}
+	return binding.ToMessage(e)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// deadLetterRecorder is a dead letter sink recording the events it receives.
+type deadLetterRecorder struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	events []event.Event
+}
+
+func newDeadLetterRecorder() *deadLetterRecorder {
+	r := &deadLetterRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.events = append(r.events, *e)
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return r
+}
+
+func (r *deadLetterRecorder) received() []event.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]event.Event(nil), r.events...)
+}
+
+func TestDeadLetterSink(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer subscriber.Close()
+	dls := newDeadLetterRecorder()
+	defer dls.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	subscriberURI := apis.HTTP(subscriber.Listener.Addr().String())
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: subscriberURI,
+		Delivery: &eventingduckv1.DeliverySpec{
+			DeadLetterSink: &duckv1.Destination{URI: apis.HTTP(dls.Listener.Addr().String())},
+		},
+	}}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	conn.publish(newTestEventMsg(t, "dead"))
+
+	got := dls.received()
+	if len(got) != 1 {
+		t.Fatalf("the dead letter sink received %d events, want 1", len(got))
+	}
+	if got[0].ID() != "dead" {
+		t.Errorf("the dead letter sink received event %s, want dead", got[0].ID())
+	}
+	if dest := got[0].Extensions()[ErrorDestExtension]; fmt.Sprint(dest) != subscriberURI.String() {
+		t.Errorf("%s = %v, want %s", ErrorDestExtension, dest, subscriberURI)
+	}
+	if code := got[0].Extensions()[ErrorCodeExtension]; fmt.Sprint(code) != "500" {
+		t.Errorf("%s = %v, want 500", ErrorCodeExtension, code)
+	}
+}
+
+func TestDeadLetterSinkUnreachable(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer subscriber.Close()
+	dls := newDeadLetterRecorder()
+	deadLetter := mustParseURL(t, dls.URL)
+	dls.Close()
+
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	// Not acknowledging the message makes NATSS redeliver it, once the sink is back.
+	result := s.deliver(context.Background(), ref, newTestMessage(), mustParseURL(t, subscriber.URL), nil, deadLetter, nil)
+	if result.acked() {
+		t.Errorf("deliver() = %+v, want the message left unacknowledged", result)
+	}
+}
+
+func TestDeadLetterSinkChanged(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer subscriber.Close()
+	before, after := newDeadLetterRecorder(), newDeadLetterRecorder()
+	defer before.Close()
+	defer after.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	withSink := func(dls *deadLetterRecorder) eventingduckv1.SubscriberSpec {
+		return eventingduckv1.SubscriberSpec{
+			UID:           "uid-0",
+			SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+			Delivery: &eventingduckv1.DeliverySpec{
+				DeadLetterSink: &duckv1.Destination{URI: apis.HTTP(dls.Listener.Addr().String())},
+			},
+		}
+	}
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{withSink(before)}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	conn.publish(newTestEventMsg(t, "1"))
+
+	// The running subscription sends the events it fails to deliver to the new sink.
+	channel = channel.DeepCopy()
+	channel.Spec.Subscribers[0] = withSink(after)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	conn.publish(newTestEventMsg(t, "2"))
+
+	if len(conn.subs) != 1 || conn.subs[0].durable != "uid-0" {
+		t.Errorf("got %d subscriptions, want the one of the durable uid-0", len(conn.subs))
+	}
+	for name, tc := range map[string]struct {
+		dls  *deadLetterRecorder
+		want string
+	}{"before": {dls: before, want: "1"}, "after": {dls: after, want: "2"}} {
+		got := tc.dls.received()
+		if len(got) != 1 || got[0].ID() != tc.want {
+			t.Errorf("the dead letter sink %s received %d events, want the event %s", name, len(got), tc.want)
+		}
+	}
+}
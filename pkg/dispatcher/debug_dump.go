@@ -0,0 +1,274 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// DebugDumper is implemented by the subsystems contributing their state to the support bundles.
+type DebugDumper interface {
+	// DebugDump returns the state of the subsystem, marshalled to JSON in its section of the
+	// bundle. It must leave the secrets out, the bundle only redacting the well-known ones.
+	DebugDump() interface{}
+}
+
+// DebugDumpFunc adapts a function to DebugDumper.
+type DebugDumpFunc func() interface{}
+
+// DebugDump implements DebugDumper.
+func (f DebugDumpFunc) DebugDump() interface{} {
+	return f()
+}
+
+// DebugDumperSource is implemented by the dispatchers whose subsystems contribute to the support
+// bundles.
+type DebugDumperSource interface {
+	// DebugDumpers returns the dumpers of the subsystems, by name of their section.
+	DebugDumpers() map[string]DebugDumper
+}
+
+var _ DebugDumperSource = (*SubscriptionsSupervisor)(nil)
+
+// DebugDumpers implements DebugDumperSource.
+func (s *SubscriptionsSupervisor) DebugDumpers() map[string]DebugDumper {
+	return map[string]DebugDumper{
+		"dispatcher":      DebugDumpFunc(s.dumpSettings),
+		"hostMap":         DebugDumpFunc(s.dumpHostMap),
+		"channels":        DebugDumpFunc(s.dumpChannels),
+		"circuitBreakers": DebugDumpFunc(s.dumpCircuitBreakers),
+		"connections":     DebugDumpFunc(s.dumpConnections),
+		"buffers":         DebugDumpFunc(s.dumpBuffers),
+	}
+}
+
+// settingsDump is the configuration the dispatcher was made with.
+type settingsDump struct {
+	MaxInflight            int                   `json:"maxInflight"`
+	AckWait                string                `json:"ackWait"`
+	StartAt                v1beta1.StartPosition `json:"startAt,omitempty"`
+	MaxRedirects           int                   `json:"maxRedirects"`
+	DeliveryConcurrency    int                   `json:"deliveryConcurrency"`
+	PublishMode            PublishMode           `json:"publishMode,omitempty"`
+	HibernationThreshold   string                `json:"hibernationThreshold"`
+	UnhealthyPauseAfter    string                `json:"unhealthyPauseAfter"`
+	UnhealthyProbeInterval string                `json:"unhealthyProbeInterval"`
+	DrainTimeout           string                `json:"drainTimeout"`
+	Partitioned            bool                  `json:"partitioned"`
+	Draining               bool                  `json:"draining"`
+}
+
+func (s *SubscriptionsSupervisor) dumpSettings() interface{} {
+	s.configMux.RLock()
+	defer s.configMux.RUnlock()
+	return settingsDump{
+		MaxInflight:            s.maxInflight,
+		AckWait:                s.ackWait.String(),
+		StartAt:                s.startAt,
+		MaxRedirects:           s.maxRedirects,
+		DeliveryConcurrency:    s.deliveryConcurrency,
+		PublishMode:            s.publishMode,
+		HibernationThreshold:   s.hibernationThreshold.String(),
+		UnhealthyPauseAfter:    s.unhealthyPauseAfter.String(),
+		UnhealthyProbeInterval: s.unhealthyProbeInterval.String(),
+		DrainTimeout:           s.drainTimeout.String(),
+		Partitioned:            s.partitioned,
+		Draining:               s.isDraining(),
+	}
+}
+
+func (s *SubscriptionsSupervisor) dumpHostMap() interface{} {
+	hosts := make(map[string]string)
+	for host, channel := range s.HostToChannelMap() {
+		hosts[host] = channel.String()
+	}
+	return hosts
+}
+
+// channelDump is the state of the subscriptions of a channel.
+type channelDump struct {
+	Subscriptions   []subscriptionDump `json:"subscriptions"`
+	HibernatedSince *time.Time         `json:"hibernatedSince,omitempty"`
+	Deferred        bool               `json:"deferred,omitempty"`
+	NotProvisioned  string             `json:"notProvisioned,omitempty"`
+	Connection      connectionDump     `json:"connection"`
+}
+
+// subscriptionDump is the state of a subscription of a channel.
+type subscriptionDump struct {
+	UID         types.UID `json:"uid"`
+	Ephemeral   bool      `json:"ephemeral,omitempty"`
+	MaxInflight int       `json:"maxInflight,omitempty"`
+	AckWait     string    `json:"ackWait,omitempty"`
+	Ordered     bool      `json:"ordered,omitempty"`
+	Consumers   int       `json:"consumers"`
+	// Durables are the names of the durables of the subscription, those of both schemes while
+	// it is migrated.
+	Durables []string `json:"durables,omitempty"`
+}
+
+func (s *SubscriptionsSupervisor) dumpChannels() interface{} {
+	channels := make(map[eventingchannels.ChannelReference]*channelDump)
+	s.subscriptionsMux.Lock()
+	for channel, subscriptions := range s.subscriptions {
+		dump := &channelDump{}
+		for uid := range subscriptions {
+			sub := subscriptionDump{UID: uid, Ephemeral: s.subscribedEphemeral[channel][uid]}
+			if options, ok := s.subscribedOptions[channel][uid]; ok {
+				sub.MaxInflight, sub.AckWait, sub.Ordered = options.MaxInflight, options.AckWait.String(), options.Ordered
+			}
+			if named, ok := s.namedDurables[uid]; ok {
+				sub.Durables = named.durables()
+			}
+			dump.Subscriptions = append(dump.Subscriptions, sub)
+		}
+		sort.Slice(dump.Subscriptions, func(i, j int) bool { return dump.Subscriptions[i].UID < dump.Subscriptions[j].UID })
+		channels[channel] = dump
+	}
+	s.subscriptionsMux.Unlock()
+	// The hibernated and deferred channels have no subscription made.
+	for _, m := range []*sync.Map{&s.hibernated, &s.deferred} {
+		m.Range(func(key, _ interface{}) bool {
+			if channel := key.(eventingchannels.ChannelReference); channels[channel] == nil {
+				channels[channel] = &channelDump{}
+			}
+			return true
+		})
+	}
+
+	dumps := make(map[string]*channelDump, len(channels))
+	for channel, dump := range channels {
+		for i := range dump.Subscriptions {
+			dump.Subscriptions[i].Consumers = s.Consumers(channel, dump.Subscriptions[i].UID)
+		}
+		if since, ok := s.HibernatedSince(channel); ok {
+			dump.HibernatedSince = &since
+		}
+		_, dump.Deferred = s.deferredChannel(channel)
+		dump.NotProvisioned, _ = s.NotProvisioned(channel)
+		dump.Connection = newConnectionDump(stanutil.ConnKey{}, s.ConnectionStatus(channel))
+		dumps[channel.String()] = dump
+	}
+	return dumps
+}
+
+// circuitBreakerDump is the health of the subscriber of a subscription, its subscription being
+// paused once it failed for long enough.
+type circuitBreakerDump struct {
+	Channel      string     `json:"channel,omitempty"`
+	FailingSince *time.Time `json:"failingSince,omitempty"`
+	PausedSince  *time.Time `json:"pausedSince,omitempty"`
+}
+
+func (s *SubscriptionsSupervisor) dumpCircuitBreakers() interface{} {
+	breakers := make(map[types.UID]*circuitBreakerDump)
+	s.health.Range(func(key, value interface{}) bool {
+		h := value.(*subscriberHealth)
+		h.mu.Lock()
+		failingSince := h.failingSince
+		h.mu.Unlock()
+		if !failingSince.IsZero() {
+			breakers[key.(types.UID)] = &circuitBreakerDump{FailingSince: &failingSince}
+		}
+		return true
+	})
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	for uid, paused := range s.paused {
+		breaker, ok := breakers[uid]
+		if !ok {
+			breaker = &circuitBreakerDump{}
+			breakers[uid] = breaker
+		}
+		since := paused.since
+		breaker.Channel, breaker.PausedSince = paused.channel.String(), &since
+	}
+	return breakers
+}
+
+// connectionDump is the state of a connection to NATSS.
+type connectionDump struct {
+	URL       string     `json:"url"`
+	ClusterID string     `json:"clusterID,omitempty"`
+	ClientID  string     `json:"clientID,omitempty"`
+	Ready     bool       `json:"ready"`
+	LostSince *time.Time `json:"lostSince,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func newConnectionDump(key stanutil.ConnKey, status ConnectionStatus) connectionDump {
+	dump := connectionDump{URL: status.URL, ClusterID: key.ClusterID, ClientID: key.ClientID, Ready: status.Ready}
+	if !status.LostSince.IsZero() {
+		dump.LostSince = &status.LostSince
+	}
+	if status.Err != nil {
+		dump.Error = status.Err.Error()
+	}
+	return dump
+}
+
+func (s *SubscriptionsSupervisor) dumpConnections() interface{} {
+	key := s.dispatcherKey()
+	s.natssConnMux.Lock()
+	status := ConnectionStatus{URL: key.URL, Ready: s.natssConn != nil && s.connectionLostAt.IsZero(), LostSince: s.connectionLostAt}
+	if !status.Ready {
+		status.Err = s.natssConnErr
+	}
+	s.natssConnMux.Unlock()
+	connections := []connectionDump{newConnectionDump(key, status)}
+
+	s.clusterConnsMux.Lock()
+	defer s.clusterConnsMux.Unlock()
+	keys := make(map[stanutil.ConnKey]bool)
+	for key := range s.clusterConns {
+		keys[key] = true
+	}
+	for key := range s.clusterConnErrs {
+		keys[key] = true
+	}
+	for key := range keys {
+		status := ConnectionStatus{URL: key.URL, Err: s.clusterConnErrs[key]}
+		status.Ready = status.Err == nil && s.conns.Healthy(key)
+		connections = append(connections, newConnectionDump(key, status))
+	}
+	sort.Slice(connections[1:], func(i, j int) bool { return connections[i+1].URL < connections[j+1].URL })
+	return connections
+}
+
+// buffersDump is the state of the buffers of the events being dispatched.
+type buffersDump struct {
+	BufferedBytes int64          `json:"bufferedBytes"`
+	BusyWorkers   map[string]int `json:"busyWorkers"`
+}
+
+func (s *SubscriptionsSupervisor) dumpBuffers() interface{} {
+	dump := buffersDump{BufferedBytes: s.buffer.bufferedBytes(), BusyWorkers: make(map[string]int)}
+	s.workerPools.Range(func(key, value interface{}) bool {
+		channel := key.(eventingchannels.ChannelReference)
+		dump.BusyWorkers[channel.String()] = len(value.(workerPool))
+		return true
+	})
+	return dump
+}
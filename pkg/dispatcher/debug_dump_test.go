@@ -0,0 +1,72 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestDebugDumpers(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.setRoutes(map[string]eventingchannels.ChannelReference{"channel.ns.svc.cluster.local": ref})
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	sections := make(map[string]json.RawMessage)
+	for name, dumper := range s.DebugDumpers() {
+		data, err := json.Marshal(dumper.DebugDump())
+		if err != nil {
+			t.Fatalf("the %s section does not marshal: %v", name, err)
+		}
+		sections[name] = data
+	}
+	for _, name := range []string{"dispatcher", "hostMap", "channels", "circuitBreakers", "connections", "buffers"} {
+		if _, ok := sections[name]; !ok {
+			t.Errorf("the %s section is missing", name)
+		}
+	}
+
+	var hostMap map[string]string
+	if err := json.Unmarshal(sections["hostMap"], &hostMap); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if got := hostMap["channel.ns.svc.cluster.local"]; got != "ns/channel" {
+		t.Errorf("host map = %v, want the channel of its host", hostMap)
+	}
+	var channels map[string]channelDump
+	if err := json.Unmarshal(sections["channels"], &channels); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if subs := channels["ns/channel"].Subscriptions; len(subs) != 1 || subs[0].UID != "uid-0" {
+		t.Errorf("subscriptions = %+v, want the one of the subscriber", subs)
+	}
+	var settings settingsDump
+	if err := json.Unmarshal(sections["dispatcher"], &settings); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if settings.DeliveryConcurrency != DefaultDeliveryConcurrency {
+		t.Errorf("delivery concurrency = %d, want %d", settings.DeliveryConcurrency, DefaultDeliveryConcurrency)
+	}
+}
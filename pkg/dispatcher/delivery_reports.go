@@ -0,0 +1,305 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/metrics"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// DeliveryReportSchemaVersion is the version of the schema of the DeliveryReportBatch POSTed to
+// the delivery reports sink. Fields may be added within a version; renaming or removing a field,
+// or changing its meaning, requires a new version.
+const DeliveryReportSchemaVersion = "v1"
+
+// The statuses of the deliveries reported.
+const (
+	// DeliveryStatusDelivered is the status of the events accepted by the subscriber.
+	DeliveryStatusDelivered = "delivered"
+	// DeliveryStatusDeadLettered is the status of the events sent to the dead letter sink.
+	DeliveryStatusDeadLettered = "deadLettered"
+	// DeliveryStatusDropped is the status of the events dropped by the response code policy.
+	DeliveryStatusDropped = "dropped"
+	// DeliveryStatusFailed is the status of the failed deliveries which NATSS redelivers, the
+	// only status which is not final.
+	DeliveryStatusFailed = "failed"
+)
+
+// DeliveryReport is the report of an attempt to deliver an event to a subscriber.
+type DeliveryReport struct {
+	// EventID is the ID of the event.
+	EventID string `json:"eventId"`
+	// Channel is the namespace and name of the channel, as namespace/name.
+	Channel string `json:"channel"`
+	// Subscription is the UID of the subscription.
+	Subscription string `json:"subscription"`
+	// Subscriber is the URI of the subscriber, empty for the subscriptions with a reply only.
+	Subscriber string `json:"subscriber,omitempty"`
+	// Attempt is the number of the attempt, starting at 1.
+	Attempt int `json:"attempt"`
+	// Status is one of the DeliveryStatus constants.
+	Status string `json:"status"`
+	// ResponseCode is the status code of the response of the subscriber to a failed delivery,
+	// omitted when there was no response.
+	ResponseCode int `json:"responseCode,omitempty"`
+	// LatencyMillis is the duration of the attempt, dead letter included, in milliseconds.
+	LatencyMillis float64 `json:"latencyMillis"`
+	// Time is when the attempt ended.
+	Time time.Time `json:"time"`
+}
+
+// DeliveryReportBatch is the body of the requests POSTed to the delivery reports sink.
+type DeliveryReportBatch struct {
+	// SchemaVersion is DeliveryReportSchemaVersion.
+	SchemaVersion string `json:"schemaVersion"`
+	// Reports are in the order the attempts ended.
+	Reports []DeliveryReport `json:"reports"`
+}
+
+// DeliveryReports configures the reports of the deliveries POSTed by the dispatcher.
+type DeliveryReports struct {
+	// Sink receives the batches of reports.
+	Sink *apis.URL
+	// BatchSize is the maximum number of reports POSTed at once.
+	BatchSize int
+	// FlushInterval is how long a report waits for its batch to fill before it is sent anyway.
+	FlushInterval time.Duration
+	// QueueSize is the number of reports awaiting to be sent, the oldest being dropped when it
+	// is reached.
+	QueueSize int
+}
+
+// deliveryReportTimeout is the timeout of the requests to the delivery reports sink.
+var deliveryReportTimeout = 10 * time.Second
+
+var (
+	// deliveryReportCountM records the delivery reports sent, or dropped on overflow or failure.
+	deliveryReportCountM = stats.Int64(
+		"delivery_report_count",
+		"Number of delivery reports sent to or dropped for the delivery reports sink",
+		stats.UnitDimensionless,
+	)
+
+	// reportResultKey tags the reports with reportResultSent, reportResultOverflow or
+	// reportResultFailed.
+	reportResultKey = tag.MustNewKey("result")
+)
+
+const (
+	reportResultSent     = "sent"
+	reportResultOverflow = "overflow"
+	reportResultFailed   = "failed"
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: deliveryReportCountM.Description(),
+			Measure:     deliveryReportCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{reportResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// deliveryReporter queues the delivery reports and POSTs them in batches, never delaying nor
+// failing the deliveries.
+type deliveryReporter struct {
+	config DeliveryReports
+	client *http.Client
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	queue []DeliveryReport
+	// full is signaled when a batch is ready.
+	full chan struct{}
+}
+
+func newDeliveryReporter(config DeliveryReports, client *http.Client, logger *zap.Logger) *deliveryReporter {
+	return &deliveryReporter{
+		config: config,
+		client: client,
+		logger: logger,
+		full:   make(chan struct{}, 1),
+	}
+}
+
+// add queues report, dropping the oldest report when the queue is full.
+func (r *deliveryReporter) add(report DeliveryReport) {
+	r.mu.Lock()
+	dropped := 0
+	if len(r.queue) >= r.config.QueueSize {
+		dropped = len(r.queue) - r.config.QueueSize + 1
+		r.queue = r.queue[dropped:]
+	}
+	r.queue = append(r.queue, report)
+	ready := len(r.queue) >= r.config.BatchSize
+	r.mu.Unlock()
+
+	if dropped > 0 {
+		recordDeliveryReports(reportResultOverflow, dropped)
+	}
+	if ready {
+		select {
+		case r.full <- struct{}{}:
+		default:
+			// A flush is already pending.
+		}
+	}
+}
+
+// next removes and returns the next batch of reports, nil when none is queued.
+func (r *deliveryReporter) next() []DeliveryReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.queue)
+	if n == 0 {
+		return nil
+	}
+	if n > r.config.BatchSize {
+		n = r.config.BatchSize
+	}
+	batch := make([]DeliveryReport, n)
+	copy(batch, r.queue)
+	r.queue = r.queue[n:]
+	return batch
+}
+
+// run sends the reports as their batches fill or every flush interval until ctx is done, then
+// sends the reports still queued.
+func (r *deliveryReporter) run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.full:
+			r.flush(ctx, false)
+		case <-ticker.C:
+			r.flush(ctx, true)
+		case <-ctx.Done():
+			// The last reports are sent with a context of their own, for one timeout at most.
+			ctx, cancel := context.WithTimeout(context.Background(), deliveryReportTimeout)
+			r.flush(ctx, true)
+			cancel()
+			return nil
+		}
+	}
+}
+
+// flush sends the full batches, and the last partial one when partial is set.
+func (r *deliveryReporter) flush(ctx context.Context, partial bool) {
+	for {
+		r.mu.Lock()
+		n := len(r.queue)
+		r.mu.Unlock()
+		if n == 0 || (!partial && n < r.config.BatchSize) || ctx.Err() != nil {
+			return
+		}
+		batch := r.next()
+		if err := r.send(ctx, batch); err != nil {
+			r.logger.Warn("Failed to send the delivery reports, dropping them", zap.Int("reports", len(batch)), zap.Error(err))
+			recordDeliveryReports(reportResultFailed, len(batch))
+			continue
+		}
+		recordDeliveryReports(reportResultSent, len(batch))
+	}
+}
+
+func (r *deliveryReporter) send(ctx context.Context, reports []DeliveryReport) error {
+	body, err := json.Marshal(DeliveryReportBatch{SchemaVersion: DeliveryReportSchemaVersion, Reports: reports})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.Sink.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// reportDelivery queues the report of the delivery of msg when the delivery reports are enabled.
+func (s *SubscriptionsSupervisor) reportDelivery(channel eventingchannels.ChannelReference, subscription subscriptionReference, msg *stan.Msg, result deliveryResult, start time.Time, latency time.Duration) {
+	if s.deliveryReporter == nil {
+		return
+	}
+	code := result.code
+	if code == eventingchannels.NoResponse {
+		code = 0
+	}
+	s.deliveryReporter.add(DeliveryReport{
+		EventID:       structuredEventID(msg.Data),
+		Channel:       channel.String(),
+		Subscription:  string(subscription.UID),
+		Subscriber:    subscription.SubscriberURI.String(),
+		Attempt:       int(msg.RedeliveryCount) + 1,
+		Status:        result.status,
+		ResponseCode:  code,
+		LatencyMillis: float64(latency) / float64(time.Millisecond),
+		Time:          start.Add(latency),
+	})
+}
+
+// structuredEventID returns the ID of the event of a NATSS message, which is always in the
+// structured JSON format, empty when it cannot be read.
+func structuredEventID(data []byte) string {
+	var e struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return ""
+	}
+	return e.ID
+}
+
+func recordDeliveryReports(result string, n int) {
+	ctx, err := tag.New(context.Background(), tag.Insert(reportResultKey, result))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, deliveryReportCountM.M(int64(n)))
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/apis"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// reportSink records the batches of delivery reports it receives, failing the requests while
+// status is not 200.
+type reportSink struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	status  int
+	batches []DeliveryReportBatch
+}
+
+func newReportSink(t *testing.T) *reportSink {
+	r := &reportSink{status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.status != http.StatusOK {
+			w.WriteHeader(r.status)
+			return
+		}
+		var batch DeliveryReportBatch
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.batches = append(r.batches, batch)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *reportSink) received() []DeliveryReportBatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]DeliveryReportBatch(nil), r.batches...)
+}
+
+func (r *reportSink) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *reportSink) config(batchSize, queueSize int, flushInterval time.Duration) DeliveryReports {
+	sink, _ := apis.ParseURL(r.URL)
+	return DeliveryReports{Sink: sink, BatchSize: batchSize, FlushInterval: flushInterval, QueueSize: queueSize}
+}
+
+func numberedReports(n int) []DeliveryReport {
+	reports := make([]DeliveryReport, n)
+	for i := range reports {
+		reports[i] = DeliveryReport{EventID: strconv.Itoa(i), Status: DeliveryStatusDelivered}
+	}
+	return reports
+}
+
+func eventIDs(batches []DeliveryReportBatch) [][]string {
+	var ids [][]string
+	for _, b := range batches {
+		var batch []string
+		for _, r := range b.Reports {
+			batch = append(batch, r.EventID)
+		}
+		ids = append(ids, batch)
+	}
+	return ids
+}
+
+func TestDeliveryReportsBatching(t *testing.T) {
+	sink := newReportSink(t)
+	// The flush interval is long enough for the full batches to be sent first.
+	r := newDeliveryReporter(sink.config(3, 100, time.Hour), http.DefaultClient, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.run(ctx)
+	}()
+
+	for _, report := range numberedReports(7) {
+		r.add(report)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(sink.received()); got != 2 {
+		t.Fatalf("%d batches sent, want the 2 full batches to be sent without waiting", got)
+	}
+
+	// The partial batch is sent on stop.
+	cancel()
+	<-done
+	got := sink.received()
+	want := [][]string{{"0", "1", "2"}, {"3", "4", "5"}, {"6"}}
+	if ids := eventIDs(got); len(ids) != 3 || ids[0][0] != want[0][0] || ids[1][2] != want[1][2] || len(ids[2]) != 1 || ids[2][0] != "6" {
+		t.Errorf("sent %v, want %v", ids, want)
+	}
+	for _, b := range got {
+		if b.SchemaVersion != DeliveryReportSchemaVersion {
+			t.Errorf("SchemaVersion = %q, want %q", b.SchemaVersion, DeliveryReportSchemaVersion)
+		}
+	}
+}
+
+func TestDeliveryReportsFlushInterval(t *testing.T) {
+	sink := newReportSink(t)
+	r := newDeliveryReporter(sink.config(100, 1000, 20*time.Millisecond), http.DefaultClient, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.run(ctx)
+
+	r.add(DeliveryReport{EventID: "alone"})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ids := eventIDs(sink.received()); len(ids) != 1 || len(ids[0]) != 1 || ids[0][0] != "alone" {
+		t.Errorf("sent %v, want the partial batch to be sent after the flush interval", ids)
+	}
+}
+
+func TestDeliveryReportsOverflow(t *testing.T) {
+	sink := newReportSink(t)
+	r := newDeliveryReporter(sink.config(2, 5, time.Hour), http.DefaultClient, zap.NewNop())
+
+	// Nothing sends the reports, the oldest ones are dropped.
+	for _, report := range numberedReports(8) {
+		r.add(report)
+	}
+	r.flush(context.Background(), true)
+	want := [][]string{{"3", "4"}, {"5", "6"}, {"7"}}
+	if ids := eventIDs(sink.received()); len(ids) != 3 || ids[0][0] != "3" || ids[1][1] != "6" || ids[2][0] != "7" {
+		t.Errorf("sent %v, want %v", ids, want)
+	}
+}
+
+func TestDeliveryReportsSinkFailure(t *testing.T) {
+	sink := newReportSink(t)
+	sink.setStatus(http.StatusServiceUnavailable)
+	r := newDeliveryReporter(sink.config(2, 10, time.Hour), http.DefaultClient, zap.NewNop())
+
+	for _, report := range numberedReports(3) {
+		r.add(report)
+	}
+	// The failed batches are dropped rather than retried.
+	r.flush(context.Background(), true)
+	sink.setStatus(http.StatusOK)
+	r.add(DeliveryReport{EventID: "next"})
+	r.flush(context.Background(), true)
+	if ids := eventIDs(sink.received()); len(ids) != 1 || len(ids[0]) != 1 || ids[0][0] != "next" {
+		t.Errorf("sent %v, want only the report queued after the failure", ids)
+	}
+}
+
+func TestDeliveryReportsOfDeliveries(t *testing.T) {
+	sink := newReportSink(t)
+	sink.setStatus(http.StatusInternalServerError)
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	s.deliveryReporter = newDeliveryReporter(sink.config(10, 10, time.Hour), http.DefaultClient, zap.NewNop())
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	// Failing to send the reports does not affect the deliveries.
+	publishTestEvent(t, s, ref, "reported")
+	s.deliveryReporter.flush(context.Background(), true)
+	if got := subscriber.received(); len(got) != 1 || got[0] != "reported" {
+		t.Fatalf("received %v, want the event to be delivered", got)
+	}
+
+	sink.setStatus(http.StatusOK)
+	publishTestEvent(t, s, ref, "reported-again")
+	s.deliveryReporter.flush(context.Background(), true)
+	batches := sink.received()
+	if len(batches) != 1 || len(batches[0].Reports) != 1 {
+		t.Fatalf("sent %v, want the report of the second delivery", eventIDs(batches))
+	}
+	got := batches[0].Reports[0]
+	sub := channel.Spec.Subscribers[0]
+	if got.EventID != "reported-again" || got.Channel != ref.String() || got.Subscription != string(sub.UID) ||
+		got.Subscriber != sub.SubscriberURI.String() || got.Attempt != 1 || got.Status != DeliveryStatusDelivered || got.ResponseCode != 0 {
+		t.Errorf("report = %+v, want the successful first attempt to deliver reported-again", got)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// DeliveryTimeoutSetter is implemented by the dispatchers able to bound the requests of the
+// deliveries of some of the subscriptions of a channel, so that a slow subscriber does not hold
+// a delivery, and the ack of its event, indefinitely. Each request of a delivery, to the
+// subscriber and to the reply, gets the whole timeout, and its expiry fails the request, retried
+// as any other failure following the delivery spec of the subscription.
+type DeliveryTimeoutSetter interface {
+	// SetDeliveryTimeouts sets the timeouts of the requests of the deliveries of the subscriptions
+	// of channel, by UID, the other subscriptions using the timeout of the dispatcher. It applies
+	// to the deliveries starting from then on.
+	SetDeliveryTimeouts(channel eventingchannels.ChannelReference, timeouts map[types.UID]time.Duration)
+}
+
+var _ DeliveryTimeoutSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetDeliveryTimeouts implements DeliveryTimeoutSetter.
+func (s *SubscriptionsSupervisor) SetDeliveryTimeouts(channel eventingchannels.ChannelReference, timeouts map[types.UID]time.Duration) {
+	if len(timeouts) == 0 {
+		s.deliveryTimeouts.Delete(channel)
+		return
+	}
+	s.deliveryTimeouts.Store(channel, timeouts)
+}
+
+// deliveryTimeoutOf returns the timeout of the requests of the deliveries of subscription of
+// channel, zero when they have none.
+func (s *SubscriptionsSupervisor) deliveryTimeoutOf(channel eventingchannels.ChannelReference, subscription types.UID) time.Duration {
+	if timeouts, ok := s.deliveryTimeouts.Load(channel); ok {
+		if timeout, ok := timeouts.(map[types.UID]time.Duration)[subscription]; ok {
+			return timeout
+		}
+	}
+	return s.deliveryTimeout
+}
+
+// deliveryTimeoutKey is the context key of the timeout of the requests of a delivery.
+type deliveryTimeoutKey struct{}
+
+// withDeliveryTimeout returns a context whose requests time out after timeout, zero or less
+// leaving them to the transport.
+func withDeliveryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, deliveryTimeoutKey{}, timeout)
+}
+
+// deliveryTimeoutTransport bounds each request sent with a context carrying a delivery timeout,
+// the reading of its response included. The deadline is set on the request rather than on the
+// delivery for each retry to get the whole timeout, the retries going on once it expires.
+type deliveryTimeoutTransport struct {
+	base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*deliveryTimeoutTransport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *deliveryTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout, _ := req.Context().Value(deliveryTimeoutKey{}).(time.Duration)
+	if timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline holds until the body of the response is closed.
+	resp.Body = &cancelingBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelingBody releases the context of its request once closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// newLateSubscriber returns a subscriber answering its first slow requests after a second, or
+// once they are cancelled, and the next ones at once, counting them all in attempts.
+func newLateSubscriber(slow int32, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(attempts, 1) <= slow {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+}
+
+func TestDeliveryTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	testCases := map[string]struct {
+		// slow is how many requests the subscriber answers too late.
+		slow            int32
+		timeout         time.Duration
+		dispatcherLimit time.Duration
+
+		wantAttempts     int32
+		wantDeadLettered bool
+	}{
+		"retried": {
+			slow:         1,
+			timeout:      timeout,
+			wantAttempts: 2,
+		},
+		"dead lettered": {
+			slow:             100,
+			timeout:          timeout,
+			wantAttempts:     2,
+			wantDeadLettered: true,
+		},
+		"timeout of the dispatcher": {
+			slow:             100,
+			dispatcherLimit:  timeout,
+			wantAttempts:     2,
+			wantDeadLettered: true,
+		},
+		"subscription timeout first": {
+			slow:             100,
+			timeout:          timeout,
+			dispatcherLimit:  time.Hour,
+			wantAttempts:     2,
+			wantDeadLettered: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var attempts int32
+			subscriber := newLateSubscriber(tc.slow, &attempts)
+			defer subscriber.Close()
+			dls := newDeadLetterRecorder()
+			defer dls.Close()
+
+			s, conn := newTestSupervisor(t)
+			s.deliveryTimeout = tc.dispatcherLimit
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			uid := types.UID("uid-0")
+			if tc.timeout != 0 {
+				s.SetDeliveryTimeouts(ref, map[types.UID]time.Duration{uid: tc.timeout})
+			}
+			delivery := newRetryDelivery(1, eventingduckv1.BackoffPolicyLinear, "PT0.001S")
+			delivery.DeadLetterSink = &duckv1.Destination{URI: apis.HTTP(dls.Listener.Addr().String())}
+			channel := newTestChannel(ref)
+			channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+				UID:           uid,
+				SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+				Delivery:      delivery,
+			}}
+			if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+
+			start := time.Now()
+			conn.publish(newTestEventMsg(t, "slow"))
+
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("the delivery took %v, want the slow requests cancelled after %v", elapsed, timeout)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tc.wantAttempts {
+				t.Errorf("the subscriber was sent the event %d times, want %d", got, tc.wantAttempts)
+			}
+			if got := len(dls.received()); (got == 1) != tc.wantDeadLettered {
+				t.Errorf("the dead letter sink received %d events, want dead lettered %t", got, tc.wantDeadLettered)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/metrics/metricskey"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+// noResponseClass is the response code class of the dispatches without response.
+const noResponseClass = "none"
+
+var (
+	// eventsReceivedM records the events the subscriptions receive from NATSS.
+	eventsReceivedM = stats.Int64(
+		"natss_events_received_total",
+		"Number of events received from NATSS by the subscriptions of the dispatcher",
+		stats.UnitDimensionless,
+	)
+
+	// eventsDispatchedM records the dispatches of the events to the subscribers, whatever
+	// their result.
+	eventsDispatchedM = stats.Int64(
+		"natss_events_dispatched_total",
+		"Number of events dispatched to the subscribers",
+		stats.UnitDimensionless,
+	)
+
+	// dispatchFailuresM records the dispatches which failed, retries included.
+	dispatchFailuresM = stats.Int64(
+		"natss_dispatch_failures_total",
+		"Number of events whose dispatch to the subscriber failed, by response code class",
+		stats.UnitDimensionless,
+	)
+
+	// dispatchLatencyM records the latency of the dispatches, retries included.
+	dispatchLatencyM = stats.Float64(
+		"natss_dispatch_latency_ms",
+		"Latency of the dispatch of the events to the subscribers",
+		stats.UnitMilliseconds,
+	)
+
+	// dispatchRetriesM records the retries of the dispatches, by reason.
+	dispatchRetriesM = stats.Int64(
+		"natss_dispatch_retries_total",
+		"Number of retries of the dispatch of the events to the subscribers, by reason",
+		stats.UnitDimensionless,
+	)
+
+	// activeSubscriptionsM records the number of NATSS subscriptions the dispatcher holds.
+	activeSubscriptionsM = stats.Int64(
+		"natss_active_subscriptions",
+		"Number of NATSS subscriptions held by the dispatcher",
+		stats.UnitDimensionless,
+	)
+
+	// responseCodeClassKey is the class of the response code of a failed dispatch, such as 5xx,
+	// or noResponseClass.
+	responseCodeClassKey = tag.MustNewKey(metricskey.LabelResponseCodeClass)
+
+	// retryReasonKey is the reason of a retry, retryReasonColdStart or retryReasonFailure.
+	retryReasonKey = tag.MustNewKey("retry_reason")
+
+	subscriptionTagKeys = []tag.Key{cardinality.NamespaceKey, cardinality.ChannelKey, cardinality.SubscriptionKey}
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: eventsReceivedM.Description(),
+			Measure:     eventsReceivedM,
+			Aggregation: view.Count(),
+			TagKeys:     subscriptionTagKeys,
+		},
+		&view.View{
+			Description: eventsDispatchedM.Description(),
+			Measure:     eventsDispatchedM,
+			Aggregation: view.Count(),
+			TagKeys:     subscriptionTagKeys,
+		},
+		&view.View{
+			Description: dispatchFailuresM.Description(),
+			Measure:     dispatchFailuresM,
+			Aggregation: view.Count(),
+			TagKeys:     append([]tag.Key{responseCodeClassKey}, subscriptionTagKeys...),
+		},
+		&view.View{
+			Description: dispatchLatencyM.Description(),
+			Measure:     dispatchLatencyM,
+			Aggregation: view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+			TagKeys:     subscriptionTagKeys,
+		},
+		&view.View{
+			Description: dispatchRetriesM.Description(),
+			Measure:     dispatchRetriesM,
+			Aggregation: view.Count(),
+			TagKeys:     append([]tag.Key{retryReasonKey}, subscriptionTagKeys...),
+		},
+		&view.View{
+			Description: activeSubscriptionsM.Description(),
+			Measure:     activeSubscriptionsM,
+			Aggregation: view.LastValue(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// subscriptionTags returns the tags of the metrics of subscription of channel.
+func subscriptionTags(channel eventingchannels.ChannelReference, subscription types.UID) []tag.Mutator {
+	return cardinality.Tags(cardinality.Resource{Namespace: channel.Namespace, Channel: channel.Name, Subscription: string(subscription)})
+}
+
+// responseCodeClass returns the class of code, noResponseClass when there was no response.
+func responseCodeClass(code int) string {
+	if code == eventingchannels.NoResponse {
+		return noResponseClass
+	}
+	return metrics.ResponseCodeClass(code)
+}
+
+// recordReceived counts an event received by subscription of channel.
+func (s *SubscriptionsSupervisor) recordReceived(channel eventingchannels.ChannelReference, subscription types.UID) {
+	ctx, err := tag.New(context.Background(), subscriptionTags(channel, subscription)...)
+	if err != nil {
+		s.logger.Warn("Failed to tag the received event", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, eventsReceivedM.M(1))
+}
+
+// recordDispatch counts the dispatch of an event to the subscriber of subscription of channel,
+// with its result and latency.
+func (s *SubscriptionsSupervisor) recordDispatch(channel eventingchannels.ChannelReference, subscription types.UID, result deliveryResult, latency time.Duration) {
+	ctx, err := tag.New(context.Background(), subscriptionTags(channel, subscription)...)
+	if err != nil {
+		s.logger.Warn("Failed to tag the dispatch", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, eventsDispatchedM.M(1))
+	metrics.Record(ctx, dispatchLatencyM.M(float64(latency)/float64(time.Millisecond)))
+	if result.status == DeliveryStatusDelivered {
+		return
+	}
+	ctx, err = tag.New(ctx, tag.Insert(responseCodeClassKey, responseCodeClass(result.code)))
+	if err != nil {
+		s.logger.Warn("Failed to tag the failed dispatch", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, dispatchFailuresM.M(1))
+}
+
+// recordRetry counts a retry of the dispatch of an event to the subscriber of subscription of
+// channel, for reason.
+func (s *SubscriptionsSupervisor) recordRetry(channel eventingchannels.ChannelReference, subscription types.UID, reason string) {
+	ctx, err := tag.New(context.Background(), append(subscriptionTags(channel, subscription), tag.Insert(retryReasonKey, reason))...)
+	if err != nil {
+		s.logger.Warn("Failed to tag the retry", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, dispatchRetriesM.M(1))
+}
+
+// recordActiveSubscriptions records the number of NATSS subscriptions held, and the size of
+// their registry. It must be called holding subscriptionsMux, after changing the subscriptions.
+func (s *SubscriptionsSupervisor) recordActiveSubscriptions() {
+	metrics.Record(context.Background(), activeSubscriptionsM.M(int64(s.activeSubscriptions())))
+	runtimemetrics.RecordStructures(s.subscriptionEntriesSize())
+}
+
+// activeSubscriptions returns the number of NATSS subscriptions held, the consumers of a
+// subscription counting for one each. It must be called holding subscriptionsMux.
+func (s *SubscriptionsSupervisor) activeSubscriptions() int {
+	active := 0
+	for _, subs := range s.subscriptions {
+		for _, sub := range subs {
+			active += consumersOf(*sub)
+		}
+	}
+	return active
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/tag"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+)
+
+func TestSubscriptionTags(t *testing.T) {
+	defer cardinality.Set(cardinality.Default)
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "orders"}
+
+	testCases := map[cardinality.Mode]map[string]string{
+		cardinality.Full:    {"namespace_name": "ns", "channel": "ns/orders", "subscription": "uid-0"},
+		cardinality.Channel: {"namespace_name": "ns", "channel": "ns/orders"},
+		cardinality.Low:     {"namespace_name": "ns"},
+	}
+	for mode, want := range testCases {
+		t.Run(string(mode), func(t *testing.T) {
+			cardinality.Set(mode)
+			ctx, err := tag.New(context.Background(), subscriptionTags(channel, "uid-0")...)
+			if err != nil {
+				t.Fatalf("tag.New() = %v", err)
+			}
+			got := make(map[string]string)
+			for _, key := range subscriptionTagKeys {
+				if value, ok := tag.FromContext(ctx).Value(key); ok {
+					got[key.Name()] = value
+				}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("tags (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestResponseCodeClass(t *testing.T) {
+	for code, want := range map[int]string{
+		eventingchannels.NoResponse:   noResponseClass,
+		http.StatusPermanentRedirect:  "3xx",
+		http.StatusNotFound:           "4xx",
+		http.StatusServiceUnavailable: "5xx",
+	} {
+		if got := responseCodeClass(code); got != want {
+			t.Errorf("responseCodeClass(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestActiveSubscriptions(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber, subscriber)
+	active := func() int {
+		s.subscriptionsMux.Lock()
+		defer s.subscriptionsMux.Unlock()
+		return s.activeSubscriptions()
+	}
+
+	// Each consumer of a subscription is a NATSS subscription.
+	s.SetConsumers(ref, map[types.UID]int{channel.Spec.Subscribers[0].UID: 3})
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if got := active(); got != 4 {
+		t.Errorf("activeSubscriptions() = %d, want 4", got)
+	}
+
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if got := active(); got != 0 {
+		t.Errorf("activeSubscriptions() = %d, want 0", got)
+	}
+}
@@ -18,7 +18,9 @@ package dispatcher
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
@@ -27,19 +29,30 @@ import (
 
 	"k8s.io/apimachinery/pkg/types"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/stan.go"
 	"github.com/pkg/errors"
+	"go.opencensus.io/plugin/ochttp"
 	"go.uber.org/zap"
 
 	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
 	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
 	eventingchannels "knative.dev/eventing/pkg/channel"
 	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/tracing/propagation/tracecontextb3"
 
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+	"knative.dev/eventing-natss/pkg/features"
+	"knative.dev/eventing-natss/pkg/loglevel"
+	"knative.dev/eventing-natss/pkg/retry"
+	"knative.dev/eventing-natss/pkg/security"
 	"knative.dev/eventing-natss/pkg/stanutil"
 
 	natsscloudevents "github.com/cloudevents/sdk-go/protocol/stan/v2"
 	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
 )
 
 const (
@@ -47,9 +60,24 @@ const (
 	maxElements = 10
 )
 
+// The names of the loggers of the dispatcher, whose levels are set by the loglevel.<name> keys
+// of the config-logging ConfigMap.
+const (
+	// ReceiverLoggerName names the logger of the events received and published to NATSS.
+	ReceiverLoggerName = "dispatcher.receiver"
+	// SubscriptionsLoggerName names the logger of the subscriptions to NATSS and of the
+	// deliveries to the subscribers.
+	SubscriptionsLoggerName = "dispatcher.subscriptions"
+	// ConnectionLoggerName names the logger of the connections to NATSS.
+	ConnectionLoggerName = "dispatcher.connection"
+)
+
 var (
 	// retryInterval defines delay in seconds for the next attempt to reconnect to NATSS streaming server
 	retryInterval = 1 * time.Second
+	// maxRetryInterval caps the delay between the attempts to reconnect, doubled after each
+	// failed one, so that wrong credentials do not hammer NATS.
+	maxRetryInterval = 30 * time.Second
 )
 
 type SubscriptionChannelMapping map[eventingchannels.ChannelReference]map[types.UID]*stan.Subscription
@@ -57,24 +85,333 @@ type SubscriptionChannelMapping map[eventingchannels.ChannelReference]map[types.
 // SubscriptionsSupervisor manages the state of NATS Streaming subscriptions
 type SubscriptionsSupervisor struct {
 	logger *zap.Logger
+	// receiverLogger logs the events received and published to NATSS.
+	receiverLogger *zap.Logger
+	// subscriptionsLogger logs the subscriptions to NATSS and the deliveries to the subscribers.
+	subscriptionsLogger *zap.Logger
+	// connectionLogger logs the connections to NATSS.
+	connectionLogger *zap.Logger
 
 	receiver   *eventingchannels.MessageReceiver
 	dispatcher *eventingchannels.MessageDispatcherImpl
 
 	subscriptionsMux sync.Mutex
 	subscriptions    SubscriptionChannelMapping
+	// subscribedDistributions holds the v1beta1.Distribution the subscriptions of each channel were made with.
+	subscribedDistributions map[eventingchannels.ChannelReference]v1beta1.Distribution
+	// subscribedEphemeral holds the subscriptions of each channel made without a durable.
+	subscribedEphemeral map[eventingchannels.ChannelReference]map[types.UID]bool
+	// subscribedOptions holds the planner.Options the subscriptions of each channel were made with.
+	subscribedOptions map[eventingchannels.ChannelReference]map[types.UID]planner.Options
+	// targets holds the *subscriptionTarget of the running subscriptions, by UID.
+	targets sync.Map
 
-	connect   chan struct{}
-	natssURL  string
-	clusterID string
-	clientID  string
+	connect chan struct{}
+	// conns makes the connections to NATSS, the one of connKey and the ones of the clusters of
+	// the channels.
+	conns connPool
+	// connKey is the key of the connection of the dispatcher, protected by configMux.
+	connKey stanutil.ConnKey
+	// setStanOptions sets the options of the connections conns makes from then on, nil in the
+	// tests.
+	setStanOptions func(opts ...stan.Option)
+	// clusters holds the stanutil.ConnKey of the channels bound to another cluster than connKey.
+	clusters sync.Map
+	// clusterConnsMux protects clusterConns, the connections to the clusters of the channels
+	// other than connKey, clusterConnErrs, the errors of their last failed attempt, and
+	// clusterConnsClosed.
+	clusterConnsMux    sync.Mutex
+	clusterConns       map[stanutil.ConnKey]stan.Conn
+	clusterConnErrs    map[stanutil.ConnKey]error
+	clusterConnsClosed bool
 	// natConnMux is used to protect natssConn and natssConnInProgress during
 	// the transition from not connected to connected states.
 	natssConnMux        sync.Mutex
 	natssConn           *stan.Conn
 	natssConnInProgress bool
+	// natssConnKey is the key natssConn was made with, connKey but while reconnecting after a
+	// change of the configuration.
+	natssConnKey stanutil.ConnKey
+	// natssConnErr is the error of the lost connection to NATSS, or of the last failed attempt to
+	// connect, nil once connected.
+	natssConnErr error
+	// maintenanceMux guards maintenance, which tells the maintenance mode is enabled, and
+	// resubscribeDeferred, which tells the subscriptions of the connection lost wait for it to be
+	// disabled to be made again.
+	maintenanceMux      sync.Mutex
+	maintenance         bool
+	resubscribeDeferred bool
+	// connectionLostAt is when the connection to NATSS was lost, zero while connected and once the
+	// subscriptions are made again on the new connection.
+	connectionLostAt time.Time
+	// connectingSince is when the dispatcher first attempted to connect to NATSS.
+	connectingSince time.Time
+	// connectionNotifiers holds the functions called when the connection of the subscriptions of
+	// a channel is lost or they are made again.
+	connectionNotifiers sync.Map
+	// maxPayload is the max payload of NATS the receiver enforces, zero while unknown, read
+	// from the connection by maxPayloadOf. largestAccepted is the size of the largest event
+	// accepted since.
+	maxPayload      int64
+	largestAccepted int64
+	maxPayloadOf    func(stan.Conn) int64
+	// connected is signaled every time the connection to NATSS is (re-)established.
+	connected chan struct{}
+
+	// routes holds the *channelRoutes snapshot of the channels served by the receiver.
+	routes atomic.Value
+	// hostToChannelMapMux protects hostToChannelMapProcessed, staleHostToChannelMapLoaded and the
+	// writes of routes.
+	hostToChannelMapMux         sync.Mutex
+	hostToChannelMapProcessed   bool
+	staleHostToChannelMapLoaded bool
+
+	buffer *bufferLimiter
+	// egress shares the egress limits of the dispatcher between its channels.
+	egress *egressLimiter
+
+	// responseCodePolicies holds the v1beta1.ResponseCodePolicy of the channels overriding the default one.
+	responseCodePolicies      sync.Map
+	defaultResponseCodePolicy v1beta1.ResponseCodePolicy
+
+	// distributions holds the v1beta1.Distribution of the channels not using fanout.
+	distributions sync.Map
+	// ephemeral holds the subscriptions to make without a durable of the channels having some, by
+	// UID.
+	ephemeral sync.Map
+	// coldStart holds the subscriptions of the channels whose subscriber may be scaled to zero, by
+	// UID.
+	coldStart sync.Map
+	// consumers holds the number of consumers of the subscriptions of the channels having more
+	// than one, by UID.
+	consumers sync.Map
+	// ordered holds the subscriptions delivered in order of the channels having some, by UID.
+	ordered sync.Map
+	// orderedRetryDelay is the delay of the first retry of a failed delivery of an ordered
+	// subscription, and orderedRetryPoll how often it checks it is still held while waiting.
+	orderedRetryDelay time.Duration
+	orderedRetryPoll  time.Duration
+
+	// durableNaming is the scheme of the durables of the new subscriptions, once namingRecorded.
+	durableNaming DurableNamingScheme
+	// namedDurables holds the NamedDurable of the subscriptions, by UID, and namingRecorded tells
+	// whether those of the previous runs were loaded, both guarded by subscriptionsMux.
+	namedDurables  map[types.UID]NamedDurable
+	namingRecorded bool
+	// durableMigrations holds the DurableNamingScheme the durables of the channels are migrated to.
+	durableMigrations sync.Map
+	// migrations holds the *durableMigration of the durables being migrated, by UID, guarded by
+	// subscriptionsMux.
+	migrations map[types.UID]*durableMigration
+	// namedDurablesRecorder persists the NamedDurable of a migrated durable before the old one is
+	// removed, guarded by subscriptionsMux.
+	namedDurablesRecorder func([]NamedDurable) error
+	// migrationNotifiers holds the functions called once a durable of a channel is migrated.
+	migrationNotifiers sync.Map
+
+	// keyrings holds the *Keyring of the channels whose messages are encrypted.
+	keyrings sync.Map
+
+	// auditSinks holds the *auditSink of the channels mirrored to an audit sink.
+	auditSinks sync.Map
+	// auditQueue holds the copies of the events awaiting delivery to the audit sinks.
+	auditQueue  chan *auditCopy
+	auditClient *http.Client
+
+	// avroRegistries holds the *schemaRegistry of the channels transcoding their Avro events.
+	avroRegistries     sync.Map
+	registryClient     *http.Client
+	avroSchemaCacheTTL time.Duration
+
+	// warmUpClient is the client used to dispatch events, whose idle connections are warmed up.
+	warmUpClient *http.Client
+
+	replaysMux sync.Mutex
+	replays    map[types.UID]*replay
+
+	// hibernationThreshold is how long a channel must be idle before its subscriptions are closed,
+	// zero or less disabling the hibernation.
+	hibernationThreshold time.Duration
+	// subscribedChannels holds the last version of the channels the subscriptions were updated
+	// with, from which the hibernated channels, and all of them once the connection to NATSS is
+	// lost, are subscribed again.
+	subscribedChannels map[eventingchannels.ChannelReference]subscribedChannel
+	// leads tells whether the replica leads a channel, only the leader making its subscriptions,
+	// nil while the replica leads them all. demoted holds the last version of the channels it no
+	// longer leads, subscribed again once it is promoted. Both are guarded by subscriptionsMux.
+	leads   func(eventingchannels.ChannelReference) bool
+	demoted map[eventingchannels.ChannelReference]subscribedChannel
+	// activity holds the time, in nanoseconds, an event of each channel was last received or delivered.
+	activity sync.Map
+	// hibernated holds the *hibernatedChannel of the channels whose subscriptions are closed.
+	hibernated sync.Map
+	// hibernationNotifiers holds the functions called when a channel hibernates or wakes up.
+	hibernationNotifiers sync.Map
+	// subscriptionInits holds the v1beta1.SubscriptionInit of the lazy channels.
+	subscriptionInits sync.Map
+	// deferred holds the *deferredChannel of the lazy channels whose subscriptions are not made.
+	deferred sync.Map
+	// subscriptionInitNotifiers holds the functions called once the deferred subscriptions of a
+	// channel are made.
+	subscriptionInitNotifiers sync.Map
+	// lazyStarts holds the *lazyStart of the channels whose deferred subscriptions are being made,
+	// protected by subscriptionsMux.
+	lazyStarts map[eventingchannels.ChannelReference]*lazyStart
+
+	// redirectPolicies holds the v1beta1.RedirectPolicy of the channels denying the redirects.
+	redirectPolicies sync.Map
+	// optionChangePolicies holds the v1beta1.OptionChangePolicy of the channels not ignoring the
+	// changes of options NATSS does not apply to their durables.
+	optionChangePolicies sync.Map
+	// configMux protects the settings changed by UpdateConfig: connKey, publishOptions,
+	// maxRedirects, maxInflight, ackWait, startAt, deliveryConcurrency, reconnectBackoff and
+	// reconnectMaxBackoff.
+	configMux sync.RWMutex
+	// publishOptions are the options of the publications the connection of the dispatcher is made
+	// with.
+	publishOptions PublishOptions
+	// reconnectBackoff and reconnectMaxBackoff are the delay before the second attempt to connect
+	// to NATSS, doubled after each failed one up to the max, retryInterval and maxRetryInterval
+	// when zero.
+	reconnectBackoff    time.Duration
+	reconnectMaxBackoff time.Duration
+	// maxRedirects is the number of redirects a delivery follows before failing.
+	maxRedirects int
+	// maxInflight is how many events of a subscription NATSS sends before they are acknowledged,
+	// zero or less using the default of NATSS.
+	maxInflight int
+	// ackWait is how long NATSS waits for the ack of an event before redelivering it, zero or less
+	// using defaultAckWait.
+	ackWait time.Duration
+	// startAt is where the subscriptions without a durable to resume from start.
+	startAt v1beta1.StartPosition
+	// interceptors is the chain of the IngressInterceptor of the events received.
+	interceptors []IngressInterceptor
+	// ingressErrorStatus answers the events refused by an interceptor with an error which is not
+	// an *IngressError.
+	ingressErrorStatus int
+	// defaultDelivery is the retry and backoff of the subscriptions whose delivery spec sets none,
+	// nil when they are not retried.
+	defaultDelivery *eventingduckv1.DeliverySpec
+	// deliveryTimeout is the timeout of the requests of the deliveries of the subscriptions
+	// setting none, zero when they have none.
+	deliveryTimeout time.Duration
+	// deliveryTimeouts holds the timeouts of the requests of the deliveries of the subscriptions
+	// of the channels setting some, by UID.
+	deliveryTimeouts sync.Map
+	// deliveryLimits holds the *DeliveryLimits of the channels overriding maxRedirects,
+	// maxInflight, ackWait and startAt.
+	deliveryLimits sync.Map
+	// deliveryOptions holds the v1beta1.DeliveryOptions of the channels overriding their delivery
+	// limits.
+	deliveryOptions sync.Map
+	// fanoutLimits holds the number of subscribers subscribed of the channels limiting their
+	// fan-out.
+	fanoutLimits sync.Map
+	// orphanedSubscribers holds the UIDs of the subscribers of the channels paused because their
+	// Subscription no longer exists.
+	orphanedSubscribers sync.Map
+	// failureEventTargets holds the *failureEventTarget of the channels recording an event when
+	// their subscribers fail repeatedly, and subscriberFailures the *subscriberFailures of their
+	// subscriptions, by UID.
+	failureEventTargets sync.Map
+	subscriberFailures  sync.Map
+	// fanout holds the number of subscribers admitted of the channels, delivered each event.
+	fanout sync.Map
+	// workerPools holds the workerPool of the deliveries of the channels, of deliveryConcurrency
+	// workers each.
+	workerPools         sync.Map
+	deliveryConcurrency int
+	// refuseTLSDowngrade refuses the redirects of the deliveries from HTTPS to plain HTTP.
+	refuseTLSDowngrade bool
+
+	// deliveryReporter sends the reports of the deliveries, nil when they are disabled.
+	deliveryReporter *deliveryReporter
+	// offlineBuffer holds the events received while the connection to NATSS is lost, nil when
+	// they are not buffered.
+	offlineBuffer *offlineBuffer
 
-	hostToChannelMap atomic.Value
+	// receiverTLS is the TLS configuration the receiver serves HTTPS with, nil for plain HTTP.
+	receiverTLS *tls.Config
+	// transportEncryption holds the security.TransportEncryption mode of the cluster.
+	transportEncryption atomic.Value
+	// trustedProxies are the networks of the proxies whose forwarded headers tell the address of
+	// the clients sending the events.
+	trustedProxies []*net.IPNet
+	// rejectReservedExtensions makes the receiver reject the events carrying reserved extension
+	// attributes instead of stripping them.
+	rejectReservedExtensions bool
+	// multiplexTargets holds the channels the events received on MultiplexPath can be published to.
+	multiplexTargets sync.Map
+
+	// cursors tracks the position of the durables subscribed with the delivery-cursors flag.
+	cursors *cursorTracker
+
+	// unhealthyPauseAfter is how long the deliveries to a subscriber must all fail before its
+	// subscription is paused, zero or less disabling the pauses.
+	unhealthyPauseAfter time.Duration
+	// unhealthyProbeInterval is how often the subscribers of the paused subscriptions are probed.
+	unhealthyProbeInterval time.Duration
+	// health holds the *subscriberHealth of the subscriptions.
+	health sync.Map
+	// paused holds the subscriptions paused because their subscriber was unhealthy, guarded by
+	// subscriptionsMux.
+	paused map[types.UID]*pausedSubscription
+	// pauseNotifiers holds the functions called when a subscription of a channel is paused or
+	// resumed.
+	pauseNotifiers sync.Map
+
+	// endpointConnectCheck makes the checks of the endpoints of the new subscriptions connect to
+	// their subscriber, beyond resolving its host.
+	endpointConnectCheck bool
+	// endpointChecks holds the *endpointCheck of the subscriptions.
+	endpointChecks sync.Map
+	// endpointNotifiers holds the functions called when the endpoint of a subscription of a
+	// channel becomes unreachable or reachable again.
+	endpointNotifiers sync.Map
+
+	// insecureDeliveries holds the *insecureDelivery of the subscriptions delivering over plain
+	// HTTP to another namespace.
+	insecureDeliveries sync.Map
+	// insecureNotifiers holds the functions called when a subscription of a channel first
+	// delivers over plain HTTP to another namespace.
+	insecureNotifiers sync.Map
+
+	// partitioned counts the timeouts of the requests to NATSS as the refusals of the channels no
+	// partition owns.
+	partitioned bool
+	// unprovisioned holds the *unprovisionedChannel of the channels whose NATSS channel the
+	// server refused.
+	unprovisioned sync.Map
+	// provisioningNotifiers holds the functions called when the provisioning of the NATSS channel
+	// of a channel changes.
+	provisioningNotifiers sync.Map
+	// provisioningURL is POSTed the NATSS channels the server refused, empty disabling the requests.
+	provisioningURL    string
+	provisioningClient *http.Client
+
+	// e2eProbes holds the channels receiving the arrival times of the events of the end to end
+	// probes in flight, by ID.
+	e2eProbes sync.Map
+
+	// storagePressure holds the storagePressure of the store of NATSS reported by the storage
+	// monitor.
+	storagePressure atomic.Value
+	// shedOnPressure holds the channels whose events are refused under critical storage pressure.
+	shedOnPressure sync.Map
+	// observability holds the *channelObservability of the channels logged and traced apart.
+	observability sync.Map
+	// publishMode is how the receiver publishes the events to NATSS.
+	publishMode PublishMode
+
+	// dispatches counts the events being dispatched, waited for by drainTimeout when the
+	// dispatcher stops.
+	dispatches   inflight
+	drainTimeout time.Duration
+	// draining is 1 once the dispatcher stops, the subscriptions being refused.
+	draining int32
+	// channelsSynced is 1 once the channels existing when the dispatcher started were reconciled.
+	channelsSynced int32
 }
 
 type NatssDispatcher interface {
@@ -83,43 +420,307 @@ type NatssDispatcher interface {
 	ProcessChannels(ctx context.Context, chanList []messagingv1.Channel) error
 }
 
+// ConnectionNotifier is implemented by the dispatchers able to report when their
+// connection to NATSS is (re-)established.
+type ConnectionNotifier interface {
+	// Connected returns a channel receiving a value after a connection is established.
+	// Successive connections happening before the value is received are coalesced.
+	Connected() <-chan struct{}
+}
+
+// HostToChannelMapper is implemented by the dispatchers whose host to channel map can be persisted
+// and restored, so that they serve traffic before the channels informer is synced.
+type HostToChannelMapper interface {
+	// HostToChannelMap returns the current host to channel map.
+	HostToChannelMap() map[string]eventingchannels.ChannelReference
+	// LoadStaleHostToChannelMap sets a host to channel map served until ProcessChannels is called
+	// for the first time. It does nothing if ProcessChannels was already called.
+	LoadStaleHostToChannelMap(hcMap map[string]eventingchannels.ChannelReference)
+}
+
 type Args struct {
 	NatssURL  string
 	ClusterID string
 	ClientID  string
 	Cargs     kncloudevents.ConnectionArgs
 	Logger    *zap.Logger
-	Reporter  eventingchannels.StatsReporter
+	// Loggers provides the loggers of the receiver, the subscriptions and the connections, whose
+	// levels are set separately. Logger is used for all of them when it is nil.
+	Loggers  *loglevel.Loggers
+	Reporter eventingchannels.StatsReporter
+	// MaxBufferedBytes caps the size of the events awaiting dispatch across all subscriptions,
+	// zero or less disables the cap.
+	MaxBufferedBytes int64
+	// DefaultResponseCodePolicy applies to the channels without a response code policy.
+	DefaultResponseCodePolicy v1beta1.ResponseCodePolicy
+	// OutboundHeaders configures the headers identifying the dispatcher on its outbound requests,
+	// nil leaving the requests as the client sends them.
+	OutboundHeaders *OutboundHeaders
+	// HibernationThreshold is how long a channel must go without events before its subscriptions
+	// are closed until the next event, zero or less disabling the hibernation.
+	HibernationThreshold time.Duration
+	// MaxRedirects is the number of redirects a delivery follows before failing, zero
+	// disallowing the redirects.
+	MaxRedirects int
+	// MaxInflight is how many events of a subscription NATSS sends before they are acknowledged,
+	// zero or less using the default of NATSS.
+	MaxInflight int
+	// AckWait is how long NATSS waits for the ack of an event before redelivering it, extended by
+	// the backoff of the retries of the subscription, zero or less using one minute.
+	AckWait time.Duration
+	// StartAt is where the subscriptions without a durable to resume from start, the events
+	// published from when they are made by default.
+	StartAt v1beta1.StartPosition
+	// ErrorBodyLimit is how many bytes of the bodies of the error responses of the subscribers
+	// are kept to be logged, reported and sent to the dead letter sinks, zero or less disabling
+	// them.
+	ErrorBodyLimit int64
+	// DefaultDelivery is the retry and backoff of the subscriptions whose delivery spec sets none,
+	// nil leaving their failed deliveries to NATSS to redeliver.
+	DefaultDelivery *eventingduckv1.DeliverySpec
+	// DeliveryTimeout is the timeout of each request of the deliveries of the subscriptions
+	// setting none, zero or less leaving them to the transport.
+	DeliveryTimeout time.Duration
+	// AvroSchemaCacheTTL is how long the schemas fetched from the schema registries are cached,
+	// zero or less disabling the cache.
+	AvroSchemaCacheTTL time.Duration
+	// TLS configures the TLS connections to NATSS and to the subscribers, and the receiver.
+	TLS security.Config
+	// Auth holds the credentials the connection to NATSS authenticates with, the zero Auth not
+	// authenticating.
+	Auth stanutil.Auth
+	// DeliveryReports configures the reports of the deliveries POSTed to a sink, nil disabling
+	// them.
+	DeliveryReports *DeliveryReports
+	// OfflineBuffer configures the buffer of the events received while the connection to NATSS is
+	// lost, nil answering them with an error.
+	OfflineBuffer *OfflineBuffer
+	// TrustedProxies are the networks of the proxies in front of the receiver, whose Forwarded or
+	// X-Forwarded-For headers tell the address of the clients. The headers of the other peers
+	// are ignored.
+	TrustedProxies []*net.IPNet
+	// TransportEncryption is the transport encryption mode of the cluster when the dispatcher
+	// starts, changed afterwards through SetTransportEncryption.
+	TransportEncryption security.TransportEncryption
+	// IngressInterceptors are called in order on the events received, after the interceptors of
+	// the dispatcher validating them and screening their reserved extension attributes.
+	IngressInterceptors []IngressInterceptor
+	// IngressErrorStatus answers the events refused by an interceptor with an error which is not
+	// an *IngressError, DefaultIngressErrorStatus when zero.
+	IngressErrorStatus int
+	// RejectReservedExtensions makes the receiver answer 422 Unprocessable Entity to the events
+	// carrying the extension attributes reserved to the dispatcher, which are stripped otherwise.
+	RejectReservedExtensions bool
+	// UnhealthyPauseAfter is how long the deliveries to a subscriber must all fail before its
+	// subscription is paused, zero or less disabling the pauses.
+	UnhealthyPauseAfter time.Duration
+	// UnhealthyProbeInterval is how often the subscribers of the paused subscriptions are probed,
+	// DefaultUnhealthyProbeInterval when zero or less.
+	UnhealthyProbeInterval time.Duration
+	// EndpointConnectCheck makes the dispatcher connect to the subscribers of the new
+	// subscriptions to check that they are reachable, beyond resolving their host.
+	EndpointConnectCheck bool
+	// Partitioned tells that NATSS runs with partitioning, whose servers ignore the requests of
+	// the channels they do not own. The requests then time out, which is reported as the channel
+	// not being provisioned.
+	Partitioned bool
+	// ChannelProvisioningURL is POSTed the NATSS channels the server refused, for an
+	// administration service to create them, nil disabling the requests.
+	ChannelProvisioningURL *apis.URL
+	// DrainTimeout is how long the dispatcher waits, when it stops, for the events being
+	// dispatched once its subscriptions are closed, DefaultDrainTimeout when zero or less.
+	DrainTimeout time.Duration
+	// Publish configures the publications of the events the receiver accepts.
+	Publish PublishOptions
+	// DeliveryConcurrency is how many events of a channel are delivered at once, across its
+	// subscribers, DefaultDeliveryConcurrency when zero or less.
+	DeliveryConcurrency int
+	// Egress bounds the deliveries of the dispatcher across all its channels.
+	Egress EgressLimits
+	// DurableNaming is the scheme the durables of the new subscriptions are named with,
+	// DurableNamingV1 when empty. The existing durables keep the scheme they were named with.
+	DurableNaming DurableNamingScheme
+	// ReconnectBackoff is the delay before the second attempt to connect to NATSS, doubled after
+	// each failed one up to ReconnectMaxBackoff, one and thirty seconds when zero or less.
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
 }
 
 var _ NatssDispatcher = (*SubscriptionsSupervisor)(nil)
+var _ ConnectionNotifier = (*SubscriptionsSupervisor)(nil)
+var _ HostToChannelMapper = (*SubscriptionsSupervisor)(nil)
+var _ LifecycleParticipant = (*SubscriptionsSupervisor)(nil)
 
 // NewDispatcher returns a new NatssDispatcher.
 func NewDispatcher(args Args) (NatssDispatcher, error) {
 	if args.Logger == nil {
 		args.Logger = zap.NewNop()
 	}
+	if args.UnhealthyProbeInterval <= 0 {
+		args.UnhealthyProbeInterval = DefaultUnhealthyProbeInterval
+	}
+	if args.DeliveryConcurrency <= 0 {
+		args.DeliveryConcurrency = DefaultDeliveryConcurrency
+	}
+	if args.IngressErrorStatus == 0 {
+		args.IngressErrorStatus = DefaultIngressErrorStatus
+	}
+	if args.DrainTimeout <= 0 {
+		args.DrainTimeout = DefaultDrainTimeout
+	}
+
+	// The message dispatcher sends the events through the same shared client.
+	sender, err := kncloudevents.NewHTTPMessageSenderWithTarget("")
+	if err != nil {
+		return nil, err
+	}
+	auditClient := &http.Client{}
+	clientTLS, err := args.TLS.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	receiverTLS, err := args.TLS.ServerTLS()
+	if err != nil {
+		return nil, err
+	}
+	// The deliveries also trust the receiver, to reach the HTTPS addresses of the channels.
+	deliveryTLS, err := args.TLS.DeliveryTLS()
+	if err != nil {
+		return nil, err
+	}
+	var natsOptions []nats.Option
+	if clientTLS != nil {
+		// The certificates are read again on each connection, to follow their rotations.
+		natsOptions = append(natsOptions, stanutil.SecureReloaded(args.TLS.ClientTLS))
+	}
+	authOption, err := args.Auth.Option()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS credentials: %w", err)
+	}
+	if authOption != nil {
+		natsOptions = append(natsOptions, authOption)
+	}
+	if deliveryTLS != nil {
+		transport := security.NewTransport(deliveryTLS)
+		sender.Client = &http.Client{
+			Transport: &ochttp.Transport{
+				Base:        transport,
+				Propagation: tracecontextb3.TraceContextEgress,
+			},
+		}
+		auditClient = &http.Client{Transport: transport, CheckRedirect: security.CheckRedirect}
+	}
+
+	var decorators []requestDecorator
+	if args.OutboundHeaders != nil {
+		decorators = append(decorators, args.OutboundHeaders.decorators()...)
+	}
+	// The warm ups go through the client of the deliveries to share its idle connections.
+	sender.Client = newOutboundClient(sender.Client, decorators...)
+	if args.ErrorBodyLimit > 0 {
+		sender.Client.Transport = &errorBodyTransport{base: sender.Client.Transport, limit: args.ErrorBodyLimit}
+	}
+	sender.Client.Transport = &deliveryTimeoutTransport{base: sender.Client.Transport}
 
 	d := &SubscriptionsSupervisor{
-		logger:        args.Logger,
-		dispatcher:    eventingchannels.NewMessageDispatcher(args.Logger),
-		subscriptions: make(SubscriptionChannelMapping),
-		connect:       make(chan struct{}, maxElements),
-		natssURL:      args.NatssURL,
-		clusterID:     args.ClusterID,
-		clientID:      args.ClientID,
+		logger:              args.Logger,
+		receiverLogger:      args.Logger,
+		subscriptionsLogger: args.Logger,
+		connectionLogger:    args.Logger,
+		dispatcher:          eventingchannels.NewMessageDispatcherFromSender(args.Logger, sender),
+		subscriptions:       make(SubscriptionChannelMapping),
+		replays:             make(map[types.UID]*replay),
+		connect:             make(chan struct{}, maxElements),
+		connected:           make(chan struct{}, 1),
+		connKey:             stanutil.ConnKey{ClusterID: args.ClusterID, ClientID: args.ClientID, URL: args.NatssURL},
+		clusterConns:        make(map[stanutil.ConnKey]stan.Conn),
+		clusterConnErrs:     make(map[stanutil.ConnKey]error),
+		buffer:              newBufferLimiter(args.MaxBufferedBytes),
+		egress:              newEgressLimiter(args.Egress),
+
+		subscribedDistributions:   make(map[eventingchannels.ChannelReference]v1beta1.Distribution),
+		subscribedEphemeral:       make(map[eventingchannels.ChannelReference]map[types.UID]bool),
+		subscribedOptions:         make(map[eventingchannels.ChannelReference]map[types.UID]planner.Options),
+		auditQueue:                make(chan *auditCopy, auditQueueSize),
+		auditClient:               newOutboundClient(auditClient, decorators...),
+		registryClient:            newOutboundClient(auditClient, decorators...),
+		avroSchemaCacheTTL:        args.AvroSchemaCacheTTL,
+		defaultResponseCodePolicy: args.DefaultResponseCodePolicy,
+		warmUpClient:              sender.Client,
+		hibernationThreshold:      args.HibernationThreshold,
+		subscribedChannels:        make(map[eventingchannels.ChannelReference]subscribedChannel),
+		demoted:                   make(map[eventingchannels.ChannelReference]subscribedChannel),
+		lazyStarts:                make(map[eventingchannels.ChannelReference]*lazyStart),
+		receiverTLS:               receiverTLS,
+		maxRedirects:              args.MaxRedirects,
+		maxInflight:               args.MaxInflight,
+		ackWait:                   args.AckWait,
+		startAt:                   args.StartAt,
+		defaultDelivery:           args.DefaultDelivery,
+		deliveryTimeout:           args.DeliveryTimeout,
+		ingressErrorStatus:        args.IngressErrorStatus,
+		refuseTLSDowngrade:        args.TLS.Strict || args.TLS.CAFile != "",
+		trustedProxies:            args.TrustedProxies,
+		rejectReservedExtensions:  args.RejectReservedExtensions,
+		cursors:                   newCursorTracker(),
+		unhealthyPauseAfter:       args.UnhealthyPauseAfter,
+		unhealthyProbeInterval:    args.UnhealthyProbeInterval,
+		endpointConnectCheck:      args.EndpointConnectCheck,
+		paused:                    make(map[types.UID]*pausedSubscription),
+		partitioned:               args.Partitioned,
+		provisioningClient:        newOutboundClient(auditClient, decorators...),
+		maxPayloadOf:              natsMaxPayload,
+		drainTimeout:              args.DrainTimeout,
+		publishMode:               args.Publish.Mode,
+		publishOptions:            args.Publish,
+		deliveryConcurrency:       args.DeliveryConcurrency,
+		orderedRetryDelay:         defaultOrderedRetryDelay,
+		orderedRetryPoll:          defaultOrderedRetryPoll,
+		reconnectBackoff:          args.ReconnectBackoff,
+		reconnectMaxBackoff:       args.ReconnectMaxBackoff,
+		durableNaming:             args.DurableNaming,
+		namedDurables:             make(map[types.UID]NamedDurable),
+		migrations:                make(map[types.UID]*durableMigration),
+	}
+	if args.ChannelProvisioningURL != nil {
+		d.provisioningURL = args.ChannelProvisioningURL.String()
+	}
+	sender.Client.CheckRedirect = d.checkRedirect
+	d.SetTransportEncryption(args.TransportEncryption)
+	if args.DeliveryReports != nil {
+		d.deliveryReporter = newDeliveryReporter(*args.DeliveryReports, newOutboundClient(auditClient, decorators...), args.Logger)
+	}
+	if args.OfflineBuffer != nil && args.OfflineBuffer.MaxEvents > 0 {
+		d.offlineBuffer = newOfflineBuffer(*args.OfflineBuffer)
+	}
+	if args.Loggers != nil {
+		d.receiverLogger = args.Loggers.Named(ReceiverLoggerName).Desugar()
+		d.subscriptionsLogger = args.Loggers.Named(SubscriptionsLoggerName).Desugar()
+		d.connectionLogger = args.Loggers.Named(ConnectionLoggerName).Desugar()
+	}
+	conns := stanutil.NewConnManager(d.connectionLogger.Sugar(), natsOptions...)
+	conns.SetLostHandler(d.natssConnectionLost)
+	conns.SetStanOptions(args.Publish.stanOptions()...)
+	d.conns = conns
+	d.setStanOptions = conns.SetStanOptions
+	if clientTLS != nil && args.TLS.Strict {
+		// Fail fast rather than retrying a connection which can never be made.
+		if err := conns.Probe(args.NatssURL); err != nil {
+			return nil, fmt.Errorf("NATSS does not satisfy the strict security mode: %w", err)
+		}
 	}
 
 	receiver, err := eventingchannels.NewMessageReceiver(
 		messageReceiverFunc(d),
-		d.logger,
+		d.receiverLogger,
 		args.Reporter,
 		eventingchannels.ResolveMessageChannelFromHostHeader(d.getChannelReferenceFromHost))
 	if err != nil {
 		return nil, err
 	}
 	d.receiver = receiver
-	d.setHostToChannelMap(map[string]eventingchannels.ChannelReference{})
+	d.interceptors = d.ingressInterceptors(args.IngressInterceptors)
+	d.setRoutes(map[string]eventingchannels.ChannelReference{})
 	return d, nil
 }
 
@@ -132,68 +733,294 @@ func (s *SubscriptionsSupervisor) signalReconnect() {
 	}
 }
 
+func (s *SubscriptionsSupervisor) signalConnected() {
+	select {
+	case s.connected <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+// Connected implements ConnectionNotifier.
+func (s *SubscriptionsSupervisor) Connected() <-chan struct{} {
+	return s.connected
+}
+
 func messageReceiverFunc(s *SubscriptionsSupervisor) eventingchannels.UnbufferedMessageReceiverFunc {
 	return func(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, transformers []binding.Transformer, header http.Header) error {
-		s.logger.Info("Received event", zap.String("channel", channel.String()))
+		received := time.Now()
+		logger := s.receiverLoggerOf(channel)
+		fields := []zap.Field{zap.String("channel", channel.String())}
+		if client, ok := ClientAddress(ctx); ok {
+			fields = append(fields, zap.String("client", client))
+		}
+		logger.Info("Received event", fields...)
 
-		s.natssConnMux.Lock()
-		currentNatssConn := s.natssConn
-		s.natssConnMux.Unlock()
-		if currentNatssConn == nil {
-			s.logger.Error("no Connection to NATSS")
-			return errors.New("no Connection to NATSS")
+		// The dispatch of the event continues the trace of the request.
+		transformers = withTraceContext(ctx, transformers)
+		var buffered *event.Event
+		if s.buffersOffline(channel) {
+			// The event is read once to be either published or buffered.
+			e, err := binding.ToEvent(ctx, message, transformers...)
+			if err != nil {
+				logger.Error("could not read the event", zap.Error(err))
+				return errors.Wrap(err, "could not read the event")
+			}
+			if ok, err := s.bufferOffline(ctx, channel, e, received, false); ok {
+				return err
+			}
+			buffered, message, transformers = e, binding.ToMessage(e), nil
 		}
-		sender, err := natsscloudevents.NewSenderFromConn(*currentNatssConn, getSubject(channel))
+
+		currentNatssConn, err := s.connection(ctx, channel)
 		if err != nil {
-			s.logger.Error("could not create natss sender", zap.Error(err))
-			return errors.Wrap(err, "could not create natss sender")
-		}
-		if err := sender.Send(ctx, message); err != nil {
-			errMsg := "error during send"
-			if err.Error() == stan.ErrConnectionClosed.Error() {
-				errMsg += " - connection to NATSS has been lost, attempting to reconnect"
-				s.signalReconnect()
-			}
-			s.logger.Error(errMsg, zap.Error(err))
-			return errors.Wrap(err, errMsg)
+			logger.Error("no Connection to NATSS", zap.Error(err))
+			return err
 		}
-		s.logger.Debug("published", zap.String("channel", channel.String()))
-		return nil
+		err = s.publish(ctx, *currentNatssConn, channel, message, transformers, received)
+		if buffered != nil && isConnectionClosed(err) {
+			_, err = s.bufferOffline(ctx, channel, buffered, received, true)
+		}
+		return err
 	}
 }
 
+// publish publishes message, received at received, to channel on conn.
+func (s *SubscriptionsSupervisor) publish(ctx context.Context, conn stan.Conn, channel eventingchannels.ChannelReference, message binding.Message, transformers []binding.Transformer, received time.Time) error {
+	logger := s.receiverLoggerOf(channel)
+	message, audited, err := s.prepareAudit(ctx, channel, message)
+	if err != nil {
+		logger.Error("could not copy the event for the audit sink", zap.Error(err))
+		return errors.Wrap(err, "could not copy the event for the audit sink")
+	}
+
+	subject := s.subject(channel)
+	if err := s.notProvisioned(channel); err != nil {
+		logger.Debug("NATSS channel not provisioned, event refused", zap.String("channel", channel.String()))
+		return err
+	}
+	if err := s.awaitCapture(ctx, channel); err != nil {
+		logger.Error("the position of the lazy channel was not captured in time", zap.Error(err))
+		return err
+	}
+	if keys := s.keyring(channel); s.publishMode == PublishAsync {
+		err = publishAsync(ctx, conn, subject, message, keys, transformers...)
+	} else if keys != nil {
+		err = publishEncrypted(ctx, conn, subject, message, keys, transformers...)
+	} else {
+		sender, serr := natsscloudevents.NewSenderFromConn(conn, subject)
+		if serr != nil {
+			logger.Error("could not create natss sender", zap.Error(serr))
+			return errors.Wrap(serr, "could not create natss sender")
+		}
+		err = sender.Send(ctx, message, transformers...)
+	}
+	if err != nil {
+		errMsg := "error during send"
+		if err.Error() == stan.ErrConnectionClosed.Error() {
+			errMsg += " - connection to NATSS has been lost, attempting to reconnect"
+			s.connectionLost(channel)
+		} else if perr := s.recordProvisioning(channel, subject, err); perr != err {
+			logger.Error("could not publish the event", zap.Error(perr))
+			return perr
+		}
+		logger.Error(errMsg, zap.Error(err))
+		return errors.Wrap(err, errMsg)
+	}
+	_ = s.recordProvisioning(channel, subject, nil)
+	logger.Debug("published", zap.String("channel", channel.String()))
+	s.wakeUpOnEvent(channel, received)
+	s.initializeOnEvent(channel)
+	s.recordFanout(channel)
+	if audited != nil {
+		s.queueAudit(audited)
+	}
+	return nil
+}
+
+// Start runs the dispatcher until ctx is done, through a Lifecycle of its own.
 func (s *SubscriptionsSupervisor) Start(ctx context.Context) error {
-	// Starting Connect to establish connection with NATS
-	go s.Connect(ctx)
-	// Trigger Connect to establish connection with NATS
-	s.signalReconnect()
-	return s.receiver.Start(ctx)
+	l := NewLifecycle(s.logger, DefaultShutdownTimeout)
+	if err := s.RegisterHooks(l); err != nil {
+		return err
+	}
+	return l.Run(ctx)
+}
+
+// RegisterHooks implements LifecycleParticipant. The receiver stops before the subscriptions
+// and the connection to NATSS, so that the events it accepts until then are published. The
+// subscriptions then stop receiving events, the ones being dispatched being waited for up to
+// the drain timeout, before the connection closes.
+func (s *SubscriptionsSupervisor) RegisterHooks(l *Lifecycle) error {
+	connection := l.RunHook("connection", PriorityConnection, func(ctx context.Context) error {
+		// Trigger Connect to establish connection with NATS
+		s.markConnecting()
+		s.signalReconnect()
+		s.Connect(ctx)
+		return nil
+	})
+	stopConnect := connection.Stop
+	connection.Stop = func(ctx context.Context) error {
+		if err := stopConnect(ctx); err != nil {
+			return err
+		}
+		// The receiver stopped: the events it buffered are published while connected, the others
+		// are lost.
+		s.drainOfflineBuffer(ctx)
+		if err := s.closeClusterConnections(); err != nil {
+			s.connectionLogger.Warn("Failed to close the connections to the clusters of the channels", zap.Error(err))
+		}
+		return s.closeConnection()
+	}
+
+	subscriptions := l.RunHook("subscriptions", PrioritySubscriptions, func(ctx context.Context) error {
+		s.runAuditWorkers(ctx)
+		s.runHibernation(ctx)
+		s.runLazyProbes(ctx)
+		s.runPauseProbes(ctx)
+		s.runMaxPayloadRefresh(ctx)
+		s.egress.run(ctx)
+		<-ctx.Done()
+		return nil
+	})
+	stopWorkers := subscriptions.Stop
+	subscriptions.Stop = func(ctx context.Context) error {
+		// The subscriptions are drained before the workers stop, so that the events being
+		// dispatched are delivered, audited and reported.
+		err := s.drainSubscriptions(ctx)
+		if stopErr := stopWorkers(ctx); err == nil {
+			err = stopErr
+		}
+		return err
+	}
+
+	receiver := l.RunHook("receiver", PriorityReceiver, s.startReceiver)
+
+	hooks := []Hook{connection, subscriptions, receiver}
+	if s.deliveryReporter != nil {
+		// The reports of the deliveries made until the connection closes are sent.
+		hooks = append(hooks, l.RunHook("delivery-reports", PriorityConnection-1, s.deliveryReporter.run))
+	}
+	for _, h := range hooks {
+		if err := l.Register(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeConnection closes the connection to NATSS, keeping the durables of its subscriptions.
+func (s *SubscriptionsSupervisor) closeConnection() error {
+	s.natssConnMux.Lock()
+	defer s.natssConnMux.Unlock()
+	if s.natssConn == nil {
+		return nil
+	}
+	err := s.conns.Release(s.natssConnKey, *s.natssConn)
+	s.natssConn = nil
+	return err
 }
 
 func (s *SubscriptionsSupervisor) connectWithRetry(ctx context.Context) {
-	// re-attempting evey 1 second until the connection is established.
-	ticker := time.NewTicker(retryInterval)
-	defer ticker.Stop()
-	for {
-		nConn, err := stanutil.Connect(s.clusterID, s.clientID, s.natssURL, s.logger.Sugar())
-		if err == nil {
-			// Locking here in order to reduce time in locked state.
+	// The connection being replaced was lost, the manager making a new one once it is released.
+	s.natssConnMux.Lock()
+	stale, staleKey := s.natssConn, s.natssConnKey
+	s.natssConn = nil
+	s.natssConnMux.Unlock()
+	if stale != nil {
+		_ = s.conns.Release(staleKey, *stale)
+	}
+
+	// re-attempting with an exponential backoff, with jitter, until the connection is established.
+	_ = retry.Do(ctx, s.connectPolicy(), func(ctx context.Context) error {
+		// The key is read on each attempt, to follow the changes of the configuration.
+		key := s.dispatcherKey()
+		nConn, err := s.conns.Get(ctx, key)
+		if err != nil {
 			s.natssConnMux.Lock()
-			s.natssConn = nConn
+			changed := s.natssConnErr == nil || s.natssConnErr.Error() != err.Error()
+			s.natssConnErr = err
+			s.natssConnMux.Unlock()
+			if changed {
+				s.notifyConnection(key)
+			}
+			return err
+		}
+		// Locking here in order to reduce time in locked state.
+		s.natssConnMux.Lock()
+		if ctx.Err() != nil {
+			// The connection hook stopped while connecting.
 			s.natssConnInProgress = false
 			s.natssConnMux.Unlock()
-			return
+			_ = s.conns.Release(key, nConn)
+			return nil
 		}
-		s.logger.Sugar().Errorf("Connect() failed with error: %+v, retrying in %s", err, retryInterval.String())
-		select {
-		case <-ticker.C:
-			continue
-		case <-ctx.Done():
-			return
+		s.natssConn = &nConn
+		s.natssConnKey = key
+		s.natssConnInProgress = false
+		s.natssConnErr = nil
+		s.natssConnMux.Unlock()
+		// The new connection may be to a server with another max payload.
+		s.refreshMaxPayload()
+		switch {
+		case stale != nil && s.deferResubscribe():
+			// The connection is restored, and reported so, once the subscriptions are made again.
+			s.connectionLogger.Info("Connected to NATSS in maintenance mode, the subscriptions are made again once it is disabled")
+		case stale != nil:
+			// The subscriptions of the lost connection stopped with it.
+			s.resubscribe()
+			s.connectionRestored()
+		default:
+			s.connectionRestored()
 		}
+		s.flushOfflineBuffer()
+		s.signalConnected()
+		if key != s.dispatcherKey() {
+			// The configuration changed while connecting.
+			s.signalReconnect()
+		}
+		return nil
+	}, retry.OnRetry(func(_ int, err error, wait time.Duration) {
+		s.connectionLogger.Error("Failed to connect to NATSS", zap.Error(err), zap.Duration("retryIn", wait))
+	}))
+}
+
+// connectPolicy returns how the connection to NATSS is attempted again, following the reconnect
+// backoff of the configuration.
+func (s *SubscriptionsSupervisor) connectPolicy() retry.Policy {
+	policy := connectPolicy()
+	s.configMux.RLock()
+	defer s.configMux.RUnlock()
+	if s.reconnectBackoff > 0 {
+		policy.Initial = s.reconnectBackoff
+	}
+	if s.reconnectMaxBackoff > 0 {
+		policy.Max = s.reconnectMaxBackoff
+	}
+	return policy
+}
+
+// connectPolicy returns how the connection to NATSS is attempted again, until it is established.
+func connectPolicy() retry.Policy {
+	return retry.Policy{
+		Initial:    retryInterval,
+		Max:        maxRetryInterval,
+		Multiplier: 2,
+		Jitter:     retryJitter,
 	}
 }
 
+// noConnection returns the error of the operations made without a connection to NATSS, telling
+// why the last attempt to connect failed, such as a certificate NATSS refused.
+func (s *SubscriptionsSupervisor) noConnection() error {
+	s.natssConnMux.Lock()
+	defer s.natssConnMux.Unlock()
+	if s.natssConnErr != nil {
+		return fmt.Errorf("no Connection to NATSS: %w", s.natssConnErr)
+	}
+	return errors.New("no Connection to NATSS")
+}
+
 // Connect is called for initial connection as well as after every disconnect
 func (s *SubscriptionsSupervisor) Connect(ctx context.Context) {
 	for {
@@ -222,172 +1049,437 @@ func (s *SubscriptionsSupervisor) UpdateSubscriptions(ctx context.Context, chann
 	s.subscriptionsMux.Lock()
 	defer s.subscriptionsMux.Unlock()
 
-	failedToSubscribe := make(map[eventingduckv1.SubscriberSpec]error)
 	cRef := eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
-	s.logger.Info("Update subscriptions", zap.String("cRef", cRef.String()), zap.String("subscribable", fmt.Sprintf("%v", channel)), zap.Bool("isFinalizer", isFinalizer))
-	if channel.Spec.Subscribers == nil || isFinalizer {
-		s.logger.Sugar().Infof("Empty subscriptions for channel Ref: %v; unsubscribe all active subscriptions, if any", cRef)
-		chMap, ok := s.subscriptions[cRef]
-		if !ok {
-			// nothing to do
-			s.logger.Sugar().Infof("No channel Ref %v found in subscriptions map", cRef)
-			return failedToSubscribe, nil
-		}
-		for sub := range chMap {
-			s.logger.Error("unsubscribe", zap.Error(s.unsubscribe(cRef, sub)))
-		}
-		delete(s.subscriptions, cRef)
-		return failedToSubscribe, nil
+	if s.keepHibernated(cRef, channel, isFinalizer) {
+		s.subscriptionsLogger.Debug("Channel hibernated, subscriptions left closed", zap.String("channel", cRef.String()))
+		return make(map[eventingduckv1.SubscriberSpec]error), nil
 	}
+	if s.keepDeferred(ctx, cRef, channel, isFinalizer) {
+		s.subscriptionsLogger.Debug("Lazy channel without events, subscriptions deferred", zap.String("channel", cRef.String()))
+		return make(map[eventingduckv1.SubscriberSpec]error), nil
+	}
+	return s.updateSubscriptions(ctx, cRef, channel, isFinalizer)
+}
 
-	subscriptions := channel.Spec.Subscribers
-	activeSubs := make(map[types.UID]bool) // it's logically a set
-
-	chMap, ok := s.subscriptions[cRef]
-	if !ok {
-		chMap = make(map[types.UID]*stan.Subscription)
-		s.subscriptions[cRef] = chMap
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) updateSubscriptions(ctx context.Context, cRef eventingchannels.ChannelReference, channel *messagingv1.Channel, isFinalizer bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	defer s.recordActiveSubscriptions()
+	s.subscriptionsLogger.Info("Update subscriptions", zap.String("channel", cRef.String()), zap.String("subscribable", fmt.Sprintf("%v", channel)), zap.Bool("isFinalizer", isFinalizer))
+	if isFinalizer {
+		delete(s.demoted, cRef)
+		return make(map[eventingduckv1.SubscriberSpec]error), s.finalizeChannel(ctx, cRef, channel, nil)
+	}
+	// The channels woken up or initialized on a replica which does not lead them are subscribed
+	// once it is promoted.
+	if !s.leadsChannel(cRef) {
+		s.closeDemoted(cRef)
+		s.demoted[cRef] = subscribedChannel{ctx: ctx, channel: channel.DeepCopy()}
+		return make(map[eventingduckv1.SubscriberSpec]error), nil
 	}
 
-	for _, sub := range subscriptions {
-		// check if the subscription already exist and do nothing in this case
-		subRef := newSubscriptionReference(sub)
-		if _, ok := chMap[subRef.UID]; ok {
-			activeSubs[subRef.UID] = true
-			s.logger.Sugar().Infof("Subscription: %v already active for channel: %v", sub, cRef)
-			continue
-		}
-		// subscribe and update failedSubscription if subscribe fails
-		natssSub, err := s.subscribe(ctx, cRef, subRef)
-		if err != nil {
-			s.logger.Sugar().Errorf("failed to subscribe (subscription:%q) to channel: %v. Error:%s", sub, cRef, err.Error())
+	// The refused subscribers are reported as failed.
+	subscribers, failedToSubscribe := s.admitSubscribers(cRef, channel.Spec.Subscribers)
+	s.closeRefused(cRef, failedToSubscribe)
 
-			sub := newSubscriptionReference(sub)
-			failedToSubscribe[eventingduckv1.SubscriberSpec(sub)] = err
-			continue
-		}
-		chMap[subRef.UID] = natssSub
-		activeSubs[subRef.UID] = true
+	distribution := s.distribution(cRef)
+	ephemeral := s.ephemeralSubscriptions(cRef, distribution)
+	s.nameDurables(cRef, subscribers, distribution, ephemeral)
+	options := s.subscriptionOptions(cRef)
+	desired := planner.Desired{
+		Subscribers:         subscribers,
+		Distribution:        distribution,
+		Ephemeral:           ephemeral,
+		Consumers:           s.consumerSubscriptions(cRef, distribution),
+		Options:             options,
+		SubscriptionOptions: s.orderedOptions(cRef, distribution, options),
+		OptionChangePolicy:  s.optionChangePolicy(cRef),
+	}
+	plan := planner.Compute(s.currentSubscriptions(cRef), desired)
+	activeSubs := make(map[types.UID]bool) // it's logically a set
+	// The paused subscriptions refused keep their durables too.
+	for sub := range failedToSubscribe {
+		activeSubs[sub.UID] = true
 	}
-	// Unsubscribe for deleted subscriptions
-	for sub := range chMap {
-		if ok := activeSubs[sub]; !ok {
-			s.logger.Error("unsubscribe", zap.Error(s.unsubscribe(cRef, sub)))
+	for _, step := range plan {
+		switch step.Action {
+		case planner.Keep:
+			activeSubs[step.UID] = true
+			s.retarget(cRef, step.Subscriber)
+			s.checkEndpoint(cRef, step.Subscriber)
+			s.subscriptionsLogger.Debug("Subscription already active", zap.String("channel", cRef.String()), zap.String("subscription", string(step.UID)))
+		case planner.Unsubscribe:
+			s.subscriptionsLogger.Info("Unsubscribing", zap.String("channel", cRef.String()), zap.String("subscription", string(step.UID)), zap.String("reason", step.Reason))
+			if err := s.unsubscribe(cRef, step.UID); err != nil {
+				s.subscriptionsLogger.Error("unsubscribe", zap.Error(err))
+			}
+		case planner.Resubscribe:
+			s.subscriptionsLogger.Info("Resubscribing", zap.String("channel", cRef.String()), zap.String("subscription", string(step.UID)), zap.String("reason", step.Reason))
+			s.closeSubscription(cRef, step.UID)
+			fallthrough
+		case planner.Subscribe:
+			subRef := newSubscriptionReference(step.Subscriber)
+			if s.keepPaused(ctx, cRef, subRef) {
+				activeSubs[subRef.UID] = true
+				s.subscriptionsLogger.Debug("Subscription paused", zap.String("channel", cRef.String()), zap.String("subscription", string(subRef.UID)))
+				continue
+			}
+			// subscribe and update failedSubscription if subscribe fails
+			natssSub, err := s.subscribe(ctx, cRef, subRef, ephemeral[subRef.UID])
+			if err != nil {
+				s.subscriptionsLogger.Error("Failed to subscribe", zap.String("channel", cRef.String()), zap.String("subscription", string(subRef.UID)), zap.Error(err))
+				failedToSubscribe[eventingduckv1.SubscriberSpec(subRef)] = err
+				continue
+			}
+			if s.subscriptions[cRef] == nil {
+				s.subscriptions[cRef] = make(map[types.UID]*stan.Subscription)
+			}
+			s.subscriptions[cRef][subRef.UID] = natssSub
+			// The durable keeps the events while the endpoint is checked.
+			s.checkEndpoint(cRef, step.Subscriber)
+			if s.subscribedOptions[cRef] == nil {
+				s.subscribedOptions[cRef] = make(map[types.UID]planner.Options)
+			}
+			s.subscribedOptions[cRef][subRef.UID] = step.Options
+			if ephemeral[subRef.UID] {
+				if s.subscribedEphemeral[cRef] == nil {
+					s.subscribedEphemeral[cRef] = make(map[types.UID]bool)
+				}
+				s.subscribedEphemeral[cRef][subRef.UID] = true
+			}
+			activeSubs[subRef.UID] = true
 		}
 	}
-	// delete the channel from s.subscriptions if chMap is empty
+	s.forgetPaused(cRef, activeSubs)
+	// delete the channel from s.subscriptions if it has no subscription left
 	if len(s.subscriptions[cRef]) == 0 {
-		delete(s.subscriptions, cRef)
+		s.forgetChannel(cRef)
+		return failedToSubscribe, nil
 	}
+	s.subscribedDistributions[cRef] = distribution
+	s.subscribedChannels[cRef] = subscribedChannel{ctx: ctx, channel: channel.DeepCopy()}
+	s.fanout.Store(cRef, countSubscribers(subscribers))
+	s.migrateDurables(cRef, distribution)
 	return failedToSubscribe, nil
 }
 
-func (s *SubscriptionsSupervisor) subscribe(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference) (*stan.Subscription, error) {
-	s.logger.Info("Subscribe to channel:", zap.Any("channel", channel), zap.Any("subscription", subscription))
+// currentSubscriptions returns the subscriptions of channel, whose specs are not kept.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) currentSubscriptions(channel eventingchannels.ChannelReference) planner.Current {
+	current := planner.Current{
+		Subscribers:  make(map[types.UID]*eventingduckv1.SubscriberSpec, len(s.subscriptions[channel])),
+		Distribution: s.subscribedDistributions[channel],
+		Ephemeral:    s.subscribedEphemeral[channel],
+		Consumers:    make(map[types.UID]int),
+		Options:      make(map[types.UID]planner.Options),
+	}
+	for uid, sub := range s.subscriptions[channel] {
+		current.Subscribers[uid] = nil
+		if options, ok := s.subscribedOptions[channel][uid]; ok {
+			current.Options[uid] = options
+		}
+		if n := consumersOf(*sub); n > 1 {
+			current.Consumers[uid] = n
+		}
+	}
+	return current
+}
+
+// forgetChannel removes the state of channel once it has no subscription.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) forgetChannel(channel eventingchannels.ChannelReference) {
+	delete(s.subscriptions, channel)
+	delete(s.subscribedDistributions, channel)
+	delete(s.subscribedEphemeral, channel)
+	delete(s.subscribedOptions, channel)
+	delete(s.subscribedChannels, channel)
+	s.activity.Delete(channel)
+	s.fanout.Delete(channel)
+	s.workerPools.Delete(channel)
+}
+
+// durableTarget is the durable a subscription is made with.
+type durableTarget struct {
+	name string
+	// start is where a new durable starts, overriding the start position of the channel.
+	start stan.SubscriptionOption
+	// drain is the migration the old durable is drained for, nil when none.
+	drain *durableMigration
+}
+
+// subscribe makes the subscription of subscription to channel, without a durable when ephemeral.
+func (s *SubscriptionsSupervisor) subscribe(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference, ephemeral bool) (*stan.Subscription, error) {
+	return s.subscribeDurable(ctx, channel, subscription, ephemeral, durableTarget{name: s.durableName(channel, subscription)})
+}
+
+// subscribeDurable makes the subscription of subscription to channel from the durable d, without
+// a durable when ephemeral.
+func (s *SubscriptionsSupervisor) subscribeDurable(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference, ephemeral bool, d durableTarget) (*stan.Subscription, error) {
+	s.subscriptionsLogger.Info("Subscribe to channel", zap.String("channel", channel.String()), zap.Any("subscription", subscription), zap.Bool("ephemeral", ephemeral),
+		zap.String("durable", d.name), zap.Bool("draining", d.drain != nil))
+	if s.isDraining() {
+		return nil, errDraining
+	}
+
+	retry, err := s.retryConfig(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &firstDelivery{}
+	var tracked *trackedCursor
+	// The ephemeral subscriptions have no durable to track, and the old durable of a migration
+	// is removed once drained.
+	if features.FromContext(ctx).DeliveryCursors.Enabled() && !ephemeral && d.drain == nil {
+		tracked = s.cursors.open(channel, subscription.UID, d.name)
+	}
+
+	target := newSubscriptionTarget(subscription)
+	options := s.optionsOfSubscription(channel, subscription.UID)
+	// held and lastAcked are the subscription made and the sequence of the last event it
+	// acknowledged, for the ordered subscriptions.
+	held := &heldSubscription{}
+	var lastAcked uint64
 
 	mcb := func(stanMsg *stan.Msg) {
+		// The dispatcher stopping waits for the event to be delivered.
+		s.dispatches.begin()
+		defer s.dispatches.end()
+		logger := s.subscriptionsLoggerOf(channel)
+		subscription := target.apply(subscription)
+		deadLetter := target.deadLetterSink()
 		defer func() {
 			if r := recover(); r != nil {
-				s.logger.Warn("Panic happened while handling a message",
+				logger.Warn("Panic happened while handling a message",
 					zap.String("messages", stanMsg.String()),
-					zap.String("sub", string(subscription.UID)),
+					zap.String("subscription", string(subscription.UID)),
 					zap.Any("panic value", r),
 				)
 			}
 		}()
 
-		message, err := natsscloudevents.NewMessage(stanMsg, natsscloudevents.WithManualAcks())
+		s.touch(channel)
+		tracked.received(stanMsg.Sequence)
+		s.recordReceived(channel, subscription.UID)
+		// NATSS redelivers the event an ordered subscription retries once its ack wait elapses,
+		// which is acknowledged again once delivered.
+		if options.Ordered && stanMsg.Redelivered && stanMsg.Sequence <= atomic.LoadUint64(&lastAcked) {
+			if err := stanMsg.Ack(); err != nil {
+				logger.Error("failed to acknowledge message", zap.Error(err))
+			}
+			return
+		}
+		// The old durable of a migration leaves the events stored after the cutoff to the new one.
+		if !d.drain.received(stanMsg.Sequence) {
+			if err := stanMsg.Ack(); err != nil {
+				logger.Error("failed to acknowledge message", zap.Error(err))
+			}
+			return
+		}
+
+		// Hold the callback, and thus the ack, while the channel has as many deliveries in flight
+		// as its pool has workers, or too many bytes are awaiting dispatch.
+		pool := s.workerPool(channel)
+		size := int64(len(stanMsg.Data))
+		reserve := func() {
+			pool.acquire()
+			s.buffer.acquire(size)
+		}
+		free := func() {
+			s.buffer.release(size)
+			pool.release()
+		}
+		reserve()
+		reserved := true
+		defer func() {
+			if reserved {
+				free()
+			}
+		}()
+
+		decrypted, err := s.decrypt(channel, stanMsg)
 		if err != nil {
-			s.logger.Error("could not create a message", zap.Error(err))
+			// Not acknowledging the message makes NATSS redeliver it, once the keys are fixed.
+			logger.Error("could not decrypt a message", zap.Error(err))
 			return
 		}
-		s.logger.Debug("NATSS message received", zap.String("subject", stanMsg.Subject), zap.Uint64("sequence", stanMsg.Sequence), zap.Time("timestamp", time.Unix(stanMsg.Timestamp, 0)))
+		message, err := natsscloudevents.NewMessage(decrypted, natsscloudevents.WithManualAcks())
+		if err != nil {
+			logger.Error("could not create a message", zap.Error(err))
+			return
+		}
+		logger.Debug("NATSS message received", zap.String("subject", stanMsg.Subject), zap.Uint64("sequence", stanMsg.Sequence), zap.Time("timestamp", time.Unix(stanMsg.Timestamp, 0)))
 
 		var destination *url.URL
 		if !subscription.SubscriberURI.IsEmpty() {
 			destination = subscription.SubscriberURI.URL()
-			s.logger.Debug("dispatch message", zap.String("destination", destination.String()))
+			logger.Debug("dispatch message", zap.String("destination", destination.String()))
 		}
 
 		var reply *url.URL
 		if !subscription.ReplyURI.IsEmpty() {
 			reply = subscription.ReplyURI.URL()
-			s.logger.Debug("dispatch message", zap.String("reply", reply.String()))
+			logger.Debug("dispatch message", zap.String("reply", reply.String()))
 		}
 
-		var deadLetter *url.URL
-		if subscription.Delivery != nil && subscription.Delivery.DeadLetterSink != nil && !subscription.Delivery.DeadLetterSink.URI.IsEmpty() {
-			deadLetter = subscription.Delivery.DeadLetterSink.URI.URL()
-			s.logger.Debug("dispatch message", zap.String("deadLetter", deadLetter.String()))
+		dispatch := func() deliveryResult {
+			start := time.Now()
+			result := refusedInsecureDelivery
+			dispatched := !s.refuseInsecureDelivery(channel, subscription, destination)
+			if dispatched {
+				ctx, span := startChannelHopSpan(ctx, channel, subscription.UID, decrypted.Data, s.samplerOf(channel))
+				s.egress.acquire(ctx, channel, int64(len(decrypted.Data)))
+				ctx = withDeliveryTimeout(ctx, s.deliveryTimeoutOf(channel, subscription.UID))
+				result = s.deliver(ctx, channel, withEgressExtensions(ctx, decrypted, message), destination, reply, deadLetter, s.retryOfDelivery(channel, subscription.UID, retry))
+				span.End()
+			}
+			latency := time.Since(start)
+			if dispatched {
+				s.recordDispatch(channel, subscription.UID, result, latency)
+			}
+			delivery.record(latency)
+			s.reportDelivery(channel, subscription, decrypted, result, start, latency)
+			if dispatched {
+				s.recordFailureEvent(channel, subscription, destination, result)
+			}
+			if !ephemeral {
+				s.recordHealth(ctx, channel, subscription, result)
+			}
+			return result
 		}
-
-		executionInfo, err := s.dispatcher.DispatchMessage(ctx, message, nil, destination, reply, deadLetter)
-		if err != nil {
-			s.logger.Error("Failed to dispatch message: ", zap.Error(err))
+		result := dispatch()
+		// The ordered subscriptions retry the failed delivery until it succeeds, NATSS sending them
+		// the next event once it is acknowledged. The worker of the pool and the buffered bytes
+		// are left to the other deliveries between the attempts, so that a subscriber failing
+		// for long starves neither its channel nor the others.
+		for delay := s.orderedRetryDelay; options.Ordered && !ephemeral && !result.acked(); delay = nextOrderedRetryDelay(delay) {
+			free()
+			reserved = false
+			if !s.waitOrderedRetry(held, delay) {
+				return
+			}
+			reserve()
+			reserved = true
+			result = dispatch()
+		}
+		// The ephemeral subscriptions are best effort: they are never paused, and their failed
+		// deliveries are acknowledged too, NATSS never redelivering them.
+		if !ephemeral && !result.acked() {
+			// Not acknowledging the message makes NATSS redeliver it.
 			return
 		}
-		// TODO: Actually report the stats
-		// https://github.com/knative-sandbox/eventing-natss/issues/39
-		s.logger.Debug("Dispatch details", zap.Any("DispatchExecutionInfo", executionInfo))
+		d.drain.delivered(stanMsg.Sequence)
 		if err := stanMsg.Ack(); err != nil {
-			s.logger.Error("failed to acknowledge message", zap.Error(err))
+			logger.Error("failed to acknowledge message", zap.Error(err))
+		} else {
+			tracked.acked(stanMsg.Sequence)
+			atomic.StoreUint64(&lastAcked, stanMsg.Sequence)
 		}
 
-		s.logger.Debug("message dispatched", zap.Any("channel", channel))
+		logger.Debug("message dispatched", zap.String("channel", channel.String()))
 	}
 
 	ch := getSubject(channel)
-	sub := subscription.String()
 
-	s.natssConnMux.Lock()
-	currentNatssConn := s.natssConn
-	s.natssConnMux.Unlock()
-
-	if currentNatssConn == nil {
-		return nil, errors.New("no Connection to NATSS")
+	currentNatssConn, err := s.connection(ctx, channel)
+	if err != nil {
+		s.cursors.close(channel, subscription.UID, false)
+		return nil, err
 	}
 
-	subscriber := &natsscloudevents.RegularSubscriber{}
-	natssSub, err := subscriber.Subscribe(*currentNatssConn, ch, mcb, stan.DurableName(sub), stan.SetManualAckMode(), stan.AckWait(1*time.Minute))
+	if err := s.notProvisioned(channel); err != nil {
+		s.cursors.close(channel, subscription.UID, false)
+		return nil, err
+	}
+	consumers := s.consumersOfSubscription(channel, subscription.UID)
+	subscriber, durable := s.subscriber(channel, subscription, ephemeral, consumers, d.name)
+	opts := []stan.SubscriptionOption{durable, stan.SetManualAckMode(), stan.AckWait(ackWaitOf(options.AckWait, retry) + s.coldStartAckWait(channel, subscription.UID))}
+	if options.MaxInflight > 0 {
+		opts = append(opts, stan.MaxInflight(options.MaxInflight))
+	}
+	// The durables resume from where they were, NATSS ignoring the start position.
+	if d.start != nil {
+		// The new durable of a migration starts after the events left to the old one.
+		opts = append(opts, d.start)
+	} else if s.deliveryLimitsOf(channel).StartAt.OrDefault() == v1beta1.StartPositionAllAvailable {
+		opts = append(opts, stan.DeliverAllAvailable())
+	} else if start, ok := s.lazyStartOption(channel, subscription.UID); ok {
+		// The subscriptions deferred start from where the channel was when they were deferred.
+		opts = append(opts, start)
+	}
+	natssSub, err := s.subscribeConsumers(*currentNatssConn, subscriber, consumers, ch, mcb, opts...)
 	if err != nil {
-		s.logger.Error(" Create new NATSS Subscription failed: ", zap.Error(err))
+		s.cursors.close(channel, subscription.UID, false)
+		s.subscriptionsLogger.Error("Create new NATSS Subscription failed", zap.String("channel", channel.String()), zap.Error(err))
 		if err.Error() == stan.ErrConnectionClosed.Error() {
-			s.logger.Error("Connection to NATSS has been lost, attempting to reconnect.")
+			s.subscriptionsLogger.Error("Connection to NATSS has been lost, attempting to reconnect.")
 			// Informing SubscriptionsSupervisor to re-establish connection to NATS
-			s.signalReconnect()
+			s.connectionLost(channel)
 			return nil, err
 		}
-		return nil, err
+		return nil, s.recordProvisioning(channel, ch, err)
 	}
+	_ = s.recordProvisioning(channel, ch, nil)
+	held.set(natssSub)
+	s.startedLazily(channel, subscription.UID)
+	s.targets.Store(subscription.UID, target)
 
-	s.logger.Sugar().Infof("NATSS Subscription created: %+v", natssSub)
+	s.subscriptionsLogger.Info("NATSS Subscription created", zap.String("channel", channel.String()), zap.String("subscription", string(subscription.UID)), zap.Int("consumers", consumers), zap.Bool("ordered", options.Ordered))
+	if features.FromContext(ctx).WarmUpSubscribers.Enabled() && !subscription.SubscriberURI.IsEmpty() {
+		s.warmUpAsync(withOutboundChannel(ctx, channel), subscription.SubscriberURI.URL(), delivery)
+	}
 	return &natssSub, nil
 }
 
 // should be called only while holding subscriptionsMux
 func (s *SubscriptionsSupervisor) unsubscribe(channel eventingchannels.ChannelReference, subscription types.UID) error {
-	s.logger.Info("Unsubscribe from channel:", zap.Any("channel", channel), zap.Any("subscription", subscription))
+	s.subscriptionsLogger.Info("Unsubscribe from channel", zap.String("channel", channel.String()), zap.String("subscription", string(subscription)))
 
 	if stanSub, ok := s.subscriptions[channel][subscription]; ok {
 		if err := (*stanSub).Unsubscribe(); err != nil {
-			s.logger.Error("Unsubscribing NATSS Streaming subscription failed: ", zap.Error(err))
+			s.subscriptionsLogger.Error("Unsubscribing NATSS Streaming subscription failed", zap.String("channel", channel.String()), zap.Error(err))
 			return err
 		}
-		delete(s.subscriptions[channel], subscription)
+		s.forgetSubscription(channel, subscription)
 	}
 	return nil
 }
 
-func getSubject(channel eventingchannels.ChannelReference) string {
-	return channel.Name + "." + channel.Namespace
+// forgetSubscription removes the state of the subscription of channel once unsubscribed.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) forgetSubscription(channel eventingchannels.ChannelReference, subscription types.UID) {
+	s.stopMigration(subscription, true)
+	delete(s.namedDurables, subscription)
+	delete(s.subscriptions[channel], subscription)
+	delete(s.subscribedEphemeral[channel], subscription)
+	delete(s.subscribedOptions[channel], subscription)
+	s.targets.Delete(subscription)
+	s.cursors.close(channel, subscription, true)
+	s.health.Delete(subscription)
+	s.subscriberFailures.Delete(subscription)
+	s.insecureDeliveries.Delete(subscription)
+	s.stopEndpointCheck(subscription)
 }
 
-func (s *SubscriptionsSupervisor) getHostToChannelMap() map[string]eventingchannels.ChannelReference {
-	return s.hostToChannelMap.Load().(map[string]eventingchannels.ChannelReference)
+// closeSubscription closes the subscription of channel, keeping its durable.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) closeSubscription(channel eventingchannels.ChannelReference, subscription types.UID) {
+	stanSub, ok := s.subscriptions[channel][subscription]
+	if !ok {
+		return
+	}
+	s.stopMigration(subscription, false)
+	if err := (*stanSub).Close(); err != nil {
+		s.subscriptionsLogger.Error("Closing NATSS Streaming subscription failed", zap.String("channel", channel.String()),
+			zap.String("subscription", string(subscription)), zap.Error(err))
+	}
+	delete(s.subscriptions[channel], subscription)
+	delete(s.subscribedOptions[channel], subscription)
+	s.targets.Delete(subscription)
+	s.cursors.close(channel, subscription, false)
 }
 
-func (s *SubscriptionsSupervisor) setHostToChannelMap(hcMap map[string]eventingchannels.ChannelReference) {
-	s.hostToChannelMap.Store(hcMap)
+func getSubject(channel eventingchannels.ChannelReference) string {
+	return channel.Name + "." + channel.Namespace
 }
 
 // NewHostNameToChannelRefMap parses each channel from cList and creates a map[string(Status.Address.HostName)]ChannelReference
@@ -395,16 +1487,17 @@ func newHostNameToChannelRefMap(cList []messagingv1.Channel) (map[string]eventin
 	hostToChanMap := make(map[string]eventingchannels.ChannelReference, len(cList))
 	for _, c := range cList {
 		u := c.Status.Address.URL
-		if cr, present := hostToChanMap[u.Host]; present {
+		host := normalizeHost(u.Host)
+		if cr, present := hostToChanMap[host]; present {
 			return nil, fmt.Errorf(
 				"duplicate hostName found. Each channel must have a unique host header. HostName:%s, channel:%s.%s, channel:%s.%s",
-				u.Host,
+				host,
 				c.Namespace,
 				c.Name,
 				cr.Namespace,
 				cr.Name)
 		}
-		hostToChanMap[u.Host] = eventingchannels.ChannelReference{Name: c.Name, Namespace: c.Namespace}
+		hostToChanMap[host] = eventingchannels.ChannelReference{Name: c.Name, Namespace: c.Namespace}
 	}
 	return hostToChanMap, nil
 }
@@ -419,16 +1512,37 @@ func (s *SubscriptionsSupervisor) ProcessChannels(ctx context.Context, chanList
 		s.logger.Info("ProcessChannels: Error occurred when creating the new hostToChannel map.", zap.Error(err))
 		return err
 	}
-	s.setHostToChannelMap(hostToChanMap)
+	s.hostToChannelMapMux.Lock()
+	s.setRoutes(hostToChanMap)
+	s.hostToChannelMapProcessed = true
+	s.hostToChannelMapMux.Unlock()
 	s.logger.Info("hostToChannelMap updated successfully.")
 	return nil
 }
 
+// HostToChannelMap implements HostToChannelMapper, returning a copy of the current map.
+func (s *SubscriptionsSupervisor) HostToChannelMap() map[string]eventingchannels.ChannelReference {
+	return s.getRoutes().hostToChannelMap()
+}
+
+// LoadStaleHostToChannelMap implements HostToChannelMapper.
+func (s *SubscriptionsSupervisor) LoadStaleHostToChannelMap(hcMap map[string]eventingchannels.ChannelReference) {
+	s.hostToChannelMapMux.Lock()
+	defer s.hostToChannelMapMux.Unlock()
+	if s.hostToChannelMapProcessed {
+		s.logger.Info("hostToChannelMap already processed, ignoring the stale map.")
+		return
+	}
+	s.setRoutes(hcMap)
+	s.staleHostToChannelMapLoaded = true
+	s.logger.Info("Serving stale hostToChannelMap until the channels are processed.", zap.Int("hosts", len(hcMap)))
+}
+
 func (s *SubscriptionsSupervisor) getChannelReferenceFromHost(host string) (eventingchannels.ChannelReference, error) {
-	chMap := s.getHostToChannelMap()
-	cr, ok := chMap[host]
+	route, ok := s.getRoutes().byHost[normalizeHost(host)]
 	if !ok {
-		return cr, fmt.Errorf("Invalid HostName:%q. HostName not found in any of the watched natss channels", host)
+		// The receiver answers 404 Not Found to the requests for unknown hosts.
+		return eventingchannels.ChannelReference{}, eventingchannels.UnknownHostError(host)
 	}
-	return cr, nil
+	return route.channel, nil
 }
@@ -0,0 +1,260 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/loglevel"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+func TestStaleHostToChannelMap(t *testing.T) {
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+
+	kept := eventingchannels.ChannelReference{Namespace: "ns", Name: "kept"}
+	deleted := eventingchannels.ChannelReference{Namespace: "ns", Name: "deleted"}
+
+	// Served right away from the stale map.
+	s.LoadStaleHostToChannelMap(map[string]eventingchannels.ChannelReference{
+		"kept.ns.svc.cluster.local":    kept,
+		"deleted.ns.svc.cluster.local": deleted,
+	})
+	for host, want := range map[string]eventingchannels.ChannelReference{
+		"kept.ns.svc.cluster.local":    kept,
+		"deleted.ns.svc.cluster.local": deleted,
+	} {
+		got, err := s.getChannelReferenceFromHost(host)
+		if err != nil || got != want {
+			t.Errorf("getChannelReferenceFromHost(%q) = %v, %v, want %v", host, got, err, want)
+		}
+	}
+
+	// Corrected once the channels are processed.
+	if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{newChannel(kept)}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	if got, err := s.getChannelReferenceFromHost("kept.ns.svc.cluster.local"); err != nil || got != kept {
+		t.Errorf("getChannelReferenceFromHost(kept) = %v, %v, want %v", got, err, kept)
+	}
+	if _, err := s.getChannelReferenceFromHost("deleted.ns.svc.cluster.local"); err == nil {
+		t.Error("the deleted channel is still served after the channels were processed")
+	}
+
+	// A stale map never overrides processed channels.
+	s.LoadStaleHostToChannelMap(map[string]eventingchannels.ChannelReference{
+		"deleted.ns.svc.cluster.local": deleted,
+	})
+	if _, err := s.getChannelReferenceFromHost("deleted.ns.svc.cluster.local"); err == nil {
+		t.Error("the stale map was loaded after the channels were processed")
+	}
+}
+
+func newChannel(ref eventingchannels.ChannelReference) messagingv1.Channel {
+	return messagingv1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name},
+		Status: messagingv1.ChannelStatus{
+			ChannelableStatus: eventingduckv1.ChannelableStatus{
+				AddressStatus: duckv1.AddressStatus{
+					Address: &duckv1.Addressable{
+						URL: &apis.URL{Scheme: "http", Host: ref.Name + "." + ref.Namespace + ".svc.cluster.local"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	config, _ := logging.NewConfigFromMap(map[string]string{"loglevel." + SubscriptionsLoggerName: "debug"})
+	loggers := loglevel.New("natsschannel-dispatcher", config)
+	d, err := NewDispatcher(Args{ClientID: "test", Logger: zap.NewNop(), Loggers: loggers})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+
+	if !s.subscriptionsLogger.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("the subscriptions logger is not enabled at debug")
+	}
+	if s.receiverLogger.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("the receiver logger is enabled at debug")
+	}
+
+	// The levels are updated without creating the dispatcher again.
+	config, _ = logging.NewConfigFromMap(map[string]string{"loglevel.dispatcher": "debug", "loglevel." + SubscriptionsLoggerName: "error"})
+	loggers.Update(config)
+	if !s.receiverLogger.Core().Enabled(zapcore.DebugLevel) || !s.connectionLogger.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("the receiver and connection loggers are not enabled at debug")
+	}
+	if s.subscriptionsLogger.Core().Enabled(zapcore.WarnLevel) {
+		t.Error("the subscriptions logger is enabled at warn")
+	}
+}
+
+func TestNoConnectionReportsLastError(t *testing.T) {
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	errRefused := errors.New("remote error: tls: bad certificate")
+	s.natssConnErr = errRefused
+
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP("subscriber.ns.svc.cluster.local"),
+	}}
+	failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	// The error is the message of the NotReady status of the subscriber.
+	if err := failed[channel.Spec.Subscribers[0]]; !errors.Is(err, errRefused) {
+		t.Errorf("UpdateSubscriptions() = %v, want the subscription failed with %v", failed, errRefused)
+	}
+}
+
+func TestConnectPolicy(t *testing.T) {
+	policy := connectPolicy()
+	for retry, want := range []time.Duration{
+		retryInterval,
+		2 * retryInterval,
+		4 * retryInterval,
+		8 * retryInterval,
+		16 * retryInterval,
+		maxRetryInterval,
+		maxRetryInterval,
+	} {
+		if got := policy.Delay(retry); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", retry, got, want)
+		}
+	}
+	if policy.MaxAttempts != 0 || policy.MaxElapsed != 0 {
+		t.Errorf("connectPolicy() = %+v, want the connection attempted until established", policy)
+	}
+}
+
+// startRefusingNats starts a NATS server refusing every client with an authorization violation,
+// and returns its URL.
+func startRefusingNats(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n"))
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err == nil && strings.HasPrefix(line, "CONNECT ") {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+				}
+			}()
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func TestConnectWrongCredentialsRetried(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	d, err := NewDispatcher(Args{
+		ClientID: "test",
+		NatssURL: startRefusingNats(t),
+		Auth:     stanutil.Auth{User: "dispatcher", Password: "guess"},
+	})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	core, logs := observer.New(zap.ErrorLevel)
+	s.connectionLogger = zap.New(core)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.connectWithRetry(ctx)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for logs.FilterMessage("Failed to connect to NATSS").Len() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	failures := logs.FilterMessage("Failed to connect to NATSS").All()
+	if len(failures) < 3 {
+		t.Fatalf("%d failed connections logged, want at least 3", len(failures))
+	}
+	var previous time.Duration
+	for i, entry := range failures[:3] {
+		fields := entry.ContextMap()
+		if err, _ := fields["error"].(string); !strings.Contains(strings.ToLower(err), "authorization violation") {
+			t.Errorf("attempt %d logged the error %q, want the authorization violation", i, err)
+		}
+		retryIn, _ := fields["retryIn"].(time.Duration)
+		if retryIn <= previous {
+			t.Errorf("attempt %d retried in %v, want more than %v", i, retryIn, previous)
+		}
+		previous = retryIn
+	}
+	if err := s.noConnection(); !strings.Contains(strings.ToLower(err.Error()), "authorization violation") {
+		t.Errorf("noConnection() = %v, want the authorization violation", err)
+	}
+}
+
+func TestNewDispatcherInvalidCredentials(t *testing.T) {
+	_, err := NewDispatcher(Args{
+		ClientID: "test",
+		Auth:     stanutil.Auth{Token: "t0ken", User: "dispatcher", Password: "s3cret"},
+	})
+	if err == nil {
+		t.Error("NewDispatcher() succeeded with two authentication modes")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	natsscloudevents "github.com/cloudevents/sdk-go/protocol/stan/v2"
+	"github.com/nats-io/stan.go"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// workQueueDurableName is the durable name shared by the members of the queue group of a work
+// queue channel, NATSS identifying a durable queue group by both names.
+const workQueueDurableName = "workqueue"
+
+// DistributionSetter is implemented by the dispatchers supporting the distributions other than
+// v1beta1.DistributionFanout.
+type DistributionSetter interface {
+	// SetDistribution sets how the events of channel are distributed among its subscribers. It
+	// must be called before updating the subscriptions of the channel to take effect.
+	SetDistribution(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution)
+}
+
+var _ DistributionSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetDistribution implements DistributionSetter.
+func (s *SubscriptionsSupervisor) SetDistribution(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution) {
+	if distribution.OrDefault() == v1beta1.DistributionFanout {
+		s.distributions.Delete(channel)
+		return
+	}
+	s.distributions.Store(channel, distribution)
+}
+
+// distribution returns the distribution of channel.
+func (s *SubscriptionsSupervisor) distribution(channel eventingchannels.ChannelReference) v1beta1.Distribution {
+	if distribution, ok := s.distributions.Load(channel); ok {
+		return distribution.(v1beta1.Distribution)
+	}
+	return v1beta1.DistributionFanout
+}
+
+// ChannelDurableName returns the name of the durable holding the events of subscriber on a
+// channel with distribution, the members of a work queue sharing the durable of their queue group.
+// The durables named with another scheme than DurableNamingV1 are listed by ChannelDurableNames.
+func ChannelDurableName(distribution v1beta1.Distribution, subscriber eventingduckv1.SubscriberSpec) string {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return workQueueDurableName
+	}
+	return DurableName(subscriber)
+}
+
+// subscriber returns how the subscriber subscription is made to channel, and the options of its
+// durable named durable. The subscribers of a work queue channel are the members of a single
+// durable queue group named after the subject of the channel, each message being delivered to one
+// of them only.
+func (s *SubscriptionsSupervisor) subscriber(channel eventingchannels.ChannelReference, subscription subscriptionReference, ephemeral bool, consumers int, durable string) (natsscloudevents.Subscriber, stan.SubscriptionOption) {
+	if consumers > 1 {
+		// The consumers are the members of a queue group named after the subscription, durable
+		// unless the subscription is ephemeral.
+		if ephemeral {
+			durable = ""
+		}
+		return &natsscloudevents.QueueSubscriber{QueueGroup: subscription.String()}, stan.DurableName(durable)
+	}
+	if ephemeral {
+		// An empty durable name makes a plain subscription, never a member of a work queue.
+		return &natsscloudevents.RegularSubscriber{}, stan.DurableName("")
+	}
+	if s.distribution(channel) == v1beta1.DistributionWorkQueue {
+		return &natsscloudevents.QueueSubscriber{QueueGroup: getSubject(channel)}, stan.DurableName(durable)
+	}
+	return &natsscloudevents.RegularSubscriber{}, stan.DurableName(durable)
+}
+
+// durableName returns the name of the durable subscriber subscribes to channel with, after the
+// naming scheme of the subscription.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) durableName(channel eventingchannels.ChannelReference, subscription subscriptionReference) string {
+	if s.distribution(channel) == v1beta1.DistributionWorkQueue {
+		return workQueueDurableName
+	}
+	return s.namingOf(subscription.UID).durable(subscription.UID)
+}
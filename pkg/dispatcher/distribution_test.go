@@ -0,0 +1,352 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// fakeStanConn delivers the published messages to its subscriptions like NATSS does: once to
+// each regular subscription, and once to a member of each queue group, in turn. The messages
+// published while a durable is closed are delivered when it is subscribed again.
+type fakeStanConn struct {
+	stan.Conn
+
+	mu     sync.Mutex
+	subs   []*fakeStanSubscription
+	groups map[string][]*fakeStanSubscription
+	next   map[string]int
+	closed map[string][]*stan.Msg
+
+	// unsubscribeDelay is the round-trip to NATSS of an unsubscribe, and unsubscribeErr its error.
+	unsubscribeDelay time.Duration
+	unsubscribeErr   error
+}
+
+type fakeStanSubscription struct {
+	stan.Subscription
+
+	conn        *fakeStanConn
+	cb          stan.MsgHandler
+	group       string
+	durable     string
+	maxInflight int
+	ackWait     time.Duration
+	startAt     pb.StartPosition
+	// removed is set once unsubscribed or closed.
+	removed bool
+}
+
+func newFakeStanConn() *fakeStanConn {
+	return &fakeStanConn{
+		groups: make(map[string][]*fakeStanSubscription),
+		next:   make(map[string]int),
+		closed: make(map[string][]*stan.Msg),
+	}
+}
+
+func (c *fakeStanConn) Subscribe(_ string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	o := subscriptionOptions(opts)
+	sub := &fakeStanSubscription{conn: c, cb: cb, durable: o.DurableName, maxInflight: o.MaxInflight, ackWait: o.AckWait, startAt: o.StartAt}
+	c.subs = append(c.subs, sub)
+	c.resume(sub)
+	return sub, nil
+}
+
+func (c *fakeStanConn) QueueSubscribe(_, qgroup string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	o := subscriptionOptions(opts)
+	sub := &fakeStanSubscription{conn: c, cb: cb, group: qgroup, durable: o.DurableName, maxInflight: o.MaxInflight, ackWait: o.AckWait, startAt: o.StartAt}
+	c.groups[qgroup] = append(c.groups[qgroup], sub)
+	c.resume(sub)
+	return sub, nil
+}
+
+// resume delivers to sub the messages published while its durable was closed.
+func (c *fakeStanConn) resume(sub *fakeStanSubscription) {
+	backlog, ok := c.closed[sub.key()]
+	if !ok {
+		return
+	}
+	delete(c.closed, sub.key())
+	go func() {
+		for _, msg := range backlog {
+			sub.cb(msg)
+		}
+	}()
+}
+
+func subscriptionOptions(opts []stan.SubscriptionOption) stan.SubscriptionOptions {
+	o := stan.DefaultSubscriptionOptions
+	for _, opt := range opts {
+		_ = opt(&o)
+	}
+	return o
+}
+
+func (c *fakeStanConn) Publish(_ string, data []byte) error {
+	c.publish(&stan.Msg{MsgProto: pb.MsgProto{Data: data}})
+	return nil
+}
+
+func (c *fakeStanConn) publish(msg *stan.Msg) {
+	c.mu.Lock()
+	for durable, backlog := range c.closed {
+		c.closed[durable] = append(backlog, msg)
+	}
+	var targets []*fakeStanSubscription
+	targets = append(targets, c.subs...)
+	for group, members := range c.groups {
+		if len(members) > 0 {
+			targets = append(targets, members[c.next[group]%len(members)])
+			c.next[group]++
+		}
+	}
+	c.mu.Unlock()
+	for _, sub := range targets {
+		sub.cb(msg)
+	}
+}
+
+func (s *fakeStanSubscription) Unsubscribe() error {
+	time.Sleep(s.conn.unsubscribeDelay)
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	if s.conn.unsubscribeErr != nil {
+		return s.conn.unsubscribeErr
+	}
+	s.remove()
+	return nil
+}
+
+// Close keeps the durable of the subscription, unless other members of its queue group remain.
+// Closing it again keeps the events published since.
+func (s *fakeStanSubscription) Close() error {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	if s.removed {
+		return nil
+	}
+	s.remove()
+	if s.durable != "" && len(s.conn.groups[s.group]) == 0 {
+		s.conn.closed[s.key()] = nil
+	}
+	return nil
+}
+
+func (s *fakeStanSubscription) key() string {
+	return s.group + "/" + s.durable
+}
+
+func (s *fakeStanSubscription) IsValid() bool {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	return !s.removed
+}
+
+func (s *fakeStanSubscription) remove() {
+	s.removed = true
+	remove := func(subs []*fakeStanSubscription) []*fakeStanSubscription {
+		for i, sub := range subs {
+			if sub == s {
+				return append(subs[:i], subs[i+1:]...)
+			}
+		}
+		return subs
+	}
+	if s.group != "" {
+		s.conn.groups[s.group] = remove(s.conn.groups[s.group])
+	} else {
+		s.conn.subs = remove(s.conn.subs)
+	}
+}
+
+// eventRecorder is a subscriber recording the IDs of the events it receives.
+type eventRecorder struct {
+	*httptest.Server
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func newEventRecorder() *eventRecorder {
+	r := &eventRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.ids = append(r.ids, e.ID())
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return r
+}
+
+func (r *eventRecorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+func TestDistribution(t *testing.T) {
+	testCases := map[string]struct {
+		distribution v1beta1.Distribution
+		wantTotal    int
+		wantDisjoint bool
+	}{
+		"fanout": {
+			wantTotal: 20,
+		},
+		"workqueue": {
+			distribution: v1beta1.DistributionWorkQueue,
+			wantTotal:    10,
+			wantDisjoint: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			first, second := newEventRecorder(), newEventRecorder()
+			defer first.Close()
+			defer second.Close()
+
+			s, conn := newTestSupervisor(t)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			s.SetDistribution(ref, tc.distribution)
+			failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, first, second), false)
+			if err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+
+			for i := 0; i < 10; i++ {
+				conn.publish(newTestEventMsg(t, fmt.Sprint(i)))
+			}
+
+			got := append(first.received(), second.received()...)
+			if len(got) != tc.wantTotal {
+				t.Errorf("delivered %d events, want %d", len(got), tc.wantTotal)
+			}
+			if !tc.wantDisjoint {
+				return
+			}
+			if len(first.received()) == 0 || len(second.received()) == 0 {
+				t.Errorf("a subscriber received no event: %v, %v", first.received(), second.received())
+			}
+			seen := make(map[string]bool)
+			for _, id := range got {
+				if seen[id] {
+					t.Errorf("event %s delivered to both subscribers", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestDistributionChange(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	// The subscription is made again in the queue group.
+	s.SetDistribution(ref, v1beta1.DistributionWorkQueue)
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if len(conn.subs) != 0 || len(conn.groups[getSubject(ref)]) != 1 {
+		t.Errorf("got %d regular subscriptions and %d queue group members, want 0 and 1", len(conn.subs), len(conn.groups[getSubject(ref)]))
+	}
+
+	// Once the channel is gone, its distribution is forgotten.
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, ok := s.subscribedDistributions[ref]; ok {
+		t.Error("the distribution of the removed channel is still recorded")
+	}
+	if len(conn.groups[getSubject(ref)]) != 0 {
+		t.Error("the queue group member was not unsubscribed")
+	}
+}
+
+func newTestSupervisor(t testing.TB) (*SubscriptionsSupervisor, *fakeStanConn) {
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	conn := newFakeStanConn()
+	var natssConn stan.Conn = conn
+	s.natssConn = &natssConn
+	return s, conn
+}
+
+func newTestChannel(ref eventingchannels.ChannelReference, subscribers ...*eventRecorder) *messagingv1.Channel {
+	c := &messagingv1.Channel{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}}
+	for i, subscriber := range subscribers {
+		c.Spec.Subscribers = append(c.Spec.Subscribers, eventingduckv1.SubscriberSpec{
+			UID:           types.UID(fmt.Sprintf("uid-%d", i)),
+			SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+		})
+	}
+	return c
+}
+
+func newTestEventMsg(t *testing.T, id string) *stan.Msg {
+	e := event.New()
+	e.SetID(id)
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("failed to marshal the event: %v", err)
+	}
+	return &stan.Msg{MsgProto: pb.MsgProto{Data: data}}
+}
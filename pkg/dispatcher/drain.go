@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultDrainTimeout is how long the dispatcher waits, when it stops, for the events being
+// dispatched when no drain timeout is configured.
+const DefaultDrainTimeout = 30 * time.Second
+
+// errDraining is the error of the subscriptions made while the dispatcher stops.
+var errDraining = errors.New("the dispatcher is stopping")
+
+// inflight counts the events being dispatched, which the dispatcher waits for when it stops.
+type inflight struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed once n drops to zero.
+	idle chan struct{}
+}
+
+func (f *inflight) begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.n++
+}
+
+func (f *inflight) end() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n--; f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// count returns the number of events being dispatched.
+func (f *inflight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+// wait waits until no event is being dispatched or ctx is done.
+func (f *inflight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	idle := f.idle
+	n := f.n
+	f.mu.Unlock()
+	if n == 0 {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isDraining tells whether the dispatcher is stopping, refusing new subscriptions.
+func (s *SubscriptionsSupervisor) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// drainSubscriptions closes the subscriptions, keeping their durables, so that NATSS sends them no
+// more events, then waits for the events being dispatched until the drain timeout elapses or ctx
+// is done. The events not acknowledged by then are redelivered by NATSS once subscribed again.
+func (s *SubscriptionsSupervisor) drainSubscriptions(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.subscriptionsMux.Lock()
+	closed := 0
+	for channel, subscriptions := range s.subscriptions {
+		for subscription := range subscriptions {
+			s.closeSubscription(channel, subscription)
+			closed++
+		}
+	}
+	s.subscriptionsMux.Unlock()
+	s.subscriptionsLogger.Info("Draining the subscriptions", zap.Int("subscriptions", closed), zap.Int("dispatching", s.dispatches.count()))
+
+	ctx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+	defer cancel()
+	if err := s.dispatches.wait(ctx); err != nil {
+		return fmt.Errorf("%d events still being dispatched, redelivered by NATSS: %w", s.dispatches.count(), err)
+	}
+	return nil
+}
+
+// drainOfflineBuffer publishes the events of the offline buffer while connected, until ctx is
+// done. The events left are dropped.
+func (s *SubscriptionsSupervisor) drainOfflineBuffer(ctx context.Context) {
+	b := s.offlineBuffer
+	if b == nil {
+		return
+	}
+	s.natssConnMux.Lock()
+	connected := s.natssConn != nil
+	s.natssConnMux.Unlock()
+	if connected {
+		s.flushOfflineBuffer()
+		b.mu.Lock()
+		flushing, flushed := b.flushing, b.flushed
+		b.mu.Unlock()
+		if flushing {
+			select {
+			case <-flushed:
+			case <-ctx.Done():
+			}
+		}
+	}
+	s.dropOfflineBuffer()
+}
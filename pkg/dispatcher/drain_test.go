@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+// slowSubscriber is a subscriber answering the events once released.
+type slowSubscriber struct {
+	*httptest.Server
+
+	received  chan struct{}
+	release   chan struct{}
+	delivered int32
+}
+
+func newSlowSubscriber() *slowSubscriber {
+	sub := &slowSubscriber{received: make(chan struct{}, 10), release: make(chan struct{})}
+	sub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sub.received <- struct{}{}
+		<-sub.release
+		atomic.AddInt32(&sub.delivered, 1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return sub
+}
+
+func newSlowChannel(ref eventingchannels.ChannelReference, sub *slowSubscriber) *messagingv1.Channel {
+	c := &messagingv1.Channel{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}}
+	c.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid",
+		SubscriberURI: apis.HTTP(sub.Listener.Addr().String()),
+	}}
+	return c
+}
+
+func TestDrainSubscriptions(t *testing.T) {
+	sub := newSlowSubscriber()
+	defer sub.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newSlowChannel(ref, sub)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	go conn.publish(newTestEventMsg(t, "1"))
+	select {
+	case <-sub.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not dispatched")
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.drainSubscriptions(context.Background())
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("drainSubscriptions() = %v while the event was being dispatched", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(sub.release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("drainSubscriptions() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the drain did not end once the event was delivered")
+	}
+	if got := atomic.LoadInt32(&sub.delivered); got != 1 {
+		t.Errorf("delivered %d events before the drain ended, want 1", got)
+	}
+
+	// The subscription is closed, keeping its durable, and not made again.
+	conn.mu.Lock()
+	subscriptions := len(conn.subs)
+	kept := len(conn.closed) == 1
+	conn.mu.Unlock()
+	if subscriptions != 0 || !kept {
+		t.Errorf("got %d subscriptions, durable kept %t, want the subscription closed with its durable kept", subscriptions, kept)
+	}
+	failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if err := failed[channel.Spec.Subscribers[0]]; !errors.Is(err, errDraining) {
+		t.Errorf("UpdateSubscriptions() = %v while draining, want the subscription failed with %v", failed, errDraining)
+	}
+}
+
+func TestDrainSubscriptionsTimeout(t *testing.T) {
+	sub := newSlowSubscriber()
+	defer sub.Close()
+	defer close(sub.release)
+
+	s, conn := newTestSupervisor(t)
+	s.drainTimeout = 50 * time.Millisecond
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if failed, err := s.UpdateSubscriptions(context.Background(), newSlowChannel(ref, sub), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	go conn.publish(newTestEventMsg(t, "1"))
+	<-sub.received
+
+	start := time.Now()
+	if err := s.drainSubscriptions(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drainSubscriptions() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the drain took %v, want the drain timeout", elapsed)
+	}
+}
+
+func TestDrainOfflineBuffer(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.offlineBuffer = newOfflineBuffer(OfflineBuffer{MaxEvents: 10})
+	s.natssConn = nil
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	for _, id := range []string{"1", "2"} {
+		if err := sendOffline(s, ref, id); err != nil {
+			t.Fatalf("the event %s was refused: %v", id, err)
+		}
+	}
+
+	// The dispatcher stops once connected again: the buffered events are published before the
+	// connection closes.
+	conn := newFakeStanConn()
+	published := storedEvents(conn)
+	var natssConn stan.Conn = conn
+	s.natssConnMux.Lock()
+	s.natssConn = &natssConn
+	s.natssConnMux.Unlock()
+	s.drainOfflineBuffer(context.Background())
+	if diff := cmp.Diff([]string{"1", "2"}, published()); diff != "" {
+		t.Errorf("unexpected published events (-want, +got): %s", diff)
+	}
+	if status := s.OfflineBufferStatus(ref); status.Events != 0 {
+		t.Errorf("OfflineBufferStatus() = %+v once stopped, want no event", status)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// DurableRemover is implemented by the dispatchers able to delete the durable of a NATSS
+// subscription they no longer hold.
+type DurableRemover interface {
+	// RemoveDurable deletes the durable named durable on the subject of channel. It fails if the
+	// dispatcher currently holds the subscription.
+	RemoveDurable(channel eventingchannels.ChannelReference, durable string) error
+}
+
+var _ DurableRemover = (*SubscriptionsSupervisor)(nil)
+
+// DurableName returns the name of the durable of the NATSS subscription created for subscriber.
+func DurableName(subscriber eventingduckv1.SubscriberSpec) string {
+	ref := newSubscriptionReference(subscriber)
+	return ref.String()
+}
+
+// Subject returns the NATSS subject of the events of channel.
+func Subject(channel eventingchannels.ChannelReference) string {
+	return getSubject(channel)
+}
+
+// RemoveDurable implements DurableRemover. NATSS has no API to delete a durable, it is resumed
+// and then unsubscribed, which removes it from the server.
+func (s *SubscriptionsSupervisor) RemoveDurable(channel eventingchannels.ChannelReference, durable string) error {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+
+	uid, scheme := parseDurableName(durable)
+	if _, ok := s.subscriptions[channel][uid]; ok {
+		return fmt.Errorf("durable %q of channel %v is in use", durable, channel)
+	}
+	if _, ok := s.paused[uid]; ok {
+		return fmt.Errorf("durable %q of channel %v is paused", durable, channel)
+	}
+	if err := s.removeDurable(channel, durable); err != nil {
+		return err
+	}
+	if named, ok := s.namedDurables[uid]; ok && named.Channel == channel && named.Scheme == scheme {
+		delete(s.namedDurables, uid)
+	}
+	return nil
+}
+
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) removeDurable(channel eventingchannels.ChannelReference, durable string) error {
+	return s.removeQueueDurable(channel, "", durable)
+}
+
+// removeQueueDurable removes the durable of the queue group group, the durable of a plain
+// subscription when group is empty.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) removeQueueDurable(channel eventingchannels.ChannelReference, group, durable string) error {
+	currentNatssConn, err := s.connection(context.Background(), channel)
+	if err != nil {
+		return err
+	}
+
+	// The messages delivered before unsubscribing are not acknowledged, and dropped along with the durable.
+	opts := []stan.SubscriptionOption{stan.DurableName(durable), stan.SetManualAckMode(), stan.MaxInflight(1)}
+	var sub stan.Subscription
+	if group == "" {
+		sub, err = (*currentNatssConn).Subscribe(getSubject(channel), func(*stan.Msg) {}, opts...)
+	} else {
+		sub, err = (*currentNatssConn).QueueSubscribe(getSubject(channel), group, func(*stan.Msg) {}, opts...)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to resume durable %q of channel %v", durable, channel)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		return errors.Wrapf(err, "failed to remove durable %q of channel %v", durable, channel)
+	}
+	s.cursors.forget(channel, durable)
+	s.logger.Info("Removed durable", zap.String("channel", channel.String()), zap.String("durable", durable))
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/uuid"
+
+	"knative.dev/eventing-natss/pkg/probe"
+)
+
+// e2eProbeSource is the source of the events of the end to end probe.
+const e2eProbeSource = "/natss-ch-dispatcher/e2e-probe"
+
+// E2EProber is implemented by the dispatchers serving the subscriber of the end to end probe on
+// probe.Path of their receiver.
+type E2EProber interface {
+	// ProbeE2E sends an event to target, the address of a probe channel, and returns how long it
+	// took to reach the probe subscriber, failing when ctx is done first.
+	ProbeE2E(ctx context.Context, target *url.URL) (time.Duration, error)
+}
+
+var _ E2EProber = (*SubscriptionsSupervisor)(nil)
+
+// ProbeE2E implements E2EProber. The event goes through the Service of the channel, the receiver,
+// NATSS and a subscription, like any other. The probe subscriber must be served by this
+// dispatcher for the arrival to be seen.
+func (s *SubscriptionsSupervisor) ProbeE2E(ctx context.Context, target *url.URL) (time.Duration, error) {
+	e := event.New()
+	e.SetID(uuid.New().String())
+	e.SetType(probe.EventType)
+	e.SetSource(e2eProbeSource)
+	arrived := make(chan time.Time, 1)
+	s.e2eProbes.Store(e.ID(), arrived)
+	defer s.e2eProbes.Delete(e.ID())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := cehttp.WriteRequest(ctx, binding.ToMessage(&e), req); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := s.warmUpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send the probe event: %w", err)
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("the channel answered the probe event with the status code %d", resp.StatusCode)
+	}
+
+	select {
+	case at := <-arrived:
+		return at.Sub(start), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("the probe event did not reach the probe subscriber: %w", ctx.Err())
+	}
+}
+
+// withE2EProbe returns a handler serving the probe subscriber on probe.Path, and passing the
+// other requests to next. The events of the probes of other dispatchers are accepted and ignored.
+func (s *SubscriptionsSupervisor) withE2EProbe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != probe.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		at := time.Now()
+		e, err := binding.ToEvent(r.Context(), cehttp.NewMessageFromHttpRequest(r))
+		if err != nil || e.Type() != probe.EventType {
+			http.Error(w, "not a probe event", http.StatusBadRequest)
+			return
+		}
+		if value, ok := s.e2eProbes.Load(e.ID()); ok {
+			select {
+			case value.(chan time.Time) <- at:
+			default:
+				// Redelivered.
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"knative.dev/eventing-natss/pkg/probe"
+)
+
+// newProbeChannel returns a server standing for a probe channel and its dispatcher: the events it
+// accepts are delivered to probe.Path when deliver is true.
+func newProbeChannel(t *testing.T, s *SubscriptionsSupervisor, deliver bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(s.withE2EProbe(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if deliver {
+			header := r.Header.Clone()
+			go func() {
+				req, _ := http.NewRequest(http.MethodPost, server.URL+probe.Path, bytes.NewReader(body))
+				req.Header = header
+				if resp, err := http.DefaultClient.Do(req); err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProbeE2E(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	target, _ := url.Parse(newProbeChannel(t, s, true).URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	latency, err := s.ProbeE2E(ctx, target)
+	if err != nil {
+		t.Fatalf("ProbeE2E() = %v", err)
+	}
+	if latency <= 0 {
+		t.Errorf("ProbeE2E() latency = %v, want a positive latency", latency)
+	}
+}
+
+func TestProbeE2ELost(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	target, _ := url.Parse(newProbeChannel(t, s, false).URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.ProbeE2E(ctx, target); err == nil || !strings.Contains(err.Error(), "did not reach") {
+		t.Errorf("ProbeE2E() = %v, want the event not reaching the probe subscriber", err)
+	}
+}
+
+func TestProbeE2ERefused(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer channel.Close()
+	target, _ := url.Parse(channel.URL)
+
+	if _, err := s.ProbeE2E(context.Background(), target); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("ProbeE2E() = %v, want the status code 503", err)
+	}
+}
+
+func TestE2EProbeSubscriber(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	server := newProbeChannel(t, s, false)
+
+	tests := map[string]struct {
+		method string
+		body   string
+		header http.Header
+		want   int
+	}{
+		"not a POST": {
+			method: http.MethodGet,
+			want:   http.StatusMethodNotAllowed,
+		},
+		"not an event": {
+			method: http.MethodPost,
+			body:   "{}",
+			want:   http.StatusBadRequest,
+		},
+		"not a probe event": {
+			method: http.MethodPost,
+			header: http.Header{
+				"Ce-Specversion": {"1.0"},
+				"Ce-Id":          {"1"},
+				"Ce-Type":        {"dev.knative.test"},
+				"Ce-Source":      {"test"},
+			},
+			want: http.StatusBadRequest,
+		},
+		"event of another probe": {
+			method: http.MethodPost,
+			header: http.Header{
+				"Ce-Specversion": {"1.0"},
+				"Ce-Id":          {"unknown"},
+				"Ce-Type":        {probe.EventType},
+				"Ce-Source":      {"test"},
+			},
+			want: http.StatusAccepted,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, server.URL+probe.Path, strings.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() = %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("status code = %d, want %d", resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+const (
+	// egressRebalanceInterval is how often the egress rates are shared again between the
+	// channels, following their demand.
+	egressRebalanceInterval = 100 * time.Millisecond
+	// egressHeadroom is the factor of the demand of the channels using less than their rate
+	// they are given, for their demand to grow until the next rebalance.
+	egressHeadroom = 1.5
+	// egressIdleRebalances is how many rebalances a channel must go without deliveries before
+	// its bucket is dropped, about a minute.
+	egressIdleRebalances = 600
+)
+
+// EgressLimits bounds the deliveries of the dispatcher across all its channels, each of which
+// gets a share of the limits following its demand. A zero limit is disabled.
+type EgressLimits struct {
+	// EventsPerSecond is how many events are delivered per second, the retries of a delivery
+	// aside.
+	EventsPerSecond int
+	// BytesPerSecond is how many bytes of events are delivered per second.
+	BytesPerSecond int64
+}
+
+// enabled tells whether l limits the deliveries.
+func (l EgressLimits) enabled() bool {
+	return l.EventsPerSecond > 0 || l.BytesPerSecond > 0
+}
+
+// EgressShareSetter is implemented by the dispatchers able to guarantee some channels a minimum
+// share of their egress limits.
+type EgressShareSetter interface {
+	// SetEgressMinShare sets the fraction of the egress limits of the dispatcher, between 0 and
+	// 1, channel is guaranteed while it delivers as much. The guarantees adding up to more than
+	// the limits are scaled down, and a channel delivering less leaves the rest to the others.
+	SetEgressMinShare(channel eventingchannels.ChannelReference, share float64)
+}
+
+var _ EgressShareSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetEgressMinShare implements EgressShareSetter.
+func (s *SubscriptionsSupervisor) SetEgressMinShare(channel eventingchannels.ChannelReference, share float64) {
+	s.egress.setMinShare(channel, share)
+}
+
+// tokenBucket is a rate of tokens which may be taken into debt: a take succeeds while the bucket
+// holds some tokens, whatever it takes, the next ones waiting for the debt to be paid back. The
+// events larger than what the bucket holds are so delivered at the rate of the bucket.
+type tokenBucket struct {
+	// rate is the number of tokens per second, negative when unlimited.
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last refill, holding up to a rebalance interval of
+// them.
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 && !b.last.IsZero() {
+		b.tokens = math.Min(b.tokens+b.rate*now.Sub(b.last).Seconds(), b.rate*egressRebalanceInterval.Seconds())
+	}
+	b.last = now
+}
+
+// wait returns how long to wait before taking from the bucket, zero when it can be taken now.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	switch {
+	case b.rate < 0 || b.tokens > 0:
+		return 0
+	case b.rate == 0:
+		return egressRebalanceInterval
+	default:
+		// Rounded up, for the bucket to hold some tokens once waited.
+		return time.Duration(-b.tokens/b.rate*float64(time.Second)) + time.Millisecond
+	}
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate >= 0 {
+		b.tokens -= n
+	}
+}
+
+// egressBucket is the share of the egress limits of a channel, and its demand since the last
+// rebalance.
+type egressBucket struct {
+	mu     sync.Mutex
+	events tokenBucket
+	bytes  tokenBucket
+	// requestedEvents and requestedBytes are what the channel asked to deliver.
+	requestedEvents float64
+	requestedBytes  float64
+	// waiting is the number of deliveries waiting for tokens, and throttled tells some had to.
+	waiting   int
+	throttled bool
+	// idle is the number of rebalances without deliveries.
+	idle int
+}
+
+// egressLimiter shares the egress limits of the dispatcher between its channels with a token
+// bucket per channel, so that the deliveries of different channels never contend, whose rates
+// are rebalanced every egressRebalanceInterval following the demand of the channels.
+type egressLimiter struct {
+	// enabled tells whether the limits are enabled, checked before any bucket is taken.
+	enabled int32
+
+	// mu serializes the rebalances, and guards limits, lastRebalance and the creation of the
+	// buckets.
+	mu            sync.Mutex
+	limits        EgressLimits
+	lastRebalance time.Time
+	buckets       sync.Map
+	// minShares holds the guaranteed share of the channels setting one, kept while the channel
+	// has no bucket.
+	minShares sync.Map
+}
+
+func newEgressLimiter(limits EgressLimits) *egressLimiter {
+	l := &egressLimiter{}
+	l.setLimits(limits)
+	return l
+}
+
+// setLimits replaces the limits, shared again between the channels at once.
+func (l *egressLimiter) setLimits(limits EgressLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limits == l.limits {
+		return
+	}
+	l.limits = limits
+	if limits.enabled() {
+		atomic.StoreInt32(&l.enabled, 1)
+	} else {
+		atomic.StoreInt32(&l.enabled, 0)
+	}
+	l.rebalanceLocked()
+}
+
+func (l *egressLimiter) setMinShare(channel eventingchannels.ChannelReference, share float64) {
+	if share <= 0 {
+		l.minShares.Delete(channel)
+		return
+	}
+	l.minShares.Store(channel, math.Min(share, 1))
+}
+
+func (l *egressLimiter) minShare(channel eventingchannels.ChannelReference) float64 {
+	if share, ok := l.minShares.Load(channel); ok {
+		return share.(float64)
+	}
+	return 0
+}
+
+// bucket returns the bucket of channel. A new bucket is given its share at once.
+func (l *egressLimiter) bucket(channel eventingchannels.ChannelReference) *egressBucket {
+	if b, ok := l.buckets.Load(channel); ok {
+		return b.(*egressBucket)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets.Load(channel); ok {
+		return b.(*egressBucket)
+	}
+	// Until its demand is known, the channel is as demanding as the busiest.
+	b := &egressBucket{throttled: true}
+	l.buckets.Store(channel, b)
+	l.rebalanceLocked()
+	return b
+}
+
+// acquire waits for channel to be allowed to deliver an event of size bytes, or for ctx to be
+// done. The channels deliver at the rate of their share of the limits, the events of a channel
+// being held with their worker, and so their ack, while it waits.
+func (l *egressLimiter) acquire(ctx context.Context, channel eventingchannels.ChannelReference, size int64) {
+	if atomic.LoadInt32(&l.enabled) == 0 {
+		return
+	}
+	b := l.bucket(channel)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requestedEvents++
+	b.requestedBytes += float64(size)
+	for {
+		now := time.Now()
+		wait := b.events.wait(now)
+		if w := b.bytes.wait(now); w > wait {
+			wait = w
+		}
+		if wait == 0 {
+			b.events.take(1)
+			b.bytes.take(float64(size))
+			return
+		}
+		// The rates change on rebalance.
+		if wait > egressRebalanceInterval {
+			wait = egressRebalanceInterval
+		}
+		b.throttled = true
+		b.waiting++
+		b.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		b.mu.Lock()
+		b.waiting--
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// run rebalances the rates of the channels every egressRebalanceInterval until ctx is done.
+func (l *egressLimiter) run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(egressRebalanceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.mu.Lock()
+				l.rebalanceLocked()
+				l.mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// rebalanceLocked shares the limits between the channels following their demand since the last
+// rebalance, and drops the buckets of the idle channels. It must be called holding mu.
+func (l *egressLimiter) rebalanceLocked() {
+	now := time.Now()
+	period := now.Sub(l.lastRebalance)
+	if l.lastRebalance.IsZero() || period <= 0 {
+		period = egressRebalanceInterval
+	}
+	l.lastRebalance = now
+	var channels []interface{}
+	var buckets []*egressBucket
+	var events, bytes []egressDemand
+	l.buckets.Range(func(channel, value interface{}) bool {
+		b := value.(*egressBucket)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.requestedEvents == 0 && b.waiting == 0 {
+			b.idle++
+		} else {
+			b.idle = 0
+		}
+		if b.idle >= egressIdleRebalances {
+			l.buckets.Delete(channel)
+			return true
+		}
+		share := l.minShare(channel.(eventingchannels.ChannelReference))
+		saturated := b.throttled || b.waiting > 0
+		events = append(events, egressDemand{minShare: share, demand: b.requestedEvents / period.Seconds(), saturated: saturated})
+		bytes = append(bytes, egressDemand{minShare: share, demand: b.requestedBytes / period.Seconds(), saturated: saturated})
+		b.requestedEvents, b.requestedBytes, b.throttled = 0, 0, false
+		channels = append(channels, channel)
+		buckets = append(buckets, b)
+		return true
+	})
+	eventRates := shareEgress(float64(l.limits.EventsPerSecond), events)
+	byteRates := shareEgress(float64(l.limits.BytesPerSecond), bytes)
+	for i, b := range buckets {
+		b.mu.Lock()
+		// The tokens accrued at the previous rates are kept.
+		b.events.refill(now)
+		b.bytes.refill(now)
+		b.events.rate, b.bytes.rate = eventRates[i], byteRates[i]
+		b.mu.Unlock()
+	}
+}
+
+// egressDemand is the demand of a channel for one of the egress limits.
+type egressDemand struct {
+	// minShare is the fraction of the limit the channel is guaranteed.
+	minShare float64
+	// demand is what the channel asked for per second.
+	demand float64
+	// saturated tells the channel asked for more than its rate.
+	saturated bool
+}
+
+// shareEgress shares the limit, per second, between the channels: each gets its guaranteed share
+// first, up to its demand, the rest being shared equally between the channels asking for more,
+// none getting more than it needs, and what is left over equally between all of them. The
+// unsaturated channels need their demand and some headroom, the saturated ones all they can get.
+// A limit of zero or less returns unlimited rates.
+func shareEgress(limit float64, channels []egressDemand) []float64 {
+	rates := make([]float64, len(channels))
+	if limit <= 0 {
+		for i := range rates {
+			rates[i] = -1
+		}
+		return rates
+	}
+	if len(channels) == 0 {
+		return rates
+	}
+	needs := make([]float64, len(channels))
+	minShares := 0.0
+	for i, c := range channels {
+		needs[i] = math.Inf(1)
+		if !c.saturated {
+			needs[i] = c.demand * egressHeadroom
+		}
+		minShares += c.minShare
+	}
+	scale := 1.0
+	if minShares > 1 {
+		scale = 1 / minShares
+	}
+	left := limit
+	for i, c := range channels {
+		rates[i] = math.Min(c.minShare*scale*limit, needs[i])
+		needs[i] -= rates[i]
+		left -= rates[i]
+	}
+	// Water-filling from the least needy channel.
+	order := make([]int, len(channels))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return needs[order[a]] < needs[order[b]] })
+	for k, i := range order {
+		given := math.Min(needs[i], left/float64(len(order)-k))
+		rates[i] += given
+		left -= given
+	}
+	for i := range rates {
+		rates[i] += left / float64(len(rates))
+	}
+	return rates
+}
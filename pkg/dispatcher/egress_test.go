@@ -0,0 +1,195 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestShareEgress(t *testing.T) {
+	inf := math.Inf(1)
+	testCases := map[string]struct {
+		limit    float64
+		channels []egressDemand
+		want     []float64
+	}{
+		"unlimited": {
+			limit:    0,
+			channels: []egressDemand{{saturated: true}, {demand: 10}},
+			want:     []float64{-1, -1},
+		},
+		"saturated channels share equally": {
+			limit:    100,
+			channels: []egressDemand{{saturated: true}, {saturated: true}},
+			want:     []float64{50, 50},
+		},
+		"quiet channel gets its demand": {
+			limit:    100,
+			channels: []egressDemand{{saturated: true}, {demand: 10}},
+			want:     []float64{85, 15},
+		},
+		"leftover shared": {
+			limit:    100,
+			channels: []egressDemand{{demand: 10}, {demand: 20}},
+			want:     []float64{15 + 27.5, 30 + 27.5},
+		},
+		"min share": {
+			limit:    100,
+			channels: []egressDemand{{saturated: true, minShare: 0.6}, {saturated: true}},
+			want:     []float64{80, 20},
+		},
+		"min share above demand": {
+			limit:    100,
+			channels: []egressDemand{{saturated: true}, {demand: 10, minShare: 0.5}},
+			want:     []float64{85, 15},
+		},
+		"min shares scaled down": {
+			limit:    100,
+			channels: []egressDemand{{saturated: true, minShare: 0.9}, {saturated: true, minShare: 0.9}, {saturated: true}},
+			want:     []float64{50, 50, 0},
+		},
+		"infinite demand": {
+			limit:    90,
+			channels: []egressDemand{{demand: inf}, {saturated: true}, {saturated: true}},
+			want:     []float64{30, 30, 30},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got := shareEgress(tc.limit, tc.channels)
+			for i := range tc.want {
+				if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+					t.Errorf("shareEgress() = %v, want %v", got, tc.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestEgressLimiterDisabled(t *testing.T) {
+	l := newEgressLimiter(EgressLimits{})
+	l.acquire(context.Background(), eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}, 1<<20)
+	if _, ok := l.buckets.Load(eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}); ok {
+		t.Error("a bucket was made while the limits are disabled")
+	}
+}
+
+// egressFlood delivers on channel from workers goroutines, each waiting interval between its
+// deliveries, until ctx is done, counting the deliveries made.
+func egressFlood(ctx context.Context, wg *sync.WaitGroup, l *egressLimiter, channel eventingchannels.ChannelReference, workers int, interval time.Duration, size int64, delivered *int64) {
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				l.acquire(ctx, channel, size)
+				if ctx.Err() == nil {
+					atomic.AddInt64(delivered, 1)
+				}
+				if interval > 0 {
+					select {
+					case <-time.After(interval):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+}
+
+func TestEgressFairness(t *testing.T) {
+	flooding := eventingchannels.ChannelReference{Namespace: "tenant-a", Name: "flooding"}
+	quiet := eventingchannels.ChannelReference{Namespace: "tenant-b", Name: "quiet"}
+	const duration = 2 * time.Second
+	testCases := map[string]struct {
+		limits EgressLimits
+		// quietInterval is the interval between the deliveries of each worker of the quiet channel,
+		// zero flooding it too.
+		quietInterval time.Duration
+		quietShare    float64
+		size          int64
+
+		// wantQuiet and wantFlooding are the shares of the limit the channels are expected to get.
+		wantQuiet    float64
+		wantFlooding float64
+	}{
+		"both flooding": {
+			limits:       EgressLimits{EventsPerSecond: 400},
+			wantQuiet:    0.5,
+			wantFlooding: 0.5,
+		},
+		"quiet channel": {
+			limits: EgressLimits{EventsPerSecond: 400},
+			// 4 workers asking for 40 events per second each.
+			quietInterval: 25 * time.Millisecond,
+			wantQuiet:     0.4,
+			wantFlooding:  0.6,
+		},
+		"min share": {
+			limits:     EgressLimits{EventsPerSecond: 400},
+			quietShare: 0.75,
+			// The guaranteed share, and half of the rest.
+			wantQuiet:    0.875,
+			wantFlooding: 0.125,
+		},
+		"bytes": {
+			limits:       EgressLimits{BytesPerSecond: 400 << 10},
+			size:         1 << 10,
+			wantQuiet:    0.5,
+			wantFlooding: 0.5,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			l := newEgressLimiter(tc.limits)
+			l.setMinShare(quiet, tc.quietShare)
+			ctx, cancel := context.WithTimeout(context.Background(), duration)
+			defer cancel()
+			l.run(ctx)
+
+			var wg sync.WaitGroup
+			var floodingDelivered, quietDelivered int64
+			// The flooding channel has many more deliveries in flight than the quiet one.
+			egressFlood(ctx, &wg, l, flooding, 32, 0, tc.size, &floodingDelivered)
+			egressFlood(ctx, &wg, l, quiet, 4, tc.quietInterval, tc.size, &quietDelivered)
+			wg.Wait()
+
+			// Each delivery is of an event of size bytes, or of an event.
+			limit, per := float64(tc.limits.EventsPerSecond), 1.0
+			if tc.size > 0 {
+				limit, per = float64(tc.limits.BytesPerSecond), float64(tc.size)
+			}
+			total := limit * duration.Seconds() / per
+			gotFlooding, gotQuiet := float64(floodingDelivered)/total, float64(quietDelivered)/total
+			t.Logf("flooding %d, quiet %d deliveries out of %.0f", floodingDelivered, quietDelivered, total)
+			if gotFlooding+gotQuiet > 1.15 {
+				t.Errorf("delivered %.2f of the limit, want at most the limit", gotFlooding+gotQuiet)
+			}
+			if math.Abs(gotQuiet-tc.wantQuiet) > 0.1 || math.Abs(gotFlooding-tc.wantFlooding) > 0.1 {
+				t.Errorf("shares flooding %.2f and quiet %.2f, want %.2f and %.2f", gotFlooding, gotQuiet, tc.wantFlooding, tc.wantQuiet)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// encryptedMagic prefixes the encrypted messages. It cannot start a JSON CloudEvent, telling the
+// encrypted messages from the ones published before the encryption was enabled.
+var encryptedMagic = []byte("\x00natss-encrypted/v1\n")
+
+// dataKeySize is the size of the AES-256 key generated to encrypt each message.
+const dataKeySize = 32
+
+// encryptedHeader precedes the ciphertext of an encrypted message.
+type encryptedHeader struct {
+	// KeyID is the ID of the key wrapping the data key.
+	KeyID string `json:"kid"`
+	// WrappedKey is the data key encrypted with the key KeyID, prefixed with its nonce.
+	WrappedKey []byte `json:"key"`
+	// Nonce is the nonce of the ciphertext.
+	Nonce []byte `json:"nonce"`
+}
+
+// Keyring holds the keys of a channel, named after their ID.
+type Keyring struct {
+	// currentKeyID is the ID of the key encrypting the new messages, the other ones only decrypting.
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+	// err is why the keys are unavailable.
+	err error
+}
+
+// NewKeyring returns the Keyring of the AES keys, encrypting with the key currentKeyID.
+func NewKeyring(keys map[string][]byte, currentKeyID string) (*Keyring, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("key %q not found", currentKeyID)
+	}
+	k := &Keyring{currentKeyID: currentKeyID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %q", id)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// NewUnavailableKeyring returns a Keyring failing with err, standing for the keys of a channel
+// which could not be loaded so that its messages are not published unencrypted.
+func NewUnavailableKeyring(err error) *Keyring {
+	return &Keyring{err: err}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts data with a new data key, wrapped with the current key.
+func (k *Keyring) Encrypt(data []byte) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyAEAD := k.keys[k.currentKeyID]
+	keyNonce, err := newNonce(keyAEAD)
+	if err != nil {
+		return nil, err
+	}
+	header := encryptedHeader{
+		KeyID: k.currentKeyID,
+		// The key ID is authenticated along with the data key.
+		WrappedKey: keyAEAD.Seal(keyNonce, keyNonce, dataKey, []byte(k.currentKeyID)),
+	}
+	if header.Nonce, err = newNonce(dataAEAD); err != nil {
+		return nil, err
+	}
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(encryptedMagic)
+	if err := binary.Write(&out, binary.BigEndian, uint32(len(rawHeader))); err != nil {
+		return nil, err
+	}
+	out.Write(rawHeader)
+	out.Write(dataAEAD.Seal(nil, header.Nonce, data, rawHeader))
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts data encrypted by Encrypt with any of the keys.
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	in := bytes.NewReader(data[len(encryptedMagic):])
+	var headerLen uint32
+	if err := binary.Read(in, binary.BigEndian, &headerLen); err != nil || int64(headerLen) > int64(in.Len()) {
+		return nil, errors.New("truncated encryption header")
+	}
+	rawHeader := make([]byte, headerLen)
+	if _, err := io.ReadFull(in, rawHeader); err != nil {
+		return nil, err
+	}
+	var header encryptedHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, errors.Wrap(err, "invalid encryption header")
+	}
+
+	keyAEAD, ok := k.keys[header.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", header.KeyID)
+	}
+	if len(header.WrappedKey) < keyAEAD.NonceSize() {
+		return nil, errors.New("truncated data key")
+	}
+	nonceSize := keyAEAD.NonceSize()
+	dataKey, err := keyAEAD.Open(nil, header.WrappedKey[:nonceSize], header.WrappedKey[nonceSize:], []byte(header.KeyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unwrap the data key with key %q", header.KeyID)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.Nonce) != dataAEAD.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	ciphertext := data[len(data)-in.Len():]
+	return dataAEAD.Open(nil, header.Nonce, ciphertext, rawHeader)
+}
+
+func newNonce(aead cipher.AEAD) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	return nonce, err
+}
+
+// publishEncrypted publishes message on subject, serialized like the natsscloudevents.Sender
+// does with transformers and then encrypted with keys.
+func publishEncrypted(ctx context.Context, conn stan.Conn, subject string, message binding.Message, keys *Keyring, transformers ...binding.Transformer) (err error) {
+	defer func() {
+		if ferr := message.Finish(err); ferr != nil && err == nil {
+			err = ferr
+		}
+	}()
+
+	encrypted, err := encodeMsg(ctx, message, keys, transformers...)
+	if err != nil {
+		return err
+	}
+	return conn.Publish(subject, encrypted)
+}
+
+// isEncrypted tells whether data was encrypted by a Keyring.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// EncryptionKeySetter is implemented by the dispatchers able to encrypt the stored messages.
+type EncryptionKeySetter interface {
+	// SetEncryptionKeys sets the keys of a channel, a nil Keyring disables the encryption of
+	// the new messages.
+	SetEncryptionKeys(channel eventingchannels.ChannelReference, keys *Keyring)
+}
+
+var _ EncryptionKeySetter = (*SubscriptionsSupervisor)(nil)
+
+// SetEncryptionKeys implements EncryptionKeySetter.
+func (s *SubscriptionsSupervisor) SetEncryptionKeys(channel eventingchannels.ChannelReference, keys *Keyring) {
+	if keys == nil {
+		s.keyrings.Delete(channel)
+		return
+	}
+	s.keyrings.Store(channel, keys)
+}
+
+// keyring returns the keys of channel, nil when its messages are not encrypted.
+func (s *SubscriptionsSupervisor) keyring(channel eventingchannels.ChannelReference) *Keyring {
+	if keys, ok := s.keyrings.Load(channel); ok {
+		return keys.(*Keyring)
+	}
+	return nil
+}
+
+// decrypt returns the message received on channel with its data decrypted. The messages
+// published before the encryption was enabled are returned unchanged.
+func (s *SubscriptionsSupervisor) decrypt(channel eventingchannels.ChannelReference, stanMsg *stan.Msg) (*stan.Msg, error) {
+	if !isEncrypted(stanMsg.Data) {
+		return stanMsg, nil
+	}
+	keys := s.keyring(channel)
+	if keys == nil {
+		return nil, errors.New("encrypted message received on a channel without encryption keys")
+	}
+	data, err := keys.Decrypt(stanMsg.Data)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := *stanMsg
+	decrypted.Data = data
+	return &decrypted, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestKeyring(t *testing.T) {
+	plaintext := []byte(`{"specversion":"1.0","id":"1"}`)
+	old, err := NewKeyring(map[string][]byte{"k1": key1}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring() = %v", err)
+	}
+	encrypted, err := old.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	if !isEncrypted(encrypted) || bytes.Contains(encrypted, plaintext) {
+		t.Fatalf("the message is not encrypted: %q", encrypted)
+	}
+
+	// After a rotation, the messages are encrypted with the new key and the old key still decrypts.
+	rotated, err := NewKeyring(map[string][]byte{"k1": key1, "k2": key2}, "k2")
+	if err != nil {
+		t.Fatalf("NewKeyring() = %v", err)
+	}
+	if got, err := rotated.Decrypt(encrypted); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() after rotation = %q, %v, want %q", got, err, plaintext)
+	}
+	reencrypted, err := rotated.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	if _, err := old.Decrypt(reencrypted); err == nil {
+		t.Error("the keyring without the new key decrypted a message encrypted with it")
+	}
+
+	// Once the old key is dropped, its messages can no longer be read.
+	dropped, err := NewKeyring(map[string][]byte{"k2": key2}, "k2")
+	if err != nil {
+		t.Fatalf("NewKeyring() = %v", err)
+	}
+	if _, err := dropped.Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() succeeded without the key")
+	}
+	if got, err := dropped.Decrypt(reencrypted); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() = %q, %v, want %q", got, err, plaintext)
+	}
+}
+
+func TestKeyringWrongKey(t *testing.T) {
+	right, _ := NewKeyring(map[string][]byte{"k1": key1}, "k1")
+	wrong, _ := NewKeyring(map[string][]byte{"k1": bytes.Repeat([]byte{9}, 32)}, "k1")
+	encrypted, err := right.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	if _, err := wrong.Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() succeeded with the wrong key")
+	}
+
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := right.Decrypt(tampered); err == nil {
+		t.Error("Decrypt() succeeded on a tampered message")
+	}
+	if _, err := right.Decrypt(encrypted[:len(encryptedMagic)+2]); err == nil {
+		t.Error("Decrypt() succeeded on a truncated message")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	if _, err := NewKeyring(map[string][]byte{"k1": key1}, "k2"); err == nil {
+		t.Error("NewKeyring() succeeded without the current key")
+	}
+	if _, err := NewKeyring(map[string][]byte{"k1": []byte("short")}, "k1"); err == nil {
+		t.Error("NewKeyring() succeeded with an invalid AES key")
+	}
+
+	unavailable := NewUnavailableKeyring(errors.New("secret not found"))
+	if _, err := unavailable.Encrypt([]byte("data")); err == nil {
+		t.Error("Encrypt() succeeded with unavailable keys")
+	}
+}
+
+func TestEncryptedChannel(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	keys, _ := NewKeyring(map[string][]byte{"k1": key1}, "k1")
+	s.SetEncryptionKeys(ref, keys)
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	// The messages are stored encrypted and delivered decrypted.
+	var stored [][]byte
+	conn.subs = append(conn.subs, &fakeStanSubscription{conn: conn, cb: func(msg *stan.Msg) {
+		stored = append(stored, msg.Data)
+	}})
+	e := event.New()
+	e.SetID("encrypted")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	if err := messageReceiverFunc(s)(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{}); err != nil {
+		t.Fatalf("failed to publish the event: %v", err)
+	}
+	if len(stored) != 1 || !isEncrypted(stored[0]) {
+		t.Fatalf("stored %q, want an encrypted message", stored)
+	}
+
+	// The messages published before the encryption was enabled are still readable.
+	conn.publish(newTestEventMsg(t, "legacy"))
+
+	// The messages encrypted with an unknown key are not delivered.
+	other, _ := NewKeyring(map[string][]byte{"k2": key2}, "k2")
+	unknown, err := other.Encrypt(stored[0])
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	conn.publish(&stan.Msg{MsgProto: pb.MsgProto{Data: unknown}})
+
+	want := []string{"encrypted", "legacy"}
+	if got := subscriber.received(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
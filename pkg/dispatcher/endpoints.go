@@ -0,0 +1,186 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/retry"
+)
+
+var (
+	// endpointCheckTimeout bounds the resolution of the host of a subscriber and the connection
+	// to it.
+	endpointCheckTimeout = 5 * time.Second
+
+	// endpointRecheckBackoff is the delay before the first check again of an unreachable
+	// endpoint, doubled after every failure up to endpointRecheckMaxBackoff.
+	endpointRecheckBackoff    = 5 * time.Second
+	endpointRecheckMaxBackoff = 5 * time.Minute
+)
+
+// EndpointValidator is implemented by the dispatchers checking that the subscribers of the new
+// subscriptions are reachable, so that the typos in their URI show before the events fail.
+type EndpointValidator interface {
+	// WatchEndpoints sets the function called when the endpoint of a subscription of channel
+	// becomes unreachable or reachable again, nil removing it.
+	WatchEndpoints(channel eventingchannels.ChannelReference, notify func())
+	// UnreachableEndpoint returns why the subscriber of subscription is unreachable, nil when it
+	// is reachable or not checked yet.
+	UnreachableEndpoint(subscription types.UID) error
+}
+
+var _ EndpointValidator = (*SubscriptionsSupervisor)(nil)
+
+// endpointCheck checks the endpoint of a subscription in the background, again with a backoff
+// until it is reachable.
+type endpointCheck struct {
+	// uri is the endpoint checked.
+	uri    string
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// setResult records the result of a check, returning whether the endpoint became unreachable or
+// reachable again.
+func (c *endpointCheck) setResult(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := (err == nil) != (c.err == nil)
+	c.err = err
+	return changed
+}
+
+// WatchEndpoints implements EndpointValidator.
+func (s *SubscriptionsSupervisor) WatchEndpoints(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.endpointNotifiers.Delete(channel)
+		return
+	}
+	s.endpointNotifiers.Store(channel, notify)
+}
+
+// UnreachableEndpoint implements EndpointValidator.
+func (s *SubscriptionsSupervisor) UnreachableEndpoint(subscription types.UID) error {
+	v, ok := s.endpointChecks.Load(subscription)
+	if !ok {
+		return nil
+	}
+	c := v.(*endpointCheck)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (s *SubscriptionsSupervisor) notifyEndpoint(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.endpointNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// checkEndpoint checks in the background that the subscriber of subscription of channel is
+// reachable, unless it was checked already.
+func (s *SubscriptionsSupervisor) checkEndpoint(channel eventingchannels.ChannelReference, subscriber eventingduckv1.SubscriberSpec) {
+	if subscriber.SubscriberURI.IsEmpty() {
+		s.stopEndpointCheck(subscriber.UID)
+		return
+	}
+	u := s.preferHTTPS(subscriber.SubscriberURI.URL())
+	if v, ok := s.endpointChecks.Load(subscriber.UID); ok && v.(*endpointCheck).uri == u.String() {
+		return
+	}
+	s.stopEndpointCheck(subscriber.UID)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &endpointCheck{uri: u.String(), cancel: cancel}
+	s.endpointChecks.Store(subscriber.UID, c)
+	// The backoff is read here, the check running in the background.
+	policy := retry.Policy{
+		Initial:    endpointRecheckBackoff,
+		Max:        endpointRecheckMaxBackoff,
+		Multiplier: 2,
+	}
+	go s.runEndpointCheck(ctx, channel, subscriber.UID, u, c, policy)
+}
+
+// stopEndpointCheck stops and forgets the check of the endpoint of subscription.
+func (s *SubscriptionsSupervisor) stopEndpointCheck(subscription types.UID) {
+	if v, ok := s.endpointChecks.Load(subscription); ok {
+		v.(*endpointCheck).cancel()
+		s.endpointChecks.Delete(subscription)
+	}
+}
+
+// runEndpointCheck checks the endpoint u of subscription until it is reachable, backing off
+// between the checks following policy, or ctx is done.
+func (s *SubscriptionsSupervisor) runEndpointCheck(ctx context.Context, channel eventingchannels.ChannelReference, subscription types.UID, u *url.URL, c *endpointCheck, policy retry.Policy) {
+	_ = retry.Do(ctx, policy, func(ctx context.Context) error {
+		err := s.reachEndpoint(ctx, u)
+		if ctx.Err() != nil {
+			return retry.Permanent(ctx.Err())
+		}
+		if c.setResult(err) {
+			if err != nil {
+				s.subscriptionsLogger.Warn("The subscriber is unreachable", zap.String("channel", channel.String()),
+					zap.String("subscription", string(subscription)), zap.Error(err))
+			} else {
+				s.subscriptionsLogger.Info("The subscriber is reachable again", zap.String("channel", channel.String()),
+					zap.String("subscription", string(subscription)))
+			}
+			s.notifyEndpoint(channel)
+		}
+		return err
+	})
+}
+
+// reachEndpoint resolves the host of u and, with endpointConnectCheck, connects to it.
+func (s *SubscriptionsSupervisor) reachEndpoint(ctx context.Context, u *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, endpointCheckTimeout)
+	defer cancel()
+
+	// The errors of the resolution and of the connection name the host.
+	host := u.Hostname()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return err
+	}
+	if !s.endpointConnectCheck {
+		return nil
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+// withEndpointRecheckBackoff makes the endpoints checked again after backoff, until the test
+// ends.
+func withEndpointRecheckBackoff(t *testing.T, backoff time.Duration) {
+	previous, previousMax := endpointRecheckBackoff, endpointRecheckMaxBackoff
+	endpointRecheckBackoff, endpointRecheckMaxBackoff = backoff, backoff
+	t.Cleanup(func() {
+		endpointRecheckBackoff, endpointRecheckMaxBackoff = previous, previousMax
+	})
+}
+
+// newEndpointChannel subscribes a channel whose subscriber is at host, the returned counter
+// counting the notifications of the changes of its reachability.
+func newEndpointChannel(t *testing.T, s *SubscriptionsSupervisor, host string) (*messagingv1.Channel, *int32) {
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchEndpoints(ref, func() { atomic.AddInt32(&notified, 1) })
+	channel := &messagingv1.Channel{}
+	channel.Namespace, channel.Name = ref.Namespace, ref.Name
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{UID: "uid-0", SubscriberURI: apis.HTTP(host)}}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	return channel, &notified
+}
+
+func waitForEndpoint(t *testing.T, s *SubscriptionsSupervisor, uid types.UID, reachable bool) error {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := s.UnreachableEndpoint(uid); (err == nil) == reachable {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the endpoint of %s did not become reachable: %t", uid, reachable)
+	return nil
+}
+
+func TestEndpointUnresolvable(t *testing.T) {
+	s, conn := newTestSupervisor(t)
+	channel, notified := newEndpointChannel(t, s, "subscriber.invalid")
+
+	err := waitForEndpoint(t, s, "uid-0", false)
+	if !strings.Contains(err.Error(), "subscriber.invalid") {
+		t.Errorf("UnreachableEndpoint() = %v, want an error naming the host", err)
+	}
+	if n := atomic.LoadInt32(notified); n != 1 {
+		t.Errorf("notified %d times, want 1", n)
+	}
+	// The durable keeps the events until the endpoint appears.
+	if len(conn.subs) != 1 {
+		t.Errorf("got %d subscriptions, want 1", len(conn.subs))
+	}
+
+	// The check stops with the subscription.
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if err := s.UnreachableEndpoint("uid-0"); err != nil {
+		t.Errorf("UnreachableEndpoint() = %v after the unsubscription, want nil", err)
+	}
+}
+
+func TestEndpointConnectionRefused(t *testing.T) {
+	withEndpointRecheckBackoff(t, 10*time.Millisecond)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// Without the connect check, resolving the host is enough.
+	s, _ := newTestSupervisor(t)
+	newEndpointChannel(t, s, addr)
+	time.Sleep(50 * time.Millisecond)
+	if err := s.UnreachableEndpoint("uid-0"); err != nil {
+		t.Errorf("UnreachableEndpoint() = %v without the connect check, want nil", err)
+	}
+
+	s, _ = newTestSupervisor(t)
+	s.endpointConnectCheck = true
+	_, notified := newEndpointChannel(t, s, addr)
+	err = waitForEndpoint(t, s, "uid-0", false)
+	if !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("UnreachableEndpoint() = %v, want the connection to be refused", err)
+	}
+
+	// The endpoint is checked again until it appears.
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to listen on %s again: %v", addr, err)
+	}
+	defer listener.Close()
+	waitForEndpoint(t, s, "uid-0", true)
+	if n := atomic.LoadInt32(notified); n != 2 {
+		t.Errorf("notified %d times, want 2", n)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// EphemeralSetter is implemented by the dispatchers able to subscribe without a durable. The
+// ephemeral subscriptions get the events published while the dispatcher holds them only, and
+// acknowledge them whatever the outcome of the delivery, so NATSS never redelivers them.
+type EphemeralSetter interface {
+	// SetEphemeral sets the subscriptions of channel made without a durable, by UID. It must be
+	// called before updating the subscriptions of the channel to take effect, which makes again
+	// the subscriptions switching between durable and ephemeral, removing the durable of those
+	// made ephemeral.
+	SetEphemeral(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool)
+	// Ephemeral tells whether the dispatcher holds the subscription of channel without a durable.
+	Ephemeral(channel eventingchannels.ChannelReference, subscription types.UID) bool
+}
+
+var _ EphemeralSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetEphemeral implements EphemeralSetter.
+func (s *SubscriptionsSupervisor) SetEphemeral(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool) {
+	if len(subscriptions) == 0 {
+		s.ephemeral.Delete(channel)
+		return
+	}
+	s.ephemeral.Store(channel, subscriptions)
+}
+
+// Ephemeral implements EphemeralSetter.
+func (s *SubscriptionsSupervisor) Ephemeral(channel eventingchannels.ChannelReference, subscription types.UID) bool {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	return s.subscribedEphemeral[channel][subscription]
+}
+
+// ephemeralSubscriptions returns the subscriptions of channel to make without a durable when its
+// events are distributed with distribution. The members of a work queue share its durable, and
+// are never ephemeral.
+func (s *SubscriptionsSupervisor) ephemeralSubscriptions(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution) map[types.UID]bool {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return nil
+	}
+	if subscriptions, ok := s.ephemeral.Load(channel); ok {
+		return subscriptions.(map[types.UID]bool)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestEphemeralSwitch(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+	durables := func() []string {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		var durables []string
+		for _, sub := range conn.subs {
+			durables = append(durables, sub.durable)
+		}
+		return durables
+	}
+
+	update()
+	if got := durables(); len(got) != 1 || got[0] == "" {
+		t.Fatalf("subscriptions with the durables %q, want a durable subscription", got)
+	}
+
+	// The durable is removed, rather than closed, when the subscription is made ephemeral.
+	s.SetEphemeral(ref, map[types.UID]bool{uid: true})
+	update()
+	if got := durables(); len(got) != 1 || got[0] != "" {
+		t.Errorf("subscriptions with the durables %q, want an ephemeral subscription", got)
+	}
+	if len(conn.closed) != 0 {
+		t.Errorf("durables kept: %v", conn.closed)
+	}
+	if !s.Ephemeral(ref, uid) {
+		t.Error("Ephemeral() = false, want true")
+	}
+
+	// Updating again keeps the ephemeral subscription.
+	update()
+	if got := durables(); len(got) != 1 || got[0] != "" {
+		t.Errorf("subscriptions with the durables %q, want an ephemeral subscription", got)
+	}
+
+	// The reset of the finalized channels drops their entry.
+	s.SetEphemeral(ref, nil)
+	if got := s.ephemeralSubscriptions(ref, ""); len(got) != 0 {
+		t.Errorf("ephemeralSubscriptions() = %v after the reset, want none", got)
+	}
+	if _, ok := s.ephemeral.Load(ref); ok {
+		t.Error("the ephemeral subscriptions of the channel are kept after the reset")
+	}
+	update()
+	if got := durables(); len(got) != 1 || got[0] == "" {
+		t.Errorf("subscriptions with the durables %q, want a durable subscription", got)
+	}
+	if s.Ephemeral(ref, uid) {
+		t.Error("Ephemeral() = true, want false")
+	}
+}
+
+func TestEphemeralWorkQueue(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	s.SetDistribution(ref, v1beta1.DistributionWorkQueue)
+	s.SetEphemeral(ref, map[types.UID]bool{channel.Spec.Subscribers[0].UID: true})
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	// The members of a work queue share its durable.
+	members := conn.groups[getSubject(ref)]
+	if len(members) != 1 || members[0].durable != workQueueDurableName {
+		t.Errorf("queue group members = %+v, want a member of the durable queue group", members)
+	}
+	if s.Ephemeral(ref, channel.Spec.Subscribers[0].UID) {
+		t.Error("Ephemeral() = true, want false")
+	}
+}
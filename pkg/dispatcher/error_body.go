@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errorBody holds the beginning of the body of the last error response to a delivery. The message
+// dispatcher discards the bodies of the error responses, so they are recorded through the context
+// of the request.
+type errorBody struct {
+	data []byte
+	// truncated tells that the body was longer than data.
+	truncated bool
+}
+
+// errorBodyKey is the context key of the *errorBody of a delivery.
+type errorBodyKey struct{}
+
+// withErrorBody returns a context recording the error bodies of its requests.
+func withErrorBody(ctx context.Context) (context.Context, *errorBody) {
+	body := &errorBody{}
+	return context.WithValue(ctx, errorBodyKey{}, body), body
+}
+
+// text returns the body as a single line of printable text, empty when the body is not text.
+func (b *errorBody) text() string {
+	data := b.data
+	if b.truncated {
+		// The limit may cut a character in the middle.
+		for i := 1; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return ""
+	}
+	text := strings.Join(strings.FieldsFunc(string(data), func(r rune) bool {
+		return unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}), " ")
+	if text != "" && b.truncated {
+		text += "..."
+	}
+	return text
+}
+
+// describe returns the text of the body for the logs and statuses, telling the size of the
+// binary bodies instead.
+func (b *errorBody) describe() string {
+	if len(b.data) == 0 {
+		return ""
+	}
+	if text := b.text(); text != "" {
+		return text
+	}
+	if b.truncated {
+		return fmt.Sprintf("more than %d bytes of binary data", len(b.data))
+	}
+	return fmt.Sprintf("%d bytes of binary data", len(b.data))
+}
+
+// errorBodyTransport records up to limit bytes of the bodies of the error responses in the
+// errorBody of the context of the requests, leaving the bodies to be read whole.
+type errorBodyTransport struct {
+	base  http.RoundTripper
+	limit int64
+}
+
+var _ http.RoundTripper = (*errorBodyTransport)(nil)
+
+// RoundTrip implements http.RoundTripper.
+func (t *errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	body, ok := req.Context().Value(errorBodyKey{}).(*errorBody)
+	if !ok {
+		return resp, nil
+	}
+	// One byte more than the limit tells whether the body is truncated.
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, t.limit+1))
+	body.truncated = int64(len(data)) > t.limit
+	if body.truncated {
+		body.data = data[:t.limit]
+	} else {
+		body.data = data
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	return resp, nil
+}
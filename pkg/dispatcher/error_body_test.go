@@ -0,0 +1,195 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestErrorBodyDescribe(t *testing.T) {
+	testCases := map[string]struct {
+		body errorBody
+		want string
+	}{
+		"empty": {},
+		"text": {
+			body: errorBody{data: []byte(`{"error": "field \"id\" is required"}`)},
+			want: `{"error": "field \"id\" is required"}`,
+		},
+		"multi-line text": {
+			body: errorBody{data: []byte("invalid event:\n\tmissing id\r\n")},
+			want: "invalid event: missing id",
+		},
+		"control characters": {
+			body: errorBody{data: []byte("invalid\x1b[31m event\x07")},
+			want: "invalid [31m event",
+		},
+		"truncated text": {
+			body: errorBody{data: []byte("invalid event"), truncated: true},
+			want: "invalid event...",
+		},
+		"truncated in a character": {
+			body: errorBody{data: []byte("événement invalide \xc3"), truncated: true},
+			want: "événement invalide...",
+		},
+		"binary": {
+			body: errorBody{data: []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a}},
+			want: "8 bytes of binary data",
+		},
+		"text with NUL": {
+			body: errorBody{data: []byte("invalid\x00event")},
+			want: "13 bytes of binary data",
+		},
+		"truncated binary": {
+			body: errorBody{data: []byte{0xff, 0xfe, 0xfd, 0xfc}, truncated: true},
+			want: "more than 4 bytes of binary data",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got := tc.body.describe(); got != tc.want {
+				t.Errorf("describe() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestErrorBodyTransport(t *testing.T) {
+	long := strings.Repeat("x", 2048)
+	testCases := map[string]struct {
+		code          int
+		body          string
+		want          string
+		wantTruncated bool
+	}{
+		"error": {
+			code: http.StatusUnprocessableEntity,
+			body: "invalid event",
+			want: "invalid event",
+		},
+		"truncated error": {
+			code:          http.StatusInternalServerError,
+			body:          long,
+			want:          long[:1024],
+			wantTruncated: true,
+		},
+		"body at the limit": {
+			code: http.StatusBadRequest,
+			body: long[:1024],
+			want: long[:1024],
+		},
+		"success": {
+			code: http.StatusAccepted,
+			body: "accepted",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.code)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &errorBodyTransport{base: http.DefaultTransport, limit: 1024}}
+			ctx, body := withErrorBody(context.Background())
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() = %v", err)
+			}
+			defer resp.Body.Close()
+			if string(body.data) != tc.want || body.truncated != tc.wantTruncated {
+				t.Errorf("recorded %d bytes, truncated %t, want %d bytes, truncated %t", len(body.data), body.truncated, len(tc.want), tc.wantTruncated)
+			}
+			// The body is left whole to the client.
+			if got, _ := ioutil.ReadAll(resp.Body); string(got) != tc.body {
+				t.Errorf("read %d bytes of the body, want %d", len(got), len(tc.body))
+			}
+		})
+	}
+}
+
+func TestDeadLetterErrorData(t *testing.T) {
+	const limit = 16
+	testCases := map[string]struct {
+		body string
+		want string
+	}{
+		"short body": {
+			body: `{"error": "no"}`,
+			want: `{"error": "no"}`,
+		},
+		"truncated body": {
+			body: `{"error": "field \"id\" is required"}`,
+			want: `{"error": "field`,
+		},
+		"no body": {},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(tc.body))
+			}))
+			defer subscriber.Close()
+			dls := newDeadLetterRecorder()
+			defer dls.Close()
+
+			d, err := NewDispatcher(Args{ClientID: "test", ErrorBodyLimit: limit})
+			if err != nil {
+				t.Fatalf("NewDispatcher() = %v", err)
+			}
+			s := d.(*SubscriptionsSupervisor)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			result := s.deliver(context.Background(), ref, newTestMessage(), mustParseURL(t, subscriber.URL), nil, mustParseURL(t, dls.URL), nil)
+			if result.status != DeliveryStatusDeadLettered {
+				t.Fatalf("deliver() = %+v, want the event dead lettered", result)
+			}
+
+			got := dls.received()
+			if len(got) != 1 {
+				t.Fatalf("the dead letter sink received %d events, want 1", len(got))
+			}
+			data, ok := got[0].Extensions()[ErrorDataExtension]
+			if tc.want == "" {
+				if ok {
+					t.Errorf("%s = %v, want none", ErrorDataExtension, data)
+				}
+				return
+			}
+			decoded, err := base64.StdEncoding.DecodeString(data.(string))
+			if err != nil {
+				t.Fatalf("%s = %v is not base64: %v", ErrorDataExtension, data, err)
+			}
+			if string(decoded) != tc.want {
+				t.Errorf("%s = %q, want %q", ErrorDataExtension, decoded, tc.want)
+			}
+		})
+	}
+}
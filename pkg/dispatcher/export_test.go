@@ -0,0 +1,42 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// NewTestSupervisor returns a SubscriptionsSupervisor connected to an in-memory NATSS, for the
+// tests of the dispatcher_test package.
+func NewTestSupervisor(t *testing.T) *SubscriptionsSupervisor {
+	s, _ := newTestSupervisor(t)
+	return s
+}
+
+// Subscribed returns the UIDs of the subscribers of channel which s subscribed.
+func Subscribed(s *SubscriptionsSupervisor, channel eventingchannels.ChannelReference) []types.UID {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	var uids []types.UID
+	for uid := range s.subscriptions[channel] {
+		uids = append(uids, uid)
+	}
+	return uids
+}
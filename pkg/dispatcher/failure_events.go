@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// dispatchFailedReason is the reason of the events of the subscribers failing repeatedly.
+const dispatchFailedReason = "DispatchFailed"
+
+var (
+	// failureEventThreshold is the number of consecutive failed deliveries of a subscription
+	// recording an event on its channel.
+	failureEventThreshold = 3
+	// failureEventInterval is how often an event is recorded for the failed deliveries of a
+	// subscription.
+	failureEventInterval = time.Minute
+)
+
+// FailureEventRecorder is implemented by the dispatchers recording a Warning event on the
+// channels whose subscribers fail repeatedly.
+type FailureEventRecorder interface {
+	// SetFailureEventRecorder sets the recorder of the events of channel, recorded on object, nil
+	// removing it. An event is recorded once the deliveries to a subscriber of channel failed
+	// several times in a row, at most once a minute per subscription.
+	SetFailureEventRecorder(channel eventingchannels.ChannelReference, recorder record.EventRecorder, object runtime.Object)
+}
+
+var _ FailureEventRecorder = (*SubscriptionsSupervisor)(nil)
+
+// failureEventTarget is the recorder of the events of a channel, and the object they are
+// recorded on.
+type failureEventTarget struct {
+	recorder record.EventRecorder
+	object   runtime.Object
+}
+
+// subscriberFailures tracks the consecutive failed deliveries of a subscription.
+type subscriberFailures struct {
+	mu          sync.Mutex
+	consecutive int
+	lastEvent   time.Time
+}
+
+// SetFailureEventRecorder implements FailureEventRecorder.
+func (s *SubscriptionsSupervisor) SetFailureEventRecorder(channel eventingchannels.ChannelReference, recorder record.EventRecorder, object runtime.Object) {
+	if recorder == nil || object == nil {
+		s.failureEventTargets.Delete(channel)
+		return
+	}
+	s.failureEventTargets.Store(channel, &failureEventTarget{recorder: recorder, object: object})
+}
+
+// recordFailureEvent records the result of a delivery to the subscriber of subscription, and a
+// Warning event on channel once failureEventThreshold deliveries in a row failed, at most once
+// every failureEventInterval.
+func (s *SubscriptionsSupervisor) recordFailureEvent(channel eventingchannels.ChannelReference, subscription subscriptionReference, destination *url.URL, result deliveryResult) {
+	v, ok := s.failureEventTargets.Load(channel)
+	if !ok || destination == nil {
+		return
+	}
+	if result.status == DeliveryStatusDelivered {
+		if f, ok := s.subscriberFailures.Load(subscription.UID); ok {
+			f := f.(*subscriberFailures)
+			f.mu.Lock()
+			f.consecutive = 0
+			f.mu.Unlock()
+		}
+		return
+	}
+
+	f, _ := s.subscriberFailures.LoadOrStore(subscription.UID, &subscriberFailures{})
+	failures := f.(*subscriberFailures)
+	failures.mu.Lock()
+	failures.consecutive++
+	n := failures.consecutive
+	now := time.Now()
+	if n < failureEventThreshold || (!failures.lastEvent.IsZero() && now.Sub(failures.lastEvent) < failureEventInterval) {
+		failures.mu.Unlock()
+		return
+	}
+	failures.lastEvent = now
+	failures.mu.Unlock()
+
+	// The credentials of the subscriber are not shown.
+	subscriber := *destination
+	subscriber.User = nil
+	message := fmt.Sprintf("Dispatch to subscriber %s of subscription %s failed %d times in a row, last status: %s",
+		subscriber.String(), subscription.UID, n, describeStatus(result.code))
+	if result.response != "" {
+		message += ", last response: " + result.response
+	}
+	target := v.(*failureEventTarget)
+	target.recorder.Event(target.object, corev1.EventTypeWarning, dispatchFailedReason, message)
+}
+
+// describeStatus describes the status code of the response to a failed delivery.
+func describeStatus(code int) string {
+	if code == eventingchannels.NoResponse || code <= 0 {
+		return "no response"
+	}
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
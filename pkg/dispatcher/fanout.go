@@ -0,0 +1,161 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+)
+
+var (
+	// fanoutAmplificationM records, for each event published to a channel, the number of
+	// deliveries it makes: one per admitted subscriber of the channel, the retries aside.
+	fanoutAmplificationM = stats.Int64(
+		"natss_fanout_amplification",
+		"Number of outbound deliveries per inbound event of the channel",
+		stats.UnitDimensionless,
+	)
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: fanoutAmplificationM.Description(),
+			Measure:     fanoutAmplificationM,
+			Aggregation: view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500),
+			TagKeys:     []tag.Key{cardinality.NamespaceKey, cardinality.ChannelKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// FanoutLimitExceededError is the error of the subscribers of a channel refused because the
+// channel has more subscribers than its fan-out limit.
+type FanoutLimitExceededError struct {
+	// Subscribers is the number of subscribers of the channel.
+	Subscribers int
+	// Limit is the fan-out limit of the channel.
+	Limit int
+}
+
+func (e *FanoutLimitExceededError) Error() string {
+	return fmt.Sprintf("FanoutLimitExceeded: the channel has %d subscribers, only the first %d are subscribed", e.Subscribers, e.Limit)
+}
+
+// FanoutLimitSetter is implemented by the dispatchers able to limit the number of subscribers of
+// a channel they subscribe.
+type FanoutLimitSetter interface {
+	// SetFanoutLimit sets how many subscribers of channel are subscribed, zero lifting the limit.
+	// The subscribers are admitted in the order of the spec of the channel, the others failing
+	// with a *FanoutLimitExceededError when the channel is updated. The subscriptions of the
+	// subscribers refused once subscribed are closed, keeping their durables.
+	SetFanoutLimit(channel eventingchannels.ChannelReference, limit int)
+}
+
+var _ FanoutLimitSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetFanoutLimit implements FanoutLimitSetter.
+func (s *SubscriptionsSupervisor) SetFanoutLimit(channel eventingchannels.ChannelReference, limit int) {
+	if limit <= 0 {
+		s.fanoutLimits.Delete(channel)
+		return
+	}
+	s.fanoutLimits.Store(channel, limit)
+}
+
+// admitSubscribers returns the subscribers of channel admitted, and the errors of the others.
+// The orphaned subscribers paused are refused, then the fan-out limit of the channel admits the
+// first ones of the remaining subscribers. A subscriber listed twice counts once.
+func (s *SubscriptionsSupervisor) admitSubscribers(channel eventingchannels.ChannelReference, subscribers []eventingduckv1.SubscriberSpec) ([]eventingduckv1.SubscriberSpec, map[eventingduckv1.SubscriberSpec]error) {
+	refused := make(map[eventingduckv1.SubscriberSpec]error)
+	subscribers = s.refuseOrphaned(channel, subscribers, refused)
+	l, ok := s.fanoutLimits.Load(channel)
+	if !ok {
+		return subscribers, refused
+	}
+	limit := l.(int)
+	n := countSubscribers(subscribers)
+	if n <= limit {
+		return subscribers, refused
+	}
+
+	err := &FanoutLimitExceededError{Subscribers: n, Limit: limit}
+	admitted := make([]eventingduckv1.SubscriberSpec, 0, limit)
+	admittedUIDs := make(map[types.UID]bool, limit)
+	for _, sub := range subscribers {
+		if admittedUIDs[sub.UID] || len(admittedUIDs) < limit {
+			admittedUIDs[sub.UID] = true
+			admitted = append(admitted, sub)
+			continue
+		}
+		refused[sub] = err
+	}
+	return admitted, refused
+}
+
+// countSubscribers returns the number of subscribers, those listed twice counting once.
+func countSubscribers(subscribers []eventingduckv1.SubscriberSpec) int {
+	unique := make(map[types.UID]bool, len(subscribers))
+	for _, sub := range subscribers {
+		unique[sub.UID] = true
+	}
+	return len(unique)
+}
+
+// closeRefused closes the subscriptions of channel made before their subscriber was refused,
+// keeping their durables so that they resume where they stopped once admitted again.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) closeRefused(channel eventingchannels.ChannelReference, refused map[eventingduckv1.SubscriberSpec]error) {
+	for sub, err := range refused {
+		if _, ok := s.subscriptions[channel][sub.UID]; !ok {
+			continue
+		}
+		s.subscriptionsLogger.Info("Closing the refused subscription", zap.String("channel", channel.String()),
+			zap.String("subscription", string(sub.UID)), zap.Error(err))
+		s.closeSubscription(channel, sub.UID)
+		delete(s.subscribedEphemeral[channel], sub.UID)
+		s.health.Delete(sub.UID)
+		s.insecureDeliveries.Delete(sub.UID)
+		s.stopEndpointCheck(sub.UID)
+	}
+}
+
+// recordFanout records the deliveries of an event published to channel.
+func (s *SubscriptionsSupervisor) recordFanout(channel eventingchannels.ChannelReference) {
+	var fanout int64
+	if n, ok := s.fanout.Load(channel); ok {
+		fanout = int64(n.(int))
+	}
+	ctx, err := tag.New(context.Background(), cardinality.Tags(cardinality.Resource{Namespace: channel.Namespace, Channel: channel.Name})...)
+	if err != nil {
+		s.logger.Warn("Failed to tag the fan-out of the event", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, fanoutAmplificationM.M(fanout))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestFanoutLimit(t *testing.T) {
+	var subscribers []*eventRecorder
+	for i := 0; i < 3; i++ {
+		subscriber := newEventRecorder()
+		defer subscriber.Close()
+		subscribers = append(subscribers, subscriber)
+	}
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscribers...)
+	update := func(limit int) []types.UID {
+		t.Helper()
+		s.SetFanoutLimit(ref, limit)
+		failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+		if err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+		var refused []types.UID
+		for sub, err := range failed {
+			var exceeded *FanoutLimitExceededError
+			if !errors.As(err, &exceeded) || exceeded.Subscribers != 3 || exceeded.Limit != limit {
+				t.Errorf("subscriber %s failed with %v, want the fan-out limit of %d exceeded", sub.UID, err, limit)
+			}
+			refused = append(refused, sub.UID)
+		}
+		sort.Slice(refused, func(i, j int) bool { return refused[i] < refused[j] })
+		return refused
+	}
+
+	// The first subscribers of the spec are admitted.
+	if diff := cmp.Diff([]types.UID{"uid-2"}, update(2)); diff != "" {
+		t.Errorf("unexpected refused subscribers (-want, +got): %s", diff)
+	}
+	if len(conn.subs) != 2 {
+		t.Errorf("got %d subscriptions, want 2", len(conn.subs))
+	}
+	if n, _ := s.fanout.Load(ref); n != 2 {
+		t.Errorf("fan-out = %v, want 2", n)
+	}
+
+	// The subscription refused once the limit is lowered is closed, keeping its durable.
+	if diff := cmp.Diff([]types.UID{"uid-1", "uid-2"}, update(1)); diff != "" {
+		t.Errorf("unexpected refused subscribers (-want, +got): %s", diff)
+	}
+	if len(conn.subs) != 1 || len(conn.closed) != 1 {
+		t.Errorf("got %d subscriptions and %d closed durables, want 1 and 1", len(conn.subs), len(conn.closed))
+	}
+
+	// Lifting the limit resumes the closed durable.
+	if refused := update(0); len(refused) != 0 {
+		t.Errorf("subscribers %v refused without limit", refused)
+	}
+	if len(conn.subs) != 3 || len(conn.closed) != 0 {
+		t.Errorf("got %d subscriptions and %d closed durables, want 3 and 0", len(conn.subs), len(conn.closed))
+	}
+
+	// The fan-out of a deleted channel is forgotten.
+	if _, err := s.UpdateSubscriptions(context.Background(), channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, ok := s.fanout.Load(ref); ok {
+		t.Error("the fan-out of the deleted channel is still recorded")
+	}
+}
+
+func TestAdmitSubscribersListedTwice(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetFanoutLimit(ref, 2)
+	subscribers := []eventingduckv1.SubscriberSpec{{UID: "a"}, {UID: "b"}, {UID: "a", Generation: 2}, {UID: "c"}}
+
+	admitted, refused := s.admitSubscribers(ref, subscribers)
+	if diff := cmp.Diff(subscribers[:3], admitted); diff != "" {
+		t.Errorf("unexpected admitted subscribers (-want, +got): %s", diff)
+	}
+	if _, ok := refused[subscribers[3]]; len(refused) != 1 || !ok {
+		t.Errorf("refused = %v, want c alone", refused)
+	}
+}
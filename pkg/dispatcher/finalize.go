@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+// finalizeWorkers is how many subscriptions FinalizeChannels unsubscribes at once.
+const finalizeWorkers = 16
+
+// BatchFinalizer is implemented by the dispatchers able to finalize several channels at once,
+// such as the channels of a namespace being deleted.
+type BatchFinalizer interface {
+	// FinalizeChannels removes the subscriptions of channels and their durables, like
+	// UpdateSubscriptions does for a channel being deleted, the subscriptions of all the channels
+	// being unsubscribed in parallel. It returns the error of each channel whose subscriptions are
+	// not all removed, which is left to finalize again.
+	FinalizeChannels(ctx context.Context, channels []*messagingv1.Channel) map[eventingchannels.ChannelReference]error
+}
+
+var _ BatchFinalizer = (*SubscriptionsSupervisor)(nil)
+
+// FinalizeChannels implements BatchFinalizer.
+func (s *SubscriptionsSupervisor) FinalizeChannels(ctx context.Context, channels []*messagingv1.Channel) map[eventingchannels.ChannelReference]error {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	start := time.Now()
+
+	type pending struct {
+		channel      eventingchannels.ChannelReference
+		subscription types.UID
+		sub          stan.Subscription
+	}
+	var subs []pending
+	for _, channel := range channels {
+		cRef := eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
+		// The subscriptions of a hibernated channel are made again, for their durables to be
+		// removed.
+		s.keepHibernated(cRef, channel, true)
+		for uid, sub := range s.subscriptions[cRef] {
+			subs = append(subs, pending{channel: cRef, subscription: uid, sub: *sub})
+		}
+	}
+
+	errs := make([]error, len(subs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < finalizeWorkers && w < len(subs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = subs[i].sub.Unsubscribe()
+			}
+		}()
+	}
+	for i := range subs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	failed := make(map[eventingchannels.ChannelReference]error)
+	removed := make(map[eventingchannels.ChannelReference]map[types.UID]bool)
+	for i, p := range subs {
+		if errs[i] != nil {
+			s.subscriptionsLogger.Error("Unsubscribing NATSS Streaming subscription failed", zap.String("channel", p.channel.String()),
+				zap.String("subscription", string(p.subscription)), zap.Error(errs[i]))
+			failed[p.channel] = errs[i]
+			continue
+		}
+		s.forgetSubscription(p.channel, p.subscription)
+		if removed[p.channel] == nil {
+			removed[p.channel] = make(map[types.UID]bool)
+		}
+		removed[p.channel][p.subscription] = true
+	}
+	// The subscriptions failing to unsubscribe are kept for the next finalization to remove their
+	// durables, the other channels are finalized like UpdateSubscriptions does.
+	for _, channel := range channels {
+		cRef := eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
+		if _, ok := failed[cRef]; ok {
+			continue
+		}
+		if err := s.finalizeChannel(ctx, cRef, channel, removed[cRef]); err != nil {
+			failed[cRef] = err
+		}
+	}
+	s.recordActiveSubscriptions()
+	s.subscriptionsLogger.Info("Channels finalized", zap.Int("channels", len(channels)), zap.Int("subscriptions", len(subs)),
+		zap.Int("failed", len(failed)), zap.Duration("latency", time.Since(start)))
+	return failed
+}
+
+// finalizeChannel removes the subscriptions of channel, being deleted, and their durables, along
+// with the durables of the subscribers whose subscriptions the dispatcher does not hold, such as
+// those refused or never made since it started. The subscriptions in removed are already
+// unsubscribed. NATSS must be reachable: the channel is left to finalize again otherwise, and so
+// it is when a durable is not removed, rather than leaking its events to a channel recreated
+// with the same name.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) finalizeChannel(ctx context.Context, channel eventingchannels.ChannelReference, spec *messagingv1.Channel, removed map[types.UID]bool) error {
+	if _, err := s.connection(ctx, channel); err != nil {
+		return fmt.Errorf("failed to remove the durables of channel %v: %w", channel, err)
+	}
+
+	if removed == nil {
+		removed = make(map[types.UID]bool)
+	}
+	var errs []string
+	for uid := range s.subscriptions[channel] {
+		s.subscriptionsLogger.Info("Unsubscribing", zap.String("channel", channel.String()), zap.String("subscription", string(uid)), zap.String("reason", planner.ReasonFinalized))
+		if err := s.unsubscribe(channel, uid); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		removed[uid] = true
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove the durables of channel %v: %s", channel, strings.Join(errs, "; "))
+	}
+
+	distribution := s.distribution(channel)
+	// The durables of the paused subscriptions are removed along with their state, but the one
+	// of a work queue.
+	for uid, p := range s.paused {
+		if p.channel == channel && distribution != v1beta1.DistributionWorkQueue {
+			removed[uid] = true
+		}
+	}
+	s.forgetPaused(channel, nil)
+	ephemeral := s.ephemeralSubscriptions(channel, distribution)
+	consumers := s.consumerSubscriptions(channel, distribution)
+	for _, subscriber := range spec.Spec.Subscribers {
+		if removed[subscriber.UID] || ephemeral[subscriber.UID] {
+			continue
+		}
+		subscription := newSubscriptionReference(subscriber)
+		var err error
+		switch {
+		case distribution == v1beta1.DistributionWorkQueue:
+			// The members of a work queue share a single durable, removed once.
+			err = s.removeQueueDurable(channel, getSubject(channel), workQueueDurableName)
+			for _, member := range spec.Spec.Subscribers {
+				removed[member.UID] = true
+			}
+		case consumers[subscriber.UID] > 1:
+			err = s.removeSubscriptionDurables(channel, subscription, subscription.String())
+		default:
+			err = s.removeSubscriptionDurables(channel, subscription, "")
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove the durables of channel %v: %s", channel, strings.Join(errs, "; "))
+	}
+
+	s.unprovisioned.Delete(channel)
+	s.forgetChannel(channel)
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// assertNoDurables checks that conn holds neither a subscription nor a durable.
+func assertNoDurables(t *testing.T, conn *fakeStanConn) {
+	t.Helper()
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	members := 0
+	for _, group := range conn.groups {
+		members += len(group)
+	}
+	if len(conn.subs) != 0 || members != 0 || len(conn.closed) != 0 {
+		t.Errorf("got %d subscriptions, %d queue group members and the durables %v, want none", len(conn.subs), members, conn.closed)
+	}
+}
+
+func TestFinalizeChannels(t *testing.T) {
+	const channels, unsubscribeDelay = 50, 2 * time.Millisecond
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	// namespace subscribes the channels of a namespace being deleted.
+	namespace := func() (*SubscriptionsSupervisor, *fakeStanConn, []*messagingv1.Channel) {
+		s, conn := newTestSupervisor(t)
+		var chs []*messagingv1.Channel
+		for i := 0; i < channels; i++ {
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: fmt.Sprint("channel-", i)}
+			ch := newTestChannel(ref, subscriber, subscriber)
+			if failed, err := s.UpdateSubscriptions(context.Background(), ch, false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+			chs = append(chs, ch)
+		}
+		conn.unsubscribeDelay = unsubscribeDelay
+		return s, conn, chs
+	}
+
+	s, conn, chs := namespace()
+	start := time.Now()
+	for _, ch := range chs {
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+	}
+	serial := time.Since(start)
+	assertNoDurables(t, conn)
+
+	s, conn, chs = namespace()
+	start = time.Now()
+	if failed := s.FinalizeChannels(context.Background(), chs); len(failed) != 0 {
+		t.Fatalf("FinalizeChannels() = %v", failed)
+	}
+	batched := time.Since(start)
+	assertNoDurables(t, conn)
+	if n := len(s.subscriptions); n != 0 {
+		t.Errorf("the subscriptions of %d channels are held, want none", n)
+	}
+
+	t.Logf("finalized %d channels of 2 subscriptions in %v one by one and in %v at once", channels, serial, batched)
+	if batched > serial/2 {
+		t.Errorf("finalized the channels in %v at once, want less than half of the %v taken one by one", batched, serial)
+	}
+}
+
+func TestFinalizeChannelsFailure(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	var chs []*messagingv1.Channel
+	for _, name := range []string{"first", "second"} {
+		ch := newTestChannel(eventingchannels.ChannelReference{Namespace: "ns", Name: name}, subscriber)
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, false); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+		chs = append(chs, ch)
+	}
+
+	// The subscriptions failing to unsubscribe are kept to remove their durables later.
+	conn.mu.Lock()
+	conn.unsubscribeErr = errors.New("timeout")
+	conn.mu.Unlock()
+	if failed := s.FinalizeChannels(context.Background(), chs); len(failed) != 2 {
+		t.Errorf("FinalizeChannels() = %v, want both channels to fail", failed)
+	}
+	if len(conn.subs) != 2 {
+		t.Errorf("got %d subscriptions, want 2", len(conn.subs))
+	}
+	for _, ch := range chs {
+		ref := eventingchannels.ChannelReference{Namespace: ch.Namespace, Name: ch.Name}
+		if n := len(s.subscriptions[ref]); n != 1 {
+			t.Errorf("%s holds %d subscriptions, want 1", ref, n)
+		}
+	}
+
+	conn.mu.Lock()
+	conn.unsubscribeErr = nil
+	conn.mu.Unlock()
+	if failed := s.FinalizeChannels(context.Background(), chs); len(failed) != 0 {
+		t.Errorf("FinalizeChannels() = %v", failed)
+	}
+	assertNoDurables(t, conn)
+}
+
+func TestFinalizeChannel(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	t.Run("active subscriptions", func(t *testing.T) {
+		s, conn := newTestSupervisor(t)
+		ch := newTestChannel(ref, subscriber, subscriber)
+		if failed, err := s.UpdateSubscriptions(context.Background(), ch, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+		assertNoDurables(t, conn)
+		if _, ok := s.subscriptions[ref]; ok {
+			t.Error("the channel is still held once finalized")
+		}
+	})
+
+	t.Run("no subscription", func(t *testing.T) {
+		// The durables left by a previous dispatcher are removed, though never subscribed.
+		s, conn := newTestSupervisor(t)
+		ch := newTestChannel(ref, subscriber, subscriber)
+		conn.mu.Lock()
+		for _, sub := range ch.Spec.Subscribers {
+			conn.closed["/"+DurableName(sub)] = nil
+		}
+		conn.mu.Unlock()
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+		assertNoDurables(t, conn)
+
+		// A channel without subscriber has nothing to remove.
+		if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref), true); err != nil {
+			t.Errorf("UpdateSubscriptions() = %v", err)
+		}
+	})
+
+	t.Run("NATSS down", func(t *testing.T) {
+		s, conn := newTestSupervisor(t)
+		ch := newTestChannel(ref, subscriber)
+		if failed, err := s.UpdateSubscriptions(context.Background(), ch, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+
+		// The channel is left to finalize again while NATSS is unreachable.
+		natssConn := s.natssConn
+		s.natssConnMux.Lock()
+		s.natssConn = nil
+		s.natssConnMux.Unlock()
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err == nil {
+			t.Error("UpdateSubscriptions() = nil while disconnected, want an error")
+		}
+		s.natssConnMux.Lock()
+		s.natssConn = natssConn
+		s.natssConnMux.Unlock()
+		conn.mu.Lock()
+		conn.unsubscribeErr = errors.New("timeout")
+		conn.mu.Unlock()
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err == nil {
+			t.Error("UpdateSubscriptions() = nil while unsubscribing fails, want an error")
+		}
+		if n := len(s.subscriptions[ref]); n != 1 {
+			t.Errorf("the channel holds %d subscriptions, want 1 left to finalize again", n)
+		}
+
+		conn.mu.Lock()
+		conn.unsubscribeErr = nil
+		conn.mu.Unlock()
+		if _, err := s.UpdateSubscriptions(context.Background(), ch, true); err != nil {
+			t.Errorf("UpdateSubscriptions() = %v once NATSS is back", err)
+		}
+		assertNoDurables(t, conn)
+	})
+}
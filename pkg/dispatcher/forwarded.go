@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	forwardedHeader     = "Forwarded"
+	xForwardedForHeader = "X-Forwarded-For"
+)
+
+// clientAddressKey is the context key of the address of the client which sent an event.
+type clientAddressKey struct{}
+
+// ClientAddress returns the IP address of the client which sent the event received with ctx,
+// resolved through the trusted proxies, for example to key the limits of the producers.
+func ClientAddress(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(clientAddressKey{}).(string)
+	return addr, ok
+}
+
+// withClientAddress returns a handler recording the address of the client of the requests in
+// their context before calling handler.
+func withClientAddress(handler http.Handler, trusted []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if addr := clientAddress(req, trusted); addr != "" {
+			req = req.WithContext(context.WithValue(req.Context(), clientAddressKey{}, addr))
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// clientAddress returns the address of the client of req. The Forwarded header, or the
+// X-Forwarded-For header without it, is only honored when the peer is a trusted proxy: the
+// client is the last address of the header which is not a trusted proxy, counting from the peer.
+// The peer is returned when the header is missing or malformed, so that a client cannot be
+// attributed an address it made up.
+func clientAddress(req *http.Request, trusted []*net.IPNet) string {
+	peer := parseIP(req.RemoteAddr)
+	if peer == nil {
+		return ""
+	}
+	if !isTrusted(peer, trusted) {
+		return peer.String()
+	}
+
+	var hops []net.IP
+	var ok bool
+	if values := req.Header.Values(forwardedHeader); len(values) > 0 {
+		hops, ok = parseForwarded(values)
+	} else if values := req.Header.Values(xForwardedForHeader); len(values) > 0 {
+		hops, ok = parseXForwardedFor(values)
+	}
+	if !ok {
+		return peer.String()
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0 && isTrusted(client, trusted); i-- {
+		client = hops[i]
+	}
+	return client.String()
+}
+
+// parseForwarded returns the for= addresses of the elements of the Forwarded headers, in the
+// order of the hops, and false when one of them is missing or is not an IP address.
+func parseForwarded(values []string) ([]net.IP, bool) {
+	var hops []net.IP
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var hop net.IP
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+					continue
+				}
+				// Obfuscated identifiers and "unknown" are not addresses.
+				if hop = parseIP(strings.Trim(kv[1], `"`)); hop == nil {
+					return nil, false
+				}
+			}
+			if hop == nil {
+				return nil, false
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops, true
+}
+
+// parseXForwardedFor returns the addresses of the X-Forwarded-For headers, in the order of the
+// hops, and false when one of them is not an IP address.
+func parseXForwardedFor(values []string) ([]net.IP, bool) {
+	var hops []net.IP
+	for _, value := range values {
+		for _, raw := range strings.Split(value, ",") {
+			hop := parseIP(strings.TrimSpace(raw))
+			if hop == nil {
+				return nil, false
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops, true
+}
+
+// parseIP parses an IP address with an optional port, IPv6 addresses with a port being
+// bracketed, nil when addr is not an address. The zone of a link-local IPv6 address is ignored.
+func parseIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	return net.ParseIP(addr)
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/8"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		trusted = append(trusted, n)
+	}
+
+	testCases := map[string]struct {
+		peer   string
+		header http.Header
+		want   string
+	}{
+		"untrusted peer": {
+			peer: "203.0.113.7:4312",
+			want: "203.0.113.7",
+		},
+		"untrusted peer, spoofed headers": {
+			peer: "203.0.113.7:4312",
+			header: http.Header{
+				forwardedHeader:     {"for=198.51.100.1"},
+				xForwardedForHeader: {"198.51.100.1"},
+			},
+			want: "203.0.113.7",
+		},
+		"trusted peer without header": {
+			peer: "10.1.2.3:80",
+			want: "10.1.2.3",
+		},
+		"trusted peer, x-forwarded-for": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {"198.51.100.1, 10.4.5.6"}},
+			want:   "198.51.100.1",
+		},
+		"trusted peer, x-forwarded-for on several headers": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {"198.51.100.1", "10.4.5.6"}},
+			want:   "198.51.100.1",
+		},
+		"trusted peer, spoofed first hop": {
+			// The client prepended an address, the first untrusted hop from the peer is kept.
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {"192.0.2.66, 198.51.100.1"}},
+			want:   "198.51.100.1",
+		},
+		"trusted peer, forwarded": {
+			peer: "[fd00::1]:80",
+			header: http.Header{forwardedHeader: {
+				`for="[2001:db8::17]:4711";proto=https, for=10.4.5.6;by=10.1.2.3`,
+			}},
+			want: "2001:db8::17",
+		},
+		"trusted peer, forwarded takes precedence": {
+			peer: "10.1.2.3:80",
+			header: http.Header{
+				forwardedHeader:     {"For=198.51.100.1"},
+				xForwardedForHeader: {"198.51.100.2"},
+			},
+			want: "198.51.100.1",
+		},
+		"trusted peer, only trusted hops": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {"10.7.7.7, 10.4.5.6"}},
+			want:   "10.7.7.7",
+		},
+		"trusted peer, malformed x-forwarded-for": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {"198.51.100.1, not-an-ip"}},
+			want:   "10.1.2.3",
+		},
+		"trusted peer, obfuscated forwarded": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{forwardedHeader: {"for=_hidden"}},
+			want:   "10.1.2.3",
+		},
+		"trusted peer, forwarded without for": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{forwardedHeader: {"proto=https;by=10.1.2.3"}},
+			want:   "10.1.2.3",
+		},
+		"trusted peer, empty x-forwarded-for": {
+			peer:   "10.1.2.3:80",
+			header: http.Header{xForwardedForHeader: {""}},
+			want:   "10.1.2.3",
+		},
+		"malformed peer": {
+			peer: "pipe",
+			want: "",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local", nil)
+			req.RemoteAddr = tc.peer
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			if got := clientAddress(req, trusted); got != tc.want {
+				t.Errorf("clientAddress() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithClientAddress(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	var got string
+	var ok bool
+	handler := withClientAddress(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got, ok = ClientAddress(req.Context())
+	}), []*net.IPNet{trusted})
+
+	req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	req.Header.Set(xForwardedForHeader, "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !ok || got != "198.51.100.1" {
+		t.Errorf("ClientAddress() = %q, %v, want the forwarded client", got, ok)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HealthReporter is implemented by the dispatchers reporting their health to the liveness and
+// readiness probes of their pod.
+type HealthReporter interface {
+	// Health returns the health of the dispatcher.
+	Health() Health
+	// MarkChannelsSynced records that the channels existing when the dispatcher started were
+	// reconciled, their subscriptions made.
+	MarkChannelsSynced()
+}
+
+var _ HealthReporter = (*SubscriptionsSupervisor)(nil)
+
+// Health is the health of a dispatcher.
+type Health struct {
+	// Connected tells whether the connection to NATSS is made, and the subscriptions made again on
+	// it when the previous one was lost.
+	Connected bool
+	// DisconnectedSince is when the connection to NATSS was lost, or first attempted when it was
+	// never made, zero while connected or before the dispatcher starts.
+	DisconnectedSince time.Time
+	// ChannelsSynced tells whether the channels existing when the dispatcher started were
+	// reconciled.
+	ChannelsSynced bool
+	// Draining tells whether the dispatcher is stopping.
+	Draining bool
+	// StaleHostMapLoaded tells whether the host to channel map persisted by a previous run was
+	// loaded, the receiver routing the events with it until the channels are reconciled.
+	StaleHostMapLoaded bool
+	// OfflineBuffering tells whether the receiver buffers the events received while the
+	// connection to NATSS is down.
+	OfflineBuffering bool
+}
+
+// Health implements HealthReporter.
+func (s *SubscriptionsSupervisor) Health() Health {
+	s.natssConnMux.Lock()
+	health := Health{Connected: s.natssConn != nil && s.connectionLostAt.IsZero()}
+	if !health.Connected {
+		health.DisconnectedSince = s.connectionLostAt
+		if health.DisconnectedSince.IsZero() {
+			health.DisconnectedSince = s.connectingSince
+		}
+	}
+	s.natssConnMux.Unlock()
+	health.ChannelsSynced = atomic.LoadInt32(&s.channelsSynced) == 1
+	health.Draining = s.isDraining()
+	s.hostToChannelMapMux.Lock()
+	health.StaleHostMapLoaded = s.staleHostToChannelMapLoaded
+	s.hostToChannelMapMux.Unlock()
+	health.OfflineBuffering = s.offlineBuffer != nil
+	return health
+}
+
+// MarkChannelsSynced implements HealthReporter.
+func (s *SubscriptionsSupervisor) MarkChannelsSynced() {
+	atomic.StoreInt32(&s.channelsSynced, 1)
+}
+
+// markConnecting records when the dispatcher first attempts to connect to NATSS.
+func (s *SubscriptionsSupervisor) markConnecting() {
+	s.natssConnMux.Lock()
+	defer s.natssConnMux.Unlock()
+	if s.connectingSince.IsZero() {
+		s.connectingSince = time.Now()
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestHealth(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	if health := s.Health(); !health.Connected || !health.DisconnectedSince.IsZero() || health.ChannelsSynced || health.Draining {
+		t.Errorf("Health() = %+v, want connected only", health)
+	}
+
+	s.MarkChannelsSynced()
+	if health := s.Health(); !health.ChannelsSynced {
+		t.Errorf("Health() = %+v, want the channels synced", health)
+	}
+
+	// Lost, the connection is down until the subscriptions are made again.
+	s.natssConnectionLost(s.connKey, errors.New("lost"))
+	health := s.Health()
+	if health.Connected || health.DisconnectedSince.IsZero() {
+		t.Errorf("Health() = %+v, want disconnected", health)
+	}
+	s.connectionRestored()
+	if health := s.Health(); !health.Connected || !health.DisconnectedSince.IsZero() {
+		t.Errorf("Health() = %+v, want connected", health)
+	}
+
+	if err := s.drainSubscriptions(context.Background()); err != nil {
+		t.Fatalf("drainSubscriptions() = %v", err)
+	}
+	if health := s.Health(); !health.Draining {
+		t.Errorf("Health() = %+v, want draining", health)
+	}
+}
+
+func TestHealthReceiverFallbacks(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	if health := s.Health(); health.StaleHostMapLoaded || health.OfflineBuffering {
+		t.Errorf("Health() = %+v, want neither the stale host map nor the offline buffer", health)
+	}
+	s.LoadStaleHostToChannelMap(map[string]eventingchannels.ChannelReference{})
+	s.offlineBuffer = newOfflineBuffer(OfflineBuffer{MaxEvents: 1})
+	if health := s.Health(); !health.StaleHostMapLoaded || !health.OfflineBuffering {
+		t.Errorf("Health() = %+v, want the stale host map and the offline buffer", health)
+	}
+}
+
+func TestHealthNeverConnected(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.natssConn = nil
+	if health := s.Health(); health.Connected || !health.DisconnectedSince.IsZero() {
+		t.Errorf("Health() before connecting = %+v, want disconnected since never", health)
+	}
+
+	// Disconnected since the first attempt, the next ones leaving it alone.
+	s.markConnecting()
+	since := s.Health().DisconnectedSince
+	if since.IsZero() || time.Since(since) > time.Minute {
+		t.Errorf("DisconnectedSince = %v, want now", since)
+	}
+	s.markConnecting()
+	if got := s.Health().DisconnectedSince; !got.Equal(since) {
+		t.Errorf("DisconnectedSince = %v after another attempt, want %v", got, since)
+	}
+}
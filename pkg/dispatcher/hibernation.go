@@ -0,0 +1,276 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+)
+
+const (
+	// wakeUpReasonEvent is the reason of the wake ups caused by an event sent to the channel.
+	wakeUpReasonEvent = "event"
+	// wakeUpReasonSubscribers is the reason of the wake ups caused by a change of the subscribers.
+	wakeUpReasonSubscribers = "subscribers"
+)
+
+var (
+	// wakeUpLatencyM records the time taken to make the subscriptions of a hibernated channel
+	// again, from the event or the change of subscribers waking it up.
+	wakeUpLatencyM = stats.Float64(
+		"hibernation_wake_up_latency",
+		"Latency of the wake up of a hibernated channel",
+		stats.UnitMilliseconds,
+	)
+
+	// wakeUpReasonKey tells whether a channel was woken up by an event or by its subscribers.
+	wakeUpReasonKey = tag.MustNewKey("reason")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: wakeUpLatencyM.Description(),
+			Measure:     wakeUpLatencyM,
+			Aggregation: view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+			TagKeys:     []tag.Key{wakeUpReasonKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// Hibernator is implemented by the dispatchers closing the subscriptions of the idle channels.
+type Hibernator interface {
+	// WatchHibernation sets the function called when channel hibernates or wakes up, nil removing it.
+	WatchHibernation(channel eventingchannels.ChannelReference, notify func())
+	// HibernatedSince returns when channel hibernated, and false when it is awake.
+	HibernatedSince(channel eventingchannels.ChannelReference) (time.Time, bool)
+}
+
+var _ Hibernator = (*SubscriptionsSupervisor)(nil)
+
+// subscribedChannel is the last version of a channel the subscriptions were updated with.
+type subscribedChannel struct {
+	ctx     context.Context
+	channel *messagingv1.Channel
+}
+
+// hibernatedChannel is a channel whose subscriptions are closed, their durables being kept by
+// NATSS so that the subscriptions resume where they stopped when they are made again.
+type hibernatedChannel struct {
+	subscribedChannel
+	since  time.Time
+	waking int32
+}
+
+// WatchHibernation implements Hibernator.
+func (s *SubscriptionsSupervisor) WatchHibernation(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.hibernationNotifiers.Delete(channel)
+		return
+	}
+	s.hibernationNotifiers.Store(channel, notify)
+}
+
+// HibernatedSince implements Hibernator.
+func (s *SubscriptionsSupervisor) HibernatedSince(channel eventingchannels.ChannelReference) (time.Time, bool) {
+	if h, ok := s.hibernatedChannel(channel); ok {
+		return h.since, true
+	}
+	return time.Time{}, false
+}
+
+func (s *SubscriptionsSupervisor) hibernatedChannel(channel eventingchannels.ChannelReference) (*hibernatedChannel, bool) {
+	h, ok := s.hibernated.Load(channel)
+	if !ok {
+		return nil, false
+	}
+	return h.(*hibernatedChannel), true
+}
+
+func (s *SubscriptionsSupervisor) notifyHibernation(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.hibernationNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// touch records that an event of channel was received or delivered.
+func (s *SubscriptionsSupervisor) touch(channel eventingchannels.ChannelReference) {
+	if s.hibernationThreshold <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	if last, ok := s.activity.Load(channel); ok {
+		atomic.StoreInt64(last.(*int64), now)
+		return
+	}
+	s.activity.Store(channel, &now)
+}
+
+// lastActivity returns when an event of channel was last received or delivered, and false when
+// none was since the dispatcher started.
+func (s *SubscriptionsSupervisor) lastActivity(channel eventingchannels.ChannelReference) (time.Time, bool) {
+	last, ok := s.activity.Load(channel)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, atomic.LoadInt64(last.(*int64))), true
+}
+
+// runHibernation hibernates the idle channels until ctx is done. It does nothing when the
+// hibernation is disabled.
+func (s *SubscriptionsSupervisor) runHibernation(ctx context.Context) {
+	if s.hibernationThreshold <= 0 {
+		return
+	}
+	go func() {
+		// The channels hibernate within a quarter of the threshold after it is reached.
+		ticker := time.NewTicker(s.hibernationThreshold / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.hibernateIdle(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// hibernateIdle closes the subscriptions of the channels without events for longer than the
+// hibernation threshold.
+func (s *SubscriptionsSupervisor) hibernateIdle(now time.Time) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	defer s.recordActiveSubscriptions()
+
+	for channel, subs := range s.subscriptions {
+		last, ok := s.lastActivity(channel)
+		if !ok {
+			// The idle time is counted from the first check after a restart.
+			s.touch(channel)
+			continue
+		}
+		subscribed, ok := s.subscribedChannels[channel]
+		if !ok || now.Sub(last) < s.hibernationThreshold {
+			continue
+		}
+
+		s.subscriptionsLogger.Info("Hibernating idle channel", zap.String("channel", channel.String()), zap.Time("lastActivity", last))
+		for uid, sub := range subs {
+			// Closing, unlike unsubscribing, keeps the durable and its position.
+			s.stopMigration(uid, false)
+			if err := (*sub).Close(); err != nil {
+				s.subscriptionsLogger.Error("Closing NATSS Streaming subscription failed", zap.String("channel", channel.String()),
+					zap.String("subscription", string(uid)), zap.Error(err))
+			}
+			s.cursors.close(channel, uid, false)
+		}
+		delete(s.subscriptions, channel)
+		delete(s.subscribedDistributions, channel)
+		delete(s.subscribedEphemeral, channel)
+		delete(s.subscribedOptions, channel)
+		h := &hibernatedChannel{subscribedChannel: subscribed, since: now}
+		s.hibernated.Store(channel, h)
+		s.notifyHibernation(channel)
+
+		// An event stored while the subscriptions were closing found the channel awake.
+		if current, _ := s.lastActivity(channel); current.After(last) {
+			s.wakeUpAsync(channel, h, wakeUpReasonEvent, current)
+		}
+	}
+}
+
+// wakeUpOnEvent wakes channel up when it is hibernated. It must be called once the event received
+// is stored, so that the subscriptions made again receive it.
+func (s *SubscriptionsSupervisor) wakeUpOnEvent(channel eventingchannels.ChannelReference, received time.Time) {
+	s.touch(channel)
+	if h, ok := s.hibernatedChannel(channel); ok {
+		s.wakeUpAsync(channel, h, wakeUpReasonEvent, received)
+	}
+}
+
+// wakeUpAsync wakes the hibernated channel up in the background, without delaying the sender of
+// the event. The concurrent calls for the same hibernation are coalesced.
+func (s *SubscriptionsSupervisor) wakeUpAsync(channel eventingchannels.ChannelReference, h *hibernatedChannel, reason string, start time.Time) {
+	if !atomic.CompareAndSwapInt32(&h.waking, 0, 1) {
+		return
+	}
+	go func() {
+		s.subscriptionsMux.Lock()
+		defer s.subscriptionsMux.Unlock()
+		// The channel may have been woken up by a change of its subscribers meanwhile.
+		if current, ok := s.hibernatedChannel(channel); ok && current == h {
+			s.wakeUp(channel, h, reason, start)
+		}
+	}()
+}
+
+// wakeUp makes the subscriptions of the hibernated channel again, resuming from their durables.
+// The subscriptions failing are made again by the next update of the channel, which the
+// notification of the wake up triggers.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) wakeUp(channel eventingchannels.ChannelReference, h *hibernatedChannel, reason string, start time.Time) {
+	s.hibernated.Delete(channel)
+	s.touch(channel)
+	failed, _ := s.updateSubscriptions(h.ctx, channel, h.channel, false)
+	for sub, err := range failed {
+		s.subscriptionsLogger.Error("Failed to subscribe on wake up", zap.String("channel", channel.String()),
+			zap.String("subscription", string(sub.UID)), zap.Error(err))
+	}
+	latency := time.Since(start)
+	s.subscriptionsLogger.Info("Woke hibernated channel up", zap.String("channel", channel.String()),
+		zap.String("reason", reason), zap.Duration("latency", latency))
+	recordWakeUp(reason, latency)
+	s.notifyHibernation(channel)
+}
+
+// keepHibernated tells whether the update of channel leaves it hibernated, which is the case
+// when its subscribers did not change. Otherwise the channel is woken up, so that the durables
+// of the removed subscribers are deleted by the update.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) keepHibernated(cRef eventingchannels.ChannelReference, channel *messagingv1.Channel, isFinalizer bool) bool {
+	h, ok := s.hibernatedChannel(cRef)
+	if !ok {
+		return false
+	}
+	if !isFinalizer && equality.Semantic.DeepEqual(h.channel.Spec.Subscribers, channel.Spec.Subscribers) {
+		return true
+	}
+	s.wakeUp(cRef, h, wakeUpReasonSubscribers, time.Now())
+	return false
+}
+
+func recordWakeUp(reason string, latency time.Duration) {
+	ctx, err := tag.New(context.Background(), tag.Insert(wakeUpReasonKey, reason))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, wakeUpLatencyM.M(float64(latency)/float64(time.Millisecond)))
+}
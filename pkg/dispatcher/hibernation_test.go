@@ -0,0 +1,150 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// newHibernatedChannel subscribes subscribers to a channel and hibernates it.
+func newHibernatedChannel(t *testing.T, subscribers ...*eventRecorder) (*SubscriptionsSupervisor, *fakeStanConn, eventingchannels.ChannelReference, *int32) {
+	s, conn := newTestSupervisor(t)
+	s.hibernationThreshold = time.Hour
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchHibernation(ref, func() { atomic.AddInt32(&notified, 1) })
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscribers...), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	now := time.Now()
+	// The idle time is counted from the first check.
+	s.hibernateIdle(now)
+	if _, hibernated := s.HibernatedSince(ref); hibernated {
+		t.Fatal("the channel hibernated at the first check, want it to wait for the threshold")
+	}
+	s.hibernateIdle(now.Add(2 * time.Hour))
+	if _, hibernated := s.HibernatedSince(ref); !hibernated {
+		t.Fatal("the channel did not hibernate once idle for longer than the threshold")
+	}
+	if len(conn.subs) != 0 {
+		t.Fatalf("%d open subscriptions, want them to be closed", len(conn.subs))
+	}
+	if got := atomic.LoadInt32(&notified); got != 1 {
+		t.Errorf("notified %d times, want 1", got)
+	}
+	return s, conn, ref, &notified
+}
+
+func waitForWakeUp(t *testing.T, s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, hibernated := s.HibernatedSince(ref); !hibernated {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the channel did not wake up")
+}
+
+func TestHibernationWakeUpOnEvent(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, _, ref, notified := newHibernatedChannel(t, subscriber)
+
+	// The updates leaving the subscribers unchanged keep the channel hibernated.
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if _, hibernated := s.HibernatedSince(ref); !hibernated {
+		t.Fatal("the channel woke up without event nor change of its subscribers")
+	}
+
+	publishTestEvent(t, s, ref, "trigger")
+	waitForWakeUp(t, s, ref)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(subscriber.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := subscriber.received(); len(got) != 1 || got[0] != "trigger" {
+		t.Errorf("received %v, want the event waking the channel up", got)
+	}
+	if got := atomic.LoadInt32(notified); got != 2 {
+		t.Errorf("notified %d times, want 2", got)
+	}
+
+	// The channel is awake, the next events are delivered right away.
+	publishTestEvent(t, s, ref, "next")
+	if got := subscriber.received(); len(got) != 2 || got[1] != "next" {
+		t.Errorf("received %v, want [trigger next]", got)
+	}
+}
+
+func TestHibernationWakeUpOnSubscribersChange(t *testing.T) {
+	first, second := newEventRecorder(), newEventRecorder()
+	defer first.Close()
+	defer second.Close()
+	s, conn, ref, _ := newHibernatedChannel(t, first)
+
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, first, second), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if _, hibernated := s.HibernatedSince(ref); hibernated {
+		t.Fatal("the channel is still hibernated after a change of its subscribers")
+	}
+	if len(conn.subs) != 2 {
+		t.Errorf("%d open subscriptions, want 2", len(conn.subs))
+	}
+}
+
+func TestHibernationFinalize(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn, ref, _ := newHibernatedChannel(t, subscriber)
+
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, hibernated := s.HibernatedSince(ref); hibernated {
+		t.Error("the finalized channel is still hibernated")
+	}
+	// The durables of the finalized channel are deleted, not left closed.
+	if len(conn.subs) != 0 || len(conn.closed) != 0 {
+		t.Errorf("%d subscriptions and %d closed durables left, want none", len(conn.subs), len(conn.closed))
+	}
+}
+
+func TestHibernationDisabled(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	s.hibernateIdle(time.Now())
+	s.hibernateIdle(time.Now().Add(24 * time.Hour))
+	if _, hibernated := s.HibernatedSince(ref); hibernated || len(conn.subs) != 1 {
+		t.Error("the channel hibernated while the hibernation is disabled")
+	}
+}
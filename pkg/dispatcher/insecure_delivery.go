@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// The outcomes of the insecure deliveries, tagging insecureDeliveriesM.
+	insecureDeliveryDelivered = "delivered"
+	insecureDeliveryRefused   = "refused"
+)
+
+var (
+	// insecureWarningInterval is how often an insecure delivery of a subscription is logged.
+	insecureWarningInterval = time.Minute
+
+	// refusedInsecureDelivery is the result of the deliveries refused in the strict mode, NATSS
+	// redelivering the message until the subscriber is reached over HTTPS.
+	refusedInsecureDelivery = deliveryResult{status: DeliveryStatusFailed, code: eventingchannels.NoResponse}
+
+	// insecureDeliveriesM records the deliveries over plain HTTP to a subscriber in another
+	// namespace than its channel.
+	insecureDeliveriesM = stats.Int64(
+		"natss_insecure_cross_namespace_deliveries_total",
+		"Number of deliveries over plain HTTP to a subscriber in another namespace than its channel",
+		stats.UnitDimensionless,
+	)
+
+	// insecureResultKey tells whether the delivery was made, or refused in the strict mode.
+	insecureResultKey = tag.MustNewKey("result")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: insecureDeliveriesM.Description(),
+			Measure:     insecureDeliveriesM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{insecureResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// InsecureDeliveryReporter is implemented by the dispatchers reporting the subscriptions
+// delivering over plain HTTP to a subscriber in another namespace than their channel.
+type InsecureDeliveryReporter interface {
+	// WatchInsecureDeliveries sets the function called when a subscription of channel first
+	// delivers insecurely, nil removing it.
+	WatchInsecureDeliveries(channel eventingchannels.ChannelReference, notify func())
+	// InsecureDeliveries returns the subscriptions of channel which delivered insecurely, sorted.
+	InsecureDeliveries(channel eventingchannels.ChannelReference) []types.UID
+}
+
+var _ InsecureDeliveryReporter = (*SubscriptionsSupervisor)(nil)
+
+// insecureDelivery tracks the insecure deliveries of a subscription.
+type insecureDelivery struct {
+	channel eventingchannels.ChannelReference
+	// lastWarning is the UnixNano time of the last warning logged.
+	lastWarning int64
+}
+
+// WatchInsecureDeliveries implements InsecureDeliveryReporter.
+func (s *SubscriptionsSupervisor) WatchInsecureDeliveries(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.insecureNotifiers.Delete(channel)
+		return
+	}
+	s.insecureNotifiers.Store(channel, notify)
+}
+
+// InsecureDeliveries implements InsecureDeliveryReporter.
+func (s *SubscriptionsSupervisor) InsecureDeliveries(channel eventingchannels.ChannelReference) []types.UID {
+	var uids []types.UID
+	s.insecureDeliveries.Range(func(key, value interface{}) bool {
+		if value.(*insecureDelivery).channel == channel {
+			uids = append(uids, key.(types.UID))
+		}
+		return true
+	})
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// refuseInsecureDelivery records the deliveries of subscription over plain HTTP to a subscriber
+// in another namespace than channel, and returns whether they are refused, as they are in the
+// strict transport encryption mode. The destinations outside of the cluster are not checked.
+func (s *SubscriptionsSupervisor) refuseInsecureDelivery(channel eventingchannels.ChannelReference, subscription subscriptionReference, destination *url.URL) bool {
+	destination = s.preferHTTPS(destination)
+	if destination == nil || destination.Scheme != "http" {
+		return false
+	}
+	namespace, ok := serviceNamespace(destination.Hostname())
+	if !ok || namespace == channel.Namespace {
+		return false
+	}
+
+	refused := s.getTransportEncryption() == security.TransportEncryptionStrict
+	result := insecureDeliveryDelivered
+	if refused {
+		result = insecureDeliveryRefused
+	}
+	if ctx, err := tag.New(context.Background(), tag.Insert(insecureResultKey, result)); err == nil {
+		metrics.Record(ctx, insecureDeliveriesM.M(1))
+	}
+
+	now := time.Now().UnixNano()
+	value, seen := s.insecureDeliveries.LoadOrStore(subscription.UID, &insecureDelivery{channel: channel, lastWarning: now})
+	if seen {
+		d := value.(*insecureDelivery)
+		last := atomic.LoadInt64(&d.lastWarning)
+		if now-last < int64(insecureWarningInterval) || !atomic.CompareAndSwapInt64(&d.lastWarning, last, now) {
+			return refused
+		}
+	}
+	s.subscriptionsLogger.Warn("Delivery over plain HTTP to another namespace",
+		zap.String("channel", channel.String()),
+		zap.String("subscription", string(subscription.UID)),
+		zap.String("subscriberNamespace", namespace),
+		zap.String("destination", destination.String()),
+		zap.Bool("refused", refused))
+	if !seen {
+		if notify, ok := s.insecureNotifiers.Load(channel); ok {
+			notify.(func())()
+		}
+	}
+	return refused
+}
+
+// serviceNamespace returns the namespace of the Kubernetes service named by hostname, in the form
+// <service>.<namespace>.svc[.<cluster domain>], and false for the other hostnames.
+func serviceNamespace(hostname string) (string, bool) {
+	labels := strings.Split(strings.ToLower(hostname), ".")
+	if len(labels) < 3 || labels[2] != "svc" || labels[1] == "" {
+		return "", false
+	}
+	return labels[1], true
+}
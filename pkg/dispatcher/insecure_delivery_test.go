@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+func TestServiceNamespace(t *testing.T) {
+	testCases := map[string]struct {
+		namespace string
+		ok        bool
+	}{
+		"subscriber.other.svc.cluster.local": {"other", true},
+		"subscriber.Other.svc":               {"other", true},
+		"subscriber.other":                   {},
+		"subscriber.other.example.com":       {},
+		"10.0.0.1":                           {},
+		"localhost":                          {},
+	}
+	for hostname, tc := range testCases {
+		namespace, ok := serviceNamespace(hostname)
+		if namespace != tc.namespace || ok != tc.ok {
+			t.Errorf("serviceNamespace(%q) = %q, %t, want %q, %t", hostname, namespace, ok, tc.namespace, tc.ok)
+		}
+	}
+}
+
+func TestRefuseInsecureDelivery(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	core, logs := observer.New(zapcore.WarnLevel)
+	s.subscriptionsLogger = zap.New(core)
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	notified := 0
+	s.WatchInsecureDeliveries(channel, func() { notified++ })
+
+	sub := func(uid types.UID) subscriptionReference {
+		return newSubscriptionReference(eventingduckv1.SubscriberSpec{UID: uid})
+	}
+	deliver := func(uid types.UID, destination string) bool {
+		t.Helper()
+		u, err := url.Parse(destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.refuseInsecureDelivery(channel, sub(uid), u)
+	}
+
+	// The secure deliveries, and those within the namespace of the channel, are left alone.
+	for uid, destination := range map[types.UID]string{
+		"uid-https":    "https://subscriber.other.svc.cluster.local",
+		"uid-local":    "http://subscriber.ns.svc.cluster.local",
+		"uid-external": "http://subscriber.example.com",
+	} {
+		if deliver(uid, destination) {
+			t.Errorf("delivery to %s refused", destination)
+		}
+	}
+	if got := s.InsecureDeliveries(channel); len(got) != 0 {
+		t.Errorf("InsecureDeliveries() = %v, want none", got)
+	}
+
+	// The insecure deliveries are warned about once per interval.
+	for i := 0; i < 3; i++ {
+		if deliver("uid-cross", "http://subscriber.other.svc.cluster.local:8080") {
+			t.Fatal("delivery refused outside of the strict mode")
+		}
+	}
+	if logs.Len() != 1 || notified != 1 {
+		t.Errorf("%d warnings and %d notifications, want 1 of each", logs.Len(), notified)
+	}
+	d, _ := s.insecureDeliveries.Load(types.UID("uid-cross"))
+	d.(*insecureDelivery).lastWarning -= int64(insecureWarningInterval)
+	deliver("uid-cross", "http://subscriber.other.svc.cluster.local:8080")
+	if logs.Len() != 2 || notified != 1 {
+		t.Errorf("%d warnings and %d notifications after the interval, want 2 and 1", logs.Len(), notified)
+	}
+	if diff := cmp.Diff([]types.UID{"uid-cross"}, s.InsecureDeliveries(channel)); diff != "" {
+		t.Errorf("InsecureDeliveries() (-want, +got) = %s", diff)
+	}
+
+	// The strict mode refuses them.
+	s.SetTransportEncryption(security.TransportEncryptionStrict)
+	if !deliver("uid-cross", "http://subscriber.other.svc.cluster.local") {
+		t.Error("insecure delivery not refused in the strict mode")
+	}
+	if deliver("uid-local", "http://subscriber.ns.svc.cluster.local") {
+		t.Error("delivery within the namespace refused in the strict mode")
+	}
+
+	// Unsubscribing forgets them.
+	c := &messagingv1.Channel{ObjectMeta: metav1.ObjectMeta{Namespace: channel.Namespace, Name: channel.Name}}
+	c.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{UID: "uid-cross", SubscriberURI: apis.HTTP("subscriber.other.svc.cluster.local")}}
+	if _, err := s.UpdateSubscriptions(context.Background(), c, false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, err := s.UpdateSubscriptions(context.Background(), c, true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if got := s.InsecureDeliveries(channel); len(got) != 0 {
+		t.Errorf("InsecureDeliveries() = %v after unsubscribing, want none", got)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+)
+
+// DefaultIngressErrorStatus is the status answering the events refused by an ingress interceptor
+// with an error which is not an *IngressError.
+const DefaultIngressErrorStatus = http.StatusInternalServerError
+
+// IngressInterceptor intercepts the events received by the dispatcher before they are published
+// to NATSS. The interceptors of the dispatcher are called in order, each one passed the event
+// returned by the previous one.
+type IngressInterceptor interface {
+	// Intercept returns the event to publish in place of e, received with headers, or an error
+	// refusing it. A nil event without error accepts e without publishing it. Both end the chain.
+	Intercept(ctx context.Context, e *event.Event, headers http.Header) (*event.Event, error)
+}
+
+// IngressInterceptorFunc is a function implementing IngressInterceptor.
+type IngressInterceptorFunc func(ctx context.Context, e *event.Event, headers http.Header) (*event.Event, error)
+
+// Intercept implements IngressInterceptor.
+func (f IngressInterceptorFunc) Intercept(ctx context.Context, e *event.Event, headers http.Header) (*event.Event, error) {
+	return f(ctx, e, headers)
+}
+
+// IngressError refuses an event with Status.
+type IngressError struct {
+	Status int
+	Err    error
+}
+
+// NewIngressError returns an *IngressError refusing an event with status, for the reason given by
+// format and args.
+func NewIngressError(status int, format string, args ...interface{}) error {
+	return &IngressError{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *IngressError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IngressError) Unwrap() error {
+	return e.Err
+}
+
+// ingressInterceptors returns the chain of the dispatcher: the validation and the screening of
+// the reserved extension attributes, followed by the interceptors of the embedder.
+func (s *SubscriptionsSupervisor) ingressInterceptors(custom []IngressInterceptor) []IngressInterceptor {
+	return append([]IngressInterceptor{
+		IngressInterceptorFunc(validateEvent),
+		IngressInterceptorFunc(s.screenReservedExtensions),
+	}, custom...)
+}
+
+// ingress returns the chain of interceptors of the receiver.
+func (s *SubscriptionsSupervisor) ingress() ingressChain {
+	return ingressChain{interceptors: s.interceptors, errorStatus: s.ingressErrorStatus, logger: s.receiverLogger}
+}
+
+// intercept passes e through the chain of interceptors, returning the event to publish, nil when
+// it is accepted without being published.
+func (s *SubscriptionsSupervisor) intercept(ctx context.Context, e *event.Event, headers http.Header) (*event.Event, error) {
+	return s.ingress().intercept(ctx, e, headers)
+}
+
+// refusalStatus returns the status answering an event refused with err.
+func (s *SubscriptionsSupervisor) refusalStatus(err error) int {
+	return s.ingress().refusalStatus(err)
+}
+
+// withIngressInterceptors returns a handler passing the events posted to the channels to next once
+// intercepted, the request rewritten with the event returned by the chain. The requests which are
+// not CloudEvents are left to next to refuse.
+func (s *SubscriptionsSupervisor) withIngressInterceptors(next http.Handler) http.Handler {
+	return s.ingress().handler(next)
+}
+
+// ingressChain is a chain of IngressInterceptor, shared by the receivers of the transports.
+type ingressChain struct {
+	interceptors []IngressInterceptor
+	// errorStatus answers the events refused by an interceptor with an error which is not an
+	// *IngressError.
+	errorStatus int
+	logger      *zap.Logger
+}
+
+// intercept passes e through the interceptors, returning the event to publish, nil when it is
+// accepted without being published.
+func (c ingressChain) intercept(ctx context.Context, e *event.Event, headers http.Header) (*event.Event, error) {
+	for _, interceptor := range c.interceptors {
+		var err error
+		if e, err = interceptor.Intercept(ctx, e, headers); err != nil || e == nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// refusalStatus returns the status answering an event refused with err.
+func (c ingressChain) refusalStatus(err error) int {
+	var ierr *IngressError
+	if errors.As(err, &ierr) {
+		return ierr.Status
+	}
+	return c.errorStatus
+}
+
+// handler returns a handler passing the events posted to the channels to next once intercepted,
+// the request rewritten with the event returned by the chain. The requests which are not
+// CloudEvents are left to next to refuse.
+func (c ingressChain) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		message := cehttp.NewMessageFromHttpRequest(r)
+		if message.ReadEncoding() == binding.EncodingUnknown {
+			next.ServeHTTP(w, r)
+			return
+		}
+		e, err := binding.ToEvent(r.Context(), message)
+		if err != nil {
+			rejectRequest(w, http.StatusBadRequest, rejectedMalformedEvent, "invalid event: %v", err)
+			return
+		}
+
+		e, err = c.intercept(r.Context(), e, r.Header)
+		if err != nil {
+			status := c.refusalStatus(err)
+			c.logger.Info("Event refused by an ingress interceptor", zap.String("host", r.Host), zap.Int("status", status), zap.Error(err))
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if e == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		for header := range r.Header {
+			if strings.HasPrefix(strings.ToLower(header), cloudEventsHeaderPrefix) {
+				r.Header.Del(header)
+			}
+		}
+		r.Header.Del("Content-Type")
+		if err := cehttp.WriteRequest(r.Context(), binding.ToMessage(e), r); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %v", err), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
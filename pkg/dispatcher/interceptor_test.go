@@ -0,0 +1,196 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/go-cmp/cmp"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// tracingInterceptor appends its name to calls, then returns the event or the error of its test case.
+func tracingInterceptor(name string, calls *[]string, intercept func(*event.Event, http.Header) (*event.Event, error)) IngressInterceptor {
+	return IngressInterceptorFunc(func(_ context.Context, e *event.Event, headers http.Header) (*event.Event, error) {
+		*calls = append(*calls, name)
+		if intercept == nil {
+			return e, nil
+		}
+		return intercept(e, headers)
+	})
+}
+
+func TestIngressInterceptors(t *testing.T) {
+	tag := func(e *event.Event, _ http.Header) (*event.Event, error) {
+		e.SetExtension("tenant", "blue")
+		return e, nil
+	}
+	scrub := func(e *event.Event, headers http.Header) (*event.Event, error) {
+		headers.Del("Authorization")
+		return e, nil
+	}
+	forbid := func(*event.Event, http.Header) (*event.Event, error) {
+		return nil, NewIngressError(http.StatusForbidden, "not allowed")
+	}
+	fail := func(*event.Event, http.Header) (*event.Event, error) {
+		return nil, errors.New("the policy service is unreachable")
+	}
+	swallow := func(*event.Event, http.Header) (*event.Event, error) {
+		return nil, nil
+	}
+
+	testCases := map[string]struct {
+		first, second func(*event.Event, http.Header) (*event.Event, error)
+		errorStatus   int
+		body          string
+		want          int
+		wantCalls     []string
+		// wantPublished tells whether the event is passed on, with the tenant extension and the
+		// Authorization header of wantTenant and wantAuthorization.
+		wantPublished     bool
+		wantTenant        interface{}
+		wantAuthorization string
+	}{
+		"passed through": {
+			want:              http.StatusAccepted,
+			wantCalls:         []string{"first", "second"},
+			wantPublished:     true,
+			wantAuthorization: "Bearer secret",
+		},
+		"enriched": {
+			first:         tag,
+			second:        scrub,
+			want:          http.StatusAccepted,
+			wantCalls:     []string{"first", "second"},
+			wantPublished: true,
+			wantTenant:    "blue",
+		},
+		"refused with a status": {
+			first:     forbid,
+			want:      http.StatusForbidden,
+			wantCalls: []string{"first"},
+		},
+		"refused with the default status": {
+			second:    fail,
+			want:      http.StatusInternalServerError,
+			wantCalls: []string{"first", "second"},
+		},
+		"refused with the configured status": {
+			first:       fail,
+			errorStatus: http.StatusServiceUnavailable,
+			want:        http.StatusServiceUnavailable,
+			wantCalls:   []string{"first"},
+		},
+		"accepted without publishing": {
+			first:     swallow,
+			want:      http.StatusAccepted,
+			wantCalls: []string{"first"},
+		},
+		"invalid event": {
+			body: `{"specversion": "1.0", "type": "dev.knative.test", "source": "test"}`,
+			want: http.StatusBadRequest,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var calls []string
+			d, err := NewDispatcher(Args{
+				ClientID: "test",
+				IngressInterceptors: []IngressInterceptor{
+					tracingInterceptor("first", &calls, tc.first),
+					tracingInterceptor("second", &calls, tc.second),
+				},
+				IngressErrorStatus: tc.errorStatus,
+			})
+			if err != nil {
+				t.Fatalf("NewDispatcher() = %v", err)
+			}
+			s := d.(*SubscriptionsSupervisor)
+
+			published := false
+			var gotTenant interface{}
+			var gotAuthorization string
+			handler := s.withIngressInterceptors(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+				if err != nil {
+					t.Errorf("ToEvent() = %v", err)
+					return
+				}
+				published = true
+				gotTenant = e.Extensions()["tenant"]
+				gotAuthorization = req.Header.Get("Authorization")
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			body := tc.body
+			if body == "" {
+				body = structuredTestEvent
+			}
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local/", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/cloudevents+json")
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if diff := cmp.Diff(tc.wantCalls, calls); diff != "" {
+				t.Errorf("interceptors called (-want, +got) = %s", diff)
+			}
+			if published != tc.wantPublished {
+				t.Errorf("published = %t, want %t", published, tc.wantPublished)
+			}
+			if gotTenant != tc.wantTenant {
+				t.Errorf("tenant = %v, want %v", gotTenant, tc.wantTenant)
+			}
+			if gotAuthorization != tc.wantAuthorization {
+				t.Errorf("Authorization = %q, want %q", gotAuthorization, tc.wantAuthorization)
+			}
+		})
+	}
+}
+
+func TestIngressInterceptorsMultiplex(t *testing.T) {
+	d, err := NewDispatcher(Args{
+		ClientID: "test",
+		IngressInterceptors: []IngressInterceptor{IngressInterceptorFunc(func(context.Context, *event.Event, http.Header) (*event.Event, error) {
+			return nil, NewIngressError(http.StatusForbidden, "not allowed")
+		})},
+	})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	s.setRoutes(map[string]eventingchannels.ChannelReference{"channel.ns.svc.cluster.local": {Namespace: "ns", Name: "channel"}})
+
+	req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local"+MultiplexPath+"?channel=other", strings.NewReader(structuredTestEvent))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	rec := httptest.NewRecorder()
+	s.withMultiplex(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
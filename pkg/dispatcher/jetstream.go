@@ -0,0 +1,702 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/format"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+
+	"knative.dev/eventing-natss/pkg/retry"
+	"knative.dev/eventing-natss/pkg/security"
+	"knative.dev/eventing-natss/pkg/stanutil"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+// jetStream is the part of nats.JetStreamContext the JetStream dispatcher uses.
+type jetStream interface {
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	DeleteStream(name string, opts ...nats.JSOpt) error
+	ConsumerInfo(stream, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	AddConsumer(stream string, cfg *nats.ConsumerConfig, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	DeleteConsumer(stream, consumer string, opts ...nats.JSOpt) error
+	PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+	Subscribe(subj string, cb nats.MsgHandler, opts ...nats.SubOpt) (*nats.Subscription, error)
+}
+
+// jetStreamMsg is the part of a message delivered by JetStream the dispatcher acknowledges.
+type jetStreamMsg interface {
+	Ack(opts ...nats.AckOpt) error
+	NakWithDelay(delay time.Duration, opts ...nats.AckOpt) error
+	Metadata() (*nats.MsgMetadata, error)
+}
+
+// JetStreamDispatcher is the NatssDispatcher of the jetstream transport. Each channel is a stream
+// of its own, storing the subject of the channel, and each subscription a durable consumer of
+// that stream, which JetStream redelivers the events the subscriber failed to receive to.
+type JetStreamDispatcher struct {
+	logger         *zap.Logger
+	dispatcher     *eventingchannels.MessageDispatcherImpl
+	receiver       *eventingchannels.MessageReceiver
+	receiverTLS    *tls.Config
+	trustedProxies []*net.IPNet
+	// ingress is the chain of interceptors of the events received.
+	ingress ingressChain
+
+	defaultDelivery *eventingduckv1.DeliverySpec
+	// ackWait is how long JetStream waits for the ack of an event before redelivering it.
+	ackWait time.Duration
+
+	// connect connects to NATS, returning the JetStream context of the connection and how to
+	// close it.
+	connect func() (jetStream, func(), error)
+
+	jsMux sync.RWMutex
+	js    jetStream
+	close func()
+	// connectingSince is when the dispatcher first attempted to connect, disconnectedAt when the
+	// connection was lost, zero while connected.
+	connectingSince time.Time
+	disconnectedAt  time.Time
+
+	channelsSynced int32
+	draining       int32
+
+	subscriptionsMux sync.Mutex
+	// subscriptions are the consumers of the channels, keyed by the UID of their subscription.
+	subscriptions map[eventingchannels.ChannelReference]map[types.UID]*nats.Subscription
+	// subscribers are the specs the consumers of the channels were subscribed with.
+	subscribers map[eventingchannels.ChannelReference]map[types.UID]eventingduckv1.SubscriberSpec
+	// subscribedChannels holds the last version of the channels the consumers were subscribed
+	// for. leads tells which channels the replica leads, nil while it leads them all, and demoted
+	// holds the last version of the channels it no longer leads.
+	subscribedChannels map[eventingchannels.ChannelReference]subscribedChannel
+	leads              func(eventingchannels.ChannelReference) bool
+	demoted            map[eventingchannels.ChannelReference]subscribedChannel
+
+	routes atomic.Value
+}
+
+var (
+	_ NatssDispatcher      = (*JetStreamDispatcher)(nil)
+	_ HealthReporter       = (*JetStreamDispatcher)(nil)
+	_ LifecycleParticipant = (*JetStreamDispatcher)(nil)
+	_ LeadershipHandler    = (*JetStreamDispatcher)(nil)
+)
+
+// NewJetStreamDispatcher returns the dispatcher of the jetstream transport, connecting to the
+// NATS server of args.NatssURL, which must have JetStream enabled, once started.
+func NewJetStreamDispatcher(args Args) (NatssDispatcher, error) {
+	if args.Logger == nil {
+		args.Logger = zap.NewNop()
+	}
+	if args.IngressErrorStatus == 0 {
+		args.IngressErrorStatus = DefaultIngressErrorStatus
+	}
+	if args.AckWait <= 0 {
+		args.AckWait = defaultAckWait
+	}
+
+	sender, err := kncloudevents.NewHTTPMessageSenderWithTarget("")
+	if err != nil {
+		return nil, err
+	}
+	clientTLS, err := args.TLS.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	receiverTLS, err := args.TLS.ServerTLS()
+	if err != nil {
+		return nil, err
+	}
+	deliveryTLS, err := args.TLS.DeliveryTLS()
+	if err != nil {
+		return nil, err
+	}
+	if deliveryTLS != nil {
+		sender.Client = &http.Client{Transport: security.NewTransport(deliveryTLS)}
+	}
+	// The connection reconnects on its own, the consumers resuming with it.
+	natsOptions := []nats.Option{nats.MaxReconnects(-1)}
+	if clientTLS != nil {
+		natsOptions = append(natsOptions, stanutil.SecureReloaded(args.TLS.ClientTLS))
+	}
+	authOption, err := args.Auth.Option()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS credentials: %w", err)
+	}
+	if authOption != nil {
+		natsOptions = append(natsOptions, authOption)
+	}
+
+	d := &JetStreamDispatcher{
+		logger:         args.Logger,
+		dispatcher:     eventingchannels.NewMessageDispatcherFromSender(args.Logger, sender),
+		receiverTLS:    receiverTLS,
+		trustedProxies: args.TrustedProxies,
+		ingress: ingressChain{
+			interceptors: append([]IngressInterceptor{IngressInterceptorFunc(validateEvent)}, args.IngressInterceptors...),
+			errorStatus:  args.IngressErrorStatus,
+			logger:       args.Logger,
+		},
+		defaultDelivery:    args.DefaultDelivery,
+		ackWait:            args.AckWait,
+		subscriptions:      make(map[eventingchannels.ChannelReference]map[types.UID]*nats.Subscription),
+		subscribers:        make(map[eventingchannels.ChannelReference]map[types.UID]eventingduckv1.SubscriberSpec),
+		subscribedChannels: make(map[eventingchannels.ChannelReference]subscribedChannel),
+		demoted:            make(map[eventingchannels.ChannelReference]subscribedChannel),
+	}
+	natsOptions = append(natsOptions,
+		nats.DisconnectErrHandler(func(*nats.Conn, error) { d.setDisconnected(true) }),
+		nats.ReconnectHandler(func(*nats.Conn) { d.setDisconnected(false) }))
+	d.connect = func() (jetStream, func(), error) {
+		nc, err := nats.Connect(args.NatssURL, natsOptions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		js, err := nc.JetStream()
+		if err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+		return js, nc.Close, nil
+	}
+	receiver, err := eventingchannels.NewMessageReceiver(
+		d.publish,
+		args.Logger,
+		args.Reporter,
+		eventingchannels.ResolveMessageChannelFromHostHeader(d.getChannelReferenceFromHost))
+	if err != nil {
+		return nil, err
+	}
+	d.receiver = receiver
+	d.routes.Store(newChannelRoutes(nil))
+	return d, nil
+}
+
+// Start runs the dispatcher until ctx is done, through a Lifecycle of its own.
+func (d *JetStreamDispatcher) Start(ctx context.Context) error {
+	l := NewLifecycle(d.logger, DefaultShutdownTimeout)
+	if err := d.RegisterHooks(l); err != nil {
+		return err
+	}
+	return l.Run(ctx)
+}
+
+// RegisterHooks implements LifecycleParticipant. The receiver stops before the connection to
+// NATS, so that the events it accepts until then are published, the dispatcher reporting itself
+// as draining from then on.
+func (d *JetStreamDispatcher) RegisterHooks(l *Lifecycle) error {
+	receiver := l.RunHook("receiver", PriorityReceiver, d.startReceiver)
+	stopReceiver := receiver.Stop
+	receiver.Stop = func(ctx context.Context) error {
+		atomic.StoreInt32(&d.draining, 1)
+		return stopReceiver(ctx)
+	}
+	for _, h := range []Hook{l.RunHook("connection", PriorityConnection, d.run), receiver} {
+		if err := l.Register(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Health implements HealthReporter.
+func (d *JetStreamDispatcher) Health() Health {
+	d.jsMux.RLock()
+	health := Health{Connected: d.js != nil && d.disconnectedAt.IsZero()}
+	if !health.Connected {
+		health.DisconnectedSince = d.disconnectedAt
+		if health.DisconnectedSince.IsZero() {
+			health.DisconnectedSince = d.connectingSince
+		}
+	}
+	d.jsMux.RUnlock()
+	health.ChannelsSynced = atomic.LoadInt32(&d.channelsSynced) == 1
+	health.Draining = atomic.LoadInt32(&d.draining) == 1
+	return health
+}
+
+// MarkChannelsSynced implements HealthReporter.
+func (d *JetStreamDispatcher) MarkChannelsSynced() {
+	atomic.StoreInt32(&d.channelsSynced, 1)
+}
+
+// setDisconnected records that the connection to NATS was lost, or restored once it reconnects.
+func (d *JetStreamDispatcher) setDisconnected(disconnected bool) {
+	d.jsMux.Lock()
+	defer d.jsMux.Unlock()
+	switch {
+	case !disconnected:
+		d.disconnectedAt = time.Time{}
+	case d.disconnectedAt.IsZero():
+		d.disconnectedAt = time.Now()
+	}
+}
+
+// run connects to NATS, retrying until connected, and closes the connection once ctx is done.
+func (d *JetStreamDispatcher) run(ctx context.Context) error {
+	d.jsMux.Lock()
+	if d.connectingSince.IsZero() {
+		d.connectingSince = time.Now()
+	}
+	d.jsMux.Unlock()
+	err := retry.Do(ctx, connectPolicy(), func(ctx context.Context) error {
+		js, closeConn, err := d.connect()
+		if err != nil {
+			return err
+		}
+		d.jsMux.Lock()
+		d.js, d.close = js, closeConn
+		d.disconnectedAt = time.Time{}
+		d.jsMux.Unlock()
+		d.logger.Info("Connected to NATS JetStream")
+		return nil
+	}, retry.OnRetry(func(_ int, err error, wait time.Duration) {
+		d.logger.Error("Failed to connect to NATS JetStream", zap.Error(err), zap.Duration("retryIn", wait))
+	}))
+	if err != nil {
+		// The dispatcher stopped before connecting.
+		return nil
+	}
+	<-ctx.Done()
+	d.jsMux.Lock()
+	defer d.jsMux.Unlock()
+	d.close()
+	d.js = nil
+	return nil
+}
+
+// jetStream returns the JetStream context of the connection to NATS.
+func (d *JetStreamDispatcher) jetStream() (jetStream, error) {
+	d.jsMux.RLock()
+	defer d.jsMux.RUnlock()
+	if d.js == nil {
+		return nil, errors.New("no Connection to NATS JetStream")
+	}
+	return d.js, nil
+}
+
+func (d *JetStreamDispatcher) startReceiver(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", util.DispatcherReceiverPort))
+	if err != nil {
+		return err
+	}
+	return serve(ctx, listener, d.receiverTLS, d.receiverHandler())
+}
+
+// receiverHandler returns the handler of the requests to the receiver, which refuses the events
+// the interceptors refuse, as the receiver of the stan transport does.
+func (d *JetStreamDispatcher) receiverHandler() http.Handler {
+	return withClientAddress(withChannelContract(d.ingress.handler(kncloudevents.CreateHandler(d.receiver))), d.trustedProxies)
+}
+
+// publish stores the event of message in the stream of channel, failing unless JetStream
+// acknowledged it.
+func (d *JetStreamDispatcher) publish(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, transformers []binding.Transformer, _ http.Header) error {
+	js, err := d.jetStream()
+	if err != nil {
+		d.logger.Error("no Connection to NATS JetStream", zap.Error(err))
+		return err
+	}
+	// The dispatch of the event continues the trace of the request.
+	e, err := binding.ToEvent(ctx, message, withTraceContext(ctx, transformers)...)
+	if err != nil {
+		return fmt.Errorf("could not read the event: %w", err)
+	}
+	data, err := format.JSON.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode the event: %w", err)
+	}
+	if _, err := js.PublishMsg(&nats.Msg{Subject: getSubject(channel), Data: data}, nats.ExpectStream(streamName(channel))); err != nil {
+		d.logger.Error("error during publish", zap.String("channel", channel.String()), zap.Error(err))
+		return fmt.Errorf("error during publish: %w", err)
+	}
+	d.logger.Debug("published", zap.String("channel", channel.String()))
+	return nil
+}
+
+// UpdateSubscriptions makes the stream of channel and a consumer for each of its subscribers,
+// removing the consumers of the subscribers it no longer has, or every consumer and the stream
+// when isFinalizer.
+func (d *JetStreamDispatcher) UpdateSubscriptions(ctx context.Context, channel *messagingv1.Channel, isFinalizer bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	d.subscriptionsMux.Lock()
+	defer d.subscriptionsMux.Unlock()
+
+	cRef := eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
+	return d.updateSubscriptions(ctx, cRef, channel, isFinalizer)
+}
+
+// should be called only while holding subscriptionsMux
+func (d *JetStreamDispatcher) updateSubscriptions(ctx context.Context, cRef eventingchannels.ChannelReference, channel *messagingv1.Channel, isFinalizer bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	failedToSubscribe := make(map[eventingduckv1.SubscriberSpec]error)
+	js, err := d.jetStream()
+	if err != nil {
+		return failedToSubscribe, err
+	}
+	stream := streamName(cRef)
+	if isFinalizer {
+		for uid := range d.subscriptions[cRef] {
+			d.unsubscribe(cRef, uid)
+		}
+		delete(d.subscriptions, cRef)
+		delete(d.subscribers, cRef)
+		delete(d.subscribedChannels, cRef)
+		delete(d.demoted, cRef)
+		if err := js.DeleteStream(stream); err != nil && !isNotFound(err) {
+			return failedToSubscribe, fmt.Errorf("could not delete the stream %s: %w", stream, err)
+		}
+		return failedToSubscribe, nil
+	}
+
+	if err := ensureStream(js, stream, getSubject(cRef)); err != nil {
+		return failedToSubscribe, err
+	}
+	// The stream is made by every replica, for their receivers to publish to, but the consumers
+	// are only subscribed to by the leader of the channel, once it is promoted.
+	if !d.leadsChannel(cRef) {
+		d.closeDemoted(cRef)
+		d.demoted[cRef] = subscribedChannel{ctx: ctx, channel: channel.DeepCopy()}
+		return failedToSubscribe, nil
+	}
+	d.subscribedChannels[cRef] = subscribedChannel{ctx: ctx, channel: channel.DeepCopy()}
+	if d.subscriptions[cRef] == nil {
+		d.subscriptions[cRef] = make(map[types.UID]*nats.Subscription)
+		d.subscribers[cRef] = make(map[types.UID]eventingduckv1.SubscriberSpec)
+	}
+	active := make(map[types.UID]bool, len(channel.Spec.Subscribers))
+	for _, sub := range channel.Spec.Subscribers {
+		subRef := newSubscriptionReference(sub)
+		active[subRef.UID] = true
+		retryConfig, err := retryConfigOf(ctx, subRef, d.defaultDelivery)
+		if err != nil {
+			failedToSubscribe[sub] = err
+			continue
+		}
+		if current, ok := d.subscriptions[cRef][subRef.UID]; ok {
+			if equality.Semantic.DeepEqual(d.subscribers[cRef][subRef.UID], sub) {
+				continue
+			}
+			// The subscriber changed: its consumer is subscribed to again, keeping its position.
+			_ = current.Unsubscribe()
+			delete(d.subscriptions[cRef], subRef.UID)
+		}
+		natsSub, err := d.subscribe(ctx, js, cRef, subRef, retryConfig)
+		if err != nil {
+			d.logger.Error("Failed to subscribe", zap.String("channel", cRef.String()), zap.String("subscription", string(subRef.UID)), zap.Error(err))
+			failedToSubscribe[sub] = err
+			continue
+		}
+		d.subscriptions[cRef][subRef.UID] = natsSub
+		d.subscribers[cRef][subRef.UID] = sub
+	}
+	for uid := range d.subscriptions[cRef] {
+		if !active[uid] {
+			d.unsubscribe(cRef, uid)
+		}
+	}
+	return failedToSubscribe, nil
+}
+
+// Promote implements LeadershipHandler.
+func (d *JetStreamDispatcher) Promote(leads func(eventingchannels.ChannelReference) bool) {
+	d.subscriptionsMux.Lock()
+	defer d.subscriptionsMux.Unlock()
+
+	d.leads = leads
+	for channel, subscribed := range d.demoted {
+		if !leads(channel) {
+			continue
+		}
+		delete(d.demoted, channel)
+		failed, err := d.updateSubscriptions(subscribed.ctx, channel, subscribed.channel, false)
+		if err != nil {
+			// Kept demoted, for the next promotion to subscribe again.
+			d.demoted[channel] = subscribed
+			d.logger.Error("Failed to subscribe on promotion", zap.String("channel", channel.String()), zap.Error(err))
+			continue
+		}
+		for sub, err := range failed {
+			d.logger.Error("Failed to subscribe on promotion", zap.String("channel", channel.String()),
+				zap.String("subscription", string(sub.UID)), zap.Error(err))
+		}
+		d.logger.Info("Subscribed on promotion", zap.String("channel", channel.String()),
+			zap.Int("subscriptions", len(d.subscriptions[channel])))
+	}
+}
+
+// Demote implements LeadershipHandler.
+func (d *JetStreamDispatcher) Demote(leads func(eventingchannels.ChannelReference) bool) {
+	d.subscriptionsMux.Lock()
+	defer d.subscriptionsMux.Unlock()
+
+	d.leads = leads
+	for channel := range d.subscriptions {
+		if leads(channel) {
+			continue
+		}
+		subscribed, ok := d.subscribedChannels[channel]
+		d.logger.Info("Closing the subscriptions of the channel no longer led", zap.String("channel", channel.String()))
+		d.closeDemoted(channel)
+		if ok {
+			d.demoted[channel] = subscribed
+		}
+	}
+}
+
+// leadsChannel tells whether the replica leads channel, which it does for all the channels until
+// it is promoted or demoted.
+// should be called only while holding subscriptionsMux
+func (d *JetStreamDispatcher) leadsChannel(channel eventingchannels.ChannelReference) bool {
+	return d.leads == nil || d.leads(channel)
+}
+
+// closeDemoted unsubscribes from the consumers of channel, which the replica no longer leads.
+// Unlike unsubscribe, it keeps the consumers, for the new leader to resume from their position.
+// The subscriptions are drained, the events already sent to the replica being delivered and
+// acknowledged rather than redelivered once their ack wait expires.
+// should be called only while holding subscriptionsMux
+func (d *JetStreamDispatcher) closeDemoted(channel eventingchannels.ChannelReference) {
+	for _, sub := range d.subscriptions[channel] {
+		if err := sub.Drain(); err != nil {
+			d.logger.Warn("failed to drain the subscription of a demoted channel", zap.String("channel", channel.String()), zap.Error(err))
+			_ = sub.Unsubscribe()
+		}
+	}
+	delete(d.subscriptions, channel)
+	delete(d.subscribers, channel)
+	delete(d.subscribedChannels, channel)
+}
+
+// subscribe makes the durable consumer of subscription to the stream of channel, delivering each
+// event up to once plus the retries of retryConfig, and subscribes to it.
+func (d *JetStreamDispatcher) subscribe(ctx context.Context, js jetStream, channel eventingchannels.ChannelReference, subscription subscriptionReference, retryConfig *kncloudevents.RetryConfig) (*nats.Subscription, error) {
+	stream, subject, durable := streamName(channel), getSubject(channel), string(subscription.UID)
+	d.logger.Info("Subscribe to channel", zap.String("channel", channel.String()), zap.String("stream", stream), zap.String("durable", durable))
+
+	deadLetter := deadLetterSink(subscription)
+	cfg := consumerConfig(durable, subject, d.ackWait, retryConfig, deadLetter != nil)
+	info, err := js.ConsumerInfo(stream, durable)
+	switch {
+	case err != nil && !isNotFound(err):
+		return nil, fmt.Errorf("could not get the consumer %s: %w", durable, err)
+	case err == nil && (info.Config.MaxDeliver != cfg.MaxDeliver || info.Config.AckWait != cfg.AckWait):
+		// JetStream does not update consumers: the consumer is made again, from the first event
+		// the old one did not acknowledge.
+		if err := js.DeleteConsumer(stream, durable); err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("could not delete the consumer %s: %w", durable, err)
+		}
+		cfg.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = info.AckFloor.Stream + 1
+		fallthrough
+	case err != nil:
+		if _, err := js.AddConsumer(stream, cfg); err != nil {
+			return nil, fmt.Errorf("could not add the consumer %s: %w", durable, err)
+		}
+	}
+
+	var destination, reply *url.URL
+	if !subscription.SubscriberURI.IsEmpty() {
+		destination = subscription.SubscriberURI.URL()
+	}
+	if !subscription.ReplyURI.IsEmpty() {
+		reply = subscription.ReplyURI.URL()
+	}
+	attempts := deliveryAttempts(retryConfig)
+	return js.Subscribe(subject, func(msg *nats.Msg) {
+		ctx, span := startChannelHopSpan(ctx, channel, subscription.UID, msg.Data, nil)
+		defer span.End()
+		d.deliver(ctx, channel, msg, msg.Data, destination, reply, deadLetter, attempts, retryConfig)
+	}, nats.Durable(durable), nats.ManualAck(), nats.BindStream(stream))
+}
+
+// unsubscribe stops the consumer of the subscription uid of channel and deletes it.
+// should be called only while holding subscriptionsMux
+func (d *JetStreamDispatcher) unsubscribe(channel eventingchannels.ChannelReference, uid types.UID) {
+	d.logger.Info("Unsubscribing", zap.String("channel", channel.String()), zap.String("subscription", string(uid)))
+	if sub := d.subscriptions[channel][uid]; sub != nil {
+		_ = sub.Unsubscribe()
+	}
+	delete(d.subscriptions[channel], uid)
+	delete(d.subscribers[channel], uid)
+	if js, err := d.jetStream(); err == nil {
+		if err := js.DeleteConsumer(streamName(channel), string(uid)); err != nil && !isNotFound(err) {
+			d.logger.Error("could not delete the consumer", zap.String("subscription", string(uid)), zap.Error(err))
+		}
+	}
+}
+
+// deliver sends the event of data to destination, up to attempts times. A failed delivery is
+// negatively acknowledged for JetStream to redeliver it after the backoff of retryConfig. Once the
+// attempts are exhausted the event goes to the dead letter sink, and is only acknowledged once
+// the sink received it, JetStream redelivering it to the sink after the ack wait otherwise.
+func (d *JetStreamDispatcher) deliver(ctx context.Context, channel eventingchannels.ChannelReference, msg jetStreamMsg, data []byte, destination, reply, deadLetter *url.URL, attempts int, retryConfig *kncloudevents.RetryConfig) {
+	logger := d.logger.With(zap.String("channel", channel.String()))
+	attempt := 1
+	if meta, err := msg.Metadata(); err == nil {
+		attempt = int(meta.NumDelivered)
+	}
+
+	e := event.New()
+	if err := format.JSON.Unmarshal(data, &e); err != nil {
+		// The event could never be delivered.
+		logger.Error("could not decode the event, dropping it", zap.Error(err))
+		if err := msg.Ack(); err != nil {
+			logger.Error("failed to acknowledge message", zap.Error(err))
+		}
+		return
+	}
+	code := eventingchannels.NoResponse
+	if attempt <= attempts {
+		info, err := d.dispatcher.DispatchMessage(ctx, binding.ToMessage(&e), nil, destination, reply, nil)
+		if err == nil {
+			ackMsg(logger, msg)
+			return
+		}
+		if attempt < attempts {
+			logger.Warn("failed to deliver the event, retrying", zap.Int("attempt", attempt), zap.Error(err))
+			var backoff time.Duration
+			if retryConfig != nil {
+				backoff = retryConfig.Backoff(attempt-1, nil)
+			}
+			// JetStream holds the redelivery for the backoff, rather than the callback of the consumer.
+			nakMsg(logger, msg, backoff)
+			return
+		}
+		logger.Error("failed to deliver the event, out of attempts", zap.Int("attempt", attempt), zap.Error(err))
+		if info != nil {
+			code = info.ResponseCode
+		}
+	}
+	if deadLetter == nil {
+		ackMsg(logger, msg)
+		return
+	}
+	if _, err := d.dispatcher.DispatchMessage(ctx, withErrorExtensions(ctx, binding.ToMessage(&e), destination, code, nil), nil, deadLetter, nil, nil); err != nil {
+		// Not acknowledging the message makes JetStream redeliver it, once the sink is back.
+		logger.Error("Failed to dispatch message to the dead letter sink", zap.Error(err))
+		nakMsg(logger, msg, d.ackWait)
+		return
+	}
+	ackMsg(logger, msg)
+}
+
+func ackMsg(logger *zap.Logger, msg jetStreamMsg) {
+	if err := msg.Ack(); err != nil {
+		logger.Error("failed to acknowledge message", zap.Error(err))
+	}
+}
+
+func nakMsg(logger *zap.Logger, msg jetStreamMsg, delay time.Duration) {
+	if err := msg.NakWithDelay(delay); err != nil {
+		logger.Error("failed to negatively acknowledge message", zap.Error(err))
+	}
+}
+
+// ProcessChannels updates the channels the receiver resolves the hosts of the requests to.
+func (d *JetStreamDispatcher) ProcessChannels(ctx context.Context, chanList []messagingv1.Channel) error {
+	hostToChanMap, err := newHostNameToChannelRefMap(chanList)
+	if err != nil {
+		d.logger.Info("ProcessChannels: Error occurred when creating the new hostToChannel map.", zap.Error(err))
+		return err
+	}
+	d.routes.Store(newChannelRoutes(hostToChanMap))
+	return nil
+}
+
+func (d *JetStreamDispatcher) getChannelReferenceFromHost(host string) (eventingchannels.ChannelReference, error) {
+	route, ok := d.routes.Load().(*channelRoutes).byHost[normalizeHost(host)]
+	if !ok {
+		return eventingchannels.ChannelReference{}, eventingchannels.UnknownHostError(host)
+	}
+	return route.channel, nil
+}
+
+// ensureStream makes the stream name storing subject, unless it exists.
+func ensureStream(js jetStream, name, subject string) error {
+	if _, err := js.StreamInfo(name); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return fmt.Errorf("could not get the stream %s: %w", name, err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: name, Subjects: []string{subject}, Storage: nats.FileStorage}); err != nil {
+		return fmt.Errorf("could not add the stream %s: %w", name, err)
+	}
+	return nil
+}
+
+// deliveryAttempts returns how many times an event is delivered to a subscriber retrying its
+// deliveries with retryConfig.
+func deliveryAttempts(retryConfig *kncloudevents.RetryConfig) int {
+	if retryConfig == nil {
+		return 1
+	}
+	return 1 + retryConfig.RetryMax
+}
+
+// consumerConfig returns the config of the durable consumer of subject, delivering each event
+// once plus the retries of retryConfig. The consumers of the subscriptions with a dead letter
+// sink deliver the events until the sink received them.
+func consumerConfig(durable, subject string, ackWait time.Duration, retryConfig *kncloudevents.RetryConfig, deadLetter bool) *nats.ConsumerConfig {
+	maxDeliver := deliveryAttempts(retryConfig)
+	if deadLetter {
+		maxDeliver = -1
+	}
+	return &nats.ConsumerConfig{
+		Durable:        durable,
+		DeliverSubject: nats.NewInbox(),
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        ackWait,
+		MaxDeliver:     maxDeliver,
+		FilterSubject:  subject,
+	}
+}
+
+// streamName returns the name of the stream of channel. Stream names cannot contain dots, which
+// the names of channels may contain: they are replaced with underscores. As neither namespaces
+// nor the names of channels contain underscores, the first one separates them and the names stay
+// unique.
+func streamName(channel eventingchannels.ChannelReference) string {
+	return strings.ReplaceAll(channel.Namespace+"_"+channel.Name, ".", "_")
+}
+
+// isNotFound tells whether err is JetStream answering that a stream or consumer does not exist.
+func isNotFound(err error) bool {
+	return errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrConsumerNotFound)
+}
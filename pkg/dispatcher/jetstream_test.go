@@ -0,0 +1,793 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/format"
+	"github.com/cloudevents/sdk-go/v2/event"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/ptr"
+)
+
+// fakeJetStream is a JetStream keeping the streams and consumers it is asked to make.
+type fakeJetStream struct {
+	mu         sync.Mutex
+	streams    map[string][]string
+	consumers  map[string]*nats.ConsumerConfig
+	ackFloors  map[string]uint64
+	subscribed map[string]int
+	published  []*nats.Msg
+	publishErr error
+}
+
+func newFakeJetStream() *fakeJetStream {
+	return &fakeJetStream{
+		streams:    make(map[string][]string),
+		consumers:  make(map[string]*nats.ConsumerConfig),
+		ackFloors:  make(map[string]uint64),
+		subscribed: make(map[string]int),
+	}
+}
+
+func (f *fakeJetStream) StreamInfo(stream string, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subjects, ok := f.streams[stream]
+	if !ok {
+		return nil, nats.ErrStreamNotFound
+	}
+	return &nats.StreamInfo{Config: nats.StreamConfig{Name: stream, Subjects: subjects}}, nil
+}
+
+func (f *fakeJetStream) AddStream(cfg *nats.StreamConfig, _ ...nats.JSOpt) (*nats.StreamInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streams[cfg.Name] = cfg.Subjects
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func (f *fakeJetStream) DeleteStream(name string, _ ...nats.JSOpt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.streams[name]; !ok {
+		return nats.ErrStreamNotFound
+	}
+	delete(f.streams, name)
+	return nil
+}
+
+func (f *fakeJetStream) ConsumerInfo(stream, name string, _ ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cfg, ok := f.consumers[stream+"/"+name]
+	if !ok {
+		return nil, nats.ErrConsumerNotFound
+	}
+	return &nats.ConsumerInfo{Stream: stream, Name: name, Config: *cfg, AckFloor: nats.SequenceInfo{Stream: f.ackFloors[stream+"/"+name]}}, nil
+}
+
+func (f *fakeJetStream) AddConsumer(stream string, cfg *nats.ConsumerConfig, _ ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.consumers[stream+"/"+cfg.Durable] = cfg
+	return &nats.ConsumerInfo{Stream: stream, Name: cfg.Durable, Config: *cfg}, nil
+}
+
+func (f *fakeJetStream) DeleteConsumer(stream, consumer string, _ ...nats.JSOpt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.consumers[stream+"/"+consumer]; !ok {
+		return nats.ErrConsumerNotFound
+	}
+	delete(f.consumers, stream+"/"+consumer)
+	return nil
+}
+
+func (f *fakeJetStream) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishErr != nil {
+		return nil, f.publishErr
+	}
+	f.published = append(f.published, m)
+	return &nats.PubAck{Sequence: uint64(len(f.published))}, nil
+}
+
+func (f *fakeJetStream) Subscribe(subj string, _ nats.MsgHandler, _ ...nats.SubOpt) (*nats.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribed[subj]++
+	return &nats.Subscription{}, nil
+}
+
+func (f *fakeJetStream) consumer(stream, durable string) *nats.ConsumerConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.consumers[stream+"/"+durable]
+}
+
+// fakeJetStreamMsg is a message delivered for the numDelivered time, recording its acks.
+type fakeJetStreamMsg struct {
+	numDelivered uint64
+	acked        bool
+	naked        bool
+	nakDelay     time.Duration
+}
+
+func (m *fakeJetStreamMsg) Ack(...nats.AckOpt) error {
+	m.acked = true
+	return nil
+}
+
+func (m *fakeJetStreamMsg) NakWithDelay(delay time.Duration, _ ...nats.AckOpt) error {
+	m.naked, m.nakDelay = true, delay
+	return nil
+}
+
+func (m *fakeJetStreamMsg) Metadata() (*nats.MsgMetadata, error) {
+	return &nats.MsgMetadata{NumDelivered: m.numDelivered}, nil
+}
+
+func newTestJetStreamDispatcher(t *testing.T) (*JetStreamDispatcher, *fakeJetStream) {
+	d, err := NewJetStreamDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewJetStreamDispatcher() = %v", err)
+	}
+	js := newFakeJetStream()
+	jd := d.(*JetStreamDispatcher)
+	jd.js = js
+	return jd, js
+}
+
+func TestJetStreamUpdateSubscriptions(t *testing.T) {
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	subscriber := func(uid types.UID, retry *int32) eventingduckv1.SubscriberSpec {
+		spec := eventingduckv1.SubscriberSpec{UID: uid, SubscriberURI: apis.HTTP("subscriber.example.com")}
+		if retry != nil {
+			spec.Delivery = &eventingduckv1.DeliverySpec{Retry: retry}
+		}
+		return spec
+	}
+
+	d, js := newTestJetStreamDispatcher(t)
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{subscriber("uid-0", nil), subscriber("uid-1", ptr.Int32(3))}
+	if failed, err := d.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if got, want := js.streams["ns_channel"], []string{"channel.ns"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("subjects of the stream = %v, want %v", got, want)
+	}
+	for uid, want := range map[string]int{"uid-0": 1, "uid-1": 4} {
+		c := js.consumer("ns_channel", uid)
+		if c == nil {
+			t.Fatalf("consumer %s not made", uid)
+		}
+		if c.MaxDeliver != want {
+			t.Errorf("MaxDeliver of %s = %d, want %d", uid, c.MaxDeliver, want)
+		}
+		if c.FilterSubject != "channel.ns" || c.AckPolicy != nats.AckExplicitPolicy {
+			t.Errorf("consumer %s = %+v, want the explicit acks of channel.ns", uid, c)
+		}
+	}
+	if got := js.subscribed["channel.ns"]; got != 2 {
+		t.Errorf("subscriptions = %d, want 2", got)
+	}
+
+	// An unchanged subscriber keeps its consumer, a new retry makes it again after its ack floor.
+	js.ackFloors["ns_channel/uid-1"] = 41
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{subscriber("uid-0", nil), subscriber("uid-1", ptr.Int32(1))}
+	if failed, err := d.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if got := js.subscribed["channel.ns"]; got != 3 {
+		t.Errorf("subscriptions = %d, want 3", got)
+	}
+	if c := js.consumer("ns_channel", "uid-1"); c.MaxDeliver != 2 || c.DeliverPolicy != nats.DeliverByStartSequencePolicy || c.OptStartSeq != 42 {
+		t.Errorf("consumer uid-1 = %+v, want 2 deliveries from the sequence 42", c)
+	}
+
+	// A removed subscriber loses its consumer.
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{subscriber("uid-0", nil)}
+	if failed, err := d.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if c := js.consumer("ns_channel", "uid-1"); c != nil {
+		t.Errorf("consumer uid-1 = %+v, want deleted", c)
+	}
+
+	// An invalid delivery fails its subscriber only.
+	invalid := subscriber("uid-2", ptr.Int32(-1))
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{subscriber("uid-0", nil), invalid}
+	failed, err := d.UpdateSubscriptions(context.Background(), channel, false)
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, ok := failed[invalid]; !ok || len(failed) != 1 {
+		t.Errorf("failed subscribers = %v, want uid-2", failed)
+	}
+
+	// The finalizer deletes the consumers and the stream.
+	if _, err := d.UpdateSubscriptions(context.Background(), channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions(finalizer) = %v", err)
+	}
+	if len(js.streams) != 0 || len(js.consumers) != 0 {
+		t.Errorf("streams = %v, consumers = %v, want none", js.streams, js.consumers)
+	}
+	if len(d.subscriptions) != 0 {
+		t.Errorf("subscriptions = %v, want none", d.subscriptions)
+	}
+}
+
+func TestJetStreamUpdateSubscriptionsNotConnected(t *testing.T) {
+	d, _ := newTestJetStreamDispatcher(t)
+	d.js = nil
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if _, err := d.UpdateSubscriptions(context.Background(), newTestChannel(ref), false); err == nil {
+		t.Error("UpdateSubscriptions() = nil, want an error without a connection")
+	}
+}
+
+func TestJetStreamPublish(t *testing.T) {
+	testCases := map[string]struct {
+		publishErr error
+		connected  bool
+		wantErr    bool
+	}{
+		"published": {
+			connected: true,
+		},
+		"refused": {
+			connected:  true,
+			publishErr: errors.New("nats: no response from stream"),
+			wantErr:    true,
+		},
+		"not connected": {
+			wantErr: true,
+		},
+	}
+
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			d, js := newTestJetStreamDispatcher(t)
+			js.publishErr = tc.publishErr
+			if !tc.connected {
+				d.js = nil
+			}
+			e := event.New()
+			e.SetID("id-0")
+			e.SetSource("source")
+			e.SetType("type")
+
+			err := d.publish(context.Background(), ref, binding.ToMessage(&e), nil, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("publish() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if len(js.published) != 1 || js.published[0].Subject != "channel.ns" {
+				t.Fatalf("published = %v, want one message on channel.ns", js.published)
+			}
+			got := event.New()
+			if err := format.JSON.Unmarshal(js.published[0].Data, &got); err != nil {
+				t.Fatalf("could not decode the published event: %v", err)
+			}
+			if got.ID() != "id-0" {
+				t.Errorf("published event ID = %q, want id-0", got.ID())
+			}
+		})
+	}
+}
+
+func TestJetStreamProcessChannels(t *testing.T) {
+	d, _ := newTestJetStreamDispatcher(t)
+	channel := messagingv1.Channel{}
+	channel.Namespace, channel.Name = "ns", "channel"
+	channel.Status.Address = &duckv1.Addressable{URL: apis.HTTP("channel.ns.svc.cluster.local")}
+	if err := d.ProcessChannels(context.Background(), []messagingv1.Channel{channel}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	got, err := d.getChannelReferenceFromHost("channel.ns.svc.cluster.local")
+	if want := (eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}); err != nil || got != want {
+		t.Errorf("getChannelReferenceFromHost() = %v, %v, want %v", got, err, want)
+	}
+	if _, err := d.getChannelReferenceFromHost("unknown.ns.svc.cluster.local"); err == nil {
+		t.Error("getChannelReferenceFromHost(unknown) = nil, want an error")
+	}
+}
+
+func TestJetStreamDeliver(t *testing.T) {
+	testCases := map[string]struct {
+		status       int
+		numDelivered uint64
+		noDLS        bool
+		dlsDown      bool
+		wantAck      bool
+		wantNak      bool
+		wantNakDelay time.Duration
+		wantRequests int
+		wantDLS      int
+	}{
+		"delivered": {
+			status:       http.StatusAccepted,
+			numDelivered: 1,
+			wantAck:      true,
+			wantRequests: 1,
+		},
+		"failed, retried": {
+			status:       http.StatusInternalServerError,
+			numDelivered: 2,
+			wantNak:      true,
+			wantNakDelay: 2 * time.Millisecond,
+			wantRequests: 1,
+		},
+		"failed, last attempt": {
+			status:       http.StatusInternalServerError,
+			numDelivered: 3,
+			wantAck:      true,
+			wantRequests: 1,
+			wantDLS:      1,
+		},
+		"failed, last attempt without dead letter sink": {
+			status:       http.StatusInternalServerError,
+			numDelivered: 3,
+			noDLS:        true,
+			wantAck:      true,
+			wantRequests: 1,
+		},
+		"failed, last attempt, dead letter sink down": {
+			status:       http.StatusInternalServerError,
+			numDelivered: 3,
+			dlsDown:      true,
+			wantNak:      true,
+			wantNakDelay: defaultAckWait,
+			wantRequests: 1,
+		},
+		"redelivered to the dead letter sink": {
+			status:       http.StatusInternalServerError,
+			numDelivered: 4,
+			wantAck:      true,
+			wantDLS:      1,
+		},
+	}
+
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var requests int32
+			subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(tc.status)
+			}))
+			defer subscriber.Close()
+			dls := newDeadLetterRecorder()
+			defer dls.Close()
+			if tc.dlsDown {
+				dls.Close()
+			}
+
+			d, _ := newTestJetStreamDispatcher(t)
+			e := event.New()
+			e.SetID("id-0")
+			e.SetSource("source")
+			e.SetType("type")
+			data, err := format.JSON.Marshal(&e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			destination, _ := url.Parse(subscriber.URL)
+			var deadLetter *url.URL
+			if !tc.noDLS {
+				deadLetter, _ = url.Parse(dls.URL)
+			}
+			retryConfig := &kncloudevents.RetryConfig{RetryMax: 2, Backoff: func(attempt int, _ *http.Response) time.Duration {
+				return time.Duration(attempt+1) * time.Millisecond
+			}}
+			msg := &fakeJetStreamMsg{numDelivered: tc.numDelivered}
+
+			d.deliver(context.Background(), ref, msg, data, destination, nil, deadLetter, 3, retryConfig)
+			if msg.acked != tc.wantAck || msg.naked != tc.wantNak {
+				t.Errorf("acked, naked = %t, %t, want %t, %t", msg.acked, msg.naked, tc.wantAck, tc.wantNak)
+			}
+			if msg.nakDelay != tc.wantNakDelay {
+				t.Errorf("redelivery delay = %v, want %v", msg.nakDelay, tc.wantNakDelay)
+			}
+			if got := int(atomic.LoadInt32(&requests)); got != tc.wantRequests {
+				t.Errorf("deliveries to the subscriber = %d, want %d", got, tc.wantRequests)
+			}
+			if got := len(dls.received()); got != tc.wantDLS {
+				t.Errorf("dead letters = %d, want %d", got, tc.wantDLS)
+			}
+		})
+	}
+}
+
+func TestJetStreamReceiverValidation(t *testing.T) {
+	testCases := map[string]struct {
+		headers    map[string]string
+		wantStatus int
+	}{
+		"valid": {
+			headers:    map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "id-0", "Ce-Source": "source", "Ce-Type": "type"},
+			wantStatus: http.StatusAccepted,
+		},
+		"invalid": {
+			headers:    map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "id-0", "Ce-Source": "source"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			d, js := newTestJetStreamDispatcher(t)
+			channel := messagingv1.Channel{}
+			channel.Namespace, channel.Name = "ns", "channel"
+			channel.Status.Address = &duckv1.Addressable{URL: apis.HTTP("channel.ns.svc.cluster.local")}
+			if err := d.ProcessChannels(context.Background(), []messagingv1.Channel{channel}); err != nil {
+				t.Fatalf("ProcessChannels() = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local/", strings.NewReader("{}"))
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			d.receiverHandler().ServeHTTP(w, req)
+			if w.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tc.wantStatus, w.Body.String())
+			}
+			if wantPublished := tc.wantStatus == http.StatusAccepted; (len(js.published) == 1) != wantPublished {
+				t.Errorf("published = %d events, want published %t", len(js.published), wantPublished)
+			}
+		})
+	}
+}
+
+func TestConsumerConfig(t *testing.T) {
+	testCases := map[string]struct {
+		retry          *kncloudevents.RetryConfig
+		deadLetter     bool
+		wantMaxDeliver int
+	}{
+		"no retry": {
+			wantMaxDeliver: 1,
+		},
+		"retries": {
+			retry:          &kncloudevents.RetryConfig{RetryMax: 5},
+			wantMaxDeliver: 6,
+		},
+		"dead letter sink": {
+			retry:          &kncloudevents.RetryConfig{RetryMax: 5},
+			deadLetter:     true,
+			wantMaxDeliver: -1,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			c := consumerConfig("uid-0", "channel.ns", time.Minute, tc.retry, tc.deadLetter)
+			if c.MaxDeliver != tc.wantMaxDeliver {
+				t.Errorf("MaxDeliver = %d, want %d", c.MaxDeliver, tc.wantMaxDeliver)
+			}
+			if c.Durable != "uid-0" || c.AckWait != time.Minute || c.DeliverSubject == "" {
+				t.Errorf("consumerConfig() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestStreamName(t *testing.T) {
+	testCases := map[string]struct {
+		channel eventingchannels.ChannelReference
+		want    string
+	}{
+		"plain": {
+			channel: eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"},
+			want:    "ns_channel",
+		},
+		"dotted": {
+			channel: eventingchannels.ChannelReference{Namespace: "ns", Name: "my.channel"},
+			want:    "ns_my_channel",
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if got := streamName(tc.channel); got != tc.want {
+				t.Errorf("streamName() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// runJetStreamServer runs a NATS server with JetStream enabled until the end of the test.
+func runJetStreamServer(t *testing.T) *natsserver.Server {
+	dir, err := ioutil.TempDir("", "jetstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: dir, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("the NATS server is not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// runJetStreamDispatcher runs a JetStream dispatcher connected to s until the end of the test.
+func runJetStreamDispatcher(t *testing.T, s *natsserver.Server) *JetStreamDispatcher {
+	t.Helper()
+	nd, err := NewJetStreamDispatcher(Args{NatssURL: s.ClientURL()})
+	if err != nil {
+		t.Fatalf("NewJetStreamDispatcher() = %v", err)
+	}
+	d := nd.(*JetStreamDispatcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		d.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for !d.Health().Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("the dispatcher did not connect to the NATS server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return d
+}
+
+func TestJetStreamServer(t *testing.T) {
+	s := runJetStreamServer(t)
+
+	// The subscriber fails the first delivery, JetStream redelivering the event.
+	var requests int32
+	received := make(chan string, 2)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received <- r.Header.Get("Ce-Id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	ctx := context.Background()
+	d := runJetStreamDispatcher(t, s)
+	deadline := time.Now().Add(10 * time.Second)
+
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	delay := "PT0.05S"
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+		Delivery:      &eventingduckv1.DeliverySpec{Retry: ptr.Int32(2), BackoffDelay: &delay},
+	}}
+	if failed, err := d.UpdateSubscriptions(ctx, channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	e := event.New()
+	e.SetID("id-0")
+	e.SetSource("source")
+	e.SetType("type")
+	if err := d.publish(ctx, ref, binding.ToMessage(&e), nil, nil); err != nil {
+		t.Fatalf("publish() = %v", err)
+	}
+	select {
+	case id := <-received:
+		if id != "id-0" {
+			t.Errorf("delivered event ID = %q, want id-0", id)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the event was not redelivered")
+	}
+
+	// The redelivered event is acknowledged.
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		info, err := js.ConsumerInfo("ns_channel", "uid-0")
+		if err != nil {
+			t.Fatalf("ConsumerInfo() = %v", err)
+		}
+		if info.AckFloor.Stream == 1 && info.NumAckPending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the event was not acknowledged: %+v", info)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("deliveries = %d, want 2", got)
+	}
+
+	// The finalized channel loses its stream.
+	if _, err := d.UpdateSubscriptions(ctx, channel, true); err != nil {
+		t.Fatalf("UpdateSubscriptions(finalizer) = %v", err)
+	}
+	if _, err := js.StreamInfo("ns_channel"); !errors.Is(err, nats.ErrStreamNotFound) {
+		t.Errorf("StreamInfo() = %v, want %v", err, nats.ErrStreamNotFound)
+	}
+}
+
+func TestJetStreamLeadershipTransfer(t *testing.T) {
+	s := runJetStreamServer(t)
+	received := make(chan string, 4)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Ce-Id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	// Two replicas of the dispatcher on the same NATS server.
+	first := runJetStreamDispatcher(t, s)
+	second := runJetStreamDispatcher(t, s)
+
+	ctx := context.Background()
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+	}}
+	leader := first
+	leads := func(d *JetStreamDispatcher) func(eventingchannels.ChannelReference) bool {
+		return func(eventingchannels.ChannelReference) bool { return leader == d }
+	}
+	held := func(d *JetStreamDispatcher) int {
+		d.subscriptionsMux.Lock()
+		defer d.subscriptionsMux.Unlock()
+		return len(d.subscriptions[ref])
+	}
+	// demote demotes d, returning once its subscriptions are drained, so that the events are
+	// published once NATS no longer sends them to it.
+	demote := func(d *JetStreamDispatcher) {
+		t.Helper()
+		d.subscriptionsMux.Lock()
+		var subs []*nats.Subscription
+		for _, sub := range d.subscriptions[ref] {
+			subs = append(subs, sub)
+		}
+		d.subscriptionsMux.Unlock()
+		d.Demote(leads(d))
+		for _, sub := range subs {
+			deadline := time.Now().Add(10 * time.Second)
+			for sub.IsValid() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if sub.IsValid() {
+				t.Fatal("the subscription of the demoted replica was not drained")
+			}
+		}
+	}
+	assertLedBy := func(step string, d *JetStreamDispatcher) {
+		t.Helper()
+		other := first
+		if d == first {
+			other = second
+		}
+		if held(d) != 1 || held(other) != 0 {
+			t.Errorf("%s: subscriptions held %d by the leader and %d by the follower, want 1 and 0", step, held(d), held(other))
+		}
+	}
+	publish := func(id string) {
+		t.Helper()
+		e := event.New()
+		e.SetID(id)
+		e.SetSource("source")
+		e.SetType("type")
+		if err := second.publish(ctx, ref, binding.ToMessage(&e), nil, nil); err != nil {
+			t.Fatalf("publish() = %v", err)
+		}
+	}
+	waitFor := func(id string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != id {
+				t.Errorf("delivered event ID = %q, want %s", got, id)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("the event %s was not delivered", id)
+		}
+	}
+
+	first.Promote(leads(first))
+	second.Demote(leads(second))
+	// Only the leader subscribes, whichever replica the channel is updated on.
+	for _, d := range []*JetStreamDispatcher{first, second} {
+		if failed, err := d.UpdateSubscriptions(ctx, channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+	assertLedBy("initial", first)
+	publish("id-0")
+	waitFor("id-0")
+
+	// The old leader is demoted before the new one is promoted, as the leases expire. The events
+	// published meanwhile are kept by the consumer for the new leader.
+	leader = second
+	demote(first)
+	if held(first) != 0 || held(second) != 0 {
+		t.Errorf("subscriptions held during the transfer %d and %d, want none", held(first), held(second))
+	}
+	publish("id-1")
+	second.Promote(leads(second))
+	assertLedBy("transferred", second)
+	waitFor("id-1")
+
+	// Back to the first replica.
+	leader = first
+	demote(second)
+	first.Promote(leads(first))
+	assertLedBy("transferred back", first)
+	publish("id-2")
+	waitFor("id-2")
+
+	// Each event was delivered once.
+	select {
+	case id := <-received:
+		t.Errorf("event %s delivered again", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
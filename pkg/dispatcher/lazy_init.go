@@ -0,0 +1,322 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+const (
+	// initReasonEvent is the reason of the deferred subscriptions made for an event sent to the
+	// channel.
+	initReasonEvent = "event"
+	// initReasonProbe is the reason of the deferred subscriptions made by the probe of the
+	// subscribers.
+	initReasonProbe = "probe"
+	// initReasonEager is the reason of the deferred subscriptions made because the channel is no
+	// longer lazy, or its position could not be captured.
+	initReasonEager = "eager"
+)
+
+// captureTimeout is how long the last event of a NATSS channel is waited for when capturing its
+// position, the channel being taken for empty beyond.
+var captureTimeout = time.Second
+
+// LazySubscriber is implemented by the dispatchers able to defer the subscriptions of a channel
+// until it is sent an event.
+type LazySubscriber interface {
+	// SetSubscriptionInit sets when the subscriptions of channel are made. It must be called
+	// before updating the subscriptions of the channel to take effect.
+	SetSubscriptionInit(channel eventingchannels.ChannelReference, init v1beta1.SubscriptionInit)
+	// WatchSubscriptionInit sets the function called once the deferred subscriptions of channel
+	// are made, nil removing it.
+	WatchSubscriptionInit(channel eventingchannels.ChannelReference, notify func())
+}
+
+var _ LazySubscriber = (*SubscriptionsSupervisor)(nil)
+
+// deferredChannel is a lazy channel whose subscriptions are not made yet.
+type deferredChannel struct {
+	subscribedChannel
+	since time.Time
+	// captured is closed once start is set.
+	captured chan struct{}
+	// start is the sequence of the NATSS channel when the subscriptions were deferred, zero when
+	// it was empty, and startErr the error of its capture.
+	start    uint64
+	startErr error
+	// initializing is 1 once the subscriptions are being made.
+	initializing int32
+}
+
+// lazyStart is where the subscriptions deferred on a channel start, when they have no durable
+// to resume from.
+type lazyStart struct {
+	// sequence is the first sequence delivered, zero for all the available events.
+	sequence uint64
+	// pending are the subscriptions not made yet.
+	pending map[types.UID]bool
+}
+
+func (l *lazyStart) option() stan.SubscriptionOption {
+	if l.sequence == 0 {
+		return stan.DeliverAllAvailable()
+	}
+	return stan.StartAtSequence(l.sequence)
+}
+
+// SetSubscriptionInit implements LazySubscriber.
+func (s *SubscriptionsSupervisor) SetSubscriptionInit(channel eventingchannels.ChannelReference, init v1beta1.SubscriptionInit) {
+	if init.OrDefault() == v1beta1.SubscriptionInitEager {
+		s.subscriptionInits.Delete(channel)
+		return
+	}
+	s.subscriptionInits.Store(channel, init)
+}
+
+// WatchSubscriptionInit implements LazySubscriber.
+func (s *SubscriptionsSupervisor) WatchSubscriptionInit(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.subscriptionInitNotifiers.Delete(channel)
+		return
+	}
+	s.subscriptionInitNotifiers.Store(channel, notify)
+}
+
+func (s *SubscriptionsSupervisor) isLazy(channel eventingchannels.ChannelReference) bool {
+	_, ok := s.subscriptionInits.Load(channel)
+	return ok
+}
+
+func (s *SubscriptionsSupervisor) deferredChannel(channel eventingchannels.ChannelReference) (*deferredChannel, bool) {
+	d, ok := s.deferred.Load(channel)
+	if !ok {
+		return nil, false
+	}
+	return d.(*deferredChannel), true
+}
+
+// keepDeferred tells whether the update of channel leaves its subscriptions deferred. The
+// subscriptions of a lazy channel without any are deferred, its position being captured in the
+// background, until it is sent an event. A channel no longer lazy is subscribed by the update.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) keepDeferred(ctx context.Context, cRef eventingchannels.ChannelReference, channel *messagingv1.Channel, isFinalizer bool) bool {
+	d, deferred := s.deferredChannel(cRef)
+	switch {
+	case isFinalizer:
+		s.deferred.Delete(cRef)
+		delete(s.lazyStarts, cRef)
+		return false
+	case deferred && s.isLazy(cRef):
+		d.ctx, d.channel = ctx, channel.DeepCopy()
+		return true
+	case deferred:
+		<-d.captured
+		s.undefer(cRef, d)
+		return false
+	}
+	// The subscriptions failing after they were deferred start from the position captured then.
+	if _, started := s.lazyStarts[cRef]; started || !s.isLazy(cRef) || len(s.subscriptions[cRef]) > 0 || len(channel.Spec.Subscribers) == 0 {
+		return false
+	}
+	d = &deferredChannel{
+		subscribedChannel: subscribedChannel{ctx: ctx, channel: channel.DeepCopy()},
+		since:             time.Now(),
+		captured:          make(chan struct{}),
+	}
+	s.deferred.Store(cRef, d)
+	s.subscriptionsLogger.Info("Deferring the subscriptions of the lazy channel", zap.String("channel", cRef.String()))
+	go s.captureStart(ctx, cRef, d)
+	return true
+}
+
+// captureStart captures the position of the NATSS channel of the deferred channel. The
+// subscriptions are made right away when it cannot be captured.
+func (s *SubscriptionsSupervisor) captureStart(ctx context.Context, channel eventingchannels.ChannelReference, d *deferredChannel) {
+	conn, err := s.connection(ctx, channel)
+	if err == nil {
+		d.start, err = captureSequence(*conn, getSubject(channel))
+	}
+	d.startErr = err
+	close(d.captured)
+	if err != nil {
+		s.subscriptionsLogger.Warn("Failed to capture the position of the lazy channel, subscribing", zap.String("channel", channel.String()), zap.Error(err))
+		s.initializeAsync(channel, d, initReasonEager)
+	}
+}
+
+// captureSequence returns the sequence the durables of subject start from to receive the events
+// stored from now on: the one of the last event stored, which is delivered again, or zero, for
+// all the available events, when none is.
+func captureSequence(conn stan.Conn, subject string) (uint64, error) {
+	last := make(chan uint64, 1)
+	sub, err := conn.Subscribe(subject, func(msg *stan.Msg) {
+		select {
+		case last <- msg.Sequence:
+		default:
+		}
+	}, stan.StartWithLastReceived())
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	timer := time.NewTimer(captureTimeout)
+	defer timer.Stop()
+	select {
+	case seq := <-last:
+		return seq, nil
+	case <-timer.C:
+		return 0, nil
+	}
+}
+
+// awaitCapture waits for the position of channel to be captured when its subscriptions are
+// deferred, so that the events received are stored after it.
+func (s *SubscriptionsSupervisor) awaitCapture(ctx context.Context, channel eventingchannels.ChannelReference) error {
+	d, ok := s.deferredChannel(channel)
+	if !ok {
+		return nil
+	}
+	select {
+	case <-d.captured:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// initializeOnEvent makes the deferred subscriptions of channel. It must be called once the
+// event received is stored.
+func (s *SubscriptionsSupervisor) initializeOnEvent(channel eventingchannels.ChannelReference) {
+	if d, ok := s.deferredChannel(channel); ok {
+		s.initializeAsync(channel, d, initReasonEvent)
+	}
+}
+
+// initializeAsync makes the deferred subscriptions of channel in the background, once its
+// position is captured. The concurrent calls for the same channel are coalesced.
+func (s *SubscriptionsSupervisor) initializeAsync(channel eventingchannels.ChannelReference, d *deferredChannel, reason string) {
+	if !atomic.CompareAndSwapInt32(&d.initializing, 0, 1) {
+		return
+	}
+	go func() {
+		<-d.captured
+		s.subscriptionsMux.Lock()
+		defer s.subscriptionsMux.Unlock()
+		if current, ok := s.deferredChannel(channel); ok && current == d {
+			s.initialize(channel, d, reason)
+		}
+	}()
+}
+
+// initialize makes the deferred subscriptions of channel. The subscriptions failing are made
+// again by the next update of the channel, which the notification triggers.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) initialize(channel eventingchannels.ChannelReference, d *deferredChannel, reason string) {
+	s.undefer(channel, d)
+	failed, _ := s.updateSubscriptions(d.ctx, channel, d.channel, false)
+	for sub, err := range failed {
+		s.subscriptionsLogger.Error("Failed to make a deferred subscription", zap.String("channel", channel.String()),
+			zap.String("subscription", string(sub.UID)), zap.Error(err))
+	}
+	s.subscriptionsLogger.Info("Made the deferred subscriptions", zap.String("channel", channel.String()),
+		zap.String("reason", reason), zap.Duration("deferred", time.Since(d.since)))
+	if notify, ok := s.subscriptionInitNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// undefer forgets the deferred channel, its subscriptions starting from the position captured.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) undefer(channel eventingchannels.ChannelReference, d *deferredChannel) {
+	s.deferred.Delete(channel)
+	if d.startErr != nil {
+		return
+	}
+	start := &lazyStart{sequence: d.start, pending: make(map[types.UID]bool, len(d.channel.Spec.Subscribers))}
+	for _, sub := range d.channel.Spec.Subscribers {
+		start.pending[sub.UID] = true
+	}
+	s.lazyStarts[channel] = start
+}
+
+// lazyStartOption returns the start position of the subscription deferred on channel, false
+// when it was not deferred.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) lazyStartOption(channel eventingchannels.ChannelReference, uid types.UID) (stan.SubscriptionOption, bool) {
+	start, ok := s.lazyStarts[channel]
+	if !ok || !start.pending[uid] {
+		return nil, false
+	}
+	return start.option(), true
+}
+
+// startedLazily records that the subscription deferred on channel was made.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) startedLazily(channel eventingchannels.ChannelReference, uid types.UID) {
+	start, ok := s.lazyStarts[channel]
+	if !ok {
+		return
+	}
+	delete(start.pending, uid)
+	if len(start.pending) == 0 {
+		delete(s.lazyStarts, channel)
+	}
+}
+
+// runLazyProbes makes, until ctx is done, the deferred subscriptions of the channels deferred
+// for longer than a probe interval of the subscribers, bounding how long a channel without events
+// goes without them.
+func (s *SubscriptionsSupervisor) runLazyProbes(ctx context.Context) {
+	if s.unhealthyProbeInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.unhealthyProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.initializeDeferred(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// initializeDeferred makes the subscriptions of the channels deferred for longer than a probe
+// interval at now.
+func (s *SubscriptionsSupervisor) initializeDeferred(now time.Time) {
+	s.deferred.Range(func(key, value interface{}) bool {
+		if d := value.(*deferredChannel); now.Sub(d.since) >= s.unhealthyProbeInterval {
+			s.initializeAsync(key.(eventingchannels.ChannelReference), d, initReasonProbe)
+		}
+		return true
+	})
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// sequencedStanConn stores the messages published on its fakeStanConn with a sequence, and
+// delivers them to the new subscriptions from their start position like NATSS does. Its
+// subscriptions take subscribeDelay, the round-trip to NATSS.
+type sequencedStanConn struct {
+	*fakeStanConn
+
+	subscribeDelay time.Duration
+
+	mu       sync.Mutex
+	stored   []*stan.Msg
+	subjects map[string]bool
+}
+
+func (c *sequencedStanConn) Publish(_ string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := &stan.Msg{MsgProto: pb.MsgProto{Sequence: uint64(len(c.stored) + 1), Data: data}}
+	c.stored = append(c.stored, msg)
+	c.fakeStanConn.publish(msg)
+	return nil
+}
+
+func (c *sequencedStanConn) Subscribe(subject string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	time.Sleep(c.subscribeDelay)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subjects[subject] = true
+	sub, err := c.fakeStanConn.Subscribe(subject, cb, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var backlog []*stan.Msg
+	o := subscriptionOptions(opts)
+	switch o.StartAt {
+	case pb.StartPosition_First:
+		backlog = c.stored
+	case pb.StartPosition_SequenceStart:
+		if o.StartSequence > 0 && int(o.StartSequence) <= len(c.stored) {
+			backlog = c.stored[o.StartSequence-1:]
+		}
+	case pb.StartPosition_LastReceived:
+		if len(c.stored) > 0 {
+			backlog = c.stored[len(c.stored)-1:]
+		}
+	}
+	backlog = append([]*stan.Msg(nil), backlog...)
+	go func() {
+		for _, msg := range backlog {
+			cb(msg)
+		}
+	}()
+	return sub, nil
+}
+
+func newSequencedSupervisor(t testing.TB) (*SubscriptionsSupervisor, *sequencedStanConn) {
+	s, conn := newTestSupervisor(t)
+	sequenced := &sequencedStanConn{fakeStanConn: conn, subjects: make(map[string]bool)}
+	var natssConn stan.Conn = sequenced
+	s.natssConn = &natssConn
+	return s, sequenced
+}
+
+// newLazyChannel defers the subscriptions of a lazy channel of subscriber, waiting for its
+// position to be captured. The returned counter tells how many times its deferred subscriptions
+// were made.
+func newLazyChannel(t *testing.T, s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference, subscriber *eventRecorder) *int32 {
+	t.Helper()
+	var initialized int32
+	s.SetSubscriptionInit(ref, v1beta1.SubscriptionInitLazy)
+	s.WatchSubscriptionInit(ref, func() { atomic.AddInt32(&initialized, 1) })
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	d, ok := s.deferredChannel(ref)
+	if !ok {
+		t.Fatal("the subscriptions of the lazy channel were not deferred")
+	}
+	if n := len(s.subscriptions[ref]); n != 0 {
+		t.Fatalf("%d subscriptions made, want them to be deferred", n)
+	}
+	<-d.captured
+	return &initialized
+}
+
+func waitForInit(t *testing.T, initialized *int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(initialized) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(initialized) == 0 {
+		t.Fatal("the deferred subscriptions were not made")
+	}
+}
+
+func waitForEvents(t *testing.T, subscriber *eventRecorder, want ...string) {
+	t.Helper()
+	var got []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		got = subscriber.received()
+		if len(got) >= len(want) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Leave the time for an unexpected event to arrive.
+	time.Sleep(50 * time.Millisecond)
+	got = subscriber.received()
+	sort.Strings(got)
+	sort.Strings(want)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected events received (-want, +got): %s", diff)
+	}
+}
+
+func TestLazySubscriptionInitOnEvent(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newSequencedSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	publishTestEvent(t, s, ref, "old")
+	publishTestEvent(t, s, ref, "last")
+	initialized := newLazyChannel(t, s, ref, subscriber)
+
+	// An event stored by another publisher before the first event the receiver gets.
+	if err := conn.Publish(getSubject(ref), newTestEventMsg(t, "between").Data); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if got := subscriber.received(); len(got) != 0 {
+		t.Fatalf("received %v before the first event, want the subscriptions to be deferred", got)
+	}
+
+	publishTestEvent(t, s, ref, "first")
+	waitForInit(t, initialized)
+	// The last event stored when the subscriptions were deferred is delivered again, the
+	// position being captured from it.
+	waitForEvents(t, subscriber, "last", "between", "first")
+
+	s.subscriptionsMux.Lock()
+	subscriptions, starts := len(s.subscriptions[ref]), len(s.lazyStarts)
+	s.subscriptionsMux.Unlock()
+	if subscriptions != 1 || starts != 0 {
+		t.Errorf("%d subscriptions and %d lazy starts left, want 1 and none", subscriptions, starts)
+	}
+	if _, deferred := s.deferredChannel(ref); deferred {
+		t.Error("the channel is still deferred")
+	}
+}
+
+func TestLazySubscriptionInitEmptyChannel(t *testing.T) {
+	defer func(timeout time.Duration) { captureTimeout = timeout }(captureTimeout)
+	captureTimeout = 50 * time.Millisecond
+
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, _ := newSequencedSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	initialized := newLazyChannel(t, s, ref, subscriber)
+
+	publishTestEvent(t, s, ref, "first")
+	waitForInit(t, initialized)
+	waitForEvents(t, subscriber, "first")
+}
+
+func TestLazySubscriptionInitProbe(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newSequencedSupervisor(t)
+	s.unhealthyProbeInterval = time.Minute
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	publishTestEvent(t, s, ref, "last")
+	initialized := newLazyChannel(t, s, ref, subscriber)
+	if err := conn.Publish(getSubject(ref), newTestEventMsg(t, "between").Data); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	s.initializeDeferred(time.Now())
+	if _, deferred := s.deferredChannel(ref); !deferred {
+		t.Fatal("the subscriptions were made before a probe interval")
+	}
+	s.initializeDeferred(time.Now().Add(2 * time.Minute))
+	waitForInit(t, initialized)
+	waitForEvents(t, subscriber, "last", "between")
+}
+
+func TestLazySubscriptionInitUpdates(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, _ := newSequencedSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	initialized := newLazyChannel(t, s, ref, subscriber)
+
+	// The updates of a lazy channel leave its subscriptions deferred.
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if _, deferred := s.deferredChannel(ref); !deferred {
+		t.Fatal("the update of the lazy channel made its subscriptions")
+	}
+
+	// A channel made eager is subscribed by its next update.
+	s.SetSubscriptionInit(ref, v1beta1.SubscriptionInitEager)
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if _, deferred := s.deferredChannel(ref); deferred {
+		t.Error("the eager channel is still deferred")
+	}
+	if n := len(s.subscriptions[ref]); n != 1 {
+		t.Errorf("%d subscriptions, want 1", n)
+	}
+	if got := atomic.LoadInt32(initialized); got != 0 {
+		t.Errorf("notified %d times, want the update to report the subscriptions itself", got)
+	}
+}
+
+func TestLazySubscriptionInitFinalize(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, _ := newSequencedSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	newLazyChannel(t, s, ref, subscriber)
+
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, deferred := s.deferredChannel(ref); deferred {
+		t.Error("the finalized channel is still deferred")
+	}
+}
+
+// TestLazySubscriptionInitStartup compares the time taken to reconcile channels whose
+// subscriptions are made eagerly, each taking a round-trip to NATSS, with the lazy ones.
+func TestLazySubscriptionInitStartup(t *testing.T) {
+	defer func(timeout time.Duration) { captureTimeout = timeout }(captureTimeout)
+	captureTimeout = 10 * time.Millisecond
+
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	const channels = 40
+	const subscribeDelay = 5 * time.Millisecond
+	reconcile := func(init v1beta1.SubscriptionInit) (*SubscriptionsSupervisor, time.Duration) {
+		s, conn := newSequencedSupervisor(t)
+		conn.subscribeDelay = subscribeDelay
+		start := time.Now()
+		for i := 0; i < channels; i++ {
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: fmt.Sprintf("channel-%d", i)}
+			s.SetSubscriptionInit(ref, init)
+			if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+		}
+		return s, time.Since(start)
+	}
+
+	_, eager := reconcile(v1beta1.SubscriptionInitEager)
+	if eager < channels*subscribeDelay {
+		t.Fatalf("eager reconciliation took %v, want at least %v", eager, channels*subscribeDelay)
+	}
+	s, lazy := reconcile(v1beta1.SubscriptionInitLazy)
+	if lazy >= eager/4 {
+		t.Errorf("lazy reconciliation took %v, want less than a quarter of the eager one, %v", lazy, eager)
+	}
+
+	// The events sent once reconciled are delivered.
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel-0"}
+	d, _ := s.deferredChannel(ref)
+	var initialized int32
+	s.WatchSubscriptionInit(ref, func() { atomic.AddInt32(&initialized, 1) })
+	publishTestEvent(t, s, ref, "first")
+	<-d.captured
+	waitForInit(t, &initialized)
+	waitForEvents(t, subscriber, "first")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// LeadershipHandler is implemented by the dispatchers whose replicas only make the subscriptions
+// of the channels they lead, so that the events are not delivered once by each replica.
+type LeadershipHandler interface {
+	// Promote makes the subscriptions of the channels leads tells the replica now leads, from
+	// the last version of the channels they were updated with.
+	Promote(leads func(eventingchannels.ChannelReference) bool)
+	// Demote closes the subscriptions of the channels leads tells the replica no longer leads,
+	// keeping their durables for the new leader to resume from.
+	Demote(leads func(eventingchannels.ChannelReference) bool)
+}
+
+var _ LeadershipHandler = (*SubscriptionsSupervisor)(nil)
+
+// Promote implements LeadershipHandler.
+func (s *SubscriptionsSupervisor) Promote(leads func(eventingchannels.ChannelReference) bool) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+
+	s.leads = leads
+	for channel, subscribed := range s.demoted {
+		if !leads(channel) {
+			continue
+		}
+		delete(s.demoted, channel)
+		failed, _ := s.updateSubscriptions(subscribed.ctx, channel, subscribed.channel, false)
+		for sub, err := range failed {
+			s.subscriptionsLogger.Error("Failed to subscribe on promotion", zap.String("channel", channel.String()),
+				zap.String("subscription", string(sub.UID)), zap.Error(err))
+		}
+		s.subscriptionsLogger.Info("Subscribed on promotion", zap.String("channel", channel.String()),
+			zap.Int("subscriptions", len(s.subscriptions[channel])))
+	}
+}
+
+// Demote implements LeadershipHandler.
+func (s *SubscriptionsSupervisor) Demote(leads func(eventingchannels.ChannelReference) bool) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	defer s.recordActiveSubscriptions()
+
+	s.leads = leads
+	for channel := range s.subscriptions {
+		if leads(channel) {
+			continue
+		}
+		subscribed, ok := s.subscribedChannels[channel]
+		s.subscriptionsLogger.Info("Closing the subscriptions of the channel no longer led", zap.String("channel", channel.String()))
+		s.closeDemoted(channel)
+		if ok {
+			s.demoted[channel] = subscribed
+		}
+	}
+}
+
+// leadsChannel tells whether the replica leads channel, which it does for all the channels until
+// it is promoted or demoted.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) leadsChannel(channel eventingchannels.ChannelReference) bool {
+	return s.leads == nil || s.leads(channel)
+}
+
+// closeDemoted closes the subscriptions of channel, which the replica no longer leads. Closing,
+// unlike unsubscribing, keeps the durables and their position.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) closeDemoted(channel eventingchannels.ChannelReference) {
+	for uid, sub := range s.subscriptions[channel] {
+		s.stopMigration(uid, false)
+		if err := (*sub).Close(); err != nil {
+			s.subscriptionsLogger.Error("Closing NATSS Streaming subscription failed", zap.String("channel", channel.String()),
+				zap.String("subscription", string(uid)), zap.Error(err))
+		}
+		s.cursors.close(channel, uid, false)
+	}
+	s.forgetChannel(channel)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestLeadershipTransfer(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	// Two replicas of the dispatcher subscribing on the same NATSS server.
+	first, conn := newTestSupervisor(t)
+	second, _ := newTestSupervisor(t)
+	var natssConn stan.Conn = conn
+	second.natssConn = &natssConn
+
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	leader := first
+	leads := func(s *SubscriptionsSupervisor) func(eventingchannels.ChannelReference) bool {
+		return func(eventingchannels.ChannelReference) bool { return leader == s }
+	}
+	held := func(s *SubscriptionsSupervisor) int {
+		s.subscriptionsMux.Lock()
+		defer s.subscriptionsMux.Unlock()
+		return len(s.subscriptions[ref])
+	}
+	assertLedBy := func(step string, s *SubscriptionsSupervisor) {
+		t.Helper()
+		other := first
+		if s == first {
+			other = second
+		}
+		if held(s) != 1 || held(other) != 0 {
+			t.Errorf("%s: subscriptions held %d by the leader and %d by the follower, want 1 and 0", step, held(s), held(other))
+		}
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if len(conn.subs) != 1 {
+			t.Errorf("%s: %d subscriptions on NATSS, want 1", step, len(conn.subs))
+		}
+	}
+
+	first.Promote(leads(first))
+	second.Demote(leads(second))
+	// Only the leader subscribes, whichever replica the channel is updated on.
+	for _, s := range []*SubscriptionsSupervisor{first, second} {
+		if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+	}
+	assertLedBy("initial", first)
+
+	// The old leader is demoted before the new one is promoted, as the leases expire.
+	leader = second
+	first.Demote(leads(first))
+	if held(first) != 0 || held(second) != 0 {
+		t.Errorf("subscriptions held during the transfer %d and %d, want none", held(first), held(second))
+	}
+	second.Promote(leads(second))
+	assertLedBy("transferred", second)
+
+	// The events are delivered once, by the new leader.
+	publishTestEvents(t, conn, ref, 2)
+	waitForEvents(t, subscriber, "id-0", "id-1")
+
+	// Back to the first replica.
+	leader = first
+	second.Demote(leads(second))
+	first.Promote(leads(first))
+	assertLedBy("transferred back", first)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Priority orders the hooks of a Lifecycle: the hooks start by increasing priority and stop in
+// the reverse order.
+type Priority int
+
+// The priorities of the components of the dispatcher. Extensions register their hooks between
+// them, for example PriorityReceiver - 1 to start before the receiver accepts events.
+const (
+	// PriorityConnection is the priority of the connection to NATSS.
+	PriorityConnection Priority = 100
+	// PrioritySubscriptions is the priority of the subscriptions and of the background workers
+	// delivering to the subscribers and audit sinks.
+	PrioritySubscriptions Priority = 200
+	// PriorityReceiver is the priority of the receiver of the events sent to the channels.
+	PriorityReceiver Priority = 300
+	// PriorityAdminServer is the priority of the servers of the operators, such as the orphaned
+	// durables report.
+	PriorityAdminServer Priority = 400
+)
+
+// DefaultShutdownTimeout bounds the time the hooks of a Lifecycle take to stop.
+const DefaultShutdownTimeout = 90 * time.Second
+
+// Hook is a component of the dispatcher started and stopped by a Lifecycle.
+type Hook struct {
+	// Name identifies the hook in the logs.
+	Name     string
+	Priority Priority
+	// Start starts the component, without blocking. A failure stops the hooks already started.
+	Start func(ctx context.Context) error
+	// Stop stops the component before the deadline of ctx, after which the Lifecycle stops the
+	// next hook without waiting for it.
+	Stop func(ctx context.Context) error
+	// StopTimeout bounds Stop, zero leaving it the remainder of the shutdown timeout.
+	StopTimeout time.Duration
+}
+
+// Lifecycle starts hooks by priority and stops them in the reverse order, within a bounded time.
+type Lifecycle struct {
+	logger          *zap.Logger
+	shutdownTimeout time.Duration
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started []Hook
+	running bool
+	// failed receives the errors of the hooks failing once started.
+	failed chan error
+}
+
+// LifecycleParticipant is implemented by the dispatchers whose components are started and
+// stopped by a Lifecycle shared with the components of the process embedding them.
+type LifecycleParticipant interface {
+	// RegisterHooks registers the hooks of the components of the dispatcher to l.
+	RegisterHooks(l *Lifecycle) error
+}
+
+// NewLifecycle returns a Lifecycle stopping its hooks within shutdownTimeout, zero or less
+// meaning DefaultShutdownTimeout.
+func NewLifecycle(logger *zap.Logger, shutdownTimeout time.Duration) *Lifecycle {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+	return &Lifecycle{
+		logger:          logger,
+		shutdownTimeout: shutdownTimeout,
+		failed:          make(chan error, 1),
+	}
+}
+
+// Register adds h to the hooks, after those of the same priority registered before it. The
+// hooks cannot be registered once the Lifecycle started.
+func (l *Lifecycle) Register(h Hook) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running {
+		return fmt.Errorf("cannot register hook %q: the lifecycle already started", h.Name)
+	}
+	l.hooks = append(l.hooks, h)
+	return nil
+}
+
+// Start starts the hooks by increasing priority. When a hook fails to start, the hooks already
+// started are stopped and the error is returned.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	if l.running {
+		l.mu.Unlock()
+		return errors.New("the lifecycle already started")
+	}
+	l.running = true
+	hooks := make([]Hook, len(l.hooks))
+	copy(hooks, l.hooks)
+	l.mu.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Priority < hooks[j].Priority })
+	for _, h := range hooks {
+		l.logger.Debug("Starting hook", zap.String("hook", h.Name))
+		if h.Start != nil {
+			if err := h.Start(ctx); err != nil {
+				l.Stop(context.Background())
+				return fmt.Errorf("failed to start %s: %w", h.Name, err)
+			}
+		}
+		l.mu.Lock()
+		l.started = append(l.started, h)
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop stops the started hooks in the reverse order of their start, within the shutdown timeout
+// and the deadline of ctx. A hook not stopping within its budget is left behind and the next one
+// is stopped. The errors of the hooks are logged and the first one returned.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	started := l.started
+	l.started = nil
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, l.shutdownTimeout)
+	defer cancel()
+	var first error
+	for i := len(started) - 1; i >= 0; i-- {
+		h := started[i]
+		if h.Stop == nil {
+			continue
+		}
+		if err := l.stopHook(ctx, h); err != nil {
+			l.logger.Error("Failed to stop hook", zap.String("hook", h.Name), zap.Error(err))
+			if first == nil {
+				first = fmt.Errorf("failed to stop %s: %w", h.Name, err)
+			}
+		}
+	}
+	return first
+}
+
+func (l *Lifecycle) stopHook(ctx context.Context, h Hook) error {
+	if h.StopTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.StopTimeout)
+		defer cancel()
+	}
+	l.logger.Debug("Stopping hook", zap.String("hook", h.Name))
+	// The hook is waited for until its deadline only, so that a hanging hook does not hold the
+	// others.
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run starts the hooks and stops them once ctx is done or a hook started with RunHook fails,
+// returning the error of the failed hook.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	if err := l.Start(ctx); err != nil {
+		return err
+	}
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-l.failed:
+	}
+	// ctx is done, the hooks are stopped without it.
+	if stopErr := l.Stop(context.Background()); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// RunHook returns a hook running run in the background, from its start until its stop, for the
+// components blocking until their context is done. The hook stops by cancelling the context of
+// run and waiting for it to return. When run fails before its stop, the Lifecycle running the
+// hook stops.
+func (l *Lifecycle) RunHook(name string, priority Priority, run func(ctx context.Context) error) Hook {
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+	return Hook{
+		Name:     name,
+		Priority: priority,
+		Start: func(ctx context.Context) error {
+			var runCtx context.Context
+			// The hook is cancelled by its stop rather than by ctx, to stop in order.
+			runCtx, cancel = context.WithCancel(valuesOnlyContext{ctx})
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				if err := run(runCtx); err != nil && runCtx.Err() == nil {
+					select {
+					case l.failed <- fmt.Errorf("%s failed: %w", name, err):
+					default:
+						// Another hook already failed.
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// valuesOnlyContext keeps the values of its parent, such as the logger, but neither its deadline
+// nor its cancellation.
+type valuesOnlyContext struct {
+	context.Context
+}
+
+func (valuesOnlyContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnlyContext) Done() <-chan struct{}       { return nil }
+func (valuesOnlyContext) Err() error                  { return nil }
@@ -0,0 +1,245 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+// hookRecorder records the starts and stops of the hooks.
+type hookRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *hookRecorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *hookRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func (r *hookRecorder) hook(name string, priority Priority) Hook {
+	return Hook{
+		Name:     name,
+		Priority: priority,
+		Start: func(context.Context) error {
+			r.record("start " + name)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func mustRegister(t *testing.T, l *Lifecycle, hooks ...Hook) {
+	t.Helper()
+	for _, h := range hooks {
+		if err := l.Register(h); err != nil {
+			t.Fatalf("Register(%s) = %v", h.Name, err)
+		}
+	}
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	r := &hookRecorder{}
+	l := NewLifecycle(zap.NewNop(), 0)
+	mustRegister(t, l,
+		r.hook("admin", PriorityAdminServer),
+		r.hook("receiver", PriorityReceiver),
+		r.hook("connection", PriorityConnection),
+		r.hook("subscriptions", PrioritySubscriptions),
+		r.hook("audit", PrioritySubscriptions),
+	)
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := l.Register(r.hook("late", PriorityReceiver)); err == nil {
+		t.Error("Register() succeeded once the lifecycle started")
+	}
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+
+	want := []string{
+		"start connection", "start subscriptions", "start audit", "start receiver", "start admin",
+		"stop admin", "stop receiver", "stop audit", "stop subscriptions", "stop connection",
+	}
+	if diff := cmp.Diff(want, r.recorded()); diff != "" {
+		t.Errorf("calls (-want, +got) = %s", diff)
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	r := &hookRecorder{}
+	l := NewLifecycle(zap.NewNop(), 0)
+	failing := r.hook("receiver", PriorityReceiver)
+	failing.Start = func(context.Context) error {
+		return errors.New("address already in use")
+	}
+	mustRegister(t, l,
+		r.hook("connection", PriorityConnection),
+		r.hook("subscriptions", PrioritySubscriptions),
+		failing,
+		r.hook("admin", PriorityAdminServer),
+	)
+
+	if err := l.Start(context.Background()); err == nil {
+		t.Fatal("Start() succeeded with a failing hook")
+	}
+	want := []string{"start connection", "start subscriptions", "stop subscriptions", "stop connection"}
+	if diff := cmp.Diff(want, r.recorded()); diff != "" {
+		t.Errorf("calls (-want, +got) = %s", diff)
+	}
+}
+
+func TestLifecycleHangingHook(t *testing.T) {
+	r := &hookRecorder{}
+	l := NewLifecycle(zap.NewNop(), time.Second)
+	hanging := r.hook("subscriptions", PrioritySubscriptions)
+	hanging.StopTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	hanging.Stop = func(context.Context) error {
+		// Ignores its deadline.
+		<-release
+		return nil
+	}
+	mustRegister(t, l, r.hook("connection", PriorityConnection), hanging, r.hook("receiver", PriorityReceiver))
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	start := time.Now()
+	err := l.Stop(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Stop() took %v, want the hanging hook to be left after its budget", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() = %v, want the deadline of the hanging hook exceeded", err)
+	}
+	want := []string{"start connection", "start subscriptions", "start receiver", "stop receiver", "stop connection"}
+	if diff := cmp.Diff(want, r.recorded()); diff != "" {
+		t.Errorf("calls (-want, +got) = %s", diff)
+	}
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	l := NewLifecycle(zap.NewNop(), 50*time.Millisecond)
+	for _, name := range []string{"first", "second"} {
+		mustRegister(t, l, Hook{
+			Name:     name,
+			Priority: PrioritySubscriptions,
+			Stop: func(ctx context.Context) error {
+				// Honours its deadline, which the shutdown timeout bounds.
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+	}
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	start := time.Now()
+	_ = l.Stop(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Stop() took %v, want it bounded by the shutdown timeout", elapsed)
+	}
+}
+
+func TestLifecycleRun(t *testing.T) {
+	r := &hookRecorder{}
+	l := NewLifecycle(zap.NewNop(), 0)
+	mustRegister(t, l,
+		l.RunHook("connection", PriorityConnection, func(ctx context.Context) error {
+			<-ctx.Done()
+			r.record("connection done")
+			return nil
+		}),
+		l.RunHook("receiver", PriorityReceiver, func(ctx context.Context) error {
+			<-ctx.Done()
+			r.record("receiver done")
+			return nil
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Run(ctx)
+	}()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []string{"receiver done", "connection done"}
+	if diff := cmp.Diff(want, r.recorded()); diff != "" {
+		t.Errorf("calls (-want, +got) = %s", diff)
+	}
+}
+
+func TestLifecycleRunHookFailure(t *testing.T) {
+	l := NewLifecycle(zap.NewNop(), 0)
+	stopped := make(chan struct{})
+	mustRegister(t, l,
+		l.RunHook("connection", PriorityConnection, func(ctx context.Context) error {
+			<-ctx.Done()
+			close(stopped)
+			return nil
+		}),
+		l.RunHook("receiver", PriorityReceiver, func(context.Context) error {
+			return errors.New("address already in use")
+		}),
+	)
+
+	select {
+	case err := <-runAsync(l):
+		if err == nil {
+			t.Error("Run() = nil, want the error of the failed hook")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after a hook failed")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("the other hooks were not stopped after a hook failed")
+	}
+}
+
+func runAsync(l *Lifecycle) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Run(context.Background())
+	}()
+	return done
+}
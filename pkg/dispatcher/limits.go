@@ -0,0 +1,129 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+// DeliveryLimits are the limits of the deliveries of a channel.
+type DeliveryLimits struct {
+	// MaxRedirects is the number of redirects a delivery follows before failing, zero
+	// disallowing the redirects.
+	MaxRedirects int
+	// MaxInflight is how many events of a subscription NATSS sends before they are acknowledged,
+	// zero or less using the default of NATSS.
+	MaxInflight int
+	// AckWait is how long NATSS waits for the ack of an event before redelivering it, extended by
+	// the backoff of the retries of the subscription, zero using one minute.
+	AckWait time.Duration
+	// StartAt is where the subscriptions without a durable to resume from start.
+	StartAt v1beta1.StartPosition
+}
+
+// DeliveryLimitsSetter is implemented by the dispatchers able to override the delivery limits of
+// a channel, such as those of the channels of a namespace with a config-natss ConfigMap.
+type DeliveryLimitsSetter interface {
+	// SetDeliveryLimits sets the delivery limits of channel, nil restoring those of the
+	// dispatcher. The subscriptions whose MaxInflight or AckWait change are made again from their
+	// durables when the channel is updated, StartAt applying to the durables made from then on.
+	SetDeliveryLimits(channel eventingchannels.ChannelReference, limits *DeliveryLimits)
+}
+
+var _ DeliveryLimitsSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetDeliveryLimits implements DeliveryLimitsSetter.
+func (s *SubscriptionsSupervisor) SetDeliveryLimits(channel eventingchannels.ChannelReference, limits *DeliveryLimits) {
+	if limits == nil {
+		s.deliveryLimits.Delete(channel)
+		return
+	}
+	s.deliveryLimits.Store(channel, limits)
+}
+
+// DeliveryOptionsSetter is implemented by the dispatchers able to override the delivery limits of
+// a channel with the options set by the annotations of its NatssChannel.
+type DeliveryOptionsSetter interface {
+	// SetDeliveryOptions sets the delivery options of channel, taking precedence over its delivery
+	// limits. The zero values leave the delivery limits, applied as SetDeliveryLimits tells. The
+	// subscriptions of a channel delivered in order have a max inflight of one and a single
+	// consumer.
+	SetDeliveryOptions(channel eventingchannels.ChannelReference, options v1beta1.DeliveryOptions)
+}
+
+var _ DeliveryOptionsSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetDeliveryOptions implements DeliveryOptionsSetter.
+func (s *SubscriptionsSupervisor) SetDeliveryOptions(channel eventingchannels.ChannelReference, options v1beta1.DeliveryOptions) {
+	if options == (v1beta1.DeliveryOptions{}) {
+		s.deliveryOptions.Delete(channel)
+		return
+	}
+	s.deliveryOptions.Store(channel, options)
+}
+
+// deliveryLimitsOf returns the delivery limits of channel, overridden by its delivery options.
+func (s *SubscriptionsSupervisor) deliveryLimitsOf(channel eventingchannels.ChannelReference) DeliveryLimits {
+	s.configMux.RLock()
+	limits := DeliveryLimits{MaxRedirects: s.maxRedirects, MaxInflight: s.maxInflight, AckWait: s.ackWait, StartAt: s.startAt}
+	s.configMux.RUnlock()
+	if l, ok := s.deliveryLimits.Load(channel); ok {
+		limits = *l.(*DeliveryLimits)
+	}
+	if o, ok := s.deliveryOptions.Load(channel); ok {
+		options := o.(v1beta1.DeliveryOptions)
+		if options.AckWait != 0 {
+			limits.AckWait = options.AckWait
+		}
+		if options.MaxInflight != 0 {
+			limits.MaxInflight = options.MaxInflight
+		}
+		if options.StartAt != "" {
+			limits.StartAt = options.StartAt
+		}
+		// NATSS sends the next event of a subscription once the previous one is acknowledged.
+		if options.Order == v1beta1.DeliveryOrdered {
+			limits.MaxInflight = 1
+		}
+	}
+	return limits
+}
+
+// deliveredInOrder tells whether the events of channel are delivered in order.
+func (s *SubscriptionsSupervisor) deliveredInOrder(channel eventingchannels.ChannelReference) bool {
+	o, ok := s.deliveryOptions.Load(channel)
+	return ok && o.(v1beta1.DeliveryOptions).Order == v1beta1.DeliveryOrdered
+}
+
+// subscriptionOptions returns the options of the subscriptions of channel, whose changes make
+// them again as planner.DecideOptionChange tells.
+func (s *SubscriptionsSupervisor) subscriptionOptions(channel eventingchannels.ChannelReference) planner.Options {
+	limits := s.deliveryLimitsOf(channel)
+	options := planner.Options{AckWait: limits.AckWait, MaxInflight: limits.MaxInflight, Ordered: s.deliveredInOrder(channel), StartAt: limits.StartAt}
+	if options.AckWait <= 0 {
+		options.AckWait = defaultAckWait
+	}
+	if options.MaxInflight < 0 {
+		options.MaxInflight = 0
+	}
+	return options
+}
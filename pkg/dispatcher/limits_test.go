@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestDeliveryLimitsMaxInflight(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	s.maxInflight = 64
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	other := eventingchannels.ChannelReference{Namespace: "other", Name: "channel"}
+	s.SetDeliveryLimits(ref, &DeliveryLimits{MaxInflight: 8})
+
+	for _, channel := range []eventingchannels.ChannelReference{ref, other} {
+		if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(channel, subscriber), false); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+	}
+	if len(conn.subs) != 2 {
+		t.Fatalf("%d subscriptions, want 2", len(conn.subs))
+	}
+	if got := conn.subs[0].maxInflight; got != 8 {
+		t.Errorf("max inflight of the overridden channel = %d, want 8", got)
+	}
+	if got := conn.subs[1].maxInflight; got != 64 {
+		t.Errorf("max inflight of the other channel = %d, want 64", got)
+	}
+
+	// Without limits, the subscriptions get the default of NATSS.
+	s.maxInflight = 0
+	s.SetDeliveryLimits(ref, nil)
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if got := conn.subs[len(conn.subs)-1].maxInflight; got != stan.DefaultMaxInflight {
+		t.Errorf("max inflight = %d, want %d", got, stan.DefaultMaxInflight)
+	}
+}
+
+func TestDeliveryLimitsMaxRedirects(t *testing.T) {
+	server, requests := newRedirectingServer(t, 2)
+	s, _ := newTestSupervisor(t)
+	s.maxRedirects = 3
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetDeliveryLimits(ref, &DeliveryLimits{MaxRedirects: 1})
+
+	if s.dispatchMessage(context.Background(), ref, newRedirectTestEvent(), mustParseURL(t, server.URL+"/hop/0"), nil, nil) {
+		t.Error("dispatchMessage() = true, want the second redirect refused")
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+
+	s.SetDeliveryLimits(ref, nil)
+	if !s.dispatchMessage(context.Background(), ref, newRedirectTestEvent(), mustParseURL(t, server.URL+"/hop/0"), nil, nil) {
+		t.Error("dispatchMessage() = false, want the redirects of the dispatcher followed")
+	}
+}
+
+func TestDeliveryOptions(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	s.maxInflight = 64
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	update := func() *fakeStanSubscription {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+		if len(conn.subs) != 1 {
+			t.Fatalf("%d subscriptions, want 1", len(conn.subs))
+		}
+		return conn.subs[0]
+	}
+
+	// The options of the channel take precedence over its delivery limits.
+	s.SetDeliveryLimits(ref, &DeliveryLimits{MaxInflight: 8, AckWait: 2 * time.Minute})
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{AckWait: 5 * time.Minute, StartAt: v1beta1.StartPositionAllAvailable})
+	sub := update()
+	if sub.maxInflight != 8 || sub.ackWait != 5*time.Minute || sub.startAt != pb.StartPosition_First {
+		t.Errorf("subscription with max inflight %d, ack wait %v and start position %v, want 8, 5m0s and %v",
+			sub.maxInflight, sub.ackWait, sub.startAt, pb.StartPosition_First)
+	}
+
+	// Updating the channel with the same options keeps the subscription.
+	if got := update(); got != sub {
+		t.Error("subscription made again, want it kept")
+	}
+
+	// Changing the max inflight makes the subscription again from its durable.
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{MaxInflight: 16})
+	got := update()
+	if got == sub || got.durable != sub.durable {
+		t.Errorf("subscription of the durable %q, want the durable %q subscribed again", got.durable, sub.durable)
+	}
+	if got.maxInflight != 16 || got.ackWait != 2*time.Minute {
+		t.Errorf("subscription with max inflight %d and ack wait %v, want 16 and 2m0s", got.maxInflight, got.ackWait)
+	}
+	if len(conn.closed) != 0 {
+		t.Errorf("durables left closed: %v", conn.closed)
+	}
+
+	// Without options nor limits, the subscriptions get the defaults of the dispatcher.
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{})
+	s.SetDeliveryLimits(ref, nil)
+	if got := update(); got.maxInflight != 64 || got.ackWait != defaultAckWait || got.startAt != pb.StartPosition_NewOnly {
+		t.Errorf("subscription with max inflight %d, ack wait %v and start position %v, want 64, %v and %v",
+			got.maxInflight, got.ackWait, got.startAt, defaultAckWait, pb.StartPosition_NewOnly)
+	}
+}
+
+func TestDeliveryOrder(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+	s.SetConsumers(ref, map[types.UID]int{uid: 3})
+
+	// The ordered delivery takes precedence over the max inflight and the consumers.
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{MaxInflight: 16, Order: v1beta1.DeliveryOrdered})
+	update()
+	if len(conn.subs) != 1 || len(conn.groups[string(uid)]) != 0 {
+		t.Fatalf("got %d subscriptions and %d queue group members, want 1 subscription", len(conn.subs), len(conn.groups[string(uid)]))
+	}
+	if got := conn.subs[0].maxInflight; got != 1 {
+		t.Errorf("subscription with max inflight %d, want 1", got)
+	}
+
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{MaxInflight: 16, Order: v1beta1.DeliveryUnordered})
+	update()
+	members := conn.groups[string(uid)]
+	if len(conn.subs) != 0 || len(members) != 3 {
+		t.Fatalf("got %d subscriptions and %d queue group members, want 3 members", len(conn.subs), len(members))
+	}
+	if got := members[0].maxInflight; got != 16 {
+		t.Errorf("subscription with max inflight %d, want 16", got)
+	}
+}
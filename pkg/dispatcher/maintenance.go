@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"go.uber.org/zap"
+)
+
+// setMaintenance enables or disables the maintenance mode. While it is enabled, the subscriptions
+// of the connection lost are not made again on the new one, for a NATSS cluster under maintenance
+// not to be flooded with subscriptions every time it restarts, the events still being published.
+// The subscriptions are made again once it is disabled.
+func (s *SubscriptionsSupervisor) setMaintenance(enabled bool) {
+	s.maintenanceMux.Lock()
+	if s.maintenance == enabled {
+		s.maintenanceMux.Unlock()
+		return
+	}
+	s.maintenance = enabled
+	deferred := !enabled && s.resubscribeDeferred
+	s.resubscribeDeferred = false
+	s.maintenanceMux.Unlock()
+
+	if enabled {
+		s.connectionLogger.Info("Maintenance mode enabled, the subscriptions are not made again on reconnection")
+		return
+	}
+	s.connectionLogger.Info("Maintenance mode disabled", zap.Bool("resubscribing", deferred))
+	if deferred {
+		s.resubscribe()
+		s.connectionRestored()
+	}
+}
+
+// deferResubscribe tells whether the subscriptions of the connection lost are to be made again
+// once the maintenance mode is disabled rather than now, recording it.
+func (s *SubscriptionsSupervisor) deferResubscribe() bool {
+	s.maintenanceMux.Lock()
+	defer s.maintenanceMux.Unlock()
+	if s.maintenance {
+		s.resubscribeDeferred = true
+	}
+	return s.maintenance
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+func TestMaintenanceDefersResubscribe(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	s.natssConn = nil
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	pool := newFakeConnPool()
+	s.conns = pool
+	s.maxPayloadOf = func(stan.Conn) int64 { return 0 }
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Connect(ctx)
+	s.signalReconnect()
+	waitConnected(t, s)
+	if _, err := s.UpdateSubscriptions(ctx, newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	connOf := func() *fakeStanConn {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.conns[s.connKey]
+	}
+	subscriptionsOf := func(conn *fakeStanConn) int {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.subs)
+	}
+
+	// Entering: the subscriptions are left as they are.
+	s.setMaintenance(true)
+	if n := subscriptionsOf(connOf()); n != 1 {
+		t.Fatalf("%d subscriptions once the maintenance started, want 1", n)
+	}
+
+	// Operating: NATSS restarts, the dispatcher reconnects without subscribing again.
+	pool.lose(s.connKey)
+	s.natssConnectionLost(s.connKey, errors.New("server stopped"))
+	waitConnected(t, s)
+	conn := connOf()
+	if n := subscriptionsOf(conn); n != 0 {
+		t.Errorf("%d subscriptions made again during the maintenance, want none", n)
+	}
+	if status := s.ConnectionStatus(ref); status.Ready || status.LostSince.IsZero() {
+		t.Errorf("ConnectionStatus() = %+v during the maintenance, want the subscriptions lost", status)
+	}
+
+	// Exiting: the subscriptions are made again, and the events delivered.
+	s.setMaintenance(false)
+	if n := subscriptionsOf(conn); n != 1 {
+		t.Errorf("%d subscriptions once the maintenance ended, want 1", n)
+	}
+	if status := s.ConnectionStatus(ref); !status.Ready {
+		t.Errorf("ConnectionStatus() = %+v once the maintenance ended, want ready", status)
+	}
+	conn.publish(newTestEventMsg(t, "after"))
+	if got := subscriber.received(); len(got) != 1 || got[0] != "after" {
+		t.Errorf("the subscriber received %v, want the event published once the maintenance ended", got)
+	}
+}
+
+func TestMaintenanceWithoutOutage(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+
+	// Without a reconnection during the maintenance, there is nothing to subscribe again.
+	s.setMaintenance(true)
+	s.setMaintenance(false)
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	if n := len(s.subscriptions[ref]); n != 1 {
+		t.Errorf("%d subscriptions, want 1", n)
+	}
+}
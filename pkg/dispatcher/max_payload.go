@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	"knative.dev/pkg/metrics"
+)
+
+// maxPayloadRefreshInterval is how often the max payload of NATS is read again, the NATS client
+// reconnecting to a server of the cluster without the dispatcher knowing.
+const maxPayloadRefreshInterval = 30 * time.Second
+
+// maxPayloadM records the max payload of NATS enforced by the receiver.
+var maxPayloadM = stats.Int64(
+	"nats_max_payload_bytes",
+	"Largest event accepted by the receiver of the NATSS dispatcher, the max payload of NATS",
+	stats.UnitBytes,
+)
+
+func init() {
+	if err := view.Register(&view.View{
+		Description: maxPayloadM.Description(),
+		Measure:     maxPayloadM,
+		Aggregation: view.LastValue(),
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// MaxPayloadReporter is implemented by the dispatchers whose receiver refuses the events larger
+// than the max payload of NATS.
+type MaxPayloadReporter interface {
+	// MaxPayload returns the size in bytes of the largest event the receiver accepts, zero
+	// while it is unknown.
+	MaxPayload() int64
+}
+
+var _ MaxPayloadReporter = (*SubscriptionsSupervisor)(nil)
+
+// MaxPayload implements MaxPayloadReporter.
+func (s *SubscriptionsSupervisor) MaxPayload() int64 {
+	return atomic.LoadInt64(&s.maxPayload)
+}
+
+// natsMaxPayload returns the max payload announced by the NATS server conn is connected to.
+func natsMaxPayload(conn stan.Conn) int64 {
+	nc := conn.NatsConn()
+	if nc == nil {
+		return 0
+	}
+	return nc.MaxPayload()
+}
+
+// refreshMaxPayload reads the max payload of the current connection to NATSS, keeping the last
+// one while the dispatcher is not connected.
+func (s *SubscriptionsSupervisor) refreshMaxPayload() {
+	s.natssConnMux.Lock()
+	conn := s.natssConn
+	s.natssConnMux.Unlock()
+	if conn == nil {
+		return
+	}
+	s.setMaxPayload(s.maxPayloadOf(*conn))
+}
+
+// setMaxPayload sets the max payload enforced by the receiver, warning when it is lowered below
+// the size of an event already accepted.
+func (s *SubscriptionsSupervisor) setMaxPayload(limit int64) {
+	previous := atomic.SwapInt64(&s.maxPayload, limit)
+	if previous == limit {
+		return
+	}
+	metrics.Record(context.Background(), maxPayloadM.M(limit))
+	s.connectionLogger.Info("The max payload of NATS changed", zap.Int64("previous", previous), zap.Int64("maxPayload", limit))
+	if largest := atomic.LoadInt64(&s.largestAccepted); limit > 0 && largest > limit {
+		s.connectionLogger.Warn("The max payload of NATS was lowered below the size of events already accepted",
+			zap.Int64("maxPayload", limit), zap.Int64("largestAccepted", largest))
+	}
+}
+
+// runMaxPayloadRefresh reads the max payload of NATS every maxPayloadRefreshInterval until ctx is
+// done.
+func (s *SubscriptionsSupervisor) runMaxPayloadRefresh(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(maxPayloadRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshMaxPayload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// withMaxPayload returns a handler answering 413 Request Entity Too Large to the events larger
+// than the max payload of NATS, which NATS would refuse, and passing the others to next. The
+// events of unknown length are left to NATS to refuse.
+func (s *SubscriptionsSupervisor) withMaxPayload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.ContentLength <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limit := s.MaxPayload(); limit > 0 && r.ContentLength > limit {
+			s.receiverLogger.Debug("Event larger than the max payload of NATS", zap.String("host", r.Host),
+				zap.Int64("size", r.ContentLength), zap.Int64("maxPayload", limit))
+			http.Error(w, fmt.Sprintf("the event of %d bytes exceeds the max payload of %d bytes of NATS", r.ContentLength, limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		for {
+			largest := atomic.LoadInt64(&s.largestAccepted)
+			if r.ContentLength <= largest || atomic.CompareAndSwapInt64(&s.largestAccepted, largest, r.ContentLength) {
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
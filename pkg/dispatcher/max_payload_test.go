@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// serverConn is a connection to a NATS server announcing maxPayload.
+type serverConn struct {
+	*fakeStanConn
+	maxPayload int64
+}
+
+// reconnect replaces the connection of s with one to a server announcing maxPayload, as
+// connectWithRetry does, and refreshes the max payload.
+func reconnect(s *SubscriptionsSupervisor, maxPayload int64) {
+	var conn stan.Conn = serverConn{fakeStanConn: newFakeStanConn(), maxPayload: maxPayload}
+	s.natssConnMux.Lock()
+	s.natssConn = &conn
+	s.natssConnMux.Unlock()
+	s.refreshMaxPayload()
+}
+
+func postSized(handler http.Handler, size int) int {
+	req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local/", strings.NewReader(strings.Repeat("x", size)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestMaxPayloadRefreshedOnReconnect(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.maxPayloadOf = func(conn stan.Conn) int64 {
+		return conn.(serverConn).maxPayload
+	}
+	handler := s.withMaxPayload(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	// Unknown until connected.
+	if got := postSized(handler, 2<<20); got != http.StatusAccepted {
+		t.Errorf("status = %d, want %d without a known max payload", got, http.StatusAccepted)
+	}
+
+	reconnect(s, 1<<20)
+	if got := s.MaxPayload(); got != 1<<20 {
+		t.Errorf("MaxPayload() = %d, want %d", got, 1<<20)
+	}
+	if got := postSized(handler, 2<<20); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", got, http.StatusRequestEntityTooLarge)
+	}
+	if got := postSized(handler, 1<<20); got != http.StatusAccepted {
+		t.Errorf("status = %d, want %d for an event of the max payload", got, http.StatusAccepted)
+	}
+
+	// The max payload was raised on the server.
+	reconnect(s, 8<<20)
+	if got := postSized(handler, 2<<20); got != http.StatusAccepted {
+		t.Errorf("status = %d, want %d once the max payload is raised", got, http.StatusAccepted)
+	}
+}
+
+func TestMaxPayloadLoweredWarns(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	core, logs := observer.New(zap.WarnLevel)
+	s.connectionLogger = zap.New(core)
+	s.maxPayloadOf = func(conn stan.Conn) int64 {
+		return conn.(serverConn).maxPayload
+	}
+	handler := s.withMaxPayload(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	reconnect(s, 8<<20)
+	postSized(handler, 2<<20)
+	if logs.Len() != 0 {
+		t.Fatalf("%d warnings logged before the max payload was lowered", logs.Len())
+	}
+
+	reconnect(s, 1<<20)
+	if got := logs.FilterMessage("The max payload of NATS was lowered below the size of events already accepted").Len(); got != 1 {
+		t.Errorf("%d warnings logged, want 1 once lowered below an accepted event", got)
+	}
+}
+
+func TestMaxPayloadKeptWhileDisconnected(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.maxPayloadOf = func(conn stan.Conn) int64 {
+		return conn.(serverConn).maxPayload
+	}
+	reconnect(s, 1<<20)
+
+	s.natssConnMux.Lock()
+	s.natssConn = nil
+	s.natssConnMux.Unlock()
+	s.refreshMaxPayload()
+	if got := s.MaxPayload(); got != 1<<20 {
+		t.Errorf("MaxPayload() = %d, want the last one, %d, while disconnected", got, 1<<20)
+	}
+}
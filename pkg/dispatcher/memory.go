@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// MemoryDispatcher is a NatssDispatcher delivering the events straight from its receiver to the
+// subscribers of the channels, without NATS. The events are held in memory only, those not
+// delivered yet are lost when the dispatcher stops, which makes it fit for development and tests.
+type MemoryDispatcher struct {
+	logger *zap.Logger
+
+	receiver   *eventingchannels.MessageReceiver
+	dispatcher *eventingchannels.MessageDispatcherImpl
+
+	subscriptionsMux sync.RWMutex
+	subscriptions    map[eventingchannels.ChannelReference]map[types.UID]subscriptionReference
+
+	hostToChannelMap atomic.Value
+
+	// ctx is the context the events are delivered in, done when the dispatcher stops. It is
+	// guarded by subscriptionsMux.
+	ctx context.Context
+}
+
+var _ NatssDispatcher = (*MemoryDispatcher)(nil)
+
+// NewMemoryDispatcher returns a new in-memory NatssDispatcher. The NATSS settings of args are not
+// used.
+func NewMemoryDispatcher(args Args) (NatssDispatcher, error) {
+	if args.Logger == nil {
+		args.Logger = zap.NewNop()
+	}
+
+	d := &MemoryDispatcher{
+		logger:        args.Logger,
+		dispatcher:    eventingchannels.NewMessageDispatcher(args.Logger),
+		subscriptions: make(map[eventingchannels.ChannelReference]map[types.UID]subscriptionReference),
+		ctx:           context.Background(),
+	}
+
+	receiver, err := eventingchannels.NewMessageReceiver(
+		d.receive,
+		d.logger,
+		args.Reporter,
+		eventingchannels.ResolveMessageChannelFromHostHeader(d.getChannelReferenceFromHost))
+	if err != nil {
+		return nil, err
+	}
+	d.receiver = receiver
+	d.hostToChannelMap.Store(map[string]eventingchannels.ChannelReference{})
+	return d, nil
+}
+
+// receive accepts an event of channel, then delivers a copy of it to each of the subscribers of
+// the channel in the background.
+func (d *MemoryDispatcher) receive(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, transformers []binding.Transformer, _ http.Header) error {
+	d.logger.Info("Received event", zap.String("channel", channel.String()))
+
+	// The message is read once, the event it holds can be sent any number of times.
+	event, err := binding.ToEvent(ctx, message, transformers...)
+	if err != nil {
+		d.logger.Error("could not read the event", zap.Error(err))
+		return err
+	}
+
+	d.subscriptionsMux.RLock()
+	defer d.subscriptionsMux.RUnlock()
+	for _, subscription := range d.subscriptions[channel] {
+		copied := event.Clone()
+		go d.deliver(d.ctx, channel, binding.ToMessage(&copied), subscription)
+	}
+	return nil
+}
+
+// deliver dispatches message to subscription, which the channel delivers at most once.
+func (d *MemoryDispatcher) deliver(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, subscription subscriptionReference) {
+	var destination, reply, deadLetter *url.URL
+	if !subscription.SubscriberURI.IsEmpty() {
+		destination = subscription.SubscriberURI.URL()
+	}
+	if !subscription.ReplyURI.IsEmpty() {
+		reply = subscription.ReplyURI.URL()
+	}
+	if subscription.Delivery != nil && subscription.Delivery.DeadLetterSink != nil && !subscription.Delivery.DeadLetterSink.URI.IsEmpty() {
+		deadLetter = subscription.Delivery.DeadLetterSink.URI.URL()
+	}
+
+	if _, err := d.dispatcher.DispatchMessage(ctx, message, nil, destination, reply, deadLetter); err != nil {
+		d.logger.Error("Failed to dispatch message: ", zap.Error(err),
+			zap.String("channel", channel.String()), zap.String("sub", subscription.String()))
+		return
+	}
+	d.logger.Debug("message dispatched", zap.Any("channel", channel))
+}
+
+// Start serves the receiver until ctx is done.
+func (d *MemoryDispatcher) Start(ctx context.Context) error {
+	d.subscriptionsMux.Lock()
+	d.ctx = ctx
+	d.subscriptionsMux.Unlock()
+	return d.receiver.Start(ctx)
+}
+
+// UpdateSubscriptions sets the subscriptions of channel to its subscribers, removing them all
+// when it is finalized. Subscribing cannot fail.
+func (d *MemoryDispatcher) UpdateSubscriptions(_ context.Context, channel *messagingv1.Channel, isFinalizer bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	d.subscriptionsMux.Lock()
+	defer d.subscriptionsMux.Unlock()
+
+	cRef := eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name}
+	if len(channel.Spec.Subscribers) == 0 || isFinalizer {
+		delete(d.subscriptions, cRef)
+		return map[eventingduckv1.SubscriberSpec]error{}, nil
+	}
+
+	subscriptions := make(map[types.UID]subscriptionReference, len(channel.Spec.Subscribers))
+	for _, sub := range channel.Spec.Subscribers {
+		subRef := newSubscriptionReference(sub)
+		subscriptions[subRef.UID] = subRef
+	}
+	d.subscriptions[cRef] = subscriptions
+	return map[eventingduckv1.SubscriberSpec]error{}, nil
+}
+
+// ProcessChannels updates the host to channel map the receiver resolves the channels of the
+// events with.
+func (d *MemoryDispatcher) ProcessChannels(_ context.Context, chanList []messagingv1.Channel) error {
+	hostToChanMap, err := newHostNameToChannelRefMap(chanList)
+	if err != nil {
+		d.logger.Info("ProcessChannels: Error occurred when creating the new hostToChannel map.", zap.Error(err))
+		return err
+	}
+	d.hostToChannelMap.Store(hostToChanMap)
+	return nil
+}
+
+func (d *MemoryDispatcher) getChannelReferenceFromHost(host string) (eventingchannels.ChannelReference, error) {
+	cr, ok := d.hostToChannelMap.Load().(map[string]eventingchannels.ChannelReference)[host]
+	if !ok {
+		return cr, fmt.Errorf("Invalid HostName:%q. HostName not found in any of the watched natss channels", host)
+	}
+	return cr, nil
+}
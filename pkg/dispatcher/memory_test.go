@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+const memoryChannelHost = "channel-kn-channel.ns.svc.cluster.local"
+
+func memoryChannel(subscribers ...eventingduckv1.SubscriberSpec) *messagingv1.Channel {
+	return &messagingv1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+		Spec: messagingv1.ChannelSpec{
+			ChannelableSpec: eventingduckv1.ChannelableSpec{
+				SubscribableSpec: eventingduckv1.SubscribableSpec{Subscribers: subscribers},
+			},
+		},
+		Status: messagingv1.ChannelStatus{
+			ChannelableStatus: eventingduckv1.ChannelableStatus{
+				AddressStatus: duckv1.AddressStatus{Address: &duckv1.Addressable{URL: apis.HTTP(memoryChannelHost)}},
+			},
+		},
+	}
+}
+
+// sendEvent sends the event id to the receiver of d for host, returning the status code.
+func sendEvent(d *MemoryDispatcher, host, id string) int {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Host = host
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", id)
+	req.Header.Set("ce-type", "test.type")
+	req.Header.Set("ce-source", "test")
+	rec := httptest.NewRecorder()
+	d.receiver.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestMemoryDispatcher(t *testing.T) {
+	received := make(chan string, 10)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("ce-id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	nd, err := NewMemoryDispatcher(Args{Reporter: eventingchannels.NewStatsReporter("dispatcher", "test")})
+	if err != nil {
+		t.Fatal("NewMemoryDispatcher() =", err)
+	}
+	d := nd.(*MemoryDispatcher)
+	channel := memoryChannel(
+		eventingduckv1.SubscriberSpec{UID: "uid-1", SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String())},
+		eventingduckv1.SubscriberSpec{UID: "uid-2", SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String())},
+	)
+	if err := d.ProcessChannels(context.Background(), []messagingv1.Channel{*channel}); err != nil {
+		t.Fatal("ProcessChannels() =", err)
+	}
+	if failed, err := d.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	if code := sendEvent(d, "unknown.ns.svc.cluster.local", "0"); code == http.StatusAccepted {
+		t.Errorf("sending to an unknown host = %d, want it refused", code)
+	}
+	if code := sendEvent(d, memoryChannelHost, "1"); code != http.StatusAccepted {
+		t.Fatalf("sending to the channel = %d, want %d", code, http.StatusAccepted)
+	}
+	// Each subscriber receives the event.
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-received:
+			if id != "1" {
+				t.Errorf("the subscriber received the event %q, want 1", id)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the event to be delivered")
+		}
+	}
+
+	if failed, err := d.UpdateSubscriptions(context.Background(), channel, true); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if len(d.subscriptions) != 0 {
+		t.Errorf("got the subscriptions %v once the channel is finalized, want none", d.subscriptions)
+	}
+}
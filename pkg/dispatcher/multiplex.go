@@ -0,0 +1,269 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+)
+
+const (
+	// MultiplexPath is the path of the receiver publishing an event to several channels.
+	MultiplexPath = "/multiplex"
+
+	// MultiplexChannelParam is the query parameter naming a target channel of MultiplexPath,
+	// repeated for each target.
+	MultiplexChannelParam = "channel"
+	// MultiplexModeParam is the query parameter setting the mode of MultiplexPath.
+	MultiplexModeParam = "mode"
+	// MultiplexModeAllOrNothing answers with a success only when the event was published to
+	// every target, publishing nothing when a target is refused.
+	MultiplexModeAllOrNothing = "all-or-nothing"
+
+	// The results of the publication of an event to a target of MultiplexPath.
+	MultiplexPublished = "published"
+	MultiplexRefused   = "refused"
+	MultiplexFailed    = "failed"
+	MultiplexSkipped   = "skipped"
+
+	// maxMultiplexTargets bounds the number of targets of a request to MultiplexPath.
+	maxMultiplexTargets = 16
+)
+
+var (
+	// multiplexPublishCountM records the publications of the events received on MultiplexPath.
+	multiplexPublishCountM = stats.Int64(
+		"multiplex_publish_count",
+		"Number of publications of the events received on the multiplex endpoint, by target channel",
+		stats.UnitDimensionless,
+	)
+
+	// multiplexResultKey is one of MultiplexPublished, MultiplexRefused, MultiplexFailed or
+	// MultiplexSkipped.
+	multiplexResultKey = tag.MustNewKey("result")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: multiplexPublishCountM.Description(),
+			Measure:     multiplexPublishCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{cardinality.NamespaceKey, cardinality.ChannelKey, multiplexResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// MultiplexTargetSetter is implemented by the dispatchers publishing the events received on
+// MultiplexPath.
+type MultiplexTargetSetter interface {
+	// SetMultiplexTarget sets whether the events received on MultiplexPath can be published to
+	// channel.
+	SetMultiplexTarget(channel eventingchannels.ChannelReference, allowed bool)
+}
+
+var _ MultiplexTargetSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetMultiplexTarget implements MultiplexTargetSetter.
+func (s *SubscriptionsSupervisor) SetMultiplexTarget(channel eventingchannels.ChannelReference, allowed bool) {
+	if !allowed {
+		s.multiplexTargets.Delete(channel)
+		return
+	}
+	s.multiplexTargets.Store(channel, struct{}{})
+}
+
+// MultiplexResult is the result of the publication of an event to a target of MultiplexPath.
+type MultiplexResult struct {
+	// Channel is the namespace/name of the target.
+	Channel string `json:"channel"`
+	// Result is one of MultiplexPublished, MultiplexRefused, MultiplexFailed or MultiplexSkipped.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// MultiplexResponse is the document answering a request to MultiplexPath.
+type MultiplexResponse struct {
+	Results []MultiplexResult `json:"results"`
+}
+
+// withMultiplex returns a handler serving MultiplexPath, and passing the other requests to next.
+func (s *SubscriptionsSupervisor) withMultiplex(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != MultiplexPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.serveMultiplex(w, r)
+	})
+}
+
+// serveMultiplex publishes the structured event of r to the channels named by its channel
+// parameters, which must be in the namespace of the channel r is addressed to and allow it. The
+// answer is 202 Accepted when the event was published to every target, 207 Multi-Status when
+// only to some, and an error otherwise, with the MultiplexResponse of each target.
+func (s *SubscriptionsSupervisor) serveMultiplex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	entry, err := s.getChannelReferenceFromHost(r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	names := query[MultiplexChannelParam]
+	if len(names) == 0 || len(names) > maxMultiplexTargets {
+		http.Error(w, fmt.Sprintf("between 1 and %d %q parameters are required", maxMultiplexTargets, MultiplexChannelParam), http.StatusBadRequest)
+		return
+	}
+	allOrNothing := false
+	switch mode := query.Get(MultiplexModeParam); mode {
+	case "":
+	case MultiplexModeAllOrNothing:
+		allOrNothing = true
+	default:
+		http.Error(w, fmt.Sprintf("unknown %q %q", MultiplexModeParam, mode), http.StatusBadRequest)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != structuredContentType {
+		http.Error(w, "the event must be in structured mode", http.StatusUnsupportedMediaType)
+		return
+	}
+	e, err := binding.ToEvent(r.Context(), cehttp.NewMessageFromHttpRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e, err = s.intercept(r.Context(), e, r.Header); err != nil {
+		http.Error(w, err.Error(), s.refusalStatus(err))
+		return
+	}
+	if e == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	targets := make([]eventingchannels.ChannelReference, len(names))
+	results := make([]MultiplexResult, len(names))
+	refused := 0
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		targets[i] = eventingchannels.ChannelReference{Namespace: entry.Namespace, Name: name}
+		results[i] = MultiplexResult{Channel: targets[i].String()}
+		if seen[name] {
+			results[i].Result, results[i].Error = MultiplexRefused, "duplicate target"
+		} else if _, ok := s.multiplexTargets.Load(targets[i]); !ok {
+			results[i].Result, results[i].Error = MultiplexRefused, "not a multiplex target of the namespace"
+		}
+		if results[i].Result == MultiplexRefused {
+			refused++
+		}
+		seen[name] = true
+	}
+
+	if refused == 0 || !allOrNothing {
+		publish := messageReceiverFunc(s)
+		var wg sync.WaitGroup
+		for i := range targets {
+			if results[i].Result == MultiplexRefused {
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := publish(r.Context(), targets[i], binding.ToMessage(e), nil, r.Header); err != nil {
+					results[i].Result, results[i].Error = MultiplexFailed, err.Error()
+					return
+				}
+				results[i].Result = MultiplexPublished
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range results {
+			if results[i].Result == "" {
+				results[i].Result = MultiplexSkipped
+			}
+		}
+	}
+
+	published, failed := 0, 0
+	for i, result := range results {
+		s.recordMultiplex(targets[i], result.Result)
+		switch result.Result {
+		case MultiplexPublished:
+			published++
+		case MultiplexFailed:
+			failed++
+		}
+	}
+	code := http.StatusAccepted
+	switch {
+	case published == len(results):
+	case refused > 0 && (allOrNothing || published == 0 && failed == 0):
+		code = http.StatusUnprocessableEntity
+	case allOrNothing || published == 0:
+		// The publications made are not retracted, the results tell which.
+		code = http.StatusBadGateway
+	default:
+		code = http.StatusMultiStatus
+	}
+	if code != http.StatusAccepted {
+		s.receiverLogger.Info("Event not published to every target", zap.String("channel", entry.String()),
+			zap.Int("published", published), zap.Int("refused", refused), zap.Int("failed", failed))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(MultiplexResponse{Results: results}); err != nil {
+		s.receiverLogger.Warn("Failed to write the multiplex results", zap.Error(err))
+	}
+}
+
+func (s *SubscriptionsSupervisor) recordMultiplex(target eventingchannels.ChannelReference, result string) {
+	ctx, err := tag.New(context.Background(), multiplexTags(target, result)...)
+	if err != nil {
+		s.logger.Warn("Failed to tag the multiplex publication", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, multiplexPublishCountM.M(1))
+}
+
+// multiplexTags returns the tags of the publication to target, by result.
+func multiplexTags(target eventingchannels.ChannelReference, result string) []tag.Mutator {
+	tags := cardinality.Tags(cardinality.Resource{Namespace: target.Namespace, Channel: target.Name})
+	return append(tags, tag.Insert(multiplexResultKey, result))
+}
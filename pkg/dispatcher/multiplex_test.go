@@ -0,0 +1,243 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	"go.opencensus.io/tag"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+)
+
+// publishRecorder records the subjects published to, failing the publications to failing.
+type publishRecorder struct {
+	stan.Conn
+
+	failing string
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (c *publishRecorder) Publish(subject string, data []byte) error {
+	if subject == c.failing {
+		return errors.New("injected error")
+	}
+	c.mu.Lock()
+	c.published = append(c.published, subject)
+	c.mu.Unlock()
+	return c.Conn.Publish(subject, data)
+}
+
+func TestMultiplex(t *testing.T) {
+	orders := eventingchannels.ChannelReference{Namespace: "ns", Name: "orders"}
+	audit := eventingchannels.ChannelReference{Namespace: "ns", Name: "audit"}
+	billing := eventingchannels.ChannelReference{Namespace: "ns", Name: "billing"}
+	other := eventingchannels.ChannelReference{Namespace: "other", Name: "audit"}
+
+	testCases := map[string]struct {
+		query         string
+		contentType   string
+		host          string
+		failing       string
+		want          int
+		wantResults   []MultiplexResult
+		wantPublished []string
+	}{
+		"published to every target": {
+			query: "channel=audit&channel=billing",
+			want:  http.StatusAccepted,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/audit", Result: MultiplexPublished},
+				{Channel: "ns/billing", Result: MultiplexPublished},
+			},
+			wantPublished: []string{"audit.ns", "billing.ns"},
+		},
+		"target not allowed": {
+			query: "channel=audit&channel=orders",
+			want:  http.StatusMultiStatus,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/audit", Result: MultiplexPublished},
+				{Channel: "ns/orders", Result: MultiplexRefused, Error: "not a multiplex target of the namespace"},
+			},
+			wantPublished: []string{"audit.ns"},
+		},
+		"target not allowed, all or nothing": {
+			query: "channel=audit&channel=orders&mode=all-or-nothing",
+			want:  http.StatusUnprocessableEntity,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/audit", Result: MultiplexSkipped},
+				{Channel: "ns/orders", Result: MultiplexRefused, Error: "not a multiplex target of the namespace"},
+			},
+		},
+		"every target refused": {
+			query: "channel=orders&channel=orders",
+			want:  http.StatusUnprocessableEntity,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/orders", Result: MultiplexRefused, Error: "not a multiplex target of the namespace"},
+				{Channel: "ns/orders", Result: MultiplexRefused, Error: "duplicate target"},
+			},
+		},
+		"publication failed": {
+			query:   "channel=audit&channel=billing",
+			failing: "billing.ns",
+			want:    http.StatusMultiStatus,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/audit", Result: MultiplexPublished},
+				{Channel: "ns/billing", Result: MultiplexFailed, Error: "error during send: injected error"},
+			},
+			wantPublished: []string{"audit.ns"},
+		},
+		"publication failed, all or nothing": {
+			query:   "channel=audit&channel=billing&mode=all-or-nothing",
+			failing: "billing.ns",
+			want:    http.StatusBadGateway,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/audit", Result: MultiplexPublished},
+				{Channel: "ns/billing", Result: MultiplexFailed, Error: "error during send: injected error"},
+			},
+			wantPublished: []string{"audit.ns"},
+		},
+		"every publication failed": {
+			query:   "channel=billing",
+			failing: "billing.ns",
+			want:    http.StatusBadGateway,
+			wantResults: []MultiplexResult{
+				{Channel: "ns/billing", Result: MultiplexFailed, Error: "error during send: injected error"},
+			},
+		},
+		"binary mode": {
+			query:       "channel=audit",
+			contentType: "application/json",
+			want:        http.StatusUnsupportedMediaType,
+		},
+		"unknown host": {
+			query: "channel=audit",
+			host:  "unknown.ns.svc.cluster.local",
+			want:  http.StatusNotFound,
+		},
+		"no target": {
+			want: http.StatusBadRequest,
+		},
+		"too many targets": {
+			query: strings.Repeat("channel=audit&", maxMultiplexTargets+1),
+			want:  http.StatusBadRequest,
+		},
+		"unknown mode": {
+			query: "channel=audit&mode=some",
+			want:  http.StatusBadRequest,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, conn := newTestSupervisor(t)
+			recorder := &publishRecorder{Conn: conn, failing: tc.failing}
+			var natssConn stan.Conn = recorder
+			s.natssConn = &natssConn
+			if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{newChannel(orders), newChannel(audit), newChannel(billing), newChannel(other)}); err != nil {
+				t.Fatalf("ProcessChannels() = %v", err)
+			}
+			s.SetMultiplexTarget(audit, true)
+			s.SetMultiplexTarget(billing, true)
+			s.SetMultiplexTarget(other, true)
+			s.SetMultiplexTarget(orders, true)
+			s.SetMultiplexTarget(orders, false)
+
+			req := httptest.NewRequest(http.MethodPost, MultiplexPath+"?"+tc.query, strings.NewReader(`{"specversion":"1.0","id":"1","type":"dev.knative.test","source":"test"}`))
+			req.Host = "orders.ns.svc.cluster.local"
+			if tc.host != "" {
+				req.Host = tc.host
+			}
+			req.Header.Set("Content-Type", structuredContentType)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			s.withMultiplex(http.NotFoundHandler()).ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.wantResults != nil {
+				var got MultiplexResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("Unmarshal() = %v", err)
+				}
+				if diff := cmp.Diff(tc.wantResults, got.Results); diff != "" {
+					t.Errorf("results (-want, +got) = %s", diff)
+				}
+			}
+			sort.Strings(recorder.published)
+			if diff := cmp.Diff(tc.wantPublished, recorder.published); diff != "" {
+				t.Errorf("published (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestMultiplexOtherPaths(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	called := false
+	handler := s.withMultiplex(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !called {
+		t.Error("the requests to the channels were not passed to the receiver")
+	}
+}
+
+func TestMultiplexTags(t *testing.T) {
+	defer cardinality.Set(cardinality.Default)
+	target := eventingchannels.ChannelReference{Namespace: "ns", Name: "orders"}
+
+	testCases := map[cardinality.Mode]map[string]string{
+		cardinality.Full:    {"namespace_name": "ns", "channel": "ns/orders", "result": MultiplexPublished},
+		cardinality.Channel: {"namespace_name": "ns", "channel": "ns/orders", "result": MultiplexPublished},
+		cardinality.Low:     {"namespace_name": "ns", "result": MultiplexPublished},
+	}
+	for mode, want := range testCases {
+		t.Run(string(mode), func(t *testing.T) {
+			cardinality.Set(mode)
+			ctx, err := tag.New(context.Background(), multiplexTags(target, MultiplexPublished)...)
+			if err != nil {
+				t.Fatalf("tag.New() = %v", err)
+			}
+			got := make(map[string]string)
+			for _, key := range []tag.Key{cardinality.NamespaceKey, cardinality.ChannelKey, cardinality.SubscriptionKey, multiplexResultKey} {
+				if value, ok := tag.FromContext(ctx).Value(key); ok {
+					got[key.Name()] = value
+				}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("tags (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// DurableNamingScheme is how the durables of the subscriptions are named after them.
+type DurableNamingScheme string
+
+const (
+	// DurableNamingV1 names the durable of a subscription after its UID, the default.
+	DurableNamingV1 DurableNamingScheme = "v1"
+	// DurableNamingV2 prefixes the UID of the subscription with the scheme, so that a durable
+	// tells the scheme it was named with and never collides with the one of another scheme.
+	DurableNamingV2 DurableNamingScheme = "v2"
+)
+
+var (
+	// migrationPoll is how often the migration of a durable checks whether the old one is
+	// drained.
+	migrationPoll = time.Second
+	// migrationQuietPeriod is how long the old durable of a migration must go without receiving
+	// an event stored before the cutoff, all those received being delivered, to be drained.
+	// NATSS sends a durable resumed its pending events, and those it did not see acknowledged,
+	// right away.
+	migrationQuietPeriod = 5 * time.Second
+)
+
+// errMigrationStopped is returned when the subscription of a durable being migrated is closed or
+// removed before the migration switched to the new durable.
+var errMigrationStopped = errors.New("the migration was stopped")
+
+// OrDefault returns the scheme, DurableNamingV1 when empty.
+func (n DurableNamingScheme) OrDefault() DurableNamingScheme {
+	if n == "" {
+		return DurableNamingV1
+	}
+	return n
+}
+
+// Validate returns an error when the scheme is not a known one.
+func (n DurableNamingScheme) Validate() error {
+	switch n {
+	case DurableNamingV1, DurableNamingV2:
+		return nil
+	}
+	return fmt.Errorf("invalid durable naming scheme %q, expected %q or %q", n, DurableNamingV1, DurableNamingV2)
+}
+
+// durable returns the name of the durable of subscription with the scheme.
+func (n DurableNamingScheme) durable(subscription types.UID) string {
+	if n == DurableNamingV2 {
+		return string(DurableNamingV2) + "-" + string(subscription)
+	}
+	return string(subscription)
+}
+
+// parseDurableName returns the subscription durable was named after, and its scheme.
+func parseDurableName(durable string) (types.UID, DurableNamingScheme) {
+	if uid := strings.TrimPrefix(durable, string(DurableNamingV2)+"-"); uid != durable {
+		return types.UID(uid), DurableNamingV2
+	}
+	return types.UID(durable), DurableNamingV1
+}
+
+// ChannelDurableNames returns the names the durable holding the events of subscriber on a
+// channel with distribution may have, one by scheme, the members of a work queue sharing the
+// durable of their queue group.
+func ChannelDurableNames(distribution v1beta1.Distribution, subscriber eventingduckv1.SubscriberSpec) []string {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return []string{workQueueDurableName}
+	}
+	return []string{DurableNamingV1.durable(subscriber.UID), DurableNamingV2.durable(subscriber.UID)}
+}
+
+// NamedDurable is the naming of the durable of a subscription.
+type NamedDurable struct {
+	Channel      eventingchannels.ChannelReference
+	Subscription types.UID
+	Scheme       DurableNamingScheme
+	// MigratingTo is the scheme the durable is being migrated to, empty when none. The durables
+	// of both schemes exist until the migration completes.
+	MigratingTo DurableNamingScheme
+}
+
+// DurableNamer is implemented by the dispatchers naming the durables with a versioned scheme. The
+// scheme of a subscription is recorded when its durable is first named, and honored afterwards
+// whatever the scheme of the new subscriptions, so that changing it never strands the events of
+// the existing durables. The durables of a channel are moved to another scheme by migrating them:
+// the events stored up to then are delivered from the old durable, which is removed once drained,
+// and the next ones from the new durable.
+type DurableNamer interface {
+	// LoadNamedDurables records the durables named by a previous run, none when the records are
+	// empty. It must be called before the subscriptions are made: until it is called with a non-nil
+	// slice, the new durables are named with DurableNamingV1 whatever the configured scheme, the
+	// durables of the runs predating the schemes not being recorded.
+	LoadNamedDurables(durables []NamedDurable)
+	// NamedDurables returns the durables named by the dispatcher, sorted by channel and
+	// subscription.
+	NamedDurables() []NamedDurable
+	// DurablesOf returns the names of the durables of the subscription of channel, those of both
+	// schemes while it is migrated, none when the dispatcher has not named it.
+	DurablesOf(channel eventingchannels.ChannelReference, subscription types.UID) []string
+	// SetDurableMigration sets the scheme the durables of channel are migrated to, none when
+	// empty. It must be called before updating the subscriptions of the channel to take effect.
+	SetDurableMigration(channel eventingchannels.ChannelReference, scheme DurableNamingScheme)
+	// MigratingDurable tells whether the durable of the subscription of channel is being migrated.
+	MigratingDurable(channel eventingchannels.ChannelReference, subscription types.UID) bool
+	// WatchDurableMigrations sets the function called once a durable of channel is migrated, or
+	// its migration failed, nil removing it.
+	WatchDurableMigrations(channel eventingchannels.ChannelReference, notify func())
+	// SetNamedDurablesRecorder sets the function persisting the named durables once a durable is
+	// drained, the old durable being removed only after it succeeded, so that a restart never
+	// resumes a durable removed. The named durables are not persisted when nil.
+	SetNamedDurablesRecorder(record func(durables []NamedDurable) error)
+}
+
+var _ DurableNamer = (*SubscriptionsSupervisor)(nil)
+
+// durables returns the names of the durables of n, the old one first while it is migrated.
+func (n NamedDurable) durables() []string {
+	durables := []string{n.Scheme.durable(n.Subscription)}
+	if n.MigratingTo != "" {
+		durables = append(durables, n.MigratingTo.durable(n.Subscription))
+	}
+	return durables
+}
+
+// LoadNamedDurables implements DurableNamer.
+func (s *SubscriptionsSupervisor) LoadNamedDurables(durables []NamedDurable) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	for _, named := range durables {
+		if _, ok := s.namedDurables[named.Subscription]; !ok {
+			s.namedDurables[named.Subscription] = named
+		}
+	}
+	if durables != nil {
+		s.namingRecorded = true
+	}
+}
+
+// NamedDurables implements DurableNamer.
+func (s *SubscriptionsSupervisor) NamedDurables() []NamedDurable {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	return s.namedDurablesLocked()
+}
+
+// namedDurablesLocked returns the named durables sorted by channel and subscription.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) namedDurablesLocked() []NamedDurable {
+	durables := make([]NamedDurable, 0, len(s.namedDurables))
+	for _, named := range s.namedDurables {
+		durables = append(durables, named)
+	}
+	sort.Slice(durables, func(i, j int) bool {
+		if durables[i].Channel != durables[j].Channel {
+			return durables[i].Channel.String() < durables[j].Channel.String()
+		}
+		return durables[i].Subscription < durables[j].Subscription
+	})
+	return durables
+}
+
+// DurablesOf implements DurableNamer.
+func (s *SubscriptionsSupervisor) DurablesOf(channel eventingchannels.ChannelReference, subscription types.UID) []string {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	named, ok := s.namedDurables[subscription]
+	if !ok || named.Channel != channel {
+		return nil
+	}
+	return named.durables()
+}
+
+// SetDurableMigration implements DurableNamer.
+func (s *SubscriptionsSupervisor) SetDurableMigration(channel eventingchannels.ChannelReference, scheme DurableNamingScheme) {
+	if scheme == "" {
+		s.durableMigrations.Delete(channel)
+		return
+	}
+	s.durableMigrations.Store(channel, scheme)
+}
+
+// MigratingDurable implements DurableNamer.
+func (s *SubscriptionsSupervisor) MigratingDurable(channel eventingchannels.ChannelReference, subscription types.UID) bool {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	m, ok := s.migrations[subscription]
+	return ok && m.channel == channel
+}
+
+// WatchDurableMigrations implements DurableNamer.
+func (s *SubscriptionsSupervisor) WatchDurableMigrations(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.migrationNotifiers.Delete(channel)
+		return
+	}
+	s.migrationNotifiers.Store(channel, notify)
+}
+
+// SetNamedDurablesRecorder implements DurableNamer.
+func (s *SubscriptionsSupervisor) SetNamedDurablesRecorder(record func(durables []NamedDurable) error) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	s.namedDurablesRecorder = record
+}
+
+func (s *SubscriptionsSupervisor) notifyMigration(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.migrationNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// durableMigrationOf returns the scheme the durables of channel are migrated to, empty when none.
+func (s *SubscriptionsSupervisor) durableMigrationOf(channel eventingchannels.ChannelReference) DurableNamingScheme {
+	if scheme, ok := s.durableMigrations.Load(channel); ok {
+		return scheme.(DurableNamingScheme)
+	}
+	return ""
+}
+
+// namingOf returns the scheme of the durable of subscription: the one it was named with, or the
+// one of the new durables once those of the previous runs are recorded.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) namingOf(subscription types.UID) DurableNamingScheme {
+	if named, ok := s.namedDurables[subscription]; ok {
+		return named.Scheme
+	}
+	if s.namingRecorded {
+		return s.durableNaming.OrDefault()
+	}
+	return DurableNamingV1
+}
+
+// nameDurables records the scheme of the durables of the subscribers of channel not named yet.
+// The members of a work queue share the durable of their queue group, and the ephemeral
+// subscriptions have none.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) nameDurables(channel eventingchannels.ChannelReference, subscribers []eventingduckv1.SubscriberSpec, distribution v1beta1.Distribution, ephemeral map[types.UID]bool) {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return
+	}
+	for _, subscriber := range subscribers {
+		if _, ok := s.namedDurables[subscriber.UID]; ok || ephemeral[subscriber.UID] {
+			continue
+		}
+		s.namedDurables[subscriber.UID] = NamedDurable{Channel: channel, Subscription: subscriber.UID, Scheme: s.namingOf(subscriber.UID)}
+	}
+}
+
+// removeSubscriptionDurables removes the durables of subscription, whose consumers are the
+// members of the queue group group when not empty: the one of its scheme and the one of a
+// migration interrupted by a restart, then forgets its naming.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) removeSubscriptionDurables(channel eventingchannels.ChannelReference, subscription subscriptionReference, group string) error {
+	durables := []string{s.durableName(channel, subscription)}
+	if named, ok := s.namedDurables[subscription.UID]; ok {
+		durables = named.durables()
+	}
+	for _, durable := range durables {
+		if err := s.removeQueueDurable(channel, group, durable); err != nil {
+			return err
+		}
+	}
+	delete(s.namedDurables, subscription.UID)
+	return nil
+}
+
+// durableMigration is the migration of the durable of a subscription to another scheme. The events
+// stored up to the cutoff are delivered from the old durable, drained, and those stored after it
+// from the new one.
+type durableMigration struct {
+	channel      eventingchannels.ChannelReference
+	subscription types.UID
+	from, to     DurableNamingScheme
+	// poll and quiet are migrationPoll and migrationQuietPeriod when the migration started.
+	poll, quiet time.Duration
+
+	// old drains the old durable, nil until the migration switched to the new one.
+	old stan.Subscription
+
+	mu sync.Mutex
+	// cutoff is the sequence of the last event stored when the migration switched, zero when
+	// the channel was empty.
+	cutoff uint64
+	// pending are the events up to the cutoff the old durable received and did not deliver yet,
+	// and active is when it last received one, or was resumed.
+	pending map[uint64]bool
+	active  time.Time
+}
+
+// received records that the old durable received the event of sequence, returning false for the
+// events stored after the cutoff, which the new durable delivers.
+func (m *durableMigration) received(sequence uint64) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sequence > m.cutoff {
+		return false
+	}
+	m.pending[sequence] = true
+	m.active = time.Now()
+	return true
+}
+
+// delivered records that the old durable delivered the event of sequence, which it acknowledges.
+func (m *durableMigration) delivered(sequence uint64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, sequence)
+	m.active = time.Now()
+}
+
+// drained tells whether the old durable delivered all the events up to the cutoff.
+func (m *durableMigration) drained(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending) == 0 && now.Sub(m.active) >= m.quiet
+}
+
+// migrateDurables starts migrating the durables of the subscriptions of channel named with
+// another scheme than the one the channel is migrated to. The durable left by a migration
+// interrupted by a restart is removed first, the migration starting over if still requested.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) migrateDurables(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution) {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return
+	}
+	target := s.durableMigrationOf(channel)
+	for uid := range s.subscriptions[channel] {
+		named, ok := s.namedDurables[uid]
+		if _, migrating := s.migrations[uid]; !ok || migrating || named.Channel != channel {
+			continue
+		}
+		if named.MigratingTo != "" {
+			group := ""
+			if s.consumersOfSubscription(channel, uid) > 1 {
+				group = string(uid)
+			}
+			if err := s.removeQueueDurable(channel, group, named.MigratingTo.durable(uid)); err != nil {
+				s.subscriptionsLogger.Error("Failed to remove the durable of an interrupted migration", zap.String("channel", channel.String()),
+					zap.String("subscription", string(uid)), zap.Error(err))
+				continue
+			}
+			named.MigratingTo = ""
+			s.namedDurables[uid] = named
+		}
+		if target == "" || named.Scheme == target {
+			continue
+		}
+		m := &durableMigration{channel: channel, subscription: uid, from: named.Scheme, to: target, poll: migrationPoll, quiet: migrationQuietPeriod}
+		s.migrations[uid] = m
+		go s.migrateDurable(m)
+	}
+}
+
+// migrateDurable captures the position of the channel of m, switches its subscription to the new
+// durable from there, then removes the old durable once drained. The migration is stopped when
+// the subscription is closed in the meantime, and started over by the next update of the channel.
+func (s *SubscriptionsSupervisor) migrateDurable(m *durableMigration) {
+	logger := s.subscriptionsLogger.With(zap.String("channel", m.channel.String()), zap.String("subscription", string(m.subscription)),
+		zap.String("from", string(m.from)), zap.String("to", string(m.to)))
+	cutoff, err := s.captureCutoff(m.channel)
+
+	s.subscriptionsMux.Lock()
+	if err == nil {
+		err = s.switchDurable(m, cutoff)
+	}
+	if err != nil {
+		if s.migrations[m.subscription] == m {
+			delete(s.migrations, m.subscription)
+		}
+		s.subscriptionsMux.Unlock()
+		if err != errMigrationStopped {
+			logger.Error("Failed to migrate the durable", zap.Error(err))
+			s.notifyMigration(m.channel)
+		}
+		return
+	}
+	s.subscriptionsMux.Unlock()
+	logger.Info("Migrating the durable", zap.Uint64("cutoff", cutoff))
+
+	for {
+		time.Sleep(m.poll)
+		s.subscriptionsMux.Lock()
+		if s.migrations[m.subscription] != m {
+			s.subscriptionsMux.Unlock()
+			return
+		}
+		if !m.drained(time.Now()) {
+			s.subscriptionsMux.Unlock()
+			continue
+		}
+		// Once the new scheme is recorded, a restart resumes the new durable, the old one being
+		// left to the orphan audit if it could not be removed.
+		s.recordMigration(m)
+		record, durables := s.namedDurablesRecorder, s.namedDurablesLocked()
+		s.subscriptionsMux.Unlock()
+		if record != nil {
+			if err := record(durables); err != nil {
+				logger.Error("Failed to record the migrated durable, retrying", zap.Error(err))
+				continue
+			}
+		}
+		s.subscriptionsMux.Lock()
+		if s.migrations[m.subscription] != m {
+			s.subscriptionsMux.Unlock()
+			return
+		}
+		err := s.completeMigration(m)
+		s.subscriptionsMux.Unlock()
+		if err != nil {
+			logger.Error("Failed to remove the old durable of a migration, retrying", zap.Error(err))
+			continue
+		}
+		logger.Info("Migrated the durable")
+		s.notifyMigration(m.channel)
+		return
+	}
+}
+
+// captureCutoff returns the sequence of the last event stored on channel, zero when it is empty.
+func (s *SubscriptionsSupervisor) captureCutoff(channel eventingchannels.ChannelReference) (uint64, error) {
+	conn, err := s.connection(context.Background(), channel)
+	if err != nil {
+		return 0, err
+	}
+	return captureSequence(*conn, getSubject(channel))
+}
+
+// switchDurable makes the subscription of m again from the new durable, starting after cutoff,
+// along with a subscription draining the old durable of the events up to cutoff. The events of
+// the old durable delivered while the position was captured may be delivered again by the new
+// one.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) switchDurable(m *durableMigration, cutoff uint64) error {
+	current, ok := s.subscriptions[m.channel][m.subscription]
+	subscribed, subscribedOk := s.subscribedChannels[m.channel]
+	named := s.namedDurables[m.subscription]
+	if s.migrations[m.subscription] != m || !ok || !subscribedOk || named.Scheme != m.from {
+		return errMigrationStopped
+	}
+	var subscription subscriptionReference
+	for _, subscriber := range subscribed.channel.Spec.Subscribers {
+		if subscriber.UID == m.subscription {
+			subscription = newSubscriptionReference(subscriber)
+		}
+	}
+	if subscription.UID == "" {
+		return errMigrationStopped
+	}
+
+	// The events the old durable did not see acknowledged are redelivered to the subscription
+	// draining it.
+	if err := (*current).Close(); err != nil {
+		s.subscriptionsLogger.Error("Closing NATSS Streaming subscription failed", zap.String("channel", m.channel.String()),
+			zap.String("subscription", string(m.subscription)), zap.Error(err))
+	}
+	s.cursors.close(m.channel, m.subscription, false)
+	m.mu.Lock()
+	m.cutoff, m.pending, m.active = cutoff, make(map[uint64]bool), time.Now()
+	m.mu.Unlock()
+
+	old, err := s.subscribeDurable(subscribed.ctx, m.channel, subscription, false, durableTarget{name: m.from.durable(m.subscription), drain: m})
+	if err != nil {
+		s.dropSubscription(m.channel, m.subscription)
+		return err
+	}
+	start := stan.DeliverAllAvailable()
+	if cutoff > 0 {
+		start = stan.StartAtSequence(cutoff + 1)
+	}
+	held, err := s.subscribeDurable(subscribed.ctx, m.channel, subscription, false, durableTarget{name: m.to.durable(m.subscription), start: start})
+	if err != nil {
+		_ = (*old).Close()
+		s.dropSubscription(m.channel, m.subscription)
+		return err
+	}
+	s.subscriptions[m.channel][m.subscription] = held
+	m.old = *old
+	named.MigratingTo = m.to
+	s.namedDurables[m.subscription] = named
+	return nil
+}
+
+// recordMigration records the new scheme of the durable of m, drained.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) recordMigration(m *durableMigration) {
+	named := s.namedDurables[m.subscription]
+	named.Scheme, named.MigratingTo = m.to, ""
+	s.namedDurables[m.subscription] = named
+}
+
+// completeMigration removes the old durable of m, drained, once its new scheme is recorded.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) completeMigration(m *durableMigration) error {
+	if err := m.old.Unsubscribe(); err != nil {
+		return err
+	}
+	s.cursors.forget(m.channel, m.from.durable(m.subscription))
+	delete(s.migrations, m.subscription)
+	return nil
+}
+
+// stopMigration stops the migration of the durable of subscription, closing the subscription
+// draining the old durable, which is kept for the migration to start over, or removed along
+// with the subscription when remove.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) stopMigration(subscription types.UID, remove bool) {
+	m, ok := s.migrations[subscription]
+	if !ok {
+		return
+	}
+	delete(s.migrations, subscription)
+	if m.old == nil {
+		return
+	}
+	var err error
+	if remove {
+		err = m.old.Unsubscribe()
+	} else {
+		err = m.old.Close()
+	}
+	if err != nil {
+		s.subscriptionsLogger.Error("Failed to stop the subscription draining the old durable of a migration", zap.String("channel", m.channel.String()),
+			zap.String("subscription", string(subscription)), zap.Error(err))
+	}
+}
+
+// dropSubscription forgets the subscription of channel closed, for the next update of the
+// channel to make it again.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) dropSubscription(channel eventingchannels.ChannelReference, subscription types.UID) {
+	delete(s.subscriptions[channel], subscription)
+	delete(s.subscribedOptions[channel], subscription)
+	s.targets.Delete(subscription)
+}
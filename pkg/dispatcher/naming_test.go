@@ -0,0 +1,240 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func withMigrationTiming(t *testing.T) {
+	poll, quiet, capture := migrationPoll, migrationQuietPeriod, captureTimeout
+	migrationPoll, migrationQuietPeriod, captureTimeout = 5*time.Millisecond, 50*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { migrationPoll, migrationQuietPeriod, captureTimeout = poll, quiet, capture })
+}
+
+func publishEventRange(t *testing.T, conn stan.Conn, ref eventingchannels.ChannelReference, from, to int) []string {
+	t.Helper()
+	var ids []string
+	for i := from; i < to; i++ {
+		id := fmt.Sprintf("id-%d", i)
+		if err := conn.Publish(getSubject(ref), newTestEventMsg(t, id).Data); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func subscribedDurables(conn *fakeStanConn) []string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	var durables []string
+	for _, sub := range conn.subs {
+		durables = append(durables, sub.durable)
+	}
+	sort.Strings(durables)
+	return durables
+}
+
+func TestDurableNamingMixedSchemes(t *testing.T) {
+	testCases := map[string]struct {
+		recorded []NamedDurable
+		want     []string
+	}{
+		"not recorded yet": {
+			// The durables of the runs predating the schemes are not recorded.
+			want: []string{"uid-0", "uid-1", "uid-2"},
+		},
+		"recorded none": {
+			recorded: []NamedDurable{},
+			want:     []string{"v2-uid-0", "v2-uid-1", "v2-uid-2"},
+		},
+		"recorded": {
+			recorded: []NamedDurable{
+				{Subscription: "uid-0", Scheme: DurableNamingV1},
+				{Subscription: "uid-1", Scheme: DurableNamingV2},
+			},
+			want: []string{"uid-0", "v2-uid-1", "v2-uid-2"},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			var subscribers []*eventRecorder
+			for i := 0; i < 3; i++ {
+				subscriber := newEventRecorder()
+				defer subscriber.Close()
+				subscribers = append(subscribers, subscriber)
+			}
+			s, conn := newTestSupervisor(t)
+			s.durableNaming = DurableNamingV2
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			for i := range tc.recorded {
+				tc.recorded[i].Channel = ref
+			}
+			s.LoadNamedDurables(tc.recorded)
+			if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscribers...), false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+			if diff := cmp.Diff(tc.want, subscribedDurables(conn)); diff != "" {
+				t.Errorf("unexpected durables (-want, +got): %s", diff)
+			}
+
+			// The schemes are recorded for the next runs to honor them.
+			var named []string
+			for _, durable := range s.NamedDurables() {
+				named = append(named, durable.Scheme.durable(durable.Subscription))
+			}
+			if diff := cmp.Diff(tc.want, named); diff != "" {
+				t.Errorf("unexpected named durables (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestDurableMigration(t *testing.T) {
+	withMigrationTiming(t)
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newSequencedSupervisor(t)
+	s.durableNaming = DurableNamingV2
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.LoadNamedDurables([]NamedDurable{{Channel: ref, Subscription: "uid-0", Scheme: DurableNamingV1}})
+	migrated := make(chan struct{}, 1)
+	s.WatchDurableMigrations(ref, func() {
+		select {
+		case migrated <- struct{}{}:
+		default:
+		}
+	})
+	// The first attempt at recording the migrated durable fails, and the old durable is kept until
+	// one succeeds.
+	var (
+		attempts int
+		recorded []NamedDurable
+		durables []string
+	)
+	s.SetNamedDurablesRecorder(func(named []NamedDurable) error {
+		if attempts++; attempts == 1 {
+			return errors.New("conflict")
+		}
+		recorded, durables = named, subscribedDurables(conn.fakeStanConn)
+		return nil
+	})
+	channel := newTestChannel(ref, subscriber)
+	update := func() {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+	update()
+	want := publishEventRange(t, conn, ref, 0, 3)
+	waitForEvents(t, subscriber, want...)
+
+	// The subscription falls behind: the events published while its durable is closed are
+	// pending on it when the migration starts.
+	conn.mu.Lock()
+	behind := conn.subs[0]
+	conn.mu.Unlock()
+	if err := behind.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	want = append(want, publishEventRange(t, conn, ref, 3, 6)...)
+
+	s.SetDurableMigration(ref, DurableNamingV2)
+	update()
+	if !s.MigratingDurable(ref, "uid-0") {
+		t.Fatal("the durable is not migrated")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.DurablesOf(ref, "uid-0")) < 2 && s.MigratingDurable(ref, "uid-0") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// The events stored after the cutoff are delivered by the new durable only.
+	want = append(want, publishEventRange(t, conn, ref, 6, 9)...)
+
+	select {
+	case <-migrated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the durable was not migrated")
+	}
+	waitForEvents(t, subscriber, want...)
+	if s.MigratingDurable(ref, "uid-0") {
+		t.Error("the durable is still migrated")
+	}
+	if diff := cmp.Diff([]string{"v2-uid-0"}, s.DurablesOf(ref, "uid-0")); diff != "" {
+		t.Errorf("unexpected durables of the subscription (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]NamedDurable{{Channel: ref, Subscription: "uid-0", Scheme: DurableNamingV2}}, recorded); diff != "" {
+		t.Errorf("unexpected recorded durables (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]string{"uid-0", "v2-uid-0"}, durables); diff != "" {
+		t.Errorf("unexpected durables subscribed when recorded (-want, +got): %s", diff)
+	}
+	// The old durable was removed once recorded.
+	if diff := cmp.Diff([]string{"v2-uid-0"}, subscribedDurables(conn.fakeStanConn)); diff != "" {
+		t.Errorf("unexpected durables subscribed (-want, +got): %s", diff)
+	}
+
+	// The migrated durables are left alone by the next updates.
+	update()
+	if s.MigratingDurable(ref, "uid-0") {
+		t.Error("the migrated durable is migrated again")
+	}
+}
+
+func TestDurableMigrationStopped(t *testing.T) {
+	withMigrationTiming(t)
+	migrationQuietPeriod = time.Hour
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newSequencedSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	s.SetDurableMigration(ref, DurableNamingV2)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.DurablesOf(ref, "uid-0")) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if diff := cmp.Diff([]string{"uid-0", "v2-uid-0"}, subscribedDurables(conn.fakeStanConn)); diff != "" {
+		t.Fatalf("unexpected durables subscribed while migrating (-want, +got): %s", diff)
+	}
+
+	// Removing the subscription removes both durables, and stops the migration.
+	channel.Spec.Subscribers = nil
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if durables := subscribedDurables(conn.fakeStanConn); len(durables) != 0 {
+		t.Errorf("durables %v still subscribed, want none", durables)
+	}
+	if s.MigratingDurable(ref, "uid-0") || len(s.NamedDurables()) != 0 {
+		t.Errorf("the migration of the removed subscription was not forgotten: %v", s.NamedDurables())
+	}
+}
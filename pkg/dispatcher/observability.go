@@ -0,0 +1,130 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net/http"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/tracing/propagation/tracecontextb3"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/loglevel"
+)
+
+// ObservabilitySetter is implemented by the dispatchers able to log and trace the events of a
+// channel apart from the others.
+type ObservabilitySetter interface {
+	// SetObservability sets how the events of channel are logged and traced when received and
+	// dispatched, the zero Observability restoring config-logging and config-tracing.
+	SetObservability(channel eventingchannels.ChannelReference, o v1beta1.Observability)
+}
+
+var _ ObservabilitySetter = (*SubscriptionsSupervisor)(nil)
+
+// channelObservability holds the loggers and the sampler of a channel with an Observability.
+type channelObservability struct {
+	observability       v1beta1.Observability
+	receiverLogger      *zap.Logger
+	subscriptionsLogger *zap.Logger
+	// sampler is nil when the channel keeps the sampling of config-tracing.
+	sampler trace.Sampler
+}
+
+// SetObservability implements ObservabilitySetter.
+func (s *SubscriptionsSupervisor) SetObservability(channel eventingchannels.ChannelReference, o v1beta1.Observability) {
+	if o.IsZero() {
+		s.observability.Delete(channel)
+		return
+	}
+	if current, ok := s.observability.Load(channel); ok && sameObservability(current.(*channelObservability).observability, o) {
+		return
+	}
+
+	co := &channelObservability{
+		observability:       o,
+		receiverLogger:      s.receiverLogger,
+		subscriptionsLogger: s.subscriptionsLogger,
+	}
+	if o.LogLevel != nil {
+		co.receiverLogger = loglevel.AtLevel(s.receiverLogger, *o.LogLevel)
+		co.subscriptionsLogger = loglevel.AtLevel(s.subscriptionsLogger, *o.LogLevel)
+	}
+	if o.TraceSampling != nil {
+		co.sampler = trace.ProbabilitySampler(*o.TraceSampling)
+	}
+	s.observability.Store(channel, co)
+}
+
+// sameObservability returns whether a and b set the same level and sampling.
+func sameObservability(a, b v1beta1.Observability) bool {
+	sameLevel := (a.LogLevel == nil) == (b.LogLevel == nil) && (a.LogLevel == nil || *a.LogLevel == *b.LogLevel)
+	sameSampling := (a.TraceSampling == nil) == (b.TraceSampling == nil) && (a.TraceSampling == nil || *a.TraceSampling == *b.TraceSampling)
+	return sameLevel && sameSampling
+}
+
+// channelObservabilityOf returns the observability of channel, nil when it has none.
+func (s *SubscriptionsSupervisor) channelObservabilityOf(channel eventingchannels.ChannelReference) *channelObservability {
+	if co, ok := s.observability.Load(channel); ok {
+		return co.(*channelObservability)
+	}
+	return nil
+}
+
+// receiverLoggerOf returns the logger of the events received for channel.
+func (s *SubscriptionsSupervisor) receiverLoggerOf(channel eventingchannels.ChannelReference) *zap.Logger {
+	if co := s.channelObservabilityOf(channel); co != nil {
+		return co.receiverLogger
+	}
+	return s.receiverLogger
+}
+
+// subscriptionsLoggerOf returns the logger of the events of channel dispatched to its subscribers.
+func (s *SubscriptionsSupervisor) subscriptionsLoggerOf(channel eventingchannels.ChannelReference) *zap.Logger {
+	if co := s.channelObservabilityOf(channel); co != nil {
+		return co.subscriptionsLogger
+	}
+	return s.subscriptionsLogger
+}
+
+// samplerOf returns the sampler of the traces of the events of channel, nil keeping the sampler
+// of config-tracing.
+func (s *SubscriptionsSupervisor) samplerOf(channel eventingchannels.ChannelReference) trace.Sampler {
+	if co := s.channelObservabilityOf(channel); co != nil {
+		return co.sampler
+	}
+	return nil
+}
+
+// tracedReceiver returns the handler tracing the requests to the receiver like the one of the
+// eventing library, sampling those to a channel with a sampling of its own accordingly.
+func (s *SubscriptionsSupervisor) tracedReceiver(receiver http.Handler) http.Handler {
+	return &ochttp.Handler{
+		Propagation: tracecontextb3.TraceContextEgress,
+		Handler:     receiver,
+		GetStartOptions: func(req *http.Request) trace.StartOptions {
+			channel, err := s.getChannelReferenceFromHost(req.Host)
+			if err != nil {
+				return trace.StartOptions{}
+			}
+			return trace.StartOptions{Sampler: s.samplerOf(channel)}
+		},
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// logBuffer is a zapcore.WriteSyncer safe for concurrent use.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) Sync() error {
+	return nil
+}
+
+// lines returns the lines logged containing substr.
+func (b *logBuffer) lines(substr string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if strings.Contains(line, substr) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestObservabilityLogging(t *testing.T) {
+	recorder := newEventRecorder()
+	defer recorder.Close()
+
+	s, conn := newTestSupervisor(t)
+	logs := &logBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), logs, zapcore.InfoLevel))
+	s.receiverLogger, s.subscriptionsLogger = logger, logger
+
+	debugged := eventingchannels.ChannelReference{Namespace: "ns", Name: "debugged"}
+	other := eventingchannels.ChannelReference{Namespace: "ns", Name: "other"}
+	level := zapcore.DebugLevel
+	s.SetObservability(debugged, v1beta1.Observability{LogLevel: &level})
+	for _, ref := range []eventingchannels.ChannelReference{debugged, other} {
+		if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, recorder), false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+	}
+
+	dispatched := func(id string, want int) {
+		t.Helper()
+		conn.publish(newTestEventMsg(t, id))
+		deadline := time.Now().Add(5 * time.Second)
+		for len(recorder.received()) < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Only the dispatches of the debugged channel are logged at the debug level.
+	dispatched("1", 2)
+	if got := logs.lines(`"msg":"dispatch message"`); len(got) != 1 {
+		t.Errorf("logged %q, want the dispatch of %s only", got, debugged)
+	}
+
+	// Removing the annotation restores the level of the dispatcher.
+	s.SetObservability(debugged, v1beta1.Observability{})
+	dispatched("2", 4)
+	if got := logs.lines(`"msg":"dispatch message"`); len(got) != 1 {
+		t.Errorf("logged %q, want no dispatch logged once the level is restored", got)
+	}
+	if got := s.receiverLoggerOf(debugged); got != s.receiverLogger {
+		t.Error("receiverLoggerOf() is not the logger of the receiver once the level is restored")
+	}
+}
+
+func TestObservabilitySampling(t *testing.T) {
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()})
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+
+	s, _ := newTestSupervisor(t)
+	sampled := eventingchannels.ChannelReference{Namespace: "ns", Name: "sampled"}
+	other := eventingchannels.ChannelReference{Namespace: "ns", Name: "other"}
+	s.setRoutes(map[string]eventingchannels.ChannelReference{
+		"sampled.ns.svc.cluster.local": sampled,
+		"other.ns.svc.cluster.local":   other,
+	})
+	always := 1.0
+	s.SetObservability(sampled, v1beta1.Observability{TraceSampling: &always})
+
+	var received bool
+	handler := s.tracedReceiver(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = trace.FromContext(req.Context()).SpanContext().IsSampled()
+	}))
+	receivedSampled := func(host string) bool {
+		req := httptest.NewRequest(http.MethodPost, "http://"+host+"/", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return received
+	}
+	dispatchedSampled := func(channel eventingchannels.ChannelReference) bool {
+		_, span := startChannelHopSpan(context.Background(), channel, "uid", []byte(`{"id": "1"}`), s.samplerOf(channel))
+		span.End()
+		return span.SpanContext().IsSampled()
+	}
+
+	if !receivedSampled("sampled.ns.svc.cluster.local") || !dispatchedSampled(sampled) {
+		t.Errorf("the events of %s are not traced", sampled)
+	}
+	if receivedSampled("other.ns.svc.cluster.local") || dispatchedSampled(other) {
+		t.Errorf("the events of %s are traced, want the sampling of the dispatcher", other)
+	}
+
+	// Removing the annotation restores the sampling of the dispatcher.
+	s.SetObservability(sampled, v1beta1.Observability{})
+	if receivedSampled("sampled.ns.svc.cluster.local") || dispatchedSampled(sampled) {
+		t.Errorf("the events of %s are traced once the sampling is restored", sampled)
+	}
+}
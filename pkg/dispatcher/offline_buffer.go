@@ -0,0 +1,432 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/cardinality"
+)
+
+var (
+	// offlineBufferEventCountM records the events buffered while the connection to NATSS is lost,
+	// and what became of them.
+	offlineBufferEventCountM = stats.Int64(
+		"natss_offline_buffer_event_count",
+		"Number of events buffered, flushed, dropped or refused by the offline buffer of the receiver",
+		stats.UnitDimensionless,
+	)
+
+	// offlineBufferBytesM records the bytes of the events in the offline buffer.
+	offlineBufferBytesM = stats.Int64(
+		"natss_offline_buffer_bytes",
+		"Bytes of the events in the offline buffer of the receiver",
+		stats.UnitBytes,
+	)
+
+	// offlineBufferResultKey tags the events with one of the offlineBufferResult constants.
+	offlineBufferResultKey = tag.MustNewKey("result")
+)
+
+const (
+	offlineBufferResultBuffered = "buffered"
+	offlineBufferResultFlushed  = "flushed"
+	offlineBufferResultDropped  = "dropped"
+	offlineBufferResultRefused  = "refused"
+)
+
+// offlineRetryAfter is the Retry-After of the events refused by a full offline buffer, in seconds.
+const offlineRetryAfter = 5
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: offlineBufferEventCountM.Description(),
+			Measure:     offlineBufferEventCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{offlineBufferResultKey, cardinality.NamespaceKey, cardinality.ChannelKey},
+		},
+		&view.View{
+			Description: offlineBufferBytesM.Description(),
+			Measure:     offlineBufferBytesM,
+			Aggregation: view.LastValue(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// OfflineBuffer configures the buffer of the events the receiver accepts while the connection to
+// NATSS is lost, published in order once it is made again. The buffer is in memory: the events it
+// holds are lost when the dispatcher stops.
+type OfflineBuffer struct {
+	// MaxEvents is how many events are buffered.
+	MaxEvents int
+	// MaxBytes is how many bytes of event data are buffered, zero or less not limiting them.
+	MaxBytes int64
+	// MaxOutage is how long after the first buffered event the events are buffered, zero or less
+	// not limiting it. The events are refused beyond, or once the buffer is full, until the
+	// connection is made again.
+	MaxOutage time.Duration
+	// DropOnOverflow drops the buffered events once the buffer overflows or the outage lasts
+	// longer than MaxOutage, instead of publishing them once connected again.
+	DropOnOverflow bool
+}
+
+// OfflineBufferReporter is implemented by the dispatchers buffering the events received while the
+// connection to NATSS is lost. The notifiers of ConnectionReporter are called when a channel starts
+// or stops buffering.
+type OfflineBufferReporter interface {
+	// OfflineBufferStatus returns the status of the buffered events of channel.
+	OfflineBufferStatus(channel eventingchannels.ChannelReference) OfflineBufferStatus
+}
+
+var _ OfflineBufferReporter = (*SubscriptionsSupervisor)(nil)
+
+// OfflineBufferStatus is the status of the buffered events of a channel.
+type OfflineBufferStatus struct {
+	// Events is the number of events of the channel in the buffer.
+	Events int
+	// Since is when the buffer started buffering, zero when it holds no event.
+	Since time.Time
+}
+
+// errOfflineBufferFull is the error of the events refused by the offline buffer.
+var errOfflineBufferFull = errors.New("no Connection to NATSS and the offline buffer is full, retry later")
+
+// bufferedEvent is an event of the offline buffer.
+type bufferedEvent struct {
+	channel  eventingchannels.ChannelReference
+	event    *event.Event
+	size     int64
+	received time.Time
+	// client is the address of the client which sent the event, empty when unknown.
+	client string
+}
+
+// offlineBuffer holds the events received while the connection to NATSS is lost.
+type offlineBuffer struct {
+	config OfflineBuffer
+
+	mu     sync.Mutex
+	events []*bufferedEvent
+	bytes  int64
+	// since is when the first event of the outage was buffered.
+	since time.Time
+	// full refuses the events until the connection is made again.
+	full bool
+	// flushing tells that the events are being published, the ones received meanwhile being
+	// buffered behind them.
+	flushing bool
+	// flushed is closed once the events being published are, or the flush stopped.
+	flushed chan struct{}
+	// channels holds the number of buffered events of the channels.
+	channels map[eventingchannels.ChannelReference]int
+}
+
+func newOfflineBuffer(config OfflineBuffer) *offlineBuffer {
+	return &offlineBuffer{config: config, channels: make(map[eventingchannels.ChannelReference]int)}
+}
+
+// OfflineBufferStatus implements OfflineBufferReporter.
+func (s *SubscriptionsSupervisor) OfflineBufferStatus(channel eventingchannels.ChannelReference) OfflineBufferStatus {
+	b := s.offlineBuffer
+	if b == nil {
+		return OfflineBufferStatus{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.channels[channel]
+	if n == 0 {
+		return OfflineBufferStatus{}
+	}
+	return OfflineBufferStatus{Events: n, Since: b.since}
+}
+
+// buffersOffline tells whether the events of channel may be buffered: the offline buffer is
+// enabled, and channel is on the cluster of the dispatcher.
+func (s *SubscriptionsSupervisor) buffersOffline(channel eventingchannels.ChannelReference) bool {
+	return s.offlineBuffer != nil && s.clusterOf(channel) == s.dispatcherKey()
+}
+
+// bufferOffline buffers e unless it can be published: the dispatcher is connected and no event is
+// buffered before it. It returns whether e was buffered, with errOfflineBufferFull when it was
+// refused.
+func (s *SubscriptionsSupervisor) bufferOffline(ctx context.Context, channel eventingchannels.ChannelReference, e *event.Event, received time.Time, lost bool) (bool, error) {
+	b := s.offlineBuffer
+	b.mu.Lock()
+	if !lost && !b.flushing && len(b.events) == 0 {
+		s.natssConnMux.Lock()
+		connected := s.natssConn != nil
+		s.natssConnMux.Unlock()
+		if connected {
+			b.mu.Unlock()
+			return false, nil
+		}
+	}
+	size := int64(len(e.Data()))
+	now := time.Now()
+	if b.since.IsZero() {
+		b.since = now
+	}
+	outage := b.config.MaxOutage > 0 && !b.flushing && now.Sub(b.since) > b.config.MaxOutage
+	overflow := len(b.events) >= b.config.MaxEvents || (b.config.MaxBytes > 0 && b.bytes+size > b.config.MaxBytes)
+	if b.full || outage || overflow {
+		var dropped []*bufferedEvent
+		if !b.full {
+			b.full = true
+			s.receiverLogger.Warn("Offline buffer full, refusing the events until connected to NATSS again",
+				zap.Int("events", len(b.events)), zap.Int64("bytes", b.bytes), zap.Duration("outage", now.Sub(b.since)))
+			if b.config.DropOnOverflow {
+				dropped = b.takeAll()
+			}
+		}
+		b.mu.Unlock()
+		s.dropBuffered(dropped, "the offline buffer overflowed")
+		recordOfflineBuffer(channel, offlineBufferResultRefused)
+		markOfflineRefusal(ctx)
+		return true, errOfflineBufferFull
+	}
+	client, _ := ClientAddress(ctx)
+	b.events = append(b.events, &bufferedEvent{channel: channel, event: e, size: size, received: received, client: client})
+	b.bytes += size
+	b.channels[channel]++
+	first := b.channels[channel] == 1
+	bytes := b.bytes
+	b.mu.Unlock()
+
+	s.receiverLogger.Debug("Event buffered until connected to NATSS", zap.String("channel", channel.String()))
+	recordOfflineBuffer(channel, offlineBufferResultBuffered)
+	metrics.Record(context.Background(), offlineBufferBytesM.M(bytes))
+	if first {
+		s.notifyChannel(channel)
+	}
+	return true, nil
+}
+
+// flushOfflineBuffer publishes the buffered events in order on the connection just made, until the
+// buffer is empty or the connection is lost again. The events failing otherwise are dropped.
+func (s *SubscriptionsSupervisor) flushOfflineBuffer() {
+	b := s.offlineBuffer
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.flushing {
+		b.mu.Unlock()
+		return
+	}
+	b.full = false
+	var dropped []*bufferedEvent
+	if b.config.DropOnOverflow && b.config.MaxOutage > 0 && !b.since.IsZero() && time.Since(b.since) > b.config.MaxOutage {
+		dropped = b.takeAll()
+	}
+	if len(b.events) == 0 {
+		b.since = time.Time{}
+		b.mu.Unlock()
+		s.dropBuffered(dropped, "the outage lasted too long")
+		return
+	}
+	b.flushing = true
+	b.flushed = make(chan struct{})
+	b.mu.Unlock()
+
+	go func() {
+		flushed := 0
+		for {
+			b.mu.Lock()
+			if len(b.events) == 0 {
+				b.endFlush()
+				b.since = time.Time{}
+				b.mu.Unlock()
+				break
+			}
+			be := b.events[0]
+			b.mu.Unlock()
+
+			s.natssConnMux.Lock()
+			conn := s.natssConn
+			s.natssConnMux.Unlock()
+			err := s.noConnection()
+			if conn != nil {
+				ctx := context.Background()
+				if be.client != "" {
+					ctx = context.WithValue(ctx, clientAddressKey{}, be.client)
+				}
+				err = s.publish(ctx, *conn, be.channel, binding.ToMessage(be.event), nil, be.received)
+			}
+			if conn == nil || isConnectionClosed(err) {
+				// Published once connected again.
+				b.mu.Lock()
+				b.endFlush()
+				b.mu.Unlock()
+				s.receiverLogger.Warn("Connection to NATSS lost again while flushing the offline buffer", zap.Int("flushed", flushed))
+				return
+			}
+
+			b.mu.Lock()
+			// The events may have been dropped meanwhile, as the dispatcher stops.
+			if len(b.events) > 0 && b.events[0] == be {
+				b.events = b.events[1:]
+				b.release(be)
+			}
+			empty := b.channels[be.channel] == 0
+			bytes := b.bytes
+			b.mu.Unlock()
+			metrics.Record(context.Background(), offlineBufferBytesM.M(bytes))
+			if err != nil {
+				s.receiverLogger.Error("Dropped a buffered event failing to be published", zap.String("channel", be.channel.String()), zap.Error(err))
+				recordOfflineBuffer(be.channel, offlineBufferResultDropped)
+			} else {
+				flushed++
+				recordOfflineBuffer(be.channel, offlineBufferResultFlushed)
+			}
+			if empty {
+				s.notifyChannel(be.channel)
+			}
+		}
+		s.receiverLogger.Info("Offline buffer flushed", zap.Int("flushed", flushed))
+	}()
+}
+
+// dropOfflineBuffer drops the buffered events, lost as the dispatcher stops.
+func (s *SubscriptionsSupervisor) dropOfflineBuffer() {
+	b := s.offlineBuffer
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	dropped := b.takeAll()
+	b.mu.Unlock()
+	s.dropBuffered(dropped, "the dispatcher stopped")
+}
+
+// dropBuffered records the loss of the events taken from the buffer for reason.
+func (s *SubscriptionsSupervisor) dropBuffered(dropped []*bufferedEvent, reason string) {
+	if len(dropped) == 0 {
+		return
+	}
+	s.receiverLogger.Error("Buffered events lost", zap.Int("events", len(dropped)), zap.String("reason", reason))
+	channels := make(map[eventingchannels.ChannelReference]struct{})
+	for _, be := range dropped {
+		recordOfflineBuffer(be.channel, offlineBufferResultDropped)
+		channels[be.channel] = struct{}{}
+	}
+	metrics.Record(context.Background(), offlineBufferBytesM.M(0))
+	for channel := range channels {
+		s.notifyChannel(channel)
+	}
+}
+
+// takeAll empties the buffer, returning its events. b.mu must be held.
+func (b *offlineBuffer) takeAll() []*bufferedEvent {
+	events := b.events
+	b.events = nil
+	b.bytes = 0
+	b.channels = make(map[eventingchannels.ChannelReference]int)
+	return events
+}
+
+// endFlush records the end of the flush. b.mu must be held.
+func (b *offlineBuffer) endFlush() {
+	b.flushing = false
+	close(b.flushed)
+}
+
+// release accounts for be leaving the buffer. b.mu must be held.
+func (b *offlineBuffer) release(be *bufferedEvent) {
+	b.bytes -= be.size
+	if b.channels[be.channel]--; b.channels[be.channel] <= 0 {
+		delete(b.channels, be.channel)
+	}
+}
+
+// notifyChannel calls the connection notifier of channel.
+func (s *SubscriptionsSupervisor) notifyChannel(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.connectionNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// isConnectionClosed tells whether err is the failure of a publication on a lost connection.
+func isConnectionClosed(err error) bool {
+	return err != nil && errors.Cause(err).Error() == stan.ErrConnectionClosed.Error()
+}
+
+func recordOfflineBuffer(channel eventingchannels.ChannelReference, result string) {
+	mutators := append([]tag.Mutator{tag.Insert(offlineBufferResultKey, result)},
+		cardinality.Tags(cardinality.Resource{Namespace: channel.Namespace, Channel: channel.Name})...)
+	ctx, err := tag.New(context.Background(), mutators...)
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, offlineBufferEventCountM.M(1))
+}
+
+// offlineRefusalKey is the context key of the *int32 set when the offline buffer refuses an event.
+type offlineRefusalKey struct{}
+
+// markOfflineRefusal records in ctx that the offline buffer refused the event of the request.
+func markOfflineRefusal(ctx context.Context) {
+	if refused, ok := ctx.Value(offlineRefusalKey{}).(*int32); ok {
+		atomic.StoreInt32(refused, 1)
+	}
+}
+
+// withOfflineBuffer returns a handler answering 503 Service Unavailable, with a Retry-After, to the
+// events refused by the offline buffer, which the receiver of the eventing library answers 500.
+func (s *SubscriptionsSupervisor) withOfflineBuffer(next http.Handler) http.Handler {
+	if s.offlineBuffer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refused := new(int32)
+		next.ServeHTTP(&offlineRefusalWriter{ResponseWriter: w, refused: refused},
+			r.WithContext(context.WithValue(r.Context(), offlineRefusalKey{}, refused)))
+	})
+}
+
+// offlineRefusalWriter turns the 500 Internal Server Error of the refused events into a 503
+// Service Unavailable.
+type offlineRefusalWriter struct {
+	http.ResponseWriter
+	refused *int32
+}
+
+func (w *offlineRefusalWriter) WriteHeader(code int) {
+	if code == http.StatusInternalServerError && atomic.LoadInt32(w.refused) == 1 {
+		w.Header().Set("Retry-After", strconv.Itoa(offlineRetryAfter))
+		code = http.StatusServiceUnavailable
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+// storedEvents returns the IDs of the events published through conn.
+func storedEvents(conn *fakeStanConn) func() []string {
+	var mu sync.Mutex
+	var ids []string
+	conn.mu.Lock()
+	conn.subs = append(conn.subs, &fakeStanSubscription{conn: conn, cb: func(msg *stan.Msg) {
+		e := event.New()
+		if err := json.Unmarshal(msg.Data, &e); err != nil {
+			return
+		}
+		mu.Lock()
+		ids = append(ids, e.ID())
+		mu.Unlock()
+	}})
+	conn.mu.Unlock()
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ids...)
+	}
+}
+
+// sendOffline sends the event id to the receiver of s for ref, returning the error of the receiver.
+func sendOffline(s *SubscriptionsSupervisor, ref eventingchannels.ChannelReference, id string) error {
+	e := event.New()
+	e.SetID(id)
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	_ = e.SetData(event.ApplicationJSON, map[string]string{"id": id})
+	return messageReceiverFunc(s)(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{})
+}
+
+// postOffline posts the event id to handler, returning the response.
+func postOffline(handler http.Handler, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local/",
+		strings.NewReader(`{"specversion":"1.0","id":"`+id+`","type":"dev.knative.test","source":"test"}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitFlushed waits for the offline buffer of s to be flushed.
+func waitFlushed(t *testing.T, s *SubscriptionsSupervisor) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.offlineBuffer.mu.Lock()
+		done := !s.offlineBuffer.flushing && len(s.offlineBuffer.events) == 0
+		s.offlineBuffer.mu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the offline buffer was not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitDisconnected waits for s to drop its lost connection to NATSS.
+func waitDisconnected(t *testing.T, s *SubscriptionsSupervisor) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.natssConnMux.Lock()
+		disconnected := s.natssConn == nil
+		s.natssConnMux.Unlock()
+		if disconnected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the lost connection to NATSS was not dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOfflineBufferOutage(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	d, err := NewDispatcher(Args{ClientID: "test", OfflineBuffer: &OfflineBuffer{MaxEvents: 3}})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	s.connKey = stanutil.ConnKey{ClusterID: "default", ClientID: "test", URL: "nats://natss:4222"}
+	pool := newFakeConnPool()
+	s.conns = pool
+	s.maxPayloadOf = func(stan.Conn) int64 { return 0 }
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := messagingv1.Channel{}
+	channel.Namespace, channel.Name = ref.Namespace, ref.Name
+	channel.Status.Address = &duckv1.Addressable{URL: apis.HTTP("channel.ns.svc.cluster.local")}
+	if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{channel}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	var notified int32
+	s.WatchConnection(ref, func() { atomic.AddInt32(&notified, 1) })
+	handler := s.receiverHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Connect(ctx)
+	s.signalReconnect()
+	waitConnected(t, s)
+	pool.mu.Lock()
+	before := storedEvents(pool.conns[s.connKey])
+	pool.mu.Unlock()
+	if rec := postOffline(handler, "1"); rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d while connected, want %d", rec.Code, http.StatusAccepted)
+	}
+
+	// The server stops: the events are buffered until the buffer is full.
+	pool.mu.Lock()
+	pool.err = errors.New("connection refused")
+	pool.mu.Unlock()
+	pool.lose(s.connKey)
+	s.natssConnectionLost(s.connKey, errors.New("server stopped"))
+	waitDisconnected(t, s)
+	lost := atomic.LoadInt32(&notified)
+	for _, id := range []string{"2", "3", "4"} {
+		if rec := postOffline(handler, id); rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d for the event %s while disconnected, want %d", rec.Code, id, http.StatusAccepted)
+		}
+	}
+	if status := s.OfflineBufferStatus(ref); status.Events != 3 || status.Since.IsZero() {
+		t.Errorf("OfflineBufferStatus() = %+v, want 3 events", status)
+	}
+	if atomic.LoadInt32(&notified) == lost {
+		t.Error("the channel was not notified that it buffers")
+	}
+	rec := postOffline(handler, "5")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q once the buffer is full, want %d with a Retry-After",
+			rec.Code, rec.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+
+	// The server restarts: the buffered events are published in order, before the next ones.
+	after := newFakeStanConn()
+	published := storedEvents(after)
+	pool.mu.Lock()
+	pool.conns[s.connKey] = after
+	pool.err = nil
+	pool.mu.Unlock()
+	waitConnected(t, s)
+	waitFlushed(t, s)
+	if rec := postOffline(handler, "6"); rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d once connected again, want %d", rec.Code, http.StatusAccepted)
+	}
+	if got, want := published(), []string{"2", "3", "4", "6"}; !cmp.Equal(want, got) {
+		t.Errorf("published %v once connected again, want %v", got, want)
+	}
+	if got := before(); !cmp.Equal([]string{"1"}, got) {
+		t.Errorf("published %v before the outage, want [1]", got)
+	}
+	if status := s.OfflineBufferStatus(ref); status.Events != 0 {
+		t.Errorf("OfflineBufferStatus() = %+v once flushed, want no event", status)
+	}
+}
+
+func TestOfflineBufferOverflow(t *testing.T) {
+	testCases := map[string]struct {
+		config OfflineBuffer
+		// wait is how long the outage lasts before the last event.
+		wait          time.Duration
+		wantBuffered  int
+		wantPublished []string
+	}{
+		"full, flushed": {
+			config:        OfflineBuffer{MaxEvents: 2},
+			wantBuffered:  2,
+			wantPublished: []string{"1", "2"},
+		},
+		"full, dropped": {
+			config: OfflineBuffer{MaxEvents: 2, DropOnOverflow: true},
+		},
+		"too many bytes": {
+			config:        OfflineBuffer{MaxEvents: 10, MaxBytes: 20},
+			wantBuffered:  2,
+			wantPublished: []string{"1", "2"},
+		},
+		"outage too long, flushed": {
+			config:        OfflineBuffer{MaxEvents: 10, MaxOutage: 10 * time.Millisecond},
+			wait:          20 * time.Millisecond,
+			wantBuffered:  2,
+			wantPublished: []string{"1", "2"},
+		},
+		"outage too long, dropped": {
+			config: OfflineBuffer{MaxEvents: 10, MaxOutage: 10 * time.Millisecond, DropOnOverflow: true},
+			wait:   20 * time.Millisecond,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, _ := newTestSupervisor(t)
+			s.offlineBuffer = newOfflineBuffer(tc.config)
+			s.natssConn = nil
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+			// Each event carries 10 bytes of data.
+			for _, id := range []string{"1", "2"} {
+				if err := sendOffline(s, ref, id); err != nil {
+					t.Fatalf("the event %s was refused: %v", id, err)
+				}
+			}
+			time.Sleep(tc.wait)
+			if err := sendOffline(s, ref, "3"); err != errOfflineBufferFull {
+				t.Errorf("sending the event 3 = %v, want %v", err, errOfflineBufferFull)
+			}
+			if status := s.OfflineBufferStatus(ref); status.Events != tc.wantBuffered {
+				t.Errorf("OfflineBufferStatus() = %+v, want %d events", status, tc.wantBuffered)
+			}
+
+			conn := newFakeStanConn()
+			published := storedEvents(conn)
+			var natssConn stan.Conn = conn
+			s.natssConnMux.Lock()
+			s.natssConn = &natssConn
+			s.natssConnMux.Unlock()
+			s.flushOfflineBuffer()
+			waitFlushed(t, s)
+			if got := published(); !cmp.Equal(tc.wantPublished, got) {
+				t.Errorf("published %v, want %v", got, tc.wantPublished)
+			}
+			// The events are accepted again once connected.
+			if err := sendOffline(s, ref, "4"); err != nil {
+				t.Errorf("sending the event 4 once connected = %v", err)
+			}
+		})
+	}
+}
+
+func TestOfflineBufferLostOnStop(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.offlineBuffer = newOfflineBuffer(OfflineBuffer{MaxEvents: 10})
+	s.natssConn = nil
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchConnection(ref, func() { atomic.AddInt32(&notified, 1) })
+	for _, id := range []string{"1", "2"} {
+		if err := sendOffline(s, ref, id); err != nil {
+			t.Fatalf("the event %s was refused: %v", id, err)
+		}
+	}
+
+	// The dispatcher stops before NATSS is reachable again: the buffered events are lost, as they
+	// are held in memory only.
+	buffering := atomic.LoadInt32(&notified)
+	s.dropOfflineBuffer()
+	if status := s.OfflineBufferStatus(ref); status.Events != 0 {
+		t.Errorf("OfflineBufferStatus() = %+v once stopped, want no event", status)
+	}
+	if atomic.LoadInt32(&notified) == buffering {
+		t.Error("the channel was not notified that it stopped buffering")
+	}
+
+	conn := newFakeStanConn()
+	published := storedEvents(conn)
+	var natssConn stan.Conn = conn
+	s.natssConn = &natssConn
+	s.flushOfflineBuffer()
+	waitFlushed(t, s)
+	if got := published(); len(got) != 0 {
+		t.Errorf("published %v, want the events lost", got)
+	}
+}
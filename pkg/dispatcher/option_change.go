@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+// OptionChangePolicySetter is implemented by the dispatchers able to tell how the subscriptions
+// of a channel follow the changes of their options NATSS does not apply to their durables, as
+// planner.DecideOptionChange tells.
+type OptionChangePolicySetter interface {
+	// SetOptionChangePolicy sets the option change policy of channel. It must be called before
+	// updating the subscriptions of the channel to take effect.
+	SetOptionChangePolicy(channel eventingchannels.ChannelReference, policy v1beta1.OptionChangePolicy)
+	// NotAppliedOptions returns the changes of the options of the subscription of channel its
+	// durable does not apply, empty when it applies them all.
+	NotAppliedOptions(channel eventingchannels.ChannelReference, subscription types.UID) string
+}
+
+var _ OptionChangePolicySetter = (*SubscriptionsSupervisor)(nil)
+
+// SetOptionChangePolicy implements OptionChangePolicySetter.
+func (s *SubscriptionsSupervisor) SetOptionChangePolicy(channel eventingchannels.ChannelReference, policy v1beta1.OptionChangePolicy) {
+	if policy.OrDefault() == v1beta1.OptionChangeIgnore {
+		s.optionChangePolicies.Delete(channel)
+		return
+	}
+	s.optionChangePolicies.Store(channel, policy)
+}
+
+// NotAppliedOptions implements OptionChangePolicySetter.
+func (s *SubscriptionsSupervisor) NotAppliedOptions(channel eventingchannels.ChannelReference, subscription types.UID) string {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	options, ok := s.subscribedOptions[channel][subscription]
+	if !ok {
+		return ""
+	}
+	// The subscriptions keep the options they were made with until the channel is updated.
+	change := planner.DecideOptionChange(s.optionChangePolicy(channel), options,
+		s.optionsOfSubscription(channel, subscription), !s.subscribedEphemeral[channel][subscription])
+	if change.Action != planner.Keep {
+		return ""
+	}
+	return change.NotApplied
+}
+
+// optionChangePolicy returns the option change policy of channel.
+func (s *SubscriptionsSupervisor) optionChangePolicy(channel eventingchannels.ChannelReference) v1beta1.OptionChangePolicy {
+	if policy, ok := s.optionChangePolicies.Load(channel); ok {
+		return policy.(v1beta1.OptionChangePolicy)
+	}
+	return v1beta1.OptionChangeIgnore
+}
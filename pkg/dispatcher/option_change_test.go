@@ -0,0 +1,82 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go/pb"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestOptionChangePolicy(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() *fakeStanSubscription {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+		if len(conn.subs) != 1 {
+			t.Fatalf("%d subscriptions, want 1", len(conn.subs))
+		}
+		return conn.subs[0]
+	}
+	const ignored = "start position changed from new-only to all-available, not applied to the existing durable"
+
+	sub := update()
+	// By default, the start position is left to the durables made from then on.
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{StartAt: v1beta1.StartPositionAllAvailable})
+	if got := update(); got != sub {
+		t.Error("subscription made again, want it kept")
+	}
+	if got := s.NotAppliedOptions(ref, uid); got != ignored {
+		t.Errorf("NotAppliedOptions() = %q, want %q", got, ignored)
+	}
+	// The other changes are applied from the durable.
+	s.SetDeliveryOptions(ref, v1beta1.DeliveryOptions{StartAt: v1beta1.StartPositionAllAvailable, AckWait: 5 * time.Minute})
+	got := update()
+	if got == sub || got.durable != sub.durable || got.ackWait != 5*time.Minute {
+		t.Errorf("subscription of the durable %q with ack wait %v, want the durable %q subscribed again with 5m0s", got.durable, got.ackWait, sub.durable)
+	}
+	if got := s.NotAppliedOptions(ref, uid); got != ignored {
+		t.Errorf("NotAppliedOptions() = %q, want %q", got, ignored)
+	}
+
+	// Recreating the durable applies the start position.
+	s.SetOptionChangePolicy(ref, v1beta1.OptionChangeRecreate)
+	sub = got
+	got = update()
+	if got == sub || got.startAt != pb.StartPosition_First {
+		t.Errorf("subscription starting at %v, want a new durable starting at %v", got.startAt, pb.StartPosition_First)
+	}
+	if len(conn.closed) != 0 {
+		t.Errorf("durables left closed: %v", conn.closed)
+	}
+	if got := s.NotAppliedOptions(ref, uid); got != "" {
+		t.Errorf("NotAppliedOptions() = %q, want the changes applied", got)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+const (
+	// defaultOrderedRetryDelay is how long an ordered subscription waits before retrying a failed
+	// delivery, doubled by each retry up to maxOrderedRetryDelay.
+	defaultOrderedRetryDelay = time.Second
+	maxOrderedRetryDelay     = 30 * time.Second
+	// defaultOrderedRetryPoll is how often an ordered subscription waiting to retry checks it is
+	// still held.
+	defaultOrderedRetryPoll = 100 * time.Millisecond
+)
+
+// OrderedSetter is implemented by the dispatchers able to deliver the events of some of the
+// subscriptions of a channel in order. An ordered subscription has a max inflight of one and a
+// single consumer, and retries a failed delivery until it succeeds before acknowledging it, so
+// that NATSS never sends the next event before, its throughput being bounded by the latency of
+// its subscriber. The subscriptions of a channel delivered in order are all ordered.
+type OrderedSetter interface {
+	// SetOrdered sets the subscriptions of channel delivered in order, by UID. It must be called
+	// before updating the subscriptions of the channel to take effect, which makes again from
+	// their durables the subscriptions switching between ordered and unordered.
+	SetOrdered(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool)
+	// Ordered tells whether the dispatcher holds the subscription of channel ordered.
+	Ordered(channel eventingchannels.ChannelReference, subscription types.UID) bool
+}
+
+var _ OrderedSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetOrdered implements OrderedSetter.
+func (s *SubscriptionsSupervisor) SetOrdered(channel eventingchannels.ChannelReference, subscriptions map[types.UID]bool) {
+	if len(subscriptions) == 0 {
+		s.ordered.Delete(channel)
+		return
+	}
+	s.ordered.Store(channel, subscriptions)
+}
+
+// Ordered implements OrderedSetter.
+func (s *SubscriptionsSupervisor) Ordered(channel eventingchannels.ChannelReference, subscription types.UID) bool {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	return s.subscribedOptions[channel][subscription].Ordered
+}
+
+// orderedSubscriptions returns the subscriptions of channel delivered in order when its events are
+// distributed with distribution, besides those of a channel delivered in order. The members of a
+// work queue share its queue group, and are never ordered.
+func (s *SubscriptionsSupervisor) orderedSubscriptions(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution) map[types.UID]bool {
+	if distribution == v1beta1.DistributionWorkQueue {
+		return nil
+	}
+	if subscriptions, ok := s.ordered.Load(channel); ok {
+		return subscriptions.(map[types.UID]bool)
+	}
+	return nil
+}
+
+// orderedOptions returns the options of the ordered subscriptions of channel, by UID, options
+// being those of the other subscriptions.
+func (s *SubscriptionsSupervisor) orderedOptions(channel eventingchannels.ChannelReference, distribution v1beta1.Distribution, options planner.Options) map[types.UID]planner.Options {
+	subscriptions := s.orderedSubscriptions(channel, distribution)
+	if len(subscriptions) == 0 || options.Ordered {
+		return nil
+	}
+	// NATSS sends the next event of a subscription once the previous one is acknowledged.
+	options.MaxInflight, options.Ordered = 1, true
+	ordered := make(map[types.UID]planner.Options, len(subscriptions))
+	for uid := range subscriptions {
+		ordered[uid] = options
+	}
+	return ordered
+}
+
+// optionsOfSubscription returns the options to subscribe subscription of channel with.
+func (s *SubscriptionsSupervisor) optionsOfSubscription(channel eventingchannels.ChannelReference, subscription types.UID) planner.Options {
+	options := s.subscriptionOptions(channel)
+	if ordered, ok := s.orderedOptions(channel, s.distribution(channel), options)[subscription]; ok {
+		return ordered
+	}
+	return options
+}
+
+// heldSubscription is the subscription an ordered subscription retries its failed deliveries for,
+// until it is closed.
+type heldSubscription struct {
+	mu  sync.Mutex
+	sub stan.Subscription
+}
+
+func (h *heldSubscription) set(sub stan.Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sub = sub
+}
+
+// valid tells whether the subscription is still held, which it is while being made.
+func (h *heldSubscription) valid() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sub == nil || h.sub.IsValid()
+}
+
+// waitOrderedRetry waits for delay before an ordered subscription retries a failed delivery,
+// returning false when held is closed or the dispatcher stops in the meantime, the event being
+// redelivered by NATSS once subscribed again.
+func (s *SubscriptionsSupervisor) waitOrderedRetry(held *heldSubscription, delay time.Duration) bool {
+	deadline := time.Now().Add(delay)
+	for {
+		if s.isDraining() || !held.valid() {
+			return false
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return true
+		}
+		if wait > s.orderedRetryPoll {
+			wait = s.orderedRetryPoll
+		}
+		time.Sleep(wait)
+	}
+}
+
+// nextOrderedRetryDelay returns the delay of the retry following one after delay.
+func nextOrderedRetryDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay > maxOrderedRetryDelay {
+		return maxOrderedRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// retriedSubscriber accepts the events, failing the attempts fail selects.
+type retriedSubscriber struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	attempts map[string]int
+	accepted []string
+}
+
+func newRetriedSubscriber(fail func(id string, attempt int) bool) *retriedSubscriber {
+	f := &retriedSubscriber{attempts: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.attempts[e.ID()]++
+		if fail(e.ID(), f.attempts[e.ID()]) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f.accepted = append(f.accepted, e.ID())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return f
+}
+
+func (f *retriedSubscriber) received() (accepted []string, attempts int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, n := range f.attempts {
+		attempts += n
+	}
+	return append([]string(nil), f.accepted...), attempts
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	// Every other event fails twice before being accepted.
+	subscriber := newRetriedSubscriber(func(id string, attempt int) bool {
+		var n int
+		fmt.Sscanf(id, "id-%d", &n)
+		return n%2 == 1 && attempt <= 2
+	})
+	defer subscriber.Close()
+	s, conn := newGoroutineSupervisor(t, DefaultDeliveryConcurrency)
+	s.orderedRetryDelay, s.orderedRetryPoll = time.Millisecond, time.Millisecond
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetOrdered(ref, map[types.UID]bool{"uid-0": true})
+	if failed, err := s.UpdateSubscriptions(context.Background(), newChannelOf(ref, subscriber.Server), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if !s.Ordered(ref, "uid-0") {
+		t.Fatal("the subscription is not ordered")
+	}
+
+	const events = 20
+	publishTestEvents(t, conn, ref, events)
+	deadline := time.Now().Add(5 * time.Second)
+	for accepted, _ := subscriber.received(); len(accepted) < events && time.Now().Before(deadline); accepted, _ = subscriber.received() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := make([]string, events)
+	for i := range want {
+		want[i] = fmt.Sprintf("id-%d", i)
+	}
+	accepted, attempts := subscriber.received()
+	if diff := cmp.Diff(want, accepted); diff != "" {
+		t.Errorf("unexpected events accepted (-want, +got): %s", diff)
+	}
+	if want := events * 2; attempts != want {
+		t.Errorf("%d attempts, want %d", attempts, want)
+	}
+}
+
+func TestOrderedSwitch(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+	s, conn := newTestSupervisor(t)
+	s.maxInflight = 16
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscriber)
+	uid := channel.Spec.Subscribers[0].UID
+	update := func() *fakeStanSubscription {
+		t.Helper()
+		if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+			t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+		}
+		if len(conn.subs) != 1 {
+			t.Fatalf("got %d subscriptions, want 1", len(conn.subs))
+		}
+		return conn.subs[0]
+	}
+	unordered := update()
+
+	// The ordered subscriptions have a single consumer.
+	s.SetConsumers(ref, map[types.UID]int{uid: 3})
+	s.SetOrdered(ref, map[types.UID]bool{uid: true})
+	ordered := update()
+	if ordered == unordered || unordered.IsValid() {
+		t.Error("the subscription made ordered was not made again")
+	}
+	if ordered.maxInflight != 1 || !s.Ordered(ref, uid) {
+		t.Errorf("subscription with max inflight %d, ordered %t, want 1, ordered", ordered.maxInflight, s.Ordered(ref, uid))
+	}
+	if again := update(); again != ordered {
+		t.Error("the ordered subscription was made again without changes")
+	}
+
+	s.SetConsumers(ref, nil)
+	s.SetOrdered(ref, nil)
+	unordered = update()
+	if unordered == ordered || ordered.IsValid() {
+		t.Error("the subscription made unordered was not made again")
+	}
+	if unordered.maxInflight != 16 || s.Ordered(ref, uid) {
+		t.Errorf("subscription with max inflight %d, ordered %t, want 16, unordered", unordered.maxInflight, s.Ordered(ref, uid))
+	}
+}
+
+func TestOrderedRetriesStopWhenClosed(t *testing.T) {
+	subscriber := newRetriedSubscriber(func(string, int) bool { return true })
+	defer subscriber.Close()
+	s, conn := newGoroutineSupervisor(t, DefaultDeliveryConcurrency)
+	s.orderedRetryDelay, s.orderedRetryPoll = time.Millisecond, time.Millisecond
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newChannelOf(ref, subscriber.Server)
+	s.SetOrdered(ref, map[types.UID]bool{"uid-0": true})
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	publishTestEvents(t, conn, ref, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for _, attempts := subscriber.received(); attempts < 3 && time.Now().Before(deadline); _, attempts = subscriber.received() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Made unordered, the subscription is closed, which ends the retries of the event.
+	s.SetOrdered(ref, nil)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for s.dispatches.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.dispatches.count(); n != 0 {
+		t.Fatalf("%d events still being dispatched, want the retries ended", n)
+	}
+	_, before := subscriber.received()
+	time.Sleep(50 * time.Millisecond)
+	if _, after := subscriber.received(); after != before {
+		t.Errorf("%d more attempts after the subscription was closed, want none", after-before)
+	}
+}
+
+func TestOrderedRetriesFreeTheWorker(t *testing.T) {
+	failing := newRetriedSubscriber(func(string, int) bool { return true })
+	defer failing.Close()
+	healthy := newEventRecorder()
+	defer healthy.Close()
+	// A single worker, which the retries of the ordered subscription must not keep.
+	s, conn := newGoroutineSupervisor(t, 1)
+	s.orderedRetryDelay, s.orderedRetryPoll = 20*time.Millisecond, 20*time.Millisecond
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetOrdered(ref, map[types.UID]bool{"uid-0": true})
+	if failed, err := s.UpdateSubscriptions(context.Background(), newChannelOf(ref, failing.Server, healthy.Server), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	// The events are published once the ordered subscription retries its first one.
+	publishTestEvents(t, conn, ref, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, attempts := failing.received(); attempts < 2 && time.Now().Before(deadline); _, attempts = failing.received() {
+		time.Sleep(time.Millisecond)
+	}
+	const events = 10
+	publishTestEvents(t, conn, ref, events)
+	for len(healthy.received()) < events+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(healthy.received()); got != events+1 {
+		t.Errorf("the healthy subscriber received %d events while the ordered one kept failing, want %d", got, events+1)
+	}
+	for _, attempts := failing.received(); attempts < 3 && time.Now().Before(deadline); _, attempts = failing.received() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, attempts := failing.received(); attempts < 3 {
+		t.Errorf("the failing subscriber got %d attempts, want the retries going on", attempts)
+	}
+	// Between its attempts, the ordered subscription holds none of the buffered bytes.
+	deadline = time.Now().Add(2 * time.Second)
+	for s.buffer.bufferedBytes() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := s.buffer.bufferedBytes(); n != 0 {
+		t.Errorf("%d bytes still buffered while the ordered subscription waits to retry, want 0", n)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// OrphanedSubscriberError is the error of the subscribers of a channel refused because their
+// Subscription no longer exists.
+type OrphanedSubscriberError struct{}
+
+func (e *OrphanedSubscriberError) Error() string {
+	return "OrphanedSubscriber: the Subscription of the subscriber no longer exists, its subscription is paused"
+}
+
+// OrphanedSubscriberPauser is implemented by the dispatchers able to pause the subscriptions of
+// the subscribers left in the spec of a channel after their Subscription was deleted.
+type OrphanedSubscriberPauser interface {
+	// PauseOrphanedSubscribers sets the subscribers of channel whose subscriptions are paused, by
+	// UID, none resuming them. The orphaned subscribers fail with an *OrphanedSubscriberError
+	// when the channel is updated, and their subscriptions made before are closed, keeping their
+	// durables.
+	PauseOrphanedSubscribers(channel eventingchannels.ChannelReference, orphans map[types.UID]bool)
+}
+
+var _ OrphanedSubscriberPauser = (*SubscriptionsSupervisor)(nil)
+
+// PauseOrphanedSubscribers implements OrphanedSubscriberPauser.
+func (s *SubscriptionsSupervisor) PauseOrphanedSubscribers(channel eventingchannels.ChannelReference, orphans map[types.UID]bool) {
+	if len(orphans) == 0 {
+		s.orphanedSubscribers.Delete(channel)
+		return
+	}
+	s.orphanedSubscribers.Store(channel, orphans)
+}
+
+// refuseOrphaned returns the subscribers of channel which are not orphaned, adding the others to
+// refused.
+func (s *SubscriptionsSupervisor) refuseOrphaned(channel eventingchannels.ChannelReference, subscribers []eventingduckv1.SubscriberSpec, refused map[eventingduckv1.SubscriberSpec]error) []eventingduckv1.SubscriberSpec {
+	v, ok := s.orphanedSubscribers.Load(channel)
+	if !ok {
+		return subscribers
+	}
+	orphans := v.(map[types.UID]bool)
+	kept := make([]eventingduckv1.SubscriberSpec, 0, len(subscribers))
+	for _, sub := range subscribers {
+		if orphans[sub.UID] {
+			refused[sub] = &OrphanedSubscriberError{}
+			continue
+		}
+		kept = append(kept, sub)
+	}
+	return kept
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestPauseOrphanedSubscribers(t *testing.T) {
+	var subscribers []*eventRecorder
+	for i := 0; i < 3; i++ {
+		subscriber := newEventRecorder()
+		defer subscriber.Close()
+		subscribers = append(subscribers, subscriber)
+	}
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref, subscribers...)
+	update := func(orphans map[types.UID]bool) []types.UID {
+		t.Helper()
+		s.PauseOrphanedSubscribers(ref, orphans)
+		failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+		if err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+		var refused []types.UID
+		for sub, err := range failed {
+			var orphaned *OrphanedSubscriberError
+			if !errors.As(err, &orphaned) {
+				t.Errorf("subscriber %s failed with %v, want an orphaned subscriber", sub.UID, err)
+			}
+			refused = append(refused, sub.UID)
+		}
+		return refused
+	}
+
+	if refused := update(nil); len(refused) != 0 || len(conn.subs) != 3 {
+		t.Fatalf("refused %v with %d subscriptions, want none and 3", refused, len(conn.subs))
+	}
+
+	// The subscription of the orphaned subscriber is closed, keeping its durable.
+	if diff := cmp.Diff([]types.UID{"uid-1"}, update(map[types.UID]bool{"uid-1": true})); diff != "" {
+		t.Errorf("unexpected refused subscribers (-want, +got): %s", diff)
+	}
+	if len(conn.subs) != 2 || len(conn.closed) != 1 {
+		t.Errorf("got %d subscriptions and %d closed durables, want 2 and 1", len(conn.subs), len(conn.closed))
+	}
+	if n, _ := s.fanout.Load(ref); n != 2 {
+		t.Errorf("fan-out = %v, want 2", n)
+	}
+
+	// Resuming the subscriber makes its subscription again from its durable.
+	if refused := update(nil); len(refused) != 0 {
+		t.Errorf("subscribers %v refused once resumed", refused)
+	}
+	if len(conn.subs) != 3 || len(conn.closed) != 0 {
+		t.Errorf("got %d subscriptions and %d closed durables, want 3 and 0", len(conn.subs), len(conn.closed))
+	}
+}
+
+func TestAdmitSubscribersOrphanedBeforeFanoutLimit(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	s.SetFanoutLimit(ref, 2)
+	s.PauseOrphanedSubscribers(ref, map[types.UID]bool{"a": true})
+	subscribers := []eventingduckv1.SubscriberSpec{{UID: "a"}, {UID: "b"}, {UID: "c"}}
+
+	// The orphaned subscriber does not count against the fan-out limit.
+	admitted, refused := s.admitSubscribers(ref, subscribers)
+	if diff := cmp.Diff(subscribers[1:], admitted); diff != "" {
+		t.Errorf("unexpected admitted subscribers (-want, +got): %s", diff)
+	}
+	if _, ok := refused[subscribers[0]].(*OrphanedSubscriberError); len(refused) != 1 || !ok {
+		t.Errorf("refused = %v, want a alone, orphaned", refused)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// Version is the version of the dispatcher reported in the User-Agent of its deliveries, set at
+// build time with -ldflags "-X knative.dev/eventing-natss/pkg/dispatcher.Version=<version>".
+var Version = "devel"
+
+// OriginHeader is the header telling the receivers which component delivered a request.
+const OriginHeader = "Knative-Origin"
+
+// OutboundHeaders configures the headers set on the requests sent by the dispatcher.
+type OutboundHeaders struct {
+	// UserAgent is the User-Agent of the requests, in which {version}, {namespace} and {name}
+	// are replaced by the version of the dispatcher and the namespace and name of the channel.
+	// An empty UserAgent suppresses the header.
+	UserAgent string
+
+	// Origin is the value of the OriginHeader, an empty Origin suppresses the header.
+	Origin string
+}
+
+// requestDecorator modifies an outbound request sent on behalf of channel.
+type requestDecorator func(req *http.Request, channel eventingchannels.ChannelReference)
+
+// outboundChannelKey is the context key of the channel an outbound request is sent for.
+type outboundChannelKey struct{}
+
+// withOutboundChannel returns a context whose outbound requests are decorated for channel.
+func withOutboundChannel(ctx context.Context, channel eventingchannels.ChannelReference) context.Context {
+	return context.WithValue(ctx, outboundChannelKey{}, channel)
+}
+
+// outboundChannel returns the channel the requests sent with ctx are decorated for.
+func outboundChannel(ctx context.Context) (eventingchannels.ChannelReference, bool) {
+	channel, ok := ctx.Value(outboundChannelKey{}).(eventingchannels.ChannelReference)
+	return channel, ok
+}
+
+// outboundTransport applies the decorators to the requests sent for a channel before handing
+// them to the base transport. All the requests of the dispatcher, the deliveries, replies and
+// dead letters as well as the warm ups and the audit copies, go through it, so that whatever
+// they must carry is added in a single place.
+type outboundTransport struct {
+	base       http.RoundTripper
+	decorators []requestDecorator
+}
+
+var _ http.RoundTripper = (*outboundTransport)(nil)
+
+// newOutboundClient returns a copy of client whose requests are decorated by decorators.
+func newOutboundClient(client *http.Client, decorators ...requestDecorator) *http.Client {
+	decorated := *client
+	decorated.Transport = newOutboundTransport(client.Transport, decorators...)
+	return &decorated
+}
+
+func newOutboundTransport(base http.RoundTripper, decorators ...requestDecorator) *outboundTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &outboundTransport{base: base, decorators: decorators}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	channel, ok := outboundChannel(req.Context())
+	if !ok || len(t.decorators) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it is given.
+	req = req.Clone(req.Context())
+	for _, decorate := range t.decorators {
+		decorate(req, channel)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// decorators returns the decorators setting the headers configured by h.
+func (h OutboundHeaders) decorators() []requestDecorator {
+	return []requestDecorator{
+		func(req *http.Request, channel eventingchannels.ChannelReference) {
+			// An empty User-Agent keeps the client from sending its own.
+			req.Header.Set("User-Agent", h.userAgent(channel))
+		},
+		func(req *http.Request, _ eventingchannels.ChannelReference) {
+			if h.Origin != "" {
+				req.Header.Set(OriginHeader, h.Origin)
+			}
+		},
+	}
+}
+
+func (h OutboundHeaders) userAgent(channel eventingchannels.ChannelReference) string {
+	return strings.NewReplacer(
+		"{version}", Version,
+		"{namespace}", channel.Namespace,
+		"{name}", channel.Name,
+	).Replace(h.UserAgent)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// headerRecorder records the headers of the last request it received.
+func headerRecorder(t *testing.T) (*httptest.Server, func() http.Header) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() http.Header {
+		select {
+		case h := <-headers:
+			return h
+		default:
+			t.Fatal("no request received")
+			return nil
+		}
+	}
+}
+
+func TestOutboundTransport(t *testing.T) {
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "payments"}
+	testCases := map[string]struct {
+		headers       OutboundHeaders
+		noChannel     bool
+		wantUserAgent string
+		wantOrigin    string
+	}{
+		"default": {
+			headers: OutboundHeaders{
+				UserAgent: "knative-eventing-natss-dispatcher/{version} channel/{namespace}/{name}",
+				Origin:    "natsschannel",
+			},
+			wantUserAgent: "knative-eventing-natss-dispatcher/devel channel/ns/payments",
+			wantOrigin:    "natsschannel",
+		},
+		"overridden": {
+			headers:       OutboundHeaders{UserAgent: "acme-bus {name}", Origin: "acme"},
+			wantUserAgent: "acme-bus payments",
+			wantOrigin:    "acme",
+		},
+		"suppressed": {
+			headers: OutboundHeaders{},
+		},
+		"not sent for a channel": {
+			headers:       OutboundHeaders{UserAgent: "natss", Origin: "natsschannel"},
+			noChannel:     true,
+			wantUserAgent: "Go-http-client/1.1",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			server, received := headerRecorder(t)
+			client := newOutboundClient(&http.Client{}, tc.headers.decorators()...)
+
+			ctx := context.Background()
+			if !tc.noChannel {
+				ctx = withOutboundChannel(ctx, channel)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+			if err != nil {
+				t.Fatalf("NewRequest() = %v", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() = %v", err)
+			}
+			resp.Body.Close()
+
+			got := received()
+			if ua := got.Get("User-Agent"); ua != tc.wantUserAgent {
+				t.Errorf("User-Agent = %q, want %q", ua, tc.wantUserAgent)
+			}
+			if origin := got.Get(OriginHeader); origin != tc.wantOrigin {
+				t.Errorf("%s = %q, want %q", OriginHeader, origin, tc.wantOrigin)
+			}
+			if len(req.Header) != 0 {
+				t.Errorf("request headers = %v, want the request of the caller to be left unchanged", req.Header)
+			}
+		})
+	}
+}
+
+func TestDispatchMessageOutboundHeaders(t *testing.T) {
+	server, received := headerRecorder(t)
+	d, err := NewDispatcher(Args{
+		ClientID:        "test",
+		OutboundHeaders: &OutboundHeaders{UserAgent: "natss channel/{namespace}/{name}", Origin: "natsschannel"},
+	})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+
+	e := event.New()
+	e.SetID("1")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "payments"}
+	if !s.dispatchMessage(context.Background(), channel, binding.ToMessage(&e), mustParseURL(t, server.URL), nil, nil) {
+		t.Fatal("dispatchMessage() = false, want the delivery to succeed")
+	}
+
+	got := received()
+	if ua := got.Get("User-Agent"); ua != "natss channel/ns/payments" {
+		t.Errorf("User-Agent = %q, want %q", ua, "natss channel/ns/payments")
+	}
+	if origin := got.Get(OriginHeader); origin != "natsschannel" {
+		t.Errorf("%s = %q, want %q", OriginHeader, origin, "natsschannel")
+	}
+}
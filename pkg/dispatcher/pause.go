@@ -0,0 +1,386 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+const (
+	// DefaultUnhealthyProbeInterval is how often the subscribers of the paused subscriptions are
+	// probed when no interval is configured.
+	DefaultUnhealthyProbeInterval = 30 * time.Second
+
+	// The transitions of the paused subscriptions, tagging pauseTransitionsM.
+	pauseTransitionPaused          = "paused"
+	pauseTransitionResumedProbe    = "resumed_probe"
+	pauseTransitionResumedOperator = "resumed_operator"
+)
+
+var (
+	// probeTimeout bounds the time spent probing the subscriber of a paused subscription.
+	probeTimeout = 5 * time.Second
+
+	// pauseTransitionsM records the subscriptions paused because their subscriber kept failing,
+	// and resumed.
+	pauseTransitionsM = stats.Int64(
+		"subscriber_pause_count",
+		"Number of subscriptions paused because their subscriber kept failing, and resumed",
+		stats.UnitDimensionless,
+	)
+
+	// pauseTransitionKey tells whether a subscription was paused, or resumed by a probe or by an
+	// operator.
+	pauseTransitionKey = tag.MustNewKey("transition")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: pauseTransitionsM.Description(),
+			Measure:     pauseTransitionsM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{pauseTransitionKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// UnhealthyPauser is implemented by the dispatchers pausing the subscriptions whose subscriber
+// failed every delivery for too long.
+type UnhealthyPauser interface {
+	// WatchPauses sets the function called when a subscription of channel is paused or resumed,
+	// nil removing it.
+	WatchPauses(channel eventingchannels.ChannelReference, notify func())
+	// PausedSince returns when subscription was paused, and false when it is not paused.
+	PausedSince(subscription types.UID) (time.Time, bool)
+	// ResumeSubscription resumes the paused subscription, returning false when it is not paused
+	// or could not be made again.
+	ResumeSubscription(subscription types.UID) bool
+	// LastErrorResponse describes the body of the last error response of the subscriber of the
+	// paused subscription, empty when it is not paused or the body was not kept.
+	LastErrorResponse(subscription types.UID) string
+}
+
+var _ UnhealthyPauser = (*SubscriptionsSupervisor)(nil)
+
+// subscriberHealth tracks the failed deliveries of a subscription.
+type subscriberHealth struct {
+	mu sync.Mutex
+	// failingSince is the first of the deliveries which all failed since, zero after a success.
+	failingSince time.Time
+	// lastResponse describes the body of the last error response of the subscriber.
+	lastResponse string
+	pausing      bool
+}
+
+// pausedSubscription is a subscription closed because its subscriber was unhealthy, its durable
+// keeping the events until it is made again.
+type pausedSubscription struct {
+	ctx          context.Context
+	channel      eventingchannels.ChannelReference
+	subscription subscriptionReference
+	since        time.Time
+	lastResponse string
+}
+
+// WatchPauses implements UnhealthyPauser.
+func (s *SubscriptionsSupervisor) WatchPauses(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.pauseNotifiers.Delete(channel)
+		return
+	}
+	s.pauseNotifiers.Store(channel, notify)
+}
+
+// PausedSince implements UnhealthyPauser.
+func (s *SubscriptionsSupervisor) PausedSince(subscription types.UID) (time.Time, bool) {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	if p, ok := s.paused[subscription]; ok {
+		return p.since, true
+	}
+	return time.Time{}, false
+}
+
+// LastErrorResponse implements UnhealthyPauser.
+func (s *SubscriptionsSupervisor) LastErrorResponse(subscription types.UID) string {
+	s.subscriptionsMux.Lock()
+	defer s.subscriptionsMux.Unlock()
+	if p, ok := s.paused[subscription]; ok {
+		return p.lastResponse
+	}
+	return ""
+}
+
+// ResumeSubscription implements UnhealthyPauser.
+func (s *SubscriptionsSupervisor) ResumeSubscription(subscription types.UID) bool {
+	return s.resume(subscription, pauseTransitionResumedOperator)
+}
+
+func (s *SubscriptionsSupervisor) notifyPause(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.pauseNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// subscriberFailed returns whether result tells that the subscriber is unhealthy: it did not
+// answer, or answered with a server error. The events it refuses do not count.
+func subscriberFailed(result deliveryResult) bool {
+	if result.status == DeliveryStatusDelivered {
+		return false
+	}
+	return result.code == eventingchannels.NoResponse || result.code >= http.StatusInternalServerError
+}
+
+// recordHealth records the result of a delivery to subscription, and pauses it in the background
+// when its deliveries all failed for longer than the pause threshold.
+func (s *SubscriptionsSupervisor) recordHealth(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference, result deliveryResult) {
+	if s.unhealthyPauseAfter <= 0 {
+		return
+	}
+	v, _ := s.health.LoadOrStore(subscription.UID, &subscriberHealth{})
+	h := v.(*subscriberHealth)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !subscriberFailed(result) {
+		h.failingSince, h.lastResponse = time.Time{}, ""
+		return
+	}
+	if result.response != "" {
+		h.lastResponse = result.response
+	}
+	now := time.Now()
+	if h.failingSince.IsZero() {
+		h.failingSince = now
+		return
+	}
+	if h.pausing || now.Sub(h.failingSince) < s.unhealthyPauseAfter {
+		return
+	}
+	h.pausing = true
+	// The subscription is closed outside of its own callback.
+	go s.pause(ctx, channel, subscription, h.failingSince, h.lastResponse)
+}
+
+// pause closes the subscription, keeping its durable, until its subscriber is healthy again or
+// an operator resumes it.
+func (s *SubscriptionsSupervisor) pause(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference, failingSince time.Time, lastResponse string) {
+	s.subscriptionsMux.Lock()
+	sub, ok := s.subscriptions[channel][subscription.UID]
+	if !ok {
+		// Unsubscribed or hibernated in the meantime.
+		s.subscriptionsMux.Unlock()
+		s.health.Delete(subscription.UID)
+		return
+	}
+	s.stopMigration(subscription.UID, false)
+	if err := (*sub).Close(); err != nil {
+		s.subscriptionsLogger.Error("Closing NATSS Streaming subscription failed", zap.String("channel", channel.String()),
+			zap.String("subscription", string(subscription.UID)), zap.Error(err))
+	}
+	delete(s.subscriptions[channel], subscription.UID)
+	delete(s.subscribedOptions[channel], subscription.UID)
+	s.targets.Delete(subscription.UID)
+	s.cursors.close(channel, subscription.UID, false)
+	s.paused[subscription.UID] = &pausedSubscription{ctx: ctx, channel: channel, subscription: subscription, since: time.Now(), lastResponse: lastResponse}
+	s.recordActiveSubscriptions()
+	s.subscriptionsMux.Unlock()
+
+	s.subscriptionsLogger.Warn("Paused the subscription of an unhealthy subscriber", zap.String("channel", channel.String()),
+		zap.String("subscription", string(subscription.UID)), zap.Time("failingSince", failingSince))
+	s.recordPauseTransition(pauseTransitionPaused)
+	s.notifyPause(channel)
+}
+
+// resume makes the paused subscription again.
+func (s *SubscriptionsSupervisor) resume(uid types.UID, transition string) bool {
+	s.subscriptionsMux.Lock()
+	p, ok := s.paused[uid]
+	if !ok {
+		s.subscriptionsMux.Unlock()
+		return false
+	}
+	// The subscriptions of a hibernated channel are made again when it wakes up, and the ones of
+	// a channel the replica does not lead when it is promoted.
+	if _, hibernated := s.hibernatedChannel(p.channel); !hibernated && s.leadsChannel(p.channel) {
+		// The ephemeral subscriptions are never paused.
+		sub, err := s.subscribe(p.ctx, p.channel, p.subscription, false)
+		if err != nil {
+			s.subscriptionsMux.Unlock()
+			s.subscriptionsLogger.Error("Failed to resume the paused subscription", zap.String("channel", p.channel.String()),
+				zap.String("subscription", string(uid)), zap.Error(err))
+			return false
+		}
+		chMap, ok := s.subscriptions[p.channel]
+		if !ok {
+			chMap = make(map[types.UID]*stan.Subscription)
+			s.subscriptions[p.channel] = chMap
+		}
+		chMap[uid] = sub
+		if s.subscribedOptions[p.channel] == nil {
+			s.subscribedOptions[p.channel] = make(map[types.UID]planner.Options)
+		}
+		s.subscribedOptions[p.channel][uid] = s.optionsOfSubscription(p.channel, uid)
+		s.recordActiveSubscriptions()
+	}
+	delete(s.paused, uid)
+	s.health.Delete(uid)
+	s.subscriptionsMux.Unlock()
+
+	s.subscriptionsLogger.Info("Resumed the paused subscription", zap.String("channel", p.channel.String()),
+		zap.String("subscription", string(uid)), zap.String("transition", transition))
+	s.recordPauseTransition(transition)
+	s.notifyPause(p.channel)
+	return true
+}
+
+// keepPaused returns whether subscription of channel is paused, updating it to be made again from
+// ctx and its latest spec.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) keepPaused(ctx context.Context, channel eventingchannels.ChannelReference, subscription subscriptionReference) bool {
+	p, ok := s.paused[subscription.UID]
+	if !ok || p.channel != channel {
+		return false
+	}
+	p.ctx, p.subscription = ctx, subscription
+	return true
+}
+
+// forgetPaused forgets the paused subscriptions of channel which are not active, removing their
+// durable.
+// should be called only while holding subscriptionsMux
+func (s *SubscriptionsSupervisor) forgetPaused(channel eventingchannels.ChannelReference, active map[types.UID]bool) {
+	for uid, p := range s.paused {
+		if p.channel != channel || active[uid] {
+			continue
+		}
+		delete(s.paused, uid)
+		s.health.Delete(uid)
+		s.stopEndpointCheck(uid)
+		// The durable of a work queue is shared with the other members.
+		if s.distribution(channel) != v1beta1.DistributionWorkQueue {
+			// The consumers of the subscription share the durable of their queue group.
+			group := ""
+			if s.consumersOfSubscription(channel, uid) > 1 {
+				group = p.subscription.String()
+			}
+			if err := s.removeSubscriptionDurables(channel, p.subscription, group); err != nil {
+				s.subscriptionsLogger.Error("Failed to remove the durable of a paused subscription", zap.String("channel", channel.String()),
+					zap.String("subscription", string(uid)), zap.Error(err))
+			}
+		}
+	}
+}
+
+// runPauseProbes probes the subscribers of the paused subscriptions, resuming those which are
+// healthy again, until ctx is done. It does nothing when the pauses are disabled.
+func (s *SubscriptionsSupervisor) runPauseProbes(ctx context.Context) {
+	if s.unhealthyPauseAfter <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.unhealthyProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.probePaused(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *SubscriptionsSupervisor) probePaused(ctx context.Context) {
+	s.subscriptionsMux.Lock()
+	paused := make([]*pausedSubscription, 0, len(s.paused))
+	for _, p := range s.paused {
+		paused = append(paused, p)
+	}
+	s.subscriptionsMux.Unlock()
+
+	for _, p := range paused {
+		if err := s.probe(withOutboundChannel(ctx, p.channel), p.subscription); err != nil {
+			s.subscriptionsLogger.Debug("The subscriber of the paused subscription is still unhealthy", zap.String("channel", p.channel.String()),
+				zap.String("subscription", string(p.subscription.UID)), zap.Error(err))
+			continue
+		}
+		s.resume(p.subscription.UID, pauseTransitionResumedProbe)
+	}
+}
+
+// probe sends a HEAD request to the subscriber of subscription, which is healthy when it
+// answers without a server error.
+func (s *SubscriptionsSupervisor) probe(ctx context.Context, subscription subscriptionReference) error {
+	if subscription.SubscriberURI.IsEmpty() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.preferHTTPS(subscription.SubscriberURI.URL()).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.warmUpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &probeError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+type probeError struct {
+	code int
+}
+
+func (e *probeError) Error() string {
+	return "the subscriber answered " + http.StatusText(e.code)
+}
+
+func (s *SubscriptionsSupervisor) recordPauseTransition(transition string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(pauseTransitionKey, transition))
+	if err != nil {
+		s.logger.Warn("Failed to tag the pause transition", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, pauseTransitionsM.M(1))
+}
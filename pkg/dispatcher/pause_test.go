@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// newFlakySubscriber returns a subscriber answering 503 Service Unavailable while unhealthy is
+// set, and recording the events it accepts otherwise.
+func newFlakySubscriber(unhealthy *int32) *eventRecorder {
+	r := &eventRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(unhealthy) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.Method == http.MethodHead {
+			return
+		}
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.ids = append(r.ids, e.ID())
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return r
+}
+
+// newPausedSubscription subscribes subscriber to a channel and makes every delivery fail until
+// the subscription is paused.
+func newPausedSubscription(t *testing.T, subscriber *eventRecorder) (*SubscriptionsSupervisor, *fakeStanConn, eventingchannels.ChannelReference, *int32) {
+	s, conn := newTestSupervisor(t)
+	s.unhealthyPauseAfter = time.Millisecond
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchPauses(ref, func() { atomic.AddInt32(&notified, 1) })
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	publishTestEvent(t, s, ref, "first")
+	if _, paused := s.PausedSince("uid-0"); paused {
+		t.Fatal("the subscription paused at the first failure, want it to wait for the threshold")
+	}
+	time.Sleep(2 * time.Millisecond)
+	publishTestEvent(t, s, ref, "second")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, paused := s.PausedSince("uid-0"); paused {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, paused := s.PausedSince("uid-0"); !paused {
+		t.Fatal("the subscription was not paused once failing for longer than the threshold")
+	}
+	if len(conn.subs) != 0 || len(conn.closed) != 1 {
+		t.Fatalf("%d open subscriptions and %d closed durables, want the durable to be closed", len(conn.subs), len(conn.closed))
+	}
+	if got := atomic.LoadInt32(&notified); got != 1 {
+		t.Errorf("notified %d times, want 1", got)
+	}
+	return s, conn, ref, &notified
+}
+
+func TestPauseResumedByProbe(t *testing.T) {
+	unhealthy := int32(1)
+	subscriber := newFlakySubscriber(&unhealthy)
+	defer subscriber.Close()
+	s, conn, ref, notified := newPausedSubscription(t, subscriber)
+
+	// The updates of the channel leave the subscription paused.
+	if failed, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	publishTestEvent(t, s, ref, "paused")
+	s.probePaused(context.Background())
+	if _, paused := s.PausedSince("uid-0"); !paused || len(conn.subs) != 0 {
+		t.Fatal("the subscription resumed while the subscriber is unhealthy")
+	}
+
+	atomic.StoreInt32(&unhealthy, 0)
+	s.probePaused(context.Background())
+	if _, paused := s.PausedSince("uid-0"); paused || len(conn.subs) != 1 {
+		t.Fatal("the subscription did not resume once the subscriber is healthy")
+	}
+	if got := atomic.LoadInt32(notified); got != 2 {
+		t.Errorf("notified %d times, want 2", got)
+	}
+	// The resumed durable delivers the event published during the pause.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(subscriber.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := subscriber.received(); len(got) != 1 || got[0] != "paused" {
+		t.Errorf("received %v, want the event published during the pause", got)
+	}
+}
+
+func TestPauseResumedByOperator(t *testing.T) {
+	unhealthy := int32(1)
+	subscriber := newFlakySubscriber(&unhealthy)
+	defer subscriber.Close()
+	s, conn, _, _ := newPausedSubscription(t, subscriber)
+
+	if !s.ResumeSubscription("uid-0") {
+		t.Fatal("ResumeSubscription() = false, want the subscription to resume")
+	}
+	if _, paused := s.PausedSince("uid-0"); paused || len(conn.subs) != 1 {
+		t.Error("the subscription is still paused")
+	}
+	if s.ResumeSubscription("uid-0") {
+		t.Error("ResumeSubscription() = true for a subscription which is not paused")
+	}
+}
+
+func TestPauseRemovedSubscriber(t *testing.T) {
+	unhealthy := int32(1)
+	subscriber := newFlakySubscriber(&unhealthy)
+	defer subscriber.Close()
+	s, conn, ref, _ := newPausedSubscription(t, subscriber)
+
+	// The durable of the subscriber removed during the pause is deleted.
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if _, paused := s.PausedSince("uid-0"); paused {
+		t.Error("the removed subscription is still paused")
+	}
+	if len(conn.subs) != 0 || len(conn.closed) != 0 {
+		t.Errorf("%d subscriptions and %d closed durables left, want none", len(conn.subs), len(conn.closed))
+	}
+}
+
+func TestPauseDisabled(t *testing.T) {
+	unhealthy := int32(1)
+	subscriber := newFlakySubscriber(&unhealthy)
+	defer subscriber.Close()
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(ref, subscriber), false); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	publishTestEvent(t, s, ref, "first")
+	time.Sleep(2 * time.Millisecond)
+	publishTestEvent(t, s, ref, "second")
+	if _, paused := s.PausedSince("uid-0"); paused || len(conn.subs) != 1 {
+		t.Error("the subscription paused while the pauses are disabled")
+	}
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package planner computes what the dispatcher does to the subscriptions of a channel when its
+// spec changes, for the dispatcher to apply and for the operators to review beforehand.
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// Action is what a Step does to the subscription of a subscriber.
+type Action string
+
+const (
+	// Subscribe makes the subscription of a subscriber, creating its durable.
+	Subscribe Action = "subscribe"
+	// Keep leaves the subscription of a subscriber as it is.
+	Keep Action = "keep"
+	// Unsubscribe removes the subscription of a subscriber and its durable, the events it did
+	// not acknowledge being lost. The durable of a work queue is removed with its last member.
+	Unsubscribe Action = "unsubscribe"
+	// Resubscribe closes the subscription of a subscriber, keeping its durable, and makes it again.
+	Resubscribe Action = "resubscribe"
+)
+
+// The reasons of the steps.
+const (
+	ReasonAdded      = "added to the channel"
+	ReasonRemoved    = "removed from the channel"
+	ReasonFinalized  = "channel deleted"
+	ReasonNotApplied = "subscriber changed, the running subscription keeps its previous spec"
+	ReasonRetargeted = "subscriber, reply or dead letter sink URI changed, the running subscription delivers to the new one"
+	ReasonEphemeral  = "made ephemeral, its durable is removed"
+	ReasonDurable    = "made durable"
+)
+
+// Step is what a Plan does to the subscription of a subscriber.
+type Step struct {
+	Action Action    `json:"action"`
+	UID    types.UID `json:"uid"`
+	// Subscriber is the spec of the subscriber, set unless the step unsubscribes.
+	Subscriber eventingduckv1.SubscriberSpec `json:"-"`
+	// Options are the options the step subscribes with, set when it subscribes.
+	Options Options `json:"-"`
+	Reason  string  `json:"reason,omitempty"`
+	// NotApplied are the changes of the options the subscription does not apply.
+	NotApplied string `json:"notApplied,omitempty"`
+}
+
+func (s Step) String() string {
+	str := fmt.Sprintf("%s %s", s.Action, s.UID)
+	if s.Reason != "" {
+		str = fmt.Sprintf("%s: %s", str, s.Reason)
+	}
+	if s.NotApplied != "" {
+		str = fmt.Sprintf("%s (%s)", str, s.NotApplied)
+	}
+	return str
+}
+
+// changing tells whether the step changes something.
+func (s Step) changing() bool {
+	return s.Action != Keep || s.Reason != "" || s.NotApplied != ""
+}
+
+// Plan is the steps of a change to the subscriptions of a channel, in the order they are taken.
+type Plan []Step
+
+// NoOp returns whether the plan keeps every subscription as it is.
+func (p Plan) NoOp() bool {
+	for _, step := range p {
+		if step.changing() {
+			return false
+		}
+	}
+	return true
+}
+
+// Warnings returns the steps of the plan changing something, one line each.
+func (p Plan) Warnings() []string {
+	var warnings []string
+	for _, step := range p {
+		if step.changing() {
+			warnings = append(warnings, step.String())
+		}
+	}
+	return warnings
+}
+
+// Options are the options of NATSS the subscriptions of a channel are made with.
+type Options struct {
+	// AckWait is how long NATSS waits for the ack of an event before redelivering it, before the
+	// backoff of the retries of the subscription extends it.
+	AckWait time.Duration
+	// MaxInflight is how many events of a subscription NATSS sends before they are acknowledged,
+	// zero using the default of NATSS.
+	MaxInflight int
+	// Ordered makes the subscriptions retry each failed delivery until it succeeds before
+	// acknowledging it, one event in flight at a time.
+	Ordered bool
+	// StartAt is where the subscriptions without a durable to resume from start, empty being
+	// v1beta1.StartPositionNewOnly.
+	StartAt v1beta1.StartPosition
+}
+
+// changes returns how the options changed from o to to, empty when they did not.
+func (o Options) changes(to Options) string {
+	var changes []string
+	if o.AckWait != to.AckWait {
+		changes = append(changes, fmt.Sprintf("ack wait changed from %v to %v", o.AckWait, to.AckWait))
+	}
+	if o.MaxInflight != to.MaxInflight {
+		changes = append(changes, fmt.Sprintf("max inflight changed from %d to %d", o.MaxInflight, to.MaxInflight))
+	}
+	if o.Ordered != to.Ordered {
+		if to.Ordered {
+			changes = append(changes, "delivered in order")
+		} else {
+			changes = append(changes, "no longer delivered in order")
+		}
+	}
+	if from, to := o.StartAt.OrDefault(), to.StartAt.OrDefault(); from != to {
+		changes = append(changes, fmt.Sprintf("start position changed from %s to %s", from, to))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// OptionChange is how the subscription of a subscriber follows a change of its options.
+type OptionChange struct {
+	// Action is Keep when the subscription is left as it is, Resubscribe when it is made again
+	// from its durable, and Subscribe when its durable is unsubscribed first to be made again.
+	Action Action
+	// Options are the options the subscription is left with.
+	Options Options
+	// Applied are the changes applied, empty when none is.
+	Applied string
+	// NotApplied are the changes not applied, empty when all are.
+	NotApplied string
+}
+
+// DecideOptionChange returns how the subscription of a subscriber made with the options from
+// follows their change to to, under policy. The subscription is made again from its durable for
+// NATSS to apply the changes of the ack wait and max inflight, and the dispatcher the one of the
+// order, but NATSS ignores the start position of an existing durable: it only applies to the
+// durables made from then on, and to the ephemeral subscriptions each time they are made. When it
+// changes for a durable, OptionChangeRecreate removes the durable and makes it again, the events
+// it did not acknowledge being lost and those from the new start position delivered again,
+// OptionChangeReject applies none of the changes, and OptionChangeIgnore, the default, applies the
+// others.
+func DecideOptionChange(policy v1beta1.OptionChangePolicy, from, to Options, durable bool) OptionChange {
+	applicable := to
+	applicable.StartAt = from.StartAt
+	applied := from.changes(applicable)
+	if !durable || from.StartAt.OrDefault() == to.StartAt.OrDefault() {
+		if applied == "" {
+			return OptionChange{Action: Keep, Options: to}
+		}
+		return OptionChange{Action: Resubscribe, Options: to, Applied: applied}
+	}
+
+	switch policy.OrDefault() {
+	case v1beta1.OptionChangeRecreate:
+		return OptionChange{Action: Subscribe, Options: to, Applied: from.changes(to) + ", its durable is made again"}
+	case v1beta1.OptionChangeReject:
+		return OptionChange{Action: Keep, Options: from, NotApplied: from.changes(to) + ", rejected by the option change policy"}
+	default:
+		ignored := fmt.Sprintf("start position changed from %s to %s, not applied to the existing durable",
+			from.StartAt.OrDefault(), to.StartAt.OrDefault())
+		if applied == "" {
+			return OptionChange{Action: Keep, Options: applicable, NotApplied: ignored}
+		}
+		return OptionChange{Action: Resubscribe, Options: applicable, Applied: applied, NotApplied: ignored}
+	}
+}
+
+// Current is the state of the subscriptions of a channel.
+type Current struct {
+	// Subscribers are the specs of the subscribers subscribed, by UID, nil when unknown.
+	Subscribers map[types.UID]*eventingduckv1.SubscriberSpec
+	// Distribution is the distribution the subscriptions were made with, empty when unknown.
+	Distribution v1beta1.Distribution
+	// Ephemeral are the subscriptions made without a durable.
+	Ephemeral map[types.UID]bool
+	// Consumers are the numbers of consumers of the subscriptions having more than one.
+	Consumers map[types.UID]int
+	// Options are the options the subscriptions were made with, by UID, unknown when missing.
+	Options map[types.UID]Options
+}
+
+// Desired is the spec the subscriptions of a channel are changed to.
+type Desired struct {
+	Subscribers  []eventingduckv1.SubscriberSpec
+	Distribution v1beta1.Distribution
+	// Ephemeral are the subscriptions to make without a durable.
+	Ephemeral map[types.UID]bool
+	// Consumers are the numbers of consumers of the subscriptions to make with more than one.
+	Consumers map[types.UID]int
+	// Options are the options to make the subscriptions with.
+	Options Options
+	// OptionChangePolicy is how the subscriptions follow the changes of options NATSS does not
+	// apply to their durables.
+	OptionChangePolicy v1beta1.OptionChangePolicy
+	// SubscriptionOptions are the options of the subscriptions to make with others than Options,
+	// by UID.
+	SubscriptionOptions map[types.UID]Options
+	// Finalizing is set when the channel is deleted.
+	Finalizing bool
+}
+
+// OptionsOf returns the options to make the subscription of uid with.
+func (d Desired) OptionsOf(uid types.UID) Options {
+	if options, ok := d.SubscriptionOptions[uid]; ok {
+		return options
+	}
+	return d.Options
+}
+
+// Compute returns the plan changing the subscriptions of current to desired. A change of
+// distribution makes every subscription again. The subscribers whose spec changed keep their
+// subscription, which is reported when the current spec is known. A subscriber switching between
+// durable and ephemeral is unsubscribed, which removes its durable, and subscribed again. So is a
+// subscriber switching between a single consumer and several ones, which share a durable of their
+// own, while a subscriber changing how many consumers it has among several is subscribed again,
+// as is a subscription whose known options changed, as DecideOptionChange tells.
+func Compute(current Current, desired Desired) Plan {
+	var plan Plan
+	subscribed := make(map[types.UID]*eventingduckv1.SubscriberSpec, len(current.Subscribers))
+	for uid, spec := range current.Subscribers {
+		subscribed[uid] = spec
+	}
+
+	if desired.Finalizing {
+		return append(plan, unsubscribeAll(subscribed, ReasonFinalized)...)
+	}
+	// resubscribed is the reason of the subscriptions made again.
+	resubscribed := ""
+	distribution := desired.Distribution.OrDefault()
+	if current.Distribution != "" && current.Distribution.OrDefault() != distribution {
+		resubscribed = fmt.Sprintf("distribution changed from %s to %s", current.Distribution.OrDefault(), distribution)
+		plan = append(plan, unsubscribeAll(subscribed, resubscribed)...)
+		subscribed = make(map[types.UID]*eventingduckv1.SubscriberSpec)
+	}
+
+	wanted := make(map[types.UID]bool, len(desired.Subscribers))
+	switched := make(map[types.UID]bool)
+	for i := range desired.Subscribers {
+		sub := desired.Subscribers[i]
+		wanted[sub.UID] = true
+		options := desired.OptionsOf(sub.UID)
+		spec, ok := subscribed[sub.UID]
+		if !ok {
+			reason := ReasonAdded
+			if _, ok := current.Subscribers[sub.UID]; ok && resubscribed != "" {
+				reason = resubscribed
+			}
+			plan = append(plan, Step{Action: Subscribe, UID: sub.UID, Subscriber: sub, Options: options, Reason: reason})
+			// A subscriber listed twice is subscribed once.
+			subscribed[sub.UID] = &sub
+			continue
+		}
+		if ephemeral := desired.Ephemeral[sub.UID]; ephemeral != current.Ephemeral[sub.UID] && !switched[sub.UID] {
+			reason := ReasonDurable
+			if ephemeral {
+				reason = ReasonEphemeral
+			}
+			plan = append(plan,
+				Step{Action: Unsubscribe, UID: sub.UID, Reason: reason},
+				Step{Action: Subscribe, UID: sub.UID, Subscriber: sub, Options: options, Reason: reason})
+			switched[sub.UID] = true
+			continue
+		}
+		if from, to := consumersOf(current.Consumers, sub.UID), consumersOf(desired.Consumers, sub.UID); from != to && !switched[sub.UID] {
+			reason := fmt.Sprintf("consumers changed from %d to %d", from, to)
+			if (from > 1) != (to > 1) {
+				reason += ", its durable is made again"
+				plan = append(plan,
+					Step{Action: Unsubscribe, UID: sub.UID, Reason: reason},
+					Step{Action: Subscribe, UID: sub.UID, Subscriber: sub, Options: options, Reason: reason})
+			} else {
+				plan = append(plan, Step{Action: Resubscribe, UID: sub.UID, Subscriber: sub, Options: options, Reason: reason})
+			}
+			switched[sub.UID] = true
+			continue
+		}
+		step := Step{Action: Keep, UID: sub.UID, Subscriber: sub}
+		if from, ok := current.Options[sub.UID]; ok && !switched[sub.UID] {
+			change := DecideOptionChange(desired.OptionChangePolicy, from, options, !desired.Ephemeral[sub.UID])
+			switch change.Action {
+			case Subscribe:
+				plan = append(plan,
+					Step{Action: Unsubscribe, UID: sub.UID, Reason: change.Applied},
+					Step{Action: Subscribe, UID: sub.UID, Subscriber: sub, Options: change.Options, Reason: change.Applied})
+				switched[sub.UID] = true
+				continue
+			case Resubscribe:
+				plan = append(plan, Step{Action: Resubscribe, UID: sub.UID, Subscriber: sub, Options: change.Options,
+					Reason: change.Applied, NotApplied: change.NotApplied})
+				switched[sub.UID] = true
+				continue
+			}
+			step.NotApplied = change.NotApplied
+		}
+		if spec != nil && !equality.Semantic.DeepEqual(*spec, sub) {
+			step.Reason = ReasonNotApplied
+			if retargeted(*spec, sub) {
+				step.Reason = ReasonRetargeted
+			}
+		}
+		plan = append(plan, step)
+	}
+
+	removed := make(map[types.UID]*eventingduckv1.SubscriberSpec)
+	for uid, spec := range subscribed {
+		if !wanted[uid] {
+			removed[uid] = spec
+		}
+	}
+	return append(plan, unsubscribeAll(removed, ReasonRemoved)...)
+}
+
+// ForUpdate returns the plan of the dispatcher when the channel old is updated to new, the
+// subscribers of old being subscribed with durables. Of the options, only the start position set
+// by the annotations of the channels is compared, the others depending on the dispatcher.
+func ForUpdate(old, new *v1beta1.NatssChannel) Plan {
+	current := Current{
+		Subscribers:  make(map[types.UID]*eventingduckv1.SubscriberSpec, len(old.Spec.Subscribers)),
+		Distribution: old.Spec.Distribution.OrDefault(),
+		Options:      make(map[types.UID]Options, len(old.Spec.Subscribers)),
+	}
+	from, _ := v1beta1.DeliveryOptionsFromAnnotations(old.Annotations)
+	for i := range old.Spec.Subscribers {
+		current.Subscribers[old.Spec.Subscribers[i].UID] = &old.Spec.Subscribers[i]
+		current.Options[old.Spec.Subscribers[i].UID] = Options{StartAt: from.StartAt}
+	}
+	to, _ := v1beta1.DeliveryOptionsFromAnnotations(new.Annotations)
+	return Compute(current, Desired{
+		Subscribers:        new.Spec.Subscribers,
+		Distribution:       new.Spec.Distribution,
+		Options:            Options{StartAt: to.StartAt},
+		OptionChangePolicy: new.Spec.OptionChangePolicy,
+		Finalizing:         new.DeletionTimestamp != nil,
+	})
+}
+
+// consumersOf returns the number of consumers of the subscription of uid in consumers.
+func consumersOf(consumers map[types.UID]int, uid types.UID) int {
+	if n := consumers[uid]; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// retargeted tells whether only the URIs the events are delivered to differ between the specs
+// old and new, which the running subscription applies in place. The generation of a Subscription
+// changes with the reference its URIs are resolved from.
+func retargeted(old, new eventingduckv1.SubscriberSpec) bool {
+	if old.SubscriberURI.String() == new.SubscriberURI.String() && old.ReplyURI.String() == new.ReplyURI.String() &&
+		equality.Semantic.DeepEqual(deadLetterSinkOf(old), deadLetterSinkOf(new)) {
+		return false
+	}
+	old.Generation, old.SubscriberURI, old.ReplyURI, old.Delivery = 0, nil, nil, withoutDeadLetterSink(old.Delivery)
+	new.Generation, new.SubscriberURI, new.ReplyURI, new.Delivery = 0, nil, nil, withoutDeadLetterSink(new.Delivery)
+	return equality.Semantic.DeepEqual(old, new)
+}
+
+// deadLetterSinkOf returns the dead letter sink of the delivery of spec, nil when it has none.
+func deadLetterSinkOf(spec eventingduckv1.SubscriberSpec) *duckv1.Destination {
+	if spec.Delivery == nil {
+		return nil
+	}
+	return spec.Delivery.DeadLetterSink
+}
+
+// withoutDeadLetterSink returns a copy of delivery without its dead letter sink, nil when it sets
+// nothing else.
+func withoutDeadLetterSink(delivery *eventingduckv1.DeliverySpec) *eventingduckv1.DeliverySpec {
+	if delivery == nil {
+		return nil
+	}
+	copied := *delivery
+	copied.DeadLetterSink = nil
+	if equality.Semantic.DeepEqual(copied, eventingduckv1.DeliverySpec{}) {
+		return nil
+	}
+	return &copied
+}
+
+// unsubscribeAll returns the steps unsubscribing the subscribers of subscribed, by UID.
+func unsubscribeAll(subscribed map[types.UID]*eventingduckv1.SubscriberSpec, reason string) []Step {
+	uids := make([]types.UID, 0, len(subscribed))
+	for uid := range subscribed {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	steps := make([]Step, 0, len(uids))
+	for _, uid := range uids {
+		steps = append(steps, Step{Action: Unsubscribe, UID: uid, Reason: reason})
+	}
+	return steps
+}
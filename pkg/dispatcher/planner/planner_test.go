@@ -0,0 +1,419 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package planner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func subscriber(uid types.UID, generation int64) eventingduckv1.SubscriberSpec {
+	return eventingduckv1.SubscriberSpec{
+		UID:           uid,
+		Generation:    generation,
+		SubscriberURI: apis.HTTP(string(uid) + ".default.svc.cluster.local"),
+	}
+}
+
+// withSubscriberURI returns sub delivering to path, with its query, of its subscriber.
+func withSubscriberURI(sub eventingduckv1.SubscriberSpec, path string) eventingduckv1.SubscriberSpec {
+	uri, err := apis.ParseURL(sub.SubscriberURI.String() + path)
+	if err != nil {
+		panic(err)
+	}
+	sub.SubscriberURI = uri
+	return sub
+}
+
+// withDeadLetterSink returns sub sending the events it fails to receive to host.
+func withDeadLetterSink(sub eventingduckv1.SubscriberSpec, host string) eventingduckv1.SubscriberSpec {
+	sub.Delivery = &eventingduckv1.DeliverySpec{DeadLetterSink: &duckv1.Destination{URI: apis.HTTP(host)}}
+	return sub
+}
+
+func channel(distribution v1beta1.Distribution, subscribers ...eventingduckv1.SubscriberSpec) *v1beta1.NatssChannel {
+	c := &v1beta1.NatssChannel{}
+	c.Spec.Distribution = distribution
+	c.Spec.Subscribers = subscribers
+	return c
+}
+
+func TestForUpdate(t *testing.T) {
+	distributionChanged := "distribution changed from fanout to workqueue"
+	testCases := map[string]struct {
+		old, new *v1beta1.NatssChannel
+		want     []string
+	}{
+		"unchanged": {
+			old: channel("", subscriber("a", 1)),
+			new: channel(v1beta1.DistributionFanout, subscriber("a", 1)),
+		},
+		"added and removed": {
+			old:  channel("", subscriber("a", 1), subscriber("b", 1)),
+			new:  channel("", subscriber("b", 1), subscriber("c", 1)),
+			want: []string{"subscribe c: " + ReasonAdded, "unsubscribe a: " + ReasonRemoved},
+		},
+		"subscriber changed": {
+			old:  channel("", subscriber("a", 1)),
+			new:  channel("", subscriber("a", 2)),
+			want: []string{"keep a: " + ReasonNotApplied},
+		},
+		"subscriber path changed": {
+			old:  channel("", subscriber("a", 1)),
+			new:  channel("", withSubscriberURI(subscriber("a", 2), "/v1/events")),
+			want: []string{"keep a: " + ReasonRetargeted},
+		},
+		"subscriber query changed": {
+			old:  channel("", withSubscriberURI(subscriber("a", 1), "/v1/events?tenant=a")),
+			new:  channel("", withSubscriberURI(subscriber("a", 1), "/v1/events?tenant=b")),
+			want: []string{"keep a: " + ReasonRetargeted},
+		},
+		"subscriber URI and delivery changed": {
+			old: channel("", subscriber("a", 1)),
+			new: func() *v1beta1.NatssChannel {
+				sub := withSubscriberURI(subscriber("a", 2), "/v1/events")
+				sub.Delivery = &eventingduckv1.DeliverySpec{Retry: ptr.Int32(3)}
+				return channel("", sub)
+			}(),
+			want: []string{"keep a: " + ReasonNotApplied},
+		},
+		"dead letter sink added": {
+			old:  channel("", subscriber("a", 1)),
+			new:  channel("", withDeadLetterSink(subscriber("a", 2), "dls-a")),
+			want: []string{"keep a: " + ReasonRetargeted},
+		},
+		"dead letter sink changed": {
+			old:  channel("", withDeadLetterSink(subscriber("a", 1), "dls-a")),
+			new:  channel("", withDeadLetterSink(subscriber("a", 2), "dls-b")),
+			want: []string{"keep a: " + ReasonRetargeted},
+		},
+		"dead letter sink and retry changed": {
+			old: channel("", withDeadLetterSink(subscriber("a", 1), "dls-a")),
+			new: func() *v1beta1.NatssChannel {
+				sub := withDeadLetterSink(subscriber("a", 2), "dls-b")
+				sub.Delivery.Retry = ptr.Int32(3)
+				return channel("", sub)
+			}(),
+			want: []string{"keep a: " + ReasonNotApplied},
+		},
+		"distribution changed": {
+			old: channel("", subscriber("a", 1), subscriber("b", 1)),
+			new: channel(v1beta1.DistributionWorkQueue, subscriber("b", 1), subscriber("c", 1)),
+			want: []string{
+				"unsubscribe a: " + distributionChanged,
+				"unsubscribe b: " + distributionChanged,
+				"subscribe b: " + distributionChanged,
+				"subscribe c: " + ReasonAdded,
+			},
+		},
+		"all removed": {
+			old:  channel("", subscriber("a", 1)),
+			new:  channel(""),
+			want: []string{"unsubscribe a: " + ReasonRemoved},
+		},
+		"deleted": {
+			old: channel("", subscriber("a", 1), subscriber("b", 1)),
+			new: func() *v1beta1.NatssChannel {
+				c := channel("", subscriber("a", 1), subscriber("b", 1))
+				c.DeletionTimestamp = &metav1.Time{}
+				return c
+			}(),
+			want: []string{"unsubscribe a: " + ReasonFinalized, "unsubscribe b: " + ReasonFinalized},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			plan := ForUpdate(tc.old, tc.new)
+			if diff := cmp.Diff(tc.want, plan.Warnings(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Warnings() (-want, +got) = %s", diff)
+			}
+			if plan.NoOp() != (len(tc.want) == 0) {
+				t.Errorf("NoOp() = %t with the warnings %v", plan.NoOp(), tc.want)
+			}
+		})
+	}
+}
+
+func TestComputeUnknownSpecs(t *testing.T) {
+	// The dispatcher does not keep the specs of its subscriptions.
+	current := Current{Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil}}
+	plan := Compute(current, Desired{Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 2), subscriber("c", 1), subscriber("c", 1)}})
+
+	want := Plan{
+		{Action: Keep, UID: "a", Subscriber: subscriber("a", 2)},
+		{Action: Subscribe, UID: "c", Subscriber: subscriber("c", 1), Reason: ReasonAdded},
+		// Listed twice.
+		{Action: Keep, UID: "c", Subscriber: subscriber("c", 1)},
+		{Action: Unsubscribe, UID: "b", Reason: ReasonRemoved},
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("Compute() (-want, +got) = %s", diff)
+	}
+
+	// The distribution of the subscriptions not made yet is unknown.
+	plan = Compute(Current{}, Desired{Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1)}, Distribution: v1beta1.DistributionWorkQueue})
+	if diff := cmp.Diff([]string{"subscribe a: " + ReasonAdded}, plan.Warnings()); diff != "" {
+		t.Errorf("Warnings() (-want, +got) = %s", diff)
+	}
+}
+
+func TestComputeEphemeral(t *testing.T) {
+	current := Current{
+		Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil, "c": nil},
+		Ephemeral:   map[types.UID]bool{"b": true, "c": true},
+	}
+	plan := Compute(current, Desired{
+		Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1), subscriber("b", 1), subscriber("c", 1), subscriber("d", 1)},
+		Ephemeral:   map[types.UID]bool{"a": true, "c": true, "d": true},
+	})
+
+	want := Plan{
+		{Action: Unsubscribe, UID: "a", Reason: ReasonEphemeral},
+		{Action: Subscribe, UID: "a", Subscriber: subscriber("a", 1), Reason: ReasonEphemeral},
+		{Action: Unsubscribe, UID: "b", Reason: ReasonDurable},
+		{Action: Subscribe, UID: "b", Subscriber: subscriber("b", 1), Reason: ReasonDurable},
+		{Action: Keep, UID: "c", Subscriber: subscriber("c", 1)},
+		{Action: Subscribe, UID: "d", Subscriber: subscriber("d", 1), Reason: ReasonAdded},
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("Compute() (-want, +got) = %s", diff)
+	}
+}
+
+func TestComputeConsumers(t *testing.T) {
+	current := Current{
+		Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil, "c": nil, "d": nil},
+		Consumers:   map[types.UID]int{"b": 2, "c": 2, "d": 4},
+	}
+	plan := Compute(current, Desired{
+		Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1), subscriber("b", 1), subscriber("c", 1), subscriber("d", 1)},
+		Consumers:   map[types.UID]int{"a": 3, "c": 3, "d": 4},
+	})
+
+	want := Plan{
+		{Action: Unsubscribe, UID: "a", Reason: "consumers changed from 1 to 3, its durable is made again"},
+		{Action: Subscribe, UID: "a", Subscriber: subscriber("a", 1), Reason: "consumers changed from 1 to 3, its durable is made again"},
+		{Action: Unsubscribe, UID: "b", Reason: "consumers changed from 2 to 1, its durable is made again"},
+		{Action: Subscribe, UID: "b", Subscriber: subscriber("b", 1), Reason: "consumers changed from 2 to 1, its durable is made again"},
+		{Action: Resubscribe, UID: "c", Subscriber: subscriber("c", 1), Reason: "consumers changed from 2 to 3"},
+		{Action: Keep, UID: "d", Subscriber: subscriber("d", 1)},
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("Compute() (-want, +got) = %s", diff)
+	}
+}
+
+func TestComputeOptions(t *testing.T) {
+	options := Options{AckWait: time.Minute, MaxInflight: 16}
+	current := Current{
+		Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil, "c": nil, "d": nil},
+		Options: map[types.UID]Options{
+			"a": options,
+			"b": {AckWait: 5 * time.Minute, MaxInflight: 16},
+			"c": {AckWait: time.Minute},
+		},
+		Ephemeral: map[types.UID]bool{"c": true},
+	}
+	plan := Compute(current, Desired{
+		Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1), subscriber("b", 1), subscriber("c", 1), subscriber("d", 1)},
+		Options:     options,
+	})
+
+	// The options of d are unknown, and c is made durable anyway.
+	want := Plan{
+		{Action: Keep, UID: "a", Subscriber: subscriber("a", 1)},
+		{Action: Resubscribe, UID: "b", Subscriber: subscriber("b", 1), Options: options, Reason: "ack wait changed from 5m0s to 1m0s"},
+		{Action: Unsubscribe, UID: "c", Reason: ReasonDurable},
+		{Action: Subscribe, UID: "c", Subscriber: subscriber("c", 1), Options: options, Reason: ReasonDurable},
+		{Action: Keep, UID: "d", Subscriber: subscriber("d", 1)},
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("Compute() (-want, +got) = %s", diff)
+	}
+
+	current.Options["a"] = Options{AckWait: 2 * time.Minute}
+	plan = Compute(current, Desired{Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1)}, Options: options, Ephemeral: current.Ephemeral})
+	if diff := cmp.Diff([]string{
+		"resubscribe a: ack wait changed from 2m0s to 1m0s, max inflight changed from 0 to 16",
+		"unsubscribe b: " + ReasonRemoved,
+		"unsubscribe c: " + ReasonRemoved,
+		"unsubscribe d: " + ReasonRemoved,
+	}, plan.Warnings()); diff != "" {
+		t.Errorf("Warnings() (-want, +got) = %s", diff)
+	}
+}
+
+func TestComputeOrdered(t *testing.T) {
+	options := Options{AckWait: time.Minute, MaxInflight: 16}
+	ordered := Options{AckWait: time.Minute, MaxInflight: 1, Ordered: true}
+	current := Current{
+		Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil, "c": nil},
+		Options:     map[types.UID]Options{"a": options, "b": ordered, "c": ordered},
+	}
+	plan := Compute(current, Desired{
+		Subscribers:         []eventingduckv1.SubscriberSpec{subscriber("a", 1), subscriber("b", 1), subscriber("c", 1)},
+		Options:             options,
+		SubscriptionOptions: map[types.UID]Options{"a": ordered, "c": ordered},
+	})
+	if diff := cmp.Diff([]string{
+		"resubscribe a: max inflight changed from 16 to 1, delivered in order",
+		"resubscribe b: max inflight changed from 1 to 16, no longer delivered in order",
+	}, plan.Warnings()); diff != "" {
+		t.Errorf("Warnings() (-want, +got) = %s", diff)
+	}
+}
+
+func TestDecideOptionChange(t *testing.T) {
+	from := Options{AckWait: time.Minute, MaxInflight: 16}
+	ackWait := Options{AckWait: 5 * time.Minute, MaxInflight: 16}
+	startAt := Options{AckWait: time.Minute, MaxInflight: 16, StartAt: v1beta1.StartPositionAllAvailable}
+	both := Options{AckWait: 5 * time.Minute, MaxInflight: 16, StartAt: v1beta1.StartPositionAllAvailable}
+	// The start position is kept when it is not applied.
+	bothKept := ackWait
+
+	const (
+		ackWaitChanged = "ack wait changed from 1m0s to 5m0s"
+		startChanged   = "start position changed from new-only to all-available"
+		recreated      = ", its durable is made again"
+		rejected       = ", rejected by the option change policy"
+		ignored        = startChanged + ", not applied to the existing durable"
+	)
+	policies := []v1beta1.OptionChangePolicy{v1beta1.OptionChangeIgnore, v1beta1.OptionChangeRecreate, v1beta1.OptionChangeReject}
+	testCases := []struct {
+		policy  v1beta1.OptionChangePolicy
+		to      Options
+		durable bool
+		want    OptionChange
+	}{
+		// Unchanged, under every policy.
+		{policy: v1beta1.OptionChangeIgnore, to: from, durable: true, want: OptionChange{Action: Keep, Options: from}},
+		{policy: v1beta1.OptionChangeIgnore, to: from, want: OptionChange{Action: Keep, Options: from}},
+		{policy: v1beta1.OptionChangeRecreate, to: from, durable: true, want: OptionChange{Action: Keep, Options: from}},
+		{policy: v1beta1.OptionChangeRecreate, to: from, want: OptionChange{Action: Keep, Options: from}},
+		{policy: v1beta1.OptionChangeReject, to: from, durable: true, want: OptionChange{Action: Keep, Options: from}},
+		{policy: v1beta1.OptionChangeReject, to: from, want: OptionChange{Action: Keep, Options: from}},
+
+		// The changes NATSS applies make the subscriptions again from their durables.
+		{policy: v1beta1.OptionChangeIgnore, to: ackWait, durable: true, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeIgnore, to: ackWait, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeRecreate, to: ackWait, durable: true, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeRecreate, to: ackWait, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeReject, to: ackWait, durable: true, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeReject, to: ackWait, want: OptionChange{Action: Resubscribe, Options: ackWait, Applied: ackWaitChanged}},
+
+		// The start position of an ephemeral subscription applies the next time it is made.
+		{policy: v1beta1.OptionChangeIgnore, to: startAt, want: OptionChange{Action: Keep, Options: startAt}},
+		{policy: v1beta1.OptionChangeRecreate, to: startAt, want: OptionChange{Action: Keep, Options: startAt}},
+		{policy: v1beta1.OptionChangeReject, to: startAt, want: OptionChange{Action: Keep, Options: startAt}},
+		{policy: v1beta1.OptionChangeIgnore, to: both, want: OptionChange{Action: Resubscribe, Options: both, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeRecreate, to: both, want: OptionChange{Action: Resubscribe, Options: both, Applied: ackWaitChanged}},
+		{policy: v1beta1.OptionChangeReject, to: both, want: OptionChange{Action: Resubscribe, Options: both, Applied: ackWaitChanged}},
+
+		// The start position of a durable follows the policy.
+		{policy: v1beta1.OptionChangeIgnore, to: startAt, durable: true, want: OptionChange{Action: Keep, Options: from, NotApplied: ignored}},
+		{policy: v1beta1.OptionChangeIgnore, to: both, durable: true, want: OptionChange{Action: Resubscribe, Options: bothKept, Applied: ackWaitChanged, NotApplied: ignored}},
+		{policy: v1beta1.OptionChangeRecreate, to: startAt, durable: true, want: OptionChange{Action: Subscribe, Options: startAt, Applied: startChanged + recreated}},
+		{policy: v1beta1.OptionChangeRecreate, to: both, durable: true, want: OptionChange{Action: Subscribe, Options: both, Applied: ackWaitChanged + ", " + startChanged + recreated}},
+		{policy: v1beta1.OptionChangeReject, to: startAt, durable: true, want: OptionChange{Action: Keep, Options: from, NotApplied: startChanged + rejected}},
+		{policy: v1beta1.OptionChangeReject, to: both, durable: true, want: OptionChange{Action: Keep, Options: from, NotApplied: ackWaitChanged + ", " + startChanged + rejected}},
+	}
+
+	// Every policy, durability and kind of change is covered.
+	covered := make(map[string]bool)
+	for _, tc := range testCases {
+		covered[fmt.Sprintf("%s %t %+v", tc.policy, tc.durable, tc.to)] = true
+	}
+	for _, policy := range policies {
+		for _, to := range []Options{from, ackWait, startAt, both} {
+			for _, durable := range []bool{true, false} {
+				if key := fmt.Sprintf("%s %t %+v", policy, durable, to); !covered[key] {
+					t.Errorf("no test case for %s", key)
+				}
+			}
+		}
+	}
+
+	for _, tc := range testCases {
+		got := DecideOptionChange(tc.policy, from, tc.to, tc.durable)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("DecideOptionChange(%s, %+v, durable %t) (-want, +got) = %s", tc.policy, tc.to, tc.durable, diff)
+		}
+		// The policy defaults to ignore.
+		if tc.policy == v1beta1.OptionChangeIgnore {
+			if diff := cmp.Diff(got, DecideOptionChange("", from, tc.to, tc.durable)); diff != "" {
+				t.Errorf("DecideOptionChange() with no policy (-ignore, +got) = %s", diff)
+			}
+		}
+	}
+}
+
+func TestComputeOptionChangePolicy(t *testing.T) {
+	from := Options{AckWait: time.Minute}
+	to := Options{AckWait: time.Minute, StartAt: v1beta1.StartPositionAllAvailable}
+	current := Current{
+		Subscribers: map[types.UID]*eventingduckv1.SubscriberSpec{"a": nil, "b": nil},
+		Options:     map[types.UID]Options{"a": from, "b": from},
+		Ephemeral:   map[types.UID]bool{"b": true},
+	}
+	desired := Desired{
+		Subscribers: []eventingduckv1.SubscriberSpec{subscriber("a", 1), subscriber("b", 1)},
+		Ephemeral:   current.Ephemeral,
+		Options:     to,
+	}
+	testCases := map[v1beta1.OptionChangePolicy][]string{
+		"": {"keep a (start position changed from new-only to all-available, not applied to the existing durable)"},
+		v1beta1.OptionChangeRecreate: {
+			"unsubscribe a: start position changed from new-only to all-available, its durable is made again",
+			"subscribe a: start position changed from new-only to all-available, its durable is made again",
+		},
+		v1beta1.OptionChangeReject: {"keep a (start position changed from new-only to all-available, rejected by the option change policy)"},
+	}
+	for policy, want := range testCases {
+		desired.OptionChangePolicy = policy
+		if diff := cmp.Diff(want, Compute(current, desired).Warnings()); diff != "" {
+			t.Errorf("Warnings() with the policy %q (-want, +got) = %s", policy, diff)
+		}
+	}
+}
+
+func TestForUpdateStartAt(t *testing.T) {
+	old := channel("", subscriber("a", 1))
+	new := channel("", subscriber("a", 1))
+	new.Annotations = map[string]string{messaging.StartAtAnnotationKey: string(v1beta1.StartPositionAllAvailable)}
+	new.Spec.OptionChangePolicy = v1beta1.OptionChangeRecreate
+
+	want := []string{
+		"unsubscribe a: start position changed from new-only to all-available, its durable is made again",
+		"subscribe a: start position changed from new-only to all-available, its durable is made again",
+	}
+	if diff := cmp.Diff(want, ForUpdate(old, new).Warnings()); diff != "" {
+		t.Errorf("Warnings() (-want, +got) = %s", diff)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+var (
+	// unprovisionedRetryInterval is how long the publications and subscriptions of a channel NATSS
+	// does not serve fail without reaching NATSS, before they are attempted again.
+	unprovisionedRetryInterval = 30 * time.Second
+
+	// provisioningTimeout bounds the requests to the channel provisioning URL.
+	provisioningTimeout = 10 * time.Second
+)
+
+// ChannelNotProvisionedError is the error of the publications and subscriptions of a channel
+// whose NATSS channel is not provisioned on the server.
+type ChannelNotProvisionedError struct {
+	// Subject is the NATSS channel required.
+	Subject string
+	// Err is the error NATSS answered with.
+	Err error
+}
+
+func (e *ChannelNotProvisionedError) Error() string {
+	return fmt.Sprintf("NATSS channel %q is not provisioned on the server: %v", e.Subject, e.Err)
+}
+
+func (e *ChannelNotProvisionedError) Unwrap() error {
+	return e.Err
+}
+
+// ProvisioningReporter is implemented by the dispatchers reporting the channels whose NATSS
+// channel is not provisioned on the server.
+type ProvisioningReporter interface {
+	// WatchProvisioning sets the function called when the NATSS channel of channel is found
+	// missing from the server, when it is attempted again, and once it is served, nil removing it.
+	WatchProvisioning(channel eventingchannels.ChannelReference, notify func())
+	// NotProvisioned returns the NATSS channel of channel the server does not serve, and false
+	// when it was served or not attempted yet.
+	NotProvisioned(channel eventingchannels.ChannelReference) (string, bool)
+}
+
+var _ ProvisioningReporter = (*SubscriptionsSupervisor)(nil)
+
+// unprovisionedChannel is a channel whose NATSS channel the server refused.
+type unprovisionedChannel struct {
+	subject string
+	err     error
+	// retryAt is when the server is asked again.
+	retryAt time.Time
+}
+
+// WatchProvisioning implements ProvisioningReporter.
+func (s *SubscriptionsSupervisor) WatchProvisioning(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		s.provisioningNotifiers.Delete(channel)
+		return
+	}
+	s.provisioningNotifiers.Store(channel, notify)
+}
+
+// NotProvisioned implements ProvisioningReporter.
+func (s *SubscriptionsSupervisor) NotProvisioned(channel eventingchannels.ChannelReference) (string, bool) {
+	if value, ok := s.unprovisioned.Load(channel); ok {
+		return value.(*unprovisionedChannel).subject, true
+	}
+	return "", false
+}
+
+// notProvisioned returns the error of channel while its NATSS channel is known to be missing
+// from the server, so that the publications and subscriptions do not retry it in a hot loop.
+func (s *SubscriptionsSupervisor) notProvisioned(channel eventingchannels.ChannelReference) error {
+	value, ok := s.unprovisioned.Load(channel)
+	if !ok {
+		return nil
+	}
+	u := value.(*unprovisionedChannel)
+	if time.Now().After(u.retryAt) {
+		return nil
+	}
+	return &ChannelNotProvisionedError{Subject: u.subject, Err: u.err}
+}
+
+// recordProvisioning records the outcome err of a publication or subscription to the NATSS
+// channel subject of channel, and returns the error to report: a ChannelNotProvisionedError when
+// the server does not serve subject, which is attempted again after unprovisionedRetryInterval.
+func (s *SubscriptionsSupervisor) recordProvisioning(channel eventingchannels.ChannelReference, subject string, err error) error {
+	if err == nil {
+		if _, ok := s.unprovisioned.Load(channel); ok {
+			s.unprovisioned.Delete(channel)
+			s.subscriptionsLogger.Info("NATSS channel provisioned on the server", zap.String("channel", channel.String()), zap.String("subject", subject))
+			s.notifyProvisioning(channel)
+		}
+		return nil
+	}
+	if !stanutil.IsChannelNotProvisioned(err, s.partitioned) {
+		return err
+	}
+
+	_, known := s.unprovisioned.Load(channel)
+	s.unprovisioned.Store(channel, &unprovisionedChannel{subject: subject, err: err, retryAt: time.Now().Add(unprovisionedRetryInterval)})
+	if !known {
+		s.subscriptionsLogger.Warn("NATSS channel not provisioned on the server",
+			zap.String("channel", channel.String()), zap.String("subject", subject), zap.Error(err))
+		s.notifyProvisioning(channel)
+	}
+	if s.provisioningURL != "" {
+		go s.requestProvisioning(channel, subject)
+	}
+	// The subscriptions are made again once the server may serve the channel.
+	time.AfterFunc(unprovisionedRetryInterval, func() { s.notifyProvisioning(channel) })
+	return &ChannelNotProvisionedError{Subject: subject, Err: err}
+}
+
+func (s *SubscriptionsSupervisor) notifyProvisioning(channel eventingchannels.ChannelReference) {
+	if notify, ok := s.provisioningNotifiers.Load(channel); ok {
+		notify.(func())()
+	}
+}
+
+// provisioningRequest is the body of the requests POSTed to the channel provisioning URL.
+type provisioningRequest struct {
+	ClusterID string `json:"clusterID"`
+	Channel   string `json:"channel"`
+}
+
+// requestProvisioning asks the channel provisioning URL to create the NATSS channel subject.
+func (s *SubscriptionsSupervisor) requestProvisioning(channel eventingchannels.ChannelReference, subject string) {
+	body, err := json.Marshal(provisioningRequest{ClusterID: s.dispatcherKey().ClusterID, Channel: subject})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(withOutboundChannel(context.Background(), channel), provisioningTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.provisioningURL, bytes.NewReader(body))
+	if err != nil {
+		s.subscriptionsLogger.Error("Invalid channel provisioning request", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.provisioningClient.Do(req)
+	if err == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		s.subscriptionsLogger.Error("Failed to request the provisioning of the NATSS channel",
+			zap.String("channel", channel.String()), zap.String("subject", subject), zap.Error(err))
+		return
+	}
+	s.subscriptionsLogger.Info("Requested the provisioning of the NATSS channel", zap.String("channel", channel.String()), zap.String("subject", subject))
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// scriptedStanConn fails its requests with the errors of its script, in turn, before it behaves
+// like its fakeStanConn.
+type scriptedStanConn struct {
+	*fakeStanConn
+
+	mu     sync.Mutex
+	script []error
+	calls  int
+}
+
+func (c *scriptedStanConn) next() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.script) == 0 {
+		return nil
+	}
+	err := c.script[0]
+	c.script = c.script[1:]
+	return err
+}
+
+func (c *scriptedStanConn) Subscribe(subject string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return c.fakeStanConn.Subscribe(subject, cb, opts...)
+}
+
+func (c *scriptedStanConn) Publish(subject string, data []byte) error {
+	if err := c.next(); err != nil {
+		return err
+	}
+	return c.fakeStanConn.Publish(subject, data)
+}
+
+func (c *scriptedStanConn) requests() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func newScriptedSupervisor(t *testing.T, script ...error) (*SubscriptionsSupervisor, *scriptedStanConn) {
+	s, conn := newTestSupervisor(t)
+	scripted := &scriptedStanConn{fakeStanConn: conn, script: script}
+	var natssConn stan.Conn = scripted
+	s.natssConn = &natssConn
+	return s, scripted
+}
+
+func TestSubscribeChannelNotProvisioned(t *testing.T) {
+	defer func(interval time.Duration) { unprovisionedRetryInterval = interval }(unprovisionedRetryInterval)
+	unprovisionedRetryInterval = 100 * time.Millisecond
+
+	s, conn := newScriptedSupervisor(t, errors.New(`stan: channel not found: "channel.ns"`))
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	var notified int32
+	s.WatchProvisioning(ref, func() { atomic.AddInt32(&notified, 1) })
+	channel := newTestChannel(ref, newEventRecorder())
+
+	failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	var notProvisioned *ChannelNotProvisionedError
+	for _, err := range failed {
+		if !errors.As(err, &notProvisioned) || notProvisioned.Subject != "channel.ns" {
+			t.Errorf("subscription failed with %v, want the NATSS channel channel.ns not provisioned", err)
+		}
+	}
+	if len(failed) != 1 {
+		t.Fatalf("UpdateSubscriptions() failed %d subscriptions, want 1", len(failed))
+	}
+	if subject, ok := s.NotProvisioned(ref); !ok || subject != "channel.ns" {
+		t.Errorf("NotProvisioned() = %q, %t, want channel.ns", subject, ok)
+	}
+	if got := atomic.LoadInt32(&notified); got != 1 {
+		t.Errorf("notified %d times, want 1", got)
+	}
+
+	// NATSS is not asked again before the retry interval.
+	if failed, _ := s.UpdateSubscriptions(context.Background(), channel, false); len(failed) != 1 {
+		t.Errorf("UpdateSubscriptions() failed %d subscriptions, want 1", len(failed))
+	}
+	if got := conn.requests(); got != 1 {
+		t.Errorf("NATSS got %d subscription requests, want 1", got)
+	}
+
+	// The channel is reconciled again once the retry interval elapsed, when it is provisioned.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&notified) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	if _, ok := s.NotProvisioned(ref); ok {
+		t.Error("the channel is still reported not provisioned")
+	}
+	if got := atomic.LoadInt32(&notified); got != 3 {
+		t.Errorf("notified %d times, want 3", got)
+	}
+}
+
+func TestPublishChannelNotProvisioned(t *testing.T) {
+	var requested []provisioningRequest
+	var mu sync.Mutex
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req provisioningRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requested = append(requested, req)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer admin.Close()
+
+	s, conn := newScriptedSupervisor(t, stan.ErrTimeout, stan.ErrTimeout)
+	s.connKey.ClusterID = "knative-nats-streaming"
+	s.provisioningURL = admin.URL
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	e := event.New()
+	e.SetID("refused")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	publish := func() error {
+		return messageReceiverFunc(s)(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{})
+	}
+
+	// A timeout is not a refusal of the channel, unless NATSS is partitioned.
+	var notProvisioned *ChannelNotProvisionedError
+	if err := publish(); err == nil || errors.As(err, &notProvisioned) {
+		t.Fatalf("publish() = %v, want the timeout", err)
+	}
+	s.partitioned = true
+	if err := publish(); !errors.As(err, &notProvisioned) || notProvisioned.Subject != "channel.ns" {
+		t.Fatalf("publish() = %v, want the NATSS channel channel.ns not provisioned", err)
+	}
+	// The events are refused without reaching NATSS.
+	if err := publish(); !errors.As(err, &notProvisioned) {
+		t.Fatalf("publish() = %v, want the NATSS channel not provisioned", err)
+	}
+	if got := conn.requests(); got != 2 {
+		t.Errorf("NATSS got %d publications, want 2", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(requested)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := provisioningRequest{ClusterID: "knative-nats-streaming", Channel: "channel.ns"}
+	if len(requested) != 1 || requested[0] != want {
+		t.Errorf("provisioning requests = %+v, want %+v", requested, want)
+	}
+}
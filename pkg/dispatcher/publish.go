@@ -0,0 +1,109 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	natsscloudevents "github.com/cloudevents/sdk-go/protocol/stan/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+)
+
+// PublishMode is how the receiver publishes the events it accepts to NATSS.
+type PublishMode string
+
+const (
+	// PublishSync publishes each event with stan.Conn.Publish, the default.
+	PublishSync PublishMode = "sync"
+	// PublishAsync publishes each event with stan.Conn.PublishAsync, the publications of the
+	// concurrent requests sharing the connection without waiting for the acks of each other, up
+	// to the max publish acks in flight.
+	PublishAsync PublishMode = "async"
+)
+
+// PublishOptions configures the publications of the receiver to NATSS.
+type PublishOptions struct {
+	// Mode is how the events are published, PublishSync when empty.
+	Mode PublishMode
+	// MaxAcksInflight is how many publications wait for their ack on a connection before the
+	// next ones block, the default of NATSS when zero or less.
+	MaxAcksInflight int
+	// AckTimeout is how long a publication waits for its ack before failing, the default of
+	// NATSS when zero or less.
+	AckTimeout time.Duration
+}
+
+// stanOptions returns the options of the connections to NATSS publishing with o.
+func (o PublishOptions) stanOptions() []stan.Option {
+	var opts []stan.Option
+	if o.MaxAcksInflight > 0 {
+		opts = append(opts, stan.MaxPubAcksInflight(o.MaxAcksInflight))
+	}
+	if o.AckTimeout > 0 {
+		opts = append(opts, stan.PubAckWait(o.AckTimeout))
+	}
+	return opts
+}
+
+// encodeMsg serializes message like the natsscloudevents.Sender does with transformers, and
+// encrypts it with keys when not nil.
+func encodeMsg(ctx context.Context, message binding.Message, keys *Keyring, transformers ...binding.Transformer) ([]byte, error) {
+	var data bytes.Buffer
+	if err := natsscloudevents.WriteMsg(ctx, message, &data, transformers...); err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return data.Bytes(), nil
+	}
+	encrypted, err := keys.Encrypt(data.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt the message")
+	}
+	return encrypted, nil
+}
+
+// publishAsync publishes message on subject with conn.PublishAsync, encrypted with keys when not
+// nil, and waits for its ack. It fails with the error of the ack, stan.ErrTimeout when none
+// arrived within the ack timeout of conn, or with the error of ctx when it is done first.
+func publishAsync(ctx context.Context, conn stan.Conn, subject string, message binding.Message, keys *Keyring, transformers ...binding.Transformer) (err error) {
+	defer func() {
+		if ferr := message.Finish(err); ferr != nil && err == nil {
+			err = ferr
+		}
+	}()
+
+	data, err := encodeMsg(ctx, message, keys, transformers...)
+	if err != nil {
+		return err
+	}
+	acked := make(chan error, 1)
+	if _, err = conn.PublishAsync(subject, data, func(_ string, err error) {
+		acked <- err
+	}); err != nil {
+		return err
+	}
+	select {
+	case err = <-acked:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+// ackingStanConn acks the publications of its fakeStanConn after ackDelay, the round-trip to
+// NATSS, with ackErr. The publications acked with an error are not stored.
+type ackingStanConn struct {
+	*fakeStanConn
+
+	ackDelay time.Duration
+	ackErr   error
+}
+
+func (c *ackingStanConn) Publish(subject string, data []byte) error {
+	time.Sleep(c.ackDelay)
+	if c.ackErr != nil {
+		return c.ackErr
+	}
+	return c.fakeStanConn.Publish(subject, data)
+}
+
+func (c *ackingStanConn) PublishAsync(subject string, data []byte, ah stan.AckHandler) (string, error) {
+	go func() {
+		time.Sleep(c.ackDelay)
+		err := c.ackErr
+		if err == nil {
+			err = c.fakeStanConn.Publish(subject, data)
+		}
+		ah("guid", err)
+	}()
+	return "guid", nil
+}
+
+func newAckingSupervisor(t testing.TB, mode PublishMode, ackDelay time.Duration, ackErr error) (*SubscriptionsSupervisor, *ackingStanConn) {
+	s, conn := newTestSupervisor(t)
+	s.publishMode = mode
+	acking := &ackingStanConn{fakeStanConn: conn, ackDelay: ackDelay, ackErr: ackErr}
+	var natssConn stan.Conn = acking
+	s.natssConn = &natssConn
+	return s, acking
+}
+
+func TestPublishAck(t *testing.T) {
+	testCases := map[string]struct {
+		mode       PublishMode
+		ackErr     error
+		wantStatus int
+		wantStored int
+	}{
+		"sync acked": {
+			mode:       PublishSync,
+			wantStatus: http.StatusAccepted,
+			wantStored: 1,
+		},
+		"async acked": {
+			mode:       PublishAsync,
+			wantStatus: http.StatusAccepted,
+			wantStored: 1,
+		},
+		"async ack timeout": {
+			mode:       PublishAsync,
+			ackErr:     stan.ErrTimeout,
+			wantStatus: http.StatusInternalServerError,
+		},
+		"async negative ack": {
+			mode:       PublishAsync,
+			ackErr:     errors.New("stan: invalid publish request"),
+			wantStatus: http.StatusInternalServerError,
+		},
+		"sync ack timeout": {
+			mode:       PublishSync,
+			ackErr:     stan.ErrTimeout,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, conn := newAckingSupervisor(t, tc.mode, time.Millisecond, tc.ackErr)
+			ids := stored(conn.fakeStanConn)
+			const host = "channel.ns.svc.cluster.local"
+			s.setRoutes(map[string]eventingchannels.ChannelReference{host: {Namespace: "ns", Name: "channel"}})
+			receiver := kncloudevents.CreateHandler(s.receiver)
+
+			req := httptest.NewRequest(http.MethodPost, "http://"+host+"/", nil)
+			req.Header = http.Header{
+				"Ce-Specversion": {"1.0"},
+				"Ce-Id":          {"id"},
+				"Ce-Type":        {"dev.knative.test"},
+				"Ce-Source":      {"test"},
+			}
+			rec := httptest.NewRecorder()
+			receiver.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if got := len(*ids); got != tc.wantStored {
+				t.Errorf("%d events stored, want %d", got, tc.wantStored)
+			}
+		})
+	}
+}
+
+func TestPublishAsyncWaitsForContext(t *testing.T) {
+	s, _ := newAckingSupervisor(t, PublishAsync, time.Hour, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	e := event.New()
+	e.SetID("id")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if err := messageReceiverFunc(s)(ctx, ref, binding.ToMessage(&e), nil, http.Header{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("publish() = %v, want the deadline of the request exceeded", err)
+	}
+}
+
+func TestPublishOptionsStanOptions(t *testing.T) {
+	o := stan.GetDefaultOptions()
+	for _, opt := range (PublishOptions{MaxAcksInflight: 256, AckTimeout: 5 * time.Second}).stanOptions() {
+		if err := opt(&o); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	if o.MaxPubAcksInflight != 256 || o.AckTimeout != 5*time.Second {
+		t.Errorf("MaxPubAcksInflight, AckTimeout = %d, %v, want 256, 5s", o.MaxPubAcksInflight, o.AckTimeout)
+	}
+	if got := (PublishOptions{}).stanOptions(); len(got) != 0 {
+		t.Errorf("%d options without a configuration, want none", len(got))
+	}
+}
+
+// BenchmarkPublish compares the publications of the receiver in the sync and async modes, each
+// ack arriving after a round-trip to NATSS of a millisecond.
+func BenchmarkPublish(b *testing.B) {
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	for _, mode := range []PublishMode{PublishSync, PublishAsync} {
+		b.Run(string(mode), func(b *testing.B) {
+			s, _ := newAckingSupervisor(b, mode, time.Millisecond, nil)
+			receive := messageReceiverFunc(s)
+			b.ReportAllocs()
+			b.SetParallelism(64)
+			b.RunParallel(func(pb *testing.PB) {
+				e := event.New()
+				e.SetID("id")
+				e.SetType("dev.knative.test")
+				e.SetSource("test")
+				for pb.Next() {
+					if err := receive(context.Background(), ref, binding.ToMessage(&e), nil, http.Header{}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"knative.dev/eventing/pkg/kncloudevents"
+	"knative.dev/pkg/network"
+	"knative.dev/pkg/network/handlers"
+
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+// receiverDrainTimeout is how long the receiver keeps serving without requests before it shuts
+// down, overridden by the tests.
+var receiverDrainTimeout = network.DefaultDrainTimeout
+
+// startReceiver serves the receiver, over HTTPS as well when TLS is configured, until ctx is done.
+// The transport encryption mode decides whether the requests over plain HTTP are refused. The
+// receiver of the eventing library only serves plain HTTP, and hides the address of the peers.
+func (s *SubscriptionsSupervisor) startReceiver(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", util.DispatcherReceiverPort))
+	if err != nil {
+		return err
+	}
+	return serve(ctx, listener, s.receiverTLS, s.receiverHandler())
+}
+
+// receiverHandler returns the handler of the requests to the receiver.
+func (s *SubscriptionsSupervisor) receiverHandler() http.Handler {
+	return s.refusePlaintext(withClientAddress(s.withE2EProbe(s.withMaxPayload(s.withStoragePressure(s.withOfflineBuffer(s.withMultiplex(withChannelContract(s.withIngressInterceptors(s.tracedReceiver(s.receiver)))))))), s.trustedProxies))
+}
+
+// serve serves handler on listener, over both TLS and plain HTTP unless config is nil, until ctx
+// is done, then drains the requests in flight like the receiver of the eventing library does.
+func serve(ctx context.Context, listener net.Listener, config *tls.Config, handler http.Handler) error {
+	drainer := &handlers.Drainer{Inner: handler, QuietPeriod: receiverDrainTimeout}
+	server := &http.Server{
+		Addr:      listener.Addr().String(),
+		Handler:   drainer,
+		TLSConfig: config,
+	}
+	if config != nil {
+		listener = newSniffingListener(listener, config)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	select {
+	case <-ctx.Done():
+		server.SetKeepAlivesEnabled(false)
+		drainer.Drain()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), kncloudevents.DefaultShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		<-errCh
+		return err
+	case err := <-errCh:
+		return err
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+func TestServe(t *testing.T) {
+	defer func(timeout time.Duration) { receiverDrainTimeout = timeout }(receiverDrainTimeout)
+	receiverDrainTimeout = 10 * time.Millisecond
+
+	// Borrow the certificate of a test server.
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := certServer.TLS.Certificates[0]
+	certServer.Close()
+
+	weakClient := &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+	}
+	testCases := map[string]struct {
+		strict  bool
+		client  *tls.Config
+		wantErr bool
+	}{
+		"weak client, strict": {
+			strict:  true,
+			client:  weakClient,
+			wantErr: true,
+		},
+		"weak client": {
+			client: weakClient,
+		},
+		"default client, strict": {
+			strict: true,
+			client: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			config := &tls.Config{Certificates: []tls.Certificate{cert}}
+			if tc.strict {
+				security.Harden(config)
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- serve(ctx, listener, config, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+				}))
+			}()
+			defer func() {
+				cancel()
+				if err := <-errCh; err != nil {
+					t.Errorf("serve() = %v", err)
+				}
+			}()
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tc.client}}
+			resp, err := client.Post("https://"+listener.Addr().String(), "application/json", nil)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusAccepted {
+					t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Post() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestServePlainHTTP(t *testing.T) {
+	defer func(timeout time.Duration) { receiverDrainTimeout = timeout }(receiverDrainTimeout)
+	receiverDrainTimeout = 10 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serve(ctx, listener, nil, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+	}()
+	defer func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("serve() = %v", err)
+		}
+	}()
+
+	resp, err := http.Post("http://"+listener.Addr().String(), "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
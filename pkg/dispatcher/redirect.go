@@ -0,0 +1,130 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// RedirectReasonTooManyRedirects is the reason of the deliveries failed because the subscriber
+	// redirected them more than the maximum allowed, for example in a loop.
+	RedirectReasonTooManyRedirects = "TooManyRedirects"
+	// RedirectReasonRedirectDenied is the reason of the deliveries failed because the subscriber
+	// redirected them while the redirect policy of the channel is v1beta1.RedirectPolicyDeny.
+	RedirectReasonRedirectDenied = "RedirectDenied"
+)
+
+// maxLoggedRedirectHops bounds the redirect chain logged when a delivery fails on a redirect.
+const maxLoggedRedirectHops = 10
+
+// RedirectPolicySetter is implemented by the dispatchers able to deny the redirects of the
+// subscribers of a channel.
+type RedirectPolicySetter interface {
+	// SetRedirectPolicy sets whether the deliveries of channel follow the redirects.
+	SetRedirectPolicy(channel eventingchannels.ChannelReference, policy v1beta1.RedirectPolicy)
+}
+
+var _ RedirectPolicySetter = (*SubscriptionsSupervisor)(nil)
+
+// SetRedirectPolicy implements RedirectPolicySetter.
+func (s *SubscriptionsSupervisor) SetRedirectPolicy(channel eventingchannels.ChannelReference, policy v1beta1.RedirectPolicy) {
+	if policy.OrDefault() == v1beta1.RedirectPolicyFollow {
+		s.redirectPolicies.Delete(channel)
+		return
+	}
+	s.redirectPolicies.Store(channel, policy)
+}
+
+// redirectPolicy returns the redirect policy of channel.
+func (s *SubscriptionsSupervisor) redirectPolicy(channel eventingchannels.ChannelReference) v1beta1.RedirectPolicy {
+	if policy, ok := s.redirectPolicies.Load(channel); ok {
+		return policy.(v1beta1.RedirectPolicy)
+	}
+	return v1beta1.RedirectPolicyFollow
+}
+
+// redirectFailure records why a delivery failed on a redirect. The message dispatcher does not
+// keep the errors of the client, so the failure is recorded through the context of the request.
+type redirectFailure struct {
+	reason string
+	// code is the status code of the redirect refused.
+	code int
+}
+
+// redirectFailureKey is the context key of the *redirectFailure of a delivery.
+type redirectFailureKey struct{}
+
+// withRedirectFailure returns a context recording the redirect failures of its requests.
+func withRedirectFailure(ctx context.Context) (context.Context, *redirectFailure) {
+	failure := &redirectFailure{}
+	return context.WithValue(ctx, redirectFailureKey{}, failure), failure
+}
+
+// checkRedirect is the CheckRedirect of the delivery client. It refuses the redirects beyond the
+// maximum of the channel and all of them for the channels denying them, and the redirects from HTTPS to
+// plain HTTP when TLS is configured.
+func (s *SubscriptionsSupervisor) checkRedirect(req *http.Request, via []*http.Request) error {
+	var reason string
+	channel, _ := outboundChannel(req.Context())
+	switch {
+	case s.redirectPolicy(channel) == v1beta1.RedirectPolicyDeny:
+		reason = RedirectReasonRedirectDenied
+	case len(via) > s.deliveryLimitsOf(channel).MaxRedirects:
+		reason = RedirectReasonTooManyRedirects
+	}
+	if reason == "" {
+		if s.refuseTLSDowngrade {
+			return security.CheckRedirect(req, via)
+		}
+		return nil
+	}
+
+	code := 0
+	if req.Response != nil {
+		code = req.Response.StatusCode
+	}
+	if failure, ok := req.Context().Value(redirectFailureKey{}).(*redirectFailure); ok {
+		failure.reason, failure.code = reason, code
+	}
+	s.subscriptionsLogger.Debug("Refusing a redirect", zap.String("channel", channel.String()),
+		zap.String("reason", reason), zap.Strings("chain", redirectChain(req, via)))
+	return fmt.Errorf("%s: refusing the redirect to %s after %d requests", reason, req.URL, len(via))
+}
+
+// redirectChain returns the URLs of the requests of a redirect chain, the first ones being
+// dropped beyond maxLoggedRedirectHops.
+func redirectChain(req *http.Request, via []*http.Request) []string {
+	requests := append(via[:len(via):len(via)], req)
+	if len(requests) > maxLoggedRedirectHops {
+		requests = requests[len(requests)-maxLoggedRedirectHops:]
+	}
+	chain := make([]string, 0, len(requests))
+	for _, r := range requests {
+		chain = append(chain, r.URL.String())
+	}
+	return chain
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// newRedirectingServer redirects /hop/<n> to /hop/<n+1> until /hop/<accept>, which accepts the
+// event, with a negative accept redirecting /loop to itself forever.
+func newRedirectingServer(t *testing.T, accept int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if accept < 0 {
+			http.Redirect(w, req, "/loop", http.StatusPermanentRedirect)
+			return
+		}
+		var hop int
+		fmt.Sscanf(req.URL.Path, "/hop/%d", &hop)
+		if hop >= accept {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		http.Redirect(w, req, "/hop/"+strconv.Itoa(hop+1), http.StatusPermanentRedirect)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newRedirectTestEvent() binding.Message {
+	e := event.New()
+	e.SetID("redirected")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	return binding.ToMessage(&e)
+}
+
+func TestDispatchMessageRedirects(t *testing.T) {
+	testCases := map[string]struct {
+		accept       int
+		policy       v1beta1.RedirectPolicy
+		wantAck      bool
+		wantRequests int32
+	}{
+		"redirects within the maximum": {
+			accept:       3,
+			wantAck:      true,
+			wantRequests: 4,
+		},
+		"too many redirects": {
+			accept:       4,
+			wantRequests: 4,
+		},
+		"redirect loop": {
+			accept:       -1,
+			wantRequests: 4,
+		},
+		"redirects denied": {
+			accept:       1,
+			policy:       v1beta1.RedirectPolicyDeny,
+			wantRequests: 1,
+		},
+		"no redirect, denied": {
+			accept:       0,
+			policy:       v1beta1.RedirectPolicyDeny,
+			wantAck:      true,
+			wantRequests: 1,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			server, requests := newRedirectingServer(t, tc.accept)
+			s, _ := newTestSupervisor(t)
+			s.maxRedirects = 3
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			s.SetRedirectPolicy(ref, tc.policy)
+
+			destination := mustParseURL(t, server.URL+"/hop/0")
+			if tc.accept < 0 {
+				destination = mustParseURL(t, server.URL+"/loop")
+			}
+			if got := s.dispatchMessage(context.Background(), ref, newRedirectTestEvent(), destination, nil, nil); got != tc.wantAck {
+				t.Errorf("dispatchMessage() = %v, want %v", got, tc.wantAck)
+			}
+			if got := atomic.LoadInt32(requests); got != tc.wantRequests {
+				t.Errorf("%d requests, want %d", got, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestDispatchMessageRedirectResponseCodePolicy(t *testing.T) {
+	server, _ := newRedirectingServer(t, -1)
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	// The policy applies to the status code of the redirect refused.
+	s.SetResponseCodePolicy(ref, v1beta1.ResponseCodePolicy{"308": v1beta1.ResponseActionDrop})
+
+	if !s.dispatchMessage(context.Background(), ref, newRedirectTestEvent(), mustParseURL(t, server.URL+"/loop"), nil, nil) {
+		t.Error("dispatchMessage() = false, want the event refused on a 308 to be dropped")
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	var via []*http.Request
+	for i := 0; i < 15; i++ {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://subscriber/%d", i), nil)
+		via = append(via, req)
+	}
+	chain := redirectChain(via[14], via[:14])
+	if len(chain) != maxLoggedRedirectHops || chain[0] != "http://subscriber/5" || chain[9] != "http://subscriber/14" {
+		t.Errorf("redirectChain() = %v, want the last %d hops", chain, maxLoggedRedirectHops)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	natsscloudevents "github.com/cloudevents/sdk-go/protocol/stan/v2"
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+var (
+	// replayIdleTimeout ends a replay when no message was received for that long, NATSS not
+	// telling when a subscription has caught up with the last message of a channel.
+	replayIdleTimeout = 10 * time.Second
+
+	// replayProgressInterval is the number of deliveries between two progress notifications.
+	replayProgressInterval int64 = 100
+)
+
+// ReplayState is the state of a replay.
+type ReplayState string
+
+const (
+	// ReplayRunning is the state of a replay delivering messages.
+	ReplayRunning ReplayState = "Running"
+	// ReplayCompleted is the state of a replay which delivered all the messages it was asked to.
+	ReplayCompleted ReplayState = "Completed"
+	// ReplayFailed is the state of a replay which was interrupted.
+	ReplayFailed ReplayState = "Failed"
+)
+
+// ReplayStatus reports the progress of a replay.
+type ReplayStatus struct {
+	// ID identifies the request which started the replay.
+	ID string
+	// From is the time from which the messages are replayed.
+	From time.Time
+	// State is the state of the replay.
+	State ReplayState
+	// Delivered is the number of messages delivered so far.
+	Delivered int64
+	// Failed is the number of messages whose delivery failed.
+	Failed int64
+	// Err is why the replay failed.
+	Err error
+}
+
+// Replayer is implemented by the dispatchers able to deliver again the messages of a channel to
+// one of its subscribers.
+type Replayer interface {
+	// StartReplay delivers to subscriber the messages published on channel since from, through
+	// the normal delivery path, until it catches up with the messages published when it started.
+	// The replay is identified by id, notify being called when its progress changes. It fails if
+	// a replay of subscriber is already known.
+	StartReplay(ctx context.Context, channel eventingchannels.ChannelReference, subscriber eventingduckv1.SubscriberSpec, from time.Time, id string, notify func()) error
+	// Replay returns the status of the last replay of the subscriber, if any.
+	Replay(subscriber types.UID) (ReplayStatus, bool)
+	// ForgetReplay forgets a replay which is no longer running.
+	ForgetReplay(subscriber types.UID)
+}
+
+var _ Replayer = (*SubscriptionsSupervisor)(nil)
+
+// errReplayInProgress is returned when starting a replay of a subscriber already replaying.
+var errReplayInProgress = errors.New("a replay is already in progress")
+
+// replay delivers the historical messages of a channel to a subscriber.
+type replay struct {
+	channel     eventingchannels.ChannelReference
+	destination *url.URL
+	reply       *url.URL
+	deadLetter  *url.URL
+	// until is when the replay started, the messages published since are delivered by the
+	// durable subscription.
+	until  time.Time
+	notify func()
+
+	delivered int64
+	failed    int64
+	// activity is signaled for every message received.
+	activity chan struct{}
+	// caughtUp is closed once a message published after until is received.
+	caughtUp     chan struct{}
+	caughtUpOnce sync.Once
+
+	mu     sync.Mutex
+	status ReplayStatus
+}
+
+func newReplay(channel eventingchannels.ChannelReference, subscriber eventingduckv1.SubscriberSpec, from time.Time, id string, notify func()) *replay {
+	r := &replay{
+		channel:  channel,
+		until:    time.Now(),
+		notify:   notify,
+		activity: make(chan struct{}, 1),
+		caughtUp: make(chan struct{}),
+		status:   ReplayStatus{ID: id, From: from, State: ReplayRunning},
+	}
+	if !subscriber.SubscriberURI.IsEmpty() {
+		r.destination = subscriber.SubscriberURI.URL()
+	}
+	if !subscriber.ReplyURI.IsEmpty() {
+		r.reply = subscriber.ReplyURI.URL()
+	}
+	if subscriber.Delivery != nil && subscriber.Delivery.DeadLetterSink != nil && !subscriber.Delivery.DeadLetterSink.URI.IsEmpty() {
+		r.deadLetter = subscriber.Delivery.DeadLetterSink.URI.URL()
+	}
+	return r
+}
+
+// handle delivers a message received by the replay subscription.
+func (r *replay) handle(ctx context.Context, s *SubscriptionsSupervisor, stanMsg *stan.Msg) {
+	select {
+	case r.activity <- struct{}{}:
+	default:
+	}
+	if !time.Unix(0, stanMsg.Timestamp).Before(r.until) {
+		r.caughtUpOnce.Do(func() { close(r.caughtUp) })
+		return
+	}
+	select {
+	case <-r.caughtUp:
+		return
+	default:
+	}
+
+	decrypted, err := s.decrypt(r.channel, stanMsg)
+	if err != nil {
+		s.logger.Error("could not decrypt a message", zap.Error(err))
+		atomic.AddInt64(&r.failed, 1)
+		return
+	}
+	message, err := natsscloudevents.NewMessage(decrypted)
+	if err != nil {
+		s.logger.Error("could not create a message", zap.Error(err))
+		atomic.AddInt64(&r.failed, 1)
+		return
+	}
+	// The replay subscription is not durable, the message is not redelivered whatever the result.
+	if s.dispatchMessage(ctx, r.channel, withEgressExtensions(ctx, decrypted, message), r.destination, r.reply, r.deadLetter) {
+		if n := atomic.AddInt64(&r.delivered, 1); n%replayProgressInterval == 0 {
+			r.notify()
+		}
+	} else {
+		atomic.AddInt64(&r.failed, 1)
+	}
+}
+
+// wait returns once the replay caught up, stayed idle for replayIdleTimeout or ctx is done.
+func (r *replay) wait(ctx context.Context) error {
+	timer := time.NewTimer(replayIdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(replayIdleTimeout)
+		case <-r.caughtUp:
+			return nil
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *replay) finish(err error) {
+	r.mu.Lock()
+	if err != nil {
+		r.status.State = ReplayFailed
+		r.status.Err = err
+	} else {
+		r.status.State = ReplayCompleted
+	}
+	r.mu.Unlock()
+	r.notify()
+}
+
+func (r *replay) getStatus() ReplayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	status.Delivered = atomic.LoadInt64(&r.delivered)
+	status.Failed = atomic.LoadInt64(&r.failed)
+	return status
+}
+
+// StartReplay implements Replayer.
+func (s *SubscriptionsSupervisor) StartReplay(ctx context.Context, channel eventingchannels.ChannelReference, subscriber eventingduckv1.SubscriberSpec, from time.Time, id string, notify func()) error {
+	s.replaysMux.Lock()
+	defer s.replaysMux.Unlock()
+	if _, ok := s.replays[subscriber.UID]; ok {
+		return errReplayInProgress
+	}
+
+	currentNatssConn, err := s.connection(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	r := newReplay(channel, subscriber, from, id, notify)
+	sub, err := (*currentNatssConn).Subscribe(getSubject(channel), func(stanMsg *stan.Msg) {
+		r.handle(ctx, s, stanMsg)
+	}, stan.StartAtTime(from))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the replay subscription")
+	}
+	s.replays[subscriber.UID] = r
+	s.logger.Info("Replay started", zap.String("channel", channel.String()), zap.String("subscription", string(subscriber.UID)), zap.Time("from", from))
+
+	go func() {
+		err := r.wait(ctx)
+		if uerr := sub.Unsubscribe(); uerr != nil {
+			s.logger.Warn("Failed to remove the replay subscription", zap.Error(uerr))
+		}
+		r.finish(err)
+		status := r.getStatus()
+		s.logger.Info("Replay finished", zap.String("subscription", string(subscriber.UID)),
+			zap.String("state", string(status.State)), zap.Int64("delivered", status.Delivered), zap.Int64("failed", status.Failed))
+	}()
+	return nil
+}
+
+// Replay implements Replayer.
+func (s *SubscriptionsSupervisor) Replay(subscriber types.UID) (ReplayStatus, bool) {
+	s.replaysMux.Lock()
+	r, ok := s.replays[subscriber]
+	s.replaysMux.Unlock()
+	if !ok {
+		return ReplayStatus{}, false
+	}
+	return r.getStatus(), true
+}
+
+// ForgetReplay implements Replayer.
+func (s *SubscriptionsSupervisor) ForgetReplay(subscriber types.UID) {
+	s.replaysMux.Lock()
+	defer s.replaysMux.Unlock()
+	if r, ok := s.replays[subscriber]; ok && r.getStatus().State != ReplayRunning {
+		delete(s.replays, subscriber)
+	}
+}
+
+// String describes the progress of the replay.
+func (rs ReplayStatus) String() string {
+	switch rs.State {
+	case ReplayFailed:
+		return fmt.Sprintf("replay since %s failed after %d events delivered: %v", rs.From.Format(time.RFC3339), rs.Delivered, rs.Err)
+	default:
+		return fmt.Sprintf("replay since %s %s: %d events delivered, %d failed", rs.From.Format(time.RFC3339), strings.ToLower(string(rs.State)), rs.Delivered, rs.Failed)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+func TestReplay(t *testing.T) {
+	var received int32
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+
+	var notified int32
+	from := time.Now().Add(-time.Hour)
+	spec := eventingduckv1.SubscriberSpec{UID: "uid", SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String())}
+	r := newReplay(eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}, spec, from, "id", func() {
+		atomic.AddInt32(&notified, 1)
+	})
+
+	// The historical messages are delivered, the ones published after the replay started are not.
+	ctx := context.Background()
+	r.handle(ctx, s, newTestStanMsg(t, r.until.Add(-time.Minute)))
+	r.handle(ctx, s, newTestStanMsg(t, r.until.Add(-time.Second)))
+	r.handle(ctx, s, newTestStanMsg(t, r.until.Add(time.Second)))
+	r.handle(ctx, s, newTestStanMsg(t, r.until.Add(-time.Second)))
+
+	if err := r.wait(ctx); err != nil {
+		t.Fatalf("wait() = %v", err)
+	}
+	r.finish(nil)
+
+	if got := atomic.LoadInt32(&received); got != 2 {
+		t.Errorf("delivered %d messages, want 2", got)
+	}
+	status := r.getStatus()
+	if status.State != ReplayCompleted || status.Delivered != 2 || status.ID != "id" || !status.From.Equal(from) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if atomic.LoadInt32(&notified) == 0 {
+		t.Error("the completion was not notified")
+	}
+}
+
+func TestReplayIdle(t *testing.T) {
+	defer func(timeout time.Duration) { replayIdleTimeout = timeout }(replayIdleTimeout)
+	replayIdleTimeout = 10 * time.Millisecond
+
+	r := newReplay(eventingchannels.ChannelReference{}, eventingduckv1.SubscriberSpec{}, time.Now(), "id", func() {})
+	if err := r.wait(context.Background()); err != nil {
+		t.Errorf("wait() = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	replayIdleTimeout = time.Hour
+	if err := r.wait(ctx); err == nil {
+		t.Error("wait() succeeded after the context was cancelled")
+	}
+}
+
+func TestStartReplay(t *testing.T) {
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	spec := eventingduckv1.SubscriberSpec{UID: "uid"}
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	if err := s.StartReplay(context.Background(), channel, spec, time.Now(), "id", func() {}); err == nil {
+		t.Error("StartReplay() succeeded without a connection to NATSS")
+	}
+
+	// Concurrent replays of a subscriber are rejected.
+	s.replays[spec.UID] = newReplay(channel, spec, time.Now(), "other", func() {})
+	if err := s.StartReplay(context.Background(), channel, spec, time.Now(), "id", func() {}); err != errReplayInProgress {
+		t.Errorf("StartReplay() = %v, want %v", err, errReplayInProgress)
+	}
+	if status, ok := s.Replay(spec.UID); !ok || status.ID != "other" || status.State != ReplayRunning {
+		t.Errorf("Replay() = %+v, %v, want the running replay", status, ok)
+	}
+
+	// Running replays are not forgotten.
+	s.ForgetReplay(spec.UID)
+	if _, ok := s.Replay(spec.UID); !ok {
+		t.Error("the running replay was forgotten")
+	}
+	s.replays[spec.UID].finish(nil)
+	s.ForgetReplay(spec.UID)
+	if _, ok := s.Replay(types.UID("uid")); ok {
+		t.Error("the completed replay was not forgotten")
+	}
+}
+
+func newTestStanMsg(t *testing.T, published time.Time) *stan.Msg {
+	e := event.New()
+	e.SetID("1")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("failed to marshal the event: %v", err)
+	}
+	return &stan.Msg{MsgProto: pb.MsgProto{Data: data, Timestamp: published.UnixNano()}}
+}
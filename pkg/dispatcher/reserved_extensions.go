@@ -0,0 +1,169 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/nats-io/stan.go"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/metrics"
+)
+
+// RedeliveredExtension is the CloudEvents extension attribute set to true on the deliveries of
+// the events NATSS redelivers.
+const RedeliveredExtension = "knativenatssredelivered"
+
+const (
+	// cloudEventsHeaderPrefix prefixes the headers of the attributes of the events in binary mode.
+	cloudEventsHeaderPrefix = "ce-"
+	// structuredContentType is the content type of the events in structured mode.
+	structuredContentType = "application/cloudevents+json"
+
+	reservedExtensionsStripped = "stripped"
+	reservedExtensionsRejected = "rejected"
+)
+
+// reservedExtensions are the extension attributes set by the dispatcher, which the producers
+// cannot set: the receiver strips or rejects the events carrying them.
+var reservedExtensions = map[string]struct{}{
+	AuditChannelExtension: {},
+	AuditClientExtension:  {},
+	RedeliveredExtension:  {},
+}
+
+var (
+	// reservedExtensionsCountM records the events received with reserved extension attributes.
+	reservedExtensionsCountM = stats.Int64(
+		"reserved_extensions_count",
+		"Number of events received with extension attributes reserved to the dispatcher",
+		stats.UnitDimensionless,
+	)
+
+	// reservedExtensionsResultKey tags the events with either reservedExtensionsStripped or
+	// reservedExtensionsRejected.
+	reservedExtensionsResultKey = tag.MustNewKey("result")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: reservedExtensionsCountM.Description(),
+			Measure:     reservedExtensionsCountM,
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{reservedExtensionsResultKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// ReservedExtensions returns the sorted names of the extension attributes reserved to the
+// dispatcher.
+func ReservedExtensions() []string {
+	names := make([]string, 0, len(reservedExtensions))
+	for name := range reservedExtensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsReservedExtension returns whether the extension attribute name is reserved to the dispatcher.
+func IsReservedExtension(name string) bool {
+	_, ok := reservedExtensions[strings.ToLower(name)]
+	return ok
+}
+
+// screenReservedExtensions is the IngressInterceptor stripping the reserved extension attributes
+// of the events, or refusing them with 422 Unprocessable Entity when the receiver rejects them, so
+// that the producers cannot pass for the dispatcher.
+func (s *SubscriptionsSupervisor) screenReservedExtensions(ctx context.Context, e *event.Event, _ http.Header) (*event.Event, error) {
+	var found []string
+	for name := range e.Extensions() {
+		if IsReservedExtension(name) {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 0 {
+		return e, nil
+	}
+	sort.Strings(found)
+	fields := []zap.Field{zap.Strings("extensions", found), zap.String("id", e.ID())}
+	if client, ok := ClientAddress(ctx); ok {
+		fields = append(fields, zap.String("client", client))
+	}
+	if s.rejectReservedExtensions {
+		s.receiverLogger.Warn("Rejected an event with reserved extensions", fields...)
+		recordReservedExtensions(reservedExtensionsRejected)
+		return nil, NewIngressError(http.StatusUnprocessableEntity, "the extensions %s are reserved", strings.Join(found, ", "))
+	}
+	s.receiverLogger.Info("Stripped the reserved extensions of an event", fields...)
+	recordReservedExtensions(reservedExtensionsStripped)
+	for _, name := range found {
+		e.SetExtension(name, nil)
+	}
+	return e, nil
+}
+
+// withEgressExtensions returns message with the reserved extension attributes the dispatcher
+// sets, and those alone: RedeliveredExtension when NATSS redelivers msg. The events published
+// before the receiver screened them are stripped here too.
+func withEgressExtensions(ctx context.Context, msg *stan.Msg, message binding.Message) binding.Message {
+	// The events are stored in structured mode, those without reserved extensions are not parsed.
+	if !msg.Redelivered && !mentionsReservedExtension(msg.Data) {
+		return message
+	}
+	e, err := binding.ToEvent(ctx, message)
+	if err != nil {
+		// The delivery fails on the same error.
+		return message
+	}
+	for name := range reservedExtensions {
+		e.SetExtension(name, nil)
+	}
+	if msg.Redelivered {
+		e.SetExtension(RedeliveredExtension, true)
+	}
+	return binding.ToMessage(e)
+}
+
+func mentionsReservedExtension(data []byte) bool {
+	for name := range reservedExtensions {
+		if bytes.Contains(data, []byte(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func recordReservedExtensions(result string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(reservedExtensionsResultKey, result))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, reservedExtensionsCountM.M(1))
+}
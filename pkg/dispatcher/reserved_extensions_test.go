@@ -0,0 +1,202 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/go-cmp/cmp"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+const structuredTestEvent = `{"specversion":"1.0","id":"1","type":"dev.knative.test","source":"test","knativenatssredelivered":true,"knauditclient":"10.0.0.1","custom":"kept"}`
+
+func TestScreenReservedExtensions(t *testing.T) {
+	testCases := map[string]struct {
+		reject  bool
+		header  http.Header
+		body    string
+		want    int
+		wantExt []string
+	}{
+		"binary, stripped": {
+			header:  http.Header{"Ce-Knativenatssredelivered": {"true"}, "Ce-Custom": {"kept"}},
+			want:    http.StatusAccepted,
+			wantExt: []string{"custom"},
+		},
+		"binary, rejected": {
+			reject: true,
+			header: http.Header{"Ce-Knativenatssredelivered": {"true"}},
+			want:   http.StatusUnprocessableEntity,
+		},
+		"binary without reserved extension": {
+			reject:  true,
+			header:  http.Header{"Ce-Custom": {"kept"}},
+			want:    http.StatusAccepted,
+			wantExt: []string{"custom"},
+		},
+		"structured, stripped": {
+			header:  http.Header{"Content-Type": {"application/cloudevents+json; charset=utf-8"}},
+			body:    structuredTestEvent,
+			want:    http.StatusAccepted,
+			wantExt: []string{"custom"},
+		},
+		"structured, rejected": {
+			reject: true,
+			header: http.Header{"Content-Type": {"application/cloudevents+json"}},
+			body:   structuredTestEvent,
+			want:   http.StatusUnprocessableEntity,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s := &SubscriptionsSupervisor{receiverLogger: zap.NewNop(), rejectReservedExtensions: tc.reject}
+			s.interceptors = s.ingressInterceptors(nil)
+			var gotExt []string
+			handler := s.withIngressInterceptors(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+				if err != nil {
+					t.Errorf("ToEvent() = %v", err)
+					return
+				}
+				for name := range e.Extensions() {
+					gotExt = append(gotExt, name)
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "http://channel.ns.svc.cluster.local/", strings.NewReader(tc.body))
+			if tc.body == "" {
+				req.Header = http.Header{"Ce-Specversion": {"1.0"}, "Ce-Id": {"1"}, "Ce-Type": {"dev.knative.test"}, "Ce-Source": {"test"}}
+			}
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if diff := cmp.Diff(tc.wantExt, gotExt); diff != "" {
+				t.Errorf("extensions (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+// redeliveredRecorder is a subscriber recording the RedeliveredExtension of the events it receives.
+type redeliveredRecorder struct {
+	*httptest.Server
+
+	mu  sync.Mutex
+	got []interface{}
+}
+
+func newRedeliveredRecorder() *redeliveredRecorder {
+	r := &redeliveredRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.got = append(r.got, e.Extensions()[RedeliveredExtension])
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return r
+}
+
+func (r *redeliveredRecorder) last() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.got) == 0 {
+		return "nothing received"
+	}
+	return r.got[len(r.got)-1]
+}
+
+func TestSpoofedRedeliveredExtension(t *testing.T) {
+	subscriber := newRedeliveredRecorder()
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	const host = "channel.ns.svc.cluster.local"
+	s.setRoutes(map[string]eventingchannels.ChannelReference{host: ref})
+	channel := newTestChannel(ref, &eventRecorder{Server: subscriber.Server})
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	receiver := s.withIngressInterceptors(kncloudevents.CreateHandler(s.receiver))
+
+	// The extension sent by the producer is stripped by the receiver.
+	req := httptest.NewRequest(http.MethodPost, "http://"+host+"/", nil)
+	req.Header = http.Header{
+		"Ce-Specversion":             {"1.0"},
+		"Ce-Id":                      {"spoofed"},
+		"Ce-Type":                    {"dev.knative.test"},
+		"Ce-Source":                  {"test"},
+		"Ce-Knativenatssredelivered": {"true"},
+	}
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	if got := subscriber.last(); got != nil {
+		t.Errorf("%s = %v delivered for an event sent once", RedeliveredExtension, got)
+	}
+
+	// The events stored with the extension, before the receiver stripped it, are delivered
+	// with the extension set by the dispatcher alone.
+	stored := func(redelivered bool, spoofed interface{}) *stan.Msg {
+		e := event.New()
+		e.SetID("stored")
+		e.SetType("dev.knative.test")
+		e.SetSource("test")
+		e.SetExtension(RedeliveredExtension, spoofed)
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &stan.Msg{MsgProto: pb.MsgProto{Data: data, Redelivered: redelivered}}
+	}
+	conn.publish(stored(false, true))
+	if got := subscriber.last(); got != nil {
+		t.Errorf("%s = %v delivered for an event sent once", RedeliveredExtension, got)
+	}
+	conn.publish(stored(true, false))
+	// The extensions of the events received in binary mode are strings.
+	if got := subscriber.last(); got != "true" {
+		t.Errorf("%s = %v delivered for a redelivered event, want true", RedeliveredExtension, got)
+	}
+}
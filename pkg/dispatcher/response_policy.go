@@ -0,0 +1,174 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	"go.uber.org/zap"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/kncloudevents"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// defaultResponseAction is applied to the failed deliveries not matched by any policy.
+const defaultResponseAction = v1beta1.ResponseActionDeadLetter
+
+// ResponseCodePolicySetter is implemented by the dispatchers supporting response code policies.
+type ResponseCodePolicySetter interface {
+	// SetResponseCodePolicy sets the response code policy of a channel, a nil policy removes it
+	// so that only the cluster default applies.
+	SetResponseCodePolicy(channel eventingchannels.ChannelReference, policy v1beta1.ResponseCodePolicy)
+}
+
+var _ ResponseCodePolicySetter = (*SubscriptionsSupervisor)(nil)
+
+// SetResponseCodePolicy implements ResponseCodePolicySetter.
+func (s *SubscriptionsSupervisor) SetResponseCodePolicy(channel eventingchannels.ChannelReference, policy v1beta1.ResponseCodePolicy) {
+	if len(policy) == 0 {
+		s.responseCodePolicies.Delete(channel)
+		return
+	}
+	s.responseCodePolicies.Store(channel, policy)
+}
+
+// responseAction returns the action for a failed delivery on channel, looking up the policy of
+// the channel first, then the cluster default one.
+func (s *SubscriptionsSupervisor) responseAction(channel eventingchannels.ChannelReference, code int) v1beta1.ResponseAction {
+	if policy, ok := s.responseCodePolicies.Load(channel); ok {
+		if action, ok := policy.(v1beta1.ResponseCodePolicy).Action(code); ok {
+			return action
+		}
+	}
+	if action, ok := s.defaultResponseCodePolicy.Action(code); ok {
+		return action
+	}
+	return defaultResponseAction
+}
+
+// dispatchMessage delivers message to destination, forwarding the response to reply, and returns
+// whether the message must be acknowledged. When the delivery fails, the response code policy of
+// the channel decides whether the message is retried, dropped or sent to deadLetter.
+func (s *SubscriptionsSupervisor) dispatchMessage(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, destination, reply, deadLetter *url.URL) bool {
+	return s.deliver(ctx, channel, message, destination, reply, deadLetter, nil).acked()
+}
+
+// deliveryResult is the outcome of the delivery of a message.
+type deliveryResult struct {
+	// status is one of the DeliveryStatus constants.
+	status string
+	// code is the status code of the response to the failed delivery.
+	code int
+	// response describes the body of the response to the failed delivery, empty when it was not
+	// kept.
+	response string
+}
+
+// acked returns whether the message must be acknowledged.
+func (r deliveryResult) acked() bool {
+	return r.status != DeliveryStatusFailed
+}
+
+// deliver is dispatchMessage, returning the outcome of the delivery. The failed deliveries to
+// destination are retried following retry first, when it is not nil.
+func (s *SubscriptionsSupervisor) deliver(ctx context.Context, channel eventingchannels.ChannelReference, message binding.Message, destination, reply, deadLetter *url.URL, retry *kncloudevents.RetryConfig) deliveryResult {
+	ctx = withOutboundChannel(ctx, channel)
+	ctx, redirect := withRedirectFailure(ctx)
+	ctx, body := withErrorBody(ctx)
+	message = s.transcodeAvro(ctx, channel, message)
+	destination, reply, deadLetter = s.preferHTTPS(destination), s.preferHTTPS(reply), s.preferHTTPS(deadLetter)
+	// Acks are driven by the result of the dispatch, not by the dispatcher finishing the message.
+	message = unackedMessage{message}
+
+	executionInfo, err := s.dispatcher.DispatchMessageWithRetries(ctx, message, nil, destination, reply, nil, retry)
+	if err == nil {
+		// TODO: Actually report the stats
+		// https://github.com/knative-sandbox/eventing-natss/issues/39
+		s.subscriptionsLoggerOf(channel).Debug("Dispatch details", zap.Any("DispatchExecutionInfo", executionInfo))
+		return deliveryResult{status: DeliveryStatusDelivered}
+	}
+
+	code := eventingchannels.NoResponse
+	if executionInfo != nil {
+		code = executionInfo.ResponseCode
+	}
+	fields := []zap.Field{zap.Error(err)}
+	response := body.describe()
+	if response != "" {
+		fields = append(fields, zap.String("responseBody", response))
+	}
+	if redirect.reason != "" {
+		// The policy applies to the status code of the redirect refused.
+		code = redirect.code
+		fields = append(fields, zap.String("reason", redirect.reason))
+	}
+	action := s.responseAction(channel, code)
+	s.subscriptionsLoggerOf(channel).Error("Failed to dispatch message", append(fields, zap.Int("responseCode", code), zap.String("action", string(action)))...)
+
+	failed := deliveryResult{status: DeliveryStatusFailed, code: code, response: response}
+	switch action {
+	case v1beta1.ResponseActionDrop:
+		return deliveryResult{status: DeliveryStatusDropped, code: code, response: response}
+	case v1beta1.ResponseActionDeadLetter:
+		if deadLetter == nil {
+			return failed
+		}
+		// The timeout of the deliveries to the subscriber does not apply to its dead letter sink.
+		if _, err := s.dispatcher.DispatchMessage(withDeliveryTimeout(ctx, 0), withErrorExtensions(ctx, message, destination, code, body.data), nil, deadLetter, nil, nil); err != nil {
+			// Not acknowledging the message makes NATSS redeliver it, once the sink is back.
+			s.subscriptionsLogger.Error("Failed to dispatch message to the dead letter sink", zap.Error(err))
+			return failed
+		}
+		return deliveryResult{status: DeliveryStatusDeadLettered, code: code, response: response}
+	default:
+		return failed
+	}
+}
+
+// unackedMessage prevents the STAN message from being acknowledged when it is finished.
+type unackedMessage struct {
+	binding.Message
+}
+
+var _ binding.MessageWrapper = unackedMessage{}
+
+func (unackedMessage) Finish(error) error {
+	return nil
+}
+
+func (m unackedMessage) GetWrappedMessage() binding.Message {
+	return m.Message
+}
+
+func (m unackedMessage) GetAttribute(k spec.Kind) (spec.Attribute, interface{}) {
+	if r, ok := m.Message.(binding.MessageMetadataReader); ok {
+		return r.GetAttribute(k)
+	}
+	return nil, nil
+}
+
+func (m unackedMessage) GetExtension(name string) interface{} {
+	if r, ok := m.Message.(binding.MessageMetadataReader); ok {
+		return r.GetExtension(name)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestDispatchMessageResponseCodePolicy(t *testing.T) {
+	channel := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+
+	testCases := map[string]struct {
+		responseCode  int
+		policy        v1beta1.ResponseCodePolicy
+		clusterPolicy v1beta1.ResponseCodePolicy
+		noDeadLetter  bool
+		wantAck       bool
+		wantDLS       bool
+	}{
+		"2xx unaffected": {
+			responseCode: http.StatusAccepted,
+			policy:       v1beta1.ResponseCodePolicy{"4xx": v1beta1.ResponseActionRetry, "5xx": v1beta1.ResponseActionDrop},
+			wantAck:      true,
+		},
+		"404 to dead letter": {
+			responseCode: http.StatusNotFound,
+			policy:       v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDeadLetter, "4xx": v1beta1.ResponseActionRetry},
+			wantAck:      true,
+			wantDLS:      true,
+		},
+		"429 retried": {
+			responseCode: http.StatusTooManyRequests,
+			policy:       v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDeadLetter, "429": v1beta1.ResponseActionRetry},
+			wantAck:      false,
+		},
+		"dropped": {
+			responseCode: http.StatusBadRequest,
+			policy:       v1beta1.ResponseCodePolicy{"4xx": v1beta1.ResponseActionDrop},
+			wantAck:      true,
+		},
+		"cluster default": {
+			responseCode:  http.StatusTooManyRequests,
+			clusterPolicy: v1beta1.ResponseCodePolicy{"429": v1beta1.ResponseActionRetry},
+			wantAck:       false,
+		},
+		"channel policy overrides cluster default": {
+			responseCode:  http.StatusTooManyRequests,
+			policy:        v1beta1.ResponseCodePolicy{"4xx": v1beta1.ResponseActionDrop},
+			clusterPolicy: v1beta1.ResponseCodePolicy{"429": v1beta1.ResponseActionRetry},
+			wantAck:       true,
+		},
+		"no policy, dead letter": {
+			responseCode: http.StatusInternalServerError,
+			wantAck:      true,
+			wantDLS:      true,
+		},
+		"no policy, no dead letter": {
+			responseCode: http.StatusInternalServerError,
+			noDeadLetter: true,
+			wantAck:      false,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.responseCode)
+			}))
+			defer subscriber.Close()
+
+			var deadLettered int32
+			dls := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&deadLettered, 1)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer dls.Close()
+
+			d, err := NewDispatcher(Args{ClientID: "test", DefaultResponseCodePolicy: tc.clusterPolicy})
+			if err != nil {
+				t.Fatalf("NewDispatcher() = %v", err)
+			}
+			s := d.(*SubscriptionsSupervisor)
+			s.SetResponseCodePolicy(channel, tc.policy)
+
+			var deadLetter *url.URL
+			if !tc.noDeadLetter {
+				deadLetter = mustParseURL(t, dls.URL)
+			}
+			ack := s.dispatchMessage(context.Background(), channel, newTestMessage(), mustParseURL(t, subscriber.URL), nil, deadLetter)
+
+			if ack != tc.wantAck {
+				t.Errorf("ack = %v, want %v", ack, tc.wantAck)
+			}
+			if got := atomic.LoadInt32(&deadLettered) == 1; got != tc.wantDLS {
+				t.Errorf("sent to the dead letter sink = %v, want %v", got, tc.wantDLS)
+			}
+		})
+	}
+}
+
+func newTestMessage() binding.Message {
+	e := event.New()
+	e.SetID("1")
+	e.SetType("dev.knative.test")
+	e.SetSource("test")
+	return binding.ToMessage(&e)
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", s, err)
+	}
+	return u
+}
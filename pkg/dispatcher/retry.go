@@ -0,0 +1,86 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/kncloudevents"
+)
+
+const (
+	// defaultAckWait is how long NATSS waits for the ack of a message before redelivering it,
+	// extended by the backoff of the retries of the subscription, when none is configured.
+	defaultAckWait = 1 * time.Minute
+
+	// defaultBackoffPolicy and defaultBackoffDelay complete the delivery specs setting retries
+	// without a backoff.
+	defaultBackoffPolicy = eventingduckv1.BackoffPolicyExponential
+	defaultBackoffDelay  = "PT0.2S"
+)
+
+// retryConfig returns how the deliveries to subscription are retried before they are left to
+// NATSS to redeliver, nil when they are not. The delivery spec of the subscription applies, the
+// default one of the dispatcher when it sets no retry. An invalid spec fails the subscription.
+func (s *SubscriptionsSupervisor) retryConfig(ctx context.Context, subscription subscriptionReference) (*kncloudevents.RetryConfig, error) {
+	return retryConfigOf(ctx, subscription, s.defaultDelivery)
+}
+
+// retryConfigOf returns how the deliveries to subscription are retried, following its delivery
+// spec or defaultDelivery when it sets no retry, nil when they are not.
+func retryConfigOf(ctx context.Context, subscription subscriptionReference, defaultDelivery *eventingduckv1.DeliverySpec) (*kncloudevents.RetryConfig, error) {
+	spec := subscription.Delivery
+	if err := spec.Validate(ctx); err != nil {
+		return nil, fmt.Errorf("invalid delivery: %w", err)
+	}
+	if spec == nil || (spec.Retry == nil && spec.BackoffPolicy == nil && spec.BackoffDelay == nil) {
+		spec = defaultDelivery
+	}
+	if spec == nil || spec.Retry == nil || *spec.Retry == 0 {
+		return nil, nil
+	}
+
+	completed := *spec
+	if completed.BackoffPolicy == nil {
+		policy := defaultBackoffPolicy
+		completed.BackoffPolicy = &policy
+	}
+	if completed.BackoffDelay == nil {
+		delay := defaultBackoffDelay
+		completed.BackoffDelay = &delay
+	}
+	config, err := kncloudevents.RetryConfigFromDeliverySpec(completed)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// ackWaitOf returns the ack wait of the subscriptions retrying their deliveries with retry, wait
+// extended long enough for NATSS not to redeliver the messages while they are retried.
+func ackWaitOf(wait time.Duration, retry *kncloudevents.RetryConfig) time.Duration {
+	if retry == nil {
+		return wait
+	}
+	for i := 0; i < retry.RetryMax; i++ {
+		wait += retry.Backoff(i, nil)
+	}
+	return wait
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// newFailingSubscriber returns a subscriber failing its first failures requests, counting them all
+// in attempts.
+func newFailingSubscriber(failures int32, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(attempts, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+}
+
+func newRetryDelivery(retry int32, policy eventingduckv1.BackoffPolicyType, delay string) *eventingduckv1.DeliverySpec {
+	return &eventingduckv1.DeliverySpec{Retry: &retry, BackoffPolicy: &policy, BackoffDelay: &delay}
+}
+
+func TestRetrySucceedsOnSecondAttempt(t *testing.T) {
+	var attempts int32
+	subscriber := newFailingSubscriber(1, &attempts)
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+		Delivery:      newRetryDelivery(3, eventingduckv1.BackoffPolicyExponential, "PT0.01S"),
+	}}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	conn.publish(newTestEventMsg(t, "flaky"))
+
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("the subscriber was sent the event %d times, want 2", got)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var attempts int32
+	subscriber := newFailingSubscriber(100, &attempts)
+	defer subscriber.Close()
+	dls := newDeadLetterRecorder()
+	defer dls.Close()
+
+	s, conn := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	delivery := newRetryDelivery(2, eventingduckv1.BackoffPolicyLinear, "PT0.01S")
+	delivery.DeadLetterSink = &duckv1.Destination{URI: apis.HTTP(dls.Listener.Addr().String())}
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+		Delivery:      delivery,
+	}}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	conn.publish(newTestEventMsg(t, "exhausted"))
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("the subscriber was sent the event %d times, want 3", got)
+	}
+	if got := dls.received(); len(got) != 1 {
+		t.Errorf("the dead letter sink received %d events, want 1 once the retries are exhausted", len(got))
+	}
+}
+
+func TestRetryExhaustedUnacknowledged(t *testing.T) {
+	var attempts int32
+	subscriber := newFailingSubscriber(100, &attempts)
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	retry, err := s.retryConfig(context.Background(), subscriptionReference{
+		Delivery: newRetryDelivery(1, eventingduckv1.BackoffPolicyLinear, "PT0.01S"),
+	})
+	if err != nil {
+		t.Fatalf("retryConfig() = %v", err)
+	}
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	// Not acknowledging the message leaves it to NATSS to redeliver.
+	result := s.deliver(context.Background(), ref, newTestMessage(), mustParseURL(t, subscriber.URL), nil, nil, retry)
+	if result.acked() {
+		t.Errorf("deliver() = %+v, want the message left unacknowledged", result)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("the subscriber was sent the event %d times, want 2", got)
+	}
+}
+
+func TestRetryDefaultDelivery(t *testing.T) {
+	var attempts int32
+	subscriber := newFailingSubscriber(1, &attempts)
+	defer subscriber.Close()
+
+	s, conn := newTestSupervisor(t)
+	retry := int32(1)
+	s.defaultDelivery = &eventingduckv1.DeliverySpec{Retry: &retry}
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+	}}
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	conn.publish(newTestEventMsg(t, "default"))
+
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("the subscriber was sent the event %d times, want 2 with the default delivery", got)
+	}
+}
+
+func TestRetryInvalidBackoffDelay(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newTestChannel(ref)
+	channel.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           "uid-0",
+		SubscriberURI: apis.HTTP("subscriber.ns.svc.cluster.local"),
+		Delivery:      newRetryDelivery(3, eventingduckv1.BackoffPolicyLinear, "10s"),
+	}}
+	failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	// The error is the message of the NotReady status of the subscriber.
+	if err := failed[channel.Spec.Subscribers[0]]; err == nil || !strings.Contains(err.Error(), "backoffDelay") {
+		t.Errorf("UpdateSubscriptions() = %v, want the subscription failed on its invalid backoff delay", failed)
+	}
+}
+
+func TestAckWaitOf(t *testing.T) {
+	testCases := map[string]struct {
+		wait     time.Duration
+		delivery *eventingduckv1.DeliverySpec
+		want     time.Duration
+	}{
+		"no retry": {
+			wait: defaultAckWait,
+			want: defaultAckWait,
+		},
+		"exponential": {
+			wait:     defaultAckWait,
+			delivery: newRetryDelivery(3, eventingduckv1.BackoffPolicyExponential, "PT1S"),
+			want:     defaultAckWait + 7*time.Second,
+		},
+		"linear": {
+			wait:     defaultAckWait,
+			delivery: newRetryDelivery(3, eventingduckv1.BackoffPolicyLinear, "PT1S"),
+			want:     defaultAckWait + 3*time.Second,
+		},
+		"configured ack wait": {
+			wait:     5 * time.Minute,
+			delivery: newRetryDelivery(3, eventingduckv1.BackoffPolicyLinear, "PT1S"),
+			want:     5*time.Minute + 3*time.Second,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, _ := newTestSupervisor(t)
+			retry, err := s.retryConfig(context.Background(), subscriptionReference{Delivery: tc.delivery})
+			if err != nil {
+				t.Fatalf("retryConfig() = %v", err)
+			}
+			if got := ackWaitOf(tc.wait, retry); got != tc.want {
+				t.Errorf("ackWaitOf() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
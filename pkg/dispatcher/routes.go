@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net"
+	"strings"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+// channelRoute holds what the receiver needs to publish the events of a channel.
+type channelRoute struct {
+	channel eventingchannels.ChannelReference
+	// subject is the NATSS subject of the channel, built once rather than for every event.
+	subject string
+}
+
+// channelRoutes is an immutable snapshot of the channels served by the receiver. Every update of
+// the channels stores a new snapshot, so that the receiver looks the channels up without locking
+// nor allocating while the reconciler updates them.
+type channelRoutes struct {
+	byHost    map[string]*channelRoute
+	byChannel map[eventingchannels.ChannelReference]*channelRoute
+}
+
+func newChannelRoutes(hcMap map[string]eventingchannels.ChannelReference) *channelRoutes {
+	r := &channelRoutes{
+		byHost:    make(map[string]*channelRoute, len(hcMap)),
+		byChannel: make(map[eventingchannels.ChannelReference]*channelRoute, len(hcMap)),
+	}
+	for host, channel := range hcMap {
+		route, ok := r.byChannel[channel]
+		if !ok {
+			route = &channelRoute{channel: channel, subject: getSubject(channel)}
+			r.byChannel[channel] = route
+		}
+		r.byHost[normalizeHost(host)] = route
+	}
+	return r
+}
+
+// normalizeHost returns host in the form of the keys of the routes, so that the Host headers
+// match the addresses of the channels however they are written: hostnames are in lower case, the
+// default HTTP and HTTPS ports are removed, and IPv6 literals are bracketed in their canonical
+// form, without the zone identifier that the clients do not send. The common hostnames without a
+// port are returned as is, without allocating.
+func normalizeHost(host string) string {
+	if !strings.ContainsAny(host, ":[") {
+		return strings.ToLower(host)
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// An IPv6 literal without port, bracketed or not.
+		name, port = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), ""
+	}
+	if port == "80" || port == "443" {
+		port = ""
+	}
+	if i := strings.IndexByte(name, '%'); i >= 0 {
+		name = name[:i]
+	}
+	switch ip := net.ParseIP(name); {
+	case ip == nil:
+		name = strings.ToLower(name)
+	case ip.To4() == nil:
+		name = "[" + ip.String() + "]"
+	default:
+		// Including the IPv4-mapped IPv6 addresses.
+		name = ip.String()
+	}
+	if port == "" {
+		return name
+	}
+	return name + ":" + port
+}
+
+// hostToChannelMap returns a copy of the host to channel map of the snapshot.
+func (r *channelRoutes) hostToChannelMap() map[string]eventingchannels.ChannelReference {
+	hcMap := make(map[string]eventingchannels.ChannelReference, len(r.byHost))
+	for host, route := range r.byHost {
+		hcMap[host] = route.channel
+	}
+	return hcMap
+}
+
+func (s *SubscriptionsSupervisor) getRoutes() *channelRoutes {
+	return s.routes.Load().(*channelRoutes)
+}
+
+func (s *SubscriptionsSupervisor) setRoutes(hcMap map[string]eventingchannels.ChannelReference) {
+	s.routes.Store(newChannelRoutes(hcMap))
+	runtimemetrics.RecordStructures(s.hostMapSize())
+}
+
+// subject returns the NATSS subject of channel, from the snapshot when the channel is served.
+func (s *SubscriptionsSupervisor) subject(channel eventingchannels.ChannelReference) string {
+	if route, ok := s.getRoutes().byChannel[channel]; ok {
+		return route.subject
+	}
+	return getSubject(channel)
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+)
+
+// routedChannels returns n channels with a distinct host each.
+func routedChannels(n int) []messagingv1.Channel {
+	channels := make([]messagingv1.Channel, n)
+	for i := range channels {
+		c := &channels[i]
+		c.Namespace = "ns"
+		c.Name = fmt.Sprintf("channel-%d", i)
+		c.Status.Address = &duckv1.Addressable{
+			URL: apis.HTTP(fmt.Sprintf("%s-kn-channel.ns.svc.cluster.local", c.Name)),
+		}
+	}
+	return channels
+}
+
+func newRoutedSupervisor(tb testing.TB, channels []messagingv1.Channel) *SubscriptionsSupervisor {
+	s := &SubscriptionsSupervisor{logger: zap.NewNop()}
+	s.setRoutes(map[string]eventingchannels.ChannelReference{})
+	if err := s.ProcessChannels(context.Background(), channels); err != nil {
+		tb.Fatalf("ProcessChannels() = %v", err)
+	}
+	return s
+}
+
+func TestChannelRoutes(t *testing.T) {
+	channels := routedChannels(2)
+	s := newRoutedSupervisor(t, channels)
+
+	for _, c := range channels {
+		want := eventingchannels.ChannelReference{Namespace: c.Namespace, Name: c.Name}
+		got, err := s.getChannelReferenceFromHost(c.Status.Address.URL.Host)
+		if err != nil || got != want {
+			t.Errorf("getChannelReferenceFromHost(%q) = %v, %v, want %v", c.Status.Address.URL.Host, got, err, want)
+		}
+		if got := s.subject(want); got != getSubject(want) {
+			t.Errorf("subject(%v) = %q, want %q", want, got, getSubject(want))
+		}
+	}
+
+	// The channels which are not served still get their subject.
+	other := eventingchannels.ChannelReference{Namespace: "ns", Name: "other"}
+	if got := s.subject(other); got != "other.ns" {
+		t.Errorf("subject(%v) = %q, want %q", other, got, "other.ns")
+	}
+
+	// The map handed out is a copy.
+	hcMap := s.HostToChannelMap()
+	delete(hcMap, channels[0].Status.Address.URL.Host)
+	if _, err := s.getChannelReferenceFromHost(channels[0].Status.Address.URL.Host); err != nil {
+		t.Errorf("getChannelReferenceFromHost() = %v after changing the map handed out", err)
+	}
+}
+
+func TestChannelRoutesConcurrentUpdates(t *testing.T) {
+	channels := routedChannels(20)
+	s := newRoutedSupervisor(t, channels)
+	host := channels[0].Status.Address.URL.Host
+	want := eventingchannels.ChannelReference{Namespace: channels[0].Namespace, Name: channels[0].Name}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// The first channel is in every snapshot.
+				if got, err := s.getChannelReferenceFromHost(host); err != nil || got != want {
+					t.Errorf("getChannelReferenceFromHost(%q) = %v, %v, want %v", host, got, err, want)
+					return
+				}
+				if got := s.subject(want); got != getSubject(want) {
+					t.Errorf("subject(%v) = %q, want %q", want, got, getSubject(want))
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 200; i++ {
+		if err := s.ProcessChannels(context.Background(), channels[:1+i%len(channels)]); err != nil {
+			t.Fatalf("ProcessChannels() = %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestChannelLookupAllocations(t *testing.T) {
+	channels := routedChannels(100)
+	s := newRoutedSupervisor(t, channels)
+	host := channels[42].Status.Address.URL.Host
+	allocs := testing.AllocsPerRun(100, func() {
+		channel, _ := s.getChannelReferenceFromHost(host)
+		_ = s.subject(channel)
+	})
+	if allocs != 0 {
+		t.Errorf("looking a channel up allocates %v times, want 0", allocs)
+	}
+}
+
+// subjectSink keeps the subjects looked up by the benchmarks escaping, as they do in the receiver.
+var (
+	subjectSinkMu sync.Mutex
+	subjectSink   string
+)
+
+func keepSubject(subject string) {
+	subjectSinkMu.Lock()
+	subjectSink = subject
+	subjectSinkMu.Unlock()
+}
+
+// BenchmarkChannelLookup compares the lookup of the channel and its subject by the receiver,
+// "assembled" building the subject for every event as the receiver used to.
+func BenchmarkChannelLookup(b *testing.B) {
+	channels := routedChannels(1000)
+	s := newRoutedSupervisor(b, channels)
+	hosts := make([]string, len(channels))
+	for i, c := range channels {
+		hosts[i] = c.Status.Address.URL.Host
+	}
+
+	b.Run("assembled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			var subject string
+			i := 0
+			for pb.Next() {
+				channel, _ := s.getChannelReferenceFromHost(hosts[i%len(hosts)])
+				subject = getSubject(channel)
+				i++
+			}
+			keepSubject(subject)
+		})
+	})
+	b.Run("snapshot", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			var subject string
+			i := 0
+			for pb.Next() {
+				channel, _ := s.getChannelReferenceFromHost(hosts[i%len(hosts)])
+				subject = s.subject(channel)
+				i++
+			}
+			keepSubject(subject)
+		})
+	})
+	// The allocations reported include those of the updates, the lookups making none.
+	b.Run("snapshot with updates", func(b *testing.B) {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_ = s.ProcessChannels(context.Background(), channels)
+				}
+			}
+		}()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			var subject string
+			i := 0
+			for pb.Next() {
+				channel, _ := s.getChannelReferenceFromHost(hosts[i%len(hosts)])
+				subject = s.subject(channel)
+				i++
+			}
+			keepSubject(subject)
+		})
+	})
+}
+
+func TestNormalizeHost(t *testing.T) {
+	testCases := map[string]string{
+		"channel-kn-channel.ns.svc.cluster.local":    "channel-kn-channel.ns.svc.cluster.local",
+		"Channel-KN-Channel.ns.svc.cluster.local:80": "channel-kn-channel.ns.svc.cluster.local",
+		"channel.ns.svc.cluster.local:443":           "channel.ns.svc.cluster.local",
+		"channel.ns.svc.cluster.local:8080":          "channel.ns.svc.cluster.local:8080",
+		"192.0.2.1":                                  "192.0.2.1",
+		"192.0.2.1:80":                               "192.0.2.1",
+		"192.0.2.1:8080":                             "192.0.2.1:8080",
+		"[2001:db8::1]":                              "[2001:db8::1]",
+		"[2001:DB8:0:0::1]:80":                       "[2001:db8::1]",
+		"[2001:db8::1]:8080":                         "[2001:db8::1]:8080",
+		"2001:db8::1":                                "[2001:db8::1]",
+		"[fe80::1%eth0]:8080":                        "[fe80::1]:8080",
+		"[::ffff:192.0.2.1]":                         "192.0.2.1",
+	}
+	for host, want := range testCases {
+		if got := normalizeHost(host); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestChannelRoutesIPv6(t *testing.T) {
+	channels := routedChannels(1)
+	channels[0].Status.Address.URL = apis.HTTP("[2001:db8::1]")
+	s := newRoutedSupervisor(t, channels)
+	want := eventingchannels.ChannelReference{Namespace: channels[0].Namespace, Name: channels[0].Name}
+
+	for _, host := range []string{"[2001:db8::1]", "[2001:db8::1]:80", "[2001:DB8:0::1]"} {
+		if got, err := s.getChannelReferenceFromHost(host); err != nil || got != want {
+			t.Errorf("getChannelReferenceFromHost(%q) = %v, %v, want %v", host, got, err, want)
+		}
+	}
+	if _, err := s.getChannelReferenceFromHost("[2001:db8::2]"); err == nil {
+		t.Error("getChannelReferenceFromHost() found a channel for another address")
+	}
+}
+
+func TestDeliverToIPv6Subscriber(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	subscriber := newEventRecorder()
+	subscriber.Close()
+	subscriber.Server = httptest.NewUnstartedServer(subscriber.Config.Handler)
+	subscriber.Listener = listener
+	subscriber.Start()
+	defer subscriber.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	for n, host := range map[string]string{
+		"ipv6":      "[::1]:" + port,
+		"ipv6 zone": "[::1%lo]:" + port,
+	} {
+		t.Run(n, func(t *testing.T) {
+			if n == "ipv6 zone" {
+				if _, err := net.InterfaceByName("lo"); err != nil {
+					t.Skip("no loopback interface named lo")
+				}
+			}
+			s, _ := newTestSupervisor(t)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			channel := newTestChannel(ref, subscriber)
+			channel.Spec.Subscribers[0].SubscriberURI = apis.HTTP(host)
+			if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+			before := len(subscriber.received())
+			publishTestEvent(t, s, ref, n)
+			if got := subscriber.received(); len(got) != before+1 || got[before] != n {
+				t.Errorf("received %v, want %q delivered to %s", got, n, host)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// StoragePressure is how full the store of NATSS is.
+type StoragePressure int
+
+const (
+	// StoragePressureNone is the pressure of a store below the warning threshold, or whose
+	// utilization is unknown.
+	StoragePressureNone StoragePressure = iota
+	// StoragePressureWarning is the pressure of a store above the warning threshold: the receiver
+	// warns the producers.
+	StoragePressureWarning
+	// StoragePressureCritical is the pressure of a store above the critical threshold: the
+	// receiver refuses the events of the channels shedding on pressure, keeping the room left to
+	// the others.
+	StoragePressureCritical
+)
+
+func (p StoragePressure) String() string {
+	switch p {
+	case StoragePressureWarning:
+		return "warning"
+	case StoragePressureCritical:
+		return "critical"
+	default:
+		return "none"
+	}
+}
+
+// StoragePressureSetter is implemented by the dispatchers whose receiver degrades gracefully when
+// the store of NATSS is nearly full.
+type StoragePressureSetter interface {
+	// SetStoragePressure sets the pressure of the store, and the percentage of it in use.
+	SetStoragePressure(pressure StoragePressure, percent float64)
+	// SetShedOnPressure sets whether the events of channel are refused under critical pressure.
+	SetShedOnPressure(channel eventingchannels.ChannelReference, shed bool)
+}
+
+var _ StoragePressureSetter = (*SubscriptionsSupervisor)(nil)
+
+// storagePressure is the pressure of the store with the percentage of it in use.
+type storagePressure struct {
+	pressure StoragePressure
+	percent  float64
+}
+
+// SetStoragePressure implements StoragePressureSetter.
+func (s *SubscriptionsSupervisor) SetStoragePressure(pressure StoragePressure, percent float64) {
+	s.storagePressure.Store(storagePressure{pressure: pressure, percent: percent})
+}
+
+// SetShedOnPressure implements StoragePressureSetter.
+func (s *SubscriptionsSupervisor) SetShedOnPressure(channel eventingchannels.ChannelReference, shed bool) {
+	if !shed {
+		s.shedOnPressure.Delete(channel)
+		return
+	}
+	s.shedOnPressure.Store(channel, struct{}{})
+}
+
+func (s *SubscriptionsSupervisor) getStoragePressure() storagePressure {
+	p, _ := s.storagePressure.Load().(storagePressure)
+	return p
+}
+
+// withStoragePressure returns a handler passing the events to next with a Warning header while the
+// store is under pressure, and answering 507 Insufficient Storage to the events of the channels
+// shedding on pressure while it is critical.
+func (s *SubscriptionsSupervisor) withStoragePressure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := s.getStoragePressure()
+		if p.pressure == StoragePressureNone || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The warn code 199 is the miscellaneous warning, - stands for the unknown agent.
+		w.Header().Set("Warning", fmt.Sprintf("199 - %q", fmt.Sprintf("the NATSS store is %.1f%% full", p.percent)))
+		if p.pressure == StoragePressureCritical {
+			if channel, err := s.getChannelReferenceFromHost(r.Host); err == nil {
+				if _, shed := s.shedOnPressure.Load(channel); shed {
+					s.receiverLogger.Debug("Event shed under critical storage pressure", zap.String("channel", channel.String()))
+					http.Error(w, "the NATSS store is nearly full, the events of the channel are shed", http.StatusInsufficientStorage)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+func TestWithStoragePressure(t *testing.T) {
+	shedRef := eventingchannels.ChannelReference{Namespace: "ns", Name: "shed"}
+	keptRef := eventingchannels.ChannelReference{Namespace: "ns", Name: "kept"}
+	const (
+		shedHost = "shed.ns.svc.cluster.local"
+		keptHost = "kept.ns.svc.cluster.local"
+	)
+
+	testCases := map[string]struct {
+		pressure    StoragePressure
+		percent     float64
+		method      string
+		host        string
+		want        int
+		wantWarning string
+	}{
+		"no pressure": {
+			host: shedHost,
+			want: http.StatusAccepted,
+		},
+		"warning": {
+			pressure:    StoragePressureWarning,
+			percent:     85,
+			host:        shedHost,
+			want:        http.StatusAccepted,
+			wantWarning: `199 - "the NATSS store is 85.0% full"`,
+		},
+		"critical, shed": {
+			pressure:    StoragePressureCritical,
+			percent:     97.5,
+			host:        shedHost,
+			want:        http.StatusInsufficientStorage,
+			wantWarning: `199 - "the NATSS store is 97.5% full"`,
+		},
+		"critical, kept": {
+			pressure:    StoragePressureCritical,
+			percent:     97.5,
+			host:        keptHost,
+			want:        http.StatusAccepted,
+			wantWarning: `199 - "the NATSS store is 97.5% full"`,
+		},
+		"critical, unknown host": {
+			pressure:    StoragePressureCritical,
+			percent:     97.5,
+			host:        "unknown.ns.svc.cluster.local",
+			want:        http.StatusAccepted,
+			wantWarning: `199 - "the NATSS store is 97.5% full"`,
+		},
+		"critical, not an event": {
+			pressure: StoragePressureCritical,
+			percent:  97.5,
+			method:   http.MethodOptions,
+			host:     shedHost,
+			want:     http.StatusAccepted,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s, _ := newTestSupervisor(t)
+			s.setRoutes(map[string]eventingchannels.ChannelReference{shedHost: shedRef, keptHost: keptRef})
+			s.SetShedOnPressure(shedRef, true)
+			s.SetShedOnPressure(keptRef, true)
+			s.SetShedOnPressure(keptRef, false)
+			s.SetStoragePressure(tc.pressure, tc.percent)
+
+			passed := false
+			handler := s.withStoragePressure(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				passed = true
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "http://"+tc.host+"/", nil))
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if passed != (tc.want == http.StatusAccepted) {
+				t.Errorf("passed to the receiver = %t, want %t", passed, !passed)
+			}
+			if got := rec.Header().Get("Warning"); got != tc.wantWarning {
+				t.Errorf("Warning = %q, want %q", got, tc.wantWarning)
+			}
+		})
+	}
+}
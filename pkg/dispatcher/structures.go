@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+// The names of the in-memory structures whose size is recorded.
+const (
+	// hostMapEntries is the number of hosts the receiver routes.
+	hostMapEntries = "host_map_entries"
+	// subscriptionEntries is the number of subscriptions the dispatcher registered, whatever
+	// their number of consumers.
+	subscriptionEntries = "subscription_entries"
+	// bufferedBytes is the size of the events being dispatched.
+	bufferedBytes = "buffered_bytes"
+)
+
+// StructureSizer is implemented by the dispatchers reporting the sizes of their in-memory
+// structures.
+type StructureSizer interface {
+	// Structures returns the sizes of the in-memory structures of the receiver and of the
+	// dispatcher.
+	Structures() []runtimemetrics.Structure
+}
+
+var _ StructureSizer = (*SubscriptionsSupervisor)(nil)
+
+// Structures implements StructureSizer.
+func (s *SubscriptionsSupervisor) Structures() []runtimemetrics.Structure {
+	s.subscriptionsMux.Lock()
+	subscriptions := s.subscriptionEntriesSize()
+	s.subscriptionsMux.Unlock()
+	return []runtimemetrics.Structure{
+		s.hostMapSize(),
+		subscriptions,
+		{Component: runtimemetrics.Dispatcher, Name: bufferedBytes, Size: s.buffer.bufferedBytes()},
+	}
+}
+
+// hostMapSize returns the size of the host map of the current routes.
+func (s *SubscriptionsSupervisor) hostMapSize() runtimemetrics.Structure {
+	return runtimemetrics.Structure{Component: runtimemetrics.Receiver, Name: hostMapEntries, Size: int64(len(s.getRoutes().byHost))}
+}
+
+// subscriptionEntriesSize returns the size of the registry of the subscriptions. It must be
+// called holding subscriptionsMux.
+func (s *SubscriptionsSupervisor) subscriptionEntriesSize() runtimemetrics.Structure {
+	entries := 0
+	for _, subs := range s.subscriptions {
+		entries += len(subs)
+	}
+	return runtimemetrics.Structure{Component: runtimemetrics.Dispatcher, Name: subscriptionEntries, Size: int64(entries)}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+func TestStructures(t *testing.T) {
+	subscriber := newEventRecorder()
+	defer subscriber.Close()
+
+	s, _ := newTestSupervisor(t)
+	orders := eventingchannels.ChannelReference{Namespace: "ns", Name: "orders"}
+	invoices := eventingchannels.ChannelReference{Namespace: "ns", Name: "invoices"}
+	sizes := func() map[string]int64 {
+		got := make(map[string]int64)
+		for _, st := range s.Structures() {
+			got[st.Component+"/"+st.Name] = st.Size
+		}
+		return got
+	}
+	want := func(hosts, subscriptions int64) map[string]int64 {
+		return map[string]int64{
+			runtimemetrics.Receiver + "/" + hostMapEntries:        hosts,
+			runtimemetrics.Dispatcher + "/" + subscriptionEntries: subscriptions,
+			runtimemetrics.Dispatcher + "/" + bufferedBytes:       0,
+		}
+	}
+	if diff := cmp.Diff(want(0, 0), sizes()); diff != "" {
+		t.Errorf("Structures() before the channels (-want, +got) = %s", diff)
+	}
+
+	// The host map follows the channels, the registry their subscriptions.
+	if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{newChannel(orders), newChannel(invoices)}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	for _, c := range []*messagingv1.Channel{newTestChannel(orders, subscriber, subscriber), newTestChannel(invoices, subscriber)} {
+		if _, err := s.UpdateSubscriptions(context.Background(), c, false); err != nil {
+			t.Fatalf("UpdateSubscriptions() = %v", err)
+		}
+	}
+	if diff := cmp.Diff(want(2, 3), sizes()); diff != "" {
+		t.Errorf("Structures() with the channels (-want, +got) = %s", diff)
+	}
+
+	if _, err := s.UpdateSubscriptions(context.Background(), newTestChannel(orders, subscriber, subscriber), true); err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	if err := s.ProcessChannels(context.Background(), []messagingv1.Channel{newChannel(invoices)}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	if diff := cmp.Diff(want(1, 1), sizes()); diff != "" {
+		t.Errorf("Structures() once a channel is removed (-want, +got) = %s", diff)
+	}
+}
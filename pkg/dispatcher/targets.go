@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"net/url"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+// subscriptionTarget is where a running subscription delivers its events. The subscriptions are
+// identified by their UID, which names their durable, so that a change of the subscriber, reply or
+// dead letter sink URI, such as of its path or query, updates the target in place instead of making
+// another durable.
+type subscriptionTarget struct {
+	// uris holds the targetURIs the events are delivered to.
+	uris atomic.Value
+}
+
+// targetURIs are the URIs of the spec of a subscription the events are delivered to.
+type targetURIs struct {
+	subscriber *apis.URL
+	reply      *apis.URL
+	// deadLetter is the dead letter sink the events failed to deliver are sent to, nil when none.
+	deadLetter *apis.URL
+}
+
+func newSubscriptionTarget(subscription subscriptionReference) *subscriptionTarget {
+	t := &subscriptionTarget{}
+	t.uris.Store(targetURIsOf(subscription))
+	return t
+}
+
+func targetURIsOf(subscription subscriptionReference) targetURIs {
+	return targetURIs{subscriber: subscription.SubscriberURI, reply: subscription.ReplyURI, deadLetter: (*apis.URL)(deadLetterSink(subscription))}
+}
+
+// apply returns subscription with the URIs of the target.
+func (t *subscriptionTarget) apply(subscription subscriptionReference) subscriptionReference {
+	uris := t.uris.Load().(targetURIs)
+	subscription.SubscriberURI, subscription.ReplyURI = uris.subscriber, uris.reply
+	return subscription
+}
+
+// deadLetterSink returns the URI of the dead letter sink of the target, nil when it has none.
+func (t *subscriptionTarget) deadLetterSink() *url.URL {
+	if uri := t.uris.Load().(targetURIs).deadLetter; uri != nil {
+		return uri.URL()
+	}
+	return nil
+}
+
+// update sets the URIs of the target to the ones of spec, returning whether they changed.
+func (t *subscriptionTarget) update(spec eventingduckv1.SubscriberSpec) bool {
+	uris, updated := t.uris.Load().(targetURIs), targetURIsOf(subscriptionReference(spec))
+	if uris.subscriber.String() == updated.subscriber.String() && uris.reply.String() == updated.reply.String() &&
+		uris.deadLetter.String() == updated.deadLetter.String() {
+		return false
+	}
+	t.uris.Store(updated)
+	return true
+}
+
+// retarget updates the target of the running subscription of spec to its URIs, including the
+// one of its dead letter sink.
+func (s *SubscriptionsSupervisor) retarget(channel eventingchannels.ChannelReference, spec eventingduckv1.SubscriberSpec) {
+	v, ok := s.targets.Load(spec.UID)
+	if !ok {
+		return
+	}
+	if target := v.(*subscriptionTarget); target.update(spec) {
+		s.subscriptionsLogger.Info("Subscription retargeted", zap.String("channel", channel.String()), zap.String("subscription", string(spec.UID)),
+			zap.Stringer("subscriberURI", spec.SubscriberURI), zap.Stringer("replyURI", spec.ReplyURI),
+			zap.Stringer("deadLetterSinkURI", target.deadLetterSink()))
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+func TestRetarget(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests = append(requests, req.URL.RequestURI())
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer subscriber.Close()
+
+	testCases := map[string]struct {
+		before, after string
+	}{
+		"path only":    {before: "/v1/events", after: "/v2/events"},
+		"query string": {before: "/v1/events?tenant=a", after: "/v1/events?tenant=b"},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			mu.Lock()
+			requests = nil
+			mu.Unlock()
+			s, conn := newTestSupervisor(t)
+			ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+			channel := newTestChannel(ref)
+			channel.Spec.Subscribers = append(channel.Spec.Subscribers, subscriberTo(t, subscriber.URL+tc.before))
+			if _, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil {
+				t.Fatalf("UpdateSubscriptions() = %v", err)
+			}
+			conn.publish(newTestEventMsg(t, "1"))
+
+			channel = channel.DeepCopy()
+			channel.Spec.Subscribers[0] = subscriberTo(t, subscriber.URL+tc.after)
+			failed, err := s.UpdateSubscriptions(context.Background(), channel, false)
+			if err != nil || len(failed) != 0 {
+				t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+			}
+			conn.publish(newTestEventMsg(t, "2"))
+
+			// The subscription and its durable are kept, only its target changing.
+			if len(conn.subs) != 1 || conn.subs[0].durable != "uid" {
+				t.Errorf("got %d subscriptions, want the one of the durable uid", len(conn.subs))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if diff := cmp.Diff([]string{tc.before, tc.after}, requests); diff != "" {
+				t.Errorf("requests (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func subscriberTo(t *testing.T, uri string) eventingduckv1.SubscriberSpec {
+	t.Helper()
+	u, err := apis.ParseURL(uri)
+	if err != nil {
+		t.Fatalf("apis.ParseURL() = %v", err)
+	}
+	return eventingduckv1.SubscriberSpec{UID: "uid", SubscriberURI: u}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	gotesting "testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// Conformance describes a NatssDispatcher implementation run through RunConformance.
+type Conformance struct {
+	// New returns a new dispatcher, ready to subscribe.
+	New func(t *gotesting.T) dispatcher.NatssDispatcher
+	// Subscribed returns the UIDs of the subscribers of channel which d subscribed, nil when the
+	// subscriptions of the implementation cannot be observed.
+	Subscribed func(d dispatcher.NatssDispatcher, channel eventingchannels.ChannelReference) []types.UID
+	// FailsSubscriptions is set for the implementations failing every subscription, whose
+	// failures are checked instead of their subscriptions.
+	FailsSubscriptions bool
+	// FailsFinalizations is set for the implementations unable to reach NATSS, whose
+	// finalizations fail for the channels to be finalized again rather than leak their durables.
+	FailsFinalizations bool
+}
+
+// RunConformance runs the tests every NatssDispatcher implementation must pass: the host
+// registration and lookup, the updates of the subscriptions, the cleanup of the finalized
+// channels, the shape of the failures and the concurrent use.
+func RunConformance(t *gotesting.T, c Conformance) {
+	t.Run("hosts", func(t *gotesting.T) { testHosts(t, c) })
+	t.Run("update subscriptions", func(t *gotesting.T) { testUpdateSubscriptions(t, c) })
+	t.Run("finalize", func(t *gotesting.T) { testFinalize(t, c) })
+	t.Run("concurrency", func(t *gotesting.T) { testConcurrency(t, c) })
+}
+
+// ConformanceChannel returns a channel addressed at <name>.<namespace>.svc.cluster.local, with a
+// subscriber for each of uids.
+func ConformanceChannel(namespace, name string, uids ...types.UID) *messagingv1.Channel {
+	c := &messagingv1.Channel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status: messagingv1.ChannelStatus{
+			ChannelableStatus: eventingduckv1.ChannelableStatus{
+				AddressStatus: duckv1.AddressStatus{
+					Address: &duckv1.Addressable{
+						URL: &apis.URL{Scheme: "http", Host: name + "." + namespace + ".svc.cluster.local"},
+					},
+				},
+			},
+		},
+	}
+	for i, uid := range uids {
+		c.Spec.Subscribers = append(c.Spec.Subscribers, eventingduckv1.SubscriberSpec{
+			UID:           uid,
+			Generation:    int64(i + 1),
+			SubscriberURI: apis.HTTP(fmt.Sprintf("subscriber-%d.%s.svc.cluster.local", i, namespace)),
+		})
+	}
+	return c
+}
+
+func testHosts(t *gotesting.T, c Conformance) {
+	ctx := context.Background()
+	d := c.New(t)
+	first, second := ConformanceChannel("ns", "first"), ConformanceChannel("ns", "second")
+	if err := d.ProcessChannels(ctx, []messagingv1.Channel{*first, *second}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	if err := d.ProcessChannels(ctx, nil); err != nil {
+		t.Fatalf("ProcessChannels(nil) = %v", err)
+	}
+
+	mapper, ok := d.(dispatcher.HostToChannelMapper)
+	if !ok {
+		// The hosts cannot be looked up.
+		return
+	}
+	if err := d.ProcessChannels(ctx, []messagingv1.Channel{*first, *second}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	want := map[string]eventingchannels.ChannelReference{
+		"first.ns.svc.cluster.local":  {Namespace: "ns", Name: "first"},
+		"second.ns.svc.cluster.local": {Namespace: "ns", Name: "second"},
+	}
+	if diff := cmp.Diff(want, mapper.HostToChannelMap()); diff != "" {
+		t.Errorf("HostToChannelMap() (-want, +got) = %s", diff)
+	}
+
+	// A host claimed by two channels is refused, the previous hosts being kept.
+	duplicate := ConformanceChannel("other", "first")
+	duplicate.Status.Address.URL = first.Status.Address.URL
+	if err := d.ProcessChannels(ctx, []messagingv1.Channel{*first, *duplicate}); err == nil {
+		t.Error("ProcessChannels() succeeded with a duplicate host, want an error")
+	}
+	if diff := cmp.Diff(want, mapper.HostToChannelMap()); diff != "" {
+		t.Errorf("HostToChannelMap() after a duplicate host (-want, +got) = %s", diff)
+	}
+
+	// The removed channels are not served anymore.
+	if err := d.ProcessChannels(ctx, []messagingv1.Channel{*second}); err != nil {
+		t.Fatalf("ProcessChannels() = %v", err)
+	}
+	delete(want, "first.ns.svc.cluster.local")
+	if diff := cmp.Diff(want, mapper.HostToChannelMap()); diff != "" {
+		t.Errorf("HostToChannelMap() after a removal (-want, +got) = %s", diff)
+	}
+}
+
+// update updates the subscriptions of channel, and checks the failures and the subscriptions.
+func update(t *gotesting.T, c Conformance, d dispatcher.NatssDispatcher, channel *messagingv1.Channel, isFinalizer bool) {
+	t.Helper()
+	failed, err := d.UpdateSubscriptions(context.Background(), channel, isFinalizer)
+	if isFinalizer && c.FailsFinalizations {
+		if err == nil {
+			t.Error("UpdateSubscriptions() succeeded to finalize, want an error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("UpdateSubscriptions() = %v", err)
+	}
+	checkFailures(t, c, channel, isFinalizer, failed)
+
+	if c.Subscribed == nil {
+		return
+	}
+	var want []types.UID
+	if !isFinalizer && !c.FailsSubscriptions {
+		for _, sub := range channel.Spec.Subscribers {
+			want = append(want, sub.UID)
+		}
+	}
+	got := c.Subscribed(d, eventingchannels.ChannelReference{Namespace: channel.Namespace, Name: channel.Name})
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("subscribed (-want, +got) = %s", diff)
+	}
+}
+
+// checkFailures checks that the failures are keyed by the subscribers of channel, which the
+// reconcilers look them up with, and hold an error.
+func checkFailures(t *gotesting.T, c Conformance, channel *messagingv1.Channel, isFinalizer bool, failed map[eventingduckv1.SubscriberSpec]error) {
+	t.Helper()
+	subscribers := make(map[eventingduckv1.SubscriberSpec]bool, len(channel.Spec.Subscribers))
+	for _, sub := range channel.Spec.Subscribers {
+		subscribers[sub] = true
+	}
+	for spec, err := range failed {
+		if !subscribers[spec] {
+			t.Errorf("failure keyed by %+v, which is not a subscriber of the channel", spec)
+		}
+		if err == nil {
+			t.Errorf("failure of %s without error", spec.UID)
+		}
+	}
+	want := 0
+	if c.FailsSubscriptions && !isFinalizer {
+		want = len(channel.Spec.Subscribers)
+	}
+	if !isFinalizer && len(failed) != want {
+		t.Errorf("%d failures, want %d", len(failed), want)
+	}
+}
+
+func testUpdateSubscriptions(t *gotesting.T, c Conformance) {
+	d := c.New(t)
+	// Added.
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-a", "uid-b"), false)
+	// Unchanged.
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-a", "uid-b"), false)
+	// Added and removed.
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-b", "uid-c"), false)
+	// The other channels are left alone.
+	update(t, c, d, ConformanceChannel("ns", "other", "uid-d"), false)
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-b", "uid-c"), false)
+	// All removed.
+	update(t, c, d, ConformanceChannel("ns", "channel"), false)
+	update(t, c, d, ConformanceChannel("ns", "other", "uid-d"), false)
+}
+
+func testFinalize(t *gotesting.T, c Conformance) {
+	d := c.New(t)
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-a", "uid-b"), false)
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-a", "uid-b"), true)
+	// Finalizing twice, or a channel never subscribed, is harmless.
+	update(t, c, d, ConformanceChannel("ns", "channel", "uid-a", "uid-b"), true)
+	update(t, c, d, ConformanceChannel("ns", "unknown"), true)
+}
+
+func testConcurrency(t *gotesting.T, c Conformance) {
+	ctx := context.Background()
+	d := c.New(t)
+	const channels = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*channels)
+	for i := 0; i < channels; i++ {
+		name := fmt.Sprintf("channel-%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, uids := range [][]types.UID{{"uid-a"}, {"uid-a", "uid-b"}, {"uid-b"}} {
+				subscribers := make([]types.UID, 0, len(uids))
+				for _, uid := range uids {
+					subscribers = append(subscribers, types.UID(name)+"-"+uid)
+				}
+				if _, err := d.UpdateSubscriptions(ctx, ConformanceChannel("ns", name, subscribers...), false); err != nil {
+					errs <- err
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			list := make([]messagingv1.Channel, 0, channels)
+			for j := 0; j < channels; j++ {
+				list = append(list, *ConformanceChannel("ns", fmt.Sprintf("channel-%d", j)))
+			}
+			if err := d.ProcessChannels(ctx, list); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent call failed: %v", err)
+	}
+
+	for i := 0; i < channels; i++ {
+		name := fmt.Sprintf("channel-%d", i)
+		update(t, c, d, ConformanceChannel("ns", name, types.UID(name)+"-uid-b"), false)
+		update(t, c, d, ConformanceChannel("ns", name, types.UID(name)+"-uid-b"), true)
+	}
+}
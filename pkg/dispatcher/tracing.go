@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/extensions"
+	"go.opencensus.io/trace"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/eventing/pkg/tracing"
+)
+
+// channelHopSpanName is the name of the span of the hop of an event through a channel, from its
+// storage in NATSS to its dispatch to a subscriber.
+const channelHopSpanName = "natss-channel-hop"
+
+// withTraceContext returns transformers with the one writing the trace context of ctx, the span
+// of the request to the receiver, into the events published to NATSS.
+func withTraceContext(ctx context.Context, transformers []binding.Transformer) []binding.Transformer {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return transformers
+	}
+	tc := extensions.FromSpanContext(span.SpanContext())
+	return append(transformers, tc.WriteTransformer())
+}
+
+// startChannelHopSpan starts the span of the dispatch of the event of data to subscription of
+// channel, in the trace the event was received with. Without trace context, such as for the events
+// stored before it was propagated, the span starts a new trace, sampled by sampler unless nil.
+func startChannelHopSpan(ctx context.Context, channel eventingchannels.ChannelReference, subscription types.UID, data []byte, sampler trace.Sampler) (context.Context, *trace.Span) {
+	attributes := []trace.Attribute{
+		tracing.MessagingSystemAttribute,
+		tracing.MessagingProtocolAttribute("NATSS"),
+		trace.StringAttribute(tracing.MessagingDestinationAttributeName, channel.String()),
+		trace.StringAttribute("natss.subscription", string(subscription)),
+	}
+	options := []trace.StartOption{trace.WithSpanKind(trace.SpanKindServer)}
+	if sampler != nil {
+		options = append(options, trace.WithSampler(sampler))
+	}
+	var span *trace.Span
+	if sc, ok := structuredTraceContext(data); ok {
+		ctx, span = trace.StartSpanWithRemoteParent(ctx, channelHopSpanName, sc, options...)
+	} else {
+		ctx, span = trace.StartSpan(ctx, channelHopSpanName, options...)
+	}
+	if span.IsRecordingEvents() {
+		if id := structuredEventID(data); id != "" {
+			attributes = append(attributes, tracing.MessagingMessageIDAttribute(id))
+		}
+		span.AddAttributes(attributes...)
+	}
+	return ctx, span
+}
+
+// structuredTraceContext returns the trace context of the event of a NATSS message, which is
+// always in the structured JSON format, false when it has none or it cannot be read.
+func structuredTraceContext(data []byte) (trace.SpanContext, bool) {
+	// The events without trace context are not parsed.
+	if !bytes.Contains(data, []byte(extensions.TraceParentExtension)) {
+		return trace.SpanContext{}, false
+	}
+	var e struct {
+		TraceParent string `json:"traceparent"`
+		TraceState  string `json:"tracestate"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil || e.TraceParent == "" {
+		return trace.SpanContext{}, false
+	}
+	sc, err := extensions.DistributedTracingExtension{TraceParent: e.TraceParent, TraceState: e.TraceState}.ToSpanContext()
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return sc, true
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceParent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	subscriber := &contractSink{Server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		received = append(received, req.Header.Get("traceparent"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))}
+	defer subscriber.Close()
+	channel := newContractChannel(t, subscriber, nil)
+	defer channel.Close()
+
+	req, err := http.NewRequest(http.MethodPost, channel.URL, strings.NewReader(`{"contract": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = contractChannelHost
+	for k, v := range binaryHeaders("1.0") {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("traceparent", traceParent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("the subscriber received %d events, want 1", len(received))
+	}
+	// The dispatch continues the trace, its parent being the span of the channel hop.
+	parts := strings.Split(received[0], "-")
+	if len(parts) != 4 || parts[1] != traceID {
+		t.Errorf("the subscriber received the traceparent %q, want one of the trace %s", received[0], traceID)
+	} else if received[0] == traceParent {
+		t.Errorf("the subscriber received the traceparent %q of the sender, want one of a span of the channel", received[0])
+	}
+}
+
+func TestStructuredTraceContext(t *testing.T) {
+	testCases := map[string]struct {
+		data      string
+		wantTrace string
+	}{
+		"trace context": {
+			data:      `{"id": "1", "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "tracestate": "vendor=value"}`,
+			wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		"no trace context": {
+			data: `{"id": "1"}`,
+		},
+		"invalid traceparent": {
+			data: `{"id": "1", "traceparent": "invalid"}`,
+		},
+		"not JSON": {
+			data: `traceparent`,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			sc, ok := structuredTraceContext([]byte(tc.data))
+			if ok != (tc.wantTrace != "") {
+				t.Fatalf("structuredTraceContext() = %v, want a trace context: %t", ok, tc.wantTrace != "")
+			}
+			if ok && sc.TraceID.String() != tc.wantTrace {
+				t.Errorf("trace ID = %s, want %s", sc.TraceID, tc.wantTrace)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// StanTransport is the name of the NATS Streaming transport.
+	StanTransport = "stan"
+	// JetStreamTransport is the name of the NATS JetStream transport.
+	JetStreamTransport = "jetstream"
+	// MemoryTransport is the name of the in-memory transport, which does not use NATS.
+	MemoryTransport = "memory"
+)
+
+// Factory creates a NatssDispatcher for a transport.
+type Factory func(args Args) (NatssDispatcher, error)
+
+var (
+	transportsMux sync.RWMutex
+	transports    = map[string]Factory{
+		StanTransport:      NewDispatcher,
+		JetStreamTransport: NewJetStreamDispatcher,
+		MemoryTransport:    NewMemoryDispatcher,
+	}
+)
+
+// RegisterTransport makes a transport available under the given name,
+// replacing any transport previously registered with the same name.
+func RegisterTransport(name string, factory Factory) {
+	transportsMux.Lock()
+	defer transportsMux.Unlock()
+	transports[name] = factory
+}
+
+// NewTransport creates a NatssDispatcher using the transport registered under
+// the given name.
+func NewTransport(name string, args Args) (NatssDispatcher, error) {
+	transportsMux.RLock()
+	factory, ok := transports[name]
+	transportsMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q, registered transports: %v", name, Transports())
+	}
+	return factory(args)
+}
+
+// Transports returns the sorted names of the registered transports.
+func Transports() []string {
+	transportsMux.RLock()
+	defer transportsMux.RUnlock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
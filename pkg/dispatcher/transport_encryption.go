@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// tlsHandshakeRecord is the first byte sent by the TLS clients, the content type of the
+	// record of their ClientHello.
+	tlsHandshakeRecord = 0x16
+
+	// sniffTimeout is how long an accepted connection may stay silent before its protocol is known.
+	sniffTimeout = 10 * time.Second
+)
+
+// errListenerClosed is returned by the Accept of a closed sniffingListener.
+var errListenerClosed = errors.New("listener closed")
+
+// TransportEncryptionSetter is implemented by the dispatchers following the transport encryption
+// mode of Knative Eventing.
+type TransportEncryptionSetter interface {
+	// SetTransportEncryption sets the transport encryption mode, which applies to the requests
+	// and deliveries that follow, without restarting the receiver.
+	SetTransportEncryption(mode security.TransportEncryption)
+}
+
+var _ TransportEncryptionSetter = (*SubscriptionsSupervisor)(nil)
+
+// SetTransportEncryption implements TransportEncryptionSetter.
+func (s *SubscriptionsSupervisor) SetTransportEncryption(mode security.TransportEncryption) {
+	if mode == "" {
+		mode = security.TransportEncryptionDisabled
+	}
+	if previous, _ := s.transportEncryption.Load().(security.TransportEncryption); previous == mode {
+		return
+	}
+	s.transportEncryption.Store(mode)
+	s.logger.Info("Transport encryption mode changed", zap.String("mode", string(mode)))
+	if mode.ServesHTTPS() && s.receiverTLS == nil {
+		s.logger.Warn("The transport encryption requires HTTPS but the receiver has no certificate", zap.String("mode", string(mode)))
+	}
+}
+
+func (s *SubscriptionsSupervisor) getTransportEncryption() security.TransportEncryption {
+	if mode, ok := s.transportEncryption.Load().(security.TransportEncryption); ok {
+		return mode
+	}
+	return security.TransportEncryptionDisabled
+}
+
+// refusesPlaintext returns whether the receiver refuses the requests over plain HTTP: in the
+// strict mode, and when the transport encryption is disabled but the receiver has a certificate,
+// which makes it serve HTTPS only as it always did.
+func (s *SubscriptionsSupervisor) refusesPlaintext() bool {
+	switch s.getTransportEncryption() {
+	case security.TransportEncryptionStrict:
+		return true
+	case security.TransportEncryptionPermissive:
+		return false
+	default:
+		return s.receiverTLS != nil
+	}
+}
+
+// refusePlaintext answers the requests over plain HTTP with 426 Upgrade Required when the
+// receiver refuses them, the others going through to next.
+func (s *SubscriptionsSupervisor) refusePlaintext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil && s.refusesPlaintext() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "the events must be sent over HTTPS", http.StatusUpgradeRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preferHTTPS returns the HTTPS address of destination when it is the plain HTTP address of a
+// channel of the dispatcher, which advertises its HTTPS addresses while the transport encryption
+// is permissive or strict. The other destinations are returned as is, the subscriptions resolving
+// the addresses they advertise.
+func (s *SubscriptionsSupervisor) preferHTTPS(destination *url.URL) *url.URL {
+	if destination == nil || destination.Scheme != "http" || s.receiverTLS == nil || !s.getTransportEncryption().ServesHTTPS() {
+		return destination
+	}
+	if port := destination.Port(); port != "" && port != "80" {
+		return destination
+	}
+	if _, ok := s.getRoutes().byHost[normalizeHost(destination.Host)]; !ok {
+		return destination
+	}
+	upgraded := *destination
+	upgraded.Scheme = "https"
+	upgraded.Host = strings.TrimSuffix(destination.Host, ":80")
+	return &upgraded
+}
+
+// sniffingListener serves both TLS and plain HTTP on the same port, telling the connections apart
+// by the first byte their client sends, so that the changes of the transport encryption mode
+// never restart the receiver.
+type sniffingListener struct {
+	net.Listener
+	config *tls.Config
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSniffingListener(inner net.Listener, config *tls.Config) net.Listener {
+	l := &sniffingListener{
+		Listener: inner,
+		config:   config,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *sniffingListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		// A slow client does not hold the others back.
+		go l.sniff(conn)
+	}
+}
+
+func (l *sniffingListener) sniff(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := r.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	var c net.Conn = &peekedConn{Conn: conn, r: r}
+	if first[0] == tlsHandshakeRecord {
+		c = tls.Server(c, l.config)
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection, a *tls.Conn for the TLS clients.
+func (l *sniffingListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *sniffingListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// peekedConn reads the bytes of Conn peeked by r first.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
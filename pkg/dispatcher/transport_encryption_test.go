@@ -0,0 +1,206 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+// serveTestReceiver serves a receiver answering 202 Accepted through the transport encryption of
+// s, returning its address and a client trusting its certificate.
+func serveTestReceiver(t *testing.T, s *SubscriptionsSupervisor) (string, *http.Client) {
+	timeout := receiverDrainTimeout
+	receiverDrainTimeout = 10 * time.Millisecond
+	// Borrow the certificate of a test server, and its client trusting it.
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	if s.receiverTLS != nil {
+		s.receiverTLS.Certificates = certServer.TLS.Certificates
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serve(ctx, listener, s.receiverTLS, s.refusePlaintext(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		})))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("serve() = %v", err)
+		}
+		receiverDrainTimeout = timeout
+	})
+	return listener.Addr().String(), certServer.Client()
+}
+
+func TestReceiverTransportEncryption(t *testing.T) {
+	testCases := map[string]struct {
+		certificate bool
+		// want are the status codes of the requests over HTTP and HTTPS by mode, zero for a
+		// failed connection.
+		want map[security.TransportEncryption][2]int
+	}{
+		"with certificate": {
+			certificate: true,
+			want: map[security.TransportEncryption][2]int{
+				// The receiver with a certificate has always refused plain HTTP.
+				security.TransportEncryptionDisabled:   {http.StatusUpgradeRequired, http.StatusAccepted},
+				security.TransportEncryptionPermissive: {http.StatusAccepted, http.StatusAccepted},
+				security.TransportEncryptionStrict:     {http.StatusUpgradeRequired, http.StatusAccepted},
+			},
+		},
+		"without certificate": {
+			want: map[security.TransportEncryption][2]int{
+				security.TransportEncryptionDisabled:   {http.StatusAccepted, 0},
+				security.TransportEncryptionPermissive: {http.StatusAccepted, 0},
+				security.TransportEncryptionStrict:     {http.StatusUpgradeRequired, 0},
+			},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s := &SubscriptionsSupervisor{logger: zap.NewNop()}
+			if tc.certificate {
+				s.receiverTLS = &tls.Config{}
+			}
+			address, client := serveTestReceiver(t, s)
+
+			// The modes change on the live receiver, back and forth, keeping the connections.
+			for _, mode := range []security.TransportEncryption{
+				security.TransportEncryptionDisabled,
+				security.TransportEncryptionPermissive,
+				security.TransportEncryptionStrict,
+				security.TransportEncryptionPermissive,
+				security.TransportEncryptionDisabled,
+			} {
+				s.SetTransportEncryption(mode)
+				for i, scheme := range []string{"http", "https"} {
+					got := 0
+					resp, err := client.Post(scheme+"://"+address, "application/json", nil)
+					if err == nil {
+						resp.Body.Close()
+						got = resp.StatusCode
+					}
+					if want := tc.want[mode][i]; got != want {
+						t.Errorf("%s over %s = %d (%v), want %d", mode, scheme, got, err, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSniffingListenerSilentClient(t *testing.T) {
+	s := &SubscriptionsSupervisor{logger: zap.NewNop(), receiverTLS: &tls.Config{}}
+	s.SetTransportEncryption(security.TransportEncryptionPermissive)
+	address, client := serveTestReceiver(t, s)
+
+	// A client which connects without sending anything does not hold the others back.
+	silent, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	client.Timeout = 5 * time.Second
+	resp, err := client.Post("http://"+address, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestPreferHTTPS(t *testing.T) {
+	channels := routedChannels(1)
+	host := channels[0].Status.Address.URL.Host
+	s := newRoutedSupervisor(t, channels)
+	s.receiverTLS = &tls.Config{}
+
+	testCases := map[string]struct {
+		mode        security.TransportEncryption
+		destination string
+		want        string
+	}{
+		"channel, disabled": {
+			mode:        security.TransportEncryptionDisabled,
+			destination: "http://" + host,
+			want:        "http://" + host,
+		},
+		"channel, permissive": {
+			mode:        security.TransportEncryptionPermissive,
+			destination: "http://" + host,
+			want:        "https://" + host,
+		},
+		"channel with default port, strict": {
+			mode:        security.TransportEncryptionStrict,
+			destination: "http://" + host + ":80/path",
+			want:        "https://" + host + "/path",
+		},
+		"channel with other port": {
+			mode:        security.TransportEncryptionStrict,
+			destination: "http://" + host + ":8080",
+			want:        "http://" + host + ":8080",
+		},
+		"other destination": {
+			mode:        security.TransportEncryptionStrict,
+			destination: "http://subscriber.ns.svc.cluster.local",
+			want:        "http://subscriber.ns.svc.cluster.local",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			s.SetTransportEncryption(tc.mode)
+			destination, err := url.Parse(tc.destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.preferHTTPS(destination).String(); got != tc.want {
+				t.Errorf("preferHTTPS(%s) = %s, want %s", tc.destination, got, tc.want)
+			}
+			if destination.String() != tc.destination {
+				t.Errorf("preferHTTPS() changed the destination to %s", destination)
+			}
+		})
+	}
+
+	if got := s.preferHTTPS(nil); got != nil {
+		t.Errorf("preferHTTPS(nil) = %v, want nil", got)
+	}
+	// Without certificate, the channels are not served over HTTPS.
+	s.receiverTLS = nil
+	destination := &url.URL{Scheme: "http", Host: host}
+	if got := s.preferHTTPS(destination); got != destination {
+		t.Errorf("preferHTTPS() = %v without receiver certificate, want %v", got, destination)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+)
+
+type fakeTransport struct {
+	args Args
+}
+
+func (*fakeTransport) Start(context.Context) error { return nil }
+
+func (*fakeTransport) UpdateSubscriptions(context.Context, *messagingv1.Channel, bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	return nil, nil
+}
+
+func (*fakeTransport) ProcessChannels(context.Context, []messagingv1.Channel) error { return nil }
+
+func TestNewTransport(t *testing.T) {
+	RegisterTransport("fake", func(args Args) (NatssDispatcher, error) {
+		return &fakeTransport{args: args}, nil
+	})
+
+	// Every transport accepted by the transport key of config-natss, and an invalid one.
+	testCases := map[string]struct {
+		transport string
+		wantType  NatssDispatcher
+		wantErr   bool
+	}{
+		"stan": {
+			transport: StanTransport,
+			wantType:  &SubscriptionsSupervisor{},
+		},
+		"jetstream": {
+			transport: JetStreamTransport,
+			wantType:  &JetStreamDispatcher{},
+		},
+		"memory": {
+			transport: MemoryTransport,
+			wantType:  &MemoryDispatcher{},
+		},
+		"registered": {
+			transport: "fake",
+			wantType:  &fakeTransport{},
+		},
+		"unknown": {
+			transport: "kafka",
+			wantErr:   true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			d, err := NewTransport(tc.transport, Args{ClientID: "test"})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewTransport(%q) error = %v, wantErr %v", tc.transport, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got, want := fmt.Sprintf("%T", d), fmt.Sprintf("%T", tc.wantType); got != want {
+				t.Fatalf("NewTransport(%q) = %s, want %s", tc.transport, got, want)
+			}
+			if f, ok := d.(*fakeTransport); ok && f.args.ClientID != "test" {
+				t.Errorf("args not passed to the factory: got %+v", f.args)
+			}
+		})
+	}
+}
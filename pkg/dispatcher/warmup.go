@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/metrics"
+)
+
+var (
+	// warmUpTimeout bounds the time spent pre-establishing a connection to a subscriber.
+	warmUpTimeout = 5 * time.Second
+
+	// firstDeliveryLatencyM records the latency of the first delivery to a subscriber
+	// after its subscription was created.
+	firstDeliveryLatencyM = stats.Float64(
+		"first_delivery_latency",
+		"Latency of the first delivery to a subscriber after its subscription was created",
+		stats.UnitMilliseconds,
+	)
+
+	// warmedUpKey tells whether a connection to the subscriber was pre-established
+	// before the first delivery.
+	warmedUpKey = tag.MustNewKey("warmed_up")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: firstDeliveryLatencyM.Description(),
+			Measure:     firstDeliveryLatencyM,
+			Aggregation: view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+			TagKeys:     []tag.Key{warmedUpKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// warmUp pre-establishes an idle connection to subscriber with a HEAD request sent through
+// the client used to dispatch events, so that the first delivery does not pay for the DNS
+// lookup and the TCP and TLS handshakes. Any response, whatever its status, leaves the
+// connection in the idle pool of the client.
+func (s *SubscriptionsSupervisor) warmUp(ctx context.Context, subscriber *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, warmUpTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, subscriber.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.warmUpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", subscriber, err)
+	}
+	// Draining the body lets the connection be reused.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// warmUpAsync warms up the connection to subscriber in the background, the failures are only logged.
+func (s *SubscriptionsSupervisor) warmUpAsync(ctx context.Context, subscriber *url.URL, delivery *firstDelivery) {
+	go func() {
+		if err := s.warmUp(ctx, subscriber); err != nil {
+			s.logger.Info("Failed to warm up the connection to the subscriber", zap.Error(err))
+			return
+		}
+		delivery.setWarmedUp()
+		s.logger.Debug("Warmed up the connection to the subscriber", zap.String("subscriber", subscriber.String()))
+	}()
+}
+
+// firstDelivery records the latency of the first delivery of a subscription.
+type firstDelivery struct {
+	done     int32
+	warmedUp int32
+}
+
+func (f *firstDelivery) setWarmedUp() {
+	atomic.StoreInt32(&f.warmedUp, 1)
+}
+
+// record reports latency if it is the one of the first delivery.
+func (f *firstDelivery) record(latency time.Duration) {
+	if !atomic.CompareAndSwapInt32(&f.done, 0, 1) {
+		return
+	}
+	ctx, err := tag.New(context.Background(), tag.Insert(warmedUpKey, strconv.FormatBool(atomic.LoadInt32(&f.warmedUp) == 1)))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, firstDeliveryLatencyM.M(float64(latency)/float64(time.Millisecond)))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmUp(t *testing.T) {
+	var conns, heads int32
+	subscriber := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	subscriber.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	subscriber.StartTLS()
+	defer subscriber.Close()
+
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	// Trust the certificate of the subscriber.
+	s.warmUpClient = subscriber.Client()
+
+	if err := s.warmUp(context.Background(), mustParseURL(t, subscriber.URL)); err != nil {
+		t.Fatalf("warmUp() = %v", err)
+	}
+	if got := atomic.LoadInt32(&heads); got != 1 {
+		t.Errorf("HEAD requests = %d, want 1", got)
+	}
+
+	// The delivery reuses the pre-established connection.
+	resp, err := s.warmUpClient.Post(subscriber.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post() = %v", err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("connections = %d, want the warmed up connection to be reused", got)
+	}
+}
+
+func TestWarmUpFailure(t *testing.T) {
+	subscriber := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	url := mustParseURL(t, subscriber.URL)
+	subscriber.Close()
+
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	s := d.(*SubscriptionsSupervisor)
+	if err := s.warmUp(context.Background(), url); err == nil {
+		t.Error("warmUp() succeeded for a closed subscriber, want an error")
+	}
+}
+
+func TestFirstDeliveryRecord(t *testing.T) {
+	delivery := &firstDelivery{}
+	delivery.record(10 * time.Millisecond)
+	if got := atomic.LoadInt32(&delivery.done); got != 1 {
+		t.Errorf("done = %d, want the first delivery to be recorded", got)
+	}
+	delivery.record(time.Second)
+	if got := atomic.LoadInt32(&delivery.done); got != 1 {
+		t.Errorf("done = %d, want the next deliveries to be ignored", got)
+	}
+}
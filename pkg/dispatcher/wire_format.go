@@ -0,0 +1,44 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// WireFormatSupporter is implemented by the dispatchers able to tell which wire formats they can
+// publish and read. Dispatchers not implementing it only support v1beta1.WireFormatEnvelope.
+type WireFormatSupporter interface {
+	// SupportsWireFormat returns whether the dispatcher can publish and read events in format.
+	SupportsWireFormat(format v1beta1.WireFormat) bool
+}
+
+var _ WireFormatSupporter = (*SubscriptionsSupervisor)(nil)
+
+// SupportsWireFormat implements WireFormatSupporter. NATS Streaming messages have no headers,
+// so the CloudEvents NATS protocol binding cannot be used on top of them.
+func (s *SubscriptionsSupervisor) SupportsWireFormat(format v1beta1.WireFormat) bool {
+	return format.OrDefault() == v1beta1.WireFormatEnvelope
+}
+
+// SupportsWireFormat returns whether d can publish and read events in format.
+func SupportsWireFormat(d NatssDispatcher, format v1beta1.WireFormat) bool {
+	if s, ok := d.(WireFormatSupporter); ok {
+		return s.SupportsWireFormat(format)
+	}
+	return format.OrDefault() == v1beta1.WireFormatEnvelope
+}
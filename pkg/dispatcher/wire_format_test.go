@@ -0,0 +1,41 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestSupportsWireFormat(t *testing.T) {
+	d, err := NewDispatcher(Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+
+	testCases := map[v1beta1.WireFormat]bool{
+		"":                            true,
+		v1beta1.WireFormatEnvelope:    true,
+		v1beta1.WireFormatNATSBinding: false,
+	}
+	for format, want := range testCases {
+		if got := SupportsWireFormat(d, format); got != want {
+			t.Errorf("SupportsWireFormat(%q) = %v, want %v", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+// DefaultDeliveryConcurrency is how many events of a channel are delivered at once, across its
+// subscribers, when Args.DeliveryConcurrency is zero or less.
+const DefaultDeliveryConcurrency = 10
+
+// workerPool bounds the deliveries of a channel made at once. NATSS calls the handler of each
+// subscription on its own goroutine, one event at a time, so the subscribers of a channel are
+// delivered concurrently, a slow subscriber holding a single worker while the others go on.
+// Each subscription acks the events on its own durable, whatever the others deliver.
+type workerPool chan struct{}
+
+func newWorkerPool(size int) workerPool {
+	return make(workerPool, size)
+}
+
+// acquire waits for a worker of the pool to be free and holds it.
+func (p workerPool) acquire() {
+	p <- struct{}{}
+}
+
+// release frees the worker held with acquire.
+func (p workerPool) release() {
+	<-p
+}
+
+// workerPool returns the pool of the deliveries of channel.
+func (s *SubscriptionsSupervisor) workerPool(channel eventingchannels.ChannelReference) workerPool {
+	if p, ok := s.workerPools.Load(channel); ok {
+		return p.(workerPool)
+	}
+	// The pools are replaced while holding configMux when the delivery concurrency changes.
+	s.configMux.RLock()
+	defer s.configMux.RUnlock()
+	p, _ := s.workerPools.LoadOrStore(channel, newWorkerPool(s.deliveryConcurrency))
+	return p.(workerPool)
+}
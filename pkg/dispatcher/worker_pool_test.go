@@ -0,0 +1,205 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+)
+
+// goroutineStanConn calls the handler of each subscription of its fakeStanConn on its own
+// goroutine, one message at a time, like NATSS does.
+type goroutineStanConn struct {
+	*fakeStanConn
+
+	done chan struct{}
+}
+
+func (c *goroutineStanConn) Subscribe(subject string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (stan.Subscription, error) {
+	msgs := make(chan *stan.Msg, 100)
+	go func() {
+		for {
+			select {
+			case msg := <-msgs:
+				cb(msg)
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c.fakeStanConn.Subscribe(subject, func(msg *stan.Msg) { msgs <- msg }, opts...)
+}
+
+func newGoroutineSupervisor(t *testing.T, concurrency int) (*SubscriptionsSupervisor, *goroutineStanConn) {
+	s, conn := newTestSupervisor(t)
+	s.deliveryConcurrency = concurrency
+	goroutines := &goroutineStanConn{fakeStanConn: conn, done: make(chan struct{})}
+	t.Cleanup(func() { close(goroutines.done) })
+	var natssConn stan.Conn = goroutines
+	s.natssConn = &natssConn
+	return s, goroutines
+}
+
+// blockingSubscriber accepts the events once released, tracking how many it is sent at once.
+type blockingSubscriber struct {
+	*httptest.Server
+
+	released chan struct{}
+
+	mu          sync.Mutex
+	inflight    int
+	maxInflight int
+	received    int
+}
+
+func newBlockingSubscriber() *blockingSubscriber {
+	b := &blockingSubscriber{released: make(chan struct{})}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b.mu.Lock()
+		b.inflight++
+		if b.inflight > b.maxInflight {
+			b.maxInflight = b.inflight
+		}
+		b.mu.Unlock()
+		<-b.released
+		b.mu.Lock()
+		b.inflight--
+		b.received++
+		b.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return b
+}
+
+func (b *blockingSubscriber) counts() (inflight, maxInflight, received int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inflight, b.maxInflight, b.received
+}
+
+func newChannelOf(ref eventingchannels.ChannelReference, subscribers ...*httptest.Server) *messagingv1.Channel {
+	c := newTestChannel(ref)
+	for i, subscriber := range subscribers {
+		c.Spec.Subscribers = append(c.Spec.Subscribers, eventingduckv1.SubscriberSpec{
+			UID:           types.UID(fmt.Sprintf("uid-%d", i)),
+			SubscriberURI: apis.HTTP(subscriber.Listener.Addr().String()),
+		})
+	}
+	return c
+}
+
+func publishTestEvents(t *testing.T, conn stan.Conn, ref eventingchannels.ChannelReference, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := conn.Publish(getSubject(ref), newTestEventMsg(t, fmt.Sprintf("id-%d", i)).Data); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+	}
+}
+
+func TestWorkerPoolSlowSubscriber(t *testing.T) {
+	slow := newBlockingSubscriber()
+	defer slow.Close()
+	defer close(slow.released)
+	fast := []*eventRecorder{newEventRecorder(), newEventRecorder()}
+	for _, r := range fast {
+		defer r.Close()
+	}
+	s, conn := newGoroutineSupervisor(t, DefaultDeliveryConcurrency)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	channel := newChannelOf(ref, slow.Server, fast[0].Server, fast[1].Server)
+	if failed, err := s.UpdateSubscriptions(context.Background(), channel, false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+
+	const events = 5
+	publishTestEvents(t, conn, ref, events)
+	deadline := time.Now().Add(2 * time.Second)
+	for _, r := range fast {
+		for len(r.received()) < events && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(r.received()); got != events {
+			t.Errorf("a fast subscriber received %d events while the slow one was blocked, want %d", got, events)
+		}
+	}
+	if inflight, _, _ := slow.counts(); inflight != 1 {
+		t.Errorf("the slow subscriber has %d events in flight, want 1", inflight)
+	}
+}
+
+func TestWorkerPoolConcurrencyLimit(t *testing.T) {
+	const concurrency = 2
+	subscribers := make([]*blockingSubscriber, 5)
+	servers := make([]*httptest.Server, len(subscribers))
+	for i := range subscribers {
+		subscribers[i] = newBlockingSubscriber()
+		defer subscribers[i].Close()
+		servers[i] = subscribers[i].Server
+	}
+	s, conn := newGoroutineSupervisor(t, concurrency)
+	ref := eventingchannels.ChannelReference{Namespace: "ns", Name: "channel"}
+	if failed, err := s.UpdateSubscriptions(context.Background(), newChannelOf(ref, servers...), false); err != nil || len(failed) != 0 {
+		t.Fatalf("UpdateSubscriptions() = %v, %v", failed, err)
+	}
+	inflight := func() int {
+		var n int
+		for _, sub := range subscribers {
+			i, _, _ := sub.counts()
+			n += i
+		}
+		return n
+	}
+
+	const events = 3
+	publishTestEvents(t, conn, ref, events)
+	deadline := time.Now().Add(2 * time.Second)
+	for inflight() < concurrency && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Leave the time for the deliveries beyond the limit to start.
+	time.Sleep(100 * time.Millisecond)
+	if got := inflight(); got != concurrency {
+		t.Fatalf("%d deliveries in flight, want %d", got, concurrency)
+	}
+
+	// The deliveries go on, within the limit, as the subscribers answer.
+	for _, sub := range subscribers {
+		close(sub.released)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for _, sub := range subscribers {
+		for _, _, received := sub.counts(); received < events && time.Now().Before(deadline); _, _, received = sub.counts() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, maxInflight, received := sub.counts(); received != events || maxInflight != 1 {
+			t.Errorf("a subscriber received %d events, up to %d at once, want %d, one at a time", received, maxInflight, events)
+		}
+	}
+}
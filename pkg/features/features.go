@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features holds the feature flags of the NATSS channels, set by the features.<name> keys
+// of the config-natss ConfigMap, in the manner of the feature flags of Knative.
+package features
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// KeyPrefix prefixes the ConfigMap keys of the feature flags, the name of the flag ending the key.
+const KeyPrefix = "features."
+
+// Flag is the state of a feature flag.
+type Flag string
+
+const (
+	// Enabled turns the feature on.
+	Enabled Flag = "enabled"
+	// Disabled turns the feature off.
+	Disabled Flag = "disabled"
+	// Allowed leaves the feature off unless the resources opt in.
+	Allowed Flag = "allowed"
+)
+
+// Enabled returns whether the feature is on.
+func (f Flag) Enabled() bool {
+	return f == Enabled
+}
+
+// Allowed returns whether the resources may use the feature, which they do when it is enabled.
+func (f Flag) Allowed() bool {
+	return f == Enabled || f == Allowed
+}
+
+// Flags holds the feature flags. The flags which are not set in the ConfigMap keep their default.
+type Flags struct {
+	// WarmUpSubscribers pre-establishes a connection to the subscribers of the new subscriptions,
+	// sparing their first delivery the connection setup. Disabled by default.
+	WarmUpSubscribers Flag
+
+	// OrphanAuditDelete deletes the orphaned durables found by the orphan audit once their grace
+	// period is over. Disabled by default.
+	OrphanAuditDelete Flag
+
+	// DeliveryCursors tracks the highest sequence acknowledged without gap by each durable of the
+	// subscriptions made, persisting it for the consumers taking over from the dispatcher.
+	// Disabled by default.
+	DeliveryCursors Flag
+
+	// InsecureDeliveryCondition sets the informational InsecureDelivery condition of the channels
+	// whose subscriptions deliver over plain HTTP to another namespace. Disabled by default.
+	InsecureDeliveryCondition Flag
+
+	// OrphanedSubscriberPause pauses the subscriptions of the subscribers left in the spec of a
+	// channel after their Subscription was deleted, keeping their durables. Disabled by default.
+	OrphanedSubscriberPause Flag
+}
+
+// flags describes the flags of Flags: their name, the key which set them before the features
+// section existed, and their default.
+var flags = []struct {
+	name      string
+	legacyKey string
+	def       Flag
+	field     func(*Flags) *Flag
+}{{
+	name:      "warm-up-subscribers",
+	legacyKey: "warm-up-subscribers",
+	def:       Disabled,
+	field:     func(f *Flags) *Flag { return &f.WarmUpSubscribers },
+}, {
+	name:      "orphan-audit-delete",
+	legacyKey: "orphan-audit-delete",
+	def:       Disabled,
+	field:     func(f *Flags) *Flag { return &f.OrphanAuditDelete },
+}, {
+	name:  "delivery-cursors",
+	def:   Disabled,
+	field: func(f *Flags) *Flag { return &f.DeliveryCursors },
+}, {
+	name:  "insecure-delivery-condition",
+	def:   Disabled,
+	field: func(f *Flags) *Flag { return &f.InsecureDeliveryCondition },
+}, {
+	name:  "orphaned-subscriber-pause",
+	def:   Disabled,
+	field: func(f *Flags) *Flag { return &f.OrphanedSubscriberPause },
+}}
+
+// Defaults returns the default Flags.
+func Defaults() *Flags {
+	f := &Flags{}
+	for _, flag := range flags {
+		*flag.field(f) = flag.def
+	}
+	return f
+}
+
+// NewFlagsFromMap parses the features.<name> keys of data. The boolean keys which set the flags
+// before still apply when their features key is not set. The unknown flags are ignored, so that
+// the configuration of a newer version does not break an older one.
+func NewFlagsFromMap(data map[string]string) (*Flags, error) {
+	f := Defaults()
+	for _, flag := range flags {
+		if raw, ok := data[KeyPrefix+flag.name]; ok {
+			state, err := parseFlag(raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", KeyPrefix+flag.name, err)
+			}
+			*flag.field(f) = state
+			continue
+		}
+		if raw, ok := data[flag.legacyKey]; flag.legacyKey != "" && ok {
+			enabled, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q: %w", flag.legacyKey, err)
+			}
+			if enabled {
+				*flag.field(f) = Enabled
+			} else {
+				*flag.field(f) = Disabled
+			}
+		}
+	}
+	return f, nil
+}
+
+func parseFlag(raw string) (Flag, error) {
+	switch state := Flag(strings.ToLower(strings.TrimSpace(raw))); state {
+	case Enabled, Disabled, Allowed:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown state %q, expected one of %q, %q or %q", raw, Enabled, Disabled, Allowed)
+	}
+}
+
+type flagsKey struct{}
+
+// ToContext returns a copy of ctx carrying flags.
+func ToContext(ctx context.Context, flags *Flags) context.Context {
+	return context.WithValue(ctx, flagsKey{}, flags)
+}
+
+// FromContext returns the Flags carried by ctx, the defaults when it carries none.
+func FromContext(ctx context.Context) *Flags {
+	if f, ok := ctx.Value(flagsKey{}).(*Flags); ok && f != nil {
+		return f
+	}
+	return Defaults()
+}
+
+// Store holds the current Flags, updated by the watcher of the ConfigMap. It is the ConfigStore of
+// the reconcilers, which attach the flags to the context of every reconciliation.
+type Store struct {
+	flags atomic.Value
+}
+
+// NewStore returns a Store holding flags, the defaults when nil.
+func NewStore(flags *Flags) *Store {
+	s := &Store{}
+	s.Set(flags)
+	return s
+}
+
+// Set replaces the flags, nil restoring the defaults.
+func (s *Store) Set(flags *Flags) {
+	if flags == nil {
+		flags = Defaults()
+	}
+	s.flags.Store(flags)
+}
+
+// Load returns the current flags.
+func (s *Store) Load() *Flags {
+	return s.flags.Load().(*Flags)
+}
+
+// ToContext returns a copy of ctx carrying the current flags.
+func (s *Store) ToContext(ctx context.Context) context.Context {
+	return ToContext(ctx, s.Load())
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewFlagsFromMap(t *testing.T) {
+	testCases := map[string]struct {
+		data    map[string]string
+		want    *Flags
+		wantErr bool
+	}{
+		"defaults": {
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"enabled": {
+			data: map[string]string{"features.warm-up-subscribers": "enabled"},
+			want: &Flags{WarmUpSubscribers: Enabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"allowed": {
+			data: map[string]string{"features.orphan-audit-delete": " Allowed "},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Allowed, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"delivery cursors": {
+			data: map[string]string{"features.delivery-cursors": "enabled"},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Enabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"insecure delivery condition": {
+			data: map[string]string{"features.insecure-delivery-condition": "enabled"},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Enabled, OrphanedSubscriberPause: Disabled},
+		},
+		"orphaned subscriber pause": {
+			data: map[string]string{"features.orphaned-subscriber-pause": "enabled"},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Enabled},
+		},
+		"legacy key": {
+			data: map[string]string{"warm-up-subscribers": "true", "orphan-audit-delete": "false"},
+			want: &Flags{WarmUpSubscribers: Enabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"features key over legacy key": {
+			data: map[string]string{"features.warm-up-subscribers": "disabled", "warm-up-subscribers": "true"},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"unknown flag": {
+			data: map[string]string{"features.from-a-newer-version": "enabled"},
+			want: &Flags{WarmUpSubscribers: Disabled, OrphanAuditDelete: Disabled, DeliveryCursors: Disabled, InsecureDeliveryCondition: Disabled, OrphanedSubscriberPause: Disabled},
+		},
+		"invalid state": {
+			data:    map[string]string{"features.warm-up-subscribers": "true"},
+			wantErr: true,
+		},
+		"invalid legacy key": {
+			data:    map[string]string{"orphan-audit-delete": "enabled"},
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := NewFlagsFromMap(tc.data)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewFlagsFromMap() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("NewFlagsFromMap() (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestFlagStates(t *testing.T) {
+	for _, tc := range []struct {
+		flag             Flag
+		enabled, allowed bool
+	}{
+		{flag: Enabled, enabled: true, allowed: true},
+		{flag: Allowed, allowed: true},
+		{flag: Disabled},
+		{flag: ""},
+	} {
+		if got := tc.flag.Enabled(); got != tc.enabled {
+			t.Errorf("%q.Enabled() = %v, want %v", tc.flag, got, tc.enabled)
+		}
+		if got := tc.flag.Allowed(); got != tc.allowed {
+			t.Errorf("%q.Allowed() = %v, want %v", tc.flag, got, tc.allowed)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if diff := cmp.Diff(Defaults(), FromContext(context.Background())); diff != "" {
+		t.Errorf("FromContext() without flags (-want, +got) = %s", diff)
+	}
+	flags := &Flags{WarmUpSubscribers: Enabled}
+	if got := FromContext(ToContext(context.Background(), flags)); got != flags {
+		t.Errorf("FromContext() = %+v, want %+v", got, flags)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(nil)
+	before := store.ToContext(context.Background())
+	if FromContext(before).WarmUpSubscribers.Enabled() {
+		t.Error("WarmUpSubscribers enabled by default")
+	}
+
+	// The changes apply to the contexts that follow, the earlier ones keeping their flags.
+	store.Set(&Flags{WarmUpSubscribers: Enabled, OrphanAuditDelete: Disabled})
+	if !FromContext(store.ToContext(context.Background())).WarmUpSubscribers.Enabled() {
+		t.Error("WarmUpSubscribers not enabled after the update")
+	}
+	if FromContext(before).WarmUpSubscribers.Enabled() {
+		t.Error("WarmUpSubscribers enabled in the context of before the update")
+	}
+
+	store.Set(nil)
+	if diff := cmp.Diff(Defaults(), store.Load()); diff != "" {
+		t.Errorf("Load() after Set(nil) (-want, +got) = %s", diff)
+	}
+}
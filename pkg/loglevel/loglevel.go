@@ -0,0 +1,204 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loglevel provides named loggers whose levels are set independently in the
+// config-logging ConfigMap, for example to debug a single path of the dispatcher in production.
+package loglevel
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/injection/sharedmain"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/logging/logkey"
+	"knative.dev/pkg/system"
+)
+
+// Loggers creates the named loggers of a component. The level of a logger is set by the
+// loglevel.<name> key of the config-logging ConfigMap. A logger without level falls back to the
+// level of its parent, dispatcher.subscriptions falling back to dispatcher, then to the level of
+// the component and finally to the level of the logging configuration. Only the levels are
+// updated when the ConfigMap changes, the other settings are read once.
+type Loggers struct {
+	component string
+
+	mu      sync.Mutex
+	config  *logging.Config
+	levels  map[string]zap.AtomicLevel
+	loggers map[string]*zap.SugaredLogger
+}
+
+// New returns the Loggers of component, configured by config.
+func New(component string, config *logging.Config) *Loggers {
+	return &Loggers{
+		component: component,
+		config:    config,
+		levels:    make(map[string]zap.AtomicLevel),
+		loggers:   make(map[string]*zap.SugaredLogger),
+	}
+}
+
+type componentKey struct{}
+
+// WithComponent records in ctx the name of the component whose level the named loggers fall
+// back to.
+func WithComponent(ctx context.Context, component string) context.Context {
+	return context.WithValue(ctx, componentKey{}, component)
+}
+
+// NewFromContext returns the Loggers of the component recorded in ctx, configured by the
+// config-logging ConfigMap and updated when it changes.
+func NewFromContext(ctx context.Context, cmw configmap.Watcher) *Loggers {
+	logger := logging.FromContext(ctx)
+	component, _ := ctx.Value(componentKey{}).(string)
+
+	config, err := sharedmain.GetLoggingConfig(ctx)
+	if err != nil {
+		logger.Errorw("Error reading the logging configuration, using the default one", zap.Error(err))
+		config, _ = logging.NewConfigFromMap(nil)
+	}
+	l := New(component, config)
+	l.Watch(ctx, cmw)
+	return l
+}
+
+// Watch updates the levels of the loggers every time the config-logging ConfigMap changes.
+func (l *Loggers) Watch(ctx context.Context, cmw configmap.Watcher) {
+	logger := logging.FromContext(ctx)
+	o := func(cm *corev1.ConfigMap) {
+		config, err := logging.NewConfigFromConfigMap(cm)
+		if err != nil {
+			logger.Errorw("Ignoring the invalid logging configuration", zap.Error(err))
+			return
+		}
+		l.Update(config)
+	}
+	if iw, ok := cmw.(*configmap.InformedWatcher); ok {
+		iw.WatchWithDefault(corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName(), Namespace: system.Namespace()},
+		}, o)
+		return
+	}
+	cmw.Watch(logging.ConfigMapName(), o)
+}
+
+// Named returns the logger called name.
+func (l *Loggers) Named(name string) *zap.SugaredLogger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if logger, ok := l.loggers[name]; ok {
+		return logger
+	}
+
+	level := zap.NewAtomicLevelAt(l.level(name))
+	zapConfig := l.zapConfig()
+	zapConfig.Level = level
+	logger, err := zapConfig.Build()
+	if err != nil {
+		// The logging configuration was already used to build the logger of the component.
+		logger = zap.NewNop()
+	}
+	if l.component != "" {
+		logger = logger.Named(l.component)
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		logger = logger.With(zap.String(logkey.Pod, pod))
+	}
+
+	sugared := logger.Named(name).Sugar()
+	l.levels[name] = level
+	l.loggers[name] = sugared
+	return sugared
+}
+
+// Update sets the levels of the loggers from config.
+func (l *Loggers) Update(config *logging.Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	for name, level := range l.levels {
+		if want := l.level(name); level.Level() != want {
+			l.loggers[name].Infow("Updating the logging level", zap.String("from", level.Level().String()), zap.String("to", want.String()))
+			level.SetLevel(want)
+		}
+	}
+}
+
+// level returns the level of the logger called name.
+func (l *Loggers) level(name string) zapcore.Level {
+	for key := name; key != ""; {
+		if level, ok := l.config.LoggingLevel[key]; ok {
+			return level
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	if level, ok := l.config.LoggingLevel[l.component]; ok && l.component != "" {
+		return level
+	}
+	return l.zapConfig().Level.Level()
+}
+
+// zapConfig returns the zap configuration of the logging configuration, its missing settings
+// being the production ones.
+func (l *Loggers) zapConfig() zap.Config {
+	zapConfig := zap.NewProductionConfig()
+	if err := json.Unmarshal([]byte(l.config.LoggingConfig), &zapConfig); err != nil {
+		return zap.NewProductionConfig()
+	}
+	return zapConfig
+}
+
+// AtLevel returns logger logging at level whatever the level of logger, for example to debug a
+// single channel. The entries enabled by level are written without the sampling of logger.
+func AtLevel(logger *zap.Logger, level zapcore.Level) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, level: level}
+	}))
+}
+
+// levelCore is a zapcore.Core enabling the entries of its level instead of those of its core.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.Level
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loglevel
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/logging"
+	_ "knative.dev/pkg/system/testing"
+)
+
+const component = "natsschannel-dispatcher"
+
+func TestLevels(t *testing.T) {
+	testCases := map[string]struct {
+		data map[string]string
+		name string
+		want zapcore.Level
+	}{
+		"default": {
+			name: "dispatcher.subscriptions",
+			want: zapcore.InfoLevel,
+		},
+		"own level": {
+			data: map[string]string{"loglevel.dispatcher.subscriptions": "debug", "loglevel.dispatcher": "error"},
+			name: "dispatcher.subscriptions",
+			want: zapcore.DebugLevel,
+		},
+		"parent level": {
+			data: map[string]string{"loglevel.dispatcher": "warn", "loglevel." + component: "error"},
+			name: "dispatcher.subscriptions",
+			want: zapcore.WarnLevel,
+		},
+		"component level": {
+			data: map[string]string{"loglevel." + component: "error"},
+			name: "dispatcher.subscriptions",
+			want: zapcore.ErrorLevel,
+		},
+		"global level": {
+			data: map[string]string{"zap-logger-config": `{"level": "debug"}`},
+			name: "receiver",
+			want: zapcore.DebugLevel,
+		},
+		"global level missing": {
+			data: map[string]string{"zap-logger-config": `{}`},
+			name: "receiver",
+			want: zapcore.InfoLevel,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			config, err := logging.NewConfigFromMap(tc.data)
+			if err != nil {
+				t.Fatalf("NewConfigFromMap() = %v", err)
+			}
+			logger := New(component, config).Named(tc.name).Desugar()
+			if !logger.Core().Enabled(tc.want) || (tc.want > zapcore.DebugLevel && logger.Core().Enabled(tc.want-1)) {
+				t.Errorf("logger %s is not enabled at %s", tc.name, tc.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	config, _ := logging.NewConfigFromMap(nil)
+	loggers := New(component, config)
+	subscriptions := loggers.Named("dispatcher.subscriptions").Desugar()
+	if loggers.Named("receiver") != loggers.Named("receiver") {
+		t.Error("Named() created the receiver logger again")
+	}
+	receiver := loggers.Named("receiver").Desugar()
+
+	cmw := &configmap.ManualWatcher{Namespace: "knative-testing"}
+	loggers.Watch(context.Background(), cmw)
+
+	// The levels change without creating the loggers again.
+	cmw.OnChange(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-testing", Name: logging.ConfigMapName()},
+		Data: map[string]string{
+			"loglevel.dispatcher.subscriptions": "debug",
+			"loglevel.receiver":                 "error",
+		},
+	})
+	if !subscriptions.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("the subscriptions logger is not enabled at debug")
+	}
+	if receiver.Core().Enabled(zapcore.WarnLevel) {
+		t.Error("the receiver logger is enabled at warn")
+	}
+
+	// Invalid levels are ignored.
+	cmw.OnChange(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-testing", Name: logging.ConfigMapName()},
+		Data:       map[string]string{"loglevel.receiver": "loud"},
+	})
+	if receiver.Core().Enabled(zapcore.WarnLevel) {
+		t.Error("the invalid configuration changed the level of the receiver logger")
+	}
+
+	// Removing a level restores the default one.
+	cmw.OnChange(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "knative-testing", Name: logging.ConfigMapName()},
+	})
+	if subscriptions.Core().Enabled(zapcore.DebugLevel) || !subscriptions.Core().Enabled(zapcore.InfoLevel) {
+		t.Error("the subscriptions logger is not back to info")
+	}
+	if !receiver.Core().Enabled(zapcore.InfoLevel) {
+		t.Error("the receiver logger is not back to info")
+	}
+}
+
+func TestAtLevel(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	debug := AtLevel(logger, zapcore.DebugLevel).With(zap.String("channel", "ns/channel"))
+	debug.Debug("forced")
+	logger.Debug("default")
+	AtLevel(logger, zapcore.ErrorLevel).Info("silenced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"msg":"forced"`) || !strings.Contains(lines[0], `"channel":"ns/channel"`) {
+		t.Errorf("logged %q, want only the entry logged at the forced level", lines)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package probe defines the NatssChannel of the end to end probe, which the controller maintains
+// in the namespace of the probe.namespace key of the config-natss ConfigMap, and through which
+// the dispatcher periodically sends an event to its own probe subscriber.
+package probe
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/network"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+const (
+	// ChannelName is the name of the probe channel.
+	ChannelName = "natss-e2e-probe"
+
+	// LabelKey labels the probe channels with "true", the controller deleting those which are not
+	// in the namespace of the probe.
+	LabelKey = "natss.messaging.knative.dev/e2e-probe"
+
+	// Path is the path of the receiver of the dispatcher serving the probe subscriber.
+	Path = "/e2e-probe"
+
+	// SubscriberUID is the UID of the probe subscriber in the spec of the probe channel.
+	SubscriberUID types.UID = "natss-e2e-probe"
+
+	// EventType is the type of the events of the probe.
+	EventType = "dev.knative.natss.e2e-probe"
+)
+
+// IsChannel tells whether nc is a probe channel.
+func IsChannel(nc *v1beta1.NatssChannel) bool {
+	return nc.Name == ChannelName && nc.Labels[LabelKey] == "true"
+}
+
+// MakeChannel returns the probe channel of namespace, whose subscriber is reached at Path through
+// the address of the channel, and thus by the dispatcher serving it.
+func MakeChannel(namespace string) *v1beta1.NatssChannel {
+	host := network.GetServiceHostname(fmt.Sprintf("%s-kn-channel", ChannelName), namespace)
+	nc := &v1beta1.NatssChannel{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      ChannelName,
+			Labels:    map[string]string{LabelKey: "true"},
+		},
+	}
+	nc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{
+		UID:           SubscriberUID,
+		Generation:    1,
+		SubscriberURI: &apis.URL{Scheme: "http", Host: host, Path: Path},
+	}}
+	return nc
+}
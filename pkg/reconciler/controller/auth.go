@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+// natsAuthInvalid is the reason of the events of the channels while the NATS credentials of the
+// dispatcher are invalid.
+const natsAuthInvalid = "NatsAuthInvalid"
+
+// natsAuth holds the outcome of the validation of the NATS credentials referenced by config-natss,
+// which the dispatcher reads when it starts.
+type natsAuth struct {
+	mu  sync.Mutex
+	err error
+}
+
+// validate reads the credentials of the Secret secretName, returning whether their validity
+// changed.
+func (a *natsAuth) validate(ctx context.Context, secretName string) bool {
+	_, err := config.GetAuth(ctx, secretName)
+	if err != nil {
+		logging.FromContext(ctx).Errorw("The dispatcher cannot authenticate to NATS", zap.Error(err))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	changed := (a.err == nil) != (err == nil)
+	a.err = err
+	return changed
+}
+
+// reconcile warns on natssChannel while the credentials are invalid.
+func (a *natsAuth) reconcile(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	a.mu.Lock()
+	err := a.err
+	a.mu.Unlock()
+	if err != nil {
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, natsAuthInvalid,
+			"The dispatcher cannot authenticate to NATS: %v", err)
+	}
+}
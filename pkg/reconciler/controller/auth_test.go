@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/system"
+
+	_ "knative.dev/pkg/system/testing"
+
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+	"knative.dev/eventing-natss/pkg/stanutil"
+)
+
+func TestNatsAuth(t *testing.T) {
+	ctx, _ := fakekubeclient.With(context.Background(),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nats-token", Namespace: system.Namespace()},
+			Data:       map[string][]byte{stanutil.AuthTokenKey: []byte("t0ken")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nats-ambiguous", Namespace: system.Namespace()},
+			Data: map[string][]byte{
+				stanutil.AuthTokenKey: []byte("t0ken"),
+				stanutil.AuthNKeyKey:  []byte("SUAGIEYODKBBTUMOB666Z5KA4FCWAZV7HWSGRHOD7MK6UM5IYLWLACH7DQ"),
+			},
+		},
+	)
+	recorder := record.NewFakeRecorder(10)
+	ctx = controller.WithEventRecorder(ctx, recorder)
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+
+	a := &natsAuth{}
+	if a.validate(ctx, "nats-token") {
+		t.Error("validate() = true, want valid credentials to leave the channels unchanged")
+	}
+	a.reconcile(ctx, nc)
+	if len(recorder.Events) != 0 {
+		t.Errorf("%q emitted with valid credentials", <-recorder.Events)
+	}
+
+	if !a.validate(ctx, "nats-ambiguous") {
+		t.Error("validate() = false, want the channels resynced once the credentials are invalid")
+	}
+	a.reconcile(ctx, nc)
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, corev1.EventTypeWarning+" "+natsAuthInvalid) || !strings.Contains(event, "exactly one authentication mode") {
+			t.Errorf("event = %q, want a %s warning telling exactly one mode must be set", event, natsAuthInvalid)
+		}
+	default:
+		t.Error("no event emitted with invalid credentials")
+	}
+
+	if !a.validate(ctx, "") {
+		t.Error("validate() = false, want the channels resynced once the credentials are removed")
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/logging"
+	certresources "knative.dev/pkg/webhook/certificates/resources"
+
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+)
+
+const (
+	// webhookServiceName is the name of the Service of the webhook, in the system namespace.
+	webhookServiceName = "natss-webhook"
+	// webhookSecretName is the name of the Secret the webhook serves its certificate from, in
+	// the system namespace.
+	webhookSecretName = "natss-webhook-certs"
+)
+
+// certificates maintains the certificates issued by cert-manager when it is enabled: that of the
+// webhook, copied into the Secret the webhook serves it from and publishes the certificate
+// authority of, and that of the receiver of the dispatcher of the cluster, mounted into its pods,
+// which are rolled out on each renewal. Nothing is done while cert-manager is disabled, the
+// webhook generating its own certificate and the receiver serving the files of config-natss.
+type certificates struct {
+	kubeClient      kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	systemNamespace string
+	// dispatcherName is the name of the Deployment and Service of the dispatcher of the cluster.
+	dispatcherName string
+
+	natsschannelLister listers.NatssChannelLister
+	deploymentLister   appsv1listers.DeploymentLister
+	// secretLister lists the Secrets of the system namespace.
+	secretLister corev1listers.SecretLister
+
+	// receiverIssued is called with whether the receiver certificate is issued, and the
+	// certificate authority it is issued by.
+	receiverIssued func(issued bool, caCerts string)
+
+	// mu serializes the reconciliations.
+	mu     sync.Mutex
+	config config.CertManager
+	// applied are the specs of the Certificates last applied, by name, which are not read again
+	// while they do not change.
+	applied map[string]interface{}
+}
+
+// setConfig sets the configuration of cert-manager and reconciles the certificates.
+func (c *certificates) setConfig(ctx context.Context, cfg config.CertManager) {
+	c.mu.Lock()
+	if c.config != cfg {
+		c.applied = nil
+	}
+	c.config = cfg
+	c.mu.Unlock()
+	c.reconcile(ctx)
+}
+
+// reconcile ensures the Certificates, then wires the Secrets issued once they are. The failures
+// are logged, the next change of the channels, of the Secrets, of the dispatcher or of the
+// configuration retrying.
+func (c *certificates) reconcile(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logger := logging.FromContext(ctx)
+
+	if !c.config.Enabled {
+		c.receiverIssued(false, "")
+		return
+	}
+
+	// The certificate of the receiver covers the hosts of the channels of the cluster, by
+	// namespace so that it is not issued again for each new channel.
+	channels, err := c.natsschannelLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Failed to list the channels", zap.Error(err))
+		return
+	}
+	namespaces := sets.NewString()
+	for _, nc := range channels {
+		if !nc.IsNamespaceScoped() {
+			namespaces.Insert(nc.Namespace)
+		}
+	}
+	dispatcherNames := append(resources.ServiceDNSNames(c.dispatcherName, c.systemNamespace), resources.ChannelDNSNames(namespaces.List())...)
+	for _, desired := range []*unstructured.Unstructured{
+		resources.MakeCertificate(c.systemNamespace, resources.DispatcherCertificateName, dispatcherNames, c.config),
+		resources.MakeCertificate(c.systemNamespace, resources.WebhookCertificateName, resources.ServiceDNSNames(webhookServiceName, c.systemNamespace), c.config),
+	} {
+		if err := c.reconcileCertificate(ctx, desired); err != nil {
+			logger.Errorw("Failed to reconcile the certificate", zap.String("certificate", desired.GetName()), zap.Error(err))
+		}
+	}
+
+	if err := c.reconcileWebhookSecret(ctx); err != nil {
+		logger.Errorw("Failed to update the certificate of the webhook", zap.Error(err))
+	}
+	if err := c.reconcileDispatcher(ctx); err != nil {
+		logger.Errorw("Failed to mount the receiver certificate into the dispatcher", zap.Error(err))
+	}
+}
+
+func (c *certificates) reconcileCertificate(ctx context.Context, desired *unstructured.Unstructured) error {
+	spec := desired.Object["spec"]
+	if equality.Semantic.DeepEqual(c.applied[desired.GetName()], spec) {
+		return nil
+	}
+	client := c.dynamicClient.Resource(resources.CertificateGVR).Namespace(desired.GetNamespace())
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	switch {
+	case apierrs.IsNotFound(err):
+		_, err = client.Create(ctx, desired, metav1.CreateOptions{})
+	case err != nil:
+	case !equality.Semantic.DeepEqual(existing.Object["spec"], spec):
+		existing = existing.DeepCopy()
+		existing.Object["spec"] = spec
+		_, err = client.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	if c.applied == nil {
+		c.applied = make(map[string]interface{})
+	}
+	c.applied[desired.GetName()] = spec
+	return nil
+}
+
+// issued returns the Secret name issued by cert-manager, nil while it is not.
+func (c *certificates) issued(name string) (*corev1.Secret, error) {
+	secret, err := c.secretLister.Secrets(c.systemNamespace).Get(name)
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+// reconcileWebhookSecret copies the certificate of the webhook, once issued, into the Secret of
+// the webhook, under the keys of Knative. The webhook serves it and publishes its certificate
+// authority in the caBundle of its webhook configurations.
+func (c *certificates) reconcileWebhookSecret(ctx context.Context) error {
+	issued, err := c.issued(resources.WebhookCertificateName)
+	if err != nil || issued == nil {
+		return err
+	}
+	secret, err := c.secretLister.Secrets(c.systemNamespace).Get(webhookSecretName)
+	if err != nil {
+		return err
+	}
+	data := map[string][]byte{
+		certresources.ServerKey:  issued.Data[corev1.TLSPrivateKeyKey],
+		certresources.ServerCert: issued.Data[corev1.TLSCertKey],
+		certresources.CACert:     issued.Data[config.CACertKey],
+	}
+	if equality.Semantic.DeepEqual(secret.Data, data) {
+		return nil
+	}
+	secret = secret.DeepCopy()
+	secret.Data = data
+	_, err = c.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// reconcileDispatcher mounts the receiver certificate, once issued, into the dispatcher of the
+// cluster, annotating its pods with the revision of the certificate so that its renewals roll
+// them out.
+func (c *certificates) reconcileDispatcher(ctx context.Context) error {
+	issued, err := c.issued(resources.DispatcherCertificateName)
+	if err != nil || issued == nil {
+		c.receiverIssued(false, "")
+		return err
+	}
+	d, err := c.deploymentLister.Deployments(c.systemNamespace).Get(c.dispatcherName)
+	if err != nil {
+		return err
+	}
+	desired := resources.WithReceiverCertificate(d, certificateRevision(issued.Data[corev1.TLSCertKey]))
+	if !equality.Semantic.DeepEqual(d.Spec.Template, desired.Spec.Template) {
+		if _, err := c.kubeClient.AppsV1().Deployments(d.Namespace).Update(ctx, desired, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Infow("Mounted the receiver certificate into the dispatcher", zap.String("revision", desired.Spec.Template.Annotations[resources.CertificateRevisionAnnotationKey]))
+	}
+	c.receiverIssued(true, string(issued.Data[config.CACertKey]))
+	return nil
+}
+
+// certificateRevision returns the revision of the PEM certificate cert, which changes when it is
+// renewed.
+func certificateRevision(cert []byte) string {
+	sum := sha256.Sum256(cert)
+	return hex.EncodeToString(sum[:8])
+}
+
+// isCertificateSecret is the filter of the informer events of the Secrets of the certificates.
+func isCertificateSecret(obj interface{}) bool {
+	object, err := kmeta.DeletionHandlingAccessor(obj)
+	if err != nil {
+		return false
+	}
+	switch object.GetName() {
+	case resources.DispatcherCertificateName, resources.WebhookCertificateName, webhookSecretName:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamicclient "k8s.io/client-go/dynamic/fake"
+	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+	certresources "knative.dev/pkg/webhook/certificates/resources"
+
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func makeIssuedSecret(name, cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNS, Name: name},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte("key of " + cert),
+			config.CACertKey:        []byte("ca"),
+		},
+	}
+}
+
+func makeWebhookSecret() *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testNS, Name: webhookSecretName}}
+}
+
+func TestCertificates(t *testing.T) {
+	issuer := config.CertManager{Enabled: true, IssuerName: "natss-ca", IssuerKind: config.CertManagerClusterIssuer}
+	dispatcherNames := append(resources.ServiceDNSNames(dispatcherDeploymentName, testNS), resources.ChannelDNSNames([]string{"a", "b"})...)
+	webhookNames := resources.ServiceDNSNames(webhookServiceName, testNS)
+	channels := []runtime.Object{
+		reconciletesting.NewNatssChannel("nc", "a"),
+		reconciletesting.NewNatssChannel("nc", "b"),
+		reconciletesting.NewNatssChannel("other", "b"),
+		// Served by the dispatcher of its namespace.
+		reconciletesting.NewNatssChannel("nc", "team", reconciletesting.WithNatssChannelNamespaceScoped),
+	}
+	staleCertificate := resources.MakeCertificate(testNS, resources.DispatcherCertificateName, resources.ServiceDNSNames(dispatcherDeploymentName, testNS), issuer)
+	renewed := resources.WithReceiverCertificate(makeReadyDeploymentWithReceiverPort(8080), certificateRevision([]byte("previous")))
+
+	tests := map[string]struct {
+		config       config.CertManager
+		objects      []runtime.Object
+		certificates []runtime.Object
+		// wantCertificates are the DNS names of the Certificates by name.
+		wantCertificates map[string][]interface{}
+		wantWebhook      map[string][]byte
+		// wantRevision is the revision of the certificate mounted into the dispatcher, empty when
+		// the dispatcher is left untouched.
+		wantRevision string
+		wantIssued   bool
+	}{
+		"disabled": {
+			objects: append(channels, makeReadyDeploymentWithReceiverPort(8080), makeWebhookSecret(),
+				makeIssuedSecret(resources.DispatcherCertificateName, "dispatcher"),
+				makeIssuedSecret(resources.WebhookCertificateName, "webhook")),
+		},
+		"certificates requested": {
+			config:  issuer,
+			objects: append(channels, makeReadyDeploymentWithReceiverPort(8080), makeWebhookSecret()),
+			wantCertificates: map[string][]interface{}{
+				resources.DispatcherCertificateName: stringsToInterfaces(dispatcherNames),
+				resources.WebhookCertificateName:    stringsToInterfaces(webhookNames),
+			},
+		},
+		"certificate updated": {
+			config:       issuer,
+			objects:      append(channels, makeReadyDeploymentWithReceiverPort(8080), makeWebhookSecret()),
+			certificates: []runtime.Object{staleCertificate},
+			wantCertificates: map[string][]interface{}{
+				resources.DispatcherCertificateName: stringsToInterfaces(dispatcherNames),
+				resources.WebhookCertificateName:    stringsToInterfaces(webhookNames),
+			},
+		},
+		"certificates issued": {
+			config: issuer,
+			objects: append(channels, makeReadyDeploymentWithReceiverPort(8080), makeWebhookSecret(),
+				makeIssuedSecret(resources.DispatcherCertificateName, "dispatcher"),
+				makeIssuedSecret(resources.WebhookCertificateName, "webhook")),
+			wantCertificates: map[string][]interface{}{
+				resources.DispatcherCertificateName: stringsToInterfaces(dispatcherNames),
+				resources.WebhookCertificateName:    stringsToInterfaces(webhookNames),
+			},
+			wantWebhook: map[string][]byte{
+				certresources.ServerKey:  []byte("key of webhook"),
+				certresources.ServerCert: []byte("webhook"),
+				certresources.CACert:     []byte("ca"),
+			},
+			wantRevision: certificateRevision([]byte("dispatcher")),
+			wantIssued:   true,
+		},
+		"certificate renewed": {
+			config: issuer,
+			objects: append(channels, renewed, makeWebhookSecret(),
+				makeIssuedSecret(resources.DispatcherCertificateName, "renewed")),
+			wantCertificates: map[string][]interface{}{
+				resources.DispatcherCertificateName: stringsToInterfaces(dispatcherNames),
+				resources.WebhookCertificateName:    stringsToInterfaces(webhookNames),
+			},
+			wantRevision: certificateRevision([]byte("renewed")),
+			wantIssued:   true,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			ctx := context.Background()
+			listers := reconciletesting.NewListers(tc.objects)
+			kubeClient := fakekubeclientset.NewSimpleClientset(listers.GetKubeObjects()...)
+			dynamicClient := fakedynamicclient.NewSimpleDynamicClient(runtime.NewScheme(), tc.certificates...)
+			issued := !tc.wantIssued
+			c := &certificates{
+				kubeClient:         kubeClient,
+				dynamicClient:      dynamicClient,
+				systemNamespace:    testNS,
+				dispatcherName:     dispatcherDeploymentName,
+				natsschannelLister: listers.GetNatssChannelLister(),
+				deploymentLister:   listers.GetDeploymentLister(),
+				secretLister:       listers.GetSecretLister(),
+				receiverIssued: func(got bool, caCerts string) {
+					issued = got
+					if got && caCerts != "ca" {
+						t.Errorf("caCerts = %q, want the certificate authority of the Secret", caCerts)
+					}
+				},
+			}
+			c.setConfig(ctx, tc.config)
+
+			if issued != tc.wantIssued {
+				t.Errorf("issued = %v, want %v", issued, tc.wantIssued)
+			}
+			for _, name := range []string{resources.DispatcherCertificateName, resources.WebhookCertificateName} {
+				cert, err := dynamicClient.Resource(resources.CertificateGVR).Namespace(testNS).Get(ctx, name, metav1.GetOptions{})
+				want, ok := tc.wantCertificates[name]
+				if !ok {
+					if !apierrs.IsNotFound(err) {
+						t.Errorf("Get(%s) = %v, want not found", name, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Get(%s) = %v", name, err)
+				}
+				got, _, _ := unstructured.NestedSlice(cert.Object, "spec", "dnsNames")
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("unexpected DNS names of %s (-want, +got): %s", name, diff)
+				}
+				if ref, _, _ := unstructured.NestedStringMap(cert.Object, "spec", "issuerRef"); ref["name"] != issuer.IssuerName || ref["kind"] != issuer.IssuerKind {
+					t.Errorf("issuerRef of %s = %v, want %+v", name, ref, issuer)
+				}
+			}
+
+			secret, err := kubeClient.CoreV1().Secrets(testNS).Get(ctx, webhookSecretName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			if diff := cmp.Diff(tc.wantWebhook, secret.Data); diff != "" {
+				t.Errorf("unexpected webhook Secret (-want, +got): %s", diff)
+			}
+
+			d, err := kubeClient.AppsV1().Deployments(testNS).Get(ctx, dispatcherDeploymentName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			gotRevision := d.Spec.Template.Annotations[resources.CertificateRevisionAnnotationKey]
+			if tc.wantRevision == "" {
+				if gotRevision != "" || len(d.Spec.Template.Spec.Volumes) != 0 {
+					t.Errorf("the dispatcher was changed before the certificate was issued: %+v", d.Spec.Template)
+				}
+				return
+			}
+			if gotRevision != tc.wantRevision {
+				t.Errorf("revision = %q, want %q", gotRevision, tc.wantRevision)
+			}
+			if mounts := mountsOf(d); !cmp.Equal(mounts, []string{config.CertManagerReceiverDir}) {
+				t.Errorf("mounts = %v, want the receiver certificate only", mounts)
+			}
+		})
+	}
+}
+
+func stringsToInterfaces(s []string) []interface{} {
+	out := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+func mountsOf(d *appsv1.Deployment) []string {
+	var mounts []string
+	for _, m := range d.Spec.Template.Spec.Containers[0].VolumeMounts {
+		mounts = append(mounts, m.MountPath)
+	}
+	return mounts
+}
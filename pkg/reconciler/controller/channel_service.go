@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// channelServiceCorrected is the reason of the event emitted when the Service of a channel
+// drifted from the one the controller makes, and was updated.
+const channelServiceCorrected = "ChannelServiceCorrected"
+
+// channelServiceDrift returns how the live Service of a channel differs from the desired one: the
+// type, the ExternalName, the selector and the ports of its spec, and the labels the controller
+// sets, the other labels being left alone.
+func channelServiceDrift(desired, live *corev1.Service) []string {
+	var drift []string
+	if live.Spec.Type != desired.Spec.Type {
+		drift = append(drift, fmt.Sprintf("type %q, want %q", live.Spec.Type, desired.Spec.Type))
+	}
+	if live.Spec.ExternalName != desired.Spec.ExternalName {
+		drift = append(drift, fmt.Sprintf("externalName %q, want %q", live.Spec.ExternalName, desired.Spec.ExternalName))
+	}
+	if !equality.Semantic.DeepEqual(live.Spec.Selector, desired.Spec.Selector) {
+		drift = append(drift, fmt.Sprintf("selector %v, want %v", live.Spec.Selector, desired.Spec.Selector))
+	}
+	if !equality.Semantic.DeepEqual(live.Spec.Ports, desired.Spec.Ports) {
+		drift = append(drift, fmt.Sprintf("ports %v, want %v", describePorts(live.Spec.Ports), describePorts(desired.Spec.Ports)))
+	}
+	for k, v := range desired.Labels {
+		if got, ok := live.Labels[k]; !ok || got != v {
+			drift = append(drift, fmt.Sprintf("label %s=%q, want %q", k, got, v))
+		}
+	}
+	return drift
+}
+
+// describePorts returns ports as name:port->targetPort, the target port being omitted when unset.
+func describePorts(ports []corev1.ServicePort) []string {
+	described := make([]string, 0, len(ports))
+	for _, p := range ports {
+		d := fmt.Sprintf("%s:%d", p.Name, p.Port)
+		if p.TargetPort.String() != "0" {
+			d += "->" + p.TargetPort.String()
+		}
+		described = append(described, d)
+	}
+	return described
+}
+
+// correctChannelService updates the live Service of channel with the spec and the labels of the
+// desired one when it drifted, such as when the dispatcher Service was renamed, and emits an event
+// describing the drift.
+func (r *Reconciler) correctChannelService(ctx context.Context, channel *v1beta1.NatssChannel, desired, live *corev1.Service) (*corev1.Service, error) {
+	drift := channelServiceDrift(desired, live)
+	if len(drift) == 0 {
+		return live, nil
+	}
+	corrected := live.DeepCopy()
+	corrected.Spec = desired.Spec
+	if corrected.Labels == nil {
+		corrected.Labels = make(map[string]string, len(desired.Labels))
+	}
+	for k, v := range desired.Labels {
+		corrected.Labels[k] = v
+	}
+	svc, err := r.kubeClientSet.CoreV1().Services(corrected.Namespace).Update(ctx, corrected, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to correct the drifted Service %q: %w", live.Name, err)
+	}
+	controller.GetEventRecorder(ctx).Eventf(channel, corev1.EventTypeNormal, channelServiceCorrected,
+		"The Service %s drifted and was updated: %s", live.Name, strings.Join(drift, "; "))
+	return svc, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func TestChannelServiceDrift(t *testing.T) {
+	tests := map[string]struct {
+		live func(*corev1.Service)
+		want []string
+	}{
+		"no drift": {
+			live: func(*corev1.Service) {},
+		},
+		"other labels are kept": {
+			live: func(svc *corev1.Service) {
+				svc.Labels["team"] = "events"
+			},
+		},
+		"ports": {
+			live: func(svc *corev1.Service) {
+				svc.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)}}
+			},
+			want: []string{"ports [http:80->8080], want []"},
+		},
+		"role label": {
+			live: func(svc *corev1.Service) {
+				svc.Labels[resources.MessagingRoleLabel] = "in-memory-channel"
+			},
+			want: []string{`label messaging.knative.dev/role="in-memory-channel", want "natss-channel"`},
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			desired := makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS))
+			got := channelServiceDrift(desired, makeChannelServiceWith(tc.live))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("channelServiceDrift() (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
@@ -18,30 +18,65 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
 
 	kubeclient "knative.dev/pkg/client/injection/kube/client"
 	deploymentinformer "knative.dev/pkg/client/injection/kube/informers/apps/v1/deployment"
 	"knative.dev/pkg/client/injection/kube/informers/core/v1/endpoints"
 	"knative.dev/pkg/client/injection/kube/informers/core/v1/service"
+	"knative.dev/pkg/client/injection/kube/informers/core/v1/serviceaccount"
+	"knative.dev/pkg/client/injection/kube/informers/rbac/v1/rolebinding"
+	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/dynamicclient"
+	secretinformer "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret"
+	"knative.dev/pkg/kmeta"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/system"
 
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
 
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	natssclient "knative.dev/eventing-natss/pkg/client/injection/client"
 	"knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel"
 	natssChannelReconciler "knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/loglevel"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
+	"knative.dev/eventing-natss/pkg/reconciler/maintenance"
+	"knative.dev/eventing-natss/pkg/reconciler/resync"
+	"knative.dev/eventing-natss/pkg/reconciler/statuspatch"
+	"knative.dev/eventing-natss/pkg/reconciler/summary"
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+const (
+	// resyncPath is the path of the endpoint resyncing all the channels on POST.
+	resyncPath = "/resync"
+	// statusSummaryPath is the path of the endpoint summarizing the readiness of the channels.
+	statusSummaryPath = "/status/summary"
+
+	// adminPort is the port serving resyncPath and statusSummaryPath.
+	adminPort = 8081
 )
 
 // NewController initializes the controller and is called by the generated code.
 // Registers event handlers to enqueue events.
-func NewController(ctx context.Context) *controller.Impl {
+func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
 
 	logger := logging.FromContext(ctx)
 	channelInformer := natsschannel.Get(ctx)
 	deploymentInformer := deploymentinformer.Get(ctx)
 	serviceInformer := service.Get(ctx)
 	endpointsInformer := endpoints.Get(ctx)
+	serviceAccountInformer := serviceaccount.Get(ctx)
+	roleBindingInformer := rolebinding.Get(ctx)
+	secretInformer := secretinformer.Get(ctx)
 	kubeClient := kubeclient.Get(ctx)
 
 	r := &Reconciler{
@@ -49,24 +84,63 @@ func NewController(ctx context.Context) *controller.Impl {
 		dispatcherNamespace:      system.Namespace(),
 		dispatcherDeploymentName: dispatcherName,
 		dispatcherServiceName:    dispatcherName,
+		natsschannelLister:       channelInformer.Lister(),
 		deploymentLister:         deploymentInformer.Lister(),
 		serviceLister:            serviceInformer.Lister(),
 		endpointsLister:          endpointsInformer.Lister(),
+		conditionRecorder:        events.NewConditionRecorder(events.DefaultDedupWindow),
+		transportEncryption:      &transportEncryption{},
+		natsAuth:                 &natsAuth{},
+		dispatcherReplicas:       &dispatcherReplicas{},
+	}
+	r.namespacedDispatchers = &namespacedDispatchers{
+		kubeClient:           kubeClient,
+		systemNamespace:      r.dispatcherNamespace,
+		natsschannelLister:   r.natsschannelLister,
+		deploymentLister:     r.deploymentLister,
+		serviceLister:        r.serviceLister,
+		serviceAccountLister: serviceAccountInformer.Lister(),
+		roleBindingLister:    roleBindingInformer.Lister(),
 	}
 
+	// The status is patched to keep the fields written by newer versions and by the dispatcher.
+	ctx = statuspatch.WithClient(ctx, statuspatch.Controller)
 	impl := natssChannelReconciler.NewImpl(ctx, r)
+	// Nothing is changed while config-natss enables the maintenance mode, read when the
+	// ConfigMap is first observed.
+	gate := maintenance.NewGate(ctx, "natsschannel-controller", r.natsschannelLister)
+	impl.Reconciler = &maintenanceFilter{leaderAwareReconciler: impl.Reconciler.(leaderAwareReconciler), gate: gate}
 
 	logger.Info("Setting up event handlers")
 	channelInformer.Informer().AddEventHandler(controller.HandleAll(impl.Enqueue))
 
+	// The changes of the dispatcher of the cluster affect all the channels, the namespaced ones
+	// following it, while those of the dispatcher of a namespace affect its namespaced channels.
 	grCh := func(obj interface{}) {
-		impl.GlobalResync(channelInformer.Informer())
+		object, err := kmeta.DeletionHandlingAccessor(obj)
+		if err != nil || object.GetNamespace() == r.dispatcherNamespace {
+			impl.GlobalResync(channelInformer.Informer())
+			return
+		}
+		impl.FilteredGlobalResync(func(obj interface{}) bool {
+			nc, ok := obj.(*v1beta1.NatssChannel)
+			return ok && nc.Namespace == object.GetNamespace() && nc.IsNamespaceScoped()
+		}, channelInformer.Informer())
+	}
+	filterFunc := func(obj interface{}) bool {
+		object, err := kmeta.DeletionHandlingAccessor(obj)
+		if err != nil {
+			return false
+		}
+		if object.GetNamespace() == r.dispatcherNamespace {
+			return object.GetName() == r.dispatcherDeploymentName || object.GetName() == r.dispatcherServiceName
+		}
+		return object.GetName() == resources.DispatcherName
 	}
-	filterFunc := controller.FilterWithNameAndNamespace(r.dispatcherNamespace, r.dispatcherDeploymentName)
 
 	// Set up watches for dispatcher resources we care about, since any changes to these
 	// resources will affect our Channels. So, set up a watch here, that will cause
-	// a global Resync for all the channels to take stock of their health when these change.
+	// a resync of the channels they serve to take stock of their health when these change.
 	deploymentInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
 		FilterFunc: filterFunc,
 		Handler:    controller.HandleAll(grCh),
@@ -79,6 +153,121 @@ func NewController(ctx context.Context) *controller.Impl {
 		FilterFunc: filterFunc,
 		Handler:    controller.HandleAll(grCh),
 	})
+	serviceAccountInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: filterFunc,
+		Handler:    controller.HandleAll(grCh),
+	})
+	roleBindingInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: filterFunc,
+		Handler:    controller.HandleAll(grCh),
+	})
+	// The dispatchers of the namespaces lose the access to the system namespace along their last
+	// namespaced channel, the other resources being garbage collected.
+	channelInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: unlessMaintenance(gate, r.namespacedDispatchers.channelDeleted(ctx)),
+	})
+
+	// The controller maintains the channel of the end to end probe of the dispatcher.
+	probes := &probeChannels{client: natssclient.Get(ctx), lister: r.natsschannelLister}
+	channelInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isProbeChannel,
+		Handler:    controller.HandleAll(unlessMaintenance(gate, func(interface{}) { go probes.reconcile(ctx) })),
+	})
+
+	// The controller maintains the certificates issued by cert-manager, the addresses of the
+	// channels following the receiver certificate.
+	certs := &certificates{
+		kubeClient:         kubeClient,
+		dynamicClient:      dynamicclient.Get(ctx),
+		systemNamespace:    r.dispatcherNamespace,
+		dispatcherName:     r.dispatcherDeploymentName,
+		natsschannelLister: r.natsschannelLister,
+		deploymentLister:   r.deploymentLister,
+		secretLister:       secretInformer.Lister(),
+		receiverIssued: func(issued bool, caCerts string) {
+			if r.transportEncryption.setIssued(issued, caCerts) {
+				impl.GlobalResync(channelInformer.Informer())
+			}
+		},
+	}
+	reconcileCerts := unlessMaintenance(gate, func(interface{}) { go certs.reconcile(ctx) })
+	secretInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isCertificateSecret,
+		Handler:    controller.HandleAll(reconcileCerts),
+	})
+	deploymentInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterWithNameAndNamespace(r.dispatcherNamespace, r.dispatcherDeploymentName),
+		Handler:    controller.HandleAll(reconcileCerts),
+	})
+	// The namespaces of the channels are covered by the certificate of the receiver.
+	channelInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    reconcileCerts,
+		DeleteFunc: reconcileCerts,
+	})
+
+	loggers := loglevel.NewFromContext(ctx, cmw)
+	resyncer := resync.New("controller", r.natsschannelLister, impl.EnqueueSlowKey, resync.ChannelNotReady)
+	// The resyncs on demand go through the rate limiter of the work queue to absorb the storms.
+	onDemand := resync.NewOnDemand("controller", r.natsschannelLister, func(key types.NamespacedName) {
+		impl.WorkQueue().AddRateLimited(key)
+	}, loggers.Named("controller.resync"))
+	config.Watch(ctx, cmw, func(c *config.Config) {
+		// The channels, deleted ones included, are reconciled again when the maintenance ends,
+		// along the probe channels and the access of the dispatchers of the namespaces.
+		if gate.Set(c.MaintenanceMode) {
+			impl.GlobalResync(channelInformer.Informer())
+			r.namespacedDispatchers.channelDeleted(ctx)(nil)
+		}
+		resyncer.SetConfig(c.ControllerResync)
+		onDemand.Observe(c.ResyncRequest)
+		if !gate.Enabled() {
+			go probes.setNamespace(ctx, c.Probe.Namespace)
+			go certs.setConfig(ctx, c.CertManager)
+		}
+		// Validated when the controller starts and on every change, the channels warning while
+		// the credentials are invalid.
+		if r.natsAuth.validate(ctx, c.AuthSecretName) {
+			impl.GlobalResync(channelInformer.Informer())
+		}
+		if r.dispatcherReplicas.setRequired(c.DispatcherRequireReplicas) {
+			impl.GlobalResync(channelInformer.Informer())
+		}
+		// Both are set, a change of either one updating the addresses of the channels.
+		receiverChanged := r.transportEncryption.setReceiver(c.Security)
+		if r.transportEncryption.setAdvertised(c.Address) || receiverChanged {
+			impl.GlobalResync(channelInformer.Informer())
+		}
+	})
+	// The addresses of the channels follow the transport encryption mode of the cluster.
+	config.WatchFeatures(ctx, cmw, func(f *config.Features) {
+		if r.transportEncryption.setMode(f.TransportEncryption) {
+			logger.Infow("Transport encryption mode changed", zap.String("mode", string(f.TransportEncryption)))
+			impl.GlobalResync(channelInformer.Informer())
+		}
+	})
+	go resyncer.Run(logging.WithLogger(ctx, loggers.Named("controller.resync")))
+	summarizer := summary.New(r.natsschannelLister, summary.DefaultCacheTTL)
+	go summarizer.Run(ctx)
+	go serveAdmin(ctx, onDemand, summarizer)
+	go runtimemetrics.Run(ctx, runtimemetrics.Controller, runtimemetrics.DefaultPeriod, r.conditionRecorder.Sizer(runtimemetrics.Controller))
 
 	return impl
 }
+
+// serveAdmin serves the resyncs on demand on resyncPath and the summary of the channels on
+// statusSummaryPath until ctx is done.
+func serveAdmin(ctx context.Context, onDemand, summary http.Handler) {
+	logger := logging.FromContext(ctx)
+	mux := http.NewServeMux()
+	mux.Handle(resyncPath, onDemand)
+	mux.Handle(statusSummaryPath, summary)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", adminPort), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	// The endpoint is not worth stopping the controller.
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Errorw("Error serving the admin endpoints", zap.Error(err))
+	}
+}
@@ -20,18 +20,31 @@ import (
 	"context"
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
 
+	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/injection"
+	"knative.dev/pkg/logging"
 
 	_ "knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel/fake"
+	"knative.dev/eventing-natss/pkg/config"
 	_ "knative.dev/pkg/client/injection/kube/informers/apps/v1/deployment/fake"
 	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/endpoints/fake"
 	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/service/fake"
+	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/serviceaccount/fake"
+	_ "knative.dev/pkg/client/injection/kube/informers/rbac/v1/rolebinding/fake"
+	_ "knative.dev/pkg/injection/clients/dynamicclient/fake"
+	_ "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret/fake"
 )
 
 func TestNewController(t *testing.T) {
 	ctx, _ := injection.Fake.SetupInformers(context.Background(), &rest.Config{})
 	// no panic
-	_ = NewController(ctx)
+	_ = NewController(ctx, configmap.NewStaticWatcher(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.FeaturesConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName()}},
+	))
 }
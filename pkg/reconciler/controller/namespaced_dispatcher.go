@@ -0,0 +1,279 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+)
+
+// namespacedDispatcherFailed is the reason of the DispatcherReady condition of the channels
+// annotated with eventing.knative.dev/scope: namespace whose dispatcher could not be reconciled.
+const namespacedDispatcherFailed = "NamespacedDispatcherFailed"
+
+// errNoDispatcherTemplate is returned when the dispatcher of the cluster, which the dispatchers of
+// the namespaces are modeled on, does not exist.
+var errNoDispatcherTemplate = errors.New("the dispatcher Deployment of the system namespace does not exist")
+
+// namespacedDispatchers maintains the dispatchers of the namespaces, which serve the channels
+// annotated with eventing.knative.dev/scope: namespace. Their resources are owned by the channels
+// they serve, and garbage collected along the last of them.
+type namespacedDispatchers struct {
+	kubeClient      kubernetes.Interface
+	systemNamespace string
+
+	natsschannelLister   listers.NatssChannelLister
+	deploymentLister     appsv1listers.DeploymentLister
+	serviceLister        corev1listers.ServiceLister
+	serviceAccountLister corev1listers.ServiceAccountLister
+	roleBindingLister    rbacv1listers.RoleBindingLister
+
+	// mu serializes the updates of the RoleBinding of the system namespace, shared by the
+	// namespaces.
+	mu sync.Mutex
+}
+
+// reconcile ensures the dispatcher of the namespace of nc, whose resources nc owns along the other
+// namespaced channels of the namespace. template is the dispatcher Deployment of the cluster, nil
+// when it does not exist.
+func (n *namespacedDispatchers) reconcile(ctx context.Context, nc *v1beta1.NatssChannel, template *appsv1.Deployment) error {
+	container := dispatcherContainer(template)
+	if container == nil {
+		return errNoDispatcherTemplate
+	}
+	owners := []metav1.OwnerReference{resources.DispatcherOwnerRef(nc)}
+	if err := n.reconcileConfigAccess(ctx); err != nil {
+		return err
+	}
+	if err := n.reconcileServiceAccount(ctx, resources.MakeNamespacedDispatcherServiceAccount(nc.Namespace, owners)); err != nil {
+		return err
+	}
+	if err := n.reconcileRoleBinding(ctx, resources.MakeNamespacedDispatcherRoleBinding(nc.Namespace, owners)); err != nil {
+		return err
+	}
+	if err := n.reconcileDeployment(ctx, resources.MakeNamespacedDispatcher(nc.Namespace, n.systemNamespace, container, template.Spec.Template.Spec.TerminationGracePeriodSeconds, owners)); err != nil {
+		return err
+	}
+	return n.reconcileService(ctx, resources.MakeNamespacedDispatcherService(nc.Namespace, owners))
+}
+
+// dispatcherContainer returns the dispatcher container of d, nil when d is nil or has none.
+func dispatcherContainer(d *appsv1.Deployment) *corev1.Container {
+	if d == nil || len(d.Spec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	for i, c := range d.Spec.Template.Spec.Containers {
+		if c.Name == resources.DispatcherContainerName {
+			return &d.Spec.Template.Spec.Containers[i]
+		}
+	}
+	return &d.Spec.Template.Spec.Containers[0]
+}
+
+func (n *namespacedDispatchers) reconcileServiceAccount(ctx context.Context, desired *corev1.ServiceAccount) error {
+	sa, err := n.serviceAccountLister.ServiceAccounts(desired.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		_, err = n.kubeClient.CoreV1().ServiceAccounts(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	refs, changed := withOwners(sa.OwnerReferences, desired.OwnerReferences)
+	if !changed {
+		return nil
+	}
+	sa = sa.DeepCopy()
+	sa.OwnerReferences = refs
+	_, err = n.kubeClient.CoreV1().ServiceAccounts(sa.Namespace).Update(ctx, sa, metav1.UpdateOptions{})
+	return err
+}
+
+func (n *namespacedDispatchers) reconcileRoleBinding(ctx context.Context, desired *rbacv1.RoleBinding) error {
+	rb, err := n.roleBindingLister.RoleBindings(desired.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		_, err = n.kubeClient.RbacV1().RoleBindings(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if rb.RoleRef != desired.RoleRef {
+		// The role of a RoleBinding cannot change, it is recreated by the next reconciliation.
+		return n.kubeClient.RbacV1().RoleBindings(rb.Namespace).Delete(ctx, rb.Name, metav1.DeleteOptions{})
+	}
+	refs, changed := withOwners(rb.OwnerReferences, desired.OwnerReferences)
+	if !changed && equality.Semantic.DeepEqual(rb.Subjects, desired.Subjects) {
+		return nil
+	}
+	rb = rb.DeepCopy()
+	rb.OwnerReferences = refs
+	rb.Subjects = desired.Subjects
+	_, err = n.kubeClient.RbacV1().RoleBindings(rb.Namespace).Update(ctx, rb, metav1.UpdateOptions{})
+	return err
+}
+
+func (n *namespacedDispatchers) reconcileDeployment(ctx context.Context, desired *appsv1.Deployment) error {
+	d, err := n.deploymentLister.Deployments(desired.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		_, err = n.kubeClient.AppsV1().Deployments(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	// The Deployment follows the dispatcher of the cluster, such as its image on upgrades.
+	desired.Spec.Replicas = persistedReplicas(ctx, d, desired.Spec.Replicas)
+	refs, changed := withOwners(d.OwnerReferences, desired.OwnerReferences)
+	if !changed && equality.Semantic.DeepDerivative(desired.Spec, d.Spec) {
+		return nil
+	}
+	d = d.DeepCopy()
+	d.OwnerReferences = refs
+	d.Spec = desired.Spec
+	_, err = n.kubeClient.AppsV1().Deployments(d.Namespace).Update(ctx, d, metav1.UpdateOptions{})
+	return err
+}
+
+// persistedReplicas returns the replicas of the dispatcher Deployment d of a namespace, desired
+// unless d is scaled to zero with messaging.AllowZeroReplicasAnnotationKey. The controller owning
+// the replicas, it refuses to persist zero without the annotation, restoring desired.
+func persistedReplicas(ctx context.Context, d *appsv1.Deployment, desired *int32) *int32 {
+	if d.Spec.Replicas == nil || *d.Spec.Replicas != 0 {
+		return desired
+	}
+	if d.Annotations[messaging.AllowZeroReplicasAnnotationKey] == "true" {
+		return ptr.Int32(0)
+	}
+	logging.FromContext(ctx).Warnw("Restoring the replicas of the dispatcher scaled to zero without the annotation allowing it",
+		zap.String("namespace", d.Namespace), zap.String("annotation", messaging.AllowZeroReplicasAnnotationKey))
+	return desired
+}
+
+func (n *namespacedDispatchers) reconcileService(ctx context.Context, desired *corev1.Service) error {
+	svc, err := n.serviceLister.Services(desired.Namespace).Get(desired.Name)
+	if apierrs.IsNotFound(err) {
+		_, err = n.kubeClient.CoreV1().Services(desired.Namespace).Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	// The ports are corrected by reconcileDispatcherPorts, as those of the dispatcher of the cluster.
+	refs, changed := withOwners(svc.OwnerReferences, desired.OwnerReferences)
+	if !changed {
+		return nil
+	}
+	svc = svc.DeepCopy()
+	svc.OwnerReferences = refs
+	_, err = n.kubeClient.CoreV1().Services(svc.Namespace).Update(ctx, svc, metav1.UpdateOptions{})
+	return err
+}
+
+// withOwners returns refs along the owners it lacks, and whether it lacked any.
+func withOwners(refs, owners []metav1.OwnerReference) ([]metav1.OwnerReference, bool) {
+	// refs belongs to the informer cache.
+	refs = append([]metav1.OwnerReference(nil), refs...)
+	changed := false
+	for _, owner := range owners {
+		found := false
+		for _, ref := range refs {
+			if ref.UID == owner.UID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			refs = append(refs, owner)
+			changed = true
+		}
+	}
+	return refs, changed
+}
+
+// reconcileConfigAccess grants the dispatchers of the namespaces with namespaced channels, and only
+// those, the configuration of the system namespace. The RoleBinding granting it cannot be owned by
+// the channels of other namespaces: it is deleted once the last namespaced channel is.
+func (n *namespacedDispatchers) reconcileConfigAccess(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	channels, err := n.natsschannelLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	namespaces := sets.NewString()
+	for _, nc := range channels {
+		if nc.IsNamespaceScoped() {
+			namespaces.Insert(nc.Namespace)
+		}
+	}
+	desired := resources.MakeNamespacedDispatchersRoleBinding(n.systemNamespace, namespaces.List())
+
+	rbs := n.kubeClient.RbacV1().RoleBindings(n.systemNamespace)
+	rb, err := n.roleBindingLister.RoleBindings(n.systemNamespace).Get(desired.Name)
+	switch {
+	case apierrs.IsNotFound(err):
+		if namespaces.Len() == 0 {
+			return nil
+		}
+		_, err = rbs.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	case err != nil:
+		return err
+	case namespaces.Len() == 0:
+		err = rbs.Delete(ctx, rb.Name, metav1.DeleteOptions{})
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	case equality.Semantic.DeepEqual(rb.Subjects, desired.Subjects):
+		return nil
+	}
+	rb = rb.DeepCopy()
+	rb.Subjects = desired.Subjects
+	_, err = rbs.Update(ctx, rb, metav1.UpdateOptions{})
+	return err
+}
+
+// channelDeleted revokes the access to the configuration of the system namespace of the
+// dispatcher of the namespace of a deleted namespaced channel, when it was the last one.
+func (n *namespacedDispatchers) channelDeleted(ctx context.Context) func(interface{}) {
+	return func(interface{}) {
+		if err := n.reconcileConfigAccess(ctx); err != nil {
+			logging.FromContext(ctx).Errorw("Failed to update the access of the dispatchers of the namespaces", zap.Error(err))
+		}
+	}
+}
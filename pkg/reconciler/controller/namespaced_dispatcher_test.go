@@ -0,0 +1,361 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fakekubeclientset "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/network"
+	"knative.dev/pkg/ptr"
+	. "knative.dev/pkg/reconciler/testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	fakeclientset "knative.dev/eventing-natss/pkg/client/injection/client/fake"
+	"knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+const teamNS = "team-namespace"
+
+func TestNamespacedDispatcher(t *testing.T) {
+	ncKey := teamNS + "/" + ncName
+	channelAddress := network.GetServiceHostname(resources.MakeChannelServiceName(ncName), teamNS)
+	newChannel := func(opts ...reconciletesting.NatssChannelOption) *v1beta1.NatssChannel {
+		opts = append([]reconciletesting.NatssChannelOption{reconciletesting.WithNatssChannelNamespaceScoped}, opts...)
+		nc := reconciletesting.NewNatssChannel(ncName, teamNS, opts...)
+		nc.UID = "channel-uid"
+		return nc
+	}
+	// other is another namespaced channel of the namespace, owning the existing dispatcher.
+	other := reconciletesting.NewNatssChannel("other", teamNS, reconciletesting.WithNatssChannelNamespaceScoped)
+	other.UID = "other-uid"
+	owners := []metav1.OwnerReference{resources.DispatcherOwnerRef(newChannel())}
+	bothOwners := []metav1.OwnerReference{resources.DispatcherOwnerRef(other), resources.DispatcherOwnerRef(newChannel())}
+	template := makeTemplateDeployment()
+
+	table := TableTest{
+		{
+			Name: "dispatcher created",
+			// The RoleBinding of the system namespace is created along the resources of the namespace.
+			SkipNamespaceValidation: true,
+			Key:                     ncKey,
+			Objects: []runtime.Object{
+				template,
+				newChannel(),
+			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionFalse(v1beta1.NatssChannelConditionDispatcherReady, dispatcherDeploymentNotFound, "Dispatcher Deployment does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionEndpointsReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionServiceReady, dispatcherServiceNotFound, "Dispatcher Service does not exist"),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: newChannel(
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentNotReady(dispatcherDeploymentNotFound, "Dispatcher Deployment does not exist"),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelAddress),
+					reconciletesting.Addressable(),
+					reconciletesting.WithNatssChannelServiceNotReady(dispatcherServiceNotFound, "Dispatcher Service does not exist"),
+					reconciletesting.WithNatssChannelEndpointsNotReady(dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				),
+			}},
+			WantCreates: []runtime.Object{
+				resources.MakeNamespacedDispatchersRoleBinding(testNS, []string{teamNS}),
+				resources.MakeNamespacedDispatcherServiceAccount(teamNS, owners),
+				resources.MakeNamespacedDispatcherRoleBinding(teamNS, owners),
+				resources.MakeNamespacedDispatcher(teamNS, testNS, &template.Spec.Template.Spec.Containers[0], template.Spec.Template.Spec.TerminationGracePeriodSeconds, owners),
+				resources.MakeNamespacedDispatcherService(teamNS, owners),
+				makeNamespacedChannelService(newChannel()),
+			},
+		}, {
+			Name: "dispatcher shared",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				template,
+				other,
+				newChannel(),
+				resources.MakeNamespacedDispatchersRoleBinding(testNS, []string{teamNS}),
+				resources.MakeNamespacedDispatcherServiceAccount(teamNS, bothOwners[:1]),
+				resources.MakeNamespacedDispatcherRoleBinding(teamNS, bothOwners[:1]),
+				makeReadyNamespacedDispatcher(bothOwners[:1]),
+				resources.MakeNamespacedDispatcherService(teamNS, bothOwners[:1]),
+				makeReadyNamespacedEndpoints(),
+				makeNamespacedChannelService(newChannel()),
+			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: newChannel(
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelAddress),
+					reconciletesting.Addressable(),
+				),
+			}},
+			WantUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: resources.MakeNamespacedDispatcherServiceAccount(teamNS, bothOwners),
+			}, {
+				Object: resources.MakeNamespacedDispatcherRoleBinding(teamNS, bothOwners),
+			}, {
+				Object: makeReadyNamespacedDispatcher(bothOwners),
+			}, {
+				Object: resources.MakeNamespacedDispatcherService(teamNS, bothOwners),
+			}},
+		}, {
+			Name: "dispatcher of the cluster missing",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				newChannel(),
+			},
+			WantErr: true,
+			WantEvents: []string{
+				conditionFalse(v1beta1.NatssChannelConditionDispatcherReady, namespacedDispatcherFailed, "Failed to reconcile the dispatcher of the namespace: "+errNoDispatcherTemplate.Error()),
+				conditionFalse(v1beta1.NatssChannelConditionReady, namespacedDispatcherFailed, "Failed to reconcile the dispatcher of the namespace: "+errNoDispatcherTemplate.Error()),
+				Eventf(corev1.EventTypeWarning, "InternalError", errNoDispatcherTemplate.Error()),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: newChannel(
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentNotReady(namespacedDispatcherFailed, "Failed to reconcile the dispatcher of the namespace: "+errNoDispatcherTemplate.Error()),
+				),
+			}},
+		},
+	}
+
+	table.Test(t, reconciletesting.MakeFactory(func(ctx context.Context, listers *reconciletesting.Listers) controller.Reconciler {
+		r := &Reconciler{
+			dispatcherNamespace:      testNS,
+			dispatcherDeploymentName: dispatcherDeploymentName,
+			dispatcherServiceName:    dispatcherServiceName,
+			kubeClientSet:            fakekubeclient.Get(ctx),
+			natsschannelLister:       listers.GetNatssChannelLister(),
+			deploymentLister:         listers.GetDeploymentLister(),
+			serviceLister:            listers.GetServiceLister(),
+			endpointsLister:          listers.GetEndpointsLister(),
+			conditionRecorder:        events.NewConditionRecorder(events.DefaultDedupWindow),
+			transportEncryption:      &transportEncryption{},
+			natsAuth:                 &natsAuth{},
+			dispatcherReplicas:       &dispatcherReplicas{},
+		}
+		r.namespacedDispatchers = &namespacedDispatchers{
+			kubeClient:           fakekubeclient.Get(ctx),
+			systemNamespace:      testNS,
+			natsschannelLister:   listers.GetNatssChannelLister(),
+			deploymentLister:     listers.GetDeploymentLister(),
+			serviceLister:        listers.GetServiceLister(),
+			serviceAccountLister: listers.GetServiceAccountLister(),
+			roleBindingLister:    listers.GetRoleBindingLister(),
+		}
+		return natsschannel.NewReconciler(ctx, logging.FromContext(ctx),
+			fakeclientset.Get(ctx), listers.GetNatssChannelLister(),
+			controller.GetEventRecorder(ctx),
+			r)
+	}))
+}
+
+func TestNamespacedDispatchersConfigAccess(t *testing.T) {
+	bindings := func(namespaces ...string) []runtime.Object {
+		return []runtime.Object{resources.MakeNamespacedDispatchersRoleBinding(testNS, namespaces)}
+	}
+	namespaced := func(namespace string) runtime.Object {
+		return reconciletesting.NewNatssChannel(ncName, namespace, reconciletesting.WithNatssChannelNamespaceScoped)
+	}
+
+	testCases := map[string]struct {
+		objects []runtime.Object
+		want    *rbacv1.RoleBinding
+	}{
+		"namespace added": {
+			objects: append(bindings("team-a"), namespaced("team-a"), namespaced("team-b")),
+			want:    resources.MakeNamespacedDispatchersRoleBinding(testNS, []string{"team-a", "team-b"}),
+		},
+		"namespace removed": {
+			objects: append(bindings("team-a", "team-b"), namespaced("team-b"), reconciletesting.NewNatssChannel(ncName, "team-a")),
+			want:    resources.MakeNamespacedDispatchersRoleBinding(testNS, []string{"team-b"}),
+		},
+		"last namespace removed": {
+			objects: append(bindings("team-a"), reconciletesting.NewNatssChannel(ncName, "team-a")),
+		},
+		"no namespaced channel": {
+			objects: []runtime.Object{reconciletesting.NewNatssChannel(ncName, "team-a")},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			ls := reconciletesting.NewListers(tc.objects)
+			kubeClient := fakekubeclientset.NewSimpleClientset(ls.GetKubeObjects()...)
+			n := &namespacedDispatchers{
+				kubeClient:         kubeClient,
+				systemNamespace:    testNS,
+				natsschannelLister: ls.GetNatssChannelLister(),
+				roleBindingLister:  ls.GetRoleBindingLister(),
+			}
+			n.channelDeleted(context.Background())(nil)
+
+			got, err := kubeClient.RbacV1().RoleBindings(testNS).Get(context.Background(), resources.NamespacedDispatchersRoleBindingName, metav1.GetOptions{})
+			if tc.want == nil {
+				if !apierrs.IsNotFound(err) {
+					t.Fatalf("RoleBinding = %v, %v, want none", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to get the RoleBinding: %v", err)
+			}
+			if diff := cmp.Diff(tc.want.Subjects, got.Subjects); diff != "" {
+				t.Errorf("unexpected subjects (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestNamespacedDispatcherZeroReplicas(t *testing.T) {
+	owners := []metav1.OwnerReference{{Name: ncName, UID: "channel-uid"}}
+	scaled := func(replicas int32, annotations map[string]string) *appsv1.Deployment {
+		d := makeReadyNamespacedDispatcher(owners)
+		d.Annotations = annotations
+		d.Spec.Replicas = ptr.Int32(replicas)
+		return d
+	}
+	allowed := map[string]string{messaging.AllowZeroReplicasAnnotationKey: "true"}
+
+	testCases := map[string]struct {
+		existing *appsv1.Deployment
+		want     int32
+	}{
+		"scaled to zero": {
+			existing: scaled(0, nil),
+			want:     1,
+		},
+		"scaled to zero with the annotation": {
+			existing: scaled(0, allowed),
+			want:     0,
+		},
+		"annotation not allowing zero": {
+			existing: scaled(0, map[string]string{messaging.AllowZeroReplicasAnnotationKey: "false"}),
+			want:     1,
+		},
+		"scaled up with the annotation": {
+			existing: scaled(3, allowed),
+			want:     1,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			ls := reconciletesting.NewListers([]runtime.Object{tc.existing})
+			kubeClient := fakekubeclientset.NewSimpleClientset(ls.GetKubeObjects()...)
+			n := &namespacedDispatchers{kubeClient: kubeClient, deploymentLister: ls.GetDeploymentLister()}
+			template := makeTemplateDeployment()
+			desired := resources.MakeNamespacedDispatcher(teamNS, testNS, &template.Spec.Template.Spec.Containers[0], template.Spec.Template.Spec.TerminationGracePeriodSeconds, owners)
+			if err := n.reconcileDeployment(context.Background(), desired); err != nil {
+				t.Fatalf("reconcileDeployment() = %v", err)
+			}
+
+			got, err := kubeClient.AppsV1().Deployments(teamNS).Get(context.Background(), resources.DispatcherName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get the Deployment: %v", err)
+			}
+			if got.Spec.Replicas == nil || *got.Spec.Replicas != tc.want {
+				t.Errorf("Replicas = %v, want %d", got.Spec.Replicas, tc.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherOwnerRefs(t *testing.T) {
+	a := metav1.OwnerReference{Name: "a", UID: types.UID("a")}
+	b := metav1.OwnerReference{Name: "b", UID: types.UID("b")}
+	if refs, changed := withOwners([]metav1.OwnerReference{a}, []metav1.OwnerReference{a}); changed || len(refs) != 1 {
+		t.Errorf("withOwners() = %v, %t, want the existing owner unchanged", refs, changed)
+	}
+	existing := make([]metav1.OwnerReference, 1, 2)
+	existing[0] = a
+	refs, changed := withOwners(existing, []metav1.OwnerReference{b})
+	if !changed || len(refs) != 2 || refs[1].UID != b.UID {
+		t.Errorf("withOwners() = %v, %t, want b added", refs, changed)
+	}
+	if existing[:2][1].UID == b.UID {
+		t.Error("withOwners() modified the existing references")
+	}
+}
+
+// makeTemplateDeployment returns the dispatcher of the cluster, which the dispatchers of the
+// namespaces are modeled on.
+func makeTemplateDeployment() *appsv1.Deployment {
+	d := makeReadyDeploymentWithReceiverPort(util.DispatcherReceiverPort)
+	d.Spec.Template.Spec.Containers[0].Image = "dispatcher-image"
+	d.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "DEFAULT_NATSS_URL", Value: "nats://natss:4222"},
+		{Name: "SYSTEM_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+	}
+	d.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
+		Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/readyz", Port: intstr.FromString("receiver")}},
+	}
+	d.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.Int64(120)
+	return d
+}
+
+func makeReadyNamespacedDispatcher(owners []metav1.OwnerReference) *appsv1.Deployment {
+	template := makeTemplateDeployment()
+	d := resources.MakeNamespacedDispatcher(teamNS, testNS, &template.Spec.Template.Spec.Containers[0], template.Spec.Template.Spec.TerminationGracePeriodSeconds, owners)
+	d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue}}
+	return d
+}
+
+func makeReadyNamespacedEndpoints() *corev1.Endpoints {
+	e := makeReadyEndpoints()
+	e.Namespace = teamNS
+	e.Name = resources.DispatcherName
+	return e
+}
+
+func makeNamespacedChannelService(nc *v1beta1.NatssChannel) *corev1.Service {
+	svc, _ := resources.MakeK8sService(nc, resources.ExternalService(teamNS, resources.DispatcherName))
+	svc.OwnerReferences = []metav1.OwnerReference{*kmeta.NewControllerRef(nc)}
+	return svc
+}
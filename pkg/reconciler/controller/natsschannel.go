@@ -22,7 +22,7 @@ import (
 
 	"go.uber.org/zap"
 
-	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/network"
 	"knative.dev/pkg/reconciler"
@@ -36,7 +36,9 @@ import (
 
 	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
 	natssChannelReconciler "knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
 	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
 )
 
 const (
@@ -62,15 +64,29 @@ type Reconciler struct {
 	dispatcherDeploymentName string
 	dispatcherServiceName    string
 
-	deploymentLister appsv1listers.DeploymentLister
-	serviceLister    corev1listers.ServiceLister
-	endpointsLister  corev1listers.EndpointsLister
+	natsschannelLister listers.NatssChannelLister
+	deploymentLister   appsv1listers.DeploymentLister
+	serviceLister      corev1listers.ServiceLister
+	endpointsLister    corev1listers.EndpointsLister
+
+	conditionRecorder *events.ConditionRecorder
+	// transportEncryption decides the addresses of the channels.
+	transportEncryption *transportEncryption
+	// natsAuth reports the invalid NATS credentials of the dispatcher on the channels.
+	natsAuth *natsAuth
+	// dispatcherReplicas fails the channels while the dispatcher is scaled to zero.
+	dispatcherReplicas *dispatcherReplicas
+	// namespacedDispatchers maintains the dispatchers of the channels annotated with
+	// eventing.knative.dev/scope: namespace.
+	namespacedDispatchers *namespacedDispatchers
 }
 
 var _ natssChannelReconciler.Interface = (*Reconciler)(nil)
 
 func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel) reconciler.Event {
 	logger := logging.FromContext(ctx)
+	defer r.recordConditionTransitions(ctx, nc)
+	r.natsAuth.reconcile(ctx, nc)
 
 	// We reconcile the status of the Channel by looking at:
 	// 1. Dispatcher Deployment for it's readiness.
@@ -78,8 +94,21 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel
 	// 3. Dispatcher endpoints to ensure that there's something backing the Service.
 	// 4. K8s service representing the channel that will use ExternalName to point to the Dispatcher k8s service.
 
+	// The namespaced channels are served by a dispatcher of their namespace, modeled on the one of
+	// the cluster.
+	dispatcherNamespace, deploymentName, serviceName := r.dispatcherOf(nc)
+	if nc.IsNamespaceScoped() {
+		template, _ := r.deploymentLister.Deployments(r.dispatcherNamespace).Get(r.dispatcherDeploymentName)
+		if err := r.namespacedDispatchers.reconcile(ctx, nc, template); err != nil {
+			logger.Errorw("Failed to reconcile the dispatcher of the namespace", zap.Error(err))
+			nc.Status.MarkDispatcherFailed(namespacedDispatcherFailed, "Failed to reconcile the dispatcher of the namespace: %v", err)
+			return err
+		}
+	}
+
 	// Get the Dispatcher Deployment and propagate the status to the Channel
-	if d, err := r.deploymentLister.Deployments(r.dispatcherNamespace).Get(r.dispatcherDeploymentName); err != nil {
+	d, err := r.deploymentLister.Deployments(dispatcherNamespace).Get(deploymentName)
+	if err != nil {
 		logger.Error("Unable to get the dispatcher Deployment", zap.Error(err))
 		if apierrs.IsNotFound(err) {
 			nc.Status.MarkDispatcherFailed(dispatcherDeploymentNotFound, "Dispatcher Deployment does not exist")
@@ -88,12 +117,13 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel
 		}
 	} else {
 		nc.Status.PropagateDispatcherStatus(&d.Status)
+		r.dispatcherReplicas.reconcile(nc, d)
 	}
 
 	// Get the Dispatcher Service and propagate the status to the Channel in case it does not exist.
-	// We don't do anything with the service because it's status contains nothing useful, so just do
-	// an existence check. Then below we check the endpoints targeting it.
-	if _, err := r.serviceLister.Services(r.dispatcherNamespace).Get(r.dispatcherServiceName); err != nil {
+	// Its status contains nothing useful, so just do an existence check and make sure its ports
+	// route to the receiver of the dispatcher. Then below we check the endpoints targeting it.
+	if svc, err := r.serviceLister.Services(dispatcherNamespace).Get(serviceName); err != nil {
 		logger.Error("Unable to get the dispatcher service", zap.Error(err))
 		if apierrs.IsNotFound(err) {
 			nc.Status.MarkServiceFailed(dispatcherServiceNotFound, "Dispatcher Service does not exist")
@@ -102,11 +132,12 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel
 		}
 	} else {
 		nc.Status.MarkServiceTrue()
+		r.reconcileDispatcherPorts(ctx, nc, svc, d)
 	}
 
 	// Get the Dispatcher Service Endpoints and propagate the status to the Channel
 	// endpoints has the same name as the service, so not a bug.
-	if e, err := r.endpointsLister.Endpoints(r.dispatcherNamespace).Get(r.dispatcherServiceName); err != nil {
+	if e, err := r.endpointsLister.Endpoints(dispatcherNamespace).Get(serviceName); err != nil {
 		logger.Error("Unable to get the dispatcher endpoints", zap.Error(err))
 		if apierrs.IsNotFound(err) {
 			nc.Status.MarkEndpointsFailed(dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist")
@@ -126,10 +157,7 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel
 		nc.Status.MarkChannelServiceFailed(channelServiceFailed, fmt.Sprintf("Channel Service failed: %s", err))
 	} else {
 		nc.Status.MarkChannelServiceTrue()
-		nc.Status.SetAddress(&apis.URL{
-			Scheme: "http",
-			Host:   network.GetServiceHostname(svc.Name, svc.Namespace),
-		})
+		r.transportEncryption.setAddresses(nc, network.GetServiceHostname(svc.Name, svc.Namespace))
 	}
 
 	// Ok, so now the Dispatcher Deployment & Service have been created, we're golden since the
@@ -137,21 +165,41 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel
 	return nil
 }
 
+// dispatcherOf returns the namespace and the names of the Deployment and Service of the dispatcher
+// serving nc.
+func (r *Reconciler) dispatcherOf(nc *v1beta1.NatssChannel) (namespace, deployment, service string) {
+	if nc.IsNamespaceScoped() {
+		return nc.Namespace, resources.DispatcherName, resources.DispatcherName
+	}
+	return r.dispatcherNamespace, r.dispatcherDeploymentName, r.dispatcherServiceName
+}
+
+// recordConditionTransitions emits an event for every condition of nc that changed compared to the
+// version of the object stored in the informer cache.
+func (r *Reconciler) recordConditionTransitions(ctx context.Context, nc *v1beta1.NatssChannel) {
+	var before duckv1.Conditions
+	if original, err := r.natsschannelLister.NatssChannels(nc.Namespace).Get(nc.Name); err == nil {
+		before = original.Status.Conditions
+	}
+	r.conditionRecorder.RecordConditions(ctx, nc, before, nc.Status.Conditions)
+}
+
 func (r *Reconciler) reconcileChannelService(ctx context.Context, channel *v1beta1.NatssChannel) (*corev1.Service, error) {
 	logger := logging.FromContext(ctx)
 	// Get the  Service and propagate the status to the Channel in case it does not exist.
-	// We don't do anything with the service because it's status contains nothing useful, so just do
-	// an existence check. Then below we check the endpoints targeting it.
+	// Its status contains nothing useful, so just check its existence and that its spec still points
+	// to the dispatcher. Then below we check the endpoints targeting it.
 	// We may change this name later, so we have to ensure we use proper addressable when resolving these.
-	svc, err := r.serviceLister.Services(channel.Namespace).Get(resources.MakeChannelServiceName(channel.Name))
+	dispatcherNamespace, _, serviceName := r.dispatcherOf(channel)
+	desired, err := resources.MakeK8sService(channel, resources.ExternalService(dispatcherNamespace, serviceName))
+	if err != nil {
+		logger.Error("Failed to create the channel service object", zap.Error(err))
+		return nil, err
+	}
+	svc, err := r.serviceLister.Services(channel.Namespace).Get(desired.Name)
 	if err != nil {
 		if apierrs.IsNotFound(err) {
-			svc, err = resources.MakeK8sService(channel, resources.ExternalService(r.dispatcherNamespace, r.dispatcherServiceName))
-			if err != nil {
-				logger.Error("Failed to create the channel service object", zap.Error(err))
-				return nil, err
-			}
-			svc, err = r.kubeClientSet.CoreV1().Services(channel.Namespace).Create(ctx, svc, metav1.CreateOptions{})
+			svc, err = r.kubeClientSet.CoreV1().Services(channel.Namespace).Create(ctx, desired, metav1.CreateOptions{})
 			if err != nil {
 				logger.Error("Failed to create the channel service", zap.Error(err))
 				return nil, err
@@ -165,5 +213,10 @@ func (r *Reconciler) reconcileChannelService(ctx context.Context, channel *v1bet
 	if !metav1.IsControlledBy(svc, channel) {
 		return nil, fmt.Errorf("natsschannel: %s/%s does not own Service: %q", channel.Namespace, channel.Name, svc.Name)
 	}
+	svc, err = r.correctChannelService(ctx, channel, desired, svc)
+	if err != nil {
+		logger.Error("Failed to correct the channel service", zap.Error(err))
+		return nil, err
+	}
 	return svc, nil
 }
@@ -19,10 +19,12 @@ package controller
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"knative.dev/pkg/network"
 
+	"knative.dev/pkg/apis"
 	duckv1 "knative.dev/pkg/apis/duck/v1"
 	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
 	"knative.dev/pkg/controller"
@@ -34,6 +36,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/scheme"
 	clientgotesting "k8s.io/client-go/testing"
 
@@ -41,7 +44,9 @@ import (
 	fakeclientset "knative.dev/eventing-natss/pkg/client/injection/client/fake"
 	"knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
 	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
 	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+	"knative.dev/eventing-natss/pkg/util"
 )
 
 const (
@@ -50,6 +55,8 @@ const (
 	dispatcherDeploymentName = "test-deployment"
 	dispatcherServiceName    = "test-service"
 	channelServiceAddress    = "test-nc-kn-channel.test-namespace.svc.cluster.local"
+
+	receiverPortMismatchMessage = "The dispatcher Service does not route to the receiver: the dispatcher Deployment declares the receiver port 9999, the receiver listens on 8080"
 )
 
 func init() {
@@ -82,6 +89,14 @@ func TestAllCases(t *testing.T) {
 			Objects: []runtime.Object{
 				reconciletesting.NewNatssChannel(ncName, testNS),
 			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionFalse(v1beta1.NatssChannelConditionDispatcherReady, dispatcherDeploymentNotFound, "Dispatcher Deployment does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionEndpointsReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionServiceReady, dispatcherServiceNotFound, "Dispatcher Service does not exist"),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -103,6 +118,14 @@ func TestAllCases(t *testing.T) {
 				makeReadyDeployment(),
 				reconciletesting.NewNatssChannel(ncName, testNS),
 			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionFalse(v1beta1.NatssChannelConditionEndpointsReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionServiceReady, dispatcherServiceNotFound, "Dispatcher Service does not exist"),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -125,6 +148,14 @@ func TestAllCases(t *testing.T) {
 				makeService(),
 				reconciletesting.NewNatssChannel(ncName, testNS),
 			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionFalse(v1beta1.NatssChannelConditionEndpointsReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherEndpointsNotFound, "Dispatcher Endpoints does not exist"),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -148,6 +179,14 @@ func TestAllCases(t *testing.T) {
 				makeEmptyEndpoints(),
 				reconciletesting.NewNatssChannel(ncName, testNS),
 			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionFalse(v1beta1.NatssChannelConditionEndpointsReady, "DispatcherEndpointsNotReady", "There are no endpoints ready for Dispatcher service"),
+				conditionFalse(v1beta1.NatssChannelConditionReady, "DispatcherEndpointsNotReady", "There are no endpoints ready for Dispatcher service"),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -169,14 +208,23 @@ func TestAllCases(t *testing.T) {
 				makeReadyDeployment(),
 				makeService(),
 				makeReadyEndpoints(),
-				reconciletesting.NewNatssChannel(ncName, testNS),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
 			},
 			WantCreates: []runtime.Object{
 				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
 			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
 					reconciletesting.WithNatssChannelDeploymentReady(),
 					reconciletesting.WithNatssChannelServiceReady(),
 					reconciletesting.WithNatssChannelEndpointsReady(),
@@ -192,12 +240,137 @@ func TestAllCases(t *testing.T) {
 				makeReadyDeployment(),
 				makeService(),
 				makeReadyEndpoints(),
-				reconciletesting.NewNatssChannel(ncName, testNS),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		}, {
+			Name: "Service port mismatch, corrected",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeReadyDeployment(),
+				makeServiceWithTargetPort(9999),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
 				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
 			},
+			WantUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: makeService(),
+			}},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		}, {
+			Name: "Channel Service pointing at another dispatcher, corrected",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeReadyDeployment(),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
+				makeChannelServiceWith(func(svc *corev1.Service) {
+					svc.Spec.ExternalName = network.GetServiceHostname("renamed-dispatcher", testNS)
+				}),
+			},
+			WantUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			}},
+			WantEvents: []string{
+				Eventf(corev1.EventTypeNormal, channelServiceCorrected, "The Service %s-kn-channel drifted and was updated: externalName %q, want %q",
+					ncName, network.GetServiceHostname("renamed-dispatcher", testNS), network.GetServiceHostname(dispatcherServiceName, testNS)),
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		}, {
+			Name: "Channel Service selecting pods without its labels, corrected",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeReadyDeployment(),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
+				makeChannelServiceWith(func(svc *corev1.Service) {
+					svc.Labels = map[string]string{"team": "events"}
+					svc.Spec = corev1.ServiceSpec{
+						Type:     corev1.ServiceTypeClusterIP,
+						Selector: map[string]string{"app": "dispatcher"},
+						Ports:    []corev1.ServicePort{{Name: "http", Port: 80}},
+					}
+				}),
+			},
+			WantUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: makeChannelServiceWith(func(svc *corev1.Service) {
+					svc.Labels["team"] = "events"
+				}),
+			}},
+			WantEvents: []string{
+				Eventf(corev1.EventTypeNormal, channelServiceCorrected, "The Service %s-kn-channel drifted and was updated: %s", ncName, strings.Join([]string{
+					fmt.Sprintf("type %q, want %q", corev1.ServiceTypeClusterIP, corev1.ServiceTypeExternalName),
+					fmt.Sprintf("externalName %q, want %q", "", network.GetServiceHostname(dispatcherServiceName, testNS)),
+					"selector map[app:dispatcher], want map[]",
+					"ports [http:80], want []",
+					fmt.Sprintf("label %s=%q, want %q", resources.MessagingRoleLabel, "", resources.MessagingRole),
+				}, "; ")),
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
 					reconciletesting.WithNatssChannelDeploymentReady(),
 					reconciletesting.WithNatssChannelServiceReady(),
 					reconciletesting.WithNatssChannelEndpointsReady(),
@@ -205,6 +378,34 @@ func TestAllCases(t *testing.T) {
 					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
 				),
 			}},
+		}, {
+			Name: "Deployment receiver port mismatch",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeReadyDeploymentWithReceiverPort(9999),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherPortMismatch, receiverPortMismatchMessage),
+				conditionFalse(v1beta1.NatssChannelConditionServiceReady, dispatcherPortMismatch, receiverPortMismatchMessage),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceNotReady(dispatcherPortMismatch, receiverPortMismatchMessage),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
 		}, {
 			Name: "channel exists, not owned by us",
 			Key:  ncKey,
@@ -215,6 +416,13 @@ func TestAllCases(t *testing.T) {
 				reconciletesting.NewNatssChannel(ncName, testNS),
 				makeChannelServiceNotOwnedByUs(),
 			},
+			WantEvents: []string{
+				conditionFalse(v1beta1.NatssChannelConditionChannelServiceReady, "ChannelServiceFailed", "Channel Service failed: natsschannel: test-namespace/test-nc does not own Service: \"test-nc-kn-channel\""),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionFalse(v1beta1.NatssChannelConditionReady, "ChannelServiceFailed", "Channel Service failed: natsschannel: test-namespace/test-nc does not own Service: \"test-nc-kn-channel\""),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -236,6 +444,13 @@ func TestAllCases(t *testing.T) {
 			WithReactors: []clientgotesting.ReactionFunc{
 				InduceFailure("create", "Services"),
 			},
+			WantEvents: []string{
+				conditionFalse(v1beta1.NatssChannelConditionChannelServiceReady, channelServiceFailed, "Channel Service failed: inducing failure for create services"),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionFalse(v1beta1.NatssChannelConditionReady, channelServiceFailed, "Channel Service failed: inducing failure for create services"),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
 				Object: reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssInitChannelConditions,
@@ -257,9 +472,14 @@ func TestAllCases(t *testing.T) {
 			dispatcherDeploymentName: dispatcherDeploymentName,
 			dispatcherServiceName:    dispatcherServiceName,
 			kubeClientSet:            fakekubeclient.Get(ctx),
+			natsschannelLister:       listers.GetNatssChannelLister(),
 			deploymentLister:         listers.GetDeploymentLister(),
 			serviceLister:            listers.GetServiceLister(),
 			endpointsLister:          listers.GetEndpointsLister(),
+			conditionRecorder:        events.NewConditionRecorder(events.DefaultDedupWindow),
+			transportEncryption:      &transportEncryption{},
+			natsAuth:                 &natsAuth{},
+			dispatcherReplicas:       &dispatcherReplicas{},
 		}
 		return natsschannel.NewReconciler(ctx, logging.FromContext(ctx),
 			fakeclientset.Get(ctx), listers.GetNatssChannelLister(),
@@ -288,6 +508,15 @@ func makeReadyDeployment() *appsv1.Deployment {
 	return d
 }
 
+func makeReadyDeploymentWithReceiverPort(port int32) *appsv1.Deployment {
+	d := makeReadyDeployment()
+	d.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:  "dispatcher",
+		Ports: []corev1.ContainerPort{{Name: util.DispatcherReceiverPortName, ContainerPort: port}},
+	}}
+	return d
+}
+
 func makeService() *corev1.Service {
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -298,7 +527,18 @@ func makeService() *corev1.Service {
 			Namespace: testNS,
 			Name:      dispatcherServiceName,
 		},
+		Spec: corev1.ServiceSpec{
+			Ports: resources.MakeDispatcherServicePorts(),
+		},
+	}
+}
+
+func makeServiceWithTargetPort(port int) *corev1.Service {
+	svc := makeService()
+	for i := range svc.Spec.Ports {
+		svc.Spec.Ports[i].TargetPort = intstr.FromInt(port)
 	}
+	return svc
 }
 
 func makeChannelService(nc *v1beta1.NatssChannel) *corev1.Service {
@@ -324,6 +564,12 @@ func makeChannelService(nc *v1beta1.NatssChannel) *corev1.Service {
 	}
 }
 
+func makeChannelServiceWith(opt func(*corev1.Service)) *corev1.Service {
+	svc := makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS))
+	opt(svc)
+	return svc
+}
+
 func makeChannelServiceNotOwnedByUs() *corev1.Service {
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -362,3 +608,11 @@ func makeReadyEndpoints() *corev1.Endpoints {
 	e.Subsets = []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "1.1.1.1"}}}}
 	return e
 }
+
+func conditionTrue(t apis.ConditionType) string {
+	return Eventf(corev1.EventTypeNormal, string(t)+"True", "%s is True", t)
+}
+
+func conditionFalse(t apis.ConditionType, reason, message string) string {
+	return Eventf(corev1.EventTypeWarning, string(t)+"False", "%s is False: %s: %s", t, reason, message)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/reconciler/controller/resources"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+// dispatcherPortMismatch is the reason of the ServiceReady condition of the channels when the
+// dispatcher Service does not route to the receiver of the dispatcher.
+const dispatcherPortMismatch = "PortMismatch"
+
+// reconcileDispatcherPorts checks that the ports of the dispatcher Service svc target the port
+// the receiver of the dispatcher listens on, and corrects them when they do not. The channels are
+// marked with the PortMismatch reason when the mismatch cannot be corrected, d being the
+// dispatcher Deployment, nil when it is missing.
+func (r *Reconciler) reconcileDispatcherPorts(ctx context.Context, nc *v1beta1.NatssChannel, svc *corev1.Service, d *appsv1.Deployment) {
+	logger := logging.FromContext(ctx)
+	containerPorts := dispatcherContainerPorts(d)
+
+	// The Deployment is not managed by the controller.
+	mismatches := deploymentPortMismatches(containerPorts)
+	if serviceMismatches := servicePortMismatches(svc, containerPorts); len(serviceMismatches) > 0 {
+		logger.Warnw("The dispatcher Service does not route to the receiver, correcting it", zap.Strings("mismatches", serviceMismatches))
+		if err := r.correctDispatcherPorts(ctx, svc); err != nil {
+			logger.Errorw("Failed to correct the ports of the dispatcher Service", zap.Error(err))
+			mismatches = append(mismatches, serviceMismatches...)
+		}
+	}
+	if len(mismatches) > 0 {
+		nc.Status.MarkServiceFailed(dispatcherPortMismatch, "The dispatcher Service does not route to the receiver: %s", strings.Join(mismatches, "; "))
+	}
+}
+
+// dispatcherContainerPorts returns the container ports of the dispatcher Deployment d, by name.
+func dispatcherContainerPorts(d *appsv1.Deployment) map[string]int32 {
+	ports := make(map[string]int32)
+	if d == nil {
+		return ports
+	}
+	for _, c := range d.Spec.Template.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name != "" {
+				ports[p.Name] = p.ContainerPort
+			}
+		}
+	}
+	return ports
+}
+
+// deploymentPortMismatches returns the mismatch between the receiver port declared by the
+// dispatcher Deployment, if any, and the port the receiver listens on.
+func deploymentPortMismatches(containerPorts map[string]int32) []string {
+	if port, ok := containerPorts[util.DispatcherReceiverPortName]; ok && port != util.DispatcherReceiverPort {
+		return []string{fmt.Sprintf("the dispatcher Deployment declares the %s port %d, the receiver listens on %d",
+			util.DispatcherReceiverPortName, port, util.DispatcherReceiverPort)}
+	}
+	return nil
+}
+
+// servicePortMismatches returns the ports of resources.MakeDispatcherServicePorts that svc lacks
+// or does not route to the receiver, the named target ports resolving to containerPorts.
+func servicePortMismatches(svc *corev1.Service, containerPorts map[string]int32) []string {
+	var mismatches []string
+	for _, want := range resources.MakeDispatcherServicePorts() {
+		got, ok := findServicePort(svc, want.Port)
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("port %d is missing", want.Port))
+			continue
+		}
+		target := got.Port
+		switch {
+		case got.TargetPort.Type == intstr.String:
+			port, ok := containerPorts[got.TargetPort.StrVal]
+			if !ok {
+				mismatches = append(mismatches, fmt.Sprintf("port %d targets %q, which the dispatcher Deployment does not declare", want.Port, got.TargetPort.StrVal))
+				continue
+			}
+			target = port
+		case got.TargetPort.IntVal != 0:
+			target = got.TargetPort.IntVal
+		}
+		if target != util.DispatcherReceiverPort {
+			mismatches = append(mismatches, fmt.Sprintf("port %d targets %d, the receiver listens on %d", want.Port, target, util.DispatcherReceiverPort))
+		}
+	}
+	return mismatches
+}
+
+func findServicePort(svc *corev1.Service, port int32) (corev1.ServicePort, bool) {
+	for _, p := range svc.Spec.Ports {
+		if p.Port == port {
+			return p, true
+		}
+	}
+	return corev1.ServicePort{}, false
+}
+
+// correctDispatcherPorts updates svc with the ports of resources.MakeDispatcherServicePorts,
+// keeping its other ports.
+func (r *Reconciler) correctDispatcherPorts(ctx context.Context, svc *corev1.Service) error {
+	corrected := svc.DeepCopy()
+	for _, want := range resources.MakeDispatcherServicePorts() {
+		replaced := false
+		for i, p := range corrected.Spec.Ports {
+			if p.Port == want.Port {
+				corrected.Spec.Ports[i].TargetPort = want.TargetPort
+				replaced = true
+			}
+		}
+		if !replaced {
+			corrected.Spec.Ports = append(corrected.Spec.Ports, want)
+		}
+	}
+	_, err := r.kubeClientSet.CoreV1().Services(corrected.Namespace).Update(ctx, corrected, metav1.UpdateOptions{})
+	return err
+}
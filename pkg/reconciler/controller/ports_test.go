@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+func TestServicePortMismatches(t *testing.T) {
+	withTargetPort := func(target intstr.IntOrString) *corev1.Service {
+		svc := makeService()
+		for i := range svc.Spec.Ports {
+			svc.Spec.Ports[i].TargetPort = target
+		}
+		return svc
+	}
+	receiver := map[string]int32{util.DispatcherReceiverPortName: util.DispatcherReceiverPort}
+
+	tests := map[string]struct {
+		svc            *corev1.Service
+		containerPorts map[string]int32
+		want           int
+	}{
+		"matching": {
+			svc: makeService(),
+		},
+		"named target port": {
+			svc:            withTargetPort(intstr.FromString(util.DispatcherReceiverPortName)),
+			containerPorts: receiver,
+		},
+		"undeclared named target port": {
+			svc:  withTargetPort(intstr.FromString(util.DispatcherReceiverPortName)),
+			want: 2,
+		},
+		"target port defaulting to the port": {
+			svc:  withTargetPort(intstr.IntOrString{}),
+			want: 2,
+		},
+		"wrong target port": {
+			svc:  withTargetPort(intstr.FromInt(9999)),
+			want: 2,
+		},
+		"missing port": {
+			svc: func() *corev1.Service {
+				svc := makeService()
+				svc.Spec.Ports = svc.Spec.Ports[:1]
+				return svc
+			}(),
+			want: 1,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			if got := servicePortMismatches(tc.svc, tc.containerPorts); len(got) != tc.want {
+				t.Errorf("servicePortMismatches() = %q, want %d mismatches", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	clientset "knative.dev/eventing-natss/pkg/client/clientset/versioned"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/probe"
+)
+
+// probeChannels maintains the channel of the end to end probe in the namespace of the probe, and
+// deletes the probe channels of the other namespaces.
+type probeChannels struct {
+	client clientset.Interface
+	lister listers.NatssChannelLister
+
+	// mu serializes the reconciliations.
+	mu        sync.Mutex
+	namespace string
+}
+
+// setNamespace sets the namespace of the probe, empty when it is disabled, and reconciles the
+// probe channels.
+func (p *probeChannels) setNamespace(ctx context.Context, namespace string) {
+	p.mu.Lock()
+	p.namespace = namespace
+	p.mu.Unlock()
+	p.reconcile(ctx)
+}
+
+// reconcile creates the probe channel of the namespace of the probe when it is missing, and
+// deletes the others. The failures are logged, the next change of a probe channel or of the
+// configuration retrying.
+func (p *probeChannels) reconcile(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logger := logging.FromContext(ctx)
+
+	channels, err := p.lister.List(labels.SelectorFromSet(labels.Set{probe.LabelKey: "true"}))
+	if err != nil {
+		logger.Errorw("Failed to list the probe channels", zap.Error(err))
+		return
+	}
+	found := false
+	for _, nc := range channels {
+		if nc.Name != probe.ChannelName {
+			continue
+		}
+		if nc.Namespace == p.namespace {
+			found = true
+			continue
+		}
+		if nc.DeletionTimestamp != nil {
+			continue
+		}
+		err := p.client.MessagingV1beta1().NatssChannels(nc.Namespace).Delete(ctx, nc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			logger.Errorw("Failed to delete the probe channel", zap.String("namespace", nc.Namespace), zap.Error(err))
+			continue
+		}
+		logger.Infow("Deleted the probe channel", zap.String("namespace", nc.Namespace))
+	}
+	if p.namespace == "" || found {
+		return
+	}
+
+	_, err = p.client.MessagingV1beta1().NatssChannels(p.namespace).Create(ctx, probe.MakeChannel(p.namespace), metav1.CreateOptions{})
+	switch {
+	case apierrs.IsAlreadyExists(err):
+		// Not in the cache yet, or not labeled as a probe channel.
+	case err != nil:
+		logger.Errorw("Failed to create the probe channel", zap.String("namespace", p.namespace), zap.Error(err))
+	default:
+		logger.Infow("Created the probe channel", zap.String("namespace", p.namespace))
+	}
+}
+
+// isProbeChannel is the filter of the informer events of the probe channels.
+func isProbeChannel(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	nc, ok := obj.(*v1beta1.NatssChannel)
+	return ok && probe.IsChannel(nc)
+}
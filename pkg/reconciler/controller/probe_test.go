@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"knative.dev/eventing-natss/pkg/client/clientset/versioned/fake"
+	"knative.dev/eventing-natss/pkg/probe"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func TestProbeChannels(t *testing.T) {
+	// A channel named like the probe channel which is not labeled as one.
+	unlabeled := reconciletesting.NewNatssChannel(probe.ChannelName, "unlabeled")
+
+	tests := map[string]struct {
+		namespace string
+		objects   []runtime.Object
+		want      []string
+	}{
+		"disabled": {
+			objects: []runtime.Object{probe.MakeChannel("probes"), unlabeled},
+			want:    []string{"unlabeled/" + probe.ChannelName},
+		},
+		"created": {
+			namespace: "probes",
+			objects:   []runtime.Object{unlabeled},
+			want:      []string{"probes/" + probe.ChannelName, "unlabeled/" + probe.ChannelName},
+		},
+		"moved": {
+			namespace: "probes",
+			objects:   []runtime.Object{probe.MakeChannel("previous")},
+			want:      []string{"probes/" + probe.ChannelName},
+		},
+		"kept": {
+			namespace: "probes",
+			objects:   []runtime.Object{probe.MakeChannel("probes")},
+			want:      []string{"probes/" + probe.ChannelName},
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tc.objects...)
+			listers := reconciletesting.NewListers(tc.objects)
+			p := &probeChannels{client: client, lister: listers.GetNatssChannelLister()}
+			p.setNamespace(context.Background(), tc.namespace)
+
+			channels, err := client.MessagingV1beta1().NatssChannels("").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("List() = %v", err)
+			}
+			var got []string
+			for _, nc := range channels.Items {
+				got = append(got, nc.Namespace+"/"+nc.Name)
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected channels (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync/atomic"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// dispatcherScaledToZero is the reason of the DispatcherReady condition of the channels while the
+// dispatcher Deployment has no replica.
+const dispatcherScaledToZero = "DispatcherScaledToZero"
+
+// dispatcherReplicas fails the DispatcherReady condition of the channels while the dispatcher
+// Deployment is scaled to zero, which its Available condition does not tell, when required by
+// config-natss. The Deployment is applied with the other resources of config/, the controller
+// never writing its replicas.
+type dispatcherReplicas struct {
+	// required is 1 when the channels require a replica of the dispatcher.
+	required int32
+}
+
+// setRequired sets whether the channels require a replica of the dispatcher, returning whether it
+// changed.
+func (r *dispatcherReplicas) setRequired(required bool) bool {
+	var v int32
+	if required {
+		v = 1
+	}
+	return atomic.SwapInt32(&r.required, v) != v
+}
+
+// reconcile marks the dispatcher of natssChannel failed when required and d has no replica desired
+// or available. The warning event of the transition is left to the events.ConditionRecorder of
+// the reconciler, which does not repeat it on the next reconciliations.
+func (r *dispatcherReplicas) reconcile(natssChannel *v1beta1.NatssChannel, d *appsv1.Deployment) {
+	if atomic.LoadInt32(&r.required) == 0 {
+		return
+	}
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	if desired > 0 && d.Status.AvailableReplicas > 0 {
+		return
+	}
+	natssChannel.Status.MarkDispatcherFailed(dispatcherScaledToZero, "Dispatcher Deployment is scaled to zero: %d replicas desired, %d available",
+		desired, d.Status.AvailableReplicas)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	. "knative.dev/pkg/reconciler/testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	fakeclientset "knative.dev/eventing-natss/pkg/client/injection/client/fake"
+	"knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func makeScaledDeployment(desired, available int32) *appsv1.Deployment {
+	d := makeReadyDeployment()
+	d.Spec.Replicas = &desired
+	d.Status.Replicas = available
+	d.Status.AvailableReplicas = available
+	return d
+}
+
+func TestDispatcherScaledToZero(t *testing.T) {
+	ncKey := testNS + "/" + ncName
+	// scaledToZero are the events of the conditions of a channel whose dispatcher is scaled to zero.
+	scaledToZero := func(message string) []string {
+		return []string{
+			conditionTrue(v1beta1.NatssChannelConditionAddressable),
+			conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+			conditionFalse(v1beta1.NatssChannelConditionDispatcherReady, dispatcherScaledToZero, message),
+			conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+			conditionFalse(v1beta1.NatssChannelConditionReady, dispatcherScaledToZero, message),
+			conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+		}
+	}
+
+	table := TableTest{
+		{
+			Name: "zero replicas desired",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeScaledDeployment(0, 0),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+			WantEvents: scaledToZero("Dispatcher Deployment is scaled to zero: 0 replicas desired, 0 available"),
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentNotReady(dispatcherScaledToZero, "Dispatcher Deployment is scaled to zero: 0 replicas desired, 0 available"),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		}, {
+			Name: "no replica available",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeScaledDeployment(1, 0),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+			WantEvents: scaledToZero("Dispatcher Deployment is scaled to zero: 1 replicas desired, 0 available"),
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentNotReady(dispatcherScaledToZero, "Dispatcher Deployment is scaled to zero: 1 replicas desired, 0 available"),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		}, {
+			// The transition was reported by a previous reconciliation.
+			Name: "still scaled to zero",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeScaledDeployment(0, 0),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelDeploymentNotReady(dispatcherScaledToZero, "Dispatcher Deployment is scaled to zero: 0 replicas desired, 0 available"),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+		}, {
+			Name: "replica available",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				makeScaledDeployment(1, 1),
+				makeService(),
+				makeReadyEndpoints(),
+				reconciletesting.NewNatssChannel(ncName, testNS, reconciletesting.WithNatssChannelNatssConnectionReady()),
+				makeChannelService(reconciletesting.NewNatssChannel(ncName, testNS)),
+			},
+			WantEvents: []string{
+				conditionTrue(v1beta1.NatssChannelConditionAddressable),
+				conditionTrue(v1beta1.NatssChannelConditionChannelServiceReady),
+				conditionTrue(v1beta1.NatssChannelConditionDispatcherReady),
+				conditionTrue(v1beta1.NatssChannelConditionEndpointsReady),
+				conditionTrue(v1beta1.NatssChannelConditionReady),
+				conditionTrue(v1beta1.NatssChannelConditionServiceReady),
+			},
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+				Object: reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithNatssInitChannelConditions,
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
+					reconciletesting.WithNatssChannelDeploymentReady(),
+					reconciletesting.WithNatssChannelServiceReady(),
+					reconciletesting.WithNatssChannelEndpointsReady(),
+					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelAddress(channelServiceAddress),
+				),
+			}},
+		},
+	}
+
+	table.Test(t, reconciletesting.MakeFactory(func(ctx context.Context, listers *reconciletesting.Listers) controller.Reconciler {
+		replicas := &dispatcherReplicas{}
+		replicas.setRequired(true)
+		r := &Reconciler{
+			dispatcherNamespace:      testNS,
+			dispatcherDeploymentName: dispatcherDeploymentName,
+			dispatcherServiceName:    dispatcherServiceName,
+			kubeClientSet:            fakekubeclient.Get(ctx),
+			natsschannelLister:       listers.GetNatssChannelLister(),
+			deploymentLister:         listers.GetDeploymentLister(),
+			serviceLister:            listers.GetServiceLister(),
+			endpointsLister:          listers.GetEndpointsLister(),
+			conditionRecorder:        events.NewConditionRecorder(events.DefaultDedupWindow),
+			transportEncryption:      &transportEncryption{},
+			natsAuth:                 &natsAuth{},
+			dispatcherReplicas:       replicas,
+		}
+		return natsschannel.NewReconciler(ctx, logging.FromContext(ctx),
+			fakeclientset.Get(ctx), listers.GetNatssChannelLister(),
+			controller.GetEventRecorder(ctx),
+			r)
+	}))
+}
+
+func TestDispatcherReplicasSetRequired(t *testing.T) {
+	r := &dispatcherReplicas{}
+	if r.setRequired(false) {
+		t.Error("setRequired(false) = true, want unchanged")
+	}
+	if !r.setRequired(true) {
+		t.Error("setRequired(true) = false, want the channels resynced")
+	}
+	if r.setRequired(true) {
+		t.Error("setRequired(true) = true again, want unchanged")
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/network"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+const (
+	// DispatcherCertificateName is the name of the cert-manager Certificate of the receiver of the
+	// dispatcher of the cluster, and of the Secret it is issued into.
+	DispatcherCertificateName = "natss-ch-dispatcher-tls"
+
+	// WebhookCertificateName is the name of the cert-manager Certificate of the webhook, and of
+	// the Secret it is issued into, which the controller copies into the Secret of the webhook.
+	WebhookCertificateName = "natss-webhook-tls"
+
+	// CertificateRevisionAnnotationKey is the annotation of the pods of the dispatcher holding the
+	// revision of the receiver certificate they serve, the dispatcher being rolled out when the
+	// certificate is renewed.
+	CertificateRevisionAnnotationKey = "natss.messaging.knative.dev/certificate-revision"
+
+	// receiverTLSVolumeName is the name of the volume of the receiver certificate.
+	receiverTLSVolumeName = "receiver-tls"
+)
+
+// CertificateGVR is the resource of the cert-manager Certificates.
+var CertificateGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+// MakeCertificate creates the cert-manager Certificate name of namespace, issued by issuer for
+// dnsNames into the Secret name.
+func MakeCertificate(namespace, name string, dnsNames []string, issuer config.CertManager) *unstructured.Unstructured {
+	names := make([]interface{}, 0, len(dnsNames))
+	for _, n := range dnsNames {
+		names = append(names, n)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": CertificateGVR.GroupVersion().String(),
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels": map[string]interface{}{
+				channelLabel: channelValue,
+			},
+		},
+		"spec": map[string]interface{}{
+			"secretName": name,
+			"dnsNames":   names,
+			"issuerRef": map[string]interface{}{
+				"group": CertificateGVR.Group,
+				"kind":  issuer.IssuerKind,
+				"name":  issuer.IssuerName,
+			},
+		},
+	}}
+}
+
+// ServiceDNSNames returns the DNS names of the Service name of namespace.
+func ServiceDNSNames(name, namespace string) []string {
+	return []string{
+		name + "." + namespace + ".svc",
+		network.GetServiceHostname(name, namespace),
+	}
+}
+
+// ChannelDNSNames returns the DNS names of the channels of namespaces, those of their Services
+// in these namespaces.
+func ChannelDNSNames(namespaces []string) []string {
+	names := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		names = append(names, "*."+ns+".svc."+network.GetClusterDomainName())
+	}
+	return names
+}
+
+// WithReceiverCertificate returns a copy of d mounting the receiver certificate issued by
+// cert-manager into its dispatcher container, its pods being annotated with the revision of the
+// certificate so that they are rolled out when it is renewed.
+func WithReceiverCertificate(d *appsv1.Deployment, revision string) *appsv1.Deployment {
+	d = d.DeepCopy()
+	template := &d.Spec.Template
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[CertificateRevisionAnnotationKey] = revision
+
+	volume := corev1.Volume{
+		Name: receiverTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			// Optional, for the pods to start once cert-manager is disabled and the Secret deleted.
+			Secret: &corev1.SecretVolumeSource{SecretName: DispatcherCertificateName, Optional: ptr.Bool(true)},
+		},
+	}
+	template.Spec.Volumes = withVolume(template.Spec.Volumes, volume)
+	for i := range template.Spec.Containers {
+		c := &template.Spec.Containers[i]
+		if c.Name != DispatcherContainerName {
+			continue
+		}
+		c.VolumeMounts = withVolumeMount(c.VolumeMounts, corev1.VolumeMount{
+			Name:      receiverTLSVolumeName,
+			MountPath: config.CertManagerReceiverDir,
+			ReadOnly:  true,
+		})
+	}
+	return d
+}
+
+func withVolume(volumes []corev1.Volume, volume corev1.Volume) []corev1.Volume {
+	for i, v := range volumes {
+		if v.Name == volume.Name {
+			volumes[i] = volume
+			return volumes
+		}
+	}
+	return append(volumes, volume)
+}
+
+func withVolumeMount(mounts []corev1.VolumeMount, mount corev1.VolumeMount) []corev1.VolumeMount {
+	for i, m := range mounts {
+		if m.Name == mount.Name {
+			mounts[i] = mount
+			return mounts
+		}
+	}
+	return append(mounts, mount)
+}
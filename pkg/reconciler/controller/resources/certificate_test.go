@@ -0,0 +1,86 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+func TestMakeCertificate(t *testing.T) {
+	issuer := config.CertManager{Enabled: true, IssuerName: "natss-ca", IssuerKind: config.CertManagerIssuer}
+	names := append(ServiceDNSNames(dispatcherName, dispatcherNS), ChannelDNSNames([]string{testNS})...)
+	got := MakeCertificate(dispatcherNS, DispatcherCertificateName, names, issuer)
+
+	want := map[string]interface{}{
+		"secretName": DispatcherCertificateName,
+		"dnsNames": []interface{}{
+			"dispatcher-name.dispatcher-namespace.svc",
+			"dispatcher-name.dispatcher-namespace.svc.cluster.local",
+			"*.my-test-ns.svc.cluster.local",
+		},
+		"issuerRef": map[string]interface{}{
+			"group": "cert-manager.io",
+			"kind":  "Issuer",
+			"name":  "natss-ca",
+		},
+	}
+	if diff := cmp.Diff(want, got.Object["spec"]); diff != "" {
+		t.Errorf("unexpected spec (-want, +got): %s", diff)
+	}
+	if got.GetNamespace() != dispatcherNS || got.GetName() != DispatcherCertificateName {
+		t.Errorf("Certificate = %s/%s, want %s/%s", got.GetNamespace(), got.GetName(), dispatcherNS, DispatcherCertificateName)
+	}
+}
+
+func TestWithReceiverCertificate(t *testing.T) {
+	d := &appsv1.Deployment{}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "sidecar"}, {Name: DispatcherContainerName}}
+
+	got := WithReceiverCertificate(d, "r1")
+	if len(d.Spec.Template.Spec.Volumes) != 0 || d.Spec.Template.Annotations != nil {
+		t.Error("WithReceiverCertificate() changed its argument")
+	}
+	// Mounting again only updates the revision.
+	got = WithReceiverCertificate(got, "r2")
+
+	want := &appsv1.Deployment{}
+	want.Spec.Template.Annotations = map[string]string{CertificateRevisionAnnotationKey: "r2"}
+	want.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name: receiverTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: DispatcherCertificateName, Optional: ptr.Bool(true)},
+		},
+	}}
+	want.Spec.Template.Spec.Containers = []corev1.Container{{Name: "sidecar"}, {
+		Name: DispatcherContainerName,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      receiverTLSVolumeName,
+			MountPath: config.CertManagerReceiverDir,
+			ReadOnly:  true,
+		}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected Deployment (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+const (
+	// DispatcherName is the name of the Deployment, Service, ServiceAccount and RoleBinding of the
+	// dispatchers, in the system namespace as well as in the namespaces of the channels annotated
+	// with eventing.knative.dev/scope: namespace.
+	DispatcherName = "natss-ch-dispatcher"
+
+	// DispatcherContainerName is the name of the container of the dispatcher in its Deployment.
+	DispatcherContainerName = "dispatcher"
+
+	// NamespacedDispatchersRoleBindingName is the name of the RoleBinding, in the system
+	// namespace, granting the dispatchers of the namespaces the configuration of the system
+	// namespace.
+	NamespacedDispatchersRoleBindingName = "natss-ch-namespaced-dispatchers"
+
+	// dispatcherConfigReaderRole is the ClusterRole NamespacedDispatchersRoleBindingName binds.
+	dispatcherConfigReaderRole = "natss-ch-dispatcher-config-reader"
+
+	channelLabel = "messaging.knative.dev/channel"
+	channelValue = "natss-channel"
+	roleValue    = "dispatcher"
+)
+
+// DispatcherLabels returns the labels of the dispatchers, which the dispatcher Services select.
+func DispatcherLabels() map[string]string {
+	return map[string]string{
+		channelLabel:       channelValue,
+		MessagingRoleLabel: roleValue,
+	}
+}
+
+// DispatcherOwnerRef returns the reference to nc owning the dispatcher resources of its namespace.
+// The references do not control the resources, which the other namespaced channels of the
+// namespace own as well, the resources being garbage collected along the last of them.
+func DispatcherOwnerRef(nc *v1beta1.NatssChannel) metav1.OwnerReference {
+	gvk := nc.GetGroupVersionKind()
+	return metav1.OwnerReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       nc.Name,
+		UID:        nc.UID,
+	}
+}
+
+// MakeNamespacedDispatcher creates the Deployment of the dispatcher serving the namespaced
+// channels of namespace. It is modeled on template, the dispatcher container of the dispatcher of
+// the cluster, whose image, resources and environment it shares, its pods draining within
+// terminationGracePeriod like those of the dispatcher of the cluster. Only the environment
+// variables which do not reference the ConfigMaps and Secrets of the system namespace are kept,
+// as are none of its volumes. Its probes are those of MakeDispatcherReadinessProbe and
+// MakeDispatcherLivenessProbe.
+func MakeNamespacedDispatcher(namespace, systemNamespace string, template *corev1.Container, terminationGracePeriod *int64, owners []metav1.OwnerReference) *appsv1.Deployment {
+	env := []corev1.EnvVar{{
+		Name:  "SYSTEM_NAMESPACE",
+		Value: systemNamespace,
+	}, {
+		Name: "NAMESPACE",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+		},
+	}}
+	for _, e := range template.Env {
+		if e.Name == "SYSTEM_NAMESPACE" || e.Name == "NAMESPACE" {
+			continue
+		}
+		if e.ValueFrom != nil && (e.ValueFrom.ConfigMapKeyRef != nil || e.ValueFrom.SecretKeyRef != nil) {
+			continue
+		}
+		env = append(env, e)
+	}
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            DispatcherName,
+			Namespace:       namespace,
+			Labels:          DispatcherLabels(),
+			OwnerReferences: owners,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.Int32(1),
+			Selector: &metav1.LabelSelector{MatchLabels: DispatcherLabels()},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: DispatcherLabels()},
+				Spec: corev1.PodSpec{
+					ServiceAccountName:            DispatcherName,
+					TerminationGracePeriodSeconds: terminationGracePeriod,
+					Containers: []corev1.Container{{
+						Name:           DispatcherContainerName,
+						Image:          template.Image,
+						Env:            env,
+						Ports:          template.Ports,
+						Resources:      template.Resources,
+						ReadinessProbe: MakeDispatcherReadinessProbe(),
+						LivenessProbe:  MakeDispatcherLivenessProbe(),
+					}},
+				},
+			},
+		},
+	}
+}
+
+// MakeDispatcherReadinessProbe returns the readiness probe of the dispatchers, which are ready
+// once connected to NATSS with their channels reconciled, and while connected, their stale host
+// map and their offline buffer standing for the channels and the connection when enabled.
+func MakeDispatcherReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{
+			Path: "/readyz",
+			Port: intstr.FromInt(util.DispatcherAdminPort),
+		}},
+		PeriodSeconds:    5,
+		FailureThreshold: 1,
+	}
+}
+
+// MakeDispatcherLivenessProbe returns the liveness probe of the dispatchers, which fails once
+// their connection to NATSS has been down for longer than the liveness threshold of config-natss.
+func MakeDispatcherLivenessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{
+			Path: "/healthz",
+			Port: intstr.FromInt(util.DispatcherAdminPort),
+		}},
+		PeriodSeconds:    10,
+		FailureThreshold: 3,
+	}
+}
+
+// MakeNamespacedDispatcherService creates the Service of the dispatcher serving the namespaced
+// channels of namespace.
+func MakeNamespacedDispatcherService(namespace string, owners []metav1.OwnerReference) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            DispatcherName,
+			Namespace:       namespace,
+			Labels:          DispatcherLabels(),
+			OwnerReferences: owners,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: DispatcherLabels(),
+			Ports:    MakeDispatcherServicePorts(),
+		},
+	}
+}
+
+// MakeNamespacedDispatcherServiceAccount creates the ServiceAccount of the dispatcher serving the
+// namespaced channels of namespace.
+func MakeNamespacedDispatcherServiceAccount(namespace string, owners []metav1.OwnerReference) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            DispatcherName,
+			Namespace:       namespace,
+			Labels:          DispatcherLabels(),
+			OwnerReferences: owners,
+		},
+	}
+}
+
+// MakeNamespacedDispatcherRoleBinding creates the RoleBinding granting the dispatcher serving the
+// namespaced channels of namespace the permissions of the dispatcher of the cluster, within
+// namespace.
+func MakeNamespacedDispatcherRoleBinding(namespace string, owners []metav1.OwnerReference) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            DispatcherName,
+			Namespace:       namespace,
+			Labels:          DispatcherLabels(),
+			OwnerReferences: owners,
+		},
+		Subjects: []rbacv1.Subject{dispatcherSubject(namespace)},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     DispatcherName,
+		},
+	}
+}
+
+// MakeNamespacedDispatchersRoleBinding creates the RoleBinding granting the dispatchers of
+// namespaces the configuration of the system namespace, such as config-natss and the NATS
+// credentials.
+func MakeNamespacedDispatchersRoleBinding(systemNamespace string, namespaces []string) *rbacv1.RoleBinding {
+	sorted := append([]string(nil), namespaces...)
+	sort.Strings(sorted)
+	subjects := make([]rbacv1.Subject, 0, len(sorted))
+	for _, ns := range sorted {
+		subjects = append(subjects, dispatcherSubject(ns))
+	}
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      NamespacedDispatchersRoleBindingName,
+			Namespace: systemNamespace,
+			Labels:    DispatcherLabels(),
+		},
+		Subjects: subjects,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     dispatcherConfigReaderRole,
+		},
+	}
+}
+
+func dispatcherSubject(namespace string) rbacv1.Subject {
+	return rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      DispatcherName,
+		Namespace: namespace,
+	}
+}
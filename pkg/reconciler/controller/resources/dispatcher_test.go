@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"knative.dev/pkg/ptr"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+func TestMakeNamespacedDispatcher(t *testing.T) {
+	nc := &v1beta1.NatssChannel{ObjectMeta: metav1.ObjectMeta{Name: ncName, Namespace: testNS, UID: "uid"}}
+	owners := []metav1.OwnerReference{DispatcherOwnerRef(nc)}
+	template := &corev1.Container{
+		Name:  DispatcherContainerName,
+		Image: "dispatcher-image",
+		Env: []corev1.EnvVar{
+			{Name: "SYSTEM_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+			{Name: "DEFAULT_NATSS_URL", Value: "nats://natss:4222"},
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			{Name: "FROM_CONFIGMAP", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "key"}}},
+			{Name: "FROM_SECRET", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "key"}}},
+		},
+		Ports: []corev1.ContainerPort{{Name: "receiver", ContainerPort: 8080}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "config-logging", MountPath: "/etc/config-logging"}},
+		ReadinessProbe: &corev1.Probe{
+			Handler: corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/readyz", Port: intstr.FromString("receiver")}},
+		},
+	}
+
+	d := MakeNamespacedDispatcher(testNS, dispatcherNS, template, ptr.Int64(120), owners)
+	if diff := cmp.Diff(owners, d.OwnerReferences); diff != "" {
+		t.Errorf("unexpected owners (-want, +got): %s", diff)
+	}
+	if owners[0].Controller != nil {
+		t.Errorf("the owner reference controls the dispatcher: %+v", owners[0])
+	}
+	if got := d.Spec.Template.Spec.ServiceAccountName; got != DispatcherName {
+		t.Errorf("service account = %q, want %q", got, DispatcherName)
+	}
+	if got := d.Spec.Template.Spec.TerminationGracePeriodSeconds; got == nil || *got != 120 {
+		t.Errorf("termination grace period = %v, want 120 seconds", got)
+	}
+	containers := d.Spec.Template.Spec.Containers
+	if len(containers) != 1 {
+		t.Fatalf("containers = %+v, want one", containers)
+	}
+	c := containers[0]
+	if c.Image != template.Image || !cmp.Equal(c.Ports, template.Ports) || !cmp.Equal(c.Resources, template.Resources) {
+		t.Errorf("container = %+v, want the image, ports and resources of the template", c)
+	}
+	// The probes reflect the connection to NATSS, whatever the probes of the template.
+	for _, probe := range []*corev1.Probe{c.ReadinessProbe, c.LivenessProbe} {
+		if probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Port != intstr.FromInt(util.DispatcherAdminPort) {
+			t.Errorf("probe = %+v, want an HTTP probe of the admin port", probe)
+		}
+	}
+	if c.ReadinessProbe.HTTPGet.Path != "/readyz" || c.LivenessProbe.HTTPGet.Path != "/healthz" {
+		t.Errorf("probes of %q and %q, want /readyz and /healthz", c.ReadinessProbe.HTTPGet.Path, c.LivenessProbe.HTTPGet.Path)
+	}
+	if len(c.VolumeMounts) != 0 {
+		t.Errorf("volume mounts = %+v, want none", c.VolumeMounts)
+	}
+	wantEnv := []corev1.EnvVar{
+		{Name: "SYSTEM_NAMESPACE", Value: dispatcherNS},
+		{Name: "NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+		{Name: "DEFAULT_NATSS_URL", Value: "nats://natss:4222"},
+		{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+	}
+	if diff := cmp.Diff(wantEnv, c.Env); diff != "" {
+		t.Errorf("unexpected env (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff(DispatcherLabels(), d.Spec.Selector.MatchLabels); diff != "" {
+		t.Errorf("unexpected selector (-want, +got): %s", diff)
+	}
+	if svc := MakeNamespacedDispatcherService(testNS, owners); !cmp.Equal(svc.Spec.Selector, d.Spec.Template.Labels) {
+		t.Errorf("the Service selects %v, the dispatcher pods are labeled %v", svc.Spec.Selector, d.Spec.Template.Labels)
+	}
+}
+
+func TestMakeNamespacedDispatchersRoleBinding(t *testing.T) {
+	rb := MakeNamespacedDispatchersRoleBinding(dispatcherNS, []string{"team-b", "team-a"})
+	want := []rbacv1.Subject{
+		{Kind: rbacv1.ServiceAccountKind, Name: DispatcherName, Namespace: "team-a"},
+		{Kind: rbacv1.ServiceAccountKind, Name: DispatcherName, Namespace: "team-b"},
+	}
+	if diff := cmp.Diff(want, rb.Subjects); diff != "" {
+		t.Errorf("unexpected subjects (-want, +got): %s", diff)
+	}
+	if rb.Namespace != dispatcherNS || rb.RoleRef.Name != dispatcherConfigReaderRole {
+		t.Errorf("RoleBinding = %+v, want a binding of %s in %s", rb, dispatcherConfigReaderRole, dispatcherNS)
+	}
+}
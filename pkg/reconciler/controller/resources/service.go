@@ -20,16 +20,19 @@ import (
 	"fmt"
 
 	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/util"
 	"knative.dev/pkg/network"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"knative.dev/pkg/kmeta"
 )
 
 const (
 	portName           = "http"
 	portNumber         = 80
+	httpsPortNumber    = 443
 	MessagingRoleLabel = "messaging.knative.dev/role"
 	MessagingRole      = "natss-channel"
 )
@@ -91,3 +94,22 @@ func MakeK8sService(kc *v1beta1.NatssChannel, opts ...ServiceOption) (*corev1.Se
 	}
 	return svc, nil
 }
+
+// MakeDispatcherServicePorts returns the ports of the dispatcher Service, which serve the HTTP and
+// HTTPS addresses of the channels from the receiver of the dispatcher.
+func MakeDispatcherServicePorts() []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Name:       "http-dispatcher",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       portNumber,
+			TargetPort: intstr.FromInt(util.DispatcherReceiverPort),
+		},
+		{
+			Name:       "https-dispatcher",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       httpsPortNumber,
+			TargetPort: intstr.FromInt(util.DispatcherReceiverPort),
+		},
+	}
+}
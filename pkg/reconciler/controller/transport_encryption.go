@@ -0,0 +1,163 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+const (
+	// transportEncryptionUnavailable is the reason of the Addressable condition of the channels in
+	// the strict mode when the receiver does not serve HTTPS.
+	transportEncryptionUnavailable = "TransportEncryptionUnavailable"
+	// invalidAddressOverride is the reason of the Addressable condition of the channels whose
+	// address annotations are invalid.
+	invalidAddressOverride = "InvalidAddressOverride"
+
+	httpAddressName  = "http"
+	httpsAddressName = "https"
+)
+
+// transportEncryption holds the transport encryption mode of the cluster and the HTTPS settings
+// of the receiver, which decide the addresses of the channels unless an address is advertised
+// instead.
+type transportEncryption struct {
+	mu   sync.Mutex
+	mode security.TransportEncryption
+	// https is whether the receiver has a certificate to serve HTTPS with.
+	https bool
+	// caCerts are the certificate authorities of the receiver certificate, empty when they are
+	// trusted by default.
+	caCerts string
+	// advertised overrides the addresses of the receiver, for example behind a mesh terminating
+	// TLS.
+	advertised config.Address
+	// issued is whether the receiver has a certificate issued by cert-manager, and issuedCACerts
+	// its certificate authority, advertised unless caCerts is set.
+	issued        bool
+	issuedCACerts string
+}
+
+// setMode sets the transport encryption mode, returning whether it changed.
+func (t *transportEncryption) setMode(mode security.TransportEncryption) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.mode != mode
+	t.mode = mode
+	return changed
+}
+
+// setReceiver sets the HTTPS settings of the receiver from its security configuration, returning
+// whether they changed.
+func (t *transportEncryption) setReceiver(c security.Config) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	https := c.ReceiverCertFile != ""
+	changed := t.https != https || t.caCerts != c.ReceiverCACerts
+	t.https, t.caCerts = https, c.ReceiverCACerts
+	return changed
+}
+
+// setIssued sets whether the receiver has a certificate issued by cert-manager and its
+// certificate authority, returning whether they changed.
+func (t *transportEncryption) setIssued(issued bool, caCerts string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.issued != issued || t.issuedCACerts != caCerts
+	t.issued, t.issuedCACerts = issued, caCerts
+	return changed
+}
+
+// setAdvertised sets the address advertised by the channels, returning whether it changed.
+func (t *transportEncryption) setAdvertised(a config.Address) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.advertised != a
+	t.advertised = a
+	return changed
+}
+
+// setAddresses sets the addresses of nc, served at host. The address advertised for the cluster
+// or by the annotations of nc is its only address. The others follow the transport encryption
+// mode: the HTTP address alone when it is disabled, the HTTP address and both addresses when it is
+// permissive, and the HTTPS address alone when it is strict. The channels have no address in the
+// strict mode when the receiver does not serve HTTPS.
+func (t *transportEncryption) setAddresses(nc *v1beta1.NatssChannel, host string) {
+	t.mu.Lock()
+	mode, serves, caCerts, advertised := t.mode, t.https || t.issued, t.caCerts, t.advertised
+	if caCerts == "" && !t.https {
+		caCerts = t.issuedCACerts
+	}
+	t.mu.Unlock()
+
+	status := &nc.Status
+	advertised, err := advertised.WithAnnotations(nc.Annotations)
+	if err != nil {
+		status.MarkAddressUnavailable(invalidAddressOverride, "%v", err)
+		return
+	}
+	if !advertised.IsZero() {
+		status.SetAddress(advertised.URL(host))
+		status.Addresses = nil
+		return
+	}
+
+	httpURL := &apis.URL{Scheme: "http", Host: host}
+	if !mode.ServesHTTPS() {
+		status.SetAddress(httpURL)
+		status.Addresses = nil
+		return
+	}
+	if !serves {
+		if mode == security.TransportEncryptionStrict {
+			status.MarkAddressUnavailable(transportEncryptionUnavailable, "The transport encryption is strict but the dispatcher has no receiver certificate")
+			return
+		}
+		// The permissive mode falls back to the HTTP address.
+		status.SetAddress(httpURL)
+		status.Addresses = nil
+		return
+	}
+
+	httpsAddress := v1beta1.NatssChannelAddress{
+		Name: stringPtr(httpsAddressName),
+		URL:  &apis.URL{Scheme: "https", Host: host},
+	}
+	if caCerts != "" {
+		httpsAddress.CACerts = stringPtr(caCerts)
+	}
+	if mode == security.TransportEncryptionStrict {
+		status.SetAddress(httpsAddress.URL.DeepCopy())
+		status.Addresses = []v1beta1.NatssChannelAddress{httpsAddress}
+		return
+	}
+	status.SetAddress(httpURL)
+	status.Addresses = []v1beta1.NatssChannelAddress{
+		httpsAddress,
+		{Name: stringPtr(httpAddressName), URL: httpURL.DeepCopy()},
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/security"
+)
+
+func TestTransportEncryptionAddresses(t *testing.T) {
+	const host = "test-nc-kn-channel.test-namespace.svc.cluster.local"
+	const caCerts = "-----BEGIN CERTIFICATE-----"
+	httpURL := &apis.URL{Scheme: "http", Host: host}
+	httpsURL := &apis.URL{Scheme: "https", Host: host}
+	receiver := security.Config{ReceiverCertFile: "tls.crt", ReceiverKeyFile: "tls.key", ReceiverCACerts: caCerts}
+	httpsAddress := v1beta1.NatssChannelAddress{Name: stringPtr("https"), URL: httpsURL, CACerts: stringPtr(caCerts)}
+
+	testCases := map[string]struct {
+		mode       security.TransportEncryption
+		receiver   security.Config
+		advertised config.Address
+		// issued is the certificate authority of the receiver certificate issued by
+		// cert-manager, empty when none is.
+		issued        string
+		annotations   map[string]string
+		wantAddress   *apis.URL
+		wantAddresses []v1beta1.NatssChannelAddress
+		wantReady     bool
+	}{
+		"disabled": {
+			mode:        security.TransportEncryptionDisabled,
+			receiver:    receiver,
+			wantAddress: httpURL,
+			wantReady:   true,
+		},
+		"permissive": {
+			mode:        security.TransportEncryptionPermissive,
+			receiver:    receiver,
+			wantAddress: httpURL,
+			wantAddresses: []v1beta1.NatssChannelAddress{
+				httpsAddress,
+				{Name: stringPtr("http"), URL: httpURL},
+			},
+			wantReady: true,
+		},
+		"permissive without receiver certificate": {
+			mode:        security.TransportEncryptionPermissive,
+			wantAddress: httpURL,
+			wantReady:   true,
+		},
+		"strict": {
+			mode:          security.TransportEncryptionStrict,
+			receiver:      receiver,
+			wantAddress:   httpsURL,
+			wantAddresses: []v1beta1.NatssChannelAddress{httpsAddress},
+			wantReady:     true,
+		},
+		"strict with publicly trusted certificate": {
+			mode:          security.TransportEncryptionStrict,
+			receiver:      security.Config{ReceiverCertFile: "tls.crt", ReceiverKeyFile: "tls.key"},
+			wantAddress:   httpsURL,
+			wantAddresses: []v1beta1.NatssChannelAddress{{Name: stringPtr("https"), URL: httpsURL}},
+			wantReady:     true,
+		},
+		"strict without receiver certificate": {
+			mode: security.TransportEncryptionStrict,
+		},
+		"strict with issued certificate": {
+			mode:          security.TransportEncryptionStrict,
+			issued:        caCerts,
+			wantAddress:   httpsURL,
+			wantAddresses: []v1beta1.NatssChannelAddress{httpsAddress},
+			wantReady:     true,
+		},
+		"issued certificate overridden by the receiver certificate": {
+			mode:          security.TransportEncryptionStrict,
+			receiver:      security.Config{ReceiverCertFile: "tls.crt", ReceiverKeyFile: "tls.key"},
+			issued:        caCerts,
+			wantAddress:   httpsURL,
+			wantAddresses: []v1beta1.NatssChannelAddress{{Name: stringPtr("https"), URL: httpsURL}},
+			wantReady:     true,
+		},
+		"advertised https": {
+			mode:        security.TransportEncryptionDisabled,
+			advertised:  config.Address{Scheme: "https", Port: 443},
+			wantAddress: &apis.URL{Scheme: "https", Host: host + ":443"},
+			wantReady:   true,
+		},
+		"advertised scheme": {
+			mode:        security.TransportEncryptionDisabled,
+			advertised:  config.Address{Scheme: "https"},
+			wantAddress: httpsURL,
+			wantReady:   true,
+		},
+		"advertised port": {
+			mode:        security.TransportEncryptionDisabled,
+			advertised:  config.Address{Port: 8080},
+			wantAddress: &apis.URL{Scheme: "http", Host: host + ":8080"},
+			wantReady:   true,
+		},
+		"advertised in the permissive mode": {
+			mode:        security.TransportEncryptionPermissive,
+			receiver:    receiver,
+			advertised:  config.Address{Scheme: "https", Port: 443},
+			wantAddress: &apis.URL{Scheme: "https", Host: host + ":443"},
+			wantReady:   true,
+		},
+		"advertised in the strict mode without receiver certificate": {
+			mode:        security.TransportEncryptionStrict,
+			advertised:  config.Address{Scheme: "https", Port: 443},
+			wantAddress: &apis.URL{Scheme: "https", Host: host + ":443"},
+			wantReady:   true,
+		},
+		"annotations": {
+			mode: security.TransportEncryptionDisabled,
+			annotations: map[string]string{
+				messaging.AddressSchemeAnnotationKey: "https",
+				messaging.AddressPortAnnotationKey:   "8443",
+			},
+			wantAddress: &apis.URL{Scheme: "https", Host: host + ":8443"},
+			wantReady:   true,
+		},
+		"annotation overriding the cluster": {
+			mode:        security.TransportEncryptionDisabled,
+			advertised:  config.Address{Scheme: "https", Port: 443},
+			annotations: map[string]string{messaging.AddressPortAnnotationKey: "8443"},
+			wantAddress: &apis.URL{Scheme: "https", Host: host + ":8443"},
+			wantReady:   true,
+		},
+		"invalid scheme annotation": {
+			mode:        security.TransportEncryptionDisabled,
+			annotations: map[string]string{messaging.AddressSchemeAnnotationKey: "ftp"},
+		},
+		"invalid port annotation": {
+			mode:        security.TransportEncryptionDisabled,
+			annotations: map[string]string{messaging.AddressPortAnnotationKey: "https"},
+		},
+		"out of range port annotation": {
+			mode:        security.TransportEncryptionDisabled,
+			annotations: map[string]string{messaging.AddressPortAnnotationKey: "65536"},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			te := &transportEncryption{}
+			te.setMode(tc.mode)
+			te.setReceiver(tc.receiver)
+			te.setAdvertised(tc.advertised)
+			te.setIssued(tc.issued != "", tc.issued)
+
+			nc := &v1beta1.NatssChannel{}
+			nc.Annotations = tc.annotations
+			status := &nc.Status
+			// The addresses of the previous mode are replaced.
+			status.Addresses = []v1beta1.NatssChannelAddress{{Name: stringPtr("stale")}}
+			te.setAddresses(nc, host)
+
+			var gotAddress *apis.URL
+			if status.Address != nil {
+				gotAddress = status.Address.URL
+			}
+			if diff := cmp.Diff(tc.wantAddress, gotAddress); diff != "" {
+				t.Errorf("status.address (-want, +got) = %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantAddresses, status.Addresses); diff != "" {
+				t.Errorf("status.addresses (-want, +got) = %s", diff)
+			}
+			if got := status.GetCondition(v1beta1.NatssChannelConditionAddressable).IsTrue(); got != tc.wantReady {
+				t.Errorf("Addressable = %v, want %v", got, tc.wantReady)
+			}
+		})
+	}
+}
+
+func TestTransportEncryptionChanges(t *testing.T) {
+	te := &transportEncryption{}
+	if !te.setMode(security.TransportEncryptionPermissive) || te.setMode(security.TransportEncryptionPermissive) {
+		t.Error("setMode() does not report the changes of the mode only")
+	}
+	receiver := security.Config{ReceiverCertFile: "tls.crt", ReceiverKeyFile: "tls.key"}
+	if !te.setReceiver(receiver) || te.setReceiver(receiver) {
+		t.Error("setReceiver() does not report the changes of the receiver only")
+	}
+	receiver.ReceiverCACerts = "-----BEGIN CERTIFICATE-----"
+	if !te.setReceiver(receiver) {
+		t.Error("setReceiver() does not report the change of the certificate authorities")
+	}
+	advertised := config.Address{Scheme: "https", Port: 443}
+	if !te.setAdvertised(advertised) || te.setAdvertised(advertised) {
+		t.Error("setAdvertised() does not report the changes of the advertised address only")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileAudit hands the audit sink of natssChannel to the dispatcher and reports whether it
+// is reachable. The sink does not affect the readiness of the channel, the events being
+// delivered to the subscribers whether or not their copies reach it.
+func (r *Reconciler) reconcileAudit(natssChannel *v1beta1.NatssChannel) {
+	audit := natssChannel.Spec.Audit
+	auditor, ok := r.natssDispatcher.(dispatcher.Auditor)
+	if !ok {
+		if audit != nil {
+			natssChannel.Status.MarkAuditSinkUnreachable("AuditUnsupported", "Audit is not supported by the dispatcher transport")
+		} else {
+			natssChannel.Status.ClearAuditSinkCondition()
+		}
+		return
+	}
+	if audit == nil {
+		auditor.SetAuditSink(channelReference(natssChannel), nil, nil)
+		natssChannel.Status.ClearAuditSinkCondition()
+		return
+	}
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	auditor.SetAuditSink(channelReference(natssChannel), audit.Sink, func() { r.enqueueKey(key) })
+	switch sent, err := auditor.AuditSinkStatus(channelReference(natssChannel)); {
+	case err != nil:
+		natssChannel.Status.MarkAuditSinkUnreachable("AuditSinkUnreachable", "Failed to deliver events to %s: %v", audit.Sink, err)
+	case sent:
+		natssChannel.Status.MarkAuditSinkReachable()
+	default:
+		natssChannel.Status.MarkAuditSinkUnknown("NoEventAudited", "No event was sent to %s yet", audit.Sink)
+	}
+}
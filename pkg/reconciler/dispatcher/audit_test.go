@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeAuditor struct {
+	dispatcher.NatssDispatcher
+
+	sinks map[eventingchannels.ChannelReference]*apis.URL
+	sent  bool
+	err   error
+}
+
+func (a *fakeAuditor) SetAuditSink(channel eventingchannels.ChannelReference, sink *apis.URL, _ func()) {
+	if sink == nil {
+		delete(a.sinks, channel)
+		return
+	}
+	a.sinks[channel] = sink
+}
+
+func (a *fakeAuditor) AuditSinkStatus(eventingchannels.ChannelReference) (bool, error) {
+	return a.sent, a.err
+}
+
+func TestReconcileAudit(t *testing.T) {
+	sink, _ := apis.ParseURL("http://audit.ns.svc.cluster.local")
+
+	testCases := map[string]struct {
+		audit       *v1beta1.NatssChannelAudit
+		unsupported bool
+		sent        bool
+		err         error
+		wantStatus  corev1.ConditionStatus
+		wantReason  string
+	}{
+		"no audit": {},
+		"no event audited yet": {
+			audit:      &v1beta1.NatssChannelAudit{Sink: sink},
+			wantStatus: corev1.ConditionUnknown,
+			wantReason: "NoEventAudited",
+		},
+		"reachable": {
+			audit:      &v1beta1.NatssChannelAudit{Sink: sink},
+			sent:       true,
+			wantStatus: corev1.ConditionTrue,
+		},
+		"unreachable": {
+			audit:      &v1beta1.NatssChannelAudit{Sink: sink},
+			sent:       true,
+			err:        errors.New("unexpected status code 503"),
+			wantStatus: corev1.ConditionFalse,
+			wantReason: "AuditSinkUnreachable",
+		},
+		"unsupported": {
+			audit:       &v1beta1.NatssChannelAudit{Sink: sink},
+			unsupported: true,
+			wantStatus:  corev1.ConditionFalse,
+			wantReason:  "AuditUnsupported",
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			auditor := &fakeAuditor{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				sinks:           make(map[eventingchannels.ChannelReference]*apis.URL),
+				sent:            tc.sent,
+				err:             tc.err,
+			}
+			r := &Reconciler{natssDispatcher: auditor}
+			if tc.unsupported {
+				r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+			}
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS,
+				reconciletesting.WithNatssInitChannelConditions,
+				reconciletesting.WithNatssChannelDeploymentReady(),
+				reconciletesting.WithNatssChannelServiceReady(),
+				reconciletesting.WithNatssChannelEndpointsReady(),
+				reconciletesting.WithNatssChannelChannelServiceReady(),
+				reconciletesting.WithNatssChannelNatssConnectionReady(),
+				reconciletesting.WithNatssChannelAddress("channel.ns.svc.cluster.local"))
+			nc.Spec.Audit = tc.audit
+			r.reconcileAudit(nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionAuditSinkReachable)
+			if tc.wantStatus == "" {
+				if cond != nil {
+					t.Errorf("unexpected condition %+v", cond)
+				}
+			} else if cond == nil || cond.Status != tc.wantStatus || cond.Reason != tc.wantReason {
+				t.Errorf("condition = %+v, want status %s and reason %q", cond, tc.wantStatus, tc.wantReason)
+			}
+			if !nc.Status.IsReady() {
+				t.Error("the audit sink changed the readiness of the channel")
+			}
+			var want *apis.URL
+			if tc.audit != nil && !tc.unsupported {
+				want = tc.audit.Sink
+			}
+			if got := auditor.sinks[channelReference(nc)]; got != want {
+				t.Errorf("sink = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileAvroTranscode hands the schema registry of natssChannel to the dispatcher. When its
+// credentials cannot be loaded, the dispatcher gets a registry failing every request, so that
+// the Avro events are delivered unchanged rather than transcoded with the previous credentials.
+func (r *Reconciler) reconcileAvroTranscode(ctx context.Context, natssChannel *v1beta1.NatssChannel) error {
+	transcode := natssChannel.Spec.AvroTranscode
+	transcoder, ok := r.natssDispatcher.(dispatcher.AvroTranscoder)
+	if !ok {
+		if transcode != nil {
+			return errors.New("avro transcoding is not supported by the dispatcher transport")
+		}
+		return nil
+	}
+	if transcode == nil {
+		transcoder.SetAvroRegistry(channelReference(natssChannel), nil)
+		return nil
+	}
+
+	registry, err := r.loadAvroRegistry(ctx, natssChannel.Namespace, transcode)
+	if err != nil {
+		transcoder.SetAvroRegistry(channelReference(natssChannel), dispatcher.NewUnavailableAvroRegistry(transcode.RegistryURL, err))
+		return err
+	}
+	transcoder.SetAvroRegistry(channelReference(natssChannel), registry)
+	return nil
+}
+
+// loadAvroRegistry returns the registry of transcode with the credentials of its Secret.
+func (r *Reconciler) loadAvroRegistry(ctx context.Context, namespace string, transcode *v1beta1.NatssChannelAvroTranscode) (*dispatcher.AvroRegistry, error) {
+	if transcode.SecretRef == nil {
+		return dispatcher.NewAvroRegistry(transcode.RegistryURL, nil)
+	}
+	secret, err := r.kubeClientSet.CoreV1().Secrets(namespace).Get(ctx, transcode.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the schema registry credentials: %w", err)
+	}
+	registry, err := dispatcher.NewAvroRegistry(transcode.RegistryURL, secret.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema registry credentials in secret %q: %w", transcode.SecretRef.Name, err)
+	}
+	return registry, nil
+}
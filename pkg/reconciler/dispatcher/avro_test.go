@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeAvroTranscoder struct {
+	dispatcher.NatssDispatcher
+
+	registries map[eventingchannels.ChannelReference]*dispatcher.AvroRegistry
+}
+
+func (s *fakeAvroTranscoder) SetAvroRegistry(channel eventingchannels.ChannelReference, registry *dispatcher.AvroRegistry) {
+	if registry == nil {
+		delete(s.registries, channel)
+		return
+	}
+	s.registries[channel] = registry
+}
+
+func TestReconcileAvroTranscode(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNS, Name: "registry"},
+		Data: map[string][]byte{
+			"username": []byte("user"),
+			"password": []byte("secret"),
+		},
+	}
+	registryURL := apis.HTTP("registry.example.com")
+
+	testCases := map[string]struct {
+		transcode     *v1beta1.NatssChannelAvroTranscode
+		unsupported   bool
+		wantErr       bool
+		wantRegistry  bool
+		wantAvailable bool
+	}{
+		"no transcoding": {},
+		"anonymous registry": {
+			transcode:     &v1beta1.NatssChannelAvroTranscode{RegistryURL: registryURL},
+			wantRegistry:  true,
+			wantAvailable: true,
+		},
+		"registry credentials": {
+			transcode: &v1beta1.NatssChannelAvroTranscode{
+				RegistryURL: registryURL,
+				SecretRef:   &corev1.LocalObjectReference{Name: "registry"},
+			},
+			wantRegistry:  true,
+			wantAvailable: true,
+		},
+		"missing secret": {
+			transcode: &v1beta1.NatssChannelAvroTranscode{
+				RegistryURL: registryURL,
+				SecretRef:   &corev1.LocalObjectReference{Name: "missing"},
+			},
+			wantErr:      true,
+			wantRegistry: true,
+		},
+		"unsupported": {
+			transcode:   &v1beta1.NatssChannelAvroTranscode{RegistryURL: registryURL},
+			unsupported: true,
+			wantErr:     true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			transcoder := &fakeAvroTranscoder{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				registries:      make(map[eventingchannels.ChannelReference]*dispatcher.AvroRegistry),
+			}
+			r := &Reconciler{natssDispatcher: transcoder, kubeClientSet: fake.NewSimpleClientset(secret)}
+			if tc.unsupported {
+				r.natssDispatcher = transcoder.NatssDispatcher
+			}
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Spec.AvroTranscode = tc.transcode
+			// A registry left by a previous configuration is replaced.
+			transcoder.registries[channelReference(nc)] = dispatcher.NewUnavailableAvroRegistry(apis.HTTP("stale.example.com"), nil)
+
+			err := r.reconcileAvroTranscode(context.Background(), nc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("reconcileAvroTranscode() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.unsupported {
+				return
+			}
+			registry, ok := transcoder.registries[channelReference(nc)]
+			if ok != tc.wantRegistry {
+				t.Fatalf("registry set = %v, want %v", ok, tc.wantRegistry)
+			}
+			if ok && (registry.Err() == nil) != tc.wantAvailable {
+				t.Errorf("registry error = %v, want available %v", registry.Err(), tc.wantAvailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// errClusterUnsupported is returned for the channels asking for a cluster of their own the
+// dispatcher transport does not support.
+var errClusterUnsupported = errors.New("the NATSS cluster of the channel is not supported by the dispatcher transport")
+
+// reconcileCluster binds natssChannel to the cluster of its spec and reports it in its status.
+func (r *Reconciler) reconcileCluster(natssChannel *v1beta1.NatssChannel) error {
+	binder, ok := r.natssDispatcher.(dispatcher.ClusterBinder)
+	if !ok {
+		natssChannel.Status.Cluster = nil
+		if natssChannel.Spec.Cluster() != (v1beta1.NatssChannelCluster{}) {
+			return errClusterUnsupported
+		}
+		return nil
+	}
+	cluster := binder.BindCluster(channelReference(natssChannel), natssChannel.Spec.Cluster())
+	natssChannel.Status.Cluster = &cluster
+	return nil
+}
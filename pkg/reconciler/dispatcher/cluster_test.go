@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeClusterBinder struct {
+	dispatcher.NatssDispatcher
+
+	bound map[eventingchannels.ChannelReference]v1beta1.NatssChannelCluster
+}
+
+func (b *fakeClusterBinder) BindCluster(channel eventingchannels.ChannelReference, cluster v1beta1.NatssChannelCluster) v1beta1.NatssChannelCluster {
+	if cluster.NatsURL == "" {
+		cluster.NatsURL = "nats://natss:4222"
+	}
+	if cluster.ClusterID == "" {
+		cluster.ClusterID = "knative-nats-streaming"
+	}
+	b.bound[channel] = cluster
+	return cluster
+}
+
+func TestReconcileCluster(t *testing.T) {
+	testCases := map[string]struct {
+		spec        v1beta1.NatssChannelSpec
+		unsupported bool
+		wantErr     bool
+		want        *v1beta1.NatssChannelCluster
+	}{
+		"default cluster": {
+			want: &v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "knative-nats-streaming"},
+		},
+		"cluster of the channel": {
+			spec: v1beta1.NatssChannelSpec{ClusterID: "other"},
+			want: &v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "other"},
+		},
+		"default cluster unsupported": {
+			unsupported: true,
+		},
+		"cluster of the channel unsupported": {
+			spec:        v1beta1.NatssChannelSpec{NatsURL: "nats://other:4222"},
+			unsupported: true,
+			wantErr:     true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			binder := &fakeClusterBinder{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				bound:           make(map[eventingchannels.ChannelReference]v1beta1.NatssChannelCluster),
+			}
+			r := &Reconciler{natssDispatcher: binder}
+			if tc.unsupported {
+				r.natssDispatcher = binder.NatssDispatcher
+			}
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Spec = tc.spec
+
+			if err := r.reconcileCluster(nc); (err != nil) != tc.wantErr {
+				t.Fatalf("reconcileCluster() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if (nc.Status.Cluster == nil) != (tc.want == nil) || (tc.want != nil && *nc.Status.Cluster != *tc.want) {
+				t.Errorf("Status.Cluster = %+v, want %+v", nc.Status.Cluster, tc.want)
+			}
+			if tc.want != nil && binder.bound[channelReference(nc)] != *tc.want {
+				t.Errorf("bound to %+v, want %+v", binder.bound[channelReference(nc)], *tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// servingGroup is the API group of the Knative Serving resources, whose subscribers may be scaled
+// to zero.
+const servingGroup = "serving.knative.dev"
+
+// isNatssChannelColdStart tells whether obj is a Subscription to a NatssChannel with the
+// natss.messaging.knative.dev/cold-start-retries annotation.
+func isNatssChannelColdStart(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.ColdStartRetriesAnnotationKey]
+	return ok
+}
+
+// subscriberScalesToZero tells whether the subscriber of sub is a Knative Service, or one of its
+// Routes or Revisions, reached through the activator while it has no replica. The subscribers
+// given by URI are not detected.
+func subscriberScalesToZero(sub *messagingv1.Subscription) bool {
+	if sub.Spec.Subscriber == nil || sub.Spec.Subscriber.Ref == nil {
+		return false
+	}
+	ref := sub.Spec.Subscriber.Ref
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != servingGroup {
+		return false
+	}
+	switch ref.Kind {
+	case "Service", "Route", "Revision":
+		return true
+	}
+	return false
+}
+
+// reconcileColdStart makes the dispatcher retry with the cold start backoff the deliveries refused
+// by the activator to the subscribers of natssChannel which are Knative Services, unless their
+// Subscription has the natss.messaging.knative.dev/cold-start-retries annotation set to "false",
+// and to the subscribers whose Subscription has it set to "true".
+func (r *Reconciler) reconcileColdStart(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.ColdStartSetter)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	subscribers := make(map[types.UID]bool, len(natssChannel.Spec.Subscribers))
+	for _, spec := range natssChannel.Spec.Subscribers {
+		subscribers[spec.UID] = true
+	}
+
+	coldStart := make(map[types.UID]bool)
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name || !subscribers[sub.UID] {
+			continue
+		}
+		value, ok := sub.Annotations[messaging.ColdStartRetriesAnnotationKey]
+		switch {
+		case value == "true":
+			coldStart[sub.UID] = true
+		case value == "false":
+		case ok:
+			recorder.Eventf(sub, corev1.EventTypeWarning, "ColdStartRetriesInvalid",
+				"Invalid %s annotation %q, expected \"true\" or \"false\", the cold start of the subscriber is detected",
+				messaging.ColdStartRetriesAnnotationKey, value)
+			fallthrough
+		default:
+			if subscriberScalesToZero(sub) {
+				coldStart[sub.UID] = true
+			}
+		}
+	}
+	setter.SetColdStart(channelReference(natssChannel), coldStart)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeColdStartSetter struct {
+	dispatcher.NatssDispatcher
+
+	coldStart map[types.UID]bool
+}
+
+var _ dispatcher.ColdStartSetter = (*fakeColdStartSetter)(nil)
+
+func (f *fakeColdStartSetter) SetColdStart(_ eventingchannels.ChannelReference, subscriptions map[types.UID]bool) {
+	f.coldStart = subscriptions
+}
+
+func TestReconcileColdStart(t *testing.T) {
+	kservice := &duckv1.Destination{Ref: &duckv1.KReference{APIVersion: "serving.knative.dev/v1", Kind: "Service", Name: "svc"}}
+	tests := map[string]struct {
+		subscriber    *duckv1.Destination
+		annotations   map[string]string
+		wantColdStart bool
+		wantEvent     string
+	}{
+		"knative service": {
+			subscriber:    kservice,
+			wantColdStart: true,
+		},
+		"knative route": {
+			subscriber:    &duckv1.Destination{Ref: &duckv1.KReference{APIVersion: "serving.knative.dev/v1", Kind: "Route", Name: "route"}},
+			wantColdStart: true,
+		},
+		"kubernetes service": {
+			subscriber: &duckv1.Destination{Ref: &duckv1.KReference{APIVersion: "v1", Kind: "Service", Name: "svc"}},
+		},
+		"uri": {
+			subscriber: &duckv1.Destination{URI: apis.HTTP("svc.ns.svc.cluster.local")},
+		},
+		"suppressed": {
+			subscriber:  kservice,
+			annotations: map[string]string{messaging.ColdStartRetriesAnnotationKey: "false"},
+		},
+		"forced": {
+			subscriber:    &duckv1.Destination{URI: apis.HTTP("svc.ns.svc.cluster.local")},
+			annotations:   map[string]string{messaging.ColdStartRetriesAnnotationKey: "true"},
+			wantColdStart: true,
+		},
+		"invalid": {
+			subscriber:    kservice,
+			annotations:   map[string]string{messaging.ColdStartRetriesAnnotationKey: "maybe"},
+			wantColdStart: true,
+			wantEvent:     "Warning ColdStartRetriesInvalid",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			sub := &messagingv1.Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   testNS,
+					Name:        "sub",
+					UID:         replaySubscriptionUID,
+					Annotations: tc.annotations,
+				},
+				Spec: messagingv1.SubscriptionSpec{
+					Channel:    corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+					Subscriber: tc.subscriber,
+				},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(sub); err != nil {
+				t.Fatalf("failed to add the subscription: %v", err)
+			}
+			setter := &fakeColdStartSetter{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+			r := &Reconciler{natssDispatcher: setter, subscriptionLister: messaginglisters.NewSubscriptionLister(indexer)}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			r.reconcileColdStart(ctx, reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID)))
+			if got := setter.coldStart[replaySubscriptionUID]; got != tc.wantColdStart {
+				t.Errorf("cold start = %t, want %t", got, tc.wantColdStart)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
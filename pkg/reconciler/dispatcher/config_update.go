@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+// natssServer returns the NATS URL and the cluster ID of the cluster of the dispatcher set by c,
+// defaulting to those of the environment of the dispatcher.
+func natssServer(c *config.Config) (string, string) {
+	url, clusterID := c.ServerURL, c.ServerClusterID
+	if url == "" {
+		url = util.GetDefaultNatssURL()
+	}
+	if clusterID == "" {
+		clusterID = util.GetDefaultClusterID()
+	}
+	return url, clusterID
+}
+
+// newConfigUpdate returns the settings of c the dispatcher applies while it runs.
+func newConfigUpdate(c *config.Config) dispatcher.ConfigUpdate {
+	url, clusterID := natssServer(c)
+	return dispatcher.ConfigUpdate{
+		NatssURL:            url,
+		ClusterID:           clusterID,
+		ReconnectBackoff:    c.ServerReconnectBackoff,
+		ReconnectMaxBackoff: c.ServerReconnectMaxBackoff,
+		DeliveryLimits: dispatcher.DeliveryLimits{
+			MaxRedirects: c.DeliveryMaxRedirects,
+			MaxInflight:  c.DeliveryMaxInflight,
+			AckWait:      c.DeliveryAckWait,
+			StartAt:      c.DeliveryStartAt,
+		},
+		DeliveryConcurrency: c.DeliveryConcurrency,
+		Egress: dispatcher.EgressLimits{
+			EventsPerSecond: c.EgressEventsPerSecond,
+			BytesPerSecond:  c.EgressBytesPerSecond,
+		},
+		Maintenance: c.MaintenanceMode,
+		Publish: dispatcher.PublishOptions{
+			Mode:            dispatcher.PublishMode(c.ReceiverPublish.Mode),
+			MaxAcksInflight: c.ReceiverPublish.MaxInflight,
+			AckTimeout:      c.ReceiverPublish.AckTimeout,
+		},
+	}
+}
+
+// configUpdates applies the changes of the configuration to an updater.
+type configUpdates struct {
+	updater dispatcher.ConfigUpdater
+
+	mu sync.Mutex
+	// server is the NATS URL and the cluster ID last applied.
+	server [2]string
+}
+
+func newConfigUpdates(updater dispatcher.ConfigUpdater, initial *config.Config) *configUpdates {
+	url, clusterID := natssServer(initial)
+	return &configUpdates{updater: updater, server: [2]string{url, clusterID}}
+}
+
+// apply applies c, telling whether the cluster of the dispatcher changed, in which case the
+// channels are reconciled for their status to show it.
+func (u *configUpdates) apply(c *config.Config) bool {
+	update := newConfigUpdate(c)
+	u.updater.UpdateConfig(update)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	server := [2]string{update.NatssURL, update.ClusterID}
+	changed := server != u.server
+	u.server = server
+	return changed
+}
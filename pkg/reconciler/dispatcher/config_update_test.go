@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/util"
+)
+
+type fakeConfigUpdater struct {
+	updates []dispatcher.ConfigUpdate
+}
+
+func (f *fakeConfigUpdater) UpdateConfig(update dispatcher.ConfigUpdate) {
+	f.updates = append(f.updates, update)
+}
+
+func newTestConfig(t *testing.T, data map[string]string) *config.Config {
+	t.Helper()
+	c, err := config.NewConfigFromConfigMap(&corev1.ConfigMap{Data: data})
+	if err != nil {
+		t.Fatalf("NewConfigFromConfigMap() = %v", err)
+	}
+	return c
+}
+
+func TestConfigUpdates(t *testing.T) {
+	initial := newTestConfig(t, nil)
+	updater := &fakeConfigUpdater{}
+	updates := newConfigUpdates(updater, initial)
+
+	// Observed when the watch starts, the initial configuration changes nothing.
+	if updates.apply(initial) {
+		t.Error("apply() = true for the initial configuration")
+	}
+	if got := updater.updates[0]; got.NatssURL != util.GetDefaultNatssURL() || got.ClusterID != util.GetDefaultClusterID() {
+		t.Errorf("the cluster is %s at %s, want the one of the environment", got.ClusterID, got.NatssURL)
+	}
+
+	if !updates.apply(newTestConfig(t, map[string]string{config.ServerURLKey: "nats://other:4222"})) {
+		t.Error("apply() = false once the URL changed")
+	}
+	changed := newTestConfig(t, map[string]string{
+		config.ServerURLKey:              "nats://other:4222",
+		config.DeliveryAckWaitKey:        "2m",
+		config.DeliveryConcurrencyKey:    "4",
+		config.ServerReconnectBackoffKey: "5s",
+		config.EgressEventsPerSecondKey:  "100",
+	})
+	if updates.apply(changed) {
+		t.Error("apply() = true while the cluster is the same")
+	}
+	got := updater.updates[2]
+	if got.DeliveryLimits.AckWait != 2*time.Minute || got.DeliveryConcurrency != 4 || got.ReconnectBackoff != 5*time.Second ||
+		got.Egress.EventsPerSecond != 100 {
+		t.Errorf("UpdateConfig() called with %+v, want the settings of the configuration", got)
+	}
+}
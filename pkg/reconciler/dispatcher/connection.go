@@ -0,0 +1,47 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileConnection reports whether the dispatcher is connected to the NATSS cluster of
+// natssChannel, the channel being reconciled again when the connection is made, lost or fails.
+// The dispatchers which do not report their connection are taken for connected.
+func (r *Reconciler) reconcileConnection(natssChannel *v1beta1.NatssChannel) {
+	reporter, ok := r.natssDispatcher.(dispatcher.ConnectionReporter)
+	if !ok {
+		natssChannel.Status.MarkNatssConnectionReady()
+		return
+	}
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	reporter.WatchConnection(channelReference(natssChannel), func() { r.enqueueKey(key) })
+	status := reporter.ConnectionStatus(channelReference(natssChannel))
+	switch {
+	case status.Ready:
+		natssChannel.Status.MarkNatssConnectionReady()
+	case !status.LostSince.IsZero():
+		natssChannel.Status.MarkNatssConnectionLost(status.URL, status.LostSince, status.Err)
+	default:
+		natssChannel.Status.MarkNatssConnectionFailed(status.URL, status.Err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeConnectionReporter struct {
+	dispatcher.NatssDispatcher
+
+	watched map[eventingchannels.ChannelReference]func()
+	status  dispatcher.ConnectionStatus
+}
+
+func (f *fakeConnectionReporter) WatchConnection(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(f.watched, channel)
+		return
+	}
+	f.watched[channel] = notify
+}
+
+func (f *fakeConnectionReporter) ConnectionStatus(eventingchannels.ChannelReference) dispatcher.ConnectionStatus {
+	return f.status
+}
+
+func TestReconcileConnection(t *testing.T) {
+	const natsURL = "nats://natss.natss.svc:4222"
+
+	testCases := map[string]struct {
+		status      dispatcher.ConnectionStatus
+		unsupported bool
+		wantStatus  corev1.ConditionStatus
+		wantReason  string
+		// wantMessage are the parts of the message of the condition.
+		wantMessage []string
+	}{
+		"connected": {
+			status:     dispatcher.ConnectionStatus{URL: natsURL, Ready: true},
+			wantStatus: corev1.ConditionTrue,
+		},
+		"not connected yet": {
+			status:      dispatcher.ConnectionStatus{URL: natsURL},
+			wantStatus:  corev1.ConditionFalse,
+			wantReason:  "NatssNotConnected",
+			wantMessage: []string{natsURL},
+		},
+		"connection failed": {
+			status:      dispatcher.ConnectionStatus{URL: natsURL, Err: errors.New("authorization violation")},
+			wantStatus:  corev1.ConditionFalse,
+			wantReason:  "NatssConnectionFailed",
+			wantMessage: []string{natsURL, "authorization violation"},
+		},
+		"lost": {
+			status: dispatcher.ConnectionStatus{
+				URL:       natsURL,
+				LostSince: time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC),
+				Err:       errors.New("connection refused"),
+			},
+			wantStatus:  corev1.ConditionFalse,
+			wantReason:  "NatssConnectionLost",
+			wantMessage: []string{natsURL, "2020-11-01T09:00:00Z", "connection refused"},
+		},
+		"unsupported": {
+			unsupported: true,
+			wantStatus:  corev1.ConditionTrue,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			reporter := &fakeConnectionReporter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				watched:         make(map[eventingchannels.ChannelReference]func()),
+				status:          tc.status,
+			}
+			r := &Reconciler{natssDispatcher: reporter}
+			if tc.unsupported {
+				r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+			}
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS,
+				reconciletesting.WithNatssInitChannelConditions,
+				reconciletesting.WithNatssChannelDeploymentReady(),
+				reconciletesting.WithNatssChannelServiceReady(),
+				reconciletesting.WithNatssChannelEndpointsReady(),
+				reconciletesting.WithNatssChannelChannelServiceReady(),
+				reconciletesting.WithNatssChannelAddress("channel.ns.svc.cluster.local"))
+			r.reconcileConnection(nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionNatssConnectionReady)
+			if cond == nil || cond.Status != tc.wantStatus || cond.Reason != tc.wantReason {
+				t.Fatalf("condition = %+v, want status %s and reason %q", cond, tc.wantStatus, tc.wantReason)
+			}
+			for _, part := range tc.wantMessage {
+				if !strings.Contains(cond.Message, part) {
+					t.Errorf("message = %q, want it to include %q", cond.Message, part)
+				}
+			}
+			if ready := nc.Status.IsReady(); ready != (tc.wantStatus == corev1.ConditionTrue) {
+				t.Errorf("IsReady() = %t, want %t", ready, tc.wantStatus == corev1.ConditionTrue)
+			}
+			if _, watched := reporter.watched[channelReference(nc)]; watched == tc.unsupported {
+				t.Errorf("watched = %v, want %v", watched, !tc.unsupported)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// isNatssChannelConsumers tells whether obj is a Subscription to a NatssChannel with the
+// natss.messaging.knative.dev/consumers annotation.
+func isNatssChannelConsumers(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.ConsumersAnnotationKey]
+	return ok
+}
+
+// parseConsumers returns the number of consumers set by the natss.messaging.knative.dev/consumers
+// annotation value.
+func parseConsumers(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > dispatcher.MaxConsumers {
+		return 0, fmt.Errorf("invalid %s annotation %q, expected an integer from 1 to %d", messaging.ConsumersAnnotationKey, value, dispatcher.MaxConsumers)
+	}
+	return n, nil
+}
+
+// reconcileConsumers makes the dispatcher process the events of the subscribers of natssChannel
+// whose Subscription has the natss.messaging.knative.dev/consumers annotation with as many
+// consumers in parallel, and records the changes of consumers on the Subscriptions.
+func (r *Reconciler) reconcileConsumers(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.ConsumersSetter)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	subscribers := make(map[types.UID]bool, len(natssChannel.Spec.Subscribers))
+	for _, spec := range natssChannel.Spec.Subscribers {
+		subscribers[spec.UID] = true
+	}
+
+	channel := channelReference(natssChannel)
+	ordered := deliveredInOrder(natssChannel)
+	consumers := make(map[types.UID]int)
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name || !subscribers[sub.UID] {
+			continue
+		}
+		// The Subscriptions whose annotation was removed have a single consumer again.
+		n := 1
+		if value, ok := sub.Annotations[messaging.ConsumersAnnotationKey]; ok {
+			parsed, err := parseConsumers(value)
+			switch {
+			case err != nil:
+				recorder.Eventf(sub, corev1.EventTypeWarning, "ConsumersInvalid", "%v, the subscription has a single consumer", err)
+			case parsed > 1 && natssChannel.Spec.Distribution == v1beta1.DistributionWorkQueue:
+				recorder.Event(sub, corev1.EventTypeWarning, "SubscriptionConsumersIgnored",
+					"The subscription has a single consumer, the members of a work queue share its queue group")
+			case parsed > 1 && ordered:
+				recorder.Event(sub, corev1.EventTypeWarning, "SubscriptionConsumersIgnored",
+					"The subscription has a single consumer, the events of the channel being delivered in order")
+			case parsed > 1 && subscriptionOrdered(sub):
+				recorder.Event(sub, corev1.EventTypeWarning, "SubscriptionConsumersIgnored",
+					"The subscription has a single consumer, its events being delivered in order")
+			default:
+				n = parsed
+			}
+		}
+		if n > 1 {
+			consumers[sub.UID] = n
+		}
+		held := setter.Consumers(channel, sub.UID)
+		switch {
+		case held == 0 || held == n:
+		case (held > 1) != (n > 1):
+			recorder.Eventf(sub, corev1.EventTypeWarning, "SubscriptionConsumers",
+				"Processing the events with %d consumers instead of %d: the durable of the subscription is made again, from the events published from now on", n, held)
+		default:
+			recorder.Eventf(sub, corev1.EventTypeNormal, "SubscriptionConsumers", "Processing the events with %d consumers instead of %d", n, held)
+		}
+	}
+	setter.SetConsumers(channel, consumers)
+}
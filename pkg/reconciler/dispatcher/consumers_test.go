@@ -0,0 +1,163 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeConsumersSetter struct {
+	dispatcher.NatssDispatcher
+
+	consumers map[types.UID]int
+	held      map[types.UID]int
+}
+
+var _ dispatcher.ConsumersSetter = (*fakeConsumersSetter)(nil)
+
+func (f *fakeConsumersSetter) SetConsumers(_ eventingchannels.ChannelReference, consumers map[types.UID]int) {
+	f.consumers = consumers
+}
+
+func (f *fakeConsumersSetter) Consumers(_ eventingchannels.ChannelReference, subscription types.UID) int {
+	return f.held[subscription]
+}
+
+func TestReconcileConsumers(t *testing.T) {
+	tests := map[string]struct {
+		annotations   map[string]string
+		workQueue     bool
+		ordered       bool
+		held          int
+		wantConsumers int
+		wantEvent     string
+	}{
+		"single consumer": {},
+		"several consumers": {
+			annotations:   map[string]string{messaging.ConsumersAnnotationKey: "4"},
+			wantConsumers: 4,
+		},
+		"maximum": {
+			annotations:   map[string]string{messaging.ConsumersAnnotationKey: "32"},
+			wantConsumers: 32,
+		},
+		"more consumers": {
+			annotations:   map[string]string{messaging.ConsumersAnnotationKey: "3"},
+			held:          2,
+			wantConsumers: 3,
+			wantEvent:     "Normal SubscriptionConsumers",
+		},
+		"made several consumers": {
+			annotations:   map[string]string{messaging.ConsumersAnnotationKey: "2"},
+			held:          1,
+			wantConsumers: 2,
+			wantEvent:     "Warning SubscriptionConsumers",
+		},
+		"annotation removed": {
+			held:      2,
+			wantEvent: "Warning SubscriptionConsumers",
+		},
+		"zero": {
+			annotations: map[string]string{messaging.ConsumersAnnotationKey: "0"},
+			wantEvent:   "Warning ConsumersInvalid",
+		},
+		"not a number": {
+			annotations: map[string]string{messaging.ConsumersAnnotationKey: "abc"},
+			wantEvent:   "Warning ConsumersInvalid",
+		},
+		"above the maximum": {
+			annotations: map[string]string{messaging.ConsumersAnnotationKey: "33"},
+			wantEvent:   "Warning ConsumersInvalid",
+		},
+		"work queue": {
+			annotations: map[string]string{messaging.ConsumersAnnotationKey: "4"},
+			workQueue:   true,
+			wantEvent:   "Warning SubscriptionConsumersIgnored",
+		},
+		"delivered in order": {
+			annotations: map[string]string{messaging.ConsumersAnnotationKey: "4"},
+			ordered:     true,
+			wantEvent:   "Warning SubscriptionConsumersIgnored",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			sub := &messagingv1.Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   testNS,
+					Name:        "sub",
+					UID:         replaySubscriptionUID,
+					Annotations: tc.annotations,
+				},
+				Spec: messagingv1.SubscriptionSpec{
+					Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+				},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(sub); err != nil {
+				t.Fatalf("failed to add the subscription: %v", err)
+			}
+			setter := &fakeConsumersSetter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				held:            map[types.UID]int{replaySubscriptionUID: tc.held},
+			}
+			r := &Reconciler{natssDispatcher: setter, subscriptionLister: messaginglisters.NewSubscriptionLister(indexer)}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+			if tc.workQueue {
+				nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+			}
+			if tc.ordered {
+				nc.Annotations = map[string]string{messaging.DeliveryOrderAnnotationKey: string(v1beta1.DeliveryOrdered)}
+			}
+			r.reconcileConsumers(ctx, nc)
+			if got := setter.consumers[replaySubscriptionUID]; got != tc.wantConsumers {
+				t.Errorf("consumers = %d, want %d", got, tc.wantConsumers)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
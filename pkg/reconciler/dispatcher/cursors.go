@@ -0,0 +1,280 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+const (
+	// cursorsConfigMapName is the name of the ConfigMap holding the delivery cursors of the
+	// durables, the sequence of each keyed by <namespace>_<channel>_<durable>.
+	cursorsConfigMapName = "natss-ch-dispatcher-cursors"
+
+	// cursorsPath is the path of the endpoint listing the delivery cursors.
+	cursorsPath = "/debug/cursors"
+)
+
+var (
+	// cursorsFlushInterval is how long the changed cursors wait before being written.
+	cursorsFlushInterval = 5 * time.Second
+	// cursorsFlushAcks is the number of acknowledgements writing the changed cursors before
+	// cursorsFlushInterval.
+	cursorsFlushAcks uint64 = 1000
+	// cursorsCheckInterval is how often the cursors are checked, which bounds the writes to one
+	// per interval whatever the rate of the acknowledgements.
+	cursorsCheckInterval = time.Second
+)
+
+// deliveryCursor is a cursor as listed on cursorsPath.
+type deliveryCursor struct {
+	// Channel is the namespace/name of the channel of the durable.
+	Channel string `json:"channel"`
+	// Subject is the NATSS subject of the channel.
+	Subject  string `json:"subject"`
+	Durable  string `json:"durable"`
+	Sequence uint64 `json:"sequence"`
+}
+
+// cursorStore persists the delivery cursors of the dispatcher in a ConfigMap, writing them in
+// batches. A crash loses the cursors changed since the last write, which only makes the
+// consumers resuming from them receive some messages twice.
+type cursorStore struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	tracker    dispatcher.DeliveryCursorTracker
+	now        func() time.Time
+
+	mu     sync.Mutex
+	loaded bool
+	// persisted is the data last written, nil when the ConfigMap does not exist.
+	persisted map[string]string
+	// flushedAcks and flushedAt are the number of acknowledgements and the time of the last
+	// check which found the cursors written.
+	flushedAcks uint64
+	flushedAt   time.Time
+}
+
+func newCursorStore(kubeClient kubernetes.Interface, namespace string, tracker dispatcher.DeliveryCursorTracker) *cursorStore {
+	return &cursorStore{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		tracker:    tracker,
+		now:        time.Now,
+	}
+}
+
+// registerDeliveryCursors registers the hook persisting the delivery cursors of tracker, and
+// serves them on cursorsPath of admin.
+func registerDeliveryCursors(ctx context.Context, lifecycle *dispatcher.Lifecycle, admin *http.ServeMux, tracker dispatcher.DeliveryCursorTracker) error {
+	logger := logging.FromContext(ctx)
+	store := newCursorStore(kubeclient.Get(ctx), stateNamespace(ctx), tracker)
+
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+	// The cursors are loaded before the subscriptions start, and written a last time once they
+	// stopped.
+	hook := dispatcher.Hook{
+		Name:     "delivery-cursors",
+		Priority: dispatcher.PrioritySubscriptions - 1,
+		Start: func(ctx context.Context) error {
+			if err := store.load(ctx); err != nil {
+				// The cursors are loaded again before they are written.
+				logger.Errorw("Error loading the delivery cursors", zap.Error(err))
+			}
+			var runCtx context.Context
+			runCtx, cancel = context.WithCancel(logging.WithLogger(context.Background(), logger))
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				store.run(runCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			<-done
+			return store.flush(ctx)
+		},
+	}
+	if err := lifecycle.Register(hook); err != nil {
+		return err
+	}
+	admin.Handle(cursorsPath, store)
+	return nil
+}
+
+// run writes the changed cursors every cursorsFlushInterval, or sooner after cursorsFlushAcks
+// acknowledgements, until ctx is done.
+func (s *cursorStore) run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(cursorsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flushIfDue(ctx); err != nil {
+				logger.Errorw("Error writing the delivery cursors", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// load reads the persisted cursors into the tracker. The invalid entries are ignored.
+func (s *cursorStore) load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked(ctx)
+}
+
+func (s *cursorStore) loadLocked(ctx context.Context) error {
+	cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, cursorsConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		s.loaded, s.persisted = true, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cursors := make([]dispatcher.DeliveryCursor, 0, len(cm.Data))
+	for key, value := range cm.Data {
+		channel, durable, ok := parseCursorKey(key)
+		sequence, err := strconv.ParseUint(value, 10, 64)
+		if !ok || err != nil {
+			logging.FromContext(ctx).Warnw("Ignoring an invalid delivery cursor", zap.String("key", key), zap.String("value", value))
+			continue
+		}
+		cursors = append(cursors, dispatcher.DeliveryCursor{Channel: channel, Durable: durable, Sequence: sequence})
+	}
+	s.tracker.LoadDeliveryCursors(cursors)
+	s.loaded, s.persisted = true, cm.Data
+	return nil
+}
+
+// flushIfDue writes the cursors when cursorsFlushInterval passed or cursorsFlushAcks messages
+// were acknowledged since they were last written.
+func (s *cursorStore) flushIfDue(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, acks := s.tracker.DeliveryCursors()
+	if s.loaded && acks-s.flushedAcks < cursorsFlushAcks && s.now().Sub(s.flushedAt) < cursorsFlushInterval {
+		return nil
+	}
+	return s.flushLocked(ctx)
+}
+
+// flush writes the cursors if they changed since they were last written.
+func (s *cursorStore) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked(ctx)
+}
+
+func (s *cursorStore) flushLocked(ctx context.Context) error {
+	// Writing before loading would drop the cursors of the durables not resumed yet.
+	if !s.loaded {
+		if err := s.loadLocked(ctx); err != nil {
+			return err
+		}
+	}
+	cursors, acks := s.tracker.DeliveryCursors()
+	data := make(map[string]string, len(cursors))
+	for _, c := range cursors {
+		data[cursorKey(c.Channel, c.Durable)] = strconv.FormatUint(c.Sequence, 10)
+	}
+	if !reflect.DeepEqual(data, s.persisted) && (len(data) > 0 || s.persisted != nil) {
+		if err := s.write(ctx, data); err != nil {
+			return err
+		}
+		s.persisted = data
+	}
+	s.flushedAcks, s.flushedAt = acks, s.now()
+	return nil
+}
+
+func (s *cursorStore) write(ctx context.Context, data map[string]string) error {
+	cms := s.kubeClient.CoreV1().ConfigMaps(s.namespace)
+	cm, err := cms.Get(ctx, cursorsConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = cms.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cursorsConfigMapName, Namespace: s.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm = cm.DeepCopy()
+	cm.Data = data
+	_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// ServeHTTP lists the current cursors as JSON, ahead of the persisted ones.
+func (s *cursorStore) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	cursors, _ := s.tracker.DeliveryCursors()
+	list := make([]deliveryCursor, 0, len(cursors))
+	for _, c := range cursors {
+		list = append(list, deliveryCursor{
+			Channel:  c.Channel.String(),
+			Subject:  dispatcher.Subject(c.Channel),
+			Durable:  c.Durable,
+			Sequence: c.Sequence,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// cursorKey returns the ConfigMap key of the cursor of durable. The names of the namespaces, of
+// the channels and of the durables have no underscore.
+func cursorKey(channel eventingchannels.ChannelReference, durable string) string {
+	return channel.Namespace + "_" + channel.Name + "_" + durable
+}
+
+func parseCursorKey(key string) (eventingchannels.ChannelReference, string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return eventingchannels.ChannelReference{}, "", false
+	}
+	return eventingchannels.ChannelReference{Namespace: parts[0], Name: parts[1]}, parts[2], true
+}
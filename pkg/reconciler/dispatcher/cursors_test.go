@@ -0,0 +1,252 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+var cursorsChannel = eventingchannels.ChannelReference{Namespace: testNS, Name: "channel"}
+
+// fakeCursorTracker holds the cursors of a dispatcher, the loaded ones replacing them.
+type fakeCursorTracker struct {
+	cursors []dispatcher.DeliveryCursor
+	acks    uint64
+}
+
+func (t *fakeCursorTracker) DeliveryCursors() ([]dispatcher.DeliveryCursor, uint64) {
+	return t.cursors, t.acks
+}
+
+func (t *fakeCursorTracker) LoadDeliveryCursors(cursors []dispatcher.DeliveryCursor) {
+	t.cursors = cursors
+}
+
+func (t *fakeCursorTracker) advance(sequence, acks uint64) {
+	t.cursors = []dispatcher.DeliveryCursor{{Channel: cursorsChannel, Durable: liveUID, Sequence: sequence}}
+	t.acks += acks
+}
+
+func persistedCursors(t *testing.T, kubeClient *fake.Clientset) map[string]string {
+	t.Helper()
+	cm, err := kubeClient.CoreV1().ConfigMaps(hostMapNamespace).Get(context.Background(), cursorsConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(%s) = %v", cursorsConfigMapName, err)
+	}
+	return cm.Data
+}
+
+func countWrites(kubeClient *fake.Clientset) int {
+	writes := 0
+	for _, action := range kubeClient.Actions() {
+		if action.GetVerb() == "create" || action.GetVerb() == "update" {
+			writes++
+		}
+	}
+	return writes
+}
+
+func TestCursorStoreBatches(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	tracker := &fakeCursorTracker{}
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	store := newCursorStore(kubeClient, hostMapNamespace, tracker)
+	store.now = func() time.Time { return now }
+
+	if err := store.load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	// Nothing is written before there is a cursor.
+	if err := store.flushIfDue(ctx); err != nil {
+		t.Fatalf("flushIfDue() = %v", err)
+	}
+	if got := countWrites(kubeClient); got != 0 {
+		t.Errorf("writes without cursor = %d, want 0", got)
+	}
+
+	testCases := []struct {
+		name      string
+		elapsed   time.Duration
+		sequence  uint64
+		acks      uint64
+		wantWrite bool
+	}{{
+		name:     "before the interval",
+		elapsed:  time.Second,
+		sequence: 10,
+		acks:     10,
+	}, {
+		name:      "after the interval",
+		elapsed:   cursorsFlushInterval,
+		sequence:  20,
+		acks:      10,
+		wantWrite: true,
+	}, {
+		name:      "after a batch of acknowledgements",
+		elapsed:   time.Second,
+		sequence:  1020,
+		acks:      cursorsFlushAcks,
+		wantWrite: true,
+	}, {
+		name:     "unchanged",
+		elapsed:  cursorsFlushInterval,
+		sequence: 1020,
+	}}
+	written := "0"
+	for _, tc := range testCases {
+		writes := countWrites(kubeClient)
+		now = now.Add(tc.elapsed)
+		tracker.advance(tc.sequence, tc.acks)
+		if err := store.flushIfDue(ctx); err != nil {
+			t.Fatalf("%s: flushIfDue() = %v", tc.name, err)
+		}
+		if got := countWrites(kubeClient) > writes; got != tc.wantWrite {
+			t.Errorf("%s: written = %v, want %v", tc.name, got, tc.wantWrite)
+		}
+		if tc.wantWrite {
+			written = persistedCursors(t, kubeClient)[testNS+"_channel_"+liveUID]
+		}
+	}
+	if written != "1020" {
+		t.Errorf("persisted sequence = %s, want 1020", written)
+	}
+}
+
+func TestCursorStoreCrashRecovery(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: cursorsConfigMapName, Namespace: hostMapNamespace},
+		Data: map[string]string{
+			testNS + "_hibernated_" + orphanUID: "42",
+			"invalid":                           "1",
+			testNS + "_channel_" + liveUID:      "not-a-sequence",
+		},
+	})
+
+	// The dispatcher loads the cursors persisted before it crashed.
+	d, err := dispatcher.NewDispatcher(dispatcher.Args{ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	tracker := d.(dispatcher.DeliveryCursorTracker)
+	store := newCursorStore(kubeClient, hostMapNamespace, tracker)
+	if err := store.load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	want := []dispatcher.DeliveryCursor{{
+		Channel:  eventingchannels.ChannelReference{Namespace: testNS, Name: "hibernated"},
+		Durable:  orphanUID,
+		Sequence: 42,
+	}}
+	if got, _ := tracker.DeliveryCursors(); !cmp.Equal(want, got) {
+		t.Errorf("loaded cursors (-want, +got) = %s", cmp.Diff(want, got))
+	}
+	// The cursors of the durables not resumed are written again, the invalid ones are dropped.
+	if err := store.flush(ctx); err != nil {
+		t.Fatalf("flush() = %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{testNS + "_hibernated_" + orphanUID: "42"}, persistedCursors(t, kubeClient)); diff != "" {
+		t.Errorf("persisted cursors (-want, +got) = %s", diff)
+	}
+
+	// The next dispatcher reports the stale cursor until the durable resumes.
+	restarted := &fakeCursorTracker{}
+	if err := newCursorStore(kubeClient, hostMapNamespace, restarted).load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if diff := cmp.Diff(want, restarted.cursors); diff != "" {
+		t.Errorf("cursors after the crash (-want, +got) = %s", diff)
+	}
+}
+
+func TestCursorStoreWritesAfterLoading(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	failed := false
+	kubeClient.PrependReactor("get", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		if failed {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, errors.New("injected error")
+	})
+	tracker := &fakeCursorTracker{}
+	store := newCursorStore(kubeClient, hostMapNamespace, tracker)
+	if err := store.load(ctx); err == nil {
+		t.Fatal("load() succeeded, want the injected error")
+	}
+
+	// The cursors are loaded before they are written.
+	tracker.advance(7, 1)
+	if err := store.flushIfDue(ctx); err != nil {
+		t.Fatalf("flushIfDue() = %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{testNS + "_channel_" + liveUID: "7"}, persistedCursors(t, kubeClient)); diff != "" {
+		t.Errorf("persisted cursors (-want, +got) = %s", diff)
+	}
+}
+
+func TestCursorsEndpoint(t *testing.T) {
+	tracker := &fakeCursorTracker{}
+	tracker.advance(12, 12)
+	store := newCursorStore(fake.NewSimpleClientset(), hostMapNamespace, tracker)
+
+	recorder := httptest.NewRecorder()
+	store.ServeHTTP(recorder, httptest.NewRequest("GET", cursorsPath, nil))
+	var got []deliveryCursor
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	want := []deliveryCursor{{
+		Channel:  testNS + "/channel",
+		Subject:  dispatcher.Subject(cursorsChannel),
+		Durable:  liveUID,
+		Sequence: 12,
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("cursors (-want, +got) = %s", diff)
+	}
+}
+
+func TestCursorKey(t *testing.T) {
+	key := cursorKey(cursorsChannel, liveUID)
+	channel, durable, ok := parseCursorKey(key)
+	if !ok || channel != cursorsChannel || durable != liveUID {
+		t.Errorf("parseCursorKey(%q) = %v, %q, %v", key, channel, durable, ok)
+	}
+	for _, invalid := range []string{"", "ns_channel", "ns__durable", "ns_channel_durable_more"} {
+		if _, _, ok := parseCursorKey(invalid); ok {
+			t.Errorf("parseCursorKey(%q) succeeded, want a failure", invalid)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// defaultDeadLetterSinks holds the default dead letter sinks of the namespaces, which follow the
+// config-natss ConfigMap.
+type defaultDeadLetterSinks struct {
+	mu    sync.Mutex
+	sinks map[string]*apis.URL
+}
+
+// set replaces the sinks, returning the namespaces whose sink was added, changed or removed.
+func (d *defaultDeadLetterSinks) set(sinks map[string]*apis.URL) sets.String {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed := sets.NewString()
+	for namespace, sink := range sinks {
+		if old, ok := d.sinks[namespace]; !ok || old.String() != sink.String() {
+			changed.Insert(namespace)
+		}
+	}
+	for namespace := range d.sinks {
+		if _, ok := sinks[namespace]; !ok {
+			changed.Insert(namespace)
+		}
+	}
+	d.sinks = sinks
+	return changed
+}
+
+// get returns the default dead letter sink of namespace, nil when it has none.
+func (d *defaultDeadLetterSinks) get(namespace string) *apis.URL {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sinks[namespace]
+}
+
+// applyDefaultDeadLetterSink sets the default dead letter sink of the namespace of natssChannel on
+// the subscribers of c without a dead letter sink, and records it in the status of natssChannel.
+// A dead letter sink set on the channel or on a subscription always takes precedence.
+func (r *Reconciler) applyDefaultDeadLetterSink(natssChannel *v1beta1.NatssChannel, c *messagingv1.Channel) {
+	natssChannel.Status.DeadLetterSinkURI = nil
+	sink := r.defaultDeadLetterSinks.get(natssChannel.Namespace)
+	if sink == nil || hasDeadLetterSink(natssChannel.Spec.Delivery) {
+		return
+	}
+	for i, sub := range c.Spec.Subscribers {
+		if hasDeadLetterSink(sub.Delivery) {
+			continue
+		}
+		delivery := &eventingduckv1.DeliverySpec{}
+		if sub.Delivery != nil {
+			delivery = sub.Delivery.DeepCopy()
+		}
+		delivery.DeadLetterSink = &duckv1.Destination{URI: sink.DeepCopy()}
+		c.Spec.Subscribers[i].Delivery = delivery
+		natssChannel.Status.DeadLetterSinkURI = sink.DeepCopy()
+	}
+}
+
+func hasDeadLetterSink(delivery *eventingduckv1.DeliverySpec) bool {
+	return delivery != nil && delivery.DeadLetterSink != nil
+}
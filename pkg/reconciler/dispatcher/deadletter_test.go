@@ -0,0 +1,135 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func TestApplyDefaultDeadLetterSink(t *testing.T) {
+	namespaceSink := apis.HTTP("dls.team-a.svc.cluster.local")
+	channelSink := apis.HTTP("channel-dls.team-a.svc.cluster.local")
+	subscriptionSink := apis.HTTP("subscription-dls.team-a.svc.cluster.local")
+	withDeadLetterSink := func(sink *apis.URL) *eventingduckv1.DeliverySpec {
+		return &eventingduckv1.DeliverySpec{DeadLetterSink: &duckv1.Destination{URI: sink}}
+	}
+	retry := int32(3)
+
+	testCases := map[string]struct {
+		namespace       string
+		channelDelivery *eventingduckv1.DeliverySpec
+		subscribers     []*eventingduckv1.DeliverySpec
+		// want are the dead letter sinks of the subscribers, nil standing for none.
+		want       []*apis.URL
+		wantStatus *apis.URL
+	}{
+		"namespace default": {
+			namespace:   "team-a",
+			subscribers: []*eventingduckv1.DeliverySpec{nil, {Retry: &retry}},
+			want:        []*apis.URL{namespaceSink, namespaceSink},
+			wantStatus:  namespaceSink,
+		},
+		"subscription sink takes precedence": {
+			namespace:   "team-a",
+			subscribers: []*eventingduckv1.DeliverySpec{withDeadLetterSink(subscriptionSink), nil},
+			want:        []*apis.URL{subscriptionSink, namespaceSink},
+			wantStatus:  namespaceSink,
+		},
+		"only subscription sinks": {
+			namespace:   "team-a",
+			subscribers: []*eventingduckv1.DeliverySpec{withDeadLetterSink(subscriptionSink)},
+			want:        []*apis.URL{subscriptionSink},
+		},
+		"channel sink takes precedence": {
+			namespace:       "team-a",
+			channelDelivery: withDeadLetterSink(channelSink),
+			subscribers:     []*eventingduckv1.DeliverySpec{nil},
+			want:            []*apis.URL{nil},
+		},
+		"namespace without default": {
+			namespace:   "team-b",
+			subscribers: []*eventingduckv1.DeliverySpec{nil},
+			want:        []*apis.URL{nil},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			r := &Reconciler{defaultDeadLetterSinks: &defaultDeadLetterSinks{}}
+			r.defaultDeadLetterSinks.set(map[string]*apis.URL{"team-a": namespaceSink})
+
+			nc := reconciletesting.NewNatssChannel("channel", tc.namespace)
+			nc.Spec.Delivery = tc.channelDelivery
+			for _, delivery := range tc.subscribers {
+				nc.Spec.Subscribers = append(nc.Spec.Subscribers, eventingduckv1.SubscriberSpec{Delivery: delivery})
+			}
+			// A stale status is cleared.
+			nc.Status.DeadLetterSinkURI = apis.HTTP("stale")
+			original := nc.DeepCopy()
+
+			c := toChannel(nc)
+			r.applyDefaultDeadLetterSink(nc, c)
+			var got []*apis.URL
+			for _, sub := range c.Spec.Subscribers {
+				var sink *apis.URL
+				if sub.Delivery != nil && sub.Delivery.DeadLetterSink != nil {
+					sink = sub.Delivery.DeadLetterSink.URI
+				}
+				got = append(got, sink)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("dead letter sinks (-want, +got) = %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantStatus, nc.Status.DeadLetterSinkURI); diff != "" {
+				t.Errorf("status.deadLetterSinkURI (-want, +got) = %s", diff)
+			}
+			if diff := cmp.Diff(original.Spec, nc.Spec); diff != "" {
+				t.Errorf("the spec of the channel changed (-want, +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestDefaultDeadLetterSinksRemoved(t *testing.T) {
+	sinks := &defaultDeadLetterSinks{}
+	if changed := sinks.set(map[string]*apis.URL{"team-a": apis.HTTP("dls"), "team-b": apis.HTTP("dls")}); changed.Len() != 2 {
+		t.Errorf("set() changed %v, want both namespaces", changed.List())
+	}
+	changed := sinks.set(map[string]*apis.URL{"team-a": apis.HTTP("dls")})
+	if diff := cmp.Diff([]string{"team-b"}, changed.List()); diff != "" {
+		t.Errorf("set() changed (-want, +got) = %s", diff)
+	}
+
+	// The default stops applying once removed.
+	r := &Reconciler{defaultDeadLetterSinks: sinks}
+	nc := reconciletesting.NewNatssChannel("channel", "team-b")
+	nc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{{}}
+	nc.Status.DeadLetterSinkURI = apis.HTTP("dls")
+	c := toChannel(nc)
+	r.applyDefaultDeadLetterSink(nc, c)
+	if c.Spec.Subscribers[0].Delivery != nil || nc.Status.DeadLetterSinkURI != nil {
+		t.Errorf("delivery = %v, status.deadLetterSinkURI = %v, want the removed default not applied",
+			c.Spec.Subscribers[0].Delivery, nc.Status.DeadLetterSinkURI)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// configPath is the path of the endpoint showing the effective configuration of the dispatcher.
+const configPath = "/debug/config"
+
+// effectiveConfig is the configuration of the dispatcher as served on configPath, the settings
+// learnt from NATS included.
+type effectiveConfig struct {
+	// MaxPayload is the size in bytes of the largest event accepted by the receiver, zero while
+	// it is unknown.
+	MaxPayload int64 `json:"maxPayload"`
+}
+
+// debugConfigHandler serves the effective configuration of the dispatcher.
+type debugConfigHandler struct {
+	maxPayload dispatcher.MaxPayloadReporter
+}
+
+// ServeHTTP shows the effective configuration of the dispatcher as JSON.
+func (h *debugConfigHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	config := effectiveConfig{MaxPayload: h.maxPayload.MaxPayload()}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
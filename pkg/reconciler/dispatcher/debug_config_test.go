@@ -0,0 +1,46 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeMaxPayloadReporter int64
+
+func (r fakeMaxPayloadReporter) MaxPayload() int64 {
+	return int64(r)
+}
+
+func TestDebugConfigHandler(t *testing.T) {
+	handler := &debugConfigHandler{maxPayload: fakeMaxPayloadReporter(1 << 20)}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", configPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var got effectiveConfig
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if got.MaxPayload != 1<<20 {
+		t.Errorf("maxPayload = %d, want %d", got.MaxPayload, 1<<20)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// deliveryTimeoutInvalidReason is the reason of the status of the subscribers whose delivery
+// timeout is invalid.
+const deliveryTimeoutInvalidReason = "DeliveryTimeoutInvalid"
+
+// isNatssChannelDeliveryTimeout tells whether obj is a Subscription to a NatssChannel with the
+// natss.messaging.knative.dev/delivery-timeout annotation.
+func isNatssChannelDeliveryTimeout(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.DeliveryTimeoutAnnotationKey]
+	return ok
+}
+
+// reconcileDeliveryTimeouts bounds the requests of the deliveries to the subscribers of
+// natssChannel whose Subscription has the natss.messaging.knative.dev/delivery-timeout annotation.
+// It returns the errors of the invalid annotations by UID, whose subscribers are delivered with
+// the timeout of the dispatcher.
+func (r *Reconciler) reconcileDeliveryTimeouts(ctx context.Context, natssChannel *v1beta1.NatssChannel) map[types.UID]error {
+	setter, ok := r.natssDispatcher.(dispatcher.DeliveryTimeoutSetter)
+	if !ok || r.subscriptionLister == nil {
+		return nil
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return nil
+	}
+	subscribers := make(map[types.UID]bool, len(natssChannel.Spec.Subscribers))
+	for _, spec := range natssChannel.Spec.Subscribers {
+		subscribers[spec.UID] = true
+	}
+
+	timeouts := make(map[types.UID]time.Duration)
+	var invalid map[types.UID]error
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name || !subscribers[sub.UID] {
+			continue
+		}
+		value, ok := sub.Annotations[messaging.DeliveryTimeoutAnnotationKey]
+		if !ok {
+			continue
+		}
+		timeout, err := v1beta1.ParseDeliveryTimeout(value)
+		if err != nil {
+			recorder.Eventf(sub, corev1.EventTypeWarning, deliveryTimeoutInvalidReason,
+				"Invalid %s annotation %q, %v, the subscriber is delivered with the timeout of the dispatcher",
+				messaging.DeliveryTimeoutAnnotationKey, value, err)
+			if invalid == nil {
+				invalid = make(map[types.UID]error)
+			}
+			invalid[sub.UID] = err
+			continue
+		}
+		timeouts[sub.UID] = timeout
+	}
+	setter.SetDeliveryTimeouts(channelReference(natssChannel), timeouts)
+	return invalid
+}
+
+// reportInvalidDeliveryTimeouts marks as not ready the subscribers of natssChannel whose
+// delivery timeout is invalid, their events being still delivered.
+func reportInvalidDeliveryTimeouts(natssChannel *v1beta1.NatssChannel, invalid map[types.UID]error) {
+	for i, status := range natssChannel.Status.Subscribers {
+		if err, ok := invalid[status.UID]; ok {
+			natssChannel.Status.Subscribers[i].Ready = corev1.ConditionFalse
+			natssChannel.Status.Subscribers[i].Message = deliveryTimeoutInvalidReason + ": invalid " +
+				messaging.DeliveryTimeoutAnnotationKey + " annotation, " + err.Error() +
+				", its events are delivered with the timeout of the dispatcher"
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeDeliveryTimeoutSetter struct {
+	dispatcher.NatssDispatcher
+
+	timeouts map[types.UID]time.Duration
+}
+
+var _ dispatcher.DeliveryTimeoutSetter = (*fakeDeliveryTimeoutSetter)(nil)
+
+func (f *fakeDeliveryTimeoutSetter) SetDeliveryTimeouts(_ eventingchannels.ChannelReference, timeouts map[types.UID]time.Duration) {
+	f.timeouts = timeouts
+}
+
+func TestReconcileDeliveryTimeouts(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		wantTimeout time.Duration
+		wantInvalid bool
+	}{
+		"none": {},
+		"valid": {
+			annotations: map[string]string{messaging.DeliveryTimeoutAnnotationKey: "PT1M30S"},
+			wantTimeout: 90 * time.Second,
+		},
+		"malformed": {
+			annotations: map[string]string{messaging.DeliveryTimeoutAnnotationKey: "90s"},
+			wantInvalid: true,
+		},
+		"not positive": {
+			annotations: map[string]string{messaging.DeliveryTimeoutAnnotationKey: "PT0S"},
+			wantInvalid: true,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			sub := &messagingv1.Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   testNS,
+					Name:        "sub",
+					UID:         replaySubscriptionUID,
+					Annotations: tc.annotations,
+				},
+				Spec: messagingv1.SubscriptionSpec{
+					Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+				},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(sub); err != nil {
+				t.Fatalf("failed to add the subscription: %v", err)
+			}
+			setter := &fakeDeliveryTimeoutSetter{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+			r := &Reconciler{natssDispatcher: setter, subscriptionLister: messaginglisters.NewSubscriptionLister(indexer)}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+			invalid := r.reconcileDeliveryTimeouts(ctx, nc)
+			if got := setter.timeouts[replaySubscriptionUID]; got != tc.wantTimeout {
+				t.Errorf("timeout = %v, want %v", got, tc.wantTimeout)
+			}
+			if _, got := invalid[replaySubscriptionUID]; got != tc.wantInvalid {
+				t.Errorf("invalid = %t, want %t", got, tc.wantInvalid)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !tc.wantInvalid || !strings.HasPrefix(event, "Warning "+deliveryTimeoutInvalidReason) {
+					t.Errorf("event = %q, want invalid %t", event, tc.wantInvalid)
+				}
+			default:
+				if tc.wantInvalid {
+					t.Error("no event, want a warning")
+				}
+			}
+
+			nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{{UID: replaySubscriptionUID, Ready: corev1.ConditionTrue}}
+			reportInvalidDeliveryTimeouts(nc, invalid)
+			status := nc.Status.Subscribers[0]
+			if got := status.Ready == corev1.ConditionFalse; got != tc.wantInvalid {
+				t.Errorf("status = %+v, want not ready %t", status, tc.wantInvalid)
+			}
+			if tc.wantInvalid && !strings.Contains(status.Message, "ISO 8601") && !strings.Contains(status.Message, "not positive") {
+				t.Errorf("status message = %q, want the error of the annotation", status.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+const (
+	// durableNamesConfigMapName is the name of the ConfigMap recording the naming scheme of the
+	// durable of each subscription, keyed by its UID.
+	durableNamesConfigMapName = "natss-ch-dispatcher-durable-names"
+
+	// durableMigratingReason prefixes the message of the subscribers whose durable is migrated.
+	durableMigratingReason = "DurableMigrating"
+)
+
+// durableNamesFlushInterval is how often the durables named since are recorded.
+var durableNamesFlushInterval = 5 * time.Second
+
+// errDurableNamesNotLoaded is returned when the named durables are written before the records of
+// the previous runs were read, which would drop them.
+var errDurableNamesNotLoaded = errors.New("the durable names are not loaded")
+
+// durableNameRecord is the record of the naming of the durable of a subscription.
+type durableNameRecord struct {
+	// Channel is the namespace/name of the channel of the subscription.
+	Channel     string                         `json:"channel"`
+	Scheme      dispatcher.DurableNamingScheme `json:"scheme"`
+	MigratingTo dispatcher.DurableNamingScheme `json:"migratingTo,omitempty"`
+}
+
+// reconcileDurableNaming migrates the durables of the subscriptions of natssChannel to the scheme
+// of its natss.messaging.knative.dev/durable-naming-scheme annotation, the channel being
+// reconciled again once a durable is migrated.
+func (r *Reconciler) reconcileDurableNaming(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	namer, ok := r.natssDispatcher.(dispatcher.DurableNamer)
+	if !ok {
+		return
+	}
+	recorder := controller.GetEventRecorder(ctx)
+	channel := channelReference(natssChannel)
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	namer.WatchDurableMigrations(channel, func() { r.enqueueKey(key) })
+
+	value, ok := natssChannel.Annotations[messaging.DurableNamingAnnotationKey]
+	scheme := dispatcher.DurableNamingScheme(value)
+	switch {
+	case !ok:
+	case scheme.Validate() != nil:
+		recorder.Eventf(natssChannel, corev1.EventTypeWarning, "DurableNamingInvalid",
+			"Invalid %s annotation %q, expected %q or %q, the durables are not migrated",
+			messaging.DurableNamingAnnotationKey, value, dispatcher.DurableNamingV1, dispatcher.DurableNamingV2)
+		scheme = ""
+	case natssChannel.Spec.Distribution == v1beta1.DistributionWorkQueue:
+		recorder.Event(natssChannel, corev1.EventTypeWarning, "DurableNamingIgnored",
+			"The durables are not migrated, the members of a work queue share the durable of their queue group")
+		scheme = ""
+	}
+	namer.SetDurableMigration(channel, scheme)
+}
+
+// reportDurableMigrations shows the subscribers whose durable is being migrated in their status.
+func (r *Reconciler) reportDurableMigrations(natssChannel *v1beta1.NatssChannel) {
+	namer, ok := r.natssDispatcher.(dispatcher.DurableNamer)
+	if !ok {
+		return
+	}
+	channel := channelReference(natssChannel)
+	for i, status := range natssChannel.Status.Subscribers {
+		// The messages of the other reasons take precedence.
+		if status.Ready == corev1.ConditionTrue && status.Message == "" && namer.MigratingDurable(channel, status.UID) {
+			natssChannel.Status.Subscribers[i].Message = durableMigratingReason +
+				": the events stored before the migration are delivered from the old durable, " +
+				"removed once drained, and the next ones from the new durable"
+		}
+	}
+}
+
+// durableNamesStore records the naming of the durables in a ConfigMap, for the next runs to name
+// them the same whatever the configured scheme.
+type durableNamesStore struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	namer      dispatcher.DurableNamer
+
+	mu     sync.Mutex
+	loaded bool
+	// persisted is the data last written, nil when the ConfigMap does not exist.
+	persisted map[string]string
+}
+
+func newDurableNamesStore(kubeClient kubernetes.Interface, namespace string, namer dispatcher.DurableNamer) *durableNamesStore {
+	return &durableNamesStore{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		namer:      namer,
+	}
+}
+
+// registerDurableNames registers the hook recording the naming of the durables of namer.
+func registerDurableNames(ctx context.Context, lifecycle *dispatcher.Lifecycle, namer dispatcher.DurableNamer) error {
+	logger := logging.FromContext(ctx)
+	store := newDurableNamesStore(kubeclient.Get(ctx), stateNamespace(ctx), namer)
+
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+	// The records are loaded before the subscriptions start, and written a last time once they
+	// stopped.
+	hook := dispatcher.Hook{
+		Name:     "durable-names",
+		Priority: dispatcher.PrioritySubscriptions - 1,
+		Start: func(ctx context.Context) error {
+			// Naming the durables without the records would strand the events of those named
+			// with another scheme than the configured one.
+			if err := store.load(ctx); err != nil {
+				return fmt.Errorf("failed to load the durable names: %w", err)
+			}
+			namer.SetNamedDurablesRecorder(func(durables []dispatcher.NamedDurable) error {
+				return store.write(context.Background(), durables)
+			})
+			var runCtx context.Context
+			runCtx, cancel = context.WithCancel(logging.WithLogger(context.Background(), logger))
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				store.run(runCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			<-done
+			return store.flush(ctx)
+		},
+	}
+	return lifecycle.Register(hook)
+}
+
+// run records the durables named since every durableNamesFlushInterval until ctx is done.
+func (s *durableNamesStore) run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(durableNamesFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(ctx); err != nil {
+				logger.Errorw("Error writing the durable names", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// load reads the recorded durables into the namer. The invalid records are ignored.
+func (s *durableNamesStore) load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, durableNamesConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		s.loaded, s.persisted = true, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	durables := make([]dispatcher.NamedDurable, 0, len(cm.Data))
+	for uid, value := range cm.Data {
+		var record durableNameRecord
+		err := json.Unmarshal([]byte(value), &record)
+		channel, ok := parseChannelReference(record.Channel)
+		if err != nil || !ok || record.Scheme.Validate() != nil || (record.MigratingTo != "" && record.MigratingTo.Validate() != nil) {
+			logging.FromContext(ctx).Warnw("Ignoring an invalid durable name record", zap.String("subscription", uid), zap.String("value", value))
+			continue
+		}
+		durables = append(durables, dispatcher.NamedDurable{
+			Channel:      channel,
+			Subscription: types.UID(uid),
+			Scheme:       record.Scheme,
+			MigratingTo:  record.MigratingTo,
+		})
+	}
+	s.namer.LoadNamedDurables(durables)
+	s.loaded, s.persisted = true, cm.Data
+	return nil
+}
+
+// flush records the durables of the namer if they changed since they were last written.
+func (s *durableNamesStore) flush(ctx context.Context) error {
+	return s.write(ctx, s.namer.NamedDurables())
+}
+
+// write records durables if they changed since they were last written.
+func (s *durableNamesStore) write(ctx context.Context, durables []dispatcher.NamedDurable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		return errDurableNamesNotLoaded
+	}
+	data := make(map[string]string, len(durables))
+	for _, named := range durables {
+		value, err := json.Marshal(durableNameRecord{Channel: named.Channel.String(), Scheme: named.Scheme, MigratingTo: named.MigratingTo})
+		if err != nil {
+			return err
+		}
+		data[string(named.Subscription)] = string(value)
+	}
+	if reflect.DeepEqual(data, s.persisted) || (len(data) == 0 && s.persisted == nil) {
+		return nil
+	}
+
+	cms := s.kubeClient.CoreV1().ConfigMaps(s.namespace)
+	cm, err := cms.Get(ctx, durableNamesConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = cms.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: durableNamesConfigMapName, Namespace: s.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		cm = cm.DeepCopy()
+		cm.Data = data
+		_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	s.persisted = data
+	return nil
+}
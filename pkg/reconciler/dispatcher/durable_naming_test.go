@@ -0,0 +1,250 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeDurableNamer struct {
+	dispatcher.NatssDispatcher
+
+	loaded    []dispatcher.NamedDurable
+	named     []dispatcher.NamedDurable
+	migration dispatcher.DurableNamingScheme
+	migrating map[types.UID]bool
+	notify    func()
+}
+
+var _ dispatcher.DurableNamer = (*fakeDurableNamer)(nil)
+
+func (f *fakeDurableNamer) LoadNamedDurables(durables []dispatcher.NamedDurable) {
+	f.loaded = durables
+}
+
+func (f *fakeDurableNamer) NamedDurables() []dispatcher.NamedDurable {
+	return f.named
+}
+
+func (f *fakeDurableNamer) DurablesOf(channel eventingchannels.ChannelReference, subscription types.UID) []string {
+	for _, named := range f.named {
+		if named.Channel == channel && named.Subscription == subscription {
+			durables := []string{durableNameOf(named.Scheme, subscription)}
+			if named.MigratingTo != "" {
+				durables = append(durables, durableNameOf(named.MigratingTo, subscription))
+			}
+			return durables
+		}
+	}
+	return nil
+}
+
+func (f *fakeDurableNamer) SetDurableMigration(_ eventingchannels.ChannelReference, scheme dispatcher.DurableNamingScheme) {
+	f.migration = scheme
+}
+
+func (f *fakeDurableNamer) MigratingDurable(_ eventingchannels.ChannelReference, subscription types.UID) bool {
+	return f.migrating[subscription]
+}
+
+func (f *fakeDurableNamer) WatchDurableMigrations(_ eventingchannels.ChannelReference, notify func()) {
+	f.notify = notify
+}
+
+func (f *fakeDurableNamer) SetNamedDurablesRecorder(func([]dispatcher.NamedDurable) error) {}
+
+func durableNameOf(scheme dispatcher.DurableNamingScheme, subscription types.UID) string {
+	if scheme == dispatcher.DurableNamingV2 {
+		return "v2-" + string(subscription)
+	}
+	return string(subscription)
+}
+
+func TestReconcileDurableNaming(t *testing.T) {
+	tests := map[string]struct {
+		annotations   map[string]string
+		workQueue     bool
+		wantMigration dispatcher.DurableNamingScheme
+		wantEvent     string
+	}{
+		"not migrated": {},
+		"migrated": {
+			annotations:   map[string]string{messaging.DurableNamingAnnotationKey: "v2"},
+			wantMigration: dispatcher.DurableNamingV2,
+		},
+		"invalid": {
+			annotations: map[string]string{messaging.DurableNamingAnnotationKey: "v3"},
+			wantEvent:   "Warning DurableNamingInvalid",
+		},
+		"work queue": {
+			annotations: map[string]string{messaging.DurableNamingAnnotationKey: "v2"},
+			workQueue:   true,
+			wantEvent:   "Warning DurableNamingIgnored",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			namer := &fakeDurableNamer{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(), migration: "unset"}
+			r := &Reconciler{natssDispatcher: namer}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Annotations = tc.annotations
+			if tc.workQueue {
+				nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+			}
+			r.reconcileDurableNaming(ctx, nc)
+			if namer.migration != tc.wantMigration {
+				t.Errorf("migration = %q, want %q", namer.migration, tc.wantMigration)
+			}
+			if namer.notify == nil {
+				t.Error("the migrations are not watched")
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestReportDurableMigrations(t *testing.T) {
+	namer := &fakeDurableNamer{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		migrating:       map[types.UID]bool{"migrating": true, "failed": true},
+	}
+	r := &Reconciler{natssDispatcher: namer}
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{
+		{UID: "migrated", Ready: corev1.ConditionTrue},
+		{UID: "migrating", Ready: corev1.ConditionTrue},
+		{UID: "failed", Ready: corev1.ConditionFalse, Message: "failed"},
+	}
+	r.reportDurableMigrations(nc)
+
+	for _, status := range nc.Status.Subscribers {
+		migrating := strings.HasPrefix(status.Message, durableMigratingReason+": ")
+		if migrating != (status.UID == "migrating") {
+			t.Errorf("subscriber %s has the message %q", status.UID, status.Message)
+		}
+	}
+}
+
+func TestDurableNamesStore(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	channel := eventingchannels.ChannelReference{Namespace: testNS, Name: "channel"}
+	named := []dispatcher.NamedDurable{
+		{Channel: channel, Subscription: liveUID, Scheme: dispatcher.DurableNamingV1, MigratingTo: dispatcher.DurableNamingV2},
+		{Channel: channel, Subscription: orphanUID, Scheme: dispatcher.DurableNamingV2},
+	}
+	namer := &fakeDurableNamer{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+	store := newDurableNamesStore(kubeClient, hostMapNamespace, namer)
+
+	// Writing before the records of the previous runs are read would drop them.
+	if err := store.write(ctx, named); err != errDurableNamesNotLoaded {
+		t.Errorf("write() before load() = %v, want %v", err, errDurableNamesNotLoaded)
+	}
+	// Nothing is loaded nor written before the durables are named.
+	if err := store.load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if namer.loaded != nil {
+		t.Errorf("loaded %v without records", namer.loaded)
+	}
+	if err := store.flush(ctx); err != nil {
+		t.Fatalf("flush() = %v", err)
+	}
+	if got := countWrites(kubeClient); got != 0 {
+		t.Errorf("writes without durables = %d, want 0", got)
+	}
+
+	namer.named = named
+	if err := store.flush(ctx); err != nil {
+		t.Fatalf("flush() = %v", err)
+	}
+	// Unchanged durables are not written again.
+	if err := store.write(ctx, named); err != nil {
+		t.Fatalf("write() = %v", err)
+	}
+	if got := countWrites(kubeClient); got != 1 {
+		t.Errorf("writes = %d, want 1", got)
+	}
+
+	// The next run loads the records, ignoring the invalid ones.
+	cm, err := kubeClient.CoreV1().ConfigMaps(hostMapNamespace).Get(ctx, durableNamesConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(%s) = %v", durableNamesConfigMapName, err)
+	}
+	cm.Data["invalid"] = `{"channel":"ns/channel","scheme":"v3"}`
+	if _, err := kubeClient.CoreV1().ConfigMaps(hostMapNamespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update(%s) = %v", durableNamesConfigMapName, err)
+	}
+	next := &fakeDurableNamer{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+	if err := newDurableNamesStore(kubeClient, hostMapNamespace, next).load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if diff := cmp.Diff(named, sortedNamedDurables(next.loaded)); diff != "" {
+		t.Errorf("unexpected loaded durables (-want, +got): %s", diff)
+	}
+
+	// Once the subscriptions are gone, the records are emptied, telling the next run that the
+	// durables were named.
+	namer.named = nil
+	if err := store.flush(ctx); err != nil {
+		t.Fatalf("flush() = %v", err)
+	}
+	next = &fakeDurableNamer{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+	if err := newDurableNamesStore(kubeClient, hostMapNamespace, next).load(ctx); err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if next.loaded == nil || len(next.loaded) != 0 {
+		t.Errorf("loaded %#v, want no durable", next.loaded)
+	}
+}
+
+func sortedNamedDurables(durables []dispatcher.NamedDurable) []dispatcher.NamedDurable {
+	sorted := append([]dispatcher.NamedDurable(nil), durables...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Subscription < sorted[j].Subscription })
+	return sorted
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/probe"
+)
+
+// e2eProbeFailedReason is the reason of the events of the probe channel whose probe failed.
+const e2eProbeFailedReason = "E2EProbeFailed"
+
+var (
+	// e2eProbeLatencyM records the time taken by the events of the end to end probe to reach the
+	// probe subscriber.
+	e2eProbeLatencyM = stats.Float64(
+		"natss_e2e_probe_latency_ms",
+		"Latency of the events of the end to end probe, from the channel to the probe subscriber",
+		stats.UnitMilliseconds,
+	)
+
+	// e2eProbeSuccessM records 1 when the last end to end probe succeeded, and 0 when it failed.
+	e2eProbeSuccessM = stats.Int64(
+		"natss_e2e_probe_success",
+		"Whether the last end to end probe succeeded",
+		stats.UnitDimensionless,
+	)
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: e2eProbeLatencyM.Description(),
+			Measure:     e2eProbeLatencyM,
+			Aggregation: view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000),
+		},
+		&view.View{
+			Description: e2eProbeSuccessM.Description(),
+			Measure:     e2eProbeSuccessM,
+			Aggregation: view.LastValue(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// e2eProbe periodically sends an event through the probe channel maintained by the controller to
+// the probe subscriber of the dispatcher, and reports the failures on the probe channel.
+type e2eProbe struct {
+	prober dispatcher.E2EProber
+	lister listers.NatssChannelLister
+
+	mu     sync.Mutex
+	config config.Probe
+	// recorder is the event recorder of the reconciliations of the probe channel, nil until it
+	// is reconciled.
+	recorder record.EventRecorder
+}
+
+func newE2EProbe(prober dispatcher.E2EProber, lister listers.NatssChannelLister, c config.Probe) *e2eProbe {
+	return &e2eProbe{prober: prober, lister: lister, config: c}
+}
+
+// setConfig applies c from the next probe.
+func (p *e2eProbe) setConfig(c config.Probe) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = c
+}
+
+// observe records the event recorder of ctx, the context of a reconciliation of the probe channel.
+func (p *e2eProbe) observe(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recorder = controller.GetEventRecorder(ctx)
+}
+
+// register registers the hook probing once the channels informer is synced.
+func (p *e2eProbe) register(lifecycle *dispatcher.Lifecycle, hasSynced cache.InformerSynced) error {
+	// The probe sends its events to the receiver, it stops before it.
+	return lifecycle.Register(lifecycle.RunHook("e2e-probe", dispatcher.PriorityReceiver+1, func(ctx context.Context) error {
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+			return nil
+		}
+		p.run(ctx)
+		return nil
+	}))
+}
+
+// run probes every interval while a namespace is configured, until ctx is done.
+func (p *e2eProbe) run(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		c := p.config
+		p.mu.Unlock()
+		if c.Namespace != "" {
+			_ = p.probe(ctx, c)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// probe sends an event through the probe channel of c, recording the outcome.
+func (p *e2eProbe) probe(ctx context.Context, c config.Probe) error {
+	logger := logging.FromContext(ctx)
+	nc, err := p.lister.NatssChannels(c.Namespace).Get(probe.ChannelName)
+	var latency time.Duration
+	switch {
+	case err != nil:
+		err = fmt.Errorf("the probe channel %s/%s cannot be read: %w", c.Namespace, probe.ChannelName, err)
+	case !nc.Status.IsReady() || nc.Status.Address == nil || nc.Status.Address.URL == nil:
+		err = errors.New("the probe channel is not ready")
+	default:
+		probeCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+		latency, err = p.prober.ProbeE2E(probeCtx, nc.Status.Address.URL.URL())
+		cancel()
+	}
+	if err != nil {
+		metrics.Record(ctx, e2eProbeSuccessM.M(0))
+		logger.Warnw("End to end probe failed", zap.Error(err))
+		p.mu.Lock()
+		recorder := p.recorder
+		p.mu.Unlock()
+		if nc != nil && recorder != nil {
+			recorder.Eventf(nc, corev1.EventTypeWarning, e2eProbeFailedReason, "End to end probe failed: %v", err)
+		}
+		return err
+	}
+	metrics.Record(ctx, e2eProbeSuccessM.M(1))
+	metrics.Record(ctx, e2eProbeLatencyM.M(float64(latency)/float64(time.Millisecond)))
+	logger.Debugw("End to end probe succeeded", zap.Duration("latency", latency))
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/probe"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeE2EProber struct {
+	target *url.URL
+	err    error
+}
+
+func (f *fakeE2EProber) ProbeE2E(_ context.Context, target *url.URL) (time.Duration, error) {
+	f.target = target
+	return 5 * time.Millisecond, f.err
+}
+
+func TestE2EProbe(t *testing.T) {
+	ready := func(ns string) runtime.Object {
+		return reconciletesting.NewNatssChannel(probe.ChannelName, ns,
+			reconciletesting.WithNatssInitChannelConditions,
+			reconciletesting.WithNatssChannelDeploymentReady(),
+			reconciletesting.WithNatssChannelServiceReady(),
+			reconciletesting.WithNatssChannelEndpointsReady(),
+			reconciletesting.WithNatssChannelChannelServiceReady(),
+			reconciletesting.WithNatssChannelNatssConnectionReady(),
+			reconciletesting.WithNatssChannelAddress("natss-e2e-probe-kn-channel."+ns+".svc.cluster.local"))
+	}
+	tests := map[string]struct {
+		objects   []runtime.Object
+		proberErr error
+		wantErr   string
+		wantEvent bool
+	}{
+		"succeeded": {
+			objects: []runtime.Object{ready(testNS)},
+		},
+		"no probe channel": {
+			objects: []runtime.Object{ready("other")},
+			wantErr: "cannot be read",
+		},
+		"probe channel not ready": {
+			objects:   []runtime.Object{reconciletesting.NewNatssChannel(probe.ChannelName, testNS)},
+			wantErr:   "not ready",
+			wantEvent: true,
+		},
+		"event lost": {
+			objects:   []runtime.Object{ready(testNS)},
+			proberErr: errors.New("the probe event did not reach the probe subscriber"),
+			wantErr:   "did not reach",
+			wantEvent: true,
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			listers := reconciletesting.NewListers(tc.objects)
+			prober := &fakeE2EProber{err: tc.proberErr}
+			c := config.Probe{Namespace: testNS, Interval: time.Minute, Timeout: time.Second}
+			p := newE2EProbe(prober, listers.GetNatssChannelLister(), c)
+			recorder := record.NewFakeRecorder(10)
+			p.observe(controller.WithEventRecorder(context.Background(), recorder))
+
+			err := p.probe(context.Background(), c)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("probe() = %v", err)
+				}
+				if want := "http://natss-e2e-probe-kn-channel." + testNS + ".svc.cluster.local"; prober.target.String() != want {
+					t.Errorf("probed %s, want %s", prober.target, want)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("probe() = %v, want an error containing %q", err, tc.wantErr)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if !tc.wantEvent {
+					t.Errorf("unexpected event %q", event)
+				} else if !strings.Contains(event, e2eProbeFailedReason) {
+					t.Errorf("event = %q, want %s", event, e2eProbeFailedReason)
+				}
+			default:
+				if tc.wantEvent {
+					t.Error("no event recorded")
+				}
+			}
+		})
+	}
+}
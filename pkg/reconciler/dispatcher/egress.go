@@ -0,0 +1,62 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// egressMinShare returns the guaranteed share of the egress limits set by the annotations of a
+// NatssChannel, zero when they set none.
+func egressMinShare(annotations map[string]string) (float64, error) {
+	raw, ok := annotations[messaging.EgressMinShareAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+	share, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the %q annotation: %w", messaging.EgressMinShareAnnotationKey, err)
+	}
+	if share < 0 || share > 1 {
+		return 0, fmt.Errorf("the %q annotation %v is not between 0 and 1", messaging.EgressMinShareAnnotationKey, share)
+	}
+	return share, nil
+}
+
+// reconcileEgress guarantees natssChannel the share of the egress limits of the dispatcher set by
+// its annotation. An invalid annotation guarantees none.
+func (r *Reconciler) reconcileEgress(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.EgressShareSetter)
+	if !ok {
+		return
+	}
+	share, err := egressMinShare(natssChannel.Annotations)
+	if err != nil {
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, "EgressMinShareInvalid",
+			"Ignoring the invalid egress share of the channel: %v", err)
+	}
+	setter.SetEgressMinShare(channelReference(natssChannel), share)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeEgressShareSetter struct {
+	dispatcher.NatssDispatcher
+
+	shares map[eventingchannels.ChannelReference]float64
+}
+
+func (f *fakeEgressShareSetter) SetEgressMinShare(channel eventingchannels.ChannelReference, share float64) {
+	f.shares[channel] = share
+}
+
+func TestReconcileEgress(t *testing.T) {
+	testCases := map[string]struct {
+		annotations map[string]string
+		wantShare   float64
+		wantEvent   string
+	}{
+		"no share": {},
+		"share": {
+			annotations: map[string]string{messaging.EgressMinShareAnnotationKey: "0.25"},
+			wantShare:   0.25,
+		},
+		"malformed": {
+			annotations: map[string]string{messaging.EgressMinShareAnnotationKey: "25%"},
+			wantEvent:   "Warning EgressMinShareInvalid",
+		},
+		"above one": {
+			annotations: map[string]string{messaging.EgressMinShareAnnotationKey: "1.5"},
+			wantEvent:   "Warning EgressMinShareInvalid",
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			setter := &fakeEgressShareSetter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				shares:          map[eventingchannels.ChannelReference]float64{},
+			}
+			r := &Reconciler{natssDispatcher: setter}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Annotations = tc.annotations
+			r.reconcileEgress(ctx, nc)
+
+			if got, ok := setter.shares[channelReference(nc)]; !ok || got != tc.wantShare {
+				t.Errorf("share = %v, want %v", got, tc.wantShare)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
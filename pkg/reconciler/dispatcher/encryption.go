@@ -0,0 +1,71 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// errEncryptionUnsupported is returned for the channels asking for an encryption the dispatcher
+// transport does not support.
+var errEncryptionUnsupported = errors.New("encryption is not supported by the dispatcher transport")
+
+// reconcileEncryption hands the keys of natssChannel to the dispatcher. When they cannot be
+// loaded, the dispatcher gets keys failing every operation, so that the events of the channel
+// are never published unencrypted.
+func (r *Reconciler) reconcileEncryption(ctx context.Context, natssChannel *v1beta1.NatssChannel) error {
+	encryption := natssChannel.Spec.Encryption
+	setter, ok := r.natssDispatcher.(dispatcher.EncryptionKeySetter)
+	if !ok {
+		if encryption != nil {
+			return errEncryptionUnsupported
+		}
+		return nil
+	}
+	if encryption == nil {
+		setter.SetEncryptionKeys(channelReference(natssChannel), nil)
+		return nil
+	}
+
+	keys, err := r.loadKeyring(ctx, natssChannel.Namespace, encryption)
+	if err != nil {
+		setter.SetEncryptionKeys(channelReference(natssChannel), dispatcher.NewUnavailableKeyring(err))
+		return err
+	}
+	setter.SetEncryptionKeys(channelReference(natssChannel), keys)
+	return nil
+}
+
+// loadKeyring reads the keys of the Secret referenced by encryption.
+func (r *Reconciler) loadKeyring(ctx context.Context, namespace string, encryption *v1beta1.NatssChannelEncryption) (*dispatcher.Keyring, error) {
+	secret, err := r.kubeClientSet.CoreV1().Secrets(namespace).Get(ctx, encryption.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the encryption keys: %w", err)
+	}
+	keys, err := dispatcher.NewKeyring(secret.Data, encryption.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keys in secret %q: %w", encryption.SecretRef.Name, err)
+	}
+	return keys, nil
+}
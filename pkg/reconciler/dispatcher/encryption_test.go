@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeKeySetter struct {
+	dispatcher.NatssDispatcher
+
+	keys map[eventingchannels.ChannelReference]*dispatcher.Keyring
+}
+
+func (s *fakeKeySetter) SetEncryptionKeys(channel eventingchannels.ChannelReference, keys *dispatcher.Keyring) {
+	if keys == nil {
+		delete(s.keys, channel)
+		return
+	}
+	s.keys[channel] = keys
+}
+
+func TestReconcileEncryption(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNS, Name: "keys"},
+		Data: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+
+	testCases := map[string]struct {
+		encryption  *v1beta1.NatssChannelEncryption
+		unsupported bool
+		wantErr     bool
+		wantKeys    bool
+		wantWorking bool
+	}{
+		"no encryption": {},
+		"encryption": {
+			encryption:  &v1beta1.NatssChannelEncryption{SecretRef: corev1.LocalObjectReference{Name: "keys"}, KeyID: "k2"},
+			wantKeys:    true,
+			wantWorking: true,
+		},
+		"missing secret": {
+			encryption: &v1beta1.NatssChannelEncryption{SecretRef: corev1.LocalObjectReference{Name: "missing"}, KeyID: "k1"},
+			wantErr:    true,
+			wantKeys:   true,
+		},
+		"missing key": {
+			encryption: &v1beta1.NatssChannelEncryption{SecretRef: corev1.LocalObjectReference{Name: "keys"}, KeyID: "k3"},
+			wantErr:    true,
+			wantKeys:   true,
+		},
+		"unsupported": {
+			encryption:  &v1beta1.NatssChannelEncryption{SecretRef: corev1.LocalObjectReference{Name: "keys"}, KeyID: "k1"},
+			unsupported: true,
+			wantErr:     true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			setter := &fakeKeySetter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				keys:            make(map[eventingchannels.ChannelReference]*dispatcher.Keyring),
+			}
+			r := &Reconciler{natssDispatcher: setter, kubeClientSet: fake.NewSimpleClientset(secret)}
+			if tc.unsupported {
+				r.natssDispatcher = setter.NatssDispatcher
+			}
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Spec.Encryption = tc.encryption
+			// Keys left by a previous configuration are replaced.
+			setter.keys[channelReference(nc)] = dispatcher.NewUnavailableKeyring(errors.New("stale keys"))
+
+			err := r.reconcileEncryption(context.Background(), nc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("reconcileEncryption() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.unsupported {
+				return
+			}
+			keys, ok := setter.keys[channelReference(nc)]
+			if ok != tc.wantKeys {
+				t.Fatalf("keys set = %v, want %v", ok, tc.wantKeys)
+			}
+			if !ok {
+				return
+			}
+			// Failing to load the keys must not let the events be published unencrypted.
+			if _, err := keys.Encrypt([]byte("data")); (err == nil) != tc.wantWorking {
+				t.Errorf("Encrypt() = %v, want working keys %v", err, tc.wantWorking)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// endpointUnreachableReason prefixes the message of the subscribers whose endpoint is unreachable.
+const endpointUnreachableReason = "EndpointUnreachable"
+
+// watchEndpoints makes natssChannel reconciled again when the endpoint of one of its subscribers
+// becomes unreachable or reachable again.
+func (r *Reconciler) watchEndpoints(natssChannel *v1beta1.NatssChannel) {
+	validator, ok := r.natssDispatcher.(dispatcher.EndpointValidator)
+	if !ok {
+		return
+	}
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	validator.WatchEndpoints(channelReference(natssChannel), func() { r.enqueueKey(key) })
+}
+
+// reportUnreachableEndpoints marks the subscribers whose endpoint is unreachable as not ready,
+// their subscription still receiving the events to deliver once it is reachable. The subscribers
+// not ready for another reason keep it.
+func (r *Reconciler) reportUnreachableEndpoints(natssChannel *v1beta1.NatssChannel) {
+	validator, ok := r.natssDispatcher.(dispatcher.EndpointValidator)
+	if !ok {
+		return
+	}
+	for i, status := range natssChannel.Status.Subscribers {
+		if status.Ready != corev1.ConditionTrue {
+			continue
+		}
+		if err := validator.UnreachableEndpoint(status.UID); err != nil {
+			natssChannel.Status.Subscribers[i].Ready = corev1.ConditionFalse
+			natssChannel.Status.Subscribers[i].Message = endpointUnreachableReason + ": " + err.Error()
+		}
+	}
+}
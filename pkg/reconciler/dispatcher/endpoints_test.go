@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeEndpointValidator struct {
+	dispatcher.NatssDispatcher
+
+	watched     map[eventingchannels.ChannelReference]func()
+	unreachable map[types.UID]error
+}
+
+func (f *fakeEndpointValidator) WatchEndpoints(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(f.watched, channel)
+		return
+	}
+	f.watched[channel] = notify
+}
+
+func (f *fakeEndpointValidator) UnreachableEndpoint(subscription types.UID) error {
+	return f.unreachable[subscription]
+}
+
+func TestReportUnreachableEndpoints(t *testing.T) {
+	validator := &fakeEndpointValidator{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		watched:         make(map[eventingchannels.ChannelReference]func()),
+		unreachable: map[types.UID]error{
+			"uid-typo":   errors.New("lookup subscriber.defualt.svc.cluster.local: no such host"),
+			"uid-failed": errors.New("dial tcp 10.0.0.1:80: connect: connection refused"),
+		},
+	}
+	r := &Reconciler{natssDispatcher: validator}
+
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs("uid-reachable", "uid-typo", "uid-failed"))
+	r.watchEndpoints(nc)
+	if _, ok := validator.watched[channelReference(nc)]; !ok {
+		t.Error("the reachability of the endpoints of the channel is not watched")
+	}
+	nc.Status.SubscribableStatus = r.createSubscribableStatus(nc.Spec.Subscribers, map[eventingduckv1.SubscriberSpec]error{
+		nc.Spec.Subscribers[2]: errors.New("subscription failed"),
+	})
+	r.reportUnreachableEndpoints(nc)
+
+	want := []eventingduckv1.SubscriberStatus{{
+		UID:   "uid-reachable",
+		Ready: corev1.ConditionTrue,
+	}, {
+		UID:     "uid-typo",
+		Ready:   corev1.ConditionFalse,
+		Message: "EndpointUnreachable: lookup subscriber.defualt.svc.cluster.local: no such host",
+	}, {
+		// The failure of the subscription is reported first.
+		UID:     "uid-failed",
+		Ready:   corev1.ConditionFalse,
+		Message: "subscription failed",
+	}}
+	if diff := cmp.Diff(want, nc.Status.Subscribers); diff != "" {
+		t.Errorf("unexpected subscribers status (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// ephemeralReason prefixes the message of the subscribers subscribed without a durable.
+const ephemeralReason = "Ephemeral"
+
+// isNatssChannelEphemeral tells whether obj is a Subscription to a NatssChannel with the
+// natss.messaging.knative.dev/durable annotation.
+func isNatssChannelEphemeral(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.DurableAnnotationKey]
+	return ok
+}
+
+// reconcileEphemeral makes the dispatcher subscribe without a durable the subscribers of
+// natssChannel whose Subscription has the natss.messaging.knative.dev/durable annotation set to
+// "false", and records the change of semantics on the Subscriptions switching to or from it.
+func (r *Reconciler) reconcileEphemeral(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.EphemeralSetter)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	subscribers := make(map[types.UID]bool, len(natssChannel.Spec.Subscribers))
+	for _, spec := range natssChannel.Spec.Subscribers {
+		subscribers[spec.UID] = true
+	}
+
+	channel := channelReference(natssChannel)
+	ephemeral := make(map[types.UID]bool)
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name || !subscribers[sub.UID] {
+			continue
+		}
+		// The Subscriptions whose annotation was removed are durable again.
+		value, ok := sub.Annotations[messaging.DurableAnnotationKey]
+		switch {
+		case value == "false" && natssChannel.Spec.Distribution == v1beta1.DistributionWorkQueue:
+			recorder.Event(sub, corev1.EventTypeWarning, "SubscriptionEphemeralIgnored",
+				"The subscription is durable, the members of a work queue share its durable")
+		case value == "false":
+			ephemeral[sub.UID] = true
+			if !setter.Ephemeral(channel, sub.UID) {
+				recorder.Event(sub, corev1.EventTypeWarning, "SubscriptionEphemeral",
+					"Subscribing without a durable: the events published while the dispatcher does not hold the subscription are not delivered, "+
+						"the failed deliveries are not redelivered, and the durable of the subscription is removed")
+			}
+		case ok && value != "true":
+			recorder.Eventf(sub, corev1.EventTypeWarning, "DurableInvalid",
+				"Invalid %s annotation %q, expected \"true\" or \"false\", the subscription is durable", messaging.DurableAnnotationKey, value)
+			fallthrough
+		default:
+			if setter.Ephemeral(channel, sub.UID) {
+				recorder.Event(sub, corev1.EventTypeNormal, "SubscriptionDurable", "Subscribing with a durable, from the events published from now on")
+			}
+		}
+	}
+	setter.SetEphemeral(channel, ephemeral)
+}
+
+// reportEphemeral shows the subscribers subscribed without a durable in their status.
+func (r *Reconciler) reportEphemeral(natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.EphemeralSetter)
+	if !ok {
+		return
+	}
+	channel := channelReference(natssChannel)
+	for i, status := range natssChannel.Status.Subscribers {
+		if status.Ready == corev1.ConditionTrue && setter.Ephemeral(channel, status.UID) {
+			natssChannel.Status.Subscribers[i].Message = ephemeralReason +
+				": subscribed without a durable, the events published while the dispatcher does not hold the subscription are not delivered"
+		}
+	}
+}
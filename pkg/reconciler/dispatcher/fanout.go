@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// fanoutLimits holds the fan-out limits of the channels, which follow the config-natss ConfigMap.
+type fanoutLimits struct {
+	mu     sync.Mutex
+	limits config.FanoutLimits
+}
+
+// set replaces the limits, returning whether they changed.
+func (f *fanoutLimits) set(limits config.FanoutLimits) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changed := f.limits != limits
+	f.limits = limits
+	return changed
+}
+
+// get returns the limits, none when f is nil.
+func (f *fanoutLimits) get() config.FanoutLimits {
+	if f == nil {
+		return config.FanoutLimits{}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.limits
+}
+
+// reconcileFanout applies to natssChannel the fan-out limits of config-natss, overridden by its
+// annotations, and reports the subscribers above them. The invalid annotations are ignored.
+func (r *Reconciler) reconcileFanout(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.FanoutLimitSetter)
+	if !ok {
+		natssChannel.Status.ClearFanoutAboveLimitCondition()
+		return
+	}
+	global := r.fanoutLimits.get()
+	limits, err := global.WithAnnotations(natssChannel.Annotations)
+	if err != nil {
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, "FanoutLimitsInvalid",
+			"Ignoring the invalid fan-out limits of the channel: %v", err)
+		limits = global
+	}
+	setter.SetFanoutLimit(channelReference(natssChannel), limits.Hard)
+
+	// A subscriber listed twice is subscribed once.
+	uids := sets.NewString()
+	for _, sub := range natssChannel.Spec.Subscribers {
+		uids.Insert(string(sub.UID))
+	}
+	switch subscribers := uids.Len(); {
+	case limits.Hard > 0 && subscribers > limits.Hard:
+		natssChannel.Status.MarkFanoutLimitExceeded(subscribers, limits.Hard)
+	case limits.Soft > 0 && subscribers > limits.Soft:
+		natssChannel.Status.MarkFanoutAboveSoftLimit(subscribers, limits.Soft)
+	default:
+		natssChannel.Status.ClearFanoutAboveLimitCondition()
+	}
+}
+
+// isFanoutLimitExceeded tells whether err refused a subscriber because of the fan-out limit of its
+// channel, which is reported by the FanoutAboveLimit condition.
+func isFanoutLimitExceeded(err error) bool {
+	var exceeded *dispatcher.FanoutLimitExceededError
+	return errors.As(err, &exceeded)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeFanoutLimitSetter struct {
+	dispatcher.NatssDispatcher
+
+	limits map[eventingchannels.ChannelReference]int
+}
+
+func (f *fakeFanoutLimitSetter) SetFanoutLimit(channel eventingchannels.ChannelReference, limit int) {
+	f.limits[channel] = limit
+}
+
+func TestReconcileFanout(t *testing.T) {
+	testCases := map[string]struct {
+		limits      config.FanoutLimits
+		annotations map[string]string
+		unsupported bool
+		wantLimit   int
+		wantReason  string
+		wantEvent   string
+	}{
+		"no limit": {},
+		"below the limits": {
+			limits:    config.FanoutLimits{Soft: 3, Hard: 4},
+			wantLimit: 4,
+		},
+		"above the soft limit": {
+			limits:     config.FanoutLimits{Soft: 2, Hard: 4},
+			wantLimit:  4,
+			wantReason: "FanoutSoftLimitExceeded",
+		},
+		"above the hard limit": {
+			limits:     config.FanoutLimits{Soft: 1, Hard: 2},
+			wantLimit:  2,
+			wantReason: "FanoutLimitExceeded",
+		},
+		"annotations": {
+			limits:      config.FanoutLimits{Soft: 1, Hard: 2},
+			annotations: map[string]string{messaging.FanoutSoftLimitAnnotationKey: "5", messaging.FanoutHardLimitAnnotationKey: "10"},
+			wantLimit:   10,
+		},
+		"invalid annotations": {
+			limits:      config.FanoutLimits{Soft: 5, Hard: 10},
+			annotations: map[string]string{messaging.FanoutHardLimitAnnotationKey: "many"},
+			wantLimit:   10,
+			wantEvent:   "Warning FanoutLimitsInvalid",
+		},
+		"unsupported": {
+			limits:      config.FanoutLimits{Soft: 1, Hard: 2},
+			unsupported: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			setter := &fakeFanoutLimitSetter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				limits:          make(map[eventingchannels.ChannelReference]int),
+			}
+			r := &Reconciler{natssDispatcher: setter, fanoutLimits: &fanoutLimits{}}
+			if tc.unsupported {
+				r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+			}
+			r.fanoutLimits.set(tc.limits)
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			// The channel lists one of its three subscribers twice.
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs("a", "b", "a", "c"))
+			nc.Annotations = tc.annotations
+			nc.Status.MarkFanoutAboveSoftLimit(10, 1)
+			r.reconcileFanout(ctx, nc)
+
+			if got := setter.limits[channelReference(nc)]; got != tc.wantLimit {
+				t.Errorf("limit = %d, want %d", got, tc.wantLimit)
+			}
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionFanoutAboveLimit)
+			switch {
+			case tc.wantReason == "" && cond != nil:
+				t.Errorf("condition = %+v, want none", cond)
+			case tc.wantReason != "" && (cond == nil || cond.Reason != tc.wantReason || !strings.Contains(cond.Message, "3 subscribers")):
+				t.Errorf("condition = %+v, want the reason %q for 3 subscribers", cond, tc.wantReason)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestIsFanoutLimitExceeded(t *testing.T) {
+	err := fmt.Errorf("failed to subscribe: %w", &dispatcher.FanoutLimitExceededError{Subscribers: 3, Limit: 2})
+	if !isFanoutLimitExceeded(err) {
+		t.Errorf("isFanoutLimitExceeded(%v) = false, want true", err)
+	}
+	if err := fmt.Errorf("failed to subscribe"); isFanoutLimitExceeded(err) {
+		t.Errorf("isFanoutLimitExceeded(%v) = true, want false", err)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// finalizeBatchSize is the maximum number of channels finalized at once.
+const finalizeBatchSize = 100
+
+// finalizeSubscriptions removes the subscriptions of natssChannel and their durables. When the
+// dispatcher finalizes channels in batches, the other channels of the namespace pending deletion
+// are finalized along with it, such as when the namespace is deleted, and their own finalization
+// only has to remove their finalizer.
+func (r *Reconciler) finalizeSubscriptions(ctx context.Context, natssChannel *v1beta1.NatssChannel) error {
+	finalizer, ok := r.natssDispatcher.(dispatcher.BatchFinalizer)
+	if !ok || r.natsschannelLister == nil {
+		_, err := r.natssDispatcher.UpdateSubscriptions(ctx, toChannel(natssChannel), true)
+		return err
+	}
+	if _, ok := r.finalized.Load(natssChannel.UID); ok {
+		r.finalized.Delete(natssChannel.UID)
+		return nil
+	}
+
+	batch := []*v1beta1.NatssChannel{natssChannel}
+	ncs, err := r.natsschannelLister.NatssChannels(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		// The channel is finalized alone.
+		logging.FromContext(ctx).Errorw("Error listing the channels pending deletion", zap.Error(err))
+	}
+	for _, nc := range ncs {
+		if len(batch) == finalizeBatchSize {
+			break
+		}
+		if _, ok := r.finalized.Load(nc.UID); ok || nc.UID == natssChannel.UID || nc.DeletionTimestamp == nil {
+			continue
+		}
+		batch = append(batch, nc)
+	}
+	channels := make([]*messagingv1.Channel, 0, len(batch))
+	for _, nc := range batch {
+		channels = append(channels, toChannel(nc))
+	}
+	failed := finalizer.FinalizeChannels(ctx, channels)
+	for _, nc := range batch[1:] {
+		if _, ok := failed[channelReference(nc)]; !ok {
+			r.finalized.Store(nc.UID, true)
+		}
+	}
+	return failed[channelReference(natssChannel)]
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeBatchFinalizer struct {
+	dispatcher.NatssDispatcher
+
+	batches [][]string
+	failed  map[eventingchannels.ChannelReference]error
+}
+
+var _ dispatcher.BatchFinalizer = (*fakeBatchFinalizer)(nil)
+
+func (f *fakeBatchFinalizer) FinalizeChannels(_ context.Context, channels []*messagingv1.Channel) map[eventingchannels.ChannelReference]error {
+	var batch []string
+	for _, c := range channels {
+		batch = append(batch, c.Name)
+	}
+	f.batches = append(f.batches, batch)
+	return f.failed
+}
+
+func TestFinalizeSubscriptions(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	var deleted []*v1beta1.NatssChannel
+	for i := 0; i < 3; i++ {
+		nc := reconciletesting.NewNatssChannel(fmt.Sprint("deleted-", i), testNS, reconciletesting.WithNatssChannelDeleted)
+		nc.UID = types.UID(nc.Name)
+		deleted = append(deleted, nc)
+	}
+	kept := reconciletesting.NewNatssChannel("kept", testNS)
+	other := reconciletesting.NewNatssChannel("other", "other-ns", reconciletesting.WithNatssChannelDeleted)
+	for _, nc := range append(deleted, kept, other) {
+		if err := indexer.Add(nc); err != nil {
+			t.Fatalf("failed to add the channel: %v", err)
+		}
+	}
+	finalizer := &fakeBatchFinalizer{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		failed:          map[eventingchannels.ChannelReference]error{channelReference(deleted[2]): errors.New("timeout")},
+	}
+	r := &Reconciler{natssDispatcher: finalizer, natsschannelLister: listers.NewNatssChannelLister(indexer)}
+
+	// The channels of the namespace pending deletion are finalized along with the first one.
+	if err := r.finalizeSubscriptions(context.Background(), deleted[0]); err != nil {
+		t.Errorf("finalizeSubscriptions() = %v", err)
+	}
+	if len(finalizer.batches) != 1 || len(finalizer.batches[0]) != 3 || finalizer.batches[0][0] != deleted[0].Name {
+		t.Fatalf("finalized %v, want the channels pending deletion of %s", finalizer.batches, testNS)
+	}
+
+	// The finalization of a channel finalized along with another one has nothing left to do.
+	if err := r.finalizeSubscriptions(context.Background(), deleted[1]); err != nil {
+		t.Errorf("finalizeSubscriptions() = %v", err)
+	}
+	if len(finalizer.batches) != 1 {
+		t.Errorf("finalized %v, want the channel not to be finalized again", finalizer.batches)
+	}
+
+	// The channel which failed is finalized again.
+	if err := r.finalizeSubscriptions(context.Background(), deleted[2]); err == nil {
+		t.Error("finalizeSubscriptions() = nil, want the error of the channel")
+	}
+	if len(finalizer.batches) != 2 || finalizer.batches[1][0] != deleted[2].Name {
+		t.Errorf("finalized %v, want the channel to be finalized again", finalizer.batches)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	pkgreconciler "knative.dev/pkg/reconciler"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	clientset "knative.dev/eventing-natss/pkg/client/clientset/versioned"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+// finalizerName is the finalizer of the NatssChannels reconciled by the dispatcher.
+const finalizerName = v1beta1.DispatcherFinalizerName
+
+// leaderAwareReconciler is the generated reconciler of the NatssChannels.
+type leaderAwareReconciler interface {
+	controller.Reconciler
+	pkgreconciler.LeaderAware
+	IsLeaderFor(key types.NamespacedName) bool
+}
+
+// finalizerMigration replaces the legacy finalizers of the NatssChannels with finalizerName before
+// the generated reconciler, which only knows the latter, sees them. Otherwise the channels
+// carrying only a legacy finalizer could never be deleted.
+type finalizerMigration struct {
+	leaderAwareReconciler
+
+	lister listers.NatssChannelLister
+	client clientset.Interface
+}
+
+// Reconcile implements controller.Reconciler.
+func (m *finalizerMigration) Reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return m.leaderAwareReconciler.Reconcile(ctx, key)
+	}
+	nc, err := m.lister.NatssChannels(namespace).Get(name)
+	if err != nil || !hasLegacyFinalizer(nc) || !m.IsLeaderFor(types.NamespacedName{Namespace: namespace, Name: name}) {
+		return m.leaderAwareReconciler.Reconcile(ctx, key)
+	}
+
+	if nc.DeletionTimestamp.IsZero() {
+		// The channel is reconciled again once patched.
+		return m.patchFinalizers(ctx, nc, migratedFinalizers(nc.Finalizers, true))
+	}
+	// No finalizer can be added to a channel being deleted, whose legacy finalizers are removed
+	// once it is finalized instead.
+	if err := m.leaderAwareReconciler.Reconcile(ctx, key); err != nil {
+		return err
+	}
+	return m.patchFinalizers(ctx, nc, migratedFinalizers(nc.Finalizers, false))
+}
+
+// patchFinalizers sets the finalizers of nc in a single patch, failing when nc changed since.
+func (m *finalizerMigration) patchFinalizers(ctx context.Context, nc *v1beta1.NatssChannel, finalizers []string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": nc.ResourceVersion,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := m.client.MessagingV1beta1().NatssChannels(nc.Namespace).Patch(ctx, nc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Infow("Migrated the legacy finalizers", zap.String("channel", nc.Namespace+"/"+nc.Name), zap.Strings("finalizers", finalizers))
+	return nil
+}
+
+func hasLegacyFinalizer(nc *v1beta1.NatssChannel) bool {
+	for _, f := range nc.Finalizers {
+		if v1beta1.IsLegacyDispatcherFinalizer(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// migratedFinalizers returns finalizers without the legacy ones, ensuring finalizerName when
+// ensure is true, and without it otherwise.
+func migratedFinalizers(finalizers []string, ensure bool) []string {
+	migrated := make([]string, 0, len(finalizers)+1)
+	for _, f := range finalizers {
+		if !v1beta1.IsLegacyDispatcherFinalizer(f) && f != finalizerName {
+			migrated = append(migrated, f)
+		}
+	}
+	if ensure {
+		migrated = append(migrated, finalizerName)
+	}
+	return migrated
+}
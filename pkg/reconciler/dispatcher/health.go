@@ -0,0 +1,169 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/logging"
+
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+const (
+	// healthzPath is the path of the liveness probe of the dispatcher.
+	healthzPath = "/healthz"
+	// readyzPath is the path of the readiness probe of the dispatcher.
+	readyzPath = "/readyz"
+)
+
+// initialSyncPoll is how often the channels existing when the dispatcher started are checked for
+// being reconciled.
+var initialSyncPoll = 100 * time.Millisecond
+
+// healthHandler serves the liveness and readiness probes of the dispatcher.
+type healthHandler struct {
+	reporter dispatcher.HealthReporter
+	// livenessThreshold is how long the connection to NATSS may be down before the liveness probe
+	// fails, zero never failing it.
+	livenessThreshold time.Duration
+	now               func() time.Time
+}
+
+func newHealthHandler(reporter dispatcher.HealthReporter, livenessThreshold time.Duration) *healthHandler {
+	return &healthHandler{reporter: reporter, livenessThreshold: livenessThreshold, now: time.Now}
+}
+
+// ready fails until the connection to NATSS is made and the channels existing when the dispatcher
+// started are reconciled, while the connection is down, and once the dispatcher stops. The stale
+// host to channel map stands for the channels until they are reconciled, and the offline buffer
+// for the connection, leaving a connection down for long to the liveness probe.
+func (h *healthHandler) ready(w http.ResponseWriter, _ *http.Request) {
+	health := h.reporter.Health()
+	switch {
+	case health.Draining:
+		http.Error(w, "the dispatcher is stopping", http.StatusServiceUnavailable)
+	case !health.Connected && !health.OfflineBuffering:
+		http.Error(w, "not connected to NATSS", http.StatusServiceUnavailable)
+	case !health.ChannelsSynced && !health.StaleHostMapLoaded:
+		http.Error(w, "the channels are not reconciled yet", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// live fails once the connection to NATSS has been down for longer than the liveness threshold, so
+// that the dispatcher is restarted.
+func (h *healthHandler) live(w http.ResponseWriter, _ *http.Request) {
+	health := h.reporter.Health()
+	if !health.Connected && h.livenessThreshold > 0 && !health.DisconnectedSince.IsZero() {
+		if down := h.now().Sub(health.DisconnectedSince); down > h.livenessThreshold {
+			http.Error(w, fmt.Sprintf("not connected to NATSS for %v", down.Round(time.Second)), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// initialSync records the channels reconciled since the dispatcher started, until the ones
+// existing once the informer synced are all reconciled. The followers record the channels they
+// skip, the leader making their subscriptions.
+type initialSync struct {
+	leaderAwareReconciler
+
+	mu sync.Mutex
+	// reconciled holds the keys of the channels reconciled, nil once synced.
+	reconciled sets.String
+}
+
+func newInitialSync(r leaderAwareReconciler) *initialSync {
+	return &initialSync{leaderAwareReconciler: r, reconciled: sets.NewString()}
+}
+
+// Reconcile implements controller.Reconciler. The channels failing to reconcile count as
+// reconciled, their status telling why.
+func (s *initialSync) Reconcile(ctx context.Context, key string) error {
+	err := s.leaderAwareReconciler.Reconcile(ctx, key)
+	s.mu.Lock()
+	if s.reconciled != nil {
+		s.reconciled.Insert(key)
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// register registers the hook marking the channels of reporter synced once the channels in the
+// scope of namespace, listed once hasSynced, are reconciled or deleted.
+func (s *initialSync) register(lifecycle *dispatcher.Lifecycle, reporter dispatcher.HealthReporter, lister listers.NatssChannelLister, namespace string, hasSynced cache.InformerSynced) error {
+	return lifecycle.Register(lifecycle.RunHook("initial-sync", dispatcher.PrioritySubscriptions+1, func(ctx context.Context) error {
+		if !s.wait(ctx, lister, namespace, hasSynced) {
+			return nil
+		}
+		logging.FromContext(ctx).Info("The channels are reconciled, the dispatcher is ready")
+		reporter.MarkChannelsSynced()
+		return nil
+	}))
+}
+
+// wait returns once the channels in the scope of namespace, listed once hasSynced, are reconciled
+// or deleted, false when ctx is done first.
+func (s *initialSync) wait(ctx context.Context, lister listers.NatssChannelLister, namespace string, hasSynced cache.InformerSynced) bool {
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+		return false
+	}
+	channels, err := lister.List(labels.Everything())
+	if err != nil {
+		return false
+	}
+	pending := sets.NewString()
+	for _, nc := range channels {
+		if inScope(nc, namespace) {
+			pending.Insert(nc.Namespace + "/" + nc.Name)
+		}
+	}
+
+	ticker := time.NewTicker(initialSyncPoll)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		for _, key := range pending.UnsortedList() {
+			namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+			if _, err := lister.NatssChannels(namespace).Get(name); s.reconciled.Has(key) || err != nil {
+				pending.Delete(key)
+			}
+		}
+		if pending.Len() == 0 {
+			s.reconciled = nil
+			s.mu.Unlock()
+			return true
+		}
+		s.mu.Unlock()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
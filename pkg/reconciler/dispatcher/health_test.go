@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeHealthReporter struct {
+	health dispatcher.Health
+}
+
+func (r *fakeHealthReporter) Health() dispatcher.Health {
+	return r.health
+}
+
+func (r *fakeHealthReporter) MarkChannelsSynced() {
+	r.health.ChannelsSynced = true
+}
+
+func TestHealthHandler(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		health    dispatcher.Health
+		threshold time.Duration
+		wantReady int
+		wantLive  int
+	}{
+		"ready": {
+			health:    dispatcher.Health{Connected: true, ChannelsSynced: true},
+			threshold: time.Minute,
+			wantReady: http.StatusOK,
+			wantLive:  http.StatusOK,
+		},
+		"channels not synced": {
+			health:    dispatcher.Health{Connected: true},
+			threshold: time.Minute,
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusOK,
+		},
+		"draining": {
+			health:    dispatcher.Health{Connected: true, ChannelsSynced: true, Draining: true},
+			threshold: time.Minute,
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusOK,
+		},
+		"disconnected below the threshold": {
+			health:    dispatcher.Health{ChannelsSynced: true, DisconnectedSince: now.Add(-30 * time.Second)},
+			threshold: time.Minute,
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusOK,
+		},
+		"disconnected beyond the threshold": {
+			health:    dispatcher.Health{ChannelsSynced: true, DisconnectedSince: now.Add(-2 * time.Minute)},
+			threshold: time.Minute,
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusServiceUnavailable,
+		},
+		"stale host map before the channels are synced": {
+			health:    dispatcher.Health{Connected: true, StaleHostMapLoaded: true},
+			threshold: time.Minute,
+			wantReady: http.StatusOK,
+			wantLive:  http.StatusOK,
+		},
+		"offline buffer while disconnected": {
+			health:    dispatcher.Health{ChannelsSynced: true, OfflineBuffering: true, DisconnectedSince: now.Add(-30 * time.Second)},
+			threshold: time.Minute,
+			wantReady: http.StatusOK,
+			wantLive:  http.StatusOK,
+		},
+		"offline buffer disconnected beyond the threshold": {
+			health:    dispatcher.Health{ChannelsSynced: true, OfflineBuffering: true, DisconnectedSince: now.Add(-2 * time.Minute)},
+			threshold: time.Minute,
+			wantReady: http.StatusOK,
+			wantLive:  http.StatusServiceUnavailable,
+		},
+		"offline buffer before the channels are synced": {
+			health:    dispatcher.Health{OfflineBuffering: true, DisconnectedSince: now},
+			threshold: time.Minute,
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusOK,
+		},
+		"no threshold": {
+			health:    dispatcher.Health{ChannelsSynced: true, DisconnectedSince: now.Add(-time.Hour)},
+			wantReady: http.StatusServiceUnavailable,
+			wantLive:  http.StatusOK,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			h := newHealthHandler(&fakeHealthReporter{health: tc.health}, tc.threshold)
+			h.now = func() time.Time { return now }
+
+			w := httptest.NewRecorder()
+			h.ready(w, httptest.NewRequest("GET", readyzPath, nil))
+			if w.Code != tc.wantReady {
+				t.Errorf("%s status = %d, want %d: %s", readyzPath, w.Code, tc.wantReady, w.Body)
+			}
+			w = httptest.NewRecorder()
+			h.live(w, httptest.NewRequest("GET", healthzPath, nil))
+			if w.Code != tc.wantLive {
+				t.Errorf("%s status = %d, want %d: %s", healthzPath, w.Code, tc.wantLive, w.Body)
+			}
+		})
+	}
+}
+
+func TestHealthHandlerBeforeConnecting(t *testing.T) {
+	d, err := dispatcher.NewDispatcher(dispatcher.Args{
+		ClientID:      "test",
+		OfflineBuffer: &dispatcher.OfflineBuffer{MaxEvents: 10},
+	})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = %v", err)
+	}
+	reporter := d.(dispatcher.HealthReporter)
+	h := newHealthHandler(reporter, time.Minute)
+	ready := func() int {
+		w := httptest.NewRecorder()
+		h.ready(w, httptest.NewRequest("GET", readyzPath, nil))
+		return w.Code
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("%s status = %d without the channels, want %d", readyzPath, code, http.StatusServiceUnavailable)
+	}
+
+	// The stale host map routes the events the offline buffer holds until the connection is made.
+	d.(dispatcher.HostToChannelMapper).LoadStaleHostToChannelMap(map[string]eventingchannels.ChannelReference{
+		"channel.ns.svc.cluster.local": {Namespace: "ns", Name: "channel"},
+	})
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("%s status = %d with the stale host map and the offline buffer, want %d", readyzPath, code, http.StatusOK)
+	}
+}
+
+func TestInitialSync(t *testing.T) {
+	poll := initialSyncPoll
+	initialSyncPoll = time.Millisecond
+	defer func() { initialSyncPoll = poll }()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, nc := range []interface{}{
+		reconciletesting.NewNatssChannel("reconciled", testNS),
+		reconciletesting.NewNatssChannel("deleted", testNS),
+		reconciletesting.NewNatssChannel("namespaced", testNS, reconciletesting.WithNatssChannelNamespaceScoped),
+	} {
+		if err := indexer.Add(nc); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+	lister := listers.NewNatssChannelLister(indexer)
+	s := newInitialSync(&recordingReconciler{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan bool, 1)
+	go func() {
+		synced <- s.wait(ctx, lister, "", func() bool { return true })
+	}()
+
+	// The channels out of the scope of the dispatcher are not waited for.
+	if err := s.Reconcile(ctx, testNS+"/reconciled"); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	select {
+	case <-synced:
+		t.Fatal("synced before every channel was reconciled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The channels deleted before being reconciled are not waited for.
+	if err := indexer.Delete(reconciletesting.NewNatssChannel("deleted", testNS)); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	select {
+	case ok := <-synced:
+		if !ok {
+			t.Error("wait() = false, want true")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not synced once every channel was reconciled")
+	}
+
+	// The keys are no longer recorded once synced.
+	if err := s.Reconcile(ctx, testNS+"/other"); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	if s.reconciled != nil {
+		t.Errorf("reconciled = %v once synced, want nil", s.reconciled)
+	}
+}
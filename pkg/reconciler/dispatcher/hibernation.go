@@ -0,0 +1,42 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileHibernation reports whether the dispatcher closed the subscriptions of natssChannel
+// because it was idle, the channel being reconciled again when it hibernates or wakes up.
+func (r *Reconciler) reconcileHibernation(natssChannel *v1beta1.NatssChannel) {
+	hibernator, ok := r.natssDispatcher.(dispatcher.Hibernator)
+	if !ok {
+		natssChannel.Status.ClearHibernatedCondition()
+		return
+	}
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	hibernator.WatchHibernation(channelReference(natssChannel), func() { r.enqueueKey(key) })
+	if since, hibernated := hibernator.HibernatedSince(channelReference(natssChannel)); hibernated {
+		natssChannel.Status.MarkHibernated(since)
+	} else {
+		natssChannel.Status.ClearHibernatedCondition()
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeHibernator struct {
+	dispatcher.NatssDispatcher
+
+	watched map[eventingchannels.ChannelReference]func()
+	since   time.Time
+}
+
+func (h *fakeHibernator) WatchHibernation(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(h.watched, channel)
+		return
+	}
+	h.watched[channel] = notify
+}
+
+func (h *fakeHibernator) HibernatedSince(eventingchannels.ChannelReference) (time.Time, bool) {
+	return h.since, !h.since.IsZero()
+}
+
+func TestReconcileHibernation(t *testing.T) {
+	testCases := map[string]struct {
+		since       time.Time
+		unsupported bool
+		wantStatus  corev1.ConditionStatus
+	}{
+		"awake": {},
+		"hibernated": {
+			since:      time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC),
+			wantStatus: corev1.ConditionTrue,
+		},
+		"unsupported": {
+			unsupported: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			hibernator := &fakeHibernator{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				watched:         make(map[eventingchannels.ChannelReference]func()),
+				since:           tc.since,
+			}
+			r := &Reconciler{natssDispatcher: hibernator}
+			if tc.unsupported {
+				r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+			}
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS,
+				reconciletesting.WithNatssInitChannelConditions,
+				reconciletesting.WithNatssChannelDeploymentReady(),
+				reconciletesting.WithNatssChannelServiceReady(),
+				reconciletesting.WithNatssChannelEndpointsReady(),
+				reconciletesting.WithNatssChannelChannelServiceReady(),
+				reconciletesting.WithNatssChannelNatssConnectionReady(),
+				reconciletesting.WithNatssChannelAddress("channel.ns.svc.cluster.local"))
+			// A channel which was hibernated.
+			nc.Status.MarkHibernated(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+			r.reconcileHibernation(nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionHibernated)
+			if tc.wantStatus == "" {
+				if cond != nil {
+					t.Errorf("unexpected condition %+v", cond)
+				}
+			} else if cond == nil || cond.Status != tc.wantStatus || cond.Severity != apis.ConditionSeverityInfo {
+				t.Errorf("condition = %+v, want an informational condition with status %s", cond, tc.wantStatus)
+			}
+			if !nc.Status.IsReady() {
+				t.Error("the hibernation changed the readiness of the channel")
+			}
+			if _, watched := hibernator.watched[channelReference(nc)]; watched == tc.unsupported {
+				t.Errorf("watched = %v, want %v", watched, !tc.unsupported)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+const (
+	// hostMapConfigMapPrefix prefixes the names of the ConfigMaps holding the host to channel map,
+	// the map being split in ConfigMaps named <prefix>-0, <prefix>-1, ...
+	hostMapConfigMapPrefix = "natss-ch-dispatcher-hosts"
+
+	hostMapLabel = "messaging.knative.dev/natss-host-map"
+)
+
+// maxHostMapChunkBytes is the maximum size of the data of a host map ConfigMap,
+// well below the 1MiB limit of the objects stored in etcd.
+var maxHostMapChunkBytes = 512 * 1024
+
+// hostMapStore persists the host to channel map of the dispatcher in ConfigMaps.
+type hostMapStore struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+
+	mu sync.Mutex
+	// persisted is the last map written, used to skip unchanged writes.
+	persisted map[string]eventingchannels.ChannelReference
+}
+
+func newHostMapStore(kubeClient kubernetes.Interface, namespace string) *hostMapStore {
+	return &hostMapStore{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+	}
+}
+
+// load reads the persisted host to channel map, an empty map is returned when nothing was persisted.
+func (s *hostMapStore) load(ctx context.Context) (map[string]eventingchannels.ChannelReference, error) {
+	hcMap := make(map[string]eventingchannels.ChannelReference)
+	for i := 0; ; i++ {
+		cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, hostMapConfigMapName(i), metav1.GetOptions{})
+		if apierrs.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for host, value := range cm.Data {
+			parts := strings.SplitN(value, "/", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid channel reference %q for host %q in ConfigMap %s", value, host, cm.Name)
+			}
+			hcMap[host] = eventingchannels.ChannelReference{Namespace: parts[0], Name: parts[1]}
+		}
+	}
+
+	s.mu.Lock()
+	s.persisted = hcMap
+	s.mu.Unlock()
+	return hcMap, nil
+}
+
+// persist writes hcMap if it changed since the last load or persist.
+func (s *hostMapStore) persist(ctx context.Context, hcMap map[string]eventingchannels.ChannelReference) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.persisted != nil && reflect.DeepEqual(s.persisted, hcMap) {
+		return nil
+	}
+
+	chunks := chunkHostMap(hcMap)
+	for i, data := range chunks {
+		if err := s.write(ctx, hostMapConfigMapName(i), data); err != nil {
+			return err
+		}
+	}
+	// Remove the chunks of a previously larger map.
+	for i := len(chunks); ; i++ {
+		err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Delete(ctx, hostMapConfigMapName(i), metav1.DeleteOptions{})
+		if apierrs.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.persisted = hcMap
+	return nil
+}
+
+func (s *hostMapStore) write(ctx context.Context, name string, data map[string]string) error {
+	configMaps := s.kubeClient.CoreV1().ConfigMaps(s.namespace)
+	cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: s.namespace,
+				Labels:    map[string]string{hostMapLabel: "true"},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(cm.Data, data) {
+		return nil
+	}
+	cm = cm.DeepCopy()
+	cm.Data = data
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// chunkHostMap splits hcMap, sorted by host, in ConfigMap data of at most maxHostMapChunkBytes.
+// There is always at least one chunk, so that an empty map overrides a previously persisted one.
+func chunkHostMap(hcMap map[string]eventingchannels.ChannelReference) []map[string]string {
+	hosts := make([]string, 0, len(hcMap))
+	for host := range hcMap {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	chunks := []map[string]string{{}}
+	size := 0
+	for _, host := range hosts {
+		value := hcMap[host].Namespace + "/" + hcMap[host].Name
+		entrySize := len(host) + len(value)
+		if size > 0 && size+entrySize > maxHostMapChunkBytes {
+			chunks = append(chunks, map[string]string{})
+			size = 0
+		}
+		chunks[len(chunks)-1][host] = value
+		size += entrySize
+	}
+	return chunks
+}
+
+func hostMapConfigMapName(i int) string {
+	return fmt.Sprintf("%s-%d", hostMapConfigMapPrefix, i)
+}
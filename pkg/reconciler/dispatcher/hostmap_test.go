@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+)
+
+const hostMapNamespace = "knative-eventing"
+
+func TestHostMapStoreLoad(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: hostMapConfigMapName(0), Namespace: hostMapNamespace},
+			Data:       map[string]string{"a.ns.svc.cluster.local": "ns/a"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: hostMapConfigMapName(1), Namespace: hostMapNamespace},
+			Data:       map[string]string{"b.ns.svc.cluster.local": "ns/b"},
+		},
+	)
+
+	got, err := newHostMapStore(kubeClient, hostMapNamespace).load(context.Background())
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	want := map[string]eventingchannels.ChannelReference{
+		"a.ns.svc.cluster.local": {Namespace: "ns", Name: "a"},
+		"b.ns.svc.cluster.local": {Namespace: "ns", Name: "b"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected host map (-want, +got): %s", diff)
+	}
+}
+
+func TestHostMapStoreLoadNothingPersisted(t *testing.T) {
+	got, err := newHostMapStore(fake.NewSimpleClientset(), hostMapNamespace).load(context.Background())
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("load() = %v, want an empty map", got)
+	}
+}
+
+func TestHostMapStorePersistChunks(t *testing.T) {
+	defer func(size int) { maxHostMapChunkBytes = size }(maxHostMapChunkBytes)
+	maxHostMapChunkBytes = 100
+
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	store := newHostMapStore(kubeClient, hostMapNamespace)
+
+	hcMap := make(map[string]eventingchannels.ChannelReference)
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("channel-%d", i)
+		hcMap[name+".ns.svc.cluster.local"] = eventingchannels.ChannelReference{Namespace: "ns", Name: name}
+	}
+	if err := store.persist(ctx, hcMap); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+	if chunks := countHostMapConfigMaps(t, kubeClient); chunks < 2 {
+		t.Errorf("persisted %d ConfigMaps, want the map to be split", chunks)
+	}
+
+	// A restarted dispatcher reads the same map.
+	got, err := newHostMapStore(kubeClient, hostMapNamespace).load(ctx)
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if diff := cmp.Diff(hcMap, got); diff != "" {
+		t.Errorf("unexpected host map (-want, +got): %s", diff)
+	}
+
+	// Unchanged maps are not written again.
+	kubeClient.ClearActions()
+	if err := store.persist(ctx, hcMap); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+	if actions := kubeClient.Actions(); len(actions) != 0 {
+		t.Errorf("unexpected actions persisting an unchanged map: %v", actions)
+	}
+
+	// The chunks of a larger map are removed once it shrinks.
+	small := map[string]eventingchannels.ChannelReference{
+		"channel-0.ns.svc.cluster.local": {Namespace: "ns", Name: "channel-0"},
+	}
+	if err := store.persist(ctx, small); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+	if chunks := countHostMapConfigMaps(t, kubeClient); chunks != 1 {
+		t.Errorf("persisted %d ConfigMaps, want 1", chunks)
+	}
+	got, err = newHostMapStore(kubeClient, hostMapNamespace).load(ctx)
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if diff := cmp.Diff(small, got); diff != "" {
+		t.Errorf("unexpected host map (-want, +got): %s", diff)
+	}
+}
+
+func countHostMapConfigMaps(t *testing.T, kubeClient *fake.Clientset) int {
+	cms, err := kubeClient.CoreV1().ConfigMaps(hostMapNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list ConfigMaps: %v", err)
+	}
+	return len(cms.Items)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/features"
+)
+
+// reconcileInsecureDeliveries lists, with the insecure-delivery-condition flag, the subscribers of
+// natssChannel delivered to over plain HTTP in another namespace, the channel being reconciled
+// again when another one is.
+func (r *Reconciler) reconcileInsecureDeliveries(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	reporter, ok := r.natssDispatcher.(dispatcher.InsecureDeliveryReporter)
+	if !ok || !features.FromContext(ctx).InsecureDeliveryCondition.Enabled() {
+		if ok {
+			reporter.WatchInsecureDeliveries(channelReference(natssChannel), nil)
+		}
+		natssChannel.Status.ClearInsecureDeliveryCondition()
+		return
+	}
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	reporter.WatchInsecureDeliveries(channelReference(natssChannel), func() { r.enqueueKey(key) })
+	insecure := make(map[types.UID]bool)
+	for _, uid := range reporter.InsecureDeliveries(channelReference(natssChannel)) {
+		insecure[uid] = true
+	}
+	var subscribers []string
+	for _, sub := range natssChannel.Spec.Subscribers {
+		if insecure[sub.UID] {
+			subscribers = append(subscribers, sub.SubscriberURI.String())
+		}
+	}
+	if len(subscribers) == 0 {
+		natssChannel.Status.ClearInsecureDeliveryCondition()
+		return
+	}
+	natssChannel.Status.MarkInsecureDelivery(subscribers)
+}
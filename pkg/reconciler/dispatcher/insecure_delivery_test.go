@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	"knative.dev/eventing-natss/pkg/features"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeInsecureReporter struct {
+	dispatcher.NatssDispatcher
+
+	watched  map[eventingchannels.ChannelReference]func()
+	insecure []types.UID
+}
+
+func (f *fakeInsecureReporter) WatchInsecureDeliveries(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(f.watched, channel)
+		return
+	}
+	f.watched[channel] = notify
+}
+
+func (f *fakeInsecureReporter) InsecureDeliveries(eventingchannels.ChannelReference) []types.UID {
+	return f.insecure
+}
+
+func TestReconcileInsecureDeliveries(t *testing.T) {
+	testCases := map[string]struct {
+		flag        features.Flag
+		insecure    []types.UID
+		wantMessage string
+		wantWatched bool
+	}{
+		"disabled": {
+			flag:     features.Disabled,
+			insecure: []types.UID{"uid-cross"},
+		},
+		"secure": {
+			flag:        features.Enabled,
+			wantWatched: true,
+		},
+		"insecure": {
+			flag:        features.Enabled,
+			insecure:    []types.UID{"uid-cross", "uid-removed"},
+			wantMessage: "http://subscriber.other.svc.cluster.local",
+			wantWatched: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			reporter := &fakeInsecureReporter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				watched:         make(map[eventingchannels.ChannelReference]func()),
+				insecure:        tc.insecure,
+			}
+			r := &Reconciler{natssDispatcher: reporter}
+			flags := features.Defaults()
+			flags.InsecureDeliveryCondition = tc.flag
+			ctx := features.ToContext(context.Background(), flags)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS,
+				reconciletesting.WithNatssInitChannelConditions,
+				reconciletesting.WithNatssChannelDeploymentReady(),
+				reconciletesting.WithNatssChannelServiceReady(),
+				reconciletesting.WithNatssChannelEndpointsReady(),
+				reconciletesting.WithNatssChannelChannelServiceReady(),
+				reconciletesting.WithNatssChannelNatssConnectionReady(),
+				reconciletesting.WithNatssChannelAddress("channel.ns.svc.cluster.local"))
+			nc.Spec.Subscribers = []eventingduckv1.SubscriberSpec{
+				{UID: "uid-cross", SubscriberURI: apis.HTTP("subscriber.other.svc.cluster.local")},
+				{UID: "uid-local", SubscriberURI: apis.HTTP("subscriber." + testNS + ".svc.cluster.local")},
+			}
+			// A channel which delivered insecurely before.
+			nc.Status.MarkInsecureDelivery([]string{"http://previous.other.svc.cluster.local"})
+			r.reconcileInsecureDeliveries(ctx, nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionInsecureDelivery)
+			if tc.wantMessage == "" {
+				if cond != nil {
+					t.Errorf("unexpected condition %+v", cond)
+				}
+			} else if cond == nil || cond.Severity != apis.ConditionSeverityInfo || !strings.HasSuffix(cond.Message, ": "+tc.wantMessage) {
+				t.Errorf("condition = %+v, want an informational condition listing %s", cond, tc.wantMessage)
+			}
+			if !nc.Status.IsReady() {
+				t.Error("the insecure deliveries changed the readiness of the channel")
+			}
+			if _, watched := reporter.watched[channelReference(nc)]; watched != tc.wantWatched {
+				t.Errorf("watched = %v, want %v", watched, tc.wantWatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileSubscriptionInit tells the dispatcher when to make the subscriptions of natssChannel,
+// the channel being reconciled again once its deferred subscriptions are made, to report the
+// ones failing.
+func (r *Reconciler) reconcileSubscriptionInit(natssChannel *v1beta1.NatssChannel) {
+	lazy, ok := r.natssDispatcher.(dispatcher.LazySubscriber)
+	if !ok {
+		return
+	}
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	lazy.SetSubscriptionInit(channelReference(natssChannel), natssChannel.Spec.SubscriptionInit)
+	lazy.WatchSubscriptionInit(channelReference(natssChannel), func() { r.enqueueKey(key) })
+}
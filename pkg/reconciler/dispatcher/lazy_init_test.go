@@ -0,0 +1,70 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeLazySubscriber struct {
+	dispatcher.NatssDispatcher
+
+	inits   map[eventingchannels.ChannelReference]v1beta1.SubscriptionInit
+	watched map[eventingchannels.ChannelReference]func()
+}
+
+func (f *fakeLazySubscriber) SetSubscriptionInit(channel eventingchannels.ChannelReference, init v1beta1.SubscriptionInit) {
+	f.inits[channel] = init
+}
+
+func (f *fakeLazySubscriber) WatchSubscriptionInit(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(f.watched, channel)
+		return
+	}
+	f.watched[channel] = notify
+}
+
+func TestReconcileSubscriptionInit(t *testing.T) {
+	lazy := &fakeLazySubscriber{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		inits:           make(map[eventingchannels.ChannelReference]v1beta1.SubscriptionInit),
+		watched:         make(map[eventingchannels.ChannelReference]func()),
+	}
+	r := &Reconciler{natssDispatcher: lazy}
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	nc.Spec.SubscriptionInit = v1beta1.SubscriptionInitLazy
+
+	r.reconcileSubscriptionInit(nc)
+	if got := lazy.inits[channelReference(nc)]; got != v1beta1.SubscriptionInitLazy {
+		t.Errorf("subscription init = %q, want %q", got, v1beta1.SubscriptionInitLazy)
+	}
+	if _, watched := lazy.watched[channelReference(nc)]; !watched {
+		t.Error("the deferred subscriptions of the channel are not watched")
+	}
+
+	// The dispatchers unable to defer the subscriptions make them eagerly.
+	r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+	r.reconcileSubscriptionInit(nc)
+}
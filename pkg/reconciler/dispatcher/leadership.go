@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	pkgreconciler "knative.dev/pkg/reconciler"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// leadership makes the subscriptions of the channels follow the buckets of config-leader-election
+// the replica leads: the subscriptions of the channels of a bucket are closed when the replica is
+// demoted, their durables kept for the new leader, and made again when it is promoted.
+type leadership struct {
+	leaderAwareReconciler
+
+	handler dispatcher.LeadershipHandler
+	lister  listers.NatssChannelLister
+}
+
+// Promote implements pkgreconciler.LeaderAware.
+func (l *leadership) Promote(b pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+	if err := l.leaderAwareReconciler.Promote(b, enq); err != nil {
+		return err
+	}
+	l.handler.Promote(l.leads)
+	return nil
+}
+
+// Demote implements pkgreconciler.LeaderAware.
+func (l *leadership) Demote(b pkgreconciler.Bucket) {
+	l.leaderAwareReconciler.Demote(b)
+	l.handler.Demote(l.leads)
+}
+
+// leads tells whether the replica leads channel. The channels deleted since the replica was
+// demoted are left to the leader which finalized them.
+func (l *leadership) leads(channel eventingchannels.ChannelReference) bool {
+	if !l.IsLeaderFor(types.NamespacedName{Namespace: channel.Namespace, Name: channel.Name}) {
+		return false
+	}
+	_, err := l.lister.NatssChannels(channel.Namespace).Get(channel.Name)
+	return !apierrs.IsNotFound(err)
+}
+
+// ObserveKind keeps the receiver of the replicas not leading natssChannel publishing its events
+// as the leader does. Only the leader makes its subscriptions and updates its status, the changes
+// of the status being made on a copy.
+func (r *Reconciler) ObserveKind(ctx context.Context, natssChannel *v1beta1.NatssChannel) pkgreconciler.Event {
+	natssChannel = natssChannel.DeepCopy()
+	ctx = withChannelLogLevel(ctx, natssChannel)
+	if setter, ok := r.natssDispatcher.(dispatcher.ObservabilitySetter); ok {
+		o, _ := v1beta1.ObservabilityFromAnnotations(natssChannel.Annotations)
+		setter.SetObservability(channelReference(natssChannel), o)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.MultiplexTargetSetter); ok {
+		setter.SetMultiplexTarget(channelReference(natssChannel), natssChannel.Annotations[messaging.MultiplexTargetAnnotationKey] == "true")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.StoragePressureSetter); ok {
+		setter.SetShedOnPressure(channelReference(natssChannel), natssChannel.Labels[messaging.ShedOnPressureLabelKey] == "true")
+	}
+	r.reconcileAudit(natssChannel)
+	if err := r.reconcileCluster(natssChannel); err != nil {
+		return err
+	}
+	if err := r.reconcileEncryption(ctx, natssChannel); err != nil {
+		return err
+	}
+	return r.processChannels(ctx)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+	pkgreconciler "knative.dev/pkg/reconciler"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+// bucketReconciler is leader of the buckets it is promoted for.
+type bucketReconciler struct {
+	controller.Reconciler
+	pkgreconciler.LeaderAwareFuncs
+}
+
+// fakeLeadershipHandler records which of channels are led on each promotion or demotion.
+type fakeLeadershipHandler struct {
+	channels []eventingchannels.ChannelReference
+	led      []string
+}
+
+func (h *fakeLeadershipHandler) record(transition string, leads func(eventingchannels.ChannelReference) bool) {
+	for _, channel := range h.channels {
+		if leads(channel) {
+			h.led = append(h.led, transition+" "+channel.String())
+		}
+	}
+}
+
+func (h *fakeLeadershipHandler) Promote(leads func(eventingchannels.ChannelReference) bool) {
+	h.record("promoted", leads)
+}
+
+func (h *fakeLeadershipHandler) Demote(leads func(eventingchannels.ChannelReference) bool) {
+	h.record("demoted", leads)
+}
+
+func TestLeadership(t *testing.T) {
+	handler := &fakeLeadershipHandler{channels: []eventingchannels.ChannelReference{
+		{Namespace: testNS, Name: ncName},
+		{Namespace: testNS, Name: "deleted"},
+	}}
+	var enqueued []types.NamespacedName
+	l := &leadership{
+		leaderAwareReconciler: &bucketReconciler{LeaderAwareFuncs: pkgreconciler.LeaderAwareFuncs{
+			PromoteFunc: func(b pkgreconciler.Bucket, enq func(pkgreconciler.Bucket, types.NamespacedName)) error {
+				enqueued = append(enqueued, types.NamespacedName{Namespace: testNS, Name: ncName})
+				return nil
+			},
+		}},
+		handler: handler,
+		lister:  reconciletesting.NewNatssChannelLister(reconciletesting.NewNatssChannel(ncName, testNS)),
+	}
+
+	// The channels of the bucket are enqueued and subscribed on promotion, but the deleted ones.
+	if err := l.Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {}); err != nil {
+		t.Fatalf("Promote() = %v", err)
+	}
+	if len(enqueued) != 1 {
+		t.Errorf("enqueued %v on promotion, want the channels of the bucket", enqueued)
+	}
+	l.Demote(pkgreconciler.UniversalBucket())
+
+	want := []string{"promoted " + testNS + "/" + ncName}
+	if diff := cmp.Diff(want, handler.led); diff != "" {
+		t.Errorf("led channels (-want, +got) = %s", diff)
+	}
+}
+
+func TestObserveKind(t *testing.T) {
+	binder := &fakeClusterBinder{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		bound:           make(map[eventingchannels.ChannelReference]v1beta1.NatssChannelCluster),
+	}
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	r := &Reconciler{natssDispatcher: binder, natsschannelLister: reconciletesting.NewNatssChannelLister(nc)}
+
+	// The receiver of a follower publishes to the cluster of the channel, whose status is left to
+	// the leader.
+	if err := r.ObserveKind(context.Background(), nc); err != nil {
+		t.Fatalf("ObserveKind() = %v", err)
+	}
+	if _, ok := binder.bound[channelReference(nc)]; !ok {
+		t.Error("the channel is not bound to its cluster")
+	}
+	if nc.Status.Cluster != nil {
+		t.Errorf("Status.Cluster = %+v, want the status unchanged", nc.Status.Cluster)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"knative.dev/eventing-natss/pkg/reconciler/maintenance"
+)
+
+// maintenanceFilter skips the reconciliations of the channels while config-natss enables the
+// maintenance mode, the dispatcher keeping the subscriptions it has. The replicas not leading a
+// channel, which only observe it, are left unchanged.
+type maintenanceFilter struct {
+	leaderAwareReconciler
+
+	gate *maintenance.Gate
+}
+
+// Reconcile implements controller.Reconciler.
+func (f *maintenanceFilter) Reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err == nil && f.IsLeaderFor(types.NamespacedName{Namespace: namespace, Name: name}) && f.gate.Skip(key) {
+		return nil
+	}
+	return f.leaderAwareReconciler.Reconcile(ctx, key)
+}
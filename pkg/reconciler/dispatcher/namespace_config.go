@@ -0,0 +1,178 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// namespaceConfigs merges the config-natss ConfigMaps of the namespaces of the channels with the
+// config-natss ConfigMap of the system namespace.
+type namespaceConfigs struct {
+	lister corev1listers.ConfigMapLister
+
+	mu     sync.Mutex
+	global *config.Config
+}
+
+// setGlobal sets the configuration of the system namespace.
+func (n *namespaceConfigs) setGlobal(global *config.Config) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.global = global
+}
+
+// get returns the configuration of the channels of namespace, nil when the namespace has no
+// config-natss ConfigMap, with the keys of its ConfigMap which are not overridable.
+func (n *namespaceConfigs) get(namespace string) (*config.Config, []string, error) {
+	if n == nil || namespace == system.Namespace() {
+		return nil, nil, nil
+	}
+	cm, err := n.lister.ConfigMaps(namespace).Get(config.ConfigMapName)
+	if apierrs.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	n.mu.Lock()
+	global := n.global
+	n.mu.Unlock()
+	return global.WithNamespaceOverrides(cm)
+}
+
+// isNamespaceConfig tells whether obj is the config-natss ConfigMap of a namespace other than the
+// system namespace.
+func isNamespaceConfig(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	return ok && cm.Name == config.ConfigMapName && cm.Namespace != system.Namespace()
+}
+
+// namespaceConfigChanged reconciles the channels of the namespace of the config-natss ConfigMap
+// obj, after logging the keys it cannot override.
+func (r *Reconciler) namespaceConfigChanged(ctx context.Context, channels cache.SharedIndexInformer) func(obj interface{}) {
+	logger := logging.FromContext(ctx)
+	return func(obj interface{}) {
+		accessor, err := kmeta.DeletionHandlingAccessor(obj)
+		if err != nil {
+			return
+		}
+		namespace := accessor.GetNamespace()
+		_, ignored, err := r.namespaceConfigs.get(namespace)
+		if err != nil {
+			logger.Errorw("Invalid configuration of the namespace, the global configuration applies to its channels",
+				zap.String("namespace", namespace), zap.Error(err))
+		}
+		if len(ignored) > 0 {
+			logger.Warnw("Ignoring the keys of the configuration of the namespace which are not overridable",
+				zap.String("namespace", namespace), zap.Strings("keys", ignored))
+		}
+		r.impl.FilteredGlobalResync(func(obj interface{}) bool {
+			nc, ok := obj.(*v1beta1.NatssChannel)
+			return ok && nc.Namespace == namespace
+		}, channels)
+	}
+}
+
+// reconcileNamespaceConfig applies to natssChannel the settings of the config-natss ConfigMap of
+// its namespace. The response code policy of the spec of the channel takes precedence over that
+// of the namespace, which takes precedence over that of the system namespace. An invalid ConfigMap
+// is ignored.
+func (r *Reconciler) reconcileNamespaceConfig(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	// The keys which are not overridable are logged when the ConfigMap changes.
+	cfg, _, err := r.namespaceConfigs.get(natssChannel.Namespace)
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Ignoring the configuration of the namespace", zap.Error(err))
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, "NamespaceConfigInvalid",
+			"Ignoring the invalid %s ConfigMap of the namespace: %v", config.ConfigMapName, err)
+		cfg = nil
+	}
+
+	if setter, ok := r.natssDispatcher.(dispatcher.ResponseCodePolicySetter); ok {
+		policy := natssChannel.Spec.ResponseCodePolicy
+		if policy == nil && cfg != nil {
+			policy = cfg.ResponseCodePolicy
+		}
+		setter.SetResponseCodePolicy(channelReference(natssChannel), policy)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.DeliveryLimitsSetter); ok {
+		var limits *dispatcher.DeliveryLimits
+		if cfg != nil {
+			limits = &dispatcher.DeliveryLimits{
+				MaxRedirects: cfg.DeliveryMaxRedirects,
+				MaxInflight:  cfg.DeliveryMaxInflight,
+				AckWait:      cfg.DeliveryAckWait,
+				StartAt:      cfg.DeliveryStartAt,
+			}
+		}
+		setter.SetDeliveryLimits(channelReference(natssChannel), limits)
+	}
+}
+
+// reconcileDeliveryOptions applies to natssChannel the delivery options set by its annotations,
+// which take precedence over the configuration of its namespace. The invalid annotations, which the
+// webhook rejects, are ignored.
+func (r *Reconciler) reconcileDeliveryOptions(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.DeliveryOptionsSetter)
+	if !ok {
+		return
+	}
+	recorder := controller.GetEventRecorder(ctx)
+	options, err := v1beta1.DeliveryOptionsFromAnnotations(natssChannel.Annotations)
+	if err != nil {
+		recorder.Eventf(natssChannel, corev1.EventTypeWarning, "DeliveryOptionsInvalid",
+			"Ignoring the invalid delivery options of the channel: %v", err)
+	}
+	switch {
+	case options.Order != v1beta1.DeliveryOrdered:
+	case natssChannel.Spec.Distribution == v1beta1.DistributionWorkQueue:
+		// The distribution set by the spec takes precedence over the annotation.
+		recorder.Eventf(natssChannel, corev1.EventTypeWarning, "DeliveryOrderIgnored",
+			"The events of a work queue are not delivered in order, ignoring the %s annotation", messaging.DeliveryOrderAnnotationKey)
+		options.Order = ""
+	case options.MaxInflight > 1:
+		recorder.Eventf(natssChannel, corev1.EventTypeWarning, "DeliveryOrderConflict",
+			"The events are delivered in order, one at a time, ignoring the %s annotation", messaging.MaxInflightAnnotationKey)
+	}
+	setter.SetDeliveryOptions(channelReference(natssChannel), options)
+}
+
+// deliveredInOrder tells whether the events of natssChannel are delivered in order, which the
+// events of a work queue never are.
+func deliveredInOrder(natssChannel *v1beta1.NatssChannel) bool {
+	options, _ := v1beta1.DeliveryOptionsFromAnnotations(natssChannel.Annotations)
+	return options.Order == v1beta1.DeliveryOrdered && natssChannel.Spec.Distribution != v1beta1.DistributionWorkQueue
+}
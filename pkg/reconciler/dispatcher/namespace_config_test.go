@@ -0,0 +1,261 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeNamespaceConfigSetter struct {
+	dispatcher.NatssDispatcher
+
+	policy  v1beta1.ResponseCodePolicy
+	limits  *dispatcher.DeliveryLimits
+	options v1beta1.DeliveryOptions
+}
+
+var _ dispatcher.ResponseCodePolicySetter = (*fakeNamespaceConfigSetter)(nil)
+var _ dispatcher.DeliveryLimitsSetter = (*fakeNamespaceConfigSetter)(nil)
+var _ dispatcher.DeliveryOptionsSetter = (*fakeNamespaceConfigSetter)(nil)
+
+func (f *fakeNamespaceConfigSetter) SetResponseCodePolicy(_ eventingchannels.ChannelReference, policy v1beta1.ResponseCodePolicy) {
+	f.policy = policy
+}
+
+func (f *fakeNamespaceConfigSetter) SetDeliveryLimits(_ eventingchannels.ChannelReference, limits *dispatcher.DeliveryLimits) {
+	f.limits = limits
+}
+
+func (f *fakeNamespaceConfigSetter) SetDeliveryOptions(_ eventingchannels.ChannelReference, options v1beta1.DeliveryOptions) {
+	f.options = options
+}
+
+func TestReconcileNamespaceConfig(t *testing.T) {
+	global, err := config.NewConfigFromConfigMap(&corev1.ConfigMap{
+		Data: map[string]string{
+			config.ResponseCodePolicyKey:   "404=drop",
+			config.DeliveryMaxRedirectsKey: "5",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConfigFromConfigMap() = %v", err)
+	}
+	specPolicy := v1beta1.ResponseCodePolicy{"429": v1beta1.ResponseActionRetry}
+
+	tests := map[string]struct {
+		namespace  string
+		data       map[string]string
+		specPolicy v1beta1.ResponseCodePolicy
+		wantPolicy v1beta1.ResponseCodePolicy
+		wantLimits *dispatcher.DeliveryLimits
+		wantEvent  string
+	}{
+		"no namespace config": {},
+		"spec without namespace config": {
+			specPolicy: specPolicy,
+			wantPolicy: specPolicy,
+		},
+		"namespace config": {
+			data: map[string]string{
+				config.ResponseCodePolicyKey:  "5xx=deadletter",
+				config.DeliveryMaxInflightKey: "8",
+			},
+			wantPolicy: v1beta1.ResponseCodePolicy{"5xx": v1beta1.ResponseActionDeadLetter},
+			wantLimits: &dispatcher.DeliveryLimits{MaxRedirects: 5, MaxInflight: 8},
+		},
+		"namespace ack wait and start position": {
+			data: map[string]string{
+				config.DeliveryAckWaitKey: "3m",
+				config.DeliveryStartAtKey: "all-available",
+			},
+			wantPolicy: v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDrop},
+			wantLimits: &dispatcher.DeliveryLimits{MaxRedirects: 5, AckWait: 3 * time.Minute, StartAt: v1beta1.StartPositionAllAvailable},
+		},
+		"global policy kept by the namespace config": {
+			data:       map[string]string{config.DeliveryMaxRedirectsKey: "0"},
+			wantPolicy: v1beta1.ResponseCodePolicy{"404": v1beta1.ResponseActionDrop},
+			wantLimits: &dispatcher.DeliveryLimits{MaxRedirects: 0},
+		},
+		"spec over namespace config": {
+			data:       map[string]string{config.ResponseCodePolicyKey: "5xx=deadletter"},
+			specPolicy: specPolicy,
+			wantPolicy: specPolicy,
+			wantLimits: &dispatcher.DeliveryLimits{MaxRedirects: 5},
+		},
+		"invalid namespace config": {
+			data:       map[string]string{config.DeliveryMaxInflightKey: "-1"},
+			specPolicy: specPolicy,
+			wantPolicy: specPolicy,
+			wantEvent:  "Warning NamespaceConfigInvalid",
+		},
+		"system namespace": {
+			namespace: system.Namespace(),
+			data:      map[string]string{config.DeliveryMaxInflightKey: "8"},
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			namespace := testNS
+			if tc.namespace != "" {
+				namespace = tc.namespace
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if tc.data != nil {
+				cm := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: config.ConfigMapName},
+					Data:       tc.data,
+				}
+				if err := indexer.Add(cm); err != nil {
+					t.Fatalf("failed to add the ConfigMap: %v", err)
+				}
+			}
+			setter := &fakeNamespaceConfigSetter{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+			r := &Reconciler{
+				natssDispatcher:  setter,
+				namespaceConfigs: &namespaceConfigs{lister: corev1listers.NewConfigMapLister(indexer), global: global},
+			}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, namespace)
+			nc.Spec.ResponseCodePolicy = tc.specPolicy
+			r.reconcileNamespaceConfig(ctx, nc)
+
+			if diff := cmp.Diff(tc.wantPolicy, setter.policy); diff != "" {
+				t.Errorf("unexpected response code policy (-want, +got): %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantLimits, setter.limits); diff != "" {
+				t.Errorf("unexpected delivery limits (-want, +got): %s", diff)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDeliveryOptions(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		workQueue   bool
+		want        v1beta1.DeliveryOptions
+		wantEvent   string
+	}{
+		"no annotation": {},
+		"annotations": {
+			annotations: map[string]string{
+				messaging.AckWaitAnnotationKey:     "90s",
+				messaging.MaxInflightAnnotationKey: "4",
+				messaging.StartAtAnnotationKey:     "new-only",
+			},
+			want: v1beta1.DeliveryOptions{AckWait: 90 * time.Second, MaxInflight: 4, StartAt: v1beta1.StartPositionNewOnly},
+		},
+		"invalid annotation ignored": {
+			annotations: map[string]string{
+				messaging.AckWaitAnnotationKey:     "forever",
+				messaging.MaxInflightAnnotationKey: "4",
+			},
+			want:      v1beta1.DeliveryOptions{MaxInflight: 4},
+			wantEvent: "Warning DeliveryOptionsInvalid",
+		},
+		"out of range": {
+			annotations: map[string]string{messaging.MaxInflightAnnotationKey: "100000"},
+			wantEvent:   "Warning DeliveryOptionsInvalid",
+		},
+		"ordered": {
+			annotations: map[string]string{messaging.DeliveryOrderAnnotationKey: "ordered"},
+			want:        v1beta1.DeliveryOptions{Order: v1beta1.DeliveryOrdered},
+		},
+		"unordered": {
+			annotations: map[string]string{messaging.DeliveryOrderAnnotationKey: "unordered"},
+			want:        v1beta1.DeliveryOptions{Order: v1beta1.DeliveryUnordered},
+		},
+		"unknown order": {
+			annotations: map[string]string{messaging.DeliveryOrderAnnotationKey: "partitioned"},
+			wantEvent:   "Warning DeliveryOptionsInvalid",
+		},
+		"ordered work queue": {
+			annotations: map[string]string{messaging.DeliveryOrderAnnotationKey: "ordered"},
+			workQueue:   true,
+			wantEvent:   "Warning DeliveryOrderIgnored",
+		},
+		"ordered with max inflight": {
+			annotations: map[string]string{
+				messaging.DeliveryOrderAnnotationKey: "ordered",
+				messaging.MaxInflightAnnotationKey:   "8",
+			},
+			want:      v1beta1.DeliveryOptions{MaxInflight: 8, Order: v1beta1.DeliveryOrdered},
+			wantEvent: "Warning DeliveryOrderConflict",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			setter := &fakeNamespaceConfigSetter{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+			r := &Reconciler{natssDispatcher: setter}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Annotations = tc.annotations
+			if tc.workQueue {
+				nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+			}
+			r.reconcileDeliveryOptions(ctx, nc)
+
+			if diff := cmp.Diff(tc.want, setter.options); diff != "" {
+				t.Errorf("unexpected delivery options (-want, +got): %s", diff)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
@@ -19,7 +19,10 @@ package controller
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
+	"sync"
 
 	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
 	"knative.dev/eventing/pkg/channel"
@@ -35,20 +38,45 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingclientset "knative.dev/eventing/pkg/client/clientset/versioned"
+	eventingclient "knative.dev/eventing/pkg/client/injection/client"
+	"knative.dev/eventing/pkg/client/injection/informers/messaging/v1/subscription"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
 	"knative.dev/eventing/pkg/kncloudevents"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/tracing"
+	tracingconfig "knative.dev/pkg/tracing/config"
 
+	"knative.dev/eventing-natss/pkg/apis/messaging"
 	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/cardinality"
 	clientset "knative.dev/eventing-natss/pkg/client/clientset/versioned"
 	"knative.dev/eventing-natss/pkg/client/injection/client"
 	"knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel"
 	natsschannelreconciler "knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
 	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
 	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/features"
+	"knative.dev/eventing-natss/pkg/loglevel"
+	"knative.dev/eventing-natss/pkg/probe"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
+	"knative.dev/eventing-natss/pkg/reconciler/maintenance"
+	"knative.dev/eventing-natss/pkg/reconciler/resync"
+	"knative.dev/eventing-natss/pkg/reconciler/statuspatch"
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
 	"knative.dev/eventing-natss/pkg/util"
 )
 
@@ -57,7 +85,9 @@ const (
 	// itself when creating events.
 	controllerAgentName = "natss-ch-dispatcher"
 
-	finalizerName = controllerAgentName
+	// adminPort is the port serving the endpoints of the operators, such as orphansPath, and the
+	// probes of the pod.
+	adminPort = util.DispatcherAdminPort
 )
 
 // Reconciler reconciles NATSS Channels.
@@ -65,14 +95,56 @@ type Reconciler struct {
 	natssDispatcher dispatcher.NatssDispatcher
 
 	natssClientSet clientset.Interface
+	// kubeClientSet reads the Secrets holding the encryption keys of the channels.
+	kubeClientSet kubernetes.Interface
 
 	natsschannelLister listers.NatssChannelLister
 	impl               *controller.Impl
+
+	conditionRecorder *events.ConditionRecorder
+	// hostMapStore persists the host to channel map of the dispatcher, nil when disabled.
+	hostMapStore *hostMapStore
+
+	// eventingClientSet and subscriptionLister are used to handle the replays requested by the
+	// Subscriptions, the replays are disabled when subscriptionLister is nil.
+	eventingClientSet  eventingclientset.Interface
+	subscriptionLister messaginglisters.SubscriptionLister
+
+	// defaultDeadLetterSinks are applied to the subscribers without a dead letter sink.
+	defaultDeadLetterSinks *defaultDeadLetterSinks
+
+	// namespaceConfigs holds the configuration of the namespaces with a config-natss ConfigMap,
+	// which are not looked up when nil.
+	namespaceConfigs *namespaceConfigs
+
+	// fanoutLimits are the fan-out limits of the channels without annotations overriding them,
+	// none when nil.
+	fanoutLimits *fanoutLimits
+
+	// pauses holds the *pauseMark of the paused subscriptions annotated on their Subscription.
+	pauses sync.Map
+
+	// orphanedSubscribers holds the *orphanedSubscribers of the channels having subscribers
+	// without Subscription, by namespaced name.
+	orphanedSubscribers sync.Map
+
+	// finalized holds the UIDs of the channels pending deletion whose subscriptions were removed
+	// along with the ones of another channel.
+	finalized sync.Map
+
+	// e2eProbe probes the probe channel, nil when the dispatcher does not serve the probe
+	// subscriber.
+	e2eProbe *e2eProbe
+
+	// storageMonitor reports the pressure of the store of NATSS to the dispatcher, nil when the
+	// dispatcher does not degrade under pressure.
+	storageMonitor *storageMonitor
 }
 
 // Check that our Reconciler implements controller.Reconciler.
 var _ natsschannelreconciler.Interface = (*Reconciler)(nil)
 var _ natsschannelreconciler.Finalizer = (*Reconciler)(nil)
+var _ natsschannelreconciler.ReadOnlyInterface = (*Reconciler)(nil)
 
 type envConfig struct {
 	PodName       string `envconfig:"POD_NAME" required:"true"`
@@ -81,7 +153,7 @@ type envConfig struct {
 
 // NewController initializes the controller and is called by the generated code.
 // Registers event handlers to enqueue events.
-func NewController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
+func NewController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
 
 	logger := logging.FromContext(ctx)
 
@@ -90,20 +162,103 @@ func NewController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
 		logger.Fatalw("Failed to process env var", zap.Error(err))
 	}
 
+	natssChannelConfig, err := config.Get(ctx)
+	if err != nil {
+		logger.Fatalw("Unable to read the natss channel configuration", zap.Error(err))
+	}
+
+	// The controller reports the invalid credentials on the channels.
+	auth, err := config.GetAuth(ctx, natssChannelConfig.AuthSecretName)
+	if err != nil {
+		logger.Fatalw("Unable to read the NATS credentials", zap.Error(err))
+	}
+
+	eventingFeatures, err := config.GetFeatures(ctx)
+	if err != nil {
+		logger.Fatalw("Unable to read the feature flags", zap.Error(err))
+	}
+
+	loggers := loglevel.NewFromContext(ctx, cmw)
+	cardinality.Set(natssChannelConfig.MetricsCardinality)
+
 	natssConfig := util.GetNatssConfig()
 	reporter := channel.NewStatsReporter(env.ContainerName, kmeta.ChildName(env.PodName, uuid.New().String()))
+	// The receiver serves the certificate issued by cert-manager once the controller mounts it.
+	tlsConfig, err := natssChannelConfig.CertManager.WithReceiverCertificate(natssChannelConfig.Security, config.CertManagerReceiverDir)
+	if err != nil {
+		logger.Fatalw("Unable to read the receiver certificate", zap.Error(err))
+	}
+	natssURL, clusterID := natssServer(natssChannelConfig)
 	dispatcherArgs := dispatcher.Args{
-		NatssURL:  util.GetDefaultNatssURL(),
-		ClusterID: util.GetDefaultClusterID(),
+		NatssURL:  natssURL,
+		ClusterID: clusterID,
 		ClientID:  natssConfig.ClientID,
 		Cargs: kncloudevents.ConnectionArgs{
 			MaxIdleConns:        natssConfig.MaxIdleConns,
 			MaxIdleConnsPerHost: natssConfig.MaxIdleConnsPerHost,
 		},
-		Logger:   logger.Desugar(),
-		Reporter: reporter,
+		Logger:           logger.Desugar(),
+		Loggers:          loggers,
+		Reporter:         reporter,
+		MaxBufferedBytes: natssConfig.MaxBufferedBytes,
+
+		DefaultResponseCodePolicy: natssChannelConfig.ResponseCodePolicy,
+		OutboundHeaders: &dispatcher.OutboundHeaders{
+			UserAgent: natssChannelConfig.DeliveryUserAgent,
+			Origin:    natssChannelConfig.DeliveryOrigin,
+		},
+		MaxRedirects:        natssChannelConfig.DeliveryMaxRedirects,
+		MaxInflight:         natssChannelConfig.DeliveryMaxInflight,
+		DeliveryConcurrency: natssChannelConfig.DeliveryConcurrency,
+		Egress: dispatcher.EgressLimits{
+			EventsPerSecond: natssChannelConfig.EgressEventsPerSecond,
+			BytesPerSecond:  natssChannelConfig.EgressBytesPerSecond,
+		},
+		AckWait:                natssChannelConfig.DeliveryAckWait,
+		StartAt:                natssChannelConfig.DeliveryStartAt,
+		ErrorBodyLimit:         natssChannelConfig.DeliveryErrorBodyLimit,
+		DefaultDelivery:        natssChannelConfig.DefaultDelivery,
+		DeliveryTimeout:        natssChannelConfig.DeliveryTimeout,
+		HibernationThreshold:   natssChannelConfig.HibernationThreshold,
+		UnhealthyPauseAfter:    natssChannelConfig.SubscriberPauseAfter,
+		UnhealthyProbeInterval: natssChannelConfig.SubscriberProbeInterval,
+		EndpointConnectCheck:   natssChannelConfig.SubscriberConnectCheck,
+		AvroSchemaCacheTTL:     natssChannelConfig.AvroSchemaCacheTTL,
+		TLS:                    tlsConfig,
+		Auth:                   auth,
+		TrustedProxies:         natssChannelConfig.ReceiverTrustedProxies,
+		TransportEncryption:    eventingFeatures.TransportEncryption,
+		Partitioned:            natssChannelConfig.ServerPartitioned,
+		ChannelProvisioningURL: natssChannelConfig.ServerChannelProvisioningURL,
+		DrainTimeout:           natssChannelConfig.DispatcherDrainTimeout,
+		DurableNaming:          dispatcher.DurableNamingScheme(natssChannelConfig.DurableNamingScheme),
+		ReconnectBackoff:       natssChannelConfig.ServerReconnectBackoff,
+		ReconnectMaxBackoff:    natssChannelConfig.ServerReconnectMaxBackoff,
+		Publish: dispatcher.PublishOptions{
+			Mode:            dispatcher.PublishMode(natssChannelConfig.ReceiverPublish.Mode),
+			MaxAcksInflight: natssChannelConfig.ReceiverPublish.MaxInflight,
+			AckTimeout:      natssChannelConfig.ReceiverPublish.AckTimeout,
+		},
+
+		RejectReservedExtensions: natssChannelConfig.ReceiverRejectReservedExtensions,
+	}
+	if reports := natssChannelConfig.DeliveryReports; reports.Sink != nil {
+		dispatcherArgs.DeliveryReports = &dispatcher.DeliveryReports{
+			Sink:          reports.Sink,
+			BatchSize:     reports.BatchSize,
+			FlushInterval: reports.FlushInterval,
+			QueueSize:     reports.QueueSize,
+		}
 	}
-	natssDispatcher, err := dispatcher.NewDispatcher(dispatcherArgs)
+	if buffer := natssChannelConfig.OfflineBuffer; buffer.MaxEvents > 0 {
+		dispatcherArgs.OfflineBuffer = &dispatcher.OfflineBuffer{
+			MaxEvents:      buffer.MaxEvents,
+			MaxBytes:       buffer.MaxBytes,
+			MaxOutage:      buffer.MaxOutage,
+			DropOnOverflow: buffer.OverflowPolicy == config.OfflineBufferDrop,
+		}
+	}
+	natssDispatcher, err := dispatcher.NewTransport(natssChannelConfig.Transport, dispatcherArgs)
 	if err != nil {
 		logger.Fatal("Unable to create natss dispatcher", zap.Error(err))
 	}
@@ -112,34 +267,358 @@ func NewController(ctx context.Context, _ configmap.Watcher) *controller.Impl {
 	logger.Info("Starting the NATSS dispatcher")
 
 	channelInformer := natsschannel.Get(ctx)
+	subscriptionInformer := subscription.Get(ctx)
 
 	r := &Reconciler{
 		natssDispatcher:    natssDispatcher,
 		natsschannelLister: channelInformer.Lister(),
 		natssClientSet:     client.Get(ctx),
+		kubeClientSet:      kubeclient.Get(ctx),
+		conditionRecorder:  events.NewConditionRecorder(events.DefaultDedupWindow),
+		eventingClientSet:  eventingclient.Get(ctx),
+		subscriptionLister: subscriptionInformer.Lister(),
+	}
+	if natssChannelConfig.PersistHostMap {
+		r.hostMapStore = newHostMapStore(kubeclient.Get(ctx), stateNamespace(ctx))
+		r.loadHostToChannelMap(ctx, channelInformer.Informer().HasSynced)
+	}
+	if prober, ok := natssDispatcher.(dispatcher.E2EProber); ok {
+		r.e2eProbe = newE2EProbe(prober, r.natsschannelLister, natssChannelConfig.Probe)
+	}
+	if setter, ok := natssDispatcher.(dispatcher.StoragePressureSetter); ok {
+		r.storageMonitor = newStorageMonitor(setter, r.natsschannelLister, natssChannelConfig.Storage)
 	}
-	r.impl = natsschannelreconciler.NewImpl(ctx, r)
+	// The status is patched to keep the fields written by newer versions and by the controller.
+	ctx = statuspatch.WithClient(ctx, statuspatch.Dispatcher)
+	r.defaultDeadLetterSinks = &defaultDeadLetterSinks{}
+	r.defaultDeadLetterSinks.set(natssChannelConfig.DefaultDeadLetterSinks)
+	r.fanoutLimits = &fanoutLimits{}
+	r.fanoutLimits.set(natssChannelConfig.Fanout)
+	// Only the config-natss ConfigMaps of the namespaces are watched, in the namespace the dispatcher
+	// is scoped to, if any.
+	namespaceConfigInformer := coreinformers.NewFilteredConfigMapInformer(kubeclient.Get(ctx), injection.GetNamespaceScope(ctx),
+		controller.GetResyncPeriod(ctx), cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		func(options *v1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", config.ConfigMapName).String()
+		})
+	r.namespaceConfigs = &namespaceConfigs{lister: corev1listers.NewConfigMapLister(namespaceConfigInformer.GetIndexer())}
+	r.namespaceConfigs.setGlobal(natssChannelConfig)
+	// The reconciliations, and the subscriptions they create, see the feature flags of the
+	// ConfigMap as it is when they start.
+	flags := features.NewStore(natssChannelConfig.Features)
+	r.impl = natsschannelreconciler.NewImpl(ctx, r, func(*controller.Impl) controller.Options {
+		return controller.Options{ConfigStore: flags, FinalizerName: finalizerName}
+	})
+	generated := r.impl.Reconciler.(leaderAwareReconciler)
+	if handler, ok := natssDispatcher.(dispatcher.LeadershipHandler); ok {
+		generated = &leadership{leaderAwareReconciler: generated, handler: handler, lister: r.natsschannelLister}
+	}
+	// The channels skipped during the maintenance count as synced, the dispatcher being ready.
+	gate := maintenance.NewGate(ctx, controllerAgentName, r.natsschannelLister)
+	gate.Set(natssChannelConfig.MaintenanceMode)
+	initial := newInitialSync(&maintenanceFilter{
+		leaderAwareReconciler: &scopeFilter{
+			leaderAwareReconciler: &finalizerMigration{
+				leaderAwareReconciler: generated,
+				lister:                r.natsschannelLister,
+				client:                r.natssClientSet,
+			},
+			namespace: injection.GetNamespaceScope(ctx),
+			lister:    r.natsschannelLister,
+		},
+		gate: gate,
+	})
+	r.impl.Reconciler = initial
 
 	logger.Info("Setting up event handlers")
 
-	channelInformer.Informer().AddEventHandler(controller.HandleAll(r.impl.Enqueue))
+	channelInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isInScope(injection.GetNamespaceScope(ctx)),
+		Handler:    controller.HandleAll(r.impl.Enqueue),
+	})
+	subscriptionInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isNatssChannelWatched,
+		Handler:    controller.HandleAll(r.enqueueSubscriptionChannel),
+	})
+	namespaceConfigInformer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: isNamespaceConfig,
+		Handler:    controller.HandleAll(r.namespaceConfigChanged(ctx, channelInformer.Informer())),
+	})
+	go namespaceConfigInformer.Run(ctx.Done())
+
+	if notifier, ok := natssDispatcher.(dispatcher.ConnectionNotifier); ok {
+		go resyncOnConnect(ctx, notifier.Connected(), minResyncInterval, func() {
+			logger.Info("Connection to NATSS established, resyncing all channels")
+			r.impl.GlobalResync(channelInformer.Informer())
+		})
+	}
+
+	resyncer := resync.New("dispatcher", r.natsschannelLister, r.impl.EnqueueSlowKey, resync.SubscribersNotReady)
+	onDemand := resync.NewOnDemand("dispatcher", r.natsschannelLister, func(key types.NamespacedName) {
+		r.impl.WorkQueue().AddRateLimited(key)
+	}, loggers.Named("dispatcher.resync"))
+	var updates *configUpdates
+	if updater, ok := natssDispatcher.(dispatcher.ConfigUpdater); ok {
+		updates = newConfigUpdates(updater, natssChannelConfig)
+	}
+	config.Watch(ctx, cmw, func(c *config.Config) {
+		// The channels bound to the cluster of the dispatcher follow it.
+		if updates != nil && updates.apply(c) {
+			r.impl.GlobalResync(channelInformer.Informer())
+		}
+		// The channels, deleted ones included, are reconciled again when the maintenance ends.
+		if gate.Set(c.MaintenanceMode) {
+			r.impl.GlobalResync(channelInformer.Informer())
+		}
+		resyncer.SetConfig(c.DispatcherResync)
+		r.namespaceConfigs.setGlobal(c)
+		onDemand.Observe(c.ResyncRequest)
+		flags.Set(c.Features)
+		cardinality.Set(c.MetricsCardinality)
+		if r.e2eProbe != nil {
+			r.e2eProbe.setConfig(c.Probe)
+		}
+		if r.storageMonitor != nil {
+			r.storageMonitor.setConfig(c.Storage)
+		}
+		if r.fanoutLimits.set(c.Fanout) {
+			r.impl.GlobalResync(channelInformer.Informer())
+		}
+		// The channels of the namespaces whose default dead letter sink changed apply it again.
+		if changed := r.defaultDeadLetterSinks.set(c.DefaultDeadLetterSinks); changed.Len() > 0 {
+			r.impl.FilteredGlobalResync(func(obj interface{}) bool {
+				nc, ok := obj.(*v1beta1.NatssChannel)
+				return ok && changed.Has(nc.Namespace)
+			}, channelInformer.Informer())
+		}
+	})
+	if setter, ok := natssDispatcher.(dispatcher.TransportEncryptionSetter); ok {
+		config.WatchFeatures(ctx, cmw, func(f *config.Features) {
+			setter.SetTransportEncryption(f.TransportEncryption)
+		})
+	}
+	// The receiver and the dispatcher run in this process: config-tracing applies to the spans of
+	// both.
+	tracer := tracing.NewOpenCensusTracer(tracing.WithExporter(controllerAgentName, logger))
+	config.WatchTracing(ctx, cmw, func(c *tracingconfig.Config) {
+		if err := tracer.ApplyConfig(c); err != nil {
+			logger.Errorw("Unable to apply the tracing configuration", zap.Error(err))
+		}
+	})
+	go resyncer.Run(logging.WithLogger(ctx, loggers.Named("dispatcher.resync")))
+	// The runtime metrics of the process are the ones of the receiver and of the dispatcher.
+	sizers := []runtimemetrics.Sizer{r.conditionRecorder.Sizer(runtimemetrics.Dispatcher)}
+	if sizer, ok := natssDispatcher.(dispatcher.StructureSizer); ok {
+		sizers = append(sizers, sizer.Structures)
+	}
+	go runtimemetrics.Run(ctx, runtimemetrics.Dispatcher, runtimemetrics.DefaultPeriod, sizers...)
+
+	// The components of the dispatcher and of the controller are started, and stopped in the
+	// reverse order, by the same lifecycle.
+	lifecycle := dispatcher.NewLifecycle(logger.Desugar(), dispatcher.DefaultShutdownTimeout)
+	if participant, ok := natssDispatcher.(dispatcher.LifecycleParticipant); ok {
+		err = participant.RegisterHooks(lifecycle)
+	} else {
+		err = lifecycle.Register(lifecycle.RunHook("dispatcher", dispatcher.PrioritySubscriptions, natssDispatcher.Start))
+	}
+	if err != nil {
+		logger.Fatalw("Unable to register the dispatcher hooks", zap.Error(err))
+	}
+	admin := http.NewServeMux()
+	if natssChannelConfig.OrphanAuditInterval > 0 {
+		if err := r.registerOrphanAudit(ctx, lifecycle, admin, natssChannelConfig, flags, channelInformer.Informer().HasSynced); err != nil {
+			logger.Fatalw("Unable to register the orphaned durables audit hooks", zap.Error(err))
+		}
+	}
+	if err := r.registerOrphanedSubscriberCheck(lifecycle, channelInformer.Informer().HasSynced, subscriptionInformer.Informer().HasSynced); err != nil {
+		logger.Fatalw("Unable to register the orphaned subscribers check hooks", zap.Error(err))
+	}
+	if tracker, ok := natssDispatcher.(dispatcher.DeliveryCursorTracker); ok {
+		if err := registerDeliveryCursors(ctx, lifecycle, admin, tracker); err != nil {
+			logger.Fatalw("Unable to register the delivery cursors hooks", zap.Error(err))
+		}
+	}
+	if namer, ok := natssDispatcher.(dispatcher.DurableNamer); ok {
+		if err := registerDurableNames(ctx, lifecycle, namer); err != nil {
+			logger.Fatalw("Unable to register the durable names hooks", zap.Error(err))
+		}
+	}
+	bundle := newSupportBundleHandler(natssChannelConfig.SupportBundle)
+	bundle.add("config", dispatcher.DebugDumpFunc(func() interface{} { return natssChannelConfig }))
+	if reporter, ok := natssDispatcher.(dispatcher.MaxPayloadReporter); ok {
+		admin.Handle(configPath, &debugConfigHandler{maxPayload: reporter})
+		bundle.add("effectiveConfig", dispatcher.DebugDumpFunc(func() interface{} {
+			return effectiveConfig{MaxPayload: reporter.MaxPayload()}
+		}))
+	}
+	if source, ok := natssDispatcher.(dispatcher.DebugDumperSource); ok {
+		bundle.addSource(source)
+	}
+	admin.Handle(supportBundlePath, bundle)
+	if reporter, ok := natssDispatcher.(dispatcher.HealthReporter); ok {
+		if err := initial.register(lifecycle, reporter, r.natsschannelLister, injection.GetNamespaceScope(ctx), channelInformer.Informer().HasSynced); err != nil {
+			logger.Fatalw("Unable to register the initial sync hooks", zap.Error(err))
+		}
+		health := newHealthHandler(reporter, natssChannelConfig.DispatcherLivenessThreshold)
+		admin.HandleFunc(healthzPath, health.live)
+		admin.HandleFunc(readyzPath, health.ready)
+	}
+	if r.e2eProbe != nil {
+		if err := r.e2eProbe.register(lifecycle, channelInformer.Informer().HasSynced); err != nil {
+			logger.Fatalw("Unable to register the end to end probe hooks", zap.Error(err))
+		}
+	}
+	if r.storageMonitor != nil {
+		if err := r.storageMonitor.register(lifecycle); err != nil {
+			logger.Fatalw("Unable to register the storage monitor hooks", zap.Error(err))
+		}
+	}
+	if err := registerAdminServer(ctx, lifecycle, admin); err != nil {
+		logger.Fatalw("Unable to register the admin server hooks", zap.Error(err))
+	}
 
 	logger.Info("Starting dispatcher.")
 	go func() {
-		if err := natssDispatcher.Start(ctx); err != nil {
+		defer markStopped(ctx)
+		if err := lifecycle.Run(ctx); err != nil {
 			logger.Errorw("Cannot start dispatcher", zap.Error(err))
 		}
 	}()
 	return r.impl
 }
 
+// registerOrphanAudit registers the hooks periodically auditing the orphaned durables once the
+// channels informer is synced, and serves the last audit on orphansPath of admin.
+func (r *Reconciler) registerOrphanAudit(ctx context.Context, lifecycle *dispatcher.Lifecycle, admin *http.ServeMux, cfg *config.Config, flags *features.Store, hasSynced cache.InformerSynced) error {
+	logger := logging.FromContext(ctx)
+
+	// The deletion follows the orphan-audit-delete flag of every audit.
+	remover, ok := r.natssDispatcher.(dispatcher.DurableRemover)
+	if !ok && flags.Load().OrphanAuditDelete.Enabled() {
+		logger.Warn("The dispatcher transport cannot remove durables, the orphaned durables are only reported")
+	}
+	auditor := newOrphanAuditor(kubeclient.Get(ctx), stateNamespace(ctx), r.natsschannelLister, remover, cfg.OrphanAuditGracePeriod)
+	auditor.flags = flags
+	if namer, ok := r.natssDispatcher.(dispatcher.DurableNamer); ok {
+		auditor.namer = namer
+	}
+
+	// The audit removes durables through the connection, it stops before it.
+	audit := lifecycle.RunHook("orphan-audit", dispatcher.PrioritySubscriptions+1, func(ctx context.Context) error {
+		// Auditing before the informer is synced would report every durable as orphaned.
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+			return nil
+		}
+		auditor.run(ctx, cfg.OrphanAuditInterval)
+		return nil
+	})
+	if err := lifecycle.Register(audit); err != nil {
+		return err
+	}
+	admin.Handle(orphansPath, auditor)
+	return nil
+}
+
+// registerAdminServer registers the hook serving the endpoints of admin, for the operators and the
+// probes of the pod, on adminPort.
+func registerAdminServer(ctx context.Context, lifecycle *dispatcher.Lifecycle, admin *http.ServeMux) error {
+	logger := logging.FromContext(ctx)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", adminPort), Handler: admin}
+	return lifecycle.Register(dispatcher.Hook{
+		Name:     "admin-server",
+		Priority: dispatcher.PriorityAdminServer,
+		Start: func(context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				// The probes of the pod are served on it.
+				return fmt.Errorf("failed to serve the admin endpoints: %w", err)
+			}
+			go func() {
+				if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					logger.Errorw("Error serving the admin endpoints", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		Stop: server.Shutdown,
+	})
+}
+
 // reconcile performs the following steps
 // - update natss subscriptions
 // - set NatssChannel SubscribableStatus
 // - update host2channel map
 func (r *Reconciler) ReconcileKind(ctx context.Context, natssChannel *v1beta1.NatssChannel) pkgreconciler.Event {
+	ctx = r.reconcileObservability(ctx, natssChannel)
+	defer r.recordConditionTransitions(ctx, natssChannel)
+
 	// TODO update dispatcher API and use Channelable or NatssChannel.
 	c := toChannel(natssChannel)
+	r.applyDefaultDeadLetterSink(natssChannel, c)
+	if r.e2eProbe != nil && probe.IsChannel(natssChannel) {
+		r.e2eProbe.observe(ctx)
+	}
+
+	r.reconcileNamespaceConfig(ctx, natssChannel)
+	r.reconcileDeliveryOptions(ctx, natssChannel)
+	r.reconcileFanout(ctx, natssChannel)
+	r.reconcileEgress(ctx, natssChannel)
+	if setter, ok := r.natssDispatcher.(dispatcher.DistributionSetter); ok {
+		setter.SetDistribution(channelReference(natssChannel), natssChannel.Spec.Distribution)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.RedirectPolicySetter); ok {
+		setter.SetRedirectPolicy(channelReference(natssChannel), natssChannel.Spec.RedirectPolicy)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.OptionChangePolicySetter); ok {
+		setter.SetOptionChangePolicy(channelReference(natssChannel), natssChannel.Spec.OptionChangePolicy)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.MultiplexTargetSetter); ok {
+		setter.SetMultiplexTarget(channelReference(natssChannel), natssChannel.Annotations[messaging.MultiplexTargetAnnotationKey] == "true")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.StoragePressureSetter); ok {
+		setter.SetShedOnPressure(channelReference(natssChannel), natssChannel.Labels[messaging.ShedOnPressureLabelKey] == "true")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.FailureEventRecorder); ok {
+		// The events of the deliveries are recorded after the reconciliation, on a reference to
+		// the channel rather than on the object it updates.
+		setter.SetFailureEventRecorder(channelReference(natssChannel), controller.GetEventRecorder(ctx), objectReference(natssChannel))
+	}
+	if r.storageMonitor != nil {
+		r.storageMonitor.observe(ctx)
+	}
+	r.reconcileAudit(natssChannel)
+
+	if format := natssChannel.Spec.WireFormat; !dispatcher.SupportsWireFormat(r.natssDispatcher, format) {
+		err := fmt.Errorf("wire format %q is not supported by the dispatcher transport", format)
+		r.failSubscribers(natssChannel, err)
+		return pkgreconciler.NewEvent(corev1.EventTypeWarning, "WireFormatUnsupported", err.Error())
+	}
+
+	if err := r.reconcileCluster(natssChannel); err != nil {
+		r.failSubscribers(natssChannel, err)
+		return pkgreconciler.NewEvent(corev1.EventTypeWarning, "ClusterUnsupported", err.Error())
+	}
+
+	if err := r.reconcileEncryption(ctx, natssChannel); err != nil {
+		r.failSubscribers(natssChannel, err)
+		if err == errEncryptionUnsupported {
+			return pkgreconciler.NewEvent(corev1.EventTypeWarning, "EncryptionUnsupported", err.Error())
+		}
+		// The Secret is not watched, the channel is reconciled again with a backoff.
+		return err
+	}
+
+	// The events are delivered unchanged while the credentials of the registry cannot be read.
+	transcodeErr := r.reconcileAvroTranscode(ctx, natssChannel)
+
+	r.reconcileEphemeral(ctx, natssChannel)
+	r.reconcileConsumers(ctx, natssChannel)
+	r.reconcileOrdered(ctx, natssChannel)
+	r.reconcileColdStart(ctx, natssChannel)
+	invalidTimeouts := r.reconcileDeliveryTimeouts(ctx, natssChannel)
+	r.reconcileDurableNaming(ctx, natssChannel)
+	r.reconcileSubscriptionInit(natssChannel)
+	orphans, orphansPaused := r.reconcileOrphanedSubscribers(ctx, natssChannel)
 
 	// Try to subscribe.
 	failedSubscriptions, err := r.natssDispatcher.UpdateSubscriptions(ctx, c, false)
@@ -148,18 +627,68 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, natssChannel *v1beta1.Na
 		return err
 	}
 
-	natssChannel.Status.SubscribableStatus = r.createSubscribableStatus(natssChannel.Spec.Subscribers, failedSubscriptions)
-	if len(failedSubscriptions) > 0 {
-		var b strings.Builder
-		for _, subError := range failedSubscriptions {
-			b.WriteString("\n")
-			b.WriteString(subError.Error())
+	r.reconcileReplays(ctx, natssChannel, c.Spec.Subscribers)
+	r.reconcileHibernation(natssChannel)
+	r.reconcilePauses(ctx, natssChannel)
+	r.watchEndpoints(natssChannel)
+	r.reconcileInsecureDeliveries(ctx, natssChannel)
+	r.reconcileProvisioning(natssChannel)
+	r.reconcileConnection(natssChannel)
+	r.reconcileOfflineBuffer(natssChannel)
+
+	// The failed subscriptions are keyed by the subscribers of c, which carry the defaults.
+	natssChannel.Status.SubscribableStatus = r.createSubscribableStatus(c.Spec.Subscribers, failedSubscriptions)
+	r.reportReplays(natssChannel)
+	r.reportPauses(natssChannel)
+	r.reportOrphanedSubscribers(natssChannel, orphans, orphansPaused)
+	reportInvalidDeliveryTimeouts(natssChannel, invalidTimeouts)
+	r.reportUnreachableEndpoints(natssChannel)
+	r.reportEphemeral(natssChannel)
+	r.reportOptionChanges(natssChannel)
+	r.reportOrdered(natssChannel)
+	r.reportDurableMigrations(natssChannel)
+	var b strings.Builder
+	for _, subError := range failedSubscriptions {
+		if isNotProvisioned(subError) {
+			// Reported by the ChannelNotProvisionedOnServer condition.
+			continue
+		}
+		if isFanoutLimitExceeded(subError) {
+			// Reported by the FanoutAboveLimit condition.
+			continue
+		}
+		if isOrphanedSubscriber(subError) {
+			// Reported by the status of the subscriber.
+			continue
 		}
+		b.WriteString("\n")
+		b.WriteString(subError.Error())
+	}
+	if b.Len() > 0 {
 		errMsg := b.String()
 		logging.FromContext(ctx).Error(errMsg)
 		return fmt.Errorf(errMsg)
 	}
 
+	if err := r.processChannels(ctx); err != nil {
+		return err
+	}
+	// The Secret is not watched, the channel is reconciled again with a backoff.
+	return transcodeErr
+}
+
+// failSubscribers reports all the subscribers of natssChannel as failed with err.
+func (r *Reconciler) failSubscribers(natssChannel *v1beta1.NatssChannel, err error) {
+	failedSubscriptions := make(map[eventingduckv1.SubscriberSpec]error, len(natssChannel.Spec.Subscribers))
+	for _, sub := range natssChannel.Spec.Subscribers {
+		failedSubscriptions[sub] = err
+	}
+	natssChannel.Status.SubscribableStatus = r.createSubscribableStatus(natssChannel.Spec.Subscribers, failedSubscriptions)
+}
+
+// processChannels updates the host to channel map of the dispatcher with the ready channels and
+// persists it when enabled.
+func (r *Reconciler) processChannels(ctx context.Context) error {
 	natssChannels, err := r.natsschannelLister.List(labels.Everything())
 	if err != nil {
 		logging.FromContext(ctx).Error("Error listing natss channels")
@@ -178,22 +707,184 @@ func (r *Reconciler) ReconcileKind(ctx context.Context, natssChannel *v1beta1.Na
 		return err
 	}
 
+	if mapper, ok := r.natssDispatcher.(dispatcher.HostToChannelMapper); ok && r.hostMapStore != nil {
+		// The persisted map only speeds up restarts, failing to write it must not fail the reconciliation.
+		if err := r.hostMapStore.persist(ctx, mapper.HostToChannelMap()); err != nil {
+			logging.FromContext(ctx).Warnw("Error persisting host to channel map", zap.Error(err))
+		}
+	}
+
 	return nil
 }
 
-func (r *Reconciler) FinalizeKind(ctx context.Context, c *v1beta1.NatssChannel) pkgreconciler.Event {
+// loadHostToChannelMap makes the dispatcher serve the persisted host to channel map until the
+// channels informer is synced, then replaces it with the map built from the informer, which
+// invalidates the hosts of the channels deleted in the meantime.
+func (r *Reconciler) loadHostToChannelMap(ctx context.Context, hasSynced cache.InformerSynced) {
+	logger := logging.FromContext(ctx)
+	mapper, ok := r.natssDispatcher.(dispatcher.HostToChannelMapper)
+	if !ok {
+		return
+	}
+
+	hcMap, err := r.hostMapStore.load(ctx)
+	if err != nil {
+		logger.Warnw("Error loading the persisted host to channel map", zap.Error(err))
+	} else if len(hcMap) > 0 {
+		mapper.LoadStaleHostToChannelMap(hcMap)
+	}
 
-	if _, err := r.natssDispatcher.UpdateSubscriptions(ctx, toChannel(c), true); err != nil {
+	go func() {
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+			return
+		}
+		if err := r.processChannels(ctx); err != nil {
+			logger.Errorw("Error processing channels after the informer sync", zap.Error(err))
+		}
+	}()
+}
+
+func (r *Reconciler) FinalizeKind(ctx context.Context, c *v1beta1.NatssChannel) pkgreconciler.Event {
+	ctx = withChannelLogLevel(ctx, c)
+	if err := r.finalizeSubscriptions(ctx, c); err != nil {
 		logging.FromContext(ctx).Errorw("Error updating subscriptions", zap.Any("channel", c), zap.Error(err))
-		return err
+		// The finalizer is kept, and the channel finalized again with a backoff until NATSS
+		// removes its durables.
+		return fmt.Errorf("%w", pkgreconciler.NewEvent(corev1.EventTypeWarning, "FinalizeRetrying",
+			"Failed to remove the durable subscriptions of the channel, retrying: %v", err))
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.ResponseCodePolicySetter); ok {
+		setter.SetResponseCodePolicy(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.DeliveryLimitsSetter); ok {
+		setter.SetDeliveryLimits(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.DeliveryOptionsSetter); ok {
+		setter.SetDeliveryOptions(channelReference(c), v1beta1.DeliveryOptions{})
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.FanoutLimitSetter); ok {
+		setter.SetFanoutLimit(channelReference(c), 0)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.EgressShareSetter); ok {
+		setter.SetEgressMinShare(channelReference(c), 0)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.DistributionSetter); ok {
+		setter.SetDistribution(channelReference(c), "")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.RedirectPolicySetter); ok {
+		setter.SetRedirectPolicy(channelReference(c), "")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.OptionChangePolicySetter); ok {
+		setter.SetOptionChangePolicy(channelReference(c), "")
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.MultiplexTargetSetter); ok {
+		setter.SetMultiplexTarget(channelReference(c), false)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.ConsumersSetter); ok {
+		setter.SetConsumers(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.OrderedSetter); ok {
+		setter.SetOrdered(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.EphemeralSetter); ok {
+		setter.SetEphemeral(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.ColdStartSetter); ok {
+		setter.SetColdStart(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.DeliveryTimeoutSetter); ok {
+		setter.SetDeliveryTimeouts(channelReference(c), nil)
+	}
+	if namer, ok := r.natssDispatcher.(dispatcher.DurableNamer); ok {
+		namer.SetDurableMigration(channelReference(c), "")
+		namer.WatchDurableMigrations(channelReference(c), nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.StoragePressureSetter); ok {
+		setter.SetShedOnPressure(channelReference(c), false)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.FailureEventRecorder); ok {
+		setter.SetFailureEventRecorder(channelReference(c), nil, nil)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.ObservabilitySetter); ok {
+		setter.SetObservability(channelReference(c), v1beta1.Observability{})
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.EncryptionKeySetter); ok {
+		setter.SetEncryptionKeys(channelReference(c), nil)
+	}
+	if binder, ok := r.natssDispatcher.(dispatcher.ClusterBinder); ok {
+		binder.BindCluster(channelReference(c), v1beta1.NatssChannelCluster{})
+	}
+	if auditor, ok := r.natssDispatcher.(dispatcher.Auditor); ok {
+		auditor.SetAuditSink(channelReference(c), nil, nil)
+	}
+	if transcoder, ok := r.natssDispatcher.(dispatcher.AvroTranscoder); ok {
+		transcoder.SetAvroRegistry(channelReference(c), nil)
+	}
+	if hibernator, ok := r.natssDispatcher.(dispatcher.Hibernator); ok {
+		hibernator.WatchHibernation(channelReference(c), nil)
+	}
+	if lazy, ok := r.natssDispatcher.(dispatcher.LazySubscriber); ok {
+		lazy.SetSubscriptionInit(channelReference(c), "")
+		lazy.WatchSubscriptionInit(channelReference(c), nil)
+	}
+	if pauser, ok := r.natssDispatcher.(dispatcher.UnhealthyPauser); ok {
+		pauser.WatchPauses(channelReference(c), nil)
+	}
+	if pauser, ok := r.natssDispatcher.(dispatcher.OrphanedSubscriberPauser); ok {
+		pauser.PauseOrphanedSubscribers(channelReference(c), nil)
+	}
+	r.orphanedSubscribers.Delete(types.NamespacedName{Namespace: c.Namespace, Name: c.Name})
+	if reporter, ok := r.natssDispatcher.(dispatcher.InsecureDeliveryReporter); ok {
+		reporter.WatchInsecureDeliveries(channelReference(c), nil)
+	}
+	if validator, ok := r.natssDispatcher.(dispatcher.EndpointValidator); ok {
+		validator.WatchEndpoints(channelReference(c), nil)
+	}
+	if reporter, ok := r.natssDispatcher.(dispatcher.ProvisioningReporter); ok {
+		reporter.WatchProvisioning(channelReference(c), nil)
+	}
+	if reporter, ok := r.natssDispatcher.(dispatcher.ConnectionReporter); ok {
+		reporter.WatchConnection(channelReference(c), nil)
 	}
 	return nil
 }
 
+func channelReference(nc *v1beta1.NatssChannel) channel.ChannelReference {
+	return channel.ChannelReference{Namespace: nc.Namespace, Name: nc.Name}
+}
+
+// objectReference returns the reference to nc the events are recorded on.
+func objectReference(nc *v1beta1.NatssChannel) *corev1.ObjectReference {
+	gvk := nc.GetGroupVersionKind()
+	return &corev1.ObjectReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  nc.Namespace,
+		Name:       nc.Name,
+		UID:        nc.UID,
+	}
+}
+
+// recordConditionTransitions emits an event for every subscriber of natssChannel whose readiness changed
+// compared to the version of the object stored in the informer cache. The channel conditions are owned
+// by the controller which reports their transitions.
+func (r *Reconciler) recordConditionTransitions(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	var before []eventingduckv1.SubscriberStatus
+	if original, err := r.natsschannelLister.NatssChannels(natssChannel.Namespace).Get(natssChannel.Name); err == nil {
+		before = original.Status.Subscribers
+	}
+	r.conditionRecorder.RecordSubscribers(ctx, natssChannel, before, natssChannel.Status.Subscribers)
+}
+
 // createSubscribableStatus creates the SubscribableStatus based on the failedSubscriptions
 // checks for each subscriber on the natss channel if there is a failed subscription on natss side
 // if there is no failed subscription => set ready status
+// The failed subscriptions are matched by UID, their URIs being the target of the deliveries only.
 func (r *Reconciler) createSubscribableStatus(subscribers []eventingduckv1.SubscriberSpec, failedSubscriptions map[eventingduckv1.SubscriberSpec]error) eventingduckv1.SubscribableStatus {
+	failed := make(map[types.UID]error, len(failedSubscriptions))
+	for sub, err := range failedSubscriptions {
+		failed[sub.UID] = err
+	}
 	subscriberStatus := make([]eventingduckv1.SubscriberStatus, 0)
 	for _, sub := range subscribers {
 		status := eventingduckv1.SubscriberStatus{
@@ -202,7 +893,7 @@ func (r *Reconciler) createSubscribableStatus(subscribers []eventingduckv1.Subsc
 			Ready:              corev1.ConditionTrue,
 		}
 
-		if err, ok := failedSubscriptions[sub]; ok {
+		if err, ok := failed[sub.UID]; ok {
 			status.Ready = corev1.ConditionFalse
 			status.Message = err.Error()
 		}
@@ -18,14 +18,22 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	clientgotesting "k8s.io/client-go/testing"
+	"knative.dev/pkg/apis"
 	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
 	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/service/fake"
 	"knative.dev/pkg/configmap"
@@ -34,15 +42,23 @@ import (
 	fakedynamicclient "knative.dev/pkg/injection/clients/dynamicclient/fake"
 	"knative.dev/pkg/logging"
 	. "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	tracingconfig "knative.dev/pkg/tracing/config"
 
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
 	fakeeventingclient "knative.dev/eventing/pkg/client/injection/client/fake"
+	_ "knative.dev/eventing/pkg/client/injection/informers/messaging/v1/subscription/fake"
 
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
 	"knative.dev/eventing-natss/pkg/client/injection/client"
 	fakeclientset "knative.dev/eventing-natss/pkg/client/injection/client/fake"
 	_ "knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel/fake"
 	natsschannelreconciler "knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	"knative.dev/eventing-natss/pkg/config"
 	"knative.dev/eventing-natss/pkg/dispatcher"
 	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	"knative.dev/eventing-natss/pkg/reconciler/events"
 	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
 
 	"go.uber.org/zap"
@@ -92,6 +108,7 @@ func TestAllCases(t *testing.T) {
 				{
 					Object: reconciletesting.NewNatssChannel(ncName, testNS,
 						reconciletesting.WithNatssChannelChannelServiceReady(),
+						reconciletesting.WithNatssChannelNatssConnectionReady(),
 						reconciletesting.WithNatssChannelServiceReady(),
 						reconciletesting.WithNatssChannelEndpointsReady(),
 						reconciletesting.WithNatssChannelDeploymentReady(),
@@ -101,6 +118,71 @@ func TestAllCases(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "legacy finalizer migrated",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithReady,
+					reconciletesting.WithNatssChannelFinalizers("natss-ch-dispatcher", "other"),
+				),
+			},
+			// A single patch replaces the legacy finalizer, the channel being reconciled again
+			// once patched.
+			WantPatches: []clientgotesting.PatchActionImpl{
+				makeFinalizersPatch(testNS, ncName, "other", finalizerName),
+			},
+		},
+		{
+			Name: "legacy finalizer of a deleted channel removed once finalized",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithReady,
+					reconciletesting.WithNatssChannelDeleted,
+					reconciletesting.WithNatssChannelFinalizers("natsschannels.messaging.knative.dev"),
+				),
+			},
+			WantPatches: []clientgotesting.PatchActionImpl{
+				makeFinalizersPatch(testNS, ncName),
+			},
+		},
+		{
+			Name: "unsupported wire format",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithReady,
+					reconciletesting.WithNatssChannelWireFormat(v1beta1.WireFormatNATSBinding),
+					reconciletesting.WithNatssChannelSubscribers(t, "http://example.com"),
+				),
+			},
+			WantPatches: []clientgotesting.PatchActionImpl{
+				makeFinalizerPatch(testNS, ncName),
+			},
+			WantEvents: []string{
+				finalizerUpdatedEvent,
+				Eventf(corev1.EventTypeWarning, "SubscriberReadyFalse", `Subscriber "" is False: wire format "nats-binding" is not supported by the dispatcher transport`),
+				Eventf(corev1.EventTypeWarning, "WireFormatUnsupported", `wire format "nats-binding" is not supported by the dispatcher transport`),
+			},
+			WantErr: false,
+			WantStatusUpdates: []clientgotesting.UpdateActionImpl{
+				{
+					Object: reconciletesting.NewNatssChannel(ncName, testNS,
+						reconciletesting.WithNatssChannelChannelServiceReady(),
+						reconciletesting.WithNatssChannelNatssConnectionReady(),
+						reconciletesting.WithNatssChannelServiceReady(),
+						reconciletesting.WithNatssChannelEndpointsReady(),
+						reconciletesting.WithNatssChannelDeploymentReady(),
+						reconciletesting.Addressable(),
+						reconciletesting.WithReady,
+						reconciletesting.WithNatssChannelWireFormat(v1beta1.WireFormatNATSBinding),
+						reconciletesting.WithNatssChannelSubscribers(t, "http://example.com"),
+						reconciletesting.WithNatssChannelSubscribableStatus(corev1.ConditionFalse, `wire format "nats-binding" is not supported by the dispatcher transport`),
+					),
+				},
+			},
+		},
 	}
 
 	table.Test(t, reconciletesting.MakeFactory(func(ctx context.Context, listers *reconciletesting.Listers) controller.Reconciler {
@@ -123,6 +205,35 @@ func (l *failOnFatalAndErrorLogger) Fatal(msg string, fields ...zap.Field) {
 	l.t.Fatalf("Fatal() called - msg: %s - fields: %v", msg, fields)
 }
 
+func TestNewControllerJetStreamProbes(t *testing.T) {
+	os.Setenv("POD_NAME", "testpod")
+	os.Setenv("CONTAINER_NAME", "testcontainer")
+
+	// It runs before the other tests building the controller, whose dispatchers keep the ports.
+	newControllerWithTransport(t, dispatcher.JetStreamTransport)
+
+	// No NATS server is running: the dispatcher is alive while it connects, but not ready.
+	for path, want := range map[string]int{
+		healthzPath: http.StatusOK,
+		readyzPath:  http.StatusServiceUnavailable,
+	} {
+		url := fmt.Sprintf("http://localhost:%d%s", adminPort, path)
+		var resp *http.Response
+		err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+			var err error
+			resp, err = http.Get(url)
+			return err == nil, nil
+		})
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
 func TestNewController(t *testing.T) {
 	os.Setenv("POD_NAME", "testpod")
 	os.Setenv("CONTAINER_NAME", "testcontainer")
@@ -140,7 +251,57 @@ func TestNewController(t *testing.T) {
 	ctx = injection.WithConfig(ctx, cfg)
 	ctx, _ = injection.Fake.SetupInformers(ctx, cfg)
 
-	NewController(ctx, configmap.NewStaticWatcher())
+	NewController(ctx, configmap.NewStaticWatcher(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.FeaturesConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName()}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: tracingconfig.ConfigName}},
+	))
+}
+
+func TestNewControllerTransport(t *testing.T) {
+	os.Setenv("POD_NAME", "testpod")
+	os.Setenv("CONTAINER_NAME", "testcontainer")
+
+	selected := false
+	dispatcher.RegisterTransport("test", func(dispatcher.Args) (dispatcher.NatssDispatcher, error) {
+		selected = true
+		return dispatchertesting.NewDispatcherDoNothing(), nil
+	})
+
+	newControllerWithTransport(t, "test")
+	if !selected {
+		t.Error("the transport configured in config-natss was not used")
+	}
+}
+
+// newControllerWithTransport builds the controller with transport configured in config-natss.
+func newControllerWithTransport(t *testing.T, transport string) {
+	ctx, cancel := context.WithCancel(logging.WithLogger(context.Background(), zap.NewNop().Sugar()))
+	t.Cleanup(cancel)
+	ctx, _ = fakeeventingclient.With(ctx)
+	ctx, _ = fakedynamicclient.With(ctx, runtime.NewScheme())
+	ctx, _ = fakeclientset.With(ctx)
+	cfg := &rest.Config{}
+	ctx = injection.WithConfig(ctx, cfg)
+	ctx, _ = injection.Fake.SetupInformers(ctx, cfg)
+	// Replace the kube client set up by the informers with one holding the configuration.
+	ctx, _ = fakekubeclient.With(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapName,
+			Namespace: system.Namespace(),
+		},
+		Data: map[string]string{
+			config.TransportKey: transport,
+		},
+	})
+
+	NewController(ctx, configmap.NewStaticWatcher(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: config.FeaturesConfigMapName}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName()}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: tracingconfig.ConfigName}},
+	))
 }
 
 func TestFailedNatssSubscription(t *testing.T) {
@@ -155,6 +316,7 @@ func TestFailedNatssSubscription(t *testing.T) {
 			Objects: []runtime.Object{
 				reconciletesting.NewNatssChannel(ncName, testNS,
 					reconciletesting.WithNatssChannelChannelServiceReady(),
+					reconciletesting.WithNatssChannelNatssConnectionReady(),
 					reconciletesting.WithNatssChannelServiceReady(),
 					reconciletesting.WithNatssChannelEndpointsReady(),
 					reconciletesting.WithNatssChannelDeploymentReady(),
@@ -167,12 +329,14 @@ func TestFailedNatssSubscription(t *testing.T) {
 			Key: ncKey,
 			WantEvents: []string{
 				finalizerUpdatedEvent,
+				Eventf(corev1.EventTypeWarning, "SubscriberReadyFalse", `Subscriber "" is False: ups`),
 				Eventf(corev1.EventTypeWarning, "InternalError", "\nups"),
 			},
 			WantStatusUpdates: []clientgotesting.UpdateActionImpl{
 				{
 					Object: reconciletesting.NewNatssChannel(ncName, testNS,
 						reconciletesting.WithNatssChannelChannelServiceReady(),
+						reconciletesting.WithNatssChannelNatssConnectionReady(),
 						reconciletesting.WithNatssChannelServiceReady(),
 						reconciletesting.WithNatssChannelEndpointsReady(),
 						reconciletesting.WithNatssChannelDeploymentReady(),
@@ -199,11 +363,81 @@ func TestFailedNatssSubscription(t *testing.T) {
 	}))
 }
 
+// dispatcherNatssDown fails the finalizations like a dispatcher unable to reach NATSS.
+type dispatcherNatssDown struct {
+	dispatchertesting.DispatcherDoNothing
+}
+
+func (d *dispatcherNatssDown) UpdateSubscriptions(ctx context.Context, channel *messagingv1.Channel, isFinalizer bool) (map[eventingduckv1.SubscriberSpec]error, error) {
+	if isFinalizer {
+		return nil, errors.New("no Connection to NATSS")
+	}
+	return d.DispatcherDoNothing.UpdateSubscriptions(ctx, channel, isFinalizer)
+}
+
+func TestFinalizeNatssDown(t *testing.T) {
+	ncKey := testNS + "/" + ncName
+
+	table := TableTest{
+		{
+			Name: "finalizer kept while the durables cannot be removed",
+			Key:  ncKey,
+			Objects: []runtime.Object{
+				reconciletesting.NewNatssChannel(ncName, testNS,
+					reconciletesting.WithReady,
+					reconciletesting.WithNatssChannelDeleted,
+					reconciletesting.WithNatssChannelFinalizers(finalizerName),
+					reconciletesting.WithNatssChannelSubscribers(t, "http://example.com"),
+				),
+			},
+			WantEvents: []string{
+				Eventf(corev1.EventTypeWarning, "FinalizeRetrying", "Failed to remove the durable subscriptions of the channel, retrying: no Connection to NATSS"),
+			},
+			WantErr: true,
+		},
+	}
+
+	table.Test(t, reconciletesting.MakeFactory(func(ctx context.Context, listers *reconciletesting.Listers) controller.Reconciler {
+		return createReconciler(ctx, listers, func() dispatcher.NatssDispatcher {
+			return &dispatcherNatssDown{}
+		})
+	}))
+}
+
+func TestCreateSubscribableStatusByUID(t *testing.T) {
+	subscribers := []eventingduckv1.SubscriberSpec{
+		{UID: "a", SubscriberURI: apis.HTTP("example.com")},
+		{UID: "b", SubscriberURI: apis.HTTP("example.com")},
+	}
+	// The failed subscription is reported with another URI than the one of the spec, as when its
+	// path or query changed.
+	failed := map[eventingduckv1.SubscriberSpec]error{
+		{UID: "b", SubscriberURI: &apis.URL{Scheme: "http", Host: "example.com", Path: "/v1/events", RawQuery: "tenant=a"}}: errors.New("ups"),
+	}
+
+	status := (&Reconciler{}).createSubscribableStatus(subscribers, failed)
+	if got := status.Subscribers[0].Ready; got != corev1.ConditionTrue {
+		t.Errorf("subscriber a is %s, want True", got)
+	}
+	if got := status.Subscribers[1]; got.Ready != corev1.ConditionFalse || got.Message != "ups" {
+		t.Errorf("subscriber b is %s: %q, want False: ups", got.Ready, got.Message)
+	}
+}
+
 func makeFinalizerPatch(namespace, name string) clientgotesting.PatchActionImpl {
+	return makeFinalizersPatch(namespace, name, finalizerName)
+}
+
+// makeFinalizersPatch returns the patch setting the finalizers of the channel.
+func makeFinalizersPatch(namespace, name string, finalizers ...string) clientgotesting.PatchActionImpl {
 	action := clientgotesting.PatchActionImpl{}
 	action.Name = name
 	action.Namespace = namespace
-	patch := `{"metadata":{"finalizers":["` + finalizerName + `"],"resourceVersion":""}}`
+	quoted := make([]string, 0, len(finalizers))
+	for _, f := range finalizers {
+		quoted = append(quoted, `"`+f+`"`)
+	}
+	patch := `{"metadata":{"finalizers":[` + strings.Join(quoted, ",") + `],"resourceVersion":""}}`
 	action.Patch = []byte(patch)
 	return action
 }
@@ -214,7 +448,7 @@ func createReconciler(
 	dispatcherFactory func() dispatcher.NatssDispatcher,
 ) controller.Reconciler {
 
-	return natsschannelreconciler.NewReconciler(
+	r := natsschannelreconciler.NewReconciler(
 		ctx,
 		logging.FromContext(ctx),
 		client.Get(ctx),
@@ -224,9 +458,30 @@ func createReconciler(
 			natssDispatcher:    dispatcherFactory(),
 			natsschannelLister: listers.GetNatssChannelLister(),
 			natssClientSet:     client.Get(ctx),
+			conditionRecorder:  events.NewConditionRecorder(events.DefaultDedupWindow),
 		},
 		controller.Options{
 			FinalizerName: finalizerName,
 		},
 	)
+	return &finalizerMigration{
+		leaderAwareReconciler: r.(leaderAwareReconciler),
+		lister:                listers.GetNatssChannelLister(),
+		client:                client.Get(ctx),
+	}
+}
+
+func TestObjectReference(t *testing.T) {
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	nc.UID = "channel-uid"
+	want := &corev1.ObjectReference{
+		APIVersion: "messaging.knative.dev/v1beta1",
+		Kind:       "NatssChannel",
+		Namespace:  testNS,
+		Name:       ncName,
+		UID:        "channel-uid",
+	}
+	if diff := cmp.Diff(want, objectReference(nc)); diff != "" {
+		t.Errorf("unexpected reference (-want, +got): %s", diff)
+	}
 }
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/loglevel"
+)
+
+// reconcileObservability applies to natssChannel the log level and the trace sampling set by its
+// annotations, returning ctx logging the reconciliation of the channel at that level. The invalid
+// annotations, which the webhook rejects, are ignored.
+func (r *Reconciler) reconcileObservability(ctx context.Context, natssChannel *v1beta1.NatssChannel) context.Context {
+	o, err := v1beta1.ObservabilityFromAnnotations(natssChannel.Annotations)
+	if err != nil {
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, "ObservabilityInvalid",
+			"Ignoring the invalid observability annotations of the channel: %v", err)
+	}
+	if setter, ok := r.natssDispatcher.(dispatcher.ObservabilitySetter); ok {
+		setter.SetObservability(channelReference(natssChannel), o)
+	}
+	return withChannelLogLevel(ctx, natssChannel)
+}
+
+// withChannelLogLevel returns ctx logging at the level set by the annotation of natssChannel, if
+// any.
+func withChannelLogLevel(ctx context.Context, natssChannel *v1beta1.NatssChannel) context.Context {
+	o, _ := v1beta1.ObservabilityFromAnnotations(natssChannel.Annotations)
+	if o.LogLevel == nil {
+		return ctx
+	}
+	logger := loglevel.AtLevel(logging.FromContext(ctx).Desugar(), *o.LogLevel)
+	return logging.WithLogger(ctx, logger.Sugar())
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeObservabilitySetter struct {
+	dispatcher.NatssDispatcher
+
+	observability v1beta1.Observability
+}
+
+var _ dispatcher.ObservabilitySetter = (*fakeObservabilitySetter)(nil)
+
+func (f *fakeObservabilitySetter) SetObservability(_ eventingchannels.ChannelReference, o v1beta1.Observability) {
+	f.observability = o
+}
+
+func TestReconcileObservability(t *testing.T) {
+	debug := zapcore.DebugLevel
+	always := 1.0
+	tests := map[string]struct {
+		annotations map[string]string
+		want        v1beta1.Observability
+		wantDebug   bool
+		wantEvent   string
+	}{
+		"no annotation": {},
+		"annotations": {
+			annotations: map[string]string{
+				messaging.LogLevelAnnotationKey:      "debug",
+				messaging.TraceSamplingAnnotationKey: "1.0",
+			},
+			want:      v1beta1.Observability{LogLevel: &debug, TraceSampling: &always},
+			wantDebug: true,
+		},
+		"invalid annotation ignored": {
+			annotations: map[string]string{
+				messaging.LogLevelAnnotationKey:      "debug",
+				messaging.TraceSamplingAnnotationKey: "always",
+			},
+			want:      v1beta1.Observability{LogLevel: &debug},
+			wantDebug: true,
+			wantEvent: "Warning ObservabilityInvalid",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			setter := &fakeObservabilitySetter{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+			r := &Reconciler{natssDispatcher: setter}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+			ctx = logging.WithLogger(ctx, zap.NewNop().Sugar())
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Annotations = tc.annotations
+			ctx = r.reconcileObservability(ctx, nc)
+
+			if diff := cmp.Diff(tc.want, setter.observability); diff != "" {
+				t.Errorf("unexpected observability (-want, +got): %s", diff)
+			}
+			if got := logging.FromContext(ctx).Desugar().Core().Enabled(zapcore.DebugLevel); got != tc.wantDebug {
+				t.Errorf("the reconciliation logs at the debug level: %t, want %t", got, tc.wantDebug)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileOfflineBuffer reports the events of natssChannel the receiver holds in memory while
+// the connection to NATSS is lost. The channel is reconciled again through the notifier of its
+// connection when it starts or stops buffering.
+func (r *Reconciler) reconcileOfflineBuffer(natssChannel *v1beta1.NatssChannel) {
+	reporter, ok := r.natssDispatcher.(dispatcher.OfflineBufferReporter)
+	if !ok {
+		natssChannel.Status.ClearBufferingOfflineCondition()
+		return
+	}
+	if status := reporter.OfflineBufferStatus(channelReference(natssChannel)); status.Events > 0 {
+		natssChannel.Status.MarkBufferingOffline(status.Events, status.Since)
+	} else {
+		natssChannel.Status.ClearBufferingOfflineCondition()
+	}
+}
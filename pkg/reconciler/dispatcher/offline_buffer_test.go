@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeOfflineBufferReporter struct {
+	dispatcher.NatssDispatcher
+
+	status dispatcher.OfflineBufferStatus
+}
+
+func (f *fakeOfflineBufferReporter) OfflineBufferStatus(eventingchannels.ChannelReference) dispatcher.OfflineBufferStatus {
+	return f.status
+}
+
+func TestReconcileOfflineBuffer(t *testing.T) {
+	since := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		status      dispatcher.OfflineBufferStatus
+		unsupported bool
+		want        bool
+	}{
+		"buffering": {
+			status: dispatcher.OfflineBufferStatus{Events: 42, Since: since},
+			want:   true,
+		},
+		"not buffering": {},
+		"unsupported": {
+			unsupported: true,
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			r := &Reconciler{natssDispatcher: &fakeOfflineBufferReporter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				status:          tc.status,
+			}}
+			if tc.unsupported {
+				r.natssDispatcher = dispatchertesting.NewDispatcherDoNothing()
+			}
+
+			// The channel was buffering when last reconciled.
+			nc := reconciletesting.NewNatssChannel(ncName, testNS)
+			nc.Status.MarkBufferingOffline(1, since)
+			r.reconcileOfflineBuffer(nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionBufferingOffline)
+			switch {
+			case !tc.want && cond != nil:
+				t.Errorf("condition = %+v, want none", cond)
+			case tc.want && (cond == nil || !strings.Contains(cond.Message, "42 events") || !strings.Contains(cond.Message, "2020-11-01T09:00:00Z")):
+				t.Errorf("condition = %+v, want 42 events buffered since 2020-11-01T09:00:00Z", cond)
+			}
+		})
+	}
+}
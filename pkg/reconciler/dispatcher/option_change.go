@@ -0,0 +1,46 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// optionsNotAppliedReason prefixes the message of the subscribers whose durables do not apply
+// some of the changes of their options.
+const optionsNotAppliedReason = "OptionsNotApplied"
+
+// reportOptionChanges shows the changes of the options the durables of the subscribers do not
+// apply in their status, as the option change policy of the channel tells.
+func (r *Reconciler) reportOptionChanges(natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.OptionChangePolicySetter)
+	if !ok {
+		return
+	}
+	channel := channelReference(natssChannel)
+	for i, status := range natssChannel.Status.Subscribers {
+		if status.Ready != corev1.ConditionTrue || status.Message != "" {
+			continue
+		}
+		if notApplied := setter.NotAppliedOptions(channel, status.UID); notApplied != "" {
+			natssChannel.Status.Subscribers[i].Message = optionsNotAppliedReason + ": " + notApplied
+		}
+	}
+}
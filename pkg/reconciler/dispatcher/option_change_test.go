@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeOptionChangePolicySetter struct {
+	dispatcher.NatssDispatcher
+
+	policy     v1beta1.OptionChangePolicy
+	notApplied map[types.UID]string
+}
+
+var _ dispatcher.OptionChangePolicySetter = (*fakeOptionChangePolicySetter)(nil)
+
+func (f *fakeOptionChangePolicySetter) SetOptionChangePolicy(_ eventingchannels.ChannelReference, policy v1beta1.OptionChangePolicy) {
+	f.policy = policy
+}
+
+func (f *fakeOptionChangePolicySetter) NotAppliedOptions(_ eventingchannels.ChannelReference, subscription types.UID) string {
+	return f.notApplied[subscription]
+}
+
+func TestReportOptionChanges(t *testing.T) {
+	const ignored = "start position changed from new-only to all-available, not applied to the existing durable"
+	setter := &fakeOptionChangePolicySetter{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		notApplied:      map[types.UID]string{"ignored": ignored, "failed": ignored, "ephemeral": ignored},
+	}
+	r := &Reconciler{natssDispatcher: setter}
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{
+		{UID: "applied", Ready: corev1.ConditionTrue},
+		{UID: "ignored", Ready: corev1.ConditionTrue},
+		{UID: "failed", Ready: corev1.ConditionFalse, Message: "failed"},
+		// The messages of the other reasons take precedence.
+		{UID: "ephemeral", Ready: corev1.ConditionTrue, Message: ephemeralReason},
+	}
+	r.reportOptionChanges(nc)
+
+	want := map[types.UID]string{
+		"applied":   "",
+		"ignored":   optionsNotAppliedReason + ": " + ignored,
+		"failed":    "failed",
+		"ephemeral": ephemeralReason,
+	}
+	got := make(map[types.UID]string)
+	for _, status := range nc.Status.Subscribers {
+		got[status.UID] = status.Message
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("messages (-want, +got) = %s", diff)
+	}
+}
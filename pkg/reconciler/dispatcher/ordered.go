@@ -0,0 +1,130 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// orderedReason prefixes the message of the subscribers delivered in order.
+const orderedReason = "Ordered"
+
+// isNatssChannelOrdered tells whether obj is a Subscription to a NatssChannel with the
+// natss.messaging.knative.dev/delivery-order annotation.
+func isNatssChannelOrdered(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.OrderedAnnotationKey]
+	return ok
+}
+
+// subscriptionOrdered tells whether sub has the natss.messaging.knative.dev/delivery-order
+// annotation set to "ordered".
+func subscriptionOrdered(sub *messagingv1.Subscription) bool {
+	return sub.Annotations[messaging.OrderedAnnotationKey] == string(v1beta1.DeliveryOrdered)
+}
+
+// reconcileOrdered makes the dispatcher deliver in order the events of the subscribers of
+// natssChannel whose Subscription has the natss.messaging.knative.dev/delivery-order annotation
+// set to "ordered", and records the subscriptions switching to or from it.
+func (r *Reconciler) reconcileOrdered(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.OrderedSetter)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	subscribers := make(map[types.UID]bool, len(natssChannel.Spec.Subscribers))
+	for _, spec := range natssChannel.Spec.Subscribers {
+		subscribers[spec.UID] = true
+	}
+
+	channel := channelReference(natssChannel)
+	channelOrdered := deliveredInOrder(natssChannel)
+	ordered := make(map[types.UID]bool)
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name || !subscribers[sub.UID] {
+			continue
+		}
+		// The Subscriptions whose annotation was removed are delivered as their channel again.
+		value, ok := sub.Annotations[messaging.OrderedAnnotationKey]
+		switch {
+		case ok && value != string(v1beta1.DeliveryOrdered) && value != string(v1beta1.DeliveryUnordered):
+			recorder.Eventf(sub, corev1.EventTypeWarning, "DeliveryOrderInvalid",
+				"Invalid %s annotation %q, expected %q or %q, the events are delivered as those of the channel",
+				messaging.OrderedAnnotationKey, value, v1beta1.DeliveryOrdered, v1beta1.DeliveryUnordered)
+		case value == string(v1beta1.DeliveryOrdered) && natssChannel.Spec.Distribution == v1beta1.DistributionWorkQueue:
+			recorder.Event(sub, corev1.EventTypeWarning, "DeliveryOrderIgnored",
+				"The events are not delivered in order, the members of a work queue share its queue group")
+			continue
+		case value == string(v1beta1.DeliveryUnordered) && channelOrdered:
+			recorder.Event(sub, corev1.EventTypeWarning, "DeliveryOrderIgnored",
+				"The events are delivered in order, as those of the channel")
+		case value == string(v1beta1.DeliveryOrdered):
+			ordered[sub.UID] = true
+			if !channelOrdered && !setter.Ordered(channel, sub.UID) {
+				recorder.Event(sub, corev1.EventTypeNormal, "SubscriptionOrdered",
+					"Delivering the events in order: the subscription is made again with one event in flight, "+
+						"a failed delivery being retried until it succeeds before the next event is delivered")
+			}
+			continue
+		}
+		if !channelOrdered && setter.Ordered(channel, sub.UID) {
+			recorder.Event(sub, corev1.EventTypeNormal, "SubscriptionUnordered",
+				"Delivering the events out of order: the subscription is made again with the max inflight of the channel")
+		}
+	}
+	setter.SetOrdered(channel, ordered)
+}
+
+// reportOrdered shows the subscribers delivered in order in their status, with the throughput
+// they trade for it.
+func (r *Reconciler) reportOrdered(natssChannel *v1beta1.NatssChannel) {
+	setter, ok := r.natssDispatcher.(dispatcher.OrderedSetter)
+	if !ok {
+		return
+	}
+	channel := channelReference(natssChannel)
+	for i, status := range natssChannel.Status.Subscribers {
+		// The messages of the other reasons take precedence.
+		if status.Ready == corev1.ConditionTrue && status.Message == "" && setter.Ordered(channel, status.UID) {
+			natssChannel.Status.Subscribers[i].Message = orderedReason +
+				": delivered in order, one event at a time: a failing delivery holds back the next events until it succeeds, " +
+				"and the throughput is bounded by the latency of the subscriber"
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeOrderedSetter struct {
+	dispatcher.NatssDispatcher
+
+	ordered map[types.UID]bool
+	held    map[types.UID]bool
+}
+
+var _ dispatcher.OrderedSetter = (*fakeOrderedSetter)(nil)
+
+func (f *fakeOrderedSetter) SetOrdered(_ eventingchannels.ChannelReference, subscriptions map[types.UID]bool) {
+	f.ordered = subscriptions
+}
+
+func (f *fakeOrderedSetter) Ordered(_ eventingchannels.ChannelReference, subscription types.UID) bool {
+	return f.held[subscription]
+}
+
+func TestReconcileOrdered(t *testing.T) {
+	tests := map[string]struct {
+		annotations        map[string]string
+		channelAnnotations map[string]string
+		workQueue          bool
+		held               bool
+		wantOrdered        bool
+		wantEvent          string
+	}{
+		"unordered": {},
+		"made ordered": {
+			annotations: map[string]string{messaging.OrderedAnnotationKey: "ordered"},
+			wantOrdered: true,
+			wantEvent:   "Normal SubscriptionOrdered",
+		},
+		"ordered": {
+			annotations: map[string]string{messaging.OrderedAnnotationKey: "ordered"},
+			held:        true,
+			wantOrdered: true,
+		},
+		"made unordered": {
+			annotations: map[string]string{messaging.OrderedAnnotationKey: "unordered"},
+			held:        true,
+			wantEvent:   "Normal SubscriptionUnordered",
+		},
+		"annotation removed": {
+			held:      true,
+			wantEvent: "Normal SubscriptionUnordered",
+		},
+		"channel ordered": {
+			annotations:        map[string]string{messaging.OrderedAnnotationKey: "unordered"},
+			channelAnnotations: map[string]string{messaging.DeliveryOrderAnnotationKey: "ordered"},
+			held:               true,
+			wantEvent:          "Warning DeliveryOrderIgnored",
+		},
+		"work queue": {
+			annotations: map[string]string{messaging.OrderedAnnotationKey: "ordered"},
+			workQueue:   true,
+			wantEvent:   "Warning DeliveryOrderIgnored",
+		},
+		"invalid": {
+			annotations: map[string]string{messaging.OrderedAnnotationKey: "sorted"},
+			wantEvent:   "Warning DeliveryOrderInvalid",
+		},
+	}
+	for n, tc := range tests {
+		t.Run(n, func(t *testing.T) {
+			sub := &messagingv1.Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   testNS,
+					Name:        "sub",
+					UID:         replaySubscriptionUID,
+					Annotations: tc.annotations,
+				},
+				Spec: messagingv1.SubscriptionSpec{
+					Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+				},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(sub); err != nil {
+				t.Fatalf("failed to add the subscription: %v", err)
+			}
+			setter := &fakeOrderedSetter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				held:            map[types.UID]bool{replaySubscriptionUID: tc.held},
+			}
+			r := &Reconciler{natssDispatcher: setter, subscriptionLister: messaginglisters.NewSubscriptionLister(indexer)}
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+			nc.Annotations = tc.channelAnnotations
+			if tc.workQueue {
+				nc.Spec.Distribution = v1beta1.DistributionWorkQueue
+			}
+			r.reconcileOrdered(ctx, nc)
+			if got := setter.ordered[replaySubscriptionUID]; got != tc.wantOrdered {
+				t.Errorf("ordered = %t, want %t", got, tc.wantOrdered)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestReportOrdered(t *testing.T) {
+	setter := &fakeOrderedSetter{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		held:            map[types.UID]bool{"ordered": true, "failed": true},
+	}
+	r := &Reconciler{natssDispatcher: setter}
+	nc := reconciletesting.NewNatssChannel(ncName, testNS)
+	nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{
+		{UID: "unordered", Ready: corev1.ConditionTrue},
+		{UID: "ordered", Ready: corev1.ConditionTrue},
+		{UID: "failed", Ready: corev1.ConditionFalse, Message: "failed"},
+	}
+	r.reportOrdered(nc)
+
+	for _, status := range nc.Status.Subscribers {
+		ordered := strings.HasPrefix(status.Message, orderedReason+": ")
+		if ordered != (status.UID == "ordered") {
+			t.Errorf("subscriber %s has the message %q", status.UID, status.Message)
+		}
+	}
+}
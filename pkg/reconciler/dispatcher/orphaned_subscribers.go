@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/features"
+)
+
+// orphanedSubscriberReason prefixes the message of the subscribers whose Subscription no longer
+// exists.
+const orphanedSubscriberReason = "OrphanedSubscriber"
+
+var (
+	// orphanedSubscriberGracePeriod is how long a subscriber is left without Subscription before
+	// it is orphaned, the Subscriptions informer possibly lagging behind the channels one.
+	orphanedSubscriberGracePeriod = time.Minute
+
+	// orphanedSubscriberCheckInterval is the interval between two checks of the subscribers of
+	// all the channels.
+	orphanedSubscriberCheckInterval = time.Minute
+)
+
+// orphanedSubscribers tracks the subscribers of a channel without Subscription.
+type orphanedSubscribers struct {
+	// missingSince is when each subscriber was first found without Subscription.
+	missingSince map[types.UID]time.Time
+	// reported are the UIDs of the orphaned subscribers of the last event.
+	reported sets.String
+}
+
+// liveSubscriptions returns the UIDs of the Subscriptions to natssChannel.
+func (r *Reconciler) liveSubscriptions(natssChannel *v1beta1.NatssChannel) (sets.String, error) {
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	live := sets.NewString()
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind == "NatssChannel" && sub.Spec.Channel.Name == natssChannel.Name {
+			live.Insert(string(sub.UID))
+		}
+	}
+	return live, nil
+}
+
+// reconcileOrphanedSubscribers returns the subscribers of natssChannel whose Subscription has no
+// longer existed for the grace period, a force-deleted Subscription leaving its subscriber in the
+// spec of the channel, and whether their subscriptions are paused, which they are when the
+// orphaned-subscriber-pause flag of ctx is enabled. A Warning event lists them whenever they
+// change.
+func (r *Reconciler) reconcileOrphanedSubscribers(ctx context.Context, natssChannel *v1beta1.NatssChannel) (map[types.UID]bool, bool) {
+	if r.subscriptionLister == nil {
+		return nil, false
+	}
+	live, err := r.liveSubscriptions(natssChannel)
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Error listing subscriptions", zap.Error(err))
+		return nil, false
+	}
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	previous := &orphanedSubscribers{}
+	if v, ok := r.orphanedSubscribers.Load(key); ok {
+		previous = v.(*orphanedSubscribers)
+	}
+	now := time.Now()
+	current := &orphanedSubscribers{missingSince: make(map[types.UID]time.Time), reported: previous.reported}
+	orphans := make(map[types.UID]bool)
+	uids := sets.NewString()
+	for _, sub := range natssChannel.Spec.Subscribers {
+		if live.Has(string(sub.UID)) {
+			continue
+		}
+		since, ok := previous.missingSince[sub.UID]
+		if !ok {
+			since = now
+		}
+		current.missingSince[sub.UID] = since
+		if now.Sub(since) >= orphanedSubscriberGracePeriod {
+			orphans[sub.UID] = true
+			uids.Insert(string(sub.UID))
+		}
+	}
+
+	pauser, ok := r.natssDispatcher.(dispatcher.OrphanedSubscriberPauser)
+	paused := ok && features.FromContext(ctx).OrphanedSubscriberPause.Enabled()
+	if ok {
+		if paused {
+			pauser.PauseOrphanedSubscribers(channelReference(natssChannel), orphans)
+		} else {
+			pauser.PauseOrphanedSubscribers(channelReference(natssChannel), nil)
+		}
+	}
+
+	if uids.Len() > 0 && !uids.Equal(current.reported) {
+		action := "their events are still delivered"
+		if paused {
+			action = "their subscriptions are paused"
+		}
+		controller.GetEventRecorder(ctx).Eventf(natssChannel, corev1.EventTypeWarning, orphanedSubscriberReason,
+			"Subscribers without Subscription, %s: %s", action, strings.Join(uids.List(), ", "))
+	}
+	current.reported = uids
+	if len(current.missingSince) == 0 {
+		r.orphanedSubscribers.Delete(key)
+	} else {
+		r.orphanedSubscribers.Store(key, current)
+	}
+	return orphans, paused
+}
+
+// reportOrphanedSubscribers marks the orphaned subscribers as not ready.
+func (r *Reconciler) reportOrphanedSubscribers(natssChannel *v1beta1.NatssChannel, orphans map[types.UID]bool, paused bool) {
+	message := orphanedSubscriberReason + ": the Subscription of the subscriber no longer exists, its events are still delivered"
+	if paused {
+		message = (&dispatcher.OrphanedSubscriberError{}).Error()
+	}
+	for i, status := range natssChannel.Status.Subscribers {
+		if orphans[status.UID] {
+			natssChannel.Status.Subscribers[i].Ready = corev1.ConditionFalse
+			natssChannel.Status.Subscribers[i].Message = message
+		}
+	}
+}
+
+// isOrphanedSubscriber tells whether err refused a subscriber because its Subscription no longer
+// exists, which is reported by the status of the subscriber.
+func isOrphanedSubscriber(err error) bool {
+	var orphaned *dispatcher.OrphanedSubscriberError
+	return errors.As(err, &orphaned)
+}
+
+// registerOrphanedSubscriberCheck registers the hook checking the subscribers of all the channels
+// every orphanedSubscriberCheckInterval once the informers are synced.
+func (r *Reconciler) registerOrphanedSubscriberCheck(lifecycle *dispatcher.Lifecycle, hasSynced ...cache.InformerSynced) error {
+	return lifecycle.Register(lifecycle.RunHook("orphaned-subscriber-check", dispatcher.PrioritySubscriptions+1, func(ctx context.Context) error {
+		// Checking before the informers are synced would find every subscriber orphaned.
+		if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+			return nil
+		}
+		ticker := time.NewTicker(orphanedSubscriberCheckInterval)
+		defer ticker.Stop()
+		for {
+			r.checkOrphanedSubscribers(ctx, r.enqueueKey)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}))
+}
+
+// checkOrphanedSubscribers enqueues the channels having a subscriber without Subscription, which
+// is marked once its grace period is over.
+func (r *Reconciler) checkOrphanedSubscribers(ctx context.Context, enqueue func(types.NamespacedName)) {
+	channels, err := r.natsschannelLister.List(labels.Everything())
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Error listing natss channels", zap.Error(err))
+		return
+	}
+	for _, nc := range channels {
+		if len(nc.Spec.Subscribers) == 0 {
+			continue
+		}
+		live, err := r.liveSubscriptions(nc)
+		if err != nil {
+			logging.FromContext(ctx).Errorw("Error listing subscriptions", zap.Error(err))
+			return
+		}
+		for _, sub := range nc.Spec.Subscribers {
+			if !live.Has(string(sub.UID)) {
+				enqueue(types.NamespacedName{Namespace: nc.Namespace, Name: nc.Name})
+				break
+			}
+		}
+	}
+}
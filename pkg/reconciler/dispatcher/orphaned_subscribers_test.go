@@ -0,0 +1,245 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	"knative.dev/eventing-natss/pkg/features"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const (
+	liveSubscriberUID    = "live-uid"
+	deletedSubscriberUID = "deleted-uid"
+	// recreatedSubscriberUID is the UID of a subscriber whose Subscription was deleted and
+	// created again with the same name, and another UID.
+	recreatedSubscriberUID = "recreated-uid"
+)
+
+type fakeOrphanPauser struct {
+	dispatcher.NatssDispatcher
+
+	paused map[types.UID]bool
+}
+
+var _ dispatcher.OrphanedSubscriberPauser = (*fakeOrphanPauser)(nil)
+
+func (p *fakeOrphanPauser) PauseOrphanedSubscribers(_ eventingchannels.ChannelReference, orphans map[types.UID]bool) {
+	p.paused = orphans
+}
+
+// newOrphanFixture returns a Reconciler whose Subscriptions to ncName are the live subscriber and
+// the subscriber created again, a Subscription to another channel having the UID of the deleted
+// subscriber.
+func newOrphanFixture(t *testing.T) (*Reconciler, *fakeOrphanPauser, *record.FakeRecorder) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, sub := range []*messagingv1.Subscription{
+		newSubscription("live", liveSubscriberUID, ncName),
+		newSubscription("recreated", "another-uid", ncName),
+		newSubscription("other-channel", deletedSubscriberUID, "other-nc"),
+		newSubscription("other-live", "other-live-uid", "live-nc"),
+	} {
+		if err := indexer.Add(sub); err != nil {
+			t.Fatalf("failed to add the subscription: %v", err)
+		}
+	}
+	pauser := &fakeOrphanPauser{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing()}
+	r := &Reconciler{
+		natssDispatcher:    pauser,
+		subscriptionLister: messaginglisters.NewSubscriptionLister(indexer),
+		natsschannelLister: reconciletesting.NewNatssChannelLister(
+			reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(liveSubscriberUID, deletedSubscriberUID)),
+			reconciletesting.NewNatssChannel("live-nc", testNS, withSubscriberUIDs("other-live-uid")),
+		),
+	}
+	return r, pauser, record.NewFakeRecorder(10)
+}
+
+func newSubscription(name, uid, channel string) *messagingv1.Subscription {
+	return &messagingv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNS, Name: name, UID: types.UID(uid)},
+		Spec: messagingv1.SubscriptionSpec{
+			Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: channel},
+		},
+	}
+}
+
+func withOrphanGracePeriod(t *testing.T, d time.Duration) {
+	previous := orphanedSubscriberGracePeriod
+	orphanedSubscriberGracePeriod = d
+	t.Cleanup(func() { orphanedSubscriberGracePeriod = previous })
+}
+
+func TestReconcileOrphanedSubscribers(t *testing.T) {
+	withOrphanGracePeriod(t, 0)
+	testCases := map[string]struct {
+		subscriber types.UID
+		pause      bool
+		wantOrphan bool
+		wantEvent  string
+	}{
+		"live": {
+			subscriber: liveSubscriberUID,
+		},
+		"deleted": {
+			subscriber: deletedSubscriberUID,
+			wantOrphan: true,
+			wantEvent:  "Warning OrphanedSubscriber Subscribers without Subscription, their events are still delivered: " + deletedSubscriberUID,
+		},
+		"mismatched UID": {
+			subscriber: recreatedSubscriberUID,
+			wantOrphan: true,
+			wantEvent:  "Warning OrphanedSubscriber Subscribers without Subscription, their events are still delivered: " + recreatedSubscriberUID,
+		},
+		"deleted and paused": {
+			subscriber: deletedSubscriberUID,
+			pause:      true,
+			wantOrphan: true,
+			wantEvent:  "Warning OrphanedSubscriber Subscribers without Subscription, their subscriptions are paused: " + deletedSubscriberUID,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			r, pauser, recorder := newOrphanFixture(t)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+			if tc.pause {
+				ctx = features.ToContext(ctx, &features.Flags{OrphanedSubscriberPause: features.Enabled})
+			}
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(string(tc.subscriber)))
+			nc.Status.SubscribableStatus = r.createSubscribableStatus(nc.Spec.Subscribers, nil)
+
+			orphans, paused := r.reconcileOrphanedSubscribers(ctx, nc)
+			r.reportOrphanedSubscribers(nc, orphans, paused)
+
+			if orphans[tc.subscriber] != tc.wantOrphan || paused != tc.pause {
+				t.Errorf("orphans = %v, paused = %t, want orphaned %t and paused %t", orphans, paused, tc.wantOrphan, tc.pause)
+			}
+			if got := len(pauser.paused) > 0; got != tc.pause {
+				t.Errorf("dispatcher paused %v, want paused %t", pauser.paused, tc.pause)
+			}
+			status := nc.Status.Subscribers[0]
+			if wantReady := !tc.wantOrphan; (status.Ready == corev1.ConditionTrue) != wantReady {
+				t.Errorf("subscriber ready = %s, want ready %t", status.Ready, wantReady)
+			}
+			if tc.wantOrphan && !strings.HasPrefix(status.Message, orphanedSubscriberReason+": ") {
+				t.Errorf("subscriber message = %q, want the %s reason", status.Message, orphanedSubscriberReason)
+			}
+			select {
+			case event := <-recorder.Events:
+				if event != tc.wantEvent {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileOrphanedSubscribersGracePeriod(t *testing.T) {
+	withOrphanGracePeriod(t, time.Hour)
+	r, _, recorder := newOrphanFixture(t)
+	ctx := controller.WithEventRecorder(context.Background(), recorder)
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(deletedSubscriberUID))
+
+	// The Subscription may not be in the cache yet.
+	if orphans, _ := r.reconcileOrphanedSubscribers(ctx, nc); len(orphans) != 0 {
+		t.Errorf("orphans = %v within the grace period", orphans)
+	}
+
+	// Orphaned once missing for the grace period, the event being recorded once.
+	v, _ := r.orphanedSubscribers.Load(types.NamespacedName{Namespace: testNS, Name: ncName})
+	v.(*orphanedSubscribers).missingSince[deletedSubscriberUID] = time.Now().Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		if orphans, _ := r.reconcileOrphanedSubscribers(ctx, nc); !orphans[deletedSubscriberUID] {
+			t.Errorf("orphans = %v after the grace period", orphans)
+		}
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("recorded %d events, want 1", len(recorder.Events))
+	}
+
+	// The subscriber removed from the spec is forgotten.
+	nc.Spec.Subscribers = nil
+	r.reconcileOrphanedSubscribers(ctx, nc)
+	if _, ok := r.orphanedSubscribers.Load(types.NamespacedName{Namespace: testNS, Name: ncName}); ok {
+		t.Error("the subscribers of the channel are still tracked")
+	}
+}
+
+func TestReconcileOrphanedSubscribersStatus(t *testing.T) {
+	withOrphanGracePeriod(t, 0)
+	r, pauser, recorder := newOrphanFixture(t)
+	ctx := controller.WithEventRecorder(context.Background(), recorder)
+	ctx = features.ToContext(ctx, &features.Flags{OrphanedSubscriberPause: features.Enabled})
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(liveSubscriberUID, deletedSubscriberUID))
+
+	orphans, paused := r.reconcileOrphanedSubscribers(ctx, nc)
+	// The dispatcher refuses the paused subscribers.
+	failed := map[eventingduckv1.SubscriberSpec]error{nc.Spec.Subscribers[1]: &dispatcher.OrphanedSubscriberError{}}
+	nc.Status.SubscribableStatus = r.createSubscribableStatus(nc.Spec.Subscribers, failed)
+	r.reportOrphanedSubscribers(nc, orphans, paused)
+
+	want := []eventingduckv1.SubscriberStatus{{
+		UID:   liveSubscriberUID,
+		Ready: corev1.ConditionTrue,
+	}, {
+		UID:     deletedSubscriberUID,
+		Ready:   corev1.ConditionFalse,
+		Message: "OrphanedSubscriber: the Subscription of the subscriber no longer exists, its subscription is paused",
+	}}
+	if diff := cmp.Diff(want, nc.Status.Subscribers); diff != "" {
+		t.Errorf("unexpected subscribers status (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff(map[types.UID]bool{deletedSubscriberUID: true}, pauser.paused); diff != "" {
+		t.Errorf("unexpected paused subscribers (-want, +got): %s", diff)
+	}
+	if !isOrphanedSubscriber(failed[nc.Spec.Subscribers[1]]) {
+		t.Error("the error of the paused subscriber is not an orphaned subscriber")
+	}
+}
+
+func TestCheckOrphanedSubscribers(t *testing.T) {
+	r, _, _ := newOrphanFixture(t)
+	var enqueued []types.NamespacedName
+	r.checkOrphanedSubscribers(context.Background(), func(key types.NamespacedName) {
+		enqueued = append(enqueued, key)
+	})
+	if diff := cmp.Diff([]types.NamespacedName{{Namespace: testNS, Name: ncName}}, enqueued); diff != "" {
+		t.Errorf("unexpected enqueued channels (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	"knative.dev/eventing-natss/pkg/features"
+)
+
+const (
+	// durablesConfigMapName is the name of the ConfigMap holding the bookkeeping of the durables
+	// created by the dispatcher.
+	durablesConfigMapName = "natss-ch-dispatcher-durables"
+
+	// orphansPath is the path of the endpoint listing the orphaned durables.
+	orphansPath = "/debug/orphans"
+)
+
+var (
+	// orphanedDurablesM records the number of durables whose channel or subscriber no longer exists.
+	orphanedDurablesM = stats.Int64(
+		"natss_orphaned_durables",
+		"Number of NATSS durables whose channel or subscriber no longer exists",
+		stats.UnitDimensionless,
+	)
+
+	// durableNameRegexp matches the names of the durables created by the dispatcher, which are
+	// the UIDs of the subscriptions, prefixed with v2- by the v2 naming scheme.
+	durableNameRegexp = regexp.MustCompile(`^(v2-)?[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: orphanedDurablesM.Description(),
+			Measure:     orphanedDurablesM,
+			Aggregation: view.LastValue(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// durableRecord is the bookkeeping of a durable.
+type durableRecord struct {
+	// Channel is the namespace/name of the channel of the durable.
+	Channel string `json:"channel"`
+	// MissingSince is when the audit first found the durable absent from the cluster.
+	MissingSince *metav1.Time `json:"missingSince,omitempty"`
+}
+
+// orphanedDurable is a durable whose channel or subscriber no longer exists.
+type orphanedDurable struct {
+	Durable      string      `json:"durable"`
+	Channel      string      `json:"channel"`
+	MissingSince metav1.Time `json:"missingSince"`
+}
+
+// orphanAuditor finds the durables created for the channels and subscribers deleted since, and
+// optionally removes them. Only the durables recorded in its bookkeeping are audited: NATSS
+// cannot enumerate the durables of a client.
+type orphanAuditor struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	lister     listers.NatssChannelLister
+
+	// remover deletes the orphaned durables when the orphan-audit-delete flag is enabled, nil when
+	// the transport cannot delete them.
+	remover dispatcher.DurableRemover
+	// namer tells the names of the durables of the subscriptions, nil when the transport names
+	// them after the subscriptions alone.
+	namer dispatcher.DurableNamer
+	// flags holds the feature flags of every audit, nil leaving those of its context.
+	flags       *features.Store
+	gracePeriod time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	orphans []orphanedDurable
+}
+
+func newOrphanAuditor(kubeClient kubernetes.Interface, namespace string, lister listers.NatssChannelLister, remover dispatcher.DurableRemover, gracePeriod time.Duration) *orphanAuditor {
+	return &orphanAuditor{
+		kubeClient:  kubeClient,
+		namespace:   namespace,
+		lister:      lister,
+		remover:     remover,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+}
+
+// run audits the durables every interval until ctx is done.
+func (a *orphanAuditor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		auditCtx := ctx
+		if a.flags != nil {
+			auditCtx = a.flags.ToContext(ctx)
+		}
+		if err := a.audit(auditCtx); err != nil {
+			logging.FromContext(ctx).Warnw("Error auditing the orphaned durables", zap.Error(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// audit records the durables of the current subscribers in the bookkeeping, then reports the
+// recorded durables absent from the cluster. An orphaned durable is deleted when the
+// orphan-audit-delete flag of ctx is enabled, its name matches the durables created by the dispatcher and it has been orphaned for
+// longer than the grace period.
+func (a *orphanAuditor) audit(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	now := a.now()
+	remove := a.remover != nil && features.FromContext(ctx).OrphanAuditDelete.Enabled()
+
+	live, err := a.liveDurables()
+	if err != nil {
+		return err
+	}
+	records, err := a.load(ctx)
+	if err != nil {
+		return err
+	}
+	original := make(map[string]durableRecord, len(records))
+	for durable, record := range records {
+		original[durable] = record
+	}
+
+	for durable, channel := range live {
+		records[durable] = durableRecord{Channel: channel}
+	}
+
+	var orphans []orphanedDurable
+	for durable, record := range records {
+		if _, ok := live[durable]; ok {
+			continue
+		}
+		if record.MissingSince == nil {
+			record.MissingSince = &metav1.Time{Time: now}
+			records[durable] = record
+		}
+
+		if remove && durableNameRegexp.MatchString(durable) && now.Sub(record.MissingSince.Time) > a.gracePeriod {
+			if channel, ok := parseChannelReference(record.Channel); ok {
+				if err := a.remover.RemoveDurable(channel, durable); err != nil {
+					logger.Warnw("Error removing the orphaned durable", zap.String("durable", durable), zap.Error(err))
+				} else {
+					delete(records, durable)
+					continue
+				}
+			}
+		}
+		orphans = append(orphans, orphanedDurable{Durable: durable, Channel: record.Channel, MissingSince: *record.MissingSince})
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Durable < orphans[j].Durable })
+
+	a.mu.Lock()
+	a.orphans = orphans
+	a.mu.Unlock()
+	metrics.Record(ctx, orphanedDurablesM.M(int64(len(orphans))))
+
+	if reflect.DeepEqual(original, records) {
+		return nil
+	}
+	return a.persist(ctx, records)
+}
+
+// liveDurables returns the channel of the durables of every subscriber of the current channels,
+// both the old and the new one of those being migrated.
+func (a *orphanAuditor) liveDurables() (map[string]string, error) {
+	channels, err := a.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]string)
+	for _, nc := range channels {
+		ref := channelReference(nc)
+		for _, sub := range nc.Spec.Subscribers {
+			var durables []string
+			if a.namer != nil {
+				durables = a.namer.DurablesOf(ref, sub.UID)
+			}
+			// Without a namer, or before the subscription is made, the durable is named after it.
+			if len(durables) == 0 {
+				durables = []string{dispatcher.DurableName(sub)}
+			}
+			for _, durable := range durables {
+				live[durable] = ref.String()
+			}
+		}
+	}
+	return live, nil
+}
+
+func (a *orphanAuditor) load(ctx context.Context) (map[string]durableRecord, error) {
+	records := make(map[string]durableRecord)
+	cm, err := a.kubeClient.CoreV1().ConfigMaps(a.namespace).Get(ctx, durablesConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for durable, value := range cm.Data {
+		var record durableRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, fmt.Errorf("invalid record of durable %q in ConfigMap %s: %w", durable, cm.Name, err)
+		}
+		records[durable] = record
+	}
+	return records, nil
+}
+
+func (a *orphanAuditor) persist(ctx context.Context, records map[string]durableRecord) error {
+	data := make(map[string]string, len(records))
+	for durable, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data[durable] = string(value)
+	}
+
+	cms := a.kubeClient.CoreV1().ConfigMaps(a.namespace)
+	cm, err := cms.Get(ctx, durablesConfigMapName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		_, err = cms.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: durablesConfigMapName, Namespace: a.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm = cm.DeepCopy()
+	cm.Data = data
+	_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// ServeHTTP lists the orphaned durables found by the last audit as JSON.
+func (a *orphanAuditor) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	a.mu.Lock()
+	orphans := a.orphans
+	a.mu.Unlock()
+	if orphans == nil {
+		orphans = []orphanedDurable{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(orphans); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func parseChannelReference(s string) (eventingchannels.ChannelReference, bool) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return eventingchannels.ChannelReference{}, false
+	}
+	return eventingchannels.ChannelReference{Namespace: parts[0], Name: parts[1]}, true
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	"knative.dev/eventing-natss/pkg/features"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const (
+	liveUID     = "11111111-1111-1111-1111-111111111111"
+	orphanUID   = "22222222-2222-2222-2222-222222222222"
+	foreignName = "not-ours"
+)
+
+type fakeDurableRemover struct {
+	removed []string
+}
+
+func (r *fakeDurableRemover) RemoveDurable(channel eventingchannels.ChannelReference, durable string) error {
+	r.removed = append(r.removed, channel.String()+"/"+durable)
+	return nil
+}
+
+func TestOrphanAudit(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	remover := &fakeDurableRemover{}
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+
+	channel := reconciletesting.NewNatssChannel("live", testNS, withSubscriberUIDs(liveUID, orphanUID))
+	auditor := newOrphanAuditor(kubeClient, hostMapNamespace, reconciletesting.NewNatssChannelLister(channel), remover, time.Hour)
+	auditor.now = func() time.Time { return now }
+
+	// The durables of the current subscribers are recorded.
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if got := listOrphans(t, auditor); len(got) != 0 {
+		t.Errorf("orphans = %v, want none", got)
+	}
+
+	// The subscriber and a foreign durable go away.
+	auditor.lister = reconciletesting.NewNatssChannelLister(reconciletesting.NewNatssChannel("live", testNS, withSubscriberUIDs(liveUID)))
+	records, err := auditor.load(ctx)
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	records[foreignName] = durableRecord{Channel: testNS + "/gone"}
+	if err := auditor.persist(ctx, records); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if diff := cmp.Diff([]string{orphanUID, foreignName}, listOrphans(t, auditor)); diff != "" {
+		t.Errorf("unexpected orphans (-want, +got): %s", diff)
+	}
+	if len(remover.removed) != 0 {
+		t.Errorf("removed %v within the grace period", remover.removed)
+	}
+
+	// Once the grace period elapsed, the durables are kept while the deletion is disabled.
+	now = now.Add(2 * time.Hour)
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if len(remover.removed) != 0 {
+		t.Errorf("removed %v with the deletion disabled", remover.removed)
+	}
+
+	// Then only the durable named after a subscription UID is removed.
+	ctx = features.ToContext(ctx, &features.Flags{OrphanAuditDelete: features.Enabled})
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if diff := cmp.Diff([]string{testNS + "/live/" + orphanUID}, remover.removed); diff != "" {
+		t.Errorf("unexpected removed durables (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]string{foreignName}, listOrphans(t, auditor)); diff != "" {
+		t.Errorf("unexpected orphans (-want, +got): %s", diff)
+	}
+	records, err = auditor.load(ctx)
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if _, ok := records[orphanUID]; ok {
+		t.Error("the removed durable is still recorded")
+	}
+	if _, ok := records[liveUID]; !ok {
+		t.Error("the live durable is not recorded")
+	}
+}
+
+func TestOrphanAuditNamedDurables(t *testing.T) {
+	ctx := features.ToContext(context.Background(), &features.Flags{OrphanAuditDelete: features.Enabled})
+	remover := &fakeDurableRemover{}
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+	channel := reconciletesting.NewNatssChannel("live", testNS, withSubscriberUIDs(liveUID))
+	ref := channelReference(channel)
+	namer := &fakeDurableNamer{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		named:           []dispatcher.NamedDurable{{Channel: ref, Subscription: liveUID, Scheme: dispatcher.DurableNamingV1, MigratingTo: dispatcher.DurableNamingV2}},
+	}
+	auditor := newOrphanAuditor(fake.NewSimpleClientset(), hostMapNamespace, reconciletesting.NewNatssChannelLister(channel), remover, time.Hour)
+	auditor.namer = namer
+	auditor.now = func() time.Time { return now }
+
+	// Both durables of a migration are live.
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if got := listOrphans(t, auditor); len(got) != 0 {
+		t.Errorf("orphans = %v, want none", got)
+	}
+
+	// The old durable left by the migration is removed after the grace period.
+	namer.named[0].Scheme, namer.named[0].MigratingTo = dispatcher.DurableNamingV2, ""
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if diff := cmp.Diff([]string{testNS + "/live/" + liveUID}, remover.removed); diff != "" {
+		t.Errorf("unexpected removed durables (-want, +got): %s", diff)
+	}
+	if got := listOrphans(t, auditor); len(got) != 0 {
+		t.Errorf("orphans = %v, want none", got)
+	}
+}
+
+func TestOrphanAuditReportOnly(t *testing.T) {
+	ctx := context.Background()
+	auditor := newOrphanAuditor(fake.NewSimpleClientset(), hostMapNamespace,
+		reconciletesting.NewNatssChannelLister(reconciletesting.NewNatssChannel("live", testNS, withSubscriberUIDs(orphanUID))), nil, 0)
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+
+	auditor.lister = reconciletesting.NewNatssChannelLister()
+	auditor.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if err := auditor.audit(ctx); err != nil {
+		t.Fatalf("audit() = %v", err)
+	}
+	if diff := cmp.Diff([]string{orphanUID}, listOrphans(t, auditor)); diff != "" {
+		t.Errorf("unexpected orphans (-want, +got): %s", diff)
+	}
+}
+
+func withSubscriberUIDs(uids ...string) reconciletesting.NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		for _, uid := range uids {
+			nc.Spec.Subscribers = append(nc.Spec.Subscribers, eventingduckv1.SubscriberSpec{UID: types.UID(uid)})
+		}
+	}
+}
+
+// listOrphans returns the durables served on the orphans endpoint.
+func listOrphans(t *testing.T, auditor *orphanAuditor) []string {
+	w := httptest.NewRecorder()
+	auditor.ServeHTTP(w, httptest.NewRequest("GET", orphansPath, nil))
+
+	var orphans []orphanedDurable
+	if err := json.NewDecoder(w.Body).Decode(&orphans); err != nil {
+		t.Fatalf("failed to decode the orphans: %v", err)
+	}
+	durables := make([]string, 0, len(orphans))
+	for _, o := range orphans {
+		durables = append(durables, o.Durable)
+	}
+	return durables
+}
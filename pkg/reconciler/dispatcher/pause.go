@@ -0,0 +1,176 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// pausedUnhealthyReason prefixes the message of the subscribers whose subscription is paused.
+const pausedUnhealthyReason = "UnhealthyPaused"
+
+// pauseMark is the pause of a subscription recorded on its Subscription.
+type pauseMark struct {
+	// since is the time of the pause.
+	since time.Time
+	// resourceVersion is the version of the Subscription before it was annotated. The
+	// annotation missing from a later version was removed by an operator.
+	resourceVersion string
+}
+
+// isNatssChannelPaused tells whether obj is a Subscription to a NatssChannel paused by the
+// dispatcher.
+func isNatssChannelPaused(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.PausedUnhealthyAnnotationKey]
+	return ok
+}
+
+// isNatssChannelWatched tells whether obj is a Subscription whose changes are reconciled by the
+// dispatcher.
+func isNatssChannelWatched(obj interface{}) bool {
+	return isNatssChannelReplay(obj) || isNatssChannelPaused(obj) || isNatssChannelEphemeral(obj) || isNatssChannelConsumers(obj) ||
+		isNatssChannelOrdered(obj) || isNatssChannelColdStart(obj) || isNatssChannelDeliveryTimeout(obj)
+}
+
+// reconcilePauses records the subscriptions of natssChannel paused by the dispatcher on their
+// Subscription, with the natss.messaging.knative.dev/paused-unhealthy annotation and a Warning
+// event, and resumes those whose annotation an operator removed.
+func (r *Reconciler) reconcilePauses(ctx context.Context, natssChannel *v1beta1.NatssChannel) {
+	pauser, ok := r.natssDispatcher.(dispatcher.UnhealthyPauser)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	pauser.WatchPauses(channelReference(natssChannel), func() { r.enqueueKey(key) })
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	for _, sub := range subs {
+		if sub.Spec.Channel.Kind != "NatssChannel" || sub.Spec.Channel.Name != natssChannel.Name {
+			continue
+		}
+		since, paused := pauser.PausedSince(sub.UID)
+		_, annotated := sub.Annotations[messaging.PausedUnhealthyAnnotationKey]
+		var mark *pauseMark
+		if v, ok := r.pauses.Load(sub.UID); ok {
+			mark = v.(*pauseMark)
+		}
+
+		switch {
+		case paused && annotated:
+			// Recorded already.
+
+		case paused && mark != nil && mark.since.Equal(since):
+			if sub.ResourceVersion == mark.resourceVersion {
+				// The annotation is not in the cache yet.
+				continue
+			}
+			if !pauser.ResumeSubscription(sub.UID) {
+				recorder.Eventf(sub, corev1.EventTypeWarning, "SubscriberResumeFailed",
+					"Failed to resume the subscription paused since %s", since.Format(time.RFC3339))
+				continue
+			}
+			r.pauses.Delete(sub.UID)
+			recorder.Event(sub, corev1.EventTypeNormal, "SubscriberResumed", "Subscription resumed by removing the paused-unhealthy annotation")
+
+		case paused:
+			if err := r.annotatePause(ctx, sub, since.UTC().Format(time.RFC3339)); err != nil {
+				logger.Errorw("Error annotating the paused subscription", zap.String("subscription", sub.Name), zap.Error(err))
+				continue
+			}
+			r.pauses.Store(sub.UID, &pauseMark{since: since, resourceVersion: sub.ResourceVersion})
+			recorder.Eventf(sub, corev1.EventTypeWarning, pausedUnhealthyReason,
+				"Subscription paused since %s, every delivery to the subscriber failed", since.Format(time.RFC3339))
+
+		case annotated:
+			// Resumed by a probe, or by a restart of the dispatcher.
+			if err := r.annotatePause(ctx, sub, ""); err != nil {
+				logger.Errorw("Error removing the paused-unhealthy annotation", zap.String("subscription", sub.Name), zap.Error(err))
+				continue
+			}
+			r.pauses.Delete(sub.UID)
+			recorder.Event(sub, corev1.EventTypeNormal, "SubscriberResumed", "Subscription resumed, the subscriber is healthy again")
+
+		default:
+			r.pauses.Delete(sub.UID)
+		}
+	}
+}
+
+// reportPauses marks the subscribers whose subscription is paused as not ready.
+func (r *Reconciler) reportPauses(natssChannel *v1beta1.NatssChannel) {
+	pauser, ok := r.natssDispatcher.(dispatcher.UnhealthyPauser)
+	if !ok {
+		return
+	}
+	for i, status := range natssChannel.Status.Subscribers {
+		if since, paused := pauser.PausedSince(status.UID); paused {
+			natssChannel.Status.Subscribers[i].Ready = corev1.ConditionFalse
+			message := pausedUnhealthyReason + ": every delivery to the subscriber failed, paused since " + since.UTC().Format(time.RFC3339)
+			if response := pauser.LastErrorResponse(status.UID); response != "" {
+				message += ", last response: " + response
+			}
+			natssChannel.Status.Subscribers[i].Message = message
+		}
+	}
+}
+
+// annotatePause sets the paused-unhealthy annotation of sub to value, removing it when value is
+// empty.
+func (r *Reconciler) annotatePause(ctx context.Context, sub *messagingv1.Subscription, value string) error {
+	var annotation interface{}
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				messaging.PausedUnhealthyAnnotationKey: annotation,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.eventingClientSet.MessagingV1().Subscriptions(sub.Namespace).Patch(ctx, sub.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	fakeeventingclientset "knative.dev/eventing/pkg/client/clientset/versioned/fake"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const pausedSince = "2020-11-01T09:00:00Z"
+
+type fakePauser struct {
+	dispatcher.NatssDispatcher
+
+	paused    map[types.UID]time.Time
+	responses map[types.UID]string
+	resumed   []types.UID
+}
+
+var _ dispatcher.UnhealthyPauser = (*fakePauser)(nil)
+
+func (p *fakePauser) WatchPauses(eventingchannels.ChannelReference, func()) {}
+
+func (p *fakePauser) PausedSince(subscription types.UID) (time.Time, bool) {
+	since, ok := p.paused[subscription]
+	return since, ok
+}
+
+func (p *fakePauser) LastErrorResponse(subscription types.UID) string {
+	return p.responses[subscription]
+}
+
+func (p *fakePauser) ResumeSubscription(subscription types.UID) bool {
+	if _, ok := p.paused[subscription]; !ok {
+		return false
+	}
+	delete(p.paused, subscription)
+	p.resumed = append(p.resumed, subscription)
+	return true
+}
+
+// pauseFixture reconciles the pauses of a channel with a single Subscription.
+type pauseFixture struct {
+	t              *testing.T
+	r              *Reconciler
+	pauser         *fakePauser
+	indexer        cache.Indexer
+	eventingClient *fakeeventingclientset.Clientset
+	recorder       *record.FakeRecorder
+}
+
+func newPauseFixture(t *testing.T, sub *messagingv1.Subscription) *pauseFixture {
+	f := &pauseFixture{
+		t:              t,
+		pauser:         &fakePauser{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(), paused: make(map[types.UID]time.Time)},
+		indexer:        cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		eventingClient: fakeeventingclientset.NewSimpleClientset(sub),
+		recorder:       record.NewFakeRecorder(10),
+	}
+	if err := f.indexer.Add(sub); err != nil {
+		t.Fatalf("failed to add the subscription: %v", err)
+	}
+	f.r = &Reconciler{
+		natssDispatcher:    f.pauser,
+		eventingClientSet:  f.eventingClient,
+		subscriptionLister: messaginglisters.NewSubscriptionLister(f.indexer),
+	}
+	return f
+}
+
+// reconcile reconciles the pauses, and checks the event recorded and the annotation left.
+func (f *pauseFixture) reconcile(wantEvent, wantAnnotation string) {
+	f.t.Helper()
+	ctx := controller.WithEventRecorder(context.Background(), f.recorder)
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+	f.r.reconcilePauses(ctx, nc)
+
+	select {
+	case event := <-f.recorder.Events:
+		if wantEvent == "" || !strings.HasPrefix(event, wantEvent) {
+			f.t.Errorf("event = %q, want %q", event, wantEvent)
+		}
+	default:
+		if wantEvent != "" {
+			f.t.Errorf("no event, want %q", wantEvent)
+		}
+	}
+	if got := f.subscription().Annotations[messaging.PausedUnhealthyAnnotationKey]; got != wantAnnotation {
+		f.t.Errorf("%s = %q, want %q", messaging.PausedUnhealthyAnnotationKey, got, wantAnnotation)
+	}
+}
+
+func (f *pauseFixture) subscription() *messagingv1.Subscription {
+	f.t.Helper()
+	sub, err := f.eventingClient.MessagingV1().Subscriptions(testNS).Get(context.Background(), "sub", metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("failed to get the subscription: %v", err)
+	}
+	return sub
+}
+
+// sync updates the Subscription with edit, and the cache with the new version.
+func (f *pauseFixture) sync(resourceVersion string, edit func(*messagingv1.Subscription)) {
+	f.t.Helper()
+	sub := f.subscription()
+	edit(sub)
+	sub.ResourceVersion = resourceVersion
+	sub, err := f.eventingClient.MessagingV1().Subscriptions(testNS).Update(context.Background(), sub, metav1.UpdateOptions{})
+	if err != nil {
+		f.t.Fatalf("failed to update the subscription: %v", err)
+	}
+	if err := f.indexer.Update(sub); err != nil {
+		f.t.Fatalf("failed to update the subscription: %v", err)
+	}
+}
+
+func newPausedSubscription(annotations map[string]string) *messagingv1.Subscription {
+	return &messagingv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       testNS,
+			Name:            "sub",
+			UID:             replaySubscriptionUID,
+			ResourceVersion: "1",
+			Annotations:     annotations,
+		},
+		Spec: messagingv1.SubscriptionSpec{
+			Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+		},
+	}
+}
+
+func TestReconcilePausesResumedByOperator(t *testing.T) {
+	since, _ := time.Parse(time.RFC3339, pausedSince)
+	f := newPauseFixture(t, newPausedSubscription(nil))
+	f.reconcile("", "")
+
+	f.pauser.paused[replaySubscriptionUID] = since
+	f.reconcile("Warning UnhealthyPaused", pausedSince)
+
+	// The cache does not hold the annotation yet.
+	f.reconcile("", pausedSince)
+	if len(f.pauser.resumed) != 0 {
+		t.Fatalf("resumed %v before the annotation was removed", f.pauser.resumed)
+	}
+	f.sync("2", func(*messagingv1.Subscription) {})
+	f.reconcile("", pausedSince)
+
+	// An operator removes the annotation.
+	f.sync("3", func(sub *messagingv1.Subscription) {
+		delete(sub.Annotations, messaging.PausedUnhealthyAnnotationKey)
+	})
+	f.reconcile("Normal SubscriberResumed", "")
+	if diff := cmp.Diff([]types.UID{replaySubscriptionUID}, f.pauser.resumed); diff != "" {
+		t.Errorf("unexpected resumed subscriptions (-want, +got): %s", diff)
+	}
+}
+
+func TestReconcilePausesResumedByProbe(t *testing.T) {
+	f := newPauseFixture(t, newPausedSubscription(map[string]string{messaging.PausedUnhealthyAnnotationKey: pausedSince}))
+
+	// The subscription is not paused anymore, the annotation is removed.
+	f.reconcile("Normal SubscriberResumed", "")
+	if len(f.pauser.resumed) != 0 {
+		t.Errorf("resumed %v, want the dispatcher to have resumed the subscription already", f.pauser.resumed)
+	}
+}
+
+func TestReportPauses(t *testing.T) {
+	since, _ := time.Parse(time.RFC3339, pausedSince)
+	pauser := &fakePauser{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		paused:          map[types.UID]time.Time{replaySubscriptionUID: since, "uid-rejecting": since},
+		responses:       map[types.UID]string{"uid-rejecting": `{"error": "field \"id\" is required"}`},
+	}
+	r := &Reconciler{natssDispatcher: pauser}
+
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID, "uid-rejecting"))
+	nc.Status.SubscribableStatus = r.createSubscribableStatus(nc.Spec.Subscribers, nil)
+	r.reportPauses(nc)
+
+	want := []eventingduckv1.SubscriberStatus{{
+		UID:     replaySubscriptionUID,
+		Ready:   corev1.ConditionFalse,
+		Message: "UnhealthyPaused: every delivery to the subscriber failed, paused since 2020-11-01T09:00:00Z",
+	}, {
+		UID:     "uid-rejecting",
+		Ready:   corev1.ConditionFalse,
+		Message: `UnhealthyPaused: every delivery to the subscriber failed, paused since 2020-11-01T09:00:00Z, last response: {"error": "field \"id\" is required"}`,
+	}}
+	if diff := cmp.Diff(want, nc.Status.Subscribers); diff != "" {
+		t.Errorf("unexpected subscribers status (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// reconcileProvisioning reports the NATSS channel of natssChannel refused by the server, the
+// channel being reconciled again when the dispatcher attempts it again.
+func (r *Reconciler) reconcileProvisioning(natssChannel *v1beta1.NatssChannel) {
+	reporter, ok := r.natssDispatcher.(dispatcher.ProvisioningReporter)
+	if !ok {
+		return
+	}
+	key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+	reporter.WatchProvisioning(channelReference(natssChannel), func() { r.enqueueKey(key) })
+	if subject, ok := reporter.NotProvisioned(channelReference(natssChannel)); ok {
+		natssChannel.Status.MarkChannelNotProvisionedOnServer(subject)
+		return
+	}
+	natssChannel.Status.ClearChannelNotProvisionedOnServerCondition()
+}
+
+// isNotProvisioned tells whether err failed a subscription because the server refused its NATSS
+// channel, which the dispatcher attempts again without the backoff of the reconciliations.
+func isNotProvisioned(err error) bool {
+	var notProvisioned *dispatcher.ChannelNotProvisionedError
+	return errors.As(err, &notProvisioned)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	eventingchannels "knative.dev/eventing/pkg/channel"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeProvisioningReporter struct {
+	dispatcher.NatssDispatcher
+
+	watched map[eventingchannels.ChannelReference]func()
+	subject string
+}
+
+func (f *fakeProvisioningReporter) WatchProvisioning(channel eventingchannels.ChannelReference, notify func()) {
+	if notify == nil {
+		delete(f.watched, channel)
+		return
+	}
+	f.watched[channel] = notify
+}
+
+func (f *fakeProvisioningReporter) NotProvisioned(eventingchannels.ChannelReference) (string, bool) {
+	return f.subject, f.subject != ""
+}
+
+func TestReconcileProvisioning(t *testing.T) {
+	for _, subject := range []string{"", ncName + "." + testNS} {
+		t.Run("subject "+subject, func(t *testing.T) {
+			reporter := &fakeProvisioningReporter{
+				NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+				watched:         make(map[eventingchannels.ChannelReference]func()),
+				subject:         subject,
+			}
+			r := &Reconciler{natssDispatcher: reporter}
+			nc := reconciletesting.NewNatssChannel(ncName, testNS,
+				reconciletesting.WithNatssInitChannelConditions,
+				reconciletesting.WithNatssChannelDeploymentReady(),
+				reconciletesting.WithNatssChannelServiceReady(),
+				reconciletesting.WithNatssChannelEndpointsReady(),
+				reconciletesting.WithNatssChannelChannelServiceReady(),
+				reconciletesting.WithNatssChannelNatssConnectionReady(),
+				reconciletesting.WithNatssChannelAddress("channel.ns.svc.cluster.local"))
+			// A channel which was refused before.
+			nc.Status.MarkChannelNotProvisionedOnServer("previous")
+			r.reconcileProvisioning(nc)
+
+			cond := nc.Status.GetCondition(v1beta1.NatssChannelConditionChannelNotProvisionedOnServer)
+			if subject == "" {
+				if cond != nil {
+					t.Errorf("unexpected condition %+v", cond)
+				}
+			} else if cond == nil || !strings.Contains(cond.Message, `"`+subject+`"`) {
+				t.Errorf("condition = %+v, want a condition naming %s", cond, subject)
+			}
+			if !nc.Status.IsReady() {
+				t.Error("the provisioning changed the readiness of the channel")
+			}
+			if _, watched := reporter.watched[channelReference(nc)]; !watched {
+				t.Error("the provisioning of the channel is not watched")
+			}
+		})
+	}
+}
+
+func TestIsNotProvisioned(t *testing.T) {
+	err := fmt.Errorf("subscribe: %w", &dispatcher.ChannelNotProvisionedError{Subject: "channel.ns", Err: errors.New("too many channels")})
+	if !isNotProvisioned(err) {
+		t.Errorf("isNotProvisioned(%v) = false", err)
+	}
+	if err := errors.New("no Connection to NATSS"); isNotProvisioned(err) {
+		t.Errorf("isNotProvisioned(%v) = true", err)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// isNatssChannelReplay tells whether obj is a Subscription to a NatssChannel requesting a replay.
+func isNatssChannelReplay(obj interface{}) bool {
+	sub, ok := obj.(*messagingv1.Subscription)
+	if !ok || sub.Spec.Channel.Kind != "NatssChannel" {
+		return false
+	}
+	_, ok = sub.Annotations[messaging.ReplayFromAnnotationKey]
+	return ok
+}
+
+// enqueueSubscriptionChannel enqueues the channel of a Subscription.
+func (r *Reconciler) enqueueSubscriptionChannel(obj interface{}) {
+	if sub, ok := obj.(*messagingv1.Subscription); ok {
+		r.impl.EnqueueKey(types.NamespacedName{Namespace: sub.Namespace, Name: sub.Spec.Channel.Name})
+	}
+}
+
+// reconcileReplays starts the replays requested by the Subscriptions of natssChannel, and records
+// the outcome of the finished ones. A Subscription requests a replay by setting the
+// natss.messaging.knative.dev/replay-from annotation, the replay being handled once the value is
+// copied to natss.messaging.knative.dev/replayed-from.
+func (r *Reconciler) reconcileReplays(ctx context.Context, natssChannel *v1beta1.NatssChannel, subscribers []eventingduckv1.SubscriberSpec) {
+	replayer, ok := r.natssDispatcher.(dispatcher.Replayer)
+	if !ok || r.subscriptionLister == nil {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	recorder := controller.GetEventRecorder(ctx)
+
+	subs, err := r.subscriptionLister.Subscriptions(natssChannel.Namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorw("Error listing subscriptions", zap.Error(err))
+		return
+	}
+	specs := make(map[types.UID]eventingduckv1.SubscriberSpec, len(subscribers))
+	for _, spec := range subscribers {
+		specs[spec.UID] = spec
+	}
+
+	for _, sub := range subs {
+		if !isNatssChannelReplay(sub) || sub.Spec.Channel.Name != natssChannel.Name {
+			continue
+		}
+		value := sub.Annotations[messaging.ReplayFromAnnotationKey]
+		if value == sub.Annotations[messaging.ReplayedFromAnnotationKey] {
+			continue
+		}
+		spec, ok := specs[sub.UID]
+		if !ok {
+			// The subscriber is not added to the channel yet.
+			continue
+		}
+
+		status, known := replayer.Replay(sub.UID)
+		switch {
+		case known && status.State == dispatcher.ReplayRunning:
+			if status.ID != value {
+				recorder.Eventf(sub, corev1.EventTypeWarning, "ReplayRejected",
+					"Replay since %s rejected, a replay since %s is in progress", value, status.ID)
+			}
+
+		case known && status.ID == value:
+			if status.State == dispatcher.ReplayCompleted {
+				recorder.Eventf(sub, corev1.EventTypeNormal, "ReplayCompleted", "Completed %s", status)
+			} else {
+				recorder.Eventf(sub, corev1.EventTypeWarning, "ReplayFailed", "Failed %s", status)
+			}
+			if err := r.markReplayed(ctx, sub, value); err != nil {
+				logger.Errorw("Error marking the replay as handled", zap.String("subscription", sub.Name), zap.Error(err))
+				continue
+			}
+			replayer.ForgetReplay(sub.UID)
+
+		default:
+			// The replay of a previous request is finished.
+			replayer.ForgetReplay(sub.UID)
+
+			from, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				recorder.Eventf(sub, corev1.EventTypeWarning, "ReplayInvalid",
+					"Invalid %s annotation %q, expected an RFC3339 time: %v", messaging.ReplayFromAnnotationKey, value, err)
+				continue
+			}
+			key := types.NamespacedName{Namespace: natssChannel.Namespace, Name: natssChannel.Name}
+			if err := replayer.StartReplay(ctx, channelReference(natssChannel), spec, from, value, func() { r.enqueueKey(key) }); err != nil {
+				recorder.Eventf(sub, corev1.EventTypeWarning, "ReplayFailed", "Failed to start the replay since %s: %v", value, err)
+				continue
+			}
+			recorder.Eventf(sub, corev1.EventTypeNormal, "ReplayStarted", "Replaying the events published since %s", value)
+		}
+	}
+}
+
+// reportReplays shows the progress of the running replays in the status of the subscribers.
+func (r *Reconciler) reportReplays(natssChannel *v1beta1.NatssChannel) {
+	replayer, ok := r.natssDispatcher.(dispatcher.Replayer)
+	if !ok {
+		return
+	}
+	for i, status := range natssChannel.Status.Subscribers {
+		if replay, ok := replayer.Replay(status.UID); ok && replay.State == dispatcher.ReplayRunning && status.Message == "" {
+			natssChannel.Status.Subscribers[i].Message = replay.String()
+		}
+	}
+}
+
+// markReplayed records on sub that the replay requested with value was handled.
+func (r *Reconciler) markReplayed(ctx context.Context, sub *messagingv1.Subscription, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				messaging.ReplayedFromAnnotationKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.eventingClientSet.MessagingV1().Subscriptions(sub.Namespace).Patch(ctx, sub.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (r *Reconciler) enqueueKey(key types.NamespacedName) {
+	if r.impl != nil {
+		r.impl.EnqueueKey(key)
+	}
+}
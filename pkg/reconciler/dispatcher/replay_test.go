@@ -0,0 +1,209 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	messagingv1 "knative.dev/eventing/pkg/apis/messaging/v1"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	fakeeventingclientset "knative.dev/eventing/pkg/client/clientset/versioned/fake"
+	messaginglisters "knative.dev/eventing/pkg/client/listers/messaging/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	dispatchertesting "knative.dev/eventing-natss/pkg/dispatcher/testing"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const (
+	replaySubscriptionUID = "11111111-1111-1111-1111-111111111111"
+	replayFrom            = "2020-11-01T09:00:00Z"
+)
+
+type fakeReplayer struct {
+	dispatcher.NatssDispatcher
+
+	replays map[types.UID]dispatcher.ReplayStatus
+	started []string
+}
+
+var _ dispatcher.Replayer = (*fakeReplayer)(nil)
+
+func (r *fakeReplayer) StartReplay(_ context.Context, _ eventingchannels.ChannelReference, subscriber eventingduckv1.SubscriberSpec, from time.Time, id string, _ func()) error {
+	r.started = append(r.started, string(subscriber.UID)+"@"+from.Format(time.RFC3339))
+	r.replays[subscriber.UID] = dispatcher.ReplayStatus{ID: id, From: from, State: dispatcher.ReplayRunning}
+	return nil
+}
+
+func (r *fakeReplayer) Replay(subscriber types.UID) (dispatcher.ReplayStatus, bool) {
+	status, ok := r.replays[subscriber]
+	return status, ok
+}
+
+func (r *fakeReplayer) ForgetReplay(subscriber types.UID) {
+	delete(r.replays, subscriber)
+}
+
+func TestReconcileReplays(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, replayFrom)
+
+	testCases := map[string]struct {
+		annotations   map[string]string
+		replays       map[types.UID]dispatcher.ReplayStatus
+		wantStarted   []string
+		wantEvent     string
+		wantReplayed  string
+		wantForgotten bool
+	}{
+		"no replay requested": {},
+		"replay started": {
+			annotations: map[string]string{messaging.ReplayFromAnnotationKey: replayFrom},
+			wantStarted: []string{replaySubscriptionUID + "@" + replayFrom},
+			wantEvent:   "Normal ReplayStarted",
+		},
+		"replay already handled": {
+			annotations: map[string]string{
+				messaging.ReplayFromAnnotationKey:   replayFrom,
+				messaging.ReplayedFromAnnotationKey: replayFrom,
+			},
+		},
+		"replay in progress": {
+			annotations: map[string]string{messaging.ReplayFromAnnotationKey: replayFrom},
+			replays: map[types.UID]dispatcher.ReplayStatus{
+				replaySubscriptionUID: {ID: replayFrom, From: from, State: dispatcher.ReplayRunning},
+			},
+		},
+		"concurrent replay rejected": {
+			annotations: map[string]string{messaging.ReplayFromAnnotationKey: "2020-11-02T09:00:00Z"},
+			replays: map[types.UID]dispatcher.ReplayStatus{
+				replaySubscriptionUID: {ID: replayFrom, From: from, State: dispatcher.ReplayRunning},
+			},
+			wantEvent: "Warning ReplayRejected",
+		},
+		"replay completed": {
+			annotations: map[string]string{messaging.ReplayFromAnnotationKey: replayFrom},
+			replays: map[types.UID]dispatcher.ReplayStatus{
+				replaySubscriptionUID: {ID: replayFrom, From: from, State: dispatcher.ReplayCompleted, Delivered: 42},
+			},
+			wantEvent:     "Normal ReplayCompleted",
+			wantReplayed:  replayFrom,
+			wantForgotten: true,
+		},
+		"invalid time": {
+			annotations: map[string]string{messaging.ReplayFromAnnotationKey: "yesterday"},
+			wantEvent:   "Warning ReplayInvalid",
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			sub := &messagingv1.Subscription{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   testNS,
+					Name:        "sub",
+					UID:         replaySubscriptionUID,
+					Annotations: tc.annotations,
+				},
+				Spec: messagingv1.SubscriptionSpec{
+					Channel: corev1.ObjectReference{Kind: "NatssChannel", Name: ncName},
+				},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if err := indexer.Add(sub); err != nil {
+				t.Fatalf("failed to add the subscription: %v", err)
+			}
+			eventingClient := fakeeventingclientset.NewSimpleClientset(sub)
+
+			replays := tc.replays
+			if replays == nil {
+				replays = make(map[types.UID]dispatcher.ReplayStatus)
+			}
+			replayer := &fakeReplayer{NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(), replays: replays}
+			r := &Reconciler{
+				natssDispatcher:    replayer,
+				eventingClientSet:  eventingClient,
+				subscriptionLister: messaginglisters.NewSubscriptionLister(indexer),
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			ctx := controller.WithEventRecorder(context.Background(), recorder)
+			nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+			r.reconcileReplays(ctx, nc, nc.Spec.Subscribers)
+
+			if diff := cmp.Diff(tc.wantStarted, replayer.started); diff != "" {
+				t.Errorf("unexpected started replays (-want, +got): %s", diff)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+
+			got, err := eventingClient.MessagingV1().Subscriptions(testNS).Get(ctx, sub.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get the subscription: %v", err)
+			}
+			if tc.wantReplayed != "" && got.Annotations[messaging.ReplayedFromAnnotationKey] != tc.wantReplayed {
+				t.Errorf("%s = %q, want %q", messaging.ReplayedFromAnnotationKey, got.Annotations[messaging.ReplayedFromAnnotationKey], tc.wantReplayed)
+			}
+			if _, ok := replayer.replays[replaySubscriptionUID]; ok && tc.wantForgotten {
+				t.Error("the handled replay was not forgotten")
+			}
+		})
+	}
+}
+
+func TestReportReplays(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, replayFrom)
+	replayer := &fakeReplayer{
+		NatssDispatcher: dispatchertesting.NewDispatcherDoNothing(),
+		replays: map[types.UID]dispatcher.ReplayStatus{
+			replaySubscriptionUID: {ID: replayFrom, From: from, State: dispatcher.ReplayRunning, Delivered: 3},
+		},
+	}
+	r := &Reconciler{natssDispatcher: replayer}
+
+	nc := reconciletesting.NewNatssChannel(ncName, testNS, withSubscriberUIDs(replaySubscriptionUID))
+	nc.Status.SubscribableStatus = r.createSubscribableStatus(nc.Spec.Subscribers, nil)
+	r.reportReplays(nc)
+
+	want := []eventingduckv1.SubscriberStatus{{
+		UID:     replaySubscriptionUID,
+		Ready:   corev1.ConditionTrue,
+		Message: "replay since 2020-11-01T09:00:00Z running: 3 events delivered, 0 failed",
+	}}
+	if diff := cmp.Diff(want, nc.Status.Subscribers); diff != "" {
+		t.Errorf("unexpected subscribers status (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// minResyncInterval is the minimum delay between two global resyncs triggered
+// by the NATSS connection being (re-)established.
+var minResyncInterval = 30 * time.Second
+
+// resyncOnConnect calls resync every time a value is received from connected,
+// so that channels whose subscriptions failed during a NATSS outage are
+// reconciled as soon as the connection recovers instead of waiting for their
+// backoff. To avoid resync storms with a flapping connection, resync is called
+// at most once per minInterval, notifications received in the meantime being
+// coalesced. It returns when ctx is done.
+func resyncOnConnect(ctx context.Context, connected <-chan struct{}, minInterval time.Duration, resync func()) {
+	var last time.Time
+	for {
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			return
+		}
+
+		if wait := minInterval - time.Since(last); !last.IsZero() && wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			// Coalesce the notifications received while waiting.
+			select {
+			case <-connected:
+			default:
+			}
+		}
+		last = time.Now()
+		resync()
+	}
+}
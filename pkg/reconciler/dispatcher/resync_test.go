@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResyncOnConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// connected is the fake connection-state source.
+	connected := make(chan struct{}, 1)
+	resyncs := make(chan struct{}, 10)
+	minInterval := 200 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		resyncOnConnect(ctx, connected, minInterval, func() { resyncs <- struct{}{} })
+		close(done)
+	}()
+
+	// The first connection triggers a resync right away.
+	connected <- struct{}{}
+	select {
+	case <-resyncs:
+	case <-time.After(time.Second):
+		t.Fatal("no resync after the connection was established")
+	}
+
+	// A flapping connection does not trigger a resync before minInterval elapsed.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	}
+	select {
+	case <-resyncs:
+		if elapsed := time.Since(start); elapsed < minInterval/2 {
+			t.Errorf("resync triggered after %v, want at least ~%v", elapsed, minInterval)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no resync after the connection was re-established")
+	}
+
+	// The notifications received while waiting were coalesced.
+	select {
+	case <-resyncs:
+		t.Error("unexpected extra resync")
+	case <-time.After(2 * minInterval):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("resyncOnConnect did not return when the context was done")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/injection"
+	"knative.dev/pkg/system"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+// stateNamespace returns the namespace of the ConfigMaps holding the state of the dispatcher: the
+// namespace it is scoped to, if any, the system namespace otherwise.
+func stateNamespace(ctx context.Context) string {
+	if ns := injection.GetNamespaceScope(ctx); ns != "" {
+		return ns
+	}
+	return system.Namespace()
+}
+
+// inScope returns whether nc is served by the dispatcher scoped to namespace, the dispatcher of the
+// cluster when namespace is empty. The latter leaves the channels annotated with
+// eventing.knative.dev/scope: namespace to the dispatchers of their namespace, which serve only
+// those.
+func inScope(nc *v1beta1.NatssChannel, namespace string) bool {
+	if namespace == "" {
+		return !nc.IsNamespaceScoped()
+	}
+	return nc.Namespace == namespace && nc.IsNamespaceScoped()
+}
+
+// scopeFilter skips the reconciliation of the channels out of the scope of the dispatcher, which
+// the global resyncs and the resyncs on demand enqueue along the others.
+type scopeFilter struct {
+	leaderAwareReconciler
+
+	namespace string
+	lister    listers.NatssChannelLister
+}
+
+// Reconcile implements controller.Reconciler.
+func (f *scopeFilter) Reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return f.leaderAwareReconciler.Reconcile(ctx, key)
+	}
+	// The channels no longer in the informer are left to the generated reconciler.
+	if nc, err := f.lister.NatssChannels(namespace).Get(name); err == nil && !inScope(nc, f.namespace) {
+		return nil
+	}
+	return f.leaderAwareReconciler.Reconcile(ctx, key)
+}
+
+// isInScope returns a FilterFunc accepting the channels served by the dispatcher scoped to
+// namespace.
+func isInScope(namespace string) func(interface{}) bool {
+	return func(obj interface{}) bool {
+		nc, ok := obj.(*v1beta1.NatssChannel)
+		return ok && inScope(nc, namespace)
+	}
+}
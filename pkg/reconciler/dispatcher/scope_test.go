@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+// recordingReconciler records the keys it reconciles.
+type recordingReconciler struct {
+	leaderAwareReconciler
+
+	keys []string
+}
+
+func (r *recordingReconciler) Reconcile(_ context.Context, key string) error {
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+func TestScopeFilter(t *testing.T) {
+	lister := reconciletesting.NewNatssChannelLister(
+		reconciletesting.NewNatssChannel("cluster", "team-a"),
+		reconciletesting.NewNatssChannel("namespaced", "team-a", reconciletesting.WithNatssChannelNamespaceScoped),
+		reconciletesting.NewNatssChannel("namespaced", "team-b", reconciletesting.WithNatssChannelNamespaceScoped),
+	)
+	keys := []string{"team-a/cluster", "team-a/namespaced", "team-b/namespaced", "team-a/deleted", "not/a/key"}
+
+	testCases := map[string]struct {
+		namespace string
+		want      []string
+	}{
+		"cluster": {
+			want: []string{"team-a/cluster", "team-a/deleted", "not/a/key"},
+		},
+		"namespace": {
+			namespace: "team-a",
+			want:      []string{"team-a/namespaced", "team-a/deleted", "not/a/key"},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			recorder := &recordingReconciler{}
+			f := &scopeFilter{leaderAwareReconciler: recorder, namespace: tc.namespace, lister: lister}
+			for _, key := range keys {
+				if err := f.Reconcile(context.Background(), key); err != nil {
+					t.Fatalf("Reconcile(%q) = %v", key, err)
+				}
+			}
+			if diff := cmp.Diff(tc.want, recorder.keys); diff != "" {
+				t.Errorf("unexpected reconciled keys (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import "context"
+
+// stoppedKey is the context key of the channel closed once the dispatcher stopped.
+type stoppedKey struct{}
+
+// WithShutdownWait returns ctx along a function waiting, once ctx is done, for the dispatcher
+// started by NewController with ctx to stop. sharedmain returns as soon as ctx is done: the
+// process waits before exiting, so that the events being dispatched are delivered.
+func WithShutdownWait(ctx context.Context) (context.Context, func()) {
+	stopped := make(chan struct{})
+	return context.WithValue(ctx, stoppedKey{}, stopped), func() {
+		<-stopped
+	}
+}
+
+// markStopped tells the function returned by WithShutdownWait, if any, that the dispatcher
+// started with ctx stopped.
+func markStopped(ctx context.Context) {
+	if stopped, ok := ctx.Value(stoppedKey{}).(chan struct{}); ok {
+		close(stopped)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownWait(t *testing.T) {
+	ctx, waitStopped := WithShutdownWait(context.Background())
+	waited := make(chan struct{})
+	go func() {
+		waitStopped()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("the wait ended before the dispatcher stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	markStopped(ctx)
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wait did not end once the dispatcher stopped")
+	}
+
+	// The dispatchers started without WithShutdownWait are not waited for.
+	markStopped(context.Background())
+}
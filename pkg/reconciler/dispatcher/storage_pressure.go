@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// storagePressureReason is the reason of the events of the channels when the pressure of the store
+// of NATSS rises.
+const storagePressureReason = "StoragePressure"
+
+// storageUtilizationM records the percentage of the store of NATSS in use.
+var storageUtilizationM = stats.Float64(
+	"natss_storage_utilization_percent",
+	"Percentage of the store of NATSS in use",
+	stats.UnitDimensionless,
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: storageUtilizationM.Description(),
+			Measure:     storageUtilizationM,
+			Aggregation: view.LastValue(),
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// storez is the part of the store page of the monitoring endpoint of NATSS read by the monitor.
+type storez struct {
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// storageMonitor periodically reads how full the store of NATSS is from its monitoring endpoint,
+// reports the pressure to the dispatcher, and records an event on the channels when it rises.
+type storageMonitor struct {
+	setter dispatcher.StoragePressureSetter
+	lister listers.NatssChannelLister
+	client *http.Client
+
+	mu       sync.Mutex
+	config   config.Storage
+	pressure dispatcher.StoragePressure
+	// recorder is the event recorder of the reconciliations of the channels, nil until one is
+	// reconciled.
+	recorder record.EventRecorder
+}
+
+func newStorageMonitor(setter dispatcher.StoragePressureSetter, lister listers.NatssChannelLister, c config.Storage) *storageMonitor {
+	return &storageMonitor{setter: setter, lister: lister, client: &http.Client{}, config: c}
+}
+
+// setConfig applies c from the next poll.
+func (m *storageMonitor) setConfig(c config.Storage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config = c
+}
+
+// observe records the event recorder of ctx, the context of a reconciliation of a channel.
+func (m *storageMonitor) observe(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recorder = controller.GetEventRecorder(ctx)
+}
+
+// register registers the hook polling the monitoring endpoint.
+func (m *storageMonitor) register(lifecycle *dispatcher.Lifecycle) error {
+	// The pressure is known before the receiver accepts events.
+	return lifecycle.Register(lifecycle.RunHook("storage-monitor", dispatcher.PriorityReceiver-1, func(ctx context.Context) error {
+		m.run(ctx)
+		return nil
+	}))
+}
+
+// run polls every interval, until ctx is done.
+func (m *storageMonitor) run(ctx context.Context) {
+	for {
+		m.mu.Lock()
+		c := m.config
+		m.mu.Unlock()
+		m.poll(ctx, c)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// poll reads the utilization of the store from the monitoring endpoint of c, and reports its
+// pressure. The pressure is reported as none while the endpoint cannot be read, so that a failing
+// monitor does not refuse events: the publications fail on their own when the store is full.
+func (m *storageMonitor) poll(ctx context.Context, c config.Storage) {
+	pressure, percent := dispatcher.StoragePressureNone, 0.0
+	if c.MonitoringURL != nil {
+		used, err := m.read(ctx, c)
+		if err != nil {
+			logging.FromContext(ctx).Warnw("Failed to read the utilization of the NATSS store", zap.Error(err))
+		} else {
+			percent = 100 * float64(used) / float64(c.Capacity)
+			switch {
+			case percent >= c.CriticalPercent:
+				pressure = dispatcher.StoragePressureCritical
+			case percent >= c.WarningPercent:
+				pressure = dispatcher.StoragePressureWarning
+			}
+			metrics.Record(ctx, storageUtilizationM.M(percent))
+		}
+	}
+	m.setter.SetStoragePressure(pressure, percent)
+
+	m.mu.Lock()
+	rising := pressure > m.pressure
+	m.pressure = pressure
+	recorder := m.recorder
+	m.mu.Unlock()
+	if rising {
+		logging.FromContext(ctx).Warnw("The NATSS store is under pressure", zap.Stringer("pressure", pressure), zap.Float64("percent", percent))
+		if recorder != nil {
+			m.recordPressure(ctx, recorder, pressure, percent)
+		}
+	}
+}
+
+// read returns the number of bytes in use in the store.
+func (m *storageMonitor) read(ctx context.Context, c config.Storage) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.MonitoringURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s answered %d", c.MonitoringURL, resp.StatusCode)
+	}
+	var page storez
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return 0, fmt.Errorf("failed to decode the store page: %w", err)
+	}
+	return page.TotalBytes, nil
+}
+
+// recordPressure records the rise of the pressure on the channels, telling those whose events are
+// refused.
+func (m *storageMonitor) recordPressure(ctx context.Context, recorder record.EventRecorder, pressure dispatcher.StoragePressure, percent float64) {
+	channels, err := m.lister.List(labels.Everything())
+	if err != nil {
+		logging.FromContext(ctx).Warnw("Failed to list the channels to report the storage pressure", zap.Error(err))
+		return
+	}
+	for _, nc := range channels {
+		if pressure == dispatcher.StoragePressureCritical && nc.Labels[messaging.ShedOnPressureLabelKey] == "true" {
+			recorder.Eventf(nc, corev1.EventTypeWarning, storagePressureReason,
+				"The NATSS store is %.1f%% full, the events of the channel are refused", percent)
+			continue
+		}
+		recorder.Eventf(nc, corev1.EventTypeWarning, storagePressureReason, "The NATSS store is %.1f%% full", percent)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	eventingchannels "knative.dev/eventing/pkg/channel"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+type fakeStoragePressureSetter struct {
+	pressure dispatcher.StoragePressure
+	percent  float64
+}
+
+func (f *fakeStoragePressureSetter) SetStoragePressure(pressure dispatcher.StoragePressure, percent float64) {
+	f.pressure, f.percent = pressure, percent
+}
+
+func (f *fakeStoragePressureSetter) SetShedOnPressure(eventingchannels.ChannelReference, bool) {}
+
+// newStorezServer stubs the monitoring endpoint of NATSS, answering the store page with the bytes
+// in use read from used, or 500 when it is negative.
+func newStorezServer(used *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/streaming/storez" || *used < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"cluster_id": "knative-nats-streaming", "type": "FILE", "total_msgs": 12, "total_bytes": %d}`, *used)
+	}))
+}
+
+func TestStorageMonitor(t *testing.T) {
+	var used int64
+	server := newStorezServer(&used)
+	defer server.Close()
+	monitoringURL, err := apis.ParseURL(server.URL + "/streaming/storez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shed := reconciletesting.NewNatssChannel("shed", testNS)
+	shed.Labels = map[string]string{messaging.ShedOnPressureLabelKey: "true"}
+	listers := reconciletesting.NewListers([]runtime.Object{shed, reconciletesting.NewNatssChannel("kept", testNS)})
+
+	setter := &fakeStoragePressureSetter{}
+	c := config.Storage{MonitoringURL: monitoringURL, Capacity: 1000, WarningPercent: 80, CriticalPercent: 95, PollInterval: time.Minute}
+	m := newStorageMonitor(setter, listers.GetNatssChannelLister(), c)
+	recorder := record.NewFakeRecorder(10)
+	m.observe(controller.WithEventRecorder(context.Background(), recorder))
+
+	// The steps are polled in order, each one rising, keeping or dropping the pressure.
+	steps := []struct {
+		used         int64
+		wantPressure dispatcher.StoragePressure
+		wantPercent  float64
+		wantEvents   []string
+	}{{
+		used:        500,
+		wantPercent: 50,
+	}, {
+		used:         850,
+		wantPressure: dispatcher.StoragePressureWarning,
+		wantPercent:  85,
+		wantEvents: []string{
+			"Warning StoragePressure The NATSS store is 85.0% full",
+			"Warning StoragePressure The NATSS store is 85.0% full",
+		},
+	}, {
+		used:         900,
+		wantPressure: dispatcher.StoragePressureWarning,
+		wantPercent:  90,
+	}, {
+		used:         960,
+		wantPressure: dispatcher.StoragePressureCritical,
+		wantPercent:  96,
+		wantEvents: []string{
+			"Warning StoragePressure The NATSS store is 96.0% full",
+			"Warning StoragePressure The NATSS store is 96.0% full, the events of the channel are refused",
+		},
+	}, {
+		// The pressure is dropped while the endpoint cannot be read.
+		used: -1,
+	}, {
+		used:         990,
+		wantPressure: dispatcher.StoragePressureCritical,
+		wantPercent:  99,
+		wantEvents: []string{
+			"Warning StoragePressure The NATSS store is 99.0% full",
+			"Warning StoragePressure The NATSS store is 99.0% full, the events of the channel are refused",
+		},
+	}}
+	for i, step := range steps {
+		used = step.used
+		m.poll(context.Background(), c)
+		if setter.pressure != step.wantPressure || setter.percent != step.wantPercent {
+			t.Errorf("step %d: pressure = %v at %.1f%%, want %v at %.1f%%", i, setter.pressure, setter.percent, step.wantPressure, step.wantPercent)
+		}
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		sort.Strings(events)
+		if strings.Join(events, "\n") != strings.Join(step.wantEvents, "\n") {
+			t.Errorf("step %d: events = %q, want %q", i, events, step.wantEvents)
+		}
+	}
+}
+
+func TestStorageMonitorDisabled(t *testing.T) {
+	setter := &fakeStoragePressureSetter{pressure: dispatcher.StoragePressureCritical}
+	listers := reconciletesting.NewListers(nil)
+	c := config.Storage{WarningPercent: 80, CriticalPercent: 95, PollInterval: time.Minute}
+	newStorageMonitor(setter, listers.GetNatssChannelLister(), c).poll(context.Background(), c)
+	if setter.pressure != dispatcher.StoragePressureNone {
+		t.Errorf("pressure = %v without a monitoring URL, want none", setter.pressure)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"knative.dev/eventing-natss/pkg/config"
+	"knative.dev/eventing-natss/pkg/dispatcher"
+)
+
+// supportBundlePath is the path of the endpoint making a support bundle of the state of the
+// dispatcher, requested with POST.
+const supportBundlePath = "/debug/support-bundle"
+
+const redacted = "REDACTED"
+
+var (
+	// sensitiveKey matches the JSON keys whose values the bundles redact.
+	sensitiveKey = regexp.MustCompile(`(?i)password|passwd|secret|token|credential|private.?key|nkey|seed|authorization`)
+	// urlUserinfo matches the user info of the URLs, which may hold a password.
+	urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+)
+
+// supportBundle is the JSON document of a support bundle, gzipped.
+type supportBundle struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// Sections hold the state of the subsystems, by name.
+	Sections map[string]json.RawMessage `json:"sections"`
+	// Omitted are the sections left out for the bundle not to exceed its size.
+	Omitted []string `json:"omitted,omitempty"`
+	// Errors are why the sections missing could not be dumped, by name.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// supportBundleWritten answers the requests whose bundle was written to the directory of the
+// bundles.
+type supportBundleWritten struct {
+	Path  string `json:"path"`
+	Bytes int    `json:"bytes"`
+}
+
+// supportBundleHandler makes the support bundles, holding the state of its dumpers with the
+// secrets redacted, one at a time.
+type supportBundleHandler struct {
+	mu      sync.Mutex
+	dumpers map[string]dispatcher.DebugDumper
+	// dir is where the bundles are written, empty sending them in the responses.
+	dir string
+	// maxBytes is how many bytes of JSON the sections of a bundle hold.
+	maxBytes int64
+	now      func() time.Time
+}
+
+func newSupportBundleHandler(cfg config.SupportBundle) *supportBundleHandler {
+	return &supportBundleHandler{
+		dumpers:  make(map[string]dispatcher.DebugDumper),
+		dir:      cfg.Dir,
+		maxBytes: cfg.MaxBytes,
+		now:      time.Now,
+	}
+}
+
+// add makes dumper contribute the section name of the bundles.
+func (h *supportBundleHandler) add(name string, dumper dispatcher.DebugDumper) {
+	h.dumpers[name] = dumper
+}
+
+// addSource makes the dumpers of source contribute to the bundles.
+func (h *supportBundleHandler) addSource(source dispatcher.DebugDumperSource) {
+	for name, dumper := range source.DebugDumpers() {
+		h.add(name, dumper)
+	}
+}
+
+// ServeHTTP makes a support bundle, sent in the response or written to the directory of the
+// bundles.
+func (h *supportBundleHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "a support bundle is made with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now().UTC()
+	var data bytes.Buffer
+	zw := gzip.NewWriter(&data)
+	if err := json.NewEncoder(zw).Encode(h.bundle(now)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := fmt.Sprintf("support-bundle-%s.json.gz", now.Format("20060102T150405Z"))
+	if h.dir == "" {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		_, _ = w.Write(data.Bytes())
+		return
+	}
+	path, err := writeFileAtomically(h.dir, name, data.Bytes())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to write the support bundle: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(supportBundleWritten{Path: path, Bytes: data.Len()})
+}
+
+// bundle dumps the sections of a bundle, in the order of their names, leaving out those which
+// would make it hold more than maxBytes.
+func (h *supportBundleHandler) bundle(now time.Time) *supportBundle {
+	bundle := &supportBundle{CreatedAt: now, Sections: make(map[string]json.RawMessage)}
+	names := make([]string, 0, len(h.dumpers))
+	for name := range h.dumpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var size int64
+	for _, name := range names {
+		section, err := dumpSection(h.dumpers[name])
+		if err != nil {
+			if bundle.Errors == nil {
+				bundle.Errors = make(map[string]string)
+			}
+			bundle.Errors[name] = err.Error()
+			continue
+		}
+		if size+int64(len(section)) > h.maxBytes {
+			bundle.Omitted = append(bundle.Omitted, name)
+			continue
+		}
+		size += int64(len(section))
+		bundle.Sections[name] = section
+	}
+	return bundle
+}
+
+// dumpSection returns the state of dumper as JSON, redacted.
+func dumpSection(dumper dispatcher.DebugDumper) (section json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic dumping the section: %v", r)
+		}
+	}()
+	raw, err := json.Marshal(dumper.DebugDump())
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redact(value))
+}
+
+// redact replaces the values of the sensitive keys of value, and the user info of its URLs.
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if sensitiveKey.MatchString(key) && item != nil && item != "" {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redact(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	case string:
+		return urlUserinfo.ReplaceAllString(v, "${1}"+redacted+"@")
+	}
+	return value
+}
+
+// writeFileAtomically writes data to the file name of dir through a temporary file, so that a
+// partial file is never left behind, and returns its path.
+func writeFileAtomically(dir, name string, data []byte) (string, error) {
+	tmp, err := ioutil.TempFile(dir, "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	return path, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/runtimemetrics"
+)
+
+const (
+	// DefaultDedupWindow is the period during which an identical transition
+	// is not reported twice for the same object.
+	DefaultDedupWindow = 5 * time.Minute
+
+	subscriberReady = "SubscriberReady"
+)
+
+// Object is the subset of a Kubernetes object the ConditionRecorder needs.
+type Object interface {
+	runtime.Object
+	metav1.Object
+}
+
+// state is the part of a condition (or of a subscriber status) whose change is
+// reported as a transition.
+type state struct {
+	status  corev1.ConditionStatus
+	reason  string
+	message string
+}
+
+type transitionKey struct {
+	object types.NamespacedName
+	id     string
+	status corev1.ConditionStatus
+	reason string
+}
+
+// ConditionRecorder emits a Kubernetes event for every condition transition
+// between two observations of an object. Identical transitions of the same
+// object are emitted at most once per dedup window, so that a flapping
+// condition does not flood the event stream.
+type ConditionRecorder struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	emitted   map[transitionKey]time.Time
+	lastPrune time.Time
+}
+
+// NewConditionRecorder creates a ConditionRecorder suppressing identical
+// transitions during the given window.
+func NewConditionRecorder(window time.Duration) *ConditionRecorder {
+	return &ConditionRecorder{
+		window:  window,
+		now:     time.Now,
+		emitted: make(map[transitionKey]time.Time),
+	}
+}
+
+// RecordConditions compares the conditions before and after a reconciliation
+// and emits a Normal (True, Unknown) or Warning (False) event for each
+// condition whose status or reason changed, using the event recorder from the
+// context. Conditions appearing for the first time with status Unknown are not
+// reported since they only reflect the initialization of the condition set.
+func (r *ConditionRecorder) RecordConditions(ctx context.Context, obj Object, before, after duckv1.Conditions) {
+	previous := make(map[string]state, len(before))
+	for _, c := range before {
+		previous[string(c.Type)] = state{status: c.Status, reason: c.Reason, message: c.Message}
+	}
+	for _, c := range after {
+		id := string(c.Type)
+		r.record(ctx, obj, id, id, previous, state{status: c.Status, reason: c.Reason, message: c.Message})
+	}
+}
+
+// RecordSubscribers does the same as RecordConditions for the readiness of
+// each subscriber listed in the SubscribableStatus of a channel.
+func (r *ConditionRecorder) RecordSubscribers(ctx context.Context, obj Object, before, after []eventingduckv1.SubscriberStatus) {
+	previous := make(map[string]state, len(before))
+	for _, s := range before {
+		previous[string(s.UID)] = state{status: s.Ready, message: s.Message}
+	}
+	for _, s := range after {
+		r.record(ctx, obj, string(s.UID), fmt.Sprintf("Subscriber %q", s.UID), previous, state{status: s.Ready, message: s.Message})
+	}
+}
+
+func (r *ConditionRecorder) record(ctx context.Context, obj Object, id, subject string, previous map[string]state, current state) {
+	recorder := controller.GetEventRecorder(ctx)
+	if recorder == nil {
+		return
+	}
+
+	old, ok := previous[id]
+	if !ok && current.status == corev1.ConditionUnknown {
+		return
+	}
+	if ok && old.status == current.status && old.reason == current.reason {
+		return
+	}
+	nn := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if !r.shouldEmit(transitionKey{object: nn, id: id, status: current.status, reason: current.reason}) {
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if current.status == corev1.ConditionFalse {
+		eventType = corev1.EventTypeWarning
+	}
+	reason := id
+	if subject != id {
+		reason = subscriberReady
+	}
+	recorder.Event(obj, eventType, reason+string(current.status), transitionMessage(subject, current))
+}
+
+func (r *ConditionRecorder) shouldEmit(key transitionKey) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastPrune) > r.window {
+		for k, t := range r.emitted {
+			if now.Sub(t) > r.window {
+				delete(r.emitted, k)
+			}
+		}
+		r.lastPrune = now
+	}
+
+	if t, ok := r.emitted[key]; ok && now.Sub(t) <= r.window {
+		return false
+	}
+	r.emitted[key] = now
+	return true
+}
+
+// Len returns the number of transitions held to suppress their repetition, the ones of the
+// past windows being pruned as new transitions are reported.
+func (r *ConditionRecorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.emitted)
+}
+
+// Sizer returns the runtimemetrics.Sizer of the transitions held, for component.
+func (r *ConditionRecorder) Sizer(component string) runtimemetrics.Sizer {
+	return func() []runtimemetrics.Structure {
+		return []runtimemetrics.Structure{{Component: component, Name: "dedup_cache_entries", Size: int64(r.Len())}}
+	}
+}
+
+func transitionMessage(subject string, s state) string {
+	msg := fmt.Sprintf("%s is %s", subject, s.status)
+	if s.reason != "" {
+		msg += ": " + s.reason
+	}
+	if s.message != "" {
+		msg += ": " + s.message
+	}
+	return msg
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/controller"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+func TestRecordConditions(t *testing.T) {
+	testCases := map[string]struct {
+		before duckv1.Conditions
+		after  duckv1.Conditions
+		want   []string
+	}{
+		"new condition": {
+			after: duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}},
+			want:  []string{"Normal ReadyTrue Ready is True"},
+		},
+		"new unknown condition": {
+			after: duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionUnknown}},
+		},
+		"unchanged condition": {
+			before: duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}},
+			after:  duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}},
+		},
+		"status changed": {
+			before: duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}},
+			after:  duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionFalse, Reason: "Broken", Message: "it broke"}},
+			want:   []string{"Warning ReadyFalse Ready is False: Broken: it broke"},
+		},
+		"reason changed": {
+			before: duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionFalse, Reason: "Broken"}},
+			after:  duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionFalse, Reason: "StillBroken"}},
+			want:   []string{"Warning ReadyFalse Ready is False: StillBroken"},
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			ctx, recorder := withFakeRecorder()
+			NewConditionRecorder(DefaultDedupWindow).RecordConditions(ctx, newChannel(), tc.before, tc.after)
+			if diff := cmp.Diff(tc.want, drain(recorder)); diff != "" {
+				t.Errorf("unexpected events (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRecordSubscribers(t *testing.T) {
+	ctx, recorder := withFakeRecorder()
+	before := []eventingduckv1.SubscriberStatus{{UID: "sub", Ready: corev1.ConditionTrue}}
+	after := []eventingduckv1.SubscriberStatus{{UID: "sub", Ready: corev1.ConditionFalse, Message: "ups"}}
+
+	NewConditionRecorder(DefaultDedupWindow).RecordSubscribers(ctx, newChannel(), before, after)
+
+	want := []string{`Warning SubscriberReadyFalse Subscriber "sub" is False: ups`}
+	if diff := cmp.Diff(want, drain(recorder)); diff != "" {
+		t.Errorf("unexpected events (-want, +got): %s", diff)
+	}
+}
+
+func TestRecordConditionsDedup(t *testing.T) {
+	ctx, recorder := withFakeRecorder()
+	now := time.Unix(0, 0)
+	r := NewConditionRecorder(time.Minute)
+	r.now = func() time.Time { return now }
+
+	ready := duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionTrue}}
+	notReady := duckv1.Conditions{{Type: apis.ConditionReady, Status: corev1.ConditionFalse}}
+
+	// Flapping within the window only reports each transition once.
+	r.RecordConditions(ctx, newChannel(), ready, notReady)
+	r.RecordConditions(ctx, newChannel(), notReady, ready)
+	r.RecordConditions(ctx, newChannel(), ready, notReady)
+	r.RecordConditions(ctx, newChannel(), notReady, ready)
+
+	want := []string{"Warning ReadyFalse Ready is False", "Normal ReadyTrue Ready is True"}
+	if diff := cmp.Diff(want, drain(recorder)); diff != "" {
+		t.Errorf("unexpected events (-want, +got): %s", diff)
+	}
+	if got := r.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+
+	// Once the window expired the transition is reported again.
+	now = now.Add(2 * time.Minute)
+	r.RecordConditions(ctx, newChannel(), ready, notReady)
+
+	want = []string{"Warning ReadyFalse Ready is False"}
+	if diff := cmp.Diff(want, drain(recorder)); diff != "" {
+		t.Errorf("unexpected events (-want, +got): %s", diff)
+	}
+	// The transitions of the expired window are pruned.
+	if got := r.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func withFakeRecorder() (context.Context, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return controller.WithEventRecorder(context.Background(), recorder), recorder
+}
+
+func newChannel() *v1beta1.NatssChannel {
+	return &v1beta1.NatssChannel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-nc"},
+	}
+}
+
+func drain(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package maintenance pauses the reconciliations of the NatssChannels while config-natss enables
+// the maintenance mode of the NATSS cluster.
+package maintenance
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/eventing-natss/pkg/client/clientset/versioned/scheme"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+// Reason is the reason of the event recorded on the channels whose reconciliation the maintenance
+// mode skipped.
+const Reason = "MaintenanceMode"
+
+// Gate skips the reconciliations of the channels while the maintenance mode is enabled, so that
+// nothing is changed, subscribed, or finalized until it is disabled. The channels deleted in the
+// meantime keep their finalizers and are finalized once it is.
+type Gate struct {
+	component string
+	lister    listers.NatssChannelLister
+	recorder  record.EventRecorder
+	logger    *zap.SugaredLogger
+
+	mu      sync.Mutex
+	enabled bool
+	// notified holds the keys of the channels the event was recorded on since the maintenance
+	// mode was enabled, and deletions those of the channels deleted.
+	notified  sets.String
+	deletions sets.String
+}
+
+// NewGate returns the gate of the reconciliations of the component, the maintenance mode being
+// disabled. The events are recorded with the recorder of ctx, or of a new broadcaster.
+func NewGate(ctx context.Context, component string, lister listers.NatssChannelLister) *Gate {
+	return &Gate{
+		component: component,
+		lister:    lister,
+		recorder:  newRecorder(ctx, component),
+		logger:    logging.FromContext(ctx).With(zap.String("controller", component)),
+		notified:  sets.NewString(),
+		deletions: sets.NewString(),
+	}
+}
+
+// newRecorder returns the recorder of ctx, or one recording the events of the component to the
+// API server until ctx is done, as the generated reconcilers do.
+func newRecorder(ctx context.Context, component string) record.EventRecorder {
+	if recorder := controller.GetEventRecorder(ctx); recorder != nil {
+		return recorder
+	}
+	broadcaster := record.NewBroadcaster()
+	watches := []watch.Interface{
+		broadcaster.StartLogging(logging.FromContext(ctx).Named("event-broadcaster").Infof),
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclient.Get(ctx).CoreV1().Events("")}),
+	}
+	go func() {
+		<-ctx.Done()
+		for _, w := range watches {
+			w.Stop()
+		}
+	}()
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// Set enables or disables the maintenance mode. It returns true when it was disabled, the channels
+// having then to be reconciled again, those deleted meanwhile included.
+func (g *Gate) Set(enabled bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if enabled == g.enabled {
+		return false
+	}
+	g.enabled = enabled
+	if enabled {
+		g.logger.Info("Maintenance mode enabled, the channels are no longer reconciled")
+		return false
+	}
+	g.logger.Infow("Maintenance mode disabled, reconciling all the channels", zap.Int("deletions", g.deletions.Len()))
+	g.notified = sets.NewString()
+	g.deletions = sets.NewString()
+	return true
+}
+
+// Enabled tells whether the maintenance mode is enabled.
+func (g *Gate) Enabled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.enabled
+}
+
+// Skip tells whether the reconciliation of the channel of key is skipped, recording the
+// MaintenanceMode event on the channel the first time, and queueing its deletion.
+func (g *Gate) Skip(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.enabled {
+		return false
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return true
+	}
+	nc, err := g.lister.NatssChannels(namespace).Get(name)
+	if err != nil {
+		// Gone, there is nothing left to reconcile.
+		return true
+	}
+	if !nc.DeletionTimestamp.IsZero() && !g.deletions.Has(key) {
+		g.deletions.Insert(key)
+		g.logger.Infow("Channel deleted during the maintenance, finalized once it ends", zap.String("key", key))
+	}
+	if !g.notified.Has(key) {
+		g.notified.Insert(key)
+		g.recorder.Eventf(nc, corev1.EventTypeNormal, Reason,
+			"The %s does not reconcile the channel while config-natss enables the maintenance mode", g.component)
+	}
+	return true
+}
+
+// Deletions returns the keys of the channels deleted during the maintenance.
+func (g *Gate) Deletions() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.deletions.List()
+}
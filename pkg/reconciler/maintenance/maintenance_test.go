@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package maintenance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/controller"
+
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const testNS = "test-namespace"
+
+func TestGate(t *testing.T) {
+	lister := reconciletesting.NewNatssChannelLister(
+		reconciletesting.NewNatssChannel("live", testNS),
+		reconciletesting.NewNatssChannel("deleted", testNS, reconciletesting.WithNatssChannelDeleted),
+	)
+	recorder := record.NewFakeRecorder(10)
+	g := NewGate(controller.WithEventRecorder(context.Background(), recorder), "test", lister)
+
+	// Disabled, everything is reconciled.
+	if g.Skip(testNS + "/live") {
+		t.Error("Skip() = true before the maintenance")
+	}
+	if g.Set(false) {
+		t.Error("Set(false) = true while disabled")
+	}
+
+	// Entering.
+	if g.Set(true) {
+		t.Error("Set(true) = true")
+	}
+	if !g.Enabled() {
+		t.Error("Enabled() = false after Set(true)")
+	}
+
+	// Operating, the event being recorded once per channel.
+	for i := 0; i < 3; i++ {
+		for _, key := range []string{testNS + "/live", testNS + "/deleted", testNS + "/gone"} {
+			if !g.Skip(key) {
+				t.Errorf("Skip(%q) = false during the maintenance", key)
+			}
+		}
+	}
+	if got := len(recorder.Events); got != 2 {
+		t.Errorf("recorded %d events, want 2", got)
+	}
+	want := "Normal " + Reason + " The test does not reconcile the channel while config-natss enables the maintenance mode"
+	if got := <-recorder.Events; got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]string{testNS + "/deleted"}, g.Deletions()); diff != "" {
+		t.Errorf("unexpected deletions (-want, +got): %s", diff)
+	}
+
+	// Exiting, the caller resyncing the channels.
+	if !g.Set(false) {
+		t.Error("Set(false) = false when exiting")
+	}
+	if g.Skip(testNS + "/deleted") {
+		t.Error("Skip() = true after the maintenance")
+	}
+	if got := g.Deletions(); len(got) != 0 {
+		t.Errorf("Deletions() = %v after the maintenance", got)
+	}
+
+	// Entering again, the events are recorded again.
+	<-recorder.Events
+	g.Set(true)
+	g.Skip(testNS + "/live")
+	if got := len(recorder.Events); got != 1 {
+		t.Errorf("recorded %d events in the second maintenance, want 1", got)
+	}
+}
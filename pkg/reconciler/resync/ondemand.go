@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resync
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+// OnDemand enqueues all the cached NatssChannels once when requested, either by changing the
+// resync annotation of the config-natss ConfigMap or by POSTing to its HTTP handler, so that a
+// change of the defaults applies without waiting for the periodic resyncs.
+type OnDemand struct {
+	name    string
+	lister  listers.NatssChannelLister
+	enqueue func(types.NamespacedName)
+	logger  *zap.SugaredLogger
+
+	mu sync.Mutex
+	// request is the last resync request observed, observed telling whether there was one.
+	request  string
+	observed bool
+}
+
+// NewOnDemand returns an OnDemand resyncing the channels listed by lister, named after the
+// controller calling enqueue. enqueue should go through the rate limiter of the work queue, which
+// throttles the repeated resyncs.
+func NewOnDemand(name string, lister listers.NatssChannelLister, enqueue func(types.NamespacedName), logger *zap.SugaredLogger) *OnDemand {
+	return &OnDemand{
+		name:    name,
+		lister:  lister,
+		enqueue: enqueue,
+		logger:  logger,
+	}
+}
+
+// Observe resyncs all the channels when request differs from the previous request observed. The
+// first request is only recorded: the channels are all reconciled when the controller starts.
+func (o *OnDemand) Observe(request string) {
+	o.mu.Lock()
+	changed := o.observed && request != o.request
+	o.request, o.observed = request, true
+	o.mu.Unlock()
+
+	if !changed || request == "" {
+		return
+	}
+	if _, err := o.resyncAll("annotation " + request); err != nil {
+		o.logger.Errorw("Error resyncing all the NatssChannels", zap.Error(err))
+	}
+}
+
+// ServeHTTP resyncs all the channels on POST, replying with the number of channels enqueued.
+func (o *OnDemand) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	n, err := o.resyncAll("admin endpoint")
+	if err != nil {
+		o.logger.Errorw("Error resyncing all the NatssChannels", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%d channels enqueued\n", n)
+}
+
+// resyncAll enqueues all the cached channels, returning how many were.
+func (o *OnDemand) resyncAll(trigger string) (int, error) {
+	keys, err := selectChannels(o.lister, func(*v1beta1.NatssChannel) bool { return true })
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		o.enqueue(key)
+	}
+	o.logger.Infow("Resyncing all the NatssChannels on demand", zap.String("controller", o.name),
+		zap.String("trigger", trigger), zap.Int("channels", len(keys)))
+	return len(keys), nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+func newTestOnDemand() (*OnDemand, *[]types.NamespacedName) {
+	lister := reconciletesting.NewNatssChannelLister(
+		reconciletesting.NewNatssChannel("ready", testNS, reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("not-ready", testNS, reconciletesting.WithNotReady("DispatcherNotReady", "")),
+	)
+	var enqueued []types.NamespacedName
+	o := NewOnDemand("test", lister, func(key types.NamespacedName) {
+		enqueued = append(enqueued, key)
+	}, zap.NewNop().Sugar())
+	return o, &enqueued
+}
+
+func TestOnDemandObserve(t *testing.T) {
+	o, enqueued := newTestOnDemand()
+
+	// The request found on start is only recorded.
+	o.Observe("2020-10-01T12:00:00Z")
+	o.Observe("2020-10-01T12:00:00Z")
+	if len(*enqueued) != 0 {
+		t.Fatalf("enqueued %v, want nothing until the request changes", names(*enqueued))
+	}
+
+	o.Observe("2020-10-02T12:00:00Z")
+	if diff := cmp.Diff([]string{"not-ready", "ready"}, names(*enqueued)); diff != "" {
+		t.Errorf("enqueued (-want, +got) = %s", diff)
+	}
+
+	// Removing the annotation does not resync.
+	*enqueued = nil
+	o.Observe("")
+	if len(*enqueued) != 0 {
+		t.Errorf("enqueued %v, want nothing when the request is removed", names(*enqueued))
+	}
+}
+
+func TestOnDemandServeHTTP(t *testing.T) {
+	o, enqueued := newTestOnDemand()
+
+	rec := httptest.NewRecorder()
+	o.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resync", nil))
+	if rec.Code != http.StatusMethodNotAllowed || len(*enqueued) != 0 {
+		t.Errorf("GET = %d and enqueued %v, want %d and nothing", rec.Code, names(*enqueued), http.StatusMethodNotAllowed)
+	}
+
+	rec = httptest.NewRecorder()
+	o.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/resync", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "2 channels enqueued\n" {
+		t.Errorf("POST = %d %q, want the count of the channels enqueued", rec.Code, rec.Body.String())
+	}
+	if diff := cmp.Diff([]string{"not-ready", "ready"}, names(*enqueued)); diff != "" {
+		t.Errorf("enqueued (-want, +got) = %s", diff)
+	}
+}
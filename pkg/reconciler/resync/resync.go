@@ -0,0 +1,260 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resync reconciles the cached NatssChannels periodically, more often the ones which are
+// not ready, or on demand, and exports the size and age of the cache.
+package resync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+// reportInterval is the interval between two reports of the cache metrics.
+var reportInterval = 30 * time.Second
+
+var (
+	// cacheSizeM records the number of NatssChannels in the informer cache.
+	cacheSizeM = stats.Int64(
+		"natss_channel_cache_size",
+		"Number of NatssChannels in the informer cache",
+		stats.UnitDimensionless,
+	)
+
+	// cacheAgeM records the time since all the cached NatssChannels were last reconciled.
+	cacheAgeM = stats.Float64(
+		"natss_channel_cache_age_seconds",
+		"Time since all the cached NatssChannels were last reconciled",
+		stats.UnitSeconds,
+	)
+
+	// controllerKey tags the metrics with the name of the controller owning the cache.
+	controllerKey = tag.MustNewKey("controller")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: cacheSizeM.Description(),
+			Measure:     cacheSizeM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{controllerKey},
+		},
+		&view.View{
+			Description: cacheAgeM.Description(),
+			Measure:     cacheAgeM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{controllerKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// ChannelNotReady tells whether the NatssChannel is not ready.
+func ChannelNotReady(nc *v1beta1.NatssChannel) bool {
+	return !nc.Status.IsReady()
+}
+
+// SubscribersNotReady tells whether one of the subscribers of the NatssChannel is not ready,
+// including the subscribers without a status yet.
+func SubscribersNotReady(nc *v1beta1.NatssChannel) bool {
+	ready := make(map[types.UID]bool, len(nc.Status.Subscribers))
+	for _, status := range nc.Status.Subscribers {
+		ready[status.UID] = status.Ready == corev1.ConditionTrue
+	}
+	for _, sub := range nc.Spec.Subscribers {
+		if !ready[sub.UID] {
+			return true
+		}
+	}
+	return false
+}
+
+// Resyncer enqueues all the cached NatssChannels every config.Resync Period, and the ones which
+// are not ready every NotReadyPeriod, so that drifts are caught without reconciling every
+// channel as often as the failing ones. The periods may change while it runs.
+type Resyncer struct {
+	name     string
+	lister   listers.NatssChannelLister
+	enqueue  func(types.NamespacedName)
+	notReady func(*v1beta1.NatssChannel) bool
+	now      func() time.Time
+
+	mu         sync.Mutex
+	config     config.Resync
+	lastResync time.Time
+	// changed is signaled when the config changes.
+	changed chan struct{}
+}
+
+// New returns a Resyncer of the channels listed by lister, named after the controller calling
+// enqueue. Both periods are initially zero, disabling the resyncs.
+func New(name string, lister listers.NatssChannelLister, enqueue func(types.NamespacedName), notReady func(*v1beta1.NatssChannel) bool) *Resyncer {
+	return &Resyncer{
+		name:       name,
+		lister:     lister,
+		enqueue:    enqueue,
+		notReady:   notReady,
+		now:        time.Now,
+		lastResync: time.Now(),
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// SetConfig changes the resync periods.
+func (r *Resyncer) SetConfig(c config.Resync) {
+	r.mu.Lock()
+	changed := r.config != c
+	r.config = c
+	r.mu.Unlock()
+	if changed {
+		select {
+		case r.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *Resyncer) getConfig() config.Resync {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// Run resyncs and reports the cache metrics until ctx is done.
+func (r *Resyncer) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	report := time.NewTicker(reportInterval)
+	defer report.Stop()
+
+	for {
+		c := r.getConfig()
+		logger.Infow("Resyncing the NatssChannels", zap.String("controller", r.name),
+			zap.Duration("period", c.Period), zap.Duration("notReadyPeriod", c.NotReadyPeriod))
+		all, stopAll := tick(c.Period)
+		notReady, stopNotReady := tick(c.NotReadyPeriod)
+
+		done := r.loop(ctx, all, notReady, report.C)
+		stopAll()
+		stopNotReady()
+		if done {
+			return
+		}
+	}
+}
+
+// loop resyncs until the config changes or ctx is done, returning true in the latter case.
+func (r *Resyncer) loop(ctx context.Context, all, notReady, report <-chan time.Time) bool {
+	logger := logging.FromContext(ctx)
+	for {
+		select {
+		case <-all:
+			if err := r.resync(func(*v1beta1.NatssChannel) bool { return true }); err != nil {
+				logger.Errorw("Error resyncing the NatssChannels", zap.Error(err))
+				continue
+			}
+			r.mu.Lock()
+			r.lastResync = r.now()
+			r.mu.Unlock()
+		case <-notReady:
+			if err := r.resync(r.notReady); err != nil {
+				logger.Errorw("Error resyncing the NatssChannels which are not ready", zap.Error(err))
+			}
+		case <-report:
+			r.report(ctx)
+		case <-r.changed:
+			return false
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// resync enqueues the cached channels matching filter.
+func (r *Resyncer) resync(filter func(*v1beta1.NatssChannel) bool) error {
+	keys, err := r.selectChannels(filter)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		r.enqueue(key)
+	}
+	return nil
+}
+
+// selectChannels returns the keys of the cached channels matching filter.
+func (r *Resyncer) selectChannels(filter func(*v1beta1.NatssChannel) bool) ([]types.NamespacedName, error) {
+	return selectChannels(r.lister, filter)
+}
+
+// selectChannels returns the keys of the channels listed by lister matching filter.
+func selectChannels(lister listers.NatssChannelLister, filter func(*v1beta1.NatssChannel) bool) ([]types.NamespacedName, error) {
+	channels, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var keys []types.NamespacedName
+	for _, nc := range channels {
+		if filter(nc) {
+			keys = append(keys, types.NamespacedName{Namespace: nc.Namespace, Name: nc.Name})
+		}
+	}
+	return keys, nil
+}
+
+// report records the cache metrics.
+func (r *Resyncer) report(ctx context.Context) {
+	channels, err := r.lister.List(labels.Everything())
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Error listing the NatssChannels", zap.Error(err))
+		return
+	}
+	r.mu.Lock()
+	age := r.now().Sub(r.lastResync)
+	r.mu.Unlock()
+
+	ctx, err = tag.New(ctx, tag.Insert(controllerKey, r.name))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, cacheSizeM.M(int64(len(channels))))
+	metrics.Record(ctx, cacheAgeM.M(age.Seconds()))
+}
+
+// tick returns a channel receiving the time every period, or never when period is zero.
+func tick(period time.Duration) (<-chan time.Time, func()) {
+	if period <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(period)
+	return t.C, t.Stop
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resync
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+const testNS = "test-namespace"
+
+func TestSelectNotReady(t *testing.T) {
+	lister := reconciletesting.NewNatssChannelLister(
+		reconciletesting.NewNatssChannel("ready", testNS, reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("not-ready", testNS, reconciletesting.WithNotReady("DispatcherNotReady", "")),
+		reconciletesting.NewNatssChannel("new", testNS),
+		reconciletesting.NewNatssChannel("subscribers-ready", testNS, reconciletesting.WithReady,
+			withSubscriber("a", corev1.ConditionTrue), withSubscriber("b", corev1.ConditionTrue)),
+		reconciletesting.NewNatssChannel("subscriber-failed", testNS, reconciletesting.WithReady,
+			withSubscriber("a", corev1.ConditionTrue), withSubscriber("b", corev1.ConditionFalse)),
+		reconciletesting.NewNatssChannel("subscriber-pending", testNS, reconciletesting.WithReady,
+			withSubscriber("a", corev1.ConditionTrue), withSubscriber("b", "")),
+	)
+
+	testCases := map[string]struct {
+		notReady func(*v1beta1.NatssChannel) bool
+		want     []string
+	}{
+		"channel not ready": {
+			notReady: ChannelNotReady,
+			want:     []string{"new", "not-ready"},
+		},
+		"subscribers not ready": {
+			notReady: SubscribersNotReady,
+			want:     []string{"subscriber-failed", "subscriber-pending"},
+		},
+	}
+
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			r := New("test", lister, func(types.NamespacedName) {}, tc.notReady)
+			keys, err := r.selectChannels(r.notReady)
+			if err != nil {
+				t.Fatalf("selectChannels() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, names(keys)); diff != "" {
+				t.Errorf("unexpected channels (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestResyncer(t *testing.T) {
+	lister := reconciletesting.NewNatssChannelLister(
+		reconciletesting.NewNatssChannel("ready", testNS, reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("not-ready", testNS, reconciletesting.WithNotReady("DispatcherNotReady", "")),
+	)
+	enqueued := make(chan types.NamespacedName, 10)
+	r := New("test", lister, func(key types.NamespacedName) { enqueued <- key }, ChannelNotReady)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Only the channel which is not ready is enqueued by the fast loop.
+	r.SetConfig(config.Resync{Period: time.Hour, NotReadyPeriod: 10 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		if key := receive(t, enqueued); key.Name != "not-ready" {
+			t.Errorf("enqueued %v, want only the channel which is not ready", key)
+		}
+	}
+
+	// The periods change without restarting.
+	r.SetConfig(config.Resync{Period: 10 * time.Millisecond})
+	seen := make(map[string]bool)
+	for len(seen) < 2 {
+		seen[receive(t, enqueued).Name] = true
+	}
+	if !seen["ready"] {
+		t.Error("the ready channel was not enqueued by the full resync")
+	}
+}
+
+func receive(t *testing.T, enqueued <-chan types.NamespacedName) types.NamespacedName {
+	t.Helper()
+	select {
+	case key := <-enqueued:
+		return key
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a channel to be enqueued")
+		return types.NamespacedName{}
+	}
+}
+
+func withSubscriber(uid types.UID, ready corev1.ConditionStatus) reconciletesting.NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.Spec.Subscribers = append(nc.Spec.Subscribers, eventingduckv1.SubscriberSpec{UID: uid})
+		if ready != "" {
+			nc.Status.Subscribers = append(nc.Status.Subscribers, eventingduckv1.SubscriberStatus{UID: uid, Ready: ready})
+		}
+	}
+}
+
+func names(keys []types.NamespacedName) []string {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, key.Name)
+	}
+	sort.Strings(names)
+	return names
+}
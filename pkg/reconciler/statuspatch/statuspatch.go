@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statuspatch makes the NatssChannel clientset write the status with merge patches
+// limited to the fields known by this version. Updating the whole status would drop the fields
+// written by a newer version, which the decoding of the objects ignores, so that they would be
+// lost when downgrading.
+//
+// The controller and the dispatcher both reconcile the NatssChannels, and each of them only
+// patches the fields of the status it owns, so that neither clobbers the fields of the other.
+package statuspatch
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/reconciler"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/client/clientset/versioned"
+	messagingv1beta1 "knative.dev/eventing-natss/pkg/client/clientset/versioned/typed/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/client/injection/client"
+)
+
+// Owner is the reconciler writing a part of the status.
+type Owner int
+
+const (
+	// Controller owns the status but for the fields owned by the dispatcher: the observed
+	// generation, the addresses and the conditions of the channel resources and its readiness.
+	Controller Owner = iota
+	// Dispatcher owns the statuses of the subscribers, the dead letter sink of the namespace, the
+	// cluster the channel is bound to, the informational conditions set by the dispatcher and the
+	// NatssConnectionReady condition, the readiness being updated from the latter.
+	Dispatcher
+)
+
+// dispatcherConditions are the conditions owned by the dispatcher. None of them affects the
+// readiness of the channel, which the controller computes.
+var dispatcherConditions = map[apis.ConditionType]bool{
+	v1beta1.NatssChannelConditionAuditSinkReachable:            true,
+	v1beta1.NatssChannelConditionHibernated:                    true,
+	v1beta1.NatssChannelConditionInsecureDelivery:              true,
+	v1beta1.NatssChannelConditionChannelNotProvisionedOnServer: true,
+	v1beta1.NatssChannelConditionFanoutAboveLimit:              true,
+	v1beta1.NatssChannelConditionBufferingOffline:              true,
+}
+
+// Merge returns stored with the fields owned by o replaced by the ones of desired.
+func (o Owner) Merge(stored, desired *v1beta1.NatssChannelStatus) v1beta1.NatssChannelStatus {
+	dispatcher, controller := desired, stored
+	if o == Controller {
+		dispatcher, controller = stored, desired
+	}
+	merged := *controller.DeepCopy()
+	merged.SubscribableStatus = *dispatcher.SubscribableStatus.DeepCopy()
+	merged.DeadLetterSinkURI = dispatcher.DeadLetterSinkURI.DeepCopy()
+	merged.Cluster = dispatcher.Cluster.DeepCopy()
+	merged.Conditions = nil
+	for _, c := range controller.Conditions {
+		if !dispatcherConditions[c.Type] {
+			merged.Conditions = append(merged.Conditions, c)
+		}
+	}
+	for _, c := range dispatcher.Conditions {
+		if dispatcherConditions[c.Type] {
+			merged.Conditions = append(merged.Conditions, c)
+		}
+	}
+	// The conditions are kept sorted by type, like the condition sets do.
+	sort.Slice(merged.Conditions, func(i, j int) bool {
+		return merged.Conditions[i].Type < merged.Conditions[j].Type
+	})
+	merged.PropagateNatssConnectionStatus(dispatcher)
+	return merged
+}
+
+// WithClient replaces the injected NatssChannel clientset of ctx with one patching the fields
+// of the status owned by o.
+func WithClient(ctx context.Context, o Owner) context.Context {
+	return context.WithValue(ctx, client.Key{}, NewClient(client.Get(ctx), o))
+}
+
+// NewClient returns a clientset whose UpdateStatus of the NatssChannels patches the known
+// fields of the status owned by o which changed.
+func NewClient(c versioned.Interface, o Owner) versioned.Interface {
+	return &clientset{Interface: c, owner: o}
+}
+
+type clientset struct {
+	versioned.Interface
+	owner Owner
+}
+
+func (c *clientset) MessagingV1beta1() messagingv1beta1.MessagingV1beta1Interface {
+	return &messagingClient{MessagingV1beta1Interface: c.Interface.MessagingV1beta1(), owner: c.owner}
+}
+
+type messagingClient struct {
+	messagingv1beta1.MessagingV1beta1Interface
+	owner Owner
+}
+
+func (c *messagingClient) NatssChannels(namespace string) messagingv1beta1.NatssChannelInterface {
+	return &natssChannels{NatssChannelInterface: c.MessagingV1beta1Interface.NatssChannels(namespace), owner: c.owner}
+}
+
+type natssChannels struct {
+	messagingv1beta1.NatssChannelInterface
+	owner Owner
+}
+
+// UpdateStatus patches the fields of the stored status owned by the client with the ones of
+// natssChannel. The other fields are kept as stored, so that natssChannel may be stale. The patch
+// is conditioned on the version of the stored object it was computed from, and computed again
+// from the latest one on conflicts. The status of an object being deleted is not written, nothing
+// reading it anymore.
+func (c *natssChannels) UpdateStatus(ctx context.Context, natssChannel *v1beta1.NatssChannel, opts metav1.UpdateOptions) (*v1beta1.NatssChannel, error) {
+	var updated *v1beta1.NatssChannel
+	err := reconciler.RetryUpdateConflicts(func(int) error {
+		stored, err := c.Get(ctx, natssChannel.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if stored.DeletionTimestamp != nil {
+			updated = stored
+			return nil
+		}
+		desired := stored.DeepCopy()
+		desired.Status = c.owner.Merge(&stored.Status, &natssChannel.Status)
+		if equality.Semantic.DeepEqual(stored.Status, desired.Status) {
+			updated = stored
+			return nil
+		}
+		patch, err := Create(stored, desired)
+		if err != nil {
+			return err
+		}
+		updated, err = c.Patch(ctx, natssChannel.Name, types.MergePatchType, patch, metav1.PatchOptions{DryRun: opts.DryRun, FieldManager: opts.FieldManager}, "status")
+		return err
+	})
+	return updated, err
+}
+
+// Create returns the merge patch changing the status of stored into the one of desired. The
+// fields unknown to this version are not part of either status, and are thus left untouched.
+func Create(stored, desired *v1beta1.NatssChannel) ([]byte, error) {
+	before, err := json.Marshal(&v1beta1.NatssChannel{Status: stored.Status})
+	if err != nil {
+		return nil, err
+	}
+	after, err := json.Marshal(&v1beta1.NatssChannel{
+		ObjectMeta: metav1.ObjectMeta{ResourceVersion: desired.ResourceVersion},
+		Status:     desired.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreateMergePatch(before, after)
+}
@@ -0,0 +1,387 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statuspatch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/controller"
+	logtesting "knative.dev/pkg/logging/testing"
+	pkgreconciler "knative.dev/pkg/reconciler"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/client/clientset/versioned"
+	natsschannelreconciler "knative.dev/eventing-natss/pkg/client/injection/reconciler/messaging/v1beta1/natsschannel"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+const (
+	channelPath = "/apis/messaging.knative.dev/v1beta1/namespaces/ns/natsschannels/channel"
+
+	// storedChannel was written by a newer version, with fields this version does not know.
+	storedChannel = `{
+  "apiVersion": "messaging.knative.dev/v1beta1",
+  "kind": "NatssChannel",
+  "metadata": {"namespace": "ns", "name": "channel", "generation": 2, "resourceVersion": "1"},
+  "spec": {"futureSpec": {"enabled": true}},
+  "status": {"futureStatus": "kept", "observedGeneration": 1, "address": {"url": "http://old.ns.svc.cluster.local"}}
+}`
+)
+
+// apiServer stores a NatssChannel as JSON, the way the API server stores the custom resources
+// whose schema preserves the unknown fields.
+type apiServer struct {
+	mu     sync.Mutex
+	stored []byte
+	// beforePatch is called before the next patch is applied.
+	beforePatch func()
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	if beforePatch := s.beforePatch; beforePatch != nil && req.Method == http.MethodPatch {
+		s.beforePatch = nil
+		s.mu.Unlock()
+		beforePatch()
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == channelPath:
+	case req.Method == http.MethodPatch && req.URL.Path == channelPath+"/status":
+		if req.Header.Get("Content-Type") != "application/merge-patch+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		patch, _ := ioutil.ReadAll(req.Body)
+		var stored, precondition metav1.PartialObjectMetadata
+		_ = json.Unmarshal(s.stored, &stored)
+		_ = json.Unmarshal(patch, &precondition)
+		if precondition.ResourceVersion != "" && precondition.ResourceVersion != stored.ResourceVersion {
+			status := apierrors.NewConflict(v1beta1.Resource("natsschannels"), "channel", nil).Status()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(&status)
+			return
+		}
+		patched, err := jsonpatch.MergePatch(s.stored, patch)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rv, _ := strconv.Atoi(stored.ResourceVersion)
+		s.stored, _ = jsonpatch.MergePatch(patched, []byte(`{"metadata": {"resourceVersion": "`+strconv.Itoa(rv+1)+`"}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s.stored)
+}
+
+func (s *apiServer) get(t *testing.T) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var obj map[string]interface{}
+	if err := json.Unmarshal(s.stored, &obj); err != nil {
+		t.Fatalf("failed to decode the stored channel: %v", err)
+	}
+	return obj
+}
+
+type reconcileFunc func(context.Context, *v1beta1.NatssChannel) pkgreconciler.Event
+
+func (f reconcileFunc) ReconcileKind(ctx context.Context, nc *v1beta1.NatssChannel) pkgreconciler.Event {
+	return f(ctx, nc)
+}
+
+func TestReconcileKeepsUnknownFields(t *testing.T) {
+	server := &apiServer{stored: []byte(storedChannel)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := logtesting.TestContextWithLogger(t)
+	c := NewClient(versioned.NewForConfigOrDie(&rest.Config{Host: ts.URL}), Controller)
+
+	// The unknown fields are ignored when decoding.
+	nc, err := c.MessagingV1beta1().NatssChannels("ns").Get(ctx, "channel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(nc); err != nil {
+		t.Fatalf("failed to add the channel: %v", err)
+	}
+
+	r := natsschannelreconciler.NewReconciler(ctx, logtesting.TestLogger(t), c, listers.NewNatssChannelLister(indexer),
+		record.NewFakeRecorder(10), reconcileFunc(func(_ context.Context, nc *v1beta1.NatssChannel) pkgreconciler.Event {
+			nc.Status.SetAddress(nil)
+			nc.Status.MarkServiceTrue()
+			return nil
+		}))
+	if err := r.(pkgreconciler.LeaderAware).Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {}); err != nil {
+		t.Fatalf("Promote() = %v", err)
+	}
+	if err := r.Reconcile(ctx, "ns/channel"); err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	obj := server.get(t)
+	spec, _ := obj["spec"].(map[string]interface{})
+	if _, ok := spec["futureSpec"]; !ok {
+		t.Errorf("the unknown spec field was dropped: %v", spec)
+	}
+	status, _ := obj["status"].(map[string]interface{})
+	if status["futureStatus"] != "kept" {
+		t.Errorf("the unknown status field was dropped: %v", status)
+	}
+	if address, _ := status["address"].(map[string]interface{}); address["url"] != nil {
+		t.Errorf("the cleared address was kept: %v", address)
+	}
+	if status["observedGeneration"] != float64(2) || status["conditions"] == nil {
+		t.Errorf("the status was not updated: %v", status)
+	}
+}
+
+func TestUpdateStatusRetriesConflicts(t *testing.T) {
+	server := &apiServer{stored: []byte(storedChannel)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := context.Background()
+	cs := versioned.NewForConfigOrDie(&rest.Config{Host: ts.URL})
+	controllerClient := NewClient(cs, Controller).MessagingV1beta1().NatssChannels("ns")
+	dispatcherClient := NewClient(cs, Dispatcher).MessagingV1beta1().NatssChannels("ns")
+
+	nc, err := controllerClient.Get(ctx, "channel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	// The dispatcher writes the status between the read and the patch of the controller, whose
+	// patch conflicts and is computed again.
+	server.beforePatch = func() {
+		update := nc.DeepCopy()
+		update.Status.Subscribers = []eventingduckv1.SubscriberStatus{{UID: "sub", Ready: corev1.ConditionTrue}}
+		if _, err := dispatcherClient.UpdateStatus(ctx, update, metav1.UpdateOptions{}); err != nil {
+			t.Errorf("dispatcher UpdateStatus() = %v", err)
+		}
+	}
+	nc.Status.MarkServiceTrue()
+	updated, err := controllerClient.UpdateStatus(ctx, nc, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("UpdateStatus() = %v", err)
+	}
+	if got := updated.Status.GetCondition(v1beta1.NatssChannelConditionServiceReady); got == nil || !got.IsTrue() {
+		t.Errorf("ServiceReady = %v, want True", got)
+	}
+	if len(updated.Status.Subscribers) != 1 {
+		t.Errorf("Subscribers = %v, want the ones written by the dispatcher", updated.Status.Subscribers)
+	}
+	if updated.ResourceVersion != "3" {
+		t.Errorf("ResourceVersion = %q, want 3", updated.ResourceVersion)
+	}
+}
+
+func TestUpdateStatusSkipsDeleted(t *testing.T) {
+	server := &apiServer{stored: []byte(storedChannel)}
+	server.stored, _ = jsonpatch.MergePatch(server.stored, []byte(`{"metadata": {"deletionTimestamp": "2020-01-01T00:00:00Z"}}`))
+	server.beforePatch = func() {
+		t.Error("the status of a channel being deleted was patched")
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := context.Background()
+	cs := versioned.NewForConfigOrDie(&rest.Config{Host: ts.URL})
+	dispatcherClient := NewClient(cs, Dispatcher).MessagingV1beta1().NatssChannels("ns")
+
+	nc, err := dispatcherClient.Get(ctx, "channel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{{UID: "sub", Ready: corev1.ConditionTrue}}
+	updated, err := dispatcherClient.UpdateStatus(ctx, nc, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("UpdateStatus() = %v", err)
+	}
+	if len(updated.Status.Subscribers) != 0 || updated.ResourceVersion != "1" {
+		t.Errorf("UpdateStatus() = %v at version %q, want the stored channel", updated.Status.Subscribers, updated.ResourceVersion)
+	}
+}
+
+// TestInterleavedReconcilers runs the reconcilers of the controller and the dispatcher from the
+// same stale copy of the channel, and checks that neither clobbers the fields of the other.
+func TestInterleavedReconcilers(t *testing.T) {
+	server := &apiServer{stored: []byte(storedChannel)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := logtesting.TestContextWithLogger(t)
+	cs := versioned.NewForConfigOrDie(&rest.Config{Host: ts.URL})
+
+	nc, err := cs.MessagingV1beta1().NatssChannels("ns").Get(ctx, "channel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(nc); err != nil {
+		t.Fatalf("failed to add the channel: %v", err)
+	}
+	newReconciler := func(o Owner, f reconcileFunc) controller.Reconciler {
+		r := natsschannelreconciler.NewReconciler(ctx, logtesting.TestLogger(t), NewClient(cs, o),
+			listers.NewNatssChannelLister(indexer), record.NewFakeRecorder(10), f)
+		if err := r.(pkgreconciler.LeaderAware).Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {}); err != nil {
+			t.Fatalf("Promote() = %v", err)
+		}
+		return r
+	}
+	controllerReconciler := newReconciler(Controller, func(_ context.Context, nc *v1beta1.NatssChannel) pkgreconciler.Event {
+		nc.Status.SetAddress(apis.HTTP("channel.ns.svc.cluster.local"))
+		nc.Status.MarkServiceTrue()
+		nc.Status.MarkChannelServiceTrue()
+		nc.Status.MarkEndpointsTrue()
+		nc.Status.PropagateDispatcherStatus(&appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentAvailable,
+			Status: corev1.ConditionTrue,
+		}}})
+		return nil
+	})
+	dispatcherReconciler := newReconciler(Dispatcher, func(_ context.Context, nc *v1beta1.NatssChannel) pkgreconciler.Event {
+		nc.Status.Subscribers = []eventingduckv1.SubscriberStatus{{UID: "sub", Ready: corev1.ConditionTrue}}
+		nc.Status.DeadLetterSinkURI = apis.HTTP("dls.ns.svc.cluster.local")
+		nc.Status.MarkHibernated(time.Unix(0, 0))
+		nc.Status.MarkNatssConnectionReady()
+		return nil
+	})
+
+	// The lister is never updated, so that each reconciler starts from the status read before the
+	// writes of the other, in both orders.
+	for _, r := range []controller.Reconciler{controllerReconciler, dispatcherReconciler, controllerReconciler, dispatcherReconciler} {
+		if err := r.Reconcile(ctx, "ns/channel"); err != nil {
+			t.Fatalf("Reconcile() = %v", err)
+		}
+	}
+
+	got, err := cs.MessagingV1beta1().NatssChannels("ns").Get(ctx, "channel", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if !got.Status.IsReady() {
+		t.Errorf("the channel is not ready: %v", got.Status.Conditions)
+	}
+	if got.Status.Address == nil || got.Status.Address.URL.Host != "channel.ns.svc.cluster.local" {
+		t.Errorf("Address = %v, want the one written by the controller", got.Status.Address)
+	}
+	if got.Status.ObservedGeneration != 2 {
+		t.Errorf("ObservedGeneration = %d, want 2", got.Status.ObservedGeneration)
+	}
+	if len(got.Status.Subscribers) != 1 || got.Status.Subscribers[0].UID != "sub" {
+		t.Errorf("Subscribers = %v, want the ones written by the dispatcher", got.Status.Subscribers)
+	}
+	if got.Status.DeadLetterSinkURI == nil {
+		t.Error("the dead letter sink written by the dispatcher was dropped")
+	}
+	if c := got.Status.GetCondition(v1beta1.NatssChannelConditionHibernated); c == nil || !c.IsTrue() {
+		t.Errorf("Hibernated = %v, want the condition written by the dispatcher", c)
+	}
+	if status, _ := server.get(t)["status"].(map[string]interface{}); status["futureStatus"] != "kept" {
+		t.Errorf("the unknown status field was dropped: %v", status)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	stored := v1beta1.NatssChannelStatus{}
+	stored.MarkServiceTrue()
+	stored.MarkHibernated(time.Unix(0, 0))
+	stored.MarkNatssConnectionReady()
+	stored.Subscribers = []eventingduckv1.SubscriberStatus{{UID: "stored"}}
+
+	desired := v1beta1.NatssChannelStatus{}
+	desired.MarkServiceFailed("Failed", "failed")
+	desired.MarkInsecureDelivery([]string{"http://sub.other.svc.cluster.local"})
+	desired.MarkNatssConnectionFailed("nats://natss:4222", nil)
+	desired.Subscribers = []eventingduckv1.SubscriberStatus{{UID: "desired"}}
+	desired.ObservedGeneration = 3
+	desired.Cluster = &v1beta1.NatssChannelCluster{NatsURL: "nats://natss:4222", ClusterID: "knative-nats-streaming"}
+
+	tests := map[Owner]struct {
+		serviceReady, hibernated, insecureDelivery, cluster, natssConnectionReady bool
+		subscriber                                                                types.UID
+		observedGeneration                                                        int64
+	}{
+		Controller: {serviceReady: false, hibernated: true, natssConnectionReady: true, subscriber: "stored", observedGeneration: 3},
+		Dispatcher: {serviceReady: true, insecureDelivery: true, cluster: true, subscriber: "desired"},
+	}
+	for owner, want := range tests {
+		got := owner.Merge(&stored, &desired)
+		if c := got.GetCondition(v1beta1.NatssChannelConditionServiceReady); c.IsTrue() != want.serviceReady {
+			t.Errorf("%d: ServiceReady = %v, want true: %t", owner, c, want.serviceReady)
+		}
+		if c := got.GetCondition(v1beta1.NatssChannelConditionHibernated); (c != nil) != want.hibernated {
+			t.Errorf("%d: Hibernated = %v, want set: %t", owner, c, want.hibernated)
+		}
+		if c := got.GetCondition(v1beta1.NatssChannelConditionInsecureDelivery); (c != nil) != want.insecureDelivery {
+			t.Errorf("%d: InsecureDelivery = %v, want set: %t", owner, c, want.insecureDelivery)
+		}
+		if c := got.GetCondition(v1beta1.NatssChannelConditionNatssConnectionReady); c.IsTrue() != want.natssConnectionReady {
+			t.Errorf("%d: NatssConnectionReady = %v, want true: %t", owner, c, want.natssConnectionReady)
+		}
+		if !want.natssConnectionReady && got.IsReady() {
+			t.Errorf("%d: the channel is ready without a connection to NATSS", owner)
+		}
+		if len(got.Subscribers) != 1 || got.Subscribers[0].UID != want.subscriber {
+			t.Errorf("%d: Subscribers = %v, want %s", owner, got.Subscribers, want.subscriber)
+		}
+		if (got.Cluster != nil) != want.cluster {
+			t.Errorf("%d: Cluster = %v, want set: %t", owner, got.Cluster, want.cluster)
+		}
+		if got.ObservedGeneration != want.observedGeneration {
+			t.Errorf("%d: ObservedGeneration = %d, want %d", owner, got.ObservedGeneration, want.observedGeneration)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	stored := &v1beta1.NatssChannel{}
+	stored.Status.MarkServiceTrue()
+	desired := stored.DeepCopy()
+	desired.ResourceVersion = "3"
+	desired.Status.ObservedGeneration = 2
+
+	patch, err := Create(stored, desired)
+	if err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	if got, want := string(patch), `{"metadata":{"resourceVersion":"3"},"status":{"observedGeneration":2}}`; got != want {
+		t.Errorf("Create() = %s, want %s", got, want)
+	}
+}
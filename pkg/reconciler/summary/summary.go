@@ -0,0 +1,283 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package summary aggregates the readiness of the cached NatssChannels, served as JSON on the
+// admin port of the controller and exported as metrics, so that the channels which are not ready
+// are counted without joining the metrics of every channel.
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	listers "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+)
+
+const (
+	// DefaultCacheTTL is how long a summary is served before being computed again.
+	DefaultCacheTTL = 5 * time.Second
+
+	// longestNotReady is how many of the channels not ready for the longest time are listed.
+	longestNotReady = 10
+
+	// ReasonNotReconciled is the reason of the channels without a Ready condition yet.
+	ReasonNotReconciled = "NotReconciled"
+	// ReasonUnknown is the reason of the channels whose Ready condition has no reason.
+	ReasonUnknown = "Unknown"
+)
+
+// reportInterval is the interval between two reports of the metrics.
+var reportInterval = 30 * time.Second
+
+var (
+	// channelsM records the number of NatssChannels.
+	channelsM = stats.Int64(
+		"natss_channels",
+		"Number of NatssChannels",
+		stats.UnitDimensionless,
+	)
+
+	// notReadyChannelsM records the number of NatssChannels which are not ready, by reason.
+	notReadyChannelsM = stats.Int64(
+		"natss_channels_not_ready",
+		"Number of NatssChannels which are not ready, by reason",
+		stats.UnitDimensionless,
+	)
+
+	// reasonKey tags the channels which are not ready with the reason of their Ready condition.
+	reasonKey = tag.MustNewKey("reason")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: channelsM.Description(),
+			Measure:     channelsM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: notReadyChannelsM.Description(),
+			Measure:     notReadyChannelsM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{reasonKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// Summary is the readiness of the NatssChannels.
+type Summary struct {
+	// Channels is the number of channels, Ready and NotReady how many are and are not ready.
+	Channels int `json:"channels"`
+	Ready    int `json:"ready"`
+	NotReady int `json:"notReady"`
+	// Reasons is the number of channels which are not ready, by reason of their Ready condition.
+	Reasons map[string]int `json:"reasons"`
+	// LongestNotReady are the channels not ready for the longest time, the oldest first.
+	LongestNotReady []NotReadyChannel `json:"longestNotReady"`
+	// Namespaces is the summary of the channels of each namespace.
+	Namespaces map[string]NamespaceSummary `json:"namespaces"`
+	// ComputedAt is when the summary was computed, which is at most the cache TTL ago.
+	ComputedAt time.Time `json:"computedAt"`
+}
+
+// NotReadyChannel is a channel which is not ready.
+type NotReadyChannel struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message,omitempty"`
+	// Since is when the channel stopped being ready, or was created when it never was.
+	Since time.Time `json:"since"`
+	// Age is the time since Since, in seconds.
+	Age int64 `json:"ageSeconds"`
+}
+
+// NamespaceSummary is the readiness of the channels of a namespace.
+type NamespaceSummary struct {
+	Channels int `json:"channels"`
+	NotReady int `json:"notReady"`
+}
+
+// Summarizer computes the Summary of the channels listed by its lister on demand, serving the
+// last one computed for its cache TTL.
+type Summarizer struct {
+	lister listers.NatssChannelLister
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	summary *Summary
+	// reasons are the reasons last reported, reported as zero once no channel has them.
+	reasons map[string]bool
+}
+
+// New returns a Summarizer of the channels listed by lister, computing the summary at most once
+// every ttl.
+func New(lister listers.NatssChannelLister, ttl time.Duration) *Summarizer {
+	return &Summarizer{
+		lister:  lister,
+		ttl:     ttl,
+		now:     time.Now,
+		reasons: make(map[string]bool),
+	}
+}
+
+// Summary returns the summary of the channels, computed at most the cache TTL ago.
+func (s *Summarizer) Summary() (*Summary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.summary != nil && now.Sub(s.summary.ComputedAt) < s.ttl {
+		return s.summary, nil
+	}
+	channels, err := s.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	s.summary = summarize(channels, now)
+	return s.summary, nil
+}
+
+// summarize returns the summary of channels at now.
+func summarize(channels []*v1beta1.NatssChannel, now time.Time) *Summary {
+	summary := &Summary{
+		Channels:        len(channels),
+		Reasons:         make(map[string]int),
+		LongestNotReady: []NotReadyChannel{},
+		Namespaces:      make(map[string]NamespaceSummary),
+		ComputedAt:      now,
+	}
+	var notReady []NotReadyChannel
+	for _, nc := range channels {
+		ns := summary.Namespaces[nc.Namespace]
+		ns.Channels++
+		if nc.Status.IsReady() {
+			summary.Ready++
+			summary.Namespaces[nc.Namespace] = ns
+			continue
+		}
+		ns.NotReady++
+		summary.Namespaces[nc.Namespace] = ns
+		summary.NotReady++
+
+		c := NotReadyChannel{Namespace: nc.Namespace, Name: nc.Name, Reason: ReasonNotReconciled, Since: nc.CreationTimestamp.Time}
+		if cond := nc.Status.GetCondition(apis.ConditionReady); cond != nil {
+			c.Reason, c.Message = cond.Reason, cond.Message
+			if c.Reason == "" {
+				c.Reason = ReasonUnknown
+			}
+			if !cond.LastTransitionTime.Inner.IsZero() {
+				c.Since = cond.LastTransitionTime.Inner.Time
+			}
+		}
+		c.Age = int64(now.Sub(c.Since) / time.Second)
+		summary.Reasons[c.Reason]++
+		notReady = append(notReady, c)
+	}
+
+	sort.Slice(notReady, func(i, j int) bool {
+		if !notReady[i].Since.Equal(notReady[j].Since) {
+			return notReady[i].Since.Before(notReady[j].Since)
+		}
+		if notReady[i].Namespace != notReady[j].Namespace {
+			return notReady[i].Namespace < notReady[j].Namespace
+		}
+		return notReady[i].Name < notReady[j].Name
+	})
+	if len(notReady) > longestNotReady {
+		notReady = notReady[:longestNotReady]
+	}
+	summary.LongestNotReady = append(summary.LongestNotReady, notReady...)
+	return summary
+}
+
+// ServeHTTP serves the summary of the channels as JSON on GET.
+func (s *Summarizer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	summary, err := s.Summary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(summary)
+}
+
+// Run reports the metrics of the summary until ctx is done.
+func (s *Summarizer) Run(ctx context.Context) {
+	report := time.NewTicker(reportInterval)
+	defer report.Stop()
+	for {
+		select {
+		case <-report.C:
+			s.report(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// report records the metrics of the summary, the reasons no channel has anymore being reported
+// as zero.
+func (s *Summarizer) report(ctx context.Context) {
+	summary, err := s.Summary()
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Error listing the NatssChannels", zap.Error(err))
+		return
+	}
+	metrics.Record(ctx, channelsM.M(int64(summary.Channels)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for reason := range s.reasons {
+		if _, ok := summary.Reasons[reason]; !ok {
+			s.recordNotReady(ctx, reason, 0)
+			delete(s.reasons, reason)
+		}
+	}
+	for reason, n := range summary.Reasons {
+		s.recordNotReady(ctx, reason, n)
+		s.reasons[reason] = true
+	}
+}
+
+func (s *Summarizer) recordNotReady(ctx context.Context, reason string, n int) {
+	ctx, err := tag.New(ctx, tag.Insert(reasonKey, reason))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, notReadyChannelsM.M(int64(n)))
+}
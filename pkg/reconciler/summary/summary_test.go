@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+var now = time.Date(2020, 11, 20, 12, 0, 0, 0, time.UTC)
+
+func TestSummary(t *testing.T) {
+	channels := []*v1beta1.NatssChannel{
+		reconciletesting.NewNatssChannel("ready", "a", reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("ready", "b", reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("new", "b", createdAgo(time.Minute)),
+		reconciletesting.NewNatssChannel("no-reason", "b", createdAgo(time.Hour), notReadySince("", time.Minute)),
+	}
+	// Twelve channels not ready since 1 to 12 hours, of which only the ten oldest are listed.
+	for i := 1; i <= 12; i++ {
+		reason := "DispatcherNotReady"
+		if i%2 == 0 {
+			reason = "BackingChannelFailed"
+		}
+		channels = append(channels, reconciletesting.NewNatssChannel(fmt.Sprint("not-ready-", i), "a",
+			createdAgo(24*time.Hour), notReadySince(reason, time.Duration(i)*time.Hour)))
+	}
+
+	s := newTestSummarizer(channels...)
+	got, err := s.Summary()
+	if err != nil {
+		t.Fatalf("Summary() = %v", err)
+	}
+
+	if got.Channels != 16 || got.Ready != 2 || got.NotReady != 14 {
+		t.Errorf("counts = %d channels, %d ready, %d not ready, want 16, 2, 14", got.Channels, got.Ready, got.NotReady)
+	}
+	wantReasons := map[string]int{
+		"DispatcherNotReady":   6,
+		"BackingChannelFailed": 6,
+		ReasonNotReconciled:    1,
+		ReasonUnknown:          1,
+	}
+	if diff := cmp.Diff(wantReasons, got.Reasons); diff != "" {
+		t.Errorf("reasons (-want, +got) = %s", diff)
+	}
+	wantNamespaces := map[string]NamespaceSummary{
+		"a": {Channels: 13, NotReady: 12},
+		"b": {Channels: 3, NotReady: 2},
+	}
+	if diff := cmp.Diff(wantNamespaces, got.Namespaces); diff != "" {
+		t.Errorf("namespaces (-want, +got) = %s", diff)
+	}
+
+	if len(got.LongestNotReady) != longestNotReady {
+		t.Fatalf("%d channels listed, want %d", len(got.LongestNotReady), longestNotReady)
+	}
+	oldest := got.LongestNotReady[0]
+	want := NotReadyChannel{
+		Namespace: "a",
+		Name:      "not-ready-12",
+		Reason:    "BackingChannelFailed",
+		Message:   "failing",
+		Since:     now.Add(-12 * time.Hour),
+		Age:       12 * 3600,
+	}
+	if diff := cmp.Diff(want, oldest); diff != "" {
+		t.Errorf("oldest channel (-want, +got) = %s", diff)
+	}
+	if youngest := got.LongestNotReady[longestNotReady-1]; youngest.Name != "not-ready-3" {
+		t.Errorf("youngest channel listed = %s, want not-ready-3", youngest.Name)
+	}
+}
+
+func TestSummaryNotReconciledAge(t *testing.T) {
+	s := newTestSummarizer(reconciletesting.NewNatssChannel("new", "a", createdAgo(time.Minute)))
+	got, err := s.Summary()
+	if err != nil {
+		t.Fatalf("Summary() = %v", err)
+	}
+	want := []NotReadyChannel{{Namespace: "a", Name: "new", Reason: ReasonNotReconciled, Since: now.Add(-time.Minute), Age: 60}}
+	if diff := cmp.Diff(want, got.LongestNotReady); diff != "" {
+		t.Errorf("channels not ready (-want, +got) = %s", diff)
+	}
+}
+
+func TestSummaryCache(t *testing.T) {
+	s := newTestSummarizer(reconciletesting.NewNatssChannel("ready", "a", reconciletesting.WithReady))
+	first, _ := s.Summary()
+
+	s.now = func() time.Time { return now.Add(DefaultCacheTTL - time.Second) }
+	if cached, _ := s.Summary(); cached != first {
+		t.Error("the summary was computed again within the cache TTL")
+	}
+
+	s.now = func() time.Time { return now.Add(DefaultCacheTTL) }
+	if computed, _ := s.Summary(); computed == first || !computed.ComputedAt.Equal(now.Add(DefaultCacheTTL)) {
+		t.Error("the summary was not computed again after the cache TTL")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := newTestSummarizer(
+		reconciletesting.NewNatssChannel("ready", "a", reconciletesting.WithReady),
+		reconciletesting.NewNatssChannel("not-ready", "a", createdAgo(time.Hour), notReadySince("DispatcherNotReady", time.Minute)),
+	)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status/summary", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("POST = %d, Allow %q, want %d and GET", rec.Code, rec.Header().Get("Allow"), http.StatusMethodNotAllowed)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/summary", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET = %d %q, want %d and JSON", rec.Code, rec.Header().Get("Content-Type"), http.StatusOK)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	want := map[string]interface{}{
+		"channels": 2.0,
+		"ready":    1.0,
+		"notReady": 1.0,
+		"reasons":  map[string]interface{}{"DispatcherNotReady": 1.0},
+		"longestNotReady": []interface{}{map[string]interface{}{
+			"namespace":  "a",
+			"name":       "not-ready",
+			"reason":     "DispatcherNotReady",
+			"message":    "failing",
+			"since":      "2020-11-20T11:59:00Z",
+			"ageSeconds": 60.0,
+		}},
+		"namespaces": map[string]interface{}{"a": map[string]interface{}{"channels": 2.0, "notReady": 1.0}},
+		"computedAt": "2020-11-20T12:00:00Z",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("summary (-want, +got) = %s", diff)
+	}
+}
+
+func newTestSummarizer(channels ...*v1beta1.NatssChannel) *Summarizer {
+	s := New(reconciletesting.NewNatssChannelLister(channels...), DefaultCacheTTL)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func createdAgo(d time.Duration) reconciletesting.NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.CreationTimestamp = metav1.NewTime(now.Add(-d))
+	}
+}
+
+// notReadySince sets a Ready condition false with reason since d.
+func notReadySince(reason string, d time.Duration) reconciletesting.NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.Status.Conditions = append(nc.Status.Conditions, apis.Condition{
+			Type:               apis.ConditionReady,
+			Status:             corev1.ConditionFalse,
+			Reason:             reason,
+			Message:            "failing",
+			LastTransitionTime: apis.VolatileTime{Inner: metav1.NewTime(now.Add(-d))},
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	duckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/eventing/pkg/apis/eventing"
 	"knative.dev/pkg/apis"
 
 	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
@@ -69,7 +70,14 @@ func WithNatssInitChannelConditions(nc *v1beta1.NatssChannel) {
 }
 
 func WithNatssChannelFinalizer(nc *v1beta1.NatssChannel) {
-	nc.Finalizers = []string{"natss-ch-dispatcher"}
+	nc.Finalizers = []string{v1beta1.DispatcherFinalizerName}
+}
+
+// WithNatssChannelFinalizers sets the finalizers of a NatssChannel, such as legacy ones.
+func WithNatssChannelFinalizers(finalizers ...string) NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.Finalizers = finalizers
+	}
 }
 
 func WithNatssChannelDeleted(nc *v1beta1.NatssChannel) {
@@ -107,6 +115,12 @@ func WithNatssChannelChannelServicetNotReady(reason, message string) NatssChanne
 	}
 }
 
+func WithNatssChannelNatssConnectionReady() NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.Status.MarkNatssConnectionReady()
+	}
+}
+
 func WithNatssChannelChannelServiceReady() NatssChannelOption {
 	return func(nc *v1beta1.NatssChannel) {
 		nc.Status.MarkChannelServiceTrue()
@@ -148,6 +162,12 @@ func WithNatssChannelSubscribableStatus(ready corev1.ConditionStatus, message st
 	}
 }
 
+func WithNatssChannelWireFormat(format v1beta1.WireFormat) NatssChannelOption {
+	return func(nc *v1beta1.NatssChannel) {
+		nc.Spec.WireFormat = format
+	}
+}
+
 func WithNatssChannelAddress(a string) NatssChannelOption {
 	return func(nc *v1beta1.NatssChannel) {
 		nc.Status.SetAddress(&apis.URL{
@@ -162,3 +182,12 @@ func Addressable() NatssChannelOption {
 		channel.GetConditionSet().Manage(&channel.Status).MarkTrue(v1beta1.NatssChannelConditionAddressable)
 	}
 }
+
+// WithNatssChannelNamespaceScoped annotates a NatssChannel to be served by the dispatcher of its
+// namespace.
+func WithNatssChannelNamespaceScoped(nc *v1beta1.NatssChannel) {
+	if nc.Annotations == nil {
+		nc.Annotations = make(map[string]string)
+	}
+	nc.Annotations[eventing.ScopeAnnotationKey] = eventing.ScopeNamespace
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package retry attempts the operations failing transiently again, backing off between the
+// attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// random returns a number in [0, 1), replaced by the tests.
+var random = rand.Float64
+
+// Policy is how an operation is attempted again.
+type Policy struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+	// Max caps the delays before jitter, zero leaving them uncapped.
+	Max time.Duration
+	// Multiplier multiplies the delay after every retry, the delays being constant below 1.
+	Multiplier float64
+	// Jitter is the fraction of every delay by which it is randomly shortened or lengthened,
+	// within [0, 1].
+	Jitter float64
+	// MaxAttempts is the number of attempts, the first one included, zero not limiting them.
+	MaxAttempts int
+	// MaxElapsed is the time from the first attempt after which no attempt starts, zero not
+	// limiting it.
+	MaxElapsed time.Duration
+}
+
+// Delay returns the delay before the retry of index n, zero being the first retry, without
+// jitter.
+func (p Policy) Delay(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.Initial) * math.Pow(multiplier, float64(n))
+	if p.Max > 0 && delay > float64(p.Max) {
+		return p.Max
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// jittered returns delay shortened or lengthened by the fraction Jitter of it, r within [0, 1)
+// picking where in that range.
+func (p Policy) jittered(delay time.Duration, r float64) time.Duration {
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	return delay + time.Duration((r*2-1)*jitter*float64(delay))
+}
+
+// RetryableError is implemented by the errors telling whether the operation failing with them is
+// attempted again, which the default classification follows.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// permanentError is an error which is not retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Retryable() bool { return false }
+
+// Permanent marks err as not retried, whatever the policy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable is the default classification of the errors: those wrapping a RetryableError are
+// retried as it tells, the others are.
+func IsRetryable(err error) bool {
+	var r RetryableError
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return true
+}
+
+// Option configures Do.
+type Option func(*options)
+
+type options struct {
+	retryable func(error) bool
+	onRetry   func(attempt int, err error, wait time.Duration)
+}
+
+// WithClassifier makes Do retry the errors for which retryable returns true, instead of following
+// IsRetryable. The Permanent errors are never retried.
+func WithClassifier(retryable func(error) bool) Option {
+	return func(o *options) {
+		o.retryable = retryable
+	}
+}
+
+// OnRetry makes Do call notify when the attempt of index attempt, starting at 1, failed with err
+// and is retried after wait.
+func OnRetry(notify func(attempt int, err error, wait time.Duration)) Option {
+	return func(o *options) {
+		o.onRetry = notify
+	}
+}
+
+// Do calls fn until it succeeds, backing off between the attempts as p tells. It returns the
+// error of the last attempt once the error is not retried or p allows no more attempt, and the
+// error of ctx when it is done while backing off.
+func Do(ctx context.Context, p Policy, fn func(ctx context.Context) error, opts ...Option) error {
+	o := options{retryable: IsRetryable}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if !o.retryable(err) || (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) {
+			return err
+		}
+		wait := p.jittered(p.Delay(attempt-1), random())
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return err
+		}
+		if o.onRetry != nil {
+			o.onRetry(attempt, err, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDelay(t *testing.T) {
+	testCases := map[string]struct {
+		policy Policy
+		want   []time.Duration
+	}{
+		"constant": {
+			policy: Policy{Initial: time.Second},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		"multiplier below one": {
+			policy: Policy{Initial: time.Second, Multiplier: 0.5},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		"exponential": {
+			policy: Policy{Initial: time.Second, Multiplier: 2},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		"fractional multiplier": {
+			policy: Policy{Initial: time.Second, Multiplier: 1.5},
+			want:   []time.Duration{time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond},
+		},
+		"capped": {
+			policy: Policy{Initial: time.Second, Max: 3 * time.Second, Multiplier: 2},
+			want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got := make([]time.Duration, 0, len(tc.want))
+			for i := range tc.want {
+				got = append(got, tc.policy.Delay(i))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected delays (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestDelayOverflow(t *testing.T) {
+	p := Policy{Initial: time.Second, Multiplier: 2}
+	if got := p.Delay(1000); got != math.MaxInt64 {
+		t.Errorf("Delay(1000) = %v, want %v", got, time.Duration(math.MaxInt64))
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	// The jitter stays within its fraction of the delay, whatever the delay, fraction and draw.
+	bounded := func(delay uint32, jitter uint8, r uint16) bool {
+		p := Policy{Jitter: float64(jitter%101) / 100}
+		d := time.Duration(delay)
+		got := p.jittered(d, float64(r)/(math.MaxUint16+1))
+		spread := time.Duration(p.Jitter * float64(d))
+		return got >= d-spread && got <= d+spread
+	}
+	if err := quick.Check(bounded, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+	// Out of range fractions are clamped.
+	for _, jitter := range []float64{-1, 2} {
+		p := Policy{Jitter: jitter}
+		for _, r := range []float64{0, 0.5, 0.999} {
+			got := p.jittered(time.Second, r)
+			if got < 0 || got > 2*time.Second {
+				t.Errorf("jittered(1s, %v) with a jitter of %v = %v, want within [0, 2s]", r, jitter, got)
+			}
+		}
+	}
+}
+
+var errTransient = errors.New("transient")
+
+// retryableError tells whether it is retried.
+type retryableError bool
+
+func (e retryableError) Error() string   { return fmt.Sprintf("retryable: %t", bool(e)) }
+func (e retryableError) Retryable() bool { return bool(e) }
+
+func TestDo(t *testing.T) {
+	testCases := map[string]struct {
+		policy   Policy
+		opts     []Option
+		errs     []error
+		want     error
+		attempts int
+	}{
+		"success": {
+			policy:   Policy{Initial: time.Millisecond},
+			attempts: 1,
+		},
+		"success after retries": {
+			policy:   Policy{Initial: time.Millisecond, Multiplier: 2, Jitter: 0.5},
+			errs:     []error{errTransient, errTransient},
+			attempts: 3,
+		},
+		"max attempts": {
+			policy:   Policy{Initial: time.Millisecond, MaxAttempts: 3},
+			errs:     []error{errTransient, errTransient, errTransient, errTransient},
+			want:     errTransient,
+			attempts: 3,
+		},
+		"max elapsed": {
+			policy:   Policy{Initial: 10 * time.Millisecond, MaxElapsed: 5 * time.Millisecond},
+			errs:     []error{errTransient, errTransient},
+			want:     errTransient,
+			attempts: 1,
+		},
+		"permanent": {
+			policy:   Policy{Initial: time.Millisecond},
+			errs:     []error{Permanent(errTransient)},
+			want:     errTransient,
+			attempts: 1,
+		},
+		"wrapped permanent": {
+			policy:   Policy{Initial: time.Millisecond},
+			errs:     []error{fmt.Errorf("wrapped: %w", Permanent(errTransient))},
+			want:     errTransient,
+			attempts: 1,
+		},
+		"not retryable": {
+			policy:   Policy{Initial: time.Millisecond},
+			errs:     []error{retryableError(false)},
+			want:     retryableError(false),
+			attempts: 1,
+		},
+		"retryable": {
+			policy:   Policy{Initial: time.Millisecond},
+			errs:     []error{retryableError(true)},
+			attempts: 2,
+		},
+		"classifier": {
+			policy:   Policy{Initial: time.Millisecond},
+			opts:     []Option{WithClassifier(func(err error) bool { return !errors.Is(err, errTransient) })},
+			errs:     []error{errTransient},
+			want:     errTransient,
+			attempts: 1,
+		},
+		"classifier does not retry permanent errors": {
+			policy:   Policy{Initial: time.Millisecond},
+			opts:     []Option{WithClassifier(func(error) bool { return true })},
+			errs:     []error{Permanent(errTransient)},
+			want:     errTransient,
+			attempts: 1,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), tc.policy, func(context.Context) error {
+				attempts++
+				if attempts <= len(tc.errs) {
+					return tc.errs[attempts-1]
+				}
+				return nil
+			}, tc.opts...)
+			if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+				t.Errorf("Do() = %v, want %v", err, tc.want)
+			}
+			if attempts != tc.attempts {
+				t.Errorf("attempted %d times, want %d", attempts, tc.attempts)
+			}
+		})
+	}
+}
+
+func TestDoOnRetry(t *testing.T) {
+	defer func(r func() float64) { random = r }(random)
+	random = func() float64 { return 0.75 }
+
+	type retried struct {
+		attempt int
+		err     error
+		wait    time.Duration
+	}
+	var got []retried
+	p := Policy{Initial: time.Millisecond, Multiplier: 2, Jitter: 0.2, MaxAttempts: 3}
+	err := Do(context.Background(), p, func(context.Context) error {
+		return errTransient
+	}, OnRetry(func(attempt int, err error, wait time.Duration) {
+		got = append(got, retried{attempt: attempt, err: err, wait: wait})
+	}))
+	if !errors.Is(err, errTransient) {
+		t.Errorf("Do() = %v, want %v", err, errTransient)
+	}
+	want := []retried{
+		{attempt: 1, err: errTransient, wait: 1100 * time.Microsecond},
+		{attempt: 2, err: errTransient, wait: 2200 * time.Microsecond},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(retried{}), cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+		t.Errorf("unexpected retries (-want, +got): %s", diff)
+	}
+}
+
+func TestDoContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := Do(ctx, Policy{Initial: time.Hour}, func(context.Context) error {
+		attempts++
+		cancel()
+		return errTransient
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %v, want %v", err, context.Canceled)
+	}
+	if attempts != 1 {
+		t.Errorf("attempted %d times, want 1", attempts)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runtimemetrics records the Go runtime metrics of the processes of the channel, and the
+// sizes of their in-memory structures, tagged with the component they belong to.
+package runtimemetrics
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"knative.dev/pkg/metrics"
+)
+
+// The components the metrics are tagged with. The receiver and the dispatcher run in the same
+// process, whose runtime metrics are tagged with Dispatcher.
+const (
+	Receiver   = "receiver"
+	Dispatcher = "dispatcher"
+	Controller = "controller"
+)
+
+// DefaultPeriod is how often the metrics are recorded, as the memory stats of knative.dev/pkg.
+const DefaultPeriod = 30 * time.Second
+
+var (
+	heapBytesM = stats.Int64(
+		"natss_runtime_heap_bytes",
+		"Bytes of the allocated heap objects",
+		stats.UnitBytes,
+	)
+
+	goroutinesM = stats.Int64(
+		"natss_runtime_goroutines",
+		"Number of goroutines",
+		stats.UnitDimensionless,
+	)
+
+	gcPauseM = stats.Int64(
+		"natss_runtime_gc_pause_ns",
+		"Duration of the last stop-the-world pause of the garbage collector",
+		"ns",
+	)
+
+	structureSizeM = stats.Int64(
+		"natss_structure_size",
+		"Size of the in-memory structures, in entries or in bytes as their name tells",
+		stats.UnitDimensionless,
+	)
+
+	// ComponentKey is the component the metrics are recorded for.
+	ComponentKey = tag.MustNewKey("component")
+	// StructureKey is the in-memory structure whose size is recorded.
+	StructureKey = tag.MustNewKey("structure")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: heapBytesM.Description(),
+			Measure:     heapBytesM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{ComponentKey},
+		},
+		&view.View{
+			Description: goroutinesM.Description(),
+			Measure:     goroutinesM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{ComponentKey},
+		},
+		&view.View{
+			Description: gcPauseM.Description(),
+			Measure:     gcPauseM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{ComponentKey},
+		},
+		&view.View{
+			Description: structureSizeM.Description(),
+			Measure:     structureSizeM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{ComponentKey, StructureKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// Structure is the size of an in-memory structure of a component.
+type Structure struct {
+	Component string
+	// Name names the structure and the unit of its size, such as host_map_entries.
+	Name string
+	Size int64
+}
+
+// Sizer returns the sizes of in-memory structures.
+type Sizer func() []Structure
+
+// Runtime is a sample of the Go runtime metrics.
+type Runtime struct {
+	HeapBytes  int64
+	Goroutines int64
+	// LastGCPause is zero before the first collection.
+	LastGCPause time.Duration
+}
+
+// Read samples the Go runtime metrics of the process.
+func Read() Runtime {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	r := Runtime{HeapBytes: int64(ms.HeapAlloc), Goroutines: int64(runtime.NumGoroutine())}
+	if ms.NumGC > 0 {
+		r.LastGCPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+	}
+	return r
+}
+
+// RecordRuntime records r for component.
+func RecordRuntime(component string, r Runtime) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(ComponentKey, component))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, heapBytesM.M(r.HeapBytes))
+	metrics.Record(ctx, goroutinesM.M(r.Goroutines))
+	metrics.Record(ctx, gcPauseM.M(int64(r.LastGCPause)))
+}
+
+// RecordStructures records the sizes of structures, as soon as they change for the structures
+// changing seldom.
+func RecordStructures(structures ...Structure) {
+	for _, st := range structures {
+		ctx, err := tag.New(context.Background(), tag.Upsert(ComponentKey, st.Component), tag.Upsert(StructureKey, st.Name))
+		if err != nil {
+			continue
+		}
+		metrics.Record(ctx, structureSizeM.M(st.Size))
+	}
+}
+
+// Run records the runtime metrics of the process for component and the structures sized by
+// sizers every period, DefaultPeriod when zero or less, until ctx is done.
+func Run(ctx context.Context, component string, period time.Duration, sizers ...Sizer) {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		RecordRuntime(component, Read())
+		for _, sizer := range sizers {
+			RecordStructures(sizer()...)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimemetrics
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	runtime.GC()
+	r := Read()
+	if r.HeapBytes <= 0 {
+		t.Errorf("HeapBytes = %d, want > 0", r.HeapBytes)
+	}
+	if r.Goroutines <= 0 {
+		t.Errorf("Goroutines = %d, want > 0", r.Goroutines)
+	}
+	if r.LastGCPause <= 0 {
+		t.Errorf("LastGCPause = %v, want > 0 after a collection", r.LastGCPause)
+	}
+}
+
+func TestRunSizes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sized := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, Controller, time.Millisecond, func() []Structure {
+			select {
+			case sized <- struct{}{}:
+			default:
+			}
+			return []Structure{{Component: Controller, Name: "dedup_cache_entries", Size: 1}}
+		})
+	}()
+
+	// The structures are sized right away, then every period.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sized:
+		case <-time.After(time.Second):
+			t.Fatalf("structures sized %d times, want 2", i)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return once the context was done")
+	}
+}
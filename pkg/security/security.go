@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package security builds the TLS configurations of the connections of the dispatcher.
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Config holds the TLS settings of the dispatcher.
+type Config struct {
+	// Strict restricts all the TLS connections to TLS 1.2 or later and to the cipher suites and
+	// curves approved by FIPS 140-2, and requires TLS for the connection to NATSS.
+	Strict bool
+
+	// CAFile is a PEM bundle of the certificate authorities trusted, in addition to the system
+	// ones, for the connection to NATSS and the deliveries. Setting it requires TLS for the
+	// connection to NATSS.
+	CAFile string
+
+	// ClientCertFile and ClientKeyFile hold the PEM certificate and key the dispatcher
+	// authenticates to NATSS with. Setting them requires TLS for the connection to NATSS.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// InsecureSkipVerify connects to NATSS over TLS without verifying its certificate, which the
+	// strict mode does not allow.
+	InsecureSkipVerify bool
+
+	// ReceiverCertFile and ReceiverKeyFile hold the PEM certificate and key the receiver serves
+	// HTTPS with, the receiver serving plain HTTP when they are not set.
+	ReceiverCertFile string
+	ReceiverKeyFile  string
+
+	// ReceiverCACerts is the PEM bundle of the certificate authorities of the receiver
+	// certificate, advertised along with the HTTPS addresses of the channels and trusted by the
+	// deliveries to them.
+	ReceiverCACerts string
+}
+
+// Validate checks that the certificates and keys are set together, that the receiver certificate
+// authorities hold a certificate, and that the strict mode verifies the certificate of NATSS.
+func (c Config) Validate() error {
+	if (c.ReceiverCertFile == "") != (c.ReceiverKeyFile == "") {
+		return errors.New("the receiver certificate and key must be set together")
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return errors.New("the client certificate and key must be set together")
+	}
+	if c.Strict && c.InsecureSkipVerify {
+		return errors.New("the strict mode verifies the certificate of NATSS")
+	}
+	if c.ReceiverCACerts != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(c.ReceiverCACerts)) {
+		return errors.New("no certificate found in the receiver certificate authorities")
+	}
+	return nil
+}
+
+// ClientTLS returns the TLS configuration of the connection to NATSS, nil when neither the strict
+// mode, a certificate authority, a client certificate nor InsecureSkipVerify is configured. The
+// files are read on each call.
+func (c Config) ClientTLS() (*tls.Config, error) {
+	if !c.Strict && c.CAFile == "" && c.ClientCertFile == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := c.clientTLS("")
+	if err != nil {
+		return nil, err
+	}
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	config.InsecureSkipVerify = c.InsecureSkipVerify
+	return config, nil
+}
+
+// DeliveryTLS returns the TLS configuration of the deliveries, which is ClientTLS also trusting
+// the receiver certificate authorities, nil when none of them is configured.
+func (c Config) DeliveryTLS() (*tls.Config, error) {
+	if !c.Strict && c.CAFile == "" && c.ReceiverCACerts == "" {
+		return nil, nil
+	}
+	return c.clientTLS(c.ReceiverCACerts)
+}
+
+// clientTLS returns the TLS configuration trusting the system certificate authorities, those of
+// CAFile and those of the PEM bundle extra.
+func (c Config) clientTLS(extra string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if c.CAFile != "" || extra != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if c.CAFile != "" {
+			pem, err := ioutil.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the certificate authorities: %w", err)
+			}
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+			}
+		}
+		if extra != "" && !roots.AppendCertsFromPEM([]byte(extra)) {
+			return nil, errors.New("no certificate found in the receiver certificate authorities")
+		}
+		config.RootCAs = roots
+	}
+	if c.Strict {
+		Harden(config)
+	}
+	return config, nil
+}
+
+// ServerTLS returns the TLS configuration of the receiver, nil when it serves plain HTTP.
+func (c Config) ServerTLS() (*tls.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.ReceiverCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.ReceiverCertFile, c.ReceiverKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the receiver certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.Strict {
+		Harden(config)
+	}
+	return config, nil
+}
+
+// Harden restricts config to TLS 1.2 or later, to the AES-GCM cipher suites with forward secrecy
+// and to the NIST curves, as approved by FIPS 140-2. The TLS 1.3 cipher suites are not
+// configurable and all use AEAD ciphers.
+func Harden(config *tls.Config) {
+	config.MinVersion = tls.VersionTLS12
+	config.CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	config.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+}
+
+// NewTransport returns a copy of the default HTTP transport using config for its TLS connections.
+func NewTransport(config *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+// CheckRedirect is an http.Client CheckRedirect refusing the redirects from HTTPS to plain HTTP,
+// so that a request configured for TLS never goes out in plaintext.
+func CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if previous := via[len(via)-1]; req.URL.Scheme == "http" && previous.URL.Scheme == "https" {
+		return fmt.Errorf("refusing the redirect from %s to plain HTTP %s", previous.URL, req.URL)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTLSServer starts an HTTPS server restricted by config, nil for the defaults.
+func newTLSServer(t *testing.T, config *tls.Config) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// weakTLS only offers a cipher suite without AEAD.
+func weakTLS() *tls.Config {
+	return &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "security")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeCA writes the certificate of server to a PEM file.
+func writeCA(t *testing.T, server *httptest.Server) string {
+	file := filepath.Join(tempDir(t), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientTLS(t *testing.T) {
+	testCases := map[string]struct {
+		server  *tls.Config
+		strict  bool
+		wantErr bool
+	}{
+		"weak cipher, strict": {
+			server:  weakTLS(),
+			strict:  true,
+			wantErr: true,
+		},
+		"weak cipher": {
+			server: weakTLS(),
+		},
+		"TLS 1.1, strict": {
+			server:  &tls.Config{MaxVersion: tls.VersionTLS11},
+			strict:  true,
+			wantErr: true,
+		},
+		"TLS 1.2 with AES-GCM, strict": {
+			server: &tls.Config{
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			},
+			strict: true,
+		},
+		"TLS 1.3, strict": {
+			server: &tls.Config{MinVersion: tls.VersionTLS13},
+			strict: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			server := newTLSServer(t, tc.server)
+			config, err := Config{Strict: tc.strict, CAFile: writeCA(t, server)}.ClientTLS()
+			if err != nil {
+				t.Fatalf("ClientTLS() = %v", err)
+			}
+			client := &http.Client{Transport: NewTransport(config)}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Get() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientTLSDisabled(t *testing.T) {
+	config, err := Config{}.ClientTLS()
+	if config != nil || err != nil {
+		t.Errorf("ClientTLS() = %v, %v, want no TLS configuration", config, err)
+	}
+	if _, err := (Config{CAFile: filepath.Join(tempDir(t), "missing.pem")}).ClientTLS(); err == nil {
+		t.Error("ClientTLS() succeeded with a missing certificate authority file")
+	}
+}
+
+// writeKeyPair writes a self-signed client certificate and its key to PEM files.
+func writeKeyPair(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dispatcher"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempDir(t)
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestClientTLSClientCertificate(t *testing.T) {
+	server := newTLSServer(t, &tls.Config{ClientAuth: tls.RequireAnyClientCert})
+	certFile, keyFile := writeKeyPair(t)
+
+	testCases := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"without client certificate": {
+			config:  Config{CAFile: writeCA(t, server)},
+			wantErr: true,
+		},
+		"with client certificate": {
+			config: Config{CAFile: writeCA(t, server), ClientCertFile: certFile, ClientKeyFile: keyFile},
+		},
+		"without verification": {
+			config: Config{ClientCertFile: certFile, ClientKeyFile: keyFile, InsecureSkipVerify: true},
+		},
+		"unknown certificate authority": {
+			config:  Config{ClientCertFile: certFile, ClientKeyFile: keyFile},
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			config, err := tc.config.ClientTLS()
+			if err != nil {
+				t.Fatalf("ClientTLS() = %v", err)
+			}
+			resp, err := (&http.Client{Transport: NewTransport(config)}).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Get() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientTLSInvalid(t *testing.T) {
+	certFile, _ := writeKeyPair(t)
+	testCases := map[string]Config{
+		"certificate without key":  {ClientCertFile: certFile},
+		"missing key":              {ClientCertFile: certFile, ClientKeyFile: filepath.Join(tempDir(t), "missing.key")},
+		"strict without verifying": {Strict: true, InsecureSkipVerify: true},
+		"certificate as key":       {ClientCertFile: certFile, ClientKeyFile: certFile},
+	}
+	for n, c := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if _, err := c.ClientTLS(); err == nil {
+				t.Error("ClientTLS() succeeded")
+			}
+		})
+	}
+}
+
+func TestDeliveryTLS(t *testing.T) {
+	server := newTLSServer(t, nil)
+	caCerts := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	// The receiver certificate authorities are trusted by the deliveries only.
+	c := Config{ReceiverCACerts: caCerts}
+	if config, err := c.ClientTLS(); config != nil || err != nil {
+		t.Errorf("ClientTLS() = %v, %v, want no TLS configuration", config, err)
+	}
+	config, err := c.DeliveryTLS()
+	if err != nil {
+		t.Fatalf("DeliveryTLS() = %v", err)
+	}
+	resp, err := (&http.Client{Transport: NewTransport(config)}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	resp.Body.Close()
+
+	if config, err := (Config{}).DeliveryTLS(); config != nil || err != nil {
+		t.Errorf("DeliveryTLS() = %v, %v, want no TLS configuration", config, err)
+	}
+	if err := (Config{ReceiverCACerts: "not a certificate"}).Validate(); err == nil {
+		t.Error("Validate() succeeded without receiver certificate authority")
+	}
+}
+
+func TestParseTransportEncryption(t *testing.T) {
+	testCases := map[string]struct {
+		want    TransportEncryption
+		wantErr bool
+	}{
+		"":           {want: TransportEncryptionDisabled},
+		"disabled":   {want: TransportEncryptionDisabled},
+		"Permissive": {want: TransportEncryptionPermissive},
+		" strict ":   {want: TransportEncryptionStrict},
+		"enabled":    {wantErr: true},
+	}
+	for raw, tc := range testCases {
+		got, err := ParseTransportEncryption(raw)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("ParseTransportEncryption(%q) = %q, %v, want %q and error %v", raw, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	// Reuse the certificate of a test server.
+	server := newTLSServer(t, nil)
+	cert := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempDir(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Config{Strict: true, ReceiverCertFile: certFile, ReceiverKeyFile: keyFile}.ServerTLS()
+	if err != nil {
+		t.Fatalf("ServerTLS() = %v", err)
+	}
+	if len(config.Certificates) != 1 || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("ServerTLS() = %+v, want the certificate and TLS 1.2 or later", config)
+	}
+
+	if config, err := (Config{}).ServerTLS(); config != nil || err != nil {
+		t.Errorf("ServerTLS() = %v, %v, want plain HTTP", config, err)
+	}
+	if _, err := (Config{ReceiverCertFile: certFile}).ServerTLS(); err == nil {
+		t.Error("ServerTLS() succeeded without key")
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	request := func(rawURL string) *http.Request {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Request{URL: u}
+	}
+	testCases := map[string]struct {
+		from, to string
+		wantErr  bool
+	}{
+		"https to http": {
+			from:    "https://subscriber.example.com",
+			to:      "http://subscriber.example.com",
+			wantErr: true,
+		},
+		"https to https": {
+			from: "https://subscriber.example.com",
+			to:   "https://other.example.com",
+		},
+		"http to http": {
+			from: "http://subscriber.example.com",
+			to:   "http://other.example.com",
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			err := CheckRedirect(request(tc.to), []*http.Request{request(tc.from)})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("CheckRedirect() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package security
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TransportEncryption is the cluster-wide transport encryption mode of Knative Eventing, which
+// rolls HTTPS out to the addressables in phases.
+type TransportEncryption string
+
+const (
+	// TransportEncryptionDisabled only advertises the plain HTTP addresses. It is the default.
+	TransportEncryptionDisabled TransportEncryption = "disabled"
+	// TransportEncryptionPermissive serves both HTTP and HTTPS, and advertises the HTTPS
+	// addresses along with the HTTP ones.
+	TransportEncryptionPermissive TransportEncryption = "permissive"
+	// TransportEncryptionStrict only serves and advertises HTTPS, refusing plain HTTP.
+	TransportEncryptionStrict TransportEncryption = "strict"
+)
+
+// ParseTransportEncryption parses the transport encryption mode, ignoring its case, an empty
+// value standing for TransportEncryptionDisabled.
+func ParseTransportEncryption(raw string) (TransportEncryption, error) {
+	switch mode := TransportEncryption(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "":
+		return TransportEncryptionDisabled, nil
+	case TransportEncryptionDisabled, TransportEncryptionPermissive, TransportEncryptionStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown transport encryption mode %q, expected one of %q, %q or %q",
+			raw, TransportEncryptionDisabled, TransportEncryptionPermissive, TransportEncryptionStrict)
+	}
+}
+
+// ServesHTTPS returns whether the mode serves and advertises HTTPS.
+func (m TransportEncryption) ServesHTTPS() bool {
+	return m == TransportEncryptionPermissive || m == TransportEncryptionStrict
+}
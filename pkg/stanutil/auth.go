@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// The keys of a Secret holding the credentials of the connections to NATS, see AuthFromSecret.
+const (
+	AuthUserKey     = "user"
+	AuthPasswordKey = "password"
+	AuthTokenKey    = "token"
+	AuthCredsKey    = "creds"
+	AuthNKeyKey     = "nkey"
+)
+
+// Auth holds the credentials the connections to NATS authenticate with, in a single mode: a user
+// and its password, a token, the content of a .creds file, or an NKey seed. The zero Auth does not
+// authenticate.
+type Auth struct {
+	User     string
+	Password string
+	Token    string
+	// Creds is the content of a .creds file, the JWT of a user followed by its NKey seed.
+	Creds []byte
+	// NKeySeed is the seed of the NKey of a user.
+	NKeySeed []byte
+}
+
+// AuthFromSecret returns the credentials held by data, the data of a Secret with the AuthUserKey
+// and AuthPasswordKey, AuthTokenKey, AuthCredsKey or AuthNKeyKey keys. The surrounding spaces of
+// the values are ignored.
+func AuthFromSecret(data map[string][]byte) Auth {
+	return Auth{
+		User:     strings.TrimSpace(string(data[AuthUserKey])),
+		Password: strings.TrimSpace(string(data[AuthPasswordKey])),
+		Token:    strings.TrimSpace(string(data[AuthTokenKey])),
+		Creds:    bytes.TrimSpace(data[AuthCredsKey]),
+		NKeySeed: bytes.TrimSpace(data[AuthNKeyKey]),
+	}
+}
+
+// modes returns the authentication modes set in a.
+func (a Auth) modes() []string {
+	var modes []string
+	if a.User != "" || a.Password != "" {
+		modes = append(modes, "user and password")
+	}
+	if a.Token != "" {
+		modes = append(modes, "token")
+	}
+	if len(a.Creds) > 0 {
+		modes = append(modes, "creds")
+	}
+	if len(a.NKeySeed) > 0 {
+		modes = append(modes, "nkey")
+	}
+	return modes
+}
+
+// IsZero tells whether a does not authenticate.
+func (a Auth) IsZero() bool {
+	return len(a.modes()) == 0
+}
+
+// Validate checks that exactly one authentication mode is set, with the user and the password
+// set together.
+func (a Auth) Validate() error {
+	switch modes := a.modes(); len(modes) {
+	case 0:
+		return errors.New("no authentication mode is set")
+	case 1:
+	default:
+		return fmt.Errorf("exactly one authentication mode must be set, got %s", strings.Join(modes, ", "))
+	}
+	if (a.User == "") != (a.Password == "") {
+		return errors.New("the user and password must be set together")
+	}
+	return nil
+}
+
+// Option returns the nats.Option authenticating with a, nil for the zero Auth. The JWT and seeds
+// are parsed, and the error returned, once.
+func (a Auth) Option() (nats.Option, error) {
+	if a.IsZero() {
+		return nil, nil
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case a.User != "":
+		return nats.UserInfo(a.User, a.Password), nil
+	case a.Token != "":
+		return nats.Token(a.Token), nil
+	case len(a.Creds) > 0:
+		userJWT, err := jwt.ParseDecoratedJWT(a.Creds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid creds: %w", err)
+		}
+		kp, err := jwt.ParseDecoratedUserNKey(a.Creds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid creds: %w", err)
+		}
+		return nats.UserJWT(func() (string, error) {
+			return userJWT, nil
+		}, kp.Sign), nil
+	default:
+		kp, err := nkeys.FromSeed(a.NKeySeed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nkey: %w", err)
+		}
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid nkey: %w", err)
+		}
+		if !nkeys.IsValidPublicUserKey(pub) {
+			return nil, errors.New("invalid nkey: not the seed of a user")
+		}
+		return nats.Nkey(pub, kp.Sign), nil
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+const testNonce = "dGVzdC1ub25jZQ"
+
+// natsConnect is the CONNECT message of a NATS client.
+type natsConnect struct {
+	JWT       string `json:"jwt"`
+	NKey      string `json:"nkey"`
+	Signature string `json:"sig"`
+	User      string `json:"user"`
+	Pass      string `json:"pass"`
+	Token     string `json:"auth_token"`
+}
+
+// signedBy tells whether the signature of c is that of the nonce by the public key pub.
+func (c natsConnect) signedBy(pub string) bool {
+	kp, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return false
+	}
+	return kp.Verify([]byte(testNonce), sig) == nil
+}
+
+// startAuthNats starts a NATS server requiring authentication, accepting the clients whose
+// CONNECT message satisfies authorized, and returns its URL.
+func startAuthNats(t *testing.T, authorized func(natsConnect) bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576,\"auth_required\":true,\"nonce\":\"" + testNonce + "\"}\r\n"))
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case strings.HasPrefix(line, "CONNECT "):
+						var connect natsConnect
+						if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect); err != nil || !authorized(connect) {
+							conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+							return
+						}
+					case line == "PING\r\n":
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func newUserNKey(t *testing.T) nkeys.KeyPair {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func seedOf(t *testing.T, kp nkeys.KeyPair) []byte {
+	seed, err := kp.Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seed
+}
+
+func publicKeyOf(t *testing.T, kp nkeys.KeyPair) string {
+	pub, err := kp.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// newCreds returns the content of the .creds file of a new user of a new account.
+func newCreds(t *testing.T) []byte {
+	account, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := newUserNKey(t)
+	token, err := jwt.NewUserClaims(publicKeyOf(t, user)).Encode(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := jwt.FormatUserConfig(token, seedOf(t, user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creds
+}
+
+func TestAuth(t *testing.T) {
+	userKey := newUserNKey(t)
+	otherKey := newUserNKey(t)
+
+	testCases := map[string]struct {
+		authorized func(natsConnect) bool
+		auth       Auth
+		wantErr    bool
+	}{
+		"user and password": {
+			authorized: func(c natsConnect) bool { return c.User == "dispatcher" && c.Pass == "s3cret" },
+			auth:       Auth{User: "dispatcher", Password: "s3cret"},
+		},
+		"wrong password": {
+			authorized: func(c natsConnect) bool { return c.User == "dispatcher" && c.Pass == "s3cret" },
+			auth:       Auth{User: "dispatcher", Password: "guess"},
+			wantErr:    true,
+		},
+		"token": {
+			authorized: func(c natsConnect) bool { return c.Token == "t0ken" },
+			auth:       Auth{Token: "t0ken"},
+		},
+		"nkey": {
+			authorized: func(c natsConnect) bool {
+				return c.NKey == publicKeyOf(t, userKey) && c.signedBy(c.NKey)
+			},
+			auth: Auth{NKeySeed: seedOf(t, userKey)},
+		},
+		"unknown nkey": {
+			authorized: func(c natsConnect) bool {
+				return c.NKey == publicKeyOf(t, userKey) && c.signedBy(c.NKey)
+			},
+			auth:    Auth{NKeySeed: seedOf(t, otherKey)},
+			wantErr: true,
+		},
+		"creds": {
+			authorized: func(c natsConnect) bool {
+				claims, err := jwt.DecodeUserClaims(c.JWT)
+				return err == nil && c.signedBy(claims.Subject)
+			},
+			auth: Auth{Creds: newCreds(t)},
+		},
+		"no credentials": {
+			authorized: func(c natsConnect) bool { return c.Token == "t0ken" },
+			wantErr:    true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			url := startAuthNats(t, tc.authorized)
+			var opts []nats.Option
+			option, err := tc.auth.Option()
+			if err != nil {
+				t.Fatalf("Option() = %v", err)
+			}
+			if option != nil {
+				opts = append(opts, option)
+			}
+			err = Probe(url, opts...)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Probe() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthValidate(t *testing.T) {
+	testCases := map[string]struct {
+		auth    Auth
+		wantErr bool
+	}{
+		"user and password": {
+			auth: Auth{User: "dispatcher", Password: "s3cret"},
+		},
+		"user without password": {
+			auth:    Auth{User: "dispatcher"},
+			wantErr: true,
+		},
+		"password without user": {
+			auth:    Auth{Password: "s3cret"},
+			wantErr: true,
+		},
+		"token and creds": {
+			auth:    Auth{Token: "t0ken", Creds: []byte("creds")},
+			wantErr: true,
+		},
+		"none": {
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if err := tc.auth.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthOptionInvalid(t *testing.T) {
+	testCases := map[string]Auth{
+		"two modes":          {Token: "t0ken", User: "dispatcher", Password: "s3cret"},
+		"invalid seed":       {NKeySeed: []byte("not a seed")},
+		"seed of an account": {NKeySeed: seedOf(t, mustCreateAccount(t))},
+		"creds without nkey": {Creds: []byte("-----BEGIN NATS USER JWT-----\neyJ0eXAiOiJqd3QifQ.e30.c2ln\n------END NATS USER JWT------\n")},
+	}
+	for n, auth := range testCases {
+		t.Run(n, func(t *testing.T) {
+			if _, err := auth.Option(); err == nil {
+				t.Error("Option() succeeded")
+			}
+		})
+	}
+	if option, err := (Auth{}).Option(); option != nil || err != nil {
+		t.Errorf("Option() = %v, %v, want no option without credentials", option, err)
+	}
+}
+
+func TestAuthFromSecret(t *testing.T) {
+	got := AuthFromSecret(map[string][]byte{
+		AuthUserKey:     []byte("dispatcher\n"),
+		AuthPasswordKey: []byte(" s3cret\n"),
+	})
+	if got.User != "dispatcher" || got.Password != "s3cret" || got.Token != "" || len(got.Creds) > 0 || len(got.NKeySeed) > 0 {
+		t.Errorf("AuthFromSecret() = %+v, want the trimmed user and password only", got)
+	}
+}
+
+func mustCreateAccount(t *testing.T) nkeys.KeyPair {
+	kp, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
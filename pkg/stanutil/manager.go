@@ -0,0 +1,267 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+)
+
+// ErrManagerClosed is returned by ConnManager.Get once the manager is closed.
+var ErrManagerClosed = errors.New("connection manager closed")
+
+// ConnKey identifies a NATS-Streaming connection of a ConnManager.
+type ConnKey struct {
+	ClusterID string
+	ClientID  string
+	// URL is the comma separated NATS URLs of the cluster.
+	URL string
+}
+
+// ConnManager shares the NATS-Streaming connections between their users, connecting on the first
+// Get of a key and closing on its last Release. A connection lost, or closed behind the back of
+// the manager, is forgotten and made again by the next Get.
+type ConnManager struct {
+	logger *zap.SugaredLogger
+	// natsOpts configure the underlying NATS connections.
+	natsOpts []nats.Option
+	// stanOpts configure the streaming connections, such as stan.MaxPubAcksInflight, protected
+	// by mu.
+	stanOpts []stan.Option
+	// dial connects key, calling lost when the connection is lost. It is replaced by the tests.
+	dial func(key ConnKey, lost func(error)) (stan.Conn, error)
+	// lostHandler is called with the key of each connection lost, nil when none is set.
+	lostHandler func(key ConnKey, err error)
+
+	mu     sync.Mutex
+	conns  map[ConnKey]*managedConn
+	closed bool
+}
+
+// managedConn is a connection of a ConnManager and its users.
+type managedConn struct {
+	// ready is closed once the connection is made or failed, conn and err being set.
+	ready chan struct{}
+	conn  stan.Conn
+	err   error
+	refs  int
+	lost  bool
+}
+
+// NewConnManager returns a ConnManager connecting with natsOpts, such as nats.Secure.
+func NewConnManager(logger *zap.SugaredLogger, natsOpts ...nats.Option) *ConnManager {
+	m := &ConnManager{
+		logger:   logger,
+		natsOpts: natsOpts,
+		conns:    make(map[ConnKey]*managedConn),
+	}
+	m.dial = func(key ConnKey, lost func(error)) (stan.Conn, error) {
+		m.mu.Lock()
+		stanOpts := m.stanOpts
+		m.mu.Unlock()
+		return connect(key, m.logger, lost, stanOpts, m.natsOpts...)
+	}
+	return m
+}
+
+// SetLostHandler sets the function called when the connection of a key is lost, such as when the
+// NATS Streaming server restarts, after the connection is forgotten. It must be set before the
+// first Get.
+func (m *ConnManager) SetLostHandler(handler func(key ConnKey, err error)) {
+	m.lostHandler = handler
+}
+
+// SetStanOptions sets the options of the streaming connections, such as stan.PubAckWait, applied
+// before the ones of the manager. The connections already made keep their options.
+func (m *ConnManager) SetStanOptions(opts ...stan.Option) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stanOpts = opts
+}
+
+// Get returns the connection of key, connecting when it has none or its connection is not
+// healthy. The concurrent calls for a key share a single connection attempt, ctx bounding the wait
+// for it. Each connection returned must be given back to Release.
+func (m *ConnManager) Get(ctx context.Context, key ConnKey) (stan.Conn, error) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrManagerClosed
+		}
+		mc, ok := m.conns[key]
+		if ok && isReady(mc) && !healthy(mc) {
+			// Closed by its users without being released, or lost.
+			m.logger.Infow("Replacing the unhealthy connection to NATSS", zap.String("clientId", key.ClientID))
+			delete(m.conns, key)
+			ok = false
+		}
+		if !ok {
+			return m.connect(key)
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-mc.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		m.mu.Lock()
+		if mc.err != nil {
+			m.mu.Unlock()
+			return nil, mc.err
+		}
+		if m.conns[key] != mc {
+			// Lost while waiting.
+			m.mu.Unlock()
+			continue
+		}
+		mc.refs++
+		m.mu.Unlock()
+		return mc.conn, nil
+	}
+}
+
+// connect makes the connection of key, to which it holds the first reference. It is called while
+// holding mu, which it releases.
+func (m *ConnManager) connect(key ConnKey) (stan.Conn, error) {
+	mc := &managedConn{ready: make(chan struct{})}
+	m.conns[key] = mc
+	m.mu.Unlock()
+
+	conn, err := m.dial(key, func(err error) { m.lose(key, mc, err) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer close(mc.ready)
+	if err == nil && m.closed {
+		_ = conn.Close()
+		err = ErrManagerClosed
+	}
+	if err != nil {
+		mc.err = err
+		if m.conns[key] == mc {
+			delete(m.conns, key)
+		}
+		return nil, err
+	}
+	mc.conn = conn
+	mc.refs = 1
+	return conn, nil
+}
+
+// lose forgets the lost connection mc of key, and tells the lost handler.
+func (m *ConnManager) lose(key ConnKey, mc *managedConn, err error) {
+	m.logger.Warnw("Connection to NATSS lost", zap.String("clientId", key.ClientID), zap.Error(err))
+	m.mu.Lock()
+	mc.lost = true
+	if m.conns[key] == mc {
+		delete(m.conns, key)
+	}
+	closed := m.closed
+	m.mu.Unlock()
+	if m.lostHandler != nil && !closed {
+		m.lostHandler(key, err)
+	}
+}
+
+// Release gives back the connection of key returned by Get, closing it when it was its last
+// user. Releasing a connection which was replaced closes it.
+func (m *ConnManager) Release(key ConnKey, conn stan.Conn) error {
+	m.mu.Lock()
+	mc, ok := m.conns[key]
+	if !ok || !isReady(mc) || mc.conn != conn {
+		m.mu.Unlock()
+		return closeStale(conn)
+	}
+	mc.refs--
+	if mc.refs > 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	delete(m.conns, key)
+	m.mu.Unlock()
+	return conn.Close()
+}
+
+// Healthy returns whether key has a connection which was neither lost nor closed.
+func (m *ConnManager) Healthy(key ConnKey) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mc, ok := m.conns[key]
+	return ok && isReady(mc) && healthy(mc)
+}
+
+// Close closes every connection, whatever its users, and fails the later calls to Get.
+func (m *ConnManager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	conns := m.conns
+	m.conns = make(map[ConnKey]*managedConn)
+	m.mu.Unlock()
+
+	var firstErr error
+	for _, mc := range conns {
+		if !isReady(mc) || mc.conn == nil {
+			// Closed by connect once made.
+			continue
+		}
+		if err := mc.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Probe connects to the NATS server at natsUrl with the options of the manager, see Probe.
+func (m *ConnManager) Probe(natsUrl string) error {
+	return probe(natsUrl, m.natsOpts...)
+}
+
+func isReady(mc *managedConn) bool {
+	select {
+	case <-mc.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthy returns whether the ready connection mc was neither lost nor closed.
+func healthy(mc *managedConn) bool {
+	if mc.err != nil || mc.lost {
+		return false
+	}
+	nc := mc.conn.NatsConn()
+	return nc != nil && !nc.IsClosed()
+}
+
+// closeStale closes a connection the manager forgot, which is usually closed already.
+func closeStale(conn stan.Conn) error {
+	if conn == nil || conn.NatsConn() == nil {
+		return nil
+	}
+	if err := conn.Close(); err != nil && err != stan.ErrConnectionClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stanutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/stan.go"
+	"go.uber.org/zap"
+)
+
+// fakeConn is a stan.Conn whose NatsConn, like the real one, is nil once closed.
+type fakeConn struct {
+	stan.Conn
+	mu     sync.Mutex
+	closed int
+	nc     *nats.Conn
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed++
+	return nil
+}
+
+func (c *fakeConn) NatsConn() *nats.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed > 0 {
+		return nil
+	}
+	return c.nc
+}
+
+func (c *fakeConn) closes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// fakeDialer records the connections made by a ConnManager.
+type fakeDialer struct {
+	mu    sync.Mutex
+	conns []*fakeConn
+	lost  []func(error)
+	// release, when not nil, is waited for by the connections.
+	release chan struct{}
+	err     error
+}
+
+func (d *fakeDialer) dial(_ ConnKey, lost func(error)) (stan.Conn, error) {
+	if d.release != nil {
+		<-d.release
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// A NATS connection which was never connected is not closed.
+	c := &fakeConn{nc: &nats.Conn{}}
+	d.conns = append(d.conns, c)
+	d.lost = append(d.lost, lost)
+	return c, nil
+}
+
+func (d *fakeDialer) dialed() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func newTestManager() (*ConnManager, *fakeDialer) {
+	m := NewConnManager(zap.NewNop().Sugar())
+	d := &fakeDialer{}
+	m.dial = d.dial
+	return m, d
+}
+
+var testKey = ConnKey{ClusterID: "cluster", ClientID: "client", URL: "nats://localhost:4222"}
+
+func TestConnManagerRefCounting(t *testing.T) {
+	ctx := context.Background()
+	m, d := newTestManager()
+
+	first, err := m.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	second, err := m.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if first != second || d.dialed() != 1 {
+		t.Fatalf("%d connections made, want the first shared", d.dialed())
+	}
+	other, err := m.Get(ctx, ConnKey{ClusterID: "cluster", ClientID: "other", URL: testKey.URL})
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if other == first {
+		t.Error("Get() shared the connection of another key")
+	}
+
+	if err := m.Release(testKey, first); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if n := d.conns[0].closes(); n != 0 {
+		t.Fatalf("connection closed %d times while in use", n)
+	}
+	if !m.Healthy(testKey) {
+		t.Error("Healthy() = false for a connection in use")
+	}
+	if err := m.Release(testKey, second); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if n := d.conns[0].closes(); n != 1 {
+		t.Errorf("connection closed %d times on its last release, want 1", n)
+	}
+	if m.Healthy(testKey) {
+		t.Error("Healthy() = true once released")
+	}
+
+	// The next Get connects again.
+	if _, err := m.Get(ctx, testKey); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if d.dialed() != 3 {
+		t.Errorf("%d connections made, want a new one after the last release", d.dialed())
+	}
+}
+
+func TestConnManagerConcurrentGet(t *testing.T) {
+	m, d := newTestManager()
+	d.release = make(chan struct{})
+	const users = 16
+
+	var wg sync.WaitGroup
+	conns := make([]stan.Conn, users)
+	var failed int32
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := m.Get(context.Background(), testKey)
+			if err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+			conns[i] = conn
+		}(i)
+	}
+	// Every Get waits for the single connection attempt.
+	time.Sleep(10 * time.Millisecond)
+	close(d.release)
+	wg.Wait()
+
+	if failed != 0 {
+		t.Fatalf("%d calls to Get() failed", failed)
+	}
+	if d.dialed() != 1 {
+		t.Fatalf("%d connections made, want 1", d.dialed())
+	}
+	for i, conn := range conns {
+		if err := m.Release(testKey, conn); err != nil {
+			t.Fatalf("Release() = %v", err)
+		}
+		if closes, last := d.conns[0].closes(), i == users-1; (closes == 1) != last {
+			t.Fatalf("connection closed %d times after %d releases", closes, i+1)
+		}
+	}
+}
+
+func TestConnManagerGetCanceled(t *testing.T) {
+	m, d := newTestManager()
+	d.release = make(chan struct{})
+	defer close(d.release)
+
+	go m.Get(context.Background(), testKey)
+	for !func() bool { m.mu.Lock(); defer m.mu.Unlock(); return m.conns[testKey] != nil }() {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Get(ctx, testKey); err != context.Canceled {
+		t.Errorf("Get() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConnManagerLostConnection(t *testing.T) {
+	ctx := context.Background()
+	m, d := newTestManager()
+
+	lost, err := m.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	d.lost[0](errors.New("lost"))
+	if m.Healthy(testKey) {
+		t.Error("Healthy() = true for a lost connection")
+	}
+	conn, err := m.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if conn == lost || d.dialed() != 2 {
+		t.Fatal("Get() returned the lost connection")
+	}
+
+	// Releasing the lost connection leaves the new one alone.
+	if err := m.Release(testKey, lost); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if d.conns[1].closes() != 0 || !m.Healthy(testKey) {
+		t.Error("releasing the lost connection closed its replacement")
+	}
+
+	// A connection closed by its user is replaced as well.
+	_ = conn.Close()
+	if m.Healthy(testKey) {
+		t.Error("Healthy() = true for a closed connection")
+	}
+	if replacement, err := m.Get(ctx, testKey); err != nil || replacement == conn {
+		t.Errorf("Get() = %v, %v, want a new connection", replacement, err)
+	}
+}
+
+func TestConnManagerLostHandler(t *testing.T) {
+	m, d := newTestManager()
+	var lost []ConnKey
+	m.SetLostHandler(func(key ConnKey, err error) {
+		// The lost connection is forgotten first.
+		if m.Healthy(key) {
+			t.Error("the lost handler was called before the connection was forgotten")
+		}
+		lost = append(lost, key)
+	})
+
+	if _, err := m.Get(context.Background(), testKey); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	d.lost[0](errors.New("server restarted"))
+	if len(lost) != 1 || lost[0] != testKey {
+		t.Errorf("the lost handler was called with %v, want %v", lost, testKey)
+	}
+
+	// The connections closed with the manager are not reported.
+	if _, err := m.Get(context.Background(), testKey); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	_ = m.Close()
+	d.lost[1](errors.New("closed"))
+	if len(lost) != 1 {
+		t.Errorf("the lost handler was called %d times, want once", len(lost))
+	}
+}
+
+func TestConnManagerDialFailure(t *testing.T) {
+	m, d := newTestManager()
+	d.err = errors.New("unreachable")
+	if _, err := m.Get(context.Background(), testKey); err != d.err {
+		t.Fatalf("Get() = %v, want %v", err, d.err)
+	}
+	d.err = nil
+	if _, err := m.Get(context.Background(), testKey); err != nil {
+		t.Errorf("Get() = %v after a failure, want a new attempt", err)
+	}
+}
+
+func TestConnManagerClose(t *testing.T) {
+	ctx := context.Background()
+	m, d := newTestManager()
+	if _, err := m.Get(ctx, testKey); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if n := d.conns[0].closes(); n != 1 {
+		t.Errorf("connection closed %d times, want 1", n)
+	}
+	if _, err := m.Get(ctx, testKey); err != ErrManagerClosed {
+		t.Errorf("Get() = %v, want %v", err, ErrManagerClosed)
+	}
+}
@@ -17,19 +17,239 @@
 package stanutil
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/stan.go"
 
 	"go.uber.org/zap"
 )
 
-// Connect creates a new NATS-Streaming connection
-func Connect(clusterId string, clientId string, natsUrl string, logger *zap.SugaredLogger) (*stan.Conn, error) {
-	logger.Infof("Connect(): clusterId: %v; clientId: %v; natssUrl: %v", clusterId, clientId, natsUrl)
-	sc, err := stan.Connect(clusterId, clientId, stan.NatsURL(natsUrl))
+// Connect creates a new NATS-Streaming connection. The natsOpts, such as nats.Secure or the
+// Option of an Auth, configure the underlying NATS connection, which is closed when the streaming
+// connection is lost.
+//
+// Deprecated: use ConnManager.Get, which shares the connections of a key.
+func Connect(clusterId string, clientId string, natsUrl string, logger *zap.SugaredLogger, natsOpts ...nats.Option) (*stan.Conn, error) {
+	sc, err := connect(ConnKey{ClusterID: clusterId, ClientID: clientId, URL: natsUrl}, logger, nil, nil, natsOpts...)
 	if err != nil {
-		logger.Errorf("Connect(): create new connection failed: %v", err)
 		return nil, err
 	}
-	logger.Infof("Connect(): connection to NATSS established, natsConn=%+v", &sc)
 	return &sc, nil
 }
+
+// connect creates a new NATS-Streaming connection to key with stanOpts, calling lost, when not
+// nil, once the connection is lost.
+func connect(key ConnKey, logger *zap.SugaredLogger, lost func(error), stanOpts []stan.Option, natsOpts ...nats.Option) (stan.Conn, error) {
+	natsUrl, err := NormalizeURL(key.URL)
+	if err != nil {
+		logger.Errorw("Invalid NATS URL", zap.Error(err))
+		return nil, err
+	}
+	logger = logger.With(zap.String("clusterId", key.ClusterID), zap.String("clientId", key.ClientID), zap.String("natssUrl", natsUrl))
+	logger.Info("Connecting to NATSS")
+	opts := append(append([]stan.Option(nil), stanOpts...), stan.NatsURL(natsUrl))
+	var nc *nats.Conn
+	if len(natsOpts) > 0 {
+		if nc, err = nats.Connect(natsUrl, natsOpts...); err != nil {
+			logger.Errorw("Create new NATS connection failed", zap.Error(err))
+			return nil, err
+		}
+		// The streaming connection does not own a NATS connection it is given.
+		opts = append(opts, stan.NatsConn(nc))
+	}
+	if nc != nil || lost != nil {
+		opts = append(opts, stan.SetConnectionLostHandler(func(_ stan.Conn, err error) {
+			if nc != nil {
+				nc.Close()
+			}
+			if lost != nil {
+				lost(err)
+			}
+		}))
+	}
+	sc, err := stan.Connect(key.ClusterID, key.ClientID, opts...)
+	if err != nil {
+		if nc != nil {
+			nc.Close()
+		}
+		logger.Errorw("Create new connection failed", zap.Error(err))
+		return nil, err
+	}
+	logger.Info("Connection to NATSS established")
+	return sc, nil
+}
+
+// SecureReloaded returns a nats.Option securing the connection with the TLS configuration load
+// returns when the connection is made, so that the certificates rotated on disk are used from the
+// next connection on, without a restart. The connection fails with the error of load.
+func SecureReloaded(load func() (*tls.Config, error)) nats.Option {
+	return func(o *nats.Options) error {
+		config, err := load()
+		if err != nil {
+			return fmt.Errorf("failed to load the TLS configuration: %w", err)
+		}
+		return nats.Secure(config)(o)
+	}
+}
+
+// Probe connects to the NATS server at natsUrl with natsOpts and returns an error when the server
+// rejects the connection, for example because it cannot satisfy the TLS configuration. An
+// unreachable server is not an error, the connection being attempted again later.
+//
+// Deprecated: use ConnManager.Probe, which probes with the options of the manager.
+func Probe(natsUrl string, natsOpts ...nats.Option) error {
+	return probe(natsUrl, natsOpts...)
+}
+
+func probe(natsUrl string, natsOpts ...nats.Option) error {
+	natsUrl, err := NormalizeURL(natsUrl)
+	if err != nil {
+		return err
+	}
+	nc, err := nats.Connect(natsUrl, natsOpts...)
+	if err == nats.ErrNoServers {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("connection to NATS at %s failed: %w", natsUrl, err)
+	}
+	nc.Close()
+	return nil
+}
+
+// defaultNatsPort is the port of the NATS URLs without one.
+const defaultNatsPort = "4222"
+
+// NormalizeURL returns the comma separated NATS URLs of natsUrl in the form the NATS client
+// parses: the scheme defaults to nats, the port to 4222, and the IPv6 literals are bracketed with
+// their zone identifier escaped, as in nats://[fe80::1%25eth0]:4222. The order of the URLs is
+// kept, so that a dual-stack server may be listed by both its IPv6 and IPv4 addresses, the client
+// falling back to the next one it can reach.
+func NormalizeURL(natsUrl string) (string, error) {
+	var urls []string
+	for _, raw := range strings.Split(natsUrl, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := normalizeURL(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid NATS URL %q: %w", raw, err)
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return "", fmt.Errorf("no NATS URL in %q", natsUrl)
+	}
+	return strings.Join(urls, ","), nil
+}
+
+func normalizeURL(raw string) (string, error) {
+	scheme, rest := "nats", raw
+	if i := strings.Index(raw, "://"); i >= 0 {
+		scheme, rest = raw[:i], raw[i+len("://"):]
+	}
+	var userinfo, path string
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest, path = rest[:i], rest[i:]
+	}
+	if i := strings.LastIndexByte(rest, '@'); i >= 0 {
+		userinfo, rest = rest[:i+1], rest[i+1:]
+	}
+
+	host, port, err := splitHostPort(rest)
+	if err != nil {
+		return "", err
+	}
+	bracketed := strings.HasPrefix(rest, "[")
+	if host == "" {
+		return "", fmt.Errorf("missing host")
+	}
+	if port == "" {
+		port = defaultNatsPort
+	} else if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if bracketed || strings.Contains(host, ":") {
+		addr, zone := host, ""
+		if i := strings.IndexByte(host, '%'); i >= 0 {
+			addr, zone = host[:i], host[i+1:]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("invalid IPv6 address %q", host)
+		}
+		host = "[" + ip.String()
+		if zone != "" {
+			host += "%25" + zone
+		}
+		host += "]"
+	}
+
+	normalized := scheme + "://" + userinfo + host + ":" + port + path
+	if _, err := url.Parse(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// splitHostPort splits hostport into its host, with the zone identifier of an IPv6 literal
+// unescaped, and its optional port. An IPv6 literal without brackets cannot have a port.
+func splitHostPort(hostport string) (host, port string, err error) {
+	switch {
+	case strings.HasPrefix(hostport, "["):
+		end := strings.IndexByte(hostport, ']')
+		if end < 0 {
+			return "", "", fmt.Errorf("missing ']' in %q", hostport)
+		}
+		host, rest := hostport[1:end], hostport[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return "", "", fmt.Errorf("unexpected %q after the IPv6 address", rest)
+			}
+			port = rest[1:]
+		}
+		return strings.Replace(host, "%25", "%", 1), port, nil
+	case strings.Count(hostport, ":") > 1:
+		return strings.Replace(hostport, "%25", "%", 1), "", nil
+	case strings.Contains(hostport, ":"):
+		return net.SplitHostPort(hostport)
+	default:
+		return hostport, "", nil
+	}
+}
+
+// channelRejections are the messages of the errors of the NATS-Streaming servers refusing a
+// channel they do not serve, such as a channel missing from the fixed list of a server which
+// does not create them on demand.
+var channelRejections = []string{
+	"channel not found",
+	"unknown channel",
+	"channel not allowed",
+	"too many channels",
+}
+
+// IsChannelNotProvisioned tells whether err is the rejection of a channel the NATS-Streaming
+// server does not serve. The partitioned servers ignore the requests of the channels none of them
+// owns instead, which then time out: the timeouts are only counted when partitioned is set, since
+// they are otherwise the sign of an overloaded or unreachable server.
+func IsChannelNotProvisioned(err error, partitioned bool) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	if partitioned && (strings.Contains(msg, stan.ErrTimeout.Error()) || strings.Contains(msg, stan.ErrSubReqTimeout.Error())) {
+		return true
+	}
+	for _, rejection := range channelRejections {
+		if strings.Contains(msg, rejection) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,8 +17,17 @@ limitations under the License.
 package stanutil
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"testing"
 
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/stan.go"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"knative.dev/pkg/logging"
@@ -108,6 +117,188 @@ func TestConnect(t *testing.T) {
 	}
 }
 
+// startFakeNats starts a NATS server offering no TLS on a listener of address, returning its
+// URL, or skips the test when the address cannot be listened on.
+func startFakeNats(t *testing.T, address string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n"))
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == "PING\r\n" {
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func TestProbe(t *testing.T) {
+	plainURL := startFakeNats(t, "127.0.0.1:0")
+
+	if err := Probe(plainURL); err != nil {
+		t.Errorf("Probe() = %v, want the plain connection to succeed", err)
+	}
+	if err := Probe(plainURL, nats.Secure(&tls.Config{})); err == nil {
+		t.Error("Probe() succeeded with TLS required from a server without TLS")
+	}
+	// An unreachable server is retried by the connection loop rather than refused.
+	if err := Probe("nats://127.0.0.1:1", nats.Secure(&tls.Config{})); err != nil {
+		t.Errorf("Probe() = %v, want nil for an unreachable server", err)
+	}
+	if err := Probe("nats://[::1"); err == nil {
+		t.Error("Probe() succeeded with an invalid URL")
+	}
+}
+
+func TestProbeIPv6(t *testing.T) {
+	v6URL := startFakeNats(t, "[::1]:0")
+	if err := Probe(v6URL, nats.Secure(&tls.Config{})); err == nil {
+		t.Error("Probe() succeeded with TLS required from a server without TLS, the IPv6 server was not reached")
+	}
+	// The zone identifier is escaped.
+	zoned := strings.Replace(v6URL, "[::1]", "[::1%lo]", 1)
+	if err := Probe(zoned, nats.Secure(&tls.Config{})); err == nil {
+		t.Error("Probe() succeeded with TLS required from a server without TLS, the zoned IPv6 server was not reached")
+	}
+}
+
+func TestProbeDualStackFallback(t *testing.T) {
+	v4URL := startFakeNats(t, "127.0.0.1:0")
+	// The IPv6 address of the server is unreachable, the client falls back to its IPv4 address.
+	if err := Probe("nats://[::1]:1,"+v4URL, nats.DontRandomize(), nats.Secure(&tls.Config{})); err == nil {
+		t.Error("Probe() succeeded with TLS required from a server without TLS, the IPv4 address was not reached")
+	}
+}
+
+func TestSecureReloaded(t *testing.T) {
+	plainURL := startFakeNats(t, "127.0.0.1:0")
+
+	loads := 0
+	reloaded := SecureReloaded(func() (*tls.Config, error) {
+		loads++
+		return &tls.Config{}, nil
+	})
+	for i := 1; i <= 2; i++ {
+		if err := Probe(plainURL, reloaded); err == nil {
+			t.Error("Probe() succeeded with TLS required from a server without TLS")
+		}
+		if loads != i {
+			t.Errorf("the TLS configuration was loaded %d times for %d connections", loads, i)
+		}
+	}
+
+	errRotating := errors.New("the certificate is being rotated")
+	err := Probe(plainURL, SecureReloaded(func() (*tls.Config, error) {
+		return nil, errRotating
+	}))
+	if !errors.Is(err, errRotating) {
+		t.Errorf("Probe() = %v, want %v", err, errRotating)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	testCases := map[string]struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		"ipv4": {
+			url:  "nats://192.0.2.1:4222",
+			want: "nats://192.0.2.1:4222",
+		},
+		"ipv4 without port": {
+			url:  "nats://192.0.2.1",
+			want: "nats://192.0.2.1:4222",
+		},
+		"hostname": {
+			url:  "nats://nats-streaming.natss.svc.cluster.local:4222",
+			want: "nats://nats-streaming.natss.svc.cluster.local:4222",
+		},
+		"hostname without scheme": {
+			url:  "nats-streaming.natss.svc.cluster.local",
+			want: "nats://nats-streaming.natss.svc.cluster.local:4222",
+		},
+		"ipv6": {
+			url:  "nats://[2001:db8::1]:4222",
+			want: "nats://[2001:db8::1]:4222",
+		},
+		"ipv6 without port": {
+			url:  "nats://[2001:DB8:0::1]",
+			want: "nats://[2001:db8::1]:4222",
+		},
+		"ipv6 without brackets": {
+			url:  "nats://2001:db8::1",
+			want: "nats://[2001:db8::1]:4222",
+		},
+		"ipv6 with zone": {
+			url:  "nats://[fe80::1%eth0]:4222",
+			want: "nats://[fe80::1%25eth0]:4222",
+		},
+		"ipv6 with escaped zone": {
+			url:  "nats://[fe80::1%25eth0]:4222",
+			want: "nats://[fe80::1%25eth0]:4222",
+		},
+		"credentials": {
+			url:  "tls://user:pass@[2001:db8::1]:4443",
+			want: "tls://user:pass@[2001:db8::1]:4443",
+		},
+		"dual-stack": {
+			url:  "nats://[2001:db8::1]:4222, nats://192.0.2.1:4222",
+			want: "nats://[2001:db8::1]:4222,nats://192.0.2.1:4222",
+		},
+		"unclosed bracket": {
+			url:     "nats://[2001:db8::1:4222",
+			wantErr: true,
+		},
+		"invalid ipv6": {
+			url:     "nats://[2001:db8::g]:4222",
+			wantErr: true,
+		},
+		"ipv4 in brackets": {
+			url:     "nats://[192.0.2.1]:4222",
+			wantErr: true,
+		},
+		"invalid port": {
+			url:     "nats://192.0.2.1:nats",
+			wantErr: true,
+		},
+		"empty": {
+			url:     " , ",
+			wantErr: true,
+		},
+	}
+	for n, tc := range testCases {
+		t.Run(n, func(t *testing.T) {
+			got, err := NormalizeURL(tc.url)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NormalizeURL(%q) = %v, wantErr %v", tc.url, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
 func newLoggingConfig() *logging.Config {
 	lc := &logging.Config{}
 	lc.LoggingConfig = `{
@@ -134,6 +325,47 @@ func newLoggingConfig() *logging.Config {
 	return lc
 }
 
+func TestIsChannelNotProvisioned(t *testing.T) {
+	testCases := map[string]struct {
+		err         error
+		partitioned bool
+		want        bool
+	}{
+		"nil": {},
+		"channel not found": {
+			err:  errors.New(`stan: channel not found: "orders.default"`),
+			want: true,
+		},
+		"too many channels": {
+			err:  fmt.Errorf("could not send: %w", errors.New("too many channels")),
+			want: true,
+		},
+		"connection closed": {
+			err: stan.ErrConnectionClosed,
+		},
+		"publish timeout": {
+			err: stan.ErrTimeout,
+		},
+		"partitioned publish timeout": {
+			err:         stan.ErrTimeout,
+			partitioned: true,
+			want:        true,
+		},
+		"partitioned subscribe timeout": {
+			err:         stan.ErrSubReqTimeout,
+			partitioned: true,
+			want:        true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := IsChannelNotProvisioned(tc.err, tc.partitioned); got != tc.want {
+				t.Errorf("IsChannelNotProvisioned(%v, %t) = %t, want %t", tc.err, tc.partitioned, got, tc.want)
+			}
+		})
+	}
+}
+
 func setupLogger() *zap.SugaredLogger {
 	logger, _ := logging.NewLoggerFromConfig(newLoggingConfig(), "stanutil_test")
 	return logger
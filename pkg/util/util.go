@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"knative.dev/pkg/network"
 )
@@ -11,27 +12,47 @@ const (
 	// DefaultNatssURLKey is the environment variable that can be set to specify the natss url
 	defaultNatssURLVar  = "DEFAULT_NATSS_URL"
 	defaultClusterIDVar = "DEFAULT_CLUSTER_ID"
+	maxBufferedBytesVar = "MAX_BUFFERED_BYTES"
+	// namespaceVar is set to the namespace of the dispatchers serving the channels of a namespace.
+	namespaceVar = "NAMESPACE"
 
 	fallbackDefaultNatssURLTmpl = "nats://nats-streaming.natss.svc.%s:4222"
 	fallbackDefaultClusterID    = "knative-nats-streaming"
 
 	defaultMaxIdleConnections        = 1000
 	defaultMaxIdleConnectionsPerHost = 100
+	defaultMaxBufferedBytes          = 64 * 1024 * 1024
 
 	clientID = "natss-ch-dispatcher"
 )
 
+const (
+	// DispatcherReceiverPort is the port the receiver of the dispatcher listens on, whether it
+	// serves HTTP or HTTPS, and which the ports of the dispatcher Service target.
+	DispatcherReceiverPort = 8080
+
+	// DispatcherReceiverPortName is the name of the receiver port among the container ports of the
+	// dispatcher Deployment.
+	DispatcherReceiverPortName = "receiver"
+
+	// DispatcherAdminPort is the port the dispatcher serves its admin endpoints and the probes of
+	// its pod on.
+	DispatcherAdminPort = 8081
+)
+
 type NatssConfig struct {
 	ClientID            string
 	MaxIdleConns        int
 	MaxIdleConnsPerHost int
+	MaxBufferedBytes    int64
 }
 
 func GetNatssConfig() NatssConfig {
 	return NatssConfig{
-		ClientID:            clientID,
+		ClientID:            getClientID(),
 		MaxIdleConns:        getMaxIdleConnections(),
 		MaxIdleConnsPerHost: getMaxIdleConnectionsPerHost(),
+		MaxBufferedBytes:    getMaxBufferedBytes(),
 	}
 }
 
@@ -45,6 +66,15 @@ func GetDefaultClusterID() string {
 	return getEnv(defaultClusterIDVar, fallbackDefaultClusterID)
 }
 
+// getClientID returns the client ID of the dispatcher, which the dispatchers of the namespaces
+// suffix with their namespace to connect along the dispatcher of the cluster.
+func getClientID() string {
+	if ns := getEnv(namespaceVar, ""); ns != "" {
+		return clientID + "-" + ns
+	}
+	return clientID
+}
+
 // getMaxIdleConnections returns the max number of idle connections
 func getMaxIdleConnections() int {
 	return defaultMaxIdleConnections
@@ -55,6 +85,15 @@ func getMaxIdleConnectionsPerHost() int {
 	return defaultMaxIdleConnectionsPerHost
 }
 
+// getMaxBufferedBytes returns the max number of bytes of events awaiting dispatch, zero or less disables the cap
+func getMaxBufferedBytes() int64 {
+	val, err := strconv.ParseInt(getEnv(maxBufferedBytesVar, ""), 10, 64)
+	if err != nil {
+		return defaultMaxBufferedBytes
+	}
+	return val
+}
+
 func getEnv(envKey string, fallback string) string {
 	val, ok := os.LookupEnv(envKey)
 	if !ok {
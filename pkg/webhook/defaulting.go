@@ -0,0 +1,62 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook holds the admission controllers of the NatssChannels.
+package webhook
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/webhook/resourcesemantics"
+	"knative.dev/pkg/webhook/resourcesemantics/defaulting"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/config"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+const (
+	// DefaultingWebhookName is the name of the MutatingWebhookConfiguration of the defaulting
+	// webhook, whose CA bundle and rules the admission controller keeps up to date.
+	DefaultingWebhookName = "defaulting.webhook.natss.messaging.knative.dev"
+
+	// DefaultingWebhookPath is the path the defaulting webhook is served on.
+	DefaultingWebhookPath = "/defaulting"
+)
+
+// types are the resources defaulted and validated by the webhooks.
+var types = map[schema.GroupVersionKind]resourcesemantics.GenericCRD{
+	v1beta1.SchemeGroupVersion.WithKind("NatssChannel"): &v1beta1.NatssChannel{},
+}
+
+// NewDefaultingAdmissionController creates the admission controller setting the defaults of the
+// NatssChannels, those of the config-natss-defaults ConfigMap following its changes.
+func NewDefaultingAdmissionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	store := config.NewStore(logging.FromContext(ctx).Named("config-store"))
+	store.WatchConfigs(cmw)
+
+	return defaulting.NewAdmissionController(ctx,
+		DefaultingWebhookName,
+		DefaultingWebhookPath,
+		types,
+		store.ToContext,
+		// Reject the unknown fields, which the API server would prune.
+		true,
+	)
+}
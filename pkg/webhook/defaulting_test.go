@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/google/go-cmp/cmp"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/ptr"
+	reconcilertesting "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	pkgwebhook "knative.dev/pkg/webhook"
+
+	_ "knative.dev/pkg/client/injection/kube/client/fake"
+	_ "knative.dev/pkg/client/injection/kube/informers/admissionregistration/v1/mutatingwebhookconfiguration/fake"
+	_ "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret/fake"
+	_ "knative.dev/pkg/system/testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/config"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+const defaultDelivery = `
+retry: 5
+backoffPolicy: exponential
+backoffDelay: PT0.5S
+`
+
+func defaultsConfigMap(delivery string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.DefaultsConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{config.DefaultDeliveryKey: delivery},
+	}
+}
+
+// admit sends nc to ac as operation, returning the channel patched by the response.
+func admit(t *testing.T, ac pkgwebhook.AdmissionController, operation admissionv1.Operation, nc *v1beta1.NatssChannel) *v1beta1.NatssChannel {
+	t.Helper()
+	raw, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatalf("failed to marshal the channel: %v", err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		Operation: operation,
+		Kind: metav1.GroupVersionKind{
+			Group:   v1beta1.SchemeGroupVersion.Group,
+			Version: v1beta1.SchemeGroupVersion.Version,
+			Kind:    "NatssChannel",
+		},
+		Resource: metav1.GroupVersionResource{
+			Group:    v1beta1.SchemeGroupVersion.Group,
+			Version:  v1beta1.SchemeGroupVersion.Version,
+			Resource: "natsschannels",
+		},
+		Object: runtime.RawExtension{Raw: raw},
+	}
+	if operation == admissionv1.Update {
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	resp := ac.Admit(context.Background(), req)
+	if !resp.Allowed {
+		t.Fatalf("Admit() refused the channel: %v", resp.Result)
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		t.Fatalf("failed to decode the patch %s: %v", resp.Patch, err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("failed to apply the patch %s: %v", resp.Patch, err)
+	}
+	got := &v1beta1.NatssChannel{}
+	if err := json.Unmarshal(patched, got); err != nil {
+		t.Fatalf("failed to unmarshal the patched channel: %v", err)
+	}
+	return got
+}
+
+func TestDefaultingAdmissionController(t *testing.T) {
+	ctx, _ := reconcilertesting.SetupFakeContext(t)
+	ctx = pkgwebhook.WithOptions(ctx, pkgwebhook.Options{SecretName: "natss-webhook-certs"})
+	cmw := &configmap.ManualWatcher{Namespace: system.Namespace()}
+	ac := NewDefaultingAdmissionController(ctx, cmw).Reconciler.(pkgwebhook.AdmissionController)
+	cmw.OnChange(defaultsConfigMap(defaultDelivery))
+
+	backoffPolicy := eventingduckv1.BackoffPolicyExponential
+	wantDelivery := &eventingduckv1.DeliverySpec{
+		Retry:         ptr.Int32(5),
+		BackoffPolicy: &backoffPolicy,
+		BackoffDelay:  ptr.String("PT0.5S"),
+	}
+	newChannel := func() *v1beta1.NatssChannel {
+		return &v1beta1.NatssChannel{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.SchemeGroupVersion.String(), Kind: "NatssChannel"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "channel"},
+		}
+	}
+
+	// A bare channel gets the defaults, and keeps them unchanged once admitted again.
+	defaulted := admit(t, ac, admissionv1.Create, newChannel())
+	if diff := cmp.Diff(wantDelivery, defaulted.Spec.Delivery); diff != "" {
+		t.Errorf("unexpected delivery (-want, +got): %s", diff)
+	}
+	if again := admit(t, ac, admissionv1.Update, defaulted); !cmp.Equal(defaulted, again) {
+		t.Errorf("defaulting again changed the channel: %s", cmp.Diff(defaulted, again))
+	}
+
+	// The delivery set by the user is untouched.
+	explicit := newChannel()
+	explicit.Spec.Delivery = &eventingduckv1.DeliverySpec{Retry: ptr.Int32(1)}
+	if got := admit(t, ac, admissionv1.Create, explicit); !cmp.Equal(explicit.Spec.Delivery, got.Spec.Delivery) {
+		t.Errorf("the explicit delivery was changed: %s", cmp.Diff(explicit.Spec.Delivery, got.Spec.Delivery))
+	}
+
+	// The changes of the ConfigMap apply to the next channels, the invalid ones being ignored.
+	cmw.OnChange(defaultsConfigMap("retry: 2"))
+	cmw.OnChange(defaultsConfigMap("retry: -1"))
+	if got := admit(t, ac, admissionv1.Create, newChannel()); !cmp.Equal(&eventingduckv1.DeliverySpec{Retry: ptr.Int32(2)}, got.Spec.Delivery) {
+		t.Errorf("delivery = %+v, want 2 retries", got.Spec.Delivery)
+	}
+	cmw.OnChange(defaultsConfigMap(""))
+	if got := admit(t, ac, admissionv1.Create, newChannel()); got.Spec.Delivery != nil {
+		t.Errorf("delivery = %+v, want none", got.Spec.Delivery)
+	}
+}
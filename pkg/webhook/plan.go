@@ -0,0 +1,89 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	pkgwebhook "knative.dev/pkg/webhook"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/dispatcher/planner"
+)
+
+// PlanAuditAnnotationKey is the key of the audit annotation of the responses to the dry-run
+// updates of the NatssChannels holding the plan of the dispatcher, which the API server prefixes
+// with ValidationWebhookName.
+const PlanAuditAnnotationKey = "plan"
+
+// admissionReconciler is the reconciler of an admission controller of knative.dev/pkg, which
+// keeps its webhook configuration up to date.
+type admissionReconciler interface {
+	controller.Reconciler
+	pkgreconciler.LeaderAware
+	pkgwebhook.AdmissionController
+	pkgwebhook.StatelessAdmissionController
+}
+
+// dryRunPlanner adds the plan of the dispatcher to the responses of the admission controller to the
+// dry-run updates of the NatssChannels it admits, for the operators to review what the dispatcher
+// would do to the subscriptions of the channel. The admission responses of this version of
+// Kubernetes have no warnings: the plan is returned as an audit annotation and as the message of
+// the result, one step per line.
+type dryRunPlanner struct {
+	admissionReconciler
+}
+
+// Admit implements webhook.AdmissionController.
+func (p *dryRunPlanner) Admit(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := p.admissionReconciler.Admit(ctx, req)
+	if !resp.Allowed || req.DryRun == nil || !*req.DryRun || req.Operation != admissionv1.Update || req.Kind.Kind != "NatssChannel" {
+		return resp
+	}
+	var old, new v1beta1.NatssChannel
+	if err := decodeChannels(req, &old, &new); err != nil {
+		logging.FromContext(ctx).Warnw("Failed to decode the channels to plan the update of", zap.Error(err))
+		return resp
+	}
+	warnings := planner.ForUpdate(&old, &new).Warnings()
+	if len(warnings) == 0 {
+		return resp
+	}
+	plan := strings.Join(warnings, "\n")
+	if resp.AuditAnnotations == nil {
+		resp.AuditAnnotations = make(map[string]string, 1)
+	}
+	resp.AuditAnnotations[PlanAuditAnnotationKey] = plan
+	resp.Result = &metav1.Status{Status: metav1.StatusSuccess, Message: "The dispatcher plans to:\n" + plan}
+	return resp
+}
+
+// decodeChannels decodes the channel req updates into old, and the updated one into new.
+func decodeChannels(req *admissionv1.AdmissionRequest, old, new *v1beta1.NatssChannel) error {
+	if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+		return err
+	}
+	return json.Unmarshal(req.Object.Raw, new)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/ptr"
+	reconcilertesting "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	pkgwebhook "knative.dev/pkg/webhook"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+)
+
+// update sends the update of old to new to ac, as a dry-run when dryRun.
+func update(t *testing.T, ac pkgwebhook.AdmissionController, old, new *v1beta1.NatssChannel, dryRun bool) *admissionv1.AdmissionResponse {
+	t.Helper()
+	oldRaw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatalf("failed to marshal the channel: %v", err)
+	}
+	newRaw, err := json.Marshal(new)
+	if err != nil {
+		t.Fatalf("failed to marshal the channel: %v", err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		Kind: metav1.GroupVersionKind{
+			Group:   v1beta1.SchemeGroupVersion.Group,
+			Version: v1beta1.SchemeGroupVersion.Version,
+			Kind:    "NatssChannel",
+		},
+		DryRun:    ptr.Bool(dryRun),
+		OldObject: runtime.RawExtension{Raw: oldRaw},
+		Object:    runtime.RawExtension{Raw: newRaw},
+	}
+	return ac.Admit(context.Background(), req)
+}
+
+func TestDryRunPlan(t *testing.T) {
+	ctx, _ := reconcilertesting.SetupFakeContext(t)
+	ctx = pkgwebhook.WithOptions(ctx, pkgwebhook.Options{SecretName: "natss-webhook-certs"})
+	ac := NewValidationAdmissionController(ctx, &configmap.ManualWatcher{Namespace: system.Namespace()}).Reconciler.(pkgwebhook.AdmissionController)
+
+	old, changed := quotaChannel("ns", "channel", 1), quotaChannel("ns", "channel", 2)
+
+	// The plan of a dry-run update is returned.
+	resp := update(t, ac, old, changed, true)
+	if !resp.Allowed {
+		t.Fatalf("Admit() refused the update: %v", resp.Result)
+	}
+	want := "subscribe uid-1: added to the channel"
+	if diff := cmp.Diff(map[string]string{PlanAuditAnnotationKey: want}, resp.AuditAnnotations); diff != "" {
+		t.Errorf("unexpected audit annotations (-want, +got): %s", diff)
+	}
+	if resp.Result == nil || resp.Result.Message != "The dispatcher plans to:\n"+want {
+		t.Errorf("Admit() result = %v, want the plan", resp.Result)
+	}
+
+	// Nor the updates applied, nor the dry-run ones changing nothing, get a plan.
+	if resp := update(t, ac, old, changed, false); !resp.Allowed || resp.AuditAnnotations != nil || resp.Result != nil {
+		t.Errorf("Admit() = %+v for an update, want no plan", resp)
+	}
+	if resp := update(t, ac, old, old, true); !resp.Allowed || resp.AuditAnnotations != nil || resp.Result != nil {
+		t.Errorf("Admit() = %+v for a dry-run update changing nothing, want no plan", resp)
+	}
+
+	// The invalid updates are refused as without dry-run.
+	invalid := changed.DeepCopy()
+	invalid.Spec.WireFormat = "invalid"
+	if resp := update(t, ac, old, invalid, true); resp.Allowed {
+		t.Error("Admit() admitted an invalid update")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/cardinality"
+	natsslisters "knative.dev/eventing-natss/pkg/client/listers/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+var (
+	// quotaUtilizationM records the fraction of its quotas a namespace uses, each time a channel
+	// of the namespace is admitted or refused.
+	quotaUtilizationM = stats.Float64(
+		"natss_quota_utilization",
+		"Fraction of the quota of the namespace in use",
+		stats.UnitDimensionless,
+	)
+
+	// quotaKey tells the quota of channels and the quota of subscriptions apart.
+	quotaKey = tag.MustNewKey("quota")
+)
+
+func init() {
+	if err := view.Register(
+		&view.View{
+			Description: quotaUtilizationM.Description(),
+			Measure:     quotaUtilizationM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{cardinality.NamespaceKey, quotaKey},
+		},
+	); err != nil {
+		panic(err)
+	}
+}
+
+// quota enforces the NamespaceQuota of the namespaces, counting their channels and subscribers
+// in the informer caches. Concurrent creations may overshoot the quota by the channels, or
+// subscribers, admitted before the caches see them.
+type quota struct {
+	channelLister   natsslisters.NatssChannelLister
+	namespaceLister corev1listers.NamespaceLister
+
+	mu       sync.RWMutex
+	defaults config.NamespaceQuota
+}
+
+func newQuota(channelLister natsslisters.NatssChannelLister, namespaceLister corev1listers.NamespaceLister) *quota {
+	return &quota{channelLister: channelLister, namespaceLister: namespaceLister}
+}
+
+// setDefaults sets the quota of the namespaces not overriding it.
+func (q *quota) setDefaults(defaults config.NamespaceQuota) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.defaults = defaults
+}
+
+// limits returns the quota of the namespace ns, the defaults when its annotations are invalid.
+func (q *quota) limits(ctx context.Context, ns string) config.NamespaceQuota {
+	q.mu.RLock()
+	defaults := q.defaults
+	q.mu.RUnlock()
+
+	namespace, err := q.namespaceLister.Get(ns)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logging.FromContext(ctx).Errorw("Failed to get the namespace, using the default quota", zap.String("namespace", ns), zap.Error(err))
+		}
+		return defaults
+	}
+	limits, err := defaults.WithAnnotations(namespace.Annotations)
+	if err != nil {
+		logging.FromContext(ctx).Errorw("Ignoring the invalid quota annotations", zap.String("namespace", ns), zap.Error(err))
+		return defaults
+	}
+	return limits
+}
+
+// admit refuses the creation of a channel over the channel quota of its namespace, and the
+// creations and updates adding subscribers over its subscription quota. An update not adding
+// subscribers is always admitted, for a namespace over a lowered quota to keep working.
+func (q *quota) admit(ctx context.Context, u *unstructured.Unstructured) error {
+	nc := &v1beta1.NatssChannel{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), nc); err != nil {
+		return fmt.Errorf("failed to convert the channel: %w", err)
+	}
+	limits := q.limits(ctx, nc.Namespace)
+	if limits.Channels == 0 && limits.Subscriptions == 0 {
+		return nil
+	}
+
+	var original *v1beta1.NatssChannel
+	if apis.IsInUpdate(ctx) {
+		original, _ = apis.GetBaseline(ctx).(*v1beta1.NatssChannel)
+	}
+	subscribers := len(nc.Spec.Subscribers)
+	if original != nil && subscribers <= len(original.Spec.Subscribers) {
+		return nil
+	}
+
+	channels, err := q.channelLister.NatssChannels(nc.Namespace).List(labels.Everything())
+	if err != nil {
+		// The quota is best effort, a failure to count must not block the channels.
+		logging.FromContext(ctx).Errorw("Failed to list the channels, admitting the channel", zap.String("namespace", nc.Namespace), zap.Error(err))
+		return nil
+	}
+	others := 0
+	for _, c := range channels {
+		if c.Name == nc.Name {
+			continue
+		}
+		others++
+		subscribers += len(c.Spec.Subscribers)
+	}
+
+	var refusal error
+	if original == nil && limits.Channels > 0 && others >= limits.Channels {
+		refusal = fmt.Errorf("the namespace %s has %d channels, its quota of %d channels is reached", nc.Namespace, others, limits.Channels)
+	} else if limits.Subscriptions > 0 && subscribers > limits.Subscriptions {
+		refusal = fmt.Errorf("the channels of the namespace %s would have %d subscribers, over its quota of %d subscriptions", nc.Namespace, subscribers, limits.Subscriptions)
+	}
+
+	// The namespace uses the channel once admitted, and its original otherwise.
+	channelCount := others + 1
+	if refusal != nil {
+		subscribers -= len(nc.Spec.Subscribers)
+		if original == nil {
+			channelCount = others
+		} else {
+			subscribers += len(original.Spec.Subscribers)
+		}
+	}
+	recordQuotaUtilization(ctx, nc.Namespace, "channels", channelCount, limits.Channels)
+	recordQuotaUtilization(ctx, nc.Namespace, "subscriptions", subscribers, limits.Subscriptions)
+	return refusal
+}
+
+// recordQuotaUtilization records the fraction of the quota limit of the namespace ns which used
+// uses, nothing when the quota is disabled.
+func recordQuotaUtilization(ctx context.Context, ns, quota string, used, limit int) {
+	if limit == 0 {
+		return
+	}
+	mutators := append(cardinality.Tags(cardinality.Resource{Namespace: ns}), tag.Upsert(quotaKey, quota))
+	tagged, err := tag.New(context.Background(), mutators...)
+	if err != nil {
+		logging.FromContext(ctx).Warnw("Failed to tag the quota utilization", zap.Error(err))
+		return
+	}
+	metrics.Record(tagged, quotaUtilizationM.M(float64(used)/float64(limit)))
+}
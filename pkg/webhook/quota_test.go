@@ -0,0 +1,179 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging"
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	"knative.dev/eventing-natss/pkg/config"
+	reconciletesting "knative.dev/eventing-natss/pkg/reconciler/testing"
+)
+
+// quotaChannel returns the channel name of the namespace ns with subscribers subscribers.
+func quotaChannel(ns, name string, subscribers int) *v1beta1.NatssChannel {
+	nc := &v1beta1.NatssChannel{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.SchemeGroupVersion.String(), Kind: "NatssChannel"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+	}
+	for i := 0; i < subscribers; i++ {
+		nc.Spec.Subscribers = append(nc.Spec.Subscribers, eventingduckv1.SubscriberSpec{
+			UID:           k8stypes.UID(fmt.Sprintf("uid-%d", i)),
+			SubscriberURI: apis.HTTP(fmt.Sprintf("sub-%d.%s.svc.cluster.local", i, ns)),
+		})
+	}
+	return nc
+}
+
+func quotaNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestQuotaAdmit(t *testing.T) {
+	testCases := map[string]struct {
+		defaults config.NamespaceQuota
+		objects  []runtime.Object
+		channel  *v1beta1.NatssChannel
+		// original is the channel updated, nil for a creation.
+		original *v1beta1.NatssChannel
+		wantErr  string
+	}{
+		"no quota": {
+			objects: []runtime.Object{quotaChannel("ns", "a", 10), quotaChannel("ns", "b", 10)},
+			channel: quotaChannel("ns", "c", 10),
+		},
+		"under the channel quota": {
+			defaults: config.NamespaceQuota{Channels: 3},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 0)},
+			channel:  quotaChannel("ns", "b", 0),
+		},
+		"at the channel quota": {
+			defaults: config.NamespaceQuota{Channels: 2},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 0)},
+			channel:  quotaChannel("ns", "b", 0),
+		},
+		"over the channel quota": {
+			defaults: config.NamespaceQuota{Channels: 2},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 0), quotaChannel("ns", "b", 0)},
+			channel:  quotaChannel("ns", "c", 0),
+			wantErr:  "the namespace ns has 2 channels, its quota of 2 channels is reached",
+		},
+		"the channels of the other namespaces are not counted": {
+			defaults: config.NamespaceQuota{Channels: 1},
+			objects:  []runtime.Object{quotaChannel("other", "a", 0)},
+			channel:  quotaChannel("ns", "a", 0),
+		},
+		"update of a namespace at the channel quota": {
+			defaults: config.NamespaceQuota{Channels: 1},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 1)},
+			channel:  quotaChannel("ns", "a", 2),
+			original: quotaChannel("ns", "a", 1),
+		},
+		"at the subscription quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 5},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 3)},
+			channel:  quotaChannel("ns", "b", 2),
+		},
+		"over the subscription quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 5},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 3)},
+			channel:  quotaChannel("ns", "b", 3),
+			wantErr:  "the channels of the namespace ns would have 6 subscribers, over its quota of 5 subscriptions",
+		},
+		"update adding subscribers over the quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 5},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 3), quotaChannel("ns", "b", 2)},
+			channel:  quotaChannel("ns", "b", 3),
+			original: quotaChannel("ns", "b", 2),
+			wantErr:  "the channels of the namespace ns would have 6 subscribers, over its quota of 5 subscriptions",
+		},
+		"update adding subscribers under the quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 5},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 1), quotaChannel("ns", "b", 2)},
+			channel:  quotaChannel("ns", "b", 4),
+			original: quotaChannel("ns", "b", 2),
+		},
+		"update not adding subscribers over a lowered quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 2},
+			objects:  []runtime.Object{quotaChannel("ns", "a", 3), quotaChannel("ns", "b", 3)},
+			channel:  quotaChannel("ns", "b", 2),
+			original: quotaChannel("ns", "b", 3),
+		},
+		"the annotations of the namespace lift the quota": {
+			defaults: config.NamespaceQuota{Channels: 1},
+			objects: []runtime.Object{
+				quotaNamespace("ns", map[string]string{messaging.QuotaMaxChannelsAnnotationKey: "0"}),
+				quotaChannel("ns", "a", 0),
+			},
+			channel: quotaChannel("ns", "b", 0),
+		},
+		"the annotations of the namespace lower the quota": {
+			defaults: config.NamespaceQuota{Subscriptions: 10},
+			objects: []runtime.Object{
+				quotaNamespace("ns", map[string]string{messaging.QuotaMaxSubscriptionsAnnotationKey: "1"}),
+				quotaChannel("ns", "a", 1),
+			},
+			channel: quotaChannel("ns", "b", 1),
+			wantErr: "the channels of the namespace ns would have 2 subscribers, over its quota of 1 subscriptions",
+		},
+		"invalid annotations keep the defaults": {
+			defaults: config.NamespaceQuota{Channels: 1},
+			objects: []runtime.Object{
+				quotaNamespace("ns", map[string]string{messaging.QuotaMaxChannelsAnnotationKey: "-1"}),
+				quotaChannel("ns", "a", 0),
+			},
+			channel: quotaChannel("ns", "b", 0),
+			wantErr: "the namespace ns has 1 channels, its quota of 1 channels is reached",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ls := reconciletesting.NewListers(tc.objects)
+			q := newQuota(ls.GetNatssChannelLister(), ls.GetNamespaceLister())
+			q.setDefaults(tc.defaults)
+
+			ctx := apis.WithinCreate(context.Background())
+			if tc.original != nil {
+				ctx = apis.WithinUpdate(context.Background(), tc.original)
+			}
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc.channel)
+			if err != nil {
+				t.Fatalf("failed to convert the channel: %v", err)
+			}
+			err = q.admit(ctx, &unstructured.Unstructured{Object: content})
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("admit() = %v, want no error", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("admit() = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
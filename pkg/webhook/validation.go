@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	namespaceinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	pkgwebhook "knative.dev/pkg/webhook"
+	"knative.dev/pkg/webhook/resourcesemantics/validation"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	natsschannelinformer "knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel"
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+const (
+	// ValidationWebhookName is the name of the ValidatingWebhookConfiguration of the validation
+	// webhook, whose CA bundle and rules the admission controller keeps up to date.
+	ValidationWebhookName = "validation.webhook.natss.messaging.knative.dev"
+
+	// ValidationWebhookPath is the path the validation webhook is served on.
+	ValidationWebhookPath = "/validation"
+)
+
+// NewValidationAdmissionController creates the admission controller validating the
+// NatssChannels, and enforcing the quotas of their namespaces set in the config-natss ConfigMap
+// and the annotations of the namespaces. Its responses to the dry-run updates of the channels hold
+// the plan of the dispatcher.
+func NewValidationAdmissionController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
+	q := newQuota(
+		natsschannelinformer.Get(ctx).Lister(),
+		namespaceinformer.Get(ctx).Lister(),
+	)
+	config.Watch(ctx, cmw, func(c *config.Config) {
+		q.setDefaults(c.Quota)
+	})
+
+	impl := validation.NewAdmissionController(ctx,
+		ValidationWebhookName,
+		ValidationWebhookPath,
+		types,
+		nil,
+		// Reject the unknown fields, which the API server would prune.
+		true,
+		map[schema.GroupVersionKind]validation.Callback{
+			v1beta1.SchemeGroupVersion.WithKind("NatssChannel"): validation.NewCallback(q.admit, pkgwebhook.Create, pkgwebhook.Update),
+		},
+	)
+	impl.Reconciler = &dryRunPlanner{admissionReconciler: impl.Reconciler.(admissionReconciler)}
+	return impl
+}
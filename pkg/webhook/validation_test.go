@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/configmap"
+	reconcilertesting "knative.dev/pkg/reconciler/testing"
+	"knative.dev/pkg/system"
+	pkgwebhook "knative.dev/pkg/webhook"
+
+	_ "knative.dev/pkg/client/injection/kube/client/fake"
+	_ "knative.dev/pkg/client/injection/kube/informers/admissionregistration/v1/validatingwebhookconfiguration/fake"
+	_ "knative.dev/pkg/client/injection/kube/informers/core/v1/namespace/fake"
+	_ "knative.dev/pkg/injection/clients/namespacedkube/informers/core/v1/secret/fake"
+	_ "knative.dev/pkg/system/testing"
+
+	"knative.dev/eventing-natss/pkg/apis/messaging/v1beta1"
+	natsschannelinformer "knative.dev/eventing-natss/pkg/client/injection/informers/messaging/v1beta1/natsschannel/fake"
+	"knative.dev/eventing-natss/pkg/config"
+)
+
+// validate sends nc to ac as operation, returning the response.
+func validate(t *testing.T, ac pkgwebhook.AdmissionController, operation admissionv1.Operation, nc *v1beta1.NatssChannel) *admissionv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatalf("failed to marshal the channel: %v", err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		Operation: operation,
+		Kind: metav1.GroupVersionKind{
+			Group:   v1beta1.SchemeGroupVersion.Group,
+			Version: v1beta1.SchemeGroupVersion.Version,
+			Kind:    "NatssChannel",
+		},
+		Object: runtime.RawExtension{Raw: raw},
+	}
+	return ac.Admit(context.Background(), req)
+}
+
+func TestValidationAdmissionController(t *testing.T) {
+	ctx, _ := reconcilertesting.SetupFakeContext(t)
+	ctx = pkgwebhook.WithOptions(ctx, pkgwebhook.Options{SecretName: "natss-webhook-certs"})
+	cmw := &configmap.ManualWatcher{Namespace: system.Namespace()}
+	ac := NewValidationAdmissionController(ctx, cmw).Reconciler.(pkgwebhook.AdmissionController)
+	cmw.OnChange(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapName, Namespace: system.Namespace()},
+		Data:       map[string]string{config.QuotaMaxChannelsKey: "1"},
+	})
+	if err := natsschannelinformer.Get(ctx).Informer().GetIndexer().Add(quotaChannel("ns", "a", 0)); err != nil {
+		t.Fatalf("failed to add the channel: %v", err)
+	}
+
+	if resp := validate(t, ac, admissionv1.Create, quotaChannel("other", "a", 0)); !resp.Allowed {
+		t.Errorf("Admit() refused the channel: %v", resp.Result)
+	}
+
+	resp := validate(t, ac, admissionv1.Create, quotaChannel("ns", "b", 0))
+	if want := "its quota of 1 channels is reached"; resp.Allowed || !strings.Contains(resp.Result.Message, want) {
+		t.Errorf("Admit() = %v, want a refusal containing %q", resp.Result, want)
+	}
+
+	// The channels are validated before their quotas are checked.
+	invalid := quotaChannel("other", "b", 0)
+	invalid.Spec.WireFormat = "invalid"
+	if resp := validate(t, ac, admissionv1.Create, invalid); resp.Allowed {
+		t.Error("Admit() admitted an invalid channel")
+	}
+}